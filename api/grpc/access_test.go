@@ -0,0 +1,66 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// restartRequest stands in for a generated request with cluster_id and
+// namespace fields
+type restartRequest struct{ clusterID, namespace string }
+
+func (r *restartRequest) GetClusterId() string { return r.clusterID }
+func (r *restartRequest) GetNamespace() string { return r.namespace }
+
+func TestAccessUnaryInterceptor(t *testing.T) {
+	const (
+		listMethod    = "/krustron.v1.ClusterService/ListClusters"
+		restartMethod = "/krustron.v1.ClusterService/RestartWorkload"
+		versionMethod = "/krustron.v1.Meta/Version"
+	)
+	interceptor := accessUnaryInterceptor(&accessChecker{
+		methods: map[string]MethodAccess{
+			listMethod:    {Permission: "clusters:read", Resource: rbac.ResourceCluster, Action: rbac.ActionRead},
+			restartMethod: {Permission: "clusters:write", Resource: rbac.ResourceCluster, Action: rbac.ActionUpdate},
+		},
+		logger: zap.NewNop(),
+	})
+
+	call := func(claims *auth.Claims, method string) error {
+		ctx := context.WithValue(context.Background(), claimsKey{}, claims)
+		_, err := interceptor(ctx, &restartRequest{"prod", "payments"}, &grpc.UnaryServerInfo{FullMethod: method},
+			func(context.Context, interface{}) (interface{}, error) { return "ok", nil })
+		return err
+	}
+
+	developer := accessClaims(0)
+	require.NoError(t, call(developer, listMethod))
+	require.NoError(t, call(developer, versionMethod), "methods without an entry pass")
+	key := accessClaims(0)
+	key.TokenType, key.Permissions = auth.TokenTypeAPIKey, []string{"clusters:read"}
+	require.NoError(t, call(key, listMethod))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call(key, versionMethod)), "API keys need a scope")
+	key.Permissions = []string{"*"}
+	require.NoError(t, call(key, versionMethod))
+	err := call(developer, restartMethod)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Contains(t, err.Error(), "clusters:write")
+
+	admin := accessClaims(0)
+	admin.Role, admin.Permissions = "admin", []string{"*"}
+	require.NoError(t, call(admin, restartMethod))
+
+	viewer := accessClaims(0)
+	viewer.Role, viewer.Permissions = "viewer", []string{"*:read"}
+	require.NoError(t, call(viewer, listMethod))
+	assert.Equal(t, codes.PermissionDenied, status.Code(call(viewer, restartMethod)))
+}
@@ -0,0 +1,194 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const testJWTSecret = "grpc-test-secret-0123456789abcdefghijkl"
+
+func newTestAuthService(t *testing.T) *auth.Service {
+	t.Helper()
+	svc, err := auth.NewService(nil, nil, &config.AuthConfig{JWTSecret: testJWTSecret, BCryptCost: 10})
+	require.NoError(t, err)
+	return svc
+}
+
+// signToken signs claims the way the auth service does
+func signToken(t *testing.T, secret string, claims *auth.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return token
+}
+
+func accessClaims(expiresIn time.Duration) *auth.Claims {
+	return &auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		},
+		UserID:      "user-1",
+		Email:       "dev@example.com",
+		Role:        "developer",
+		Permissions: []string{"clusters:read"},
+		TokenType:   "access",
+	}
+}
+
+func withAuthorization(value string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", value))
+}
+
+func TestAuthUnaryInterceptor(t *testing.T) {
+	interceptor := authUnaryInterceptor(newAuthorizer(newTestAuthService(t), []string{"/krustron.v1.Meta/Version"}, map[string]string{
+		"/krustron.v1.ClusterService/DeleteCluster": "clusters:delete",
+	}))
+
+	call := func(ctx context.Context, method string) (*auth.Claims, error) {
+		var claims *auth.Claims
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			claims, _ = ClaimsFromContext(ctx)
+			return "ok", nil
+		})
+		return claims, err
+	}
+	const method = "/krustron.v1.ClusterService/ListClusters"
+
+	claims, err := call(withAuthorization("Bearer "+signToken(t, testJWTSecret, accessClaims(time.Hour))), method)
+	require.NoError(t, err)
+	require.NotNil(t, claims)
+	assert.Equal(t, "user-1", claims.UserID)
+	assert.Equal(t, "developer", claims.Role)
+	assert.Equal(t, []string{"clusters:read"}, claims.Permissions)
+
+	refresh := accessClaims(time.Hour)
+	refresh.TokenType = "refresh"
+	for name, ctx := range map[string]context.Context{
+		"no metadata":  context.Background(),
+		"no token":     metadata.NewIncomingContext(context.Background(), metadata.MD{}),
+		"garbage":      withAuthorization("Bearer not-a-jwt"),
+		"wrong secret": withAuthorization("Bearer " + signToken(t, "another-secret-0123456789abcdefghijkl", accessClaims(time.Hour))),
+		"expired":      withAuthorization("Bearer " + signToken(t, testJWTSecret, accessClaims(-time.Minute))),
+		"refresh":      withAuthorization("Bearer " + signToken(t, testJWTSecret, refresh)),
+	} {
+		_, err := call(ctx, method)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), name)
+	}
+
+	// Declared permissions are enforced on top of authentication
+	_, err = call(withAuthorization("Bearer "+signToken(t, testJWTSecret, accessClaims(time.Hour))), "/krustron.v1.ClusterService/DeleteCluster")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	admin := accessClaims(time.Hour)
+	admin.Role = "admin"
+	_, err = call(withAuthorization("Bearer "+signToken(t, testJWTSecret, admin)), "/krustron.v1.ClusterService/DeleteCluster")
+	assert.NoError(t, err)
+
+	// Health checks and allow-listed methods need no token
+	for _, public := range []string{"/grpc.health.v1.Health/Check", "/krustron.v1.Meta/Version"} {
+		claims, err := call(context.Background(), public)
+		assert.NoError(t, err, public)
+		assert.Nil(t, claims)
+	}
+}
+
+// dialTestServer serves s in memory and returns a client connected to it
+func dialTestServer(t *testing.T, s *Server) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go s.server.Serve(lis)
+	t.Cleanup(s.ForceStop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// watchService is a fake server-streaming service; Watch sends one empty
+// message per call it sees, after recording the caller's claims
+type watchService struct {
+	calls chan *auth.Claims
+}
+
+var watchServiceDesc = grpc.ServiceDesc{
+	ServiceName: "krustron.test.Watcher",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Watch",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			claims, _ := ClaimsFromContext(stream.Context())
+			srv.(*watchService).calls <- claims
+			return stream.SendMsg(&emptypb.Empty{})
+		},
+	}},
+}
+
+func TestAuthStreamInterceptor(t *testing.T) {
+	s, err := NewServer(zap.NewNop(), &Config{
+		MethodPermissions: map[string]string{"/krustron.test.Watcher/Watch": "events:watch"},
+	}, newTestAuthService(t))
+	require.NoError(t, err)
+	watcher := &watchService{calls: make(chan *auth.Claims, 1)}
+	s.server.RegisterService(&watchServiceDesc, watcher)
+
+	conn := dialTestServer(t, s)
+
+	watch := func(ctx context.Context) error {
+		stream, err := conn.NewStream(ctx, &watchServiceDesc.Streams[0], "/krustron.test.Watcher/Watch")
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+			return err
+		}
+		if err := stream.CloseSend(); err != nil {
+			return err
+		}
+		return stream.RecvMsg(&emptypb.Empty{})
+	}
+	outgoing := func(claims *auth.Claims) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+signToken(t, testJWTSecret, claims))
+	}
+
+	// Rejected before the handler ever runs
+	err = watch(context.Background())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	err = watch(outgoing(accessClaims(-time.Minute)))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	err = watch(outgoing(accessClaims(time.Hour)))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Empty(t, watcher.calls)
+
+	allowed := accessClaims(time.Hour)
+	allowed.Permissions = []string{"events:*"}
+	require.NoError(t, watch(outgoing(allowed)))
+	claims := <-watcher.calls
+	require.NotNil(t, claims)
+	assert.Equal(t, "user-1", claims.UserID)
+}
+
+func TestNewServerRequiresAuth(t *testing.T) {
+	_, err := NewServer(nil, &Config{}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package grpc
+
+import (
+	"context"
+	goerrors "errors"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// toStatusError maps an error returned by a handler to the gRPC status
+// the client receives. Domain errors keep their code and meta in an
+// ErrorInfo detail, statuses pass through, and anything else becomes an
+// internal error without leaking its message.
+func toStatusError(logger *zap.Logger, method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var appErr *errors.AppError
+	if goerrors.As(err, &appErr) {
+		return appErr.GRPCStatus().Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if mapped := errors.ToAppError(err); mapped.Code != errors.CodeInternal {
+		return mapped.GRPCStatus().Err()
+	}
+
+	logger.Error("Unmapped error in gRPC handler", zap.String("method", method), zap.Error(err))
+	return errors.Internal("internal server error").GRPCStatus().Err()
+}
+
+func errorUnaryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, toStatusError(logger, info.FullMethod, err)
+	}
+}
+
+func errorStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return toStatusError(logger, info.FullMethod, handler(srv, ss))
+	}
+}
@@ -0,0 +1,65 @@
+package grpc
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorUnaryInterceptor(t *testing.T) {
+	interceptor := errorUnaryInterceptor(zap.NewNop())
+	info := &grpc.UnaryServerInfo{FullMethod: "/krustron.v1.ClusterService/GetCluster"}
+	call := func(err error) error {
+		_, got := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, err
+		})
+		return got
+	}
+
+	t.Run("domain error carries code and meta", func(t *testing.T) {
+		err := call(fmt.Errorf("lookup: %w", errors.NotFound("cluster", "c1")))
+
+		st, ok := status.FromError(err)
+		require.True(t, ok)
+		assert.Equal(t, codes.NotFound, st.Code())
+		assert.Equal(t, "cluster with id 'c1' not found", st.Message())
+		require.Len(t, st.Details(), 1)
+		info := st.Details()[0].(*errdetails.ErrorInfo)
+		assert.Equal(t, errors.CodeNotFound, info.Reason)
+		assert.Equal(t, errors.ErrorDomain, info.Domain)
+		assert.Equal(t, map[string]string{"resource": "cluster", "id": "c1"}, info.Metadata)
+	})
+
+	t.Run("status errors pass through", func(t *testing.T) {
+		err := call(status.Error(codes.FailedPrecondition, "not ready"))
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		assert.Equal(t, "not ready", status.Convert(err).Message())
+	})
+
+	t.Run("context errors", func(t *testing.T) {
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(call(context.DeadlineExceeded)))
+		assert.Equal(t, codes.Canceled, status.Code(call(fmt.Errorf("query: %w", context.Canceled))))
+	})
+
+	t.Run("other errors are internal and hidden", func(t *testing.T) {
+		err := call(goerrors.New("pq: password authentication failed"))
+		st := status.Convert(err)
+		assert.Equal(t, codes.Internal, st.Code())
+		assert.Equal(t, "internal server error", st.Message())
+		assert.Equal(t, errors.CodeInternal, errors.FromGRPC(err).Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		assert.NoError(t, call(nil))
+	})
+}
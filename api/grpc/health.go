@@ -0,0 +1,177 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	defaultHealthCheckInterval = 15 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+)
+
+// HealthCheck probes a dependency; nil means it's healthy. Methods such
+// as PostgresDB.Health, RedisCache.Health and ClusterClient.CheckHealth
+// fit as they are.
+type HealthCheck func(ctx context.Context) error
+
+// Dependency is something the server needs to serve, probed in the
+// background by RunHealthChecks
+type Dependency struct {
+	Name  string
+	Check HealthCheck
+	// Interval and Timeout default to the server's HealthCheckInterval
+	// and HealthCheckTimeout
+	Interval time.Duration
+	Timeout  time.Duration
+	// Services are the gRPC services that report NOT_SERVING while this
+	// dependency is down. The overall ("") status depends on every
+	// dependency regardless.
+	Services []string
+}
+
+// DependencyHealth is the last probe result of a dependency
+type DependencyHealth struct {
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+}
+
+// HealthReport is the aggregated health of the server
+type HealthReport struct {
+	Healthy      bool                        `json:"healthy"`
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+}
+
+// dependencyState tracks one dependency. Until its first probe it counts
+// as healthy.
+type dependencyState struct {
+	dep    Dependency
+	health DependencyHealth
+}
+
+// AddDependency registers a dependency for RunHealthChecks to probe. Each
+// dependency is also served as its own health service, "dependency/<name>".
+func (s *Server) AddDependency(dep Dependency) {
+	if dep.Interval <= 0 {
+		dep.Interval = s.config.HealthCheckInterval
+	}
+	if dep.Timeout <= 0 {
+		dep.Timeout = s.config.HealthCheckTimeout
+	}
+
+	s.healthMu.Lock()
+	s.dependencies = append(s.dependencies, &dependencyState{dep: dep, health: DependencyHealth{Healthy: true}})
+	s.publishHealthLocked()
+	s.healthMu.Unlock()
+}
+
+// RunHealthChecks probes every dependency, immediately and then on its
+// interval, until ctx is done. Services flip to NOT_SERVING when a
+// dependency they need fails and back to SERVING once it recovers, which
+// Watch clients see as it happens.
+func (s *Server) RunHealthChecks(ctx context.Context) {
+	s.healthMu.Lock()
+	deps := append([]*dependencyState(nil), s.dependencies...)
+	s.healthMu.Unlock()
+
+	done := make(chan struct{})
+	for _, d := range deps {
+		go func(d *dependencyState) {
+			defer func() { done <- struct{}{} }()
+			ticker := time.NewTicker(d.dep.Interval)
+			defer ticker.Stop()
+			for {
+				s.probe(ctx, d)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(d)
+	}
+	for range deps {
+		<-done
+	}
+}
+
+// probe runs one check of d and publishes the outcome if it changed
+func (s *Server) probe(ctx context.Context, d *dependencyState) {
+	checkCtx, cancel := context.WithTimeout(ctx, d.dep.Timeout)
+	err := d.dep.Check(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		// Shutting down; a cancelled probe says nothing about the dependency
+		return
+	}
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	wasHealthy := d.health.Healthy
+	d.health = DependencyHealth{Healthy: err == nil, CheckedAt: time.Now()}
+	if err != nil {
+		d.health.Error = err.Error()
+	}
+	if wasHealthy == d.health.Healthy {
+		return
+	}
+
+	if err != nil {
+		s.logger.Warn("Dependency unhealthy", zap.String("dependency", d.dep.Name), zap.Error(err))
+	} else {
+		s.logger.Info("Dependency recovered", zap.String("dependency", d.dep.Name))
+	}
+	s.publishHealthLocked()
+}
+
+// publishHealthLocked pushes the dependency states to the health server:
+// each dependency, every service that needs one, and the overall status
+func (s *Server) publishHealthLocked() {
+	if s.healthServer == nil {
+		return
+	}
+
+	overall := true
+	services := make(map[string]bool)
+	for _, d := range s.dependencies {
+		healthy := d.health.Healthy
+		overall = overall && healthy
+		s.setServingStatus("dependency/"+d.dep.Name, healthy)
+		for _, svc := range d.dep.Services {
+			if ok, seen := services[svc]; !seen || ok {
+				services[svc] = healthy
+			}
+		}
+	}
+	for svc, healthy := range services {
+		s.setServingStatus(svc, healthy)
+	}
+	s.setServingStatus("", overall)
+}
+
+func (s *Server) setServingStatus(service string, healthy bool) {
+	status := grpc_health_v1.HealthCheckResponse_SERVING
+	if !healthy {
+		status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	s.healthServer.SetServingStatus(service, status)
+}
+
+// Health returns the aggregated health: healthy only while every
+// dependency is
+func (s *Server) Health() HealthReport {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	report := HealthReport{Healthy: true, Dependencies: make(map[string]DependencyHealth, len(s.dependencies))}
+	for _, d := range s.dependencies {
+		report.Dependencies[d.dep.Name] = d.health
+		report.Healthy = report.Healthy && d.health.Healthy
+	}
+	return report
+}
@@ -0,0 +1,93 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeDependency fails its health check while down is set
+type fakeDependency struct {
+	down atomic.Bool
+}
+
+func (f *fakeDependency) check(context.Context) error {
+	if f.down.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestHealthFollowsDependencies(t *testing.T) {
+	s, err := NewServer(zap.NewNop(), &Config{EnableHealthCheck: true, HealthCheckInterval: 10 * time.Millisecond}, newTestAuthService(t))
+	require.NoError(t, err)
+	const clusters = "krustron.v1.ClusterService"
+	s.RegisterService(clusters, nil, func(grpc.ServiceRegistrar, interface{}) {})
+
+	db, nats := &fakeDependency{}, &fakeDependency{}
+	s.AddDependency(Dependency{Name: "postgres", Check: db.check, Services: []string{clusters}})
+	s.AddDependency(Dependency{Name: "nats", Check: nats.check})
+
+	client := grpc_health_v1.NewHealthClient(dialTestServer(t, s))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	watch := func(service string) grpc_health_v1.Health_WatchClient {
+		stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return stream
+	}
+	next := func(stream grpc_health_v1.Health_WatchClient) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		return resp.Status
+	}
+	const serving, notServing = grpc_health_v1.HealthCheckResponse_SERVING, grpc_health_v1.HealthCheckResponse_NOT_SERVING
+
+	overall, service := watch(""), watch(clusters)
+	assert.Equal(t, serving, next(overall))
+	assert.Equal(t, serving, next(service))
+
+	checks := make(chan struct{})
+	go func() {
+		s.RunHealthChecks(ctx)
+		close(checks)
+	}()
+
+	// The database goes down: the service needing it and the overall
+	// status follow, and the report says why
+	db.down.Store(true)
+	assert.Equal(t, notServing, next(service))
+	assert.Equal(t, notServing, next(overall))
+	report := s.Health()
+	assert.False(t, report.Healthy)
+	assert.Equal(t, "connection refused", report.Dependencies["postgres"].Error)
+	assert.True(t, report.Dependencies["nats"].Healthy)
+
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "dependency/postgres"})
+	require.NoError(t, err)
+	assert.Equal(t, notServing, resp.Status)
+
+	// Recovery flips them back
+	db.down.Store(false)
+	assert.Equal(t, serving, next(service))
+	assert.Equal(t, serving, next(overall))
+
+	// A dependency no service declares only affects the overall status
+	nats.down.Store(true)
+	assert.Equal(t, notServing, next(overall))
+	resp, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: clusters})
+	require.NoError(t, err)
+	assert.Equal(t, serving, resp.Status)
+
+	cancel()
+	<-checks
+}
@@ -0,0 +1,116 @@
+package grpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Metadata of idempotent calls: the key a client sends, and the header
+// marking a replayed response
+const (
+	IdempotencyKeyMetadata     = "idempotency-key"
+	IdempotentReplayedMetadata = "idempotent-replayed"
+)
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+// idempotencyUnaryInterceptor makes unary calls sent with idempotency-key
+// metadata safe to retry: the first call with a key runs and its response
+// is stored, later ones with the same key get that response, and one
+// arriving while the first still runs waits for it. Keys are scoped to
+// the caller. Failed calls aren't stored, so they may be retried.
+func idempotencyUnaryInterceptor(store *cache.Idempotency, logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key := idempotencyKey(ctx)
+		msg, ok := req.(proto.Message)
+		if key == "" || !ok {
+			return handler(ctx, req)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return nil, status.Error(codes.InvalidArgument, "idempotency key is too long")
+		}
+
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return handler(ctx, req)
+		}
+		sum := sha256.Sum256(append([]byte(info.FullMethod+"\n"), data...))
+		fingerprint := hex.EncodeToString(sum[:])
+		storeKey := cache.BuildKey(cache.PrefixIdempotency, "grpc", clientKey(ctx), key)
+
+		stored, err := store.Claim(ctx, storeKey, fingerprint)
+		switch {
+		case err == cache.ErrIdempotencyKeyReused:
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case err == cache.ErrIdempotencyInFlight:
+			return nil, status.Error(codes.Aborted, err.Error())
+		case err != nil:
+			// Without the store the call runs as it would without a key
+			logger.Warn("Idempotency store unavailable", zap.String("method", info.FullMethod), zap.Error(err))
+			return handler(ctx, req)
+		case stored != nil:
+			var packed anypb.Any
+			if err := proto.Unmarshal(stored.Body, &packed); err != nil {
+				return nil, status.Error(codes.Internal, "failed to decode stored response")
+			}
+			resp, err := packed.UnmarshalNew()
+			if err != nil {
+				return nil, status.Error(codes.Internal, "failed to decode stored response")
+			}
+			grpc.SetHeader(ctx, metadata.Pairs(IdempotentReplayedMetadata, "true"))
+			return resp, nil
+		}
+
+		// The outcome is stored even if the client has gone
+		storeCtx := context.WithoutCancel(ctx)
+		completed := false
+		defer func() {
+			if !completed {
+				store.Release(storeCtx, storeKey)
+			}
+		}()
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		if respMsg, ok := resp.(proto.Message); ok {
+			if packed, err := anypb.New(respMsg); err == nil {
+				body, _ := proto.Marshal(packed)
+				err = store.Complete(storeCtx, storeKey, &cache.IdempotentResponse{
+					Fingerprint: fingerprint,
+					Status:      int(codes.OK),
+					Body:        body,
+				})
+				if err != nil {
+					logger.Warn("Failed to store idempotent response", zap.String("method", info.FullMethod), zap.Error(err))
+				}
+				completed = err == nil
+			}
+		}
+		return resp, nil
+	}
+}
+
+// idempotencyKey returns the call's idempotency key, if any
+func idempotencyKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(IdempotencyKeyMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestIdempotencyReplaysUnaryCall(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redisCache, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	defer redisCache.Close()
+
+	interceptor := idempotencyUnaryInterceptor(cache.NewIdempotency(redisCache, 0), zap.NewNop())
+	info := &grpc.UnaryServerInfo{FullMethod: "/krustron.v1.PipelineService/TriggerPipeline"}
+	runs := 0
+	trigger := func(_ context.Context, req interface{}) (interface{}, error) {
+		runs++
+		return structpb.NewStruct(map[string]interface{}{
+			"run":      float64(runs),
+			"pipeline": req.(*wrapperspb.StringValue).GetValue(),
+		})
+	}
+	withKey := func(ctx context.Context, key string) context.Context {
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(IdempotencyKeyMetadata, key))
+	}
+	ctx := asUser(context.Background(), "alice")
+
+	first, err := interceptor(withKey(ctx, "k1"), wrapperspb.String("p1"), info, trigger)
+	require.NoError(t, err)
+	again, err := interceptor(withKey(ctx, "k1"), wrapperspb.String("p1"), info, trigger)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(first.(proto.Message), again.(proto.Message)))
+	assert.Equal(t, 1, runs)
+
+	_, err = interceptor(withKey(ctx, "k1"), wrapperspb.String("p2"), info, trigger)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Other callers and calls without a key run
+	_, err = interceptor(withKey(asUser(context.Background(), "bob"), "k1"), wrapperspb.String("p1"), info, trigger)
+	require.NoError(t, err)
+	_, err = interceptor(ctx, wrapperspb.String("p1"), info, trigger)
+	require.NoError(t, err)
+	assert.Equal(t, 3, runs)
+
+	// Failed calls may be retried
+	failing := func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unavailable, "cluster unreachable")
+	}
+	_, err = interceptor(withKey(ctx, "k2"), wrapperspb.String("p1"), info, failing)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = interceptor(withKey(ctx, "k2"), wrapperspb.String("p1"), info, trigger)
+	require.NoError(t, err)
+	assert.Equal(t, 4, runs)
+}
@@ -0,0 +1,264 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons a call is throttled, as reported by Server.ThrottledCalls
+const (
+	ThrottlePeerRateLimit = "peer_rate_limit"
+	ThrottleRateLimit     = "rate_limit"
+	ThrottleConcurrency   = "concurrency"
+)
+
+// idleLimiterTTL is how long a client's rate limiter is kept after its
+// last call
+const idleLimiterTTL = 10 * time.Minute
+
+// ThrottledCount is how many calls to Method were rejected for Reason
+type ThrottledCount struct {
+	Method string `json:"method"`
+	Reason string `json:"reason"`
+	Count  uint64 `json:"count"`
+}
+
+// throttleMetrics counts rejected calls per method and reason, exporting
+// them as metrics.GRPCThrottledCalls too
+type throttleMetrics struct {
+	mu     sync.Mutex
+	counts map[[2]string]uint64
+}
+
+func newThrottleMetrics() *throttleMetrics {
+	return &throttleMetrics{counts: make(map[[2]string]uint64)}
+}
+
+func (m *throttleMetrics) inc(method, reason string) {
+	m.mu.Lock()
+	m.counts[[2]string{method, reason}]++
+	m.mu.Unlock()
+	metrics.GRPCThrottledCalls.WithLabelValues(method, reason).Inc()
+}
+
+func (m *throttleMetrics) snapshot() []ThrottledCount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]ThrottledCount, 0, len(m.counts))
+	for k, n := range m.counts {
+		out = append(out, ThrottledCount{Method: k[0], Reason: k[1], Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Method != out[j].Method {
+			return out[i].Method < out[j].Method
+		}
+		return out[i].Reason < out[j].Reason
+	})
+	return out
+}
+
+// ThrottledCalls returns how many calls the rate and concurrency limits
+// have rejected, per method and reason
+func (s *Server) ThrottledCalls() []ThrottledCount {
+	return s.throttled.snapshot()
+}
+
+// clientLimiter is one client's token bucket
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter gives every client, as identified by key, a token bucket
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	key     func(context.Context) string
+	reason  string
+	metrics *throttleMetrics
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastPrune time.Time
+}
+
+// newRateLimiter limits each client, a client being the authenticated
+// user or else the peer IP
+func newRateLimiter(perSecond float64, burst int, metrics *throttleMetrics) *rateLimiter {
+	return newKeyedRateLimiter(perSecond, burst, clientKey, ThrottleRateLimit, metrics)
+}
+
+// newPeerRateLimiter limits each peer IP whoever it calls as, so it can run
+// before authentication
+func newPeerRateLimiter(perSecond float64, burst int, metrics *throttleMetrics) *rateLimiter {
+	return newKeyedRateLimiter(perSecond, burst, peerKey, ThrottlePeerRateLimit, metrics)
+}
+
+func newKeyedRateLimiter(perSecond float64, burst int, key func(context.Context) string, reason string, metrics *throttleMetrics) *rateLimiter {
+	if burst <= 0 {
+		burst = int(perSecond)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &rateLimiter{
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		key:     key,
+		reason:  reason,
+		metrics: metrics,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+// clientKey identifies the caller for rate limiting
+func clientKey(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok && claims.UserID != "" {
+		return "user:" + claims.UserID
+	}
+	return peerKey(ctx)
+}
+
+// peerKey identifies the caller by the address it connects from
+func peerKey(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		return "ip:" + addr
+	}
+	return "unknown"
+}
+
+func (r *rateLimiter) allow(ctx context.Context, method string) error {
+	key := r.key(ctx)
+	now := time.Now()
+
+	r.mu.Lock()
+	if now.Sub(r.lastPrune) > idleLimiterTTL {
+		for k, c := range r.clients {
+			if now.Sub(c.lastSeen) > idleLimiterTTL {
+				delete(r.clients, k)
+			}
+		}
+		r.lastPrune = now
+	}
+	c, ok := r.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(r.limit, r.burst)}
+		r.clients[key] = c
+	}
+	c.lastSeen = now
+	allowed := c.limiter.AllowN(now, 1)
+	r.mu.Unlock()
+
+	if !allowed {
+		r.metrics.inc(method, r.reason)
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	return nil
+}
+
+func rateLimitUnaryInterceptor(r *rateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := r.allow(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func rateLimitStreamInterceptor(r *rateLimiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := r.allow(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// concurrencyLimiter caps the calls in flight per method. Calls over the
+// cap are rejected rather than queued.
+type concurrencyLimiter struct {
+	defaultMax int
+	perMethod  map[string]int
+	metrics    *throttleMetrics
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newConcurrencyLimiter(defaultMax int, perMethod map[string]int, metrics *throttleMetrics) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		defaultMax: defaultMax,
+		perMethod:  make(map[string]int, len(perMethod)),
+		metrics:    metrics,
+		inFlight:   make(map[string]int),
+	}
+	for m, n := range perMethod {
+		l.perMethod[m] = n
+	}
+	return l
+}
+
+// acquire reserves a slot for method; the returned func releases it
+func (l *concurrencyLimiter) acquire(method string) (func(), error) {
+	max, ok := l.perMethod[method]
+	if !ok {
+		max = l.defaultMax
+	}
+	if max <= 0 {
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	if l.inFlight[method] >= max {
+		l.mu.Unlock()
+		l.metrics.inc(method, ThrottleConcurrency)
+		return nil, status.Error(codes.ResourceExhausted, "too many concurrent calls")
+	}
+	l.inFlight[method]++
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		l.inFlight[method]--
+		if l.inFlight[method] == 0 {
+			delete(l.inFlight, method)
+		}
+		l.mu.Unlock()
+	}, nil
+}
+
+func concurrencyUnaryInterceptor(l *concurrencyLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := l.acquire(info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+func concurrencyStreamInterceptor(l *concurrencyLimiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.acquire(info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,146 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func fromIP(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+}
+
+func asUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, claimsKey{}, &auth.Claims{UserID: userID})
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	metrics := newThrottleMetrics()
+	// Two calls per client, refilled far slower than the test runs
+	interceptor := rateLimitUnaryInterceptor(newRateLimiter(0.001, 2, metrics))
+	info := &grpc.UnaryServerInfo{FullMethod: "/krustron.v1.ClusterService/ListClusters"}
+	ok := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+
+	burst := func(ctx context.Context, n int) (passed, rejected int) {
+		for i := 0; i < n; i++ {
+			_, err := interceptor(ctx, nil, info, ok)
+			switch status.Code(err) {
+			case codes.OK:
+				passed++
+			case codes.ResourceExhausted:
+				rejected++
+			default:
+				t.Fatalf("unexpected error %v", err)
+			}
+		}
+		return passed, rejected
+	}
+
+	passed, rejected := burst(asUser(fromIP("10.0.0.1"), "alice"), 5)
+	assert.Equal(t, 2, passed)
+	assert.Equal(t, 3, rejected)
+
+	// Another user behind the same address has their own bucket
+	passed, _ = burst(asUser(fromIP("10.0.0.1"), "bob"), 2)
+	assert.Equal(t, 2, passed)
+
+	// Anonymous callers are limited by address
+	passed, rejected = burst(fromIP("10.0.0.2"), 3)
+	assert.Equal(t, 2, passed)
+	assert.Equal(t, 1, rejected)
+	passed, _ = burst(fromIP("10.0.0.3"), 1)
+	assert.Equal(t, 1, passed)
+
+	assert.Equal(t, []ThrottledCount{
+		{Method: info.FullMethod, Reason: ThrottleRateLimit, Count: 4},
+	}, metrics.snapshot())
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	metrics := newThrottleMetrics()
+	interceptor := concurrencyUnaryInterceptor(newConcurrencyLimiter(1, map[string]int{
+		"/krustron.v1.ApplicationService/SyncApplication": 2,
+	}, metrics))
+
+	// Hold calls open until released
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	blocking := func(context.Context, interface{}) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return "ok", nil
+	}
+	call := func(method string, handler grpc.UnaryHandler) error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	const list = "/krustron.v1.ClusterService/ListClusters"
+	const sync = "/krustron.v1.ApplicationService/SyncApplication"
+	done := make(chan error, 3)
+	for _, m := range []string{list, sync, sync} {
+		go func(m string) { done <- call(m, blocking) }(m)
+		<-started
+	}
+
+	// Every slot is taken: the next call to either method is turned away
+	// at once while other methods are unaffected
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call(list, blocking)))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call(sync, blocking)))
+	assert.NoError(t, call("/krustron.v1.ClusterService/GetCluster", func(context.Context, interface{}) (interface{}, error) { return nil, nil }))
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, <-done)
+	}
+	// Slots are freed once calls finish
+	assert.NoError(t, call(list, blocking))
+
+	assert.Equal(t, []ThrottledCount{
+		{Method: sync, Reason: ThrottleConcurrency, Count: 1},
+		{Method: list, Reason: ThrottleConcurrency, Count: 1},
+	}, metrics.snapshot())
+}
+
+func TestNewServerWiresLimits(t *testing.T) {
+	s, err := NewServer(zap.NewNop(), &Config{RateLimit: 5, MaxConcurrentCalls: 10}, newTestAuthService(t))
+	require.NoError(t, err)
+	assert.Empty(t, s.ThrottledCalls())
+}
+
+func TestPeerRateLimitRunsBeforeAuth(t *testing.T) {
+	s, err := NewServer(zap.NewNop(), &Config{PeerRateLimit: 0.001, PeerRateBurst: 2}, newTestAuthService(t))
+	require.NoError(t, err)
+	s.server.RegisterService(&watchServiceDesc, &watchService{calls: make(chan *auth.Claims, 1)})
+	conn := dialTestServer(t, s)
+
+	watch := func() error {
+		stream, err := conn.NewStream(context.Background(), &watchServiceDesc.Streams[0], "/krustron.test.Watcher/Watch")
+		if err != nil {
+			return err
+		}
+		return stream.RecvMsg(&emptypb.Empty{})
+	}
+	// Calls without a token are throttled, not just rejected
+	assert.Equal(t, codes.Unauthenticated, status.Code(watch()))
+	assert.Equal(t, codes.Unauthenticated, status.Code(watch()))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(watch()))
+
+	assert.Equal(t, []ThrottledCount{
+		{Method: "/krustron.test.Watcher/Watch", Reason: ThrottlePeerRateLimit, Count: 1},
+	}, s.ThrottledCalls())
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.GRPCThrottledCalls.WithLabelValues(
+		"/krustron.test.Watcher/Watch", ThrottlePeerRateLimit)))
+}
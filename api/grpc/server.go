@@ -9,9 +9,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
@@ -19,7 +26,6 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
-	"google.golang.org/grpc/status"
 )
 
 // Config holds gRPC server configuration
@@ -35,6 +41,48 @@ type Config struct {
 	KeepaliveTimeout  time.Duration
 	EnableReflection  bool
 	EnableHealthCheck bool
+	// HealthCheckInterval and HealthCheckTimeout are the defaults for
+	// dependencies added with AddDependency
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// UnauthenticatedMethods are full method names ("/pkg.Service/Method")
+	// callable without a token, on top of the health checks
+	UnauthenticatedMethods []string
+	// MethodPermissions maps full method names to the permission the
+	// caller's token must grant (see auth.Claims.HasPermission)
+	MethodPermissions map[string]string
+	// MethodAccess maps full method names to the access they require,
+	// checked with RBAC when the token lacks the permission. Without RBAC
+	// only the permission is checked. Denials are recorded with
+	// AuditRecorder.
+	MethodAccess  map[string]MethodAccess
+	RBAC          *rbac.Service
+	AuditRecorder *audit.Recorder
+	// RateLimit is the calls per second each client may make, a client
+	// being the authenticated user or else the peer IP; 0 disables it.
+	// RateBurst is the bucket size, defaulting to RateLimit.
+	RateLimit float64
+	RateBurst int
+	// PeerRateLimit is the calls per second each peer IP may make, checked
+	// before authentication so floods of calls without a valid token are
+	// turned away too; 0 disables it. PeerRateBurst is the bucket size,
+	// defaulting to PeerRateLimit.
+	PeerRateLimit float64
+	PeerRateBurst int
+	// MaxConcurrentCalls caps the calls in flight per method, with
+	// MethodConcurrency overriding it for single methods; 0 is unlimited
+	MaxConcurrentCalls int
+	MethodConcurrency  map[string]int
+	// TracingEnabled starts a span per call, exported over OTLP/HTTP to
+	// TracingEndpoint as TracingServiceName (default "krustron-grpc").
+	// TracerProvider, when set, is used instead of building an exporter.
+	TracingEnabled     bool
+	TracingEndpoint    string
+	TracingServiceName string
+	TracerProvider     trace.TracerProvider
+	// Idempotency stores the responses of unary calls sent with an
+	// idempotency-key, replaying them for retries; nil disables it
+	Idempotency *cache.Idempotency
 }
 
 // Server represents the gRPC server
@@ -43,12 +91,24 @@ type Server struct {
 	logger       *zap.Logger
 	config       *Config
 	healthServer *health.Server
-	services     map[string]interface{}
+	auth         *auth.Service
+	throttled    *throttleMetrics
+	healthMu     sync.Mutex
+	dependencies []*dependencyState
+	// tracerProvider is set when the server created it and must flush it
+	tracerProvider *sdktrace.TracerProvider
+	services       map[string]interface{}
 	mu           sync.RWMutex
 }
 
-// NewServer creates a new gRPC server
-func NewServer(logger *zap.Logger, config *Config) (*Server, error) {
+// NewServer creates a new gRPC server. Every call except the health checks
+// and config.UnauthenticatedMethods must carry an access token that
+// authService accepts.
+func NewServer(logger *zap.Logger, config *Config, authService *auth.Service) (*Server, error) {
+	if authService == nil {
+		return nil, fmt.Errorf("gRPC server requires an auth service")
+	}
+
 	// Set defaults
 	if config.Port == 0 {
 		config.Port = 9090
@@ -68,6 +128,12 @@ func NewServer(logger *zap.Logger, config *Config) (*Server, error) {
 	if config.KeepaliveTimeout == 0 {
 		config.KeepaliveTimeout = 10 * time.Second
 	}
+	if config.HealthCheckInterval == 0 {
+		config.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if config.HealthCheckTimeout == 0 {
+		config.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
 
 	// Build server options
 	opts := []grpc.ServerOption{
@@ -93,27 +159,91 @@ func NewServer(logger *zap.Logger, config *Config) (*Server, error) {
 		opts = append(opts, grpc.Creds(creds))
 	}
 
-	// Add interceptors
+	// Add interceptors. Tracing comes first so every later step, logging
+	// included, runs inside the call's span. The peer limit runs before
+	// auth so it also throttles callers without a valid token; the other
+	// limits run after auth so they can key on the user.
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+
+	var ownedProvider *sdktrace.TracerProvider
+	if config.TracingEnabled {
+		provider := config.TracerProvider
+		if provider == nil {
+			if config.TracingServiceName == "" {
+				config.TracingServiceName = defaultTracingServiceName
+			}
+			tp, err := newTracerProvider(context.Background(), config.TracingEndpoint, config.TracingServiceName)
+			if err != nil {
+				return nil, err
+			}
+			// Let clients the handlers use pick up the provider and
+			// propagate the trace downstream
+			otel.SetTracerProvider(tp)
+			otel.SetTextMapPropagator(tracePropagator)
+			ownedProvider, provider = tp, tp
+		}
+		tracer := newRPCTracer(provider)
+		unary = append(unary, tracingUnaryInterceptor(tracer))
+		stream = append(stream, tracingStreamInterceptor(tracer))
+	}
+
+	unary = append(unary,
+		loggingUnaryInterceptor(logger),
+		recoveryUnaryInterceptor(logger),
+		errorUnaryInterceptor(logger),
+	)
+	stream = append(stream,
+		loggingStreamInterceptor(logger),
+		recoveryStreamInterceptor(logger),
+		errorStreamInterceptor(logger),
+	)
+	throttled := newThrottleMetrics()
+	if config.PeerRateLimit > 0 {
+		limiter := newPeerRateLimiter(config.PeerRateLimit, config.PeerRateBurst, throttled)
+		unary = append(unary, rateLimitUnaryInterceptor(limiter))
+		stream = append(stream, rateLimitStreamInterceptor(limiter))
+	}
+
+	authorizer := newAuthorizer(authService, config.UnauthenticatedMethods, config.MethodPermissions)
+	unary = append(unary, authUnaryInterceptor(authorizer))
+	stream = append(stream, authStreamInterceptor(authorizer))
+	if len(config.MethodAccess) > 0 {
+		access := &accessChecker{methods: config.MethodAccess, rbac: config.RBAC, recorder: config.AuditRecorder, logger: logger}
+		unary = append(unary, accessUnaryInterceptor(access))
+		stream = append(stream, accessStreamInterceptor(access))
+	}
+
+	if config.RateLimit > 0 {
+		limiter := newRateLimiter(config.RateLimit, config.RateBurst, throttled)
+		unary = append(unary, rateLimitUnaryInterceptor(limiter))
+		stream = append(stream, rateLimitStreamInterceptor(limiter))
+	}
+	if config.MaxConcurrentCalls > 0 || len(config.MethodConcurrency) > 0 {
+		limiter := newConcurrencyLimiter(config.MaxConcurrentCalls, config.MethodConcurrency, throttled)
+		unary = append(unary, concurrencyUnaryInterceptor(limiter))
+		stream = append(stream, concurrencyStreamInterceptor(limiter))
+	}
+	if config.Idempotency != nil {
+		unary = append(unary, idempotencyUnaryInterceptor(config.Idempotency, logger))
+	}
+
 	opts = append(opts,
-		grpc.ChainUnaryInterceptor(
-			loggingUnaryInterceptor(logger),
-			recoveryUnaryInterceptor(logger),
-			authUnaryInterceptor(),
-		),
-		grpc.ChainStreamInterceptor(
-			loggingStreamInterceptor(logger),
-			recoveryStreamInterceptor(logger),
-			authStreamInterceptor(),
-		),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
 	)
 
 	server := grpc.NewServer(opts...)
 
 	s := &Server{
-		server:   server,
-		logger:   logger,
-		config:   config,
-		services: make(map[string]interface{}),
+		server:    server,
+		logger:    logger,
+		config:    config,
+		auth:      authService,
+		throttled: throttled,
+		services:  make(map[string]interface{}),
+
+		tracerProvider: ownedProvider,
 	}
 
 	// Register health check
@@ -141,6 +271,10 @@ func (s *Server) RegisterService(name string, service interface{}, registrar fun
 
 	if s.healthServer != nil {
 		s.healthServer.SetServingStatus(name, grpc_health_v1.HealthCheckResponse_SERVING)
+		// A service whose dependency is already down starts NOT_SERVING
+		s.healthMu.Lock()
+		s.publishHealthLocked()
+		s.healthMu.Unlock()
 	}
 
 	s.logger.Info("Registered gRPC service", zap.String("service", name))
@@ -166,12 +300,26 @@ func (s *Server) Start() error {
 func (s *Server) Stop() {
 	s.logger.Info("Stopping gRPC server")
 	s.server.GracefulStop()
+	s.shutdownTracing()
 }
 
 // ForceStop forcefully stops the gRPC server
 func (s *Server) ForceStop() {
 	s.logger.Info("Force stopping gRPC server")
 	s.server.Stop()
+	s.shutdownTracing()
+}
+
+// shutdownTracing flushes the spans still queued for export
+func (s *Server) shutdownTracing() {
+	if s.tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.tracerProvider.Shutdown(ctx); err != nil {
+		s.logger.Warn("Failed to flush traces", zap.Error(err))
+	}
 }
 
 // SetServiceHealth sets the health status of a service
@@ -215,6 +363,7 @@ func loggingUnaryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 			zap.Duration("duration", duration),
 			zap.String("client_ip", clientIP),
 		}
+		fields = append(fields, traceFields(ctx)...)
 
 		if requestID != "" {
 			fields = append(fields, zap.String("request_id", requestID))
@@ -244,6 +393,7 @@ func loggingStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
 			zap.Bool("client_stream", info.IsClientStream),
 			zap.Bool("server_stream", info.IsServerStream),
 		}
+		fields = append(fields, traceFields(ss.Context())...)
 
 		if err != nil {
 			fields = append(fields, zap.Error(err))
@@ -264,7 +414,7 @@ func recoveryUnaryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
 					zap.Any("panic", r),
 					zap.String("method", info.FullMethod),
 				)
-				err = status.Errorf(codes.Internal, "internal server error")
+				err = errors.Internal("internal server error").GRPCStatus().Err()
 			}
 		}()
 		return handler(ctx, req)
@@ -279,63 +429,13 @@ func recoveryStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor
 					zap.Any("panic", r),
 					zap.String("method", info.FullMethod),
 				)
-				err = status.Errorf(codes.Internal, "internal server error")
+				err = errors.Internal("internal server error").GRPCStatus().Err()
 			}
 		}()
 		return handler(srv, ss)
 	}
 }
 
-func authUnaryInterceptor() grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		// Skip auth for health checks
-		if info.FullMethod == "/grpc.health.v1.Health/Check" {
-			return handler(ctx, req)
-		}
-
-		// Extract token from metadata
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.Unauthenticated, "missing metadata")
-		}
-
-		tokens := md.Get("authorization")
-		if len(tokens) == 0 {
-			// For now, allow unauthenticated requests
-			// In production, return error
-			return handler(ctx, req)
-		}
-
-		// Validate token (simplified - use proper JWT validation)
-		token := tokens[0]
-		if !validateToken(token) {
-			return nil, status.Error(codes.Unauthenticated, "invalid token")
-		}
-
-		// Add user info to context
-		ctx = context.WithValue(ctx, "user_id", extractUserID(token))
-
-		return handler(ctx, req)
-	}
-}
-
-func authStreamInterceptor() grpc.StreamServerInterceptor {
-	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		// Similar to unary interceptor
-		return handler(srv, ss)
-	}
-}
-
-func validateToken(token string) bool {
-	// Simplified validation - implement proper JWT validation
-	return len(token) > 0
-}
-
-func extractUserID(token string) string {
-	// Simplified extraction - implement proper JWT parsing
-	return "user"
-}
-
 // Service definitions for Krustron gRPC API
 
 // ClusterService defines cluster management operations
@@ -0,0 +1,145 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tracerName                = "github.com/anubhavg-icpl/krustron/api/grpc"
+	defaultTracingServiceName = "krustron-grpc"
+)
+
+// tracePropagator reads and writes W3C trace context and baggage
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// newTracerProvider exports spans over OTLP/HTTP to endpoint, e.g.
+// "http://otel-collector:4318"
+func newTracerProvider(ctx context.Context, endpoint, serviceName string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	), nil
+}
+
+// metadataCarrier adapts gRPC metadata to the OTel propagation API
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// rpcTracer starts a server span per call, continuing the trace the
+// caller propagated in its metadata
+type rpcTracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newRPCTracer(provider trace.TracerProvider) *rpcTracer {
+	return &rpcTracer{
+		tracer:     provider.Tracer(tracerName),
+		propagator: tracePropagator,
+	}
+}
+
+// start opens the span for method. Handlers get the returned ctx, so any
+// span they start (or propagate downstream) becomes its child.
+func (t *rpcTracer) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("rpc.system", "grpc")}
+	if service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/"); ok {
+		attrs = append(attrs, attribute.String("rpc.service", service), attribute.String("rpc.method", name))
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = t.propagator.Extract(ctx, metadataCarrier(md))
+		if ids := md.Get("x-request-id"); len(ids) > 0 {
+			attrs = append(attrs, attribute.String("request.id", ids[0]))
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			attrs = append(attrs, attribute.String("client.address", host))
+		}
+	}
+
+	return t.tracer.Start(ctx, strings.TrimPrefix(method, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// end records the call's outcome on span and closes it
+func (t *rpcTracer) end(span trace.Span, err error) {
+	st := status.Convert(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(st.Code())))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, st.Message())
+	}
+	span.End()
+}
+
+func tracingUnaryInterceptor(t *rpcTracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := t.start(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		t.end(span, err)
+		return resp, err
+	}
+}
+
+func tracingStreamInterceptor(t *rpcTracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := t.start(ss.Context(), info.FullMethod)
+		err := handler(srv, &WrappedServerStream{ServerStream: ss, WrappedContext: ctx})
+		t.end(span, err)
+		return err
+	}
+}
+
+// traceFields returns the IDs of the span in ctx, so log lines can be
+// joined with their trace
+func traceFields(ctx context.Context) []zap.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []zap.Field{
+		zap.String("trace_id", sc.TraceID().String()),
+		zap.String("span_id", sc.SpanID().String()),
+	}
+}
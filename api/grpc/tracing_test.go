@@ -0,0 +1,92 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracingInterceptors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	core, logs := observer.New(zapcore.InfoLevel)
+	s, err := NewServer(zap.New(core), &Config{
+		EnableHealthCheck: true,
+		TracingEnabled:    true,
+		TracerProvider:    sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	}, newTestAuthService(t))
+	require.NoError(t, err)
+	s.server.RegisterService(&watchServiceDesc, &watchService{calls: make(chan *auth.Claims, 1)})
+	conn := dialTestServer(t, s)
+
+	// A caller already inside a trace, passing it on in W3C form
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"traceparent", "00-"+parent.TraceID().String()+"-"+parent.SpanID().String()+"-01",
+		"x-request-id", "req-42",
+	)
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	stream, err := conn.NewStream(context.Background(), &watchServiceDesc.Streams[0], "/krustron.test.Watcher/Watch")
+	require.NoError(t, err)
+	require.NoError(t, stream.CloseSend())
+	err = stream.RecvMsg(&emptypb.Empty{})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	check := spans[0]
+	assert.Equal(t, "grpc.health.v1.Health/Check", check.Name())
+	assert.Equal(t, trace.SpanKindServer, check.SpanKind())
+	assert.Equal(t, parent.TraceID(), check.SpanContext().TraceID(), "continues the caller's trace")
+	assert.Equal(t, parent.SpanID(), check.Parent().SpanID())
+	assert.Equal(t, "grpc.health.v1.Health", spanAttr(check, "rpc.service").AsString())
+	assert.Equal(t, "Check", spanAttr(check, "rpc.method").AsString())
+	assert.Equal(t, "req-42", spanAttr(check, "request.id").AsString())
+	assert.Equal(t, int64(codes.OK), spanAttr(check, "rpc.grpc.status_code").AsInt64())
+	assert.Equal(t, otelcodes.Unset, check.Status().Code)
+
+	watch := spans[1]
+	assert.Equal(t, "krustron.test.Watcher/Watch", watch.Name())
+	assert.False(t, watch.Parent().IsValid(), "starts a new trace")
+	assert.Equal(t, int64(codes.Unauthenticated), spanAttr(watch, "rpc.grpc.status_code").AsInt64())
+	assert.Equal(t, otelcodes.Error, watch.Status().Code)
+
+	// Log lines carry the IDs of the span they were written in
+	entries := logs.FilterMessage("gRPC request completed").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, check.SpanContext().TraceID().String(), fields["trace_id"])
+	assert.Equal(t, check.SpanContext().SpanID().String(), fields["span_id"])
+}
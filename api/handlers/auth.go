@@ -5,8 +5,10 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
 	"github.com/anubhavg-icpl/krustron/pkg/errors"
 	"github.com/gin-gonic/gin"
 )
@@ -235,6 +237,66 @@ func RevokeSession(svc *auth.Service) gin.HandlerFunc {
 	}
 }
 
+// ListAPIKeys lists the caller's API keys. The keys themselves are never
+// returned, only their prefixes.
+func ListAPIKeys(svc *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := c.Get("user_id")
+		keys, err := svc.ListAPIKeys(c.Request.Context(), userID.(string))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": keys})
+	}
+}
+
+// CreateAPIKey creates an API key for the caller. The key is in the
+// response only; it cannot be retrieved later.
+func CreateAPIKey(svc *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// A key must not mint further keys
+		if claims, ok := c.Get("claims"); ok {
+			if cs, ok := claims.(*auth.Claims); ok && cs.TokenType == auth.TokenTypeAPIKey {
+				handleError(c, errors.Forbidden("API keys cannot create API keys"))
+				return
+			}
+		}
+
+		var req struct {
+			Name       string     `json:"name"`
+			Scopes     []string   `json:"scopes" binding:"required"`
+			AllowedIPs []string   `json:"allowed_ips"`
+			ExpiresAt  *time.Time `json:"expires_at"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		userID, _ := c.Get("user_id")
+		key, err := svc.CreateAPIKey(c.Request.Context(), userID.(string), req.Scopes, req.ExpiresAt,
+			auth.WithAPIKeyName(req.Name), auth.WithAllowedIPs(req.AllowedIPs...))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": gin.H{"key": key}})
+	}
+}
+
+// RevokeAPIKey revokes one of the caller's API keys.
+func RevokeAPIKey(svc *auth.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := c.Get("user_id")
+		if err := svc.RevokeAPIKey(c.Request.Context(), userID.(string), c.Param("id")); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+	}
+}
+
 // Setup2FA generates a TOTP secret + otpauth URL for enrollment.
 func Setup2FA(svc *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -303,12 +365,23 @@ func ChangePassword(svc *auth.Service) gin.HandlerFunc {
 	}
 }
 
-// ListUsers returns all users (admin only)
+// ListUsers returns all users (admin only). A cursor query parameter,
+// empty for the first page, pages by keyset instead of page number.
 func ListUsers(svc *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			users, next, err := svc.ListUsersAfter(c.Request.Context(), cursor, limit)
+			if err != nil {
+				handleError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": users, "next_cursor": next, "limit": limit})
+			return
+		}
+
 		users, total, err := svc.ListUsers(c.Request.Context(), page, limit)
 		if err != nil {
 			handleError(c, err)
@@ -503,13 +576,41 @@ func ListPermissions(svc *auth.Service) gin.HandlerFunc {
 	}
 }
 
-// ListAuditLogs returns audit logs
+// ListAuditLogs returns audit logs matching the query filters. from and
+// to are RFC 3339 times; q searches resource names and error messages. A
+// cursor, empty for the first page, pages by keyset instead of page.
 func ListAuditLogs(svc *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
 
-		logs, total, err := svc.ListAuditLogs(c.Request.Context(), page, limit)
+		filter := auth.AuditLogFilter{
+			UserID:       c.Query("user_id"),
+			UserEmail:    c.Query("user_email"),
+			Action:       c.Query("action"),
+			ResourceType: c.Query("resource_type"),
+			Cluster:      c.Query("cluster"),
+			Status:       c.Query("status"),
+			Search:       c.Query("q"),
+			Page:         page,
+			Limit:        limit,
+		}
+		var ok bool
+		if filter.From, filter.To, ok = timeRange(c); !ok {
+			return
+		}
+
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			logs, next, err := svc.SearchAuditLogsAfter(c.Request.Context(), filter, cursor)
+			if err != nil {
+				handleError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": logs, "next_cursor": next, "limit": limit})
+			return
+		}
+
+		logs, total, err := svc.SearchAuditLogs(c.Request.Context(), filter)
 		if err != nil {
 			handleError(c, err)
 			return
@@ -524,6 +625,27 @@ func ListAuditLogs(svc *auth.Service) gin.HandlerFunc {
 	}
 }
 
+// timeRange reads the optional from and to query parameters as RFC 3339
+// times, responding with 400 and returning false if either is malformed
+func timeRange(c *gin.Context) (from, to time.Time, ok bool) {
+	for _, bound := range []struct {
+		param string
+		dst   *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(bound.param+" must be an RFC 3339 time").ToResponse(getRequestID(c)))
+			return time.Time{}, time.Time{}, false
+		}
+		*bound.dst = t
+	}
+	return from, to, true
+}
+
 // GetAuditLog returns a single audit log entry
 func GetAuditLog(svc *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -539,57 +661,82 @@ func GetAuditLog(svc *auth.Service) gin.HandlerFunc {
 	}
 }
 
-// ExportAuditLogs exports audit logs
+// ExportAuditLogs streams the audit logs matching the ListAuditLogs
+// filters as csv, json or ndjson
 func ExportAuditLogs(svc *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		format := c.DefaultQuery("format", "csv")
-
-		data, contentType, err := svc.ExportAuditLogs(c.Request.Context(), format)
+		format := c.DefaultQuery("format", auth.ExportFormatCSV)
+		contentType, err := auth.ExportContentType(format)
 		if err != nil {
 			handleError(c, err)
 			return
 		}
 
-		c.Header("Content-Disposition", "attachment; filename=audit_logs."+format)
-		c.Data(http.StatusOK, contentType, data)
-	}
-}
-
-// GetSettings returns system settings
-func GetSettings(svc *auth.Service) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		settings, err := svc.GetSettings(c.Request.Context())
-		if err != nil {
-			handleError(c, err)
+		filter := auth.AuditLogFilter{
+			UserID:       c.Query("user_id"),
+			UserEmail:    c.Query("user_email"),
+			Action:       c.Query("action"),
+			ResourceType: c.Query("resource_type"),
+			Cluster:      c.Query("cluster"),
+			Status:       c.Query("status"),
+			Search:       c.Query("q"),
+		}
+		var ok bool
+		if filter.From, filter.To, ok = timeRange(c); !ok {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": settings})
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", "attachment; filename=audit_logs."+format)
+		if err := svc.ExportAuditLogs(c.Request.Context(), c.Writer, format, filter); err != nil {
+			if c.Writer.Written() {
+				// The response is already under way; record the error only
+				c.Error(err)
+				return
+			}
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			handleError(c, err)
+		}
 	}
 }
 
-// UpdateSettings updates system settings
-func UpdateSettings(svc *auth.Service) gin.HandlerFunc {
+// VerifyAuditChain checks the hash chains of the audit logs, and of the
+// RBAC audit logs when fine-grained RBAC is enabled, over an optional
+// from/to range of RFC 3339 times
+func VerifyAuditChain(svc *auth.Service, rbacSvc *rbac.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var req map[string]interface{}
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+		from, to, ok := timeRange(c)
+		if !ok {
 			return
 		}
 
-		if err := svc.UpdateSettings(c.Request.Context(), req); err != nil {
+		report, err := svc.VerifyAuditChain(c.Request.Context(), from, to)
+		if err != nil {
 			handleError(c, err)
 			return
 		}
+		data := gin.H{"audit_logs": report}
+		intact := report.Intact
 
-		c.JSON(http.StatusOK, gin.H{"message": "settings updated successfully"})
+		if rbacSvc != nil {
+			rbacReport, err := rbacSvc.VerifyAuditChain(c.Request.Context(), from, to)
+			if err != nil {
+				handleError(c, errors.DatabaseWrap(err, "failed to verify RBAC audit logs"))
+				return
+			}
+			data["rbac_audit_logs"] = rbacReport
+			intact = intact && rbacReport.Intact
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": data, "intact": intact})
 	}
 }
 
-// GetNotificationSettings returns notification settings
-func GetNotificationSettings(svc *auth.Service) gin.HandlerFunc {
+// GetSettings returns system settings
+func GetSettings(svc *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		settings, err := svc.GetNotificationSettings(c.Request.Context())
+		settings, err := svc.GetSettings(c.Request.Context())
 		if err != nil {
 			handleError(c, err)
 			return
@@ -599,8 +746,8 @@ func GetNotificationSettings(svc *auth.Service) gin.HandlerFunc {
 	}
 }
 
-// UpdateNotificationSettings updates notification settings
-func UpdateNotificationSettings(svc *auth.Service) gin.HandlerFunc {
+// UpdateSettings updates system settings
+func UpdateSettings(svc *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req map[string]interface{}
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -608,11 +755,11 @@ func UpdateNotificationSettings(svc *auth.Service) gin.HandlerFunc {
 			return
 		}
 
-		if err := svc.UpdateNotificationSettings(c.Request.Context(), req); err != nil {
+		if err := svc.UpdateSettings(c.Request.Context(), req); err != nil {
 			handleError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "notification settings updated successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "settings updated successfully"})
 	}
 }
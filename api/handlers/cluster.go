@@ -3,14 +3,20 @@
 package handlers
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/anubhavg-icpl/krustron/internal/cluster"
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
 	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // ListClusters returns all clusters
@@ -43,6 +49,57 @@ func ListClusters(svc *cluster.Service) gin.HandlerFunc {
 	}
 }
 
+// SearchResources finds pods, deployments and services across all
+// connected clusters by name substring, label selector and kind
+func SearchResources(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := cluster.SearchQuery{
+			Name:          c.Query("name"),
+			LabelSelector: c.Query("labelSelector"),
+			Namespace:     c.Query("namespace"),
+		}
+		if kinds := c.Query("kind"); kinds != "" {
+			query.Kinds = strings.Split(kinds, ",")
+		}
+
+		result, err := svc.SearchResources(c.Request.Context(), query)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"data":     result.Matches,
+			"failed":   result.Failed,
+			"searched": result.Searched,
+		})
+	}
+}
+
+// CompareNamespaces reports how a namespace differs between two clusters.
+// ignoreFields and ignoreLabels take comma-separated lists.
+func CompareNamespaces(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var opts cluster.CompareOptions
+		if fields := c.Query("ignoreFields"); fields != "" {
+			opts.IgnoreFields = strings.Split(fields, ",")
+		}
+		if labels := c.Query("ignoreLabels"); labels != "" {
+			opts.IgnoreLabels = strings.Split(labels, ",")
+		}
+
+		diff, err := svc.CompareNamespaces(c.Request.Context(),
+			c.Query("clusterA"), c.Query("namespaceA"),
+			c.Query("clusterB"), c.Query("namespaceB"), opts)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": diff, "in_sync": diff.InSync()})
+	}
+}
+
 // GetCluster returns a single cluster
 func GetCluster(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -115,6 +172,41 @@ func DeleteCluster(svc *cluster.Service) gin.HandlerFunc {
 	}
 }
 
+// GetClusterConfig returns a cluster's overrides of global settings
+func GetClusterConfig(store *clusterconfig.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg, err := store.GetClusterConfig(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": cfg})
+	}
+}
+
+// SetClusterConfig replaces a cluster's overrides of global settings; an
+// empty object removes them
+func SetClusterConfig(store *clusterconfig.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var overrides clusterconfig.Overrides
+		if err := c.ShouldBindJSON(&overrides); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		userID, _ := c.Get("user_id")
+		updatedBy, _ := userID.(string)
+		cfg := &clusterconfig.ClusterConfig{ClusterID: c.Param("id"), Overrides: overrides, UpdatedBy: updatedBy}
+		if err := store.SetClusterConfig(c.Request.Context(), cfg); err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": cfg})
+	}
+}
+
 // GetClusterHealth returns cluster health status
 func GetClusterHealth(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -145,6 +237,33 @@ func GetClusterResources(svc *cluster.Service) gin.HandlerFunc {
 	}
 }
 
+// GetNodes returns the nodes of a cluster with their capacity and usage
+func GetNodes(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nodes, err := svc.GetNodes(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": nodes})
+	}
+}
+
+// GetClusterCapacity returns allocatable versus requested resources and
+// the scheduling headroom of a cluster
+func GetClusterCapacity(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		capacity, err := svc.GetCapacity(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": capacity})
+	}
+}
+
 // GetNamespaces returns namespaces in a cluster
 func GetNamespaces(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -160,19 +279,31 @@ func GetNamespaces(svc *cluster.Service) gin.HandlerFunc {
 	}
 }
 
-// GetPods returns pods in a namespace
+// listOptions reads paging and selectors for a namespaced list from the
+// query: limit, continue, labelSelector and fieldSelector
+func listOptions(c *gin.Context) kube.ListOptions {
+	limit, _ := strconv.ParseInt(c.Query("limit"), 10, 64)
+	return kube.ListOptions{
+		Limit:         max(limit, 0),
+		Continue:      c.Query("continue"),
+		LabelSelector: c.Query("labelSelector"),
+		FieldSelector: c.Query("fieldSelector"),
+	}
+}
+
+// GetPods returns a page of pods in a namespace
 func GetPods(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clusterID := c.Param("id")
 		namespace := c.Param("namespace")
 
-		pods, err := svc.GetPods(c.Request.Context(), clusterID, namespace)
+		pods, next, err := svc.ListPods(c.Request.Context(), clusterID, namespace, listOptions(c))
 		if err != nil {
 			handleError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": pods})
+		c.JSON(http.StatusOK, gin.H{"data": pods, "continue": next})
 	}
 }
 
@@ -195,54 +326,321 @@ func GetPodLogs(svc *cluster.Service) gin.HandlerFunc {
 	}
 }
 
-// GetServices returns services in a namespace
+// GetServices returns a page of services in a namespace
 func GetServices(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clusterID := c.Param("id")
 		namespace := c.Param("namespace")
 
-		services, err := svc.GetServices(c.Request.Context(), clusterID, namespace)
+		services, next, err := svc.ListServices(c.Request.Context(), clusterID, namespace, listOptions(c))
 		if err != nil {
 			handleError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": services})
+		c.JSON(http.StatusOK, gin.H{"data": services, "continue": next})
 	}
 }
 
-// GetDeployments returns deployments in a namespace
+// GetDeployments returns a page of deployments in a namespace
 func GetDeployments(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clusterID := c.Param("id")
 		namespace := c.Param("namespace")
 
-		deployments, err := svc.GetDeployments(c.Request.Context(), clusterID, namespace)
+		deployments, next, err := svc.ListDeployments(c.Request.Context(), clusterID, namespace, listOptions(c))
 		if err != nil {
 			handleError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": deployments})
+		c.JSON(http.StatusOK, gin.H{"data": deployments, "continue": next})
 	}
 }
 
-// GetEvents returns events in a namespace
+// GetEvents returns a page of events in a namespace
 func GetEvents(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clusterID := c.Param("id")
 		namespace := c.Param("namespace")
 
-		events, err := svc.GetEvents(c.Request.Context(), clusterID, namespace)
+		events, next, err := svc.ListEvents(c.Request.Context(), clusterID, namespace, listOptions(c))
 		if err != nil {
 			handleError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": events})
+		c.JSON(http.StatusOK, gin.H{"data": events, "continue": next})
 	}
 }
 
+// ListAPIResources returns the resource types a cluster serves, CRDs
+// included
+func ListAPIResources(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resources, err := svc.ListAPIResources(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": resources})
+	}
+}
+
+// resourceGVR reads the group, version and resource path parameters; the
+// core group is written "core"
+func resourceGVR(c *gin.Context) schema.GroupVersionResource {
+	group := c.Param("group")
+	if group == "core" {
+		group = ""
+	}
+	return schema.GroupVersionResource{Group: group, Version: c.Param("version"), Resource: c.Param("resource")}
+}
+
+// ListResources returns a page of objects of any resource type. The
+// namespace query parameter is left empty for cluster-scoped resources or
+// to list across all namespaces.
+func ListResources(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		items, next, err := svc.ListResources(c.Request.Context(), c.Param("id"), resourceGVR(c),
+			c.Query("namespace"), listOptions(c))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": items, "continue": next})
+	}
+}
+
+// GetResource returns one object of any resource type. The namespace query
+// parameter is required for namespaced resources.
+func GetResource(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		obj, err := svc.GetResource(c.Request.Context(), c.Param("id"), resourceGVR(c),
+			c.Query("namespace"), c.Param("name"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": obj})
+	}
+}
+
+// ApplyManifestRequest is a manifest to apply to a cluster
+type ApplyManifestRequest struct {
+	Manifest string `json:"manifest" binding:"required"`
+	cluster.ApplyOptions
+}
+
+// ApplyManifest server-side applies a multi-document YAML manifest. With
+// dry_run set nothing is persisted and each object carries its diff.
+func ApplyManifest(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ApplyManifestRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		results, err := svc.ApplyManifest(c.Request.Context(), c.Param("id"), []byte(req.Manifest), req.ApplyOptions)
+		if err != nil {
+			// Objects applied before the failure are reported with it
+			status, body := errors.HTTPResponse(err, getRequestID(c))
+			c.JSON(status, gin.H{"error": body.Error, "data": results})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": results, "dry_run": req.DryRun})
+	}
+}
+
+// ListAppliedResources returns the objects applied to a cluster from
+// manifests
+func ListAppliedResources(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resources, err := svc.ListAppliedResources(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": resources})
+	}
+}
+
+// DeleteAppliedResources deletes the objects applied to a cluster from
+// manifests
+func DeleteAppliedResources(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deleted, err := svc.DeleteAppliedResources(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": deleted})
+	}
+}
+
+// ListResourceQuotas returns the ResourceQuotas of a namespace
+func ListResourceQuotas(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		quotas, err := svc.ListResourceQuotas(c.Request.Context(), c.Param("id"), c.Param("namespace"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": quotas})
+	}
+}
+
+// SetResourceQuota creates or updates a ResourceQuota on a namespace
+func SetResourceQuota(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var spec cluster.ResourceQuotaSpec
+		if err := c.ShouldBindJSON(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		userID, _ := c.Get("user_id")
+		uid, _ := userID.(string)
+		quota, err := svc.SetResourceQuota(c.Request.Context(), c.Param("id"), c.Param("namespace"), spec, uid)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": quota})
+	}
+}
+
+// CheckResourceQuota returns the resources a namespace already uses more
+// of than a proposed quota allows
+func CheckResourceQuota(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var spec cluster.ResourceQuotaSpec
+		if err := c.ShouldBindJSON(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		violations, err := svc.CheckResourceQuota(c.Request.Context(), c.Param("id"), c.Param("namespace"), spec.Hard)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": violations})
+	}
+}
+
+// RecommendQuota suggests a quota for a namespace from its recent usage
+func RecommendQuota(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rec, err := svc.RecommendQuota(c.Request.Context(), c.Param("id"), c.Param("namespace"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": rec})
+	}
+}
+
+// ListLimitRanges returns the LimitRanges of a namespace
+func ListLimitRanges(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ranges, err := svc.ListLimitRanges(c.Request.Context(), c.Param("id"), c.Param("namespace"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": ranges})
+	}
+}
+
+// SetLimitRange creates or updates a LimitRange on a namespace
+func SetLimitRange(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var spec cluster.LimitRangeSpec
+		if err := c.ShouldBindJSON(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		userID, _ := c.Get("user_id")
+		uid, _ := userID.(string)
+		limitRange, err := svc.SetLimitRange(c.Request.Context(), c.Param("id"), c.Param("namespace"), spec, uid)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": limitRange})
+	}
+}
+
+// workloadOperation responds with the state of the workload named by the
+// route after op; clients poll it until the rollout completes
+func workloadOperation(op func(c *gin.Context, clusterID, namespace, kind, name string) (*cluster.WorkloadStatus, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, err := op(c, c.Param("id"), c.Param("namespace"), c.Param("kind"), c.Param("name"))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": status})
+	}
+}
+
+// RestartWorkload rolls the pods of a Deployment, StatefulSet or DaemonSet
+func RestartWorkload(svc *cluster.Service) gin.HandlerFunc {
+	return workloadOperation(func(c *gin.Context, clusterID, namespace, kind, name string) (*cluster.WorkloadStatus, error) {
+		return svc.RestartWorkload(c.Request.Context(), clusterID, namespace, kind, name)
+	})
+}
+
+// ScaleWorkloadRequest sets the replicas of a workload
+type ScaleWorkloadRequest struct {
+	Replicas *int32 `json:"replicas" binding:"required"`
+}
+
+// ScaleWorkload sets the replicas of a Deployment or StatefulSet
+func ScaleWorkload(svc *cluster.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ScaleWorkloadRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		workloadOperation(func(c *gin.Context, clusterID, namespace, kind, name string) (*cluster.WorkloadStatus, error) {
+			return svc.ScaleWorkload(c.Request.Context(), clusterID, namespace, kind, name, *req.Replicas)
+		})(c)
+	}
+}
+
+// PauseRollout pauses the rollout of a Deployment
+func PauseRollout(svc *cluster.Service) gin.HandlerFunc {
+	return workloadOperation(func(c *gin.Context, clusterID, namespace, kind, name string) (*cluster.WorkloadStatus, error) {
+		return svc.PauseRollout(c.Request.Context(), clusterID, namespace, kind, name)
+	})
+}
+
+// ResumeRollout resumes the paused rollout of a Deployment
+func ResumeRollout(svc *cluster.Service) gin.HandlerFunc {
+	return workloadOperation(func(c *gin.Context, clusterID, namespace, kind, name string) (*cluster.WorkloadStatus, error) {
+		return svc.ResumeRollout(c.Request.Context(), clusterID, namespace, kind, name)
+	})
+}
+
 // InstallAgent installs the Krustron agent on a cluster
 func InstallAgent(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -257,6 +655,56 @@ func InstallAgent(svc *cluster.Service) gin.HandlerFunc {
 	}
 }
 
+// BulkClustersRequest names the clusters of a bulk operation
+type BulkClustersRequest struct {
+	ClusterIDs []string `json:"cluster_ids" binding:"required"`
+	// Labels are merged into each cluster's labels by BulkUpdateLabels; an
+	// empty value removes the label
+	Labels map[string]string `json:"labels"`
+}
+
+// bulkClusters binds a BulkClustersRequest and responds with the
+// per-cluster results of op
+func bulkClusters(op func(c *gin.Context, req *BulkClustersRequest) (map[string]cluster.BulkResult, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BulkClustersRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		results, err := op(c, &req)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": results})
+	}
+}
+
+// BulkInstallAgent installs the agent on several clusters, reporting the
+// outcome per cluster
+func BulkInstallAgent(svc *cluster.Service) gin.HandlerFunc {
+	return bulkClusters(func(c *gin.Context, req *BulkClustersRequest) (map[string]cluster.BulkResult, error) {
+		return svc.BulkInstallAgent(c.Request.Context(), req.ClusterIDs)
+	})
+}
+
+// BulkHealthCheck checks the health of several clusters
+func BulkHealthCheck(svc *cluster.Service) gin.HandlerFunc {
+	return bulkClusters(func(c *gin.Context, req *BulkClustersRequest) (map[string]cluster.BulkResult, error) {
+		return svc.BulkHealthCheck(c.Request.Context(), req.ClusterIDs)
+	})
+}
+
+// BulkUpdateLabels merges labels into the labels of several clusters
+func BulkUpdateLabels(svc *cluster.Service) gin.HandlerFunc {
+	return bulkClusters(func(c *gin.Context, req *BulkClustersRequest) (map[string]cluster.BulkResult, error) {
+		return svc.BulkUpdateLabels(c.Request.Context(), req.ClusterIDs, req.Labels)
+	})
+}
+
 // ClusterEventsWS streams cluster events via WebSocket
 // wsUpgrader upgrades the dedicated resource-streaming sockets. Origin checks
 // are permissive (same as the dashboard socket); auth is enforced by WSAuth on
@@ -294,40 +742,102 @@ func ClusterEventsWS(svc *cluster.Service) gin.HandlerFunc {
 	}
 }
 
-// PodLogsWS streams a pod's logs (follow) over WebSocket.
+// logOptions reads follow, previous, timestamps, sinceTime (RFC3339),
+// sinceSeconds, tail and limitBytes from the query string
+func logOptions(c *gin.Context) (kube.LogOptions, error) {
+	opts := kube.LogOptions{
+		Follow:     c.DefaultQuery("follow", "true") == "true",
+		Previous:   c.Query("previous") == "true",
+		Timestamps: c.Query("timestamps") == "true",
+	}
+	if v := c.Query("sinceTime"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return opts, errors.BadRequest("sinceTime must be RFC3339")
+		}
+		opts.SinceTime = &t
+	}
+	opts.SinceSeconds, _ = strconv.ParseInt(c.Query("sinceSeconds"), 10, 64)
+	opts.TailLines, _ = strconv.ParseInt(c.Query("tail"), 10, 64)
+	opts.LimitBytes, _ = strconv.ParseInt(c.Query("limitBytes"), 10, 64)
+	return opts, nil
+}
+
+// wsLineWriter sends each complete log line as one text frame, holding a
+// trailing partial line until the rest arrives
+type wsLineWriter struct {
+	conn    *websocket.Conn
+	pending []byte
+}
+
+func (w *wsLineWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := w.conn.WriteMessage(websocket.TextMessage, w.pending[:i]); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[i+1:]
+	}
+}
+
+func (w *wsLineWriter) flush() {
+	if len(w.pending) > 0 {
+		_ = w.conn.WriteMessage(websocket.TextMessage, w.pending)
+		w.pending = nil
+	}
+}
+
+// PodLogsWS streams a pod's logs over WebSocket, following by default.
+// The stream stops when the client disconnects.
 func PodLogsWS(svc *cluster.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clusterID := c.Param("cluster")
 		namespace := c.Param("namespace")
 		pod := c.Param("pod")
 
-		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		opts, err := logOptions(c)
 		if err != nil {
+			handleError(c, err)
 			return
 		}
-		defer conn.Close()
 
-		stream, err := svc.StreamPodLogs(c.Request.Context(), clusterID, namespace, pod, "")
+		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
-			_ = conn.WriteJSON(gin.H{"type": "error", "message": err.Error()})
 			return
 		}
-		defer stream.Close()
+		defer conn.Close()
 
-		scanner := bufio.NewScanner(stream)
-		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-		for scanner.Scan() {
-			if err := conn.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
-				return
+		// Reads only serve to notice the client going away
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
 			}
+		}()
+
+		out := &wsLineWriter{conn: conn}
+		if err := svc.StreamPodLogs(ctx, clusterID, namespace, pod, c.Query("container"), opts, out); err != nil {
+			_ = conn.WriteJSON(gin.H{"type": "error", "message": err.Error()})
+			return
 		}
+		out.flush()
 	}
 }
 
 // Helper function to handle errors
 func handleError(c *gin.Context, err error) {
-	appErr := errors.ToAppError(err)
-	c.JSON(appErr.HTTPStatus, appErr.ToResponse(getRequestID(c)))
+	if retry := errors.RetryAfter(err); retry != "" {
+		c.Header("Retry-After", retry)
+	}
+	c.JSON(errors.HTTPResponse(err, getRequestID(c)))
 }
 
 func getRequestID(c *gin.Context) string {
@@ -3,18 +3,26 @@
 package handlers
 
 import (
+	goerrors "errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/anubhavg-icpl/krustron/internal/cost"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
 	"github.com/gin-gonic/gin"
 )
 
-// GetCostSummary returns the platform cost summary
+// GetCostSummary returns the platform cost summary. ?force=true bypasses
+// the cache.
 func GetCostSummary(svc *cost.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		summary, err := svc.GetCostSummary(c.Request.Context())
+		force, _ := strconv.ParseBool(c.Query("force"))
+		summary, err := svc.GetCostSummary(c.Request.Context(), c.Query("currency"), force)
+		if goerrors.Is(err, cost.ErrMissingRate) {
+			handleError(c, errors.BadRequest(err.Error()))
+			return
+		}
 		if err != nil {
 			handleError(c, err)
 			return
@@ -74,10 +82,13 @@ func CreateBudget(svc *cost.Service) gin.HandlerFunc {
 	}
 }
 
-// GenerateCostReport generates a cost report
+// GenerateCostReport generates a cost report for the month to the end of
+// the current hour, so repeated requests within the hour share a cached
+// report. ?force=true bypasses the cache.
 func GenerateCostReport(svc *cost.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		now := time.Now()
+		now := time.Now().Truncate(time.Hour).Add(time.Hour)
+		force, _ := strconv.ParseBool(c.Query("force"))
 		req := cost.ReportRequest{
 			Name:      c.Query("name"),
 			Type:      c.DefaultQuery("type", "monthly"),
@@ -85,8 +96,14 @@ func GenerateCostReport(svc *cost.Service) gin.HandlerFunc {
 			Namespace: c.Query("namespace"),
 			StartTime: now.AddDate(0, -1, 0),
 			EndTime:   now,
+			Currency:  c.Query("currency"),
+			Force:     force,
 		}
 		report, err := svc.GenerateReport(c.Request.Context(), req)
+		if goerrors.Is(err, cost.ErrMissingRate) {
+			handleError(c, errors.BadRequest(err.Error()))
+			return
+		}
 		if err != nil {
 			handleError(c, err)
 			return
@@ -94,3 +111,23 @@ func GenerateCostReport(svc *cost.Service) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"data": report})
 	}
 }
+
+// ApplyRightsizing applies a rightsizing recommendation to its workload.
+// With ?dry_run=true it only returns the resource diff.
+func ApplyRightsizing(svc *cost.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := c.Get("user_id")
+		dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+		change, err := svc.ApplyRightsizing(c.Request.Context(), c.Param("id"), userID.(string), dryRun)
+		if goerrors.Is(err, cost.ErrStaleRecommendation) {
+			handleError(c, errors.Conflict(err.Error()))
+			return
+		}
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": change})
+	}
+}
@@ -137,6 +137,26 @@ func SyncApplication(svc *gitops.Service) gin.HandlerFunc {
 	}
 }
 
+// ValidateRepositoryAccess checks that a repository can be reached with
+// its credentials before an application uses it
+func ValidateRepositoryAccess(svc *gitops.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req gitops.RepoAccessRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		access, err := svc.ValidateRepoAccess(c.Request.Context(), &req.Repository, req.Ref)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": access})
+	}
+}
+
 // GetApplicationStatus returns the status of an application
 func GetApplicationStatus(svc *gitops.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -69,6 +69,40 @@ func SyncHelmRepo(svc *helm.Service) gin.HandlerFunc {
 	}
 }
 
+// LoginHelmRegistry logs in to an OCI registry with credentials from the
+// secret store
+func LoginHelmRegistry(svc *helm.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req helm.RegistryLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		registry, err := svc.LoginRegistry(c.Request.Context(), &req)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": registry})
+	}
+}
+
+// LogoutHelmRegistry logs out of an OCI registry
+func LogoutHelmRegistry(svc *helm.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host := c.Param("host")
+
+		if err := svc.LogoutRegistry(c.Request.Context(), host); err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out of registry"})
+	}
+}
+
 // SearchCharts searches for Helm charts
 func SearchCharts(svc *helm.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -200,6 +234,40 @@ func UpgradeRelease(svc *helm.Service) gin.HandlerFunc {
 	}
 }
 
+// PreviewUpgrade shows what upgrading a release to a chart would change,
+// without applying it. The chart is sent base64-encoded, packaged as
+// `helm package` writes it.
+func PreviewUpgrade(svc *helm.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			helm.UpgradeRequest
+			Chart []byte `json:"chart" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+
+		chart, err := helm.LoadChartArchive(req.Chart)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		req.ClusterID = c.Param("cluster")
+		req.Namespace = c.Param("namespace")
+		req.Name = c.Param("name")
+
+		preview, err := svc.PreviewUpgrade(c.Request.Context(), &req.UpgradeRequest, chart)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": preview})
+	}
+}
+
 // UninstallRelease uninstalls a Helm release
 func UninstallRelease(svc *helm.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -228,13 +296,17 @@ func RollbackRelease(svc *helm.Service) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
 			return
 		}
+		req.ClusterID = cluster
+		req.Namespace = namespace
+		req.Name = name
 
-		if err := svc.Rollback(c.Request.Context(), cluster, namespace, name, req.Revision); err != nil {
+		result, err := svc.Rollback(c.Request.Context(), &req)
+		if err != nil {
 			handleError(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "release rolled back successfully"})
+		c.JSON(http.StatusOK, gin.H{"data": result})
 	}
 }
 
@@ -245,7 +317,7 @@ func GetReleaseHistory(svc *helm.Service) gin.HandlerFunc {
 		namespace := c.Param("namespace")
 		name := c.Param("name")
 
-		history, err := svc.GetHistory(c.Request.Context(), cluster, namespace, name)
+		history, err := svc.ListHistory(c.Request.Context(), cluster, namespace, name)
 		if err != nil {
 			handleError(c, err)
 			return
@@ -0,0 +1,132 @@
+// Package handlers - Notification channel and route handlers
+// Author: Anubhav Gain <anubhavg@infopercept.com>
+package handlers
+
+import (
+	goerrors "errors"
+	"net/http"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/gin-gonic/gin"
+)
+
+// GetNotificationSettings returns the notification channels, with their
+// credentials masked, and routes
+func GetNotificationSettings(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channels, err := svc.ListChannels(c.Request.Context())
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+		routes, err := svc.ListRoutes(c.Request.Context())
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"channels": channels, "routes": routes}})
+	}
+}
+
+// CreateNotificationChannel adds a notification channel
+func CreateNotificationChannel(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var channel notify.Channel
+		if err := c.ShouldBindJSON(&channel); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+		if err := svc.CreateChannel(c.Request.Context(), &channel); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": channel})
+	}
+}
+
+// UpdateNotificationChannel replaces a notification channel. Credentials
+// sent back masked are kept.
+func UpdateNotificationChannel(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var channel notify.Channel
+		if err := c.ShouldBindJSON(&channel); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+		if err := svc.UpdateChannel(c.Request.Context(), c.Param("id"), &channel); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": channel})
+	}
+}
+
+// DeleteNotificationChannel removes a notification channel
+func DeleteNotificationChannel(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.DeleteChannel(c.Request.Context(), c.Param("id")); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "notification channel deleted successfully"})
+	}
+}
+
+// TestNotificationChannel sends a test notification to a channel
+func TestNotificationChannel(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.TestChannel(c.Request.Context(), c.Param("id")); err != nil {
+			var appErr *errors.AppError
+			if !goerrors.As(err, &appErr) {
+				err = errors.Validation("test notification failed: " + err.Error())
+			}
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "test notification sent"})
+	}
+}
+
+// CreateNotificationRoute adds a notification route
+func CreateNotificationRoute(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var route notify.Route
+		if err := c.ShouldBindJSON(&route); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+		if err := svc.CreateRoute(c.Request.Context(), &route); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": route})
+	}
+}
+
+// UpdateNotificationRoute replaces a notification route
+func UpdateNotificationRoute(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var route notify.Route
+		if err := c.ShouldBindJSON(&route); err != nil {
+			c.JSON(http.StatusBadRequest, errors.BadRequest(err.Error()).ToResponse(getRequestID(c)))
+			return
+		}
+		if err := svc.UpdateRoute(c.Request.Context(), c.Param("id"), &route); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": route})
+	}
+}
+
+// DeleteNotificationRoute removes a notification route
+func DeleteNotificationRoute(svc *notify.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.DeleteRoute(c.Request.Context(), c.Param("id")); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "notification route deleted successfully"})
+	}
+}
@@ -3,6 +3,8 @@
 package handlers
 
 import (
+	goerrors "errors"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -12,7 +14,8 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-// ListPipelines returns all pipelines
+// ListPipelines returns all pipelines. A cursor query parameter, empty for
+// the first page, pages by keyset instead of page number.
 func ListPipelines(svc *pipeline.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -25,6 +28,16 @@ func ListPipelines(svc *pipeline.Service) gin.HandlerFunc {
 			ApplicationID: appID,
 		}
 
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			pipelines, next, err := svc.ListAfter(c.Request.Context(), filters, cursor)
+			if err != nil {
+				handleError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": pipelines, "next_cursor": next, "limit": limit})
+			return
+		}
+
 		pipelines, total, err := svc.List(c.Request.Context(), filters)
 		if err != nil {
 			handleError(c, err)
@@ -73,7 +86,12 @@ func CreatePipeline(svc *pipeline.Service) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"data": p})
+		resp := gin.H{"data": p}
+		if p.WebhookSecret != "" {
+			// Only returned here, for configuring the repository's webhook
+			resp["webhook_secret"] = p.WebhookSecret
+		}
+		c.JSON(http.StatusCreated, resp)
 	}
 }
 
@@ -136,7 +154,8 @@ func TriggerPipeline(svc *pipeline.Service) gin.HandlerFunc {
 	}
 }
 
-// ListPipelineRuns returns runs for a pipeline
+// ListPipelineRuns returns runs for a pipeline. A cursor query parameter,
+// empty for the first page, pages by keyset instead of page number.
 func ListPipelineRuns(svc *pipeline.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
@@ -144,6 +163,16 @@ func ListPipelineRuns(svc *pipeline.Service) gin.HandlerFunc {
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 		status := c.Query("status")
 
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			runs, next, err := svc.ListRunsAfter(c.Request.Context(), id, cursor, limit, status)
+			if err != nil {
+				handleError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": runs, "next_cursor": next, "limit": limit})
+			return
+		}
+
 		runs, total, err := svc.ListRuns(c.Request.Context(), id, page, limit, status)
 		if err != nil {
 			handleError(c, err)
@@ -208,6 +237,51 @@ func RetryPipelineRun(svc *pipeline.Service) gin.HandlerFunc {
 	}
 }
 
+// ApprovePipelineRun approves the stage a run is waiting on
+func ApprovePipelineRun(svc *pipeline.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		runID := c.Param("runId")
+		userID, _ := c.Get("user_id")
+
+		var req struct {
+			Stage string `json:"stage"`
+		}
+		// The stage is optional, so an empty body is fine
+		_ = c.ShouldBindJSON(&req)
+
+		if err := svc.ApproveRun(c.Request.Context(), id, runID, req.Stage, userID.(string)); err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "approval recorded"})
+	}
+}
+
+// RejectPipelineRun rejects the stage a run is waiting on
+func RejectPipelineRun(svc *pipeline.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		runID := c.Param("runId")
+		userID, _ := c.Get("user_id")
+
+		var req struct {
+			Stage  string `json:"stage"`
+			Reason string `json:"reason"`
+		}
+		// The stage and reason are optional, so an empty body is fine
+		_ = c.ShouldBindJSON(&req)
+
+		if err := svc.RejectRun(c.Request.Context(), id, runID, req.Stage, userID.(string), req.Reason); err != nil {
+			handleError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "rejection recorded"})
+	}
+}
+
 // GetPipelineRunLogs returns logs for a pipeline run
 func GetPipelineRunLogs(svc *pipeline.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -220,8 +294,22 @@ func GetPipelineRunLogs(svc *pipeline.Service) gin.HandlerFunc {
 			handleError(c, err)
 			return
 		}
+		defer logs.Close()
+
+		// Clients asking for plain text get the logs streamed as stored
+		if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEPlain) == gin.MIMEPlain {
+			c.Header("Content-Type", "text/plain; charset=utf-8")
+			c.Status(http.StatusOK)
+			_, _ = io.Copy(c.Writer, logs)
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"data": logs})
+		data, err := io.ReadAll(logs)
+		if err != nil {
+			handleError(c, errors.PipelineWrap(err, "failed to read pipeline logs"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": string(data)})
 	}
 }
 
@@ -254,19 +342,45 @@ func PipelineLogsWS(svc *pipeline.Service) gin.HandlerFunc {
 			_ = conn.WriteJSON(gin.H{"type": "error", "message": err.Error()})
 			return
 		}
-		_ = conn.WriteJSON(gin.H{"type": "pipeline.log", "run_id": runs[0].ID, "data": logs})
+		defer logs.Close()
+		data, err := io.ReadAll(logs)
+		if err != nil {
+			_ = conn.WriteJSON(gin.H{"type": "error", "message": err.Error()})
+			return
+		}
+		_ = conn.WriteJSON(gin.H{"type": "pipeline.log", "run_id": runs[0].ID, "data": string(data)})
 	}
 }
 
+// maxWebhookBodyBytes caps webhook payloads at GitHub's own limit
+const maxWebhookBodyBytes = 25 << 20
+
+// readWebhookBody reads a webhook payload of at most maxWebhookBodyBytes,
+// answering 413 for a larger one
+func readWebhookBody(c *gin.Context) ([]byte, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBodyBytes)
+	body, err := c.GetRawData()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if goerrors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge,
+				errors.New(errors.CodeBadRequest, "webhook payload too large", http.StatusRequestEntityTooLarge).ToResponse(getRequestID(c)))
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, errors.BadRequest("failed to read body").ToResponse(getRequestID(c)))
+		return nil, false
+	}
+	return body, true
+}
+
 // GitHubWebhook handles GitHub webhook events
 func GitHubWebhook(svc *pipeline.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		event := c.GetHeader("X-GitHub-Event")
 		signature := c.GetHeader("X-Hub-Signature-256")
 
-		body, err := c.GetRawData()
-		if err != nil {
-			c.JSON(http.StatusBadRequest, errors.BadRequest("failed to read body").ToResponse(getRequestID(c)))
+		body, ok := readWebhookBody(c)
+		if !ok {
 			return
 		}
 
@@ -285,9 +399,8 @@ func GitLabWebhook(svc *pipeline.Service) gin.HandlerFunc {
 		event := c.GetHeader("X-Gitlab-Event")
 		token := c.GetHeader("X-Gitlab-Token")
 
-		body, err := c.GetRawData()
-		if err != nil {
-			c.JSON(http.StatusBadRequest, errors.BadRequest("failed to read body").ToResponse(getRequestID(c)))
+		body, ok := readWebhookBody(c)
+		if !ok {
 			return
 		}
 
@@ -304,14 +417,14 @@ func GitLabWebhook(svc *pipeline.Service) gin.HandlerFunc {
 func BitbucketWebhook(svc *pipeline.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		event := c.GetHeader("X-Event-Key")
+		signature := c.GetHeader("X-Hub-Signature")
 
-		body, err := c.GetRawData()
-		if err != nil {
-			c.JSON(http.StatusBadRequest, errors.BadRequest("failed to read body").ToResponse(getRequestID(c)))
+		body, ok := readWebhookBody(c)
+		if !ok {
 			return
 		}
 
-		if err := svc.HandleBitbucketWebhook(c.Request.Context(), event, body); err != nil {
+		if err := svc.HandleBitbucketWebhook(c.Request.Context(), event, signature, body); err != nil {
 			handleError(c, err)
 			return
 		}
@@ -0,0 +1,238 @@
+// Package handlers - SCIM 2.0 provisioning handlers
+// Author: Anubhav Gain <anubhavg@infopercept.com>
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/anubhavg-icpl/krustron/internal/scim"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/gin-gonic/gin"
+)
+
+// scimJSON answers with a SCIM resource. SCIM bodies are not wrapped in
+// "data" like the rest of the API.
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, body)
+}
+
+// scimError answers with a SCIM error body
+func scimError(c *gin.Context, err error) {
+	status, body := scim.ErrorResponse(err)
+	scimJSON(c, status, body)
+}
+
+// scimBadBody answers a request whose body doesn't decode
+func scimBadBody(c *gin.Context, err error) {
+	scimError(c, errors.BadRequest(err.Error()).WithMeta("scim_type", scim.ErrInvalidSyntax))
+}
+
+// scimListQuery reads the filter and pagination of a list request
+func scimListQuery(c *gin.Context) (scim.ListQuery, error) {
+	query := scim.ListQuery{Filter: c.Query("filter"), StartIndex: 1, Count: scim.DefaultCount}
+	for name, value := range map[string]*int{"startIndex": &query.StartIndex, "count": &query.Count} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return query, errors.BadRequest(name+" must be an integer").WithMeta("scim_type", scim.ErrInvalidValue)
+		}
+		*value = n
+	}
+	return query, nil
+}
+
+// SCIMServiceProviderConfig describes the SCIM features supported
+func SCIMServiceProviderConfig(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scimJSON(c, http.StatusOK, svc.ServiceProviderConfig())
+	}
+}
+
+// SCIMListUsers lists users matching the SCIM filter
+func SCIMListUsers(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, err := scimListQuery(c)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		users, err := svc.ListUsers(c.Request.Context(), query)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, users)
+	}
+}
+
+// SCIMGetUser returns a user
+func SCIMGetUser(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := svc.GetUser(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, user)
+	}
+}
+
+// SCIMCreateUser provisions a user
+func SCIMCreateUser(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req scim.User
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimBadBody(c, err)
+			return
+		}
+		user, err := svc.CreateUser(c.Request.Context(), &req)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		c.Header("Location", user.Meta.Location)
+		scimJSON(c, http.StatusCreated, user)
+	}
+}
+
+// SCIMReplaceUser replaces a user
+func SCIMReplaceUser(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req scim.User
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimBadBody(c, err)
+			return
+		}
+		user, err := svc.ReplaceUser(c.Request.Context(), c.Param("id"), &req)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, user)
+	}
+}
+
+// SCIMPatchUser patches a user, e.g. to deactivate them
+func SCIMPatchUser(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req scim.PatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimBadBody(c, err)
+			return
+		}
+		user, err := svc.PatchUser(c.Request.Context(), c.Param("id"), &req)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, user)
+	}
+}
+
+// SCIMDeleteUser deletes a user
+func SCIMDeleteUser(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.DeleteUser(c.Request.Context(), c.Param("id")); err != nil {
+			scimError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// SCIMListGroups lists groups matching the SCIM filter
+func SCIMListGroups(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query, err := scimListQuery(c)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		groups, err := svc.ListGroups(c.Request.Context(), query)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, groups)
+	}
+}
+
+// SCIMGetGroup returns a group
+func SCIMGetGroup(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		group, err := svc.GetGroup(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, group)
+	}
+}
+
+// SCIMCreateGroup provisions a group
+func SCIMCreateGroup(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req scim.Group
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimBadBody(c, err)
+			return
+		}
+		group, err := svc.CreateGroup(c.Request.Context(), &req)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		c.Header("Location", group.Meta.Location)
+		scimJSON(c, http.StatusCreated, group)
+	}
+}
+
+// SCIMReplaceGroup replaces a group
+func SCIMReplaceGroup(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req scim.Group
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimBadBody(c, err)
+			return
+		}
+		group, err := svc.ReplaceGroup(c.Request.Context(), c.Param("id"), &req)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, group)
+	}
+}
+
+// SCIMPatchGroup patches a group, e.g. to add or remove members
+func SCIMPatchGroup(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req scim.PatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			scimBadBody(c, err)
+			return
+		}
+		group, err := svc.PatchGroup(c.Request.Context(), c.Param("id"), &req)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		scimJSON(c, http.StatusOK, group)
+	}
+}
+
+// SCIMDeleteGroup deletes a group
+func SCIMDeleteGroup(svc *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := svc.DeleteGroup(c.Request.Context(), c.Param("id")); err != nil {
+			scimError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -3,6 +3,13 @@
 package handlers
 
 import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
 	"github.com/anubhavg-icpl/krustron/pkg/websocket"
 	"github.com/gin-gonic/gin"
 )
@@ -18,3 +25,73 @@ func DashboardWS(hub *websocket.Hub) gin.HandlerFunc {
 		hub.HandleWebSocket(c.Writer, c.Request, uid)
 	}
 }
+
+// eventResources maps the category of a krustron.<category>.<id>.<type>
+// subject to the RBAC resource whose read permission covers its events,
+// and to the API key scope that does
+var eventResources = map[string]struct{ resource, permission string }{
+	"cluster":     {rbac.ResourceCluster, "clusters:read"},
+	"application": {rbac.ResourceApplication, "applications:read"},
+	"deployment":  {rbac.ResourceApplication, "applications:read"},
+	"pipeline":    {rbac.ResourcePipeline, "pipelines:read"},
+	"alert":       {rbac.ResourceCluster, "clusters:read"},
+	"security":    {"security", "security:read"},
+	"audit":       {"audit", "audit:read"},
+}
+
+// EventsWS upgrades a WebSocket that forwards the krustron.* NATS events a
+// client subscribes to. Subjects are scoped to what the caller may read:
+// admins see everything, other roles the categories their role may read,
+// and users granted access to a single cluster that cluster's events. API
+// keys see the categories their scopes may read.
+func EventsWS(gateway *websocket.Gateway, rbacSvc *rbac.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if gateway == nil {
+			c.JSON(http.StatusServiceUnavailable, errors.ServiceUnavailable("event streaming is unavailable").ToResponse(getRequestID(c)))
+			return
+		}
+		value, _ := c.Get("claims")
+		claims, ok := value.(*auth.Claims)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, errors.Unauthorized("authentication required").ToResponse(getRequestID(c)))
+			return
+		}
+		gateway.Serve(c.Writer, c.Request, claims.UserID, subjectAuthorizer(rbacSvc, claims))
+	}
+}
+
+// subjectAuthorizer scopes event subjects to the RBAC permissions of a
+// user, or to the scopes of an API key. Without the RBAC service only
+// admins are allowed.
+func subjectAuthorizer(rbacSvc *rbac.Service, claims *auth.Claims) websocket.SubjectAuthorizer {
+	apiKey := claims.TokenType == auth.TokenTypeAPIKey
+	return func(ctx context.Context, subject string) bool {
+		if claims.Role == "admin" && !apiKey {
+			return true
+		}
+		tokens := strings.Split(subject, ".")
+		if len(tokens) < 3 {
+			return false
+		}
+		category, ok := eventResources[tokens[1]]
+		if !ok {
+			return false
+		}
+		// An API key is limited to its scopes, as on the REST API
+		if apiKey {
+			return claims.HasPermission(category.permission)
+		}
+		if rbacSvc == nil {
+			return false
+		}
+		if allowed, err := rbacSvc.AuthorizeRole(ctx, claims.Role, category.resource, rbac.ActionRead); err == nil && allowed {
+			return true
+		}
+		// Cluster grants only cover subjects naming the cluster
+		if tokens[1] != "cluster" || tokens[2] == "*" || tokens[2] == ">" {
+			return false
+		}
+		allowed, err := rbacSvc.Authorize(ctx, claims.UserID, rbac.ClusterDomain(tokens[2]), category.resource, rbac.ActionRead)
+		return err == nil && allowed
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/internal/audit"
 	"github.com/anubhavg-icpl/krustron/internal/auth"
 	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/internal/scim"
 	"github.com/anubhavg-icpl/krustron/pkg/errors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -17,7 +19,8 @@ import (
 	"golang.org/x/time/rate"
 )
 
-// RequestID adds a unique request ID to each request
+// RequestID adds a unique request ID to each request, and puts it with the
+// client's address and user agent in the request context for audit logs
 func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		requestID := c.GetHeader("X-Request-ID")
@@ -26,11 +29,16 @@ func RequestID() gin.HandlerFunc {
 		}
 		c.Set("request_id", requestID)
 		c.Header("X-Request-ID", requestID)
+		c.Request = c.Request.WithContext(audit.WithRequest(c.Request.Context(), audit.RequestInfo{
+			RequestID: requestID,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}))
 		c.Next()
 	}
 }
 
-// JWTAuth validates JWT tokens
+// JWTAuth validates JWT tokens and API keys
 func JWTAuth(authService *auth.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -55,7 +63,14 @@ func JWTAuth(authService *auth.Service) gin.HandlerFunc {
 			return
 		}
 
-		claims, err := authService.ValidateToken(token)
+		var claims *auth.Claims
+		var err error
+		if strings.HasPrefix(token, auth.APIKeyPrefix) {
+			// API keys may be limited to the addresses they are used from
+			claims, err = authService.ValidateAPIKey(c.Request.Context(), token, c.ClientIP())
+		} else {
+			claims, err = authService.ValidateToken(token)
+		}
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, errors.Unauthorized("invalid or expired token").ToResponse(getRequestID(c)))
 			return
@@ -66,6 +81,32 @@ func JWTAuth(authService *auth.Service) gin.HandlerFunc {
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
 		c.Set("claims", claims)
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), claims.UserID, claims.Email))
+
+		c.Next()
+	}
+}
+
+// SCIMAuth authenticates identity providers by the SCIM bearer token.
+// User JWTs are not accepted, and errors are SCIM error bodies.
+func SCIMAuth(scimService *scim.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var token string
+		parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+		if len(parts) == 2 && strings.ToLower(parts[0]) == "bearer" {
+			token = parts[1]
+		}
+
+		if !scimService.Authenticate(token) {
+			status, body := scim.ErrorResponse(errors.Unauthorized("invalid or missing SCIM token"))
+			c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+			c.Header("Content-Type", scim.ContentType)
+			c.AbortWithStatusJSON(status, body)
+			return
+		}
+
+		// Changes made over SCIM are audited as the identity provider's
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), "", "scim"))
 
 		c.Next()
 	}
@@ -94,6 +135,7 @@ func WSAuth(authService *auth.Service) gin.HandlerFunc {
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
 		c.Set("claims", claims)
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), claims.UserID, claims.Email))
 
 		c.Next()
 	}
@@ -169,32 +211,7 @@ func RequirePermission(permission string) gin.HandlerFunc {
 			return
 		}
 
-		userClaims := claims.(*auth.Claims)
-
-		// Admin has all permissions
-		if userClaims.Role == "admin" {
-			c.Next()
-			return
-		}
-
-		// Check if user has the required permission
-		hasPermission := false
-		for _, p := range userClaims.Permissions {
-			if p == permission || p == "*" {
-				hasPermission = true
-				break
-			}
-			// Check wildcard permissions
-			if strings.HasSuffix(p, ":*") {
-				prefix := strings.TrimSuffix(p, "*")
-				if strings.HasPrefix(permission, prefix) {
-					hasPermission = true
-					break
-				}
-			}
-		}
-
-		if !hasPermission {
+		if !claims.(*auth.Claims).HasPermission(permission) {
 			c.AbortWithStatusJSON(http.StatusForbidden, errors.Forbidden("permission denied: "+permission).ToResponse(getRequestID(c)))
 			return
 		}
@@ -205,28 +222,63 @@ func RequirePermission(permission string) gin.HandlerFunc {
 
 // RateLimiter provides rate limiting per IP
 func RateLimiter(requestsPerSecond float64, burst int) gin.HandlerFunc {
-	var mu sync.Mutex
-	limiters := make(map[string]*rate.Limiter)
+	return NewRateLimit(requestsPerSecond, burst).Handler()
+}
+
+// RateLimit limits requests per client IP. Its limit can be changed while
+// serving, for configuration reloads.
+type RateLimit struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+}
+
+// NewRateLimit creates a per-IP limit of requestsPerSecond with the given
+// burst
+func NewRateLimit(requestsPerSecond float64, burst int) *RateLimit {
+	l := &RateLimit{
+		limit:    rate.Limit(requestsPerSecond),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
 
 	cleanup := time.NewTicker(10 * time.Minute)
 	go func() {
 		for range cleanup.C {
-			mu.Lock()
-			limiters = make(map[string]*rate.Limiter)
-			mu.Unlock()
+			l.mu.Lock()
+			l.limiters = make(map[string]*rate.Limiter)
+			l.mu.Unlock()
 		}
 	}()
+	return l
+}
+
+// SetLimit changes the limit for every client, including those already
+// seen
+func (l *RateLimit) SetLimit(requestsPerSecond float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.burst = rate.Limit(requestsPerSecond), burst
+	now := time.Now()
+	for _, limiter := range l.limiters {
+		limiter.SetLimitAt(now, l.limit)
+		limiter.SetBurstAt(now, burst)
+	}
+}
 
+// Handler returns the middleware enforcing the limit
+func (l *RateLimit) Handler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ip := c.ClientIP()
 
-		mu.Lock()
-		limiter, exists := limiters[ip]
+		l.mu.Lock()
+		limiter, exists := l.limiters[ip]
 		if !exists {
-			limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), burst)
-			limiters[ip] = limiter
+			limiter = rate.NewLimiter(l.limit, l.burst)
+			l.limiters[ip] = limiter
 		}
-		mu.Unlock()
+		l.mu.Unlock()
 
 		if !limiter.Allow() {
 			c.AbortWithStatusJSON(http.StatusTooManyRequests, errors.RateLimited("too many requests").ToResponse(getRequestID(c)))
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testRoutePermissions = RoutePermissions{
+	"GET /clusters/:id": {
+		Permission: "clusters:read", Resource: rbac.ResourceCluster, Action: rbac.ActionRead, ClusterParam: "id",
+	},
+	"POST /clusters/:id/namespaces/:namespace/restart": {
+		Permission: "clusters:write", Resource: rbac.ResourceCluster, Action: rbac.ActionUpdate,
+		ClusterParam: "id", NamespaceParam: "namespace",
+	},
+	"DELETE /clusters/:id": {
+		Permission: "clusters:delete", Resource: rbac.ResourceCluster, Action: rbac.ActionDelete, ClusterParam: "id",
+	},
+}
+
+// newAuthorizeRouter serves testRoutePermissions' routes, plus an unlisted
+// one, as if JWTAuth had authenticated claims. Denials are recorded in the
+// audit_logs table of the returned database.
+func newAuthorizeRouter(t *testing.T, rbacSvc *rbac.Service, claims *auth.Claims) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	recorder := audittest.NewRecorder(t, sqlDB)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("claims", claims)
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), claims.UserID, claims.Email))
+	})
+	r.Use(Authorize(rbacSvc, recorder, testRoutePermissions))
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	r.GET("/clusters/:id", ok)
+	r.POST("/clusters/:id/namespaces/:namespace/restart", ok)
+	r.DELETE("/clusters/:id", ok)
+	r.GET("/version", ok)
+	return r, db
+}
+
+func newAuthorizeRBAC(t *testing.T) *rbac.Service {
+	t.Helper()
+	// The Casbin adapter saves policies on a second connection, so the
+	// in-memory database has to be shared
+	db, err := gorm.Open(sqlite.Open("file:"+uuid.NewString()+"?mode=memory&cache=shared"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	svc, err := rbac.NewService(db, zap.NewNop(), &rbac.Config{})
+	require.NoError(t, err)
+	t.Cleanup(svc.Stop)
+	return svc
+}
+
+func serveAuthorized(r *gin.Engine, method, path string) int {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec.Code
+}
+
+func TestAuthorizeChecksTokenPermissions(t *testing.T) {
+	claims := &auth.Claims{UserID: "u1", Email: "dev@example.com", Role: "developer", Permissions: []string{"clusters:read"}}
+	r, db := newAuthorizeRouter(t, nil, claims)
+
+	assert.Equal(t, http.StatusNoContent, serveAuthorized(r, "GET", "/clusters/prod"))
+	assert.Equal(t, http.StatusNoContent, serveAuthorized(r, "GET", "/version"), "unlisted routes pass")
+	assert.Equal(t, http.StatusForbidden, serveAuthorized(r, "POST", "/clusters/prod/namespaces/payments/restart"))
+
+	var entry struct {
+		UserID, UserEmail, Action, ResourceType, Status, ErrorMessage string
+		Metadata                                                      []byte
+	}
+	require.NoError(t, db.Raw(`SELECT user_id, user_email, action, resource_type, status, error_message, metadata
+		FROM audit_logs`).Row().Scan(&entry.UserID, &entry.UserEmail, &entry.Action, &entry.ResourceType,
+		&entry.Status, &entry.ErrorMessage, &entry.Metadata))
+	assert.Equal(t, "u1", entry.UserID)
+	assert.Equal(t, "dev@example.com", entry.UserEmail)
+	assert.Equal(t, "access_denied", entry.Action)
+	assert.Equal(t, rbac.ResourceCluster, entry.ResourceType)
+	assert.Equal(t, audit.StatusDenied, entry.Status)
+	assert.Equal(t, "permission denied: clusters:write", entry.ErrorMessage)
+	var metadata map[string]interface{}
+	require.NoError(t, json.Unmarshal(entry.Metadata, &metadata))
+	assert.Equal(t, map[string]interface{}{
+		"route": "POST /clusters/:id/namespaces/:namespace/restart", "permission": "clusters:write",
+		"action": rbac.ActionUpdate, "cluster_id": "prod", "namespace": "payments",
+	}, metadata)
+}
+
+func TestAuthorizeDeniesAPIKeysOnUnlistedRoutes(t *testing.T) {
+	key := &auth.Claims{UserID: "u1", TokenType: auth.TokenTypeAPIKey, Permissions: []string{"clusters:read"}}
+	r, _ := newAuthorizeRouter(t, nil, key)
+	assert.Equal(t, http.StatusNoContent, serveAuthorized(r, "GET", "/clusters/prod"))
+	assert.Equal(t, http.StatusForbidden, serveAuthorized(r, "GET", "/version"))
+
+	key.Permissions = []string{"*"}
+	assert.Equal(t, http.StatusNoContent, serveAuthorized(r, "GET", "/version"))
+}
+
+func TestAuthorizeWildcards(t *testing.T) {
+	admin := &auth.Claims{UserID: "u1", Role: "admin", Permissions: []string{"*"}}
+	r, _ := newAuthorizeRouter(t, nil, admin)
+	assert.Equal(t, http.StatusNoContent, serveAuthorized(r, "DELETE", "/clusters/prod"))
+
+	viewer := &auth.Claims{UserID: "u2", Role: "viewer", Permissions: []string{"*:read"}}
+	r, _ = newAuthorizeRouter(t, nil, viewer)
+	assert.Equal(t, http.StatusNoContent, serveAuthorized(r, "GET", "/clusters/prod"))
+	assert.Equal(t, http.StatusForbidden, serveAuthorized(r, "DELETE", "/clusters/prod"))
+}
+
+func TestAuthorizeFallsBackToScopedRBAC(t *testing.T) {
+	ctx := context.Background()
+	svc := newAuthorizeRBAC(t)
+
+	role := &rbac.Role{Name: "cluster-operator", Type: "custom", Permissions: []rbac.Permission{
+		{Resource: rbac.ResourceCluster, Action: rbac.ActionUpdate, Scope: rbac.ResourceCluster, Effect: "allow", Priority: 500},
+	}}
+	require.NoError(t, svc.CreateRole(ctx, role))
+	team := &rbac.Team{Name: "payments-oncall"}
+	require.NoError(t, svc.CreateTeam(ctx, team))
+	require.NoError(t, svc.AddTeamMember(ctx, team.ID, "u1", "member", "admin"))
+	require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, role.ID, rbac.ClusterDomain("prod"), "prod", "admin"))
+
+	claims := &auth.Claims{UserID: "u1", Role: "developer"}
+	r, _ := newAuthorizeRouter(t, svc, claims)
+	assert.Equal(t, http.StatusNoContent, serveAuthorized(r, "POST", "/clusters/prod/namespaces/payments/restart"))
+	assert.Equal(t, http.StatusForbidden, serveAuthorized(r, "DELETE", "/clusters/prod"))
+
+	// API keys are limited to their scopes
+	claims.TokenType = auth.TokenTypeAPIKey
+	assert.Equal(t, http.StatusForbidden, serveAuthorized(r, "POST", "/clusters/prod/namespaces/payments/restart"))
+
+	outsider := &auth.Claims{UserID: "u2", Role: "developer"}
+	r, _ = newAuthorizeRouter(t, svc, outsider)
+	assert.Equal(t, http.StatusForbidden, serveAuthorized(r, "POST", "/clusters/prod/namespaces/payments/restart"))
+}
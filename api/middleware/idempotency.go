@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader carries the client's idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader marks a response replayed for a repeated key
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+// Idempotency makes POST requests sent with an Idempotency-Key header
+// safe to retry: the first request with a key runs and its response is
+// stored, later ones with the same key get that response replayed, and
+// one arriving while the first still runs waits for it. Keys are scoped
+// to the caller, and reusing one for a different request is refused.
+// Server errors aren't stored, so the request may be retried. A nil store
+// disables it.
+func Idempotency(store *cache.Idempotency) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if store == nil || key == "" || c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errors.BadRequest("Idempotency-Key is too long").ToResponse(getRequestID(c)))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errors.BadRequest("failed to read request body").ToResponse(getRequestID(c)))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		storeKey := cache.BuildKey(cache.PrefixIdempotency, "http", c.GetString("user_id"), key)
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.Path, body)
+		stored, err := store.Claim(ctx, storeKey, fingerprint)
+		switch {
+		case err == cache.ErrIdempotencyKeyReused:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity,
+				errors.New(errors.CodeValidation, err.Error(), http.StatusUnprocessableEntity).ToResponse(getRequestID(c)))
+			return
+		case err == cache.ErrIdempotencyInFlight:
+			c.AbortWithStatusJSON(http.StatusConflict, errors.Conflict(err.Error()).ToResponse(getRequestID(c)))
+			return
+		case err != nil:
+			// Without the store the request runs as it would without a key
+			logger.Warn("Idempotency store unavailable", zap.String("path", c.FullPath()), zap.Error(err))
+			c.Next()
+			return
+		case stored != nil:
+			for name, value := range stored.Header {
+				c.Header(name, value)
+			}
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Data(stored.Status, stored.Header["Content-Type"], stored.Body)
+			c.Abort()
+			return
+		}
+
+		// The outcome is stored even if the client has gone
+		storeCtx := context.WithoutCancel(ctx)
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		completed := false
+		defer func() {
+			// A panicking handler leaves the key free for a retry
+			if !completed {
+				store.Release(storeCtx, storeKey)
+			}
+		}()
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		resp := &cache.IdempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      map[string]string{"Content-Type": recorder.Header().Get("Content-Type")},
+			Body:        recorder.body.Bytes(),
+		}
+		if location := recorder.Header().Get("Location"); location != "" {
+			resp.Header["Location"] = location
+		}
+		if err := store.Complete(storeCtx, storeKey, resp); err != nil {
+			logger.Warn("Failed to store idempotent response", zap.String("path", c.FullPath()), zap.Error(err))
+			return
+		}
+		completed = true
+	}
+}
+
+// requestFingerprint identifies a request by method, path and body
+func requestFingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, method+" "+path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bodyRecorder keeps a copy of the response body as it is written
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
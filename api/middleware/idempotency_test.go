@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type pipelineRow struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+}
+
+func newIdempotentRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redisCache, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	t.Cleanup(func() { redisCache.Close() })
+
+	db, err := gorm.Open(sqlite.Open("file:"+uuid.NewString()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&pipelineRow{}))
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", c.GetHeader("X-User")) })
+	r.Use(Idempotency(cache.NewIdempotency(redisCache, time.Hour)))
+	r.POST("/pipelines", func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Slow enough for concurrent duplicates to overlap
+		time.Sleep(50 * time.Millisecond)
+		row := pipelineRow{ID: uuid.NewString(), Name: req.Name}
+		if err := db.Create(&row).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, row)
+	})
+	return r, db
+}
+
+func createPipeline(r *gin.Engine, user, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/pipelines", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", user)
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func countRows(t *testing.T, db *gorm.DB) int64 {
+	var n int64
+	require.NoError(t, db.Model(&pipelineRow{}).Count(&n).Error)
+	return n
+}
+
+func TestIdempotencyReplaysCreate(t *testing.T) {
+	r, db := newIdempotentRouter(t)
+
+	first := createPipeline(r, "alice", "key-1", `{"name":"build"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+
+	again := createPipeline(r, "alice", "key-1", `{"name":"build"}`)
+	assert.Equal(t, http.StatusCreated, again.Code)
+	assert.Equal(t, first.Body.String(), again.Body.String())
+	assert.Equal(t, "true", again.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, "application/json; charset=utf-8", again.Header().Get("Content-Type"))
+	assert.EqualValues(t, 1, countRows(t, db))
+
+	// The key can't be reused for another request
+	reused := createPipeline(r, "alice", "key-1", `{"name":"deploy"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.EqualValues(t, 1, countRows(t, db))
+
+	// Keys are scoped to the caller, and requests without one always run
+	assert.Equal(t, http.StatusCreated, createPipeline(r, "bob", "key-1", `{"name":"build"}`).Code)
+	assert.Equal(t, http.StatusCreated, createPipeline(r, "alice", "", `{"name":"build"}`).Code)
+	assert.Equal(t, http.StatusCreated, createPipeline(r, "alice", "", `{"name":"build"}`).Code)
+	assert.EqualValues(t, 4, countRows(t, db))
+
+	// Rejected requests are replayed as well
+	bad := createPipeline(r, "alice", "key-2", `{`)
+	assert.Equal(t, http.StatusBadRequest, bad.Code)
+	assert.Equal(t, "true", createPipeline(r, "alice", "key-2", `{`).Header().Get(IdempotentReplayedHeader))
+}
+
+func TestIdempotencyConcurrentDuplicates(t *testing.T) {
+	r, db := newIdempotentRouter(t)
+
+	const n = 5
+	responses := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = createPipeline(r, "alice", "key-1", `{"name":"build"}`)
+		}(i)
+	}
+	wg.Wait()
+
+	replayed := 0
+	for _, w := range responses {
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, responses[0].Body.String(), w.Body.String())
+		if w.Header().Get(IdempotentReplayedHeader) == "true" {
+			replayed++
+		}
+	}
+	assert.Equal(t, n-1, replayed)
+	assert.EqualValues(t, 1, countRows(t, db))
+}
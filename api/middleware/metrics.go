@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics counts requests and observes their latency by route pattern, so
+// path parameters don't create a series per cluster or pipeline
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		metrics.HTTPRequests.WithLabelValues(method, route, metrics.StatusClass(c.Writer.Status())).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,142 @@
+package router
+
+import (
+	"github.com/anubhavg-icpl/krustron/api/middleware"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+)
+
+// clusterAccess is checked in the cluster named by the :id param and, on
+// namespaced routes, the namespace
+func clusterAccess(permission, action string) middleware.RoutePermission {
+	return middleware.RoutePermission{
+		Permission: permission, Resource: rbac.ResourceCluster, Action: action,
+		ClusterParam: "id", NamespaceParam: "namespace",
+	}
+}
+
+// helmAccess is checked in the release's cluster and namespace, on routes
+// that name them
+func helmAccess(permission, action string) middleware.RoutePermission {
+	return middleware.RoutePermission{
+		Permission: permission, Resource: rbac.ResourceHelm, Action: action,
+		ClusterParam: "cluster", NamespaceParam: "namespace",
+	}
+}
+
+func applicationAccess(permission, action string) middleware.RoutePermission {
+	return middleware.RoutePermission{Permission: permission, Resource: rbac.ResourceApplication, Action: action}
+}
+
+func pipelineAccess(permission, action string) middleware.RoutePermission {
+	return middleware.RoutePermission{Permission: permission, Resource: rbac.ResourcePipeline, Action: action}
+}
+
+func securityAccess(permission, action string) middleware.RoutePermission {
+	return middleware.RoutePermission{Permission: permission, Resource: "security", Action: action}
+}
+
+// routePermissions are the permissions middleware.Authorize enforces on
+// cluster, Helm, application, pipeline and security routes. Routes
+// elsewhere only need authentication, or a role.
+var routePermissions = middleware.RoutePermissions{
+	// Clusters
+	"GET /api/v1/clusters":                                                          clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/search":                                                   clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/compare":                                                  clusterAccess("clusters:read", rbac.ActionRead),
+	"POST /api/v1/clusters/bulk/agent/install":                                      clusterAccess("clusters:write", rbac.ActionUpdate),
+	"POST /api/v1/clusters/bulk/health":                                             clusterAccess("clusters:read", rbac.ActionRead),
+	"POST /api/v1/clusters/bulk/labels":                                             clusterAccess("clusters:write", rbac.ActionUpdate),
+	"GET /api/v1/clusters/:id":                                                      clusterAccess("clusters:read", rbac.ActionRead),
+	"POST /api/v1/clusters":                                                         clusterAccess("clusters:write", rbac.ActionCreate),
+	"PUT /api/v1/clusters/:id":                                                      clusterAccess("clusters:write", rbac.ActionUpdate),
+	"DELETE /api/v1/clusters/:id":                                                   clusterAccess("clusters:delete", rbac.ActionDelete),
+	"GET /api/v1/clusters/:id/config":                                               clusterAccess("clusters:read", rbac.ActionRead),
+	"PUT /api/v1/clusters/:id/config":                                               clusterAccess("clusters:write", rbac.ActionUpdate),
+	"GET /api/v1/clusters/:id/health":                                               clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/resources":                                            clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/nodes":                                                clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/capacity":                                             clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces":                                           clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/pods":                           clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/pods/:pod/logs":                 clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/services":                       clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/deployments":                    clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/events":                         clusterAccess("clusters:read", rbac.ActionRead),
+	"POST /api/v1/clusters/:id/namespaces/:namespace/workloads/:kind/:name/restart": clusterAccess("clusters:write", rbac.ActionUpdate),
+	"POST /api/v1/clusters/:id/namespaces/:namespace/workloads/:kind/:name/scale":   clusterAccess("clusters:write", rbac.ActionUpdate),
+	"POST /api/v1/clusters/:id/namespaces/:namespace/workloads/:kind/:name/pause":   clusterAccess("clusters:write", rbac.ActionUpdate),
+	"POST /api/v1/clusters/:id/namespaces/:namespace/workloads/:kind/:name/resume":  clusterAccess("clusters:write", rbac.ActionUpdate),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/quotas":                         clusterAccess("clusters:read", rbac.ActionRead),
+	"PUT /api/v1/clusters/:id/namespaces/:namespace/quota":                          clusterAccess("clusters:write", rbac.ActionUpdate),
+	"POST /api/v1/clusters/:id/namespaces/:namespace/quota/check":                   clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/quota/recommendation":           clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/namespaces/:namespace/limitranges":                    clusterAccess("clusters:read", rbac.ActionRead),
+	"PUT /api/v1/clusters/:id/namespaces/:namespace/limitrange":                     clusterAccess("clusters:write", rbac.ActionUpdate),
+	"GET /api/v1/clusters/:id/api-resources":                                        clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/apis/:group/:version/:resource":                       clusterAccess("clusters:read", rbac.ActionRead),
+	"GET /api/v1/clusters/:id/apis/:group/:version/:resource/:name":                 clusterAccess("clusters:read", rbac.ActionRead),
+	"POST /api/v1/clusters/:id/apply":                                               clusterAccess("clusters:write", rbac.ActionUpdate),
+	"GET /api/v1/clusters/:id/applied":                                              clusterAccess("clusters:read", rbac.ActionRead),
+	"DELETE /api/v1/clusters/:id/applied":                                           clusterAccess("clusters:write", rbac.ActionDelete),
+	"POST /api/v1/clusters/:id/agent/install":                                       clusterAccess("clusters:write", rbac.ActionUpdate),
+
+	// Helm
+	"GET /api/v1/helm/repositories":                                 helmAccess("helm:read", rbac.ActionRead),
+	"POST /api/v1/helm/repositories":                                helmAccess("helm:write", rbac.ActionCreate),
+	"DELETE /api/v1/helm/repositories/:name":                        helmAccess("helm:delete", rbac.ActionDelete),
+	"POST /api/v1/helm/repositories/:name/sync":                     helmAccess("helm:write", rbac.ActionUpdate),
+	"POST /api/v1/helm/registries":                                  helmAccess("helm:write", rbac.ActionCreate),
+	"DELETE /api/v1/helm/registries/:host":                          helmAccess("helm:delete", rbac.ActionDelete),
+	"GET /api/v1/helm/charts":                                       helmAccess("helm:read", rbac.ActionRead),
+	"GET /api/v1/helm/charts/:repo/:chart":                          helmAccess("helm:read", rbac.ActionRead),
+	"GET /api/v1/helm/charts/:repo/:chart/versions":                 helmAccess("helm:read", rbac.ActionRead),
+	"GET /api/v1/helm/releases":                                     helmAccess("helm:read", rbac.ActionRead),
+	"GET /api/v1/helm/releases/:cluster/:namespace/:name":           helmAccess("helm:read", rbac.ActionRead),
+	"POST /api/v1/helm/releases":                                    helmAccess("helm:write", rbac.ActionDeploy),
+	"PUT /api/v1/helm/releases/:cluster/:namespace/:name":           helmAccess("helm:write", rbac.ActionDeploy),
+	"POST /api/v1/helm/releases/:cluster/:namespace/:name/preview":  helmAccess("helm:write", rbac.ActionDeploy),
+	"DELETE /api/v1/helm/releases/:cluster/:namespace/:name":        helmAccess("helm:delete", rbac.ActionDelete),
+	"POST /api/v1/helm/releases/:cluster/:namespace/:name/rollback": helmAccess("helm:write", rbac.ActionRollback),
+	"GET /api/v1/helm/releases/:cluster/:namespace/:name/history":   helmAccess("helm:read", rbac.ActionRead),
+	"GET /api/v1/helm/releases/:cluster/:namespace/:name/values":    helmAccess("helm:read", rbac.ActionRead),
+
+	// Applications
+	"GET /api/v1/applications":                        applicationAccess("applications:read", rbac.ActionRead),
+	"GET /api/v1/applications/:id":                    applicationAccess("applications:read", rbac.ActionRead),
+	"POST /api/v1/applications/repositories/validate": applicationAccess("applications:write", rbac.ActionCreate),
+	"POST /api/v1/applications":                       applicationAccess("applications:write", rbac.ActionCreate),
+	"PUT /api/v1/applications/:id":                    applicationAccess("applications:write", rbac.ActionUpdate),
+	"DELETE /api/v1/applications/:id":                 applicationAccess("applications:delete", rbac.ActionDelete),
+	"POST /api/v1/applications/:id/sync":              applicationAccess("applications:sync", rbac.ActionDeploy),
+	"GET /api/v1/applications/:id/status":             applicationAccess("applications:read", rbac.ActionRead),
+	"GET /api/v1/applications/:id/resources":          applicationAccess("applications:read", rbac.ActionRead),
+	"GET /api/v1/applications/:id/events":             applicationAccess("applications:read", rbac.ActionRead),
+	"GET /api/v1/applications/:id/manifests":          applicationAccess("applications:read", rbac.ActionRead),
+	"GET /api/v1/applications/:id/diff":               applicationAccess("applications:read", rbac.ActionRead),
+
+	// Pipelines
+	"GET /api/v1/pipelines":                          pipelineAccess("pipelines:read", rbac.ActionRead),
+	"GET /api/v1/pipelines/:id":                      pipelineAccess("pipelines:read", rbac.ActionRead),
+	"POST /api/v1/pipelines":                         pipelineAccess("pipelines:write", rbac.ActionCreate),
+	"PUT /api/v1/pipelines/:id":                      pipelineAccess("pipelines:write", rbac.ActionUpdate),
+	"DELETE /api/v1/pipelines/:id":                   pipelineAccess("pipelines:delete", rbac.ActionDelete),
+	"POST /api/v1/pipelines/:id/trigger":             pipelineAccess("pipelines:trigger", rbac.ActionExecute),
+	"GET /api/v1/pipelines/:id/runs":                 pipelineAccess("pipelines:read", rbac.ActionRead),
+	"GET /api/v1/pipelines/:id/runs/:runId":          pipelineAccess("pipelines:read", rbac.ActionRead),
+	"POST /api/v1/pipelines/:id/runs/:runId/cancel":  pipelineAccess("pipelines:trigger", rbac.ActionExecute),
+	"POST /api/v1/pipelines/:id/runs/:runId/retry":   pipelineAccess("pipelines:trigger", rbac.ActionExecute),
+	"POST /api/v1/pipelines/:id/runs/:runId/approve": pipelineAccess("pipelines:trigger", rbac.ActionApprove),
+	"POST /api/v1/pipelines/:id/runs/:runId/reject":  pipelineAccess("pipelines:trigger", rbac.ActionApprove),
+	"GET /api/v1/pipelines/:id/runs/:runId/logs":     pipelineAccess("pipelines:read", rbac.ActionRead),
+
+	// Security
+	"GET /api/v1/security/scans":                  securityAccess("security:read", rbac.ActionRead),
+	"GET /api/v1/security/scans/:id":              securityAccess("security:read", rbac.ActionRead),
+	"POST /api/v1/security/scans":                 securityAccess("security:write", rbac.ActionExecute),
+	"GET /api/v1/security/vulnerabilities":        securityAccess("security:read", rbac.ActionRead),
+	"GET /api/v1/security/policies":               securityAccess("security:read", rbac.ActionRead),
+	"POST /api/v1/security/policies":              securityAccess("security:write", rbac.ActionCreate),
+	"PUT /api/v1/security/policies/:id":           securityAccess("security:write", rbac.ActionUpdate),
+	"DELETE /api/v1/security/policies/:id":        securityAccess("security:write", rbac.ActionDelete),
+	"POST /api/v1/security/policies/:id/validate": securityAccess("security:read", rbac.ActionRead),
+}
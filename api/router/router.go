@@ -12,15 +12,20 @@ import (
 	"github.com/anubhavg-icpl/krustron/internal/rbac"
 	"github.com/anubhavg-icpl/krustron/internal/auth"
 	"github.com/anubhavg-icpl/krustron/internal/cluster"
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
 	"github.com/anubhavg-icpl/krustron/internal/gitops"
 	"github.com/anubhavg-icpl/krustron/internal/helm"
 	"github.com/anubhavg-icpl/krustron/internal/observability"
 	"github.com/anubhavg-icpl/krustron/internal/pipeline"
+	"github.com/anubhavg-icpl/krustron/internal/scim"
 	"github.com/anubhavg-icpl/krustron/internal/security"
 	"github.com/gin-contrib/cors"
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
 	"github.com/anubhavg-icpl/krustron/pkg/websocket"
 )
 
@@ -28,11 +33,20 @@ import (
 type Config struct {
 	Mode        string
 	CorsOrigins []string
+	// RateLimit limits requests per client IP; nil allows 100 requests per
+	// second with bursts of 200
+	RateLimit *middleware.RateLimit
+	// MetricsPath serves Prometheus metrics, named with MetricsNamespace;
+	// empty disables them
+	MetricsPath      string
+	MetricsNamespace string
 }
 
 // Services holds all service dependencies
 type Services struct {
 	Cluster       *cluster.Service
+	// ClusterConfig holds per-cluster overrides of global settings
+	ClusterConfig *clusterconfig.Store
 	Helm          *helm.Service
 	GitOps        *gitops.Service
 	Pipeline      *pipeline.Service
@@ -40,8 +54,19 @@ type Services struct {
 	Security      *security.Service
 	Observability *observability.Service
 	Hub           *websocket.Hub
+	// Events forwards NATS events over WebSocket; nil without NATS
+	Events *websocket.Gateway
 	Cost          *cost.Service
 	RBAC          *rbac.Service
+	// Notify routes notifications to channels; nil if it failed to start
+	Notify *notify.Service
+	// SCIM is nil unless a SCIM token is configured
+	SCIM *scim.Service
+	// Readiness turns /ready not ready at shutdown; nil is always ready
+	Readiness *Readiness
+	// Idempotency replays responses to POSTs retried with the same
+	// Idempotency-Key; nil without Redis
+	Idempotency *cache.Idempotency
 }
 
 // New creates a new Gin router
@@ -56,7 +81,15 @@ func New(cfg *Config) *gin.Engine {
 	r.Use(ginzap.Ginzap(logger.Get(), time.RFC3339, true))
 	r.Use(ginzap.RecoveryWithZap(logger.Get(), true))
 	r.Use(middleware.RequestID())
-	r.Use(middleware.RateLimiter(100, 200)) // 100 requests per second, burst 200
+	if cfg.MetricsPath != "" {
+		r.Use(middleware.Metrics())
+		r.GET(cfg.MetricsPath, gin.WrapH(metrics.Handler(cfg.MetricsNamespace)))
+	}
+	if cfg.RateLimit != nil {
+		r.Use(cfg.RateLimit.Handler())
+	} else {
+		r.Use(middleware.RateLimiter(100, 200)) // 100 requests per second, burst 200
+	}
 
 	// CORS
 	// AllowOrigins: ["*"] combined with AllowCredentials: true is invalid —
@@ -72,8 +105,8 @@ func New(cfg *Config) *gin.Engine {
 	corsConfig := cors.Config{
 		AllowOrigins:     allowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID"},
-		ExposeHeaders:    []string{"Content-Length", "X-Request-ID"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Request-ID", middleware.IdempotencyKeyHeader},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID", middleware.IdempotentReplayedHeader},
 		AllowCredentials: allowCredentials,
 		MaxAge:           12 * time.Hour,
 	}
@@ -100,11 +133,21 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 			public.POST("/auth/refresh", handlers.RefreshToken(services.Auth))
 			public.GET("/auth/oidc/login", handlers.OIDCLogin(services.Auth))
 			public.GET("/auth/oidc/callback", handlers.OIDCCallback(services.Auth))
+
+			// Git provider webhooks carry no JWT; each delivery is checked
+			// against the matching pipelines' webhook secrets instead
+			public.POST("/webhooks/github", handlers.GitHubWebhook(services.Pipeline))
+			public.POST("/webhooks/gitlab", handlers.GitLabWebhook(services.Pipeline))
+			public.POST("/webhooks/bitbucket", handlers.BitbucketWebhook(services.Pipeline))
 		}
 
 		// Protected routes
 		protected := v1.Group("")
-		protected.Use(middleware.JWTAuth(services.Auth))
+		protected.Use(
+			middleware.JWTAuth(services.Auth),
+			middleware.Authorize(services.RBAC, services.Auth.AuditRecorder(), routePermissions),
+			middleware.Idempotency(services.Idempotency),
+		)
 		{
 			// Auth routes
 			authRoutes := protected.Group("/auth")
@@ -115,6 +158,9 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 				authRoutes.PUT("/password", handlers.ChangePassword(services.Auth))
 				authRoutes.GET("/sessions", handlers.ListSessions(services.Auth))
 				authRoutes.DELETE("/sessions/:id", handlers.RevokeSession(services.Auth))
+				authRoutes.GET("/api-keys", handlers.ListAPIKeys(services.Auth))
+				authRoutes.POST("/api-keys", handlers.CreateAPIKey(services.Auth))
+				authRoutes.DELETE("/api-keys/:id", handlers.RevokeAPIKey(services.Auth))
 				authRoutes.POST("/2fa/setup", handlers.Setup2FA(services.Auth))
 				authRoutes.POST("/2fa/verify", handlers.Verify2FA(services.Auth))
 				authRoutes.POST("/2fa/disable", handlers.Disable2FA(services.Auth))
@@ -136,21 +182,45 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 			clusterRoutes := protected.Group("/clusters")
 			{
 				clusterRoutes.GET("", handlers.ListClusters(services.Cluster))
+				clusterRoutes.GET("/search", handlers.SearchResources(services.Cluster))
+				clusterRoutes.GET("/compare", handlers.CompareNamespaces(services.Cluster))
+				clusterRoutes.POST("/bulk/agent/install", middleware.RequireRole("admin"), handlers.BulkInstallAgent(services.Cluster))
+				clusterRoutes.POST("/bulk/health", handlers.BulkHealthCheck(services.Cluster))
+				clusterRoutes.POST("/bulk/labels", middleware.RequireRole("admin"), handlers.BulkUpdateLabels(services.Cluster))
 				clusterRoutes.GET("/:id", handlers.GetCluster(services.Cluster))
 				clusterRoutes.POST("", middleware.RequireRole("admin"), handlers.CreateCluster(services.Cluster))
 				clusterRoutes.PUT("/:id", middleware.RequireRole("admin"), handlers.UpdateCluster(services.Cluster))
-				// ponytail: destructive infra ops gated to admin; full fine-grained
-				// RBAC (RequirePermission per route + object-level scoping) is the
-				// remaining P0 before real deployment.
+				// Destructive infra ops stay gated to admin on top of the
+				// per-route permissions in routePermissions
 				clusterRoutes.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteCluster(services.Cluster))
+				clusterRoutes.GET("/:id/config", handlers.GetClusterConfig(services.ClusterConfig))
+				clusterRoutes.PUT("/:id/config", middleware.RequireRole("admin"), handlers.SetClusterConfig(services.ClusterConfig))
 				clusterRoutes.GET("/:id/health", handlers.GetClusterHealth(services.Cluster))
 				clusterRoutes.GET("/:id/resources", handlers.GetClusterResources(services.Cluster))
+				clusterRoutes.GET("/:id/nodes", handlers.GetNodes(services.Cluster))
+				clusterRoutes.GET("/:id/capacity", handlers.GetClusterCapacity(services.Cluster))
 				clusterRoutes.GET("/:id/namespaces", handlers.GetNamespaces(services.Cluster))
 				clusterRoutes.GET("/:id/namespaces/:namespace/pods", handlers.GetPods(services.Cluster))
 				clusterRoutes.GET("/:id/namespaces/:namespace/pods/:pod/logs", handlers.GetPodLogs(services.Cluster))
 				clusterRoutes.GET("/:id/namespaces/:namespace/services", handlers.GetServices(services.Cluster))
 				clusterRoutes.GET("/:id/namespaces/:namespace/deployments", handlers.GetDeployments(services.Cluster))
 				clusterRoutes.GET("/:id/namespaces/:namespace/events", handlers.GetEvents(services.Cluster))
+				clusterRoutes.POST("/:id/namespaces/:namespace/workloads/:kind/:name/restart", handlers.RestartWorkload(services.Cluster))
+				clusterRoutes.POST("/:id/namespaces/:namespace/workloads/:kind/:name/scale", middleware.RequireRole("admin"), handlers.ScaleWorkload(services.Cluster))
+				clusterRoutes.POST("/:id/namespaces/:namespace/workloads/:kind/:name/pause", handlers.PauseRollout(services.Cluster))
+				clusterRoutes.POST("/:id/namespaces/:namespace/workloads/:kind/:name/resume", handlers.ResumeRollout(services.Cluster))
+				clusterRoutes.GET("/:id/namespaces/:namespace/quotas", handlers.ListResourceQuotas(services.Cluster))
+				clusterRoutes.PUT("/:id/namespaces/:namespace/quota", middleware.RequireRole("admin"), handlers.SetResourceQuota(services.Cluster))
+				clusterRoutes.POST("/:id/namespaces/:namespace/quota/check", handlers.CheckResourceQuota(services.Cluster))
+				clusterRoutes.GET("/:id/namespaces/:namespace/quota/recommendation", handlers.RecommendQuota(services.Cluster))
+				clusterRoutes.GET("/:id/namespaces/:namespace/limitranges", handlers.ListLimitRanges(services.Cluster))
+				clusterRoutes.PUT("/:id/namespaces/:namespace/limitrange", middleware.RequireRole("admin"), handlers.SetLimitRange(services.Cluster))
+				clusterRoutes.GET("/:id/api-resources", handlers.ListAPIResources(services.Cluster))
+				clusterRoutes.GET("/:id/apis/:group/:version/:resource", handlers.ListResources(services.Cluster))
+				clusterRoutes.GET("/:id/apis/:group/:version/:resource/:name", handlers.GetResource(services.Cluster))
+				clusterRoutes.POST("/:id/apply", middleware.RequireRole("admin"), handlers.ApplyManifest(services.Cluster))
+				clusterRoutes.GET("/:id/applied", handlers.ListAppliedResources(services.Cluster))
+				clusterRoutes.DELETE("/:id/applied", middleware.RequireRole("admin"), handlers.DeleteAppliedResources(services.Cluster))
 				clusterRoutes.POST("/:id/agent/install", handlers.InstallAgent(services.Cluster))
 			}
 
@@ -161,6 +231,8 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 				helmRoutes.POST("/repositories", handlers.AddHelmRepo(services.Helm))
 				helmRoutes.DELETE("/repositories/:name", middleware.RequireRole("admin"), handlers.RemoveHelmRepo(services.Helm))
 				helmRoutes.POST("/repositories/:name/sync", handlers.SyncHelmRepo(services.Helm))
+				helmRoutes.POST("/registries", handlers.LoginHelmRegistry(services.Helm))
+				helmRoutes.DELETE("/registries/:host", handlers.LogoutHelmRegistry(services.Helm))
 				helmRoutes.GET("/charts", handlers.SearchCharts(services.Helm))
 				helmRoutes.GET("/charts/:repo/:chart", handlers.GetChartDetails(services.Helm))
 				helmRoutes.GET("/charts/:repo/:chart/versions", handlers.GetChartVersions(services.Helm))
@@ -168,6 +240,7 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 				helmRoutes.GET("/releases/:cluster/:namespace/:name", handlers.GetRelease(services.Helm))
 				helmRoutes.POST("/releases", handlers.InstallRelease(services.Helm))
 				helmRoutes.PUT("/releases/:cluster/:namespace/:name", handlers.UpgradeRelease(services.Helm))
+				helmRoutes.POST("/releases/:cluster/:namespace/:name/preview", handlers.PreviewUpgrade(services.Helm))
 				helmRoutes.DELETE("/releases/:cluster/:namespace/:name", middleware.RequireRole("admin"), handlers.UninstallRelease(services.Helm))
 				helmRoutes.POST("/releases/:cluster/:namespace/:name/rollback", handlers.RollbackRelease(services.Helm))
 				helmRoutes.GET("/releases/:cluster/:namespace/:name/history", handlers.GetReleaseHistory(services.Helm))
@@ -179,6 +252,7 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 			{
 				appRoutes.GET("", handlers.ListApplications(services.GitOps))
 				appRoutes.GET("/:id", handlers.GetApplication(services.GitOps))
+				appRoutes.POST("/repositories/validate", middleware.RBACEnforce(services.RBAC, "application", "create"), handlers.ValidateRepositoryAccess(services.GitOps))
 				appRoutes.POST("", middleware.RBACEnforce(services.RBAC, "application", "create"), handlers.CreateApplication(services.GitOps))
 				appRoutes.PUT("/:id", middleware.RBACEnforce(services.RBAC, "application", "update"), handlers.UpdateApplication(services.GitOps))
 				appRoutes.DELETE("/:id", middleware.RequireRole("admin"), handlers.DeleteApplication(services.GitOps))
@@ -203,6 +277,8 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 				pipelineRoutes.GET("/:id/runs/:runId", handlers.GetPipelineRun(services.Pipeline))
 				pipelineRoutes.POST("/:id/runs/:runId/cancel", handlers.CancelPipelineRun(services.Pipeline))
 				pipelineRoutes.POST("/:id/runs/:runId/retry", handlers.RetryPipelineRun(services.Pipeline))
+				pipelineRoutes.POST("/:id/runs/:runId/approve", middleware.RBACEnforce(services.RBAC, "pipeline", "execute"), handlers.ApprovePipelineRun(services.Pipeline))
+				pipelineRoutes.POST("/:id/runs/:runId/reject", middleware.RBACEnforce(services.RBAC, "pipeline", "execute"), handlers.RejectPipelineRun(services.Pipeline))
 				pipelineRoutes.GET("/:id/runs/:runId/logs", handlers.GetPipelineRunLogs(services.Pipeline))
 			}
 
@@ -242,6 +318,7 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 					costRoutes.GET("/budgets", handlers.ListBudgets(services.Cost))
 					costRoutes.POST("/budgets", middleware.RequireRole("admin"), handlers.CreateBudget(services.Cost))
 					costRoutes.POST("/reports", handlers.GenerateCostReport(services.Cost))
+					costRoutes.POST("/recommendations/:id/apply", middleware.RequireRole("admin"), handlers.ApplyRightsizing(services.Cost))
 				}
 			}
 
@@ -264,6 +341,7 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 				auditRoutes.GET("/logs", handlers.ListAuditLogs(services.Auth))
 				auditRoutes.GET("/logs/:id", handlers.GetAuditLog(services.Auth))
 				auditRoutes.GET("/logs/export", handlers.ExportAuditLogs(services.Auth))
+				auditRoutes.GET("/verify", handlers.VerifyAuditChain(services.Auth, services.RBAC))
 			}
 
 			// Settings routes
@@ -272,17 +350,39 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 			{
 				settingsRoutes.GET("", handlers.GetSettings(services.Auth))
 				settingsRoutes.PUT("", handlers.UpdateSettings(services.Auth))
-				settingsRoutes.GET("/notifications", handlers.GetNotificationSettings(services.Auth))
-				settingsRoutes.PUT("/notifications", handlers.UpdateNotificationSettings(services.Auth))
+				if services.Notify != nil {
+					settingsRoutes.GET("/notifications", handlers.GetNotificationSettings(services.Notify))
+					settingsRoutes.POST("/notifications/channels", handlers.CreateNotificationChannel(services.Notify))
+					settingsRoutes.PUT("/notifications/channels/:id", handlers.UpdateNotificationChannel(services.Notify))
+					settingsRoutes.DELETE("/notifications/channels/:id", handlers.DeleteNotificationChannel(services.Notify))
+					settingsRoutes.POST("/notifications/channels/:id/test", handlers.TestNotificationChannel(services.Notify))
+					settingsRoutes.POST("/notifications/routes", handlers.CreateNotificationRoute(services.Notify))
+					settingsRoutes.PUT("/notifications/routes/:id", handlers.UpdateNotificationRoute(services.Notify))
+					settingsRoutes.DELETE("/notifications/routes/:id", handlers.DeleteNotificationRoute(services.Notify))
+				}
 			}
+		}
+	}
 
-			// Webhooks (for external integrations)
-			webhookRoutes := protected.Group("/webhooks")
-			{
-				webhookRoutes.POST("/github", handlers.GitHubWebhook(services.Pipeline))
-				webhookRoutes.POST("/gitlab", handlers.GitLabWebhook(services.Pipeline))
-				webhookRoutes.POST("/bitbucket", handlers.BitbucketWebhook(services.Pipeline))
-			}
+	// SCIM provisioning for identity providers. It authenticates with its
+	// own bearer token, not user JWTs, and is off unless one is configured.
+	if services.SCIM != nil {
+		scimRoutes := r.Group(scim.BasePath)
+		scimRoutes.Use(middleware.SCIMAuth(services.SCIM))
+		{
+			scimRoutes.GET("/ServiceProviderConfig", handlers.SCIMServiceProviderConfig(services.SCIM))
+			scimRoutes.GET("/Users", handlers.SCIMListUsers(services.SCIM))
+			scimRoutes.POST("/Users", handlers.SCIMCreateUser(services.SCIM))
+			scimRoutes.GET("/Users/:id", handlers.SCIMGetUser(services.SCIM))
+			scimRoutes.PUT("/Users/:id", handlers.SCIMReplaceUser(services.SCIM))
+			scimRoutes.PATCH("/Users/:id", handlers.SCIMPatchUser(services.SCIM))
+			scimRoutes.DELETE("/Users/:id", handlers.SCIMDeleteUser(services.SCIM))
+			scimRoutes.GET("/Groups", handlers.SCIMListGroups(services.SCIM))
+			scimRoutes.POST("/Groups", handlers.SCIMCreateGroup(services.SCIM))
+			scimRoutes.GET("/Groups/:id", handlers.SCIMGetGroup(services.SCIM))
+			scimRoutes.PUT("/Groups/:id", handlers.SCIMReplaceGroup(services.SCIM))
+			scimRoutes.PATCH("/Groups/:id", handlers.SCIMPatchGroup(services.SCIM))
+			scimRoutes.DELETE("/Groups/:id", handlers.SCIMDeleteGroup(services.SCIM))
 		}
 	}
 
@@ -295,6 +395,7 @@ func RegisterRoutes(r *gin.Engine, services *Services) {
 	ws := r.Group("/ws")
 	ws.Use(middleware.WSAuth(services.Auth))
 	{
+		ws.GET("/events", handlers.EventsWS(services.Events, services.RBAC))
 		ws.GET("/clusters/:id/events", handlers.ClusterEventsWS(services.Cluster))
 		ws.GET("/pipelines/:id/logs", handlers.PipelineLogsWS(services.Pipeline))
 		ws.GET("/pods/:cluster/:namespace/:pod/logs", handlers.PodLogsWS(services.Cluster))
@@ -312,6 +413,13 @@ func healthCheck(c *gin.Context) {
 // readinessCheck checks if all dependencies are ready
 func readinessCheck(services *Services) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if services.Readiness.Draining() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "shutting down",
+			})
+			return
+		}
+
 		// Check all service dependencies
 		ready := true
 		checks := make(map[string]string)
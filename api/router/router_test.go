@@ -0,0 +1,38 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := New(&Config{Mode: "test", CorsOrigins: []string{"*"}, MetricsPath: "/metrics", MetricsNamespace: "krustron"})
+	r.GET("/things/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	requests := metrics.HTTPRequests.WithLabelValues("GET", "/things/:id", "2xx")
+	before := testutil.ToFloat64(requests)
+
+	for _, id := range []string{"a", "b"} {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/things/"+id, nil))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+	assert.Equal(t, before+2, testutil.ToFloat64(requests), "requests are counted by route, not path")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `krustron_http_requests_total{code="2xx",method="GET",route="/things/:id"}`)
+
+	// Disabled metrics serve nothing
+	r = New(&Config{Mode: "test", CorsOrigins: []string{"*"}})
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness reports whether the server should receive traffic. It turns
+// not ready once the server starts shutting down.
+type Readiness struct {
+	draining atomic.Bool
+}
+
+// Drain marks the server not ready
+func (r *Readiness) Drain() {
+	r.draining.Store(true)
+}
+
+// Draining reports whether Drain has been called
+func (r *Readiness) Draining() bool {
+	return r != nil && r.draining.Load()
+}
+
+// ServerConfig holds HTTP server settings
+type ServerConfig struct {
+	Addr         string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// DrainDelay is how long the server keeps serving after turning not
+	// ready, so load balancers notice before connections are refused
+	DrainDelay time.Duration
+}
+
+// Server serves the API and shuts down without dropping in-flight
+// requests
+type Server struct {
+	http       *http.Server
+	readiness  *Readiness
+	drainDelay time.Duration
+}
+
+// NewServer creates a server for handler. readiness is drained when the
+// server shuts down; it should be the one passed to RegisterRoutes.
+func NewServer(handler http.Handler, cfg *ServerConfig, readiness *Readiness) *Server {
+	return &Server{
+		http: &http.Server{
+			Addr:         cfg.Addr,
+			Handler:      handler,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		},
+		readiness:  readiness,
+		drainDelay: cfg.DrainDelay,
+	}
+}
+
+// ListenAndServe listens on the configured address and serves until
+// Shutdown. It returns nil after a shutdown.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves on l until Shutdown. It returns nil after a shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.http.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown turns the server not ready, keeps serving for the drain delay,
+// then stops accepting connections and waits for in-flight requests to
+// finish. If ctx ends first the remaining connections are closed and its
+// error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.readiness != nil {
+		s.readiness.Drain()
+	}
+	if s.drainDelay > 0 {
+		timer := time.NewTimer(s.drainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	err := s.http.Shutdown(ctx)
+	if err != nil {
+		s.http.Close()
+	}
+	return err
+}
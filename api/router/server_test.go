@@ -0,0 +1,115 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves a router with /ready and a /slow route that blocks
+// until release is closed
+func startServer(t *testing.T, drainDelay time.Duration) (*Server, *Readiness, string, chan struct{}, chan struct{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	started, release := make(chan struct{}), make(chan struct{})
+
+	readiness := &Readiness{}
+	r := gin.New()
+	r.GET("/ready", readinessCheck(&Services{Readiness: readiness}))
+	r.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(r, &ServerConfig{DrainDelay: drainDelay}, readiness)
+	go srv.Serve(l)
+	return srv, readiness, "http://" + l.Addr().String(), started, release
+}
+
+func TestServerShutdownDrainsInFlightRequests(t *testing.T) {
+	srv, readiness, base, started, release := startServer(t, 50*time.Millisecond)
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- result{status: resp.StatusCode, body: string(body)}
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+	// Not ready as soon as shutdown starts, while still serving
+	require.Eventually(t, readiness.Draining, time.Second, time.Millisecond)
+	resp, err := http.Get(base + "/ready")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned with a request in flight: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	res := <-slow
+	require.NoError(t, res.err)
+	assert.Equal(t, http.StatusOK, res.status)
+	assert.Equal(t, "done", res.body)
+	assert.NoError(t, <-shutdown)
+
+	_, err = http.Get(base + "/ready")
+	assert.Error(t, err, "new connections are refused after shutdown")
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	srv, _, base, started, release := startServer(t, 0)
+	defer close(release)
+
+	go http.Get(base + "/slow")
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func TestReadinessCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	readiness := &Readiness{}
+	r := gin.New()
+	r.GET("/ready", readinessCheck(&Services{Readiness: readiness}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	readiness.Drain()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "shutting down")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/internal/backup"
+	"github.com/anubhavg-icpl/krustron/internal/cost"
+	"github.com/anubhavg-icpl/krustron/internal/pipeline"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/internal/remediation"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var backupResources = []string{
+	backup.ResourceRBAC,
+	backup.ResourceRemediation,
+	backup.ResourceBudgets,
+	backup.ResourcePipelines,
+}
+
+func backupCmd() *cobra.Command {
+	var output string
+	var resources []string
+
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Back up Krustron state to an archive",
+		Long: `Write RBAC roles and policies, remediation rules and playbooks, cost
+budgets and pipelines to a single versioned archive.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manager, cleanup, err := newBackupManager()
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", output, err)
+			}
+			archive, err := manager.Backup(context.Background(), f, resources...)
+			if cerr := f.Close(); err == nil && cerr != nil {
+				err = fmt.Errorf("failed to write %s: %w", output, cerr)
+			}
+			if err != nil {
+				os.Remove(output)
+				return err
+			}
+
+			fmt.Printf("Backed up %s to %s (schema version %d)\n",
+				strings.Join(archive.ResourceTypes(), ", "), output, archive.SchemaVersion)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "archive file to write")
+	cmd.Flags().StringSliceVar(&resources, "resources", nil,
+		"resource types to back up (default all: "+strings.Join(backupResources, ", ")+")")
+	cmd.MarkFlagRequired("output")
+	return cmd
+}
+
+func restoreCmd() *cobra.Command {
+	var input string
+	var resources []string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore Krustron state from an archive",
+		Long: `Reapply an archive written by "krustron backup" in a single transaction.
+Use --dry-run to see what would be restored without changing anything.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(input)
+			if err != nil {
+				return fmt.Errorf("failed to open %s: %w", input, err)
+			}
+			archive, err := backup.ReadArchive(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+
+			manager, cleanup, err := newBackupManager()
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			report, err := manager.Restore(context.Background(), archive, backup.RestoreOptions{
+				Resources: resources,
+				DryRun:    dryRun,
+			})
+			if err != nil {
+				return err
+			}
+
+			verb := "Restored"
+			if report.DryRun {
+				verb = "Would restore"
+			}
+			for _, r := range report.Resources {
+				fmt.Printf("%s %d %s\n", verb, r.Count, r.Resource)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&input, "input", "i", "", "archive file to restore")
+	cmd.Flags().StringSliceVar(&resources, "resources", nil,
+		"resource types to restore (default all in the archive)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "show what would be restored without changing anything")
+	cmd.MarkFlagRequired("input")
+	return cmd
+}
+
+// newBackupManager connects to the database and registers every service
+// that takes part in backups
+func newBackupManager() (*backup.Manager, func(), error) {
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	db, err := database.NewPostgresDB(&cfg.Database)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	gormDB, err := database.NewGormDB(&cfg.Database)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to open GORM connection: %w", err)
+	}
+	closeDBs := func() {
+		if sqlDB, err := gormDB.DB(); err == nil {
+			sqlDB.Close()
+		}
+		db.Close()
+	}
+
+	log := zap.NewNop()
+	rbacService, err := rbac.NewService(gormDB, log, &rbac.Config{})
+	if err != nil {
+		closeDBs()
+		return nil, nil, fmt.Errorf("failed to create RBAC service: %w", err)
+	}
+	remediationService, err := remediation.NewService(gormDB, log, &remediation.Config{DisableWorkers: true})
+	if err != nil {
+		rbacService.Stop()
+		closeDBs()
+		return nil, nil, fmt.Errorf("failed to create remediation service: %w", err)
+	}
+	costService, err := cost.NewService(gormDB, log, &cost.Config{})
+	if err != nil {
+		rbacService.Stop()
+		remediationService.Stop()
+		closeDBs()
+		return nil, nil, fmt.Errorf("failed to create cost service: %w", err)
+	}
+
+	manager := backup.NewManager(gormDB, version)
+	manager.Register(backup.ResourceRBAC, rbacService)
+	manager.Register(backup.ResourceRemediation, remediationService)
+	manager.Register(backup.ResourceBudgets, costService)
+	manager.Register(backup.ResourcePipelines, pipeline.NewService(db, nil, nil, nil))
+
+	cleanup := func() {
+		rbacService.Stop()
+		remediationService.Stop()
+		costService.Stop()
+		closeDBs()
+	}
+	return manager, cleanup, nil
+}
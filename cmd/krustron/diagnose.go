@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/ai"
+	"github.com/anubhavg-icpl/krustron/internal/cluster"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// diagnoseAILimit is how many of the top findings are sent to the AI
+const diagnoseAILimit = 5
+
+// diagnosis is one triage finding with the AI's take on it, if any
+type diagnosis struct {
+	cluster.Finding
+	AI *ai.DiagnosisResult `json:"ai,omitempty"`
+}
+
+// diagnoseReport is what "krustron diagnose" prints
+type diagnoseReport struct {
+	*cluster.TriageReport
+	Findings []diagnosis `json:"findings"`
+	AIError  string      `json:"ai_error,omitempty"`
+}
+
+func diagnoseCmd() *cobra.Command {
+	var namespace, format, output string
+	var since time.Duration
+	var noAI bool
+
+	cmd := &cobra.Command{
+		Use:   "diagnose <cluster>",
+		Short: "Triage a cluster and rank what is wrong with it",
+		Long: `Gather pods that aren't running, pending volume claims and recent Warning
+events from a cluster, given by name or ID, and print them most urgent
+first. When AI is enabled and configured the top findings are diagnosed
+by the AI as well; otherwise only built-in heuristics are used.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("invalid format %q: must be text or json", format)
+			}
+
+			cfg, err := config.Load(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			db, err := database.NewPostgresDB(&cfg.Database)
+			if err != nil {
+				return fmt.Errorf("failed to connect to database: %w", err)
+			}
+			defer db.Close()
+
+			kubeManager, err := kube.NewClientManager(&cfg.Kubernetes)
+			if err != nil {
+				return fmt.Errorf("failed to create Kubernetes client manager: %w", err)
+			}
+			clusterService := cluster.NewService(db, kubeManager, nil)
+			localClient, _ := kubeManager.GetLocalClient()
+			secretStore, err := newSecretStore(cfg, localClient, db)
+			if err != nil {
+				return err
+			}
+			if secretStore != nil {
+				clusterService.SetSecretStore(secretStore)
+			}
+
+			ctx := context.Background()
+			triage, err := clusterService.Triage(ctx, args[0], cluster.TriageOptions{Namespace: namespace, Since: since})
+			if err != nil {
+				return err
+			}
+
+			report := &diagnoseReport{TriageReport: triage, Findings: make([]diagnosis, len(triage.Findings))}
+			for i, f := range triage.Findings {
+				report.Findings[i].Finding = f
+			}
+			if !noAI && aiConfigured(&cfg.AI) && len(report.Findings) > 0 {
+				if err := diagnoseWithAI(ctx, cfg, secretStore, report); err != nil {
+					report.AIError = err.Error()
+					fmt.Fprintf(os.Stderr, "Warning: AI diagnosis failed, showing heuristics only: %v\n", err)
+				}
+			}
+
+			w := io.Writer(os.Stdout)
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %w", output, err)
+				}
+				defer f.Close()
+				w = f
+			}
+			if format == "json" {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+			return writeDiagnoseText(w, report)
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "namespace to triage (default all namespaces)")
+	cmd.Flags().DurationVar(&since, "since", time.Hour, "how far back to look at events")
+	cmd.Flags().StringVar(&format, "format", "text", "output format: text or json")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the report to (default stdout)")
+	cmd.Flags().BoolVar(&noAI, "no-ai", false, "use heuristics only, even when AI is configured")
+	return cmd
+}
+
+// aiConfigured reports whether the AI can be asked: it must be enabled and
+// have an API key or a secret holding one, except for a local Ollama and
+// Bedrock, which is signed with AWS credentials
+func aiConfigured(cfg *config.AIConfig) bool {
+	if !cfg.Enabled {
+		return false
+	}
+	switch ai.Provider(cfg.Provider) {
+	case ai.ProviderOllama:
+		return true
+	case ai.ProviderBedrock:
+		return cfg.AWSAccessKeyID != "" && cfg.AWSSecretAccessKey != ""
+	}
+	return cfg.APIKey != "" || cfg.APIKeyRef != ""
+}
+
+// newAIConfig builds the AI service configuration from cfg
+func newAIConfig(cfg *config.AIConfig) *ai.Config {
+	return &ai.Config{
+		Provider:           ai.Provider(cfg.Provider),
+		APIKey:             cfg.APIKey,
+		APIKeyRef:          cfg.APIKeyRef,
+		Endpoint:           cfg.Endpoint,
+		Model:              cfg.Model,
+		MaxTokens:          cfg.MaxTokens,
+		Temperature:        cfg.Temperature,
+		AzureResource:      cfg.AzureResource,
+		AzureDeployment:    cfg.AzureDeployment,
+		AzureAPIVersion:    cfg.AzureAPIVersion,
+		BedrockRegion:      cfg.BedrockRegion,
+		AWSAccessKeyID:     cfg.AWSAccessKeyID,
+		AWSSecretAccessKey: cfg.AWSSecretAccessKey,
+		AWSSessionToken:    cfg.AWSSessionToken,
+	}
+}
+
+// diagnoseWithAI asks the AI about the top findings of report
+func diagnoseWithAI(ctx context.Context, cfg *config.Config, secretStore *secrets.Cached, report *diagnoseReport) error {
+	gormDB, err := database.NewGormDB(&cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to open GORM connection: %w", err)
+	}
+	aiService, err := ai.NewService(gormDB, zap.NewNop(), newAIConfig(&cfg.AI))
+	if err != nil {
+		return fmt.Errorf("failed to create AI service: %w", err)
+	}
+	if secretStore != nil {
+		aiService.SetSecretStore(secretStore)
+	}
+
+	for i := range report.Findings {
+		if i == diagnoseAILimit {
+			break
+		}
+		f := &report.Findings[i]
+		events := make([]map[string]interface{}, len(f.Events))
+		for j, e := range f.Events {
+			events[j] = map[string]interface{}{"message": e}
+		}
+		result, err := aiService.DiagnoseIssue(ctx, "cli", ai.DiagnosisRequest{
+			ResourceType: f.Kind,
+			ResourceName: f.Name,
+			Namespace:    f.Namespace,
+			Cluster:      report.Cluster,
+			Description:  f.Summary(),
+			Events:       events,
+			Status:       map[string]interface{}{"reason": f.Reason, "severity": f.Severity},
+		})
+		if err != nil {
+			return err
+		}
+		f.AI = result
+	}
+	return nil
+}
+
+// writeDiagnoseText prints the report for a terminal
+func writeDiagnoseText(w io.Writer, report *diagnoseReport) error {
+	scope := "all namespaces"
+	if report.Namespace != "" {
+		scope = "namespace " + report.Namespace
+	}
+	fmt.Fprintf(w, "Cluster %s, %s, events from the last %s\n", report.Cluster, scope, report.Since)
+	if len(report.Findings) == 0 {
+		_, err := fmt.Fprintln(w, "No problems found")
+		return err
+	}
+	fmt.Fprintf(w, "%d findings, %d warning events\n", len(report.Findings), report.WarningEvents)
+
+	for i, f := range report.Findings {
+		fmt.Fprintf(w, "\n%d. [%s] %s\n", i+1, strings.ToUpper(f.Severity), f.Summary())
+		if f.Hint != "" {
+			fmt.Fprintf(w, "   Hint: %s\n", f.Hint)
+		}
+		for _, e := range f.Events {
+			fmt.Fprintf(w, "   Event: %s\n", e)
+		}
+		if f.AI != nil {
+			if f.AI.RootCause != "" {
+				fmt.Fprintf(w, "   Root cause: %s\n", f.AI.RootCause)
+			}
+			for j, step := range f.AI.Steps {
+				fmt.Fprintf(w, "   %d) %s\n", j+1, step)
+			}
+		}
+	}
+	return nil
+}
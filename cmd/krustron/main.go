@@ -27,29 +27,38 @@ import (
 	"context"
 	"time"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
+	"github.com/anubhavg-icpl/krustron/api/middleware"
 	"github.com/anubhavg-icpl/krustron/api/router"
+	"github.com/anubhavg-icpl/krustron/internal/ai"
 	"github.com/anubhavg-icpl/krustron/internal/cost"
 	"github.com/anubhavg-icpl/krustron/internal/cluster"
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
 	"github.com/anubhavg-icpl/krustron/internal/gitops"
 	"github.com/anubhavg-icpl/krustron/internal/helm"
 	"github.com/anubhavg-icpl/krustron/internal/pipeline"
 	"github.com/anubhavg-icpl/krustron/internal/auth"
 	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/internal/remediation"
+	"github.com/anubhavg-icpl/krustron/internal/scim"
 	"github.com/anubhavg-icpl/krustron/internal/security"
 	"github.com/anubhavg-icpl/krustron/internal/observability"
 	"github.com/anubhavg-icpl/krustron/pkg/cache"
 	"github.com/anubhavg-icpl/krustron/pkg/config"
 	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/httpsafe"
 	"github.com/anubhavg-icpl/krustron/pkg/kube"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/nats"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
 	"github.com/anubhavg-icpl/krustron/pkg/websocket"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
@@ -77,6 +86,10 @@ Built by Anubhav Gain <anubhavg@infopercept.com>`,
 	rootCmd.AddCommand(serveCmd())
 	rootCmd.AddCommand(versionCmd())
 	rootCmd.AddCommand(migrateCmd())
+	rootCmd.AddCommand(backupCmd())
+	rootCmd.AddCommand(restoreCmd())
+	rootCmd.AddCommand(diagnoseCmd())
+	rootCmd.AddCommand(secretsCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -114,6 +127,9 @@ func migrateCmd() *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("failed to load config: %w", err)
 			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
 
 			db, err := database.NewPostgresDB(&cfg.Database)
 			if err != nil {
@@ -142,6 +158,9 @@ func runServer(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
 
 	// Initialize logger
 	logCfg := &logger.Config{
@@ -159,11 +178,25 @@ func runServer(cmd *cobra.Command, args []string) error {
 		zap.String("version", version),
 		zap.String("commit", commit),
 	)
+	for _, warning := range cfg.Warnings() {
+		logger.Warn("Insecure configuration", zap.String("warning", warning))
+	}
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Background workers stop when ctx is cancelled; shutdown waits for
+	// them before the database and cache are closed
+	var workers sync.WaitGroup
+	runWorker := func(run func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
+
 	// Initialize database
 	db, err := database.NewPostgresDB(&cfg.Database)
 	if err != nil {
@@ -184,6 +217,25 @@ func runServer(cmd *cobra.Command, args []string) error {
 		defer redisCache.Close()
 	}
 
+	// Events are published to NATS when it is reachable; without it audit
+	// logs are still written, just not published
+	var eventBus *nats.EventBus
+	var eventGateway *websocket.Gateway
+	natsClient, err := nats.NewClient(logger.Get(), &nats.Config{
+		URL:           cfg.NATS.URL,
+		ClusterID:     cfg.NATS.ClusterID,
+		ClientID:      cfg.NATS.ClientID,
+		MaxReconnects: cfg.NATS.MaxReconnects,
+		ReconnectWait: cfg.NATS.ReconnectWait,
+	})
+	if err != nil {
+		logger.Warn("Failed to connect to NATS, continuing without events", zap.Error(err))
+	} else {
+		defer natsClient.Close()
+		eventBus = nats.NewEventBus(natsClient, logger.Get())
+		eventGateway = websocket.NewGateway(natsClient, logger.Get(), nil)
+	}
+
 	// Initialize Kubernetes client manager
 	kubeManager, err := kube.NewClientManager(&cfg.Kubernetes)
 	if err != nil {
@@ -200,28 +252,128 @@ func runServer(cmd *cobra.Command, args []string) error {
 		)
 	}
 
+	// Kubeconfigs and cloud, registry and repository credentials are kept
+	// in the configured secret backend
+	secretStore, err := newSecretStore(cfg, localClient, db)
+	if err != nil {
+		return err
+	}
+	if secretStore == nil {
+		logger.Warn("No secret store; cloud clusters, private registries and repositories can't be used")
+	}
+	// Sensitive columns such as webhook secrets are encrypted with field
+	// keys from the secret store
+	fieldCipher, err := newFieldCipher(ctx, cfg, secretStore)
+	if err != nil {
+		return fmt.Errorf("failed to load field keys: %w", err)
+	}
+
 	// Initialize services
 	clusterService := cluster.NewService(db, kubeManager, redisCache)
+	if secretStore != nil {
+		clusterService.SetSecretStore(secretStore)
+		// Clusters reconnect when their credentials rotate
+		secretStore.OnRotate(clusterService.SecretRotated)
+	}
+	clusterService.SetFieldCipher(fieldCipher)
+	clusterService.SetBulkConcurrency(cfg.Kubernetes.BulkConcurrency)
 	helmService := helm.NewService(db, kubeManager, redisCache)
+	helmService.SetChartCache(cfg.Helm.ChartCacheDir, cfg.Helm.ChartCacheTTL)
+	helmService.SetStrictRender(cfg.Helm.StrictRender)
+	if secretStore != nil {
+		helmService.SetSecretStore(secretStore)
+	}
+	if eventBus != nil {
+		helmService.SetEventBus(eventBus)
+	}
 	gitopsService := gitops.NewService(db, kubeManager, &cfg.GitOps)
+	if eventBus != nil {
+		gitopsService.SetEventBus(eventBus)
+	}
+	if secretStore != nil {
+		gitopsService.SetSecretStore(secretStore)
+	}
+	if redisCache != nil {
+		gitopsService.SetLocks(redisCache)
+	}
 	pipelineService := pipeline.NewService(db, kubeManager, redisCache, gitopsService)
+	pipelineService.SetFieldCipher(fieldCipher)
+	if localClient != nil {
+		// Stage Jobs run in the cluster krustron itself runs in
+		pipelineService.SetRunner(localClient.Clientset, cfg.Kubernetes.PipelineNamespace)
+	}
+	logSink, err := pipeline.NewLogSink(db, &cfg.Pipeline.Logs)
+	if err != nil {
+		return fmt.Errorf("failed to create pipeline log sink: %w", err)
+	}
+	pipelineService.SetLogSink(logSink, cfg.Pipeline.Logs.MaxBytes)
+	if cfg.Observability.Prometheus.URL != "" {
+		// Canary stages judge their canaries by Prometheus queries
+		pipelineService.SetMetricSource(pipeline.NewPrometheusMetrics(&cfg.Observability.Prometheus))
+	}
 	authService, err := auth.NewService(db, redisCache, &cfg.Auth)
 	if err != nil {
 		return fmt.Errorf("failed to create auth service: %w", err)
 	}
+	// Every service writes audit logs through the auth service's recorder
+	auditRecorder := authService.AuditRecorder()
+	if eventBus != nil {
+		auditRecorder.SetEventBus(eventBus)
+	}
+	// Per-cluster overrides of remediation, cost and GitOps settings
+	clusterConfigStore := clusterconfig.NewStore(db)
+	clusterConfigStore.SetAuditRecorder(auditRecorder)
+	clusterService.SetAuditRecorder(auditRecorder)
+	gitopsService.SetClusterOverrides(clusterConfigStore)
 	securityService := security.NewService(db, kubeManager, &cfg.Security)
 	observabilityService := observability.NewService(&cfg.Observability)
 
+	// Outbound webhooks go to user-supplied URLs, so they are held to the
+	// configured egress policy
+	egress := httpsafe.Policy(cfg.Webhooks)
+
+	// Notification channels and the routes sending events to them
+	// (GORM-backed). Without it services only send to the targets
+	// configured on them.
+	var notifyService *notify.Service
+	if gormDB, gerr := database.NewGormDB(&cfg.Database); gerr != nil {
+		logger.Warn("Failed to open GORM connection, notification routing disabled", zap.Error(gerr))
+	} else if svc, nerr := notify.NewService(gormDB, logger.Get(), &notify.Config{
+		SMTP: notify.SMTPConfig{
+			Addr:     cfg.Notifications.SMTPAddr,
+			From:     cfg.Notifications.SMTPFrom,
+			Username: cfg.Notifications.SMTPUsername,
+			Password: cfg.Notifications.SMTPPassword,
+		},
+		Egress: egress,
+	}); nerr != nil {
+		logger.Warn("Failed to create notification service", zap.Error(nerr))
+	} else {
+		notifyService = svc
+		notifyService.SetFieldCipher(fieldCipher)
+	}
+
 	// Fine-grained RBAC (Casbin, GORM-backed). Reuses the GORM handle; tables
 	// are namespaced rbac_* so they don't collide with auth's roles table.
 	// Nil-safe: if it fails to construct, RBACEnforce degrades to deny.
 	var rbacService *rbac.Service
 	if gormDB, gerr := database.NewGormDB(&cfg.Database); gerr != nil {
 		logger.Warn("Failed to open GORM connection for RBAC, fine-grained RBAC disabled", zap.Error(gerr))
-	} else if svc, rerr := rbac.NewService(gormDB, logger.Get(), &rbac.Config{AuditEnabled: true}); rerr != nil {
+	} else if svc, rerr := rbac.NewService(gormDB, logger.Get(), &rbac.Config{AuditEnabled: true, Egress: egress}); rerr != nil {
 		logger.Warn("Failed to create RBAC service", zap.Error(rerr))
 	} else {
 		rbacService = svc
+		rbacService.SetAuditRecorder(auditRecorder)
+		if notifyService != nil {
+			rbacService.SetNotifier(notifyService)
+		}
+		defer rbacService.Stop()
+	}
+
+	// SCIM provisioning is opt-in: it needs its own token
+	var scimService *scim.Service
+	if cfg.Auth.SCIMToken != "" {
+		scimService = scim.NewService(authService, rbacService, logger.Get(), &scim.Config{Token: cfg.Auth.SCIMToken})
 	}
 
 	// Cost service is GORM-backed (the rest of the app uses database/sql).
@@ -230,46 +382,240 @@ func runServer(cmd *cobra.Command, args []string) error {
 	var costService *cost.Service
 	if gormDB, gerr := database.NewGormDB(&cfg.Database); gerr != nil {
 		logger.Warn("Failed to open GORM connection, cost service disabled", zap.Error(gerr))
-	} else if svc, cerr := cost.NewService(gormDB, logger.Get(), &cost.Config{}); cerr != nil {
+	} else if svc, cerr := cost.NewService(gormDB, logger.Get(), &cost.Config{
+		SMTPAddr:                 cfg.Notifications.SMTPAddr,
+		SMTPFrom:                 cfg.Notifications.SMTPFrom,
+		SMTPUsername:             cfg.Notifications.SMTPUsername,
+		SMTPPassword:             cfg.Notifications.SMTPPassword,
+		Egress:                   egress,
+		CacheEnabled:             cfg.Cost.CacheEnabled,
+		CacheTTL:                 cfg.Cost.CacheTTL,
+		RemediateRecommendations: cfg.Cost.RemediateRecommendations,
+		PrometheusEndpoint:       cfg.Observability.Prometheus.URL,
+		PrometheusUsername:       cfg.Observability.Prometheus.Username,
+		PrometheusPassword:       cfg.Observability.Prometheus.Password,
+		GPUUtilizationQuery:      cfg.Cost.GPUUtilizationQuery,
+	}); cerr != nil {
 		logger.Warn("Failed to create cost service", zap.Error(cerr))
 	} else {
 		costService = svc
+		defer costService.Stop()
 		costService.SetKubeManager(kubeManager)
+		costService.SetClusterOverrides(clusterConfigStore)
+		costService.SetAuditRecorder(auditRecorder)
+		if redisCache != nil {
+			costService.SetCache(redisCache)
+		}
+		if notifyService != nil {
+			costService.SetNotifier(notifyService)
+		}
+		// Namespace quota recommendations are sized from cost allocations
+		clusterService.SetUsageSource(costService)
+		// Take over rightsizing rollback watches a stopped replica, or this
+		// one before a restart, left unfinished
+		runWorker(func() { costService.RunRightsizingWatches(ctx) })
 		// Sample cluster usage every 15 minutes so the cost tables accumulate
-		// real data (GetCostSummary/ListCostAllocations otherwise return zeros).
-		go func() {
+		// real data (GetCostSummary/ListCostAllocations otherwise return zeros),
+		// then check budgets against it and send any new alerts.
+		runWorker(func() {
 			ticker := time.NewTicker(15 * time.Minute)
 			defer ticker.Stop()
-			costService.IngestUsage(ctx) // one immediate sample at startup
+			tick := func() {
+				costService.IngestUsage(ctx)
+				if err := costService.CheckBudgetAlerts(ctx); err != nil {
+					logger.Warn("Budget alert check failed", zap.Error(err))
+				}
+				if _, err := costService.DispatchBudgetAlerts(ctx); err != nil {
+					logger.Warn("Budget alert dispatch failed", zap.Error(err))
+				}
+			}
+			tick() // one immediate sample at startup
 			for {
 				select {
 				case <-ctx.Done():
 					return
 				case <-ticker.C:
-					costService.IngestUsage(ctx)
+					tick()
 				}
 			}
-		}()
+		})
+	}
+
+	// AI assistant (GORM-backed). Its tools read cluster state with the
+	// permissions the asking user holds in RBAC; without RBAC every tool
+	// call is denied.
+	var aiService *ai.Service
+	if !aiConfigured(&cfg.AI) {
+		logger.Info("AI assistant disabled")
+	} else if gormDB, gerr := database.NewGormDB(&cfg.Database); gerr != nil {
+		logger.Warn("Failed to open GORM connection, AI assistant disabled", zap.Error(gerr))
+	} else if svc, aerr := ai.NewService(gormDB, logger.Get(), newAIConfig(&cfg.AI)); aerr != nil {
+		logger.Warn("Failed to create AI service", zap.Error(aerr))
+	} else {
+		aiService = svc
+		// Share rate limits across replicas when Redis is up
+		if redisCache != nil {
+			aiService.SetRedis(redisCache)
+		}
+		if secretStore != nil {
+			aiService.SetSecretStore(secretStore)
+		}
+		aiService.RegisterTool(clusterService.AITools()...)
+		if rbacService != nil {
+			aiService.SetToolAuthorizer(rbacService.AuthorizeUser)
+		}
+		// Insights are raised from diagnoses and from the cost service's
+		// pending recommendations. Stops when ctx is cancelled.
+		if costService != nil {
+			aiService.SetRecommendationSource(costService)
+		}
+		runWorker(func() { aiService.RunInsights(ctx) })
+	}
+
+	// Auto-remediation (GORM-backed) watches the events of every cluster the
+	// client manager holds, now and as clusters are added, and runs the
+	// rules matching them
+	var remediationService *remediation.Service
+	if !cfg.Remediation.Enabled {
+		logger.Info("Auto-remediation disabled")
+	} else if gormDB, gerr := database.NewGormDB(&cfg.Database); gerr != nil {
+		logger.Warn("Failed to open GORM connection, auto-remediation disabled", zap.Error(gerr))
+	} else if svc, rerr := remediation.NewService(gormDB, logger.Get(), &remediation.Config{
+		Enabled:         true,
+		DryRun:          cfg.Remediation.DryRun,
+		RequireApproval: cfg.Remediation.RequireApproval,
+		Egress:          egress,
+		Prometheus: remediation.PrometheusConfig{
+			URL:      cfg.Observability.Prometheus.URL,
+			Username: cfg.Observability.Prometheus.Username,
+			Password: cfg.Observability.Prometheus.Password,
+		},
+		EventWatch: remediation.EventWatchConfig{PodStatus: cfg.Remediation.WatchPodStatus},
+	}); rerr != nil {
+		logger.Warn("Failed to create remediation service", zap.Error(rerr))
+	} else {
+		remediationService = svc
+		defer remediationService.Stop()
+		remediationService.SetClusterOverrides(clusterConfigStore)
+		if notifyService != nil {
+			remediationService.SetNotifier(notifyService)
+		}
+		// Each scheduled rule runs on one replica
+		if redisCache != nil {
+			remediationService.SetLocks(redisCache)
+		}
+		// Replicas reload the rules each other change
+		if natsClient != nil {
+			if _, err := remediationService.FollowRuleChanges(natsClient); err != nil {
+				logger.Warn("Rule changes won't reach other replicas", zap.Error(err))
+			}
+		}
+		kubeManager.AddListener(remediationService)
+		// Opted-in cost recommendations are acted on by the rules
+		if costService != nil {
+			costService.SetRemediation(remediationService)
+		}
 	}
 
 	// Real-time hub: broadcasts cluster/app/pipeline events to dashboard clients.
 	// Runs until ctx is cancelled at shutdown.
 	wsHub := websocket.NewHub(logger.Get(), websocket.DefaultConfig())
-	go wsHub.Run(ctx)
+	runWorker(func() { wsHub.Run(ctx) })
 	wsEmitter := websocket.NewEventEmitter(wsHub)
 	clusterService.SetEventEmitter(wsEmitter)
 	gitopsService.SetEventEmitter(wsEmitter)
 	pipelineService.SetEventEmitter(wsEmitter)
 
+	// Keep cluster status current rather than only refreshing it when
+	// someone opens a cluster's health. Stops when ctx is cancelled.
+	runWorker(func() {
+		clusterService.RunHealthMonitor(ctx, cluster.HealthMonitorConfig{
+			Interval:    cfg.Kubernetes.HealthCheckInterval,
+			Concurrency: cfg.Kubernetes.HealthCheckConcurrency,
+		})
+	})
+
+	// Compare Git applications with their clusters, auto-syncing those
+	// that ask for it. Stops when ctx is cancelled.
+	if cfg.GitOps.Enabled {
+		runWorker(func() {
+			gitopsService.RunReconciler(ctx, gitops.ReconcileConfig{
+				Interval:    cfg.GitOps.SyncInterval,
+				Concurrency: cfg.GitOps.ReconcileConcurrency,
+			})
+		})
+	}
+
+	// Delete stage logs past their retention. Stops when ctx is cancelled.
+	runWorker(func() { pipelineService.RunLogRetention(ctx, cfg.Pipeline.Logs.Retention) })
+
+	// Resume runs whose replica stopped mid-run, including this one's
+	// before a restart. Stops when ctx is cancelled.
+	runWorker(func() { pipelineService.RunRecovery(ctx) })
+
 	// Create router
-	r := router.New(&router.Config{
+	rateLimit := middleware.NewRateLimit(cfg.Server.RateLimit, cfg.Server.RateBurst)
+	routerConfig := &router.Config{
 		Mode:        cfg.Server.Mode,
 		CorsOrigins: cfg.Server.CorsOrigins,
+		RateLimit:   rateLimit,
+	}
+	if cfg.Observability.Metrics.Enabled {
+		routerConfig.MetricsPath = cfg.Observability.Metrics.Path
+		routerConfig.MetricsNamespace = cfg.Observability.Metrics.Namespace
+	}
+	r := router.New(routerConfig)
+
+	// Apply config file changes, or a SIGHUP, to the subsystems that can
+	// take them without a restart. Stops when ctx is cancelled.
+	reloader := config.NewReloader(cfgFile, cfg)
+	reloader.Subscribe(config.Subscriber{
+		Name:  "logger",
+		Check: func(c *config.Config) error { _, err := zapcore.ParseLevel(c.Logger.Level); return err },
+		Apply: func(c *config.Config) { logger.SetLevel(c.Logger.Level) },
+	})
+	reloader.Subscribe(config.Subscriber{
+		Name: "rate_limit",
+		Check: func(c *config.Config) error {
+			if c.Server.RateLimit <= 0 || c.Server.RateBurst <= 0 {
+				return fmt.Errorf("server.rate_limit and server.rate_burst must be positive")
+			}
+			return nil
+		},
+		Apply: func(c *config.Config) { rateLimit.SetLimit(c.Server.RateLimit, c.Server.RateBurst) },
+	})
+	reloader.Subscribe(config.Subscriber{
+		Name: "gitops",
+		Apply: func(c *config.Config) {
+			gitopsService.UpdateReconcileConfig(gitops.ReconcileConfig{
+				Interval:    c.GitOps.SyncInterval,
+				Concurrency: c.GitOps.ReconcileConcurrency,
+			})
+		},
+	})
+	if remediationService != nil {
+		// Disabling pauses the rules; enabling a server started without
+		// remediation needs a restart
+		reloader.Subscribe(config.Subscriber{
+			Name: "remediation",
+			Apply: func(c *config.Config) {
+				remediationService.SetMode(c.Remediation.Enabled, c.Remediation.DryRun)
+			},
+		})
+	}
+	reloader.OnReload = func(*config.Config) { logger.Info("Configuration reloaded") }
+	reloader.OnError = func(err error) { logger.Error("Configuration reload failed", zap.Error(err)) }
+	runWorker(func() {
+		if err := reloader.Run(ctx); err != nil {
+			logger.Warn("Configuration hot reload disabled", zap.Error(err))
+		}
 	})
 
 	// Register routes
+	readiness := &router.Readiness{}
 	router.RegisterRoutes(r, &router.Services{
 		Cluster:       clusterService,
+		ClusterConfig: clusterConfigStore,
 		Helm:          helmService,
 		GitOps:        gitopsService,
 		Pipeline:      pipelineService,
@@ -277,8 +623,13 @@ func runServer(cmd *cobra.Command, args []string) error {
 		Security:      securityService,
 		Observability: observabilityService,
 		Hub:           wsHub,
+		Events:        eventGateway,
 		Cost:          costService,
 		RBAC:          rbacService,
+		Notify:        notifyService,
+		SCIM:          scimService,
+		Readiness:     readiness,
+		Idempotency:   cache.NewIdempotency(redisCache, cache.DefaultIdempotencyTTL),
 	})
 
 	// Start server
@@ -288,19 +639,18 @@ func runServer(cmd *cobra.Command, args []string) error {
 		zap.String("mode", cfg.Server.Mode),
 	)
 
-	// Use an explicit http.Server so we can drain in-flight requests on shutdown.
-	// r.Run() owns its own server and ignores our context, so the old code
-	// dropped connections hard on SIGTERM and raced db.Close() against handlers.
-	httpServer := &http.Server{
+	// Shutdown drains in-flight requests before the app context is
+	// cancelled, so handlers never race db.Close()
+	httpServer := router.NewServer(r, &router.ServerConfig{
 		Addr:         serverAddr,
-		Handler:      r,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
-	}
+		DrainDelay:   cfg.Server.DrainDelay,
+	}, readiness)
 
 	errChan := make(chan error, 1)
 	go func() {
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := httpServer.ListenAndServe(); err != nil {
 			errChan <- err
 		}
 	}()
@@ -314,18 +664,37 @@ func runServer(cmd *cobra.Command, args []string) error {
 		logger.Info("Received shutdown signal")
 	case err := <-errChan:
 		logger.Error("Server error", zap.Error(err))
+		cancel()
+		workers.Wait()
 		return err
 	}
 
-	// Graceful shutdown: stop accepting new requests, let in-flight finish,
-	// then cancel the app context so services/DB close cleanly afterwards.
-	logger.Info("Shutting down server...")
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	// Graceful shutdown: turn /ready not ready, stop accepting requests
+	// once load balancers have noticed, let in-flight requests finish, then
+	// stop the background workers. The database and cache close last, as
+	// deferred above.
+	logger.Info("Shutting down server...",
+		zap.Duration("drain_delay", cfg.Server.DrainDelay),
+		zap.Duration("timeout", cfg.Server.ShutdownTimeout),
+	)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(),
+		cfg.Server.DrainDelay+cfg.Server.ShutdownTimeout)
 	defer shutdownCancel()
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		logger.Error("Forced shutdown after timeout", zap.Error(err))
 	}
+
 	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		logger.Warn("Background workers did not stop before the shutdown timeout")
+	}
 
 	logger.Info("Server stopped")
 	return nil
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/anubhavg-icpl/krustron/internal/cluster"
+	"github.com/anubhavg-icpl/krustron/internal/pipeline"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newSecretStore creates the configured secret backend behind a cache.
+// The kubernetes backend needs the local cluster; without one there is no
+// store and nil is returned.
+func newSecretStore(cfg *config.Config, localClient *kube.ClusterClient, db *database.PostgresDB) (*secrets.Cached, error) {
+	var clientset kubernetes.Interface
+	if localClient != nil {
+		clientset = localClient.Clientset
+	}
+	if clientset == nil && (cfg.Secrets.Backend == "kubernetes" || cfg.Secrets.Backend == "") {
+		return nil, nil
+	}
+	store, err := secrets.New(&cfg.Secrets, cfg.Kubernetes.AgentNamespace, secrets.Backends{
+		Kubernetes: clientset,
+		DB:         db.DB,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret store: %w", err)
+	}
+	return secrets.NewCached(store, cfg.Secrets.CacheTTL), nil
+}
+
+// newFieldCipher loads the keys encrypting sensitive columns; nil when
+// none are configured
+func newFieldCipher(ctx context.Context, cfg *config.Config, store *secrets.Cached) (*secrets.FieldCipher, error) {
+	if cfg.Secrets.FieldKeysRef == "" {
+		return nil, nil
+	}
+	if store == nil {
+		return nil, fmt.Errorf("secrets.field_keys_ref needs a secret store")
+	}
+	return secrets.LoadFieldCipher(ctx, store, cfg.Secrets.FieldKeysRef, cfg.Secrets.FieldKeyID)
+}
+
+// encryptedColumns are every column a FieldCipher encrypts
+func encryptedColumns() []secrets.Column {
+	columns := append([]secrets.Column{}, cluster.EncryptedColumns...)
+	columns = append(columns, pipeline.EncryptedColumns...)
+	return append(columns, notify.EncryptedColumns...)
+}
+
+func secretsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage secrets and encrypted database columns",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rekey",
+		Short: "Re-encrypt sensitive database columns with the active field key",
+		Long: `Encrypt every value of the encrypted columns that is still in plaintext, or
+under a key other than secrets.field_key_id, with that key. To rotate keys,
+add the new key to the secret at secrets.field_keys_ref, make it
+secrets.field_key_id, restart the servers and run rekey; the old key can be
+removed from the secret once rekey reports nothing left to change.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			if cfg.Secrets.FieldKeysRef == "" {
+				return fmt.Errorf("secrets.field_keys_ref is not set; there is nothing to encrypt with")
+			}
+
+			db, err := database.NewPostgresDB(&cfg.Database)
+			if err != nil {
+				return fmt.Errorf("failed to connect to database: %w", err)
+			}
+			defer db.Close()
+			kubeManager, err := kube.NewClientManager(&cfg.Kubernetes)
+			if err != nil {
+				return fmt.Errorf("failed to create Kubernetes client manager: %w", err)
+			}
+			localClient, _ := kubeManager.GetLocalClient()
+			store, err := newSecretStore(cfg, localClient, db)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			fields, err := newFieldCipher(ctx, cfg, store)
+			if err != nil {
+				return err
+			}
+			// Notification channels are created by the server on start;
+			// create their table in case it hasn't run yet
+			gormDB, err := database.NewGormDB(&cfg.Database)
+			if err != nil {
+				return fmt.Errorf("failed to open GORM connection: %w", err)
+			}
+			if _, err := notify.NewService(gormDB, zap.NewNop(), &notify.Config{}); err != nil {
+				return err
+			}
+			for _, column := range encryptedColumns() {
+				changed, err := fields.Reencrypt(ctx, db.DB, column)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s: %d re-encrypted\n", column, changed)
+			}
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "seal <ref>",
+		Short: "Seal a secret for an environment variable",
+		Long: `Read a secret as a JSON object of strings from stdin and print the
+environment variable that holds it for the env secret backend, sealed with
+secrets.data_key (KRUSTRON_SECRETS_DATA_KEY) when one is configured.
+
+  echo '{"token":"ghp_..."}' | krustron secrets seal git-creds`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			var cipher *secrets.Cipher
+			if cfg.Secrets.DataKey != "" {
+				if cipher, err = secrets.ParseDataKey(cfg.Secrets.DataKey); err != nil {
+					return err
+				}
+			}
+
+			raw, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			var values map[string]string
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return fmt.Errorf("secret must be a JSON object of strings: %w", err)
+			}
+			data := make(map[string][]byte, len(values))
+			for k, v := range values {
+				data[k] = []byte(v)
+			}
+
+			store := secrets.NewEnv(cfg.Secrets.EnvPrefix, cipher)
+			value, err := store.Encode(args[0], data)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s=%s\n", store.Name(args[0]), value)
+			return nil
+		},
+	})
+	return cmd
+}
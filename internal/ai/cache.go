@@ -0,0 +1,248 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultVolatileCacheTTL       = 5 * time.Minute
+	defaultSemanticCacheThreshold = 0.95
+)
+
+// volatileContextFields are context entries describing live state; answers
+// built on them go stale quickly
+var volatileContextFields = []string{"logs", "events", "status", "describe", "metrics"}
+
+// cacheStopwords are dropped when normalizing questions for the cache key
+var cacheStopwords = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "my": true,
+	"please": true, "can": true, "you": true, "me": true, "i": true,
+}
+
+// AskOptions adjust how a single question is answered
+type AskOptions struct {
+	// BypassCache skips the cache lookup; the fresh answer is still cached
+	BypassCache bool `json:"bypass_cache"`
+}
+
+func askOptions(opts []AskOptions) AskOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return AskOptions{}
+}
+
+// cacheMetrics counts response cache hits, misses and entries
+var cacheMetrics = metrics.NewCache("ai")
+
+// CacheStats reports response cache counters
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`
+	SemanticHits uint64 `json:"semantic_hits"` // included in Hits
+	Misses       uint64 `json:"misses"`
+	Size         int    `json:"size"`
+}
+
+// CacheStats returns hit/miss counters and the current size of the response
+// cache
+func (s *Service) CacheStats() CacheStats {
+	size := 0
+	s.cache.Range(func(_, _ interface{}) bool {
+		size++
+		return true
+	})
+	return CacheStats{
+		Hits:         s.cacheHits.Load(),
+		SemanticHits: s.cacheSemanticHits.Load(),
+		Misses:       s.cacheMisses.Load(),
+		Size:         size,
+	}
+}
+
+type cacheEntry struct {
+	query       *Query
+	fingerprint string
+	embedding   []float32
+	expiry      time.Time
+}
+
+// cacheProbe carries what a lookup computed so a miss can be stored
+// without recomputing it
+type cacheProbe struct {
+	key         string
+	fingerprint string
+	embedding   []float32
+}
+
+// newCacheProbe keys question by its normalized form, the asking user and
+// a fingerprint of context. Answers are built from what the user may see,
+// so they are never served to another user, nor for another cluster or
+// resource.
+func newCacheProbe(userID, question string, context map[string]interface{}) *cacheProbe {
+	fingerprint := userID + "\x00" + contextFingerprint(context)
+	return &cacheProbe{
+		key:         cacheKey(normalizeQuestion(question), fingerprint),
+		fingerprint: fingerprint,
+	}
+}
+
+// getFromCache looks question up by key, then, if semantic caching is on,
+// by embedding similarity within the same user and context
+func (s *Service) getFromCache(ctx context.Context, userID, question string, context map[string]interface{}) (*Query, *cacheProbe) {
+	probe := newCacheProbe(userID, question, context)
+	now := time.Now()
+
+	if val, ok := s.cache.Load(probe.key); ok {
+		if entry := val.(*cacheEntry); now.Before(entry.expiry) {
+			s.cacheHits.Add(1)
+			cacheMetrics.Hit()
+			s.logger.Debug("AI cache hit", zap.String("key", probe.key))
+			return entry.query, probe
+		}
+		s.evict(probe.key)
+	}
+
+	if s.config.SemanticCache && s.embedder != nil {
+		vectors, err := s.embedder.Embed(ctx, []string{question})
+		if err != nil {
+			s.logger.Warn("Failed to embed question for semantic cache", zap.Error(err))
+		} else {
+			probe.embedding = vectors[0]
+			if query, ok := s.semanticLookup(probe, now); ok {
+				s.cacheHits.Add(1)
+				s.cacheSemanticHits.Add(1)
+				cacheMetrics.Hit()
+				s.logger.Debug("AI semantic cache hit", zap.String("query_id", query.ID))
+				return query, probe
+			}
+		}
+	}
+
+	s.cacheMisses.Add(1)
+	cacheMetrics.Miss()
+	s.logger.Debug("AI cache miss", zap.String("key", probe.key))
+	return nil, probe
+}
+
+// semanticLookup finds the most similar cached question in the probe's
+// scope above the similarity threshold
+func (s *Service) semanticLookup(probe *cacheProbe, now time.Time) (*Query, bool) {
+	threshold := s.config.SemanticCacheThreshold
+	if threshold <= 0 {
+		threshold = defaultSemanticCacheThreshold
+	}
+
+	var best *Query
+	bestSimilarity := threshold
+	s.cache.Range(func(key, val interface{}) bool {
+		entry := val.(*cacheEntry)
+		if !now.Before(entry.expiry) {
+			s.evict(key)
+			return true
+		}
+		if entry.fingerprint != probe.fingerprint || entry.embedding == nil {
+			return true
+		}
+		if similarity := cosineSimilarity(probe.embedding, entry.embedding); similarity >= bestSimilarity {
+			best, bestSimilarity = entry.query, similarity
+		}
+		return true
+	})
+	return best, best != nil
+}
+
+// saveToCache stores query under the probe's key for a TTL matching how
+// volatile the answer is
+func (s *Service) saveToCache(ctx context.Context, probe *cacheProbe, intent Intent, context map[string]interface{}, query *Query) {
+	if s.config.SemanticCache && s.embedder != nil && probe.embedding == nil {
+		if vectors, err := s.embedder.Embed(ctx, []string{query.Query}); err == nil {
+			probe.embedding = vectors[0]
+		}
+	}
+	entry := &cacheEntry{
+		query:       query,
+		fingerprint: probe.fingerprint,
+		embedding:   probe.embedding,
+		expiry:      time.Now().Add(s.cacheTTL(intent, context)),
+	}
+	if _, replaced := s.cache.Swap(probe.key, entry); !replaced {
+		cacheMetrics.Added()
+	}
+}
+
+// evict removes the entry at key
+func (s *Service) evict(key interface{}) {
+	if _, ok := s.cache.LoadAndDelete(key); ok {
+		cacheMetrics.Removed()
+	}
+}
+
+// cacheTTL is short for answers about live state - diagnoses, or any
+// answer built on logs, events or status - and CacheTTL otherwise
+func (s *Service) cacheTTL(intent Intent, context map[string]interface{}) time.Duration {
+	volatile := s.config.VolatileCacheTTL
+	if volatile <= 0 {
+		volatile = defaultVolatileCacheTTL
+	}
+	ttl := s.config.CacheTTL
+
+	switch intent {
+	case IntentDiagnose, IntentTroubleshoot, IntentAnalyze:
+		ttl = volatile
+	}
+	for _, field := range volatileContextFields {
+		if _, ok := context[field]; ok {
+			ttl = volatile
+		}
+	}
+
+	if ttl > s.config.CacheTTL && s.config.CacheTTL > 0 {
+		ttl = s.config.CacheTTL
+	}
+	return ttl
+}
+
+// normalizeQuestion lowercases question, strips punctuation and stopwords
+// and collapses whitespace
+func normalizeQuestion(question string) string {
+	words := strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '/' && r != '.'
+	})
+	kept := words[:0]
+	for _, word := range words {
+		word = strings.Trim(word, ".")
+		if word != "" && !cacheStopwords[word] {
+			kept = append(kept, word)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// contextFingerprint hashes the context. Maps encode with sorted keys, so
+// equal contexts give equal fingerprints.
+func contextFingerprint(context map[string]interface{}) string {
+	if len(context) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(context)
+	if err != nil {
+		// Unencodable context can't be compared; don't share its answers
+		return "unhashable:" + time.Now().String()
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func cacheKey(normalized, fingerprint string) string {
+	sum := sha256.Sum256([]byte(normalized + "\x00" + fingerprint))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newCacheTestService(config *Config) *Service {
+	config.EnableCache = true
+	if config.CacheTTL == 0 {
+		config.CacheTTL = time.Hour
+	}
+	return &Service{logger: zap.NewNop(), config: config}
+}
+
+func cacheAnswer(t *testing.T, svc *Service, question string, intent Intent, scope map[string]interface{}, response string) {
+	t.Helper()
+	ctx := context.Background()
+	cached, probe := svc.getFromCache(ctx, "alice", question, scope)
+	require.Nil(t, cached)
+	svc.saveToCache(ctx, probe, intent, scope, &Query{ID: response, Query: question, Response: response})
+}
+
+func TestCacheLookupsCounted(t *testing.T) {
+	svc := newCacheTestService(&Config{})
+	scope := map[string]interface{}{"cluster_id": "prod"}
+	hits, misses := testutil.ToFloat64(cacheMetrics.Hits), testutil.ToFloat64(cacheMetrics.Misses)
+
+	cacheAnswer(t, svc, "Why is checkout failing?", IntentTroubleshoot, scope, "oom")
+	cached, _ := svc.getFromCache(context.Background(), "alice", "Why is checkout failing?", scope)
+	require.NotNil(t, cached)
+
+	assert.Equal(t, hits+1, testutil.ToFloat64(cacheMetrics.Hits))
+	assert.Equal(t, misses+1, testutil.ToFloat64(cacheMetrics.Misses))
+}
+
+func TestCacheNormalizesQuestion(t *testing.T) {
+	svc := newCacheTestService(&Config{})
+	cluster := map[string]interface{}{"cluster_id": "prod"}
+	cacheAnswer(t, svc, "What is a PodDisruptionBudget?", IntentExplain, cluster, "pdb")
+
+	cached, _ := svc.getFromCache(context.Background(), "alice", "  what is  a poddisruptionbudget ", cluster)
+	require.NotNil(t, cached)
+	assert.Equal(t, "pdb", cached.Response)
+}
+
+func TestCacheIsScopedToContext(t *testing.T) {
+	svc := newCacheTestService(&Config{})
+	cacheAnswer(t, svc, "Why is checkout failing?", IntentExplain, map[string]interface{}{"cluster_id": "prod"}, "prod answer")
+
+	cached, _ := svc.getFromCache(context.Background(), "alice", "Why is checkout failing?", map[string]interface{}{"cluster_id": "staging"})
+	assert.Nil(t, cached, "an answer about prod must not be served for staging")
+
+	cached, _ = svc.getFromCache(context.Background(), "alice", "Why is checkout failing?", map[string]interface{}{"cluster_id": "prod"})
+	require.NotNil(t, cached)
+	assert.Equal(t, "prod answer", cached.Response)
+}
+
+func TestCacheIsScopedToUser(t *testing.T) {
+	svc := newCacheTestService(&Config{SemanticCache: true})
+	svc.SetEmbedder(&keywordEmbedder{model: "keywords-v1"})
+	cluster := map[string]interface{}{"cluster_id": "prod"}
+	cacheAnswer(t, svc, "Which secrets does checkout mount?", IntentExplain, cluster, "alice's answer")
+
+	cached, _ := svc.getFromCache(context.Background(), "bob", "Which secrets does checkout mount?", cluster)
+	assert.Nil(t, cached, "an answer built from what alice may see must not be served to bob")
+}
+
+func TestSemanticCache(t *testing.T) {
+	svc := newCacheTestService(&Config{SemanticCache: true, SemanticCacheThreshold: 0.9})
+	svc.SetEmbedder(&keywordEmbedder{model: "keywords-v1"})
+	cluster := map[string]interface{}{"cluster_id": "prod"}
+	cacheAnswer(t, svc, "How do I fix OOMKilled pods hitting the memory limit?", IntentExplain, cluster, "raise the limit")
+
+	cached, _ := svc.getFromCache(context.Background(), "alice", "pods OOMKilled at memory limit, what now", cluster)
+	require.NotNil(t, cached)
+	assert.Equal(t, "raise the limit", cached.Response)
+
+	cached, _ = svc.getFromCache(context.Background(), "alice", "pods OOMKilled at memory limit, what now", map[string]interface{}{"cluster_id": "dev"})
+	assert.Nil(t, cached, "semantic matches stay within the same context")
+
+	cached, _ = svc.getFromCache(context.Background(), "alice", "image pull from registry fails", cluster)
+	assert.Nil(t, cached)
+
+	stats := svc.CacheStats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.SemanticHits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, 1, stats.Size)
+}
+
+func TestCacheTTL(t *testing.T) {
+	svc := newCacheTestService(&Config{CacheTTL: time.Hour, VolatileCacheTTL: 2 * time.Minute})
+
+	assert.Equal(t, time.Hour, svc.cacheTTL(IntentExplain, nil))
+	assert.Equal(t, 2*time.Minute, svc.cacheTTL(IntentDiagnose, nil))
+	assert.Equal(t, 2*time.Minute, svc.cacheTTL(IntentExplain, map[string]interface{}{"events": []string{"BackOff"}}))
+
+	svc.config.CacheTTL = time.Minute
+	assert.Equal(t, time.Minute, svc.cacheTTL(IntentDiagnose, nil), "never longer than CacheTTL")
+}
+
+func TestCacheExpiry(t *testing.T) {
+	svc := newCacheTestService(&Config{})
+	entries := testutil.ToFloat64(cacheMetrics.Entries)
+	cacheAnswer(t, svc, "What is a DaemonSet?", IntentExplain, nil, "daemonset")
+	assert.Equal(t, entries+1, testutil.ToFloat64(cacheMetrics.Entries))
+
+	_, probe := svc.getFromCache(context.Background(), "alice", "What is a DaemonSet?", nil)
+	val, ok := svc.cache.Load(probe.key)
+	require.True(t, ok)
+	val.(*cacheEntry).expiry = time.Now().Add(-time.Second)
+
+	cached, _ := svc.getFromCache(context.Background(), "alice", "What is a DaemonSet?", nil)
+	assert.Nil(t, cached)
+	assert.Zero(t, svc.CacheStats().Size)
+	assert.Equal(t, entries, testutil.ToFloat64(cacheMetrics.Entries))
+}
+
+func TestNormalizeQuestion(t *testing.T) {
+	assert.Equal(t, "why pod nginx-7d9f crashing", normalizeQuestion("Why is my pod nginx-7d9f crashing?!"))
+	assert.Equal(t, normalizeQuestion("Scale deployment web to 3."), normalizeQuestion("scale  deployment web to 3"))
+}
@@ -0,0 +1,187 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultChatContextTokens = 8000
+	maxChatSummaryTokens     = 1000
+	// messageOverheadTokens approximates the role and framing tokens each
+	// message adds
+	messageOverheadTokens = 4
+
+	// Keys on ChatSession.Context holding the running summary and how many
+	// of the session's messages it covers
+	chatSummaryKey    = "summary"
+	chatSummarizedKey = "summarized_messages"
+)
+
+// chatMessages builds the conversation sent for the next reply in session:
+// the system prompt, a summary of older turns if enabled, and the most
+// recent messages that fit in ChatContextTokens. The summary is updated on
+// session.Context, to be saved with the session.
+func (s *Service) chatMessages(ctx context.Context, session *ChatSession) []ChatMessage {
+	budget := s.config.ChatContextTokens
+	if budget <= 0 {
+		budget = defaultChatContextTokens
+	}
+	summaryBudget := 0
+	if s.config.ChatSummarize {
+		summaryBudget = min(maxChatSummaryTokens, budget/4)
+	}
+
+	summary, summarized := chatSummary(session)
+	available := budget - estimateTokens(s.config.SystemPrompt) - messageOverheadTokens - summaryBudget
+
+	// Take messages from the newest back while they fit; the latest is
+	// always sent
+	start := len(session.Messages)
+	used := 0
+	for start > 0 {
+		cost := estimateTokens(session.Messages[start-1].Content) + messageOverheadTokens
+		if used+cost > available && start < len(session.Messages) {
+			break
+		}
+		used += cost
+		start--
+	}
+	// Messages already folded into the summary aren't sent again, and the
+	// window opens on a user turn
+	start = max(start, min(summarized, len(session.Messages)-1))
+	for start < len(session.Messages)-1 && session.Messages[start].Role != "user" {
+		start++
+	}
+
+	if s.config.ChatSummarize && start > summarized {
+		updated, err := s.summarizeTurns(ctx, session.UserID, summary, session.Messages[summarized:start], summaryBudget, budget/2)
+		if err != nil {
+			s.logger.Warn("Failed to summarize chat history, dropping older turns",
+				zap.String("session_id", session.ID), zap.Error(err))
+		} else {
+			summary, summarized = updated, start
+			if session.Context == nil {
+				session.Context = make(map[string]interface{})
+			}
+			session.Context[chatSummaryKey] = summary
+			session.Context[chatSummarizedKey] = summarized
+		}
+	}
+
+	system := s.config.SystemPrompt
+	if summary != "" {
+		system += "\n\nSummary of the earlier conversation:\n" + summary
+	}
+	messages := []ChatMessage{{Role: "system", Content: system}}
+	for _, msg := range session.Messages[start:] {
+		messages = append(messages, ChatMessage{Role: msg.Role, Content: msg.Content})
+	}
+	return messages
+}
+
+// summarizeTurns folds turns into summary, a chunk of at most chunkTokens
+// at a time, keeping the summary within summaryTokens
+func (s *Service) summarizeTurns(ctx context.Context, userID, summary string, turns []ChatMessage, summaryTokens, chunkTokens int) (string, error) {
+	words := summaryTokens * 3 / 4
+	for len(turns) > 0 {
+		var chunk strings.Builder
+		n := 0
+		for n < len(turns) && (n == 0 || estimateTokens(chunk.String())+estimateTokens(turns[n].Content) <= chunkTokens) {
+			fmt.Fprintf(&chunk, "%s: %s\n", turns[n].Role, turns[n].Content)
+			n++
+		}
+		turns = turns[n:]
+
+		prompt := fmt.Sprintf(`Summarize this conversation between a user and a Kubernetes assistant in at most %d words. Keep cluster, namespace and resource names, errors, findings and decisions; drop pleasantries.
+
+Summary so far:
+%s
+
+New turns:
+%s`, words, summary, chunk.String())
+		response, tokens, err := s.callProvider(ctx, prompt)
+		if err != nil {
+			return "", err
+		}
+		s.recordUsage(ctx, userID, tokens)
+		summary = strings.TrimSpace(response)
+	}
+	return summary, nil
+}
+
+// chatSummary reads the running summary off session.Context. Numbers come
+// back from JSON as float64.
+func chatSummary(session *ChatSession) (string, int) {
+	summary, _ := session.Context[chatSummaryKey].(string)
+	var summarized int
+	switch n := session.Context[chatSummarizedKey].(type) {
+	case int:
+		summarized = n
+	case float64:
+		summarized = int(n)
+	}
+	return summary, min(summarized, len(session.Messages))
+}
+
+// promptMessages wraps a single prompt as a conversation
+func promptMessages(prompt string) []ChatMessage {
+	return []ChatMessage{{Role: "user", Content: prompt}}
+}
+
+// openAIMessages converts messages to the chat completions format
+func openAIMessages(messages []ChatMessage) []map[string]string {
+	out := make([]map[string]string, 0, len(messages))
+	for _, msg := range messages {
+		out = append(out, map[string]string{"role": msg.Role, "content": msg.Content})
+	}
+	return out
+}
+
+// anthropicMessages splits messages into the Messages API's top-level
+// system prompt and its turns, merging consecutive turns of the same role
+// since the API requires them to alternate
+func anthropicMessages(messages []ChatMessage) (string, []map[string]string) {
+	var system []string
+	var turns []map[string]string
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			system = append(system, msg.Content)
+			continue
+		}
+		if n := len(turns); n > 0 && turns[n-1]["role"] == msg.Role {
+			turns[n-1]["content"] += "\n\n" + msg.Content
+			continue
+		}
+		turns = append(turns, map[string]string{"role": msg.Role, "content": msg.Content})
+	}
+	return strings.Join(system, "\n\n"), turns
+}
+
+// flattenMessages renders messages as a single prompt for providers that
+// take one
+func flattenMessages(messages []ChatMessage) string {
+	if len(messages) == 1 && messages[0].Role == "user" {
+		return messages[0].Content
+	}
+	var b strings.Builder
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			b.WriteString(msg.Content + "\n\n")
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\n", msg.Role, msg.Content)
+	}
+	return b.String()
+}
+
+func countMessageTokens(messages []ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += estimateTokens(msg.Content) + messageOverheadTokens
+	}
+	return total
+}
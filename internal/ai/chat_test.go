@@ -0,0 +1,142 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chatServer is an OpenAI-style endpoint that answers summarization
+// requests with a short summary and everything else with a fixed reply,
+// keeping the messages of each request
+type chatServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  [][]ChatMessage
+	summaries int
+}
+
+func newChatServer(t *testing.T) *chatServer {
+	cs := &chatServer{}
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body struct {
+			Messages []ChatMessage `json:"messages"`
+		}
+		_ = json.Unmarshal(raw, &body)
+
+		cs.mu.Lock()
+		reply := "Noted."
+		if strings.HasPrefix(body.Messages[len(body.Messages)-1].Content, "Summarize this conversation") {
+			cs.summaries++
+			reply = fmt.Sprintf("Summary %d: the user is debugging checkout in prod.", cs.summaries)
+		} else {
+			cs.requests = append(cs.requests, body.Messages)
+		}
+		cs.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, openAIContent(t, reply))
+	}))
+	t.Cleanup(cs.Close)
+	return cs
+}
+
+func TestChatHistoryStaysWithinBudget(t *testing.T) {
+	const budget = 600
+	server := newChatServer(t)
+	svc := newUsageTestService(t, &Config{ChatContextTokens: budget, ChatSummarize: true, PerUserRPM: 1000, RateLimitRPM: 1000})
+	svc.config.Endpoint = server.URL
+	ctx := context.Background()
+
+	session, err := svc.CreateChatSession(ctx, "alice", "checkout")
+	require.NoError(t, err)
+
+	for i := 0; i < 40; i++ {
+		message := fmt.Sprintf("turn %d: checkout pod %d in prod restarted again, here are the last log lines: %s",
+			i, i, strings.Repeat("connection refused to payments:8443 ", 4))
+		_, err := svc.SendChatMessage(ctx, session.ID, message)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, server.requests, 40)
+	for i, messages := range server.requests {
+		assert.LessOrEqual(t, countMessageTokens(messages), budget, "request %d is over budget", i)
+		assert.Equal(t, "system", messages[0].Role)
+		assert.Equal(t, "user", messages[1].Role, "the window opens on a user turn")
+	}
+
+	last := server.requests[len(server.requests)-1]
+	assert.Contains(t, last[0].Content, "Summary of the earlier conversation:")
+	assert.Contains(t, last[len(last)-1].Content, "turn 39:")
+	for _, msg := range last {
+		assert.NotContains(t, msg.Content, "turn 0:", "the oldest turns are only in the summary")
+	}
+
+	saved, err := svc.GetChatSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Len(t, saved.Messages, 80)
+	assert.Equal(t, fmt.Sprintf("Summary %d: the user is debugging checkout in prod.", server.summaries), saved.Context[chatSummaryKey])
+	summary, summarized := chatSummary(saved)
+	assert.NotEmpty(t, summary)
+	assert.Greater(t, summarized, 0)
+}
+
+func TestChatHistoryWithoutSummaryDropsOldTurns(t *testing.T) {
+	svc := newStructuredTestService(ProviderOpenAI, "")
+	svc.config.ChatContextTokens = 200
+
+	session := &ChatSession{}
+	for i := 0; i < 20; i++ {
+		session.Messages = append(session.Messages,
+			ChatMessage{Role: "user", Content: fmt.Sprintf("question %d %s", i, strings.Repeat("x", 80))},
+			ChatMessage{Role: "assistant", Content: fmt.Sprintf("answer %d %s", i, strings.Repeat("y", 80))},
+		)
+	}
+	session.Messages = append(session.Messages, ChatMessage{Role: "user", Content: "latest"})
+
+	messages := svc.chatMessages(context.Background(), session)
+	assert.LessOrEqual(t, countMessageTokens(messages), 200)
+	assert.Equal(t, "latest", messages[len(messages)-1].Content)
+	assert.Equal(t, "user", messages[1].Role)
+	assert.Empty(t, session.Context, "no summary without ChatSummarize")
+}
+
+func TestAnthropicMessages(t *testing.T) {
+	system, turns := anthropicMessages([]ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Pod is pending."},
+		{Role: "user", Content: "It's in prod."},
+		{Role: "assistant", Content: "Check node capacity."},
+	})
+	assert.Equal(t, "Be brief.", system)
+	assert.Equal(t, []map[string]string{
+		{"role": "user", "content": "Pod is pending.\n\nIt's in prod."},
+		{"role": "assistant", "content": "Check node capacity."},
+	}, turns)
+}
+
+func TestCallAnthropicSendsStructuredMessages(t *testing.T) {
+	server := newRecordingServer(t, `{"content":[{"type":"text","text":"Scale the node pool."}],"usage":{"input_tokens":30,"output_tokens":5}}`)
+	svc := newStructuredTestService(ProviderAnthropic, server.URL)
+
+	response, _, err := svc.callProviderMessages(context.Background(), []ChatMessage{
+		{Role: "system", Content: "You are a test assistant."},
+		{Role: "user", Content: "Pods are pending."},
+		{Role: "assistant", Content: "Which namespace?"},
+		{Role: "user", Content: "shop"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Scale the node pool.", response)
+	assert.Equal(t, "You are a test assistant.", server.body["system"])
+	assert.Len(t, server.body["messages"], 3)
+}
@@ -0,0 +1,127 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Embedder turns texts into embedding vectors
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model identifies the embedding model, so documents embedded with a
+	// different one can be re-indexed
+	Model() string
+}
+
+// httpEmbedder calls an OpenAI, Azure OpenAI or Ollama embeddings API
+type httpEmbedder struct {
+	provider Provider
+	endpoint string
+	apiKey   func(ctx context.Context) (string, error)
+	model    string
+	client   *http.Client
+}
+
+// newEmbedder builds the embedder described by config, defaulting to the
+// chat provider's endpoint family and to chatKey
+func newEmbedder(config *Config, client *http.Client, chatKey func(ctx context.Context) (string, error)) (Embedder, error) {
+	e := &httpEmbedder{
+		provider: config.EmbeddingProvider,
+		endpoint: config.EmbeddingEndpoint,
+		apiKey:   chatKey,
+		model:    config.EmbeddingModel,
+		client:   client,
+	}
+	if e.provider == "" {
+		e.provider = config.Provider
+	}
+	if key := config.EmbeddingAPIKey; key != "" {
+		e.apiKey = func(context.Context) (string, error) { return key, nil }
+	}
+
+	switch e.provider {
+	case ProviderOllama:
+		if e.endpoint == "" {
+			e.endpoint = "http://localhost:11434/api/embeddings"
+		}
+		if e.model == "" {
+			e.model = "nomic-embed-text"
+		}
+	case ProviderAzure:
+		// Azure embeddings live on their own deployment
+		if e.endpoint == "" {
+			return nil, fmt.Errorf("azure embeddings require EmbeddingEndpoint")
+		}
+	case ProviderOpenAI, "":
+		e.provider = ProviderOpenAI
+		if e.endpoint == "" {
+			e.endpoint = "https://api.openai.com/v1/embeddings"
+		}
+		if e.model == "" {
+			e.model = "text-embedding-3-small"
+		}
+	default:
+		return nil, fmt.Errorf("embeddings not supported for provider: %s", e.provider)
+	}
+	return e, nil
+}
+
+func (e *httpEmbedder) Model() string { return string(e.provider) + "/" + e.model }
+
+func (e *httpEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.provider == ProviderOllama {
+		// Ollama embeds one prompt per request
+		vectors := make([][]float32, 0, len(texts))
+		for _, text := range texts {
+			var result struct {
+				Embedding []float32 `json:"embedding"`
+			}
+			if err := e.post(ctx, map[string]interface{}{"model": e.model, "prompt": text}, nil, &result); err != nil {
+				return nil, err
+			}
+			vectors = append(vectors, result.Embedding)
+		}
+		return vectors, nil
+	}
+
+	requestBody := map[string]interface{}{"input": texts}
+	key, err := e.apiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{"api-key": key}
+	if e.provider == ProviderOpenAI {
+		requestBody["model"] = e.model
+		headers = map[string]string{"Authorization": "Bearer " + key}
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := e.post(ctx, requestBody, headers, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(result.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+func (e *httpEmbedder) post(ctx context.Context, requestBody interface{}, headers map[string]string, result interface{}) error {
+	if err := postJSON(ctx, e.client, e.endpoint, headers, requestBody, result); err != nil {
+		return fmt.Errorf("embedding request failed: %w", err)
+	}
+	return nil
+}
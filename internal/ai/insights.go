@@ -0,0 +1,353 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInsightInterval = time.Hour
+	defaultInsightTTL      = 7 * 24 * time.Hour
+	// insightLookback is how far back the generator reads diagnoses and
+	// recommendations
+	insightLookback = 24 * time.Hour
+	// recurringDiagnoses is how many diagnoses of one resource within the
+	// lookback make a recurring issue; criticalDiagnoses make it critical
+	recurringDiagnoses = 2
+	criticalDiagnoses  = 5
+	// costInsightSavings is the monthly saving above which a pending
+	// recommendation is raised as an insight regardless of impact
+	costInsightSavings = 100
+	maxInsightSources  = 1000
+)
+
+// Insight types raised by the generator
+const (
+	InsightRecurringIssue  = "recurring_issue"
+	InsightCostOpportunity = "cost_opportunity"
+)
+
+var insightSeverities = map[string]bool{"critical": true, "warning": true, "info": true}
+
+// InsightFilter narrows ListInsights. Expired insights are never listed.
+type InsightFilter struct {
+	Type     string
+	Severity string
+	// Acked, if set, lists only acknowledged (true) or unacknowledged
+	// (false) insights
+	Acked  *bool
+	Limit  int
+	Offset int
+}
+
+// CreateInsight stores an insight. Severity defaults to info; an insight
+// without ExpiresAt never expires.
+func (s *Service) CreateInsight(ctx context.Context, insight *Insight) error {
+	if strings.TrimSpace(insight.Title) == "" {
+		return fmt.Errorf("insight title is required")
+	}
+	if insight.Severity == "" {
+		insight.Severity = "info"
+	}
+	if !insightSeverities[insight.Severity] {
+		return fmt.Errorf("invalid insight severity: %s", insight.Severity)
+	}
+
+	insight.ID = uuid.New().String()
+	insight.AckedAt = nil
+	insight.AckedBy = ""
+	insight.CreatedAt = time.Now()
+
+	if err := s.db.WithContext(ctx).Create(insight).Error; err != nil {
+		return fmt.Errorf("failed to create insight: %w", err)
+	}
+	return nil
+}
+
+// ListInsights lists unexpired insights, newest first
+func (s *Service) ListInsights(ctx context.Context, filter InsightFilter) ([]Insight, error) {
+	q := s.db.WithContext(ctx).Model(&Insight{}).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now())
+	if filter.Type != "" {
+		q = q.Where("type = ?", filter.Type)
+	}
+	if filter.Severity != "" {
+		q = q.Where("severity = ?", filter.Severity)
+	}
+	if filter.Acked != nil {
+		if *filter.Acked {
+			q = q.Where("acked_at IS NOT NULL")
+		} else {
+			q = q.Where("acked_at IS NULL")
+		}
+	}
+	if filter.Limit > 0 {
+		q = q.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		q = q.Offset(filter.Offset)
+	}
+
+	var insights []Insight
+	if err := q.Order("created_at DESC").Find(&insights).Error; err != nil {
+		return nil, fmt.Errorf("failed to list insights: %w", err)
+	}
+	return insights, nil
+}
+
+// AckInsight acknowledges an insight. Acknowledging it again is a no-op
+// that keeps the first acknowledgement.
+func (s *Service) AckInsight(ctx context.Context, id, userID string) (*Insight, error) {
+	now := time.Now()
+	if err := s.db.WithContext(ctx).Model(&Insight{}).
+		Where("id = ? AND acked_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"acked_at": now,
+			"acked_by": userID,
+		}).Error; err != nil {
+		return nil, fmt.Errorf("failed to acknowledge insight: %w", err)
+	}
+
+	var insight Insight
+	if err := s.db.WithContext(ctx).First(&insight, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("insight not found: %w", err)
+	}
+	return &insight, nil
+}
+
+// RecommendationSource supplies recommendations made outside the AI
+// service, such as the cost service's rightsizing, for cost insights
+type RecommendationSource interface {
+	// PendingRecommendations returns recommendations created since since
+	// and not yet applied
+	PendingRecommendations(ctx context.Context, since time.Time) ([]Recommendation, error)
+}
+
+// SetRecommendationSource sets where cost insights find recommendations
+// besides the AI's own. Optional: nil-safe.
+func (s *Service) SetRecommendationSource(src RecommendationSource) { s.recommendations = src }
+
+// RunInsights deletes expired insights and derives new ones every
+// InsightInterval until ctx is cancelled
+func (s *Service) RunInsights(ctx context.Context) {
+	interval := s.config.InsightInterval
+	if interval <= 0 {
+		interval = defaultInsightInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.sweepInsights(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Warn("Failed to sweep expired insights", zap.Error(err))
+			}
+			if _, err := s.GenerateInsights(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Warn("Failed to generate insights", zap.Error(err))
+			}
+		}
+	}
+}
+
+// sweepInsights deletes insights past ExpiresAt and returns how many
+func (s *Service) sweepInsights(ctx context.Context) (int64, error) {
+	result := s.db.WithContext(ctx).
+		Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).
+		Delete(&Insight{})
+	return result.RowsAffected, result.Error
+}
+
+// GenerateInsights derives insights from the last day's diagnoses and
+// pending recommendations: resources diagnosed repeatedly, and
+// recommendations with high impact or large savings. An insight already
+// raised and not yet expired isn't raised again. It returns how many were
+// created.
+func (s *Service) GenerateInsights(ctx context.Context) (int, error) {
+	since := time.Now().Add(-insightLookback)
+
+	recurring, err := s.recurringIssueInsights(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+	cost, err := s.costInsights(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+
+	ttl := s.config.InsightTTL
+	if ttl <= 0 {
+		ttl = defaultInsightTTL
+	}
+	created := 0
+	for _, insight := range append(recurring, cost...) {
+		var existing int64
+		if err := s.db.WithContext(ctx).Model(&Insight{}).
+			Where("fingerprint = ? AND (expires_at IS NULL OR expires_at > ?)", insight.Fingerprint, time.Now()).
+			Count(&existing).Error; err != nil {
+			return created, err
+		}
+		if existing > 0 {
+			continue
+		}
+
+		expires := time.Now().Add(ttl)
+		insight.ExpiresAt = &expires
+		if err := s.CreateInsight(ctx, insight); err != nil {
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// recurringIssueInsights finds resources diagnosed more than once since
+// since. Diagnoses are the queries DiagnoseIssue records, whose context
+// names the resource.
+func (s *Service) recurringIssueInsights(ctx context.Context, since time.Time) ([]*Insight, error) {
+	var queries []Query
+	if err := s.db.WithContext(ctx).
+		Where("created_at >= ?", since).
+		Order("created_at DESC").
+		Limit(maxInsightSources).
+		Find(&queries).Error; err != nil {
+		return nil, fmt.Errorf("failed to load diagnoses: %w", err)
+	}
+
+	type resourceIssues struct {
+		key       string
+		resource  string
+		queryIDs  []string
+		rootCause string
+	}
+	byResource := make(map[string]*resourceIssues)
+	for _, q := range queries {
+		name, _ := q.Context["resource_name"].(string)
+		kind, _ := q.Context["resource_type"].(string)
+		if name == "" || kind == "" {
+			continue
+		}
+		cluster, _ := q.Context["cluster"].(string)
+		namespace, _ := q.Context["namespace"].(string)
+
+		key := strings.Join([]string{cluster, namespace, kind, name}, "/")
+		issues, ok := byResource[key]
+		if !ok {
+			issues = &resourceIssues{key: key, resource: fmt.Sprintf("%s %s/%s", kind, namespace, name)}
+			if cluster != "" {
+				issues.resource += " in " + cluster
+			}
+			// Queries are newest first; keep the latest root cause
+			issues.rootCause = s.extractRootCause(q.Response)
+			byResource[key] = issues
+		}
+		issues.queryIDs = append(issues.queryIDs, q.ID)
+	}
+
+	var insights []*Insight
+	for _, issues := range byResource {
+		count := len(issues.queryIDs)
+		if count < recurringDiagnoses {
+			continue
+		}
+		severity := "warning"
+		if count >= criticalDiagnoses {
+			severity = "critical"
+		}
+		description := fmt.Sprintf("%s was diagnosed %d times in the last day.", issues.resource, count)
+		if issues.rootCause != "" {
+			description += " Latest root cause: " + issues.rootCause
+		}
+		insights = append(insights, &Insight{
+			Type:        InsightRecurringIssue,
+			Severity:    severity,
+			Title:       "Recurring issue on " + issues.resource,
+			Description: description,
+			Evidence: map[string]interface{}{
+				"diagnoses": count,
+				"query_ids": issues.queryIDs,
+			},
+			Actions:     []string{"Review the recent diagnoses and fix the root cause rather than the symptom"},
+			Resources:   []string{issues.key},
+			Fingerprint: insightFingerprint(InsightRecurringIssue, issues.key),
+		})
+	}
+	sort.Slice(insights, func(i, j int) bool { return insights[i].Title < insights[j].Title })
+	return insights, nil
+}
+
+// costInsights raises pending recommendations created since since, the
+// AI's own and the recommendation source's, that have high impact or save
+// at least costInsightSavings a month
+func (s *Service) costInsights(ctx context.Context, since time.Time) ([]*Insight, error) {
+	var recommendations []Recommendation
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND created_at >= ? AND (impact = ? OR savings >= ?)", "pending", since, "high", costInsightSavings).
+		Order("savings DESC").
+		Limit(maxInsightSources).
+		Find(&recommendations).Error; err != nil {
+		return nil, fmt.Errorf("failed to load recommendations: %w", err)
+	}
+	if s.recommendations != nil {
+		external, err := s.recommendations.PendingRecommendations(ctx, since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load recommendations: %w", err)
+		}
+		for _, rec := range external {
+			if rec.Impact == "high" || rec.Savings >= costInsightSavings {
+				recommendations = append(recommendations, rec)
+			}
+		}
+		sort.SliceStable(recommendations, func(i, j int) bool { return recommendations[i].Savings > recommendations[j].Savings })
+		if len(recommendations) > maxInsightSources {
+			recommendations = recommendations[:maxInsightSources]
+		}
+	}
+
+	insights := make([]*Insight, 0, len(recommendations))
+	for _, rec := range recommendations {
+		severity := "info"
+		if rec.Impact == "high" {
+			severity = "warning"
+		}
+		description := rec.Description
+		if rec.Savings > 0 {
+			description = fmt.Sprintf("Estimated savings $%.2f/month. %s", rec.Savings, description)
+		}
+		var resources []string
+		if rec.ResourceID != "" {
+			resources = []string{rec.ResourceID}
+		}
+		insights = append(insights, &Insight{
+			Type:        InsightCostOpportunity,
+			Severity:    severity,
+			Title:       rec.Title,
+			Description: strings.TrimSpace(description),
+			Evidence: map[string]interface{}{
+				"recommendation_id": rec.ID,
+				"savings":           rec.Savings,
+				"impact":            rec.Impact,
+				"effort":            rec.Effort,
+			},
+			Actions:     []string{"Review and apply recommendation " + rec.ID},
+			Resources:   resources,
+			Fingerprint: insightFingerprint(InsightCostOpportunity, rec.ID),
+		})
+	}
+	return insights, nil
+}
+
+func insightFingerprint(insightType, key string) string {
+	sum := sha256.Sum256([]byte(insightType + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,162 @@
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInsightTestService(t *testing.T) *Service {
+	svc := newUsageTestService(t, &Config{})
+	return svc
+}
+
+func TestAckInsightIdempotent(t *testing.T) {
+	svc := newInsightTestService(t)
+	ctx := context.Background()
+
+	insight := &Insight{Type: "security", Severity: "critical", Title: "Privileged pods in prod"}
+	require.NoError(t, svc.CreateInsight(ctx, insight))
+
+	acked, err := svc.AckInsight(ctx, insight.ID, "alice")
+	require.NoError(t, err)
+	require.NotNil(t, acked.AckedAt)
+	assert.Equal(t, "alice", acked.AckedBy)
+
+	again, err := svc.AckInsight(ctx, insight.ID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", again.AckedBy, "the first acknowledgement stands")
+	assert.True(t, acked.AckedAt.Equal(*again.AckedAt))
+
+	_, err = svc.AckInsight(ctx, "missing", "alice")
+	assert.Error(t, err)
+}
+
+func TestListInsightsFilters(t *testing.T) {
+	svc := newInsightTestService(t)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	for _, insight := range []*Insight{
+		{Type: "security", Severity: "critical", Title: "Privileged pods"},
+		{Type: "security", Severity: "warning", Title: "Missing network policies"},
+		{Type: "cost", Severity: "info", Title: "Idle node pool"},
+		{Type: "cost", Severity: "info", Title: "Expired", ExpiresAt: &past},
+	} {
+		require.NoError(t, svc.CreateInsight(ctx, insight))
+	}
+	all, err := svc.ListInsights(ctx, InsightFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 3, "expired insights are hidden")
+
+	for _, insight := range all {
+		if insight.Title == "Missing network policies" {
+			_, err := svc.AckInsight(ctx, insight.ID, "alice")
+			require.NoError(t, err)
+		}
+	}
+
+	unacked := false
+	open, err := svc.ListInsights(ctx, InsightFilter{Acked: &unacked})
+	require.NoError(t, err)
+	assert.Len(t, open, 2)
+
+	security, err := svc.ListInsights(ctx, InsightFilter{Type: "security", Acked: &unacked})
+	require.NoError(t, err)
+	require.Len(t, security, 1)
+	assert.Equal(t, "Privileged pods", security[0].Title)
+
+	critical, err := svc.ListInsights(ctx, InsightFilter{Severity: "critical"})
+	require.NoError(t, err)
+	assert.Len(t, critical, 1)
+
+	swept, err := svc.sweepInsights(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), swept)
+
+	assert.Error(t, svc.CreateInsight(ctx, &Insight{Title: "x", Severity: "urgent"}))
+}
+
+func TestGenerateInsights(t *testing.T) {
+	svc := newInsightTestService(t)
+	ctx := context.Background()
+
+	diagnosis := map[string]interface{}{
+		"resource_type": "Deployment", "resource_name": "checkout", "namespace": "shop", "cluster": "prod",
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, svc.db.Create(&Query{
+			ID:        "q" + string(rune('a'+i)),
+			Query:     "Diagnose checkout",
+			Context:   diagnosis,
+			Response:  "Root cause: the payments service is unreachable",
+			CreatedAt: time.Now().Add(-time.Duration(i) * time.Hour),
+		}).Error)
+	}
+	// A single diagnosis isn't recurring
+	require.NoError(t, svc.db.Create(&Query{
+		ID:        "qz",
+		Context:   map[string]interface{}{"resource_type": "Pod", "resource_name": "web-1", "namespace": "shop"},
+		CreatedAt: time.Now(),
+	}).Error)
+
+	for _, rec := range []Recommendation{
+		{ID: "r1", Title: "Rightsize checkout", Impact: "medium", Savings: 250, Status: "pending", CreatedAt: time.Now()},
+		{ID: "r2", Title: "Tiny saving", Impact: "low", Savings: 5, Status: "pending", CreatedAt: time.Now()},
+		{ID: "r3", Title: "Already applied", Impact: "high", Savings: 900, Status: "applied", CreatedAt: time.Now()},
+	} {
+		require.NoError(t, svc.db.Create(&rec).Error)
+	}
+
+	created, err := svc.GenerateInsights(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, created)
+
+	insights, err := svc.ListInsights(ctx, InsightFilter{})
+	require.NoError(t, err)
+	byType := map[string]Insight{}
+	for _, insight := range insights {
+		byType[insight.Type] = insight
+		require.NotNil(t, insight.ExpiresAt)
+	}
+	recurring := byType[InsightRecurringIssue]
+	assert.Equal(t, "warning", recurring.Severity)
+	assert.Contains(t, recurring.Description, "diagnosed 3 times")
+	assert.Contains(t, recurring.Description, "payments service is unreachable")
+	assert.Equal(t, "Rightsize checkout", byType[InsightCostOpportunity].Title)
+
+	created, err = svc.GenerateInsights(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, created, "insights aren't raised twice")
+}
+
+// staticRecommendations is a RecommendationSource returning fixed
+// recommendations
+type staticRecommendations []Recommendation
+
+func (r staticRecommendations) PendingRecommendations(ctx context.Context, since time.Time) ([]Recommendation, error) {
+	return r, nil
+}
+
+func TestGenerateInsightsFromRecommendationSource(t *testing.T) {
+	svc := newInsightTestService(t)
+	ctx := context.Background()
+	svc.SetRecommendationSource(staticRecommendations{
+		{ID: "rs-1", Title: "Release idle GPUs of Deployment ml/train", Impact: "high", Savings: 40, Status: "pending"},
+		{ID: "rs-2", Title: "Rightsize Deployment shop/web", Impact: "medium", Savings: 20, Status: "pending"},
+	})
+
+	created, err := svc.GenerateInsights(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, created, "low savings without high impact aren't raised")
+
+	insights, err := svc.ListInsights(ctx, InsightFilter{Type: InsightCostOpportunity})
+	require.NoError(t, err)
+	require.Len(t, insights, 1)
+	assert.Equal(t, "Release idle GPUs of Deployment ml/train", insights[0].Title)
+	assert.Equal(t, "warning", insights[0].Severity)
+	assert.Equal(t, "rs-1", insights[0].Evidence["recommendation_id"])
+}
@@ -0,0 +1,235 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/sigv4"
+)
+
+const (
+	defaultAzureAPIVersion  = "2024-02-01"
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+)
+
+// azureURL returns the chat completions URL of the configured Azure OpenAI
+// deployment. Endpoint, if set, replaces https://<resource>.openai.azure.com.
+func (s *Service) azureURL() (string, error) {
+	if s.config.AzureDeployment == "" {
+		return "", fmt.Errorf("azure deployment not configured")
+	}
+	base := strings.TrimSuffix(s.config.Endpoint, "/")
+	if base == "" {
+		if s.config.AzureResource == "" {
+			return "", fmt.Errorf("azure resource not configured")
+		}
+		base = "https://" + s.config.AzureResource + ".openai.azure.com"
+	}
+
+	apiVersion := s.config.AzureAPIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAzureAPIVersion
+	}
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		base, url.PathEscape(s.config.AzureDeployment), url.QueryEscape(apiVersion)), nil
+}
+
+// callAzure calls a chat completions deployment on Azure OpenAI, which
+// takes the model from the deployment rather than the request body
+func (s *Service) callAzure(ctx context.Context, messages []ChatMessage) (string, int, error) {
+	endpoint, err := s.azureURL()
+	if err != nil {
+		return "", 0, err
+	}
+
+	requestBody := map[string]interface{}{
+		"messages":    openAIMessages(messages),
+		"max_tokens":  s.config.MaxTokens,
+		"temperature": s.config.Temperature,
+		"top_p":       s.config.TopP,
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("api-key", key)
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := s.doProviderRequest(req, &result); err != nil {
+		return "", 0, err
+	}
+
+	if len(result.Choices) == 0 {
+		return "", 0, fmt.Errorf("no response from AI")
+	}
+
+	return result.Choices[0].Message.Content, result.Usage.TotalTokens, nil
+}
+
+// callBedrock invokes the configured model through the Bedrock Runtime
+// InvokeModel API. Anthropic Claude and Amazon Titan text models are
+// supported; each takes its own request body.
+func (s *Service) callBedrock(ctx context.Context, messages []ChatMessage) (string, int, error) {
+	if s.config.BedrockRegion == "" {
+		return "", 0, fmt.Errorf("bedrock region not configured")
+	}
+	if s.config.AWSAccessKeyID == "" || s.config.AWSSecretAccessKey == "" {
+		return "", 0, fmt.Errorf("bedrock credentials not configured")
+	}
+
+	model := s.config.Model
+	var requestBody map[string]interface{}
+	switch {
+	case strings.Contains(model, "anthropic.claude"):
+		system, turns := anthropicMessages(messages)
+		requestBody = map[string]interface{}{
+			"anthropic_version": bedrockAnthropicVersion,
+			"max_tokens":        s.config.MaxTokens,
+			"temperature":       s.config.Temperature,
+			"top_p":             s.config.TopP,
+			"messages":          turns,
+		}
+		if system != "" {
+			requestBody["system"] = system
+		}
+	case strings.Contains(model, "amazon.titan"):
+		requestBody = map[string]interface{}{
+			"inputText": flattenMessages(messages),
+			"textGenerationConfig": map[string]interface{}{
+				"maxTokenCount": s.config.MaxTokens,
+				"temperature":   s.config.Temperature,
+				"topP":          s.config.TopP,
+			},
+		}
+	default:
+		return "", 0, fmt.Errorf("unsupported bedrock model: %s", model)
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", 0, err
+	}
+
+	base := strings.TrimSuffix(s.config.Endpoint, "/")
+	if base == "" {
+		base = "https://bedrock-runtime." + s.config.BedrockRegion + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/model/"+sigv4.Escape(model)+"/invoke", bytes.NewBuffer(body))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	sigv4.SignRequest(req, body, sigv4.Credentials{
+		AccessKeyID:     s.config.AWSAccessKeyID,
+		SecretAccessKey: s.config.AWSSecretAccessKey,
+		SessionToken:    s.config.AWSSessionToken,
+	}, s.config.BedrockRegion, "bedrock", time.Now())
+
+	if strings.Contains(model, "amazon.titan") {
+		var result struct {
+			InputTextTokenCount int `json:"inputTextTokenCount"`
+			Results             []struct {
+				TokenCount int    `json:"tokenCount"`
+				OutputText string `json:"outputText"`
+			} `json:"results"`
+		}
+		if err := s.doProviderRequest(req, &result); err != nil {
+			return "", 0, err
+		}
+		if len(result.Results) == 0 {
+			return "", 0, fmt.Errorf("no response from AI")
+		}
+		return result.Results[0].OutputText, result.InputTextTokenCount + result.Results[0].TokenCount, nil
+	}
+
+	var result struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := s.doProviderRequest(req, &result); err != nil {
+		return "", 0, err
+	}
+	if len(result.Content) == 0 {
+		return "", 0, fmt.Errorf("no response from AI")
+	}
+
+	return result.Content[0].Text, result.Usage.InputTokens + result.Usage.OutputTokens, nil
+}
+
+// doProviderRequest sends req and decodes a successful JSON response into
+// result
+func (s *Service) doProviderRequest(req *http.Request, result interface{}) error {
+	return doJSON(s.httpClient, req, result)
+}
+
+// postJSON posts requestBody as JSON with headers and decodes the response
+// into result
+func postJSON(ctx context.Context, client *http.Client, endpoint string, headers map[string]string, requestBody interface{}, result interface{}) error {
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return doJSON(client, req, result)
+}
+
+// doJSON sends req and decodes a successful JSON response into result
+func doJSON(client *http.Client, req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API error: %s - %s", resp.Status, string(body))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,173 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingServer answers every request with response and keeps the last
+// request and its body
+type recordingServer struct {
+	*httptest.Server
+	req  *http.Request
+	body map[string]interface{}
+}
+
+func newRecordingServer(t *testing.T, response string) *recordingServer {
+	rs := &recordingServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		rs.req = r
+		rs.body = nil
+		_ = json.Unmarshal(raw, &rs.body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func TestCallAzure(t *testing.T) {
+	server := newRecordingServer(t, `{"choices":[{"message":{"content":"Restart the pod"}}],"usage":{"total_tokens":31}}`)
+	svc := &Service{
+		config: &Config{
+			Provider:        ProviderAzure,
+			APIKey:          "azure-key",
+			Endpoint:        server.URL,
+			AzureDeployment: "gpt-4o-prod",
+			MaxTokens:       256,
+		},
+		httpClient: server.Client(),
+	}
+
+	response, tokens, err := svc.callProvider(context.Background(), "why")
+	require.NoError(t, err)
+	assert.Equal(t, "Restart the pod", response)
+	assert.Equal(t, 31, tokens)
+
+	assert.Equal(t, "/openai/deployments/gpt-4o-prod/chat/completions", server.req.URL.Path)
+	assert.Equal(t, defaultAzureAPIVersion, server.req.URL.Query().Get("api-version"))
+	assert.Equal(t, "azure-key", server.req.Header.Get("api-key"))
+	assert.Empty(t, server.req.Header.Get("Authorization"))
+	assert.NotContains(t, server.body, "model", "the deployment selects the model")
+	assert.Equal(t, 256.0, server.body["max_tokens"])
+}
+
+// mapSecrets is a secret store of fixed secrets
+type mapSecrets map[string]map[string][]byte
+
+func (m mapSecrets) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	data, ok := m[ref]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", ref)
+	}
+	return data, nil
+}
+
+func TestAPIKeyFromSecretStore(t *testing.T) {
+	server := newRecordingServer(t, `{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":1,"output_tokens":1}}`)
+	svc := &Service{
+		config: &Config{
+			Provider:  ProviderAnthropic,
+			APIKey:    "ignored",
+			APIKeyRef: "ai-provider",
+			Endpoint:  server.URL,
+		},
+		httpClient: server.Client(),
+	}
+
+	_, _, err := svc.callProvider(context.Background(), "why")
+	assert.EqualError(t, err, "no secret store configured for AI API key ai-provider")
+
+	secrets := mapSecrets{"ai-provider": {"api_key": []byte("sk-ant-1")}}
+	svc.SetSecretStore(secrets)
+	_, _, err = svc.callProvider(context.Background(), "why")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-ant-1", server.req.Header.Get("x-api-key"))
+
+	// A rotated key is used by the next request
+	secrets["ai-provider"] = map[string][]byte{"api_key": []byte("sk-ant-2")}
+	_, _, err = svc.callProvider(context.Background(), "why")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-ant-2", server.req.Header.Get("x-api-key"))
+
+	secrets["ai-provider"] = map[string][]byte{"token": []byte("sk-ant-3")}
+	_, _, err = svc.callProvider(context.Background(), "why")
+	assert.EqualError(t, err, "secret ai-provider has no api_key")
+}
+
+func newBedrockTestService(server *recordingServer, model string) *Service {
+	return &Service{
+		config: &Config{
+			Provider:           ProviderBedrock,
+			Model:              model,
+			Endpoint:           server.URL,
+			BedrockRegion:      "us-west-2",
+			AWSAccessKeyID:     "AKIDEXAMPLE",
+			AWSSecretAccessKey: "secret",
+			AWSSessionToken:    "session",
+			MaxTokens:          512,
+			Temperature:        0.5,
+			TopP:               0.9,
+		},
+		httpClient: server.Client(),
+	}
+}
+
+func TestCallBedrockClaude(t *testing.T) {
+	server := newRecordingServer(t, `{"content":[{"type":"text","text":"Increase the memory limit"}],"usage":{"input_tokens":12,"output_tokens":8}}`)
+	svc := newBedrockTestService(server, "anthropic.claude-3-sonnet-20240229-v1:0")
+
+	response, tokens, err := svc.callProvider(context.Background(), "why")
+	require.NoError(t, err)
+	assert.Equal(t, "Increase the memory limit", response)
+	assert.Equal(t, 20, tokens)
+
+	assert.Equal(t, "/model/anthropic.claude-3-sonnet-20240229-v1%3A0/invoke", server.req.URL.EscapedPath())
+	auth := server.req.Header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"), auth)
+	assert.Contains(t, auth, "/us-west-2/bedrock/aws4_request")
+	assert.Contains(t, auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token")
+	assert.Equal(t, "session", server.req.Header.Get("X-Amz-Security-Token"))
+
+	assert.Equal(t, bedrockAnthropicVersion, server.body["anthropic_version"])
+	assert.Equal(t, 512.0, server.body["max_tokens"])
+	messages := server.body["messages"].([]interface{})
+	require.Len(t, messages, 1)
+	assert.Equal(t, "why", messages[0].(map[string]interface{})["content"])
+}
+
+func TestCallBedrockTitan(t *testing.T) {
+	server := newRecordingServer(t, `{"inputTextTokenCount":6,"results":[{"tokenCount":14,"outputText":"Add a readiness probe","completionReason":"FINISH"}]}`)
+	svc := newBedrockTestService(server, "amazon.titan-text-express-v1")
+
+	response, tokens, err := svc.callProvider(context.Background(), "why")
+	require.NoError(t, err)
+	assert.Equal(t, "Add a readiness probe", response)
+	assert.Equal(t, 20, tokens)
+
+	assert.Equal(t, "/model/amazon.titan-text-express-v1/invoke", server.req.URL.Path)
+	assert.Equal(t, "why", server.body["inputText"])
+	config := server.body["textGenerationConfig"].(map[string]interface{})
+	assert.Equal(t, 512.0, config["maxTokenCount"])
+	assert.Equal(t, 0.5, config["temperature"])
+	assert.Equal(t, 0.9, config["topP"])
+}
+
+func TestCallBedrockUnsupportedModel(t *testing.T) {
+	server := newRecordingServer(t, `{}`)
+	svc := newBedrockTestService(server, "meta.llama3-70b-instruct-v1:0")
+
+	_, _, err := svc.callProvider(context.Background(), "why")
+	assert.ErrorContains(t, err, "unsupported bedrock model")
+	assert.Nil(t, server.req)
+}
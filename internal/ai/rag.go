@@ -0,0 +1,388 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRAGTopK          = 3
+	defaultRAGMinSimilarity = 0.75
+	reindexBatchSize        = 32
+	// defaultEmbeddingDimensions is the size of OpenAI's
+	// text-embedding-3-small and ada-002 embeddings
+	defaultEmbeddingDimensions = 1536
+)
+
+// Document is a piece of reference text retrieved into prompts: a runbook,
+// internal doc or past diagnosis
+type Document struct {
+	ID          string                 `json:"id" gorm:"primaryKey"`
+	Text        string                 `json:"text"`
+	Metadata    map[string]interface{} `json:"metadata" gorm:"serializer:json"`
+	ContentHash string                 `json:"content_hash" gorm:"uniqueIndex"`
+	Model       string                 `json:"model"`
+	Embedding   Vector                 `json:"-" gorm:"type:vector"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+// TableName keeps documents next to the other AI tables
+func (Document) TableName() string { return "ai_documents" }
+
+// ScoredDocument is a retrieved document with its cosine similarity to the
+// query
+type ScoredDocument struct {
+	Document
+	Similarity float64 `json:"similarity"`
+}
+
+// Vector is an embedding stored in a pgvector column
+type Vector []float32
+
+// Value encodes the vector in pgvector's text format, e.g. [1,2,3]
+func (v Vector) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	parts := make([]string, len(v))
+	for i, f := range v {
+		parts[i] = strconv.FormatFloat(float64(f), 'f', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]", nil
+}
+
+// Scan decodes pgvector's text format
+func (v *Vector) Scan(src interface{}) error {
+	var s string
+	switch src := src.(type) {
+	case nil:
+		*v = nil
+		return nil
+	case string:
+		s = src
+	case []byte:
+		s = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into Vector", src)
+	}
+
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if s == "" {
+		*v = Vector{}
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	out := make(Vector, len(parts))
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return fmt.Errorf("invalid vector element %q: %w", part, err)
+		}
+		out[i] = float32(f)
+	}
+	*v = out
+	return nil
+}
+
+// VectorStore persists documents and finds the nearest to an embedding
+type VectorStore interface {
+	Upsert(ctx context.Context, doc *Document) error
+	// Search returns up to k documents embedded by model, most similar
+	// first. Embeddings of different models aren't comparable.
+	Search(ctx context.Context, model string, embedding []float32, k int, minSimilarity float64) ([]ScoredDocument, error)
+	// List returns documents whose Model differs from model, or all
+	// documents if model is empty
+	List(ctx context.Context, model string) ([]Document, error)
+}
+
+// pgVectorStore keeps documents in Postgres using the pgvector extension
+type pgVectorStore struct {
+	db *gorm.DB
+}
+
+// newPGVectorStore migrates ai_documents with an embedding column of
+// dimensions, and an HNSW index for approximate nearest neighbour search
+func newPGVectorStore(db *gorm.DB, logger *zap.Logger, dimensions int) (*pgVectorStore, error) {
+	if dimensions <= 0 {
+		dimensions = defaultEmbeddingDimensions
+	}
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS vector").Error; err != nil {
+		return nil, fmt.Errorf("failed to enable pgvector: %w", err)
+	}
+	if err := db.AutoMigrate(&Document{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate AI documents: %w", err)
+	}
+
+	// Only typed columns can be indexed. Embeddings of another size came
+	// from a different model and are cleared; ReindexDocuments re-embeds
+	// them.
+	column := fmt.Sprintf("vector(%d)", dimensions)
+	var current string
+	if err := db.Raw(`SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = 'ai_documents'::regclass AND attname = 'embedding'`).Scan(&current).Error; err != nil {
+		return nil, fmt.Errorf("failed to inspect AI document embeddings: %w", err)
+	}
+	if current != column {
+		if err := db.Exec(fmt.Sprintf(`ALTER TABLE ai_documents ALTER COLUMN embedding TYPE %[1]s
+			USING CASE WHEN vector_dims(embedding) = %[2]d THEN embedding::%[1]s END`, column, dimensions)).Error; err != nil {
+			return nil, fmt.Errorf("failed to resize AI document embeddings: %w", err)
+		}
+	}
+	if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_ai_documents_model ON ai_documents(model)`).Error; err != nil {
+		return nil, fmt.Errorf("failed to index AI documents: %w", err)
+	}
+	// HNSW needs pgvector 0.5 and at most 2000 dimensions; without it
+	// searches fall back to scans
+	if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_ai_documents_embedding
+		ON ai_documents USING hnsw (embedding vector_cosine_ops)`).Error; err != nil {
+		logger.Warn("AI document embeddings will not be indexed", zap.Error(err))
+	}
+	return &pgVectorStore{db: db}, nil
+}
+
+func (p *pgVectorStore) Upsert(ctx context.Context, doc *Document) error {
+	var existing Document
+	err := p.db.WithContext(ctx).Select("id", "created_at").First(&existing, "content_hash = ?", doc.ContentHash).Error
+	if err == nil {
+		doc.ID, doc.CreatedAt = existing.ID, existing.CreatedAt
+	} else if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return p.db.WithContext(ctx).Save(doc).Error
+}
+
+func (p *pgVectorStore) Search(ctx context.Context, model string, embedding []float32, k int, minSimilarity float64) ([]ScoredDocument, error) {
+	query, err := Vector(embedding).Value()
+	if err != nil {
+		return nil, err
+	}
+
+	// <=> is cosine distance, so similarity is 1 - distance. Ordering by
+	// it uses the HNSW index.
+	var docs []ScoredDocument
+	err = p.db.WithContext(ctx).Model(&Document{}).
+		Select("*, 1 - (embedding <=> ?::vector) AS similarity", query).
+		Where("model = ?", model).
+		Where("1 - (embedding <=> ?::vector) >= ?", query, minSimilarity).
+		Order(gorm.Expr("embedding <=> ?::vector", query)).
+		Limit(k).
+		Scan(&docs).Error
+	return docs, err
+}
+
+func (p *pgVectorStore) List(ctx context.Context, model string) ([]Document, error) {
+	var docs []Document
+	q := p.db.WithContext(ctx)
+	if model != "" {
+		q = q.Where("model <> ?", model)
+	}
+	return docs, q.Find(&docs).Error
+}
+
+// memoryVectorStore is a VectorStore for databases without pgvector; it
+// does not survive restarts
+type memoryVectorStore struct {
+	mu   sync.RWMutex
+	docs map[string]Document // by content hash
+}
+
+func newMemoryVectorStore() *memoryVectorStore {
+	return &memoryVectorStore{docs: make(map[string]Document)}
+}
+
+func (m *memoryVectorStore) Upsert(_ context.Context, doc *Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.docs[doc.ContentHash]; ok {
+		doc.ID, doc.CreatedAt = existing.ID, existing.CreatedAt
+	}
+	m.docs[doc.ContentHash] = *doc
+	return nil
+}
+
+func (m *memoryVectorStore) Search(_ context.Context, model string, embedding []float32, k int, minSimilarity float64) ([]ScoredDocument, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var scored []ScoredDocument
+	for _, doc := range m.docs {
+		if doc.Model != model {
+			continue
+		}
+		similarity := cosineSimilarity(embedding, doc.Embedding)
+		if similarity >= minSimilarity {
+			scored = append(scored, ScoredDocument{Document: doc, Similarity: similarity})
+		}
+	}
+	sort.Slice(scored, func(i, j int) bool { return scored[i].Similarity > scored[j].Similarity })
+	if len(scored) > k {
+		scored = scored[:k]
+	}
+	return scored, nil
+}
+
+func (m *memoryVectorStore) List(_ context.Context, model string) ([]Document, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var docs []Document
+	for _, doc := range m.docs {
+		if model == "" || doc.Model != model {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// SetEmbedder replaces the embedder configured from Config
+func (s *Service) SetEmbedder(e Embedder) { s.embedder = e }
+
+// SetVectorStore replaces the document store chosen by NewService
+func (s *Service) SetVectorStore(store VectorStore) { s.vectors = store }
+
+// IndexDocument embeds text and stores it for retrieval. Indexing the same
+// text again updates its metadata and embedding rather than duplicating it.
+func (s *Service) IndexDocument(ctx context.Context, text string, metadata map[string]interface{}) (*Document, error) {
+	if s.embedder == nil || s.vectors == nil {
+		return nil, fmt.Errorf("document retrieval is not enabled")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("document text is required")
+	}
+
+	vectors, err := s.embedder.Embed(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(text))
+	doc := &Document{
+		ID:          uuid.New().String(),
+		Text:        text,
+		Metadata:    metadata,
+		ContentHash: hex.EncodeToString(hash[:]),
+		Model:       s.embedder.Model(),
+		Embedding:   vectors[0],
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.vectors.Upsert(ctx, doc); err != nil {
+		return nil, fmt.Errorf("failed to store document: %w", err)
+	}
+	return doc, nil
+}
+
+// ReindexDocuments re-embeds stored documents with the current embedder,
+// e.g. after changing the embedding model. With all false only documents
+// embedded by a different model are updated. It returns how many were.
+func (s *Service) ReindexDocuments(ctx context.Context, all bool) (int, error) {
+	if s.embedder == nil || s.vectors == nil {
+		return 0, fmt.Errorf("document retrieval is not enabled")
+	}
+
+	model := s.embedder.Model()
+	filter := model
+	if all {
+		filter = ""
+	}
+	docs, err := s.vectors.List(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list documents: %w", err)
+	}
+
+	reindexed := 0
+	for start := 0; start < len(docs); start += reindexBatchSize {
+		batch := docs[start:min(start+reindexBatchSize, len(docs))]
+		texts := make([]string, len(batch))
+		for i, doc := range batch {
+			texts[i] = doc.Text
+		}
+
+		vectors, err := s.embedder.Embed(ctx, texts)
+		if err != nil {
+			return reindexed, err
+		}
+		for i := range batch {
+			doc := batch[i]
+			doc.Embedding = vectors[i]
+			doc.Model = model
+			doc.UpdatedAt = time.Now()
+			if err := s.vectors.Upsert(ctx, &doc); err != nil {
+				return reindexed, fmt.Errorf("failed to store document: %w", err)
+			}
+			reindexed++
+		}
+	}
+	return reindexed, nil
+}
+
+// retrieveDocuments returns the stored documents most similar to question,
+// above the configured similarity threshold
+func (s *Service) retrieveDocuments(ctx context.Context, question string) ([]ScoredDocument, error) {
+	if s.embedder == nil || s.vectors == nil {
+		return nil, nil
+	}
+
+	vectors, err := s.embedder.Embed(ctx, []string{question})
+	if err != nil {
+		return nil, err
+	}
+
+	k := s.config.RAGTopK
+	if k <= 0 {
+		k = defaultRAGTopK
+	}
+	threshold := s.config.RAGMinSimilarity
+	if threshold <= 0 {
+		threshold = defaultRAGMinSimilarity
+	}
+	return s.vectors.Search(ctx, s.embedder.Model(), vectors[0], k, threshold)
+}
+
+// indexDiagnosis stores a completed diagnosis so later similar issues can
+// draw on it
+func (s *Service) indexDiagnosis(ctx context.Context, question string, result *DiagnosisResult) {
+	if s.embedder == nil || s.vectors == nil {
+		return
+	}
+	text := fmt.Sprintf("Past incident: %s\n\n%s", question, result.Analysis)
+	if _, err := s.IndexDocument(ctx, text, map[string]interface{}{
+		"type":       "diagnosis",
+		"query_id":   result.QueryID,
+		"root_cause": result.RootCause,
+	}); err != nil {
+		s.logger.Warn("Failed to index diagnosis", zap.String("query_id", result.QueryID), zap.Error(err))
+	}
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
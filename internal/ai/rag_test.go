@@ -0,0 +1,150 @@
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// keywordEmbedder embeds text as counts of a fixed vocabulary, so similar
+// wording gives similar vectors
+type keywordEmbedder struct {
+	model string
+	calls int
+}
+
+var keywordVocabulary = []string{
+	"oomkilled", "memory", "limit", "crashloopbackoff", "restart", "probe",
+	"imagepullbackoff", "registry", "image", "pending", "scheduling", "node",
+	"certificate", "tls", "expired", "dns", "resolve", "coredns",
+}
+
+func (e *keywordEmbedder) Model() string { return e.model }
+
+func (e *keywordEmbedder) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, len(keywordVocabulary))
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !(r >= 'a' && r <= 'z')
+		}) {
+			for j, keyword := range keywordVocabulary {
+				if word == keyword {
+					vector[j]++
+				}
+			}
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+func newRAGTestService(t *testing.T) (*Service, *keywordEmbedder) {
+	embedder := &keywordEmbedder{model: "keywords-v1"}
+	svc := &Service{
+		logger: zap.NewNop(),
+		config: &Config{SystemPrompt: "You are a test assistant."},
+	}
+	svc.SetEmbedder(embedder)
+	svc.SetVectorStore(newMemoryVectorStore())
+
+	corpus := map[string]string{
+		"oom":   "Runbook: pods OOMKilled. Raise the container memory limit or fix the memory leak.",
+		"image": "Runbook: ImagePullBackOff. Check the image name and registry credentials.",
+		"sched": "Runbook: pods Pending. Check scheduling constraints and node capacity.",
+		"tls":   "Runbook: TLS certificate expired. Renew the certificate in cert-manager.",
+	}
+	for id, text := range corpus {
+		_, err := svc.IndexDocument(context.Background(), text, map[string]interface{}{"runbook": id})
+		require.NoError(t, err)
+	}
+	return svc, embedder
+}
+
+func TestRetrieveDocuments(t *testing.T) {
+	svc, _ := newRAGTestService(t)
+
+	docs, err := svc.retrieveDocuments(context.Background(), "Why does my pod keep getting OOMKilled? Is the memory limit too low?")
+	require.NoError(t, err)
+	require.NotEmpty(t, docs)
+	assert.Equal(t, "oom", docs[0].Metadata["runbook"])
+	assert.Len(t, docs, 1, "other runbooks are below the similarity threshold")
+
+	docs, err = svc.retrieveDocuments(context.Background(), "How do I configure coredns to resolve external names?")
+	require.NoError(t, err)
+	assert.Empty(t, docs, "nothing relevant is indexed")
+}
+
+func TestRetrieveDocumentsSkipsOtherModels(t *testing.T) {
+	svc, embedder := newRAGTestService(t)
+
+	// Until they are re-indexed, documents embedded by the old model can't
+	// be compared with the new model's embeddings
+	embedder.model = "keywords-v2"
+	docs, err := svc.retrieveDocuments(context.Background(), "Why does my pod keep getting OOMKilled? Is the memory limit too low?")
+	require.NoError(t, err)
+	assert.Empty(t, docs)
+
+	_, err = svc.ReindexDocuments(context.Background(), false)
+	require.NoError(t, err)
+	docs, err = svc.retrieveDocuments(context.Background(), "Why does my pod keep getting OOMKilled? Is the memory limit too low?")
+	require.NoError(t, err)
+	require.NotEmpty(t, docs)
+	assert.Equal(t, "oom", docs[0].Metadata["runbook"])
+}
+
+func TestBuildPromptIncludesRetrievedDocuments(t *testing.T) {
+	svc, _ := newRAGTestService(t)
+
+	prompt := svc.buildPrompt(context.Background(), "image pull fails with ImagePullBackOff from our registry", IntentDiagnose, nil)
+	assert.Contains(t, prompt, "### Reference Material ###")
+	assert.Contains(t, prompt, "registry credentials")
+	assert.NotContains(t, prompt, "cert-manager")
+	assert.Less(t, strings.Index(prompt, "Reference Material"), strings.Index(prompt, "### Question ###"))
+}
+
+func TestIndexDocumentDeduplicates(t *testing.T) {
+	svc, _ := newRAGTestService(t)
+
+	first, err := svc.IndexDocument(context.Background(), "Runbook: DNS failures. Restart coredns.", nil)
+	require.NoError(t, err)
+	second, err := svc.IndexDocument(context.Background(), "Runbook: DNS failures. Restart coredns.", map[string]interface{}{"v": 2})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+
+	docs, err := svc.vectors.List(context.Background(), "")
+	require.NoError(t, err)
+	assert.Len(t, docs, 5)
+}
+
+func TestReindexDocuments(t *testing.T) {
+	svc, embedder := newRAGTestService(t)
+
+	n, err := svc.ReindexDocuments(context.Background(), false)
+	require.NoError(t, err)
+	assert.Zero(t, n, "everything is already embedded with the current model")
+
+	embedder.model = "keywords-v2"
+	n, err = svc.ReindexDocuments(context.Background(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	docs, err := svc.vectors.List(context.Background(), "keywords-v2")
+	require.NoError(t, err)
+	assert.Empty(t, docs)
+}
+
+func TestVectorValueScan(t *testing.T) {
+	value, err := Vector{1, -0.5, 0.25}.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "[1,-0.5,0.25]", value)
+
+	var v Vector
+	require.NoError(t, v.Scan([]byte("[1,-0.5,0.25]")))
+	assert.Equal(t, Vector{1, -0.5, 0.25}, v)
+}
@@ -0,0 +1,216 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPerUserRPM = 20
+	rateLimitPrefix   = "ai:ratelimit"
+)
+
+// Rate limit scopes
+const (
+	RateLimitUser     = "user"
+	RateLimitProvider = "provider"
+)
+
+// RateLimitError is returned when a per-user or provider limit is hit
+type RateLimitError struct {
+	Scope string `json:"scope"` // RateLimitUser or RateLimitProvider
+	// Limit is "requests" or "tokens"
+	Limit      string        `json:"limit"`
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s %s rate limit exceeded, retry after %s", e.Scope, e.Limit, e.RetryAfter.Round(time.Second))
+}
+
+// RetryAfterSeconds is RetryAfter rounded up to whole seconds, for a
+// Retry-After header
+func (e *RateLimitError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Unwrap exposes the limit as a rate limited AppError, so handlers answer
+// it with 429 and a Retry-After header
+func (e *RateLimitError) Unwrap() error {
+	return errors.RateLimited(e.Error()).WithMeta(errors.MetaRetryAfter, strconv.Itoa(e.RetryAfterSeconds()))
+}
+
+// bucketStore holds token buckets that refill at perMinute tokens a minute
+// up to a capacity of perMinute
+type bucketStore interface {
+	// take removes n tokens from the bucket at key. If fewer are available
+	// nothing is taken unless force is set, in which case the bucket goes
+	// into debt. It returns how long until n tokens would be available.
+	take(ctx context.Context, key string, perMinute, n float64, force bool) (time.Duration, error)
+}
+
+// memoryBuckets keeps buckets in process; limits are per replica
+type memoryBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newMemoryBuckets() *memoryBuckets {
+	return &memoryBuckets{buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (m *memoryBuckets) take(_ context.Context, key string, perMinute, n float64, force bool) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: perMinute, updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(perMinute, b.tokens+now.Sub(b.updated).Minutes()*perMinute)
+	b.updated = now
+
+	if b.tokens >= n || force {
+		b.tokens -= n
+		return 0, nil
+	}
+	return time.Duration((n - b.tokens) / perMinute * float64(time.Minute)), nil
+}
+
+// takeScript is memoryBuckets.take run atomically in Redis, so every
+// replica draws from the same bucket. Times come from the Redis clock.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+local force = ARGV[3] == "1"
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + (now - ts) * capacity / 60000)
+
+local wait = 0
+if tokens >= n or force then
+  tokens = tokens - n
+else
+  wait = math.ceil((n - tokens) * 60000 / capacity)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) * 60000 / capacity) + 1000)
+return wait
+`)
+
+// redisBuckets keeps buckets in Redis; limits hold across replicas
+type redisBuckets struct {
+	client *redis.Client
+}
+
+func (r *redisBuckets) take(ctx context.Context, key string, perMinute, n float64, force bool) (time.Duration, error) {
+	forceArg := "0"
+	if force {
+		forceArg = "1"
+	}
+	waitMS, err := takeScript.Run(ctx, r.client, []string{key}, perMinute, n, forceArg).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("rate limit check failed: %w", err)
+	}
+	return time.Duration(waitMS) * time.Millisecond, nil
+}
+
+// SetRedis moves rate limit buckets into Redis, so limits hold across
+// replicas rather than per process
+func (s *Service) SetRedis(c *cache.RedisCache) {
+	s.limits = &redisBuckets{client: c.Client()}
+}
+
+// checkUserLimit takes a request from userID's bucket
+func (s *Service) checkUserLimit(ctx context.Context, userID string) error {
+	rpm := s.config.PerUserRPM
+	if s.limits == nil || rpm <= 0 {
+		return nil
+	}
+	return s.takeLimit(ctx, RateLimitUser, "requests", rateLimitPrefix+":user:"+userID, float64(rpm), 1, false)
+}
+
+// acquireProvider takes a request and estimatedTokens from the provider's
+// buckets before a call
+func (s *Service) acquireProvider(ctx context.Context, estimatedTokens int) error {
+	if s.limits == nil {
+		return nil
+	}
+	prefix := s.providerLimitPrefix()
+	if rpm := s.config.RateLimitRPM; rpm > 0 {
+		if err := s.takeLimit(ctx, RateLimitProvider, "requests", prefix+":requests", float64(rpm), 1, false); err != nil {
+			return err
+		}
+	}
+	if tpm := s.config.ProviderTPM; tpm > 0 {
+		// A prompt larger than the whole budget would never fit; let it
+		// through once the bucket is full
+		n := math.Min(float64(estimatedTokens), float64(tpm))
+		if err := s.takeLimit(ctx, RateLimitProvider, "tokens", prefix+":tokens", float64(tpm), n, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleProvider charges the provider's token bucket for the tokens a call
+// used beyond the estimate taken up front
+func (s *Service) settleProvider(ctx context.Context, estimatedTokens, usedTokens int) {
+	tpm := s.config.ProviderTPM
+	if s.limits == nil || tpm <= 0 || usedTokens <= estimatedTokens {
+		return
+	}
+	key := s.providerLimitPrefix() + ":tokens"
+	if _, err := s.limits.take(ctx, key, float64(tpm), float64(usedTokens-estimatedTokens), true); err != nil {
+		s.logger.Warn("Failed to record provider token usage", zap.Error(err))
+	}
+}
+
+func (s *Service) takeLimit(ctx context.Context, scope, limit, key string, perMinute, n float64, force bool) error {
+	wait, err := s.limits.take(ctx, key, perMinute, n, force)
+	if err != nil {
+		// An unavailable limiter shouldn't take the AI features down
+		s.logger.Warn("Rate limiter unavailable, allowing request", zap.String("key", key), zap.Error(err))
+		return nil
+	}
+	if wait > 0 {
+		return &RateLimitError{Scope: scope, Limit: limit, RetryAfter: wait}
+	}
+	return nil
+}
+
+func (s *Service) providerLimitPrefix() string {
+	provider := s.config.Provider
+	if provider == "" {
+		provider = ProviderOpenAI
+	}
+	return rateLimitPrefix + ":provider:" + string(provider)
+}
+
+// estimateTokens approximates the prompt tokens in text at four characters
+// a token
+func estimateTokens(text string) int {
+	return len(text)/4 + 1
+}
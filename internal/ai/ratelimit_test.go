@@ -0,0 +1,119 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	apperrors "github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeClock is a settable time source for memoryBuckets
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newLimitTestService(config *Config) (*Service, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	buckets := newMemoryBuckets()
+	buckets.now = clock.Now
+	return &Service{logger: zap.NewNop(), config: config, limits: buckets}, clock
+}
+
+func TestUserLimitConcurrent(t *testing.T) {
+	svc, _ := newLimitTestService(&Config{PerUserRPM: 10})
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if svc.checkUserLimit(context.Background(), "alice") == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(10), allowed.Load())
+}
+
+func TestUserLimitIsPerUser(t *testing.T) {
+	svc, clock := newLimitTestService(&Config{PerUserRPM: 2})
+	ctx := context.Background()
+
+	require.NoError(t, svc.checkUserLimit(ctx, "alice"))
+	require.NoError(t, svc.checkUserLimit(ctx, "alice"))
+
+	err := svc.checkUserLimit(ctx, "alice")
+	var limitErr *RateLimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, RateLimitUser, limitErr.Scope)
+	assert.Equal(t, 30*time.Second, limitErr.RetryAfter, "one request refills every 30s at 2 RPM")
+	assert.Equal(t, 30, limitErr.RetryAfterSeconds())
+	httpStatus, _ := apperrors.HTTPResponse(err, "")
+	assert.Equal(t, http.StatusTooManyRequests, httpStatus)
+	assert.Equal(t, "30", apperrors.RetryAfter(err))
+
+	assert.NoError(t, svc.checkUserLimit(ctx, "bob"), "alice's usage doesn't throttle bob")
+
+	clock.Advance(30 * time.Second)
+	assert.NoError(t, svc.checkUserLimit(ctx, "alice"))
+}
+
+func TestProviderTokenLimit(t *testing.T) {
+	svc, clock := newLimitTestService(&Config{Provider: ProviderOpenAI, RateLimitRPM: 100, ProviderTPM: 1000})
+	ctx := context.Background()
+
+	require.NoError(t, svc.acquireProvider(ctx, 400))
+	// The call used more than estimated; the bucket goes into debt
+	svc.settleProvider(ctx, 400, 1200)
+
+	err := svc.acquireProvider(ctx, 100)
+	var limitErr *RateLimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, RateLimitProvider, limitErr.Scope)
+	assert.Equal(t, "tokens", limitErr.Limit)
+	// 200 tokens of debt plus 100 requested at 1000 TPM
+	assert.Equal(t, 18*time.Second, limitErr.RetryAfter)
+
+	clock.Advance(18 * time.Second)
+	assert.NoError(t, svc.acquireProvider(ctx, 100))
+}
+
+func TestProviderRequestLimit(t *testing.T) {
+	svc, _ := newLimitTestService(&Config{Provider: ProviderAnthropic, RateLimitRPM: 1})
+	ctx := context.Background()
+
+	require.NoError(t, svc.acquireProvider(ctx, 10))
+	err := svc.acquireProvider(ctx, 10)
+	var limitErr *RateLimitError
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "requests", limitErr.Limit)
+	assert.Equal(t, time.Minute, limitErr.RetryAfter)
+}
+
+func TestOversizedPromptFitsFullBucket(t *testing.T) {
+	svc, _ := newLimitTestService(&Config{ProviderTPM: 500})
+	assert.NoError(t, svc.acquireProvider(context.Background(), 5000))
+}
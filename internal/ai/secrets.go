@@ -0,0 +1,40 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+)
+
+// apiKeyField is the field of the APIKeyRef secret holding the key
+const apiKeyField = "api_key"
+
+// SecretStore is the secret backend the provider API key is read from
+type SecretStore interface {
+	// Get returns the key/value pairs of the secret at ref
+	Get(ctx context.Context, ref string) (map[string][]byte, error)
+}
+
+// SetSecretStore sets where Config.APIKeyRef is read from. The store
+// should cache; the key is read for every provider request.
+func (s *Service) SetSecretStore(store SecretStore) { s.secrets = store }
+
+// apiKey returns the provider API key, from the secret store when the
+// config references one
+func (s *Service) apiKey(ctx context.Context) (string, error) {
+	ref := s.config.APIKeyRef
+	if ref == "" {
+		return s.config.APIKey, nil
+	}
+	if s.secrets == nil {
+		return "", fmt.Errorf("no secret store configured for AI API key %s", ref)
+	}
+	data, err := s.secrets.Get(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to read AI API key: %w", err)
+	}
+	key, ok := data[apiKeyField]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %s", ref, apiKeyField)
+	}
+	return string(key), nil
+}
@@ -6,14 +6,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/pkg/nats"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -48,6 +51,10 @@ const (
 type Config struct {
 	Provider         Provider
 	APIKey           string
+	// APIKeyRef reads the key from the api_key field of this secret in
+	// the store set with SetSecretStore, on each request, so a rotated key
+	// is used without a restart; it takes precedence over APIKey
+	APIKeyRef        string
 	Endpoint         string
 	Model            string
 	MaxTokens        int
@@ -55,9 +62,67 @@ type Config struct {
 	TopP             float64
 	EnableCache      bool
 	CacheTTL         time.Duration
+	// RateLimitRPM and ProviderTPM cap requests and tokens a minute sent
+	// to the provider across all users; PerUserRPM caps each user's
+	// questions. ProviderTPM of 0 leaves tokens unlimited.
 	RateLimitRPM     int
+	PerUserRPM       int
+	ProviderTPM      int
 	EnableStreaming  bool
 	SystemPrompt     string
+	// ModelPricing is the estimated USD per million tokens of each Model,
+	// used to cost usage. Monthly limits of 0 are uncapped.
+	ModelPricing          map[string]float64
+	UserMonthlyTokenLimit int64
+	UserMonthlyCostLimit  float64
+	OrgMonthlyTokenLimit  int64
+	OrgMonthlyCostLimit   float64
+	// ChatContextTokens bounds the history sent with each chat message,
+	// default 8000; older turns are dropped, or with ChatSummarize folded
+	// into a running summary on the session
+	ChatContextTokens int
+	ChatSummarize     bool
+	// InsightInterval is how often insights are generated and expired ones
+	// deleted, default 1h; generated insights expire after InsightTTL,
+	// default 7 days
+	InsightInterval time.Duration
+	InsightTTL      time.Duration
+	// Azure OpenAI: requests go to the deployment on the resource, with
+	// APIKey sent as the api-key header
+	AzureResource   string
+	AzureDeployment string
+	AzureAPIVersion string
+	// AWS Bedrock: Model is the Bedrock model ID, e.g.
+	// anthropic.claude-3-sonnet-20240229-v1:0 or amazon.titan-text-express-v1
+	BedrockRegion      string
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+	// MaxToolIterations bounds the rounds of tool calls in
+	// AskQuestionWithTools; defaults to 5
+	MaxToolIterations int
+	// EnableRAG retrieves similar documents and past diagnoses into
+	// prompts. Embeddings use EmbeddingProvider (default Provider) and
+	// EmbeddingAPIKey (default APIKey); documents are stored with pgvector
+	// on Postgres, in memory otherwise.
+	EnableRAG         bool
+	EmbeddingProvider Provider
+	EmbeddingModel    string
+	EmbeddingEndpoint string
+	EmbeddingAPIKey   string
+	// EmbeddingDimensions sizes the pgvector column; it must match what
+	// EmbeddingModel returns. Default 1536.
+	EmbeddingDimensions int
+	RAGTopK             int     // documents retrieved per prompt, default 3
+	RAGMinSimilarity    float64 // cosine similarity cut-off, default 0.75
+	// VolatileCacheTTL caches answers about live state (diagnoses, or
+	// context with logs/events/status) for less than CacheTTL; default 5m
+	VolatileCacheTTL time.Duration
+	// SemanticCache also serves cached answers to differently worded
+	// questions whose embeddings are at least SemanticCacheThreshold
+	// similar (default 0.95), within the same context
+	SemanticCache          bool
+	SemanticCacheThreshold float64
 }
 
 // Service provides AI operations
@@ -66,8 +131,24 @@ type Service struct {
 	logger      *zap.Logger
 	config      *Config
 	httpClient  *http.Client
+	// streamClient has no timeout; streamed responses are bounded by the
+	// request context instead
+	streamClient *http.Client
 	cache       sync.Map
-	rateLimiter *rateLimiter
+	limits      bucketStore
+	events      *nats.EventBus
+	// recommendations feeds cost insights besides the AI's own
+	recommendations RecommendationSource
+	tools       map[string]Tool
+	toolOrder   []string
+	toolAuth    ToolAuthorizer
+	embedder    Embedder
+	secrets     SecretStore
+	vectors     VectorStore
+
+	cacheHits         atomic.Uint64
+	cacheSemanticHits atomic.Uint64
+	cacheMisses       atomic.Uint64
 }
 
 // Query represents an AI query
@@ -81,6 +162,7 @@ type Query struct {
 	Model        string                 `json:"model"`
 	TokensUsed   int                    `json:"tokens_used"`
 	Latency      time.Duration          `json:"latency"`
+	ToolCalls    []ToolCall             `json:"tool_calls,omitempty" gorm:"serializer:json"`
 	Feedback     *QueryFeedback         `json:"feedback" gorm:"foreignKey:QueryID"`
 	CreatedAt    time.Time              `json:"created_at"`
 }
@@ -129,6 +211,7 @@ type Insight struct {
 	ExpiresAt   *time.Time             `json:"expires_at"`
 	AckedAt     *time.Time             `json:"acked_at"`
 	AckedBy     string                 `json:"acked_by"`
+	Fingerprint string                 `json:"fingerprint,omitempty" gorm:"index"` // dedups generated insights
 	CreatedAt   time.Time              `json:"created_at"`
 }
 
@@ -159,6 +242,8 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 		&Recommendation{},
 		&Insight{},
 		&ChatSession{},
+		&UsageRecord{},
+		&budgetWarning{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate AI tables: %w", err)
 	}
@@ -176,6 +261,9 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 	if config.RateLimitRPM == 0 {
 		config.RateLimitRPM = 60
 	}
+	if config.PerUserRPM == 0 {
+		config.PerUserRPM = defaultPerUserRPM
+	}
 	if config.CacheTTL == 0 {
 		config.CacheTTL = 1 * time.Hour
 	}
@@ -190,7 +278,28 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 		httpClient: &http.Client{
 			Timeout: 120 * time.Second,
 		},
-		rateLimiter: newRateLimiter(config.RateLimitRPM),
+		streamClient: &http.Client{},
+		limits:       newMemoryBuckets(),
+	}
+
+	if config.EnableRAG || config.SemanticCache {
+		embedder, err := newEmbedder(config, svc.httpClient, svc.apiKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure embeddings: %w", err)
+		}
+		svc.embedder = embedder
+	}
+	if config.EnableRAG {
+		if db.Dialector.Name() != "postgres" {
+			logger.Warn("pgvector requires Postgres, keeping AI documents in memory")
+			svc.vectors = newMemoryVectorStore()
+		} else if store, err := newPGVectorStore(db, logger, config.EmbeddingDimensions); err != nil {
+			// Enabling the extension may need privileges the service lacks
+			logger.Warn("pgvector unavailable, keeping AI documents in memory", zap.Error(err))
+			svc.vectors = newMemoryVectorStore()
+		} else {
+			svc.vectors = store
+		}
 	}
 
 	return svc, nil
@@ -213,24 +322,30 @@ Use markdown formatting for better readability.`
 }
 
 // AskQuestion processes a natural language question about Kubernetes
-func (s *Service) AskQuestion(ctx context.Context, userID, question string, context map[string]interface{}) (*Query, error) {
+func (s *Service) AskQuestion(ctx context.Context, userID, question string, context map[string]interface{}, opts ...AskOptions) (*Query, error) {
 	// Rate limiting
-	if !s.rateLimiter.allow() {
-		return nil, fmt.Errorf("rate limit exceeded, please try again later")
+	if err := s.checkUserLimit(ctx, userID); err != nil {
+		return nil, err
 	}
 
 	// Check cache
-	if s.config.EnableCache {
-		if cached, ok := s.getFromCache(question); ok {
+	var probe *cacheProbe
+	if s.config.EnableCache && !askOptions(opts).BypassCache {
+		var cached *Query
+		if cached, probe = s.getFromCache(ctx, userID, question, context); cached != nil {
 			return cached, nil
 		}
 	}
 
+	if err := s.checkBudget(ctx, userID); err != nil {
+		return nil, err
+	}
+
 	// Detect intent
 	intent := s.detectIntent(question)
 
 	// Build prompt with context
-	prompt := s.buildPrompt(question, intent, context)
+	prompt := s.buildPrompt(ctx, question, intent, context)
 
 	// Call AI provider
 	startTime := time.Now()
@@ -239,6 +354,7 @@ func (s *Service) AskQuestion(ctx context.Context, userID, question string, cont
 		return nil, fmt.Errorf("failed to get AI response: %w", err)
 	}
 	latency := time.Since(startTime)
+	s.recordUsage(ctx, userID, tokensUsed)
 
 	// Create query record
 	query := &Query{
@@ -261,7 +377,10 @@ func (s *Service) AskQuestion(ctx context.Context, userID, question string, cont
 
 	// Cache the result
 	if s.config.EnableCache {
-		s.saveToCache(question, query)
+		if probe == nil {
+			probe = newCacheProbe(userID, question, context)
+		}
+		s.saveToCache(ctx, probe, intent, context, query)
 	}
 
 	return query, nil
@@ -314,7 +433,7 @@ func (s *Service) detectIntent(query string) Intent {
 }
 
 // buildPrompt constructs the prompt with context
-func (s *Service) buildPrompt(question string, intent Intent, context map[string]interface{}) string {
+func (s *Service) buildPrompt(ctx context.Context, question string, intent Intent, context map[string]interface{}) string {
 	var sb strings.Builder
 
 	sb.WriteString(s.config.SystemPrompt)
@@ -342,6 +461,18 @@ func (s *Service) buildPrompt(question string, intent Intent, context map[string
 		}
 	}
 
+	// Add similar runbooks and past diagnoses
+	docs, err := s.retrieveDocuments(ctx, question)
+	if err != nil {
+		s.logger.Warn("Failed to retrieve documents", zap.Error(err))
+	}
+	if len(docs) > 0 {
+		sb.WriteString("\n### Reference Material ###\n")
+		for i, doc := range docs {
+			sb.WriteString(fmt.Sprintf("[%d] (similarity %.2f)\n%s\n\n", i+1, doc.Similarity, doc.Text))
+		}
+	}
+
 	sb.WriteString("\n### Question ###\n")
 	sb.WriteString(question)
 
@@ -350,30 +481,50 @@ func (s *Service) buildPrompt(question string, intent Intent, context map[string
 
 // callProvider calls the configured AI provider
 func (s *Service) callProvider(ctx context.Context, prompt string) (string, int, error) {
+	return s.callProviderMessages(ctx, promptMessages(prompt))
+}
+
+// callProviderMessages sends a conversation to the configured provider
+func (s *Service) callProviderMessages(ctx context.Context, messages []ChatMessage) (string, int, error) {
+	estimate := countMessageTokens(messages)
+	if err := s.acquireProvider(ctx, estimate); err != nil {
+		return "", 0, err
+	}
+	response, tokens, err := s.dispatchProvider(ctx, messages)
+	if err == nil {
+		s.settleProvider(ctx, estimate, tokens)
+	}
+	return response, tokens, err
+}
+
+// dispatchProvider sends messages to the configured provider
+func (s *Service) dispatchProvider(ctx context.Context, messages []ChatMessage) (string, int, error) {
 	switch s.config.Provider {
 	case ProviderOpenAI:
-		return s.callOpenAI(ctx, prompt)
+		return s.callOpenAI(ctx, messages)
 	case ProviderAnthropic:
-		return s.callAnthropic(ctx, prompt)
+		return s.callAnthropic(ctx, messages)
 	case ProviderOllama:
-		return s.callOllama(ctx, prompt)
+		return s.callOllama(ctx, messages)
+	case ProviderAzure:
+		return s.callAzure(ctx, messages)
+	case ProviderBedrock:
+		return s.callBedrock(ctx, messages)
 	default:
-		return s.callOpenAI(ctx, prompt)
+		return s.callOpenAI(ctx, messages)
 	}
 }
 
 // callOpenAI calls the OpenAI API
-func (s *Service) callOpenAI(ctx context.Context, prompt string) (string, int, error) {
+func (s *Service) callOpenAI(ctx context.Context, messages []ChatMessage) (string, int, error) {
 	endpoint := s.config.Endpoint
 	if endpoint == "" {
 		endpoint = "https://api.openai.com/v1/chat/completions"
 	}
 
 	requestBody := map[string]interface{}{
-		"model": s.config.Model,
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
+		"model":       s.config.Model,
+		"messages":    openAIMessages(messages),
 		"max_tokens":  s.config.MaxTokens,
 		"temperature": s.config.Temperature,
 		"top_p":       s.config.TopP,
@@ -390,7 +541,11 @@ func (s *Service) callOpenAI(ctx context.Context, prompt string) (string, int, e
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -426,18 +581,20 @@ func (s *Service) callOpenAI(ctx context.Context, prompt string) (string, int, e
 }
 
 // callAnthropic calls the Anthropic API
-func (s *Service) callAnthropic(ctx context.Context, prompt string) (string, int, error) {
+func (s *Service) callAnthropic(ctx context.Context, messages []ChatMessage) (string, int, error) {
 	endpoint := s.config.Endpoint
 	if endpoint == "" {
 		endpoint = "https://api.anthropic.com/v1/messages"
 	}
 
+	system, turns := anthropicMessages(messages)
 	requestBody := map[string]interface{}{
 		"model":      s.config.Model,
 		"max_tokens": s.config.MaxTokens,
-		"messages": []map[string]string{
-			{"role": "user", "content": prompt},
-		},
+		"messages":   turns,
+	}
+	if system != "" {
+		requestBody["system"] = system
 	}
 
 	body, err := json.Marshal(requestBody)
@@ -451,7 +608,11 @@ func (s *Service) callAnthropic(ctx context.Context, prompt string) (string, int
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", s.config.APIKey)
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("x-api-key", key)
 	req.Header.Set("anthropic-version", "2024-01-01")
 
 	resp, err := s.httpClient.Do(req)
@@ -488,7 +649,7 @@ func (s *Service) callAnthropic(ctx context.Context, prompt string) (string, int
 }
 
 // callOllama calls a local Ollama instance
-func (s *Service) callOllama(ctx context.Context, prompt string) (string, int, error) {
+func (s *Service) callOllama(ctx context.Context, messages []ChatMessage) (string, int, error) {
 	endpoint := s.config.Endpoint
 	if endpoint == "" {
 		endpoint = "http://localhost:11434/api/generate"
@@ -496,7 +657,7 @@ func (s *Service) callOllama(ctx context.Context, prompt string) (string, int, e
 
 	requestBody := map[string]interface{}{
 		"model":  s.config.Model,
-		"prompt": prompt,
+		"prompt": flattenMessages(messages),
 		"stream": false,
 		"options": map[string]interface{}{
 			"temperature": s.config.Temperature,
@@ -549,7 +710,9 @@ func (s *Service) DiagnoseIssue(ctx context.Context, userID string, issue Diagno
 	question := fmt.Sprintf("Diagnose why %s '%s' in namespace '%s' is having issues: %s",
 		issue.ResourceType, issue.ResourceName, issue.Namespace, issue.Description)
 
-	query, err := s.AskQuestion(ctx, userID, question, context)
+	// With tools registered the model fetches logs, events and describe
+	// output itself, so callers need not supply them
+	query, err := s.AskQuestionWithTools(ctx, userID, question, context)
 	if err != nil {
 		return nil, err
 	}
@@ -565,6 +728,8 @@ func (s *Service) DiagnoseIssue(ctx context.Context, userID string, issue Diagno
 		RelatedDocs: s.findRelatedDocs(query.Response),
 	}
 
+	s.indexDiagnosis(ctx, question, result)
+
 	return result, nil
 }
 
@@ -717,14 +882,18 @@ func (s *Service) findRelatedDocs(response string) []string {
 func (s *Service) GenerateOptimizationRecommendations(ctx context.Context, metrics map[string]interface{}) ([]Recommendation, error) {
 	question := "Based on the following resource utilization metrics, provide specific optimization recommendations:"
 
-	query, err := s.AskQuestion(ctx, "system", question, metrics)
+	recommendations, err := s.structuredRecommendations(ctx, question, metrics)
+	if errors.Is(err, errStructuredUnsupported) {
+		// Fall back to scraping a markdown answer
+		var query *Query
+		if query, err = s.AskQuestion(ctx, "system", question, metrics); err == nil {
+			recommendations = s.parseRecommendations(query.Response, metrics)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Parse recommendations from response
-	recommendations := s.parseRecommendations(query.Response, metrics)
-
 	// Save recommendations
 	for i := range recommendations {
 		recommendations[i].ID = uuid.New().String()
@@ -740,17 +909,18 @@ func (s *Service) GenerateOptimizationRecommendations(ctx context.Context, metri
 	return recommendations, nil
 }
 
+// parseRecommendations scrapes recommendations from a markdown answer, for
+// providers without structured output. Each heading starts one; "Impact:",
+// "Effort:", "Savings:" and "Type:" lines fill those fields.
 func (s *Service) parseRecommendations(response string, metrics map[string]interface{}) []Recommendation {
 	var recommendations []Recommendation
 
-	// Parse structured recommendations from AI response
-	// This is a simplified implementation
 	lines := strings.Split(response, "\n")
 	var currentRec *Recommendation
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "##") || strings.HasPrefix(line, "**") {
+		if strings.HasPrefix(line, "##") || (strings.HasPrefix(line, "**") && !markdownFieldPattern.MatchString(line)) {
 			if currentRec != nil {
 				recommendations = append(recommendations, *currentRec)
 			}
@@ -759,6 +929,10 @@ func (s *Service) parseRecommendations(response string, metrics map[string]inter
 				Type:  "performance",
 			}
 		} else if currentRec != nil && line != "" {
+			if m := markdownFieldPattern.FindStringSubmatch(line); m != nil {
+				setMarkdownField(currentRec, strings.ToLower(m[1]), strings.Trim(m[2], "* "))
+				continue
+			}
 			currentRec.Description += line + " "
 		}
 	}
@@ -767,6 +941,9 @@ func (s *Service) parseRecommendations(response string, metrics map[string]inter
 		recommendations = append(recommendations, *currentRec)
 	}
 
+	for i := range recommendations {
+		recommendations[i].Description = strings.TrimSpace(recommendations[i].Description)
+	}
 	return recommendations
 }
 
@@ -795,6 +972,12 @@ func (s *Service) SendChatMessage(ctx context.Context, sessionID, message string
 	if err := s.db.First(&session, "id = ?", sessionID).Error; err != nil {
 		return nil, fmt.Errorf("session not found: %w", err)
 	}
+	if err := s.checkUserLimit(ctx, session.UserID); err != nil {
+		return nil, err
+	}
+	if err := s.checkBudget(ctx, session.UserID); err != nil {
+		return nil, err
+	}
 
 	// Add user message
 	userMsg := ChatMessage{
@@ -804,17 +987,12 @@ func (s *Service) SendChatMessage(ctx context.Context, sessionID, message string
 	}
 	session.Messages = append(session.Messages, userMsg)
 
-	// Build conversation history
-	var conversationHistory strings.Builder
-	for _, msg := range session.Messages {
-		conversationHistory.WriteString(fmt.Sprintf("%s: %s\n", msg.Role, msg.Content))
-	}
-
 	// Get AI response
-	response, _, err := s.callProvider(ctx, s.config.SystemPrompt+"\n\n"+conversationHistory.String())
+	response, tokensUsed, err := s.callProviderMessages(ctx, s.chatMessages(ctx, &session))
 	if err != nil {
 		return nil, err
 	}
+	s.recordUsage(ctx, session.UserID, tokensUsed)
 
 	// Add assistant response
 	assistantMsg := ChatMessage{
@@ -919,74 +1097,3 @@ func (s *Service) DismissRecommendation(ctx context.Context, recommendationID st
 	}
 	return nil
 }
-
-// Cache operations
-func (s *Service) getFromCache(key string) (*Query, bool) {
-	if val, ok := s.cache.Load(key); ok {
-		if entry, ok := val.(*cacheEntry); ok {
-			if time.Now().Before(entry.expiry) {
-				return entry.query, true
-			}
-			s.cache.Delete(key)
-		}
-	}
-	return nil, false
-}
-
-func (s *Service) saveToCache(key string, query *Query) {
-	s.cache.Store(key, &cacheEntry{
-		query:  query,
-		expiry: time.Now().Add(s.config.CacheTTL),
-	})
-}
-
-type cacheEntry struct {
-	query  *Query
-	expiry time.Time
-}
-
-// Rate limiter
-type rateLimiter struct {
-	mu         sync.Mutex
-	tokens     int
-	maxTokens  int
-	refillRate time.Duration
-	lastRefill time.Time
-}
-
-func newRateLimiter(rpm int) *rateLimiter {
-	return &rateLimiter{
-		tokens:     rpm,
-		maxTokens:  rpm,
-		refillRate: time.Minute / time.Duration(rpm),
-		lastRefill: time.Now(),
-	}
-}
-
-func (r *rateLimiter) allow() bool {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	now := time.Now()
-	elapsed := now.Sub(r.lastRefill)
-	tokensToAdd := int(elapsed / r.refillRate)
-
-	if tokensToAdd > 0 {
-		r.tokens = min(r.maxTokens, r.tokens+tokensToAdd)
-		r.lastRefill = now
-	}
-
-	if r.tokens > 0 {
-		r.tokens--
-		return true
-	}
-
-	return false
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
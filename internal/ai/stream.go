@@ -0,0 +1,530 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// maxStreamLine bounds a single SSE or NDJSON line from a provider
+const maxStreamLine = 1 << 20
+
+// AskQuestionStream is AskQuestion, forwarding response tokens to out as the
+// provider produces them. The full response is saved once the stream ends.
+// out is always closed when AskQuestionStream returns; on error or
+// cancellation the tokens already sent are not persisted.
+func (s *Service) AskQuestionStream(ctx context.Context, userID, question string, context map[string]interface{}, out chan<- string, opts ...AskOptions) (*Query, error) {
+	defer close(out)
+
+	if err := s.checkUserLimit(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	var probe *cacheProbe
+	if s.config.EnableCache && !askOptions(opts).BypassCache {
+		var cached *Query
+		if cached, probe = s.getFromCache(ctx, userID, question, context); cached != nil {
+			if err := sendToken(ctx, out, cached.Response); err != nil {
+				return nil, err
+			}
+			return cached, nil
+		}
+	}
+
+	if err := s.checkBudget(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	intent := s.detectIntent(question)
+	prompt := s.buildPrompt(ctx, question, intent, context)
+
+	startTime := time.Now()
+	response, tokensUsed, err := s.streamProvider(ctx, prompt, out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AI response: %w", err)
+	}
+	s.recordUsage(ctx, userID, tokensUsed)
+
+	query := &Query{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Query:      question,
+		Intent:     intent,
+		Context:    context,
+		Response:   response,
+		Model:      s.config.Model,
+		TokensUsed: tokensUsed,
+		Latency:    time.Since(startTime),
+		CreatedAt:  time.Now(),
+	}
+
+	if err := s.db.Create(query).Error; err != nil {
+		s.logger.Warn("Failed to save query", zap.Error(err))
+	}
+
+	if s.config.EnableCache {
+		if probe == nil {
+			probe = newCacheProbe(userID, question, context)
+		}
+		s.saveToCache(ctx, probe, intent, context, query)
+	}
+
+	return query, nil
+}
+
+// SendChatMessageStream is SendChatMessage, forwarding the assistant's reply
+// to out as it is generated. Both messages are added to the session once the
+// reply is complete; out is always closed on return.
+func (s *Service) SendChatMessageStream(ctx context.Context, sessionID, message string, out chan<- string) (*ChatMessage, error) {
+	defer close(out)
+
+	var session ChatSession
+	if err := s.db.First(&session, "id = ?", sessionID).Error; err != nil {
+		return nil, fmt.Errorf("session not found: %w", err)
+	}
+	if err := s.checkUserLimit(ctx, session.UserID); err != nil {
+		return nil, err
+	}
+	if err := s.checkBudget(ctx, session.UserID); err != nil {
+		return nil, err
+	}
+
+	session.Messages = append(session.Messages, ChatMessage{
+		Role:      "user",
+		Content:   message,
+		Timestamp: time.Now(),
+	})
+
+	response, tokensUsed, err := s.streamProviderMessages(ctx, s.chatMessages(ctx, &session), out)
+	if err != nil {
+		return nil, err
+	}
+	s.recordUsage(ctx, session.UserID, tokensUsed)
+
+	assistantMsg := ChatMessage{
+		Role:      "assistant",
+		Content:   response,
+		Timestamp: time.Now(),
+	}
+	session.Messages = append(session.Messages, assistantMsg)
+	session.UpdatedAt = time.Now()
+
+	if err := s.db.Save(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	return &assistantMsg, nil
+}
+
+// streamProvider calls the configured AI provider, sending tokens to out as
+// they arrive, and returns the assembled response and token count. With
+// streaming disabled the whole response is sent as a single token.
+func (s *Service) streamProvider(ctx context.Context, prompt string, out chan<- string) (string, int, error) {
+	return s.streamProviderMessages(ctx, promptMessages(prompt), out)
+}
+
+// streamProviderMessages is streamProvider for a conversation
+func (s *Service) streamProviderMessages(ctx context.Context, messages []ChatMessage, out chan<- string) (string, int, error) {
+	// Bedrock streams in the binary AWS event stream encoding, which isn't
+	// supported; its response is sent whole
+	if !s.config.EnableStreaming || s.config.Provider == ProviderBedrock {
+		response, tokens, err := s.callProviderMessages(ctx, messages)
+		if err != nil {
+			return "", 0, err
+		}
+		if err := sendToken(ctx, out, response); err != nil {
+			return "", 0, err
+		}
+		return response, tokens, nil
+	}
+
+	estimate := countMessageTokens(messages)
+	if err := s.acquireProvider(ctx, estimate); err != nil {
+		return "", 0, err
+	}
+
+	var response string
+	var tokens int
+	var err error
+	switch s.config.Provider {
+	case ProviderAnthropic:
+		response, tokens, err = s.streamAnthropic(ctx, messages, out)
+	case ProviderOllama:
+		response, tokens, err = s.streamOllama(ctx, messages, out)
+	case ProviderAzure:
+		response, tokens, err = s.streamAzure(ctx, messages, out)
+	default:
+		response, tokens, err = s.streamOpenAI(ctx, messages, out)
+	}
+
+	// A cancelled request surfaces as a read error; report the cancellation
+	if err != nil && ctx.Err() != nil {
+		return "", 0, ctx.Err()
+	}
+	if err == nil {
+		s.settleProvider(ctx, estimate, tokens)
+	}
+	return response, tokens, err
+}
+
+// streamOpenAI streams a chat completion from the OpenAI API
+func (s *Service) streamOpenAI(ctx context.Context, messages []ChatMessage, out chan<- string) (string, int, error) {
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.openai.com/v1/chat/completions"
+	}
+
+	requestBody := map[string]interface{}{
+		"model":          s.config.Model,
+		"messages":       openAIMessages(messages),
+		"max_tokens":     s.config.MaxTokens,
+		"temperature":    s.config.Temperature,
+		"top_p":          s.config.TopP,
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	}
+
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	return s.streamChatCompletions(ctx, endpoint, requestBody, map[string]string{
+		"Authorization": "Bearer " + key,
+	}, out)
+}
+
+// streamAzure streams a chat completion from an Azure OpenAI deployment
+func (s *Service) streamAzure(ctx context.Context, messages []ChatMessage, out chan<- string) (string, int, error) {
+	endpoint, err := s.azureURL()
+	if err != nil {
+		return "", 0, err
+	}
+
+	requestBody := map[string]interface{}{
+		"messages":       openAIMessages(messages),
+		"max_tokens":     s.config.MaxTokens,
+		"temperature":    s.config.Temperature,
+		"top_p":          s.config.TopP,
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	}
+
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	return s.streamChatCompletions(ctx, endpoint, requestBody, map[string]string{
+		"api-key": key,
+	}, out)
+}
+
+// streamChatCompletions reads the server-sent events of an OpenAI-style
+// chat completions stream
+func (s *Service) streamChatCompletions(ctx context.Context, endpoint string, requestBody map[string]interface{}, headers map[string]string, out chan<- string) (string, int, error) {
+	resp, err := s.openStream(ctx, endpoint, requestBody, headers)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	var response strings.Builder
+	var tokens int
+	err = readSSE(resp.Body, func(data []byte) (bool, error) {
+		if string(data) == "[DONE]" {
+			return true, nil
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *struct {
+				TotalTokens int `json:"total_tokens"`
+			} `json:"usage"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return false, fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return false, fmt.Errorf("API error: %s", chunk.Error.Message)
+		}
+		// The usage chunk comes last, with no choices
+		if chunk.Usage != nil {
+			tokens = chunk.Usage.TotalTokens
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			response.WriteString(choice.Delta.Content)
+			if err := sendToken(ctx, out, choice.Delta.Content); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return response.String(), tokens, nil
+}
+
+// streamAnthropic streams a message from the Anthropic API
+func (s *Service) streamAnthropic(ctx context.Context, messages []ChatMessage, out chan<- string) (string, int, error) {
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.anthropic.com/v1/messages"
+	}
+
+	system, turns := anthropicMessages(messages)
+	requestBody := map[string]interface{}{
+		"model":      s.config.Model,
+		"max_tokens": s.config.MaxTokens,
+		"messages":   turns,
+		"stream":     true,
+	}
+	if system != "" {
+		requestBody["system"] = system
+	}
+
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+	resp, err := s.openStream(ctx, endpoint, requestBody, map[string]string{
+		"x-api-key":         key,
+		"anthropic-version": "2024-01-01",
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	var response strings.Builder
+	var inputTokens, outputTokens int
+	err = readSSE(resp.Body, func(data []byte) (bool, error) {
+		var event struct {
+			Type    string `json:"type"`
+			Message struct {
+				Usage struct {
+					InputTokens  int `json:"input_tokens"`
+					OutputTokens int `json:"output_tokens"`
+				} `json:"usage"`
+			} `json:"message"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"delta"`
+			Usage struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return false, fmt.Errorf("invalid stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			inputTokens = event.Message.Usage.InputTokens
+			outputTokens = event.Message.Usage.OutputTokens
+		case "content_block_delta":
+			if event.Delta.Type != "text_delta" || event.Delta.Text == "" {
+				return false, nil
+			}
+			response.WriteString(event.Delta.Text)
+			if err := sendToken(ctx, out, event.Delta.Text); err != nil {
+				return false, err
+			}
+		case "message_delta":
+			// Output usage is cumulative
+			outputTokens = event.Usage.OutputTokens
+		case "message_stop":
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("API error: %s", event.Error.Message)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	return response.String(), inputTokens + outputTokens, nil
+}
+
+// streamOllama streams a completion from a local Ollama instance, which
+// responds with one JSON object per line
+func (s *Service) streamOllama(ctx context.Context, messages []ChatMessage, out chan<- string) (string, int, error) {
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = "http://localhost:11434/api/generate"
+	}
+
+	requestBody := map[string]interface{}{
+		"model":  s.config.Model,
+		"prompt": flattenMessages(messages),
+		"stream": true,
+		"options": map[string]interface{}{
+			"temperature": s.config.Temperature,
+			"top_p":       s.config.TopP,
+		},
+	}
+
+	resp, err := s.openStream(ctx, endpoint, requestBody, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	var response strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk struct {
+			Response        string `json:"response"`
+			Done            bool   `json:"done"`
+			PromptEvalCount int    `json:"prompt_eval_count"`
+			EvalCount       int    `json:"eval_count"`
+			Error           string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return "", 0, fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return "", 0, fmt.Errorf("API error: %s", chunk.Error)
+		}
+		if chunk.Response != "" {
+			response.WriteString(chunk.Response)
+			if err := sendToken(ctx, out, chunk.Response); err != nil {
+				return "", 0, err
+			}
+		}
+		if chunk.Done {
+			return response.String(), chunk.PromptEvalCount + chunk.EvalCount, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, err
+	}
+
+	return "", 0, fmt.Errorf("stream ended before completion")
+}
+
+// openStream posts a JSON request and returns the response once the
+// provider has accepted it. Streams are bounded by ctx rather than the
+// client timeout, which would cut off long responses.
+func (s *Service) openStream(ctx context.Context, endpoint string, requestBody map[string]interface{}, headers map[string]string) (*http.Response, error) {
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(body))
+	}
+
+	return resp, nil
+}
+
+// readSSE calls fn with the data of each server-sent event until fn reports
+// the stream is done. A body that ends first is an error, so a truncated
+// response is never mistaken for a complete one. Data split over several
+// lines is joined with newlines; comments and other fields are ignored.
+func readSSE(r io.Reader, fn func(data []byte) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+
+	var data []byte
+	dispatch := func() (bool, error) {
+		if data == nil {
+			return false, nil
+		}
+		done, err := fn(data)
+		data = nil
+		return done, err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			if done, err := dispatch(); done || err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+		value = bytes.TrimPrefix(value, []byte(" "))
+		if data != nil {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// A final event need not be followed by a blank line
+	done, err := dispatch()
+	if err != nil {
+		return err
+	}
+	if !done {
+		return fmt.Errorf("stream ended before completion")
+	}
+	return nil
+}
+
+// sendToken forwards a token unless ctx is cancelled first
+func sendToken(ctx context.Context, out chan<- string, token string) error {
+	select {
+	case out <- token:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,150 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStreamTestService(t *testing.T, provider Provider, body string) *Service {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return &Service{
+		config: &Config{
+			Provider:        provider,
+			Endpoint:        server.URL,
+			EnableStreaming: true,
+		},
+		streamClient: server.Client(),
+	}
+}
+
+// collect runs stream and gathers the tokens it sends
+func collect(stream func(out chan<- string) (string, int, error)) ([]string, string, int, error) {
+	out := make(chan string)
+	type result struct {
+		response string
+		tokens   int
+		err      error
+	}
+	done := make(chan result)
+	go func() {
+		response, tokens, err := stream(out)
+		close(out)
+		done <- result{response, tokens, err}
+	}()
+
+	var tokens []string
+	for token := range out {
+		tokens = append(tokens, token)
+	}
+	r := <-done
+	return tokens, r.response, r.tokens, r.err
+}
+
+func TestStreamOpenAI(t *testing.T) {
+	svc := newStreamTestService(t, ProviderOpenAI, strings.Join([]string{
+		`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+		`data: {"choices":[{"delta":{"content":"Pod is "}}]}`,
+		`data: {"choices":[{"delta":{"content":"OOMKilled"}}]}`,
+		`data: {"choices":[],"usage":{"total_tokens":42}}`,
+		`data: [DONE]`,
+	}, "\n\n")+"\n\n")
+
+	tokens, response, used, err := collect(func(out chan<- string) (string, int, error) {
+		return svc.streamProvider(context.Background(), "why", out)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Pod is ", "OOMKilled"}, tokens)
+	assert.Equal(t, "Pod is OOMKilled", response)
+	assert.Equal(t, 42, used)
+}
+
+func TestStreamAnthropic(t *testing.T) {
+	svc := newStreamTestService(t, ProviderAnthropic, strings.Join([]string{
+		"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":25,\"output_tokens\":1}}}",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0}",
+		"event: ping\ndata: {\"type\":\"ping\"}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Scale \"}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"down\"}}",
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":15}}",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}",
+	}, "\n\n")+"\n\n")
+
+	tokens, response, used, err := collect(func(out chan<- string) (string, int, error) {
+		return svc.streamProvider(context.Background(), "what", out)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Scale ", "down"}, tokens)
+	assert.Equal(t, "Scale down", response)
+	assert.Equal(t, 40, used)
+}
+
+func TestStreamAnthropicMidStreamError(t *testing.T) {
+	svc := newStreamTestService(t, ProviderAnthropic, strings.Join([]string{
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"partial\"}}",
+		"event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}",
+	}, "\n\n")+"\n\n")
+
+	tokens, _, _, err := collect(func(out chan<- string) (string, int, error) {
+		return svc.streamProvider(context.Background(), "what", out)
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Overloaded")
+	assert.Equal(t, []string{"partial"}, tokens)
+}
+
+func TestStreamOllama(t *testing.T) {
+	svc := newStreamTestService(t, ProviderOllama, strings.Join([]string{
+		`{"response":"Use ","done":false}`,
+		`{"response":"HPA","done":false}`,
+		`{"response":"","done":true,"prompt_eval_count":10,"eval_count":5}`,
+	}, "\n")+"\n")
+
+	tokens, response, used, err := collect(func(out chan<- string) (string, int, error) {
+		return svc.streamProvider(context.Background(), "how", out)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Use ", "HPA"}, tokens)
+	assert.Equal(t, "Use HPA", response)
+	assert.Equal(t, 15, used)
+}
+
+func TestStreamTruncated(t *testing.T) {
+	svc := newStreamTestService(t, ProviderOpenAI, `data: {"choices":[{"delta":{"content":"Pod"}}]}`+"\n\n")
+
+	_, _, _, err := collect(func(out chan<- string) (string, int, error) {
+		return svc.streamProvider(context.Background(), "why", out)
+	})
+	assert.ErrorContains(t, err, "stream ended before completion")
+}
+
+func TestStreamCancelled(t *testing.T) {
+	svc := newStreamTestService(t, ProviderOpenAI, strings.Join([]string{
+		`data: {"choices":[{"delta":{"content":"one"}}]}`,
+		`data: {"choices":[{"delta":{"content":"two"}}]}`,
+		`data: [DONE]`,
+	}, "\n\n")+"\n\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := svc.streamProvider(ctx, "why", out)
+		errc <- err
+	}()
+
+	assert.Equal(t, "one", <-out)
+	cancel() // stop reading; the second token can't be delivered
+	assert.ErrorIs(t, <-errc, context.Canceled)
+}
@@ -0,0 +1,334 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// errStructuredUnsupported is returned by callStructured for providers
+// without a structured output mode
+var errStructuredUnsupported = errors.New("structured output not supported by provider")
+
+var (
+	recommendationTypes  = map[string]bool{"cost": true, "performance": true, "security": true, "reliability": true}
+	recommendationLevels = map[string]bool{"high": true, "medium": true, "low": true}
+)
+
+// recommendationSchema is the JSON schema recommendations are requested in
+var recommendationSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"recommendations": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"type":        map[string]interface{}{"type": "string", "enum": []string{"cost", "performance", "security", "reliability"}},
+					"category":    map[string]interface{}{"type": "string"},
+					"title":       map[string]interface{}{"type": "string"},
+					"description": map[string]interface{}{"type": "string"},
+					"impact":      map[string]interface{}{"type": "string", "enum": []string{"high", "medium", "low"}},
+					"effort":      map[string]interface{}{"type": "string", "enum": []string{"high", "medium", "low"}},
+					"resource":    map[string]interface{}{"type": "string", "description": "Kind of the affected resource, e.g. Deployment"},
+					"resource_id": map[string]interface{}{"type": "string", "description": "namespace/name of the affected resource"},
+					"current_state": map[string]interface{}{
+						"type":        "object",
+						"description": "Current settings being changed, e.g. {\"cpu_request\": \"2\"}",
+					},
+					"suggested": map[string]interface{}{
+						"type":        "object",
+						"description": "Suggested settings, keyed like current_state",
+					},
+					"savings": map[string]interface{}{"type": "number", "description": "Estimated monthly savings in USD, 0 if none"},
+				},
+				"required": []string{"type", "title", "description", "impact", "effort", "current_state", "suggested", "savings"},
+			},
+		},
+	},
+	"required": []string{"recommendations"},
+}
+
+// recommendationPayload is one recommendation as the model emits it
+type recommendationPayload struct {
+	Type         string                 `json:"type"`
+	Category     string                 `json:"category"`
+	Title        string                 `json:"title"`
+	Description  string                 `json:"description"`
+	Impact       string                 `json:"impact"`
+	Effort       string                 `json:"effort"`
+	Resource     string                 `json:"resource"`
+	ResourceID   string                 `json:"resource_id"`
+	CurrentState map[string]interface{} `json:"current_state"`
+	Suggested    map[string]interface{} `json:"suggested"`
+	Savings      float64                `json:"savings"`
+}
+
+// structuredRecommendations asks for recommendations as JSON matching
+// recommendationSchema. A response that doesn't parse or validate is sent
+// back once for repair.
+func (s *Service) structuredRecommendations(ctx context.Context, question string, metrics map[string]interface{}) ([]Recommendation, error) {
+	if err := s.checkUserLimit(ctx, "system"); err != nil {
+		return nil, err
+	}
+	if err := s.checkBudget(ctx, "system"); err != nil {
+		return nil, err
+	}
+
+	prompt := s.buildPrompt(ctx, question, IntentOptimize, metrics) +
+		"\n\nRespond with JSON matching the recommendations schema. Give impact and effort as high, medium or low and savings as estimated monthly USD."
+	raw, tokens, err := s.callStructured(ctx, prompt, "recommendations", recommendationSchema)
+	if err != nil {
+		return nil, err
+	}
+	s.recordUsage(ctx, "system", tokens)
+
+	recommendations, err := parseRecommendationsJSON(raw)
+	if err == nil {
+		return recommendations, nil
+	}
+
+	s.logger.Warn("AI returned invalid recommendations, asking for a repair", zap.Error(err))
+	repair := fmt.Sprintf("%s\n\nYour previous response was invalid: %v\n\nPrevious response:\n%s\n\nReturn the corrected JSON only.",
+		prompt, err, raw)
+	raw, tokens, err = s.callStructured(ctx, repair, "recommendations", recommendationSchema)
+	if err != nil {
+		return nil, err
+	}
+	s.recordUsage(ctx, "system", tokens)
+	recommendations, err = parseRecommendationsJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("AI returned invalid recommendations: %w", err)
+	}
+	return recommendations, nil
+}
+
+// callStructured asks the provider for a JSON object conforming to schema:
+// a json_schema response format on OpenAI and Azure, a forced tool call on
+// Anthropic. Other providers return errStructuredUnsupported.
+func (s *Service) callStructured(ctx context.Context, prompt, name string, schema map[string]interface{}) (json.RawMessage, int, error) {
+	switch s.config.Provider {
+	case ProviderOpenAI, ProviderAzure, ProviderAnthropic, "":
+	default:
+		return nil, 0, errStructuredUnsupported
+	}
+
+	estimate := estimateTokens(prompt)
+	if err := s.acquireProvider(ctx, estimate); err != nil {
+		return nil, 0, err
+	}
+
+	var raw json.RawMessage
+	var tokens int
+	var err error
+	if s.config.Provider == ProviderAnthropic {
+		raw, tokens, err = s.anthropicStructured(ctx, prompt, name, schema)
+	} else {
+		raw, tokens, err = s.openAIStructured(ctx, prompt, name, schema)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	s.settleProvider(ctx, estimate, tokens)
+	return raw, tokens, nil
+}
+
+func (s *Service) openAIStructured(ctx context.Context, prompt, name string, schema map[string]interface{}) (json.RawMessage, int, error) {
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	endpoint := s.config.Endpoint
+	headers := map[string]string{"Authorization": "Bearer " + key}
+	if s.config.Provider == ProviderAzure {
+		if endpoint, err = s.azureURL(); err != nil {
+			return nil, 0, err
+		}
+		headers = map[string]string{"api-key": key}
+	} else if endpoint == "" {
+		endpoint = "https://api.openai.com/v1/chat/completions"
+	}
+
+	requestBody := map[string]interface{}{
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"max_tokens":  s.config.MaxTokens,
+		"temperature": s.config.Temperature,
+		"top_p":       s.config.TopP,
+		"response_format": map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   name,
+				"schema": schema,
+			},
+		},
+	}
+	if s.config.Provider != ProviderAzure {
+		requestBody["model"] = s.config.Model
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			TotalTokens int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := s.postProviderJSON(ctx, endpoint, headers, requestBody, &result); err != nil {
+		return nil, 0, err
+	}
+	if len(result.Choices) == 0 {
+		return nil, 0, fmt.Errorf("no response from AI")
+	}
+	return json.RawMessage(result.Choices[0].Message.Content), result.Usage.TotalTokens, nil
+}
+
+// anthropicStructured forces a call to a tool whose input schema is schema;
+// the tool input is the structured result
+func (s *Service) anthropicStructured(ctx context.Context, prompt, name string, schema map[string]interface{}) (json.RawMessage, int, error) {
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.anthropic.com/v1/messages"
+	}
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	headers := map[string]string{
+		"x-api-key":         key,
+		"anthropic-version": "2024-01-01",
+	}
+
+	requestBody := map[string]interface{}{
+		"model":      s.config.Model,
+		"max_tokens": s.config.MaxTokens,
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"tools": []map[string]interface{}{{
+			"name":         name,
+			"description":  "Record the " + name + " for the user.",
+			"input_schema": schema,
+		}},
+		"tool_choice": map[string]string{"type": "tool", "name": name},
+	}
+
+	var result struct {
+		Content []struct {
+			Type  string          `json:"type"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := s.postProviderJSON(ctx, endpoint, headers, requestBody, &result); err != nil {
+		return nil, 0, err
+	}
+	tokens := result.Usage.InputTokens + result.Usage.OutputTokens
+	for _, block := range result.Content {
+		if block.Type == "tool_use" && block.Name == name {
+			return block.Input, tokens, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no %s in AI response", name)
+}
+
+// parseRecommendationsJSON decodes and validates recommendations emitted
+// against recommendationSchema
+func parseRecommendationsJSON(raw json.RawMessage) ([]Recommendation, error) {
+	var payload struct {
+		Recommendations []recommendationPayload `json:"recommendations"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %w", err)
+	}
+	if payload.Recommendations == nil {
+		return nil, fmt.Errorf("missing recommendations")
+	}
+
+	var problems []string
+	recommendations := make([]Recommendation, 0, len(payload.Recommendations))
+	for i, p := range payload.Recommendations {
+		p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+		p.Impact = strings.ToLower(strings.TrimSpace(p.Impact))
+		p.Effort = strings.ToLower(strings.TrimSpace(p.Effort))
+
+		if strings.TrimSpace(p.Title) == "" {
+			problems = append(problems, fmt.Sprintf("recommendations[%d]: title is required", i))
+		}
+		if !recommendationTypes[p.Type] {
+			problems = append(problems, fmt.Sprintf("recommendations[%d]: invalid type %q", i, p.Type))
+		}
+		if !recommendationLevels[p.Impact] {
+			problems = append(problems, fmt.Sprintf("recommendations[%d]: invalid impact %q", i, p.Impact))
+		}
+		if !recommendationLevels[p.Effort] {
+			problems = append(problems, fmt.Sprintf("recommendations[%d]: invalid effort %q", i, p.Effort))
+		}
+		if p.Savings < 0 {
+			problems = append(problems, fmt.Sprintf("recommendations[%d]: savings must not be negative", i))
+		}
+
+		recommendations = append(recommendations, Recommendation{
+			Type:         p.Type,
+			Category:     p.Category,
+			Title:        strings.TrimSpace(p.Title),
+			Description:  strings.TrimSpace(p.Description),
+			Impact:       p.Impact,
+			Effort:       p.Effort,
+			Resource:     p.Resource,
+			ResourceID:   p.ResourceID,
+			CurrentState: p.CurrentState,
+			Suggested:    p.Suggested,
+			Savings:      p.Savings,
+		})
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return recommendations, nil
+}
+
+var (
+	markdownFieldPattern = regexp.MustCompile(`^(?i)[-*\s]*\**(impact|effort|savings|type)\**\s*:\**\s*(.+)$`)
+	savingsPattern       = regexp.MustCompile(`[0-9][0-9,]*(\.[0-9]+)?`)
+)
+
+func setMarkdownField(rec *Recommendation, field, value string) {
+	lower := strings.ToLower(value)
+	switch field {
+	case "impact", "effort":
+		for level := range recommendationLevels {
+			if strings.HasPrefix(lower, level) {
+				if field == "impact" {
+					rec.Impact = level
+				} else {
+					rec.Effort = level
+				}
+			}
+		}
+	case "type":
+		if recommendationTypes[lower] {
+			rec.Type = lower
+		}
+	case "savings":
+		if amount := savingsPattern.FindString(value); amount != "" {
+			if f, err := strconv.ParseFloat(strings.ReplaceAll(amount, ",", ""), 64); err == nil {
+				rec.Savings = f
+			}
+		}
+	}
+}
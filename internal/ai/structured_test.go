@@ -0,0 +1,173 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const cannedRecommendations = `{"recommendations":[{
+	"type": "cost",
+	"category": "rightsizing",
+	"title": "Reduce CPU requests for checkout",
+	"description": "checkout requests 2 CPU but peaks at 300m.",
+	"impact": "High",
+	"effort": "low",
+	"resource": "Deployment",
+	"resource_id": "shop/checkout",
+	"current_state": {"cpu_request": "2"},
+	"suggested": {"cpu_request": "500m"},
+	"savings": 142.5
+}]}`
+
+func assertCannedRecommendation(t *testing.T, recs []Recommendation) {
+	t.Helper()
+	require.Len(t, recs, 1)
+	rec := recs[0]
+	assert.Equal(t, "cost", rec.Type)
+	assert.Equal(t, "rightsizing", rec.Category)
+	assert.Equal(t, "Reduce CPU requests for checkout", rec.Title)
+	assert.Equal(t, "high", rec.Impact)
+	assert.Equal(t, "low", rec.Effort)
+	assert.Equal(t, "Deployment", rec.Resource)
+	assert.Equal(t, "shop/checkout", rec.ResourceID)
+	assert.Equal(t, map[string]interface{}{"cpu_request": "2"}, rec.CurrentState)
+	assert.Equal(t, map[string]interface{}{"cpu_request": "500m"}, rec.Suggested)
+	assert.Equal(t, 142.5, rec.Savings)
+}
+
+// sequenceServer answers successive requests with responses in turn and
+// keeps each request body
+type sequenceServer struct {
+	*httptest.Server
+	bodies []map[string]interface{}
+}
+
+func newSequenceServer(t *testing.T, responses ...string) *sequenceServer {
+	ss := &sequenceServer{}
+	ss.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		_ = json.Unmarshal(raw, &body)
+		n := len(ss.bodies)
+		ss.bodies = append(ss.bodies, body)
+		if n >= len(responses) {
+			http.Error(w, "unexpected request", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, responses[n])
+	}))
+	t.Cleanup(ss.Close)
+	return ss
+}
+
+func openAIContent(t *testing.T, content string) string {
+	data, err := json.Marshal(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"message": map[string]string{"content": content}}},
+		"usage":   map[string]int{"total_tokens": 100},
+	})
+	require.NoError(t, err)
+	return string(data)
+}
+
+func newStructuredTestService(provider Provider, endpoint string) *Service {
+	return &Service{
+		logger:     zap.NewNop(),
+		httpClient: http.DefaultClient,
+		config:     &Config{Provider: provider, Endpoint: endpoint, Model: "test-model", SystemPrompt: "You are a test assistant."},
+	}
+}
+
+func TestStructuredRecommendationsOpenAI(t *testing.T) {
+	server := newSequenceServer(t, openAIContent(t, cannedRecommendations))
+	svc := newStructuredTestService(ProviderOpenAI, server.URL)
+
+	recs, err := svc.structuredRecommendations(context.Background(), "Optimize", map[string]interface{}{"cpu": 0.15})
+	require.NoError(t, err)
+	assertCannedRecommendation(t, recs)
+
+	format := server.bodies[0]["response_format"].(map[string]interface{})
+	assert.Equal(t, "json_schema", format["type"])
+	assert.Equal(t, "recommendations", format["json_schema"].(map[string]interface{})["name"])
+}
+
+func TestStructuredRecommendationsAnthropic(t *testing.T) {
+	response := `{"content":[{"type":"tool_use","id":"t1","name":"recommendations","input":` + cannedRecommendations + `}],
+		"usage":{"input_tokens":80,"output_tokens":40}}`
+	server := newSequenceServer(t, response)
+	svc := newStructuredTestService(ProviderAnthropic, server.URL)
+
+	recs, err := svc.structuredRecommendations(context.Background(), "Optimize", nil)
+	require.NoError(t, err)
+	assertCannedRecommendation(t, recs)
+
+	choice := server.bodies[0]["tool_choice"].(map[string]interface{})
+	assert.Equal(t, "tool", choice["type"])
+	assert.Equal(t, "recommendations", choice["name"])
+}
+
+func TestStructuredRecommendationsRepair(t *testing.T) {
+	server := newSequenceServer(t,
+		openAIContent(t, `{"recommendations":[{"type":"cost","title":"Reduce CPU"`),
+		openAIContent(t, cannedRecommendations),
+	)
+	svc := newStructuredTestService(ProviderOpenAI, server.URL)
+
+	recs, err := svc.structuredRecommendations(context.Background(), "Optimize", nil)
+	require.NoError(t, err)
+	assertCannedRecommendation(t, recs)
+
+	require.Len(t, server.bodies, 2)
+	repair := server.bodies[1]["messages"].([]interface{})[0].(map[string]interface{})["content"].(string)
+	assert.Contains(t, repair, "malformed JSON")
+	assert.Contains(t, repair, `"title":"Reduce CPU"`)
+}
+
+func TestStructuredRecommendationsRepairFails(t *testing.T) {
+	invalid := openAIContent(t, `{"recommendations":[{"type":"cost","title":"x","impact":"huge","effort":"low"}]}`)
+	server := newSequenceServer(t, invalid, invalid)
+	svc := newStructuredTestService(ProviderOpenAI, server.URL)
+
+	_, err := svc.structuredRecommendations(context.Background(), "Optimize", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid impact "huge"`)
+	assert.Len(t, server.bodies, 2, "only one repair attempt")
+}
+
+func TestCallStructuredUnsupported(t *testing.T) {
+	svc := newStructuredTestService(ProviderOllama, "")
+	_, _, err := svc.callStructured(context.Background(), "prompt", "recommendations", recommendationSchema)
+	assert.ErrorIs(t, err, errStructuredUnsupported)
+}
+
+func TestParseRecommendationsMarkdown(t *testing.T) {
+	svc := newStructuredTestService(ProviderOllama, "")
+	recs := svc.parseRecommendations(`## Reduce CPU requests for checkout
+checkout requests 2 CPU but peaks at 300m.
+**Impact:** High
+- Effort: low
+- Savings: $1,240.50/month
+- Type: cost
+
+## Add a PodDisruptionBudget
+Protects availability during node drains.
+`, nil)
+
+	require.Len(t, recs, 2)
+	assert.Equal(t, "Reduce CPU requests for checkout", recs[0].Title)
+	assert.Equal(t, "checkout requests 2 CPU but peaks at 300m.", recs[0].Description)
+	assert.Equal(t, "high", recs[0].Impact)
+	assert.Equal(t, "low", recs[0].Effort)
+	assert.Equal(t, 1240.5, recs[0].Savings)
+	assert.Equal(t, "cost", recs[0].Type)
+	assert.Equal(t, "performance", recs[1].Type)
+	assert.Empty(t, recs[1].Impact)
+}
@@ -0,0 +1,413 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultMaxToolIterations bounds how many rounds of tool calls the model
+	// may make before it must answer
+	defaultMaxToolIterations = 5
+
+	// maxToolResult truncates tool output fed back to the model
+	maxToolResult = 16 * 1024
+)
+
+// Tool is a function the model may call to fetch live context. Every call
+// is authorized against Resource and Action for the requesting user, in the
+// cluster and namespace named by its "cluster_id" and "namespace" arguments.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON schema of the arguments
+	Resource    string
+	Action      string
+	Run         func(ctx context.Context, args map[string]interface{}) (interface{}, error)
+}
+
+// ToolAuthorizer reports whether userID may perform action on resource in
+// a cluster and (optionally) namespace
+type ToolAuthorizer func(ctx context.Context, userID, clusterID, namespace, resource, action string) (bool, error)
+
+// ToolCall records one tool invocation made while answering a query
+type ToolCall struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+	Result    string                 `json:"result,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Denied    bool                   `json:"denied,omitempty"`
+	Duration  time.Duration          `json:"duration"`
+}
+
+// RegisterTool makes tool available to AskQuestionWithTools. Tools must be
+// registered before the service handles requests.
+func (s *Service) RegisterTool(tools ...Tool) {
+	if s.tools == nil {
+		s.tools = make(map[string]Tool)
+	}
+	for _, tool := range tools {
+		if _, ok := s.tools[tool.Name]; !ok {
+			s.toolOrder = append(s.toolOrder, tool.Name)
+		}
+		s.tools[tool.Name] = tool
+	}
+}
+
+// SetToolAuthorizer sets the permission check applied to every tool call.
+// Without one, every tool call is denied.
+func (s *Service) SetToolAuthorizer(authorize ToolAuthorizer) { s.toolAuth = authorize }
+
+// AskQuestionWithTools answers question, letting the model call registered
+// tools to fetch the context it needs. Tool calls run with userID's
+// permissions and are recorded on the returned Query. Providers without
+// tool support, or a service without tools, fall back to AskQuestion.
+func (s *Service) AskQuestionWithTools(ctx context.Context, userID, question string, context map[string]interface{}, opts ...AskOptions) (*Query, error) {
+	if len(s.tools) == 0 {
+		return s.AskQuestion(ctx, userID, question, context, opts...)
+	}
+	switch s.config.Provider {
+	case ProviderOpenAI, ProviderAzure, ProviderAnthropic, "":
+	default:
+		return s.AskQuestion(ctx, userID, question, context, opts...)
+	}
+
+	if err := s.checkUserLimit(ctx, userID); err != nil {
+		return nil, err
+	}
+	if err := s.checkBudget(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	intent := s.detectIntent(question)
+	prompt := s.buildPrompt(ctx, question, intent, context) +
+		"\n\nUse the available tools to fetch any logs, events or resource details you need before answering."
+
+	startTime := time.Now()
+	var response string
+	var tokensUsed int
+	var trace []ToolCall
+	var err error
+	if s.config.Provider == ProviderAnthropic {
+		response, tokensUsed, trace, err = s.anthropicToolLoop(ctx, userID, prompt)
+	} else {
+		response, tokensUsed, trace, err = s.openAIToolLoop(ctx, userID, prompt)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AI response: %w", err)
+	}
+	s.recordUsage(ctx, userID, tokensUsed)
+
+	query := &Query{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Query:      question,
+		Intent:     intent,
+		Context:    context,
+		Response:   response,
+		Model:      s.config.Model,
+		TokensUsed: tokensUsed,
+		Latency:    time.Since(startTime),
+		ToolCalls:  trace,
+		CreatedAt:  time.Now(),
+	}
+
+	if err := s.db.Create(query).Error; err != nil {
+		s.logger.Warn("Failed to save query", zap.Error(err))
+	}
+
+	return query, nil
+}
+
+func (s *Service) maxToolIterations() int {
+	if s.config.MaxToolIterations > 0 {
+		return s.config.MaxToolIterations
+	}
+	return defaultMaxToolIterations
+}
+
+// openAIToolLoop runs a chat completions conversation (OpenAI or Azure),
+// executing tool calls until the model answers. Once the iteration bound is
+// reached the model is asked to answer without further tools.
+func (s *Service) openAIToolLoop(ctx context.Context, userID, prompt string) (string, int, []ToolCall, error) {
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	endpoint := s.config.Endpoint
+	headers := map[string]string{"Authorization": "Bearer " + key}
+	if s.config.Provider == ProviderAzure {
+		if endpoint, err = s.azureURL(); err != nil {
+			return "", 0, nil, err
+		}
+		headers = map[string]string{"api-key": key}
+	} else if endpoint == "" {
+		endpoint = "https://api.openai.com/v1/chat/completions"
+	}
+
+	tools := make([]map[string]interface{}, 0, len(s.toolOrder))
+	for _, name := range s.toolOrder {
+		tool := s.tools[name]
+		tools = append(tools, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  tool.Parameters,
+			},
+		})
+	}
+
+	messages := []interface{}{map[string]string{"role": "user", "content": prompt}}
+	var trace []ToolCall
+	tokens := 0
+	for iteration := 0; ; iteration++ {
+		requestBody := map[string]interface{}{
+			"messages":    messages,
+			"tools":       tools,
+			"max_tokens":  s.config.MaxTokens,
+			"temperature": s.config.Temperature,
+			"top_p":       s.config.TopP,
+		}
+		if s.config.Provider != ProviderAzure {
+			requestBody["model"] = s.config.Model
+		}
+		last := iteration >= s.maxToolIterations()
+		if last {
+			requestBody["tool_choice"] = "none"
+		}
+
+		var result struct {
+			Choices []struct {
+				Message json.RawMessage `json:"message"`
+			} `json:"choices"`
+			Usage struct {
+				TotalTokens int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		estimate := estimateTokens(prompt)
+		if err := s.acquireProvider(ctx, estimate); err != nil {
+			return "", 0, trace, err
+		}
+		if err := s.postProviderJSON(ctx, endpoint, headers, requestBody, &result); err != nil {
+			return "", 0, trace, err
+		}
+		s.settleProvider(ctx, estimate, result.Usage.TotalTokens)
+		tokens += result.Usage.TotalTokens
+		if len(result.Choices) == 0 {
+			return "", 0, trace, fmt.Errorf("no response from AI")
+		}
+
+		var message struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		}
+		if err := json.Unmarshal(result.Choices[0].Message, &message); err != nil {
+			return "", 0, trace, err
+		}
+		if len(message.ToolCalls) == 0 || last {
+			return message.Content, tokens, trace, nil
+		}
+
+		messages = append(messages, result.Choices[0].Message)
+		for _, call := range message.ToolCalls {
+			content, record := s.runTool(ctx, userID, call.Function.Name, []byte(call.Function.Arguments))
+			trace = append(trace, record)
+			messages = append(messages, map[string]string{
+				"role":         "tool",
+				"tool_call_id": call.ID,
+				"content":      content,
+			})
+		}
+	}
+}
+
+// anthropicToolLoop runs a Messages API conversation, executing tool_use
+// blocks until the model answers
+func (s *Service) anthropicToolLoop(ctx context.Context, userID, prompt string) (string, int, []ToolCall, error) {
+	endpoint := s.config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.anthropic.com/v1/messages"
+	}
+	key, err := s.apiKey(ctx)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	headers := map[string]string{
+		"x-api-key":         key,
+		"anthropic-version": "2024-01-01",
+	}
+
+	tools := make([]map[string]interface{}, 0, len(s.toolOrder))
+	for _, name := range s.toolOrder {
+		tool := s.tools[name]
+		tools = append(tools, map[string]interface{}{
+			"name":         tool.Name,
+			"description":  tool.Description,
+			"input_schema": tool.Parameters,
+		})
+	}
+
+	messages := []interface{}{map[string]string{"role": "user", "content": prompt}}
+	var trace []ToolCall
+	tokens := 0
+	for iteration := 0; ; iteration++ {
+		requestBody := map[string]interface{}{
+			"model":      s.config.Model,
+			"max_tokens": s.config.MaxTokens,
+			"messages":   messages,
+			"tools":      tools,
+		}
+		last := iteration >= s.maxToolIterations()
+		if last {
+			requestBody["tool_choice"] = map[string]string{"type": "none"}
+		}
+
+		var result struct {
+			Content []json.RawMessage `json:"content"`
+			Usage   struct {
+				InputTokens  int `json:"input_tokens"`
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		estimate := estimateTokens(prompt)
+		if err := s.acquireProvider(ctx, estimate); err != nil {
+			return "", 0, trace, err
+		}
+		if err := s.postProviderJSON(ctx, endpoint, headers, requestBody, &result); err != nil {
+			return "", 0, trace, err
+		}
+		s.settleProvider(ctx, estimate, result.Usage.InputTokens+result.Usage.OutputTokens)
+		tokens += result.Usage.InputTokens + result.Usage.OutputTokens
+
+		var text string
+		var results []map[string]interface{}
+		for _, raw := range result.Content {
+			var block struct {
+				Type  string          `json:"type"`
+				Text  string          `json:"text"`
+				ID    string          `json:"id"`
+				Name  string          `json:"name"`
+				Input json.RawMessage `json:"input"`
+			}
+			if err := json.Unmarshal(raw, &block); err != nil {
+				return "", 0, trace, err
+			}
+			switch block.Type {
+			case "text":
+				text += block.Text
+			case "tool_use":
+				if last {
+					continue
+				}
+				content, record := s.runTool(ctx, userID, block.Name, block.Input)
+				trace = append(trace, record)
+				results = append(results, map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": block.ID,
+					"content":     content,
+					"is_error":    record.Error != "",
+				})
+			}
+		}
+		if len(results) == 0 {
+			if text == "" {
+				return "", 0, trace, fmt.Errorf("no response from AI")
+			}
+			return text, tokens, trace, nil
+		}
+
+		messages = append(messages,
+			map[string]interface{}{"role": "assistant", "content": result.Content},
+			map[string]interface{}{"role": "user", "content": results},
+		)
+	}
+}
+
+// runTool authorizes and executes a tool call, returning the content to
+// feed back to the model and the trace entry. Failures are reported to the
+// model rather than aborting the conversation.
+func (s *Service) runTool(ctx context.Context, userID, name string, rawArgs []byte) (string, ToolCall) {
+	record := ToolCall{Name: name}
+	start := time.Now()
+
+	fail := func(format string, args ...interface{}) (string, ToolCall) {
+		record.Error = fmt.Sprintf(format, args...)
+		record.Duration = time.Since(start)
+		return "error: " + record.Error, record
+	}
+
+	tool, ok := s.tools[name]
+	if !ok {
+		return fail("unknown tool %q", name)
+	}
+
+	args := map[string]interface{}{}
+	if len(bytes.TrimSpace(rawArgs)) > 0 {
+		if err := json.Unmarshal(rawArgs, &args); err != nil {
+			return fail("invalid arguments: %v", err)
+		}
+	}
+	record.Arguments = args
+
+	clusterID, _ := args["cluster_id"].(string)
+	namespace, _ := args["namespace"].(string)
+	allowed := false
+	if s.toolAuth != nil {
+		var err error
+		allowed, err = s.toolAuth(ctx, userID, clusterID, namespace, tool.Resource, tool.Action)
+		if err != nil {
+			return fail("authorization failed: %v", err)
+		}
+	}
+	if !allowed {
+		record.Denied = true
+		s.logger.Info("AI tool call denied",
+			zap.String("user_id", userID),
+			zap.String("tool", name),
+			zap.String("cluster_id", clusterID),
+			zap.String("namespace", namespace),
+		)
+		return fail("permission denied: %s %s", tool.Action, tool.Resource)
+	}
+
+	output, err := tool.Run(ctx, args)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	content, ok := output.(string)
+	if !ok {
+		data, err := json.Marshal(output)
+		if err != nil {
+			return fail("failed to encode result: %v", err)
+		}
+		content = string(data)
+	}
+	// Keep the end, where the most recent log lines and events are
+	if len(content) > maxToolResult {
+		content = content[len(content)-maxToolResult:]
+	}
+
+	record.Result = content
+	record.Duration = time.Since(start)
+	return content, record
+}
+
+// postProviderJSON posts requestBody as JSON with headers and decodes the
+// response into result
+func (s *Service) postProviderJSON(ctx context.Context, endpoint string, headers map[string]string, requestBody interface{}, result interface{}) error {
+	return postJSON(ctx, s.httpClient, endpoint, headers, requestBody, result)
+}
@@ -0,0 +1,155 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// scriptedServer replies to successive requests with responses, repeating
+// the last one, and keeps every request body
+type scriptedServer struct {
+	*httptest.Server
+	bodies []map[string]interface{}
+}
+
+func newScriptedServer(t *testing.T, responses ...string) *scriptedServer {
+	ss := &scriptedServer{}
+	ss.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		_ = json.Unmarshal(raw, &body)
+		ss.bodies = append(ss.bodies, body)
+
+		i := len(ss.bodies) - 1
+		if i >= len(responses) {
+			i = len(responses) - 1
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, responses[i])
+	}))
+	t.Cleanup(ss.Close)
+	return ss
+}
+
+func newToolTestService(server *scriptedServer, provider Provider) *Service {
+	svc := &Service{
+		logger:     zap.NewNop(),
+		config:     &Config{Provider: provider, Endpoint: server.URL, Model: "test"},
+		httpClient: server.Client(),
+	}
+	svc.RegisterTool(Tool{
+		Name:        "get_pod_logs",
+		Description: "Fetch pod logs",
+		Parameters:  map[string]interface{}{"type": "object"},
+		Resource:    "namespace",
+		Action:      "read",
+		Run: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			return fmt.Sprintf("OOMKilled: %s", args["pod"]), nil
+		},
+	})
+	return svc
+}
+
+const openAIToolCall = `{"choices":[{"message":{"role":"assistant","content":null,"tool_calls":[
+	{"id":"call_1","type":"function","function":{"name":"get_pod_logs","arguments":"{\"cluster_id\":\"c1\",\"namespace\":\"shop\",\"pod\":\"api-0\"}"}}]}}],
+	"usage":{"total_tokens":10}}`
+
+func TestOpenAIToolLoop(t *testing.T) {
+	server := newScriptedServer(t, openAIToolCall,
+		`{"choices":[{"message":{"role":"assistant","content":"The pod ran out of memory"}}],"usage":{"total_tokens":7}}`)
+	svc := newToolTestService(server, ProviderOpenAI)
+
+	var authorized []string
+	svc.SetToolAuthorizer(func(ctx context.Context, userID, clusterID, namespace, resource, action string) (bool, error) {
+		authorized = append(authorized, fmt.Sprintf("%s %s/%s %s %s", userID, clusterID, namespace, action, resource))
+		return true, nil
+	})
+
+	response, tokens, trace, err := svc.openAIToolLoop(context.Background(), "alice", "why")
+	require.NoError(t, err)
+	assert.Equal(t, "The pod ran out of memory", response)
+	assert.Equal(t, 17, tokens)
+	assert.Equal(t, []string{"alice c1/shop read namespace"}, authorized)
+
+	require.Len(t, trace, 1)
+	assert.Equal(t, "get_pod_logs", trace[0].Name)
+	assert.Equal(t, "api-0", trace[0].Arguments["pod"])
+	assert.Equal(t, "OOMKilled: api-0", trace[0].Result)
+
+	require.Len(t, server.bodies, 2)
+	tools := server.bodies[0]["tools"].([]interface{})
+	assert.Equal(t, "get_pod_logs", tools[0].(map[string]interface{})["function"].(map[string]interface{})["name"])
+	messages := server.bodies[1]["messages"].([]interface{})
+	require.Len(t, messages, 3)
+	result := messages[2].(map[string]interface{})
+	assert.Equal(t, "tool", result["role"])
+	assert.Equal(t, "call_1", result["tool_call_id"])
+	assert.Equal(t, "OOMKilled: api-0", result["content"])
+}
+
+func TestToolCallDenied(t *testing.T) {
+	server := newScriptedServer(t, openAIToolCall,
+		`{"choices":[{"message":{"role":"assistant","content":"I could not read the logs"}}]}`)
+	svc := newToolTestService(server, ProviderOpenAI)
+	svc.SetToolAuthorizer(func(ctx context.Context, userID, clusterID, namespace, resource, action string) (bool, error) {
+		return false, nil
+	})
+
+	_, _, trace, err := svc.openAIToolLoop(context.Background(), "bob", "why")
+	require.NoError(t, err)
+	require.Len(t, trace, 1)
+	assert.True(t, trace[0].Denied)
+	assert.Empty(t, trace[0].Result)
+
+	messages := server.bodies[1]["messages"].([]interface{})
+	assert.Contains(t, messages[2].(map[string]interface{})["content"], "permission denied")
+}
+
+func TestToolIterationsBounded(t *testing.T) {
+	// The model keeps asking for tools; after the bound it must answer
+	server := newScriptedServer(t, openAIToolCall)
+	svc := newToolTestService(server, ProviderOpenAI)
+	svc.config.MaxToolIterations = 2
+	svc.SetToolAuthorizer(func(context.Context, string, string, string, string, string) (bool, error) { return true, nil })
+
+	_, _, trace, err := svc.openAIToolLoop(context.Background(), "alice", "why")
+	require.NoError(t, err)
+	assert.Len(t, trace, 2)
+	require.Len(t, server.bodies, 3)
+	assert.Equal(t, "none", server.bodies[2]["tool_choice"])
+}
+
+func TestAnthropicToolLoop(t *testing.T) {
+	server := newScriptedServer(t,
+		`{"content":[{"type":"text","text":"Checking logs."},{"type":"tool_use","id":"toolu_1","name":"get_pod_logs","input":{"cluster_id":"c1","namespace":"shop","pod":"api-0"}}],
+		"stop_reason":"tool_use","usage":{"input_tokens":20,"output_tokens":5}}`,
+		`{"content":[{"type":"text","text":"Raise the memory limit"}],"stop_reason":"end_turn","usage":{"input_tokens":30,"output_tokens":6}}`)
+	svc := newToolTestService(server, ProviderAnthropic)
+	svc.SetToolAuthorizer(func(context.Context, string, string, string, string, string) (bool, error) { return true, nil })
+
+	response, tokens, trace, err := svc.anthropicToolLoop(context.Background(), "alice", "why")
+	require.NoError(t, err)
+	assert.Equal(t, "Raise the memory limit", response)
+	assert.Equal(t, 61, tokens)
+	require.Len(t, trace, 1)
+
+	tools := server.bodies[0]["tools"].([]interface{})
+	assert.Contains(t, tools[0], "input_schema")
+	messages := server.bodies[1]["messages"].([]interface{})
+	require.Len(t, messages, 3)
+	assert.Equal(t, "assistant", messages[1].(map[string]interface{})["role"])
+	block := messages[2].(map[string]interface{})["content"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "tool_result", block["type"])
+	assert.Equal(t, "toolu_1", block["tool_use_id"])
+	assert.Equal(t, "OOMKilled: api-0", block["content"])
+	assert.Equal(t, false, block["is_error"])
+}
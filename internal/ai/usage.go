@@ -0,0 +1,287 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/anubhavg-icpl/krustron/pkg/nats"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// budgetWarnRatio is the share of a monthly cap at which a warning is
+// emitted
+const budgetWarnRatio = 0.8
+
+// UsagePeriod is the window GetUsage aggregates over
+type UsagePeriod string
+
+// Usage periods
+const (
+	UsagePeriodDay   UsagePeriod = "day"
+	UsagePeriodMonth UsagePeriod = "month"
+)
+
+// Budget scopes
+const (
+	BudgetScopeUser = "user"
+	BudgetScopeOrg  = "org"
+)
+
+// UsageRecord is one user's usage of one model on one UTC day. Queries,
+// chat replies and generated recommendations all add to it.
+type UsageRecord struct {
+	UserID    string    `json:"user_id" gorm:"primaryKey"`
+	Day       time.Time `json:"day" gorm:"primaryKey"`
+	Model     string    `json:"model" gorm:"primaryKey"`
+	Requests  int64     `json:"requests"`
+	Tokens    int64     `json:"tokens"`
+	Cost      float64   `json:"cost"` // estimated USD
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName keeps usage next to the other AI tables
+func (UsageRecord) TableName() string { return "ai_usage" }
+
+// budgetWarning records that a warning was emitted for a scope's month, so
+// it is emitted once across replicas and restarts
+type budgetWarning struct {
+	Scope     string    `gorm:"primaryKey"` // "org" or "user:<id>"
+	Month     time.Time `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+func (budgetWarning) TableName() string { return "ai_budget_warnings" }
+
+// Usage is AI usage summed over a period, with the monthly caps that apply
+type Usage struct {
+	UserID     string      `json:"user_id,omitempty"` // empty for the whole org
+	Period     UsagePeriod `json:"period"`
+	Start      time.Time   `json:"start"`
+	End        time.Time   `json:"end"`
+	Requests   int64       `json:"requests"`
+	Tokens     int64       `json:"tokens"`
+	Cost       float64     `json:"cost"`
+	TokenLimit int64       `json:"token_limit,omitempty"` // monthly, 0 if uncapped
+	CostLimit  float64     `json:"cost_limit,omitempty"`  // monthly USD, 0 if uncapped
+}
+
+// BudgetExceededError is returned once a user or the org has used its
+// monthly token or cost cap
+type BudgetExceededError struct {
+	Scope string  `json:"scope"` // BudgetScopeUser or BudgetScopeOrg
+	Limit string  `json:"limit"` // "tokens" or "cost"
+	Used  float64 `json:"used"`
+	Cap   float64 `json:"cap"`
+}
+
+func (e *BudgetExceededError) Error() string {
+	who := "your"
+	if e.Scope == BudgetScopeOrg {
+		who = "the organization's"
+	}
+	if e.Limit == "cost" {
+		return fmt.Sprintf("%s monthly AI budget is used up ($%.2f of $%.2f); it resets at the start of next month", who, e.Used, e.Cap)
+	}
+	return fmt.Sprintf("%s monthly AI token budget is used up (%.0f of %.0f tokens); it resets at the start of next month", who, e.Used, e.Cap)
+}
+
+// SetEventBus sets where budget warnings are published
+func (s *Service) SetEventBus(bus *nats.EventBus) { s.events = bus }
+
+// GetUsage sums userID's usage over the current day or month, or the whole
+// org's if userID is empty
+func (s *Service) GetUsage(ctx context.Context, userID string, period UsagePeriod) (*Usage, error) {
+	now := time.Now().UTC()
+	start := monthStart(now)
+	end := start.AddDate(0, 1, 0)
+	switch period {
+	case UsagePeriodMonth:
+	case UsagePeriodDay:
+		start = dayStart(now)
+		end = start.AddDate(0, 0, 1)
+	default:
+		return nil, fmt.Errorf("invalid usage period: %s", period)
+	}
+
+	usage, err := s.sumUsage(ctx, userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	usage.Period = period
+	if userID == "" {
+		usage.TokenLimit, usage.CostLimit = s.config.OrgMonthlyTokenLimit, s.config.OrgMonthlyCostLimit
+	} else {
+		usage.TokenLimit, usage.CostLimit = s.config.UserMonthlyTokenLimit, s.config.UserMonthlyCostLimit
+	}
+	return usage, nil
+}
+
+func (s *Service) sumUsage(ctx context.Context, userID string, start, end time.Time) (*Usage, error) {
+	usage := &Usage{UserID: userID, Start: start, End: end}
+	q := s.db.WithContext(ctx).Model(&UsageRecord{}).
+		Select("COALESCE(SUM(requests), 0) AS requests, COALESCE(SUM(tokens), 0) AS tokens, COALESCE(SUM(cost), 0) AS cost").
+		Where("day >= ? AND day < ?", start, end)
+	if userID != "" {
+		q = q.Where("user_id = ?", userID)
+	}
+	if err := q.Scan(usage).Error; err != nil {
+		return nil, fmt.Errorf("failed to get AI usage: %w", err)
+	}
+	return usage, nil
+}
+
+// checkBudget rejects a request once userID or the org has used a monthly
+// cap
+func (s *Service) checkBudget(ctx context.Context, userID string) error {
+	if s.db == nil {
+		return nil
+	}
+	month := monthStart(time.Now().UTC())
+	for _, b := range s.budgets(userID) {
+		if b.tokenCap <= 0 && b.costCap <= 0 {
+			continue
+		}
+		usage, err := s.sumUsage(ctx, b.userID, month, month.AddDate(0, 1, 0))
+		if err != nil {
+			return err
+		}
+		if b.tokenCap > 0 && usage.Tokens >= b.tokenCap {
+			return &BudgetExceededError{Scope: b.scope, Limit: "tokens", Used: float64(usage.Tokens), Cap: float64(b.tokenCap)}
+		}
+		if b.costCap > 0 && usage.Cost >= b.costCap {
+			return &BudgetExceededError{Scope: b.scope, Limit: "cost", Used: usage.Cost, Cap: b.costCap}
+		}
+	}
+	return nil
+}
+
+// recordUsage adds tokens used for userID to today's usage and warns once
+// a monthly cap is 80% used
+func (s *Service) recordUsage(ctx context.Context, userID string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	metrics.AITokens.WithLabelValues(string(s.config.Provider), s.config.Model).Add(float64(tokens))
+	if s.db == nil {
+		return
+	}
+	if userID == "" {
+		userID = "system"
+	}
+
+	now := time.Now().UTC()
+	cost := float64(tokens) * s.config.ModelPricing[s.config.Model] / 1e6
+	record := &UsageRecord{
+		UserID:    userID,
+		Day:       dayStart(now),
+		Model:     s.config.Model,
+		Requests:  1,
+		Tokens:    int64(tokens),
+		Cost:      cost,
+		UpdatedAt: now,
+	}
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "day"}, {Name: "model"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "requests"}, Value: gorm.Expr("ai_usage.requests + ?", 1)},
+			{Column: clause.Column{Name: "tokens"}, Value: gorm.Expr("ai_usage.tokens + ?", tokens)},
+			{Column: clause.Column{Name: "cost"}, Value: gorm.Expr("ai_usage.cost + ?", cost)},
+			{Column: clause.Column{Name: "updated_at"}, Value: now},
+		},
+	}).Create(record).Error
+	if err != nil {
+		s.logger.Warn("Failed to record AI usage", zap.String("user_id", userID), zap.Error(err))
+		return
+	}
+
+	for _, b := range s.budgets(userID) {
+		s.warnBudget(ctx, b, now)
+	}
+}
+
+// budget is a monthly cap on a user or the org
+type budget struct {
+	scope    string
+	userID   string // empty for the org
+	tokenCap int64
+	costCap  float64
+}
+
+func (s *Service) budgets(userID string) []budget {
+	return []budget{
+		{scope: BudgetScopeUser, userID: userID, tokenCap: s.config.UserMonthlyTokenLimit, costCap: s.config.UserMonthlyCostLimit},
+		{scope: BudgetScopeOrg, tokenCap: s.config.OrgMonthlyTokenLimit, costCap: s.config.OrgMonthlyCostLimit},
+	}
+}
+
+// warnBudget emits a warning the first time in a month that b passes 80%
+// of a cap
+func (s *Service) warnBudget(ctx context.Context, b budget, now time.Time) {
+	if b.tokenCap <= 0 && b.costCap <= 0 {
+		return
+	}
+	month := monthStart(now)
+	usage, err := s.sumUsage(ctx, b.userID, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		s.logger.Warn("Failed to check AI budget", zap.Error(err))
+		return
+	}
+
+	ratio := 0.0
+	if b.tokenCap > 0 {
+		ratio = float64(usage.Tokens) / float64(b.tokenCap)
+	}
+	if b.costCap > 0 {
+		ratio = max(ratio, usage.Cost/b.costCap)
+	}
+	if ratio < budgetWarnRatio {
+		return
+	}
+
+	scope := b.scope
+	if b.userID != "" {
+		scope += ":" + b.userID
+	}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&budgetWarning{Scope: scope, Month: month, CreatedAt: now})
+	if result.Error != nil {
+		s.logger.Warn("Failed to record AI budget warning", zap.String("scope", scope), zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		return // already warned this month
+	}
+
+	s.logger.Warn("AI budget nearly used",
+		zap.String("scope", scope),
+		zap.Int64("tokens", usage.Tokens),
+		zap.Float64("cost", usage.Cost),
+	)
+	if s.events != nil {
+		data := map[string]interface{}{
+			"scope":       b.scope,
+			"user_id":     b.userID,
+			"month":       month.Format("2006-01"),
+			"tokens":      usage.Tokens,
+			"cost":        usage.Cost,
+			"token_limit": b.tokenCap,
+			"cost_limit":  b.costCap,
+			"percent":     ratio * 100,
+		}
+		if err := s.events.EmitAlertEvent(ctx, "ai_budget_warning", "warning", "ai", data); err != nil {
+			s.logger.Warn("Failed to emit AI budget warning", zap.Error(err))
+		}
+	}
+}
+
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
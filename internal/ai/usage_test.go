@@ -0,0 +1,124 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/glebarez/sqlite"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newUsageTestService(t *testing.T, config *Config) *Service {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	config.Provider = ProviderOpenAI
+	config.Model = "gpt-test"
+	svc, err := NewService(db, zap.NewNop(), config)
+	require.NoError(t, err)
+	return svc
+}
+
+func TestRecordUsageAggregates(t *testing.T) {
+	svc := newUsageTestService(t, &Config{ModelPricing: map[string]float64{"gpt-test": 10}})
+	ctx := context.Background()
+
+	svc.recordUsage(ctx, "alice", 1000)
+	svc.recordUsage(ctx, "alice", 500)
+	svc.recordUsage(ctx, "bob", 2000)
+
+	var records []UsageRecord
+	require.NoError(t, svc.db.Find(&records).Error)
+	assert.Len(t, records, 2, "one row per user, model and day")
+
+	usage, err := svc.GetUsage(ctx, "alice", UsagePeriodDay)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Requests)
+	assert.Equal(t, int64(1500), usage.Tokens)
+	assert.InDelta(t, 0.015, usage.Cost, 1e-9)
+
+	org, err := svc.GetUsage(ctx, "", UsagePeriodMonth)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3500), org.Tokens)
+	assert.InDelta(t, 0.035, org.Cost, 1e-9)
+
+	_, err = svc.GetUsage(ctx, "alice", "week")
+	assert.Error(t, err)
+}
+
+func TestRecordUsageCountsTokens(t *testing.T) {
+	svc := newUsageTestService(t, &Config{})
+	tokens := metrics.AITokens.WithLabelValues("openai", "gpt-test")
+	before := testutil.ToFloat64(tokens)
+
+	svc.recordUsage(context.Background(), "alice", 1200)
+	svc.recordUsage(context.Background(), "alice", 0)
+
+	assert.Equal(t, before+1200, testutil.ToFloat64(tokens))
+}
+
+func TestAskQuestionRejectedOverBudget(t *testing.T) {
+	server := newSequenceServer(t,
+		openAIContent(t, "Check the probe."),
+		openAIContent(t, "Check the probe again."),
+		openAIContent(t, "Check the node capacity."),
+	)
+	svc := newUsageTestService(t, &Config{UserMonthlyTokenLimit: 150})
+	svc.config.Endpoint = server.URL
+	ctx := context.Background()
+
+	// Each canned response uses 100 tokens
+	_, err := svc.AskQuestion(ctx, "alice", "Why is my pod restarting?", nil)
+	require.NoError(t, err)
+	_, err = svc.AskQuestion(ctx, "alice", "Why is my other pod restarting?", nil)
+	require.NoError(t, err)
+
+	_, err = svc.AskQuestion(ctx, "alice", "And the third one?", nil)
+	var budgetErr *BudgetExceededError
+	require.True(t, errors.As(err, &budgetErr))
+	assert.Equal(t, BudgetScopeUser, budgetErr.Scope)
+	assert.Equal(t, "tokens", budgetErr.Limit)
+	assert.Contains(t, err.Error(), "200 of 150 tokens")
+	assert.Len(t, server.bodies, 2, "the provider isn't called once over budget")
+
+	_, err = svc.AskQuestion(ctx, "bob", "Why is my pod pending?", nil)
+	assert.NoError(t, err, "budgets are per user")
+}
+
+func TestOrgBudget(t *testing.T) {
+	svc := newUsageTestService(t, &Config{
+		ModelPricing:        map[string]float64{"gpt-test": 1000},
+		OrgMonthlyCostLimit: 5,
+	})
+	ctx := context.Background()
+
+	svc.recordUsage(ctx, "alice", 3000)
+	require.NoError(t, svc.checkBudget(ctx, "bob"))
+	svc.recordUsage(ctx, "bob", 2000)
+
+	err := svc.checkBudget(ctx, "carol")
+	var budgetErr *BudgetExceededError
+	require.True(t, errors.As(err, &budgetErr))
+	assert.Equal(t, BudgetScopeOrg, budgetErr.Scope)
+	assert.Equal(t, "cost", budgetErr.Limit)
+}
+
+func TestBudgetWarningOnce(t *testing.T) {
+	svc := newUsageTestService(t, &Config{UserMonthlyTokenLimit: 1000})
+	ctx := context.Background()
+
+	svc.recordUsage(ctx, "alice", 700)
+	var count int64
+	require.NoError(t, svc.db.Model(&budgetWarning{}).Count(&count).Error)
+	assert.Zero(t, count)
+
+	svc.recordUsage(ctx, "alice", 150)
+	svc.recordUsage(ctx, "alice", 100)
+	require.NoError(t, svc.db.Model(&budgetWarning{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,37 @@
+// Package audittest provides the audit log table and recorders for tests
+// backed by SQLite
+package audittest
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Schema is the audit_logs table in SQLite
+const Schema = `CREATE TABLE audit_logs (
+	id TEXT PRIMARY KEY, user_id TEXT, user_email TEXT, action TEXT NOT NULL,
+	resource_type TEXT NOT NULL, resource_id TEXT, resource_name TEXT,
+	cluster_id TEXT, cluster_name TEXT, old_value TEXT, new_value TEXT,
+	metadata TEXT DEFAULT '{}', ip_address TEXT, user_agent TEXT, request_id TEXT,
+	status TEXT DEFAULT 'success', error_message TEXT, created_at TIMESTAMP,
+	seq INTEGER UNIQUE, prev_hash TEXT, hash TEXT)`
+
+// CreateTable creates the audit_logs table in db
+func CreateTable(t testing.TB, db *sql.DB) {
+	t.Helper()
+	_, err := db.Exec(Schema)
+	require.NoError(t, err)
+}
+
+// NewRecorder creates the audit_logs table in db and returns a recorder
+// writing to it
+func NewRecorder(t testing.TB, db *sql.DB) *audit.Recorder {
+	t.Helper()
+	CreateTable(t, db)
+	return audit.NewRecorder(&database.PostgresDB{DB: db}, zap.NewNop())
+}
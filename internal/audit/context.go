@@ -0,0 +1,33 @@
+package audit
+
+import "context"
+
+// RequestInfo describes the request behind an audited action
+type RequestInfo struct {
+	RequestID string
+	IPAddress string
+	UserAgent string
+	UserID    string
+	UserEmail string
+}
+
+type requestKey struct{}
+
+// WithRequest returns ctx carrying info, for Record to fill entries from
+func WithRequest(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestKey{}, info)
+}
+
+// WithActor returns ctx with the authenticated user added to its request
+// info
+func WithActor(ctx context.Context, userID, userEmail string) context.Context {
+	info := RequestFromContext(ctx)
+	info.UserID, info.UserEmail = userID, userEmail
+	return WithRequest(ctx, info)
+}
+
+// RequestFromContext returns the request info carried by ctx, if any
+func RequestFromContext(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestKey{}).(RequestInfo)
+	return info
+}
@@ -0,0 +1,276 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Entry statuses
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusDenied  = "denied"
+)
+
+// chainLockKey is the advisory lock serializing audit log writes
+const chainLockKey int64 = 0x6b72757374726f6e // "krustron"
+
+// Entry is an audit log entry. OldValue and NewValue capture a mutated
+// resource before and after the change. Record fills in the ID, time,
+// status and request details when they are empty, and the chain fields
+// always.
+type Entry struct {
+	ID           string                 `json:"id"`
+	UserID       string                 `json:"user_id"`
+	UserEmail    string                 `json:"user_email"`
+	Action       string                 `json:"action"`
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   string                 `json:"resource_id"`
+	ResourceName string                 `json:"resource_name"`
+	ClusterID    string                 `json:"cluster_id"`
+	ClusterName  string                 `json:"cluster_name"`
+	OldValue     map[string]interface{} `json:"old_value,omitempty"`
+	NewValue     map[string]interface{} `json:"new_value,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	IPAddress    string                 `json:"ip_address"`
+	UserAgent    string                 `json:"user_agent,omitempty"`
+	RequestID    string                 `json:"request_id,omitempty"`
+	Status       string                 `json:"status"`
+	ErrorMessage string                 `json:"error_message,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	Seq          int64                  `json:"seq,omitempty"`
+	PrevHash     string                 `json:"prev_hash,omitempty"`
+	Hash         string                 `json:"hash,omitempty"`
+}
+
+// Content is the canonical content of an entry that its hash covers
+func (e *Entry) Content() ([]byte, error) {
+	metadata, err := CanonicalJSON(e.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	oldValue, err := CanonicalJSON(e.OldValue)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := CanonicalJSON(e.NewValue)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		ID           string          `json:"id"`
+		Seq          int64           `json:"seq"`
+		UserID       string          `json:"user_id"`
+		UserEmail    string          `json:"user_email"`
+		Action       string          `json:"action"`
+		ResourceType string          `json:"resource_type"`
+		ResourceID   string          `json:"resource_id"`
+		ResourceName string          `json:"resource_name"`
+		ClusterID    string          `json:"cluster_id"`
+		ClusterName  string          `json:"cluster_name"`
+		OldValue     json.RawMessage `json:"old_value,omitempty"`
+		NewValue     json.RawMessage `json:"new_value,omitempty"`
+		Metadata     json.RawMessage `json:"metadata,omitempty"`
+		IPAddress    string          `json:"ip_address"`
+		UserAgent    string          `json:"user_agent"`
+		RequestID    string          `json:"request_id,omitempty"`
+		Status       string          `json:"status"`
+		ErrorMessage string          `json:"error_message"`
+		CreatedAt    string          `json:"created_at"`
+	}{
+		e.ID, e.Seq, e.UserID, e.UserEmail, e.Action, e.ResourceType,
+		e.ResourceID, e.ResourceName, e.ClusterID, e.ClusterName,
+		oldValue, newValue, metadata, e.IPAddress, e.UserAgent, e.RequestID,
+		e.Status, e.ErrorMessage, CanonicalTime(e.CreatedAt),
+	})
+}
+
+// Publisher publishes audit events; *nats.EventBus implements it
+type Publisher interface {
+	EmitAuditEvent(ctx context.Context, action, userID, resource string, data interface{}) error
+}
+
+// Recorder writes audit entries to the audit_logs table, chained by hash,
+// and publishes each as a krustron.audit.* event. It is the one writer of
+// the audit log; a nil Recorder records nothing.
+type Recorder struct {
+	db     *database.PostgresDB
+	logger *zap.Logger
+	events Publisher
+	// mu serializes appends in process; the advisory lock does across
+	// replicas
+	mu sync.Mutex
+}
+
+// NewRecorder creates a recorder writing to db
+func NewRecorder(db *database.PostgresDB, logger *zap.Logger) *Recorder {
+	return &Recorder{db: db, logger: logger}
+}
+
+// SetEventBus sets where audit events are published
+func (r *Recorder) SetEventBus(events Publisher) { r.events = events }
+
+// Record appends entry to the audit log and publishes it. Only the write
+// can fail it: the table is the source of truth, and events that cannot
+// be published are logged.
+func (r *Recorder) Record(ctx context.Context, entry Entry) error {
+	return r.Append(ctx, &entry)
+}
+
+// Append records entry like Record, filling in the fields Record assigns
+func (r *Recorder) Append(ctx context.Context, entry *Entry) error {
+	if r == nil {
+		return nil
+	}
+	if entry.Action == "" {
+		return fmt.Errorf("audit entry has no action")
+	}
+
+	request := RequestFromContext(ctx)
+	if entry.UserID == "" {
+		entry.UserID = request.UserID
+	}
+	if entry.UserEmail == "" {
+		entry.UserEmail = request.UserEmail
+	}
+	if entry.IPAddress == "" {
+		entry.IPAddress = request.IPAddress
+	}
+	if entry.UserAgent == "" {
+		entry.UserAgent = request.UserAgent
+	}
+	if entry.RequestID == "" {
+		entry.RequestID = request.RequestID
+	}
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.Status == "" {
+		entry.Status = StatusSuccess
+	}
+
+	if err := r.insert(ctx, entry); err != nil {
+		return err
+	}
+
+	if r.events != nil {
+		if err := r.events.EmitAuditEvent(ctx, entry.Action, entry.UserID, entry.ResourceType, entry); err != nil {
+			r.logger.Warn("Failed to publish audit event",
+				zap.String("audit_id", entry.ID),
+				zap.String("action", entry.Action),
+				zap.Error(err),
+			)
+		}
+	}
+	return nil
+}
+
+// insert writes entry at the end of the hash chain
+func (r *Recorder) insert(ctx context.Context, entry *Entry) error {
+	metadata, err := json.Marshal(entry.Metadata)
+	if err != nil {
+		return fmt.Errorf("invalid audit metadata: %w", err)
+	}
+	if entry.Metadata == nil {
+		metadata = []byte("{}")
+	}
+	oldValue, err := nullJSON(entry.OldValue)
+	if err != nil {
+		return fmt.Errorf("invalid audit old value: %w", err)
+	}
+	newValue, err := nullJSON(entry.NewValue)
+	if err != nil {
+		return fmt.Errorf("invalid audit new value: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err = r.db.Transaction(ctx, func(tx *sql.Tx) error {
+		if err := r.db.AdvisoryLock(ctx, tx, chainLockKey); err != nil {
+			return err
+		}
+
+		var prevSeq int64
+		prevHash := GenesisHash
+		err := tx.QueryRowContext(ctx,
+			"SELECT seq, hash FROM audit_logs WHERE seq IS NOT NULL ORDER BY seq DESC LIMIT 1",
+		).Scan(&prevSeq, &prevHash)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		// Stamped under the lock so timestamps follow the order of seq
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = time.Now()
+		}
+		entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+		entry.Seq, entry.PrevHash = prevSeq+1, prevHash
+		content, err := entry.Content()
+		if err != nil {
+			return err
+		}
+		entry.Hash = ChainHash(prevHash, content)
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO audit_logs (id, user_id, user_email, action, resource_type,
+			                        resource_id, resource_name, cluster_id, cluster_name,
+			                        old_value, new_value, metadata, ip_address, user_agent,
+			                        request_id, status, error_message, created_at, seq,
+			                        prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		`,
+			entry.ID, nullString(entry.UserID), entry.UserEmail, entry.Action, entry.ResourceType,
+			entry.ResourceID, entry.ResourceName, nullString(entry.ClusterID), entry.ClusterName,
+			oldValue, newValue, metadata, entry.IPAddress, entry.UserAgent,
+			entry.RequestID, entry.Status, entry.ErrorMessage, entry.CreatedAt, entry.Seq,
+			entry.PrevHash, entry.Hash,
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record audit log: %w", err)
+	}
+	return nil
+}
+
+// Snapshot captures v, a resource before or after a change, as its JSON
+// object form. Fields hidden from JSON, like password hashes, stay out.
+func Snapshot(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var snapshot map[string]interface{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil
+	}
+	return snapshot
+}
+
+// nullJSON encodes v for a nullable JSON column
+func nullJSON(v map[string]interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// nullString stores empty strings as NULL, for UUID columns
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,164 @@
+package audit_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type publishedEvent struct {
+	action, userID, resource string
+	data                     interface{}
+}
+
+// fakePublisher records audit events, failing them when err is set
+type fakePublisher struct {
+	events []publishedEvent
+	err    error
+}
+
+func (p *fakePublisher) EmitAuditEvent(_ context.Context, action, userID, resource string, data interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, publishedEvent{action, userID, resource, data})
+	return nil
+}
+
+// newTestRecorder returns a recorder publishing to a fake event bus and
+// the database it writes to
+func newTestRecorder(t *testing.T) (*audit.Recorder, *fakePublisher, *sql.DB) {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	recorder := audittest.NewRecorder(t, sqlDB)
+	events := &fakePublisher{}
+	recorder.SetEventBus(events)
+	return recorder, events, sqlDB
+}
+
+func TestRecord(t *testing.T) {
+	recorder, events, db := newTestRecorder(t)
+	ctx := audit.WithRequest(context.Background(), audit.RequestInfo{
+		RequestID: "req-42",
+		IPAddress: "203.0.113.9",
+		UserAgent: "kubectl/1.33",
+	})
+	ctx = audit.WithActor(ctx, "5d7c1e9a-3b2f-4a6d-8e0c-1f2a3b4c5d6e", "alice@example.com")
+
+	require.NoError(t, recorder.Record(ctx, audit.Entry{
+		Action:       "update",
+		ResourceType: "role",
+		ResourceID:   "role-1",
+		ResourceName: "deployer",
+		OldValue:     map[string]interface{}{"permissions": []string{"clusters:read"}},
+		NewValue:     map[string]interface{}{"permissions": []string{"clusters:read", "clusters:write"}},
+	}))
+
+	var (
+		id, userID, email, action, ip, userAgent, requestID, status, hash string
+		oldValue, newValue                                                []byte
+		seq                                                               int64
+	)
+	require.NoError(t, db.QueryRow(`
+		SELECT id, user_id, user_email, action, ip_address, user_agent, request_id,
+		       status, old_value, new_value, seq, hash
+		FROM audit_logs`).Scan(
+		&id, &userID, &email, &action, &ip, &userAgent, &requestID,
+		&status, &oldValue, &newValue, &seq, &hash,
+	))
+	assert.NotEmpty(t, id)
+	assert.Equal(t, "5d7c1e9a-3b2f-4a6d-8e0c-1f2a3b4c5d6e", userID)
+	assert.Equal(t, "alice@example.com", email)
+	assert.Equal(t, "update", action)
+	assert.Equal(t, "203.0.113.9", ip)
+	assert.Equal(t, "kubectl/1.33", userAgent)
+	assert.Equal(t, "req-42", requestID)
+	assert.Equal(t, audit.StatusSuccess, status)
+	assert.JSONEq(t, `{"permissions": ["clusters:read"]}`, string(oldValue))
+	assert.JSONEq(t, `{"permissions": ["clusters:read", "clusters:write"]}`, string(newValue))
+	assert.Equal(t, int64(1), seq)
+
+	require.Len(t, events.events, 1)
+	event := events.events[0]
+	assert.Equal(t, "update", event.action)
+	assert.Equal(t, userID, event.userID)
+	assert.Equal(t, "role", event.resource)
+	published, ok := event.data.(*audit.Entry)
+	require.True(t, ok)
+	assert.Equal(t, id, published.ID)
+	assert.Equal(t, "req-42", published.RequestID)
+	assert.Equal(t, hash, published.Hash)
+
+	// The event carries what was written: its hash checks out
+	content, err := published.Content()
+	require.NoError(t, err)
+	assert.Equal(t, audit.ChainHash(audit.GenesisHash, content), hash)
+}
+
+func TestRecordChainsEntries(t *testing.T) {
+	recorder, _, _ := newTestRecorder(t)
+	ctx := context.Background()
+
+	entries := make([]*audit.Entry, 3)
+	for i := range entries {
+		entries[i] = &audit.Entry{Action: "sync", ResourceType: "application", ResourceName: fmt.Sprintf("app-%d", i)}
+		require.NoError(t, recorder.Append(ctx, entries[i]))
+	}
+	for i, entry := range entries {
+		assert.Equal(t, int64(i+1), entry.Seq)
+		if i > 0 {
+			assert.Equal(t, entries[i-1].Hash, entry.PrevHash)
+		}
+	}
+	assert.Equal(t, "", entries[0].UserID, "no actor in the context")
+}
+
+func TestRecordPublishFailure(t *testing.T) {
+	recorder, events, db := newTestRecorder(t)
+	events.err = fmt.Errorf("nats: no responders")
+
+	require.NoError(t, recorder.Record(context.Background(), audit.Entry{Action: "delete", ResourceType: "cluster"}),
+		"the row is the source of truth; a failed publish does not fail the record")
+	var n int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM audit_logs").Scan(&n))
+	assert.Equal(t, 1, n)
+
+	assert.Error(t, recorder.Record(context.Background(), audit.Entry{ResourceType: "cluster"}), "an action is required")
+
+	var nilRecorder *audit.Recorder
+	assert.NoError(t, nilRecorder.Record(context.Background(), audit.Entry{Action: "delete"}))
+}
+
+func TestSnapshot(t *testing.T) {
+	type secretive struct {
+		Name     string    `json:"name"`
+		Password string    `json:"-"`
+		At       time.Time `json:"at"`
+	}
+	snapshot := audit.Snapshot(&secretive{Name: "alice", Password: "hunter2", At: time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)})
+	assert.Equal(t, map[string]interface{}{"name": "alice", "at": "2026-03-10T00:00:00Z"}, snapshot)
+
+	var missing *secretive
+	assert.Nil(t, audit.Snapshot(missing))
+	assert.Nil(t, audit.Snapshot(nil))
+
+	data, err := json.Marshal(audit.Snapshot(map[string]int{"replicas": 3}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"replicas": 3}`, string(data))
+}
@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// APIKeyPrefix starts every API key, telling keys apart from JWTs
+const APIKeyPrefix = "kr_"
+
+// TokenTypeAPIKey is the token type of claims authenticated by an API key
+const TokenTypeAPIKey = "api_key"
+
+// apiKeyDisplayLength is how much of a key is kept to recognize it by
+const apiKeyDisplayLength = 11
+
+// apiKeyTouchInterval limits how often a key's last use is written
+const apiKeyTouchInterval = time.Minute
+
+// APIKey is a long-lived credential for machine access, such as CI. Only
+// a hash of the key is stored; the key itself is shown once, at creation.
+type APIKey struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	// Prefix is the start of the key, to recognize it by
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes"`
+	// AllowedIPs are the addresses the key may be used from, as CIDR
+	// ranges; empty allows any
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyOption sets an optional property of a new API key
+type APIKeyOption func(*apiKeyOptions)
+
+type apiKeyOptions struct {
+	name       string
+	allowedIPs []string
+}
+
+// WithAPIKeyName names a key, e.g. after the pipeline using it
+func WithAPIKeyName(name string) APIKeyOption {
+	return func(o *apiKeyOptions) { o.name = name }
+}
+
+// WithAllowedIPs restricts a key to addresses or CIDR ranges
+func WithAllowedIPs(cidrs ...string) APIKeyOption {
+	return func(o *apiKeyOptions) { o.allowedIPs = cidrs }
+}
+
+// CreateAPIKey creates an API key for a user and returns it. The key
+// grants scopes, which must be permissions the user holds, until
+// expiresAt, or for good if it is nil.
+func (s *Service) CreateAPIKey(ctx context.Context, userID string, scopes []string, expiresAt *time.Time, opts ...APIKeyOption) (string, error) {
+	var options apiKeyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	scopes = normalizeScopes(scopes)
+	if len(scopes) == 0 {
+		return "", errors.Validation("an API key needs at least one scope")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", errors.Validation("expiry must be in the future")
+	}
+	allowedIPs, err := parseAllowedIPs(options.allowedIPs)
+	if err != nil {
+		return "", err
+	}
+
+	owner, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if !owner.IsActive {
+		return "", errors.Forbidden("account is disabled")
+	}
+	ownerClaims := &Claims{Role: owner.Role, Permissions: s.getUserPermissions(ctx, owner.ID, owner.Role)}
+	for _, scope := range scopes {
+		if !ownerClaims.HasPermission(scope) {
+			return "", errors.Forbidden(fmt.Sprintf("scope %q exceeds the owner's permissions", scope))
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", errors.InternalWrap(err, "failed to generate API key")
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	apiKey := &APIKey{
+		ID:         uuid.NewString(),
+		UserID:     owner.ID,
+		Name:       strings.TrimSpace(options.name),
+		Prefix:     key[:apiKeyDisplayLength],
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		ExpiresAt:  expiresAt,
+		CreatedAt:  time.Now(),
+	}
+	scopesJSON, _ := json.Marshal(apiKey.Scopes)
+	allowedJSON, _ := json.Marshal(apiKey.AllowedIPs)
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, apiKey.ID, apiKey.UserID, apiKey.Name, apiKey.Prefix, hashAPIKey(key), scopesJSON, allowedJSON,
+		apiKey.ExpiresAt, apiKey.CreatedAt); err != nil {
+		return "", errors.DatabaseWrap(err, "failed to create API key")
+	}
+
+	logger.Info("API key created", zap.String("key_id", apiKey.ID), zap.String("user_id", owner.ID))
+	s.recordChange(ctx, "create", "api_key", apiKey.ID, apiKey.Name, nil, apiKey)
+	return key, nil
+}
+
+// ListAPIKeys returns a user's API keys, newest first, revoked ones
+// included
+func (s *Service) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, name, prefix, scopes, allowed_ips, expires_at,
+		       last_used_at, COALESCE(last_used_ip, ''), revoked_at, created_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id
+	`, userID)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to query API keys")
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		var scopes, allowedIPs []byte
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(
+			&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &allowedIPs, &expiresAt,
+			&lastUsedAt, &key.LastUsedIP, &revokedAt, &key.CreatedAt,
+		); err != nil {
+			return nil, errors.DatabaseWrap(err, "failed to scan API key")
+		}
+		json.Unmarshal(scopes, &key.Scopes)
+		json.Unmarshal(allowedIPs, &key.AllowedIPs)
+		key.ExpiresAt = nullTimePtr(expiresAt)
+		key.LastUsedAt = nullTimePtr(lastUsedAt)
+		key.RevokedAt = nullTimePtr(revokedAt)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to read API keys")
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	if _, err := uuid.Parse(keyID); err != nil {
+		return errors.NotFound("api_key", keyID)
+	}
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL",
+		keyID, userID, time.Now())
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to revoke API key")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.NotFound("api_key", keyID)
+	}
+
+	logger.Info("API key revoked", zap.String("key_id", keyID), zap.String("user_id", userID))
+	s.recordChange(ctx, "revoke", "api_key", keyID, "", nil, nil)
+	return nil
+}
+
+// ValidateAPIKey authenticates a request made with an API key from
+// clientIP. The claims carry the key's scopes that the owner still holds
+// as their permissions. They carry the owner's role only if the key holds
+// every permission ("*"), so a narrower key cannot pass role checks.
+func (s *Service) ValidateAPIKey(ctx context.Context, key, clientIP string) (*Claims, error) {
+	if s.db == nil || !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, errors.Unauthorized("invalid API key")
+	}
+
+	var (
+		claims                                       Claims
+		scopes, allowedIPs                           []byte
+		expiresAt, lastUsedAt, revokedAt, validAfter sql.NullTime
+		createdAt                                    time.Time
+		active                                       bool
+	)
+	err := s.db.QueryRowContext(ctx, `
+		SELECT k.id, k.user_id, k.scopes, k.allowed_ips, k.expires_at, k.last_used_at,
+		       k.revoked_at, k.created_at, u.email, u.name, u.role, u.is_active, u.tokens_valid_after
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
+	`, hashAPIKey(key)).Scan(
+		&claims.ID, &claims.UserID, &scopes, &allowedIPs, &expiresAt, &lastUsedAt,
+		&revokedAt, &createdAt, &claims.Email, &claims.Name, &claims.Role, &active, &validAfter,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errors.Unauthorized("invalid API key")
+	}
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to look up API key")
+	}
+
+	now := time.Now()
+	switch {
+	case revokedAt.Valid:
+		return nil, errors.Unauthorized("API key has been revoked")
+	case expiresAt.Valid && !now.Before(expiresAt.Time):
+		return nil, errors.Unauthorized("API key has expired")
+	case !active:
+		return nil, errors.Unauthorized("account is disabled")
+	case validAfter.Valid && !createdAt.After(validAfter.Time):
+		// Revoking a user's tokens revokes their keys too
+		return nil, errors.Unauthorized("API key has been revoked")
+	}
+
+	var allowed []string
+	json.Unmarshal(allowedIPs, &allowed)
+	if len(allowed) > 0 && !ipAllowed(allowed, clientIP) {
+		return nil, errors.Unauthorized("API key is not allowed from this address")
+	}
+
+	// A key never grants more than its owner holds now
+	var keyScopes []string
+	json.Unmarshal(scopes, &keyScopes)
+	owner := &Claims{Role: claims.Role, Permissions: s.getUserPermissions(ctx, claims.UserID, claims.Role)}
+	claims.Permissions = []string{}
+	fullAccess := false
+	for _, scope := range keyScopes {
+		if owner.HasPermission(scope) {
+			claims.Permissions = append(claims.Permissions, scope)
+			fullAccess = fullAccess || scope == "*"
+		}
+	}
+	if !fullAccess {
+		claims.Role = ""
+	}
+
+	claims.Subject = claims.UserID
+	claims.TokenType = TokenTypeAPIKey
+	if expiresAt.Valid {
+		claims.ExpiresAt = jwt.NewNumericDate(expiresAt.Time)
+	}
+
+	if !lastUsedAt.Valid || now.Sub(lastUsedAt.Time) >= apiKeyTouchInterval {
+		if _, err := s.db.ExecContext(ctx,
+			"UPDATE api_keys SET last_used_at = $2, last_used_ip = $3 WHERE id = $1",
+			claims.ID, now, nullableString(clientIP)); err != nil {
+			logger.Warn("Failed to record API key use", zap.String("key_id", claims.ID), zap.Error(err))
+		}
+	}
+	return &claims, nil
+}
+
+// hashAPIKey returns the stored form of a key. Keys are random, so a
+// fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeScopes trims scopes and drops empty and repeated ones
+func normalizeScopes(scopes []string) []string {
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" || seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		normalized = append(normalized, scope)
+	}
+	return normalized
+}
+
+// parseAllowedIPs normalizes addresses and CIDR ranges to CIDR ranges
+func parseAllowedIPs(entries []string) ([]string, error) {
+	var prefixes []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked().String())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, errors.Validation(fmt.Sprintf("invalid IP address or CIDR range %q", entry))
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()).String())
+	}
+	return prefixes, nil
+}
+
+// ipAllowed reports whether ip is in one of the CIDR ranges
+func ipAllowed(cidrs []string, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, cidr := range cidrs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// nullTimePtr returns the time of t, or nil if it is NULL
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// nullableString stores empty strings as NULL
+func nullableString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const (
+	adminID    = "0b9f3c2e-6a1d-4e8f-9c7b-1a2b3c4d5e6f"
+	deployerID = "5d4c3b2a-1f0e-4d9c-8b7a-6f5e4d3c2b1a"
+)
+
+// newAPIKeyService backs the service with in-memory SQLite holding an
+// admin and a deployer, who may read and deploy applications
+func newAPIKeyService(t *testing.T) *Service {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	for _, stmt := range []string{
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY, email TEXT UNIQUE NOT NULL, password_hash TEXT, name TEXT NOT NULL,
+			avatar_url TEXT DEFAULT '', provider TEXT DEFAULT 'local', role TEXT DEFAULT 'user',
+			is_active BOOLEAN DEFAULT true, totp_enabled BOOLEAN DEFAULT false, totp_secret TEXT DEFAULT '',
+			external_id TEXT, tokens_valid_after TIMESTAMP, last_login_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE roles (id TEXT PRIMARY KEY, name TEXT, permissions TEXT)`,
+		`CREATE TABLE user_roles (user_id TEXT, role_id TEXT)`,
+		`CREATE TABLE api_keys (
+			id TEXT PRIMARY KEY, user_id TEXT NOT NULL, name TEXT NOT NULL DEFAULT '', prefix TEXT NOT NULL,
+			key_hash TEXT UNIQUE NOT NULL, scopes TEXT NOT NULL DEFAULT '[]', allowed_ips TEXT NOT NULL DEFAULT '[]',
+			expires_at TIMESTAMP, last_used_at TIMESTAMP, last_used_ip TEXT, revoked_at TIMESTAMP,
+			created_at TIMESTAMP)`,
+		fmt.Sprintf(`INSERT INTO users (id, email, name, role) VALUES
+			('%s', 'admin@example.com', 'Admin', 'admin'),
+			('%s', 'ci@example.com', 'CI', 'user')`, adminID, deployerID),
+		`INSERT INTO roles (id, name, permissions) VALUES ('r1', 'deployer', '["applications:read","applications:deploy"]')`,
+		fmt.Sprintf(`INSERT INTO user_roles (user_id, role_id) VALUES ('%s', 'r1')`, deployerID),
+	} {
+		_, err := sqlDB.Exec(stmt)
+		require.NoError(t, err)
+	}
+
+	return &Service{db: &database.PostgresDB{DB: sqlDB}, recorder: audittest.NewRecorder(t, sqlDB)}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	svc := newAPIKeyService(t)
+	ctx := context.Background()
+
+	key, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:deploy", " applications:deploy"}, nil,
+		WithAPIKeyName("github-actions"), WithAllowedIPs("10.0.0.0/8", "192.168.1.7"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+
+	keys, err := svc.ListAPIKeys(ctx, deployerID)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "github-actions", keys[0].Name)
+	assert.Equal(t, []string{"applications:deploy"}, keys[0].Scopes)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.7/32"}, keys[0].AllowedIPs)
+	assert.True(t, strings.HasPrefix(key, keys[0].Prefix))
+	assert.Nil(t, keys[0].LastUsedAt)
+
+	// Only the hash is stored
+	var stored int
+	require.NoError(t, svc.db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE key_hash = $1", key).Scan(&stored))
+	assert.Zero(t, stored)
+
+	past := time.Now().Add(-time.Hour)
+	for name, create := range map[string]func() error{
+		"no scopes": func() error {
+			_, err := svc.CreateAPIKey(ctx, deployerID, []string{" "}, nil)
+			return err
+		},
+		"expired": func() error {
+			_, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:read"}, &past)
+			return err
+		},
+		"bad allow-list": func() error {
+			_, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:read"}, nil, WithAllowedIPs("10.0.0.300"))
+			return err
+		},
+	} {
+		assert.True(t, errors.Is(create(), errors.CodeValidation), name)
+	}
+
+	// Keys are limited to what their owner holds
+	_, err = svc.CreateAPIKey(ctx, deployerID, []string{"applications:read", "clusters:delete"}, nil)
+	assert.True(t, errors.Is(err, errors.CodeForbidden))
+	_, err = svc.CreateAPIKey(ctx, deployerID, []string{"*"}, nil)
+	assert.True(t, errors.Is(err, errors.CodeForbidden))
+	_, err = svc.CreateAPIKey(ctx, adminID, []string{"*"}, nil)
+	assert.NoError(t, err)
+}
+
+func TestValidateAPIKey(t *testing.T) {
+	svc := newAPIKeyService(t)
+	ctx := context.Background()
+
+	key, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:deploy"}, nil, WithAllowedIPs("10.0.0.0/8"))
+	require.NoError(t, err)
+
+	claims, err := svc.ValidateAPIKey(ctx, key, "10.1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, deployerID, claims.UserID)
+	assert.Equal(t, TokenTypeAPIKey, claims.TokenType)
+	assert.Empty(t, claims.Role)
+	assert.True(t, claims.HasPermission("applications:deploy"))
+	assert.False(t, claims.HasPermission("applications:read"))
+
+	keys, err := svc.ListAPIKeys(ctx, deployerID)
+	require.NoError(t, err)
+	require.NotNil(t, keys[0].LastUsedAt)
+	assert.Equal(t, "10.1.2.3", keys[0].LastUsedIP)
+
+	for _, ip := range []string{"192.168.1.7", ""} {
+		_, err = svc.ValidateAPIKey(ctx, key, ip)
+		assert.True(t, errors.Is(err, errors.CodeUnauthorized), ip)
+	}
+	_, err = svc.ValidateAPIKey(ctx, key+"x", "10.1.2.3")
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+
+	// ValidateToken recognizes keys; without a client address the
+	// allow-list rejects this one
+	_, err = svc.ValidateToken(key)
+	assert.Error(t, err)
+	open, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:read"}, nil)
+	require.NoError(t, err)
+	claims, err = svc.ValidateToken(open)
+	require.NoError(t, err)
+	assert.True(t, claims.HasPermission("applications:read"))
+}
+
+func TestAPIKeyScopesFollowOwner(t *testing.T) {
+	svc := newAPIKeyService(t)
+	ctx := context.Background()
+
+	// An admin's narrow key doesn't carry the admin role
+	narrow, err := svc.CreateAPIKey(ctx, adminID, []string{"clusters:read"}, nil)
+	require.NoError(t, err)
+	claims, err := svc.ValidateAPIKey(ctx, narrow, "")
+	require.NoError(t, err)
+	assert.Empty(t, claims.Role)
+	assert.True(t, claims.HasPermission("clusters:read"))
+	assert.False(t, claims.HasPermission("clusters:delete"))
+
+	full, err := svc.CreateAPIKey(ctx, adminID, []string{"*"}, nil)
+	require.NoError(t, err)
+	claims, err = svc.ValidateAPIKey(ctx, full, "")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Role)
+	assert.True(t, claims.HasPermission("clusters:delete"))
+
+	// Scopes the owner has since lost are dropped
+	key, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:read", "applications:deploy"}, nil)
+	require.NoError(t, err)
+	_, err = svc.db.Exec(`UPDATE roles SET permissions = '["applications:read"]' WHERE id = 'r1'`)
+	require.NoError(t, err)
+	claims, err = svc.ValidateAPIKey(ctx, key, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"applications:read"}, claims.Permissions)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	svc := newAPIKeyService(t)
+	ctx := context.Background()
+
+	key, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:read"}, nil)
+	require.NoError(t, err)
+	keys, err := svc.ListAPIKeys(ctx, deployerID)
+	require.NoError(t, err)
+	keyID := keys[0].ID
+
+	// Only the owner may revoke a key
+	assert.True(t, errors.Is(svc.RevokeAPIKey(ctx, adminID, keyID), errors.CodeNotFound))
+	assert.True(t, errors.Is(svc.RevokeAPIKey(ctx, deployerID, "not-a-uuid"), errors.CodeNotFound))
+
+	require.NoError(t, svc.RevokeAPIKey(ctx, deployerID, keyID))
+	_, err = svc.ValidateAPIKey(ctx, key, "")
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+	assert.True(t, errors.Is(svc.RevokeAPIKey(ctx, deployerID, keyID), errors.CodeNotFound))
+
+	keys, err = svc.ListAPIKeys(ctx, deployerID)
+	require.NoError(t, err)
+	assert.NotNil(t, keys[0].RevokedAt)
+
+	// Disabling the owner, or revoking all their tokens, stops their keys
+	other, err := svc.CreateAPIKey(ctx, deployerID, []string{"applications:read"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, svc.RevokeUserTokens(ctx, deployerID))
+	_, err = svc.ValidateAPIKey(ctx, other, "")
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+
+	_, err = svc.db.Exec("UPDATE users SET is_active = false, tokens_valid_after = NULL WHERE id = $1", deployerID)
+	require.NoError(t, err)
+	_, err = svc.ValidateAPIKey(ctx, other, "")
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+}
@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const prodClusterID = "8a4c9e3b-2f61-4d7a-9b0e-5c1d2e3f4a5b"
+
+var auditDay = time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+
+// newAuditService backs the service with in-memory SQLite holding a few
+// days of audit logs
+func newAuditService(t *testing.T) *Service {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	recorder := audittest.NewRecorder(t, sqlDB)
+	_, err = sqlDB.Exec(`CREATE INDEX idx_audit_logs_created_id ON audit_logs(created_at DESC, id DESC)`)
+	require.NoError(t, err)
+
+	logs := []struct {
+		id, email, action, resourceType, name, cluster, status, errMsg string
+		at                                                             time.Time
+	}{
+		{"1", "alice@example.com", "delete", "cluster", "payments-prod", "prod", "success", "", auditDay.Add(-time.Second)},
+		{"2", "alice@example.com", "delete", "cluster", "payments-staging", "staging", "success", "", auditDay},
+		{"3", "Bob@example.com", "delete", "cluster", "search-prod", "prod", "failure", "permission denied", auditDay.Add(9 * time.Hour)},
+		{"4", "bob@example.com", "update", "application", "web_app", "prod", "success", "", auditDay.Add(10 * time.Hour)},
+		{"5", "carol@example.com", "delete", "application", "web", "prod", "failure", "quota 100% used", auditDay.Add(24*time.Hour - time.Nanosecond)},
+		{"6", "alice@example.com", "delete", "cluster", "payments-prod", "prod", "success", "", auditDay.Add(24 * time.Hour)},
+	}
+	for _, l := range logs {
+		clusterID := "3f0c2b1a-9d8e-4f7a-b6c5-d4e3f2a1b0c9"
+		if l.cluster == "prod" {
+			clusterID = prodClusterID
+		}
+		_, err := sqlDB.Exec(`INSERT INTO audit_logs (id, user_email, action, resource_type, resource_id,
+			resource_name, cluster_id, cluster_name, ip_address, status, error_message, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, '10.0.0.1', $9, $10, $11)`,
+			l.id, l.email, l.action, l.resourceType, "res-"+l.id, l.name, clusterID, l.cluster, l.status, l.errMsg, l.at)
+		require.NoError(t, err)
+	}
+	return &Service{db: &database.PostgresDB{DB: sqlDB}, recorder: recorder}
+}
+
+func auditIDs(logs []AuditLog) []string {
+	ids := make([]string, len(logs))
+	for i, l := range logs {
+		ids[i] = l.ID
+	}
+	return ids
+}
+
+func TestSearchAuditLogs(t *testing.T) {
+	svc := newAuditService(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		filter AuditLogFilter
+		want   []string
+	}{
+		{"everything newest first", AuditLogFilter{}, []string{"6", "5", "4", "3", "2", "1"}},
+		{"who deleted clusters on prod", AuditLogFilter{Action: "delete", ResourceType: "cluster", Cluster: "prod"}, []string{"6", "3", "1"}},
+		{"cluster by ID", AuditLogFilter{Cluster: prodClusterID, Status: "failure"}, []string{"5", "3"}},
+		{"email ignores case", AuditLogFilter{UserEmail: "BOB@example.com"}, []string{"4", "3"}},
+		{"search resource names", AuditLogFilter{Search: "PAYMENTS", Action: "delete"}, []string{"6", "2", "1"}},
+		{"search error messages", AuditLogFilter{Search: "denied"}, []string{"3"}},
+		{"wildcards match literally", AuditLogFilter{Search: "_app"}, []string{"4"}},
+		{"percent matches literally", AuditLogFilter{Search: "100%"}, []string{"5"}},
+		{"one day", AuditLogFilter{From: auditDay, To: auditDay.Add(24 * time.Hour)}, []string{"5", "4", "3", "2"}},
+		{"from is inclusive", AuditLogFilter{From: auditDay.Add(24 * time.Hour)}, []string{"6"}},
+		{"to is exclusive", AuditLogFilter{To: auditDay}, []string{"1"}},
+		{"range in another zone", AuditLogFilter{
+			From: auditDay.In(time.FixedZone("EST", -5*3600)),
+			To:   auditDay.Add(9 * time.Hour).In(time.FixedZone("EST", -5*3600)),
+		}, []string{"2"}},
+		{"combined with range", AuditLogFilter{UserEmail: "alice@example.com", Search: "prod", From: auditDay}, []string{"6"}},
+		{"nothing matches", AuditLogFilter{Action: "create"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, total, err := svc.SearchAuditLogs(ctx, tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, auditIDs(logs))
+			assert.Equal(t, len(tt.want), total)
+		})
+	}
+}
+
+func TestSearchAuditLogsPaging(t *testing.T) {
+	svc := newAuditService(t)
+	ctx := context.Background()
+
+	logs, total, err := svc.SearchAuditLogs(ctx, AuditLogFilter{Action: "delete", Page: 2, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3", "2"}, auditIDs(logs))
+	assert.Equal(t, 5, total, "the total counts every match, not the page")
+
+	logs, total, err = svc.ListAuditLogs(ctx, 3, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "1"}, auditIDs(logs))
+	assert.Equal(t, 6, total)
+
+	_, _, err = svc.SearchAuditLogs(ctx, AuditLogFilter{From: auditDay, To: auditDay})
+	assert.Equal(t, errors.CodeValidation, errors.Code(err))
+}
+
+// recordChain records n chained entries a minute apart from auditDay
+func recordChain(t *testing.T, svc *Service, n int) []*AuditLog {
+	t.Helper()
+	logs := make([]*AuditLog, n)
+	for i := range logs {
+		logs[i] = &AuditLog{
+			UserID:       "5d7c1e9a-3b2f-4a6d-8e0c-1f2a3b4c5d6e",
+			UserEmail:    "alice@example.com",
+			Action:       "update",
+			ResourceType: "cluster",
+			ResourceName: fmt.Sprintf("cluster-%d", i),
+			ClusterID:    prodClusterID,
+			OldValue:     map[string]interface{}{"replicas": i},
+			NewValue:     map[string]interface{}{"replicas": i + 1, "labels": map[string]string{"tier": "web"}},
+			Metadata:     map[string]interface{}{"request_id": fmt.Sprintf("req-%d", i)},
+			CreatedAt:    auditDay.Add(time.Duration(i)*time.Minute + 123456789),
+		}
+		require.NoError(t, svc.RecordAuditLog(context.Background(), logs[i]))
+	}
+	return logs
+}
+
+func TestSearchAuditLogsAfter(t *testing.T) {
+	svc := newAuditService(t)
+	ctx := context.Background()
+	// Rows sharing a timestamp straddle pages and are ordered by ID
+	insertAuditLogs(t, svc, 5, auditDay.Add(48*time.Hour), 0)
+
+	var keyset []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 10, "paging does not end")
+		logs, next, err := svc.SearchAuditLogsAfter(ctx, AuditLogFilter{Limit: 3}, cursor)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(logs), 3)
+		keyset = append(keyset, auditIDs(logs)...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	var offset []string
+	for page := 1; page <= 4; page++ {
+		logs, _, err := svc.SearchAuditLogs(ctx, AuditLogFilter{Page: page, Limit: 3})
+		require.NoError(t, err)
+		offset = append(offset, auditIDs(logs)...)
+	}
+	assert.Len(t, keyset, 11)
+	assert.Equal(t, offset, keyset, "keyset and offset pages agree while nothing is inserted")
+
+	// Filters apply alongside the cursor
+	logs, next, err := svc.SearchAuditLogsAfter(ctx, AuditLogFilter{Action: "delete", Limit: 2}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"6", "5"}, auditIDs(logs))
+	logs, next, err = svc.SearchAuditLogsAfter(ctx, AuditLogFilter{Action: "delete", Limit: 2}, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3", "2"}, auditIDs(logs))
+	logs, next, err = svc.SearchAuditLogsAfter(ctx, AuditLogFilter{Action: "delete", Limit: 2}, next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, auditIDs(logs))
+	assert.Empty(t, next)
+
+	_, _, err = svc.SearchAuditLogsAfter(ctx, AuditLogFilter{}, "not-a-cursor")
+	assert.Equal(t, errors.CodeBadRequest, errors.Code(err))
+}
+
+func TestSearchAuditLogsAfterWithInserts(t *testing.T) {
+	svc := newAuditService(t)
+	ctx := context.Background()
+	logged := 0
+	logNew := func() {
+		logged++
+		_, err := svc.db.Exec(`INSERT INTO audit_logs (id, user_email, action, resource_type, resource_id,
+			resource_name, cluster_name, ip_address, status, created_at)
+			VALUES ($1, 'user@example.com', 'sync', 'application', 'app', 'app', 'prod', '10.0.0.1', 'success', $2)`,
+			fmt.Sprintf("new-%d", logged), auditDay.Add(time.Duration(48+logged)*time.Hour))
+		require.NoError(t, err)
+	}
+
+	// Logs written between pages push offset pages back, repeating rows
+	seen := map[string]int{}
+	for page := 1; page <= 3; page++ {
+		logs, _, err := svc.SearchAuditLogs(ctx, AuditLogFilter{Page: page, Limit: 2})
+		require.NoError(t, err)
+		for _, id := range auditIDs(logs) {
+			seen[id]++
+		}
+		logNew()
+	}
+	assert.Equal(t, 2, seen["5"], "offset paging repeats a row")
+
+	// Keyset pages pick up where the last ended
+	seen = map[string]int{}
+	cursor := ""
+	for {
+		logs, next, err := svc.SearchAuditLogsAfter(ctx, AuditLogFilter{Limit: 2}, cursor)
+		require.NoError(t, err)
+		for _, id := range auditIDs(logs) {
+			seen[id]++
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+		logNew()
+	}
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		assert.Equal(t, 1, seen[id], "log %s", id)
+	}
+	assert.Len(t, seen, 9, "the six logs and the three logged before paging")
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	svc := newAuditService(t)
+	ctx := context.Background()
+	logs := recordChain(t, svc, 5)
+
+	assert.Equal(t, int64(1), logs[0].Seq)
+	assert.Equal(t, audit.GenesisHash, logs[0].PrevHash)
+	assert.Equal(t, logs[0].Hash, logs[1].PrevHash)
+
+	report, err := svc.VerifyAuditChain(ctx, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, report.Intact)
+	assert.Equal(t, 5, report.Checked, "entries from before chaining are not checked")
+	assert.Nil(t, report.FirstBroken)
+
+	report, err = svc.VerifyAuditChain(ctx, logs[2].CreatedAt, logs[4].CreatedAt)
+	require.NoError(t, err)
+	assert.True(t, report.Intact)
+	assert.Equal(t, 2, report.Checked)
+
+	report, err = svc.VerifyAuditChain(ctx, auditDay.Add(48*time.Hour), time.Time{})
+	require.NoError(t, err)
+	assert.True(t, report.Intact)
+	assert.Zero(t, report.Checked)
+
+	// An entry stamped before the one chained ahead of it is not a gap
+	late := &AuditLog{Action: "update", ResourceType: "cluster", CreatedAt: logs[3].CreatedAt.Add(-30 * time.Second)}
+	require.NoError(t, svc.RecordAuditLog(ctx, late))
+	assert.Equal(t, int64(6), late.Seq)
+	report, err = svc.VerifyAuditChain(ctx, logs[2].CreatedAt, logs[4].CreatedAt)
+	require.NoError(t, err)
+	assert.True(t, report.Intact)
+	assert.Equal(t, 4, report.Checked, "entries 3 to 6 are checked")
+}
+
+func TestVerifyAuditChainTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, svc *Service, logs []*AuditLog)
+		seq    int64
+		reason string
+	}{
+		{
+			name: "altered row",
+			tamper: func(t *testing.T, svc *Service, logs []*AuditLog) {
+				_, err := svc.db.Exec("UPDATE audit_logs SET user_email = 'mallory@example.com' WHERE seq = 3")
+				require.NoError(t, err)
+			},
+			seq:    3,
+			reason: audit.ReasonAltered,
+		},
+		{
+			name: "altered metadata",
+			tamper: func(t *testing.T, svc *Service, logs []*AuditLog) {
+				_, err := svc.db.Exec(`UPDATE audit_logs SET new_value = '{"replicas": 0}' WHERE seq = 2`)
+				require.NoError(t, err)
+			},
+			seq:    2,
+			reason: audit.ReasonAltered,
+		},
+		{
+			name: "altered and rehashed row",
+			tamper: func(t *testing.T, svc *Service, logs []*AuditLog) {
+				forged := *logs[2]
+				forged.UserEmail = "mallory@example.com"
+				entry := forged.entry()
+				content, err := entry.Content()
+				require.NoError(t, err)
+				_, err = svc.db.Exec("UPDATE audit_logs SET user_email = $1, hash = $2 WHERE seq = 3",
+					forged.UserEmail, audit.ChainHash(forged.PrevHash, content))
+				require.NoError(t, err)
+			},
+			seq:    4,
+			reason: audit.ReasonRelinked,
+		},
+		{
+			name: "deleted row",
+			tamper: func(t *testing.T, svc *Service, logs []*AuditLog) {
+				_, err := svc.db.Exec("DELETE FROM audit_logs WHERE seq = 2")
+				require.NoError(t, err)
+			},
+			seq:    3,
+			reason: audit.ReasonMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newAuditService(t)
+			logs := recordChain(t, svc, 5)
+			tt.tamper(t, svc, logs)
+
+			report, err := svc.VerifyAuditChain(context.Background(), time.Time{}, time.Time{})
+			require.NoError(t, err)
+			assert.False(t, report.Intact)
+			require.NotNil(t, report.FirstBroken)
+			assert.Equal(t, tt.seq, report.FirstBroken.Seq)
+			assert.Equal(t, tt.reason, report.FirstBroken.Reason, report.FirstBroken.Message)
+		})
+	}
+}
+
+func TestRecordAuditLogConcurrently(t *testing.T) {
+	svc := newAuditService(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, svc.RecordAuditLog(ctx, &AuditLog{
+				Action:       "sync",
+				ResourceType: "application",
+				ResourceName: fmt.Sprintf("app-%d", i),
+			}))
+		}(i)
+	}
+	wg.Wait()
+
+	report, err := svc.VerifyAuditChain(ctx, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, report.Intact, "%+v", report.FirstBroken)
+	assert.Equal(t, 20, report.Checked)
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// insertAuditLogs adds n audit logs for user@example.com, spaced by step
+// from start
+func insertAuditLogs(t *testing.T, svc *Service, n int, start time.Time, step time.Duration) {
+	t.Helper()
+	tx, err := svc.db.Begin()
+	require.NoError(t, err)
+	stmt, err := tx.Prepare(`INSERT INTO audit_logs (id, user_email, action, resource_type, resource_id,
+		resource_name, cluster_name, ip_address, status, created_at)
+		VALUES ($1, 'user@example.com', 'sync', 'application', $2, $3, 'prod', '10.0.0.1', 'success', $4)`)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		_, err := stmt.Exec(fmt.Sprintf("bulk-%06d", i), fmt.Sprintf("app-%d", i), fmt.Sprintf("=app-%d", i), start.Add(time.Duration(i)*step))
+		require.NoError(t, err)
+	}
+	require.NoError(t, stmt.Close())
+	require.NoError(t, tx.Commit())
+}
+
+func TestExportAuditLogsFormats(t *testing.T) {
+	svc := newAuditService(t)
+	ctx := context.Background()
+	defer func(batch int) { auditExportBatch = batch }(auditExportBatch)
+	auditExportBatch = 2
+	filter := AuditLogFilter{Action: "delete", From: auditDay}
+
+	data, contentType, err := svc.ExportAuditLogsBytes(ctx, ExportFormatCSV, filter)
+	require.NoError(t, err)
+	assert.Equal(t, "text/csv", contentType)
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 5)
+	assert.Equal(t, auditExportCSVHeader, records[0])
+	assert.Equal(t, []string{"6", "5", "3", "2"}, []string{records[1][0], records[2][0], records[3][0], records[4][0]})
+
+	data, contentType, err = svc.ExportAuditLogsBytes(ctx, ExportFormatNDJSON, filter)
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-ndjson", contentType)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	var first AuditLog
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "6", first.ID)
+
+	data, _, err = svc.ExportAuditLogsBytes(ctx, ExportFormatJSON, filter)
+	require.NoError(t, err)
+	var logs []AuditLog
+	require.NoError(t, json.Unmarshal(data, &logs))
+	assert.Equal(t, []string{"6", "5", "3", "2"}, auditIDs(logs))
+
+	data, _, err = svc.ExportAuditLogsBytes(ctx, ExportFormatJSON, AuditLogFilter{Action: "create"})
+	require.NoError(t, err)
+	assert.JSONEq(t, "[]", string(data))
+
+	_, _, err = svc.ExportAuditLogsBytes(ctx, "xml", filter)
+	assert.Error(t, err)
+}
+
+func TestExportAuditLogsSameTimestamp(t *testing.T) {
+	svc := newAuditService(t)
+	defer func(batch int) { auditExportBatch = batch }(auditExportBatch)
+	auditExportBatch = 2
+
+	// Rows sharing a timestamp straddle batches and must each appear once
+	insertAuditLogs(t, svc, 7, auditDay.Add(72*time.Hour), 0)
+	data, _, err := svc.ExportAuditLogsBytes(context.Background(), ExportFormatNDJSON, AuditLogFilter{UserEmail: "user@example.com"})
+	require.NoError(t, err)
+
+	seen := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var log AuditLog
+		require.NoError(t, json.Unmarshal([]byte(line), &log))
+		assert.False(t, seen[log.ID], "%s exported twice", log.ID)
+		seen[log.ID] = true
+	}
+	assert.Len(t, seen, 7)
+}
+
+// heapSampler counts exported lines and samples the heap as they are
+// written
+type heapSampler struct {
+	lines    int
+	baseline uint64
+	peak     uint64
+}
+
+func (h *heapSampler) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			continue
+		}
+		h.lines++
+		if h.lines%2000 == 0 {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > h.peak {
+				h.peak = stats.HeapAlloc
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func TestExportAuditLogsLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("exports a large dataset")
+	}
+	const rows = 50000
+	svc := newAuditService(t)
+	insertAuditLogs(t, svc, rows, auditDay.Add(72*time.Hour), time.Millisecond)
+
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	for _, format := range []string{ExportFormatCSV, ExportFormatNDJSON} {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		sampler := &heapSampler{baseline: stats.HeapAlloc, peak: stats.HeapAlloc}
+
+		require.NoError(t, svc.ExportAuditLogs(context.Background(), bufio.NewWriter(sampler), format, AuditLogFilter{UserEmail: "user@example.com"}))
+
+		want := rows
+		if format == ExportFormatCSV {
+			want++ // header
+		}
+		assert.Equal(t, want, sampler.lines, format)
+		// Holding every row would take tens of megabytes
+		assert.Less(t, sampler.peak-sampler.baseline, uint64(8<<20), "%s export grew the heap by %d bytes", format, sampler.peak-sampler.baseline)
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ProvisionUserRequest is a user as an identity provider provisions it.
+// Provisioned users sign in through the IdP, so they are OIDC users.
+type ProvisionUserRequest struct {
+	Email      string
+	Name       string
+	ExternalID string
+	Active     bool
+}
+
+// ListAllUsers returns every user, oldest first, with their external IDs
+func (s *Service) ListAllUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, email, name, COALESCE(avatar_url, ''), provider, role, is_active,
+		       COALESCE(external_id, ''), created_at, updated_at
+		FROM users
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to query users")
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(
+			&user.ID, &user.Email, &user.Name, &user.AvatarURL, &user.Provider,
+			&user.Role, &user.IsActive, &user.ExternalID, &user.CreatedAt, &user.UpdatedAt,
+		); err != nil {
+			return nil, errors.DatabaseWrap(err, "failed to scan user")
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to read users")
+	}
+	return users, nil
+}
+
+// ProvisionUser creates a user for an identity provider
+func (s *Service) ProvisionUser(ctx context.Context, req *ProvisionUserRequest) (*User, error) {
+	email, name := provisionedIdentity(req)
+	if email == "" {
+		return nil, errors.Validation("email is required")
+	}
+	if err := s.checkEmailFree(ctx, email, ""); err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewString()
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (id, email, name, avatar_url, provider, role, is_active, external_id, created_at, updated_at)
+		VALUES ($1, $2, $3, '', 'oidc', 'user', $4, $5, $6, $6)
+	`, id, email, name, req.Active, externalID(req), now); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to create user")
+	}
+
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("User provisioned", zap.String("user_id", id), zap.String("email", email))
+	s.recordChange(ctx, "provision", "user", id, email, nil, user)
+	return user, nil
+}
+
+// UpdateProvisionedUser replaces a user's identity and active state with
+// what the identity provider holds. Deactivating a user revokes their
+// tokens.
+func (s *Service) UpdateProvisionedUser(ctx context.Context, id string, req *ProvisionUserRequest) (*User, error) {
+	email, name := provisionedIdentity(req)
+	if email == "" {
+		return nil, errors.Validation("email is required")
+	}
+	before, err := s.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(email, before.Email) {
+		if err := s.checkEmailFree(ctx, email, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE users
+		SET email = $2, name = $3, external_id = $4, is_active = $5, updated_at = $6
+		WHERE id = $1
+	`, id, email, name, externalID(req), req.Active, time.Now()); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to update user")
+	}
+
+	action := "update"
+	if before.IsActive && !req.Active {
+		action = "deactivate"
+		if err := s.RevokeUserTokens(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.recordChange(ctx, action, "user", id, user.Email, before, user)
+	return user, nil
+}
+
+// RevokeUserTokens revokes every token issued to a user until now, on
+// every replica: ValidateToken and RefreshToken reject tokens issued
+// before the revocation. Sessions tracked in Redis are revoked too.
+func (s *Service) RevokeUserTokens(ctx context.Context, userID string) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET tokens_valid_after = $2 WHERE id = $1", userID, time.Now())
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to revoke tokens")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.NotFound("user", userID)
+	}
+
+	sessions, err := s.ListSessions(ctx, userID)
+	if err != nil {
+		logger.Warn("Failed to list sessions to revoke", zap.String("user_id", userID), zap.Error(err))
+	}
+	for _, session := range sessions {
+		_ = s.RevokeSession(ctx, userID, session.JTI)
+	}
+	logger.Info("User tokens revoked", zap.String("user_id", userID))
+	return nil
+}
+
+// checkUserTokens rejects the tokens of users that are disabled or gone,
+// and tokens issued before the user's tokens were revoked. Token issue
+// times are whole seconds, so tokens issued in the second of the
+// revocation count as issued before it. Without a database there is
+// nothing to check.
+func (s *Service) checkUserTokens(ctx context.Context, claims *Claims) error {
+	if s.db == nil {
+		return nil
+	}
+	var active bool
+	var validAfter sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"SELECT is_active, tokens_valid_after FROM users WHERE id = $1", claims.UserID,
+	).Scan(&active, &validAfter)
+	if err == sql.ErrNoRows {
+		return errors.Unauthorized("user no longer exists")
+	}
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to check user")
+	}
+	if !active {
+		return errors.Unauthorized("account is disabled")
+	}
+	if validAfter.Valid && (claims.IssuedAt == nil || !claims.IssuedAt.Time.After(validAfter.Time.Truncate(time.Second))) {
+		return errors.Unauthorized("token has been revoked")
+	}
+	return nil
+}
+
+// checkEmailFree returns a conflict if a user other than exceptID has email
+func (s *Service) checkEmailFree(ctx context.Context, email, exceptID string) error {
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1))"
+	args := []interface{}{email}
+	if exceptID != "" {
+		query = "SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2)"
+		args = append(args, exceptID)
+	}
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		return errors.DatabaseWrap(err, "failed to check email")
+	}
+	if exists {
+		return errors.Conflict("email already registered")
+	}
+	return nil
+}
+
+// provisionedIdentity returns the trimmed email and name of req, naming
+// the user by their email if the IdP gave no name
+func provisionedIdentity(req *ProvisionUserRequest) (email, name string) {
+	email = strings.TrimSpace(req.Email)
+	name = strings.TrimSpace(req.Name)
+	if name == "" {
+		name = email
+	}
+	return email, name
+}
+
+// externalID returns the IdP's ID for req, NULL if it gave none
+func externalID(req *ProvisionUserRequest) sql.NullString {
+	id := strings.TrimSpace(req.ExternalID)
+	return sql.NullString{String: id, Valid: id != ""}
+}
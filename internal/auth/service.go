@@ -7,17 +7,18 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/internal/audit"
 	"github.com/anubhavg-icpl/krustron/pkg/cache"
 	"github.com/anubhavg-icpl/krustron/pkg/config"
 	"github.com/anubhavg-icpl/krustron/pkg/database"
 	"github.com/anubhavg-icpl/krustron/pkg/errors"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
@@ -30,6 +31,7 @@ import (
 // Service provides authentication and authorization functionality
 type Service struct {
 	db           *database.PostgresDB
+	recorder     *audit.Recorder
 	cache        *cache.RedisCache
 	config       *config.AuthConfig
 	oidcProvider *oidc.Provider
@@ -49,9 +51,10 @@ func NewService(db *database.PostgresDB, cache *cache.RedisCache, cfg *config.Au
 	}
 
 	svc := &Service{
-		db:     db,
-		cache:  cache,
-		config: cfg,
+		db:       db,
+		recorder: audit.NewRecorder(db, logger.Get()),
+		cache:    cache,
+		config:   cfg,
 	}
 
 	// Initialize OIDC if enabled
@@ -86,6 +89,8 @@ type User struct {
 	IsActive     bool       `json:"is_active" db:"is_active"`
 	TOTPEnabled  bool       `json:"totp_enabled" db:"totp_enabled"`
 	TOTPSecret   string     `json:"-" db:"totp_secret"`
+	// ExternalID is the identity provider's ID for a provisioned user
+	ExternalID   string     `json:"external_id,omitempty" db:"external_id"`
 	LastLoginAt  *time.Time `json:"last_login_at" db:"last_login_at"`
 	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
@@ -99,7 +104,29 @@ type Claims struct {
 	Name        string   `json:"name"`
 	Role        string   `json:"role"`
 	Permissions []string `json:"permissions"`
-	TokenType   string   `json:"token_type,omitempty"` // "access" or "refresh"
+	TokenType   string   `json:"token_type,omitempty"` // "access", "refresh" or "api_key"
+}
+
+// HasPermission reports whether the claims grant permission, either
+// directly, through "*", or through a "<prefix>:*" or "*:<action>"
+// wildcard. Admins hold every permission.
+func (c *Claims) HasPermission(permission string) bool {
+	// An API key is limited to its scopes, even when an admin owns it
+	if c.Role == "admin" && c.TokenType != TokenTypeAPIKey {
+		return true
+	}
+	for _, p := range c.Permissions {
+		if p == permission || p == "*" {
+			return true
+		}
+		if strings.HasSuffix(p, ":*") && strings.HasPrefix(permission, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+		if strings.HasPrefix(p, "*:") && strings.HasSuffix(permission, strings.TrimPrefix(p, "*")) {
+			return true
+		}
+	}
+	return false
 }
 
 // LoginRequest contains login credentials
@@ -147,22 +174,26 @@ func (s *Service) Login(ctx context.Context, req *LoginRequest) (*LoginResponse,
 		&user.LastLoginAt, &user.CreatedAt, &user.UpdatedAt,
 	); err != nil {
 		if err == sql.ErrNoRows {
+			metrics.AuthLoginFailures.Inc()
 			return nil, errors.Unauthorized("invalid email or password")
 		}
 		return nil, errors.DatabaseWrap(err, "failed to query user")
 	}
 
 	if !user.IsActive {
+		metrics.AuthLoginFailures.Inc()
 		return nil, errors.Unauthorized("account is disabled")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		metrics.AuthLoginFailures.Inc()
 		return nil, errors.Unauthorized("invalid email or password")
 	}
 
 	// If 2FA is enabled, require a valid TOTP code before issuing tokens.
 	if user.TOTPEnabled && user.TOTPSecret != "" {
 		if req.TOTPCode == "" || !totp.Validate(req.TOTPCode, user.TOTPSecret) {
+			metrics.AuthLoginFailures.Inc()
 			return nil, errors.Unauthorized("invalid or missing two-factor code")
 		}
 	}
@@ -227,6 +258,9 @@ func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*Login
 	if claims.TokenType == "access" {
 		return nil, errors.Unauthorized("access token cannot be used for refresh")
 	}
+	if err := s.checkUserTokens(ctx, claims); err != nil {
+		return nil, err
+	}
 
 	// Get user
 	user, err := s.GetUser(ctx, claims.UserID)
@@ -241,8 +275,12 @@ func (s *Service) RefreshToken(ctx context.Context, refreshToken string) (*Login
 	return s.generateTokens(ctx, user)
 }
 
-// ValidateToken validates a JWT token and returns claims
+// ValidateToken validates a JWT or API key and returns claims
 func (s *Service) ValidateToken(tokenString string) (*Claims, error) {
+	if strings.HasPrefix(tokenString, APIKeyPrefix) {
+		return s.ValidateAPIKey(context.Background(), tokenString, "")
+	}
+
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -269,6 +307,11 @@ func (s *Service) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.Unauthorized("token has been revoked")
 	}
 
+	// Disabling a user, or revoking all their tokens, takes effect at once
+	if err := s.checkUserTokens(context.Background(), claims); err != nil {
+		return nil, err
+	}
+
 	return claims, nil
 }
 
@@ -497,14 +540,14 @@ func (s *Service) GetUser(ctx context.Context, id string) (*User, error) {
 
 	query := `
 		SELECT id, email, name, avatar_url, provider, role, is_active,
-		       last_login_at, created_at, updated_at
+		       COALESCE(external_id, ''), last_login_at, created_at, updated_at
 		FROM users WHERE id = $1
 	`
 
 	var lastLoginAt sql.NullTime
 	if err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID, &user.Email, &user.Name, &user.AvatarURL, &user.Provider,
-		&user.Role, &user.IsActive, &lastLoginAt, &user.CreatedAt, &user.UpdatedAt,
+		&user.Role, &user.IsActive, &user.ExternalID, &lastLoginAt, &user.CreatedAt, &user.UpdatedAt,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.NotFound("user", id)
@@ -527,6 +570,8 @@ type UpdateUserRequest struct {
 
 // UpdateUser updates a user
 func (s *Service) UpdateUser(ctx context.Context, id string, req *UpdateUserRequest) (*User, error) {
+	before, _ := s.GetUser(ctx, id)
+
 	query := `
 		UPDATE users
 		SET name = COALESCE(NULLIF($2, ''), name),
@@ -545,7 +590,12 @@ func (s *Service) UpdateUser(ctx context.Context, id string, req *UpdateUserRequ
 		return nil, errors.NotFound("user", id)
 	}
 
-	return s.GetUser(ctx, id)
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.recordChange(ctx, "update", "user", id, user.Email, before, user)
+	return user, nil
 }
 
 // AuthConfig returns the auth configuration (handlers use it for cookie mode).
@@ -761,13 +811,56 @@ func (s *Service) ListUsers(ctx context.Context, page, limit int) ([]User, int,
 		SELECT id, email, name, avatar_url, provider, role, is_active,
 		       last_login_at, created_at, updated_at
 		FROM users
-		ORDER BY created_at DESC
+		ORDER BY created_at DESC, id DESC
 		LIMIT $1 OFFSET $2
 	`
 
-	rows, err := s.db.QueryContext(ctx, query, limit, offset)
+	users, err := s.queryUsers(ctx, query, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// ListUsersAfter returns the page of users after cursor, newest first,
+// and the cursor for the next page, empty on the last. An empty cursor
+// starts from the newest.
+func (s *Service) ListUsersAfter(ctx context.Context, cursor string, limit int) ([]User, string, error) {
+	after, err := database.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	query := `
+		SELECT id, email, name, avatar_url, provider, role, is_active,
+		       last_login_at, created_at, updated_at
+		FROM users
+	`
+	args := []interface{}{}
+	if !after.IsZero() {
+		query += " WHERE (created_at, id) < ($1, $2)"
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args)+1)
+
+	users, err := s.queryUsers(ctx, query, append(args, limit+1)...)
+	if err != nil {
+		return nil, "", err
+	}
+	users, next := database.KeysetPage(users, limit, func(u *User) database.Cursor {
+		return database.Cursor{CreatedAt: u.CreatedAt, ID: u.ID}
+	})
+	return users, next, nil
+}
+
+// queryUsers runs a users list query
+func (s *Service) queryUsers(ctx context.Context, query string, args ...interface{}) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, errors.DatabaseWrap(err, "failed to query users")
+		return nil, errors.DatabaseWrap(err, "failed to query users")
 	}
 	defer rows.Close()
 
@@ -780,7 +873,7 @@ func (s *Service) ListUsers(ctx context.Context, page, limit int) ([]User, int,
 			&user.ID, &user.Email, &user.Name, &user.AvatarURL, &user.Provider,
 			&user.Role, &user.IsActive, &lastLoginAt, &user.CreatedAt, &user.UpdatedAt,
 		); err != nil {
-			return nil, 0, errors.DatabaseWrap(err, "failed to scan user")
+			return nil, errors.DatabaseWrap(err, "failed to scan user")
 		}
 
 		if lastLoginAt.Valid {
@@ -790,7 +883,7 @@ func (s *Service) ListUsers(ctx context.Context, page, limit int) ([]User, int,
 		users = append(users, user)
 	}
 
-	return users, total, nil
+	return users, nil
 }
 
 // CreateUserRequest contains data for creating a user
@@ -827,11 +920,14 @@ func (s *Service) CreateUser(ctx context.Context, req *CreateUserRequest) (*User
 		return nil, errors.DatabaseWrap(err, "failed to create user")
 	}
 
+	s.recordChange(ctx, "create", "user", user.ID, user.Email, nil, &user)
 	return &user, nil
 }
 
 // DeleteUser deletes a user
 func (s *Service) DeleteUser(ctx context.Context, id string) error {
+	before, _ := s.GetUser(ctx, id)
+
 	result, err := s.db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
 	if err != nil {
 		return errors.DatabaseWrap(err, "failed to delete user")
@@ -842,6 +938,11 @@ func (s *Service) DeleteUser(ctx context.Context, id string) error {
 		return errors.NotFound("user", id)
 	}
 
+	var email string
+	if before != nil {
+		email = before.Email
+	}
+	s.recordChange(ctx, "delete", "user", id, email, before, nil)
 	return nil
 }
 
@@ -870,6 +971,7 @@ func (s *Service) AssignRoles(ctx context.Context, userID string, req *AssignRol
 		}
 	}
 
+	s.recordChange(ctx, "assign_roles", "user", userID, "", nil, req)
 	return nil
 }
 
@@ -970,6 +1072,7 @@ func (s *Service) CreateRole(ctx context.Context, req *CreateRoleRequest) (*Role
 	}
 
 	json.Unmarshal(permsOut, &role.Permissions)
+	s.recordChange(ctx, "create", "role", role.ID, role.Name, nil, &role)
 	return &role, nil
 }
 
@@ -982,6 +1085,7 @@ type UpdateRoleRequest struct {
 
 // UpdateRole updates a role
 func (s *Service) UpdateRole(ctx context.Context, id string, req *UpdateRoleRequest) (*Role, error) {
+	before, _ := s.GetRole(ctx, id)
 	perms, _ := json.Marshal(req.Permissions)
 
 	query := `
@@ -1003,11 +1107,18 @@ func (s *Service) UpdateRole(ctx context.Context, id string, req *UpdateRoleRequ
 		return nil, errors.BadRequest("cannot update system role")
 	}
 
-	return s.GetRole(ctx, id)
+	role, err := s.GetRole(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.recordChange(ctx, "update", "role", id, role.Name, before, role)
+	return role, nil
 }
 
 // DeleteRole deletes a role
 func (s *Service) DeleteRole(ctx context.Context, id string) error {
+	before, _ := s.GetRole(ctx, id)
+
 	result, err := s.db.ExecContext(ctx, "DELETE FROM roles WHERE id = $1 AND is_system = false", id)
 	if err != nil {
 		return errors.DatabaseWrap(err, "failed to delete role")
@@ -1018,6 +1129,11 @@ func (s *Service) DeleteRole(ctx context.Context, id string) error {
 		return errors.BadRequest("cannot delete system role")
 	}
 
+	var name string
+	if before != nil {
+		name = before.Name
+	}
+	s.recordChange(ctx, "delete", "role", id, name, before, nil)
 	return nil
 }
 
@@ -1051,79 +1167,237 @@ type AuditLog struct {
 	NewValue     map[string]interface{} `json:"new_value,omitempty"`
 	Metadata     map[string]interface{} `json:"metadata"`
 	IPAddress    string                 `json:"ip_address"`
+	UserAgent    string                 `json:"user_agent,omitempty"`
+	RequestID    string                 `json:"request_id,omitempty"`
 	Status       string                 `json:"status"`
+	ErrorMessage string                 `json:"error_message,omitempty"`
 	CreatedAt    time.Time              `json:"created_at"`
+	// Seq, PrevHash and Hash place the entry in the tamper-evident chain
+	Seq      int64  `json:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// AuditLogFilter narrows an audit log query. Empty fields match
+// everything; the date range includes From and excludes To.
+type AuditLogFilter struct {
+	UserID       string
+	UserEmail    string
+	Action       string
+	ResourceType string
+	// Cluster matches the cluster ID, or the cluster name when it is not a
+	// UUID
+	Cluster string
+	Status  string
+	From    time.Time
+	To      time.Time
+	// Search matches part of the resource name or error message, ignoring
+	// case
+	Search string
+	Page   int
+	Limit  int
+}
+
+// where renders the filter as a WHERE clause and its arguments
+func (f AuditLogFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.UserID != "" {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.UserEmail != "" {
+		add("LOWER(user_email) = $%d", strings.ToLower(f.UserEmail))
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.ResourceType != "" {
+		add("resource_type = $%d", f.ResourceType)
+	}
+	if f.Cluster != "" {
+		if _, err := uuid.Parse(f.Cluster); err == nil {
+			add("cluster_id = $%d", f.Cluster)
+		} else {
+			add("cluster_name = $%d", f.Cluster)
+		}
+	}
+	if f.Status != "" {
+		add("status = $%d", f.Status)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To.UTC())
+	}
+	if f.Search != "" {
+		args = append(args, "%"+escapeLike(strings.ToLower(f.Search))+"%")
+		n := len(args)
+		conds = append(conds, fmt.Sprintf(
+			`(LOWER(resource_name) LIKE $%d ESCAPE '\' OR LOWER(error_message) LIKE $%d ESCAPE '\')`, n, n))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// escapeLike makes LIKE wildcards in s match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 // ListAuditLogs returns audit logs with pagination
 func (s *Service) ListAuditLogs(ctx context.Context, page, limit int) ([]AuditLog, int, error) {
-	offset := (page - 1) * limit
+	return s.SearchAuditLogs(ctx, AuditLogFilter{Page: page, Limit: limit})
+}
+
+// SearchAuditLogs returns a page of the audit logs matching filter, newest
+// first, and how many match in total
+func (s *Service) SearchAuditLogs(ctx context.Context, filter AuditLogFilter) ([]AuditLog, int, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 {
+		filter.Limit = 50
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, 0, errors.Validation("from must be before to")
+	}
+	offset := (filter.Page - 1) * filter.Limit
+	where, args := filter.where()
 
 	var total int
-	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_logs").Scan(&total); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_logs"+where, args...).Scan(&total); err != nil {
 		return nil, 0, errors.DatabaseWrap(err, "failed to count audit logs")
 	}
 
-	query := `
-		SELECT id, user_id, user_email, action, resource_type, resource_id,
-		       resource_name, cluster_id, cluster_name, metadata, ip_address,
-		       status, created_at
-		FROM audit_logs
-		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2
-	`
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM audit_logs%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, auditListColumns, where, len(args)+1, len(args)+2)
+
+	logs, err := s.queryAuditLogs(ctx, query, append(args, filter.Limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// SearchAuditLogsAfter returns the page of audit logs matching filter
+// after cursor, newest first, and the cursor for the next page, empty on
+// the last. An empty cursor starts from the newest; filter.Page is
+// ignored.
+func (s *Service) SearchAuditLogsAfter(ctx context.Context, filter AuditLogFilter, cursor string) ([]AuditLog, string, error) {
+	after, err := database.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if filter.Limit < 1 {
+		filter.Limit = 50
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, "", errors.Validation("from must be before to")
+	}
+
+	where, args := filter.where()
+	query := "SELECT " + auditListColumns + " FROM audit_logs" + where
+	if !after.IsZero() {
+		cond := " WHERE "
+		if where != "" {
+			cond = " AND "
+		}
+		args = append(args, after.CreatedAt, after.ID)
+		query += fmt.Sprintf("%s(created_at, id) < ($%d, $%d)", cond, len(args)-1, len(args))
+	}
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	logs, err := s.queryAuditLogs(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	logs, next := database.KeysetPage(logs, filter.Limit, func(l *AuditLog) database.Cursor {
+		return database.Cursor{CreatedAt: l.CreatedAt, ID: l.ID}
+	})
+	return logs, next, nil
+}
 
-	rows, err := s.db.QueryContext(ctx, query, limit, offset)
+// queryAuditLogs runs an audit log list query selecting auditListColumns
+func (s *Service) queryAuditLogs(ctx context.Context, query string, args ...interface{}) ([]AuditLog, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, errors.DatabaseWrap(err, "failed to query audit logs")
+		return nil, errors.DatabaseWrap(err, "failed to query audit logs")
 	}
 	defer rows.Close()
 
 	var logs []AuditLog
 	for rows.Next() {
-		var log AuditLog
-		var metadata []byte
-		var userID, clusterID sql.NullString
-
-		if err := rows.Scan(
-			&log.ID, &userID, &log.UserEmail, &log.Action, &log.ResourceType,
-			&log.ResourceID, &log.ResourceName, &clusterID, &log.ClusterName,
-			&metadata, &log.IPAddress, &log.Status, &log.CreatedAt,
-		); err != nil {
-			return nil, 0, errors.DatabaseWrap(err, "failed to scan audit log")
+		log, err := scanAuditListRow(rows)
+		if err != nil {
+			return nil, err
 		}
+		logs = append(logs, log)
+	}
 
-		if userID.Valid {
-			log.UserID = userID.String
-		}
-		if clusterID.Valid {
-			log.ClusterID = clusterID.String
-		}
-		json.Unmarshal(metadata, &log.Metadata)
+	return logs, nil
+}
 
-		logs = append(logs, log)
+// auditListColumns are the audit log columns listed and exported
+const auditListColumns = `id, user_id, user_email, action, resource_type, resource_id,
+		       resource_name, cluster_id, cluster_name, metadata, ip_address,
+		       status, created_at`
+
+// scanAuditListRow scans a row of auditListColumns
+func scanAuditListRow(rows *sql.Rows) (AuditLog, error) {
+	var log AuditLog
+	var metadata []byte
+	var userID, clusterID sql.NullString
+
+	if err := rows.Scan(
+		&log.ID, &userID, &log.UserEmail, &log.Action, &log.ResourceType,
+		&log.ResourceID, &log.ResourceName, &clusterID, &log.ClusterName,
+		&metadata, &log.IPAddress, &log.Status, &log.CreatedAt,
+	); err != nil {
+		return AuditLog{}, errors.DatabaseWrap(err, "failed to scan audit log")
 	}
 
-	return logs, total, nil
+	if userID.Valid {
+		log.UserID = userID.String
+	}
+	if clusterID.Valid {
+		log.ClusterID = clusterID.String
+	}
+	json.Unmarshal(metadata, &log.Metadata)
+	return log, nil
 }
 
 // GetAuditLog returns a single audit log
 func (s *Service) GetAuditLog(ctx context.Context, id string) (*AuditLog, error) {
 	var log AuditLog
 	var metadata, oldValue, newValue []byte
-	var userID, clusterID sql.NullString
+	var userID, clusterID, userAgent, requestID sql.NullString
 
 	query := `
 		SELECT id, user_id, user_email, action, resource_type, resource_id,
 		       resource_name, cluster_id, cluster_name, old_value, new_value,
-		       metadata, ip_address, status, created_at
+		       metadata, ip_address, user_agent, request_id, status, created_at
 		FROM audit_logs WHERE id = $1
 	`
 
 	if err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&log.ID, &userID, &log.UserEmail, &log.Action, &log.ResourceType,
 		&log.ResourceID, &log.ResourceName, &clusterID, &log.ClusterName,
-		&oldValue, &newValue, &metadata, &log.IPAddress, &log.Status, &log.CreatedAt,
+		&oldValue, &newValue, &metadata, &log.IPAddress, &userAgent, &requestID,
+		&log.Status, &log.CreatedAt,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.NotFound("audit log", id)
@@ -1137,6 +1411,7 @@ func (s *Service) GetAuditLog(ctx context.Context, id string) (*AuditLog, error)
 	if clusterID.Valid {
 		log.ClusterID = clusterID.String
 	}
+	log.UserAgent, log.RequestID = userAgent.String, requestID.String
 	json.Unmarshal(metadata, &log.Metadata)
 	json.Unmarshal(oldValue, &log.OldValue)
 	json.Unmarshal(newValue, &log.NewValue)
@@ -1144,38 +1419,6 @@ func (s *Service) GetAuditLog(ctx context.Context, id string) (*AuditLog, error)
 	return &log, nil
 }
 
-// ExportAuditLogs exports audit logs in the specified format
-func (s *Service) ExportAuditLogs(ctx context.Context, format string) ([]byte, string, error) {
-	logs, _, err := s.ListAuditLogs(ctx, 1, 10000)
-	if err != nil {
-		return nil, "", err
-	}
-
-	switch format {
-	case "json":
-		data, _ := json.Marshal(logs)
-		return data, "application/json", nil
-	case "csv":
-		// RFC4180 CSV via encoding/csv: quotes/escapes fields properly so
-		// user-controlled values (email, action, resource_name) can't break
-		// columns or inject spreadsheet formulas (= / + / - / @).
-		var buf strings.Builder
-		w := csv.NewWriter(&buf)
-		_ = w.Write([]string{"id", "user_email", "action", "resource_type", "resource_id", "created_at"})
-		for _, log := range logs {
-			_ = w.Write([]string{
-				sanitizeCSVCell(log.ID), sanitizeCSVCell(log.UserEmail),
-				sanitizeCSVCell(log.Action), sanitizeCSVCell(log.ResourceType),
-				sanitizeCSVCell(log.ResourceID), log.CreatedAt.Format(time.RFC3339),
-			})
-		}
-		w.Flush()
-		return []byte(buf.String()), "text/csv", nil
-	default:
-		return nil, "", errors.BadRequest("unsupported format")
-	}
-}
-
 // sanitizeCSVCell neutralizes CSV/spreadsheet formula injection: spreadsheet
 // apps evaluate a quoted cell that starts with = + - @ as a formula, so prefix
 // those (and tab/CR) with a single quote to keep the value literal.
@@ -1206,17 +1449,3 @@ func (s *Service) UpdateSettings(ctx context.Context, settings map[string]interf
 	// Settings update logic
 	return nil
 }
-
-// GetNotificationSettings returns notification settings
-func (s *Service) GetNotificationSettings(ctx context.Context) (map[string]interface{}, error) {
-	return map[string]interface{}{
-		"email":   map[string]interface{}{"enabled": false},
-		"slack":   map[string]interface{}{"enabled": false},
-		"webhook": map[string]interface{}{"enabled": false},
-	}, nil
-}
-
-// UpdateNotificationSettings updates notification settings
-func (s *Service) UpdateNotificationSettings(ctx context.Context, settings map[string]interface{}) error {
-	return nil
-}
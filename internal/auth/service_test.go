@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginFailuresCounted(t *testing.T) {
+	svc := newAPIKeyService(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+	_, err = svc.db.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hash), deployerID)
+	require.NoError(t, err)
+	failures := testutil.ToFloat64(metrics.AuthLoginFailures)
+	ctx := context.Background()
+
+	for _, req := range []*LoginRequest{
+		{Email: "ci@example.com", Password: "wrong"},
+		{Email: "nobody@example.com", Password: "correct horse"},
+	} {
+		_, err := svc.Login(ctx, req)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+	}
+	assert.Equal(t, failures+2, testutil.ToFloat64(metrics.AuthLoginFailures))
+
+	_, err = svc.db.Exec(`UPDATE users SET is_active = false WHERE id = $1`, deployerID)
+	require.NoError(t, err)
+	_, err = svc.Login(ctx, &LoginRequest{Email: "ci@example.com", Password: "correct horse"})
+	require.Error(t, err)
+	assert.Equal(t, failures+3, testutil.ToFloat64(metrics.AuthLoginFailures))
+}
+
+func TestListUsersAfter(t *testing.T) {
+	svc := newAPIKeyService(t)
+	ctx := context.Background()
+	joined := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	_, err := svc.db.Exec(`UPDATE users SET created_at = $1`, joined)
+	require.NoError(t, err)
+	added := 0
+	addUser := func(at time.Time) {
+		added++
+		_, err := svc.db.Exec(`INSERT INTO users (id, email, name, created_at, updated_at) VALUES ($1, $2, 'User', $3, $3)`,
+			fmt.Sprintf("user-%02d", added), fmt.Sprintf("user%d@example.com", added), at)
+		require.NoError(t, err)
+	}
+	// Users sharing a timestamp straddle pages and are ordered by ID
+	for i := 0; i < 5; i++ {
+		addUser(joined.Add(time.Duration(i/2) * time.Hour))
+	}
+
+	userIDs := func(users []User) []string {
+		ids := make([]string, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		return ids
+	}
+
+	var offset []string
+	for page := 1; page <= 3; page++ {
+		users, total, err := svc.ListUsers(ctx, page, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 7, total)
+		offset = append(offset, userIDs(users)...)
+	}
+
+	// Users created between pages do not shift keyset pages
+	var keyset []string
+	cursor := ""
+	for {
+		users, next, err := svc.ListUsersAfter(ctx, cursor, 3)
+		require.NoError(t, err)
+		keyset = append(keyset, userIDs(users)...)
+		if next == "" {
+			break
+		}
+		cursor = next
+		addUser(joined.Add(24 * time.Hour))
+	}
+	assert.Equal(t, offset, keyset)
+	assert.Equal(t, []string{"user-05", "user-04", "user-03", "user-02", "user-01"}, keyset[:5])
+
+	_, _, err = svc.ListUsersAfter(ctx, "bm90LWEtY3Vyc29y", 3)
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+}
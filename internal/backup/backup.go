@@ -0,0 +1,208 @@
+// Package backup dumps Krustron state into a single versioned archive and
+// restores it
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaVersion is the archive format written by Backup. Restore accepts
+// archives up to this version.
+const SchemaVersion = 1
+
+// Resource types carried by an archive
+const (
+	ResourceRBAC        = "rbac"
+	ResourceRemediation = "remediation"
+	ResourceBudgets     = "budgets"
+	ResourcePipelines   = "pipelines"
+)
+
+// Source is a service whose state goes into backups. RestoreBackup must
+// write only through tx so a restore commits or rolls back as a whole.
+type Source interface {
+	ExportBackup(ctx context.Context) ([]byte, error)
+	RestoreBackup(ctx context.Context, tx *gorm.DB, data []byte) (int, error)
+}
+
+// Reloader is implemented by sources that keep state in memory; it runs
+// after a restore has committed
+type Reloader interface {
+	AfterRestore(ctx context.Context) error
+}
+
+// Archive is a backup: the exported state of each resource type
+type Archive struct {
+	SchemaVersion   int                        `json:"schema_version"`
+	KrustronVersion string                     `json:"krustron_version,omitempty"`
+	CreatedAt       time.Time                  `json:"created_at"`
+	Resources       map[string]json.RawMessage `json:"resources"`
+}
+
+// ResourceTypes returns the resource types in the archive, sorted
+func (a *Archive) ResourceTypes() []string {
+	types := make([]string, 0, len(a.Resources))
+	for t := range a.Resources {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// RestoreOptions selects what Restore does
+type RestoreOptions struct {
+	// Resources limits the restore to these types; empty restores all
+	// types in the archive
+	Resources []string
+	// DryRun runs the restore and rolls it back, so the report shows what
+	// would change without changing anything
+	DryRun bool
+}
+
+// ResourceResult is how many objects of a type were restored
+type ResourceResult struct {
+	Resource string `json:"resource"`
+	Count    int    `json:"count"`
+}
+
+// RestoreReport is the outcome of Restore
+type RestoreReport struct {
+	DryRun    bool             `json:"dry_run"`
+	Resources []ResourceResult `json:"resources"`
+}
+
+// errDryRun rolls back a dry-run restore
+var errDryRun = goerrors.New("dry run")
+
+// Manager backs up and restores the registered sources
+type Manager struct {
+	db      *gorm.DB
+	version string
+	sources map[string]Source
+}
+
+// NewManager creates a manager that restores through db. version is
+// recorded in the archives it writes.
+func NewManager(db *gorm.DB, version string) *Manager {
+	return &Manager{db: db, version: version, sources: make(map[string]Source)}
+}
+
+// Register adds the source for a resource type
+func (m *Manager) Register(resource string, src Source) {
+	m.sources[resource] = src
+}
+
+// Backup exports the given resource types, or every registered one, and
+// writes the archive to w as JSON
+func (m *Manager) Backup(ctx context.Context, w io.Writer, resources ...string) (*Archive, error) {
+	if len(resources) == 0 {
+		resources = m.registered()
+	}
+
+	archive := &Archive{
+		SchemaVersion:   SchemaVersion,
+		KrustronVersion: m.version,
+		CreatedAt:       time.Now().UTC(),
+		Resources:       make(map[string]json.RawMessage, len(resources)),
+	}
+	for _, resource := range resources {
+		src, ok := m.sources[resource]
+		if !ok {
+			return nil, fmt.Errorf("unknown resource type %q", resource)
+		}
+		data, err := src.ExportBackup(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", resource, err)
+		}
+		archive.Resources[resource] = data
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(archive); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return archive, nil
+}
+
+// ReadArchive parses an archive and checks that this version can restore it
+func ReadArchive(r io.Reader) (*Archive, error) {
+	var archive Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if archive.SchemaVersion < 1 {
+		return nil, fmt.Errorf("invalid archive: missing schema version")
+	}
+	if archive.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("archive schema version %d is newer than supported version %d; upgrade krustron to restore it",
+			archive.SchemaVersion, SchemaVersion)
+	}
+	return &archive, nil
+}
+
+// Restore writes the archive back in one transaction: either every
+// selected resource type is restored or none is. Sources that implement
+// Reloader are reloaded once the transaction commits.
+func (m *Manager) Restore(ctx context.Context, archive *Archive, opts RestoreOptions) (*RestoreReport, error) {
+	resources := opts.Resources
+	if len(resources) == 0 {
+		resources = archive.ResourceTypes()
+	}
+	for _, resource := range resources {
+		if _, ok := archive.Resources[resource]; !ok {
+			return nil, fmt.Errorf("archive has no %s", resource)
+		}
+		if _, ok := m.sources[resource]; !ok {
+			return nil, fmt.Errorf("unknown resource type %q", resource)
+		}
+	}
+
+	report := &RestoreReport{DryRun: opts.DryRun}
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, resource := range resources {
+			count, err := m.sources[resource].RestoreBackup(ctx, tx, archive.Resources[resource])
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", resource, err)
+			}
+			report.Resources = append(report.Resources, ResourceResult{Resource: resource, Count: count})
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !goerrors.Is(err, errDryRun) {
+		return nil, err
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	for _, resource := range resources {
+		if r, ok := m.sources[resource].(Reloader); ok {
+			if err := r.AfterRestore(ctx); err != nil {
+				return report, fmt.Errorf("restored %s but failed to reload it: %w", resource, err)
+			}
+		}
+	}
+	return report, nil
+}
+
+// registered returns the registered resource types, sorted
+func (m *Manager) registered() []string {
+	types := make([]string, 0, len(m.sources))
+	for t := range m.sources {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,171 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type item struct {
+	Kind string `gorm:"primaryKey"`
+	Name string `gorm:"primaryKey"`
+}
+
+// fakeSource backs up the item rows of one kind
+type fakeSource struct {
+	db       *gorm.DB
+	kind     string
+	fail     bool
+	reloaded int
+}
+
+func (f *fakeSource) ExportBackup(ctx context.Context) ([]byte, error) {
+	var names []string
+	if err := f.db.Model(&item{}).Where("kind = ?", f.kind).Order("name").Pluck("name", &names).Error; err != nil {
+		return nil, err
+	}
+	return json.Marshal(names)
+}
+
+func (f *fakeSource) RestoreBackup(ctx context.Context, tx *gorm.DB, data []byte) (int, error) {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		if err := tx.Save(&item{Kind: f.kind, Name: name}).Error; err != nil {
+			return 0, err
+		}
+	}
+	if f.fail {
+		return 0, fmt.Errorf("boom")
+	}
+	return len(names), nil
+}
+
+func (f *fakeSource) AfterRestore(ctx context.Context) error {
+	f.reloaded++
+	return nil
+}
+
+func newTestManager(t *testing.T) (*Manager, *gorm.DB, map[string]*fakeSource) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(&item{}))
+
+	m := NewManager(db, "1.2.3")
+	sources := map[string]*fakeSource{}
+	for _, kind := range []string{ResourceBudgets, ResourceRBAC} {
+		sources[kind] = &fakeSource{db: db, kind: kind}
+		m.Register(kind, sources[kind])
+	}
+	return m, db, sources
+}
+
+func countItems(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+	var n int64
+	require.NoError(t, db.Model(&item{}).Count(&n).Error)
+	return n
+}
+
+// backupAndClear writes a backup of two budgets and one role, then empties
+// the table
+func backupAndClear(t *testing.T, m *Manager, db *gorm.DB) *Archive {
+	t.Helper()
+	require.NoError(t, db.Create(&[]item{
+		{Kind: ResourceBudgets, Name: "dev"}, {Kind: ResourceBudgets, Name: "prod"}, {Kind: ResourceRBAC, Name: "viewer"},
+	}).Error)
+
+	var buf bytes.Buffer
+	_, err := m.Backup(context.Background(), &buf)
+	require.NoError(t, err)
+	require.NoError(t, db.Where("1 = 1").Delete(&item{}).Error)
+
+	archive, err := ReadArchive(&buf)
+	require.NoError(t, err)
+	return archive
+}
+
+func TestBackupRestore(t *testing.T) {
+	m, db, sources := newTestManager(t)
+	archive := backupAndClear(t, m, db)
+
+	assert.Equal(t, SchemaVersion, archive.SchemaVersion)
+	assert.Equal(t, "1.2.3", archive.KrustronVersion)
+	assert.Equal(t, []string{ResourceBudgets, ResourceRBAC}, archive.ResourceTypes())
+
+	report, err := m.Restore(context.Background(), archive, RestoreOptions{})
+	require.NoError(t, err)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, []ResourceResult{{ResourceBudgets, 2}, {ResourceRBAC, 1}}, report.Resources)
+	assert.EqualValues(t, 3, countItems(t, db))
+	assert.Equal(t, 1, sources[ResourceBudgets].reloaded)
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	m, db, sources := newTestManager(t)
+	archive := backupAndClear(t, m, db)
+
+	report, err := m.Restore(context.Background(), archive, RestoreOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []ResourceResult{{ResourceBudgets, 2}, {ResourceRBAC, 1}}, report.Resources)
+	assert.Zero(t, countItems(t, db), "dry run must not write")
+	assert.Zero(t, sources[ResourceBudgets].reloaded)
+}
+
+func TestRestoreIsTransactional(t *testing.T) {
+	m, db, sources := newTestManager(t)
+	archive := backupAndClear(t, m, db)
+	sources[ResourceRBAC].fail = true
+
+	_, err := m.Restore(context.Background(), archive, RestoreOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to restore rbac")
+	assert.Zero(t, countItems(t, db), "budgets restored before the failure must be rolled back")
+	assert.Zero(t, sources[ResourceBudgets].reloaded)
+}
+
+func TestRestoreSelectedResources(t *testing.T) {
+	m, db, _ := newTestManager(t)
+	archive := backupAndClear(t, m, db)
+
+	report, err := m.Restore(context.Background(), archive, RestoreOptions{Resources: []string{ResourceRBAC}})
+	require.NoError(t, err)
+	assert.Equal(t, []ResourceResult{{ResourceRBAC, 1}}, report.Resources)
+	assert.EqualValues(t, 1, countItems(t, db))
+
+	_, err = m.Restore(context.Background(), archive, RestoreOptions{Resources: []string{ResourcePipelines}})
+	assert.ErrorContains(t, err, "archive has no pipelines")
+}
+
+func TestReadArchiveSchemaVersion(t *testing.T) {
+	_, err := ReadArchive(strings.NewReader(`{"schema_version": 2, "resources": {}}`))
+	assert.ErrorContains(t, err, "newer than supported version 1")
+
+	_, err = ReadArchive(strings.NewReader(`{"resources": {}}`))
+	assert.ErrorContains(t, err, "missing schema version")
+
+	archive, err := ReadArchive(strings.NewReader(`{"schema_version": 1, "resources": {"rbac": []}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{ResourceRBAC}, archive.ResourceTypes())
+}
+
+func TestBackupUnknownResource(t *testing.T) {
+	m, _, _ := newTestManager(t)
+	_, err := m.Backup(context.Background(), &bytes.Buffer{}, "clusters")
+	assert.ErrorContains(t, err, `unknown resource type "clusters"`)
+}
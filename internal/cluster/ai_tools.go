@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/internal/ai"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	defaultToolLogLines = 200
+	maxToolLogLines     = 1000
+	maxToolEvents       = 50
+)
+
+// AITools returns tools that let the AI service read live state from
+// registered clusters: pod logs, events and resource descriptions. Each is
+// authorized as reading the namespace it targets.
+func (s *Service) AITools() []ai.Tool {
+	scope := map[string]interface{}{
+		"cluster_id": map[string]interface{}{"type": "string", "description": "Krustron cluster ID"},
+		"namespace":  map[string]interface{}{"type": "string", "description": "Kubernetes namespace"},
+	}
+	schema := func(required []string, extra map[string]interface{}) map[string]interface{} {
+		properties := map[string]interface{}{}
+		for k, v := range scope {
+			properties[k] = v
+		}
+		for k, v := range extra {
+			properties[k] = v
+		}
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+			"required":   append([]string{"cluster_id", "namespace"}, required...),
+		}
+	}
+
+	return []ai.Tool{
+		{
+			Name:        "get_pod_logs",
+			Description: "Fetch the most recent log lines of a pod's container",
+			Parameters: schema([]string{"pod"}, map[string]interface{}{
+				"pod":        map[string]interface{}{"type": "string"},
+				"container":  map[string]interface{}{"type": "string", "description": "Defaults to the pod's only container"},
+				"tail_lines": map[string]interface{}{"type": "integer", "description": fmt.Sprintf("Default %d, at most %d", defaultToolLogLines, maxToolLogLines)},
+			}),
+			Resource: rbac.ResourceNamespace,
+			Action:   rbac.ActionRead,
+			Run: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+				tail := int64(defaultToolLogLines)
+				if n, ok := args["tail_lines"].(float64); ok && n > 0 {
+					tail = int64(n)
+				}
+				if tail > maxToolLogLines {
+					tail = maxToolLogLines
+				}
+				return s.GetPodLogs(ctx, toolArg(args, "cluster_id"), toolArg(args, "namespace"),
+					toolArg(args, "pod"), toolArg(args, "container"), tail)
+			},
+		},
+		{
+			Name:        "get_events",
+			Description: "List the most recent Kubernetes events in a namespace, optionally for one object",
+			Parameters: schema(nil, map[string]interface{}{
+				"object": map[string]interface{}{"type": "string", "description": "Kind/name filter, e.g. Pod/api-7d9f"},
+			}),
+			Resource: rbac.ResourceNamespace,
+			Action:   rbac.ActionRead,
+			Run: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+				events, err := s.GetEvents(ctx, toolArg(args, "cluster_id"), toolArg(args, "namespace"))
+				if err != nil {
+					return nil, err
+				}
+				return recentEvents(events, toolArg(args, "object")), nil
+			},
+		},
+		{
+			Name:        "describe_resource",
+			Description: "Get the spec and status of a resource and its recent events. Kinds: " + strings.Join(describableKinds, ", "),
+			Parameters: schema([]string{"kind", "name"}, map[string]interface{}{
+				"kind": map[string]interface{}{"type": "string", "enum": describableKinds},
+				"name": map[string]interface{}{"type": "string"},
+			}),
+			Resource: rbac.ResourceNamespace,
+			Action:   rbac.ActionRead,
+			Run: func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+				return s.DescribeResource(ctx, toolArg(args, "cluster_id"), toolArg(args, "namespace"),
+					toolArg(args, "kind"), toolArg(args, "name"))
+			},
+		},
+	}
+}
+
+// describableKinds are the kinds DescribeResource supports. Secrets are
+// deliberately excluded.
+var describableKinds = []string{
+	"Pod", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet",
+	"Job", "Service", "PersistentVolumeClaim", "Node",
+}
+
+// ResourceDescription is a resource's manifest with its recent events
+type ResourceDescription struct {
+	Kind     string      `json:"kind"`
+	Object   interface{} `json:"object"`
+	Events   []EventInfo `json:"events"`
+	Warnings int         `json:"warnings"`
+}
+
+// DescribeResource returns a resource's spec and status, without managed
+// fields, together with its recent events, like kubectl describe
+func (s *Service) DescribeResource(ctx context.Context, clusterID, namespace, kind, name string) (*ResourceDescription, error) {
+	cluster, err := s.Get(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.kubeManager.GetClient(cluster.Name)
+	if err != nil {
+		return nil, errors.ClusterWrap(err, "failed to get cluster client")
+	}
+
+	opts := metav1.GetOptions{}
+	var object metav1.Object
+	switch strings.ToLower(kind) {
+	case "pod":
+		object, err = client.Clientset.CoreV1().Pods(namespace).Get(ctx, name, opts)
+	case "deployment":
+		object, err = client.Clientset.AppsV1().Deployments(namespace).Get(ctx, name, opts)
+	case "statefulset":
+		object, err = client.Clientset.AppsV1().StatefulSets(namespace).Get(ctx, name, opts)
+	case "daemonset":
+		object, err = client.Clientset.AppsV1().DaemonSets(namespace).Get(ctx, name, opts)
+	case "replicaset":
+		object, err = client.Clientset.AppsV1().ReplicaSets(namespace).Get(ctx, name, opts)
+	case "job":
+		object, err = client.Clientset.BatchV1().Jobs(namespace).Get(ctx, name, opts)
+	case "service":
+		object, err = client.Clientset.CoreV1().Services(namespace).Get(ctx, name, opts)
+	case "persistentvolumeclaim":
+		object, err = client.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, opts)
+	case "node":
+		object, err = client.Clientset.CoreV1().Nodes().Get(ctx, name, opts)
+	default:
+		return nil, errors.BadRequest(fmt.Sprintf("unsupported kind: %s", kind))
+	}
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to get resource")
+	}
+	object.SetManagedFields(nil)
+
+	description := &ResourceDescription{Kind: kind, Object: object}
+
+	events, err := s.GetEvents(ctx, clusterID, namespace)
+	if err == nil {
+		for _, event := range recentEvents(events, "") {
+			if !strings.EqualFold(event.Object, kind+"/"+name) {
+				continue
+			}
+			description.Events = append(description.Events, event)
+			if event.Type == "Warning" {
+				description.Warnings++
+			}
+		}
+	}
+
+	return description, nil
+}
+
+// recentEvents returns up to maxToolEvents events, newest first, optionally
+// only those for object ("Kind/name")
+func recentEvents(events []EventInfo, object string) []EventInfo {
+	var filtered []EventInfo
+	for _, event := range events {
+		if object == "" || strings.EqualFold(event.Object, object) {
+			filtered = append(filtered, event)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].LastSeen.After(filtered[j].LastSeen) })
+	if len(filtered) > maxToolEvents {
+		filtered = filtered[:maxToolEvents]
+	}
+	return filtered
+}
+
+func toolArg(args map[string]interface{}, key string) string {
+	value, _ := args[key].(string)
+	return value
+}
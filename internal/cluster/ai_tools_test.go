@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/internal/ai"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newToolModel is an OpenAI-compatible endpoint that asks to describe pod
+// web in namespace shop, then answers with whatever the tool returned
+func newToolModel(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []map[string]interface{} `json:"messages"`
+		}
+		raw, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(raw, &body)
+
+		mu.Lock()
+		calls++
+		first := calls%2 == 1
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if first {
+			args, _ := json.Marshal(map[string]string{
+				"cluster_id": testClusterID, "namespace": "shop", "kind": "Pod", "name": "web",
+			})
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"message": map[string]interface{}{
+					"role": "assistant",
+					"tool_calls": []interface{}{map[string]interface{}{
+						"id": "call_1", "type": "function",
+						"function": map[string]interface{}{"name": "describe_resource", "arguments": string(args)},
+					}},
+				}}},
+			})
+			return
+		}
+		answer := body.Messages[len(body.Messages)-1]["content"]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"message": map[string]interface{}{"role": "assistant", "content": answer}}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAIToolsAuthorizedByRBAC(t *testing.T) {
+	ctx := context.Background()
+	s, cluster := newApplyService(t)
+	_, err := cluster.clientset.CoreV1().Pods("shop").Create(ctx,
+		scheduledPod("web", "node-a", corev1.PodRunning, resources("100m", "128Mi")), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// u1 may read namespace shop through their team; u2 holds nothing
+	rbacDB, err := gorm.Open(sqlite.Open("file:"+uuid.NewString()+"?mode=memory&cache=shared"),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	rbacSvc, err := rbac.NewService(rbacDB, zap.NewNop(), &rbac.Config{})
+	require.NoError(t, err)
+	t.Cleanup(rbacSvc.Stop)
+	role := &rbac.Role{Name: "shop-reader", Type: "custom", Permissions: []rbac.Permission{
+		{Resource: rbac.ResourceNamespace, Action: rbac.ActionRead, Scope: rbac.ResourceNamespace, Effect: "allow", Priority: 500},
+	}}
+	require.NoError(t, rbacSvc.CreateRole(ctx, role))
+	team := &rbac.Team{Name: "shop-oncall"}
+	require.NoError(t, rbacSvc.CreateTeam(ctx, team))
+	require.NoError(t, rbacSvc.AddTeamMember(ctx, team.ID, "u1", "member", "admin"))
+	require.NoError(t, rbacSvc.AssignRoleToTeam(ctx, team.ID, role.ID, rbac.NamespaceDomain(testClusterID, "shop"), "shop", "admin"))
+
+	aiDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	aiSvc, err := ai.NewService(aiDB, zap.NewNop(), &ai.Config{
+		Provider: ai.ProviderOpenAI, Endpoint: newToolModel(t).URL, APIKey: "test", Model: "test",
+	})
+	require.NoError(t, err)
+	aiSvc.RegisterTool(s.AITools()...)
+	aiSvc.SetToolAuthorizer(rbacSvc.AuthorizeUser)
+
+	query, err := aiSvc.AskQuestionWithTools(ctx, "u1", "why is web restarting?", nil)
+	require.NoError(t, err)
+	require.Len(t, query.ToolCalls, 1)
+	call := query.ToolCalls[0]
+	assert.Equal(t, "describe_resource", call.Name)
+	assert.False(t, call.Denied)
+	assert.Empty(t, call.Error)
+	assert.Contains(t, call.Result, `"name":"web"`)
+	assert.Contains(t, query.Response, `"name":"web"`, "the tool result reaches the model")
+
+	query, err = aiSvc.AskQuestionWithTools(ctx, "u2", "why is web restarting?", nil)
+	require.NoError(t, err)
+	require.Len(t, query.ToolCalls, 1)
+	assert.True(t, query.ToolCalls[0].Denied)
+	assert.Contains(t, query.Response, "permission denied")
+}
@@ -0,0 +1,452 @@
+package cluster
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// What ApplyManifest did to each object
+const (
+	ActionCreated    = "created"
+	ActionConfigured = "configured"
+	ActionUnchanged  = "unchanged"
+	ActionPruned     = "pruned"
+	ActionDeleted    = "deleted"
+	ActionSkipped    = "skipped"
+)
+
+// crdPollInterval is how often ApplyManifest checks whether a CRD applied
+// earlier in the manifest is served yet, for at most crdEstablishTimeout
+var (
+	crdPollInterval     = 500 * time.Millisecond
+	crdEstablishTimeout = 30 * time.Second
+)
+
+// ApplyOptions configures ApplyManifest
+type ApplyOptions struct {
+	// FieldManager owns the applied fields; defaults to "krustron"
+	FieldManager string `json:"field_manager,omitempty"`
+	// Force takes ownership of fields another manager owns instead of
+	// failing with a conflict
+	Force bool `json:"force"`
+	// DryRun applies on the server without persisting and returns the
+	// diff each object would get
+	DryRun bool `json:"dry_run"`
+	// Namespace is used for namespaced objects that don't set one;
+	// defaults to "default"
+	Namespace string `json:"namespace,omitempty"`
+	// CreateNamespaces creates the namespaces objects are applied to if
+	// they don't exist and the manifest doesn't define them
+	CreateNamespaces bool `json:"create_namespaces"`
+	// PruneSelector deletes objects matching this label selector that
+	// were applied to the cluster before but aren't in the manifest
+	PruneSelector string `json:"prune_selector,omitempty"`
+}
+
+// AppliedResource is an object applied to a cluster, and what was done to
+// it
+type AppliedResource struct {
+	Group        string     `json:"group"`
+	Version      string     `json:"version"`
+	Resource     string     `json:"resource"`
+	Kind         string     `json:"kind"`
+	Namespace    string     `json:"namespace,omitempty"`
+	Name         string     `json:"name"`
+	Action       string     `json:"action,omitempty"`
+	Message      string     `json:"message,omitempty"`
+	Diff         string     `json:"diff,omitempty"`
+	FieldManager string     `json:"field_manager,omitempty"`
+	AppliedAt    *time.Time `json:"applied_at,omitempty"`
+}
+
+func (r *AppliedResource) key() string {
+	return r.Group + "/" + r.Resource + "/" + r.Namespace + "/" + r.Name
+}
+
+func (r *AppliedResource) apiResource() kube.APIResource {
+	return kube.APIResource{
+		Group: r.Group, Version: r.Version, Resource: r.Resource, Kind: r.Kind,
+		Namespaced: r.Namespace != "",
+	}
+}
+
+// ApplyManifest server-side applies every object in a multi-document YAML
+// or JSON manifest, namespaces and CRDs first. Applied objects are
+// recorded so they can be pruned or deleted later. On a failure it returns
+// what was applied before it along with the error.
+func (s *Service) ApplyManifest(ctx context.Context, clusterID string, manifest []byte, opts ApplyOptions) ([]AppliedResource, error) {
+	objects, err := kube.DecodeManifest(manifest)
+	if err != nil {
+		return nil, errors.ValidationWrap(err, "invalid manifest")
+	}
+	if len(objects) == 0 {
+		return nil, errors.Validation("manifest has no objects")
+	}
+	var prune labels.Selector
+	if opts.PruneSelector != "" {
+		if prune, err = labels.Parse(opts.PruneSelector); err != nil {
+			return nil, errors.Validation(fmt.Sprintf("invalid prune selector: %v", err))
+		}
+	}
+
+	client, err := s.clientByID(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &applier{
+		s:          s,
+		clusterID:  clusterID,
+		client:     client,
+		opts:       opts,
+		kubeOpts:   kube.ApplyOptions{FieldManager: opts.FieldManager, Force: opts.Force, DryRun: opts.DryRun},
+		namespaces: make(map[string]bool),
+		crdKinds:   make(map[schema.GroupKind]bool),
+	}
+	if a.kubeOpts.FieldManager == "" {
+		a.kubeOpts.FieldManager = kube.DefaultFieldManager
+	}
+	if a.opts.Namespace == "" {
+		a.opts.Namespace = corev1.NamespaceDefault
+	}
+	return a.run(ctx, objects, prune)
+}
+
+// ListAppliedResources returns the objects recorded as applied to a
+// cluster, oldest first
+func (s *Service) ListAppliedResources(ctx context.Context, clusterID string) ([]AppliedResource, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT api_group, api_version, resource, kind, namespace, name, field_manager, applied_at
+		FROM applied_resources WHERE cluster_id = $1
+		ORDER BY applied_at, api_group, resource, namespace, name
+	`, clusterID)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to query applied resources")
+	}
+	defer rows.Close()
+
+	resources := []AppliedResource{}
+	for rows.Next() {
+		var r AppliedResource
+		var appliedAt time.Time
+		if err := rows.Scan(&r.Group, &r.Version, &r.Resource, &r.Kind, &r.Namespace, &r.Name,
+			&r.FieldManager, &appliedAt); err != nil {
+			return nil, errors.DatabaseWrap(err, "failed to scan applied resource")
+		}
+		r.AppliedAt = &appliedAt
+		resources = append(resources, r)
+	}
+	return resources, rows.Err()
+}
+
+// DeleteAppliedResources deletes every object recorded as applied to a
+// cluster, newest first, and forgets them. Objects already gone are
+// forgotten too.
+func (s *Service) DeleteAppliedResources(ctx context.Context, clusterID string) ([]AppliedResource, error) {
+	client, err := s.clientByID(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.ListAppliedResources(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []AppliedResource{}
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		err := client.DeleteObject(ctx, r.apiResource(), r.Namespace, r.Name, false)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return deleted, errors.KubernetesWrap(err, fmt.Sprintf("failed to delete %s %s", r.Kind, r.Name))
+		}
+		if err := s.forgetApplied(ctx, clusterID, &r); err != nil {
+			return deleted, err
+		}
+		r.Action = ActionDeleted
+		deleted = append(deleted, r)
+	}
+	return deleted, nil
+}
+
+func (s *Service) recordApplied(ctx context.Context, clusterID string, r *AppliedResource) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO applied_resources
+			(cluster_id, api_group, api_version, resource, kind, namespace, name, field_manager, applied_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (cluster_id, api_group, resource, namespace, name) DO UPDATE SET
+			api_version = EXCLUDED.api_version,
+			kind = EXCLUDED.kind,
+			field_manager = EXCLUDED.field_manager,
+			applied_at = EXCLUDED.applied_at
+	`, clusterID, r.Group, r.Version, r.Resource, r.Kind, r.Namespace, r.Name, r.FieldManager, time.Now())
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to record applied resource")
+	}
+	return nil
+}
+
+func (s *Service) forgetApplied(ctx context.Context, clusterID string, r *AppliedResource) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM applied_resources
+		WHERE cluster_id = $1 AND api_group = $2 AND resource = $3 AND namespace = $4 AND name = $5
+	`, clusterID, r.Group, r.Resource, r.Namespace, r.Name)
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to forget applied resource")
+	}
+	return nil
+}
+
+// applier applies one manifest
+type applier struct {
+	s         *Service
+	clusterID string
+	client    *kube.ClusterClient
+	opts      ApplyOptions
+	kubeOpts  kube.ApplyOptions
+	// namespaces are known to exist or to be created by the manifest
+	namespaces map[string]bool
+	// crdKinds are defined by CRDs in the manifest
+	crdKinds map[schema.GroupKind]bool
+	results  []AppliedResource
+}
+
+func (a *applier) run(ctx context.Context, objects []*unstructured.Unstructured, prune labels.Selector) ([]AppliedResource, error) {
+	kube.SortForApply(objects)
+	for _, obj := range objects {
+		switch {
+		case obj.GetKind() == "Namespace" && obj.GroupVersionKind().Group == "":
+			a.namespaces[obj.GetName()] = true
+		case obj.GetKind() == "CustomResourceDefinition":
+			group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+			kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+			a.crdKinds[schema.GroupKind{Group: group, Kind: kind}] = true
+		}
+	}
+
+	applied := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		r, err := a.apply(ctx, obj)
+		if err != nil {
+			return a.results, err
+		}
+		a.results = append(a.results, *r)
+		applied[r.key()] = true
+	}
+
+	if prune != nil {
+		if err := a.prune(ctx, prune, applied); err != nil {
+			return a.results, err
+		}
+	}
+	return a.results, nil
+}
+
+// apply applies one object and records it
+func (a *applier) apply(ctx context.Context, obj *unstructured.Unstructured) (*AppliedResource, error) {
+	gvk := obj.GroupVersionKind()
+	r := &AppliedResource{
+		Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind,
+		Name: obj.GetName(), FieldManager: a.kubeOpts.FieldManager,
+	}
+
+	res, err := a.resourceFor(ctx, gvk)
+	if err != nil {
+		if a.opts.DryRun && a.crdKinds[gvk.GroupKind()] && goerrors.Is(err, kube.ErrUnknownResource) {
+			r.Namespace = obj.GetNamespace()
+			r.Action = ActionSkipped
+			r.Message = "its CustomResourceDefinition is only created by this manifest, so it can't be dry-run"
+			return r, nil
+		}
+		return nil, applyError(err, obj)
+	}
+	r.Resource = res.Resource
+
+	if res.Namespaced {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(a.opts.Namespace)
+		}
+		if err := a.ensureNamespace(ctx, obj.GetNamespace()); err != nil {
+			return nil, err
+		}
+	} else {
+		obj.SetNamespace("")
+	}
+	r.Namespace = obj.GetNamespace()
+
+	live, err := a.client.GetObject(ctx, res, r.Namespace, r.Name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, applyError(err, obj)
+		}
+		live = nil
+	}
+	result, err := a.client.ApplyObject(ctx, res, obj, a.kubeOpts)
+	if err != nil {
+		return nil, applyError(err, obj)
+	}
+
+	diff, err := kube.DiffObjects(objectName(r), live, result)
+	if err != nil {
+		return nil, errors.InternalWrap(err, "failed to diff applied object")
+	}
+	switch {
+	case live == nil:
+		r.Action = ActionCreated
+	case diff == "":
+		r.Action = ActionUnchanged
+	default:
+		r.Action = ActionConfigured
+	}
+	if a.opts.DryRun {
+		r.Diff = diff
+		return r, nil
+	}
+	if err := a.s.recordApplied(ctx, a.clusterID, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// resourceFor finds the resource serving gvk. A kind defined by a CRD
+// earlier in the manifest may take a moment to be served, so it's polled
+// for.
+func (a *applier) resourceFor(ctx context.Context, gvk schema.GroupVersionKind) (kube.APIResource, error) {
+	res, err := a.client.ResourceFor(gvk)
+	if err == nil || a.opts.DryRun || !a.crdKinds[gvk.GroupKind()] || !goerrors.Is(err, kube.ErrUnknownResource) {
+		return res, err
+	}
+
+	timeout := time.NewTimer(crdEstablishTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(crdPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-timeout.C:
+			return res, err
+		case <-ticker.C:
+			if res, err = a.client.ResourceFor(gvk); !goerrors.Is(err, kube.ErrUnknownResource) {
+				return res, err
+			}
+		}
+	}
+}
+
+// ensureNamespace creates namespace if it's missing and namespace creation
+// is on; otherwise a missing namespace fails the apply on the server
+func (a *applier) ensureNamespace(ctx context.Context, namespace string) error {
+	if !a.opts.CreateNamespaces || a.namespaces[namespace] {
+		return nil
+	}
+	namespaces := a.client.Clientset.CoreV1().Namespaces()
+	if _, err := namespaces.Get(ctx, namespace, metav1.GetOptions{}); err == nil {
+		a.namespaces[namespace] = true
+		return nil
+	} else if !apierrors.IsNotFound(err) {
+		return errors.KubernetesWrap(err, fmt.Sprintf("failed to get namespace %s", namespace))
+	}
+
+	opts := metav1.CreateOptions{FieldManager: a.kubeOpts.FieldManager}
+	if a.opts.DryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+	if _, err := namespaces.Create(ctx, ns, opts); err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.KubernetesWrap(err, fmt.Sprintf("failed to create namespace %s", namespace))
+	}
+	a.namespaces[namespace] = true
+	// Not recorded: deleting the applied objects later mustn't delete a
+	// namespace that may hold other workloads
+	a.results = append(a.results, AppliedResource{
+		Version: "v1", Resource: "namespaces", Kind: "Namespace", Name: namespace,
+		Action: ActionCreated, Message: "created because create_namespaces is set",
+	})
+	return nil
+}
+
+// prune deletes recorded objects matching selector that weren't applied
+// this time
+func (a *applier) prune(ctx context.Context, selector labels.Selector, applied map[string]bool) error {
+	records, err := a.s.ListAppliedResources(ctx, a.clusterID)
+	if err != nil {
+		return err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if applied[r.key()] {
+			continue
+		}
+		res := r.apiResource()
+		live, err := a.client.GetObject(ctx, res, r.Namespace, r.Name)
+		if apierrors.IsNotFound(err) {
+			if !a.opts.DryRun {
+				if err := a.s.forgetApplied(ctx, a.clusterID, &r); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return errors.KubernetesWrap(err, fmt.Sprintf("failed to get %s %s", r.Kind, r.Name))
+		}
+		if !selector.Matches(labels.Set(live.GetLabels())) {
+			continue
+		}
+
+		if err := a.client.DeleteObject(ctx, res, r.Namespace, r.Name, a.opts.DryRun); err != nil && !apierrors.IsNotFound(err) {
+			return errors.KubernetesWrap(err, fmt.Sprintf("failed to prune %s %s", r.Kind, r.Name))
+		}
+		r.Action = ActionPruned
+		r.AppliedAt = nil
+		if a.opts.DryRun {
+			if r.Diff, err = kube.DiffObjects(objectName(&r), live, nil); err != nil {
+				return errors.InternalWrap(err, "failed to diff pruned object")
+			}
+		} else if err := a.s.forgetApplied(ctx, a.clusterID, &r); err != nil {
+			return err
+		}
+		a.results = append(a.results, r)
+	}
+	return nil
+}
+
+// objectName is how an object is named in diffs, e.g.
+// apps/deployments/shop/web
+func objectName(r *AppliedResource) string {
+	name := r.Resource + "/" + r.Name
+	if r.Namespace != "" {
+		name = r.Resource + "/" + r.Namespace + "/" + r.Name
+	}
+	if r.Group != "" {
+		name = r.Group + "/" + name
+	}
+	return name
+}
+
+// applyError maps an error applying obj: bad manifests are invalid
+// requests and field ownership conflicts are conflicts
+func applyError(err error, obj *unstructured.Unstructured) error {
+	what := fmt.Sprintf("%s %s", obj.GetKind(), obj.GetName())
+	switch {
+	case goerrors.Is(err, kube.ErrUnknownResource), goerrors.Is(err, kube.ErrResourceScope):
+		return errors.ValidationWrap(err, what)
+	case apierrors.IsConflict(err):
+		return errors.Conflict(fmt.Sprintf("%s: %v; set force to take ownership of the conflicting fields", what, err))
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err), apierrors.IsNotFound(err):
+		return errors.ValidationWrap(err, what)
+	}
+	return errors.KubernetesWrap(err, fmt.Sprintf("failed to apply %s", what))
+}
@@ -0,0 +1,397 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testClusterID = "7f1c2a4e-0000-4000-8000-000000000001"
+
+var (
+	configMaps  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	deployments = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	widgets     = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+)
+
+// applyCluster is a fake cluster whose dynamic client implements
+// server-side apply: create or replace, honouring dry run and failing with
+// a conflict when another manager owns the object and force isn't set
+type applyCluster struct {
+	clientset *fake.Clientset
+	dynamic   *dynamicfake.FakeDynamicClient
+	// applyOpts are the options of the apply in flight; the fake drops
+	// them from the action its reactors see
+	applyOpts metav1.ApplyOptions
+}
+
+// optionsDynamic passes apply options on to the reactor and skips dry-run
+// deletes, neither of which the fake does
+type optionsDynamic struct {
+	*dynamicfake.FakeDynamicClient
+	cluster *applyCluster
+}
+
+func (d optionsDynamic) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return optionsResource{d.FakeDynamicClient.Resource(gvr), d.cluster}
+}
+
+type optionsResource struct {
+	dynamic.NamespaceableResourceInterface
+	cluster *applyCluster
+}
+
+func (r optionsResource) Namespace(ns string) dynamic.ResourceInterface {
+	return optionsNamespaced{r.NamespaceableResourceInterface.Namespace(ns), r.cluster}
+}
+
+func (r optionsResource) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, opts metav1.ApplyOptions, sub ...string) (*unstructured.Unstructured, error) {
+	r.cluster.applyOpts = opts
+	return r.NamespaceableResourceInterface.Apply(ctx, name, obj, opts, sub...)
+}
+
+func (r optionsResource) Delete(ctx context.Context, name string, opts metav1.DeleteOptions, sub ...string) error {
+	if len(opts.DryRun) > 0 {
+		return nil
+	}
+	return r.NamespaceableResourceInterface.Delete(ctx, name, opts, sub...)
+}
+
+type optionsNamespaced struct {
+	dynamic.ResourceInterface
+	cluster *applyCluster
+}
+
+func (r optionsNamespaced) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, opts metav1.ApplyOptions, sub ...string) (*unstructured.Unstructured, error) {
+	r.cluster.applyOpts = opts
+	return r.ResourceInterface.Apply(ctx, name, obj, opts, sub...)
+}
+
+func (r optionsNamespaced) Delete(ctx context.Context, name string, opts metav1.DeleteOptions, sub ...string) error {
+	if len(opts.DryRun) > 0 {
+		return nil
+	}
+	return r.ResourceInterface.Delete(ctx, name, opts, sub...)
+}
+
+func newApplyCluster() *applyCluster {
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	discovery := clientset.Discovery().(*fakediscovery.FakeDiscovery)
+	discovery.Resources = []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+			{Name: "namespaces", Kind: "Namespace"},
+		}},
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+			{Name: "deployments", Kind: "Deployment", Namespaced: true},
+		}},
+		{GroupVersion: "apiextensions.k8s.io/v1", APIResources: []metav1.APIResource{
+			{Name: "customresourcedefinitions", Kind: "CustomResourceDefinition"},
+		}},
+	}
+
+	c := &applyCluster{clientset: clientset, dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())}
+	c.dynamic.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchActionImpl)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		gvr, ns := patch.GetResource(), patch.GetNamespace()
+		dryRun, force := len(c.applyOpts.DryRun) > 0, c.applyOpts.Force
+
+		existing, err := c.dynamic.Tracker().Get(gvr, ns, patch.GetName())
+		switch {
+		case apierrors.IsNotFound(err):
+			err = nil
+			if !dryRun {
+				err = c.dynamic.Tracker().Create(gvr, obj, ns)
+			}
+		case err == nil:
+			if existing.(*unstructured.Unstructured).GetAnnotations()["owner"] == "kubectl" && !force {
+				return true, nil, apierrors.NewConflict(gvr.GroupResource(), patch.GetName(),
+					errorString("conflict with \"kubectl\": .data.color"))
+			}
+			if !dryRun {
+				err = c.dynamic.Tracker().Update(gvr, obj, ns)
+			}
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		// The API server starts serving a CRD's kind once it's created
+		if gvr.Resource == "customresourcedefinitions" && !dryRun {
+			discovery.Resources = append(discovery.Resources, &metav1.APIResourceList{
+				GroupVersion: "example.com/v1",
+				APIResources: []metav1.APIResource{{Name: "widgets", Kind: "Widget", Namespaced: true}},
+			})
+		}
+		return true, obj, nil
+	})
+	return c
+}
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
+
+func (c *applyCluster) get(t *testing.T, gvr schema.GroupVersionResource, ns, name string) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := c.dynamic.Tracker().Get(gvr, ns, name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	return obj.(*unstructured.Unstructured)
+}
+
+// newApplyService backs the service with in-memory SQLite holding one
+// cluster, prod, served by the fake cluster
+func newApplyService(t *testing.T) (*Service, *applyCluster) {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	seedClusters(t, sqlDB)
+
+	cluster := newApplyCluster()
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	manager.AddClient(&kube.ClusterClient{Name: "prod", Clientset: cluster.clientset, DynamicClient: optionsDynamic{cluster.dynamic, cluster}})
+	return NewService(&database.PostgresDB{DB: sqlDB}, manager, nil), cluster
+}
+
+// seedClusters creates the cluster tables in db holding one cluster, prod
+func seedClusters(t testing.TB, db *sql.DB) {
+	t.Helper()
+	_, err := db.Exec(`CREATE TABLE clusters (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), name TEXT UNIQUE, display_name TEXT, description TEXT,
+		api_server TEXT, kubeconfig TEXT, auth_type TEXT, status TEXT, version TEXT,
+		nodes_count INTEGER, cpu_capacity TEXT, memory_capacity TEXT, provider TEXT,
+		region TEXT, environment TEXT, labels TEXT, annotations TEXT, cloud_auth TEXT,
+		agent_installed BOOLEAN, agent_version TEXT, last_health_check TIMESTAMP,
+		created_by TEXT, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, kubeconfig_ref TEXT)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE applied_resources (
+		cluster_id TEXT, api_group TEXT NOT NULL DEFAULT '', api_version TEXT NOT NULL,
+		resource TEXT NOT NULL, kind TEXT NOT NULL, namespace TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL, field_manager TEXT NOT NULL, applied_at TIMESTAMP,
+		PRIMARY KEY (cluster_id, api_group, resource, namespace, name))`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO clusters VALUES ($1, 'prod', '', '', 'https://prod', '', 'kubeconfig',
+		'connected', 'v1.30.0', 3, '', '', '', '', 'production', '{}', '{}', NULL, false, '', NULL, '',
+		$2, $2, NULL)`, testClusterID, time.Now())
+	require.NoError(t, err)
+}
+
+func actions(results []AppliedResource) []string {
+	var out []string
+	for _, r := range results {
+		out = append(out, r.Kind+"/"+r.Name+":"+r.Action)
+	}
+	return out
+}
+
+const shopManifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+  labels: {app: shop}
+spec:
+  replicas: 2
+---
+# settings land in the default namespace
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  labels: {app: shop}
+data:
+  color: blue
+---
+`
+
+func TestApplyManifest(t *testing.T) {
+	svc, cluster := newApplyService(t)
+	ctx := context.Background()
+
+	results, err := svc.ApplyManifest(ctx, testClusterID, []byte(shopManifest), ApplyOptions{CreateNamespaces: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ConfigMap/settings:created", "Namespace/shop:created", "Deployment/web:created",
+	}, actions(results), "config before workloads, with the missing namespace created first")
+	assert.Empty(t, results[0].Diff, "diffs are only returned on dry runs")
+
+	require.NotNil(t, cluster.get(t, deployments, "shop", "web"))
+	settings := cluster.get(t, configMaps, "default", "settings")
+	require.NotNil(t, settings)
+	_, err = cluster.clientset.CoreV1().Namespaces().Get(ctx, "shop", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	recorded, err := svc.ListAppliedResources(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ConfigMap/settings:", "Deployment/web:"}, actions(recorded),
+		"the created namespace isn't recorded")
+	for _, r := range recorded {
+		assert.Equal(t, kube.DefaultFieldManager, r.FieldManager)
+	}
+
+	changed := []byte(`{"apiVersion": "v1", "kind": "ConfigMap",
+		"metadata": {"name": "settings", "namespace": "default", "labels": {"app": "shop"}},
+		"data": {"color": "green"}}`)
+	results, err = svc.ApplyManifest(ctx, testClusterID, append([]byte(shopManifest+"\n---\n"), changed...), ApplyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ConfigMap/settings:unchanged", "ConfigMap/settings:configured", "Deployment/web:unchanged",
+	}, actions(results))
+	assert.Equal(t, "green", cluster.get(t, configMaps, "default", "settings").Object["data"].(map[string]interface{})["color"])
+}
+
+func TestApplyManifestDryRun(t *testing.T) {
+	svc, cluster := newApplyService(t)
+	ctx := context.Background()
+
+	results, err := svc.ApplyManifest(ctx, testClusterID, []byte(shopManifest), ApplyOptions{DryRun: true, CreateNamespaces: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ConfigMap/settings:created", "Namespace/shop:created", "Deployment/web:created",
+	}, actions(results))
+	assert.Contains(t, results[0].Diff, "+++ applied/configmaps/default/settings")
+	assert.Contains(t, results[0].Diff, "+  color: blue")
+
+	assert.Nil(t, cluster.get(t, configMaps, "default", "settings"), "a dry run persists nothing")
+	recorded, err := svc.ListAppliedResources(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.Empty(t, recorded)
+}
+
+func TestApplyManifestCRDBeforeCR(t *testing.T) {
+	svc, cluster := newApplyService(t)
+	ctx := context.Background()
+	manifest := []byte(`
+apiVersion: example.com/v1
+kind: Widget
+metadata: {name: sprocket, namespace: default}
+spec: {size: 3}
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata: {name: widgets.example.com}
+spec:
+  group: example.com
+  names: {kind: Widget, plural: widgets}
+  scope: Namespaced
+`)
+
+	results, err := svc.ApplyManifest(ctx, testClusterID, manifest, ApplyOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CustomResourceDefinition/widgets.example.com:created", "Widget/sprocket:skipped",
+	}, actions(results), "a CR of a CRD that doesn't exist yet can't be dry-run")
+
+	results, err = svc.ApplyManifest(ctx, testClusterID, manifest, ApplyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CustomResourceDefinition/widgets.example.com:created", "Widget/sprocket:created",
+	}, actions(results))
+	assert.NotNil(t, cluster.get(t, widgets, "default", "sprocket"))
+}
+
+func TestApplyManifestPrune(t *testing.T) {
+	svc, cluster := newApplyService(t)
+	ctx := context.Background()
+
+	_, err := svc.ApplyManifest(ctx, testClusterID, []byte(shopManifest), ApplyOptions{CreateNamespaces: true})
+	require.NoError(t, err)
+	unlabelled := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "other"}}`
+	_, err = svc.ApplyManifest(ctx, testClusterID, []byte(unlabelled), ApplyOptions{})
+	require.NoError(t, err)
+
+	deploymentOnly := []byte(`{"apiVersion": "apps/v1", "kind": "Deployment",
+		"metadata": {"name": "web", "namespace": "shop", "labels": {"app": "shop"}}, "spec": {"replicas": 2}}`)
+	results, err := svc.ApplyManifest(ctx, testClusterID, deploymentOnly, ApplyOptions{PruneSelector: "app=shop", DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Deployment/web:unchanged", "ConfigMap/settings:pruned"}, actions(results))
+	assert.Contains(t, results[1].Diff, "-  color: blue")
+	assert.NotNil(t, cluster.get(t, configMaps, "default", "settings"))
+
+	results, err = svc.ApplyManifest(ctx, testClusterID, deploymentOnly, ApplyOptions{PruneSelector: "app=shop"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Deployment/web:unchanged", "ConfigMap/settings:pruned"}, actions(results),
+		"objects without the label are kept")
+	assert.Nil(t, cluster.get(t, configMaps, "default", "settings"))
+	assert.NotNil(t, cluster.get(t, configMaps, "default", "other"))
+
+	recorded, err := svc.ListAppliedResources(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Deployment/web:", "ConfigMap/other:"}, actions(recorded))
+
+	deleted, err := svc.DeleteAppliedResources(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	assert.Nil(t, cluster.get(t, deployments, "shop", "web"))
+	assert.Nil(t, cluster.get(t, configMaps, "default", "other"))
+	recorded, err = svc.ListAppliedResources(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.Empty(t, recorded)
+}
+
+func TestApplyManifestErrors(t *testing.T) {
+	svc, cluster := newApplyService(t)
+	ctx := context.Background()
+
+	owned := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1", "kind": "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name": "settings", "namespace": "default",
+			"annotations": map[string]interface{}{"owner": "kubectl"},
+		},
+	}}
+	require.NoError(t, cluster.dynamic.Tracker().Create(configMaps, owned, "default"))
+
+	_, err := svc.ApplyManifest(ctx, testClusterID, []byte(shopManifest), ApplyOptions{})
+	assert.Equal(t, errors.CodeConflict, errors.Code(err))
+	assert.Contains(t, err.Error(), "set force")
+
+	_, err = svc.ApplyManifest(ctx, testClusterID, []byte(shopManifest), ApplyOptions{Force: true, CreateNamespaces: true})
+	assert.NoError(t, err)
+
+	_, err = svc.ApplyManifest(ctx, testClusterID, []byte("kind: ConfigMap\nmetadata: {name: x}\n"), ApplyOptions{})
+	assert.ErrorContains(t, err, "apiVersion is required")
+	_, err = svc.ApplyManifest(ctx, testClusterID, []byte("---\n---\n"), ApplyOptions{})
+	assert.ErrorContains(t, err, "manifest has no objects")
+	_, err = svc.ApplyManifest(ctx, testClusterID, []byte(`{"apiVersion": "v9", "kind": "Gizmo", "metadata": {"name": "g"}}`), ApplyOptions{})
+	assert.Equal(t, errors.CodeValidation, errors.Code(err))
+}
@@ -0,0 +1,211 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBulkConcurrency = 10
+	// maxBulkClusters caps how many clusters one bulk request may name
+	maxBulkClusters = 500
+)
+
+// Outcomes of a bulk operation on one cluster
+const (
+	BulkOK        = "ok"
+	BulkUnchanged = "unchanged"
+	BulkFailed    = "failed"
+)
+
+// BulkResult is the outcome of a bulk operation on one cluster
+type BulkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Health is the cluster's health, for BulkHealthCheck
+	Health *HealthStatus `json:"health,omitempty"`
+}
+
+// SetBulkConcurrency caps how many clusters a bulk operation works on at
+// once; values below one restore the default of 10
+func (s *Service) SetBulkConcurrency(n int) { s.bulkConcurrency = n }
+
+// BulkInstallAgent installs the agent on each cluster. Clusters that
+// already run it are left alone and reported unchanged.
+func (s *Service) BulkInstallAgent(ctx context.Context, clusterIDs []string) (map[string]BulkResult, error) {
+	return s.bulk(ctx, "install_agent", clusterIDs, func(ctx context.Context, id string) (BulkResult, error) {
+		installed, err := s.installAgent(ctx, id)
+		if err != nil {
+			return BulkResult{}, err
+		}
+		if !installed {
+			return BulkResult{Status: BulkUnchanged}, nil
+		}
+		return BulkResult{Status: BulkOK}, nil
+	})
+}
+
+// BulkHealthCheck checks the health of each cluster now, bypassing the
+// health cache, and records the result as the health monitor does
+func (s *Service) BulkHealthCheck(ctx context.Context, clusterIDs []string) (map[string]BulkResult, error) {
+	return s.bulk(ctx, "health_check", clusterIDs, func(ctx context.Context, id string) (BulkResult, error) {
+		health, err := s.checkHealth(ctx, id)
+		if err != nil {
+			return BulkResult{}, err
+		}
+		return BulkResult{Status: BulkOK, Health: health}, nil
+	})
+}
+
+// BulkUpdateLabels merges labels into the labels of each cluster; a label
+// with an empty value is removed. Clusters whose labels already match are
+// reported unchanged.
+func (s *Service) BulkUpdateLabels(ctx context.Context, clusterIDs []string, labels map[string]string) (map[string]BulkResult, error) {
+	if len(labels) == 0 {
+		return nil, errors.Validation("no labels to update")
+	}
+	return s.bulk(ctx, "update_labels", clusterIDs, func(ctx context.Context, id string) (BulkResult, error) {
+		changed, err := s.mergeLabels(ctx, id, labels)
+		if err != nil {
+			return BulkResult{}, err
+		}
+		if !changed {
+			return BulkResult{Status: BulkUnchanged}, nil
+		}
+		return BulkResult{Status: BulkOK}, nil
+	})
+}
+
+// mergeLabels applies labels to the cluster id and reports whether its
+// labels changed
+func (s *Service) mergeLabels(ctx context.Context, id string, labels map[string]string) (bool, error) {
+	cluster, err := s.getFromDB(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	merged := maps.Clone(cluster.Labels)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	for k, v := range labels {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	if maps.Equal(merged, cluster.Labels) {
+		return false, nil
+	}
+
+	data, _ := json.Marshal(merged)
+	query := "UPDATE clusters SET labels = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1"
+	if _, err := s.db.ExecContext(ctx, query, id, data); err != nil {
+		return false, errors.DatabaseWrap(err, "failed to update cluster labels")
+	}
+	s.invalidate(ctx, id)
+	return true, nil
+}
+
+// bulk runs fn on each distinct cluster in clusterIDs with at most the
+// bulk concurrency in flight and collects the outcome per cluster. A
+// failure on one cluster doesn't stop the others; clusters not reached
+// before ctx ends are reported failed with its error.
+func (s *Service) bulk(ctx context.Context, op string, clusterIDs []string, fn func(ctx context.Context, id string) (BulkResult, error)) (map[string]BulkResult, error) {
+	ids := make([]string, 0, len(clusterIDs))
+	seen := make(map[string]bool, len(clusterIDs))
+	for _, id := range clusterIDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.Validation("no clusters given")
+	}
+	if len(ids) > maxBulkClusters {
+		return nil, errors.Validation(fmt.Sprintf("at most %d clusters may be given at once", maxBulkClusters))
+	}
+
+	concurrency := s.bulkConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBulkConcurrency
+	}
+
+	results := make(map[string]BulkResult, len(ids))
+	var mu sync.Mutex
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < min(concurrency, len(ids)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				result, err := fn(ctx, id)
+				if err != nil {
+					result = BulkResult{Status: BulkFailed, Error: err.Error()}
+				}
+				mu.Lock()
+				results[id] = result
+				mu.Unlock()
+				s.emitBulkResult(op, id, result)
+			}
+		}()
+	}
+
+feed:
+	for _, id := range ids {
+		select {
+		case jobs <- id:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	for _, id := range ids {
+		result, ok := results[id]
+		if !ok {
+			result = BulkResult{Status: BulkFailed, Error: ctx.Err().Error()}
+			results[id] = result
+		}
+		if result.Status == BulkFailed {
+			failed++
+		}
+	}
+	logger.Info("Bulk cluster operation finished",
+		zap.String("operation", op),
+		zap.Int("clusters", len(ids)),
+		zap.Int("failed", failed),
+	)
+	return results, nil
+}
+
+// emitBulkResult tells dashboard clients watching the cluster how a bulk
+// operation went for it
+func (s *Service) emitBulkResult(op, id string, result BulkResult) {
+	if s.emitter == nil {
+		return
+	}
+	event := map[string]interface{}{
+		"operation": op,
+		"result":    result.Status,
+	}
+	if result.Error != "" {
+		event["error"] = result.Error
+	}
+	if result.Health != nil {
+		event["health"] = result.Health
+	}
+	s.emitter.EmitClusterStatus(id, event)
+}
@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBulkInstallAgentReportsPartialFailure(t *testing.T) {
+	s, cluster := newApplyService(t)
+	ctx := context.Background()
+
+	results, err := s.BulkInstallAgent(ctx, []string{testClusterID, "missing", testClusterID})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, BulkResult{Status: BulkOK}, results[testClusterID])
+	assert.Equal(t, BulkFailed, results["missing"].Status)
+	assert.Contains(t, results["missing"].Error, "not found")
+
+	_, err = cluster.clientset.AppsV1().Deployments("krustron-system").Get(ctx, "krustron-agent", metav1.GetOptions{})
+	require.NoError(t, err)
+	c, err := s.Get(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.True(t, c.AgentInstalled)
+
+	// Installing again where the agent runs is a no-op
+	results, err = s.BulkInstallAgent(ctx, []string{testClusterID})
+	require.NoError(t, err)
+	assert.Equal(t, BulkResult{Status: BulkUnchanged}, results[testClusterID])
+	require.NoError(t, s.InstallAgent(ctx, testClusterID))
+}
+
+func TestBulkUpdateLabels(t *testing.T) {
+	s, _ := newApplyService(t)
+	ctx := context.Background()
+
+	results, err := s.BulkUpdateLabels(ctx, []string{testClusterID, "missing"}, map[string]string{"team": "payments", "tier": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, BulkOK, results[testClusterID].Status)
+	assert.Equal(t, BulkFailed, results["missing"].Status)
+	c, err := s.Get(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "1"}, c.Labels)
+
+	results, err = s.BulkUpdateLabels(ctx, []string{testClusterID}, map[string]string{"team": "payments"})
+	require.NoError(t, err)
+	assert.Equal(t, BulkUnchanged, results[testClusterID].Status)
+
+	// An empty value removes the label
+	results, err = s.BulkUpdateLabels(ctx, []string{testClusterID}, map[string]string{"tier": ""})
+	require.NoError(t, err)
+	assert.Equal(t, BulkOK, results[testClusterID].Status)
+	c, err = s.Get(ctx, testClusterID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments"}, c.Labels)
+
+	_, err = s.BulkUpdateLabels(ctx, []string{testClusterID}, nil)
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
+
+func TestBulkHonoursConcurrencyLimit(t *testing.T) {
+	s := &Service{}
+	s.SetBulkConcurrency(3)
+
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("cluster-%d", i)
+	}
+	var inFlight, peak atomic.Int32
+	results, err := s.bulk(context.Background(), "test", ids, func(ctx context.Context, id string) (BulkResult, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if id == "cluster-7" {
+			return BulkResult{}, fmt.Errorf("unreachable")
+		}
+		return BulkResult{Status: BulkOK}, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(3), peak.Load())
+	require.Len(t, results, len(ids))
+	assert.Equal(t, BulkResult{Status: BulkFailed, Error: "unreachable"}, results["cluster-7"])
+	assert.Equal(t, BulkOK, results["cluster-8"].Status)
+}
+
+func TestBulkReportsClustersNotReached(t *testing.T) {
+	s := &Service{}
+	s.SetBulkConcurrency(1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	results, err := s.bulk(ctx, "test", []string{"a", "b", "c"}, func(ctx context.Context, id string) (BulkResult, error) {
+		if err := ctx.Err(); err != nil {
+			return BulkResult{}, err
+		}
+		cancel()
+		return BulkResult{Status: BulkOK}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, BulkOK, results["a"].Status)
+	for _, id := range []string{"b", "c"} {
+		assert.Equal(t, BulkResult{Status: BulkFailed, Error: context.Canceled.Error()}, results[id])
+	}
+}
+
+func TestBulkValidatesClusters(t *testing.T) {
+	s := &Service{}
+	noop := func(ctx context.Context, id string) (BulkResult, error) { return BulkResult{Status: BulkOK}, nil }
+
+	_, err := s.bulk(context.Background(), "test", []string{"", ""}, noop)
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+
+	ids := make([]string, maxBulkClusters+1)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i)
+	}
+	_, err = s.bulk(context.Background(), "test", ids, noop)
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
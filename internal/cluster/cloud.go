@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+)
+
+// SecretStore is the secret backend cloud credentials are read from and
+// kubeconfigs are kept in. Only a reference to the secret is stored with
+// the cluster.
+type SecretStore interface {
+	// Get returns the key/value pairs of the secret at ref
+	Get(ctx context.Context, ref string) (map[string][]byte, error)
+	// Set creates or replaces the secret at ref
+	Set(ctx context.Context, ref string, data map[string][]byte) error
+	// Delete removes the secret at ref
+	Delete(ctx context.Context, ref string) error
+}
+
+// SetSecretStore sets where credentials for eks, gke and aks clusters are
+// read from and where kubeconfigs of new clusters are kept. Without one
+// cloud clusters can't be connected and kubeconfigs are stored inline.
+func (s *Service) SetSecretStore(store SecretStore) { s.secrets = store }
+
+// isCloudAuth reports whether authType authenticates through a cloud
+// provider rather than a kubeconfig
+func isCloudAuth(authType string) bool {
+	switch authType {
+	case kube.AuthTypeEKS, kube.AuthTypeGKE, kube.AuthTypeAKS:
+		return true
+	}
+	return false
+}
+
+// connectCloud registers a client for a cloud-authenticated cluster, with
+// tokens minted from the credentials in the secret store
+func (s *Service) connectCloud(ctx context.Context, name, apiServer string, auth *kube.CloudAuth) (*kube.ClusterClient, error) {
+	if s.secrets == nil {
+		return nil, fmt.Errorf("no secret store configured for %s credentials", auth.Provider)
+	}
+	if apiServer == "" {
+		return nil, fmt.Errorf("api_server is required for %s clusters", auth.Provider)
+	}
+	creds, err := s.secrets.Get(ctx, auth.CredentialsRef)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := kube.NewCloudTokenSource(auth, name, creds)
+	if err != nil {
+		return nil, err
+	}
+	return s.kubeManager.AddClusterWithTokenSource(name, apiServer, auth.CACert, ts)
+}
@@ -0,0 +1,411 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CompareOptions tunes a namespace comparison
+type CompareOptions struct {
+	// IgnoreFields are paths left out of the comparison, such as
+	// "spec.replicas" or "spec.template.spec.containers[*].image". A path
+	// covers everything below it and "*" matches any one segment.
+	IgnoreFields []string
+	// IgnoreLabels are label keys left out of object and pod template labels
+	IgnoreLabels []string
+}
+
+// ObjectRef names an object in a namespace diff
+type ObjectRef struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// FieldChange is one field that differs between the two sides. A is nil
+// when the field is only set on side B and B is nil when only on side A.
+type FieldChange struct {
+	Path string      `json:"path"`
+	A    interface{} `json:"a"`
+	B    interface{} `json:"b"`
+}
+
+// ObjectDiff is an object present on both sides with differing fields
+type ObjectDiff struct {
+	ObjectRef
+	Changes []FieldChange `json:"changes"`
+}
+
+// NamespaceDiff is how namespace B differs from namespace A: Added objects
+// are only in B, Removed ones only in A
+type NamespaceDiff struct {
+	ClusterA   string       `json:"cluster_a"`
+	NamespaceA string       `json:"namespace_a"`
+	ClusterB   string       `json:"cluster_b"`
+	NamespaceB string       `json:"namespace_b"`
+	Added      []ObjectRef  `json:"added"`
+	Removed    []ObjectRef  `json:"removed"`
+	Changed    []ObjectDiff `json:"changed"`
+	Identical  int          `json:"identical"`
+}
+
+// InSync reports whether both namespaces hold the same objects
+func (d *NamespaceDiff) InSync() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// CompareNamespaces compares the workloads, config maps and services of a
+// namespace in one cluster with those of a namespace in another, or the
+// same, cluster. nsB defaults to nsA.
+func (s *Service) CompareNamespaces(ctx context.Context, clusterA, nsA, clusterB, nsB string, opts CompareOptions) (*NamespaceDiff, error) {
+	if nsA == "" {
+		return nil, errors.Validation("namespace is required")
+	}
+	if nsB == "" {
+		nsB = nsA
+	}
+
+	diff := &NamespaceDiff{NamespaceA: nsA, NamespaceB: nsB}
+	sides := []struct {
+		id, namespace string
+		name          *string
+		objects       []*unstructured.Unstructured
+	}{
+		{id: clusterA, namespace: nsA, name: &diff.ClusterA},
+		{id: clusterB, namespace: nsB, name: &diff.ClusterB},
+	}
+	for i := range sides {
+		side := &sides[i]
+		client, err := s.clientByID(ctx, side.id)
+		if err != nil {
+			return nil, err
+		}
+		*side.name = client.Name
+		side.objects, err = NamespaceObjects(ctx, client.Clientset, side.namespace)
+		if err != nil {
+			return nil, errors.KubernetesWrap(err, fmt.Sprintf("failed to list namespace %s on %s", side.namespace, client.Name))
+		}
+	}
+
+	DiffObjectSets(diff, sides[0].objects, sides[1].objects, opts)
+	return diff, nil
+}
+
+// NamespaceObjects lists the deployments, stateful sets, daemon sets,
+// config maps and services of a namespace as unstructured objects
+func NamespaceObjects(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	add := func(kind string, obj runtime.Object) error {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		u := &unstructured.Unstructured{Object: content}
+		// Typed list items carry no type meta
+		u.SetKind(kind)
+		objects = append(objects, u)
+		return nil
+	}
+
+	deployments, err := clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		if err := add("Deployment", &deployments.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		if err := add("StatefulSet", &statefulSets.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	daemonSets, err := clientset.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		if err := add("DaemonSet", &daemonSets.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	configMaps, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range configMaps.Items {
+		// Every namespace gets its cluster's own CA bundle
+		if configMaps.Items[i].Name == "kube-root-ca.crt" {
+			continue
+		}
+		if err := add("ConfigMap", &configMaps.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	services, err := clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range services.Items {
+		if err := add("Service", &services.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+// DiffObjectSets fills diff with how objects b differ from objects a,
+// matching objects by kind and name
+func DiffObjectSets(diff *NamespaceDiff, a, b []*unstructured.Unstructured, opts CompareOptions) {
+	ignore := make([][]string, len(opts.IgnoreFields))
+	for i, field := range opts.IgnoreFields {
+		ignore[i] = splitPath(field)
+	}
+
+	index := func(objects []*unstructured.Unstructured) map[ObjectRef]map[string]interface{} {
+		m := make(map[ObjectRef]map[string]interface{}, len(objects))
+		for _, obj := range objects {
+			m[ObjectRef{Kind: obj.GetKind(), Name: obj.GetName()}] = normalizeForCompare(obj, opts.IgnoreLabels)
+		}
+		return m
+	}
+	sideA, sideB := index(a), index(b)
+
+	diff.Added, diff.Removed, diff.Changed = []ObjectRef{}, []ObjectRef{}, []ObjectDiff{}
+	for ref, objA := range sideA {
+		objB, ok := sideB[ref]
+		if !ok {
+			diff.Removed = append(diff.Removed, ref)
+			continue
+		}
+		var changes []FieldChange
+		compareValues(nil, objA, objB, ignore, &changes)
+		if len(changes) == 0 {
+			diff.Identical++
+			continue
+		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+		diff.Changed = append(diff.Changed, ObjectDiff{ObjectRef: ref, Changes: changes})
+	}
+	for ref := range sideB {
+		if _, ok := sideA[ref]; !ok {
+			diff.Added = append(diff.Added, ref)
+		}
+	}
+
+	sortRefs(diff.Added)
+	sortRefs(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return refLess(diff.Changed[i].ObjectRef, diff.Changed[j].ObjectRef) })
+}
+
+// serverManagedAnnotations are set by controllers and clients, not authors
+var serverManagedAnnotations = []string{
+	"deployment.kubernetes.io/revision",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// normalizeForCompare keeps what authors set: metadata is cut down to
+// labels and annotations, and status and cluster-assigned fields go
+func normalizeForCompare(obj *unstructured.Unstructured, ignoreLabels []string) map[string]interface{} {
+	out := obj.DeepCopy().Object
+	delete(out, "status")
+	delete(out, "apiVersion")
+	delete(out, "kind")
+
+	metadata := map[string]interface{}{}
+	if labels := obj.GetLabels(); len(labels) > 0 {
+		metadata["labels"] = stringMap(labels)
+	}
+	if annotations := obj.GetAnnotations(); len(annotations) > 0 {
+		kept := stringMap(annotations)
+		for _, key := range serverManagedAnnotations {
+			delete(kept, key)
+		}
+		if len(kept) > 0 {
+			metadata["annotations"] = kept
+		}
+	}
+	out["metadata"] = metadata
+	unstructured.RemoveNestedField(out, "spec", "template", "metadata", "creationTimestamp")
+	// Set by rollout restarts
+	unstructured.RemoveNestedField(out, "spec", "template", "metadata", "annotations", "kubectl.kubernetes.io/restartedAt")
+
+	if obj.GetKind() == "Service" {
+		for _, field := range []string{"clusterIP", "clusterIPs", "ipFamilies", "ipFamilyPolicy", "healthCheckNodePort"} {
+			unstructured.RemoveNestedField(out, "spec", field)
+		}
+	}
+
+	for _, key := range ignoreLabels {
+		unstructured.RemoveNestedField(out, "metadata", "labels", key)
+		unstructured.RemoveNestedField(out, "spec", "template", "metadata", "labels", key)
+	}
+	return out
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+// compareValues records the fields that differ between a and b under
+// path. Lists whose items all have a name, like containers, env and
+// ports, are matched by name; other lists are compared whole.
+func compareValues(path []string, a, b interface{}, ignore [][]string, changes *[]FieldChange) {
+	if ignored(path, ignore) {
+		return
+	}
+
+	mapA, aIsMap := a.(map[string]interface{})
+	mapB, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		for key, valueA := range mapA {
+			compareValues(appendPath(path, key), valueA, mapB[key], ignore, changes)
+		}
+		for key, valueB := range mapB {
+			if _, ok := mapA[key]; !ok {
+				compareValues(appendPath(path, key), nil, valueB, ignore, changes)
+			}
+		}
+		return
+	}
+
+	listA, aIsList := a.([]interface{})
+	listB, bIsList := b.([]interface{})
+	if aIsList && bIsList {
+		namedA, okA := namedItems(listA)
+		namedB, okB := namedItems(listB)
+		if okA && okB {
+			for name, itemA := range namedA {
+				compareValues(appendPath(path, "["+name+"]"), itemA, namedB[name], ignore, changes)
+			}
+			for name, itemB := range namedB {
+				if _, ok := namedA[name]; !ok {
+					compareValues(appendPath(path, "["+name+"]"), nil, itemB, ignore, changes)
+				}
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, FieldChange{Path: joinPath(path), A: a, B: b})
+	}
+}
+
+// namedItems indexes a list by the name of its items, if every item is a
+// map with a distinct name
+func namedItems(list []interface{}) (map[string]interface{}, bool) {
+	if len(list) == 0 {
+		return map[string]interface{}{}, true
+	}
+	items := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok || name == "" {
+			return nil, false
+		}
+		if _, dup := items[name]; dup {
+			return nil, false
+		}
+		items[name] = item
+	}
+	return items, true
+}
+
+func appendPath(path []string, segment string) []string {
+	return append(path[:len(path):len(path)], segment)
+}
+
+// joinPath renders path segments as spec.containers[app].image
+func joinPath(path []string) string {
+	var b strings.Builder
+	for i, segment := range path {
+		if i > 0 && !strings.HasPrefix(segment, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(segment)
+	}
+	return b.String()
+}
+
+// splitPath parses a path rendered by joinPath back into segments
+func splitPath(path string) []string {
+	var segments []string
+	for _, part := range strings.Split(path, ".") {
+		for part != "" {
+			open := strings.IndexByte(part, '[')
+			switch {
+			case open > 0:
+				segments = append(segments, part[:open])
+				part = part[open:]
+			case open == 0:
+				end := strings.IndexByte(part, ']')
+				if end < 0 {
+					end = len(part) - 1
+				}
+				segments = append(segments, part[:end+1])
+				part = part[end+1:]
+			default:
+				segments = append(segments, part)
+				part = ""
+			}
+		}
+	}
+	return segments
+}
+
+// ignored reports whether path is at or below one of the ignored paths
+func ignored(path []string, ignore [][]string) bool {
+	for _, pattern := range ignore {
+		if len(pattern) == 0 || len(pattern) > len(path) {
+			continue
+		}
+		match := true
+		for i, segment := range pattern {
+			if segment != path[i] && segment != "*" && segment != "[*]" {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func sortRefs(refs []ObjectRef) {
+	sort.Slice(refs, func(i, j int) bool { return refLess(refs[i], refs[j]) })
+}
+
+func refLess(a, b ObjectRef) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	return a.Name < b.Name
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func compareDeployment(name, image string, replicas int32, env ...corev1.EnvVar) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "shop",
+			Labels:    map[string]string{"app": name},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: "app", Image: image, Env: env},
+					{Name: "proxy", Image: "envoy:1.30"},
+				}},
+			},
+		},
+	}
+}
+
+// namespaceObjects lists a fake namespace holding the deployments, a
+// config map and a service with the given cluster IP
+func namespaceObjects(t *testing.T, clusterIP string, objects ...*appsv1.Deployment) []*unstructured.Unstructured {
+	clientset := fake.NewSimpleClientset(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kube-root-ca.crt", Namespace: "shop"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "shop"}, Data: map[string]string{"mode": "live"}},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+			Spec:       corev1.ServiceSpec{ClusterIP: clusterIP, Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+		},
+	)
+	for _, obj := range objects {
+		_, err := clientset.AppsV1().Deployments("shop").Create(context.Background(), obj, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	list, err := NamespaceObjects(context.Background(), clientset, "shop")
+	require.NoError(t, err)
+	return list
+}
+
+func TestDiffObjectSets(t *testing.T) {
+	staging := compareDeployment("web", "shop/web:1.4", 2, corev1.EnvVar{Name: "LOG_LEVEL", Value: "debug"})
+	staging.UID = types.UID("staging-uid")
+	staging.Annotations = map[string]string{"deployment.kubernetes.io/revision": "7"}
+	staging.Spec.Template.Labels["canary"] = "true"
+
+	prod := compareDeployment("web", "shop/web:1.3", 5, corev1.EnvVar{Name: "LOG_LEVEL", Value: "info"}, corev1.EnvVar{Name: "REGION", Value: "eu"})
+	prod.UID = types.UID("prod-uid")
+	prod.Annotations = map[string]string{"deployment.kubernetes.io/revision": "31"}
+	prod.Status.ReadyReplicas = 5
+
+	a := namespaceObjects(t, "10.0.0.10", staging, compareDeployment("worker", "shop/worker:2.0", 1), compareDeployment("debug", "busybox", 1))
+	b := namespaceObjects(t, "10.96.0.10", prod, compareDeployment("worker", "shop/worker:2.0", 1), compareDeployment("cron", "shop/cron:1.0", 1))
+
+	diff := &NamespaceDiff{}
+	DiffObjectSets(diff, a, b, CompareOptions{})
+
+	assert.Equal(t, []ObjectRef{{Kind: "Deployment", Name: "cron"}}, diff.Added)
+	assert.Equal(t, []ObjectRef{{Kind: "Deployment", Name: "debug"}}, diff.Removed)
+	assert.Equal(t, 3, diff.Identical, "worker, the config map and the service match; cluster IPs and the CA bundle are ignored")
+	assert.False(t, diff.InSync())
+
+	require.Len(t, diff.Changed, 1)
+	assert.Equal(t, ObjectRef{Kind: "Deployment", Name: "web"}, diff.Changed[0].ObjectRef)
+	assert.Equal(t, []FieldChange{
+		{Path: "spec.replicas", A: int64(2), B: int64(5)},
+		{Path: "spec.template.metadata.labels.canary", A: "true", B: nil},
+		{Path: "spec.template.spec.containers[app].env[LOG_LEVEL].value", A: "debug", B: "info"},
+		{Path: "spec.template.spec.containers[app].env[REGION]", A: nil, B: map[string]interface{}{"name": "REGION", "value": "eu"}},
+		{Path: "spec.template.spec.containers[app].image", A: "shop/web:1.4", B: "shop/web:1.3"},
+	}, diff.Changed[0].Changes, "status, uid and revision annotations are not compared")
+}
+
+func TestDiffObjectSetsIgnoring(t *testing.T) {
+	staging := compareDeployment("web", "shop/web:1.4", 2)
+	staging.Labels["env"] = "staging"
+	staging.Spec.Template.Labels["env"] = "staging"
+	prod := compareDeployment("web", "shop/web:1.3", 5)
+	prod.Labels["env"] = "prod"
+	prod.Spec.Template.Labels["env"] = "prod"
+
+	diff := &NamespaceDiff{}
+	DiffObjectSets(diff, namespaceObjects(t, "10.0.0.10", staging), namespaceObjects(t, "10.96.0.10", prod), CompareOptions{
+		IgnoreFields: []string{"spec.replicas", "spec.template.spec.containers[*].image"},
+		IgnoreLabels: []string{"env"},
+	})
+	assert.True(t, diff.InSync(), "%+v", diff.Changed)
+	assert.Equal(t, 3, diff.Identical)
+}
+
+func TestSplitPath(t *testing.T) {
+	assert.Equal(t, []string{"spec", "template", "spec", "containers", "[app]", "image"},
+		splitPath("spec.template.spec.containers[app].image"))
+	assert.Equal(t, "spec.template.spec.containers[app].image",
+		joinPath(splitPath("spec.template.spec.containers[app].image")))
+	assert.Equal(t, []string{"metadata", "labels"}, splitPath("metadata.labels"))
+}
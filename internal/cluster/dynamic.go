@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	goerrors "errors"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ListAPIResources returns the resource types a cluster serves, including
+// CRDs, for picking what to get or list
+func (s *Service) ListAPIResources(ctx context.Context, clusterID string) ([]kube.APIResource, error) {
+	client, err := s.clientByID(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := client.APIResources(ctx)
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to list API resources")
+	}
+	return resources, nil
+}
+
+// redactedValue replaces the values of sensitive objects returned by
+// GetResource and ListResources
+const redactedValue = "(redacted)"
+
+// sensitiveResources hold credentials. Reading a cluster doesn't entitle
+// anyone to them, so the generic getter and lister return them with their
+// values redacted, keeping which keys exist.
+var sensitiveResources = map[schema.GroupResource]bool{
+	{Resource: "secrets"}: true,
+}
+
+// redactObject hides the data of obj if gr is sensitive, along with the
+// last-applied annotation, which repeats it
+func redactObject(gr schema.GroupResource, obj map[string]interface{}) {
+	if !sensitiveResources[gr] {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, _ := obj[field].(map[string]interface{})
+		for key := range values {
+			values[key] = redactedValue
+		}
+	}
+	metadata, _ := obj["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if _, ok := annotations[corev1.LastAppliedConfigAnnotation]; ok {
+		annotations[corev1.LastAppliedConfigAnnotation] = redactedValue
+	}
+}
+
+// GetResource returns one object of any resource type as unstructured
+// JSON, with sensitive values redacted. namespace must be empty for
+// cluster-scoped resources.
+func (s *Service) GetResource(ctx context.Context, clusterID string, gvr schema.GroupVersionResource, namespace, name string) (map[string]interface{}, error) {
+	client, err := s.clientByID(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := client.GetResource(ctx, gvr, namespace, name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, errors.NotFound(gvr.GroupResource().String(), name)
+		}
+		return nil, dynamicError(err, "failed to get resource")
+	}
+	redactObject(gvr.GroupResource(), obj.Object)
+	return obj.Object, nil
+}
+
+// ListResources returns a page of objects of any resource type as
+// unstructured JSON, with sensitive values redacted, and the cursor for
+// the next page, empty on the last.
+// An empty namespace lists a namespaced resource across all namespaces.
+func (s *Service) ListResources(ctx context.Context, clusterID string, gvr schema.GroupVersionResource, namespace string, opts kube.ListOptions) ([]map[string]interface{}, string, error) {
+	client, err := s.clientByID(ctx, clusterID)
+	if err != nil {
+		return nil, "", err
+	}
+	list, err := client.ListResources(ctx, gvr, namespace, opts)
+	if err != nil {
+		return nil, "", dynamicError(err, "failed to list resources")
+	}
+
+	items := make([]map[string]interface{}, len(list.Items))
+	for i := range list.Items {
+		items[i] = list.Items[i].Object
+		redactObject(gvr.GroupResource(), items[i])
+	}
+	return items, list.GetContinue(), nil
+}
+
+// clientByID returns the client of the cluster with the given ID
+func (s *Service) clientByID(ctx context.Context, clusterID string) (*kube.ClusterClient, error) {
+	cluster, err := s.Get(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.kubeManager.GetClient(cluster.Name)
+	if err != nil {
+		return nil, errors.ClusterWrap(err, "failed to get cluster client")
+	}
+	return client, nil
+}
+
+// dynamicError maps a dynamic-client error: unknown resource types are
+// not found and scope mismatches are invalid requests
+func dynamicError(err error, message string) error {
+	switch {
+	case goerrors.Is(err, kube.ErrUnknownResource):
+		return errors.NotFoundMsg(err.Error())
+	case goerrors.Is(err, kube.ErrResourceScope):
+		return errors.Validation(err.Error())
+	}
+	return errors.KubernetesWrap(err, message)
+}
@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// newDynamicService serves a cluster holding objects through the generic
+// getter and lister
+func newDynamicService(t *testing.T, objects ...runtime.Object) *Service {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	seedClusters(t, sqlDB)
+
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+			{Name: "secrets", Kind: "Secret", Namespaced: true},
+		}},
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{configMaps: "ConfigMapList", secretsGVR: "SecretList"}, objects...)
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	manager.AddClient(&kube.ClusterClient{Name: "prod", Clientset: clientset, DynamicClient: dynamicClient})
+	return NewService(&database.PostgresDB{DB: sqlDB}, manager, nil)
+}
+
+func TestGenericGetterRedactsSecrets(t *testing.T) {
+	ctx := context.Background()
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name": "db", "namespace": "shop",
+			"annotations": map[string]interface{}{
+				corev1.LastAppliedConfigAnnotation: `{"data":{"password":"aHVudGVyMg=="}}`,
+				"team":                             "payments",
+			},
+		},
+		"type": "Opaque",
+		"data": map[string]interface{}{"password": "aHVudGVyMg==", "user": "YWRtaW4="},
+	}}
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+		"data":       map[string]interface{}{"color": "blue"},
+	}}
+	s := newDynamicService(t, secret, configMap)
+
+	obj, err := s.GetResource(ctx, testClusterID, secretsGVR, "shop", "db")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": redactedValue, "user": redactedValue}, obj["data"],
+		"keys are kept, values hidden")
+	annotations := obj["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	assert.Equal(t, redactedValue, annotations[corev1.LastAppliedConfigAnnotation])
+	assert.Equal(t, "payments", annotations["team"])
+	assert.Equal(t, "Opaque", obj["type"])
+
+	items, _, err := s.ListResources(ctx, testClusterID, secretsGVR, "shop", kube.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, redactedValue, items[0]["data"].(map[string]interface{})["password"])
+
+	obj, err = s.GetResource(ctx, testClusterID, configMaps, "shop", "web")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"color": "blue"}, obj["data"], "other resources are returned as is")
+}
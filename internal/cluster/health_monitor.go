@@ -0,0 +1,263 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthInterval    = time.Minute
+	defaultHealthConcurrency = 10
+	// healthCheckTimeout bounds one cluster's check so an unreachable API
+	// server can't hold a worker for the whole interval
+	healthCheckTimeout = 30 * time.Second
+)
+
+// Cluster statuses recorded by health checks
+const (
+	StatusConnected    = "connected"
+	StatusUnhealthy    = "unhealthy"
+	StatusDisconnected = "disconnected"
+)
+
+// HealthMonitorConfig configures RunHealthMonitor
+type HealthMonitorConfig struct {
+	// Interval between checks of every cluster; defaults to a minute
+	Interval time.Duration
+	// Concurrency caps how many clusters are checked at once; defaults to 10
+	Concurrency int
+}
+
+// healthTarget is the part of a cluster row a health check needs
+type healthTarget struct {
+	id         string
+	name       string
+	status     string
+	kubeconfig string
+	// kubeconfigRef is the secret holding the kubeconfig, if it isn't
+	// stored inline
+	kubeconfigRef string
+	apiServer     string
+	cloud         *kube.CloudAuth
+}
+
+// RunHealthMonitor checks every cluster once immediately and then every
+// interval, recording status, node count, capacity and last_health_check,
+// until ctx is cancelled. Status transitions are broadcast to dashboard
+// clients.
+func (s *Service) RunHealthMonitor(ctx context.Context, cfg HealthMonitorConfig) {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultHealthConcurrency
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.CheckAllClusters(ctx, concurrency); err != nil && ctx.Err() == nil {
+			logger.Warn("Cluster health sweep failed", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAllClusters checks every cluster with at most concurrency checks in
+// flight and returns once all have finished
+func (s *Service) CheckAllClusters(ctx context.Context, concurrency int) error {
+	targets, err := s.healthTargets(ctx)
+	if err != nil {
+		return err
+	}
+	if concurrency <= 0 {
+		concurrency = defaultHealthConcurrency
+	}
+
+	jobs := make(chan healthTarget)
+	var wg sync.WaitGroup
+	for i := 0; i < min(concurrency, len(targets)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				s.checkCluster(ctx, target)
+			}
+		}()
+	}
+
+feed:
+	for _, target := range targets {
+		select {
+		case jobs <- target:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (s *Service) healthTargets(ctx context.Context) ([]healthTarget, error) {
+	return s.queryHealthTargets(ctx, "")
+}
+
+// queryHealthTargets loads the clusters matching the optional where clause
+func (s *Service) queryHealthTargets(ctx context.Context, where string, args ...interface{}) ([]healthTarget, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, status, COALESCE(kubeconfig, ''), COALESCE(kubeconfig_ref, ''),
+		       api_server, cloud_auth
+		FROM clusters
+	`+where, args...)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to query clusters")
+	}
+	defer rows.Close()
+
+	var targets []healthTarget
+	for rows.Next() {
+		var t healthTarget
+		var cloudAuth []byte
+		if err := rows.Scan(&t.id, &t.name, &t.status, &t.kubeconfig, &t.kubeconfigRef, &t.apiServer, &cloudAuth); err != nil {
+			return nil, errors.DatabaseWrap(err, "failed to scan cluster")
+		}
+		if len(cloudAuth) > 0 {
+			json.Unmarshal(cloudAuth, &t.cloud)
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// checkCluster probes one cluster and records the result
+func (s *Service) checkCluster(ctx context.Context, target healthTarget) {
+	checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	status, info, message := s.probeCluster(checkCtx, target)
+	if ctx.Err() != nil {
+		// Shutting down; a check cut short says nothing about the cluster
+		return
+	}
+	s.recordHealth(ctx, target, status, info, message)
+}
+
+// probeCluster validates connectivity to a cluster. A cluster without a
+// client (e.g. after a restart) is reconnected from its stored kubeconfig
+// or cloud auth.
+func (s *Service) probeCluster(ctx context.Context, target healthTarget) (string, *kube.ClusterInfo, string) {
+	client, err := s.clientFor(ctx, target)
+	if err != nil {
+		return StatusDisconnected, nil, err.Error()
+	}
+
+	if err := client.CheckHealth(ctx); err != nil {
+		return StatusUnhealthy, nil, err.Error()
+	}
+
+	info, err := client.GetClusterInfo(ctx)
+	if err != nil {
+		return StatusUnhealthy, nil, err.Error()
+	}
+	return StatusConnected, info, ""
+}
+
+// clientFor returns the target's client, reconnecting it from its stored
+// kubeconfig or cloud auth if the manager has none
+func (s *Service) clientFor(ctx context.Context, target healthTarget) (*kube.ClusterClient, error) {
+	client, err := s.kubeManager.GetClient(target.name)
+	if err == nil {
+		return client, nil
+	}
+	switch {
+	case target.cloud != nil:
+		return s.connectCloud(ctx, target.name, target.apiServer, target.cloud)
+	case target.kubeconfigRef != "":
+		kubeconfig, err := s.loadKubeconfig(ctx, target.kubeconfigRef)
+		if err != nil {
+			return nil, err
+		}
+		return s.kubeManager.AddCluster(target.name, kubeconfig)
+	case target.kubeconfig != "":
+		kubeconfig, err := s.fields.Decrypt(kubeconfigColumn, target.kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		return s.kubeManager.AddCluster(target.name, []byte(kubeconfig))
+	}
+	return nil, err
+}
+
+// recordHealth stores the result of a check and, if the status changed,
+// logs and broadcasts the transition
+func (s *Service) recordHealth(ctx context.Context, target healthTarget, status string, info *kube.ClusterInfo, message string) {
+	var err error
+	if info != nil {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE clusters
+			SET version = $2, nodes_count = $3, cpu_capacity = $4, memory_capacity = $5,
+			    status = $6, last_health_check = NOW(), updated_at = NOW()
+			WHERE id = $1
+		`, target.id, info.Version, info.NodesCount, info.CPUCapacity, info.MemoryCapacity, status)
+	} else {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE clusters
+			SET status = $2, last_health_check = NOW(), updated_at = NOW()
+			WHERE id = $1
+		`, target.id, status)
+	}
+	if err != nil {
+		logger.Warn("Failed to record cluster health",
+			zap.String("cluster_id", target.id),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if s.cache != nil {
+		s.cache.Delete(ctx, cache.BuildKey(cache.PrefixCluster, target.id))
+	}
+
+	if status == target.status {
+		return
+	}
+
+	logger.Info("Cluster status changed",
+		zap.String("cluster_id", target.id),
+		zap.String("name", target.name),
+		zap.String("from", target.status),
+		zap.String("to", status),
+		zap.String("message", message),
+	)
+
+	if s.emitter != nil {
+		event := map[string]interface{}{
+			"name":            target.name,
+			"status":          status,
+			"previous_status": target.status,
+		}
+		if message != "" {
+			event["message"] = message
+		}
+		if info != nil {
+			event["nodes_count"] = info.NodesCount
+		}
+		s.emitter.EmitClusterStatus(target.id, event)
+	}
+}
@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// kubeconfigKey is the key a stored kubeconfig is kept under in its secret
+const kubeconfigKey = "kubeconfig"
+
+// kubeconfigColumn holds kubeconfigs stored inline, encrypted when field
+// keys are configured
+var kubeconfigColumn = secrets.Column{Table: "clusters", Key: "id", Name: "kubeconfig"}
+
+// EncryptedColumns are the cluster columns a FieldCipher encrypts
+var EncryptedColumns = []secrets.Column{kubeconfigColumn}
+
+// SetFieldCipher encrypts kubeconfigs stored inline with fields. Optional;
+// without it they are stored in plaintext.
+func (s *Service) SetFieldCipher(fields *secrets.FieldCipher) { s.fields = fields }
+
+// storeKubeconfig puts a new cluster's kubeconfig in the secret store and
+// returns its ref. Refs are random so a failed create can't overwrite
+// another cluster's kubeconfig.
+func (s *Service) storeKubeconfig(ctx context.Context, kubeconfig string) (string, error) {
+	ref := "cluster-" + uuid.NewString()
+	if err := s.secrets.Set(ctx, ref, map[string][]byte{kubeconfigKey: []byte(kubeconfig)}); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// loadKubeconfig reads a kubeconfig kept in the secret store
+func (s *Service) loadKubeconfig(ctx context.Context, ref string) ([]byte, error) {
+	if s.secrets == nil {
+		return nil, fmt.Errorf("no secret store configured for kubeconfig %s", ref)
+	}
+	data, err := s.secrets.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	kubeconfig, ok := data[kubeconfigKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no %s", ref, kubeconfigKey)
+	}
+	return kubeconfig, nil
+}
+
+// SecretRotated reconnects the clusters whose kubeconfig or cloud
+// credentials are kept in the secret at ref, so a rotated credential is
+// used without a restart. It is meant as a rotation hook of the secret
+// store.
+func (s *Service) SecretRotated(ctx context.Context, ref string) {
+	targets, err := s.healthTargets(ctx)
+	if err != nil {
+		logger.Warn("Failed to load clusters for rotated secret", zap.String("ref", ref), zap.Error(err))
+		return
+	}
+	for _, target := range targets {
+		if target.kubeconfigRef != ref && (target.cloud == nil || target.cloud.CredentialsRef != ref) {
+			continue
+		}
+		s.kubeManager.RemoveCluster(target.name)
+		if _, err := s.clientFor(ctx, target); err != nil {
+			logger.Warn("Failed to reconnect cluster with rotated credentials",
+				zap.String("cluster", target.name), zap.String("ref", ref), zap.Error(err))
+			continue
+		}
+		logger.Info("Reconnected cluster with rotated credentials",
+			zap.String("cluster", target.name), zap.String("ref", ref))
+	}
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testKubeconfig is a kubeconfig for a cluster served at server that
+// refuses connections
+func testKubeconfig(server string) string {
+	return fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: staging
+  cluster: {server: %q, insecure-skip-tls-verify: true}
+users:
+- name: admin
+  user: {token: t0ken}
+contexts:
+- name: staging
+  context: {cluster: staging, user: admin}
+current-context: staging
+`, server)
+}
+
+func TestKubeconfigKeptInSecretStore(t *testing.T) {
+	svc, _ := newApplyService(t)
+	store := secrets.NewCached(secrets.NewKube(fake.NewSimpleClientset(), "krustron-system"), time.Minute)
+	svc.SetSecretStore(store)
+	store.OnRotate(svc.SecretRotated)
+	ctx := context.Background()
+
+	created, err := svc.Create(ctx, &CreateRequest{
+		Name:       "staging",
+		APIServer:  "https://127.0.0.1:1",
+		AuthType:   "kubeconfig",
+		Kubeconfig: testKubeconfig("https://127.0.0.1:1"),
+	})
+	require.NoError(t, err)
+
+	// The row only references the kubeconfig
+	var inline sql.NullString
+	var ref string
+	require.NoError(t, svc.db.QueryRow(`SELECT kubeconfig, kubeconfig_ref FROM clusters WHERE id = $1`,
+		created.ID).Scan(&inline, &ref))
+	assert.Empty(t, inline.String)
+	assert.True(t, strings.HasPrefix(ref, "cluster-"), ref)
+	stored, err := secrets.Value(ctx, store, ref, kubeconfigKey)
+	require.NoError(t, err)
+	assert.Equal(t, testKubeconfig("https://127.0.0.1:1"), string(stored))
+
+	// A cluster without a client, as after a restart, is reconnected from
+	// the store
+	svc.kubeManager.RemoveCluster("staging")
+	targets, err := svc.queryHealthTargets(ctx, "WHERE id = $1", created.ID)
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	client, err := svc.clientFor(ctx, targets[0])
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:1", client.Config.Host)
+
+	// Rotating the kubeconfig reconnects the cluster with it
+	require.NoError(t, store.Set(ctx, ref, map[string][]byte{
+		kubeconfigKey: []byte(testKubeconfig("https://127.0.0.1:2")),
+	}))
+	client, err = svc.kubeManager.GetClient("staging")
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:2", client.Config.Host)
+
+	// Deleting the cluster deletes its kubeconfig. Get, which Delete
+	// calls, needs the columns a health check fills in.
+	_, err = svc.db.Exec(`UPDATE clusters SET cpu_capacity = '4', memory_capacity = '16Gi',
+		agent_installed = false, agent_version = '' WHERE id = $1`, created.ID)
+	require.NoError(t, err)
+	require.NoError(t, svc.Delete(ctx, created.ID))
+	_, err = store.Get(ctx, ref)
+	assert.ErrorIs(t, err, secrets.ErrNotFound)
+}
+
+func TestInlineKubeconfigEncrypted(t *testing.T) {
+	svc, _ := newApplyService(t)
+	fields, err := secrets.NewFieldCipher(map[string][]byte{"k1": []byte(strings.Repeat("k", 32))}, "k1")
+	require.NoError(t, err)
+	svc.SetFieldCipher(fields)
+	ctx := context.Background()
+
+	created, err := svc.Create(ctx, &CreateRequest{
+		Name:       "staging",
+		APIServer:  "https://127.0.0.1:1",
+		AuthType:   "kubeconfig",
+		Kubeconfig: testKubeconfig("https://127.0.0.1:1"),
+	})
+	require.NoError(t, err)
+
+	var stored string
+	require.NoError(t, svc.db.QueryRow(`SELECT kubeconfig FROM clusters WHERE id = $1`, created.ID).Scan(&stored))
+	assert.True(t, strings.HasPrefix(stored, "fenc:k1:"), stored)
+	assert.NotContains(t, stored, "t0ken")
+
+	// The client is rebuilt from the decrypted kubeconfig
+	svc.kubeManager.RemoveCluster("staging")
+	targets, err := svc.queryHealthTargets(ctx, "WHERE id = $1", created.ID)
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	client, err := svc.clientFor(ctx, targets[0])
+	require.NoError(t, err)
+	assert.Equal(t, "https://127.0.0.1:1", client.Config.Host)
+
+	body, err := json.Marshal(created)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "t0ken")
+}
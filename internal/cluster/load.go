@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"context"
+	"maps"
+	"math/rand/v2"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+)
+
+// Cache lifetimes before jitter. Health and resources come from the
+// cluster itself, so they are kept only long enough to absorb bursts.
+const (
+	clusterCacheTTL   = 5 * time.Minute
+	healthCacheTTL    = 15 * time.Second
+	resourcesCacheTTL = 30 * time.Second
+
+	// loadTimeout bounds a shared load, which outlives the caller that
+	// started it
+	loadTimeout = 30 * time.Second
+)
+
+// jitter spreads ttl by up to a tenth either way, so entries cached
+// together don't all expire, and reload, at the same moment
+func jitter(ttl time.Duration) time.Duration {
+	spread := int64(ttl / 10)
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int64N(2*spread+1)-spread)
+}
+
+// load runs fn once for all concurrent callers with the same key and
+// hands each the result. fn doesn't inherit the starting caller's
+// cancellation, so one caller giving up doesn't fail the others; each
+// caller still stops waiting when its own context ends.
+func (s *Service) load(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	ch := s.loads.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		return fn(ctx)
+	})
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// cached returns the value cached at key, loading it with fn and caching
+// it for a jittered ttl on a miss. Concurrent misses for the same key share
+// one load; each caller gets its own copy of the result.
+func cached[T any](ctx context.Context, s *Service, key string, ttl time.Duration, fn func(ctx context.Context) (*T, error)) (*T, error) {
+	if s.cache != nil {
+		var hit T
+		if err := s.cache.Get(ctx, key, &hit); err == nil {
+			return &hit, nil
+		}
+	}
+
+	v, err := s.load(ctx, key, func(ctx context.Context) (interface{}, error) {
+		value, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if s.cache != nil {
+			s.cache.Set(ctx, key, value, jitter(ttl))
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := *v.(*T)
+	return &out, nil
+}
+
+// invalidate drops everything cached about the cluster id
+func (s *Service) invalidate(ctx context.Context, id string) {
+	if s.cache != nil {
+		s.cache.Delete(ctx,
+			cache.BuildKey(cache.PrefixCluster, id),
+			cache.BuildKey(cache.PrefixCluster, id, "health"),
+			cache.BuildKey(cache.PrefixCluster, id, "resources"),
+		)
+	}
+}
+
+// clone copies c deeply enough that callers sharing a load can each
+// modify their own
+func (c *Cluster) clone() *Cluster {
+	out := *c
+	out.Labels = maps.Clone(c.Labels)
+	out.Annotations = maps.Clone(c.Annotations)
+	if c.Cloud != nil {
+		cloud := *c.Cloud
+		out.Cloud = &cloud
+	}
+	return &out
+}
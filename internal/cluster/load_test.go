@@ -0,0 +1,182 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingConnector opens in-memory SQLite connections that count, and
+// slow down by delay, the queries loading a single cluster
+type countingConnector struct {
+	driver driver.Driver
+	delay  time.Duration
+	gets   atomic.Int64
+}
+
+func (c *countingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, connector: c}, nil
+}
+
+func (c *countingConnector) Driver() driver.Driver { return c.driver }
+
+type countingConn struct {
+	driver.Conn
+	connector *countingConnector
+}
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if strings.Contains(query, "FROM clusters WHERE id") {
+		c.connector.gets.Add(1)
+		time.Sleep(c.connector.delay)
+	}
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+// newCountingService backs a service with the seeded cluster tables,
+// counting how often the cluster is loaded from the database
+func newCountingService(tb testing.TB, delay time.Duration, redis *cache.RedisCache) (*Service, *countingConnector) {
+	tb.Helper()
+	probe, err := sql.Open("sqlite", ":memory:")
+	require.NoError(tb, err)
+	connector := &countingConnector{driver: probe.Driver(), delay: delay}
+	probe.Close()
+
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(1)
+	tb.Cleanup(func() { db.Close() })
+	seedClusters(tb, db)
+	return NewService(&database.PostgresDB{DB: db}, nil, redis), connector
+}
+
+func TestGetSharesConcurrentMisses(t *testing.T) {
+	s, db := newCountingService(t, 100*time.Millisecond, nil)
+
+	const callers = 50
+	start := make(chan struct{})
+	clusters := make([]*Cluster, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			clusters[i], errs[i] = s.Get(context.Background(), testClusterID)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int64(1), db.gets.Load())
+	for i := range callers {
+		require.NoError(t, errs[i])
+		assert.Equal(t, "prod", clusters[i].Name)
+	}
+
+	// Each caller gets its own copy
+	clusters[0].Labels["team"] = "payments"
+	assert.NotContains(t, clusters[1].Labels, "team")
+
+	// Once the load finishes the next miss loads again
+	_, err := s.Get(context.Background(), testClusterID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), db.gets.Load())
+}
+
+func TestGetCancelledCallerDoesNotFailOthers(t *testing.T) {
+	s, db := newCountingService(t, 200*time.Millisecond, nil)
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := s.Get(first, testClusterID)
+		firstErr <- err
+	}()
+	require.Eventually(t, func() bool { return db.gets.Load() == 1 }, time.Second, time.Millisecond)
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := s.Get(context.Background(), testClusterID)
+		second <- err
+	}()
+	cancel()
+
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+	assert.NoError(t, <-second)
+	assert.Equal(t, int64(1), db.gets.Load())
+}
+
+func TestGetCachesWithJitteredTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redis, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	t.Cleanup(func() { redis.Close() })
+	s, db := newCountingService(t, 0, redis)
+
+	for range 3 {
+		c, err := s.Get(context.Background(), testClusterID)
+		require.NoError(t, err)
+		assert.Equal(t, "prod", c.Name)
+	}
+	assert.Equal(t, int64(1), db.gets.Load())
+
+	ttl := mr.TTL(cache.BuildKey(cache.PrefixCluster, testClusterID))
+	assert.GreaterOrEqual(t, ttl, clusterCacheTTL*9/10)
+	assert.LessOrEqual(t, ttl, clusterCacheTTL*11/10)
+
+	s.invalidate(context.Background(), testClusterID)
+	_, err = s.Get(context.Background(), testClusterID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), db.gets.Load())
+}
+
+func TestJitter(t *testing.T) {
+	seen := map[time.Duration]bool{}
+	for range 1000 {
+		ttl := jitter(time.Minute)
+		require.GreaterOrEqual(t, ttl, 54*time.Second)
+		require.LessOrEqual(t, ttl, 66*time.Second)
+		seen[ttl] = true
+	}
+	assert.Greater(t, len(seen), 1)
+	assert.Equal(t, time.Nanosecond, jitter(time.Nanosecond))
+}
+
+// BenchmarkGetConcurrentMisses reports how many database loads parallel
+// uncached Gets of one cluster cost
+func BenchmarkGetConcurrentMisses(b *testing.B) {
+	s, db := newCountingService(b, time.Millisecond, nil)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := s.Get(context.Background(), testClusterID); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	b.ReportMetric(float64(db.gets.Load())/float64(b.N), "loads/op")
+}
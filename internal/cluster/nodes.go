@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"context"
+	goerrors "errors"
+	"sort"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Node statuses
+const (
+	NodeReady    = "Ready"
+	NodeNotReady = "NotReady"
+	NodeUnknown  = "Unknown"
+)
+
+// NodeResources is an amount of CPU and memory
+type NodeResources struct {
+	CPUMillis   int64 `json:"cpu_millicores"`
+	MemoryBytes int64 `json:"memory_bytes"`
+}
+
+func (r NodeResources) add(o NodeResources) NodeResources {
+	return NodeResources{CPUMillis: r.CPUMillis + o.CPUMillis, MemoryBytes: r.MemoryBytes + o.MemoryBytes}
+}
+
+func (r NodeResources) sub(o NodeResources) NodeResources {
+	return NodeResources{CPUMillis: r.CPUMillis - o.CPUMillis, MemoryBytes: r.MemoryBytes - o.MemoryBytes}
+}
+
+// NodeTaint is a taint keeping pods off a node
+type NodeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// NodeInfo is the state and capacity of one node. Requested is what the
+// pods scheduled on it request; Usage is only set when metrics-server is
+// installed.
+type NodeInfo struct {
+	Name           string         `json:"name"`
+	Roles          []string       `json:"roles"`
+	Status         string         `json:"status"`
+	Unschedulable  bool           `json:"unschedulable"`
+	KubeletVersion string         `json:"kubelet_version"`
+	Capacity       NodeResources  `json:"capacity"`
+	Allocatable    NodeResources  `json:"allocatable"`
+	Requested      NodeResources  `json:"requested"`
+	Usage          *NodeResources `json:"usage,omitempty"`
+	Pods           int            `json:"pods"`
+	Taints         []NodeTaint    `json:"taints,omitempty"`
+}
+
+// CapacitySummary totals node capacity across a cluster. Headroom is what
+// is left to schedule on Ready, schedulable nodes: their allocatable less
+// what their pods request.
+type CapacitySummary struct {
+	Nodes            []NodeInfo     `json:"nodes"`
+	ReadyNodes       int            `json:"ready_nodes"`
+	Allocatable      NodeResources  `json:"allocatable"`
+	Requested        NodeResources  `json:"requested"`
+	Headroom         NodeResources  `json:"headroom"`
+	Usage            *NodeResources `json:"usage,omitempty"`
+	MetricsAvailable bool           `json:"metrics_available"`
+}
+
+// GetNodes returns the nodes of a cluster with their capacity, requests
+// and, when metrics-server is installed, usage
+func (s *Service) GetNodes(ctx context.Context, clusterID string) ([]NodeInfo, error) {
+	summary, err := s.GetCapacity(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	return summary.Nodes, nil
+}
+
+// GetCapacity returns the nodes of a cluster and how much room is left to
+// schedule on them
+func (s *Service) GetCapacity(ctx context.Context, clusterID string) (*CapacitySummary, error) {
+	client, err := s.clientByID(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := CollectCapacity(ctx, client)
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to collect node capacity")
+	}
+	return summary, nil
+}
+
+// CollectCapacity builds a capacity summary from the nodes and pods of a
+// cluster. Usage is left out when metrics-server is absent or fails.
+func CollectCapacity(ctx context.Context, client *kube.ClusterClient) (*CapacitySummary, error) {
+	nodes, err := client.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := client.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	requested := make(map[string]NodeResources)
+	podCounts := make(map[string]int)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		// Finished pods no longer hold their requests
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requested[pod.Spec.NodeName] = requested[pod.Spec.NodeName].add(podRequests(pod))
+		podCounts[pod.Spec.NodeName]++
+	}
+
+	usage, err := client.NodeMetrics(ctx)
+	if err != nil && !goerrors.Is(err, kube.ErrMetricsUnavailable) {
+		logger.Warn("Failed to get node metrics",
+			zap.String("cluster", client.Name),
+			zap.Error(err),
+		)
+	}
+
+	summary := &CapacitySummary{Nodes: make([]NodeInfo, 0, len(nodes.Items)), MetricsAvailable: usage != nil}
+	if usage != nil {
+		summary.Usage = &NodeResources{}
+	}
+	for i := range nodes.Items {
+		node := nodeInfo(&nodes.Items[i])
+		node.Requested = requested[node.Name]
+		node.Pods = podCounts[node.Name]
+		if u, ok := usage[node.Name]; ok {
+			node.Usage = &NodeResources{CPUMillis: u.CPUMillis, MemoryBytes: u.MemoryBytes}
+			*summary.Usage = summary.Usage.add(*node.Usage)
+		}
+
+		summary.Allocatable = summary.Allocatable.add(node.Allocatable)
+		summary.Requested = summary.Requested.add(node.Requested)
+		if node.Status == NodeReady {
+			summary.ReadyNodes++
+			if !node.Unschedulable {
+				summary.Headroom = summary.Headroom.add(node.Allocatable.sub(node.Requested))
+			}
+		}
+		summary.Nodes = append(summary.Nodes, node)
+	}
+	sort.Slice(summary.Nodes, func(i, j int) bool { return summary.Nodes[i].Name < summary.Nodes[j].Name })
+	return summary, nil
+}
+
+// nodeInfo reads the state and capacity of a node
+func nodeInfo(node *corev1.Node) NodeInfo {
+	info := NodeInfo{
+		Name:           node.Name,
+		Roles:          nodeRoles(node),
+		Status:         NodeUnknown,
+		Unschedulable:  node.Spec.Unschedulable,
+		KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+		Capacity:       listResources(node.Status.Capacity),
+		Allocatable:    listResources(node.Status.Allocatable),
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type != corev1.NodeReady {
+			continue
+		}
+		switch cond.Status {
+		case corev1.ConditionTrue:
+			info.Status = NodeReady
+		case corev1.ConditionFalse:
+			info.Status = NodeNotReady
+		}
+	}
+	for _, taint := range node.Spec.Taints {
+		info.Taints = append(info.Taints, NodeTaint{Key: taint.Key, Value: taint.Value, Effect: string(taint.Effect)})
+	}
+	return info
+}
+
+// nodeRoles reads the roles a node is labelled with
+func nodeRoles(node *corev1.Node) []string {
+	roles := []string{}
+	for label, value := range node.Labels {
+		switch {
+		case strings.HasPrefix(label, "node-role.kubernetes.io/"):
+			if role := strings.TrimPrefix(label, "node-role.kubernetes.io/"); role != "" {
+				roles = append(roles, role)
+			}
+		case label == "kubernetes.io/role" && value != "":
+			roles = append(roles, value)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// podRequests is what the scheduler reserves for a pod: the larger of its
+// containers' total and its largest init container, plus pod overhead
+func podRequests(pod *corev1.Pod) NodeResources {
+	var total NodeResources
+	for _, c := range pod.Spec.Containers {
+		total = total.add(listResources(c.Resources.Requests))
+	}
+	for _, c := range pod.Spec.InitContainers {
+		init := listResources(c.Resources.Requests)
+		total.CPUMillis = max(total.CPUMillis, init.CPUMillis)
+		total.MemoryBytes = max(total.MemoryBytes, init.MemoryBytes)
+	}
+	return total.add(listResources(pod.Spec.Overhead))
+}
+
+func listResources(list corev1.ResourceList) NodeResources {
+	var r NodeResources
+	if cpu, ok := list[corev1.ResourceCPU]; ok {
+		r.CPUMillis = cpu.MilliValue()
+	}
+	if memory, ok := list[corev1.ResourceMemory]; ok {
+		r.MemoryBytes = memory.Value()
+	}
+	return r
+}
@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func resources(cpu, memory string) corev1.ResourceList {
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse(cpu),
+		corev1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func testNode(name string, ready corev1.ConditionStatus, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{
+			Capacity:    resources("4", "16Gi"),
+			Allocatable: resources("3800m", "15Gi"),
+			Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			NodeInfo:    corev1.NodeSystemInfo{KubeletVersion: "v1.33.1"},
+		},
+	}
+}
+
+func scheduledPod(name, node string, phase corev1.PodPhase, requests corev1.ResourceList) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+		Spec: corev1.PodSpec{
+			NodeName:   node,
+			Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{Requests: requests}}},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func nodeMetrics(name, cpu, memory string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "metrics.k8s.io/v1beta1",
+		"kind":       "NodeMetrics",
+		"metadata":   map[string]interface{}{"name": name},
+		"usage":      map[string]interface{}{"cpu": cpu, "memory": memory},
+	}}
+}
+
+func capacityClient(metrics ...*unstructured.Unstructured) *kube.ClusterClient {
+	control := testNode("cp-1", corev1.ConditionTrue, map[string]string{"node-role.kubernetes.io/control-plane": ""})
+	control.Spec.Taints = []corev1.Taint{{Key: "node-role.kubernetes.io/control-plane", Effect: corev1.TaintEffectNoSchedule}}
+	cordoned := testNode("worker-2", corev1.ConditionTrue, map[string]string{"kubernetes.io/role": "worker"})
+	cordoned.Spec.Unschedulable = true
+
+	withInit := scheduledPod("migrate", "worker-1", corev1.PodRunning, resources("100m", "128Mi"))
+	withInit.Spec.InitContainers = []corev1.Container{{Name: "init", Resources: corev1.ResourceRequirements{Requests: resources("1", "64Mi")}}}
+
+	clientset := fake.NewSimpleClientset(
+		control,
+		testNode("worker-1", corev1.ConditionTrue, map[string]string{"node-role.kubernetes.io/worker": ""}),
+		cordoned,
+		testNode("worker-3", corev1.ConditionFalse, nil),
+		scheduledPod("web", "worker-1", corev1.PodRunning, resources("500m", "1Gi")),
+		withInit,
+		scheduledPod("job", "worker-1", corev1.PodSucceeded, resources("2", "4Gi")),
+		scheduledPod("pending", "", corev1.PodPending, resources("2", "4Gi")),
+		scheduledPod("api", "worker-2", corev1.PodRunning, resources("1", "2Gi")),
+	)
+
+	// The fake tracker would file NodeMetrics under a guessed resource,
+	// so metrics are listed from a reactor
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{kube.NodeMetricsResource: "NodeMetricsList"})
+	dynamicClient.PrependReactor("list", "nodes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if metrics == nil {
+			// Without metrics-server the API server does not serve the group
+			return true, nil, apierrors.NewNotFound(kube.NodeMetricsResource.GroupResource(), "")
+		}
+		list := &unstructured.UnstructuredList{}
+		for _, m := range metrics {
+			list.Items = append(list.Items, *m)
+		}
+		return true, list, nil
+	})
+	return &kube.ClusterClient{Name: "prod", Clientset: clientset, DynamicClient: dynamicClient}
+}
+
+func TestCollectCapacity(t *testing.T) {
+	summary, err := CollectCapacity(context.Background(), capacityClient(
+		nodeMetrics("worker-1", "1200m", "3Gi"),
+		nodeMetrics("cp-1", "250m", "1Gi"),
+	))
+	require.NoError(t, err)
+
+	require.Len(t, summary.Nodes, 4)
+	cp, worker := summary.Nodes[0], summary.Nodes[1]
+	assert.Equal(t, "cp-1", cp.Name)
+	assert.Equal(t, []string{"control-plane"}, cp.Roles)
+	assert.Equal(t, NodeReady, cp.Status)
+	assert.Equal(t, "v1.33.1", cp.KubeletVersion)
+	assert.Equal(t, []NodeTaint{{Key: "node-role.kubernetes.io/control-plane", Effect: "NoSchedule"}}, cp.Taints)
+	assert.Equal(t, NodeResources{CPUMillis: 4000, MemoryBytes: 16 << 30}, cp.Capacity)
+	assert.Equal(t, NodeResources{CPUMillis: 3800, MemoryBytes: 15 << 30}, cp.Allocatable)
+
+	assert.Equal(t, []string{"worker"}, worker.Roles)
+	assert.Equal(t, 2, worker.Pods, "finished pods are not counted")
+	assert.Equal(t, NodeResources{CPUMillis: 1500, MemoryBytes: 1<<30 + 128<<20}, worker.Requested,
+		"an init container larger than the app containers sets the request")
+	require.NotNil(t, worker.Usage)
+	assert.Equal(t, NodeResources{CPUMillis: 1200, MemoryBytes: 3 << 30}, *worker.Usage)
+
+	assert.Equal(t, []string{"worker"}, summary.Nodes[2].Roles)
+	assert.True(t, summary.Nodes[2].Unschedulable)
+	assert.Nil(t, summary.Nodes[2].Usage)
+	assert.Equal(t, NodeNotReady, summary.Nodes[3].Status)
+
+	assert.Equal(t, 3, summary.ReadyNodes)
+	assert.Equal(t, NodeResources{CPUMillis: 4 * 3800, MemoryBytes: 4 * 15 << 30}, summary.Allocatable)
+	assert.Equal(t, NodeResources{CPUMillis: 2500, MemoryBytes: 3<<30 + 128<<20}, summary.Requested)
+	assert.Equal(t, NodeResources{CPUMillis: 2*3800 - 1500, MemoryBytes: 30<<30 - (1<<30 + 128<<20)}, summary.Headroom,
+		"only ready, schedulable nodes leave headroom")
+	assert.True(t, summary.MetricsAvailable)
+	assert.Equal(t, &NodeResources{CPUMillis: 1450, MemoryBytes: 4 << 30}, summary.Usage)
+}
+
+func TestCollectCapacityWithoutMetrics(t *testing.T) {
+	summary, err := CollectCapacity(context.Background(), capacityClient())
+	require.NoError(t, err)
+
+	assert.False(t, summary.MetricsAvailable)
+	assert.Nil(t, summary.Usage)
+	for _, node := range summary.Nodes {
+		assert.Nil(t, node.Usage, node.Name)
+	}
+	assert.Equal(t, NodeResources{CPUMillis: 2500, MemoryBytes: 3<<30 + 128<<20}, summary.Requested)
+}
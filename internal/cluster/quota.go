@@ -0,0 +1,381 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Names of the ResourceQuota and LimitRange set when none is given
+	defaultQuotaName      = "krustron-quota"
+	defaultLimitRangeName = "krustron-limits"
+
+	// quotaUsageWindow is how far back RecommendQuota looks at usage
+	quotaUsageWindow = 7 * 24 * time.Hour
+	// quotaHeadroom is how far above peak usage a recommended quota sits
+	quotaHeadroom = 1.25
+	// quotaLimitRatio is how far recommended limits sit above requests
+	quotaLimitRatio = 2
+)
+
+// UsageSource reports what the workloads of a namespace used recently.
+// The cost service implements it from its cost allocations.
+type UsageSource interface {
+	// PeakNamespaceUsage returns the most CPU cores and memory GiB the
+	// namespace on the named cluster used at once since the given time
+	PeakNamespaceUsage(ctx context.Context, cluster, namespace string, since time.Time) (cpuCores, memoryGiB float64, err error)
+}
+
+// SetUsageSource wires the usage RecommendQuota sizes quotas from.
+// Optional: without it, quotas are sized from current pod requests.
+func (s *Service) SetUsageSource(u UsageSource) { s.usage = u }
+
+// SetAuditRecorder sets where namespace guardrail changes are audited
+func (s *Service) SetAuditRecorder(r *audit.Recorder) { s.recorder = r }
+
+// ResourceQuotaSpec is a ResourceQuota to set on a namespace
+type ResourceQuotaSpec struct {
+	// Name defaults to krustron-quota
+	Name string              `json:"name"`
+	Hard corev1.ResourceList `json:"hard"`
+	// Force sets the quota even where usage already exceeds it
+	Force bool `json:"force"`
+}
+
+// LimitRangeSpec is a LimitRange to set on a namespace
+type LimitRangeSpec struct {
+	// Name defaults to krustron-limits
+	Name   string                  `json:"name"`
+	Limits []corev1.LimitRangeItem `json:"limits"`
+}
+
+// QuotaViolation is a resource a namespace already uses more of than a
+// quota allows
+type QuotaViolation struct {
+	Resource string            `json:"resource"`
+	Used     resource.Quantity `json:"used"`
+	Hard     resource.Quantity `json:"hard"`
+}
+
+// Sources of a quota recommendation
+const (
+	QuotaBasisCostAllocations = "cost_allocations"
+	QuotaBasisPodRequests     = "pod_requests"
+)
+
+// QuotaRecommendation is a suggested quota for a namespace. It is sized
+// from peak usage over the window or current pod requests, whichever is
+// larger, plus headroom.
+type QuotaRecommendation struct {
+	Namespace     string              `json:"namespace"`
+	Hard          corev1.ResourceList `json:"hard"`
+	Basis         string              `json:"basis"`
+	PeakCPUCores  float64             `json:"peak_cpu_cores"`
+	PeakMemoryGiB float64             `json:"peak_memory_gib"`
+	Requested     NodeResources       `json:"requested"`
+	Since         time.Time           `json:"since"`
+}
+
+// ListResourceQuotas returns the ResourceQuotas of a namespace with what
+// they allow and what is used
+func (s *Service) ListResourceQuotas(ctx context.Context, clusterID, namespace string) ([]corev1.ResourceQuota, error) {
+	_, clientset, err := s.namespaceClient(ctx, clusterID, namespace)
+	if err != nil {
+		return nil, err
+	}
+	list, err := clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to list resource quotas")
+	}
+	return list.Items, nil
+}
+
+// ListLimitRanges returns the LimitRanges of a namespace
+func (s *Service) ListLimitRanges(ctx context.Context, clusterID, namespace string) ([]corev1.LimitRange, error) {
+	_, clientset, err := s.namespaceClient(ctx, clusterID, namespace)
+	if err != nil {
+		return nil, err
+	}
+	list, err := clientset.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to list limit ranges")
+	}
+	return list.Items, nil
+}
+
+// CheckResourceQuota returns the resources the namespace already uses more
+// of than hard allows. Usage is what the namespace's quotas report, or
+// what its running pods request and limit where that is more.
+func (s *Service) CheckResourceQuota(ctx context.Context, clusterID, namespace string, hard corev1.ResourceList) ([]QuotaViolation, error) {
+	_, clientset, err := s.namespaceClient(ctx, clusterID, namespace)
+	if err != nil {
+		return nil, err
+	}
+	used, err := namespaceUsage(ctx, clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return quotaViolations(used, hard), nil
+}
+
+// SetResourceQuota creates or updates a ResourceQuota on a namespace. It
+// is refused, naming the violated resources, when usage already exceeds
+// it, unless spec.Force is set.
+func (s *Service) SetResourceQuota(ctx context.Context, clusterID, namespace string, spec ResourceQuotaSpec, userID string) (*corev1.ResourceQuota, error) {
+	if len(spec.Hard) == 0 {
+		return nil, errors.Validation("quota sets no limits")
+	}
+	if spec.Name == "" {
+		spec.Name = defaultQuotaName
+	}
+	cluster, clientset, err := s.namespaceClient(ctx, clusterID, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := namespaceUsage(ctx, clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if violations := quotaViolations(used, spec.Hard); len(violations) > 0 && !spec.Force {
+		appErr := errors.Validation(fmt.Sprintf("usage in namespace %s already exceeds the quota", namespace))
+		var details []string
+		for _, v := range violations {
+			appErr.WithMeta(v.Resource, fmt.Sprintf("used %s, hard %s", v.Used.String(), v.Hard.String()))
+			details = append(details, v.Resource)
+		}
+		return nil, appErr.WithDetails("exceeded: " + strings.Join(details, ", "))
+	}
+
+	quotas := clientset.CoreV1().ResourceQuotas(namespace)
+	var before map[string]interface{}
+	quota, err := quotas.Get(ctx, spec.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		quota, err = quotas.Create(ctx, &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: spec.Name, Namespace: namespace, Labels: managedBy()},
+			Spec:       corev1.ResourceQuotaSpec{Hard: spec.Hard},
+		}, metav1.CreateOptions{})
+	case err == nil:
+		before = audit.Snapshot(quota.Spec)
+		quota.Spec.Hard = spec.Hard
+		quota, err = quotas.Update(ctx, quota, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to set resource quota")
+	}
+
+	s.recordGuardrail(ctx, cluster, userID, "resource_quota.set", "resource_quota", namespace+"/"+spec.Name, before, audit.Snapshot(quota.Spec))
+	return quota, nil
+}
+
+// SetLimitRange creates or updates a LimitRange on a namespace
+func (s *Service) SetLimitRange(ctx context.Context, clusterID, namespace string, spec LimitRangeSpec, userID string) (*corev1.LimitRange, error) {
+	if len(spec.Limits) == 0 {
+		return nil, errors.Validation("limit range sets no limits")
+	}
+	if spec.Name == "" {
+		spec.Name = defaultLimitRangeName
+	}
+	cluster, clientset, err := s.namespaceClient(ctx, clusterID, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := clientset.CoreV1().LimitRanges(namespace)
+	var before map[string]interface{}
+	limitRange, err := ranges.Get(ctx, spec.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		limitRange, err = ranges.Create(ctx, &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: spec.Name, Namespace: namespace, Labels: managedBy()},
+			Spec:       corev1.LimitRangeSpec{Limits: spec.Limits},
+		}, metav1.CreateOptions{})
+	case err == nil:
+		before = audit.Snapshot(limitRange.Spec)
+		limitRange.Spec.Limits = spec.Limits
+		limitRange, err = ranges.Update(ctx, limitRange, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to set limit range")
+	}
+
+	s.recordGuardrail(ctx, cluster, userID, "limit_range.set", "limit_range", namespace+"/"+spec.Name, before, audit.Snapshot(limitRange.Spec))
+	return limitRange, nil
+}
+
+// RecommendQuota suggests a quota for a namespace from its peak usage over
+// the last week, as recorded by the usage source, or what its pods request
+// now where that is more
+func (s *Service) RecommendQuota(ctx context.Context, clusterID, namespace string) (*QuotaRecommendation, error) {
+	cluster, clientset, err := s.namespaceClient(ctx, clusterID, namespace)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to list pods")
+	}
+
+	rec := &QuotaRecommendation{Namespace: namespace, Basis: QuotaBasisPodRequests, Since: time.Now().Add(-quotaUsageWindow)}
+	for i := range pods.Items {
+		if podActive(&pods.Items[i]) {
+			rec.Requested = rec.Requested.add(podRequests(&pods.Items[i]))
+		}
+	}
+	if s.usage != nil {
+		rec.PeakCPUCores, rec.PeakMemoryGiB, err = s.usage.PeakNamespaceUsage(ctx, cluster.Name, namespace, rec.Since)
+		if err != nil {
+			return nil, errors.InternalWrap(err, "failed to get namespace usage")
+		}
+	}
+
+	cpuMillis := max(int64(math.Ceil(rec.PeakCPUCores*1000)), rec.Requested.CPUMillis)
+	memoryBytes := max(int64(math.Ceil(rec.PeakMemoryGiB*(1<<30))), rec.Requested.MemoryBytes)
+	if cpuMillis == 0 && memoryBytes == 0 {
+		return nil, errors.Validation(fmt.Sprintf("no usage recorded for namespace %s to size a quota from", namespace))
+	}
+	if rec.PeakCPUCores*1000 >= float64(rec.Requested.CPUMillis) && rec.PeakMemoryGiB*(1<<30) >= float64(rec.Requested.MemoryBytes) {
+		rec.Basis = QuotaBasisCostAllocations
+	}
+
+	// Round up to a tenth of a core and 64Mi so quotas read cleanly
+	cpuMillis = roundUp(int64(float64(cpuMillis)*quotaHeadroom), 100)
+	memoryBytes = roundUp(int64(float64(memoryBytes)*quotaHeadroom), 64<<20)
+	rec.Hard = corev1.ResourceList{
+		corev1.ResourceRequestsCPU:    *resource.NewMilliQuantity(cpuMillis, resource.DecimalSI),
+		corev1.ResourceRequestsMemory: *resource.NewQuantity(memoryBytes, resource.BinarySI),
+		corev1.ResourceLimitsCPU:      *resource.NewMilliQuantity(cpuMillis*quotaLimitRatio, resource.DecimalSI),
+		corev1.ResourceLimitsMemory:   *resource.NewQuantity(memoryBytes*quotaLimitRatio, resource.BinarySI),
+	}
+	return rec, nil
+}
+
+// namespaceClient returns the cluster id and its clientset after checking
+// namespace is named
+func (s *Service) namespaceClient(ctx context.Context, clusterID, namespace string) (*Cluster, kubernetes.Interface, error) {
+	if namespace == "" {
+		return nil, nil, errors.Validation("namespace is required")
+	}
+	cluster, err := s.Get(ctx, clusterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := s.kubeManager.GetClient(cluster.Name)
+	if err != nil {
+		return nil, nil, errors.ClusterWrap(err, "failed to get cluster client")
+	}
+	return cluster, client.Clientset, nil
+}
+
+// recordGuardrail audits a change of a namespace quota or limit range. The
+// change is already made, so failures are only logged.
+func (s *Service) recordGuardrail(ctx context.Context, cluster *Cluster, userID, action, resourceType, resourceID string, before, after map[string]interface{}) {
+	err := s.recorder.Record(ctx, audit.Entry{
+		UserID:       userID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		ClusterID:    cluster.ID,
+		ClusterName:  cluster.Name,
+		OldValue:     before,
+		NewValue:     after,
+	})
+	if err != nil {
+		logger.Warn("Failed to record audit log",
+			zap.String("action", action),
+			zap.String("resource_id", resourceID),
+			zap.Error(err),
+		)
+	}
+}
+
+// namespaceUsage is what the namespace uses of each quota resource: the
+// most any of its quotas reports, or what its active pods add up to where
+// that is more, so usage counts before any quota exists
+func namespaceUsage(ctx context.Context, clientset kubernetes.Interface, namespace string) (corev1.ResourceList, error) {
+	used := corev1.ResourceList{}
+	raise := func(name corev1.ResourceName, q resource.Quantity) {
+		if current, ok := used[name]; !ok || q.Cmp(current) > 0 {
+			used[name] = q
+		}
+	}
+
+	quotas, err := clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to list resource quotas")
+	}
+	for _, quota := range quotas.Items {
+		for name, q := range quota.Status.Used {
+			raise(name, q)
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to list pods")
+	}
+	var requests, limits NodeResources
+	var count int64
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !podActive(pod) {
+			continue
+		}
+		count++
+		requests = requests.add(podRequests(pod))
+		for _, c := range pod.Spec.Containers {
+			limits = limits.add(listResources(c.Resources.Limits))
+		}
+	}
+	raise(corev1.ResourcePods, *resource.NewQuantity(count, resource.DecimalSI))
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceRequestsCPU} {
+		raise(name, *resource.NewMilliQuantity(requests.CPUMillis, resource.DecimalSI))
+	}
+	for _, name := range []corev1.ResourceName{corev1.ResourceMemory, corev1.ResourceRequestsMemory} {
+		raise(name, *resource.NewQuantity(requests.MemoryBytes, resource.BinarySI))
+	}
+	raise(corev1.ResourceLimitsCPU, *resource.NewMilliQuantity(limits.CPUMillis, resource.DecimalSI))
+	raise(corev1.ResourceLimitsMemory, *resource.NewQuantity(limits.MemoryBytes, resource.BinarySI))
+	return used, nil
+}
+
+// quotaViolations returns the resources of hard that used exceeds
+func quotaViolations(used, hard corev1.ResourceList) []QuotaViolation {
+	var violations []QuotaViolation
+	for name, limit := range hard {
+		if q, ok := used[name]; ok && q.Cmp(limit) > 0 {
+			violations = append(violations, QuotaViolation{Resource: string(name), Used: q, Hard: limit})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Resource < violations[j].Resource })
+	return violations
+}
+
+// podActive reports whether a pod still counts against quota
+func podActive(pod *corev1.Pod) bool {
+	return pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+// managedBy labels the guardrails set from the platform
+func managedBy() map[string]string {
+	return map[string]string{"app.kubernetes.io/managed-by": "krustron"}
+}
+
+func roundUp(n, step int64) int64 {
+	return (n + step - 1) / step * step
+}
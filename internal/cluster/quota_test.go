@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// peakUsage is a UsageSource reporting fixed peaks
+type peakUsage struct{ cpu, memory float64 }
+
+func (p peakUsage) PeakNamespaceUsage(ctx context.Context, cluster, namespace string, since time.Time) (float64, float64, error) {
+	return p.cpu, p.memory, nil
+}
+
+// newQuotaService is an apply service whose prod cluster runs two pods in
+// namespace shop requesting 1500m CPU and 3Gi, with changes audited
+func newQuotaService(t *testing.T) (*Service, *applyCluster) {
+	t.Helper()
+	s, cluster := newApplyService(t)
+	s.SetAuditRecorder(audittest.NewRecorder(t, s.db.DB))
+
+	ctx := context.Background()
+	for _, pod := range []*corev1.Pod{
+		scheduledPod("web", "node-a", corev1.PodRunning, resources("1", "2Gi")),
+		scheduledPod("worker", "node-a", corev1.PodRunning, resources("500m", "1Gi")),
+		// Finished pods no longer count
+		scheduledPod("migrate", "node-a", corev1.PodSucceeded, resources("4", "8Gi")),
+	} {
+		_, err := cluster.clientset.CoreV1().Pods("shop").Create(ctx, pod, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	return s, cluster
+}
+
+func TestSetResourceQuotaCreatesAndUpdates(t *testing.T) {
+	s, _ := newQuotaService(t)
+	ctx := context.Background()
+
+	quota, err := s.SetResourceQuota(ctx, testClusterID, "shop", ResourceQuotaSpec{
+		Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("2")},
+	}, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, defaultQuotaName, quota.Name)
+	assert.Equal(t, "krustron", quota.Labels["app.kubernetes.io/managed-by"])
+
+	_, err = s.SetResourceQuota(ctx, testClusterID, "shop", ResourceQuotaSpec{
+		Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("4"), corev1.ResourcePods: resource.MustParse("10")},
+	}, "alice")
+	require.NoError(t, err)
+
+	quotas, err := s.ListResourceQuotas(ctx, testClusterID, "shop")
+	require.NoError(t, err)
+	require.Len(t, quotas, 1)
+	hard := quotas[0].Spec.Hard
+	assert.True(t, hard[corev1.ResourceRequestsCPU].Equal(resource.MustParse("4")))
+	assert.True(t, hard[corev1.ResourcePods].Equal(resource.MustParse("10")))
+
+	// Both changes are audited, the update with what it replaced
+	rows, err := s.db.Query(`SELECT action, resource_id, user_id, cluster_name, old_value IS NULL FROM audit_logs ORDER BY seq`)
+	require.NoError(t, err)
+	defer rows.Close()
+	var created []bool
+	for rows.Next() {
+		var action, resourceID, userID, clusterName string
+		var noOld bool
+		require.NoError(t, rows.Scan(&action, &resourceID, &userID, &clusterName, &noOld))
+		assert.Equal(t, "resource_quota.set", action)
+		assert.Equal(t, "shop/"+defaultQuotaName, resourceID)
+		assert.Equal(t, "alice", userID)
+		assert.Equal(t, "prod", clusterName)
+		created = append(created, noOld)
+	}
+	assert.Equal(t, []bool{true, false}, created)
+}
+
+func TestSetLimitRangeCreatesAndUpdates(t *testing.T) {
+	s, _ := newQuotaService(t)
+	ctx := context.Background()
+
+	spec := LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+		Type:           corev1.LimitTypeContainer,
+		DefaultRequest: resources("100m", "128Mi"),
+	}}}
+	_, err := s.SetLimitRange(ctx, testClusterID, "shop", spec, "alice")
+	require.NoError(t, err)
+
+	spec.Limits[0].Default = resources("500m", "512Mi")
+	_, err = s.SetLimitRange(ctx, testClusterID, "shop", spec, "alice")
+	require.NoError(t, err)
+
+	ranges, err := s.ListLimitRanges(ctx, testClusterID, "shop")
+	require.NoError(t, err)
+	require.Len(t, ranges, 1)
+	assert.Equal(t, defaultLimitRangeName, ranges[0].Name)
+	assert.True(t, ranges[0].Spec.Limits[0].Default.Cpu().Equal(resource.MustParse("500m")))
+
+	var entries int
+	require.NoError(t, s.db.QueryRow(`SELECT COUNT(*) FROM audit_logs WHERE action = 'limit_range.set'`).Scan(&entries))
+	assert.Equal(t, 2, entries)
+
+	_, err = s.SetLimitRange(ctx, testClusterID, "shop", LimitRangeSpec{}, "alice")
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
+
+func TestQuotaViolations(t *testing.T) {
+	s, cluster := newQuotaService(t)
+	ctx := context.Background()
+
+	hard := corev1.ResourceList{
+		corev1.ResourceRequestsCPU:    resource.MustParse("1"),
+		corev1.ResourceRequestsMemory: resource.MustParse("4Gi"),
+		corev1.ResourcePods:           resource.MustParse("1"),
+		corev1.ResourceServices:       resource.MustParse("2"),
+	}
+	violations, err := s.CheckResourceQuota(ctx, testClusterID, "shop", hard)
+	require.NoError(t, err)
+	require.Len(t, violations, 2)
+	assert.Equal(t, "pods", violations[0].Resource)
+	assert.True(t, violations[0].Used.Equal(resource.MustParse("2")))
+	assert.Equal(t, "requests.cpu", violations[1].Resource)
+	assert.True(t, violations[1].Used.Equal(resource.MustParse("1500m")))
+
+	// Usage an existing quota reports counts too
+	_, err = cluster.clientset.CoreV1().ResourceQuotas("shop").Create(ctx, &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "objects", Namespace: "shop"},
+		Status:     corev1.ResourceQuotaStatus{Used: corev1.ResourceList{corev1.ResourceServices: resource.MustParse("3")}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	violations, err = s.CheckResourceQuota(ctx, testClusterID, "shop", hard)
+	require.NoError(t, err)
+	assert.Len(t, violations, 3)
+
+	// Setting the quota is refused, naming what it would violate
+	_, err = s.SetResourceQuota(ctx, testClusterID, "shop", ResourceQuotaSpec{Hard: hard}, "alice")
+	require.True(t, errors.Is(err, errors.CodeValidation))
+	appErr := errors.ToAppError(err)
+	assert.Contains(t, appErr.Meta, "requests.cpu")
+	assert.Contains(t, appErr.Meta, "services")
+	assert.NotContains(t, appErr.Meta, "requests.memory")
+	_, err = cluster.clientset.CoreV1().ResourceQuotas("shop").Get(ctx, defaultQuotaName, metav1.GetOptions{})
+	assert.Error(t, err)
+
+	// unless forced
+	_, err = s.SetResourceQuota(ctx, testClusterID, "shop", ResourceQuotaSpec{Hard: hard, Force: true}, "alice")
+	require.NoError(t, err)
+}
+
+func TestRecommendQuota(t *testing.T) {
+	s, _ := newQuotaService(t)
+	ctx := context.Background()
+
+	// Without recorded usage the quota is sized from pod requests
+	rec, err := s.RecommendQuota(ctx, testClusterID, "shop")
+	require.NoError(t, err)
+	assert.Equal(t, QuotaBasisPodRequests, rec.Basis)
+	assert.Equal(t, "1900m", quantity(rec.Hard, corev1.ResourceRequestsCPU))
+	assert.Equal(t, "3840Mi", quantity(rec.Hard, corev1.ResourceRequestsMemory))
+	assert.Equal(t, "3800m", quantity(rec.Hard, corev1.ResourceLimitsCPU))
+
+	// Peak usage above the requests sizes it instead
+	s.SetUsageSource(peakUsage{cpu: 4, memory: 2})
+	rec, err = s.RecommendQuota(ctx, testClusterID, "shop")
+	require.NoError(t, err)
+	assert.Equal(t, "5", quantity(rec.Hard, corev1.ResourceRequestsCPU))
+	assert.Equal(t, "3840Mi", quantity(rec.Hard, corev1.ResourceRequestsMemory))
+
+	// The recommendation never violates current usage
+	violations, err := s.CheckResourceQuota(ctx, testClusterID, "shop", rec.Hard)
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+
+	s.SetUsageSource(peakUsage{})
+	_, err = s.RecommendQuota(ctx, testClusterID, "empty")
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
+
+func quantity(list corev1.ResourceList, name corev1.ResourceName) string {
+	q := list[name]
+	return q.String()
+}
@@ -0,0 +1,242 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	defaultSearchConcurrency = 10
+	// searchTimeout bounds one cluster's search so an unreachable API
+	// server doesn't hold up the whole result
+	searchTimeout = 15 * time.Second
+	// searchCacheTTL is how long search results are cached
+	searchCacheTTL = 30 * time.Second
+)
+
+// Kinds SearchResources can search
+const (
+	KindPod        = "Pod"
+	KindDeployment = "Deployment"
+	KindService    = "Service"
+)
+
+var searchKinds = []string{KindPod, KindDeployment, KindService}
+
+// SearchQuery selects resources across clusters. Empty fields match
+// everything, but at least one of Name and LabelSelector is required.
+type SearchQuery struct {
+	// Name matches resources whose name contains it, case-insensitively
+	Name string `json:"name,omitempty"`
+	// LabelSelector is a Kubernetes label selector, e.g. "app=web,tier!=db"
+	LabelSelector string `json:"label_selector,omitempty"`
+	// Kinds limits the search to Pod, Deployment and/or Service
+	Kinds []string `json:"kinds,omitempty"`
+	// Namespace limits the search to one namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Concurrency caps how many clusters are searched at once; defaults to 10
+	Concurrency int `json:"-"`
+}
+
+// ResourceMatch is a resource found by SearchResources
+type ResourceMatch struct {
+	Cluster   string            `json:"cluster"`
+	Namespace string            `json:"namespace"`
+	Kind      string            `json:"kind"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Status    string            `json:"status,omitempty"`
+}
+
+// ClusterSearchError is a cluster SearchResources couldn't search
+type ClusterSearchError struct {
+	Cluster string `json:"cluster"`
+	Error   string `json:"error"`
+}
+
+// SearchResult is the outcome of SearchResources. Failed lists the
+// clusters that couldn't be searched; Matches holds what the others
+// returned.
+type SearchResult struct {
+	Matches  []ResourceMatch      `json:"matches"`
+	Failed   []ClusterSearchError `json:"failed,omitempty"`
+	Searched int                  `json:"searched"`
+}
+
+// SearchResources searches every connected cluster for pods, deployments
+// and services matching query. Clusters that fail are listed in the
+// result rather than failing the search. Results are cached briefly.
+func (s *Service) SearchResources(ctx context.Context, query SearchQuery) (*SearchResult, error) {
+	if query.Name == "" && query.LabelSelector == "" {
+		return nil, errors.Validation("name or label_selector is required")
+	}
+	selector, err := labels.Parse(query.LabelSelector)
+	if err != nil {
+		return nil, errors.Validation(fmt.Sprintf("invalid label selector: %v", err))
+	}
+	kinds, err := searchKindsFor(query.Kinds)
+	if err != nil {
+		return nil, err
+	}
+	query.Kinds = kinds
+
+	cacheKey := searchCacheKey(query)
+	if s.cache != nil {
+		var cached SearchResult
+		if err := s.cache.Get(ctx, cacheKey, &cached); err == nil {
+			return &cached, nil
+		}
+	}
+
+	names := s.kubeManager.ListClusters()
+	sort.Strings(names)
+	concurrency := query.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultSearchConcurrency
+	}
+
+	result := &SearchResult{Matches: []ResourceMatch{}, Searched: len(names)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			matches, err := s.searchCluster(ctx, name, query, selector)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed = append(result.Failed, ClusterSearchError{Cluster: name, Error: err.Error()})
+				return
+			}
+			result.Matches = append(result.Matches, matches...)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result.Matches, func(i, j int) bool {
+		a, b := result.Matches[i], result.Matches[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Cluster < result.Failed[j].Cluster })
+
+	if s.cache != nil {
+		s.cache.Set(ctx, cacheKey, result, searchCacheTTL)
+	}
+	return result, nil
+}
+
+// searchCluster lists the queried kinds in one cluster and keeps those
+// whose name matches; the label selector is applied by the API server
+func (s *Service) searchCluster(ctx context.Context, name string, query SearchQuery, selector labels.Selector) ([]ResourceMatch, error) {
+	client, err := s.kubeManager.GetClient(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+
+	opts := kube.ListOptions{LabelSelector: selector.String()}
+	var matches []ResourceMatch
+	add := func(kind string, meta metav1.ObjectMeta, status string) {
+		if query.Name != "" && !strings.Contains(strings.ToLower(meta.Name), strings.ToLower(query.Name)) {
+			return
+		}
+		matches = append(matches, ResourceMatch{
+			Cluster: name, Namespace: meta.Namespace, Kind: kind, Name: meta.Name,
+			Labels: meta.Labels, Status: status,
+		})
+	}
+
+	for _, kind := range query.Kinds {
+		switch kind {
+		case KindPod:
+			list, err := client.ListPods(ctx, query.Namespace, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list pods: %w", err)
+			}
+			for _, pod := range list.Items {
+				add(kind, pod.ObjectMeta, string(pod.Status.Phase))
+			}
+		case KindDeployment:
+			list, err := client.ListDeployments(ctx, query.Namespace, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list deployments: %w", err)
+			}
+			for _, d := range list.Items {
+				add(kind, d.ObjectMeta, fmt.Sprintf("%d/%d ready", d.Status.ReadyReplicas, d.Status.Replicas))
+			}
+		case KindService:
+			list, err := client.ListServices(ctx, query.Namespace, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list services: %w", err)
+			}
+			for _, svc := range list.Items {
+				add(kind, svc.ObjectMeta, string(svc.Spec.Type))
+			}
+		}
+	}
+	return matches, nil
+}
+
+// searchKindsFor normalizes the requested kinds; none means all
+func searchKindsFor(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return searchKinds, nil
+	}
+	seen := make(map[string]bool)
+	var kinds []string
+	for _, r := range requested {
+		kind := ""
+		for _, k := range searchKinds {
+			if strings.EqualFold(r, k) || strings.EqualFold(r, k+"s") {
+				kind = k
+			}
+		}
+		if kind == "" {
+			return nil, errors.Validation(fmt.Sprintf("unsupported kind %q: must be one of %s", r, strings.Join(searchKinds, ", ")))
+		}
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds, nil
+}
+
+// searchCacheKey identifies a normalized query in the cache
+func searchCacheKey(query SearchQuery) string {
+	data, _ := json.Marshal(query)
+	sum := sha256.Sum256(data)
+	return cache.BuildKey(cache.PrefixCluster, "search", hex.EncodeToString(sum[:8]))
+}
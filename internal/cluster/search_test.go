@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newSearchService(t *testing.T, clients map[string]*fake.Clientset) *Service {
+	t.Helper()
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	for name, clientset := range clients {
+		manager.AddClient(&kube.ClusterClient{Name: name, Clientset: clientset, Connected: true})
+	}
+	return NewService(nil, manager, nil)
+}
+
+func meta(namespace, name string, labels map[string]string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels}
+}
+
+func TestSearchResources(t *testing.T) {
+	web := map[string]string{"app": "web"}
+	prod := fake.NewSimpleClientset([]runtime.Object{
+		&corev1.Pod{ObjectMeta: meta("shop", "web-7d9f", web), Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		&corev1.Pod{ObjectMeta: meta("shop", "db-0", map[string]string{"app": "db"})},
+		&appsv1.Deployment{ObjectMeta: meta("shop", "web", web)},
+		&corev1.Service{ObjectMeta: meta("shop", "web", web), Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP}},
+	}...)
+	staging := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: meta("default", "WEB-1", web), Status: corev1.PodStatus{Phase: corev1.PodPending}},
+	)
+	broken := fake.NewSimpleClientset()
+	broken.PrependReactor("list", "*", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+
+	svc := newSearchService(t, map[string]*fake.Clientset{"prod": prod, "staging": staging, "edge": broken})
+
+	result, err := svc.SearchResources(context.Background(), SearchQuery{Name: "web"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Searched)
+	assert.Equal(t, []ResourceMatch{
+		{Cluster: "prod", Namespace: "shop", Kind: KindDeployment, Name: "web", Labels: web, Status: "0/0 ready"},
+		{Cluster: "prod", Namespace: "shop", Kind: KindPod, Name: "web-7d9f", Labels: web, Status: "Running"},
+		{Cluster: "prod", Namespace: "shop", Kind: KindService, Name: "web", Labels: web, Status: "ClusterIP"},
+		{Cluster: "staging", Namespace: "default", Kind: KindPod, Name: "WEB-1", Labels: web, Status: "Pending"},
+	}, result.Matches)
+	require.Len(t, result.Failed, 1)
+	assert.Equal(t, "edge", result.Failed[0].Cluster)
+	assert.Contains(t, result.Failed[0].Error, "connection refused")
+}
+
+func TestSearchResourcesFilters(t *testing.T) {
+	prod := fake.NewSimpleClientset(
+		&corev1.Pod{ObjectMeta: meta("shop", "web-1", map[string]string{"app": "web"})},
+		&corev1.Pod{ObjectMeta: meta("shop", "db-0", map[string]string{"app": "db"})},
+		&corev1.Pod{ObjectMeta: meta("ops", "web-2", map[string]string{"app": "web"})},
+		&corev1.Service{ObjectMeta: meta("shop", "web", map[string]string{"app": "web"})},
+	)
+	svc := newSearchService(t, map[string]*fake.Clientset{"prod": prod})
+
+	result, err := svc.SearchResources(context.Background(), SearchQuery{
+		LabelSelector: "app=web", Kinds: []string{"pods"}, Namespace: "shop",
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Matches, 1)
+	assert.Equal(t, "web-1", result.Matches[0].Name)
+	assert.Empty(t, result.Failed)
+
+	_, err = svc.SearchResources(context.Background(), SearchQuery{})
+	assert.ErrorContains(t, err, "name or label_selector is required")
+	_, err = svc.SearchResources(context.Background(), SearchQuery{Name: "web", Kinds: []string{"ConfigMap"}})
+	assert.ErrorContains(t, err, `unsupported kind "ConfigMap"`)
+	_, err = svc.SearchResources(context.Background(), SearchQuery{LabelSelector: "app in ("})
+	assert.ErrorContains(t, err, "invalid label selector")
+}
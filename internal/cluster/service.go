@@ -6,19 +6,24 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"io"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/internal/audit"
 	"github.com/anubhavg-icpl/krustron/pkg/cache"
 	"github.com/anubhavg-icpl/krustron/pkg/database"
 	"github.com/anubhavg-icpl/krustron/pkg/errors"
 	"github.com/anubhavg-icpl/krustron/pkg/kube"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
 	"github.com/anubhavg-icpl/krustron/pkg/websocket"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 )
@@ -29,6 +34,15 @@ type Service struct {
 	kubeManager *kube.ClientManager
 	cache       *cache.RedisCache
 	emitter     *websocket.EventEmitter
+	secrets     SecretStore
+	fields      *secrets.FieldCipher
+	recorder    *audit.Recorder
+	usage       UsageSource
+
+	// bulkConcurrency caps clusters worked on at once by bulk operations
+	bulkConcurrency int
+	// loads shares cache-miss loads between concurrent callers
+	loads singleflight.Group
 }
 
 // SetEventEmitter wires the real-time hub so cluster mutations broadcast
@@ -63,6 +77,7 @@ type Cluster struct {
 	Environment     string            `json:"environment" db:"environment"`
 	Labels          map[string]string `json:"labels" db:"labels"`
 	Annotations     map[string]string `json:"annotations" db:"annotations"`
+	Cloud           *kube.CloudAuth   `json:"cloud,omitempty" db:"cloud_auth"`
 	AgentInstalled  bool              `json:"agent_installed" db:"agent_installed"`
 	AgentVersion    string            `json:"agent_version" db:"agent_version"`
 	LastHealthCheck *time.Time        `json:"last_health_check" db:"last_health_check"`
@@ -94,7 +109,9 @@ type CreateRequest struct {
 	Environment string            `json:"environment"`
 	Labels      map[string]string `json:"labels"`
 	Annotations map[string]string `json:"annotations"`
-	CreatedBy   string            `json:"-"`
+	// Cloud carries the provider fields for an eks, gke or aks AuthType
+	Cloud     *kube.CloudAuth `json:"cloud"`
+	CreatedBy string          `json:"-"`
 }
 
 // UpdateRequest contains data for updating a cluster
@@ -111,7 +128,7 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Cluster, in
 	query := `
 		SELECT id, name, display_name, description, api_server, auth_type, status,
 		       version, nodes_count, cpu_capacity, memory_capacity, provider, region,
-		       environment, labels, annotations, agent_installed, agent_version,
+		       environment, labels, annotations, cloud_auth, agent_installed, agent_version,
 		       last_health_check, created_by, created_at, updated_at
 		FROM clusters
 		WHERE 1=1
@@ -161,13 +178,13 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Cluster, in
 	var clusters []Cluster
 	for rows.Next() {
 		var c Cluster
-		var labels, annotations []byte
+		var labels, annotations, cloudAuth []byte
 		var lastHealthCheck sql.NullTime
 
 		if err := rows.Scan(
 			&c.ID, &c.Name, &c.DisplayName, &c.Description, &c.APIServer, &c.AuthType,
 			&c.Status, &c.Version, &c.NodesCount, &c.CPUCapacity, &c.MemoryCapacity,
-			&c.Provider, &c.Region, &c.Environment, &labels, &annotations,
+			&c.Provider, &c.Region, &c.Environment, &labels, &annotations, &cloudAuth,
 			&c.AgentInstalled, &c.AgentVersion, &lastHealthCheck, &c.CreatedBy,
 			&c.CreatedAt, &c.UpdatedAt,
 		); err != nil {
@@ -180,6 +197,9 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Cluster, in
 
 		json.Unmarshal(labels, &c.Labels)
 		json.Unmarshal(annotations, &c.Annotations)
+		if len(cloudAuth) > 0 {
+			json.Unmarshal(cloudAuth, &c.Cloud)
+		}
 
 		clusters = append(clusters, c)
 	}
@@ -189,30 +209,33 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Cluster, in
 
 // Get returns a single cluster by ID
 func (s *Service) Get(ctx context.Context, id string) (*Cluster, error) {
-	// Try cache first
-	if s.cache != nil {
-		var cached Cluster
-		if err := s.cache.Get(ctx, cache.BuildKey(cache.PrefixCluster, id), &cached); err == nil {
-			return &cached, nil
-		}
+	c, err := cached(ctx, s, cache.BuildKey(cache.PrefixCluster, id), clusterCacheTTL, func(ctx context.Context) (*Cluster, error) {
+		return s.getFromDB(ctx, id)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return c.clone(), nil
+}
 
+// getFromDB loads the cluster id from the database
+func (s *Service) getFromDB(ctx context.Context, id string) (*Cluster, error) {
 	query := `
 		SELECT id, name, display_name, description, api_server, auth_type, status,
 		       version, nodes_count, cpu_capacity, memory_capacity, provider, region,
-		       environment, labels, annotations, agent_installed, agent_version,
+		       environment, labels, annotations, cloud_auth, agent_installed, agent_version,
 		       last_health_check, created_by, created_at, updated_at
 		FROM clusters WHERE id = $1
 	`
 
 	var c Cluster
-	var labels, annotations []byte
+	var labels, annotations, cloudAuth []byte
 	var lastHealthCheck sql.NullTime
 
 	if err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&c.ID, &c.Name, &c.DisplayName, &c.Description, &c.APIServer, &c.AuthType,
 		&c.Status, &c.Version, &c.NodesCount, &c.CPUCapacity, &c.MemoryCapacity,
-		&c.Provider, &c.Region, &c.Environment, &labels, &annotations,
+		&c.Provider, &c.Region, &c.Environment, &labels, &annotations, &cloudAuth,
 		&c.AgentInstalled, &c.AgentVersion, &lastHealthCheck, &c.CreatedBy,
 		&c.CreatedAt, &c.UpdatedAt,
 	); err != nil {
@@ -228,10 +251,8 @@ func (s *Service) Get(ctx context.Context, id string) (*Cluster, error) {
 
 	json.Unmarshal(labels, &c.Labels)
 	json.Unmarshal(annotations, &c.Annotations)
-
-	// Cache the result
-	if s.cache != nil {
-		s.cache.Set(ctx, cache.BuildKey(cache.PrefixCluster, id), &c, 5*time.Minute)
+	if len(cloudAuth) > 0 {
+		json.Unmarshal(cloudAuth, &c.Cloud)
 	}
 
 	return &c, nil
@@ -239,14 +260,35 @@ func (s *Service) Get(ctx context.Context, id string) (*Cluster, error) {
 
 // Create creates a new cluster
 func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Cluster, error) {
-	// Validate kubeconfig and get cluster info
+	// Validate kubeconfig or cloud credentials and get cluster info
 	var version string
 	var nodesCount int
-	if req.Kubeconfig != "" {
-		client, err := s.kubeManager.AddCluster(req.Name, []byte(req.Kubeconfig))
-		if err != nil {
+	var cloudAuth []byte
+	var client *kube.ClusterClient
+	if isCloudAuth(req.AuthType) {
+		if req.Cloud == nil {
+			return nil, errors.Validation(fmt.Sprintf("cloud is required for %s clusters", req.AuthType))
+		}
+		req.Cloud.Provider = req.AuthType
+		if err := req.Cloud.Validate(); err != nil {
+			return nil, errors.ValidationWrap(err, "invalid cloud auth")
+		}
+		// Only the connection metadata is kept; credentials stay in the
+		// secret store
+		req.Kubeconfig = ""
+		cloudAuth, _ = json.Marshal(req.Cloud)
+
+		var err error
+		if client, err = s.connectCloud(ctx, req.Name, req.APIServer, req.Cloud); err != nil {
 			return nil, errors.ClusterWrap(err, "failed to connect to cluster")
 		}
+	} else if req.Kubeconfig != "" {
+		var err error
+		if client, err = s.kubeManager.AddCluster(req.Name, []byte(req.Kubeconfig)); err != nil {
+			return nil, errors.ClusterWrap(err, "failed to connect to cluster")
+		}
+	}
+	if client != nil {
 		version = client.Version
 
 		// Get nodes count
@@ -256,6 +298,17 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Cluster, err
 		}
 	}
 
+	// With a secret store the row only references the kubeconfig
+	kubeconfig, kubeconfigRef := req.Kubeconfig, ""
+	if s.secrets != nil && kubeconfig != "" {
+		var err error
+		if kubeconfigRef, err = s.storeKubeconfig(ctx, kubeconfig); err != nil {
+			return nil, errors.InternalWrap(err, "failed to store kubeconfig")
+		}
+		kubeconfig = ""
+	}
+	kubeconfig = s.fields.Encrypt(kubeconfigColumn, kubeconfig)
+
 	labels, _ := json.Marshal(req.Labels)
 	annotations, _ := json.Marshal(req.Annotations)
 
@@ -267,8 +320,8 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Cluster, err
 	query := `
 		INSERT INTO clusters (name, display_name, description, api_server, kubeconfig,
 		                      auth_type, status, version, nodes_count, provider, region,
-		                      environment, labels, annotations, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		                      environment, labels, annotations, cloud_auth, created_by, kubeconfig_ref)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, ''))
 		RETURNING id, created_at, updated_at
 	`
 
@@ -279,10 +332,13 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Cluster, err
 
 	var cluster Cluster
 	if err := s.db.QueryRowContext(ctx, query,
-		req.Name, displayName, req.Description, req.APIServer, req.Kubeconfig,
+		req.Name, displayName, req.Description, req.APIServer, kubeconfig,
 		req.AuthType, status, version, nodesCount, req.Provider, req.Region,
-		req.Environment, labels, annotations, req.CreatedBy,
+		req.Environment, labels, annotations, cloudAuth, req.CreatedBy, kubeconfigRef,
 	).Scan(&cluster.ID, &cluster.CreatedAt, &cluster.UpdatedAt); err != nil {
+		if kubeconfigRef != "" {
+			s.secrets.Delete(ctx, kubeconfigRef)
+		}
 		return nil, errors.DatabaseWrap(err, "failed to create cluster")
 	}
 
@@ -299,6 +355,7 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Cluster, err
 	cluster.Environment = req.Environment
 	cluster.Labels = req.Labels
 	cluster.Annotations = req.Annotations
+	cluster.Cloud = req.Cloud
 	cluster.CreatedBy = req.CreatedBy
 
 	logger.Info("Cluster created",
@@ -344,9 +401,7 @@ func (s *Service) Update(ctx context.Context, id string, req *UpdateRequest) (*C
 	}
 
 	// Invalidate cache
-	if s.cache != nil {
-		s.cache.Delete(ctx, cache.BuildKey(cache.PrefixCluster, id))
-	}
+	s.invalidate(ctx, id)
 
 	return s.Get(ctx, id)
 }
@@ -362,35 +417,48 @@ func (s *Service) Delete(ctx context.Context, id string) error {
 	// Remove from kube manager
 	s.kubeManager.RemoveCluster(cluster.Name)
 
-	query := "DELETE FROM clusters WHERE id = $1"
-	result, err := s.db.ExecContext(ctx, query, id)
-	if err != nil {
+	var kubeconfigRef string
+	query := "DELETE FROM clusters WHERE id = $1 RETURNING COALESCE(kubeconfig_ref, '')"
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&kubeconfigRef); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NotFound("cluster", id)
+		}
 		return errors.DatabaseWrap(err, "failed to delete cluster")
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		return errors.NotFound("cluster", id)
+	if kubeconfigRef != "" && s.secrets != nil {
+		if err := s.secrets.Delete(ctx, kubeconfigRef); err != nil {
+			logger.Warn("Failed to delete cluster kubeconfig",
+				zap.String("cluster_id", id), zap.String("ref", kubeconfigRef), zap.Error(err))
+		}
 	}
 
 	// Invalidate cache
-	if s.cache != nil {
-		s.cache.Delete(ctx, cache.BuildKey(cache.PrefixCluster, id))
-	}
+	s.invalidate(ctx, id)
 
 	logger.Info("Cluster deleted", zap.String("cluster_id", id))
 	return nil
 }
 
-// GetHealth returns cluster health information
+// GetHealth returns cluster health information, cached briefly so bursts
+// of requests share one check
 func (s *Service) GetHealth(ctx context.Context, id string) (*HealthStatus, error) {
+	return cached(ctx, s, cache.BuildKey(cache.PrefixCluster, id, "health"), healthCacheTTL, func(ctx context.Context) (*HealthStatus, error) {
+		return s.checkHealth(ctx, id)
+	})
+}
+
+// checkHealth asks the cluster id for its health and records the result
+func (s *Service) checkHealth(ctx context.Context, id string) (*HealthStatus, error) {
 	cluster, err := s.Get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
+	target := healthTarget{id: cluster.ID, name: cluster.Name, status: cluster.Status}
+
 	client, err := s.kubeManager.GetClient(cluster.Name)
 	if err != nil {
+		s.recordHealth(ctx, target, StatusDisconnected, nil, err.Error())
 		return &HealthStatus{
 			Status:  "disconnected",
 			Message: err.Error(),
@@ -398,6 +466,7 @@ func (s *Service) GetHealth(ctx context.Context, id string) (*HealthStatus, erro
 	}
 
 	if err := client.CheckHealth(ctx); err != nil {
+		s.recordHealth(ctx, target, StatusUnhealthy, nil, err.Error())
 		return &HealthStatus{
 			Status:  "unhealthy",
 			Message: err.Error(),
@@ -413,7 +482,7 @@ func (s *Service) GetHealth(ctx context.Context, id string) (*HealthStatus, erro
 	}
 
 	// Update cluster info in database
-	s.updateClusterInfo(ctx, id, info)
+	s.recordHealth(ctx, target, StatusConnected, info, "")
 
 	return &HealthStatus{
 		Status:         "healthy",
@@ -442,23 +511,16 @@ type HealthStatus struct {
 	MemoryCapacity string `json:"memory_capacity,omitempty"`
 }
 
-func (s *Service) updateClusterInfo(ctx context.Context, id string, info *kube.ClusterInfo) {
-	query := `
-		UPDATE clusters
-		SET version = $2, nodes_count = $3, cpu_capacity = $4, memory_capacity = $5,
-		    status = 'connected', last_health_check = NOW(), updated_at = NOW()
-		WHERE id = $1
-	`
-	s.db.ExecContext(ctx, query, id, info.Version, info.NodesCount, info.CPUCapacity, info.MemoryCapacity)
-
-	// Invalidate cache
-	if s.cache != nil {
-		s.cache.Delete(ctx, cache.BuildKey(cache.PrefixCluster, id))
-	}
+// GetResources returns cluster resources summary, cached briefly so
+// bursts of requests share one lookup
+func (s *Service) GetResources(ctx context.Context, id string) (*ResourcesSummary, error) {
+	return cached(ctx, s, cache.BuildKey(cache.PrefixCluster, id, "resources"), resourcesCacheTTL, func(ctx context.Context) (*ResourcesSummary, error) {
+		return s.getResources(ctx, id)
+	})
 }
 
-// GetResources returns cluster resources summary
-func (s *Service) GetResources(ctx context.Context, id string) (*ResourcesSummary, error) {
+// getResources summarizes the resources of the cluster id
+func (s *Service) getResources(ctx context.Context, id string) (*ResourcesSummary, error) {
 	cluster, err := s.Get(ctx, id)
 	if err != nil {
 		return nil, err
@@ -534,23 +596,30 @@ type NamespaceInfo struct {
 
 // GetPods returns pods in a namespace
 func (s *Service) GetPods(ctx context.Context, clusterID, namespace string) ([]PodInfo, error) {
+	pods, _, err := s.ListPods(ctx, clusterID, namespace, kube.ListOptions{})
+	return pods, err
+}
+
+// ListPods returns a page of pods in a namespace and the cursor for the
+// next page, empty on the last
+func (s *Service) ListPods(ctx context.Context, clusterID, namespace string, opts kube.ListOptions) ([]PodInfo, string, error) {
 	cluster, err := s.Get(ctx, clusterID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	client, err := s.kubeManager.GetClient(cluster.Name)
 	if err != nil {
-		return nil, errors.ClusterWrap(err, "failed to get cluster client")
+		return nil, "", errors.ClusterWrap(err, "failed to get cluster client")
 	}
 
-	pods, err := client.GetPods(ctx, namespace)
+	pods, err := client.ListPods(ctx, namespace, opts)
 	if err != nil {
-		return nil, errors.KubernetesWrap(err, "failed to list pods")
+		return nil, "", errors.KubernetesWrap(err, "failed to list pods")
 	}
 
-	result := make([]PodInfo, len(pods))
-	for i, pod := range pods {
+	result := make([]PodInfo, len(pods.Items))
+	for i, pod := range pods.Items {
 		containers := make([]ContainerInfo, len(pod.Spec.Containers))
 		for j, c := range pod.Spec.Containers {
 			containers[j] = ContainerInfo{
@@ -571,7 +640,7 @@ func (s *Service) GetPods(ctx context.Context, clusterID, namespace string) ([]P
 		}
 	}
 
-	return result, nil
+	return result, pods.Continue, nil
 }
 
 // PodInfo represents pod information
@@ -620,25 +689,29 @@ func (s *Service) GetPodLogs(ctx context.Context, clusterID, namespace, podName,
 	return logs, nil
 }
 
-// StreamPodLogs opens a follow-stream of pod logs. Caller closes the ReadCloser.
-func (s *Service) StreamPodLogs(ctx context.Context, clusterID, namespace, podName, container string) (io.ReadCloser, error) {
+// StreamPodLogs writes a pod's logs to out, following them when
+// opts.Follow is set, until the stream ends or ctx is cancelled
+func (s *Service) StreamPodLogs(ctx context.Context, clusterID, namespace, podName, container string, opts kube.LogOptions, out io.Writer) error {
 	cluster, err := s.Get(ctx, clusterID)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	client, err := s.kubeManager.GetClient(cluster.Name)
 	if err != nil {
-		return nil, errors.ClusterWrap(err, "failed to get cluster client")
-	}
-	opts := &corev1.PodLogOptions{Follow: true}
-	if container != "" {
-		opts.Container = container
+		return errors.ClusterWrap(err, "failed to get cluster client")
 	}
-	stream, err := client.Clientset.CoreV1().Pods(namespace).GetLogs(podName, opts).Stream(ctx)
-	if err != nil {
-		return nil, errors.KubernetesWrap(err, "failed to open log stream")
+
+	err = client.StreamPodLogs(ctx, namespace, podName, container, opts, out)
+	switch {
+	case err == nil, goerrors.Is(err, context.Canceled):
+		return nil
+	case goerrors.Is(err, kube.ErrContainerNotFound):
+		return errors.NotFoundMsg(err.Error())
+	case goerrors.Is(err, kube.ErrContainerNotStarted):
+		return errors.Conflict(err.Error())
+	default:
+		return errors.KubernetesWrap(err, "failed to stream pod logs")
 	}
-	return stream, nil
 }
 
 // WatchEvents returns a live watch on cluster events across all namespaces.
@@ -660,23 +733,30 @@ func (s *Service) WatchEvents(ctx context.Context, clusterID string) (watch.Inte
 
 // GetServices returns services in a namespace
 func (s *Service) GetServices(ctx context.Context, clusterID, namespace string) ([]ServiceInfo, error) {
+	services, _, err := s.ListServices(ctx, clusterID, namespace, kube.ListOptions{})
+	return services, err
+}
+
+// ListServices returns a page of services in a namespace and the cursor
+// for the next page
+func (s *Service) ListServices(ctx context.Context, clusterID, namespace string, opts kube.ListOptions) ([]ServiceInfo, string, error) {
 	cluster, err := s.Get(ctx, clusterID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	client, err := s.kubeManager.GetClient(cluster.Name)
 	if err != nil {
-		return nil, errors.ClusterWrap(err, "failed to get cluster client")
+		return nil, "", errors.ClusterWrap(err, "failed to get cluster client")
 	}
 
-	services, err := client.GetServices(ctx, namespace)
+	services, err := client.ListServices(ctx, namespace, opts)
 	if err != nil {
-		return nil, errors.KubernetesWrap(err, "failed to list services")
+		return nil, "", errors.KubernetesWrap(err, "failed to list services")
 	}
 
-	result := make([]ServiceInfo, len(services))
-	for i, svc := range services {
+	result := make([]ServiceInfo, len(services.Items))
+	for i, svc := range services.Items {
 		ports := make([]PortInfo, len(svc.Spec.Ports))
 		for j, p := range svc.Spec.Ports {
 			ports[j] = PortInfo{
@@ -698,7 +778,7 @@ func (s *Service) GetServices(ctx context.Context, clusterID, namespace string)
 		}
 	}
 
-	return result, nil
+	return result, services.Continue, nil
 }
 
 // ServiceInfo represents service information
@@ -736,19 +816,26 @@ func getExternalIP(svc corev1.Service) string {
 
 // GetDeployments returns deployments in a namespace
 func (s *Service) GetDeployments(ctx context.Context, clusterID, namespace string) ([]DeploymentInfo, error) {
+	deployments, _, err := s.ListDeployments(ctx, clusterID, namespace, kube.ListOptions{})
+	return deployments, err
+}
+
+// ListDeployments returns a page of deployments in a namespace and the
+// cursor for the next page
+func (s *Service) ListDeployments(ctx context.Context, clusterID, namespace string, opts kube.ListOptions) ([]DeploymentInfo, string, error) {
 	cluster, err := s.Get(ctx, clusterID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	client, err := s.kubeManager.GetClient(cluster.Name)
 	if err != nil {
-		return nil, errors.ClusterWrap(err, "failed to get cluster client")
+		return nil, "", errors.ClusterWrap(err, "failed to get cluster client")
 	}
 
-	deployments, err := client.Clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	deployments, err := client.ListDeployments(ctx, namespace, opts)
 	if err != nil {
-		return nil, errors.KubernetesWrap(err, "failed to list deployments")
+		return nil, "", errors.KubernetesWrap(err, "failed to list deployments")
 	}
 
 	result := make([]DeploymentInfo, len(deployments.Items))
@@ -772,7 +859,7 @@ func (s *Service) GetDeployments(ctx context.Context, clusterID, namespace strin
 		}
 	}
 
-	return result, nil
+	return result, deployments.Continue, nil
 }
 
 // DeploymentInfo represents deployment information
@@ -789,23 +876,30 @@ type DeploymentInfo struct {
 
 // GetEvents returns events in a namespace
 func (s *Service) GetEvents(ctx context.Context, clusterID, namespace string) ([]EventInfo, error) {
+	events, _, err := s.ListEvents(ctx, clusterID, namespace, kube.ListOptions{})
+	return events, err
+}
+
+// ListEvents returns a page of events in a namespace and the cursor for
+// the next page
+func (s *Service) ListEvents(ctx context.Context, clusterID, namespace string, opts kube.ListOptions) ([]EventInfo, string, error) {
 	cluster, err := s.Get(ctx, clusterID)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	client, err := s.kubeManager.GetClient(cluster.Name)
 	if err != nil {
-		return nil, errors.ClusterWrap(err, "failed to get cluster client")
+		return nil, "", errors.ClusterWrap(err, "failed to get cluster client")
 	}
 
-	events, err := client.GetEvents(ctx, namespace)
+	events, err := client.ListEvents(ctx, namespace, opts)
 	if err != nil {
-		return nil, errors.KubernetesWrap(err, "failed to list events")
+		return nil, "", errors.KubernetesWrap(err, "failed to list events")
 	}
 
-	result := make([]EventInfo, len(events))
-	for i, event := range events {
+	result := make([]EventInfo, len(events.Items))
+	for i, event := range events.Items {
 		result[i] = EventInfo{
 			Name:      event.Name,
 			Namespace: event.Namespace,
@@ -819,7 +913,7 @@ func (s *Service) GetEvents(ctx context.Context, clusterID, namespace string) ([
 		}
 	}
 
-	return result, nil
+	return result, events.Continue, nil
 }
 
 // EventInfo represents event information
@@ -835,16 +929,24 @@ type EventInfo struct {
 	LastSeen  time.Time `json:"last_seen"`
 }
 
-// InstallAgent installs the Krustron agent on a cluster
+// InstallAgent installs the Krustron agent on a cluster. Installing it
+// where it already runs does nothing.
 func (s *Service) InstallAgent(ctx context.Context, id string) error {
+	_, err := s.installAgent(ctx, id)
+	return err
+}
+
+// installAgent installs the agent on the cluster id and reports whether it
+// did, rather than finding it already deployed
+func (s *Service) installAgent(ctx context.Context, id string) (bool, error) {
 	cluster, err := s.Get(ctx, id)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	client, err := s.kubeManager.GetClient(cluster.Name)
 	if err != nil {
-		return errors.ClusterWrap(err, "failed to get cluster client")
+		return false, errors.ClusterWrap(err, "failed to get cluster client")
 	}
 
 	// Create namespace
@@ -854,8 +956,8 @@ func (s *Service) InstallAgent(ctx context.Context, id string) error {
 		},
 	}
 	_, err = client.Clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
-	if err != nil {
-		logger.Warn("Namespace already exists", zap.Error(err))
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		logger.Warn("Failed to create agent namespace", zap.Error(err))
 	}
 
 	// Create agent deployment
@@ -890,21 +992,27 @@ func (s *Service) InstallAgent(ctx context.Context, id string) error {
 	}
 
 	_, err = client.Clientset.AppsV1().Deployments("krustron-system").Create(ctx, agent, metav1.CreateOptions{})
-	if err != nil {
-		return errors.KubernetesWrap(err, "failed to create agent deployment")
+	installed := err == nil
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return false, errors.KubernetesWrap(err, "failed to create agent deployment")
+	}
+	if !installed && cluster.AgentInstalled {
+		return false, nil
 	}
 
 	// Update cluster status
-	query := "UPDATE clusters SET agent_installed = true, agent_version = 'latest', updated_at = NOW() WHERE id = $1"
-	s.db.ExecContext(ctx, query, id)
+	query := "UPDATE clusters SET agent_installed = true, agent_version = 'latest', updated_at = CURRENT_TIMESTAMP WHERE id = $1"
+	if _, err := s.db.ExecContext(ctx, query, id); err != nil {
+		return false, errors.DatabaseWrap(err, "failed to record agent installation")
+	}
 
 	// Invalidate cache
-	if s.cache != nil {
-		s.cache.Delete(ctx, cache.BuildKey(cache.PrefixCluster, id))
-	}
+	s.invalidate(ctx, id)
 
-	logger.Info("Agent installed", zap.String("cluster_id", id))
-	return nil
+	if installed {
+		logger.Info("Agent installed", zap.String("cluster_id", id))
+	}
+	return installed, nil
 }
 
 func int32Ptr(i int32) *int32 { return &i }
@@ -0,0 +1,257 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// defaultTriageWindow is how far back Triage looks for events by default
+const defaultTriageWindow = time.Hour
+
+// Finding severities
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// TriageOptions scopes a triage
+type TriageOptions struct {
+	// Namespace limits the triage to one namespace; empty means all
+	Namespace string
+	// Since bounds the event window; defaults to an hour
+	Since time.Duration
+}
+
+// Finding is one problem found by a triage. Score ranks findings: higher
+// is more urgent.
+type Finding struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Reason    string   `json:"reason"`
+	Message   string   `json:"message,omitempty"`
+	Severity  string   `json:"severity"`
+	Score     int      `json:"score"`
+	Hint      string   `json:"hint,omitempty"`
+	Events    []string `json:"events,omitempty"`
+}
+
+// TriageReport is the outcome of a triage, findings ranked most urgent
+// first
+type TriageReport struct {
+	Cluster       string    `json:"cluster"`
+	Namespace     string    `json:"namespace,omitempty"`
+	Since         string    `json:"since"`
+	CollectedAt   time.Time `json:"collected_at"`
+	WarningEvents int       `json:"warning_events"`
+	Findings      []Finding `json:"findings"`
+}
+
+// Triage gathers the unhealthy pods, pending PVCs and recent Warning
+// events of a cluster, given by ID or name
+func (s *Service) Triage(ctx context.Context, clusterRef string, opts TriageOptions) (*TriageReport, error) {
+	where, arg := " WHERE name = $1", clusterRef
+	if _, err := uuid.Parse(clusterRef); err == nil {
+		where = " WHERE id = $1"
+	}
+	targets, err := s.queryHealthTargets(ctx, where, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.NotFound("cluster", clusterRef)
+	}
+
+	client, err := s.clientFor(ctx, targets[0])
+	if err != nil {
+		return nil, errors.ClusterWrap(err, "failed to get cluster client")
+	}
+	report, err := CollectTriage(ctx, client.Clientset, opts, time.Now())
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "failed to collect triage data")
+	}
+	report.Cluster = targets[0].name
+	return report, nil
+}
+
+// CollectTriage builds a triage report from what clientset returns, using
+// heuristics only. Events older than opts.Since before now are ignored.
+func CollectTriage(ctx context.Context, clientset kubernetes.Interface, opts TriageOptions, now time.Time) (*TriageReport, error) {
+	since := opts.Since
+	if since <= 0 {
+		since = defaultTriageWindow
+	}
+	ns := opts.Namespace
+
+	pods, err := clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistent volume claims: %w", err)
+	}
+	events, err := clientset.CoreV1().Events(ns).List(ctx, metav1.ListOptions{FieldSelector: "type=Warning"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	report := &TriageReport{Namespace: ns, Since: since.String(), CollectedAt: now}
+	findings := make(map[string]*Finding)
+	var order []string
+	add := func(f Finding) {
+		key := f.Kind + "/" + f.Namespace + "/" + f.Name
+		findings[key] = &f
+		order = append(order, key)
+	}
+
+	for i := range pods.Items {
+		if f, ok := triagePod(&pods.Items[i]); ok {
+			add(f)
+		}
+	}
+	for _, pvc := range pvcs.Items {
+		switch pvc.Status.Phase {
+		case corev1.ClaimPending:
+			add(Finding{
+				Kind: "PersistentVolumeClaim", Namespace: pvc.Namespace, Name: pvc.Name,
+				Reason: "Pending", Severity: SeverityWarning, Score: 55,
+				Hint: "No volume is bound; check that the storage class exists and its provisioner is running",
+			})
+		case corev1.ClaimLost:
+			add(Finding{
+				Kind: "PersistentVolumeClaim", Namespace: pvc.Namespace, Name: pvc.Name,
+				Reason: "Lost", Severity: SeverityCritical, Score: 80,
+				Hint: "The bound volume no longer exists; restore it or recreate the claim",
+			})
+		}
+	}
+
+	cutoff := now.Add(-since)
+	for _, ev := range events.Items {
+		if ev.Type != corev1.EventTypeWarning || eventTime(&ev).Before(cutoff) {
+			continue
+		}
+		report.WarningEvents++
+
+		line := fmt.Sprintf("%s: %s", ev.Reason, ev.Message)
+		if ev.Count > 1 {
+			line += fmt.Sprintf(" (x%d)", ev.Count)
+		}
+		obj := ev.InvolvedObject
+		key := obj.Kind + "/" + obj.Namespace + "/" + obj.Name
+		if f, ok := findings[key]; ok {
+			f.Events = append(f.Events, line)
+			if ev.Reason == "FailedScheduling" && f.Reason == "Pending" {
+				f.Reason = "FailedScheduling"
+				f.Message = ev.Message
+				f.Score = 65
+				f.Hint = "No node fits the pod; check its resource requests, node selectors, taints and affinity"
+			}
+			continue
+		}
+
+		count := int(ev.Count)
+		if count > 20 {
+			count = 20
+		}
+		add(Finding{
+			Kind: obj.Kind, Namespace: obj.Namespace, Name: obj.Name,
+			Reason: ev.Reason, Message: ev.Message, Severity: SeverityWarning,
+			Score: 20 + count, Events: []string{line},
+		})
+	}
+
+	report.Findings = make([]Finding, 0, len(order))
+	for _, key := range order {
+		report.Findings = append(report.Findings, *findings[key])
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Score > report.Findings[j].Score
+	})
+	return report, nil
+}
+
+// triagePod reports a pod that isn't running healthily
+func triagePod(pod *corev1.Pod) (Finding, bool) {
+	f := Finding{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Message: pod.Status.Message}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		if w := cs.State.Waiting; w != nil {
+			switch w.Reason {
+			case "CrashLoopBackOff":
+				f.Reason, f.Severity, f.Score = w.Reason, SeverityCritical, 90
+				f.Hint = fmt.Sprintf("Container %s keeps crashing; check its previous logs", cs.Name)
+				if t := cs.LastTerminationState.Terminated; t != nil && t.Reason == "OOMKilled" {
+					f.Reason, f.Score = "OOMKilled", 95
+					f.Hint = fmt.Sprintf("Container %s runs out of memory; raise its memory limit or find the leak", cs.Name)
+				}
+			case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+				f.Reason, f.Severity, f.Score = w.Reason, SeverityCritical, 80
+				f.Hint = fmt.Sprintf("Image %s can't be pulled; check the name, tag and pull secrets", cs.Image)
+			case "CreateContainerConfigError", "CreateContainerError":
+				f.Reason, f.Severity, f.Score = w.Reason, SeverityCritical, 75
+				f.Hint = "A ConfigMap, Secret or key the container references is missing"
+			default:
+				continue
+			}
+			if w.Message != "" {
+				f.Message = w.Message
+			}
+			return f, true
+		}
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodRunning, corev1.PodSucceeded:
+		return f, false
+	case corev1.PodFailed:
+		f.Reason, f.Severity, f.Score = "Failed", SeverityCritical, 70
+		if pod.Status.Reason != "" {
+			f.Reason = pod.Status.Reason
+		}
+		if f.Reason == "Evicted" {
+			f.Hint = "The node ran short of resources; check node pressure conditions"
+		}
+	case corev1.PodPending:
+		f.Reason, f.Severity, f.Score = "Pending", SeverityWarning, 50
+		f.Hint = "The pod hasn't started; check its events"
+	default:
+		f.Reason, f.Severity, f.Score = string(pod.Status.Phase), SeverityWarning, 60
+		if f.Reason == "" {
+			f.Reason = "Unknown"
+		}
+		f.Hint = "The pod's node may be unreachable"
+	}
+	return f, true
+}
+
+// eventTime is when an event last happened
+func eventTime(ev *corev1.Event) time.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	default:
+		return ev.CreationTimestamp.Time
+	}
+}
+
+// Summary is a one-line description of the finding
+func (f *Finding) Summary() string {
+	s := fmt.Sprintf("%s %s/%s: %s", f.Kind, f.Namespace, f.Name, f.Reason)
+	if f.Message != "" {
+		s += " - " + strings.TrimSpace(f.Message)
+	}
+	return s
+}
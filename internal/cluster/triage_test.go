@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func waitingPod(name, reason string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "app",
+				Image: "shop/" + name + ":1.0",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+			}},
+		},
+	}
+}
+
+func warningEvent(name, kind, object, reason string, at time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "shop"},
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Namespace: "shop", Name: object},
+		Type:           corev1.EventTypeWarning,
+		Reason:         reason,
+		Message:        reason + " on " + object,
+		Count:          3,
+		LastTimestamp:  metav1.NewTime(at),
+	}
+}
+
+func TestCollectTriage(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	oom := waitingPod("cart", "CrashLoopBackOff")
+	oom.Status.ContainerStatuses[0].LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: "OOMKilled"}
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "search", Namespace: "shop"},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	healthy := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	done := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "migrate", Namespace: "shop"},
+		Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "shop"},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+	}
+
+	objects := []runtime.Object{
+		oom, waitingPod("checkout", "ImagePullBackOff"), pending, healthy, done, pvc,
+		warningEvent("e1", "Pod", "search", "FailedScheduling", now.Add(-10*time.Minute)),
+		warningEvent("e2", "Deployment", "web", "ProgressDeadlineExceeded", now.Add(-5*time.Minute)),
+		warningEvent("e3", "Pod", "web", "Unhealthy", now.Add(-3*time.Hour)),
+		// Other namespaces are out of scope
+		func() *corev1.Pod { p := waitingPod("api", "CrashLoopBackOff"); p.Namespace = "other"; return p }(),
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+
+	report, err := CollectTriage(context.Background(), clientset, TriageOptions{Namespace: "shop"}, now)
+	require.NoError(t, err)
+
+	var got []string
+	for _, f := range report.Findings {
+		got = append(got, f.Kind+"/"+f.Name+":"+f.Reason)
+	}
+	assert.Equal(t, []string{
+		"Pod/cart:OOMKilled",
+		"Pod/checkout:ImagePullBackOff",
+		"Pod/search:FailedScheduling",
+		"PersistentVolumeClaim/data:Pending",
+		"Deployment/web:ProgressDeadlineExceeded",
+	}, got)
+
+	assert.Equal(t, 2, report.WarningEvents, "events outside the window are ignored")
+	assert.Equal(t, "1h0m0s", report.Since)
+
+	scheduling := report.Findings[2]
+	assert.Equal(t, []string{"FailedScheduling: FailedScheduling on search (x3)"}, scheduling.Events)
+	assert.Equal(t, SeverityWarning, scheduling.Severity)
+	assert.Equal(t, 23, report.Findings[4].Score)
+	assert.Contains(t, report.Findings[1].Hint, "shop/checkout:1.0")
+}
+
+func TestCollectTriageSince(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clientset := fake.NewSimpleClientset(
+		warningEvent("e1", "Node", "node-1", "NodeNotReady", now.Add(-3*time.Hour)),
+	)
+
+	report, err := CollectTriage(context.Background(), clientset, TriageOptions{}, now)
+	require.NoError(t, err)
+	assert.Empty(t, report.Findings)
+
+	report, err = CollectTriage(context.Background(), clientset, TriageOptions{Since: 4 * time.Hour}, now)
+	require.NoError(t, err)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, "NodeNotReady", report.Findings[0].Reason)
+}
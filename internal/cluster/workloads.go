@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Workload kinds rollout operations apply to, besides KindDeployment
+const (
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+)
+
+// restartedAtAnnotation is the pod template annotation kubectl rollout
+// restart sets; changing it rolls every pod
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// WorkloadStatus is a workload after a rollout operation. The operation
+// is complete once ObservedGeneration reaches Generation and the updated
+// replicas are ready.
+type WorkloadStatus struct {
+	Kind               string `json:"kind"`
+	Namespace          string `json:"namespace"`
+	Name               string `json:"name"`
+	Generation         int64  `json:"generation"`
+	ObservedGeneration int64  `json:"observed_generation"`
+	Replicas           int32  `json:"replicas"`
+	Paused             bool   `json:"paused,omitempty"`
+}
+
+// RestartWorkload rolls the pods of a Deployment, StatefulSet or
+// DaemonSet as kubectl rollout restart does, honouring its update
+// strategy rather than deleting pods
+func (s *Service) RestartWorkload(ctx context.Context, clusterID, namespace, kind, name string) (*WorkloadStatus, error) {
+	patch := map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"metadata": map[string]interface{}{
+		"annotations": map[string]string{restartedAtAnnotation: time.Now().Format(time.RFC3339)},
+	}}}}
+	return s.patchWorkload(ctx, clusterID, namespace, kind, name, "restarted", patch)
+}
+
+// ScaleWorkload sets the replicas of a Deployment or StatefulSet
+func (s *Service) ScaleWorkload(ctx context.Context, clusterID, namespace, kind, name string, replicas int32) (*WorkloadStatus, error) {
+	if replicas < 0 {
+		return nil, errors.Validation("replicas must not be negative")
+	}
+	if k, err := workloadKind(kind); err != nil {
+		return nil, err
+	} else if k == KindDaemonSet {
+		return nil, errors.Validation("a DaemonSet can't be scaled")
+	}
+	patch := map[string]interface{}{"spec": map[string]interface{}{"replicas": replicas}}
+	return s.patchWorkload(ctx, clusterID, namespace, kind, name, "scaled", patch)
+}
+
+// PauseRollout pauses the rollout of a Deployment; changes to its pod
+// template aren't rolled out until it is resumed
+func (s *Service) PauseRollout(ctx context.Context, clusterID, namespace, kind, name string) (*WorkloadStatus, error) {
+	return s.setPaused(ctx, clusterID, namespace, kind, name, true)
+}
+
+// ResumeRollout resumes the paused rollout of a Deployment
+func (s *Service) ResumeRollout(ctx context.Context, clusterID, namespace, kind, name string) (*WorkloadStatus, error) {
+	return s.setPaused(ctx, clusterID, namespace, kind, name, false)
+}
+
+func (s *Service) setPaused(ctx context.Context, clusterID, namespace, kind, name string, paused bool) (*WorkloadStatus, error) {
+	if k, err := workloadKind(kind); err != nil {
+		return nil, err
+	} else if k != KindDeployment {
+		return nil, errors.Validation(fmt.Sprintf("only a Deployment's rollout can be paused, not a %s", k))
+	}
+	verb := "resumed"
+	if paused {
+		verb = "paused"
+	}
+	patch := map[string]interface{}{"spec": map[string]interface{}{"paused": paused}}
+	return s.patchWorkload(ctx, clusterID, namespace, kind, name, verb, patch)
+}
+
+// patchWorkload applies a strategic merge patch to a workload and returns
+// its state afterwards
+func (s *Service) patchWorkload(ctx context.Context, clusterID, namespace, kind, name, verb string, patch map[string]interface{}) (*WorkloadStatus, error) {
+	kind, err := workloadKind(kind)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.Validation("workload name is required")
+	}
+	_, clientset, err := s.namespaceClient(ctx, clusterID, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := json.Marshal(patch)
+	status := &WorkloadStatus{Kind: kind, Namespace: namespace, Name: name}
+	apps := clientset.AppsV1()
+	switch kind {
+	case KindDeployment:
+		d, perr := apps.Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+		if err = perr; err == nil {
+			status.Generation, status.ObservedGeneration, status.Paused = d.Generation, d.Status.ObservedGeneration, d.Spec.Paused
+			status.Replicas = replicasOf(d.Spec.Replicas)
+		}
+	case KindStatefulSet:
+		ss, perr := apps.StatefulSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+		if err = perr; err == nil {
+			status.Generation, status.ObservedGeneration = ss.Generation, ss.Status.ObservedGeneration
+			status.Replicas = replicasOf(ss.Spec.Replicas)
+		}
+	case KindDaemonSet:
+		ds, perr := apps.DaemonSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+		if err = perr; err == nil {
+			status.Generation, status.ObservedGeneration = ds.Generation, ds.Status.ObservedGeneration
+			status.Replicas = ds.Status.DesiredNumberScheduled
+		}
+	}
+	if apierrors.IsNotFound(err) {
+		return nil, errors.NotFound(strings.ToLower(kind), namespace+"/"+name)
+	}
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, fmt.Sprintf("failed to patch %s", strings.ToLower(kind)))
+	}
+
+	logger.Info("Workload "+verb,
+		zap.String("cluster_id", clusterID),
+		zap.String("kind", kind),
+		zap.String("namespace", namespace),
+		zap.String("name", name),
+		zap.Int64("generation", status.Generation),
+	)
+	return status, nil
+}
+
+// workloadKind normalises kind, which may be given as the kind or its
+// resource name, e.g. "deployments"
+func workloadKind(kind string) (string, error) {
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments":
+		return KindDeployment, nil
+	case "statefulset", "statefulsets":
+		return KindStatefulSet, nil
+	case "daemonset", "daemonsets":
+		return KindDaemonSet, nil
+	}
+	return "", errors.Validation(fmt.Sprintf("unsupported workload kind %q", kind))
+}
+
+// replicasOf is the replicas a workload asks for; unset means one
+func replicasOf(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
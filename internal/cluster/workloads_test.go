@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podTemplate() corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+	}
+}
+
+// newWorkloadService is an apply service whose prod cluster runs a
+// Deployment, StatefulSet and DaemonSet in namespace shop
+func newWorkloadService(t *testing.T) (*Service, *applyCluster) {
+	t.Helper()
+	s, cluster := newApplyService(t)
+	ctx := context.Background()
+	meta := metav1.ObjectMeta{Name: "web", Namespace: "shop", Generation: 4}
+
+	_, err := cluster.clientset.AppsV1().Deployments("shop").Create(ctx, &appsv1.Deployment{
+		ObjectMeta: meta,
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3), Template: podTemplate()},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 4},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = cluster.clientset.AppsV1().StatefulSets("shop").Create(ctx, &appsv1.StatefulSet{
+		ObjectMeta: meta,
+		Spec:       appsv1.StatefulSetSpec{Replicas: int32Ptr(2), Template: podTemplate()},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = cluster.clientset.AppsV1().DaemonSets("shop").Create(ctx, &appsv1.DaemonSet{
+		ObjectMeta: meta,
+		Spec:       appsv1.DaemonSetSpec{Template: podTemplate()},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return s, cluster
+}
+
+func TestRestartWorkload(t *testing.T) {
+	s, cluster := newWorkloadService(t)
+	ctx := context.Background()
+	apps := cluster.clientset.AppsV1()
+
+	status, err := s.RestartWorkload(ctx, testClusterID, "shop", "deployments", "web")
+	require.NoError(t, err)
+	assert.Equal(t, &WorkloadStatus{Kind: KindDeployment, Namespace: "shop", Name: "web", Generation: 4, ObservedGeneration: 4, Replicas: 3}, status)
+	d, err := apps.Deployments("shop").Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, d.Spec.Template.Annotations[restartedAtAnnotation])
+	assert.Equal(t, int32(3), *d.Spec.Replicas)
+	assert.Equal(t, "nginx", d.Spec.Template.Spec.Containers[0].Image)
+
+	_, err = s.RestartWorkload(ctx, testClusterID, "shop", "StatefulSet", "web")
+	require.NoError(t, err)
+	ss, err := apps.StatefulSets("shop").Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, ss.Spec.Template.Annotations[restartedAtAnnotation])
+	assert.Equal(t, int32(2), *ss.Spec.Replicas)
+
+	_, err = s.RestartWorkload(ctx, testClusterID, "shop", "daemonset", "web")
+	require.NoError(t, err)
+	ds, err := apps.DaemonSets("shop").Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, ds.Spec.Template.Annotations[restartedAtAnnotation])
+
+	_, err = s.RestartWorkload(ctx, testClusterID, "shop", "deployment", "missing")
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+	_, err = s.RestartWorkload(ctx, testClusterID, "shop", "cronjob", "web")
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
+
+func TestScaleWorkload(t *testing.T) {
+	s, cluster := newWorkloadService(t)
+	ctx := context.Background()
+
+	status, err := s.ScaleWorkload(ctx, testClusterID, "shop", "statefulset", "web", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), status.Replicas)
+	ss, err := cluster.clientset.AppsV1().StatefulSets("shop").Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), *ss.Spec.Replicas)
+
+	_, err = s.ScaleWorkload(ctx, testClusterID, "shop", "daemonset", "web", 2)
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+	_, err = s.ScaleWorkload(ctx, testClusterID, "shop", "deployment", "web", -1)
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
+
+func TestPauseAndResumeRollout(t *testing.T) {
+	s, cluster := newWorkloadService(t)
+	ctx := context.Background()
+
+	status, err := s.PauseRollout(ctx, testClusterID, "shop", "deployment", "web")
+	require.NoError(t, err)
+	assert.True(t, status.Paused)
+	d, err := cluster.clientset.AppsV1().Deployments("shop").Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, d.Spec.Paused)
+	assert.Equal(t, int32(3), *d.Spec.Replicas)
+
+	status, err = s.ResumeRollout(ctx, testClusterID, "shop", "deployment", "web")
+	require.NoError(t, err)
+	assert.False(t, status.Paused)
+
+	_, err = s.PauseRollout(ctx, testClusterID, "shop", "statefulset", "web")
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
@@ -0,0 +1,266 @@
+// Package clusterconfig stores per-cluster overrides of global settings and
+// resolves the settings in effect for a cluster
+// Author: Anubhav Gain <anubhavg@infopercept.com>
+package clusterconfig
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// defaultCacheTTL is how long a replica serves overrides it has read; a
+// change made through another replica takes effect here within it
+const defaultCacheTTL = 30 * time.Second
+
+// Cost providers an override may name
+var costProviders = map[string]bool{"aws": true, "gcp": true, "azure": true, "on-prem": true}
+
+// Overrides are the settings a cluster overrides. Unset fields fall back
+// to the global configuration.
+type Overrides struct {
+	// RemediationDryRun overrides remediation dry run
+	RemediationDryRun *bool `json:"remediation_dry_run,omitempty"`
+	// CostCloudProvider and CostRegion override how the cluster is priced
+	CostCloudProvider string `json:"cost_cloud_provider,omitempty"`
+	CostRegion        string `json:"cost_region,omitempty"`
+	// GitOpsPrune and GitOpsSelfHeal override whether auto-sync of the
+	// cluster's applications may prune and self-heal
+	GitOpsPrune    *bool `json:"gitops_prune,omitempty"`
+	GitOpsSelfHeal *bool `json:"gitops_self_heal,omitempty"`
+}
+
+// empty reports whether o overrides nothing
+func (o Overrides) empty() bool { return o == Overrides{} }
+
+// Validate checks the overrides can be applied
+func (o Overrides) Validate() error {
+	if o.CostCloudProvider != "" && !costProviders[o.CostCloudProvider] {
+		return errors.Validation(fmt.Sprintf("unknown cost cloud provider %q", o.CostCloudProvider))
+	}
+	return nil
+}
+
+// ClusterConfig is the overrides of one cluster
+type ClusterConfig struct {
+	ClusterID string `json:"cluster_id"`
+	Overrides
+	UpdatedBy string     `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+type cachedOverrides struct {
+	overrides Overrides
+	expires   time.Time
+}
+
+// Store keeps cluster overrides in the cluster_configs table. Resolving a
+// setting for a cluster reads through a short cache, so changes take
+// effect without a restart. A nil Store overrides nothing.
+type Store struct {
+	db       *database.PostgresDB
+	recorder *audit.Recorder
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedOverrides
+}
+
+// NewStore creates a store backed by db
+func NewStore(db *database.PostgresDB) *Store {
+	return &Store{
+		db:    db,
+		ttl:   defaultCacheTTL,
+		now:   time.Now,
+		cache: map[string]cachedOverrides{},
+	}
+}
+
+// SetAuditRecorder sets where override changes are audited
+func (s *Store) SetAuditRecorder(r *audit.Recorder) { s.recorder = r }
+
+// GetClusterConfig returns the overrides of the cluster id; a cluster
+// without any has an empty config
+func (s *Store) GetClusterConfig(ctx context.Context, clusterID string) (*ClusterConfig, error) {
+	cfg := &ClusterConfig{ClusterID: clusterID}
+	var raw []byte
+	var updatedBy sql.NullString
+	var updatedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		"SELECT overrides, updated_by, updated_at FROM cluster_configs WHERE cluster_id = $1",
+		clusterID).Scan(&raw, &updatedBy, &updatedAt)
+	if err == sql.ErrNoRows {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to get cluster config")
+	}
+	if err := json.Unmarshal(raw, &cfg.Overrides); err != nil {
+		return nil, errors.InternalWrap(err, "failed to decode cluster config")
+	}
+	cfg.UpdatedBy = updatedBy.String
+	if updatedAt.Valid {
+		cfg.UpdatedAt = &updatedAt.Time
+	}
+	return cfg, nil
+}
+
+// SetClusterConfig replaces the overrides of cfg.ClusterID, recording the
+// change in the audit log. Empty overrides remove the cluster's config.
+func (s *Store) SetClusterConfig(ctx context.Context, cfg *ClusterConfig) error {
+	if cfg.ClusterID == "" {
+		return errors.Validation("cluster_id is required")
+	}
+	if err := cfg.Overrides.Validate(); err != nil {
+		return err
+	}
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM clusters WHERE id = $1)", cfg.ClusterID).Scan(&exists); err != nil {
+		return errors.DatabaseWrap(err, "failed to check cluster")
+	}
+	if !exists {
+		return errors.NotFound("cluster", cfg.ClusterID)
+	}
+
+	before, err := s.GetClusterConfig(ctx, cfg.ClusterID)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	if cfg.Overrides.empty() {
+		_, err = s.db.ExecContext(ctx, "DELETE FROM cluster_configs WHERE cluster_id = $1", cfg.ClusterID)
+	} else {
+		raw, _ := json.Marshal(cfg.Overrides)
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO cluster_configs (cluster_id, overrides, updated_by, updated_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (cluster_id) DO UPDATE
+			SET overrides = EXCLUDED.overrides, updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+		`, cfg.ClusterID, raw, cfg.UpdatedBy, now)
+	}
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to save cluster config")
+	}
+	cfg.UpdatedAt = &now
+
+	// Overrides are cached by cluster ID or name, so drop them all
+	s.mu.Lock()
+	s.cache = map[string]cachedOverrides{}
+	s.mu.Unlock()
+
+	if !reflect.DeepEqual(before.Overrides, cfg.Overrides) {
+		s.recordChange(ctx, cfg.ClusterID, before.Overrides, cfg.Overrides)
+	}
+	logger.Info("Cluster config updated", zap.String("cluster_id", cfg.ClusterID), zap.String("updated_by", cfg.UpdatedBy))
+	return nil
+}
+
+// recordChange audits a change of overrides. The change is already made,
+// so failures are only logged.
+func (s *Store) recordChange(ctx context.Context, clusterID string, before, after Overrides) {
+	err := s.recorder.Record(ctx, audit.Entry{
+		Action:       "cluster_config.update",
+		ResourceType: "cluster_config",
+		ResourceID:   clusterID,
+		ClusterID:    clusterID,
+		OldValue:     audit.Snapshot(before),
+		NewValue:     audit.Snapshot(after),
+	})
+	if err != nil {
+		logger.Warn("Failed to record audit log",
+			zap.String("action", "cluster_config.update"),
+			zap.String("resource_id", clusterID),
+			zap.Error(err),
+		)
+	}
+}
+
+// overrides returns the overrides of the cluster with the ID or name
+// cluster. Failing to read them is logged and overrides nothing, so
+// services carry on with the global settings.
+func (s *Store) overrides(ctx context.Context, cluster string) Overrides {
+	if s == nil || cluster == "" {
+		return Overrides{}
+	}
+	now := s.now()
+	s.mu.Lock()
+	cached, ok := s.cache[cluster]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.overrides
+	}
+
+	var o Overrides
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT cc.overrides FROM cluster_configs cc
+		WHERE CAST(cc.cluster_id AS TEXT) = $1
+		   OR cc.cluster_id IN (SELECT id FROM clusters WHERE name = $1)
+		LIMIT 1
+	`, cluster).Scan(&raw)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		logger.Warn("Failed to read cluster config, using global settings", zap.String("cluster", cluster), zap.Error(err))
+		return Overrides{}
+	default:
+		if err := json.Unmarshal(raw, &o); err != nil {
+			logger.Warn("Invalid cluster config, using global settings", zap.String("cluster", cluster), zap.Error(err))
+			return Overrides{}
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[cluster] = cachedOverrides{overrides: o, expires: now.Add(s.ttl)}
+	s.mu.Unlock()
+	return o
+}
+
+// RemediationDryRun returns whether remediation on cluster, an ID or
+// name, is a dry run: its override, or global
+func (s *Store) RemediationDryRun(ctx context.Context, cluster string, global bool) bool {
+	if o := s.overrides(ctx, cluster); o.RemediationDryRun != nil {
+		return *o.RemediationDryRun
+	}
+	return global
+}
+
+// CostProvider returns the cloud provider and region cluster, an ID or
+// name, is priced with: its overrides, or the global ones. Overriding the
+// provider alone doesn't carry the global region over to it.
+func (s *Store) CostProvider(ctx context.Context, cluster, globalProvider, globalRegion string) (string, string) {
+	o := s.overrides(ctx, cluster)
+	provider, region := globalProvider, globalRegion
+	if o.CostCloudProvider != "" && o.CostCloudProvider != globalProvider {
+		provider, region = o.CostCloudProvider, ""
+	}
+	if o.CostRegion != "" {
+		region = o.CostRegion
+	}
+	return provider, region
+}
+
+// GitOpsSyncPolicy returns whether auto-sync on cluster, an ID or name,
+// may prune and self-heal: its overrides, or the global settings
+func (s *Store) GitOpsSyncPolicy(ctx context.Context, cluster string, prune, selfHeal bool) (bool, bool) {
+	o := s.overrides(ctx, cluster)
+	if o.GitOpsPrune != nil {
+		prune = *o.GitOpsPrune
+	}
+	if o.GitOpsSelfHeal != nil {
+		selfHeal = *o.GitOpsSelfHeal
+	}
+	return prune, selfHeal
+}
@@ -0,0 +1,180 @@
+package clusterconfig
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const prodID = "7f1c2a4e-0000-4000-8000-000000000001"
+
+func newTestStore(t *testing.T) (*Store, *sql.DB) {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	for _, stmt := range []string{
+		`CREATE TABLE clusters (id TEXT PRIMARY KEY, name TEXT UNIQUE)`,
+		`CREATE TABLE cluster_configs (cluster_id TEXT PRIMARY KEY, overrides TEXT NOT NULL DEFAULT '{}',
+			updated_by TEXT, updated_at TIMESTAMP)`,
+	} {
+		_, err = sqlDB.Exec(stmt)
+		require.NoError(t, err)
+	}
+	_, err = sqlDB.Exec(`INSERT INTO clusters VALUES ($1, 'prod')`, prodID)
+	require.NoError(t, err)
+
+	store := NewStore(&database.PostgresDB{DB: sqlDB})
+	store.SetAuditRecorder(audittest.NewRecorder(t, sqlDB))
+	return store, sqlDB
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestResolveFallsBackToGlobal(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	for _, s := range []*Store{store, nil} {
+		assert.True(t, s.RemediationDryRun(ctx, prodID, true))
+		provider, region := s.CostProvider(ctx, prodID, "aws", "us-east-1")
+		assert.Equal(t, "aws", provider)
+		assert.Equal(t, "us-east-1", region)
+		prune, selfHeal := s.GitOpsSyncPolicy(ctx, prodID, true, false)
+		assert.True(t, prune)
+		assert.False(t, selfHeal)
+	}
+
+	cfg, err := store.GetClusterConfig(ctx, prodID)
+	require.NoError(t, err)
+	assert.Equal(t, &ClusterConfig{ClusterID: prodID}, cfg)
+}
+
+func TestOverridesTakePrecedence(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.SetClusterConfig(ctx, &ClusterConfig{
+		ClusterID: prodID,
+		Overrides: Overrides{
+			RemediationDryRun: boolPtr(false),
+			CostCloudProvider: "gcp",
+			GitOpsSelfHeal:    boolPtr(true),
+		},
+		UpdatedBy: "alice",
+	}))
+
+	// Clusters resolve by ID or by name
+	for _, cluster := range []string{prodID, "prod"} {
+		assert.False(t, store.RemediationDryRun(ctx, cluster, true))
+
+		// The global region belongs to the global provider
+		provider, region := store.CostProvider(ctx, cluster, "aws", "us-east-1")
+		assert.Equal(t, "gcp", provider)
+		assert.Empty(t, region)
+
+		// Prune isn't overridden, so stays global
+		prune, selfHeal := store.GitOpsSyncPolicy(ctx, cluster, false, false)
+		assert.False(t, prune)
+		assert.True(t, selfHeal)
+	}
+
+	// Other clusters keep the global settings
+	assert.True(t, store.RemediationDryRun(ctx, "staging", true))
+
+	cfg, err := store.GetClusterConfig(ctx, prodID)
+	require.NoError(t, err)
+	assert.Equal(t, "gcp", cfg.CostCloudProvider)
+	assert.Equal(t, "alice", cfg.UpdatedBy)
+	assert.NotNil(t, cfg.UpdatedAt)
+}
+
+func TestCostRegionOverride(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.SetClusterConfig(ctx, &ClusterConfig{
+		ClusterID: prodID,
+		Overrides: Overrides{CostCloudProvider: "aws", CostRegion: "eu-west-1"},
+	}))
+	provider, region := store.CostProvider(ctx, prodID, "aws", "us-east-1")
+	assert.Equal(t, "aws", provider)
+	assert.Equal(t, "eu-west-1", region)
+}
+
+func TestChangesTakeEffectWithoutRestart(t *testing.T) {
+	store, db := newTestStore(t)
+	ctx := context.Background()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	assert.True(t, store.RemediationDryRun(ctx, prodID, true))
+
+	// A change through this store applies at once
+	require.NoError(t, store.SetClusterConfig(ctx, &ClusterConfig{
+		ClusterID: prodID,
+		Overrides: Overrides{RemediationDryRun: boolPtr(false)},
+	}))
+	assert.False(t, store.RemediationDryRun(ctx, prodID, true))
+
+	// One made elsewhere applies once the cached overrides expire
+	_, err := db.Exec(`UPDATE cluster_configs SET overrides = '{"remediation_dry_run":true}'`)
+	require.NoError(t, err)
+	assert.False(t, store.RemediationDryRun(ctx, prodID, false))
+	now = now.Add(defaultCacheTTL)
+	assert.True(t, store.RemediationDryRun(ctx, prodID, false))
+
+	// Empty overrides remove the config
+	require.NoError(t, store.SetClusterConfig(ctx, &ClusterConfig{ClusterID: prodID}))
+	assert.False(t, store.RemediationDryRun(ctx, prodID, false))
+	var rows int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM cluster_configs`).Scan(&rows))
+	assert.Zero(t, rows)
+}
+
+func TestSetClusterConfigIsAudited(t *testing.T) {
+	store, db := newTestStore(t)
+	ctx := context.Background()
+
+	cfg := &ClusterConfig{ClusterID: prodID, Overrides: Overrides{GitOpsPrune: boolPtr(false)}}
+	require.NoError(t, store.SetClusterConfig(ctx, cfg))
+	// Saving the same overrides again changes nothing worth auditing
+	require.NoError(t, store.SetClusterConfig(ctx, cfg))
+
+	var action, resourceType, resourceID, oldValue, newValue string
+	require.NoError(t, db.QueryRow(`SELECT action, resource_type, resource_id, old_value, new_value FROM audit_logs`).
+		Scan(&action, &resourceType, &resourceID, &oldValue, &newValue))
+	assert.Equal(t, "cluster_config.update", action)
+	assert.Equal(t, "cluster_config", resourceType)
+	assert.Equal(t, prodID, resourceID)
+	assert.JSONEq(t, `{}`, oldValue)
+	assert.JSONEq(t, `{"gitops_prune": false}`, newValue)
+
+	var entries int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM audit_logs`).Scan(&entries))
+	assert.Equal(t, 1, entries)
+}
+
+func TestSetClusterConfigValidates(t *testing.T) {
+	store, _ := newTestStore(t)
+	ctx := context.Background()
+
+	err := store.SetClusterConfig(ctx, &ClusterConfig{ClusterID: prodID, Overrides: Overrides{CostCloudProvider: "oracle"}})
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+
+	err = store.SetClusterConfig(ctx, &ClusterConfig{ClusterID: "missing", Overrides: Overrides{GitOpsPrune: boolPtr(true)}})
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+}
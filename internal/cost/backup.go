@@ -0,0 +1,76 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// budgetsBackup is the backup form of the budgets. Spend, status and
+// alerts are derived from usage and not carried over.
+type budgetsBackup struct {
+	Budgets []Budget `json:"budgets"`
+}
+
+// ExportBackup returns the budget definitions for a Krustron backup
+func (s *Service) ExportBackup(ctx context.Context) ([]byte, error) {
+	var budgets []Budget
+	if err := s.db.WithContext(ctx).Order("created_at").Find(&budgets).Error; err != nil {
+		return nil, fmt.Errorf("failed to list budgets: %w", err)
+	}
+	for i := range budgets {
+		budgets[i].CurrentSpend = 0
+		budgets[i].ForecastSpend = 0
+		budgets[i].Status = ""
+	}
+	return json.Marshal(budgetsBackup{Budgets: budgets})
+}
+
+// RestoreBackup writes the budgets in data through tx, keeping their IDs:
+// existing budgets get the backed-up definition, missing ones are created.
+// It returns the number of budgets restored.
+func (s *Service) RestoreBackup(ctx context.Context, tx *gorm.DB, data []byte) (int, error) {
+	var backup budgetsBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return 0, fmt.Errorf("invalid budgets backup: %w", err)
+	}
+
+	now := time.Now()
+	for i := range backup.Budgets {
+		budget := backup.Budgets[i]
+		if budget.ID == "" || budget.Name == "" {
+			return 0, fmt.Errorf("budget %d: id and name are required", i+1)
+		}
+		budget.Currency = strings.ToUpper(budget.Currency)
+		budget.Alerts = nil
+		budget.UpdatedAt = now
+
+		var existing Budget
+		err := tx.WithContext(ctx).Select("id", "current_spend", "forecast_spend", "status").
+			First(&existing, "id = ?", budget.ID).Error
+		switch {
+		case err == nil:
+			budget.CurrentSpend = existing.CurrentSpend
+			budget.ForecastSpend = existing.ForecastSpend
+			budget.Status = existing.Status
+			if err := tx.WithContext(ctx).Omit("Alerts", "created_at").Save(&budget).Error; err != nil {
+				return 0, fmt.Errorf("failed to restore budget %s: %w", budget.Name, err)
+			}
+		case err == gorm.ErrRecordNotFound:
+			budget.Status = "on_track"
+			if budget.CreatedAt.IsZero() {
+				budget.CreatedAt = now
+			}
+			if err := tx.WithContext(ctx).Omit("Alerts").Create(&budget).Error; err != nil {
+				return 0, fmt.Errorf("failed to restore budget %s: %w", budget.Name, err)
+			}
+		default:
+			return 0, fmt.Errorf("failed to look up budget %s: %w", budget.Name, err)
+		}
+	}
+	return len(backup.Budgets), nil
+}
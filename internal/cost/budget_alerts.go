@@ -0,0 +1,263 @@
+package cost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	budgetAlertThreshold = "threshold"
+	budgetAlertForecast  = "forecast"
+)
+
+// EventBudgetAlert is the event type of budget alerts, for notification
+// routes
+const EventBudgetAlert = "cost.budget_alert"
+
+// NotificationTarget is a destination for budget alerts
+type NotificationTarget struct {
+	Type    string `json:"type"`    // email, slack, teams, pagerduty, webhook
+	Address string `json:"address"` // email address, webhook URL or PagerDuty routing key
+}
+
+// raiseBudgetAlert records an alert unless one of the same type and
+// threshold already exists for the budget's current period
+func (s *Service) raiseBudgetAlert(budget *Budget, alertType string, threshold float64, severity, message string) {
+	var count int64
+	if err := s.db.Model(&BudgetAlert{}).
+		Where("budget_id = ? AND type = ? AND threshold = ? AND period_start = ?",
+			budget.ID, alertType, threshold, budget.PeriodStart).
+		Count(&count).Error; err != nil {
+		s.logger.Error("Failed to check existing budget alerts", zap.Error(err))
+		return
+	}
+	if count > 0 {
+		return
+	}
+
+	alert := &BudgetAlert{
+		ID:           uuid.New().String(),
+		BudgetID:     budget.ID,
+		Type:         alertType,
+		PeriodStart:  budget.PeriodStart,
+		Threshold:    threshold,
+		CurrentSpend: budget.CurrentSpend,
+		Message:      message,
+		Severity:     severity,
+		Notified:     false,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.db.Create(alert).Error; err != nil {
+		s.logger.Error("Failed to create budget alert", zap.Error(err))
+	}
+}
+
+// checkBudgetForecast updates ForecastSpend from a forecast of the rest of
+// the budget period and raises a forecast alert if it exceeds the amount
+// while actual spend is still below it. The forecast itself is not saved.
+func (s *Service) checkBudgetForecast(ctx context.Context, budget *Budget) {
+	remaining := time.Until(budget.PeriodEnd)
+	if remaining <= 0 {
+		return
+	}
+	days := int(math.Ceil(remaining.Hours() / 24))
+
+	forecast, err := s.computeForecast(ctx, budget.Scope, budget.ScopeValue, days, forecastModelSeasonal)
+	if err != nil {
+		s.logger.Debug("Skipping budget forecast", zap.String("budget_id", budget.ID), zap.Error(err))
+		return
+	}
+
+	remainingCost, _, _, err := s.convert(ctx, forecast.ForecastCost, s.config.DefaultCurrency, budget.Currency)
+	if err != nil {
+		s.logger.Warn("Skipping budget forecast", zap.String("budget_id", budget.ID), zap.Error(err))
+		return
+	}
+
+	budget.ForecastSpend = budget.CurrentSpend + remainingCost
+	if err := s.db.Model(&Budget{}).Where("id = ?", budget.ID).
+		Update("forecast_spend", budget.ForecastSpend).Error; err != nil {
+		s.logger.Warn("Failed to save budget forecast", zap.String("budget_id", budget.ID), zap.Error(err))
+	}
+
+	if budget.CurrentSpend >= budget.Amount || budget.ForecastSpend <= budget.Amount {
+		return
+	}
+	s.raiseBudgetAlert(budget, budgetAlertForecast, 100, "warning",
+		fmt.Sprintf("Budget %s is projected to exceed its amount before %s (forecast %.2f of %.2f %s)",
+			budget.Name, budget.PeriodEnd.Format("2006-01-02"), budget.ForecastSpend, budget.Amount, budget.Currency))
+}
+
+// DispatchBudgetAlerts sends every un-notified budget alert to the budget's
+// notification targets, and the notification routes if a notifier is set,
+// and marks it notified. An alert is claimed before it
+// is sent so concurrent dispatchers don't double-send, and released again if
+// every target failed. It returns the number of alerts sent.
+func (s *Service) DispatchBudgetAlerts(ctx context.Context) (int, error) {
+	var alerts []BudgetAlert
+	if err := s.db.WithContext(ctx).Where("notified = ?", false).Order("created_at").Find(&alerts).Error; err != nil {
+		return 0, fmt.Errorf("failed to list pending budget alerts: %w", err)
+	}
+
+	sent := 0
+	budgets := make(map[string]*Budget)
+	for i := range alerts {
+		alert := &alerts[i]
+
+		budget, ok := budgets[alert.BudgetID]
+		if !ok {
+			budget = &Budget{}
+			err := s.db.WithContext(ctx).First(budget, "id = ?", alert.BudgetID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				budget = nil
+			} else if err != nil {
+				return sent, fmt.Errorf("failed to load budget: %w", err)
+			}
+			budgets[alert.BudgetID] = budget
+		}
+
+		targets := s.config.BudgetNotifications
+		if budget != nil && len(budget.NotificationTargets) > 0 {
+			targets = budget.NotificationTargets
+		}
+		if budget == nil || len(targets) == 0 && s.notifier == nil {
+			continue
+		}
+
+		claimed, err := s.claimBudgetAlert(ctx, alert)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+
+		delivered := 0
+		for _, target := range targets {
+			if err := s.sendBudgetAlert(ctx, target, budget, alert); err != nil {
+				s.logger.Warn("Failed to send budget alert",
+					zap.String("alert_id", alert.ID),
+					zap.String("target", target.Type),
+					zap.Error(err),
+				)
+				continue
+			}
+			delivered++
+		}
+		if s.notifier != nil {
+			err := s.notifier.Notify(ctx, budgetNotification(budget, alert))
+			switch {
+			case err == nil:
+				delivered++
+			case !errors.Is(err, notify.ErrNotRouted):
+				s.logger.Warn("Failed to route budget alert", zap.String("alert_id", alert.ID), zap.Error(err))
+			}
+		}
+
+		if delivered == 0 {
+			s.db.WithContext(ctx).Model(&BudgetAlert{}).Where("id = ?", alert.ID).
+				Updates(map[string]interface{}{"notified": false, "notified_at": nil})
+			continue
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// claimBudgetAlert marks alert notified if it still isn't and reports
+// whether the caller should send it. Alerts for a budget, type, threshold and
+// period that another row already notified are settled without sending.
+func (s *Service) claimBudgetAlert(ctx context.Context, alert *BudgetAlert) (bool, error) {
+	var duplicates int64
+	if err := s.db.WithContext(ctx).Model(&BudgetAlert{}).
+		Where("id <> ? AND budget_id = ? AND type = ? AND threshold = ? AND period_start = ? AND notified = ?",
+			alert.ID, alert.BudgetID, alert.Type, alert.Threshold, alert.PeriodStart, true).
+		Count(&duplicates).Error; err != nil {
+		return false, fmt.Errorf("failed to check duplicate budget alerts: %w", err)
+	}
+
+	now := time.Now()
+	result := s.db.WithContext(ctx).Model(&BudgetAlert{}).
+		Where("id = ? AND notified = ?", alert.ID, false).
+		Updates(map[string]interface{}{"notified": true, "notified_at": now})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim budget alert: %w", result.Error)
+	}
+	if result.RowsAffected == 0 || duplicates > 0 {
+		return false, nil
+	}
+
+	alert.Notified = true
+	alert.NotifiedAt = &now
+	return true, nil
+}
+
+// sendBudgetAlert sends an alert to one of the budget's targets
+func (s *Service) sendBudgetAlert(ctx context.Context, target NotificationTarget, budget *Budget, alert *BudgetAlert) error {
+	n := budgetNotification(budget, alert)
+	switch target.Type {
+	case notify.TypeSlack:
+		return notify.NewSlack(s.webhookClient, target.Address, "").Send(ctx, n)
+	case notify.TypeTeams:
+		return notify.NewTeams(s.webhookClient, target.Address).Send(ctx, n)
+	case notify.TypePagerDuty:
+		return notify.NewPagerDuty(s.webhookClient, target.Address, "").Send(ctx, n)
+	case notify.TypeWebhook:
+		n.Payload = map[string]interface{}{
+			"alert_id":      alert.ID,
+			"type":          alert.Type,
+			"severity":      alert.Severity,
+			"threshold":     alert.Threshold,
+			"message":       alert.Message,
+			"budget_id":     budget.ID,
+			"budget_name":   budget.Name,
+			"amount":        budget.Amount,
+			"currency":      budget.Currency,
+			"current_spend": alert.CurrentSpend,
+			"forecast":      budget.ForecastSpend,
+			"period_start":  budget.PeriodStart,
+			"period_end":    budget.PeriodEnd,
+			"timestamp":     time.Now(),
+		}
+		return notify.NewWebhook(s.webhookClient, target.Address, "").Send(ctx, n)
+	case notify.TypeEmail:
+		return notify.NewEmail(notify.SMTPConfig{
+			Addr:     s.config.SMTPAddr,
+			From:     s.config.SMTPFrom,
+			Username: s.config.SMTPUsername,
+			Password: s.config.SMTPPassword,
+		}, []string{target.Address}).Send(ctx, n)
+	default:
+		return fmt.Errorf("unsupported notification target: %s", target.Type)
+	}
+}
+
+// budgetNotification describes a budget alert for notification channels.
+// Alerts of a budget, type and threshold share a PagerDuty incident.
+func budgetNotification(budget *Budget, alert *BudgetAlert) *notify.Notification {
+	return &notify.Notification{
+		Event:    EventBudgetAlert,
+		Severity: alert.Severity,
+		Title:    fmt.Sprintf("Budget alert: %s", budget.Name),
+		Message:  alert.Message,
+		Fields: []notify.Field{
+			{Name: "Budget", Value: budget.Name},
+			{Name: "Amount", Value: fmt.Sprintf("%.2f %s", budget.Amount, budget.Currency)},
+			{Name: "Current spend", Value: fmt.Sprintf("%.2f %s", alert.CurrentSpend, budget.Currency)},
+			{Name: "Forecast", Value: fmt.Sprintf("%.2f %s", budget.ForecastSpend, budget.Currency)},
+			{Name: "Period", Value: budget.PeriodStart.Format("2006-01-02") + " to " + budget.PeriodEnd.Format("2006-01-02")},
+		},
+		Source:    "cost",
+		DedupKey:  fmt.Sprintf("krustron:budget:%s:%s:%g", budget.ID, alert.Type, alert.Threshold),
+		Timestamp: alert.CreatedAt,
+	}
+}
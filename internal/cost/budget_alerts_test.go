@@ -0,0 +1,230 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/httpsafe"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newBudgetTestService(t *testing.T) *Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	// Alerts go to test servers on loopback
+	svc, err := NewService(db, zap.NewNop(), &Config{
+		Egress: httpsafe.Policy{AllowHTTP: true, AllowedCIDRs: []string{"127.0.0.0/8"}},
+	})
+	require.NoError(t, err)
+	return svc
+}
+
+// webhookRecorder collects the alert types posted to it
+type webhookRecorder struct {
+	mu    sync.Mutex
+	types []string
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Type      string  `json:"type"`
+		Threshold float64 `json:"threshold"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	w.mu.Lock()
+	w.types = append(w.types, payload.Type)
+	w.mu.Unlock()
+}
+
+func (w *webhookRecorder) sent() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.types...)
+}
+
+func addSpend(t *testing.T, svc *Service, day time.Time, cost float64) {
+	t.Helper()
+	require.NoError(t, svc.db.Create(&CostAllocation{
+		ID:          uuid.New().String(),
+		ClusterID:   "c1",
+		Namespace:   "payments",
+		TotalCost:   cost,
+		PeriodStart: day,
+		PeriodEnd:   day.Add(time.Hour),
+	}).Error)
+}
+
+func TestBudgetAlertsDedup(t *testing.T) {
+	ctx := context.Background()
+	svc := newBudgetTestService(t)
+
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	now := time.Now().UTC()
+	budget := &Budget{
+		Name:                "payments",
+		Amount:              100,
+		Currency:            "USD",
+		Scope:               "namespace",
+		ScopeValue:          "payments",
+		AlertThresholds:     []float64{50, 90},
+		PeriodStart:         now.AddDate(0, 0, -10),
+		PeriodEnd:           now.AddDate(0, 0, 20),
+		NotificationTargets: []NotificationTarget{{Type: "webhook", Address: server.URL}},
+	}
+	require.NoError(t, svc.CreateBudget(ctx, budget))
+	addSpend(t, svc, now.Add(-2*time.Hour), 95)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, svc.CheckBudgetAlerts(ctx))
+	}
+
+	var alerts []BudgetAlert
+	require.NoError(t, svc.db.Where("budget_id = ?", budget.ID).Find(&alerts).Error)
+	assert.Len(t, alerts, 2, "one alert per threshold regardless of how often checks run")
+
+	sent, err := svc.DispatchBudgetAlerts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	// Further checks and dispatches don't re-send the same thresholds
+	require.NoError(t, svc.CheckBudgetAlerts(ctx))
+	sent, err = svc.DispatchBudgetAlerts(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+	assert.Equal(t, []string{budgetAlertThreshold, budgetAlertThreshold}, recorder.sent())
+
+	require.NoError(t, svc.db.Where("budget_id = ?", budget.ID).Find(&alerts).Error)
+	for _, alert := range alerts {
+		assert.True(t, alert.Notified)
+		assert.NotNil(t, alert.NotifiedAt)
+	}
+}
+
+func TestBudgetAlertsKeptPendingWithoutTargets(t *testing.T) {
+	ctx := context.Background()
+	svc := newBudgetTestService(t)
+
+	now := time.Now().UTC()
+	budget := &Budget{
+		Name: "no-targets", Amount: 10, AlertThresholds: []float64{50},
+		PeriodStart: now.AddDate(0, 0, -1), PeriodEnd: now.AddDate(0, 0, 1),
+	}
+	require.NoError(t, svc.CreateBudget(ctx, budget))
+	addSpend(t, svc, now.Add(-time.Hour), 8)
+	require.NoError(t, svc.CheckBudgetAlerts(ctx))
+
+	sent, err := svc.DispatchBudgetAlerts(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+
+	var pending int64
+	require.NoError(t, svc.db.Model(&BudgetAlert{}).Where("notified = ?", false).Count(&pending).Error)
+	assert.Equal(t, int64(1), pending)
+}
+
+func TestBudgetForecastAlert(t *testing.T) {
+	ctx := context.Background()
+	svc := newBudgetTestService(t)
+
+	now := time.Now().UTC()
+	budget := &Budget{
+		Name:            "growing",
+		Amount:          300,
+		Scope:           "namespace",
+		ScopeValue:      "payments",
+		AlertThresholds: []float64{100},
+		PeriodStart:     now.AddDate(0, 0, -14),
+		PeriodEnd:       now.AddDate(0, 0, 14),
+	}
+	require.NoError(t, svc.CreateBudget(ctx, budget))
+
+	// Daily spend growing from 10 to 23: 231 spent and rising with 14 days left
+	for d := 14; d >= 1; d-- {
+		addSpend(t, svc, now.AddDate(0, 0, -d), 10+float64(14-d))
+	}
+
+	require.NoError(t, svc.CheckBudgetAlerts(ctx))
+	require.NoError(t, svc.CheckBudgetAlerts(ctx))
+
+	var alerts []BudgetAlert
+	require.NoError(t, svc.db.Where("budget_id = ?", budget.ID).Find(&alerts).Error)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, budgetAlertForecast, alerts[0].Type)
+
+	stored, err := svc.GetBudget(ctx, budget.ID)
+	require.NoError(t, err)
+	assert.Greater(t, stored.ForecastSpend, stored.Amount)
+
+	var forecasts int64
+	require.NoError(t, svc.db.Model(&CostForecast{}).Count(&forecasts).Error)
+	assert.Zero(t, forecasts, "budget checks don't save their forecasts")
+}
+
+// recordingDispatcher keeps the notifications routed through it
+type recordingDispatcher struct {
+	mu   sync.Mutex
+	sent []*notify.Notification
+	err  error
+}
+
+func (d *recordingDispatcher) Notify(ctx context.Context, n *notify.Notification) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	d.sent = append(d.sent, n)
+	return nil
+}
+
+func TestBudgetAlertsRoutedThroughNotifier(t *testing.T) {
+	ctx := context.Background()
+	svc := newBudgetTestService(t)
+	dispatcher := &recordingDispatcher{err: notify.ErrNotRouted}
+	svc.SetNotifier(dispatcher)
+
+	now := time.Now().UTC()
+	budget := &Budget{
+		Name: "routed", Amount: 10, Currency: "USD", AlertThresholds: []float64{50},
+		PeriodStart: now.AddDate(0, 0, -1), PeriodEnd: now.AddDate(0, 0, 1),
+	}
+	require.NoError(t, svc.CreateBudget(ctx, budget))
+	addSpend(t, svc, now.Add(-time.Hour), 8)
+	require.NoError(t, svc.CheckBudgetAlerts(ctx))
+
+	// Without a matching route the alert stays pending
+	sent, err := svc.DispatchBudgetAlerts(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+
+	dispatcher.err = nil
+	sent, err = svc.DispatchBudgetAlerts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.Len(t, dispatcher.sent, 1)
+	n := dispatcher.sent[0]
+	assert.Equal(t, EventBudgetAlert, n.Event)
+	assert.Equal(t, "Budget alert: routed", n.Title)
+	assert.Equal(t, "routed", n.Field("Budget"))
+	assert.Equal(t, "10.00 USD", n.Field("Amount"))
+
+	sent, err = svc.DispatchBudgetAlerts(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+}
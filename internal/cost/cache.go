@@ -0,0 +1,140 @@
+package cost
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"go.uber.org/zap"
+)
+
+// maxCachedMonths is the longest period, in calendar months, a result is
+// cached for. Longer reports are rare and would need a tag per month.
+const maxCachedMonths = 36
+
+// SetCache caches cost summaries and reports in c for Config.CacheTTL when
+// Config.CacheEnabled is set. Optional: nil-safe.
+func (s *Service) SetCache(c *cache.RedisCache) { s.resultCache = c }
+
+func (s *Service) cachingEnabled() bool {
+	return s.resultCache != nil && s.config.CacheEnabled
+}
+
+// summaryCacheKey identifies the summary of the month starting at month
+// in currency
+func summaryCacheKey(month time.Time, currency string) string {
+	return cache.BuildKey(cache.PrefixCost, "summary", month.Format("2006-01"), currency)
+}
+
+// reportCacheKey identifies a report by everything it is generated from
+func reportCacheKey(req ReportRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return cache.BuildKey(cache.PrefixCost, "report", hex.EncodeToString(sum[:]))
+}
+
+// periodTagKey is the set of cached keys computed over the month
+func periodTagKey(month string) string {
+	return cache.BuildKey(cache.PrefixCost, "period", month)
+}
+
+// summaryTagKey is the set of cached summaries, in every currency
+func summaryTagKey() string {
+	return cache.BuildKey(cache.PrefixCost, "summaries")
+}
+
+// periodMonths returns the UTC calendar months, as YYYY-MM, that start to
+// end touches
+func periodMonths(start, end time.Time) []string {
+	start, end = start.UTC(), end.UTC()
+	if end.Before(start) {
+		end = start
+	}
+	month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var months []string
+	for !month.After(end) {
+		months = append(months, month.Format("2006-01"))
+		month = month.AddDate(0, 1, 0)
+	}
+	return months
+}
+
+// cachedResult reads the result cached at key into dest, reporting
+// whether there was one
+func (s *Service) cachedResult(ctx context.Context, key string, dest interface{}) bool {
+	if !s.cachingEnabled() {
+		return false
+	}
+	err := s.resultCache.Get(ctx, key, dest)
+	if err != nil && !cache.IsCacheMiss(err) {
+		s.logger.Warn("Failed to read cached cost result", zap.String("key", key), zap.Error(err))
+	}
+	return err == nil
+}
+
+// cacheResult caches value at key and tags it with the months start to end
+// touches, so ingesting allocations for any of them drops it, and with any
+// extra tags
+func (s *Service) cacheResult(ctx context.Context, key string, value interface{}, start, end time.Time, extra ...string) {
+	if !s.cachingEnabled() {
+		return
+	}
+	months := periodMonths(start, end)
+	if len(months) > maxCachedMonths {
+		return
+	}
+	ttl := s.config.CacheTTL
+	if err := s.resultCache.Set(ctx, key, value, ttl); err != nil {
+		s.logger.Warn("Failed to cache cost result", zap.String("key", key), zap.Error(err))
+		return
+	}
+	tags := extra
+	for _, month := range months {
+		tags = append(tags, periodTagKey(month))
+	}
+	for _, tag := range tags {
+		if err := s.resultCache.SAdd(ctx, tag, key); err != nil {
+			// Untagged, the entry would outlive an ingest; drop it
+			s.logger.Warn("Failed to tag cached cost result", zap.String("key", key), zap.Error(err))
+			_ = s.resultCache.Delete(ctx, key)
+			return
+		}
+		// A tag only needs to outlive the entries in it
+		_ = s.resultCache.Expire(ctx, tag, ttl)
+	}
+}
+
+// invalidatePeriod drops the cached results computed over any month start
+// to end touches. Called after allocations for that period are written.
+func (s *Service) invalidatePeriod(ctx context.Context, start, end time.Time) {
+	if !s.cachingEnabled() {
+		return
+	}
+	for _, month := range periodMonths(start, end) {
+		s.invalidateTag(ctx, periodTagKey(month))
+	}
+}
+
+// invalidateSummaries drops every cached summary. Called after
+// recommendations, whose savings summaries include, are written.
+func (s *Service) invalidateSummaries(ctx context.Context) {
+	if !s.cachingEnabled() {
+		return
+	}
+	s.invalidateTag(ctx, summaryTagKey())
+}
+
+// invalidateTag drops the cached results tagged with tag, and the tag
+func (s *Service) invalidateTag(ctx context.Context, tag string) {
+	keys, err := s.resultCache.SMembers(ctx, tag)
+	if err != nil {
+		s.logger.Warn("Failed to read cached cost results", zap.String("tag", tag), zap.Error(err))
+		return
+	}
+	if err := s.resultCache.Delete(ctx, append(keys, tag)...); err != nil {
+		s.logger.Warn("Failed to invalidate cached cost results", zap.String("tag", tag), zap.Error(err))
+	}
+}
@@ -0,0 +1,156 @@
+package cost
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newCacheTestService(t *testing.T) (*Service, *miniredis.Miniredis) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{CacheEnabled: true, CacheTTL: time.Minute})
+	require.NoError(t, err)
+
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redisCache, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	t.Cleanup(func() { redisCache.Close() })
+	svc.SetCache(redisCache)
+	return svc, mr
+}
+
+// addAllocation writes an allocation straight to the database, as a write
+// the cache doesn't hear about
+func addAllocation(t *testing.T, svc *Service, start time.Time, cost float64) {
+	t.Helper()
+	require.NoError(t, svc.db.Create(&CostAllocation{
+		ID: uuid.NewString(), ClusterID: "prod", Namespace: "payments", WorkloadName: "api",
+		TotalCost: cost, PeriodStart: start, PeriodEnd: start.Add(time.Hour),
+	}).Error)
+}
+
+// kubecostHour is an hour of Kubecost allocation starting at start
+func kubecostHour(start time.Time, cost float64) kubecostAllocation {
+	a := kubecostAllocation{Name: "payments/api", Start: start, End: start.Add(time.Hour), TotalCost: cost}
+	a.Properties.Cluster = "prod"
+	a.Properties.Namespace = "payments"
+	a.Properties.Controller = "api"
+	a.Properties.ControllerKind = "deployment"
+	return a
+}
+
+func TestCostSummaryCachedUntilIngest(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newCacheTestService(t)
+	now := time.Now().UTC()
+
+	addAllocation(t, svc, now, 10)
+	summary, err := svc.GetCostSummary(ctx, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, summary.CurrentMonthCost)
+
+	// Repeats are served from the cache
+	addAllocation(t, svc, now, 5)
+	summary, err = svc.GetCostSummary(ctx, "usd", false)
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, summary.CurrentMonthCost)
+
+	summary, err = svc.GetCostSummary(ctx, "", true)
+	require.NoError(t, err)
+	assert.Equal(t, 15.0, summary.CurrentMonthCost, "force recomputes")
+
+	// Ingesting allocations for this month drops the cached summary
+	addAllocation(t, svc, now, 1)
+	_, err = svc.upsertKubecostAllocations(ctx, []kubecostAllocation{kubecostHour(now, 4)})
+	require.NoError(t, err)
+	summary, err = svc.GetCostSummary(ctx, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, summary.CurrentMonthCost)
+}
+
+func TestCostSummaryDroppedWhenRecommendationsAreGenerated(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newCacheTestService(t)
+	now := time.Now().UTC()
+
+	// An idle workload, which a report recommends removing
+	addAllocation(t, svc, now, 20)
+	summary, err := svc.GetCostSummary(ctx, "", false)
+	require.NoError(t, err)
+	assert.Zero(t, summary.PotentialSavings)
+
+	report, err := svc.GenerateReport(ctx, ReportRequest{Name: "daily", Type: "daily", StartTime: now.Add(-time.Hour), EndTime: now.Add(2 * time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, report.Recommendations, 1)
+	summary, err = svc.GetCostSummary(ctx, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, report.Recommendations[0].MonthlySavings, summary.PotentialSavings)
+}
+
+func TestCostReportCachedUntilIngestForItsPeriod(t *testing.T) {
+	ctx := context.Background()
+	svc, mr := newCacheTestService(t)
+	now := time.Now().UTC()
+	req := ReportRequest{Name: "daily", Type: "daily", StartTime: now.Add(-24 * time.Hour), EndTime: now.Add(2 * time.Hour)}
+
+	addAllocation(t, svc, now, 10)
+	report, err := svc.GenerateReport(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, report.TotalCost)
+
+	addAllocation(t, svc, now, 5)
+	again, err := svc.GenerateReport(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, report.ID, again.ID, "the cached report is returned")
+	assert.Equal(t, 10.0, again.TotalCost)
+
+	other := req
+	other.Namespace = "payments"
+	fresh, err := svc.GenerateReport(ctx, other)
+	require.NoError(t, err)
+	assert.Equal(t, 15.0, fresh.TotalCost, "other filters are cached apart")
+
+	// Allocations for another period leave the report cached
+	_, err = svc.upsertKubecostAllocations(ctx, []kubecostAllocation{kubecostHour(now.AddDate(-1, 0, 0), 100)})
+	require.NoError(t, err)
+	again, err = svc.GenerateReport(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, report.ID, again.ID)
+
+	_, err = svc.upsertKubecostAllocations(ctx, []kubecostAllocation{kubecostHour(now, 4)})
+	require.NoError(t, err)
+	again, err = svc.GenerateReport(ctx, req)
+	require.NoError(t, err)
+	assert.NotEqual(t, report.ID, again.ID)
+	assert.Equal(t, 19.0, again.TotalCost)
+
+	// Entries expire after CacheTTL
+	mr.FastForward(time.Minute)
+	addAllocation(t, svc, now, 1)
+	again, err = svc.GenerateReport(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, again.TotalCost)
+}
+
+func TestPeriodMonths(t *testing.T) {
+	start := time.Date(2024, 11, 30, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"2024-11", "2024-12", "2025-01"}, periodMonths(start, start.AddDate(0, 1, 2)))
+	assert.Equal(t, []string{"2024-11"}, periodMonths(start, start))
+	assert.Equal(t, []string{"2024-11"}, periodMonths(start, start.Add(-time.Hour)))
+}
@@ -0,0 +1,264 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// labelGroupPrefix optionally marks a grouping dimension as a label key,
+	// e.g. "label:team". Dimensions that are not built in are treated as
+	// label keys either way.
+	labelGroupPrefix = "label:"
+
+	// unallocatedGroup collects costs of allocations without the grouping
+	// label. The parentheses keep it apart from any label value or
+	// namespace, which can't contain them.
+	unallocatedGroup = "(unallocated)"
+
+	// sharedGroup collects costs of shared namespaces in a chargeback
+	sharedGroup = "(shared)"
+)
+
+// Chargeback policies for spreading shared and unallocated costs
+const (
+	// SpreadProportional spreads by each owner's share of direct cost
+	SpreadProportional = "proportional"
+	// SpreadEven splits equally between owners
+	SpreadEven = "even"
+	// SpreadNone keeps shared and unallocated costs as their own lines
+	SpreadNone = "none"
+)
+
+// groupKey returns the value of allocation for a breakdown dimension
+func groupKey(alloc *CostAllocation, dimension string) string {
+	switch dimension {
+	case "namespace":
+		return alloc.Namespace
+	case "cluster":
+		if alloc.ClusterName != "" {
+			return alloc.ClusterName
+		}
+		return alloc.ClusterID
+	case "workload":
+		return alloc.WorkloadName
+	}
+
+	if value := alloc.Labels[strings.TrimPrefix(dimension, labelGroupPrefix)]; value != "" {
+		return value
+	}
+	return unallocatedGroup
+}
+
+// ChargebackRequest describes a chargeback (or showback) report
+type ChargebackRequest struct {
+	Name       string
+	OwnerLabel string // label naming the owner, e.g. "team" or "cost-center"
+	ClusterID  string
+	StartTime  time.Time
+	EndTime    time.Time
+	// SharedNamespaces are platform namespaces whose cost is spread across
+	// owners; defaults to Config.SharedNamespaces
+	SharedNamespaces []string
+	// Policy is SpreadProportional, SpreadEven or SpreadNone; defaults to
+	// Config.ChargebackPolicy
+	Policy string
+	UserID string
+}
+
+// ChargebackReport holds per-owner totals for a period
+type ChargebackReport struct {
+	ID              string           `json:"id"`
+	Name            string           `json:"name"`
+	OwnerLabel      string           `json:"owner_label"`
+	Policy          string           `json:"policy"`
+	PeriodStart     time.Time        `json:"period_start"`
+	PeriodEnd       time.Time        `json:"period_end"`
+	Currency        string           `json:"currency"`
+	TotalCost       float64          `json:"total_cost"`
+	SharedCost      float64          `json:"shared_cost"`
+	UnallocatedCost float64          `json:"unallocated_cost"`
+	Lines           []ChargebackLine `json:"lines"`
+	GeneratedAt     time.Time        `json:"generated_at"`
+	CreatedBy       string           `json:"created_by"`
+}
+
+// ChargebackLine is what one owner is charged
+type ChargebackLine struct {
+	Owner       string   `json:"owner"`
+	Namespaces  []string `json:"namespaces"`
+	DirectCost  float64  `json:"direct_cost"`
+	SharedCost  float64  `json:"shared_cost"` // spread shared and unallocated cost
+	TotalCost   float64  `json:"total_cost"`
+	Percentage  float64  `json:"percentage"`
+	CPUCost     float64  `json:"cpu_cost"`
+	MemoryCost  float64  `json:"memory_cost"`
+	StorageCost float64  `json:"storage_cost"`
+	NetworkCost float64  `json:"network_cost"`
+	GPUCost     float64  `json:"gpu_cost"`
+}
+
+// GenerateChargebackReport totals cost per owner label value. Costs of
+// shared namespaces and of allocations without the owner label are spread
+// across owners according to the policy, so the lines always add up to the
+// total cost for the period.
+func (s *Service) GenerateChargebackReport(ctx context.Context, req ChargebackRequest) (*ChargebackReport, error) {
+	if req.OwnerLabel == "" {
+		return nil, fmt.Errorf("owner label is required")
+	}
+
+	policy := req.Policy
+	if policy == "" {
+		policy = s.config.ChargebackPolicy
+	}
+	if policy == "" {
+		policy = SpreadProportional
+	}
+	if policy != SpreadProportional && policy != SpreadEven && policy != SpreadNone {
+		return nil, fmt.Errorf("unsupported chargeback policy: %s", policy)
+	}
+
+	sharedNamespaces := req.SharedNamespaces
+	if sharedNamespaces == nil {
+		sharedNamespaces = s.config.SharedNamespaces
+	}
+	shared := make(map[string]bool, len(sharedNamespaces))
+	for _, ns := range sharedNamespaces {
+		shared[ns] = true
+	}
+
+	allocations, err := s.allocationTotals(ctx, req.ClusterID, req.StartTime, req.EndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ChargebackReport{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		OwnerLabel:  req.OwnerLabel,
+		Policy:      policy,
+		PeriodStart: req.StartTime,
+		PeriodEnd:   req.EndTime,
+		Currency:    s.config.DefaultCurrency,
+		GeneratedAt: time.Now(),
+		CreatedBy:   req.UserID,
+	}
+
+	lines := make(map[string]*ChargebackLine)
+	namespaces := make(map[string]map[string]bool)
+	line := func(owner string) *ChargebackLine {
+		l, ok := lines[owner]
+		if !ok {
+			l = &ChargebackLine{Owner: owner}
+			lines[owner] = l
+			namespaces[owner] = make(map[string]bool)
+		}
+		return l
+	}
+
+	for i := range allocations {
+		alloc := &allocations[i]
+		report.TotalCost += alloc.TotalCost
+
+		owner := groupKey(alloc, req.OwnerLabel)
+		switch {
+		case shared[alloc.Namespace]:
+			owner = sharedGroup
+			report.SharedCost += alloc.TotalCost
+		case owner == unallocatedGroup:
+			report.UnallocatedCost += alloc.TotalCost
+		}
+
+		l := line(owner)
+		l.DirectCost += alloc.TotalCost
+		l.CPUCost += alloc.CPUCost
+		l.MemoryCost += alloc.MemoryCost
+		l.StorageCost += alloc.StorageCost
+		l.NetworkCost += alloc.NetworkCost
+		l.GPUCost += alloc.GPUCost
+		namespaces[owner][alloc.Namespace] = true
+	}
+
+	spreadChargeback(lines, policy)
+
+	for owner, l := range lines {
+		for ns := range namespaces[owner] {
+			l.Namespaces = append(l.Namespaces, ns)
+		}
+		sort.Strings(l.Namespaces)
+		if report.TotalCost > 0 {
+			l.Percentage = l.TotalCost / report.TotalCost * 100
+		}
+		report.Lines = append(report.Lines, *l)
+	}
+	sort.Slice(report.Lines, func(i, j int) bool {
+		if report.Lines[i].TotalCost != report.Lines[j].TotalCost {
+			return report.Lines[i].TotalCost > report.Lines[j].TotalCost
+		}
+		return report.Lines[i].Owner < report.Lines[j].Owner
+	})
+
+	return report, nil
+}
+
+// allocationTotals sums the cost allocations of clusterID (every cluster if
+// empty) in the period per namespace and label set, in the database, so
+// reports cover any number of allocations
+func (s *Service) allocationTotals(ctx context.Context, clusterID string, start, end time.Time) ([]CostAllocation, error) {
+	query := s.db.WithContext(ctx).Model(&CostAllocation{}).
+		Select(`namespace, labels, SUM(total_cost) AS total_cost, SUM(cpu_cost) AS cpu_cost,
+			SUM(memory_cost) AS memory_cost, SUM(storage_cost) AS storage_cost,
+			SUM(network_cost) AS network_cost, SUM(gpu_cost) AS gpu_cost`)
+	if clusterID != "" {
+		query = query.Where("cluster_id = ?", clusterID)
+	}
+	if !start.IsZero() {
+		query = query.Where("period_start >= ?", start)
+	}
+	if !end.IsZero() {
+		query = query.Where("period_end <= ?", end)
+	}
+
+	var totals []CostAllocation
+	if err := query.Group("namespace, labels").Scan(&totals).Error; err != nil {
+		return nil, fmt.Errorf("failed to total cost allocations: %w", err)
+	}
+	return totals, nil
+}
+
+// spreadChargeback moves the shared and unallocated lines onto the owner
+// lines according to policy and sets every line's TotalCost. With no owners
+// to spread onto, the shared and unallocated lines are kept.
+func spreadChargeback(lines map[string]*ChargebackLine, policy string) {
+	var owners []*ChargebackLine
+	var ownerDirect, pool float64
+	for name, l := range lines {
+		l.TotalCost = l.DirectCost
+		if name == sharedGroup || name == unallocatedGroup {
+			pool += l.DirectCost
+			continue
+		}
+		owners = append(owners, l)
+		ownerDirect += l.DirectCost
+	}
+
+	if policy == SpreadNone || len(owners) == 0 || pool == 0 {
+		return
+	}
+
+	for _, l := range owners {
+		share := 1 / float64(len(owners))
+		if policy == SpreadProportional && ownerDirect > 0 {
+			share = l.DirectCost / ownerDirect
+		}
+		l.SharedCost = pool * share
+		l.TotalCost = l.DirectCost + l.SharedCost
+	}
+	delete(lines, sharedGroup)
+	delete(lines, unallocatedGroup)
+}
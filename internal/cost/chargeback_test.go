@@ -0,0 +1,151 @@
+package cost
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateBreakdownNested(t *testing.T) {
+	allocations := []CostAllocation{
+		{Namespace: "payments", Labels: map[string]string{"team": "checkout"}, TotalCost: 60},
+		{Namespace: "payments", Labels: map[string]string{"team": "fraud"}, TotalCost: 30},
+		{Namespace: "web", TotalCost: 10},
+	}
+
+	breakdown := (&Service{}).generateBreakdown(allocations, []string{"namespace", "label:team"})
+	require.Len(t, breakdown, 5)
+
+	assert.Equal(t, "payments", breakdown[0].Name)
+	assert.Equal(t, 0, breakdown[0].Level)
+	assert.InDelta(t, 90.0, breakdown[0].Percentage, 1e-9)
+
+	assert.Equal(t, "team", breakdown[1].Category)
+	assert.Equal(t, "payments / checkout", breakdown[1].Name)
+	assert.Equal(t, breakdown[0].ID, breakdown[1].ParentID)
+	assert.Equal(t, "payments / fraud", breakdown[2].Name)
+
+	assert.Equal(t, "web", breakdown[3].Name)
+	assert.Equal(t, "web / (unallocated)", breakdown[4].Name)
+	assert.Equal(t, breakdown[3].ID, breakdown[4].ParentID)
+}
+
+func TestSpreadChargeback(t *testing.T) {
+	newLines := func() map[string]*ChargebackLine {
+		return map[string]*ChargebackLine{
+			"checkout":       {Owner: "checkout", DirectCost: 75},
+			"fraud":          {Owner: "fraud", DirectCost: 25},
+			sharedGroup:      {Owner: sharedGroup, DirectCost: 30},
+			unallocatedGroup: {Owner: unallocatedGroup, DirectCost: 10},
+		}
+	}
+
+	lines := newLines()
+	spreadChargeback(lines, SpreadProportional)
+	require.Len(t, lines, 2)
+	assert.InDelta(t, 105.0, lines["checkout"].TotalCost, 1e-9)
+	assert.InDelta(t, 35.0, lines["fraud"].TotalCost, 1e-9)
+
+	lines = newLines()
+	spreadChargeback(lines, SpreadEven)
+	assert.InDelta(t, 95.0, lines["checkout"].TotalCost, 1e-9)
+	assert.InDelta(t, 45.0, lines["fraud"].TotalCost, 1e-9)
+
+	lines = newLines()
+	spreadChargeback(lines, SpreadNone)
+	require.Len(t, lines, 4)
+	assert.InDelta(t, 10.0, lines[unallocatedGroup].TotalCost, 1e-9)
+}
+
+func TestGenerateChargebackReport(t *testing.T) {
+	ctx := context.Background()
+	svc := newBudgetTestService(t)
+	svc.config.SharedNamespaces = []string{"kube-system"}
+
+	start := time.Now().UTC().AddDate(0, 0, -7)
+	for _, a := range []struct {
+		namespace, team string
+		cost            float64
+	}{
+		{"payments", "checkout", 60},
+		{"fraud", "risk", 20},
+		{"batch", "", 10},
+		{"kube-system", "", 10},
+		// An hour later: the namespace's allocations add up
+		{"payments", "checkout", 15},
+	} {
+		alloc := &CostAllocation{
+			ID:          uuid.New().String(),
+			Namespace:   a.namespace,
+			TotalCost:   a.cost,
+			PeriodStart: start.Add(time.Hour),
+			PeriodEnd:   start.Add(2 * time.Hour),
+		}
+		if a.team != "" {
+			alloc.Labels = map[string]string{"team": a.team}
+		}
+		require.NoError(t, svc.db.Create(alloc).Error)
+	}
+
+	report, err := svc.GenerateChargebackReport(ctx, ChargebackRequest{
+		OwnerLabel: "team",
+		StartTime:  start,
+		EndTime:    time.Now().UTC(),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, SpreadProportional, report.Policy)
+	assert.InDelta(t, 115.0, report.TotalCost, 1e-9)
+	assert.InDelta(t, 10.0, report.SharedCost, 1e-9)
+	assert.InDelta(t, 10.0, report.UnallocatedCost, 1e-9)
+
+	require.Len(t, report.Lines, 2)
+	assert.Equal(t, "checkout", report.Lines[0].Owner)
+	assert.InDelta(t, 75.0, report.Lines[0].DirectCost, 1e-9)
+	assert.InDelta(t, 75+20*75.0/95, report.Lines[0].TotalCost, 1e-9)
+	assert.Equal(t, []string{"payments"}, report.Lines[0].Namespaces)
+	assert.InDelta(t, 20+20*20.0/95, report.Lines[1].TotalCost, 1e-9)
+
+	_, err = svc.GenerateChargebackReport(ctx, ChargebackRequest{OwnerLabel: "team", Policy: "random"})
+	assert.Error(t, err)
+}
+
+func TestChargebackOwnersNamedLikeBuckets(t *testing.T) {
+	ctx := context.Background()
+	svc := newBudgetTestService(t)
+	svc.config.SharedNamespaces = []string{"kube-system"}
+
+	start := time.Now().UTC().AddDate(0, 0, -1)
+	for _, a := range []struct {
+		namespace, team string
+		cost            float64
+	}{
+		{"platform", "shared", 30},
+		{"jobs", "unallocated", 10},
+		{"kube-system", "", 20},
+	} {
+		require.NoError(t, svc.db.Create(&CostAllocation{
+			ID:          uuid.New().String(),
+			Namespace:   a.namespace,
+			Labels:      map[string]string{"team": a.team},
+			TotalCost:   a.cost,
+			PeriodStart: start,
+			PeriodEnd:   start.Add(time.Hour),
+		}).Error)
+	}
+
+	report, err := svc.GenerateChargebackReport(ctx, ChargebackRequest{OwnerLabel: "team", Policy: SpreadNone})
+	require.NoError(t, err)
+	owners := map[string]float64{}
+	for _, l := range report.Lines {
+		owners[l.Owner] = l.TotalCost
+	}
+	assert.Equal(t, map[string]float64{"shared": 30, "unallocated": 10, sharedGroup: 20}, owners,
+		"teams named like the buckets keep their own lines")
+	assert.InDelta(t, 20.0, report.SharedCost, 1e-9)
+	assert.Zero(t, report.UnallocatedCost)
+}
@@ -0,0 +1,217 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultExchangeRateTTL is how long rates fetched from an API are reused
+const defaultExchangeRateTTL = time.Hour
+
+// ErrMissingRate is returned when no exchange rate is known for a pair
+var ErrMissingRate = errors.New("exchange rate not available")
+
+// ExchangeRateProvider returns how many units of to one unit of from buys,
+// and when that rate was observed
+type ExchangeRateProvider interface {
+	Rate(ctx context.Context, from, to string) (float64, time.Time, error)
+}
+
+// StaticRates converts using fixed rates, each the number of units of that
+// currency per one unit of Base
+type StaticRates struct {
+	Base  string
+	Rates map[string]float64
+	AsOf  time.Time
+}
+
+// Rate implements ExchangeRateProvider
+func (r *StaticRates) Rate(_ context.Context, from, to string) (float64, time.Time, error) {
+	return crossRate(r.Base, r.Rates, from, to, r.AsOf)
+}
+
+// HTTPRates fetches rates from a JSON API returning
+// {"base": "USD", "rates": {"EUR": 0.92, ...}} and caches them for TTL
+type HTTPRates struct {
+	URL    string
+	TTL    time.Duration
+	Client *http.Client
+
+	mu        sync.Mutex
+	base      string
+	rates     map[string]float64
+	fetchedAt time.Time
+}
+
+// Rate implements ExchangeRateProvider
+func (r *HTTPRates) Rate(ctx context.Context, from, to string) (float64, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = defaultExchangeRateTTL
+	}
+	if r.rates == nil || time.Since(r.fetchedAt) > ttl {
+		if err := r.fetch(ctx); err != nil {
+			// Serve stale rates rather than failing while the API is down
+			if r.rates == nil {
+				return 0, time.Time{}, err
+			}
+		}
+	}
+	return crossRate(r.base, r.rates, from, to, r.fetchedAt)
+}
+
+func (r *HTTPRates) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return err
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("exchange rate request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("exchange rate API returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("failed to decode exchange rates: %w", err)
+	}
+	if body.Base == "" || len(body.Rates) == 0 {
+		return fmt.Errorf("exchange rate API returned no rates")
+	}
+
+	r.base, r.rates, r.fetchedAt = body.Base, body.Rates, time.Now()
+	return nil
+}
+
+// crossRate derives from->to from rates quoted against base
+func crossRate(base string, rates map[string]float64, from, to string, asOf time.Time) (float64, time.Time, error) {
+	from, to, base = strings.ToUpper(from), strings.ToUpper(to), strings.ToUpper(base)
+	if from == to {
+		return 1, asOf, nil
+	}
+
+	quote := func(currency string) (float64, bool) {
+		if currency == base {
+			return 1, true
+		}
+		rate, ok := rates[currency]
+		return rate, ok && rate > 0
+	}
+	fromRate, ok := quote(from)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("%w: %s", ErrMissingRate, from)
+	}
+	toRate, ok := quote(to)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("%w: %s", ErrMissingRate, to)
+	}
+	return toRate / fromRate, asOf, nil
+}
+
+// SetExchangeRateProvider replaces the provider configured from
+// Config.ExchangeRates / Config.ExchangeRateURL
+func (s *Service) SetExchangeRateProvider(p ExchangeRateProvider) { s.rates = p }
+
+// newExchangeRateProvider builds the provider described by config: a live
+// API if ExchangeRateURL is set, otherwise the static ExchangeRates
+func newExchangeRateProvider(config *Config, client *http.Client) ExchangeRateProvider {
+	if config.ExchangeRateURL != "" {
+		return &HTTPRates{URL: config.ExchangeRateURL, TTL: config.ExchangeRateTTL, Client: client}
+	}
+	return &StaticRates{Base: config.DefaultCurrency, Rates: config.ExchangeRates, AsOf: time.Now()}
+}
+
+// convert returns amount in to, given it is in from, with the rate used
+func (s *Service) convert(ctx context.Context, amount float64, from, to string) (float64, float64, time.Time, error) {
+	if from == "" {
+		from = s.config.DefaultCurrency
+	}
+	if to == "" || strings.EqualFold(from, to) {
+		return amount, 1, time.Time{}, nil
+	}
+	if s.rates == nil {
+		return 0, 0, time.Time{}, fmt.Errorf("%w: no exchange rate provider configured", ErrMissingRate)
+	}
+
+	rate, asOf, err := s.rates.Rate(ctx, from, to)
+	if err != nil {
+		return 0, 0, time.Time{}, err
+	}
+	return roundMoney(amount * rate), rate, asOf, nil
+}
+
+// ConvertReport converts every amount in report to targetCurrency, rounding
+// to cents, and records the rate and its timestamp on the report
+func (s *Service) ConvertReport(ctx context.Context, report *CostReport, targetCurrency string) error {
+	from := report.Currency
+	if from == "" {
+		from = s.config.DefaultCurrency
+	}
+	targetCurrency = strings.ToUpper(targetCurrency)
+	if targetCurrency == "" || strings.EqualFold(from, targetCurrency) {
+		return nil
+	}
+	if s.rates == nil {
+		return fmt.Errorf("%w: no exchange rate provider configured", ErrMissingRate)
+	}
+
+	rate, asOf, err := s.rates.Rate(ctx, from, targetCurrency)
+	if err != nil {
+		return err
+	}
+	conv := func(v *float64) { *v = roundMoney(*v * rate) }
+
+	conv(&report.TotalCost)
+	conv(&report.CPUCost)
+	conv(&report.MemoryCost)
+	conv(&report.StorageCost)
+	conv(&report.NetworkCost)
+	for i := range report.Breakdown {
+		conv(&report.Breakdown[i].Cost)
+	}
+	for i := range report.Trends {
+		conv(&report.Trends[i].Cost)
+	}
+	for i := range report.Recommendations {
+		r := &report.Recommendations[i]
+		conv(&r.CurrentCost)
+		conv(&r.ProjectedCost)
+		conv(&r.MonthlySavings)
+		conv(&r.AnnualSavings)
+	}
+
+	// Rates compose, so a report converted twice records the overall rate
+	if report.ExchangeRate > 0 {
+		rate *= report.ExchangeRate
+	}
+	report.Currency = targetCurrency
+	report.ExchangeRate = rate
+	report.ExchangeRateAt = &asOf
+	return nil
+}
+
+// roundMoney rounds to two decimal places, halves away from zero
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,82 @@
+package cost
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCurrencyTestService() *Service {
+	config := &Config{
+		DefaultCurrency: "USD",
+		ExchangeRates:   map[string]float64{"EUR": 0.9235, "INR": 83.125},
+	}
+	return &Service{config: config, rates: newExchangeRateProvider(config, nil)}
+}
+
+func TestStaticRatesCrossRate(t *testing.T) {
+	rates := &StaticRates{Base: "USD", Rates: map[string]float64{"EUR": 0.8, "INR": 80}}
+
+	rate, _, err := rates.Rate(context.Background(), "EUR", "INR")
+	require.NoError(t, err)
+	assert.InDelta(t, 100.0, rate, 1e-9)
+
+	rate, _, err = rates.Rate(context.Background(), "inr", "usd")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.0125, rate, 1e-12)
+
+	_, _, err = rates.Rate(context.Background(), "USD", "GBP")
+	assert.ErrorIs(t, err, ErrMissingRate)
+}
+
+func TestConvertReportRounding(t *testing.T) {
+	svc := newCurrencyTestService()
+	report := &CostReport{
+		Currency:  "USD",
+		TotalCost: 10.01,
+		CPUCost:   0.005,
+		Breakdown: []CostBreakdown{{Name: "payments", Cost: 3.333, Percentage: 33.3}},
+		Trends:    []CostTrend{{Date: time.Now(), Cost: 1.115}},
+		Recommendations: []CostRecommendation{
+			{CurrentCost: 100, ProjectedCost: 60, MonthlySavings: 40, AnnualSavings: 480},
+		},
+	}
+
+	require.NoError(t, svc.ConvertReport(context.Background(), report, "eur"))
+
+	assert.Equal(t, "EUR", report.Currency)
+	assert.Equal(t, 9.24, report.TotalCost) // 9.244235
+	assert.Equal(t, 0.0, report.CPUCost)    // 0.0046175
+	assert.Equal(t, 3.08, report.Breakdown[0].Cost)
+	assert.Equal(t, 33.3, report.Breakdown[0].Percentage, "percentages are not converted")
+	assert.Equal(t, 1.03, report.Trends[0].Cost)
+	assert.Equal(t, 443.28, report.Recommendations[0].AnnualSavings)
+	assert.Equal(t, 0.9235, report.ExchangeRate)
+	require.NotNil(t, report.ExchangeRateAt)
+
+	// Converting on to INR records the overall rate from USD
+	require.NoError(t, svc.ConvertReport(context.Background(), report, "INR"))
+	assert.InDelta(t, 83.125, report.ExchangeRate, 1e-9)
+}
+
+func TestConvertReportMissingRate(t *testing.T) {
+	svc := newCurrencyTestService()
+	report := &CostReport{Currency: "USD", TotalCost: 10}
+
+	err := svc.ConvertReport(context.Background(), report, "GBP")
+	assert.ErrorIs(t, err, ErrMissingRate)
+	assert.Equal(t, 10.0, report.TotalCost, "report is unchanged on error")
+	assert.Equal(t, "USD", report.Currency)
+}
+
+func TestConvertSameCurrency(t *testing.T) {
+	svc := &Service{config: &Config{DefaultCurrency: "USD"}}
+
+	amount, rate, _, err := svc.convert(context.Background(), 12.345, "", "usd")
+	require.NoError(t, err)
+	assert.Equal(t, 12.345, amount, "no conversion, no rounding")
+	assert.Equal(t, 1.0, rate)
+}
@@ -0,0 +1,252 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// reservedBaselinePercentile is the share of hours the cluster's compute
+	// cost stays above the committed baseline
+	reservedBaselinePercentile = 0.10
+
+	// reservedMinHours is how much hourly history a reserved-capacity
+	// recommendation needs (one week)
+	reservedMinHours = 7 * 24
+
+	// minDiscountSavings is the smallest monthly saving worth recommending
+	minDiscountSavings = 1.0
+)
+
+// defaultSpotDiscounts is the typical spot/preemptible price reduction per
+// provider, overridable with Config.SpotDiscounts
+var defaultSpotDiscounts = map[string]float64{
+	"aws":   0.70,
+	"gcp":   0.60,
+	"azure": 0.60,
+}
+
+// defaultReservedDiscounts is the typical one-year reserved instance or
+// committed-use discount per provider, overridable with
+// Config.ReservedDiscounts
+var defaultReservedDiscounts = map[string]float64{
+	"aws":   0.40,
+	"gcp":   0.37,
+	"azure": 0.40,
+}
+
+// discount returns the configured or default discount for the provider
+func (s *Service) discount(configured, defaults map[string]float64) (string, float64) {
+	provider := s.config.CloudProvider
+	if provider == "" {
+		provider = "aws"
+	}
+	if d, ok := configured[provider]; ok {
+		return provider, d
+	}
+	return provider, defaults[provider]
+}
+
+// workloadCost is the compute cost of one workload over a report period
+type workloadCost struct {
+	clusterID, namespace, kind, name string
+	compute                          float64
+	first, last                      time.Time
+}
+
+// monthly scales the observed cost to a 30-day month
+func (w *workloadCost) monthly() float64 {
+	hours := w.last.Sub(w.first).Hours()
+	if hours < 1 {
+		hours = 1
+	}
+	return w.compute / hours * 720
+}
+
+// spotRecommendations suggests running fault-tolerant workloads on spot
+// capacity. A workload qualifies if it is a Deployment with more than one
+// replica and no local or persistent storage; this is checked against the
+// live cluster, so nothing is recommended without cluster access.
+func (s *Service) spotRecommendations(ctx context.Context, allocations []CostAllocation) []CostRecommendation {
+	provider, discount := s.discount(s.config.SpotDiscounts, defaultSpotDiscounts)
+	if discount <= 0 || s.kubeManager == nil {
+		return nil
+	}
+
+	var recommendations []CostRecommendation
+	for _, w := range groupWorkloadCosts(allocations) {
+		if !strings.EqualFold(w.kind, "deployment") {
+			continue
+		}
+		client, err := s.kubeManager.GetClient(w.clusterID)
+		if err != nil {
+			continue
+		}
+		deploy, err := client.Clientset.AppsV1().Deployments(w.namespace).Get(ctx, w.name, metav1.GetOptions{})
+		if err != nil || !spotCandidate(deploy) {
+			continue
+		}
+
+		current := w.monthly()
+		savings := current * discount
+		if savings < minDiscountSavings {
+			continue
+		}
+		recommendations = append(recommendations, CostRecommendation{
+			ID:       uuid.New().String(),
+			Type:     "spot",
+			Category: "purchasing",
+			Title:    fmt.Sprintf("Run %s on spot capacity", w.name),
+			Description: fmt.Sprintf("Deployment %s/%s runs %d replicas without local storage and can tolerate node interruptions; "+
+				"%s spot capacity is about %.0f%% cheaper", w.namespace, w.name, *deploy.Spec.Replicas, provider, discount*100),
+			ResourceType:     "Deployment",
+			ResourceName:     w.name,
+			Namespace:        w.namespace,
+			ClusterID:        w.clusterID,
+			CurrentCost:      current,
+			ProjectedCost:    current - savings,
+			MonthlySavings:   savings,
+			AnnualSavings:    savings * 12,
+			Effort:           "medium",
+			Risk:             "medium",
+			CurrentState:     map[string]interface{}{"replicas": *deploy.Spec.Replicas, "capacity": "on-demand"},
+			RecommendedState: map[string]interface{}{"capacity": "spot", "discount": discount},
+			Status:           "pending",
+			CreatedAt:        time.Now(),
+		})
+	}
+	return recommendations
+}
+
+// spotCandidate reports whether a Deployment can run on interruptible nodes
+func spotCandidate(deploy *appsv1.Deployment) bool {
+	if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas < 2 {
+		return false
+	}
+	for _, v := range deploy.Spec.Template.Spec.Volumes {
+		if v.EmptyDir != nil || v.HostPath != nil || v.PersistentVolumeClaim != nil || v.Ephemeral != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedRecommendations suggests committing to each cluster's steady
+// baseline: the hourly compute cost it stays above 90% of the time
+func (s *Service) reservedRecommendations(allocations []CostAllocation) []CostRecommendation {
+	provider, discount := s.discount(s.config.ReservedDiscounts, defaultReservedDiscounts)
+	if discount <= 0 {
+		return nil
+	}
+
+	hourly := make(map[string]map[time.Time]float64)
+	for _, alloc := range allocations {
+		if hourly[alloc.ClusterID] == nil {
+			hourly[alloc.ClusterID] = make(map[time.Time]float64)
+		}
+		hourly[alloc.ClusterID][alloc.PeriodStart.Truncate(time.Hour)] += computeCost(&alloc)
+	}
+
+	clusters := make([]string, 0, len(hourly))
+	for clusterID := range hourly {
+		clusters = append(clusters, clusterID)
+	}
+	sort.Strings(clusters)
+
+	var recommendations []CostRecommendation
+	for _, clusterID := range clusters {
+		baseline, ok := steadyBaseline(hourly[clusterID])
+		if !ok {
+			continue
+		}
+
+		committed := baseline * 720
+		savings := committed * discount
+		if savings < minDiscountSavings {
+			continue
+		}
+		recommendations = append(recommendations, CostRecommendation{
+			ID:       uuid.New().String(),
+			Type:     "reserved",
+			Category: "purchasing",
+			Title:    fmt.Sprintf("Commit to baseline capacity in cluster %s", clusterID),
+			Description: fmt.Sprintf("Cluster %s never drops below %.2f/hour of compute in 90%% of hours; "+
+				"a one-year %s reservation or committed-use discount on that baseline saves about %.0f%%",
+				clusterID, baseline, provider, discount*100),
+			ResourceType:     "Cluster",
+			ResourceName:     clusterID,
+			ClusterID:        clusterID,
+			CurrentCost:      committed,
+			ProjectedCost:    committed - savings,
+			MonthlySavings:   savings,
+			AnnualSavings:    savings * 12,
+			Effort:           "low",
+			Risk:             "low", // requires a 1-year commitment
+			CurrentState:     map[string]interface{}{"baseline_per_hour": baseline, "capacity": "on-demand"},
+			RecommendedState: map[string]interface{}{"capacity": "reserved", "term": "1y", "discount": discount},
+			Status:           "pending",
+			CreatedAt:        time.Now(),
+		})
+	}
+	return recommendations
+}
+
+// steadyBaseline returns the low percentile of the hourly costs, if there is
+// at least a week of history
+func steadyBaseline(hourly map[time.Time]float64) (float64, bool) {
+	if len(hourly) < reservedMinHours {
+		return 0, false
+	}
+	costs := make([]float64, 0, len(hourly))
+	for _, c := range hourly {
+		costs = append(costs, c)
+	}
+	sort.Float64s(costs)
+	baseline := costs[int(float64(len(costs))*reservedBaselinePercentile)]
+	return baseline, baseline > 0
+}
+
+// computeCost is the part of an allocation that spot and reserved pricing
+// discount: CPU, memory and GPU, but not storage or network
+func computeCost(alloc *CostAllocation) float64 {
+	return alloc.CPUCost + alloc.MemoryCost + alloc.GPUCost
+}
+
+// groupWorkloadCosts sums compute cost per workload, ordered by cost
+func groupWorkloadCosts(allocations []CostAllocation) []*workloadCost {
+	index := make(map[string]*workloadCost)
+	var workloads []*workloadCost
+	for _, alloc := range allocations {
+		if alloc.WorkloadName == "" {
+			continue
+		}
+		key := alloc.ClusterID + "/" + alloc.Namespace + "/" + alloc.WorkloadType + "/" + alloc.WorkloadName
+		w, ok := index[key]
+		if !ok {
+			w = &workloadCost{
+				clusterID: alloc.ClusterID, namespace: alloc.Namespace,
+				kind: alloc.WorkloadType, name: alloc.WorkloadName,
+				first: alloc.PeriodStart, last: alloc.PeriodEnd,
+			}
+			index[key] = w
+			workloads = append(workloads, w)
+		}
+		w.compute += computeCost(&alloc)
+		if alloc.PeriodStart.Before(w.first) {
+			w.first = alloc.PeriodStart
+		}
+		if alloc.PeriodEnd.After(w.last) {
+			w.last = alloc.PeriodEnd
+		}
+	}
+
+	sort.SliceStable(workloads, func(i, j int) bool { return workloads[i].compute > workloads[j].compute })
+	return workloads
+}
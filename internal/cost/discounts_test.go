@@ -0,0 +1,76 @@
+package cost
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSpotCandidate(t *testing.T) {
+	deployment := func(replicas int32, volumes ...corev1.Volume) *appsv1.Deployment {
+		d := &appsv1.Deployment{}
+		d.Spec.Replicas = &replicas
+		d.Spec.Template.Spec.Volumes = volumes
+		return d
+	}
+	configMap := corev1.Volume{Name: "config", VolumeSource: corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{},
+	}}
+	scratch := corev1.Volume{Name: "scratch", VolumeSource: corev1.VolumeSource{
+		EmptyDir: &corev1.EmptyDirVolumeSource{},
+	}}
+	data := corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+	}}
+
+	assert.True(t, spotCandidate(deployment(3, configMap)))
+	assert.False(t, spotCandidate(deployment(1)), "single replica")
+	assert.False(t, spotCandidate(deployment(3, configMap, scratch)), "local storage")
+	assert.False(t, spotCandidate(deployment(3, data)), "persistent storage")
+}
+
+func TestReservedRecommendations(t *testing.T) {
+	svc := &Service{config: &Config{
+		CloudProvider:     "gcp",
+		ReservedDiscounts: map[string]float64{"gcp": 0.5},
+	}}
+
+	// Two weeks at 10/hour of compute, with a 40/hour peak every afternoon
+	start := time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)
+	var allocations []CostAllocation
+	for h := 0; h < 14*24; h++ {
+		cpu := 6.0
+		if h%24 >= 12 && h%24 < 18 {
+			cpu = 30
+		}
+		at := start.Add(time.Duration(h) * time.Hour)
+		allocations = append(allocations, CostAllocation{
+			ClusterID:   "prod",
+			PeriodStart: at,
+			PeriodEnd:   at.Add(time.Hour),
+			CPUCost:     cpu,
+			MemoryCost:  cpu * 2 / 3,
+			StorageCost: 100, // not discounted
+		})
+	}
+
+	recs := svc.reservedRecommendations(allocations)
+	require.Len(t, recs, 1)
+	rec := recs[0]
+	assert.Equal(t, "reserved", rec.Type)
+	assert.Equal(t, "low", rec.Risk)
+	assert.InDelta(t, 10.0, rec.CurrentState["baseline_per_hour"], 1e-9)
+	assert.InDelta(t, 3600.0, rec.MonthlySavings, 1e-9)
+	assert.InDelta(t, 43200.0, rec.AnnualSavings, 1e-9)
+
+	// Less than a week of history is not enough to commit
+	assert.Empty(t, svc.reservedRecommendations(allocations[:6*24]))
+
+	// On-prem has no discount to offer
+	svc.config.CloudProvider = "on-prem"
+	assert.Empty(t, svc.reservedRecommendations(allocations))
+}
@@ -0,0 +1,200 @@
+package cost
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+)
+
+// reportSection is one table of an exported report. CSV writes sections one
+// after another; XLSX writes each as its own sheet.
+type reportSection struct {
+	Title  string
+	Header []string
+	Rows   [][]interface{} // string or float64 cells
+	Footer []interface{}   // optional totals row
+}
+
+// reportSections lays out a report as the breakdown, trend and
+// recommendation tables shared by every tabular export format
+func reportSections(report *CostReport) []reportSection {
+	breakdown := reportSection{
+		Title:  "Breakdown",
+		Header: []string{"Category", "Name", "Cost", "Percentage", "Trend", "Level"},
+		Footer: []interface{}{"Total", "", report.TotalCost, 100.0, "", ""},
+	}
+	for _, b := range report.Breakdown {
+		breakdown.Rows = append(breakdown.Rows, []interface{}{b.Category, b.Name, b.Cost, b.Percentage, b.Trend, strconv.Itoa(b.Level)})
+	}
+
+	trends := reportSection{
+		Title:  "Trends",
+		Header: []string{"Date", "Cost", "Change"},
+	}
+	for _, t := range report.Trends {
+		trends.Rows = append(trends.Rows, []interface{}{t.Date.Format("2006-01-02"), t.Cost, t.Change})
+	}
+
+	recommendations := reportSection{
+		Title: "Recommendations",
+		Header: []string{"Type", "Title", "Cluster", "Namespace", "Resource", "Current Cost",
+			"Projected Cost", "Monthly Savings", "Annual Savings", "Effort", "Risk"},
+	}
+	var monthly, annual float64
+	for _, r := range report.Recommendations {
+		recommendations.Rows = append(recommendations.Rows, []interface{}{
+			r.Type, r.Title, r.ClusterID, r.Namespace, r.ResourceType + "/" + r.ResourceName,
+			r.CurrentCost, r.ProjectedCost, r.MonthlySavings, r.AnnualSavings, r.Effort, r.Risk,
+		})
+		monthly += r.MonthlySavings
+		annual += r.AnnualSavings
+	}
+	if len(report.Recommendations) > 0 {
+		recommendations.Footer = []interface{}{"Total", "", "", "", "", "", "", monthly, annual, "", ""}
+	}
+
+	return []reportSection{breakdown, trends, recommendations}
+}
+
+func formatCell(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (s *Service) exportToCSV(report *CostReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	write := func(cells []interface{}) {
+		record := make([]string, len(cells))
+		for i, c := range cells {
+			record[i] = formatCell(c)
+		}
+		_ = w.Write(record)
+	}
+
+	for i, section := range reportSections(report) {
+		if i > 0 {
+			_ = w.Write(nil)
+		}
+		_ = w.Write([]string{"# " + section.Title})
+		_ = w.Write(section.Header)
+		for _, row := range section.Rows {
+			write(row)
+		}
+		if section.Footer != nil {
+			write(section.Footer)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write csv: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// exportToXLSX writes the report sections as sheets of a minimal Office Open
+// XML workbook
+func (s *Service) exportToXLSX(report *CostReport) ([]byte, error) {
+	sections := reportSections(report)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name, content string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write([]byte(xml.Header + content))
+		return err
+	}
+
+	var overrides, sheets, rels string
+	for i, section := range sections {
+		n := i + 1
+		overrides += fmt.Sprintf(`<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		sheets += fmt.Sprintf(`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(section.Title), n, n)
+		rels += fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			overrides + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			sheets + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels + `</Relationships>`},
+	}
+	for i, section := range sections {
+		parts = append(parts, struct{ name, content string }{
+			fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxSheet(section),
+		})
+	}
+
+	for _, p := range parts {
+		if err := add(p.name, p.content); err != nil {
+			return nil, fmt.Errorf("failed to write xlsx: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write xlsx: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func xlsxSheet(section reportSection) string {
+	var b bytes.Buffer
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	row := 0
+	writeRow := func(cells []interface{}) {
+		row++
+		fmt.Fprintf(&b, `<row r="%d">`, row)
+		for _, c := range cells {
+			if v, ok := c.(float64); ok {
+				fmt.Fprintf(&b, `<c><v>%s</v></c>`, strconv.FormatFloat(v, 'f', -1, 64))
+				continue
+			}
+			fmt.Fprintf(&b, `<c t="inlineStr"><is><t>%s</t></is></c>`, xmlEscape(formatCell(c)))
+		}
+		b.WriteString(`</row>`)
+	}
+
+	header := make([]interface{}, len(section.Header))
+	for i, h := range section.Header {
+		header[i] = h
+	}
+	writeRow(header)
+	for _, r := range section.Rows {
+		writeRow(r)
+	}
+	if section.Footer != nil {
+		writeRow(section.Footer)
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
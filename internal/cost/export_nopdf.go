@@ -0,0 +1,10 @@
+//go:build !pdf
+
+package cost
+
+import "fmt"
+
+// exportToPDF is only available in builds with the pdf tag
+func (s *Service) exportToPDF(report *CostReport) ([]byte, error) {
+	return nil, fmt.Errorf("pdf export not enabled in this build (rebuild with -tags pdf)")
+}
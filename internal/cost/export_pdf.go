@@ -0,0 +1,101 @@
+//go:build pdf
+
+package cost
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	pdfPageHeight  = 842 // A4 in points
+	pdfMargin      = 50
+	pdfLineHeight  = 14
+	pdfLinesOnPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+)
+
+// exportToPDF renders a one-column text summary of the report: totals, the
+// largest cost categories and the top recommendations
+func (s *Service) exportToPDF(report *CostReport) ([]byte, error) {
+	lines := []string{
+		fmt.Sprintf("%s (%s to %s)", report.Name,
+			report.PeriodStart.Format(time.DateOnly), report.PeriodEnd.Format(time.DateOnly)),
+		"",
+		fmt.Sprintf("Total cost:   %.2f %s", report.TotalCost, s.config.DefaultCurrency),
+		fmt.Sprintf("CPU:          %.2f", report.CPUCost),
+		fmt.Sprintf("Memory:       %.2f", report.MemoryCost),
+		fmt.Sprintf("Storage:      %.2f", report.StorageCost),
+		fmt.Sprintf("Network:      %.2f", report.NetworkCost),
+		"",
+		"Breakdown",
+	}
+	for _, b := range report.Breakdown {
+		lines = append(lines, fmt.Sprintf("  %-40s %12.2f %6.2f%%", b.Category+"/"+b.Name, b.Cost, b.Percentage))
+	}
+	if len(report.Recommendations) > 0 {
+		lines = append(lines, "", "Recommendations")
+		for _, r := range report.Recommendations {
+			lines = append(lines, fmt.Sprintf("  %-40s %10.2f/mo %12.2f/yr", r.Title, r.MonthlySavings, r.AnnualSavings))
+		}
+	}
+
+	return renderTextPDF(lines), nil
+}
+
+// renderTextPDF lays lines out in Courier on as many A4 pages as needed
+func renderTextPDF(lines []string) []byte {
+	var pages [][]string
+	for len(lines) > pdfLinesOnPage {
+		pages = append(pages, lines[:pdfLinesOnPage])
+		lines = lines[pdfLinesOnPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects: 1 catalog, 2 page tree, 3 font, then a page and content
+	// stream per page
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+	)
+	for i, page := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT /F1 10 Tf %d TL %d %d Td\n", pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", pdfEscape(line))
+		}
+		content.WriteString("ET")
+
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pdfPageHeight, 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+func pdfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+}
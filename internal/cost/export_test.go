@@ -0,0 +1,92 @@
+package cost
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport() *CostReport {
+	return &CostReport{
+		Name:        "monthly",
+		PeriodStart: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		TotalCost:   1234.5,
+		Breakdown: []CostBreakdown{
+			{Category: "namespace", Name: "payments", Cost: 800.25, Percentage: 64.82},
+			{Category: "namespace", Name: `web, "frontend"`, Cost: 400, Percentage: 32.4},
+			{ID: "ops", Category: "namespace", Name: "ops", Cost: 34.25, Percentage: 2.78},
+			{Category: "team", Name: "ops / sre", Cost: 34.25, Percentage: 2.78, ParentID: "ops", Level: 1},
+		},
+		Trends: []CostTrend{
+			{Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Cost: 40},
+			{Date: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), Cost: 42, Change: 5},
+		},
+		Recommendations: []CostRecommendation{
+			{Type: "rightsize", Title: "Reduce CPU, payments/api", CurrentCost: 300, ProjectedCost: 200,
+				MonthlySavings: 100, AnnualSavings: 1200},
+		},
+	}
+}
+
+func TestExportToCSVTotals(t *testing.T) {
+	svc := &Service{}
+	data, err := svc.exportToCSV(testReport())
+	require.NoError(t, err)
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	require.NoError(t, err)
+
+	var rowSum, total float64
+	var names []string
+	section := ""
+	for _, rec := range records {
+		if len(rec) == 1 && len(rec[0]) > 2 && rec[0][0] == '#' {
+			section = rec[0][2:]
+			continue
+		}
+		if section != "Breakdown" || rec[0] == "Category" {
+			continue
+		}
+		if rec[0] != "Total" && rec[5] != "0" {
+			continue // subgroups are already counted in their parent
+		}
+		cost, err := strconv.ParseFloat(rec[2], 64)
+		require.NoError(t, err, "cost column should be numeric: %q", rec[2])
+		if rec[0] == "Total" {
+			total = cost
+			continue
+		}
+		names = append(names, rec[1])
+		rowSum += cost
+	}
+
+	assert.Equal(t, []string{"payments", `web, "frontend"`, "ops"}, names)
+	assert.InDelta(t, rowSum, total, 0.005)
+	assert.Contains(t, string(data), "# Recommendations")
+	assert.Contains(t, string(data), "100.00,1200.00")
+}
+
+func TestExportToXLSX(t *testing.T) {
+	svc := &Service{}
+	data, err := svc.exportToXLSX(testReport())
+	require.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Contains(t, names, "xl/workbook.xml")
+	assert.Contains(t, names, "xl/worksheets/sheet3.xml")
+}
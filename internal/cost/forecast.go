@@ -0,0 +1,204 @@
+package cost
+
+import (
+	"math"
+)
+
+const (
+	forecastModelLinear   = "linear"
+	forecastModelSeasonal = "seasonal"
+
+	// weeklySeason is the season length, in days, of the seasonal model
+	weeklySeason = 7
+
+	// forecastConfidence is the coverage of the prediction interval, and
+	// forecastZ the matching normal quantile
+	forecastConfidence = 0.95
+	forecastZ          = 1.96
+)
+
+// forecastFit is a model fitted to a daily cost series
+type forecastFit struct {
+	model  string
+	params map[string]interface{}
+
+	// residualStdDev is the standard deviation of the one-step-ahead
+	// in-sample errors, used to size prediction intervals
+	residualStdDev float64
+	mape           float64
+
+	// predict returns the cost h days after the last observation (h >= 1)
+	predict func(h int) float64
+}
+
+// interval returns the prediction interval around predicted for horizon h.
+// Uncertainty grows with the square root of the horizon.
+func (f *forecastFit) interval(predicted float64, h int) (lower, upper float64) {
+	width := forecastZ * f.residualStdDev * math.Sqrt(float64(h))
+	return math.Max(predicted-width, 0), predicted + width
+}
+
+// fitLinear fits an ordinary least-squares trend line
+func fitLinear(costs []float64) *forecastFit {
+	n := len(costs)
+	slope := linearSlope(costs)
+	intercept := average(costs) - slope*float64(n-1)/2
+
+	residuals := make([]float64, 0, n)
+	actuals := make([]float64, 0, n)
+	for i, y := range costs {
+		residuals = append(residuals, y-(intercept+slope*float64(i)))
+		actuals = append(actuals, y)
+	}
+
+	return &forecastFit{
+		model: forecastModelLinear,
+		params: map[string]interface{}{
+			"slope":     slope,
+			"intercept": intercept,
+		},
+		residualStdDev: stdDev(residuals),
+		mape:           mape(actuals, residuals),
+		predict: func(h int) float64 {
+			return intercept + slope*float64(n-1+h)
+		},
+	}
+}
+
+// fitHoltWinters fits additive triple exponential smoothing with the given
+// season length. The smoothing parameters are picked by grid search on the
+// one-step-ahead squared error. costs must cover at least two seasons.
+func fitHoltWinters(costs []float64, season int) *forecastFit {
+	grid := []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+
+	var best *holtWinters
+	bestSSE := math.Inf(1)
+	for _, alpha := range grid {
+		for _, beta := range grid {
+			for _, gamma := range grid {
+				hw := runHoltWinters(costs, season, alpha, beta, gamma)
+				if sse := sumSquares(hw.residuals); sse < bestSSE {
+					best, bestSSE = hw, sse
+				}
+			}
+		}
+	}
+
+	return &forecastFit{
+		model: forecastModelSeasonal,
+		params: map[string]interface{}{
+			"alpha":         best.alpha,
+			"beta":          best.beta,
+			"gamma":         best.gamma,
+			"season_length": season,
+		},
+		residualStdDev: stdDev(best.residuals),
+		mape:           mape(costs[season:], best.residuals),
+		predict:        best.forecast,
+	}
+}
+
+// holtWinters is the state of an additive Holt-Winters run
+type holtWinters struct {
+	alpha, beta, gamma float64
+	season             int
+	level, trend       float64
+	seasonals          []float64
+	residuals          []float64
+}
+
+func runHoltWinters(costs []float64, season int, alpha, beta, gamma float64) *holtWinters {
+	first := average(costs[:season])
+	second := average(costs[season : 2*season])
+
+	hw := &holtWinters{
+		alpha: alpha, beta: beta, gamma: gamma,
+		season:    season,
+		level:     first,
+		trend:     (second - first) / float64(season),
+		seasonals: make([]float64, len(costs)),
+		residuals: make([]float64, 0, len(costs)-season),
+	}
+	for i := 0; i < season; i++ {
+		hw.seasonals[i] = costs[i] - first
+	}
+
+	for t := season; t < len(costs); t++ {
+		y := costs[t]
+		seasonal := hw.seasonals[t-season]
+		hw.residuals = append(hw.residuals, y-(hw.level+hw.trend+seasonal))
+
+		level := alpha*(y-seasonal) + (1-alpha)*(hw.level+hw.trend)
+		hw.trend = beta*(level-hw.level) + (1-beta)*hw.trend
+		hw.level = level
+		hw.seasonals[t] = gamma*(y-level) + (1-gamma)*seasonal
+	}
+	return hw
+}
+
+func (hw *holtWinters) forecast(h int) float64 {
+	n := len(hw.seasonals)
+	seasonal := hw.seasonals[n-hw.season+(h-1)%hw.season]
+	return hw.level + float64(h)*hw.trend + seasonal
+}
+
+// linearSlope is the least-squares slope of values against their index
+func linearSlope(values []float64) float64 {
+	n := float64(len(values))
+	if n < 2 {
+		return 0
+	}
+
+	var sumX, sumY, sumXY, sumX2 float64
+	for i, v := range values {
+		x := float64(i)
+		sumX += x
+		sumY += v
+		sumXY += x * v
+		sumX2 += x * x
+	}
+
+	denominator := n*sumX2 - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
+
+func sumSquares(values []float64) float64 {
+	var total float64
+	for _, v := range values {
+		total += v * v
+	}
+	return total
+}
+
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := average(values)
+	var ss float64
+	for _, v := range values {
+		ss += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(ss / float64(len(values)-1))
+}
+
+// mape is the mean absolute percentage error of errors relative to actuals,
+// skipping zero actuals
+func mape(actuals, errors []float64) float64 {
+	var total float64
+	var n int
+	for i, e := range errors {
+		if actuals[i] == 0 {
+			continue
+		}
+		total += math.Abs(e / actuals[i])
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return total / float64(n) * 100
+}
@@ -0,0 +1,81 @@
+package cost
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// weeklyCosts is a slowly growing daily cost with a weekday/weekend cycle
+// and a little deterministic noise
+func weeklyCosts(days int) []float64 {
+	pattern := []float64{120, 125, 130, 128, 122, 60, 55}
+	costs := make([]float64, days)
+	for i := range costs {
+		noise := 3 * math.Sin(float64(i)*1.7)
+		costs[i] = pattern[i%weeklySeason] + 0.5*float64(i) + noise
+	}
+	return costs
+}
+
+func holdoutMAPE(fit *forecastFit, actual []float64) float64 {
+	errs := make([]float64, len(actual))
+	for i, y := range actual {
+		errs[i] = y - fit.predict(i+1)
+	}
+	return mape(actual, errs)
+}
+
+func TestSeasonalForecastBeatsLinear(t *testing.T) {
+	costs := weeklyCosts(70)
+	history, holdout := costs[:56], costs[56:]
+
+	seasonal := fitHoltWinters(history, weeklySeason)
+	linear := fitLinear(history)
+
+	seasonalMAPE := holdoutMAPE(seasonal, holdout)
+	linearMAPE := holdoutMAPE(linear, holdout)
+	t.Logf("holdout MAPE: seasonal=%.2f%% linear=%.2f%%", seasonalMAPE, linearMAPE)
+
+	assert.Less(t, seasonalMAPE, linearMAPE)
+	assert.Less(t, seasonalMAPE, 10.0)
+	assert.Less(t, seasonal.residualStdDev, linear.residualStdDev)
+	assert.Equal(t, weeklySeason, seasonal.params["season_length"])
+}
+
+func TestForecastInterval(t *testing.T) {
+	fit := fitLinear(weeklyCosts(28))
+	require.Greater(t, fit.residualStdDev, 0.0)
+
+	lower1, upper1 := fit.interval(100, 1)
+	lower7, upper7 := fit.interval(100, 7)
+	assert.InDelta(t, 100-lower1, upper1-100, 1e-9)
+	assert.Greater(t, upper7-lower7, upper1-lower1, "interval widens with horizon")
+
+	lower, _ := fit.interval(1, 30)
+	assert.Equal(t, 0.0, lower, "lower bound is clamped at zero")
+}
+
+func TestDailyCostSeriesFillsGaps(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	allocations := []CostAllocation{
+		{PeriodStart: day(3), TotalCost: 5},
+		{PeriodStart: day(1), TotalCost: 2},
+		{PeriodStart: day(1), TotalCost: 3},
+	}
+	assert.Equal(t, []float64{5, 0, 5}, dailyCostSeries(allocations))
+}
+
+func TestDailyCostSeriesAcrossTimeZones(t *testing.T) {
+	// 2024-05-01 22:00 in UTC-5 is 2024-05-02 03:00 UTC
+	est := time.FixedZone("EST", -5*3600)
+	allocations := []CostAllocation{
+		{PeriodStart: time.Date(2024, 5, 1, 22, 0, 0, 0, est), TotalCost: 4},
+		{PeriodStart: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), TotalCost: 1},
+		{PeriodStart: time.Date(2024, 5, 3, 23, 0, 0, 0, time.UTC), TotalCost: 2},
+	}
+	assert.Equal(t, []float64{1, 4, 2}, dailyCostSeries(allocations))
+}
@@ -0,0 +1,127 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newGPUTestService(t *testing.T, cfg *Config) *Service {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(svc.Stop)
+	return svc
+}
+
+func TestCalculateCostPricesGPUsByType(t *testing.T) {
+	ctx := context.Background()
+	svc := newGPUTestService(t, &Config{GPUPricing: map[string]map[string]float64{
+		"aws": {"nvidia-a100": 5, "default": 1},
+	}})
+
+	tests := []struct {
+		gpuType string
+		want    float64
+	}{
+		{"nvidia-a100", 10},    // overridden
+		{"nvidia-h100", 24.58}, // built-in table
+		{"", 2},                // overridden gpu_per_hour
+		{"nvidia-b200", 2},     // unknown types fall back to gpu_per_hour
+	}
+	for _, tt := range tests {
+		result, err := svc.CalculateCost(ctx, ResourceUsage{CPUCoreHours: 1, GPUHours: 2, GPUType: tt.gpuType, Hours: 1})
+		require.NoError(t, err)
+		assert.InDelta(t, tt.want, result.GPUCost, 1e-9, tt.gpuType)
+		assert.InDelta(t, result.CPUCost+tt.want, result.TotalCost, 1e-9, tt.gpuType)
+	}
+}
+
+func TestIngestUsageFlagsIdleGPUs(t *testing.T) {
+	ctx := context.Background()
+	var query string
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{"resultType": "vector", "result": []interface{}{
+				map[string]interface{}{"metric": map[string]string{"namespace": "ml"}, "value": []interface{}{1.7e9, "1.5"}},
+				map[string]interface{}{"metric": map[string]string{"namespace": "web"}, "value": []interface{}{1.7e9, "80"}},
+			}},
+		})
+	}))
+	t.Cleanup(prometheus.Close)
+	svc := newGPUTestService(t, &Config{
+		PrometheusEndpoint:  prometheus.URL,
+		GPUUtilizationQuery: `avg by (namespace) (DCGM_FI_DEV_GPU_UTIL{cluster="$cluster"})`,
+	})
+
+	trainer := requestingPod("ml", "trainer", "4", "16Gi")
+	trainer.Spec.NodeName = "gpu-1"
+	trainer.Spec.Containers[0].Resources.Limits = corev1.ResourceList{resourceNvidiaGPU: resource.MustParse("2")}
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	manager.AddClient(&kube.ClusterClient{Name: "prod", Clientset: fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-1", Labels: map[string]string{labelGPUProduct: "NVIDIA-A100-SXM4-40GB"}}},
+		trainer,
+		requestingPod("web", "api", "1", "1Gi"),
+	)})
+	svc.SetKubeManager(manager)
+
+	svc.IngestUsage(ctx)
+	assert.Equal(t, `avg by (namespace) (DCGM_FI_DEV_GPU_UTIL{cluster="prod"})`, query)
+
+	var allocs []CostAllocation
+	require.NoError(t, svc.db.Order("namespace").Find(&allocs).Error)
+	require.Len(t, allocs, 2)
+	assert.Equal(t, "nvidia-a100", allocs[0].GPUType)
+	assert.InDelta(t, 2*4.10, allocs[0].GPUCost, 1e-9)
+	require.NotNil(t, allocs[0].GPUUtilization)
+	assert.InDelta(t, 1.5, *allocs[0].GPUUtilization, 1e-9)
+	assert.Nil(t, allocs[1].GPUUtilization, "namespaces without GPUs are not measured")
+
+	recs, err := svc.GenerateRightsizingRecommendations(ctx, "prod", nil)
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	assert.Equal(t, RecommendationIdleGPU, recs[0].Reason)
+	assert.Equal(t, "ml", recs[0].Namespace)
+	assert.InDelta(t, 2, recs[0].CurrentGPUs, 1e-9)
+	assert.InDelta(t, 2*4.10*720, recs[0].MonthlySavings, 1e-6)
+}
+
+func TestIngestUsageWithoutPrometheusLeavesGPUsUnmeasured(t *testing.T) {
+	ctx := context.Background()
+	svc := newGPUTestService(t, &Config{})
+	trainer := requestingPod("ml", "trainer", "4", "16Gi")
+	trainer.Spec.Containers[0].Resources.Limits = corev1.ResourceList{resourceNvidiaGPU: resource.MustParse("1")}
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	manager.AddClient(&kube.ClusterClient{Name: "prod", Clientset: fake.NewSimpleClientset(trainer)})
+	svc.SetKubeManager(manager)
+
+	svc.IngestUsage(ctx)
+
+	var alloc CostAllocation
+	require.NoError(t, svc.db.First(&alloc).Error)
+	assert.Positive(t, alloc.GPUCost)
+	assert.Nil(t, alloc.GPUUtilization)
+	recs, err := svc.GenerateRightsizingRecommendations(ctx, "prod", nil)
+	require.NoError(t, err)
+	assert.Empty(t, recs, "unmeasured GPUs are not taken as idle")
+}
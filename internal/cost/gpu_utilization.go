@@ -0,0 +1,37 @@
+package cost
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// defaultGPUUtilizationQuery is the mean utilization (%) of each
+// namespace's GPUs over the last hour, as exported by the NVIDIA DCGM
+// exporter with pod mapping enabled
+const defaultGPUUtilizationQuery = `avg by (namespace) (avg_over_time(DCGM_FI_DEV_GPU_UTIL{namespace!=""}[1h]))`
+
+// namespaceGPUUtilization returns the GPU utilization (%) of each namespace
+// of cluster over the hour up to now, read from Prometheus. "$cluster" in
+// the query is replaced by the cluster name. Namespaces without samples
+// are omitted.
+func (s *Service) namespaceGPUUtilization(ctx context.Context, cluster string, now time.Time) (map[string]float64, error) {
+	query := s.config.GPUUtilizationQuery
+	if query == "" {
+		query = defaultGPUUtilizationQuery
+	}
+	series, err := s.prometheus.Query(ctx, strings.ReplaceAll(query, "$cluster", cluster), now)
+	if err != nil {
+		return nil, err
+	}
+
+	utilization := make(map[string]float64)
+	for _, ps := range series {
+		namespace := ps.Labels["namespace"]
+		if namespace == "" || len(ps.Values) != 1 {
+			continue
+		}
+		utilization[namespace] = ps.Values[0]
+	}
+	return utilization, nil
+}
@@ -0,0 +1,58 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/ai"
+)
+
+// maxInsightRecommendations bounds the recommendations handed to insights
+const maxInsightRecommendations = 1000
+
+// PendingRecommendations returns the rightsizing and idle GPU
+// recommendations created since since and not yet applied, as AI
+// recommendations, so the AI service raises the costly ones as insights.
+// It implements ai.RecommendationSource.
+func (s *Service) PendingRecommendations(ctx context.Context, since time.Time) ([]ai.Recommendation, error) {
+	var recs []RightsizingRecommendation
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND created_at >= ?", "pending", since).
+		Order("monthly_savings DESC").
+		Limit(maxInsightRecommendations).
+		Find(&recs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load rightsizing recommendations: %w", err)
+	}
+
+	out := make([]ai.Recommendation, 0, len(recs))
+	for _, rec := range recs {
+		workload := fmt.Sprintf("%s %s/%s", rec.WorkloadType, rec.Namespace, rec.WorkloadName)
+		r := ai.Recommendation{
+			ID:         rec.ID,
+			Type:       "cost",
+			Category:   recommendationType(&rec),
+			Impact:     "medium",
+			Effort:     "low",
+			Resource:   workload,
+			ResourceID: fmt.Sprintf("%s/%s/%s/%s", rec.ClusterID, rec.Namespace, rec.WorkloadType, rec.WorkloadName),
+			Savings:    rec.MonthlySavings,
+			Status:     rec.Status,
+			CreatedAt:  rec.CreatedAt,
+		}
+		if rec.Reason == RecommendationIdleGPU {
+			// Idle GPUs are the most expensive waste there is
+			r.Impact = "high"
+			r.Title = "Release idle GPUs of " + workload
+			r.Description = fmt.Sprintf("Container %s holds %.0f %s GPUs at %.0f%% utilization.",
+				rec.ContainerName, rec.CurrentGPUs, rec.GPUType, rec.GPUUtilization)
+		} else {
+			r.Title = "Rightsize " + workload
+			r.Description = fmt.Sprintf("Container %s requests %s CPU and %s memory; %s and %s are recommended.",
+				rec.ContainerName, rec.CurrentCPURequest, rec.CurrentMemRequest,
+				rec.RecommendedCPURequest, rec.RecommendedMemRequest)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
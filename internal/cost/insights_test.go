@@ -0,0 +1,49 @@
+package cost
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingRecommendations(t *testing.T) {
+	svc := newBudgetTestService(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, rec := range []RightsizingRecommendation{
+		{ID: "rs-cpu", ClusterID: "prod", Namespace: "shop", WorkloadType: "Deployment", WorkloadName: "web",
+			ContainerName: "app", CurrentCPURequest: "2", CurrentMemRequest: "4Gi",
+			RecommendedCPURequest: "500m", RecommendedMemRequest: "1Gi", MonthlySavings: 120, Status: "pending", CreatedAt: now},
+		{ID: "rs-gpu", ClusterID: "prod", Namespace: "ml", WorkloadType: "Deployment", WorkloadName: "train",
+			ContainerName: "trainer", GPUType: "nvidia-a100", CurrentGPUs: 2, GPUUtilization: 1.5,
+			Reason: RecommendationIdleGPU, MonthlySavings: 4000, Status: "pending", CreatedAt: now},
+		{ID: "rs-applied", ClusterID: "prod", Namespace: "shop", WorkloadType: "Deployment", WorkloadName: "api",
+			MonthlySavings: 900, Status: "applied", CreatedAt: now},
+		{ID: "rs-old", ClusterID: "prod", Namespace: "shop", WorkloadType: "Deployment", WorkloadName: "cron",
+			MonthlySavings: 900, Status: "pending", CreatedAt: now.Add(-48 * time.Hour)},
+	} {
+		require.NoError(t, svc.db.Create(&rec).Error)
+	}
+
+	recs, err := svc.PendingRecommendations(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+
+	gpu := recs[0]
+	assert.Equal(t, "rs-gpu", gpu.ID)
+	assert.Equal(t, "high", gpu.Impact)
+	assert.Equal(t, RecommendationIdleGPU, gpu.Category)
+	assert.Equal(t, "Release idle GPUs of Deployment ml/train", gpu.Title)
+	assert.Contains(t, gpu.Description, "2 nvidia-a100 GPUs at 2% utilization")
+	assert.Equal(t, "prod/ml/Deployment/train", gpu.ResourceID)
+
+	cpu := recs[1]
+	assert.Equal(t, "medium", cpu.Impact)
+	assert.Equal(t, RecommendationRightsizing, cpu.Category)
+	assert.Equal(t, 120.0, cpu.Savings)
+	assert.Contains(t, cpu.Description, "500m and 1Gi are recommended")
+}
@@ -0,0 +1,360 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// kubecostPageSize is how many allocations are requested per page
+	kubecostPageSize = 500
+
+	// defaultKubecostAggregate groups allocations by workload within namespace
+	defaultKubecostAggregate = "namespace,controller"
+
+	bytesPerGiB = 1024 * 1024 * 1024
+)
+
+// kubecostWindow matches the relative windows accepted by SyncFromKubecost,
+// e.g. the standard 1d, 7d and 30d or hour-based windows like 48h
+var kubecostWindow = regexp.MustCompile(`^[1-9][0-9]*[hd]$`)
+
+// KubecostSyncStatus records the outcome of the latest Kubecost sync per
+// window so consumers can tell how fresh the allocation data is
+type KubecostSyncStatus struct {
+	Window           string     `json:"window" gorm:"column:sync_window;primaryKey"`
+	Status           string     `json:"status"` // success, partial, failed
+	Allocations      int        `json:"allocations"`
+	FailedNamespaces []string   `json:"failed_namespaces,omitempty" gorm:"serializer:json"`
+	Error            string     `json:"error,omitempty"`
+	LastAttemptAt    time.Time  `json:"last_attempt_at"`
+	LastSuccessAt    *time.Time `json:"last_success_at"`
+}
+
+// TableName sets the sync status table name
+func (KubecostSyncStatus) TableName() string {
+	return "cost_kubecost_sync_status"
+}
+
+// kubecostAllocation is one entry of the Kubecost allocation API response
+type kubecostAllocation struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Cluster        string            `json:"cluster"`
+		Namespace      string            `json:"namespace"`
+		Controller     string            `json:"controller"`
+		ControllerKind string            `json:"controllerKind"`
+		Container      string            `json:"container"`
+		Labels         map[string]string `json:"labels"`
+	} `json:"properties"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	CPUCoreHours float64   `json:"cpuCoreHours"`
+	CPUCost      float64   `json:"cpuCost"`
+	RAMByteHours float64   `json:"ramByteHours"`
+	RAMCost      float64   `json:"ramCost"`
+	PVByteHours  float64   `json:"pvByteHours"`
+	PVCost       float64   `json:"pvCost"`
+	NetworkCost  float64   `json:"networkCost"`
+	GPUHours     float64   `json:"gpuHours"`
+	GPUCost      float64   `json:"gpuCost"`
+	// GPUAllocation is reported by Kubecost 2.x with GPU metrics enabled
+	GPUAllocation *struct {
+		GPURequestAverage float64 `json:"gpuRequestAverage"`
+		GPUUsageAverage   float64 `json:"gpuUsageAverage"`
+	} `json:"gpuAllocation"`
+	TotalCost       float64 `json:"totalCost"`
+	TotalEfficiency float64 `json:"totalEfficiency"`
+}
+
+type kubecostResponse struct {
+	Code    int                             `json:"code"`
+	Message string                          `json:"message"`
+	Data    []map[string]kubecostAllocation `json:"data"`
+}
+
+// SyncFromKubecost pulls allocations for window from the Kubecost allocation
+// API and upserts them as CostAllocation rows keyed by cluster, namespace,
+// workload and period. Namespaces are fetched one at a time so a failure in
+// one is recorded without aborting the rest. It returns the number of rows
+// written.
+func (s *Service) SyncFromKubecost(ctx context.Context, window string) (int, error) {
+	if s.config.KubecostEndpoint == "" {
+		return 0, fmt.Errorf("kubecost endpoint not configured")
+	}
+	if !kubecostWindow.MatchString(window) {
+		return 0, fmt.Errorf("unsupported kubecost window %q (use e.g. 1d, 7d, 30d)", window)
+	}
+
+	status := &KubecostSyncStatus{}
+	if err := s.db.WithContext(ctx).First(status, "sync_window = ?", window).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("failed to load sync status: %w", err)
+	}
+	status.Window = window
+	status.LastAttemptAt = time.Now()
+	status.FailedNamespaces = nil
+	status.Error = ""
+
+	namespaces, err := s.kubecostNamespaces(ctx, window)
+	if err != nil {
+		status.Status = "failed"
+		status.Error = err.Error()
+		s.saveSyncStatus(status)
+		return 0, err
+	}
+
+	written := 0
+	for _, ns := range namespaces {
+		allocations, err := s.fetchKubecostAllocations(ctx, window, s.kubecostAggregate(), ns)
+		if err == nil {
+			var n int
+			n, err = s.upsertKubecostAllocations(ctx, allocations)
+			written += n
+		}
+		if err != nil {
+			s.logger.Warn("Kubecost sync failed for namespace",
+				zap.String("namespace", ns),
+				zap.String("window", window),
+				zap.Error(err),
+			)
+			status.FailedNamespaces = append(status.FailedNamespaces, ns)
+		}
+	}
+
+	status.Allocations = written
+	switch {
+	case len(status.FailedNamespaces) == 0:
+		status.Status = "success"
+	case len(status.FailedNamespaces) < len(namespaces):
+		status.Status = "partial"
+	default:
+		status.Status = "failed"
+	}
+	if status.Status != "failed" {
+		now := time.Now()
+		status.LastSuccessAt = &now
+	} else {
+		status.Error = "all namespaces failed"
+	}
+	s.saveSyncStatus(status)
+
+	s.logger.Info("Kubecost sync completed",
+		zap.String("window", window),
+		zap.Int("allocations", written),
+		zap.Int("failed_namespaces", len(status.FailedNamespaces)),
+	)
+
+	if status.Status == "failed" {
+		return written, fmt.Errorf("kubecost sync failed for all %d namespaces", len(namespaces))
+	}
+	return written, nil
+}
+
+// GetKubecostSyncStatus returns the most recent sync attempt across windows
+func (s *Service) GetKubecostSyncStatus(ctx context.Context) (*KubecostSyncStatus, error) {
+	var status KubecostSyncStatus
+	if err := s.db.WithContext(ctx).Order("last_attempt_at DESC").First(&status).Error; err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (s *Service) saveSyncStatus(status *KubecostSyncStatus) {
+	if err := s.db.Save(status).Error; err != nil {
+		s.logger.Warn("Failed to save kubecost sync status", zap.Error(err))
+	}
+}
+
+func (s *Service) kubecostAggregate() string {
+	if s.config.KubecostAggregate != "" {
+		return s.config.KubecostAggregate
+	}
+	return defaultKubecostAggregate
+}
+
+// kubecostNamespaces lists the namespaces with allocations in window
+func (s *Service) kubecostNamespaces(ctx context.Context, window string) ([]string, error) {
+	allocations, err := s.fetchKubecostAllocations(ctx, window, "namespace", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces []string
+	for _, a := range allocations {
+		// Idle and unallocated costs are not namespaced
+		if a.Name == "" || strings.HasPrefix(a.Name, "__") {
+			continue
+		}
+		namespaces = append(namespaces, a.Name)
+	}
+	return namespaces, nil
+}
+
+// fetchKubecostAllocations reads every page of accumulated allocations for
+// window, optionally restricted to one namespace
+func (s *Service) fetchKubecostAllocations(ctx context.Context, window, aggregate, namespace string) ([]kubecostAllocation, error) {
+	var all []kubecostAllocation
+	for offset := 0; ; offset += kubecostPageSize {
+		params := url.Values{}
+		params.Set("window", window)
+		params.Set("aggregate", aggregate)
+		params.Set("accumulate", "true")
+		params.Set("offset", strconv.Itoa(offset))
+		params.Set("limit", strconv.Itoa(kubecostPageSize))
+		if namespace != "" {
+			params.Set("filter", fmt.Sprintf("namespace:%q", namespace))
+		}
+
+		page, err := s.kubecostRequest(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if len(page) < kubecostPageSize {
+			return all, nil
+		}
+	}
+}
+
+func (s *Service) kubecostRequest(ctx context.Context, params url.Values) ([]kubecostAllocation, error) {
+	endpoint := strings.TrimRight(s.config.KubecostEndpoint, "/") + "/model/allocation?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubecost request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubecost returned status %d", resp.StatusCode)
+	}
+
+	var body kubecostResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode kubecost response: %w", err)
+	}
+	if body.Code != 0 && body.Code != http.StatusOK {
+		return nil, fmt.Errorf("kubecost error %d: %s", body.Code, body.Message)
+	}
+
+	var allocations []kubecostAllocation
+	for _, set := range body.Data {
+		for name, a := range set {
+			if a.Name == "" {
+				a.Name = name
+			}
+			allocations = append(allocations, a)
+		}
+	}
+	return allocations, nil
+}
+
+// upsertKubecostAllocations writes allocations, replacing rows that already
+// exist for the same cluster, namespace, workload and period, and drops the
+// cached results covering the periods written
+func (s *Service) upsertKubecostAllocations(ctx context.Context, allocations []kubecostAllocation) (int, error) {
+	written := 0
+	var start, end time.Time
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for i := range allocations {
+			if allocations[i].Name == "__idle__" {
+				continue
+			}
+			alloc := kubecostToAllocation(&allocations[i])
+
+			var existing CostAllocation
+			err := tx.Where("cluster_id = ? AND namespace = ? AND workload_type = ? AND workload_name = ? AND period_start = ? AND period_end = ?",
+				alloc.ClusterID, alloc.Namespace, alloc.WorkloadType, alloc.WorkloadName, alloc.PeriodStart, alloc.PeriodEnd).
+				First(&existing).Error
+			switch {
+			case err == nil:
+				alloc.ID = existing.ID
+				alloc.CreatedAt = existing.CreatedAt
+				err = tx.Save(alloc).Error
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				err = tx.Create(alloc).Error
+			}
+			if err != nil {
+				return err
+			}
+			if written == 0 || alloc.PeriodStart.Before(start) {
+				start = alloc.PeriodStart
+			}
+			if written == 0 || alloc.PeriodEnd.After(end) {
+				end = alloc.PeriodEnd
+			}
+			written++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if written > 0 {
+		s.invalidatePeriod(ctx, start, end)
+	}
+	return written, nil
+}
+
+// kubecostToAllocation maps a Kubecost allocation onto a CostAllocation
+func kubecostToAllocation(a *kubecostAllocation) *CostAllocation {
+	p := a.Properties
+
+	cluster := p.Cluster
+	if cluster == "" {
+		cluster = "default"
+	}
+	workloadType, workloadName := p.ControllerKind, p.Controller
+	if workloadName == "" {
+		// Aggregated by something other than controller; keep the key
+		workloadType, workloadName = "aggregate", a.Name
+	}
+
+	alloc := &CostAllocation{
+		ID:             uuid.New().String(),
+		ClusterID:      cluster,
+		ClusterName:    cluster,
+		Namespace:      p.Namespace,
+		WorkloadType:   workloadType,
+		WorkloadName:   workloadName,
+		ContainerName:  p.Container,
+		Labels:         p.Labels,
+		CPUCoreHours:   a.CPUCoreHours,
+		CPUCost:        a.CPUCost,
+		MemoryGBHours:  a.RAMByteHours / bytesPerGiB,
+		MemoryCost:     a.RAMCost,
+		StorageGBHours: a.PVByteHours / bytesPerGiB,
+		StorageCost:    a.PVCost,
+		NetworkCost:    a.NetworkCost,
+		GPUHours:       a.GPUHours,
+		GPUCost:        a.GPUCost,
+		TotalCost:      a.TotalCost,
+		Efficiency:     a.TotalEfficiency * 100,
+		Metadata:       map[string]interface{}{"source": "kubecost", "kubecost_name": a.Name},
+		PeriodStart:    a.Start,
+		PeriodEnd:      a.End,
+		CreatedAt:      time.Now(),
+	}
+	if g := a.GPUAllocation; g != nil && g.GPURequestAverage > 0 {
+		utilization := math.Min(g.GPUUsageAverage/g.GPURequestAverage*100, 100)
+		alloc.GPUUtilization = &utilization
+	}
+	return alloc
+}
@@ -0,0 +1,109 @@
+package cost
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kubecostServer serves the allocation API for namespaces ml, with one GPU
+// allocation, web, with more allocations than fit a page, and broken,
+// which always fails
+func kubecostServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	allocation := func(namespace, controller string) map[string]interface{} {
+		return map[string]interface{}{
+			"properties": map[string]interface{}{
+				"cluster": "prod", "namespace": namespace, "controller": controller, "controllerKind": "deployment",
+			},
+			"start": start, "end": start.Add(24 * time.Hour),
+			"cpuCoreHours": 24, "cpuCost": 1, "ramByteHours": 48 * bytesPerGiB, "ramCost": 0.5,
+			"totalCost": 1.5, "totalEfficiency": 0.4,
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		assert.Equal(t, "7d", query.Get("window"))
+		set := map[string]interface{}{}
+		switch query.Get("filter") {
+		case "":
+			assert.Equal(t, "namespace", query.Get("aggregate"))
+			for _, ns := range []string{"ml", "web", "broken", "__idle__"} {
+				set[ns] = map[string]interface{}{"name": ns}
+			}
+		case `namespace:"ml"`:
+			a := allocation("ml", "trainer")
+			a["gpuHours"], a["gpuCost"] = 48.0, 196.8
+			a["gpuAllocation"] = map[string]interface{}{"gpuRequestAverage": 2, "gpuUsageAverage": 0.05}
+			set["ml/trainer"] = a
+		case `namespace:"web"`:
+			// 501 workloads: a full page, then one more
+			offset, _ := strconv.Atoi(query.Get("offset"))
+			limit, _ := strconv.Atoi(query.Get("limit"))
+			for i := offset; i < min(offset+limit, kubecostPageSize+1); i++ {
+				name := fmt.Sprintf("api-%d", i)
+				set["web/"+name] = allocation("web", name)
+			}
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "data": []interface{}{set}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSyncFromKubecost(t *testing.T) {
+	ctx := context.Background()
+	svc := newGPUTestService(t, &Config{KubecostEndpoint: kubecostServer(t).URL})
+
+	written, err := svc.SyncFromKubecost(ctx, "7d")
+	require.NoError(t, err, "a failed namespace does not fail the sync")
+	assert.Equal(t, 1+kubecostPageSize+1, written)
+
+	status, err := svc.GetKubecostSyncStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "partial", status.Status)
+	assert.Equal(t, []string{"broken"}, status.FailedNamespaces)
+	assert.NotNil(t, status.LastSuccessAt)
+
+	var trainer CostAllocation
+	require.NoError(t, svc.db.First(&trainer, "workload_name = ?", "trainer").Error)
+	assert.Equal(t, "prod", trainer.ClusterID)
+	assert.Equal(t, "ml", trainer.Namespace)
+	assert.InDelta(t, 48, trainer.MemoryGBHours, 1e-9)
+	assert.InDelta(t, 40, trainer.Efficiency, 1e-9)
+	assert.InDelta(t, 196.8, trainer.GPUCost, 1e-9)
+	require.NotNil(t, trainer.GPUUtilization)
+	assert.InDelta(t, 2.5, *trainer.GPUUtilization, 1e-9)
+
+	// Syncing the same window again replaces rows instead of adding them
+	_, err = svc.SyncFromKubecost(ctx, "7d")
+	require.NoError(t, err)
+	var count int64
+	require.NoError(t, svc.db.Model(&CostAllocation{}).Count(&count).Error)
+	assert.Equal(t, int64(written), count)
+}
+
+func TestSyncFromKubecostRejectsBadWindows(t *testing.T) {
+	svc := newGPUTestService(t, &Config{KubecostEndpoint: "http://kubecost.invalid"})
+	for _, window := range []string{"", "0d", "1w", "today"} {
+		_, err := svc.SyncFromKubecost(context.Background(), window)
+		assert.Error(t, err, window)
+	}
+
+	svc = newGPUTestService(t, &Config{})
+	_, err := svc.SyncFromKubecost(context.Background(), "1d")
+	assert.Error(t, err, "no endpoint")
+}
@@ -0,0 +1,147 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/remediation"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Recommendation types Config.RemediateRecommendations may name
+const (
+	RecommendationRightsizing = "rightsizing"
+	RecommendationIdleGPU     = "idle_gpu"
+)
+
+// RemediationSink takes the remediation events recommendations raise. The
+// remediation service implements it, and its rules decide what is done and
+// whether that waits for approval.
+type RemediationSink interface {
+	ProcessEvent(ctx context.Context, event *remediation.RemediationEvent) error
+}
+
+// SetRemediation wires where recommendations of the types named by
+// Config.RemediateRecommendations are sent to be acted on. Optional:
+// nil-safe.
+func (s *Service) SetRemediation(sink RemediationSink) { s.remediation = sink }
+
+// SetAuditRecorder sets where recommendations sent to remediation are
+// audited
+func (s *Service) SetAuditRecorder(r *audit.Recorder) { s.recorder = r }
+
+// recommendationType is the type of rec: its reason, or rightsizing for
+// CPU and memory
+func recommendationType(rec *RightsizingRecommendation) string {
+	if rec.Reason != "" {
+		return rec.Reason
+	}
+	return RecommendationRightsizing
+}
+
+// remediate sends rec to remediation as an event if its type is opted in.
+// Failures are logged; the recommendation stands either way.
+func (s *Service) remediate(ctx context.Context, rec *RightsizingRecommendation) {
+	recType := recommendationType(rec)
+	if s.remediation == nil || !slices.Contains(s.config.RemediateRecommendations, recType) {
+		return
+	}
+
+	event, err := s.remediationEvent(ctx, rec)
+	if err == nil {
+		err = s.remediation.ProcessEvent(ctx, event)
+	}
+	if err != nil {
+		s.logger.Warn("Failed to send recommendation to remediation",
+			zap.String("recommendation_id", rec.ID),
+			zap.String("type", recType),
+			zap.Error(err),
+		)
+		return
+	}
+
+	err = s.recorder.Record(ctx, audit.Entry{
+		Action:       "rightsizing.remediate",
+		ResourceType: "rightsizing_recommendation",
+		ResourceID:   rec.ID,
+		ResourceName: rec.Namespace + "/" + rec.WorkloadName,
+		ClusterID:    rec.ClusterID,
+		NewValue:     audit.Snapshot(event),
+	})
+	if err != nil {
+		s.logger.Warn("Failed to record audit log",
+			zap.String("action", "rightsizing.remediate"),
+			zap.String("resource_id", rec.ID),
+			zap.Error(err),
+		)
+	}
+}
+
+// remediationEvent describes rec to remediation rules. Its data locates
+// the container in the workload's pod template, so rules can patch it.
+func (s *Service) remediationEvent(ctx context.Context, rec *RightsizingRecommendation) (*remediation.RemediationEvent, error) {
+	if s.kubeManager == nil {
+		return nil, fmt.Errorf("kubernetes access not configured")
+	}
+	client, err := s.kubeManager.GetClient(rec.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("cluster client: %w", err)
+	}
+	podSpec, err := workloadPodSpec(ctx, client.Clientset, rec.WorkloadType, rec.Namespace, rec.WorkloadName)
+	if err != nil {
+		return nil, err
+	}
+	container, err := findContainer(podSpec, rec.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+	index := slices.IndexFunc(podSpec.Containers, func(c corev1.Container) bool { return c.Name == container.Name })
+
+	recType := recommendationType(rec)
+	data := map[string]interface{}{
+		"recommendation_id": rec.ID,
+		"container":         container.Name,
+		"container_index":   strconv.Itoa(index),
+		"monthly_savings":   rec.MonthlySavings,
+		"confidence":        rec.Confidence,
+	}
+	switch recType {
+	case RecommendationIdleGPU:
+		data["gpu_type"] = rec.GPUType
+		data["current_gpus"] = rec.CurrentGPUs
+		data["gpu_utilization"] = rec.GPUUtilization
+	default:
+		for key, value := range map[string]string{
+			"current_cpu_request":     rec.CurrentCPURequest,
+			"current_mem_request":     rec.CurrentMemRequest,
+			"recommended_cpu_request": rec.RecommendedCPURequest,
+			"recommended_mem_request": rec.RecommendedMemRequest,
+			"recommended_cpu_limit":   rec.RecommendedCPULimit,
+			"recommended_mem_limit":   rec.RecommendedMemLimit,
+		} {
+			if value != "" {
+				data[key] = value
+			}
+		}
+	}
+
+	return &remediation.RemediationEvent{
+		ID:           rec.ID,
+		Type:         remediation.RightsizingEventType,
+		Source:       "cost",
+		ClusterID:    rec.ClusterID,
+		Namespace:    rec.Namespace,
+		ResourceType: rec.WorkloadType,
+		ResourceName: rec.WorkloadName,
+		Reason:       recType,
+		Message:      fmt.Sprintf("%s recommended for %s/%s, saving %.2f a month", recType, rec.Namespace, rec.WorkloadName, rec.MonthlySavings),
+		Severity:     "info",
+		Data:         data,
+		Timestamp:    time.Now(),
+	}, nil
+}
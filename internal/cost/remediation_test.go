@@ -0,0 +1,114 @@
+package cost
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/anubhavg-icpl/krustron/internal/remediation"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newRemediatingService is a cost service sending recommendations of the
+// given types to a remediation service, for cluster prod running the
+// rightsizing deployment behind a proxy sidecar
+func newRemediatingService(t *testing.T, types ...string) (*Service, *remediation.Service) {
+	t.Helper()
+	svc := newBudgetTestService(t)
+	svc.config.RemediateRecommendations = types
+
+	sqlDB, err := svc.db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	svc.SetAuditRecorder(audittest.NewRecorder(t, sqlDB))
+
+	deploy := rightsizingDeployment()
+	containers := deploy.Spec.Template.Spec.Containers
+	deploy.Spec.Template.Spec.Containers = append([]corev1.Container{{Name: "proxy"}}, containers...)
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	manager.AddClient(&kube.ClusterClient{Name: "prod", Clientset: fake.NewSimpleClientset(deploy)})
+	svc.SetKubeManager(manager)
+
+	remDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	rem, err := remediation.NewService(remDB, zap.NewNop(), &remediation.Config{DisableWorkers: true})
+	require.NoError(t, err)
+	svc.SetRemediation(rem)
+
+	// A week of the api container using half a core and 1GiB at 20%
+	// efficiency
+	now := time.Now()
+	require.NoError(t, svc.db.Create(&CostAllocation{
+		ID:            "alloc-1",
+		ClusterID:     "prod",
+		Namespace:     "payments",
+		WorkloadType:  "Deployment",
+		WorkloadName:  "api",
+		ContainerName: "api",
+		CPUCoreHours:  84,
+		MemoryGBHours: 168,
+		TotalCost:     100,
+		Efficiency:    20,
+		PeriodStart:   now.AddDate(0, 0, -6),
+		PeriodEnd:     now.Add(-time.Hour),
+	}).Error)
+	return svc, rem
+}
+
+func TestRecommendationQueuesRemediationAction(t *testing.T) {
+	svc, rem := newRemediatingService(t, RecommendationRightsizing)
+	ctx := context.Background()
+
+	recs, err := svc.GenerateRightsizingRecommendations(ctx, "prod", nil)
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+
+	actions, total, err := rem.ListActions(ctx, nil, 10, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, total)
+	action := actions[0]
+	assert.Equal(t, remediation.RightsizingRuleID, action.RuleID)
+	assert.Equal(t, "pending_approval", action.Status)
+	assert.Equal(t, "prod", action.ClusterID)
+	assert.Equal(t, "payments", action.Namespace)
+	assert.Equal(t, "Deployment", action.ResourceType)
+	assert.Equal(t, "api", action.ResourceName)
+	assert.Equal(t, map[string]interface{}{
+		"path":      "/spec/template/spec/containers/1/resources/requests/cpu",
+		"operation": "replace",
+		"value":     "600m",
+		"max_value": "4",
+	}, action.Parameters)
+
+	var entry struct {
+		Action     string
+		ResourceID string
+	}
+	require.NoError(t, svc.db.Raw(`SELECT action, resource_id FROM audit_logs`).Scan(&entry).Error)
+	assert.Equal(t, "rightsizing.remediate", entry.Action)
+	assert.Equal(t, recs[0].ID, entry.ResourceID)
+}
+
+func TestRecommendationRemediationIsOptIn(t *testing.T) {
+	// Only idle GPU recommendations are opted in
+	svc, rem := newRemediatingService(t, RecommendationIdleGPU)
+	ctx := context.Background()
+
+	recs, err := svc.GenerateRightsizingRecommendations(ctx, "prod", nil)
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+
+	_, total, err := rem.ListActions(ctx, nil, 10, 0)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+}
@@ -0,0 +1,534 @@
+package cost
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// rightsizingPollInterval is how often workload health is checked
+	// during the rollback watch window
+	rightsizingPollInterval = 10 * time.Second
+	// rightsizingWatchStaleAfter is how long a rollback watch can go
+	// without checking in before another replica, or this one after a
+	// restart, takes it over
+	rightsizingWatchStaleAfter = time.Minute
+	// rightsizingResumeInterval is how often abandoned rollback watches are
+	// looked for
+	rightsizingResumeInterval = time.Minute
+)
+
+// ErrStaleRecommendation is returned when a workload's container resources
+// changed after the recommendation was generated
+var ErrStaleRecommendation = errors.New("recommendation is stale: workload resources changed since it was generated")
+
+// RightsizingChange describes the resource change made, or that would be
+// made on a dry run, by ApplyRightsizing
+type RightsizingChange struct {
+	RecommendationID string                      `json:"recommendation_id"`
+	ClusterID        string                      `json:"cluster_id"`
+	Namespace        string                      `json:"namespace"`
+	WorkloadType     string                      `json:"workload_type"`
+	WorkloadName     string                      `json:"workload_name"`
+	ContainerName    string                      `json:"container_name"`
+	Before           corev1.ResourceRequirements `json:"before"`
+	After            corev1.ResourceRequirements `json:"after"`
+	Diff             []string                    `json:"diff"` // e.g. "requests.cpu: 500m -> 200m"
+	DryRun           bool                        `json:"dry_run"`
+}
+
+// ApplyRightsizing patches the recommendation's Deployment or StatefulSet
+// container to the recommended requests and limits. It refuses stale
+// recommendations. With dryRun nothing is changed and the returned change
+// shows the diff. If Config.RightsizingWatchWindow is set, the workload is
+// watched afterwards and the previous resources restored if it does not
+// become healthy within the window.
+func (s *Service) ApplyRightsizing(ctx context.Context, recID, userID string, dryRun bool) (*RightsizingChange, error) {
+	var rec RightsizingRecommendation
+	if err := s.db.WithContext(ctx).First(&rec, "id = ?", recID).Error; err != nil {
+		return nil, fmt.Errorf("recommendation not found: %w", err)
+	}
+	if rec.Status == "applied" && !dryRun {
+		return nil, fmt.Errorf("recommendation %s is already applied", recID)
+	}
+	if s.kubeManager == nil {
+		return nil, fmt.Errorf("kubernetes access not configured")
+	}
+	client, err := s.kubeManager.GetClient(rec.ClusterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster client: %w", err)
+	}
+
+	change, err := applyRightsizing(ctx, client.Clientset, &rec, dryRun)
+	if err != nil || dryRun {
+		return change, err
+	}
+
+	now := time.Now()
+	applied := RightsizingRecommendation{Status: "applied", AppliedAt: &now, AppliedBy: userID}
+	window := s.config.RightsizingWatchWindow
+	if window > 0 {
+		// Recorded so the watch survives a restart; see ResumeRightsizingWatches
+		until := now.Add(window)
+		applied.RollbackUntil, applied.Rollback, applied.WatchedAt = &until, change, &now
+	}
+	if err := s.db.WithContext(ctx).Model(&RightsizingRecommendation{ID: rec.ID}).
+		Select("status", "applied_at", "applied_by", "rollback_until", "rollback", "watched_at").
+		Updates(&applied).Error; err != nil {
+		return change, fmt.Errorf("failed to record applied recommendation: %w", err)
+	}
+	s.invalidateSummaries(ctx)
+
+	s.logger.Info("Applied rightsizing recommendation",
+		zap.String("recommendation_id", rec.ID),
+		zap.String("workload", rec.Namespace+"/"+rec.WorkloadName),
+		zap.Strings("diff", change.Diff),
+		zap.String("user_id", userID),
+	)
+
+	if window > 0 {
+		go s.watchRightsizing(client.Clientset, change, *applied.RollbackUntil)
+	}
+	return change, nil
+}
+
+// applyRightsizing checks the recommendation against the live workload and
+// patches the container unless dryRun is set
+func applyRightsizing(ctx context.Context, cs kubernetes.Interface, rec *RightsizingRecommendation, dryRun bool) (*RightsizingChange, error) {
+	podSpec, err := workloadPodSpec(ctx, cs, rec.WorkloadType, rec.Namespace, rec.WorkloadName)
+	if err != nil {
+		return nil, err
+	}
+	container, err := findContainer(podSpec, rec.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.WorkloadSpecHash == "" {
+		return nil, fmt.Errorf("recommendation has no workload snapshot; regenerate it")
+	}
+	if resourcesHash(container.Resources) != rec.WorkloadSpecHash {
+		return nil, ErrStaleRecommendation
+	}
+
+	after, err := recommendedResources(container.Resources, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &RightsizingChange{
+		RecommendationID: rec.ID,
+		ClusterID:        rec.ClusterID,
+		Namespace:        rec.Namespace,
+		WorkloadType:     rec.WorkloadType,
+		WorkloadName:     rec.WorkloadName,
+		ContainerName:    container.Name,
+		Before:           container.Resources,
+		After:            after,
+		Diff:             resourcesDiff(container.Resources, after),
+		DryRun:           dryRun,
+	}
+	if dryRun || len(change.Diff) == 0 {
+		return change, nil
+	}
+
+	if err := patchContainerResources(ctx, cs, rec.WorkloadType, rec.Namespace, rec.WorkloadName, container.Name, container.Resources, after); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// watchRightsizing restores the previous resources if the workload is not
+// healthy by until. If the service stops first the watch is left recorded
+// for ResumeRightsizingWatches.
+func (s *Service) watchRightsizing(cs kubernetes.Interface, change *RightsizingChange, until time.Time) {
+	ctx, cancel := context.WithDeadline(context.Background(), until)
+	defer cancel()
+
+	ticker := time.NewTicker(rightsizingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		healthy, err := workloadHealthy(ctx, cs, change.WorkloadType, change.Namespace, change.WorkloadName)
+		if err == nil && healthy {
+			if err := s.endRightsizingWatch(change.RecommendationID, ""); err != nil {
+				s.logger.Warn("Failed to record rightsizing watch", zap.String("recommendation_id", change.RecommendationID), zap.Error(err))
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			s.rollbackRightsizing(cs, change)
+			return
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := s.db.Model(&RightsizingRecommendation{}).Where("id = ?", change.RecommendationID).
+				Update("watched_at", now).Error; err != nil {
+				s.logger.Warn("Failed to record rightsizing watch", zap.String("recommendation_id", change.RecommendationID), zap.Error(err))
+			}
+		}
+	}
+}
+
+// endRightsizingWatch clears a finished watch, setting status unless it is
+// empty
+func (s *Service) endRightsizingWatch(recID, status string) error {
+	updates := map[string]interface{}{"rollback_until": nil, "rollback": nil, "watched_at": nil}
+	if status != "" {
+		updates["status"] = status
+	}
+	return s.db.Model(&RightsizingRecommendation{}).Where("id = ?", recID).Updates(updates).Error
+}
+
+// ResumeRightsizingWatches takes over the rollback watches no replica has
+// checked in on for rightsizingWatchStaleAfter, such as those of a replica
+// that stopped or restarted mid-watch. A watch whose window has passed
+// checks the workload once and rolls back if it is unhealthy. Watches in
+// clusters that aren't connected yet are left for a later call. It returns
+// how many watches were resumed.
+func (s *Service) ResumeRightsizingWatches(ctx context.Context) (int, error) {
+	if s.kubeManager == nil {
+		return 0, nil
+	}
+	stale := time.Now().Add(-rightsizingWatchStaleAfter)
+	var recs []RightsizingRecommendation
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND rollback_until IS NOT NULL AND (watched_at IS NULL OR watched_at < ?)", "applied", stale).
+		Find(&recs).Error; err != nil {
+		return 0, fmt.Errorf("failed to list rightsizing watches: %w", err)
+	}
+
+	resumed := 0
+	for _, rec := range recs {
+		if rec.Rollback == nil {
+			continue
+		}
+		client, err := s.kubeManager.GetClient(rec.ClusterID)
+		if err != nil {
+			continue
+		}
+		// Claim the watch so only one replica resumes it
+		result := s.db.WithContext(ctx).Model(&RightsizingRecommendation{}).
+			Where("id = ? AND (watched_at IS NULL OR watched_at < ?)", rec.ID, stale).
+			Update("watched_at", time.Now())
+		if result.Error != nil {
+			return resumed, fmt.Errorf("failed to claim rightsizing watch: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+
+		s.logger.Info("Resuming rightsizing rollback watch",
+			zap.String("recommendation_id", rec.ID),
+			zap.String("workload", rec.Namespace+"/"+rec.WorkloadName),
+			zap.Time("until", *rec.RollbackUntil),
+		)
+		go s.watchRightsizing(client.Clientset, rec.Rollback, *rec.RollbackUntil)
+		resumed++
+	}
+	return resumed, nil
+}
+
+// RunRightsizingWatches resumes abandoned rollback watches every
+// rightsizingResumeInterval, starting immediately, until ctx is cancelled
+func (s *Service) RunRightsizingWatches(ctx context.Context) {
+	ticker := time.NewTicker(rightsizingResumeInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ResumeRightsizingWatches(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Warn("Failed to resume rightsizing watches", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) rollbackRightsizing(cs kubernetes.Interface, change *RightsizingChange) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	logger := s.logger.With(
+		zap.String("recommendation_id", change.RecommendationID),
+		zap.String("workload", change.Namespace+"/"+change.WorkloadName),
+	)
+
+	if err := patchContainerResources(ctx, cs, change.WorkloadType, change.Namespace, change.WorkloadName,
+		change.ContainerName, change.After, change.Before); err != nil {
+		logger.Error("Failed to roll back rightsizing", zap.Error(err))
+		return
+	}
+	if err := s.endRightsizingWatch(change.RecommendationID, "rolled_back"); err != nil {
+		logger.Warn("Failed to record rightsizing rollback", zap.Error(err))
+	}
+	s.invalidateSummaries(ctx)
+	logger.Warn("Rolled back rightsizing: workload unhealthy after change")
+}
+
+// snapshotWorkload records the target container's current resources on rec
+// so ApplyRightsizing can detect later changes. Best effort: without cluster
+// access the recommendation is left without a snapshot.
+func (s *Service) snapshotWorkload(ctx context.Context, rec *RightsizingRecommendation) {
+	if s.kubeManager == nil {
+		return
+	}
+	client, err := s.kubeManager.GetClient(rec.ClusterID)
+	if err != nil {
+		return
+	}
+	podSpec, err := workloadPodSpec(ctx, client.Clientset, rec.WorkloadType, rec.Namespace, rec.WorkloadName)
+	if err != nil {
+		return
+	}
+	container, err := findContainer(podSpec, rec.ContainerName)
+	if err != nil {
+		return
+	}
+
+	snapshotResources(rec, container)
+}
+
+func snapshotResources(rec *RightsizingRecommendation, container *corev1.Container) {
+	res := container.Resources
+	rec.ContainerName = container.Name
+	rec.CurrentCPURequest = quantityString(res.Requests, corev1.ResourceCPU)
+	rec.CurrentCPULimit = quantityString(res.Limits, corev1.ResourceCPU)
+	rec.CurrentMemRequest = quantityString(res.Requests, corev1.ResourceMemory)
+	rec.CurrentMemLimit = quantityString(res.Limits, corev1.ResourceMemory)
+	rec.WorkloadSpecHash = resourcesHash(res)
+}
+
+func workloadPodSpec(ctx context.Context, cs kubernetes.Interface, kind, namespace, name string) (*corev1.PodSpec, error) {
+	switch strings.ToLower(kind) {
+	case "deployment":
+		d, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &d.Spec.Template.Spec, nil
+	case "statefulset":
+		st, err := cs.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &st.Spec.Template.Spec, nil
+	default:
+		return nil, fmt.Errorf("unsupported workload type for rightsizing: %s", kind)
+	}
+}
+
+func workloadHealthy(ctx context.Context, cs kubernetes.Interface, kind, namespace, name string) (bool, error) {
+	switch strings.ToLower(kind) {
+	case "deployment":
+		d, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		return d.Status.ObservedGeneration >= d.Generation &&
+			d.Status.UpdatedReplicas == replicas &&
+			d.Status.AvailableReplicas == replicas &&
+			d.Status.UnavailableReplicas == 0, nil
+	case "statefulset":
+		st, err := cs.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if st.Spec.Replicas != nil {
+			replicas = *st.Spec.Replicas
+		}
+		return st.Status.ObservedGeneration >= st.Generation &&
+			st.Status.UpdatedReplicas == replicas &&
+			st.Status.ReadyReplicas == replicas, nil
+	default:
+		return false, fmt.Errorf("unsupported workload type for rightsizing: %s", kind)
+	}
+}
+
+// patchContainerResources strategic-merge-patches one container's resources
+// from current to desired, removing entries desired does not have
+func patchContainerResources(ctx context.Context, cs kubernetes.Interface, kind, namespace, name, container string, current, desired corev1.ResourceRequirements) error {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name": container,
+							"resources": map[string]interface{}{
+								"requests": resourceListPatch(current.Requests, desired.Requests),
+								"limits":   resourceListPatch(current.Limits, desired.Limits),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(kind) {
+	case "deployment":
+		_, err = cs.AppsV1().Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	case "statefulset":
+		_, err = cs.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	default:
+		err = fmt.Errorf("unsupported workload type for rightsizing: %s", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to patch %s %s/%s: %w", kind, namespace, name, err)
+	}
+	return nil
+}
+
+func resourceListPatch(current, desired corev1.ResourceList) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for name := range current {
+		patch[string(name)] = nil
+	}
+	for name, q := range desired {
+		patch[string(name)] = q.String()
+	}
+	return patch
+}
+
+func findContainer(podSpec *corev1.PodSpec, name string) (*corev1.Container, error) {
+	if name == "" {
+		if len(podSpec.Containers) == 1 {
+			return &podSpec.Containers[0], nil
+		}
+		return nil, fmt.Errorf("workload has %d containers; recommendation must name one", len(podSpec.Containers))
+	}
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == name {
+			return &podSpec.Containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("container %q not found", name)
+}
+
+// recommendedResources overlays the recommendation's non-empty values on
+// current
+func recommendedResources(current corev1.ResourceRequirements, rec *RightsizingRecommendation) (corev1.ResourceRequirements, error) {
+	after := *current.DeepCopy()
+	set := func(list *corev1.ResourceList, name corev1.ResourceName, value string) error {
+		if value == "" {
+			return nil
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("invalid recommended %s %q: %w", name, value, err)
+		}
+		if *list == nil {
+			*list = corev1.ResourceList{}
+		}
+		(*list)[name] = q
+		return nil
+	}
+
+	for _, r := range []struct {
+		list  *corev1.ResourceList
+		name  corev1.ResourceName
+		value string
+	}{
+		{&after.Requests, corev1.ResourceCPU, rec.RecommendedCPURequest},
+		{&after.Limits, corev1.ResourceCPU, rec.RecommendedCPULimit},
+		{&after.Requests, corev1.ResourceMemory, rec.RecommendedMemRequest},
+		{&after.Limits, corev1.ResourceMemory, rec.RecommendedMemLimit},
+	} {
+		if err := set(r.list, r.name, r.value); err != nil {
+			return after, err
+		}
+	}
+
+	// A request above its limit would be rejected by the API server
+	for name, req := range after.Requests {
+		if limit, ok := after.Limits[name]; ok && req.Cmp(limit) > 0 {
+			return after, fmt.Errorf("recommended %s request %s exceeds limit %s", name, req.String(), limit.String())
+		}
+	}
+	return after, nil
+}
+
+func resourcesDiff(before, after corev1.ResourceRequirements) []string {
+	var diff []string
+	compare := func(section string, b, a corev1.ResourceList) {
+		names := make(map[corev1.ResourceName]bool)
+		for n := range b {
+			names[n] = true
+		}
+		for n := range a {
+			names[n] = true
+		}
+		for n := range names {
+			bq, bok := b[n]
+			aq, aok := a[n]
+			if bok && aok && bq.Cmp(aq) == 0 {
+				continue
+			}
+			from, to := "<unset>", "<unset>"
+			if bok {
+				from = bq.String()
+			}
+			if aok {
+				to = aq.String()
+			}
+			diff = append(diff, fmt.Sprintf("%s.%s: %s -> %s", section, n, from, to))
+		}
+	}
+	compare("requests", before.Requests, after.Requests)
+	compare("limits", before.Limits, after.Limits)
+	sort.Strings(diff)
+	return diff
+}
+
+func resourcesHash(res corev1.ResourceRequirements) string {
+	// Quantities are normalised so "1000m" and "1" hash the same
+	canonical := func(list corev1.ResourceList) map[string]string {
+		out := make(map[string]string, len(list))
+		for n, q := range list {
+			out[string(n)] = q.String()
+		}
+		return out
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"requests": canonical(res.Requests),
+		"limits":   canonical(res.Limits),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func quantityString(list corev1.ResourceList, name corev1.ResourceName) string {
+	if q, ok := list[name]; ok {
+		return q.String()
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+package cost
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func rightsizingDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: "api",
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("1"),
+								corev1.ResourceMemory: resource.MustParse("2Gi"),
+							},
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("4Gi"),
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func rightsizingRec(deploy *appsv1.Deployment) *RightsizingRecommendation {
+	rec := &RightsizingRecommendation{
+		ID:                    "rec-1",
+		Namespace:             deploy.Namespace,
+		WorkloadType:          "Deployment",
+		WorkloadName:          deploy.Name,
+		RecommendedCPURequest: "250m",
+		RecommendedMemRequest: "1Gi",
+	}
+	snapshotResources(rec, &deploy.Spec.Template.Spec.Containers[0])
+	return rec
+}
+
+func TestApplyRightsizingDryRun(t *testing.T) {
+	deploy := rightsizingDeployment()
+	cs := fake.NewSimpleClientset(deploy)
+	rec := rightsizingRec(deploy)
+
+	change, err := applyRightsizing(context.Background(), cs, rec, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"requests.cpu: 1 -> 250m",
+		"requests.memory: 2Gi -> 1Gi",
+	}, change.Diff)
+
+	got, err := cs.AppsV1().Deployments("payments").Get(context.Background(), "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	cpu := got.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU]
+	assert.Equal(t, "1", cpu.String(), "dry run must not change the workload")
+}
+
+func TestApplyRightsizingPatchesContainer(t *testing.T) {
+	deploy := rightsizingDeployment()
+	cs := fake.NewSimpleClientset(deploy)
+	rec := rightsizingRec(deploy)
+
+	_, err := applyRightsizing(context.Background(), cs, rec, false)
+	require.NoError(t, err)
+
+	got, err := cs.AppsV1().Deployments("payments").Get(context.Background(), "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	res := got.Spec.Template.Spec.Containers[0].Resources
+	cpu := res.Requests[corev1.ResourceCPU]
+	mem := res.Requests[corev1.ResourceMemory]
+	limit := res.Limits[corev1.ResourceMemory]
+	assert.Equal(t, "250m", cpu.String())
+	assert.Equal(t, "1Gi", mem.String())
+	assert.Equal(t, "4Gi", limit.String(), "limits without a recommendation are kept")
+}
+
+func TestApplyRightsizingRefusesStale(t *testing.T) {
+	deploy := rightsizingDeployment()
+	rec := rightsizingRec(deploy)
+
+	// Someone edits the workload after the recommendation was generated
+	deploy.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("2")
+	cs := fake.NewSimpleClientset(deploy)
+
+	_, err := applyRightsizing(context.Background(), cs, rec, false)
+	assert.ErrorIs(t, err, ErrStaleRecommendation)
+}
+
+func TestRecommendedResourcesRejectsRequestAboveLimit(t *testing.T) {
+	deploy := rightsizingDeployment()
+	rec := rightsizingRec(deploy)
+	rec.RecommendedMemRequest = "8Gi"
+
+	_, err := recommendedResources(deploy.Spec.Template.Spec.Containers[0].Resources, rec)
+	assert.ErrorContains(t, err, "exceeds limit")
+}
+
+func TestResumeRightsizingWatchRollsBackUnhealthyWorkload(t *testing.T) {
+	ctx := context.Background()
+	// Two replicas sharing a database and a cluster; the deployment never
+	// becomes available
+	db, err := gorm.Open(sqlite.Open("file:"+uuid.NewString()+"?mode=memory&cache=shared"), &gorm.Config{})
+	require.NoError(t, err)
+	deploy := rightsizingDeployment()
+	cs := fake.NewSimpleClientset(deploy)
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	manager.AddClient(&kube.ClusterClient{Name: "prod", Clientset: cs})
+	replica := func() *Service {
+		svc, err := NewService(db, zap.NewNop(), &Config{RightsizingWatchWindow: time.Hour})
+		require.NoError(t, err)
+		t.Cleanup(svc.Stop)
+		svc.SetKubeManager(manager)
+		return svc
+	}
+	a, b := replica(), replica()
+
+	rec := rightsizingRec(deploy)
+	rec.ClusterID, rec.Status = "prod", "pending"
+	require.NoError(t, db.Create(rec).Error)
+	_, err = a.ApplyRightsizing(ctx, rec.ID, "u1", false)
+	require.NoError(t, err)
+
+	var stored RightsizingRecommendation
+	require.NoError(t, db.First(&stored, "id = ?", rec.ID).Error)
+	assert.Equal(t, "applied", stored.Status)
+	require.NotNil(t, stored.RollbackUntil)
+	require.NotNil(t, stored.Rollback)
+	assert.Equal(t, "api", stored.Rollback.ContainerName)
+
+	// a is still watching
+	n, err := b.ResumeRightsizingWatches(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	// a stops, and b finds the watch once it goes stale; the window has
+	// since passed, so b rolls back at once
+	a.Stop()
+	past := time.Now().Add(-2 * rightsizingWatchStaleAfter)
+	require.NoError(t, db.Model(&RightsizingRecommendation{}).Where("id = ?", rec.ID).
+		Updates(map[string]interface{}{"watched_at": past, "rollback_until": past}).Error)
+	n, err = b.ResumeRightsizingWatches(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.Eventually(t, func() bool {
+		var got RightsizingRecommendation
+		return db.First(&got, "id = ?", rec.ID).Error == nil && got.Status == "rolled_back"
+	}, 5*time.Second, 10*time.Millisecond)
+	got, err := cs.AppsV1().Deployments("payments").Get(ctx, "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	cpu := got.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU]
+	assert.Equal(t, "1", cpu.String())
+
+	var finished RightsizingRecommendation
+	require.NoError(t, db.First(&finished, "id = ?", rec.ID).Error)
+	assert.Nil(t, finished.RollbackUntil)
+	assert.Nil(t, finished.Rollback)
+	n, err = b.ResumeRightsizingWatches(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n, "a finished watch is not resumed")
+}
@@ -15,17 +15,43 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/httpsafe"
 	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/anubhavg-icpl/krustron/pkg/prometheus"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// resourceNvidiaGPU is the extended resource name of the NVIDIA device plugin
+	resourceNvidiaGPU corev1.ResourceName = "nvidia.com/gpu"
+
+	// labelGPUProduct is set on GPU nodes by NVIDIA GPU feature discovery
+	labelGPUProduct = "nvidia.com/gpu.product"
+
+	// idleGPUUtilization is the utilization (%) below which an allocated GPU
+	// is treated as idle
+	idleGPUUtilization = 5.0
 )
 
 // Config holds cost service configuration
 type Config struct {
 	KubecostEndpoint    string
+	KubecostAggregate   string // Kubecost aggregate-by, default "namespace,controller"
 	PrometheusEndpoint  string
+	PrometheusUsername  string
+	PrometheusPassword  string
+	// GPUUtilizationQuery overrides the PromQL query giving each
+	// namespace's GPU utilization (%); "$cluster" is replaced by the
+	// cluster name. Defaults to the DCGM exporter's DCGM_FI_DEV_GPU_UTIL.
+	GPUUtilizationQuery string
 	CloudProvider       string // aws, gcp, azure
 	AWSRegion           string
 	GCPProject          string
@@ -36,6 +62,39 @@ type Config struct {
 	AlertThreshold      float64
 	CacheEnabled        bool
 	CacheTTL            time.Duration
+	// GPUPricing overrides per-GPU hourly rates: provider -> GPU type
+	// (e.g. "nvidia-a100") -> price. The "default" type sets gpu_per_hour.
+	GPUPricing map[string]map[string]float64
+	// BudgetNotifications are where budget alerts go unless a budget sets
+	// its own NotificationTargets
+	BudgetNotifications []NotificationTarget
+	SMTPAddr            string // host:port for email notifications
+	SMTPFrom            string
+	SMTPUsername        string
+	SMTPPassword        string
+	// ChargebackPolicy is the default way chargeback reports spread shared
+	// and unallocated costs: proportional (default), even or none
+	ChargebackPolicy string
+	SharedNamespaces []string // e.g. kube-system, monitoring
+	// RightsizingWatchWindow, if set, is how long an applied rightsizing
+	// has to become healthy before it is rolled back
+	RightsizingWatchWindow time.Duration
+	// ExchangeRates are static rates per one unit of DefaultCurrency, e.g.
+	// {"EUR": 0.92, "INR": 83.1}. ExchangeRateURL, if set, fetches live
+	// rates instead, cached for ExchangeRateTTL.
+	ExchangeRates   map[string]float64
+	ExchangeRateURL string
+	ExchangeRateTTL time.Duration
+	// SpotDiscounts and ReservedDiscounts override the fraction saved by
+	// spot capacity and by one-year reservations, per provider, e.g.
+	// {"aws": 0.7}
+	SpotDiscounts     map[string]float64
+	ReservedDiscounts map[string]float64
+	// Egress limits where budget alert webhooks may be sent
+	Egress httpsafe.Policy
+	// RemediateRecommendations are the recommendation types, rightsizing
+	// or idle_gpu, sent to remediation to be acted on when generated
+	RemediateRecommendations []string
 }
 
 // Service provides cost management operations
@@ -44,95 +103,264 @@ type Service struct {
 	logger      *zap.Logger
 	config      *Config
 	httpClient  *http.Client
+	prometheus  *prometheus.Client
 	cache       sync.Map
 	pricingData map[string]map[string]float64
+	gpuPricing  map[string]map[string]float64
 	kubeManager *kube.ClientManager
+	rates       ExchangeRateProvider
+	overrides   *clusterconfig.Store
+	remediation RemediationSink
+	recorder    *audit.Recorder
+	// webhookClient posts budget alerts to user-supplied URLs
+	webhookClient *http.Client
+	notifier      notify.Dispatcher
+	// resultCache holds computed summaries and reports; see SetCache
+	resultCache *cache.RedisCache
+	// stopCh ends rightsizing watches; see Stop
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
 // SetKubeManager wires the cluster manager so IngestUsage can sample live
 // resource usage. Optional: nil-safe.
 func (s *Service) SetKubeManager(km *kube.ClientManager) { s.kubeManager = km }
 
-// IngestUsage samples each registered cluster's capacity, prices one hour via
-// CalculateCost, and writes a CostAllocation row. Intended to run on a ticker
-// (main.go) so the cost tables accumulate real data instead of staying empty.
+// SetNotifier also sends budget alerts through d's routes, including for
+// budgets without notification targets. Optional.
+func (s *Service) SetNotifier(d notify.Dispatcher) { s.notifier = d }
+
+// SetClusterOverrides sets where per-cluster overrides of the cloud
+// provider and region clusters are priced with are read
+func (s *Service) SetClusterOverrides(store *clusterconfig.Store) { s.overrides = store }
+
+// namespaceUsage is what the running and pending pods of a namespace
+// request
+type namespaceUsage struct {
+	cpuMillis  int64
+	memoryMi   int64
+	gpusByType map[string]int64
+}
+
+// IngestUsage samples each registered cluster's resource requests, prices
+// one hour via CalculateCost, and writes a CostAllocation row per
+// namespace, labelled with the namespace's labels so chargeback can group
+// by owner. A cluster without requests gets one cluster-wide row priced
+// from node capacity. Intended to run on a ticker (main.go) so the cost
+// tables accumulate real data instead of staying empty.
 func (s *Service) IngestUsage(ctx context.Context) {
 	if s.kubeManager == nil || s.db == nil {
 		return
 	}
+	// The cached results over the sampled period are dropped once all
+	// clusters are written
+	var start, end time.Time
+	written := 0
 	for _, name := range s.kubeManager.ListClusters() {
 		client, err := s.kubeManager.GetClient(name)
 		if err != nil {
 			continue
 		}
-		// Sample real workload demand: sum container resource requests across
-		// the whole cluster (all namespaces). Reflects what workloads ask for,
-		// not bare node capacity, so cost tracks actual usage.
-		var reqCPUm, reqMemMi int64
-		if pods, perr := client.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{}); perr == nil {
-			for _, pod := range pods.Items {
-				if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
-					continue
-				}
-				for _, c := range pod.Spec.Containers {
-					if r, ok := c.Resources.Requests[corev1.ResourceCPU]; ok {
-						reqCPUm += r.MilliValue()
-					}
-					if r, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
-						reqMemMi += r.Value() / (1024 * 1024)
-					}
-				}
+		now := time.Now().UTC()
+		for _, alloc := range s.sampleCluster(ctx, name, client, now) {
+			if err := s.db.Create(alloc).Error; err != nil {
+				s.logger.Warn("cost ingest: save failed", zap.String("cluster", name), zap.Error(err))
+				continue
 			}
+			if written == 0 || alloc.PeriodStart.Before(start) {
+				start = alloc.PeriodStart
+			}
+			if written == 0 || alloc.PeriodEnd.After(end) {
+				end = alloc.PeriodEnd
+			}
+			written++
 		}
+	}
+	if written > 0 {
+		s.invalidatePeriod(ctx, start, end)
+	}
+}
 
-		cpuCores := float64(reqCPUm) / 1000.0
-		memGiB := float64(reqMemMi) / 1024.0
-
-		// Fall back to node capacity when no requests are declared (fresh
-		// cluster) so the cluster still shows a baseline.
-		if cpuCores == 0 || memGiB == 0 {
-			if info, infoErr := client.GetClusterInfo(ctx); infoErr == nil {
-				if cpuCores == 0 {
-					cpuCores = parseMillicores(info.CPUCapacity) / 1000.0
+// sampleCluster prices the hour up to now of every namespace of cluster
+// name that requests resources
+func (s *Service) sampleCluster(ctx context.Context, name string, client *kube.ClusterClient, now time.Time) []*CostAllocation {
+	// Sample real workload demand: sum container resource requests per
+	// namespace. Reflects what workloads ask for, not bare node capacity,
+	// so cost tracks actual usage.
+	usage := make(map[string]*namespaceUsage)
+	nodeGPUTypes := s.nodeGPUTypes(ctx, client.Clientset.CoreV1().Nodes())
+	if pods, perr := client.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{}); perr == nil {
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending {
+				continue
+			}
+			u, ok := usage[pod.Namespace]
+			if !ok {
+				u = &namespaceUsage{gpusByType: make(map[string]int64)}
+				usage[pod.Namespace] = u
+			}
+			for _, c := range pod.Spec.Containers {
+				if r, ok := c.Resources.Requests[corev1.ResourceCPU]; ok {
+					u.cpuMillis += r.MilliValue()
+				}
+				if r, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
+					u.memoryMi += r.Value() / (1024 * 1024)
 				}
-				if memGiB == 0 {
-					memGiB = parseGiB(info.MemoryCapacity)
+				// Extended resources like GPUs are requested through limits
+				if r, ok := c.Resources.Limits[resourceNvidiaGPU]; ok {
+					u.gpusByType[nodeGPUTypes[pod.Spec.NodeName]] += r.Value()
 				}
 			}
 		}
+	}
 
-		result, err := s.CalculateCost(ctx, ResourceUsage{
-			CPUCoreHours:  cpuCores,
-			MemoryGBHours: memGiB,
-			Hours:         1.0,
-		})
-		if err != nil {
+	labels := make(map[string]map[string]string)
+	if list, nerr := client.Clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{}); nerr == nil {
+		for _, ns := range list.Items {
+			labels[ns.Name] = ns.Labels
+		}
+	}
+
+	namespaces := make([]string, 0, len(usage))
+	for namespace := range usage {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	var allocs []*CostAllocation
+	for _, namespace := range namespaces {
+		u := usage[namespace]
+		if u.cpuMillis == 0 && u.memoryMi == 0 && len(u.gpusByType) == 0 {
+			continue
+		}
+		alloc := s.priceUsage(ctx, name, float64(u.cpuMillis)/1000.0, float64(u.memoryMi)/1024.0, u.gpusByType, now)
+		if alloc == nil {
 			continue
 		}
+		alloc.Namespace = namespace
+		alloc.WorkloadType = "namespace"
+		alloc.WorkloadName = namespace
+		alloc.Labels = labels[namespace]
+		allocs = append(allocs, alloc)
+	}
+	if len(allocs) > 0 {
+		s.measureGPUUtilization(ctx, name, allocs, now)
+		return allocs
+	}
 
-		now := time.Now().UTC()
-		alloc := &CostAllocation{
-			ID:            uuid.NewString(),
-			ClusterID:     name,
-			ClusterName:   name,
-			WorkloadType:  "cluster",
-			WorkloadName:  name,
-			CPUCoreHours:  cpuCores,
-			CPUCost:       result.CPUCost,
-			MemoryGBHours: memGiB,
-			MemoryCost:    result.MemoryCost,
-			StorageCost:   result.StorageCost,
-			NetworkCost:   result.NetworkCost,
-			TotalCost:     result.TotalCost,
-			Efficiency:    100,
-			PeriodStart:   now.Add(-time.Hour),
-			PeriodEnd:     now,
-			CreatedAt:     now,
+	// Fall back to node capacity when no requests are declared (fresh
+	// cluster) so the cluster still shows a baseline.
+	info, err := client.GetClusterInfo(ctx)
+	if err != nil {
+		return nil
+	}
+	alloc := s.priceUsage(ctx, name, parseMillicores(info.CPUCapacity)/1000.0, parseGiB(info.MemoryCapacity), nil, now)
+	if alloc == nil {
+		return nil
+	}
+	alloc.WorkloadType = "cluster"
+	alloc.WorkloadName = name
+	return []*CostAllocation{alloc}
+}
+
+// measureGPUUtilization sets the GPU utilization of the allocations that
+// hold GPUs from Prometheus, so idle GPUs can be flagged. Without a
+// Prometheus endpoint it is left unmeasured.
+func (s *Service) measureGPUUtilization(ctx context.Context, name string, allocs []*CostAllocation, now time.Time) {
+	if s.prometheus == nil {
+		return
+	}
+	holding := false
+	for _, alloc := range allocs {
+		holding = holding || alloc.GPUHours > 0
+	}
+	if !holding {
+		return
+	}
+
+	utilization, err := s.namespaceGPUUtilization(ctx, name, now)
+	if err != nil {
+		s.logger.Warn("cost ingest: gpu utilization query failed", zap.String("cluster", name), zap.Error(err))
+		return
+	}
+	for _, alloc := range allocs {
+		if value, ok := utilization[alloc.Namespace]; ok && alloc.GPUHours > 0 {
+			alloc.GPUUtilization = &value
+		}
+	}
+}
+
+// priceUsage prices an hour of cpuCores, memGiB and GPUs by type in cluster
+// name, ending at now. It returns nil if the usage can't be priced.
+func (s *Service) priceUsage(ctx context.Context, name string, cpuCores, memGiB float64, gpusByType map[string]int64, now time.Time) *CostAllocation {
+	result, err := s.CalculateCost(ctx, ResourceUsage{
+		ClusterID:     name,
+		CPUCoreHours:  cpuCores,
+		MemoryGBHours: memGiB,
+		Hours:         1.0,
+	})
+	if err != nil {
+		return nil
+	}
+
+	// Price GPUs per type; a cluster can mix models
+	var gpuHours float64
+	var gpuTypes []string
+	for gpuType, count := range gpusByType {
+		gpuResult, gerr := s.CalculateCost(ctx, ResourceUsage{ClusterID: name, GPUHours: float64(count), GPUType: gpuType, Hours: 1.0})
+		if gerr != nil {
+			continue
+		}
+		result.GPUCost += gpuResult.GPUCost
+		result.TotalCost += gpuResult.GPUCost
+		gpuHours += float64(count)
+		if gpuType != "" {
+			gpuTypes = append(gpuTypes, gpuType)
 		}
-		if err := s.db.Create(alloc).Error; err != nil {
-			s.logger.Warn("cost ingest: save failed", zap.String("cluster", name), zap.Error(err))
+	}
+	sort.Strings(gpuTypes)
+
+	alloc := &CostAllocation{
+		ID:            uuid.NewString(),
+		ClusterID:     name,
+		ClusterName:   name,
+		CPUCoreHours:  cpuCores,
+		CPUCost:       result.CPUCost,
+		MemoryGBHours: memGiB,
+		MemoryCost:    result.MemoryCost,
+		StorageCost:   result.StorageCost,
+		NetworkCost:   result.NetworkCost,
+		GPUCost:       result.GPUCost,
+		GPUHours:      gpuHours,
+		GPUType:       strings.Join(gpuTypes, ","),
+		TotalCost:     result.TotalCost,
+		Efficiency:    100,
+		PeriodStart:   now.Add(-time.Hour),
+		PeriodEnd:     now,
+		Metadata:      map[string]interface{}{"cloud_provider": result.Provider},
+		CreatedAt:     now,
+	}
+	if result.Region != "" {
+		alloc.Metadata["region"] = result.Region
+	}
+	return alloc
+}
+
+// nodeGPUTypes maps node names to their normalized GPU type, read from the
+// GPU feature discovery product label. Nodes without the label are omitted.
+func (s *Service) nodeGPUTypes(ctx context.Context, nodes corev1client.NodeInterface) map[string]string {
+	types := make(map[string]string)
+	list, err := nodes.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		s.logger.Debug("cost ingest: list nodes failed", zap.Error(err))
+		return types
+	}
+	for _, node := range list.Items {
+		if product := node.Labels[labelGPUProduct]; product != "" {
+			types[node.Name] = normalizeGPUType(product)
 		}
 	}
+	return types
 }
 
 // parseMillicores parses strings like "8000m" (millicores) or "8" (cores).
@@ -172,6 +400,9 @@ type CostAllocation struct {
 	StorageCost        float64                `json:"storage_cost"`
 	NetworkCost        float64                `json:"network_cost"`
 	GPUCost            float64                `json:"gpu_cost"`
+	GPUHours           float64                `json:"gpu_hours"`
+	GPUType            string                 `json:"gpu_type"`
+	GPUUtilization     *float64               `json:"gpu_utilization,omitempty"` // 0-100%, nil when not measured
 	TotalCost          float64                `json:"total_cost"`
 	Efficiency         float64                `json:"efficiency"` // 0-100%
 	Metadata           map[string]interface{} `json:"metadata" gorm:"serializer:json"`
@@ -197,6 +428,9 @@ type CostReport struct {
 	Breakdown       []CostBreakdown          `json:"breakdown" gorm:"foreignKey:ReportID"`
 	Trends          []CostTrend              `json:"trends" gorm:"serializer:json"`
 	Recommendations []CostRecommendation     `json:"recommendations" gorm:"foreignKey:ReportID"`
+	Currency        string                   `json:"currency"`
+	ExchangeRate    float64                  `json:"exchange_rate,omitempty"` // from DefaultCurrency, when converted
+	ExchangeRateAt  *time.Time               `json:"exchange_rate_at,omitempty"`
 	GeneratedAt     time.Time                `json:"generated_at"`
 	CreatedBy       string                   `json:"created_by"`
 }
@@ -210,6 +444,8 @@ type CostBreakdown struct {
 	Cost      float64 `json:"cost"`
 	Percentage float64 `json:"percentage"`
 	Trend     float64 `json:"trend"` // % change from previous period
+	ParentID  string  `json:"parent_id,omitempty"` // enclosing group when grouping by several dimensions
+	Level     int     `json:"level"`
 }
 
 // CostTrend represents cost trend data
@@ -260,6 +496,7 @@ type Budget struct {
 	ForecastSpend float64                `json:"forecast_spend"`
 	Status        string                 `json:"status"` // on_track, warning, exceeded
 	Alerts        []BudgetAlert          `json:"alerts" gorm:"foreignKey:BudgetID"`
+	NotificationTargets []NotificationTarget `json:"notification_targets,omitempty" gorm:"serializer:json"` // overrides Config.BudgetNotifications
 	PeriodStart   time.Time              `json:"period_start"`
 	PeriodEnd     time.Time              `json:"period_end"`
 	CreatedAt     time.Time              `json:"created_at"`
@@ -271,6 +508,8 @@ type Budget struct {
 type BudgetAlert struct {
 	ID          string    `json:"id" gorm:"primaryKey"`
 	BudgetID    string    `json:"budget_id" gorm:"index"`
+	Type        string    `json:"type" gorm:"default:threshold"` // threshold, forecast
+	PeriodStart time.Time `json:"period_start"`
 	Threshold   float64   `json:"threshold"`
 	CurrentSpend float64  `json:"current_spend"`
 	Message     string    `json:"message"`
@@ -325,10 +564,23 @@ type RightsizingRecommendation struct {
 	MemUsageP50       float64   `json:"mem_usage_p50"`
 	MemUsageP95       float64   `json:"mem_usage_p95"`
 	MemUsageP99       float64   `json:"mem_usage_p99"`
+	GPUType           string    `json:"gpu_type,omitempty"`
+	CurrentGPUs       float64   `json:"current_gpus,omitempty"`
+	GPUUtilization    float64   `json:"gpu_utilization,omitempty"`
+	Reason            string    `json:"reason,omitempty"` // e.g. idle_gpu; empty for CPU/memory rightsizing
 	MonthlySavings    float64   `json:"monthly_savings"`
 	Confidence        float64   `json:"confidence"`
-	Status            string    `json:"status"`
+	WorkloadSpecHash  string    `json:"workload_spec_hash,omitempty"` // container resources when generated
+	Status            string    `json:"status"` // pending, applied, rolled_back
 	AppliedAt         *time.Time `json:"applied_at"`
+	AppliedBy         string    `json:"applied_by,omitempty"`
+	// RollbackUntil ends the rollback watch of an applied recommendation;
+	// nil when there is none
+	RollbackUntil *time.Time `json:"rollback_until,omitempty"`
+	// Rollback is the change the watch reverts if the workload is unhealthy
+	Rollback *RightsizingChange `json:"-" gorm:"serializer:json"`
+	// WatchedAt is when the replica watching the workload last checked in
+	WatchedAt *time.Time `json:"-"`
 	CreatedAt         time.Time `json:"created_at"`
 }
 
@@ -344,6 +596,7 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 		&BudgetAlert{},
 		&CostForecast{},
 		&RightsizingRecommendation{},
+		&KubecostSyncStatus{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate cost tables: %w", err)
 	}
@@ -359,17 +612,55 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 		config.CacheTTL = 15 * time.Minute
 	}
 
+	webhookClient, err := httpsafe.NewClient(config.Egress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress policy: %w", err)
+	}
+
 	svc := &Service{
-		db:          db,
-		logger:      logger,
-		config:      config,
-		httpClient:  &http.Client{Timeout: 30 * time.Second},
-		pricingData: initializePricingData(),
+		db:            db,
+		logger:        logger,
+		config:        config,
+		httpClient:    &http.Client{Timeout: 30 * time.Second},
+		webhookClient: webhookClient,
+		pricingData:   initializePricingData(),
+		gpuPricing:    initializeGPUPricing(),
+		stopCh:        make(chan struct{}),
+	}
+	svc.rates = newExchangeRateProvider(config, svc.httpClient)
+	if config.PrometheusEndpoint != "" {
+		svc.prometheus = prometheus.NewClient(prometheus.Config{
+			URL:      config.PrometheusEndpoint,
+			Username: config.PrometheusUsername,
+			Password: config.PrometheusPassword,
+		})
+	}
+
+	for provider, rates := range config.GPUPricing {
+		if svc.gpuPricing[provider] == nil {
+			svc.gpuPricing[provider] = make(map[string]float64)
+		}
+		for gpuType, price := range rates {
+			if gpuType == "default" {
+				if svc.pricingData[provider] == nil {
+					svc.pricingData[provider] = make(map[string]float64)
+				}
+				svc.pricingData[provider]["gpu_per_hour"] = price
+				continue
+			}
+			svc.gpuPricing[provider][gpuType] = price
+		}
 	}
 
 	return svc, nil
 }
 
+// Stop ends the service's background work. Rightsizing watches still
+// running are abandoned, not rolled back.
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
 // initializePricingData initializes default pricing data
 func initializePricingData() map[string]map[string]float64 {
 	return map[string]map[string]float64{
@@ -378,28 +669,83 @@ func initializePricingData() map[string]map[string]float64 {
 			"memory_gb_hour":   0.00446,
 			"storage_gb_month": 0.10,
 			"network_gb":       0.09,
+			"gpu_per_hour":     0.526, // g4dn (T4) per GPU
 		},
 		"gcp": {
 			"cpu_per_hour":     0.0310,
 			"memory_gb_hour":   0.00415,
 			"storage_gb_month": 0.08,
 			"network_gb":       0.08,
+			"gpu_per_hour":     0.35,
 		},
 		"azure": {
 			"cpu_per_hour":     0.0340,
 			"memory_gb_hour":   0.00450,
 			"storage_gb_month": 0.10,
 			"network_gb":       0.087,
+			"gpu_per_hour":     0.526,
 		},
 		"on-prem": {
 			"cpu_per_hour":     0.025,
 			"memory_gb_hour":   0.003,
 			"storage_gb_month": 0.05,
 			"network_gb":       0.01,
+			"gpu_per_hour":     0.20,
 		},
 	}
 }
 
+// initializeGPUPricing returns on-demand per-GPU hourly rates by GPU type.
+// Types not listed fall back to the provider's gpu_per_hour.
+func initializeGPUPricing() map[string]map[string]float64 {
+	return map[string]map[string]float64{
+		"aws": {
+			"nvidia-t4":   0.526,
+			"nvidia-a10g": 1.006,
+			"nvidia-v100": 3.06,
+			"nvidia-a100": 4.10,
+			"nvidia-h100": 12.29,
+		},
+		"gcp": {
+			"nvidia-t4":   0.35,
+			"nvidia-l4":   0.71,
+			"nvidia-v100": 2.48,
+			"nvidia-a100": 2.93,
+			"nvidia-h100": 11.06,
+		},
+		"azure": {
+			"nvidia-t4":   0.526,
+			"nvidia-v100": 3.06,
+			"nvidia-a100": 3.67,
+			"nvidia-h100": 12.29,
+		},
+		"on-prem": {
+			"nvidia-t4":   0.20,
+			"nvidia-a100": 1.50,
+		},
+	}
+}
+
+// gpuRate returns the hourly price of one GPU of gpuType for a provider
+func (s *Service) gpuRate(provider, gpuType string) float64 {
+	if rate, ok := s.gpuPricing[provider][gpuType]; ok {
+		return rate
+	}
+	return s.pricingData[provider]["gpu_per_hour"]
+}
+
+// normalizeGPUType maps a node's GPU product label (e.g.
+// "NVIDIA-A100-SXM4-40GB" or "Tesla-T4") onto the pricing table's type names
+func normalizeGPUType(product string) string {
+	p := strings.ToLower(product)
+	for _, model := range []string{"a100", "h100", "a10g", "v100", "t4", "l4"} {
+		if strings.Contains(p, model) {
+			return "nvidia-" + model
+		}
+	}
+	return p
+}
+
 // GetCostAllocation retrieves cost allocation data
 func (s *Service) GetCostAllocation(ctx context.Context, filter CostAllocationFilter) ([]CostAllocation, error) {
 	var allocations []CostAllocation
@@ -441,40 +787,48 @@ type CostAllocationFilter struct {
 
 // CalculateCost calculates cost for given resource usage
 func (s *Service) CalculateCost(ctx context.Context, usage ResourceUsage) (*CostResult, error) {
-	provider := s.config.CloudProvider
+	provider, region := s.overrides.CostProvider(ctx, usage.ClusterID, s.config.CloudProvider, s.config.AWSRegion)
 	if provider == "" {
 		provider = "aws"
 	}
 
 	pricing, ok := s.pricingData[provider]
 	if !ok {
-		pricing = s.pricingData["aws"]
+		provider = "aws"
+		pricing = s.pricingData[provider]
 	}
 
 	cpuCost := usage.CPUCoreHours * pricing["cpu_per_hour"]
 	memoryCost := usage.MemoryGBHours * pricing["memory_gb_hour"]
 	storageCost := usage.StorageGB * pricing["storage_gb_month"] / 720 * usage.Hours // Convert monthly to hourly
 	networkCost := usage.NetworkGB * pricing["network_gb"]
+	gpuCost := usage.GPUHours * s.gpuRate(provider, usage.GPUType)
 
-	totalCost := cpuCost + memoryCost + storageCost + networkCost
+	totalCost := cpuCost + memoryCost + storageCost + networkCost + gpuCost
 
 	return &CostResult{
 		CPUCost:     cpuCost,
 		MemoryCost:  memoryCost,
 		StorageCost: storageCost,
 		NetworkCost: networkCost,
+		GPUCost:     gpuCost,
 		TotalCost:   totalCost,
 		Currency:    s.config.DefaultCurrency,
+		Provider:    provider,
+		Region:      region,
 	}, nil
 }
 
 // ResourceUsage represents resource usage for cost calculation
 type ResourceUsage struct {
+	ClusterID      string // prices with the cluster's overrides; empty uses the global provider
 	CPUCoreHours   float64
 	MemoryGBHours  float64
 	StorageGB      float64
 	NetworkGB      float64
 	Hours          float64
+	GPUHours       float64 // GPU-hours allocated, one per GPU per hour
+	GPUType        string  // e.g. nvidia-a100; empty uses the provider's gpu_per_hour
 }
 
 // CostResult represents cost calculation result
@@ -483,12 +837,24 @@ type CostResult struct {
 	MemoryCost  float64 `json:"memory_cost"`
 	StorageCost float64 `json:"storage_cost"`
 	NetworkCost float64 `json:"network_cost"`
+	GPUCost     float64 `json:"gpu_cost"`
 	TotalCost   float64 `json:"total_cost"`
 	Currency    string  `json:"currency"`
+	// Provider and Region are what the usage was priced with
+	Provider string `json:"provider"`
+	Region   string `json:"region,omitempty"`
 }
 
-// GenerateReport generates a cost report
+// GenerateReport generates a cost report. With caching enabled, repeating a
+// request returns the report it generated until the cache entry expires or
+// allocations for the period are ingested; req.Force generates a new one.
 func (s *Service) GenerateReport(ctx context.Context, req ReportRequest) (*CostReport, error) {
+	key := reportCacheKey(req)
+	var cached CostReport
+	if !req.Force && s.cachedResult(ctx, key, &cached) {
+		return &cached, nil
+	}
+
 	// Get cost allocations for the period
 	filter := CostAllocationFilter{
 		ClusterID: req.ClusterID,
@@ -517,7 +883,7 @@ func (s *Service) GenerateReport(ctx context.Context, req ReportRequest) (*CostR
 	breakdown := s.generateBreakdown(allocations, req.Grouping)
 
 	// Generate recommendations
-	recommendations := s.generateRecommendations(allocations)
+	recommendations := s.generateRecommendations(ctx, allocations)
 
 	// Calculate trends
 	trends, err := s.calculateTrends(ctx, req)
@@ -541,14 +907,23 @@ func (s *Service) GenerateReport(ctx context.Context, req ReportRequest) (*CostR
 		Breakdown:       breakdown,
 		Trends:          trends,
 		Recommendations: recommendations,
+		Currency:        s.config.DefaultCurrency,
 		GeneratedAt:     time.Now(),
 		CreatedBy:       req.UserID,
 	}
 
+	if err := s.ConvertReport(ctx, report, req.Currency); err != nil {
+		return nil, err
+	}
+
 	// Save report
 	if err := s.db.Create(report).Error; err != nil {
 		return nil, fmt.Errorf("failed to save report: %w", err)
 	}
+	if len(recommendations) > 0 {
+		s.invalidateSummaries(ctx)
+	}
+	s.cacheResult(ctx, key, report, req.StartTime, req.EndTime)
 
 	return report, nil
 }
@@ -564,63 +939,85 @@ type ReportRequest struct {
 	StartTime time.Time
 	EndTime   time.Time
 	UserID    string
+	Currency  string // report currency; defaults to Config.DefaultCurrency
+	// Force generates the report even if an identical request's is cached
+	Force bool `json:"-"`
 }
 
+// generateBreakdown groups allocations by each dimension in grouping in
+// turn. With more than one dimension the result is a hierarchy flattened
+// depth-first: every group is followed by its subgroups, which point at it
+// through ParentID. Percentages are shares of the overall total.
 func (s *Service) generateBreakdown(allocations []CostAllocation, grouping []string) []CostBreakdown {
-	var breakdowns []CostBreakdown
-
 	if len(grouping) == 0 {
 		grouping = []string{"namespace"}
 	}
 
-	// Group by first grouping dimension
-	groups := make(map[string]float64)
+	var total float64
 	for _, alloc := range allocations {
-		var key string
-		switch grouping[0] {
-		case "namespace":
-			key = alloc.Namespace
-		case "cluster":
-			key = alloc.ClusterName
-		case "workload":
-			key = alloc.WorkloadName
-		default:
-			key = "unknown"
-		}
-		groups[key] += alloc.TotalCost
+		total += alloc.TotalCost
 	}
 
-	// Calculate total for percentages
-	var total float64
-	for _, cost := range groups {
-		total += cost
+	return appendBreakdown(nil, allocations, grouping, 0, "", "", total)
+}
+
+func appendBreakdown(breakdowns []CostBreakdown, allocations []CostAllocation, grouping []string, level int, parentID, parentName string, total float64) []CostBreakdown {
+	dimension := grouping[level]
+
+	type group struct {
+		name        string
+		cost        float64
+		allocations []CostAllocation
+	}
+	index := make(map[string]*group)
+	var groups []*group
+	for _, alloc := range allocations {
+		key := groupKey(&alloc, dimension)
+		g, ok := index[key]
+		if !ok {
+			g = &group{name: key}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.cost += alloc.TotalCost
+		g.allocations = append(g.allocations, alloc)
 	}
 
-	// Create breakdown entries
-	for name, cost := range groups {
+	// Sort by cost descending
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].cost > groups[j].cost
+	})
+
+	for _, g := range groups {
 		percentage := 0.0
 		if total > 0 {
-			percentage = (cost / total) * 100
+			percentage = (g.cost / total) * 100
 		}
 
-		breakdowns = append(breakdowns, CostBreakdown{
+		name := g.name
+		if parentName != "" {
+			name = parentName + " / " + g.name
+		}
+		entry := CostBreakdown{
 			ID:         uuid.New().String(),
-			Category:   grouping[0],
+			Category:   strings.TrimPrefix(dimension, labelGroupPrefix),
 			Name:       name,
-			Cost:       cost,
+			Cost:       g.cost,
 			Percentage: percentage,
-		})
-	}
+			ParentID:   parentID,
+			Level:      level,
+		}
+		breakdowns = append(breakdowns, entry)
 
-	// Sort by cost descending
-	sort.Slice(breakdowns, func(i, j int) bool {
-		return breakdowns[i].Cost > breakdowns[j].Cost
-	})
+		if level+1 < len(grouping) {
+			breakdowns = appendBreakdown(breakdowns, g.allocations, grouping, level+1, entry.ID, name, total)
+		}
+	}
 
 	return breakdowns
 }
 
-func (s *Service) generateRecommendations(allocations []CostAllocation) []CostRecommendation {
+func (s *Service) generateRecommendations(ctx context.Context, allocations []CostAllocation) []CostRecommendation {
 	var recommendations []CostRecommendation
 
 	// Identify idle resources (efficiency < 10%)
@@ -672,6 +1069,10 @@ func (s *Service) generateRecommendations(allocations []CostAllocation) []CostRe
 		}
 	}
 
+	// Identify purchasing options for spot and steady baseline usage
+	recommendations = append(recommendations, s.spotRecommendations(ctx, allocations)...)
+	recommendations = append(recommendations, s.reservedRecommendations(allocations)...)
+
 	return recommendations
 }
 
@@ -718,6 +1119,10 @@ func (s *Service) CreateBudget(ctx context.Context, budget *Budget) error {
 	if budget.AlertThresholds == nil {
 		budget.AlertThresholds = []float64{50, 75, 90, 100}
 	}
+	if budget.Currency == "" {
+		budget.Currency = s.config.DefaultCurrency
+	}
+	budget.Currency = strings.ToUpper(budget.Currency)
 
 	if err := s.db.Create(budget).Error; err != nil {
 		return fmt.Errorf("failed to create budget: %w", err)
@@ -734,7 +1139,11 @@ func (s *Service) GetBudget(ctx context.Context, budgetID string) (*Budget, erro
 	}
 
 	// Update current spend
-	budget.CurrentSpend = s.calculateCurrentSpend(ctx, &budget)
+	spend, err := s.calculateCurrentSpend(ctx, &budget)
+	if err != nil {
+		return nil, err
+	}
+	budget.CurrentSpend = spend
 	budget.Status = s.determineBudgetStatus(&budget)
 
 	return &budget, nil
@@ -749,14 +1158,24 @@ func (s *Service) ListBudgets(ctx context.Context) ([]Budget, error) {
 
 	// Update current spend for each budget
 	for i := range budgets {
-		budgets[i].CurrentSpend = s.calculateCurrentSpend(ctx, &budgets[i])
+		spend, err := s.calculateCurrentSpend(ctx, &budgets[i])
+		if err != nil {
+			s.logger.Warn("Failed to calculate budget spend",
+				zap.String("budget_id", budgets[i].ID),
+				zap.Error(err),
+			)
+			budgets[i].Status = "unknown"
+			continue
+		}
+		budgets[i].CurrentSpend = spend
 		budgets[i].Status = s.determineBudgetStatus(&budgets[i])
 	}
 
 	return budgets, nil
 }
 
-func (s *Service) calculateCurrentSpend(ctx context.Context, budget *Budget) float64 {
+// calculateCurrentSpend returns the budget's spend in the budget's currency
+func (s *Service) calculateCurrentSpend(ctx context.Context, budget *Budget) (float64, error) {
 	var totalCost float64
 	query := s.db.Model(&CostAllocation{}).
 		Where("period_start >= ? AND period_end <= ?", budget.PeriodStart, budget.PeriodEnd)
@@ -768,7 +1187,12 @@ func (s *Service) calculateCurrentSpend(ctx context.Context, budget *Budget) flo
 	}
 
 	query.Select("COALESCE(SUM(total_cost), 0)").Scan(&totalCost)
-	return totalCost
+
+	spend, _, _, err := s.convert(ctx, totalCost, s.config.DefaultCurrency, budget.Currency)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert spend to %s: %w", budget.Currency, err)
+	}
+	return spend, nil
 }
 
 func (s *Service) determineBudgetStatus(budget *Budget) string {
@@ -782,52 +1206,65 @@ func (s *Service) determineBudgetStatus(budget *Budget) string {
 	return "on_track"
 }
 
-// CheckBudgetAlerts checks and creates alerts for budgets
+// CheckBudgetAlerts checks and creates alerts for budgets. Besides the
+// spend thresholds it forecasts each open budget to the end of its period and
+// raises a "forecast" alert if spend is projected to exceed the amount. Each
+// alert is raised once per budget period; DispatchBudgetAlerts sends them.
 func (s *Service) CheckBudgetAlerts(ctx context.Context) error {
 	budgets, err := s.ListBudgets(ctx)
 	if err != nil {
 		return err
 	}
 
-	for _, budget := range budgets {
+	for i := range budgets {
+		budget := &budgets[i]
+		if budget.Status == "unknown" {
+			continue
+		}
 		percentage := (budget.CurrentSpend / budget.Amount) * 100
 
 		for _, threshold := range budget.AlertThresholds {
-			if percentage >= threshold {
-				// Check if alert already exists
-				var existingAlert BudgetAlert
-				err := s.db.Where("budget_id = ? AND threshold = ?", budget.ID, threshold).First(&existingAlert).Error
-				if err == gorm.ErrRecordNotFound {
-					// Create new alert
-					severity := "warning"
-					if threshold >= 100 {
-						severity = "critical"
-					}
-
-					alert := &BudgetAlert{
-						ID:           uuid.New().String(),
-						BudgetID:     budget.ID,
-						Threshold:    threshold,
-						CurrentSpend: budget.CurrentSpend,
-						Message:      fmt.Sprintf("Budget %s has reached %.0f%% (%.2f of %.2f %s)", budget.Name, percentage, budget.CurrentSpend, budget.Amount, budget.Currency),
-						Severity:     severity,
-						Notified:     false,
-						CreatedAt:    time.Now(),
-					}
-
-					if err := s.db.Create(alert).Error; err != nil {
-						s.logger.Error("Failed to create budget alert", zap.Error(err))
-					}
-				}
+			if percentage < threshold {
+				continue
+			}
+			severity := "warning"
+			if threshold >= 100 {
+				severity = "critical"
 			}
+			s.raiseBudgetAlert(budget, budgetAlertThreshold, threshold, severity,
+				fmt.Sprintf("Budget %s has reached %.0f%% (%.2f of %.2f %s)", budget.Name, percentage, budget.CurrentSpend, budget.Amount, budget.Currency))
 		}
+
+		s.checkBudgetForecast(ctx, budget)
 	}
 
 	return nil
 }
 
-// GenerateForecast generates a cost forecast
-func (s *Service) GenerateForecast(ctx context.Context, scope, scopeValue string, days int) (*CostForecast, error) {
+// GenerateForecast generates a cost forecast and saves it. model is
+// "linear" (default) or "seasonal"; the seasonal model needs two full weeks
+// of history and falls back to linear when there is less.
+func (s *Service) GenerateForecast(ctx context.Context, scope, scopeValue string, days int, model string) (*CostForecast, error) {
+	forecast, err := s.computeForecast(ctx, scope, scopeValue, days, model)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(forecast).Error; err != nil {
+		return nil, fmt.Errorf("failed to save forecast: %w", err)
+	}
+	return forecast, nil
+}
+
+// computeForecast forecasts the cost of scope over the next days without
+// saving the forecast
+func (s *Service) computeForecast(ctx context.Context, scope, scopeValue string, days int, model string) (*CostForecast, error) {
+	if model == "" {
+		model = forecastModelLinear
+	}
+	if model != forecastModelLinear && model != forecastModelSeasonal {
+		return nil, fmt.Errorf("unsupported forecast model: %s", model)
+	}
+
 	// Get historical cost data
 	endTime := time.Now()
 	startTime := endTime.AddDate(0, 0, -90) // Use 90 days of history
@@ -849,42 +1286,38 @@ func (s *Service) GenerateForecast(ctx context.Context, scope, scopeValue string
 		return nil, err
 	}
 
-	// Calculate daily costs
-	dailyCosts := make(map[string]float64)
-	for _, alloc := range allocations {
-		day := alloc.PeriodStart.Format("2006-01-02")
-		dailyCosts[day] += alloc.TotalCost
-	}
-
-	// Simple linear regression for forecasting
-	var costs []float64
-	for _, cost := range dailyCosts {
-		costs = append(costs, cost)
-	}
-
+	costs := dailyCostSeries(allocations)
 	if len(costs) < 7 {
 		return nil, fmt.Errorf("insufficient data for forecasting")
 	}
 
-	// Calculate trend
-	avgCost := average(costs)
-	trend := s.calculateTrendSlope(costs)
+	var fit *forecastFit
+	var fallback string
+	switch {
+	case model == forecastModelSeasonal && len(costs) >= 2*weeklySeason:
+		fit = fitHoltWinters(costs, weeklySeason)
+	case model == forecastModelSeasonal:
+		fallback = fmt.Sprintf("seasonal model needs %d days of history, have %d", 2*weeklySeason, len(costs))
+		s.logger.Warn("Falling back to linear forecast",
+			zap.String("scope", scope),
+			zap.String("scope_value", scopeValue),
+			zap.String("reason", fallback),
+		)
+		fit = fitLinear(costs)
+	default:
+		fit = fitLinear(costs)
+	}
 
 	// Generate predictions
 	var predictions []ForecastPrediction
 	for i := 1; i <= days; i++ {
-		date := endTime.AddDate(0, 0, i)
-		predicted := avgCost + trend*float64(i)
-		if predicted < 0 {
-			predicted = 0
-		}
-
-		// Add confidence interval (±20%)
+		predicted := math.Max(fit.predict(i), 0)
+		lower, upper := fit.interval(predicted, i)
 		predictions = append(predictions, ForecastPrediction{
-			Date:       date,
-			LowerBound: predicted * 0.8,
+			Date:       endTime.AddDate(0, 0, i),
+			LowerBound: lower,
 			Predicted:  predicted,
-			UpperBound: predicted * 1.2,
+			UpperBound: upper,
 		})
 	}
 
@@ -894,6 +1327,15 @@ func (s *Service) GenerateForecast(ctx context.Context, scope, scopeValue string
 		totalForecast += p.Predicted
 	}
 
+	params := fit.params
+	params["residual_std_dev"] = fit.residualStdDev
+	params["mape"] = fit.mape
+	params["history_days"] = len(costs)
+	if fallback != "" {
+		params["requested_model"] = model
+		params["fallback_reason"] = fallback
+	}
+
 	forecast := &CostForecast{
 		ID:           uuid.New().String(),
 		Scope:        scope,
@@ -901,41 +1343,46 @@ func (s *Service) GenerateForecast(ctx context.Context, scope, scopeValue string
 		ForecastDate: endTime.AddDate(0, 0, days),
 		CurrentCost:  sum(costs),
 		ForecastCost: totalForecast,
-		Confidence:   0.75, // 75% confidence for simple linear model
-		Model:        "linear",
+		Confidence:   forecastConfidence,
+		Model:        fit.model,
+		Parameters:   params,
 		Predictions:  predictions,
 		CreatedAt:    time.Now(),
 	}
-
-	// Save forecast
-	if err := s.db.Create(forecast).Error; err != nil {
-		return nil, fmt.Errorf("failed to save forecast: %w", err)
-	}
-
 	return forecast, nil
 }
 
-func (s *Service) calculateTrendSlope(costs []float64) float64 {
-	n := float64(len(costs))
-	if n < 2 {
-		return 0
+// dailyCostSeries sums allocations per UTC day, in date order, with days
+// that have no allocations between the first and last counted as zero
+func dailyCostSeries(allocations []CostAllocation) []float64 {
+	if len(allocations) == 0 {
+		return nil
 	}
 
-	var sumX, sumY, sumXY, sumX2 float64
-	for i, cost := range costs {
-		x := float64(i)
-		sumX += x
-		sumY += cost
-		sumXY += x * cost
-		sumX2 += x * x
+	daily := make(map[time.Time]float64)
+	first, last := utcDay(allocations[0].PeriodStart), utcDay(allocations[0].PeriodStart)
+	for _, alloc := range allocations {
+		day := utcDay(alloc.PeriodStart)
+		daily[day] += alloc.TotalCost
+		if day.Before(first) {
+			first = day
+		}
+		if day.After(last) {
+			last = day
+		}
 	}
 
-	denominator := n*sumX2 - sumX*sumX
-	if denominator == 0 {
-		return 0
+	var costs []float64
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		costs = append(costs, daily[day])
 	}
+	return costs
+}
 
-	return (n*sumXY - sumX*sumY) / denominator
+// utcDay truncates t to midnight of its UTC day
+func utcDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 }
 
 // GenerateRightsizingRecommendations generates rightsizing recommendations
@@ -956,6 +1403,18 @@ func (s *Service) GenerateRightsizingRecommendations(ctx context.Context, cluste
 	}
 
 	for _, alloc := range allocations {
+		// Allocated but idle GPUs are the most expensive waste, so they are
+		// flagged regardless of CPU/memory efficiency
+		if rec, ok := idleGPURecommendation(clusterID, &alloc); ok {
+			s.snapshotWorkload(ctx, &rec)
+			recommendations = append(recommendations, rec)
+			if err := s.db.Create(&rec).Error; err != nil {
+				s.logger.Warn("Failed to save rightsizing recommendation", zap.Error(err))
+			} else {
+				s.remediate(ctx, &rec)
+			}
+		}
+
 		// Skip if efficiency is already good
 		if alloc.Efficiency > 60 {
 			continue
@@ -985,16 +1444,23 @@ func (s *Service) GenerateRightsizingRecommendations(ctx context.Context, cluste
 				Status:                "pending",
 				CreatedAt:             time.Now(),
 			}
+			s.snapshotWorkload(ctx, &rec)
 
 			recommendations = append(recommendations, rec)
 
 			// Save recommendation
 			if err := s.db.Create(&rec).Error; err != nil {
 				s.logger.Warn("Failed to save rightsizing recommendation", zap.Error(err))
+			} else {
+				s.remediate(ctx, &rec)
 			}
 		}
 	}
 
+	if len(recommendations) > 0 {
+		s.invalidateSummaries(ctx)
+	}
+
 	// Sort by savings
 	sort.Slice(recommendations, func(i, j int) bool {
 		return recommendations[i].MonthlySavings > recommendations[j].MonthlySavings
@@ -1003,12 +1469,56 @@ func (s *Service) GenerateRightsizingRecommendations(ctx context.Context, cluste
 	return recommendations, nil
 }
 
-// GetCostSummary returns a cost summary dashboard
-func (s *Service) GetCostSummary(ctx context.Context) (*CostSummary, error) {
+// idleGPURecommendation recommends releasing GPUs that were allocated but
+// measured near-zero utilization over the allocation period
+func idleGPURecommendation(clusterID string, alloc *CostAllocation) (RightsizingRecommendation, bool) {
+	if alloc.GPUHours <= 0 || alloc.GPUCost <= 0 || alloc.GPUUtilization == nil || *alloc.GPUUtilization >= idleGPUUtilization {
+		return RightsizingRecommendation{}, false
+	}
+
+	hours := alloc.PeriodEnd.Sub(alloc.PeriodStart).Hours()
+	if hours <= 0 {
+		hours = 1
+	}
+
+	return RightsizingRecommendation{
+		ID:             uuid.New().String(),
+		ClusterID:      clusterID,
+		Namespace:      alloc.Namespace,
+		WorkloadType:   alloc.WorkloadType,
+		WorkloadName:   alloc.WorkloadName,
+		ContainerName:  alloc.ContainerName,
+		GPUType:        alloc.GPUType,
+		CurrentGPUs:    alloc.GPUHours / hours,
+		GPUUtilization: *alloc.GPUUtilization,
+		Reason:         "idle_gpu",
+		MonthlySavings: alloc.GPUCost / hours * 720,
+		Confidence:     0.95,
+		Status:         "pending",
+		CreatedAt:      time.Now(),
+	}, true
+}
+
+// GetCostSummary returns a cost summary dashboard. With caching enabled it
+// is served from the cache until the entry expires, allocations for the
+// current or previous month are ingested or recommendations are generated
+// or applied; force recomputes it.
+func (s *Service) GetCostSummary(ctx context.Context, currency string, force bool) (*CostSummary, error) {
 	now := time.Now()
 	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
 	startOfPrevMonth := startOfMonth.AddDate(0, -1, 0)
 
+	if currency == "" {
+		currency = s.config.DefaultCurrency
+	}
+	currency = strings.ToUpper(currency)
+
+	key := summaryCacheKey(startOfMonth, currency)
+	var cached CostSummary
+	if !force && s.cachedResult(ctx, key, &cached) {
+		return &cached, nil
+	}
+
 	// Current month cost
 	var currentMonthCost float64
 	s.db.Model(&CostAllocation{}).
@@ -1046,28 +1556,61 @@ func (s *Service) GetCostSummary(ctx context.Context) (*CostSummary, error) {
 		changePercent = ((currentMonthCost - prevMonthCost) / prevMonthCost) * 100
 	}
 
+	// Freshness of externally synced data, if Kubecost is in use
+	var lastSync *KubecostSyncStatus
+	if s.config.KubecostEndpoint != "" {
+		lastSync, _ = s.GetKubecostSyncStatus(ctx)
+	}
+
+	// Convert amounts (the change percentage is currency-independent)
+	var rate float64
+	var rateAt time.Time
+	for _, amount := range []*float64{&currentMonthCost, &prevMonthCost, &potentialSavings} {
+		converted, r, at, err := s.convert(ctx, *amount, s.config.DefaultCurrency, currency)
+		if err != nil {
+			return nil, err
+		}
+		*amount, rate, rateAt = converted, r, at
+	}
+	for i := range topNamespaces {
+		converted, _, _, err := s.convert(ctx, topNamespaces[i].Cost, s.config.DefaultCurrency, currency)
+		if err != nil {
+			return nil, err
+		}
+		topNamespaces[i].Cost = converted
+	}
+
 	summary := &CostSummary{
 		CurrentMonthCost:  currentMonthCost,
 		PreviousMonthCost: prevMonthCost,
 		ChangePercent:     changePercent,
 		PotentialSavings:  potentialSavings,
 		TopNamespaces:     topNamespaces,
-		Currency:          s.config.DefaultCurrency,
+		Currency:          currency,
 		GeneratedAt:       now,
+		LastKubecostSync:  lastSync,
+	}
+	if rate != 1 {
+		summary.ExchangeRate = rate
+		summary.ExchangeRateAt = &rateAt
 	}
+	s.cacheResult(ctx, key, summary, startOfPrevMonth, now, summaryTagKey())
 
 	return summary, nil
 }
 
 // CostSummary represents a cost dashboard summary
 type CostSummary struct {
-	CurrentMonthCost  float64         `json:"current_month_cost"`
-	PreviousMonthCost float64         `json:"previous_month_cost"`
-	ChangePercent     float64         `json:"change_percent"`
-	PotentialSavings  float64         `json:"potential_savings"`
-	TopNamespaces     []CostBreakdown `json:"top_namespaces"`
-	Currency          string          `json:"currency"`
-	GeneratedAt       time.Time       `json:"generated_at"`
+	CurrentMonthCost  float64             `json:"current_month_cost"`
+	PreviousMonthCost float64             `json:"previous_month_cost"`
+	ChangePercent     float64             `json:"change_percent"`
+	PotentialSavings  float64             `json:"potential_savings"`
+	TopNamespaces     []CostBreakdown     `json:"top_namespaces"`
+	Currency          string              `json:"currency"`
+	GeneratedAt       time.Time           `json:"generated_at"`
+	LastKubecostSync  *KubecostSyncStatus `json:"last_kubecost_sync,omitempty"`
+	ExchangeRate      float64             `json:"exchange_rate,omitempty"` // from DefaultCurrency
+	ExchangeRateAt    *time.Time          `json:"exchange_rate_at,omitempty"`
 }
 
 // ExportReport exports a cost report in various formats
@@ -1082,27 +1625,15 @@ func (s *Service) ExportReport(ctx context.Context, reportID, format string) ([]
 		return json.MarshalIndent(report, "", "  ")
 	case "csv":
 		return s.exportToCSV(&report)
+	case "xlsx":
+		return s.exportToXLSX(&report)
+	case "pdf":
+		return s.exportToPDF(&report)
 	default:
 		return nil, fmt.Errorf("unsupported format: %s", format)
 	}
 }
 
-func (s *Service) exportToCSV(report *CostReport) ([]byte, error) {
-	var sb strings.Builder
-
-	// Header
-	sb.WriteString("Category,Name,Cost,Percentage\n")
-
-	// Data
-	for _, b := range report.Breakdown {
-		sb.WriteString(fmt.Sprintf("%s,%s,%.2f,%.2f%%\n", b.Category, b.Name, b.Cost, b.Percentage))
-	}
-
-	sb.WriteString(fmt.Sprintf("\nTotal,,%.2f,100%%\n", report.TotalCost))
-
-	return []byte(sb.String()), nil
-}
-
 // Helper functions
 func average(values []float64) float64 {
 	if len(values) == 0 {
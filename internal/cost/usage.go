@@ -0,0 +1,42 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PeakNamespaceUsage returns the most CPU cores and memory GiB the
+// namespace on cluster used at once since the given time. The allocations
+// of one period are summed across the namespace's workloads, then averaged
+// over the period's hours.
+func (s *Service) PeakNamespaceUsage(ctx context.Context, cluster, namespace string, since time.Time) (float64, float64, error) {
+	var allocations []CostAllocation
+	err := s.db.WithContext(ctx).
+		Select("cpu_core_hours", "memory_gb_hours", "period_start", "period_end").
+		Where("cluster_id = ? AND namespace = ? AND period_start >= ?", cluster, namespace, since).
+		Find(&allocations).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get namespace usage: %w", err)
+	}
+
+	type usage struct{ cpu, memory float64 }
+	periods := map[int64]usage{}
+	for _, a := range allocations {
+		hours := a.PeriodEnd.Sub(a.PeriodStart).Hours()
+		if hours <= 0 {
+			hours = 1
+		}
+		u := periods[a.PeriodStart.Unix()]
+		u.cpu += a.CPUCoreHours / hours
+		u.memory += a.MemoryGBHours / hours
+		periods[a.PeriodStart.Unix()] = u
+	}
+
+	var cpuCores, memoryGiB float64
+	for _, u := range periods {
+		cpuCores = max(cpuCores, u.cpu)
+		memoryGiB = max(memoryGiB, u.memory)
+	}
+	return cpuCores, memoryGiB, nil
+}
@@ -0,0 +1,95 @@
+package cost
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func requestingPod(namespace, name, cpu, memory string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "app",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			}},
+		}}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestIngestUsageAllocatesPerNamespace(t *testing.T) {
+	svc := newBudgetTestService(t)
+	manager, err := kube.NewClientManager(&config.KubernetesConfig{})
+	require.NoError(t, err)
+	manager.AddClient(&kube.ClusterClient{Name: "prod", Clientset: fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "checkout"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch"}},
+		requestingPod("payments", "api-1", "500m", "1Gi"),
+		requestingPod("payments", "api-2", "500m", "1Gi"),
+		requestingPod("batch", "job", "2", "4Gi"),
+	)})
+	svc.SetKubeManager(manager)
+
+	svc.IngestUsage(context.Background())
+
+	var allocs []CostAllocation
+	require.NoError(t, svc.db.Order("namespace").Find(&allocs).Error)
+	require.Len(t, allocs, 2)
+	assert.Equal(t, "batch", allocs[0].Namespace)
+	assert.Empty(t, allocs[0].Labels)
+	assert.InDelta(t, 2.0, allocs[0].CPUCoreHours, 1e-9)
+	assert.Equal(t, "payments", allocs[1].Namespace)
+	assert.Equal(t, map[string]string{"team": "checkout"}, allocs[1].Labels)
+	assert.InDelta(t, 1.0, allocs[1].CPUCoreHours, 1e-9)
+	assert.InDelta(t, 2.0, allocs[1].MemoryGBHours, 1e-9)
+	assert.Positive(t, allocs[1].TotalCost)
+}
+
+func TestPeakNamespaceUsage(t *testing.T) {
+	svc := newBudgetTestService(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Hour)
+
+	alloc := func(namespace string, start time.Time, hours, cpuCoreHours, memoryGBHours float64) {
+		require.NoError(t, svc.db.Create(&CostAllocation{
+			ID:            uuid.NewString(),
+			ClusterID:     "prod",
+			Namespace:     namespace,
+			CPUCoreHours:  cpuCoreHours,
+			MemoryGBHours: memoryGBHours,
+			PeriodStart:   start,
+			PeriodEnd:     start.Add(time.Duration(hours * float64(time.Hour))),
+		}).Error)
+	}
+	// Two workloads in the busiest hour add up
+	alloc("shop", now.Add(-2*time.Hour), 1, 1.5, 2)
+	alloc("shop", now.Add(-2*time.Hour), 1, 0.5, 2)
+	// A day-long allocation is averaged over its hours
+	alloc("shop", now.Add(-30*time.Hour), 24, 48, 24)
+	// Other namespaces and old periods don't count
+	alloc("batch", now.Add(-time.Hour), 1, 10, 10)
+	alloc("shop", now.Add(-30*24*time.Hour), 1, 10, 10)
+
+	cpu, memory, err := svc.PeakNamespaceUsage(ctx, "prod", "shop", now.Add(-7*24*time.Hour))
+	require.NoError(t, err)
+	assert.InDelta(t, 2.0, cpu, 1e-9)
+	assert.InDelta(t, 4.0, memory, 1e-9)
+
+	cpu, memory, err = svc.PeakNamespaceUsage(ctx, "prod", "empty", now.Add(-7*24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, cpu)
+	assert.Zero(t, memory)
+}
@@ -0,0 +1,742 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	defaultReconcileInterval    = 3 * time.Minute
+	defaultReconcileConcurrency = 5
+	// reconcileTimeout bounds one application's reconcile so a slow
+	// repository or cluster can't hold a worker for the whole interval
+	reconcileTimeout = 2 * time.Minute
+	// syncLockTTL is how long an application's sync lock lasts without
+	// renewal; it is renewed while the sync runs
+	syncLockTTL = 30 * time.Second
+)
+
+// Application sync statuses
+const (
+	SyncStatusSynced    = "Synced"
+	SyncStatusOutOfSync = "OutOfSync"
+	SyncStatusUnknown   = "Unknown"
+)
+
+// Resource statuses reported by syncs
+const (
+	ResourcePruned       = "Pruned"
+	ResourcePruneSkipped = "PruneSkipped"
+	ResourceSyncFailed   = "SyncFailed"
+)
+
+// Application events, published as krustron.deployment.<app id>.<type>
+const (
+	EventSynced     = "gitops_synced"
+	EventSyncFailed = "gitops_sync_failed"
+	EventDrift      = "gitops_drift"
+)
+
+// Annotations on manifests that steer syncs, as Argo CD reads them
+const (
+	// SyncWaveAnnotation orders applying: lower waves first, wave 0 when
+	// unset; resources are pruned in the reverse order
+	SyncWaveAnnotation = "argocd.argoproj.io/sync-wave"
+	// SyncOptionsAnnotation holds comma separated options; Prune=false
+	// keeps a resource when it leaves the repository
+	SyncOptionsAnnotation = "argocd.argoproj.io/sync-options"
+)
+
+// EventPublisher publishes deployment events; *nats.EventBus implements it
+type EventPublisher interface {
+	EmitDeploymentEvent(ctx context.Context, eventType, deploymentID, environment string, data interface{}) error
+}
+
+// SetEventBus sets where sync and drift events are published
+func (s *Service) SetEventBus(events EventPublisher) { s.events = events }
+
+// ClusterState reads and changes the live state of a cluster
+type ClusterState interface {
+	// Diff returns how applying obj would change the live object, or ""
+	// when it would not
+	Diff(ctx context.Context, clusterID string, obj *unstructured.Unstructured) (string, error)
+	// Apply applies obj, taking ownership of conflicting fields
+	Apply(ctx context.Context, clusterID string, obj *unstructured.Unstructured) error
+	// Delete deletes a resource; one already gone is not an error
+	Delete(ctx context.Context, clusterID string, ref ResourceRef) error
+}
+
+// SetClusterState sets how live state is compared and changed; by default
+// the service's Kubernetes clients are used
+func (s *Service) SetClusterState(state ClusterState) { s.state = state }
+
+// SetLocks makes a replica take an application's Redis lock before
+// reconciling or syncing it, so replicas never sync one application at
+// once. Optional; without it only syncs within a replica are exclusive.
+func (s *Service) SetLocks(c *cache.RedisCache) { s.locks = c }
+
+// SetClusterOverrides sets where per-cluster overrides of whether
+// auto-sync may prune and self-heal are read
+func (s *Service) SetClusterOverrides(store *clusterconfig.Store) { s.overrides = store }
+
+// ResourceRef is a resource an application manages
+type ResourceRef struct {
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Wave       int    `json:"wave,omitempty"`
+	NoPrune    bool   `json:"no_prune,omitempty"`
+}
+
+// key identifies the resource regardless of its API version
+func (r ResourceRef) key() string {
+	group := schema.FromAPIVersionAndKind(r.APIVersion, r.Kind).Group
+	return strings.Join([]string{group, r.Kind, r.Namespace, r.Name}, "/")
+}
+
+// ReconcileConfig configures RunReconciler
+type ReconcileConfig struct {
+	// Interval between reconciles of every application; defaults to three
+	// minutes
+	Interval time.Duration
+	// Concurrency caps how many applications are reconciled at once;
+	// defaults to 5
+	Concurrency int
+}
+
+// desiredResource is a manifest with its place in the sync
+type desiredResource struct {
+	ref ResourceRef
+	obj *unstructured.Unstructured
+}
+
+// comparison is an application's desired state against its live state
+type comparison struct {
+	revision  string
+	desired   []desiredResource
+	outOfSync map[string]string // resource key to its diff
+	managed   []ResourceRef     // resources the last sync left behind
+	prune     []ResourceRef     // managed resources no longer desired
+}
+
+func (c *comparison) synced() bool { return len(c.outOfSync) == 0 && len(c.prune) == 0 }
+
+// RunReconciler reconciles every Git application once immediately and
+// then every interval until ctx is cancelled
+func (s *Service) RunReconciler(ctx context.Context, cfg ReconcileConfig) {
+	s.mu.Lock()
+	s.reconcile = cfg
+	s.mu.Unlock()
+	interval, concurrency := s.reconcileSettings()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.ReconcileAll(ctx, concurrency); err != nil && ctx.Err() == nil {
+			logger.Warn("GitOps reconcile sweep failed", zap.Error(err))
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break wait
+			case <-s.reconcileWake:
+				var next time.Duration
+				next, concurrency = s.reconcileSettings()
+				if next != interval {
+					interval = next
+					ticker.Reset(interval)
+				}
+			}
+		}
+	}
+}
+
+// UpdateReconcileConfig changes a running reconciler's settings. A new
+// interval restarts the wait for the next sweep.
+func (s *Service) UpdateReconcileConfig(cfg ReconcileConfig) {
+	s.mu.Lock()
+	s.reconcile = cfg
+	s.mu.Unlock()
+	select {
+	case s.reconcileWake <- struct{}{}:
+	default:
+	}
+}
+
+// reconcileSettings returns the reconciler's interval and concurrency,
+// defaulted
+func (s *Service) reconcileSettings() (time.Duration, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval, concurrency := s.reconcile.Interval, s.reconcile.Concurrency
+	if interval <= 0 {
+		interval = defaultReconcileInterval
+	}
+	if concurrency <= 0 {
+		concurrency = defaultReconcileConcurrency
+	}
+	return interval, concurrency
+}
+
+// ReconcileAll reconciles every Git application, concurrency at a time.
+// Applications already syncing are skipped.
+func (s *Service) ReconcileAll(ctx context.Context, concurrency int) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM applications WHERE source_type = 'git' ORDER BY name")
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to list applications")
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return errors.DatabaseWrap(err, "failed to scan application")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if concurrency <= 0 {
+		concurrency = defaultReconcileConcurrency
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < min(concurrency, len(ids)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				appCtx, cancel := context.WithTimeout(ctx, reconcileTimeout)
+				if _, err := s.Reconcile(appCtx, id); err != nil && !errors.Is(err, errors.CodeConflict) && ctx.Err() == nil {
+					logger.Warn("Application reconcile failed", zap.String("app_id", id), zap.Error(err))
+				}
+				cancel()
+			}
+		}()
+	}
+
+feed:
+	for _, id := range ids {
+		select {
+		case jobs <- id:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Reconcile compares an application's desired state in Git with its live
+// state and records it as Synced or OutOfSync, publishing a drift event
+// when it goes out of sync. Applications with auto-sync are then synced
+// when Git has a new revision, or on any drift when they self-heal.
+func (s *Service) Reconcile(ctx context.Context, id string) (*SyncStatus, error) {
+	app, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.exclusive(ctx, app, func(ctx context.Context) (*SyncStatus, error) {
+		started := time.Now()
+		cmp, err := s.compare(ctx, app, "")
+		if err != nil {
+			s.setSyncStatus(ctx, app, SyncStatusUnknown, err.Error())
+			return nil, err
+		}
+		if cmp.synced() {
+			s.setSyncStatus(ctx, app, SyncStatusSynced, "")
+			return cmp.status(SyncStatusSynced, "", started), nil
+		}
+
+		if app.SyncStatus != SyncStatusOutOfSync {
+			s.emitEvent(ctx, EventDrift, app, map[string]interface{}{
+				"revision":  cmp.revision,
+				"resources": cmp.status(SyncStatusOutOfSync, "", started).ResourcesOut,
+			})
+		}
+		s.setSyncStatus(ctx, app, SyncStatusOutOfSync, "")
+
+		cfg := s.gitopsConfig()
+		pruneEnabled, selfHealEnabled := s.overrides.GitOpsSyncPolicy(ctx, app.ClusterID, cfg.PruneEnabled, cfg.SelfHealEnabled)
+		newRevision := cmp.revision != app.SyncRevision
+		if !app.AutoSync || !(newRevision || (app.SelfHeal && selfHealEnabled)) {
+			return cmp.status(SyncStatusOutOfSync, "", started), nil
+		}
+		logger.Info("Auto-syncing application",
+			zap.String("app_id", app.ID),
+			zap.String("name", app.Name),
+			zap.String("revision", cmp.revision),
+		)
+		return s.sync(ctx, app, cmp, &SyncRequest{Prune: app.Prune && pruneEnabled}, started)
+	})
+}
+
+// compare reads the desired state at revision and diffs it against the
+// live state and the resources the last sync left behind
+func (s *Service) compare(ctx context.Context, app *Application, revision string) (*comparison, error) {
+	if s.source == nil {
+		return nil, errors.BadRequest("no GitOps source is configured")
+	}
+	if s.state == nil {
+		return nil, errors.BadRequest("no cluster access is configured for GitOps")
+	}
+	desired, err := s.source.Desired(ctx, app, revision)
+	if err != nil {
+		return nil, err
+	}
+	managed, err := s.managedResources(ctx, app.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := &comparison{revision: desired.Revision, outOfSync: map[string]string{}, managed: managed}
+	wanted := map[string]bool{}
+	for _, obj := range desired.Objects {
+		obj = obj.DeepCopy()
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(app.Namespace)
+		}
+		ref, err := resourceRef(obj)
+		if err != nil {
+			return nil, err
+		}
+		cmp.desired = append(cmp.desired, desiredResource{ref: ref, obj: obj})
+		wanted[ref.key()] = true
+
+		diff, err := s.state.Diff(ctx, app.ClusterID, obj)
+		if err != nil {
+			return nil, errors.KubernetesWrap(err, fmt.Sprintf("failed to compare %s %s", ref.Kind, ref.Name))
+		}
+		if diff != "" {
+			cmp.outOfSync[ref.key()] = diff
+		}
+	}
+	for _, ref := range managed {
+		if !wanted[ref.key()] {
+			cmp.prune = append(cmp.prune, ref)
+		}
+	}
+	return cmp, nil
+}
+
+// resourceRef reads a manifest's identity and sync annotations
+func resourceRef(obj *unstructured.Unstructured) (ResourceRef, error) {
+	ref := ResourceRef{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+	annotations := obj.GetAnnotations()
+	if wave, ok := annotations[SyncWaveAnnotation]; ok {
+		n, err := strconv.Atoi(strings.TrimSpace(wave))
+		if err != nil {
+			return ref, errors.Validation(fmt.Sprintf("%s %s has an invalid sync wave %q", ref.Kind, ref.Name, wave))
+		}
+		ref.Wave = n
+	}
+	for _, opt := range strings.Split(annotations[SyncOptionsAnnotation], ",") {
+		if strings.EqualFold(strings.TrimSpace(opt), "Prune=false") {
+			ref.NoPrune = true
+		}
+	}
+	return ref, nil
+}
+
+// status reports the comparison as a sync status
+func (c *comparison) status(status, message string, started time.Time) *SyncStatus {
+	out := &SyncStatus{
+		Status:     status,
+		Message:    message,
+		Revision:   c.revision,
+		StartedAt:  started,
+		FinishedAt: time.Now(),
+	}
+	for _, d := range c.desired {
+		if _, ok := c.outOfSync[d.ref.key()]; ok {
+			out.ResourcesOut = append(out.ResourcesOut, Resource{
+				Kind: d.ref.Kind, Name: d.ref.Name, Namespace: d.ref.Namespace, Status: SyncStatusOutOfSync,
+			})
+		}
+	}
+	for _, ref := range c.prune {
+		out.ResourcesOut = append(out.ResourcesOut, Resource{
+			Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace, Status: SyncStatusOutOfSync,
+			Message: "requires pruning",
+		})
+	}
+	return out
+}
+
+// sync applies the out-of-sync resources wave by wave and, when asked,
+// prunes resources no longer in Git in reverse wave order. What was
+// synced is recorded as the application's managed resources.
+func (s *Service) sync(ctx context.Context, app *Application, cmp *comparison, req *SyncRequest, started time.Time) (*SyncStatus, error) {
+	selected := resourceFilter(req.Resources)
+	managed := map[string]ResourceRef{}
+	for _, ref := range cmp.managed {
+		managed[ref.key()] = ref
+	}
+	var results []Resource
+	pending := 0
+	var syncErr error
+
+	var apply []desiredResource
+	for _, d := range cmp.desired {
+		if _, drifted := cmp.outOfSync[d.ref.key()]; !drifted {
+			managed[d.ref.key()] = d.ref
+			continue
+		}
+		if selected(d.ref) {
+			apply = append(apply, d)
+		} else {
+			pending++
+		}
+	}
+	sort.SliceStable(apply, func(i, j int) bool { return apply[i].ref.Wave < apply[j].ref.Wave })
+	for start := 0; start < len(apply); {
+		end := start
+		for end < len(apply) && apply[end].ref.Wave == apply[start].ref.Wave {
+			end++
+		}
+		wave := apply[start:end]
+		start = end
+		if syncErr != nil {
+			pending += len(wave)
+			continue
+		}
+
+		objects := make([]*unstructured.Unstructured, len(wave))
+		refs := make(map[*unstructured.Unstructured]ResourceRef, len(wave))
+		for i, d := range wave {
+			objects[i] = d.obj
+			refs[d.obj] = d.ref
+		}
+		kube.SortForApply(objects)
+		for _, obj := range objects {
+			ref := refs[obj]
+			if syncErr != nil {
+				pending++
+				continue
+			}
+			if err := s.state.Apply(ctx, app.ClusterID, obj.DeepCopy()); err != nil {
+				pending++
+				results = append(results, Resource{
+					Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace,
+					Status: ResourceSyncFailed, Message: err.Error(),
+				})
+				syncErr = errors.KubernetesWrap(err, fmt.Sprintf("failed to apply %s %s", ref.Kind, ref.Name))
+				continue
+			}
+			managed[ref.key()] = ref
+			results = append(results, Resource{Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace, Status: SyncStatusSynced})
+		}
+	}
+
+	prune := append([]ResourceRef(nil), cmp.prune...)
+	sort.SliceStable(prune, func(i, j int) bool { return prune[i].Wave > prune[j].Wave })
+	for _, ref := range prune {
+		switch {
+		case syncErr != nil || !req.Prune || !selected(ref):
+			pending++
+		case ref.NoPrune:
+			pending++
+			results = append(results, Resource{
+				Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace,
+				Status: ResourcePruneSkipped, Message: "Prune=false",
+			})
+		default:
+			if err := s.state.Delete(ctx, app.ClusterID, ref); err != nil {
+				pending++
+				results = append(results, Resource{
+					Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace,
+					Status: ResourceSyncFailed, Message: err.Error(),
+				})
+				syncErr = errors.KubernetesWrap(err, fmt.Sprintf("failed to prune %s %s", ref.Kind, ref.Name))
+				continue
+			}
+			delete(managed, ref.key())
+			results = append(results, Resource{Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace, Status: ResourcePruned})
+		}
+	}
+
+	status, message := SyncStatusSynced, "Sync completed"
+	switch {
+	case syncErr != nil:
+		status, message = SyncStatusOutOfSync, syncErr.Error()
+	case pending > 0:
+		status, message = SyncStatusOutOfSync, fmt.Sprintf("Sync completed with %d resources left out of sync", pending)
+	}
+	if err := s.recordSync(ctx, app, status, message, cmp.revision, managed); err != nil {
+		return nil, err
+	}
+
+	out := &SyncStatus{
+		Status:       status,
+		Message:      message,
+		Revision:     cmp.revision,
+		StartedAt:    started,
+		FinishedAt:   time.Now(),
+		ResourcesOut: results,
+	}
+	eventType := EventSynced
+	if syncErr != nil {
+		eventType = EventSyncFailed
+	}
+	s.emitEvent(ctx, eventType, app, map[string]interface{}{
+		"revision":  cmp.revision,
+		"status":    status,
+		"message":   message,
+		"resources": results,
+	})
+	if s.emitter != nil {
+		s.emitter.EmitAppSync(app.ID, out)
+	}
+
+	logger.Info("Application synced",
+		zap.String("app_id", app.ID),
+		zap.String("name", app.Name),
+		zap.String("revision", cmp.revision),
+		zap.String("status", status),
+	)
+	return out, syncErr
+}
+
+// resourceFilter matches resources named in a sync request as kind/name,
+// or every resource when none are named
+func resourceFilter(names []string) func(ResourceRef) bool {
+	if len(names) == 0 {
+		return func(ResourceRef) bool { return true }
+	}
+	return func(ref ResourceRef) bool {
+		for _, name := range names {
+			kind, resource, _ := strings.Cut(name, "/")
+			if strings.EqualFold(kind, ref.Kind) && resource == ref.Name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// exclusive runs fn while the application is claimed, and with SetLocks
+// while this replica holds its sync lock. fn's ctx is cancelled if the
+// lock is lost. It fails with a conflict when the application is already
+// syncing.
+func (s *Service) exclusive(ctx context.Context, app *Application, fn func(ctx context.Context) (*SyncStatus, error)) (*SyncStatus, error) {
+	conflict := errors.Conflict(fmt.Sprintf("application %s is already syncing", app.Name))
+	if !s.claim(app.ID) {
+		return nil, conflict
+	}
+	defer s.release(app.ID)
+	if s.locks == nil {
+		return fn(ctx)
+	}
+
+	var status *SyncStatus
+	held, err := s.locks.WithLock(ctx, "gitops:reconcile:"+app.ID, syncLockTTL, func(ctx context.Context, _ cache.Lock) error {
+		var err error
+		status, err = fn(ctx)
+		return err
+	})
+	if !held {
+		if err != nil {
+			return nil, errors.InternalWrap(err, "failed to lock application")
+		}
+		return nil, conflict
+	}
+	return status, err
+}
+
+// claim marks an application as syncing; it fails when it already is
+func (s *Service) claim(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syncing[id] {
+		return false
+	}
+	s.syncing[id] = true
+	return true
+}
+
+func (s *Service) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.syncing, id)
+}
+
+// managedResources returns the resources the application's last sync
+// left in the cluster
+func (s *Service) managedResources(ctx context.Context, id string) ([]ResourceRef, error) {
+	var data []byte
+	if err := s.db.QueryRowContext(ctx, "SELECT managed_resources FROM applications WHERE id = $1", id).Scan(&data); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to get managed resources")
+	}
+	var refs []ResourceRef
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &refs); err != nil {
+			return nil, errors.InternalWrap(err, "invalid managed resources")
+		}
+	}
+	return refs, nil
+}
+
+// setSyncStatus records a comparison's outcome, broadcasting changes to
+// dashboard clients
+func (s *Service) setSyncStatus(ctx context.Context, app *Application, status, message string) {
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE applications SET sync_status = $2, sync_message = $3, updated_at = $4 WHERE id = $1",
+		app.ID, status, message, time.Now(),
+	); err != nil {
+		logger.Warn("Failed to record application sync status", zap.String("app_id", app.ID), zap.Error(err))
+		return
+	}
+	if status != app.SyncStatus && s.emitter != nil {
+		s.emitter.EmitAppStatus(app.ID, map[string]interface{}{
+			"name": app.Name, "sync_status": status, "health_status": app.HealthStatus,
+		})
+	}
+	app.SyncStatus = status
+	app.SyncMessage = message
+}
+
+// recordSync records a sync's outcome and the resources it manages
+func (s *Service) recordSync(ctx context.Context, app *Application, status, message, revision string, managed map[string]ResourceRef) error {
+	refs := make([]ResourceRef, 0, len(managed))
+	for _, ref := range managed {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].key() < refs[j].key() })
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return errors.InternalWrap(err, "failed to encode managed resources")
+	}
+
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE applications
+		SET sync_status = $2, sync_message = $3, sync_revision = $4,
+		    managed_resources = $5, last_sync_at = $6, updated_at = $6
+		WHERE id = $1
+	`, app.ID, status, message, revision, string(data), now); err != nil {
+		return errors.DatabaseWrap(err, "failed to record application sync")
+	}
+	if status != app.SyncStatus && s.emitter != nil {
+		s.emitter.EmitAppStatus(app.ID, map[string]interface{}{
+			"name": app.Name, "sync_status": status, "health_status": app.HealthStatus,
+		})
+	}
+	app.SyncStatus = status
+	app.SyncMessage = message
+	app.SyncRevision = revision
+	app.LastSyncAt = &now
+	return nil
+}
+
+// emitEvent publishes an application event, tagged with its namespace
+func (s *Service) emitEvent(ctx context.Context, eventType string, app *Application, data map[string]interface{}) {
+	if s.events == nil {
+		return
+	}
+	data["application"] = app.Name
+	data["cluster_id"] = app.ClusterID
+	if err := s.events.EmitDeploymentEvent(ctx, eventType, app.ID, app.Namespace, data); err != nil {
+		logger.Warn("Failed to emit application event",
+			zap.String("app_id", app.ID),
+			zap.String("type", eventType),
+			zap.Error(err),
+		)
+	}
+}
+
+// kubeState compares and applies with the service's Kubernetes clients
+type kubeState struct {
+	s *Service
+}
+
+func (k *kubeState) client(ctx context.Context, clusterID string) (*kube.ClusterClient, error) {
+	var name string
+	if err := k.s.db.QueryRowContext(ctx, "SELECT name FROM clusters WHERE id = $1", clusterID).Scan(&name); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to find application cluster")
+	}
+	client, err := k.s.kubeManager.GetClient(name)
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "cluster is not connected")
+	}
+	return client, nil
+}
+
+// Diff dry-runs the apply so server defaults don't count as drift
+func (k *kubeState) Diff(ctx context.Context, clusterID string, obj *unstructured.Unstructured) (string, error) {
+	client, err := k.client(ctx, clusterID)
+	if err != nil {
+		return "", err
+	}
+	res, err := client.ResourceFor(obj.GroupVersionKind())
+	if err != nil {
+		return "", err
+	}
+	name := obj.GetKind() + "/" + obj.GetName()
+	live, err := client.GetObject(ctx, res, obj.GetNamespace(), obj.GetName())
+	if apierrors.IsNotFound(err) {
+		return kube.DiffObjects(name, nil, obj)
+	}
+	if err != nil {
+		return "", err
+	}
+	applied, err := client.ApplyObject(ctx, res, obj.DeepCopy(), kube.ApplyOptions{Force: true, DryRun: true})
+	if err != nil {
+		return "", err
+	}
+	return kube.DiffObjects(name, live, applied)
+}
+
+func (k *kubeState) Apply(ctx context.Context, clusterID string, obj *unstructured.Unstructured) error {
+	client, err := k.client(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	res, err := client.ResourceFor(obj.GroupVersionKind())
+	if err != nil {
+		return err
+	}
+	_, err = client.ApplyObject(ctx, res, obj, kube.ApplyOptions{Force: true})
+	return err
+}
+
+func (k *kubeState) Delete(ctx context.Context, clusterID string, ref ResourceRef) error {
+	client, err := k.client(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	res, err := client.ResourceFor(schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind))
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteObject(ctx, res, ref.Namespace, ref.Name, false); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,375 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testClusterID = "c1"
+
+// fakeSource serves fixed manifests at a fixed revision
+type fakeSource struct {
+	revision string
+	manifest string
+}
+
+func (f *fakeSource) Desired(ctx context.Context, app *Application, revision string) (*DesiredState, error) {
+	objects, err := kube.DecodeManifest([]byte(f.manifest))
+	if err != nil {
+		return nil, err
+	}
+	return &DesiredState{Revision: f.revision, Objects: objects}, nil
+}
+
+// fakeCluster is live state kept in memory
+type fakeCluster struct {
+	mu      sync.Mutex
+	live    map[string]*unstructured.Unstructured
+	applied []string
+	deleted []string
+}
+
+func objectKey(kind, namespace, name string) string { return kind + "/" + namespace + "/" + name }
+
+func (f *fakeCluster) Diff(ctx context.Context, clusterID string, obj *unstructured.Unstructured) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	live := f.live[objectKey(obj.GetKind(), obj.GetNamespace(), obj.GetName())]
+	return kube.DiffObjects(obj.GetName(), live, obj)
+}
+
+func (f *fakeCluster) Apply(ctx context.Context, clusterID string, obj *unstructured.Unstructured) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.live[objectKey(obj.GetKind(), obj.GetNamespace(), obj.GetName())] = obj
+	f.applied = append(f.applied, obj.GetKind()+"/"+obj.GetName())
+	return nil
+}
+
+func (f *fakeCluster) Delete(ctx context.Context, clusterID string, ref ResourceRef) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.live, objectKey(ref.Kind, ref.Namespace, ref.Name))
+	f.deleted = append(f.deleted, ref.Kind+"/"+ref.Name)
+	return nil
+}
+
+// fakeEvents records published events
+type fakeEvents struct {
+	mu     sync.Mutex
+	events []string
+	data   []map[string]interface{}
+}
+
+func (f *fakeEvents) EmitDeploymentEvent(ctx context.Context, eventType, deploymentID, environment string, data interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, fmt.Sprintf("krustron.deployment.%s.%s", deploymentID, eventType))
+	f.data = append(f.data, data.(map[string]interface{}))
+	return nil
+}
+
+func (f *fakeEvents) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	events := f.events
+	f.events = nil
+	return events
+}
+
+func newTestService(t *testing.T, cfg *config.GitOpsConfig) (*Service, *fakeSource, *fakeCluster, *fakeEvents) {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	_, err = sqlDB.Exec(`CREATE TABLE applications (
+		id TEXT PRIMARY KEY, name TEXT NOT NULL, display_name TEXT DEFAULT '', description TEXT DEFAULT '',
+		cluster_id TEXT, namespace TEXT NOT NULL, source_type TEXT NOT NULL, repo_url TEXT DEFAULT '',
+		repo_branch TEXT DEFAULT 'main', repo_path TEXT DEFAULT '.', repo_credentials_ref TEXT,
+		sources TEXT DEFAULT '[]', helm_chart TEXT DEFAULT '',
+		helm_repo TEXT DEFAULT '', helm_version TEXT DEFAULT '', values_yaml TEXT,
+		sync_policy TEXT DEFAULT 'manual', auto_sync BOOLEAN DEFAULT false, prune BOOLEAN DEFAULT false,
+		self_heal BOOLEAN DEFAULT false, status TEXT DEFAULT 'unknown', health_status TEXT DEFAULT 'unknown',
+		sync_status TEXT DEFAULT 'unknown', sync_message TEXT, sync_revision TEXT,
+		managed_resources TEXT DEFAULT '[]', last_sync_at TIMESTAMP, labels TEXT DEFAULT '{}',
+		annotations TEXT DEFAULT '{}', created_by TEXT DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+
+	svc := NewService(&database.PostgresDB{DB: sqlDB}, nil, cfg)
+	source := &fakeSource{}
+	cluster := &fakeCluster{live: map[string]*unstructured.Unstructured{}}
+	events := &fakeEvents{}
+	svc.SetSource(source)
+	svc.SetClusterState(cluster)
+	svc.SetEventBus(events)
+	return svc, source, cluster, events
+}
+
+func addApp(t *testing.T, svc *Service, id string, autoSync, prune, selfHeal bool) {
+	t.Helper()
+	_, err := svc.db.Exec(`INSERT INTO applications (id, name, cluster_id, namespace, source_type, repo_url, auto_sync, prune, self_heal)
+		VALUES ($1, $2, $3, 'shop', 'git', 'https://git.example.com/shop.git', $4, $5, $6)`,
+		id, "app-"+id, testClusterID, autoSync, prune, selfHeal)
+	require.NoError(t, err)
+}
+
+const shopManifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    argocd.argoproj.io/sync-wave: "1"
+spec:
+  replicas: 2
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    argocd.argoproj.io/sync-wave: "-1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: live
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: legacy
+  annotations:
+    argocd.argoproj.io/sync-options: Prune=false
+`
+
+func TestReconcileDetectsDrift(t *testing.T) {
+	svc, source, cluster, events := newTestService(t, &config.GitOpsConfig{PruneEnabled: true, SelfHealEnabled: true})
+	ctx := context.Background()
+	source.revision, source.manifest = "abc123", shopManifest
+	addApp(t, svc, "a1", false, false, false)
+
+	// Nothing is live yet
+	status, err := svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusOutOfSync, status.Status)
+	assert.Len(t, status.ResourcesOut, 4)
+	assert.Equal(t, []string{"krustron.deployment.a1.gitops_drift"}, events.take())
+	assert.Empty(t, cluster.applied, "manual sync policy only reports drift")
+
+	// Waves apply in order, then dependencies first within a wave
+	status, err = svc.Sync(ctx, "a1", &SyncRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusSynced, status.Status)
+	assert.Equal(t, "abc123", status.Revision)
+	assert.Equal(t, []string{"Job/migrate", "ConfigMap/settings", "ConfigMap/legacy", "Deployment/web"}, cluster.applied)
+	assert.Equal(t, []string{"krustron.deployment.a1.gitops_synced"}, events.take())
+
+	app, err := svc.Get(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusSynced, app.SyncStatus)
+	assert.Equal(t, "abc123", app.SyncRevision)
+	assert.NotNil(t, app.LastSyncAt)
+
+	status, err = svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusSynced, status.Status)
+	assert.Empty(t, events.take())
+
+	// Someone edits the live ConfigMap
+	cluster.live[objectKey("ConfigMap", "shop", "settings")].Object["data"] = map[string]interface{}{"mode": "debug"}
+	status, err = svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusOutOfSync, status.Status)
+	assert.Equal(t, []Resource{{Kind: "ConfigMap", Name: "settings", Namespace: "shop", Status: SyncStatusOutOfSync}}, status.ResourcesOut)
+	assert.Equal(t, []string{"krustron.deployment.a1.gitops_drift"}, events.take())
+	assert.Equal(t, "app-a1", events.data[len(events.data)-1]["application"])
+
+	diff, err := svc.GetDiff(ctx, "a1")
+	require.NoError(t, err)
+	require.Len(t, diff.Diffs, 1)
+	assert.Contains(t, diff.Diffs[0].Diff, "-  mode: debug\n+  mode: live")
+
+	// Drift is reported once, not on every pass
+	_, err = svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Empty(t, events.take())
+	app, err = svc.Get(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusOutOfSync, app.SyncStatus)
+}
+
+func TestReconcileAutoSyncAndPrune(t *testing.T) {
+	svc, source, cluster, events := newTestService(t, &config.GitOpsConfig{PruneEnabled: true, SelfHealEnabled: true})
+	ctx := context.Background()
+	source.revision, source.manifest = "abc123", shopManifest
+	addApp(t, svc, "a1", true, true, true)
+
+	require.NoError(t, svc.ReconcileAll(ctx, 2))
+	assert.Len(t, cluster.live, 4)
+	assert.Equal(t, []string{"krustron.deployment.a1.gitops_drift", "krustron.deployment.a1.gitops_synced"}, events.take())
+
+	// Self-heal reverts drift without a new revision
+	cluster.applied = nil
+	cluster.live[objectKey("Deployment", "shop", "web")].Object["spec"] = map[string]interface{}{"replicas": int64(5)}
+	status, err := svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusSynced, status.Status)
+	assert.Equal(t, []string{"Deployment/web"}, cluster.applied)
+	assert.Equal(t, []string{"krustron.deployment.a1.gitops_drift", "krustron.deployment.a1.gitops_synced"}, events.take())
+
+	// Resources leaving Git are pruned in reverse wave order, except those
+	// that opt out
+	source.revision, source.manifest = "def456", `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: live
+`
+	status, err = svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Deployment/web", "Job/migrate"}, cluster.deleted)
+	assert.Equal(t, SyncStatusOutOfSync, status.Status)
+	assert.Contains(t, status.ResourcesOut, Resource{
+		Kind: "ConfigMap", Name: "legacy", Namespace: "shop", Status: ResourcePruneSkipped, Message: "Prune=false",
+	})
+	assert.Contains(t, cluster.live, objectKey("ConfigMap", "shop", "legacy"))
+
+	// Without self-heal and with the revision already synced, drift stays
+	_, err = svc.db.Exec("UPDATE applications SET self_heal = false WHERE id = 'a1'")
+	require.NoError(t, err)
+	cluster.applied = nil
+	cluster.live[objectKey("ConfigMap", "shop", "settings")].Object["data"] = map[string]interface{}{"mode": "debug"}
+	status, err = svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusOutOfSync, status.Status)
+	assert.Empty(t, cluster.applied)
+}
+
+func TestReconcileHonoursClusterOverrides(t *testing.T) {
+	svc, source, cluster, _ := newTestService(t, &config.GitOpsConfig{PruneEnabled: true, SelfHealEnabled: true})
+	ctx := context.Background()
+	source.revision, source.manifest = "abc123", shopManifest
+	addApp(t, svc, "a1", true, true, true)
+	require.NoError(t, svc.ReconcileAll(ctx, 1))
+
+	for _, stmt := range []string{
+		`CREATE TABLE clusters (id TEXT PRIMARY KEY, name TEXT UNIQUE)`,
+		`CREATE TABLE cluster_configs (cluster_id TEXT PRIMARY KEY, overrides TEXT NOT NULL, updated_by TEXT, updated_at TIMESTAMP)`,
+	} {
+		_, err := svc.db.Exec(stmt)
+		require.NoError(t, err)
+	}
+	_, err := svc.db.Exec(`INSERT INTO clusters VALUES ($1, 'prod')`, testClusterID)
+	require.NoError(t, err)
+	overrides := clusterconfig.NewStore(svc.db)
+	svc.SetClusterOverrides(overrides)
+	selfHeal := false
+	require.NoError(t, overrides.SetClusterConfig(ctx, &clusterconfig.ClusterConfig{
+		ClusterID: testClusterID,
+		Overrides: clusterconfig.Overrides{GitOpsSelfHeal: &selfHeal},
+	}))
+
+	// The cluster turns self-heal off, so drift is only reported
+	cluster.applied = nil
+	cluster.live[objectKey("Deployment", "shop", "web")].Object["spec"] = map[string]interface{}{"replicas": int64(5)}
+	status, err := svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusOutOfSync, status.Status)
+	assert.Empty(t, cluster.applied)
+
+	// Removing the override falls back to the global setting
+	require.NoError(t, overrides.SetClusterConfig(ctx, &clusterconfig.ClusterConfig{ClusterID: testClusterID}))
+	status, err = svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusSynced, status.Status)
+	assert.Equal(t, []string{"Deployment/web"}, cluster.applied)
+}
+
+func TestSyncConcurrentAndDryRun(t *testing.T) {
+	svc, source, cluster, _ := newTestService(t, nil)
+	ctx := context.Background()
+	source.revision, source.manifest = "abc123", shopManifest
+	addApp(t, svc, "a1", false, false, false)
+
+	status, err := svc.Sync(ctx, "a1", &SyncRequest{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusOutOfSync, status.Status)
+	assert.Empty(t, cluster.live)
+
+	status, err = svc.Sync(ctx, "a1", &SyncRequest{Resources: []string{"configmap/settings"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ConfigMap/settings"}, cluster.applied)
+	assert.Equal(t, SyncStatusOutOfSync, status.Status, "the rest is still out of sync")
+
+	require.True(t, svc.claim("a1"))
+	_, err = svc.Sync(ctx, "a1", &SyncRequest{})
+	assert.True(t, errors.Is(err, errors.CodeConflict))
+	svc.release("a1")
+
+	source.manifest = "kind: ConfigMap"
+	_, err = svc.Reconcile(ctx, "a1")
+	require.Error(t, err)
+	app, err := svc.Get(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusUnknown, app.SyncStatus)
+	assert.Contains(t, app.SyncMessage, "apiVersion is required")
+}
+
+func TestReconcileLockedAcrossReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redisCache, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	t.Cleanup(func() { redisCache.Close() })
+
+	svc, source, cluster, _ := newTestService(t, nil)
+	svc.SetLocks(redisCache)
+	ctx := context.Background()
+	source.revision, source.manifest = "abc123", shopManifest
+	addApp(t, svc, "a1", true, false, false)
+
+	// Another replica is syncing the application
+	lock, held, err := redisCache.AcquireLock(ctx, "gitops:reconcile:a1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, held)
+
+	_, err = svc.Reconcile(ctx, "a1")
+	assert.True(t, errors.Is(err, errors.CodeConflict))
+	_, err = svc.Sync(ctx, "a1", &SyncRequest{})
+	assert.True(t, errors.Is(err, errors.CodeConflict))
+	assert.Empty(t, cluster.applied)
+
+	require.NoError(t, lock.Release(ctx))
+	status, err := svc.Reconcile(ctx, "a1")
+	require.NoError(t, err)
+	assert.Equal(t, SyncStatusSynced, status.Status)
+	assert.False(t, mr.Exists("{lock:gitops:reconcile:a1}"), "the lock is released after the sync")
+}
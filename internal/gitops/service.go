@@ -6,8 +6,13 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
 	"github.com/anubhavg-icpl/krustron/pkg/config"
 	"github.com/anubhavg-icpl/krustron/pkg/database"
 	"github.com/anubhavg-icpl/krustron/pkg/errors"
@@ -23,6 +28,19 @@ type Service struct {
 	kubeManager *kube.ClientManager
 	config      *config.GitOpsConfig
 	emitter     *websocket.EventEmitter
+	events      EventPublisher
+	git         *GitSource
+	source      Source
+	state       ClusterState
+	overrides   *clusterconfig.Store
+	locks       *cache.RedisCache
+
+	mu      sync.Mutex
+	syncing map[string]bool
+	// reconcile is the running reconciler's settings; see
+	// UpdateReconcileConfig
+	reconcile     ReconcileConfig
+	reconcileWake chan struct{}
 }
 
 // SetEventEmitter wires the real-time hub so application mutations broadcast
@@ -31,11 +49,42 @@ func (s *Service) SetEventEmitter(e *websocket.EventEmitter) { s.emitter = e }
 
 // NewService creates a new GitOps service
 func NewService(db *database.PostgresDB, kubeManager *kube.ClientManager, cfg *config.GitOpsConfig) *Service {
-	return &Service{
-		db:          db,
-		kubeManager: kubeManager,
-		config:      cfg,
+	s := &Service{
+		db:            db,
+		kubeManager:   kubeManager,
+		config:        cfg,
+		syncing:       map[string]bool{},
+		reconcileWake: make(chan struct{}, 1),
 	}
+	gitCfg := s.gitopsConfig()
+	s.git = NewGitSource(GitSourceOptions{
+		Dir:            gitCfg.RepoCacheDir,
+		Depth:          gitCfg.CloneDepth,
+		KnownHostsFile: gitCfg.SSHKnownHostsFile,
+		Credentials:    repoCredentials(gitCfg.RepoCredentials),
+	})
+	s.source = s.git
+	if kubeManager != nil {
+		s.state = &kubeState{s: s}
+	}
+	return s
+}
+
+// repoCredentials indexes registered credentials by ref
+func repoCredentials(creds []config.RepoCredential) map[string][]string {
+	out := make(map[string][]string, len(creds))
+	for _, c := range creds {
+		out[c.Ref] = append(out[c.Ref], c.Hosts...)
+	}
+	return out
+}
+
+// gitopsConfig returns the GitOps config, or defaults when there is none
+func (s *Service) gitopsConfig() config.GitOpsConfig {
+	if s.config == nil {
+		return config.GitOpsConfig{}
+	}
+	return *s.config
 }
 
 // Application represents a GitOps application
@@ -50,6 +99,11 @@ type Application struct {
 	RepoURL      string            `json:"repo_url" db:"repo_url"`
 	RepoBranch   string            `json:"repo_branch" db:"repo_branch"`
 	RepoPath     string            `json:"repo_path" db:"repo_path"`
+	// RepoCredentialsRef names the secret holding the repository's
+	// credentials
+	RepoCredentialsRef string `json:"repo_credentials_ref,omitempty" db:"repo_credentials_ref"`
+	// Sources, when set, replace the single repository above
+	Sources      []AppSource       `json:"sources,omitempty" db:"sources"`
 	HelmChart    string            `json:"helm_chart" db:"helm_chart"`
 	HelmRepo     string            `json:"helm_repo" db:"helm_repo"`
 	HelmVersion  string            `json:"helm_version" db:"helm_version"`
@@ -61,6 +115,8 @@ type Application struct {
 	Status       string            `json:"status" db:"status"`
 	HealthStatus string            `json:"health_status" db:"health_status"`
 	SyncStatus   string            `json:"sync_status" db:"sync_status"`
+	SyncMessage  string            `json:"sync_message,omitempty" db:"sync_message"`
+	SyncRevision string            `json:"sync_revision,omitempty" db:"sync_revision"`
 	LastSyncAt   *time.Time        `json:"last_sync_at" db:"last_sync_at"`
 	Labels       map[string]string `json:"labels" db:"labels"`
 	Annotations  map[string]string `json:"annotations" db:"annotations"`
@@ -69,6 +125,38 @@ type Application struct {
 	UpdatedAt    time.Time         `json:"updated_at" db:"updated_at"`
 }
 
+// AppSource is one repository an application's manifests come from
+type AppSource struct {
+	RepoURL string `json:"repo_url" binding:"required"`
+	// Revision is a branch, tag or commit; the default branch when empty
+	Revision string `json:"revision,omitempty"`
+	// Path holds manifests, or a Helm chart when it has a Chart.yaml
+	Path string `json:"path,omitempty"`
+	// Ref names the source so value files of other sources can read its
+	// files as $ref/path; with no path the source only provides files
+	Ref string `json:"ref,omitempty"`
+	// ValueFiles are merged, in order, into the values of a chart at Path
+	ValueFiles     []string `json:"value_files,omitempty"`
+	CredentialsRef string   `json:"credentials_ref,omitempty"`
+}
+
+// SourceList returns the application's sources: its sources, or else its
+// repository
+func (a *Application) SourceList() []AppSource {
+	if len(a.Sources) > 0 {
+		return append([]AppSource(nil), a.Sources...)
+	}
+	if a.RepoURL == "" {
+		return nil
+	}
+	return []AppSource{{
+		RepoURL:        a.RepoURL,
+		Revision:       a.RepoBranch,
+		Path:           a.RepoPath,
+		CredentialsRef: a.RepoCredentialsRef,
+	}}
+}
+
 // ListFilters contains filters for listing applications
 type ListFilters struct {
 	Page      int
@@ -89,6 +177,10 @@ type CreateRequest struct {
 	RepoURL     string            `json:"repo_url"`
 	RepoBranch  string            `json:"repo_branch"`
 	RepoPath    string            `json:"repo_path"`
+	// RepoCredentialsRef names the secret holding the repository's
+	// credentials
+	RepoCredentialsRef string      `json:"repo_credentials_ref"`
+	Sources            []AppSource `json:"sources" binding:"omitempty,dive"`
 	HelmChart   string            `json:"helm_chart"`
 	HelmRepo    string            `json:"helm_repo"`
 	HelmVersion string            `json:"helm_version"`
@@ -107,6 +199,9 @@ type UpdateRequest struct {
 	Description string            `json:"description"`
 	RepoBranch  string            `json:"repo_branch"`
 	RepoPath    string            `json:"repo_path"`
+	// RepoCredentialsRef and Sources are left as they are when empty
+	RepoCredentialsRef string      `json:"repo_credentials_ref"`
+	Sources            []AppSource `json:"sources" binding:"omitempty,dive"`
 	HelmVersion string            `json:"helm_version"`
 	ValuesYAML  string            `json:"values_yaml"`
 	AutoSync    *bool             `json:"auto_sync"`
@@ -118,9 +213,10 @@ type UpdateRequest struct {
 
 // SyncRequest contains sync request data
 type SyncRequest struct {
-	Revision string   `json:"revision"`
-	Prune    bool     `json:"prune"`
-	DryRun   bool     `json:"dry_run"`
+	Revision string `json:"revision"`
+	Prune    bool   `json:"prune"`
+	DryRun   bool   `json:"dry_run"`
+	// Resources limits the sync to resources named as kind/name
 	Resources []string `json:"resources"`
 }
 
@@ -148,8 +244,8 @@ type Resource struct {
 func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Application, int, error) {
 	query := `
 		SELECT id, name, display_name, description, cluster_id, namespace,
-		       source_type, repo_url, repo_branch, repo_path, helm_chart,
-		       helm_repo, helm_version, sync_policy, auto_sync, prune,
+		       source_type, repo_url, repo_branch, repo_path, repo_credentials_ref,
+		       sources, helm_chart, helm_repo, helm_version, sync_policy, auto_sync, prune,
 		       self_heal, status, health_status, sync_status, last_sync_at,
 		       labels, annotations, created_by, created_at, updated_at
 		FROM applications
@@ -198,13 +294,14 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Application
 	var apps []Application
 	for rows.Next() {
 		var app Application
-		var labels, annotations []byte
+		var labels, annotations, sources []byte
 		var lastSyncAt sql.NullTime
+		var credentialsRef sql.NullString
 
 		if err := rows.Scan(
 			&app.ID, &app.Name, &app.DisplayName, &app.Description, &app.ClusterID,
 			&app.Namespace, &app.SourceType, &app.RepoURL, &app.RepoBranch,
-			&app.RepoPath, &app.HelmChart, &app.HelmRepo, &app.HelmVersion,
+			&app.RepoPath, &credentialsRef, &sources, &app.HelmChart, &app.HelmRepo, &app.HelmVersion,
 			&app.SyncPolicy, &app.AutoSync, &app.Prune, &app.SelfHeal,
 			&app.Status, &app.HealthStatus, &app.SyncStatus, &lastSyncAt,
 			&labels, &annotations, &app.CreatedBy, &app.CreatedAt, &app.UpdatedAt,
@@ -215,6 +312,8 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Application
 		if lastSyncAt.Valid {
 			app.LastSyncAt = &lastSyncAt.Time
 		}
+		app.RepoCredentialsRef = credentialsRef.String
+		json.Unmarshal(sources, &app.Sources)
 		json.Unmarshal(labels, &app.Labels)
 		json.Unmarshal(annotations, &app.Annotations)
 
@@ -228,24 +327,26 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Application
 func (s *Service) Get(ctx context.Context, id string) (*Application, error) {
 	query := `
 		SELECT id, name, display_name, description, cluster_id, namespace,
-		       source_type, repo_url, repo_branch, repo_path, helm_chart,
-		       helm_repo, helm_version, values_yaml, sync_policy, auto_sync,
-		       prune, self_heal, status, health_status, sync_status, last_sync_at,
-		       labels, annotations, created_by, created_at, updated_at
+		       source_type, repo_url, repo_branch, repo_path, repo_credentials_ref,
+		       sources, helm_chart, helm_repo, helm_version, values_yaml, sync_policy, auto_sync,
+		       prune, self_heal, status, health_status, sync_status, sync_message,
+		       sync_revision, last_sync_at, labels, annotations, created_by,
+		       created_at, updated_at
 		FROM applications WHERE id = $1
 	`
 
 	var app Application
-	var labels, annotations []byte
+	var labels, annotations, sources []byte
 	var lastSyncAt sql.NullTime
-	var valuesYAML sql.NullString
+	var valuesYAML, syncMessage, syncRevision, credentialsRef sql.NullString
 
 	if err := s.db.QueryRowContext(ctx, query, id).Scan(
 		&app.ID, &app.Name, &app.DisplayName, &app.Description, &app.ClusterID,
 		&app.Namespace, &app.SourceType, &app.RepoURL, &app.RepoBranch,
-		&app.RepoPath, &app.HelmChart, &app.HelmRepo, &app.HelmVersion,
+		&app.RepoPath, &credentialsRef, &sources, &app.HelmChart, &app.HelmRepo, &app.HelmVersion,
 		&valuesYAML, &app.SyncPolicy, &app.AutoSync, &app.Prune, &app.SelfHeal,
-		&app.Status, &app.HealthStatus, &app.SyncStatus, &lastSyncAt,
+		&app.Status, &app.HealthStatus, &app.SyncStatus, &syncMessage,
+		&syncRevision, &lastSyncAt,
 		&labels, &annotations, &app.CreatedBy, &app.CreatedAt, &app.UpdatedAt,
 	); err != nil {
 		if err == sql.ErrNoRows {
@@ -257,9 +358,11 @@ func (s *Service) Get(ctx context.Context, id string) (*Application, error) {
 	if lastSyncAt.Valid {
 		app.LastSyncAt = &lastSyncAt.Time
 	}
-	if valuesYAML.Valid {
-		app.ValuesYAML = valuesYAML.String
-	}
+	app.ValuesYAML = valuesYAML.String
+	app.SyncMessage = syncMessage.String
+	app.SyncRevision = syncRevision.String
+	app.RepoCredentialsRef = credentialsRef.String
+	json.Unmarshal(sources, &app.Sources)
 	json.Unmarshal(labels, &app.Labels)
 	json.Unmarshal(annotations, &app.Annotations)
 
@@ -268,6 +371,13 @@ func (s *Service) Get(ctx context.Context, id string) (*Application, error) {
 
 // Create creates a new application
 func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Application, error) {
+	if req.SourceType == "git" && req.RepoURL == "" && len(req.Sources) == 0 {
+		return nil, errors.BadRequest("a Git application needs a repo_url or sources")
+	}
+	if err := validateSources(req.RepoURL, req.Sources); err != nil {
+		return nil, err
+	}
+	sources, _ := json.Marshal(req.Sources)
 	labels, _ := json.Marshal(req.Labels)
 	annotations, _ := json.Marshal(req.Annotations)
 
@@ -290,8 +400,9 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Application,
 		INSERT INTO applications (name, display_name, description, cluster_id, namespace,
 		                         source_type, repo_url, repo_branch, repo_path,
 		                         helm_chart, helm_repo, helm_version, values_yaml,
-		                         auto_sync, prune, self_heal, labels, annotations, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		                         auto_sync, prune, self_heal, labels, annotations, created_by,
+		                         repo_credentials_ref, sources)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
 		RETURNING id, status, health_status, sync_status, created_at, updated_at
 	`
 
@@ -301,6 +412,7 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Application,
 		req.SourceType, req.RepoURL, repoBranch, repoPath,
 		req.HelmChart, req.HelmRepo, req.HelmVersion, req.ValuesYAML,
 		req.AutoSync, req.Prune, req.SelfHeal, labels, annotations, req.CreatedBy,
+		req.RepoCredentialsRef, sources,
 	).Scan(&app.ID, &app.Status, &app.HealthStatus, &app.SyncStatus, &app.CreatedAt, &app.UpdatedAt); err != nil {
 		return nil, errors.DatabaseWrap(err, "failed to create application")
 	}
@@ -314,6 +426,8 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Application,
 	app.RepoURL = req.RepoURL
 	app.RepoBranch = repoBranch
 	app.RepoPath = repoPath
+	app.RepoCredentialsRef = req.RepoCredentialsRef
+	app.Sources = req.Sources
 	app.HelmChart = req.HelmChart
 	app.HelmRepo = req.HelmRepo
 	app.HelmVersion = req.HelmVersion
@@ -341,6 +455,13 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Application,
 
 // Update updates an application
 func (s *Service) Update(ctx context.Context, id string, req *UpdateRequest) (*Application, error) {
+	if err := validateSources("", req.Sources); err != nil {
+		return nil, err
+	}
+	var sources []byte
+	if req.Sources != nil {
+		sources, _ = json.Marshal(req.Sources)
+	}
 	labels, _ := json.Marshal(req.Labels)
 	annotations, _ := json.Marshal(req.Annotations)
 
@@ -357,6 +478,8 @@ func (s *Service) Update(ctx context.Context, id string, req *UpdateRequest) (*A
 		    self_heal = COALESCE($10, self_heal),
 		    labels = COALESCE($11, labels),
 		    annotations = COALESCE($12, annotations),
+		    repo_credentials_ref = COALESCE(NULLIF($13, ''), repo_credentials_ref),
+		    sources = COALESCE($14, sources),
 		    updated_at = NOW()
 		WHERE id = $1
 	`
@@ -364,7 +487,7 @@ func (s *Service) Update(ctx context.Context, id string, req *UpdateRequest) (*A
 	result, err := s.db.ExecContext(ctx, query, id,
 		req.DisplayName, req.Description, req.RepoBranch, req.RepoPath,
 		req.HelmVersion, req.ValuesYAML, req.AutoSync, req.Prune, req.SelfHeal,
-		labels, annotations,
+		labels, annotations, req.RepoCredentialsRef, sources,
 	)
 	if err != nil {
 		return nil, errors.DatabaseWrap(err, "failed to update application")
@@ -378,6 +501,36 @@ func (s *Service) Update(ctx context.Context, id string, req *UpdateRequest) (*A
 	return s.Get(ctx, id)
 }
 
+// validateSources checks repository URLs and that value files only read
+// from named sources
+func validateSources(repoURL string, sources []AppSource) error {
+	if repoURL != "" {
+		if _, _, err := parseRepoURL(repoURL); err != nil {
+			return err
+		}
+	}
+	refs := map[string]bool{}
+	for _, src := range sources {
+		if _, _, err := parseRepoURL(src.RepoURL); err != nil {
+			return err
+		}
+		if src.Ref != "" {
+			if refs[src.Ref] {
+				return errors.BadRequest(fmt.Sprintf("more than one source is named %s", src.Ref))
+			}
+			refs[src.Ref] = true
+		}
+	}
+	for _, src := range sources {
+		for _, file := range src.ValueFiles {
+			if ref, _, _ := strings.Cut(strings.TrimPrefix(file, "$"), "/"); strings.HasPrefix(file, "$") && !refs[ref] {
+				return errors.BadRequest(fmt.Sprintf("value file %s refers to no source named %s", file, ref))
+			}
+		}
+	}
+	return nil
+}
+
 // Delete deletes an application
 func (s *Service) Delete(ctx context.Context, id string, cascade bool) error {
 	query := "DELETE FROM applications WHERE id = $1"
@@ -395,38 +548,36 @@ func (s *Service) Delete(ctx context.Context, id string, cascade bool) error {
 	return nil
 }
 
-// Sync triggers a sync for an application
+// Sync syncs an application to a revision, its branch by default,
+// applying the resources that differ from Git and, with prune set,
+// deleting those no longer in Git. A dry run only reports what is out of
+// sync.
 func (s *Service) Sync(ctx context.Context, id string, req *SyncRequest) (*SyncStatus, error) {
 	app, err := s.Get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-
-	// Update sync status
-	now := time.Now()
-	query := `
-		UPDATE applications
-		SET sync_status = 'syncing',
-		    last_sync_at = $2,
-		    updated_at = NOW()
-		WHERE id = $1
-	`
-	s.db.ExecContext(ctx, query, id, now)
-
-	// In a real implementation, this would trigger ArgoCD sync
-	status := &SyncStatus{
-		Status:     "syncing",
-		Message:    "Sync initiated",
-		Revision:   req.Revision,
-		StartedAt:  now,
-	}
-
-	logger.Info("Application sync triggered",
-		zap.String("app_id", id),
-		zap.String("name", app.Name),
-	)
-
-	return status, nil
+	return s.exclusive(ctx, app, func(ctx context.Context) (*SyncStatus, error) {
+		logger.Info("Application sync triggered",
+			zap.String("app_id", id),
+			zap.String("name", app.Name),
+			zap.String("revision", req.Revision),
+		)
+
+		started := time.Now()
+		cmp, err := s.compare(ctx, app, req.Revision)
+		if err != nil {
+			return nil, err
+		}
+		if req.DryRun {
+			status := SyncStatusSynced
+			if !cmp.synced() {
+				status = SyncStatusOutOfSync
+			}
+			return cmp.status(status, "Dry run", started), nil
+		}
+		return s.sync(ctx, app, cmp, req, started)
+	})
 }
 
 // GetStatus returns application status
@@ -440,6 +591,7 @@ func (s *Service) GetStatus(ctx context.Context, id string) (*AppStatus, error)
 		Status:       app.Status,
 		HealthStatus: app.HealthStatus,
 		SyncStatus:   app.SyncStatus,
+		SyncMessage:  app.SyncMessage,
 		LastSyncAt:   app.LastSyncAt,
 	}, nil
 }
@@ -449,6 +601,7 @@ type AppStatus struct {
 	Status       string     `json:"status"`
 	HealthStatus string     `json:"health_status"`
 	SyncStatus   string     `json:"sync_status"`
+	SyncMessage  string     `json:"sync_message,omitempty"`
 	LastSyncAt   *time.Time `json:"last_sync_at"`
 }
 
@@ -494,11 +647,25 @@ type Manifest struct {
 
 // GetDiff returns the diff between live and desired state
 func (s *Service) GetDiff(ctx context.Context, id string) (*Diff, error) {
-	// In a real implementation, this would compute the diff
-	return &Diff{
-		HasDiff: false,
-		Diffs:   []ResourceDiff{},
-	}, nil
+	app, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	cmp, err := s.compare(ctx, app, "")
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{HasDiff: !cmp.synced(), Diffs: []ResourceDiff{}}
+	for _, d := range cmp.desired {
+		if text, ok := cmp.outOfSync[d.ref.key()]; ok {
+			diff.Diffs = append(diff.Diffs, ResourceDiff{Kind: d.ref.Kind, Name: d.ref.Name, Namespace: d.ref.Namespace, Diff: text})
+		}
+	}
+	for _, ref := range cmp.prune {
+		diff.Diffs = append(diff.Diffs, ResourceDiff{Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace, RequiresPruning: true})
+	}
+	return diff, nil
 }
 
 // Diff represents the diff between live and desired state
@@ -509,7 +676,11 @@ type Diff struct {
 
 // ResourceDiff represents a diff for a single resource
 type ResourceDiff struct {
-	Kind string `json:"kind"`
-	Name string `json:"name"`
-	Diff string `json:"diff"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Diff      string `json:"diff"`
+	// RequiresPruning marks a resource the last sync left that is no
+	// longer in Git
+	RequiresPruning bool `json:"requires_pruning,omitempty"`
 }
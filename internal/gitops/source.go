@@ -0,0 +1,607 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anubhavg-icpl/krustron/internal/helm"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// allowedProtocols are the transports git may use, for repositories and
+// their submodules alike; local paths and ext:: commands are refused
+const allowedProtocols = "http:https:ssh"
+
+// Keys read from repository credentials secrets
+const (
+	// secretToken is an access token sent over HTTPS with secretUsername,
+	// which defaults to "git"
+	secretToken    = "token"
+	secretUsername = "username"
+	secretPassword = "password"
+	// secretSSHKey is a private key for SSH URLs, as kubernetes.io/ssh-auth
+	// Secrets hold it
+	secretSSHKey = "ssh-privatekey"
+	// secretKnownHosts verifies SSH hosts; without it the configured known
+	// hosts file is used
+	secretKnownHosts = "known_hosts"
+)
+
+// DesiredState is an application's manifests at a revision
+type DesiredState struct {
+	// Revision is the commit synced, or the commits of every source
+	// separated by commas
+	Revision string
+	Objects  []*unstructured.Unstructured
+}
+
+// Source reads an application's desired state; revision is a branch, tag
+// or commit overriding the first source's, or ""
+type Source interface {
+	Desired(ctx context.Context, app *Application, revision string) (*DesiredState, error)
+}
+
+// SetSource sets where desired state is read from; by default it is
+// fetched from Git into the configured repository cache
+func (s *Service) SetSource(source Source) { s.source = source }
+
+// SecretStore is the secret backend repository credentials are read from.
+// Only a reference to the secret is stored with the application.
+type SecretStore interface {
+	// Get returns the key/value pairs of the secret at ref
+	Get(ctx context.Context, ref string) (map[string][]byte, error)
+}
+
+// SetSecretStore sets where repository credentials are read from
+func (s *Service) SetSecretStore(secrets SecretStore) { s.git.secrets = secrets }
+
+// GitSourceOptions configures a GitSource
+type GitSourceOptions struct {
+	// Dir holds checkouts; defaults to a directory under the system temp dir
+	Dir string
+	// Depth is how many commits are fetched; 0 fetches the full history
+	Depth int
+	// KnownHostsFile verifies SSH hosts when credentials carry no
+	// known_hosts of their own
+	KnownHostsFile string
+	// Credentials are the credential refs repositories may use, each with
+	// the hosts it may be sent to. Applications are created by users, so
+	// any other ref, which could name another team's secret, is refused.
+	Credentials map[string][]string
+}
+
+// GitSource fetches manifests with the git CLI, keeping a checkout per
+// repository and revision under its directory
+type GitSource struct {
+	opts    GitSourceOptions
+	secrets SecretStore
+
+	mu    sync.Mutex
+	repos map[string]*sync.Mutex
+}
+
+// NewGitSource creates a Git source
+func NewGitSource(opts GitSourceOptions) *GitSource {
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join(os.TempDir(), "krustron-repos")
+	}
+	return &GitSource{opts: opts, repos: map[string]*sync.Mutex{}}
+}
+
+// repoLock serializes fetches into one checkout
+func (g *GitSource) repoLock(key string) *sync.Mutex {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	lock, ok := g.repos[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		g.repos[key] = lock
+	}
+	return lock
+}
+
+// checkout is a source fetched at a commit
+type checkout struct {
+	source AppSource
+	dir    string
+	commit string
+}
+
+// Desired fetches every source and reads their manifests in order. A
+// source path holding a Chart.yaml is rendered as a Helm chart with its
+// value files; any other path has its YAML and JSON files decoded in path
+// order, skipping directories starting with a dot. Sources with a ref and
+// no path only provide files to others.
+func (g *GitSource) Desired(ctx context.Context, app *Application, revision string) (*DesiredState, error) {
+	sources := app.SourceList()
+	if app.SourceType != "git" || len(sources) == 0 {
+		return nil, errors.BadRequest(fmt.Sprintf("application %s has no Git repository to sync from", app.Name))
+	}
+	if revision != "" {
+		sources[0].Revision = revision
+	}
+
+	// Lock every checkout up front, in one order, so applications sharing
+	// repositories can't deadlock
+	keys := make([]string, len(sources))
+	for i, src := range sources {
+		keys[i] = checkoutKey(src)
+	}
+	locked := append([]string(nil), keys...)
+	sort.Strings(locked)
+	for i, key := range locked {
+		if i > 0 && key == locked[i-1] {
+			continue
+		}
+		lock := g.repoLock(key)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	checkouts := make([]checkout, len(sources))
+	fetched := map[string]checkout{}
+	refs := map[string]string{}
+	commits := make([]string, len(sources))
+	for i, src := range sources {
+		co, ok := fetched[keys[i]]
+		if !ok {
+			dir, commit, err := g.fetch(ctx, src, keys[i])
+			if err != nil {
+				return nil, err
+			}
+			co = checkout{dir: dir, commit: commit}
+			fetched[keys[i]] = co
+		}
+		co.source = src
+		checkouts[i] = co
+		commits[i] = co.commit
+		if src.Ref != "" {
+			refs[src.Ref] = co.dir
+		}
+	}
+
+	var objects []*unstructured.Unstructured
+	for _, co := range checkouts {
+		if co.source.Ref != "" && co.source.Path == "" {
+			continue
+		}
+		root, err := repoPath(co.dir, co.source.Path)
+		if err != nil {
+			return nil, err
+		}
+		var decoded []*unstructured.Unstructured
+		if _, statErr := os.Stat(filepath.Join(root, "Chart.yaml")); statErr == nil {
+			decoded, err = renderChart(app, co.source, root, refs)
+		} else {
+			decoded, err = readManifests(root)
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, decoded...)
+	}
+	return &DesiredState{Revision: strings.Join(commits, ","), Objects: objects}, nil
+}
+
+// checkoutKey names the checkout of a source's repository at its revision
+func checkoutKey(src AppSource) string {
+	sum := sha256.Sum256([]byte(src.RepoURL + "\x00" + src.Revision))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fetch checks a source's revision out, with its submodules, and returns
+// the checkout and its commit
+func (g *GitSource) fetch(ctx context.Context, src AppSource, key string) (string, string, error) {
+	auth, err := g.auth(ctx, src.RepoURL, src.CredentialsRef)
+	if err != nil {
+		return "", "", err
+	}
+	defer auth.cleanup()
+
+	revision := src.Revision
+	if revision == "" {
+		revision = "HEAD"
+	}
+	dir := filepath.Join(g.opts.Dir, key)
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", "", errors.InternalWrap(err, "failed to create repository checkout")
+		}
+		if _, err := auth.git(ctx, dir, "init", "--quiet"); err != nil {
+			return "", "", err
+		}
+		if _, err := auth.git(ctx, dir, "remote", "add", "origin", src.RepoURL); err != nil {
+			return "", "", err
+		}
+	}
+
+	fetch := []string{"fetch", "--quiet", "--force"}
+	if g.opts.Depth > 0 {
+		fetch = append(fetch, "--depth="+strconv.Itoa(g.opts.Depth))
+	}
+	if _, err := auth.git(ctx, dir, append(fetch, "origin", revision)...); err != nil {
+		return "", "", err
+	}
+	if _, err := auth.git(ctx, dir, "checkout", "--quiet", "--force", "--detach", "FETCH_HEAD"); err != nil {
+		return "", "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".gitmodules")); err == nil {
+		if _, err := auth.git(ctx, dir, "submodule", "--quiet", "sync", "--recursive"); err != nil {
+			return "", "", err
+		}
+		update := []string{"submodule", "--quiet", "update", "--init", "--recursive", "--force"}
+		if g.opts.Depth > 0 {
+			update = append(update, "--depth="+strconv.Itoa(g.opts.Depth))
+		}
+		if _, err := auth.git(ctx, dir, update...); err != nil {
+			return "", "", err
+		}
+	}
+	commit, err := auth.git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	return dir, commit, nil
+}
+
+// Repository is a Git repository and the credentials to reach it
+type Repository struct {
+	URL            string `json:"repo_url" binding:"required"`
+	CredentialsRef string `json:"credentials_ref"`
+}
+
+// RepoAccessRequest asks whether a repository ref can be reached
+type RepoAccessRequest struct {
+	Repository
+	Ref string `json:"ref"`
+}
+
+// RepoAccess is a reachable repository ref
+type RepoAccess struct {
+	URL    string `json:"repo_url"`
+	Ref    string `json:"ref"`
+	Commit string `json:"commit"`
+}
+
+// ValidateRepoAccess checks that repo can be reached with its credentials
+// and has ref, a branch or tag, or HEAD when empty
+func (s *Service) ValidateRepoAccess(ctx context.Context, repo *Repository, ref string) (*RepoAccess, error) {
+	return s.git.ValidateRepoAccess(ctx, repo, ref)
+}
+
+// ValidateRepoAccess checks that repo can be reached with its credentials
+// and has ref, a branch or tag, or HEAD when empty
+func (g *GitSource) ValidateRepoAccess(ctx context.Context, repo *Repository, ref string) (*RepoAccess, error) {
+	auth, err := g.auth(ctx, repo.URL, repo.CredentialsRef)
+	if err != nil {
+		return nil, err
+	}
+	defer auth.cleanup()
+
+	if ref == "" {
+		ref = "HEAD"
+	}
+	out, err := auth.git(ctx, "", "ls-remote", "--quiet", "--exit-code", repo.URL, ref)
+	if err != nil {
+		if errors.Is(err, errors.CodeNotFound) {
+			return nil, errors.NotFoundMsg(fmt.Sprintf("ref %s not found in %s", ref, repo.URL))
+		}
+		return nil, err
+	}
+	commit, _, _ := strings.Cut(out, "\t")
+	return &RepoAccess{URL: repo.URL, Ref: ref, Commit: commit}, nil
+}
+
+// gitAuth runs git with a repository's credentials
+type gitAuth struct {
+	env     []string
+	tempDir string
+}
+
+func (a *gitAuth) cleanup() {
+	if a.tempDir != "" {
+		os.RemoveAll(a.tempDir)
+	}
+}
+
+// auth prepares git's environment for repoURL: an Authorization header
+// scoped to the host for HTTPS, or a key and strict host key checking for
+// SSH. Secrets are passed through the environment and files only the
+// process can read, never on the command line.
+func (g *GitSource) auth(ctx context.Context, repoURL, credentialsRef string) (*gitAuth, error) {
+	scheme, host, err := parseRepoURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	auth := &gitAuth{env: []string{"GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL=" + allowedProtocols}}
+
+	var secret map[string][]byte
+	if credentialsRef != "" {
+		hosts, registered := g.opts.Credentials[credentialsRef]
+		if !registered {
+			return nil, errors.Forbidden(fmt.Sprintf("credentials %s are not registered for repositories", credentialsRef))
+		}
+		if !hostAllowed(hosts, host) {
+			return nil, errors.Forbidden(fmt.Sprintf("credentials %s may not be used for %s", credentialsRef, host))
+		}
+		if g.secrets == nil {
+			return nil, errors.BadRequest("no secret store is configured for repository credentials")
+		}
+		secret, err = g.secrets.Get(ctx, credentialsRef)
+		if err != nil {
+			return nil, errors.BadRequestWrap(err, fmt.Sprintf("failed to read credentials %s", credentialsRef))
+		}
+	}
+
+	if scheme != "ssh" {
+		if secret == nil {
+			return auth, nil
+		}
+		username, password := string(secret[secretUsername]), string(secret[secretPassword])
+		if token := secret[secretToken]; len(token) > 0 {
+			password = string(token)
+			if username == "" {
+				username = "git"
+			}
+		}
+		if username == "" || password == "" {
+			return nil, errors.BadRequest(fmt.Sprintf("credentials %s need a token, or a username and password", credentialsRef))
+		}
+		header := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		auth.env = append(auth.env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http."+scheme+"://"+host+"/.extraHeader",
+			"GIT_CONFIG_VALUE_0="+header,
+		)
+		return auth, nil
+	}
+
+	key := secret[secretSSHKey]
+	if secret != nil && len(key) == 0 {
+		return nil, errors.BadRequest(fmt.Sprintf("credentials %s need an %s for SSH", credentialsRef, secretSSHKey))
+	}
+	knownHosts := secret[secretKnownHosts]
+	if len(knownHosts) == 0 && g.opts.KnownHostsFile != "" {
+		if knownHosts, err = os.ReadFile(g.opts.KnownHostsFile); err != nil {
+			return nil, errors.InternalWrap(err, "failed to read SSH known hosts")
+		}
+	}
+	if len(knownHosts) == 0 {
+		return nil, errors.BadRequest(fmt.Sprintf("no known hosts to verify %s against", host))
+	}
+
+	if auth.tempDir, err = os.MkdirTemp("", "krustron-ssh-"); err != nil {
+		return nil, errors.InternalWrap(err, "failed to prepare SSH credentials")
+	}
+	knownHostsFile := filepath.Join(auth.tempDir, "known_hosts")
+	if err := os.WriteFile(knownHostsFile, knownHosts, 0o600); err != nil {
+		auth.cleanup()
+		return nil, errors.InternalWrap(err, "failed to prepare SSH credentials")
+	}
+	command := []string{"ssh", "-F", "/dev/null", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes",
+		"-o", "UserKnownHostsFile=" + shellQuote(knownHostsFile)}
+	if len(key) > 0 {
+		keyFile := filepath.Join(auth.tempDir, "id")
+		if err := os.WriteFile(keyFile, key, 0o600); err != nil {
+			auth.cleanup()
+			return nil, errors.InternalWrap(err, "failed to prepare SSH credentials")
+		}
+		command = append(command, "-o", "IdentitiesOnly=yes", "-i", shellQuote(keyFile))
+	}
+	auth.env = append(auth.env, "GIT_SSH_COMMAND="+strings.Join(command, " "))
+	return auth, nil
+}
+
+// git runs a git command in dir, turning authentication and host key
+// failures into errors users can act on
+func (a *gitAuth) git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), a.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err == nil {
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	details := strings.TrimSpace(stderr.String())
+	switch {
+	case ctx.Err() != nil:
+		return "", ctx.Err()
+	case strings.Contains(details, "Host key verification failed"):
+		return "", errors.BadRequest("SSH host key verification failed").WithDetails(details)
+	case strings.Contains(details, "Authentication failed"),
+		strings.Contains(details, "could not read Username"),
+		strings.Contains(details, "Permission denied"),
+		strings.Contains(details, "returned error: 401"),
+		strings.Contains(details, "returned error: 403"):
+		return "", errors.Unauthorized("repository authentication failed").WithDetails(details)
+	case args[0] == "ls-remote" && cmd.ProcessState.ExitCode() == 2:
+		// --exit-code found no matching ref
+		return "", errors.NotFoundMsg("ref not found")
+	}
+	return "", errors.BadRequestWrap(err, "git "+args[0]+" failed").WithDetails(details)
+}
+
+// parseRepoURL returns a repository URL's transport and host; scp-like
+// git@host:path URLs are SSH
+func parseRepoURL(repoURL string) (string, string, error) {
+	if !strings.Contains(repoURL, "://") {
+		userHost, path, ok := strings.Cut(repoURL, ":")
+		_, host, _ := strings.Cut(userHost, "@")
+		if ok && host != "" && path != "" && !strings.ContainsAny(userHost, "/\\") {
+			return "ssh", host, nil
+		}
+		return "", "", errors.BadRequest(fmt.Sprintf("repository URL %q is not an HTTPS or SSH URL", repoURL))
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return "", "", errors.BadRequest(fmt.Sprintf("invalid repository URL %q", repoURL))
+	}
+	switch u.Scheme {
+	case "https", "http", "ssh":
+		return u.Scheme, u.Host, nil
+	}
+	return "", "", errors.BadRequest(fmt.Sprintf("repository URL %q is not an HTTPS or SSH URL", repoURL))
+}
+
+// hostAllowed reports whether host, which may carry a port, is one of
+// hosts. Entries without a port match any port.
+func hostAllowed(hosts []string, host string) bool {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	for _, allowed := range hosts {
+		if strings.EqualFold(allowed, host) || strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes a path for GIT_SSH_COMMAND, which runs through a shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// repoPath resolves a source's path inside its checkout
+func repoPath(dir, path string) (string, error) {
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.BadRequest(fmt.Sprintf("path %q is outside the repository", path))
+	}
+	root := filepath.Join(dir, clean)
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		return "", errors.BadRequest(fmt.Sprintf("path %q is not a directory in the repository", path))
+	}
+	return root, nil
+}
+
+// readManifests decodes every manifest file under root
+func readManifests(root string) ([]*unstructured.Unstructured, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.InternalWrap(err, "failed to read repository")
+	}
+	sort.Strings(files)
+
+	var objects []*unstructured.Unstructured
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.InternalWrap(err, "failed to read manifest")
+		}
+		decoded, err := kube.DecodeManifest(data)
+		if err != nil {
+			rel, _ := filepath.Rel(root, file)
+			return nil, errors.ValidationWrap(err, fmt.Sprintf("invalid manifest %s", rel))
+		}
+		objects = append(objects, decoded...)
+	}
+	return objects, nil
+}
+
+// renderChart renders the chart at root with the source's value files,
+// merged in order, then the application's inline values. A value file
+// "$ref/path" is read from the source named ref.
+func renderChart(app *Application, src AppSource, root string, refs map[string]string) ([]*unstructured.Unstructured, error) {
+	chart, err := helm.LoadChartDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	for _, file := range src.ValueFiles {
+		dir, path := root, file
+		if strings.HasPrefix(file, "$") {
+			ref, rest, _ := strings.Cut(file[1:], "/")
+			if dir = refs[ref]; dir == "" {
+				return nil, errors.BadRequest(fmt.Sprintf("value file %s refers to no source named %s", file, ref))
+			}
+			path = rest
+		}
+		clean := filepath.Clean(path)
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, errors.BadRequest(fmt.Sprintf("value file %s is outside its repository", file))
+		}
+		data, err := os.ReadFile(filepath.Join(dir, clean))
+		if err != nil {
+			return nil, errors.BadRequest(fmt.Sprintf("value file %s not found", file))
+		}
+		var fileValues map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, errors.ValidationWrap(err, fmt.Sprintf("invalid value file %s", file))
+		}
+		mergeValues(values, fileValues)
+	}
+	if app.ValuesYAML != "" {
+		var inline map[string]interface{}
+		if err := yaml.Unmarshal([]byte(app.ValuesYAML), &inline); err != nil {
+			return nil, errors.ValidationWrap(err, "invalid application values")
+		}
+		mergeValues(values, inline)
+	}
+
+	rendered, err := helm.RenderChart(chart, values, helm.RenderOptions{
+		ReleaseName: app.Name,
+		Namespace:   app.Namespace,
+		Revision:    1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects, err := kube.DecodeManifest([]byte(rendered.Manifest))
+	if err != nil {
+		return nil, errors.ValidationWrap(err, fmt.Sprintf("chart %s rendered an invalid manifest", chart.FullName()))
+	}
+	return objects, nil
+}
+
+// mergeValues merges src into dst, recursing into maps present in both
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,414 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// mapSecrets is a secret store of fixed secrets
+type mapSecrets map[string]map[string][]byte
+
+func (m mapSecrets) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	data, ok := m[ref]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", ref)
+	}
+	return data, nil
+}
+
+// runGit runs git in dir as a fixed author
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// addRepo publishes a bare repository name.git under root holding files
+// and gitlinks, submodule paths to "url commit", and returns its commit
+func addRepo(t *testing.T, root, name string, files map[string]string, gitlinks map[string]string) string {
+	t.Helper()
+	work := t.TempDir()
+	runGit(t, work, "init", "--quiet", "--initial-branch=main")
+	var modules strings.Builder
+	for path, link := range gitlinks {
+		url, _, _ := strings.Cut(link, " ")
+		fmt.Fprintf(&modules, "[submodule %q]\n\tpath = %s\n\turl = %s\n", path, path, url)
+	}
+	if modules.Len() > 0 {
+		files[".gitmodules"] = modules.String()
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(work, filepath.Dir(path)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(work, path), []byte(content), 0o644))
+	}
+	runGit(t, work, "add", "-A")
+	for path, link := range gitlinks {
+		_, commit, _ := strings.Cut(link, " ")
+		runGit(t, work, "update-index", "--add", "--cacheinfo", "160000,"+commit+","+path)
+	}
+	runGit(t, work, "commit", "--quiet", "-m", "manifests")
+	runGit(t, root, "clone", "--quiet", "--bare", work, name+".git")
+	return runGit(t, work, "rev-parse", "HEAD")
+}
+
+// newGitServer serves the bare repositories under a new root over HTTP,
+// asking for basic auth with token as the password when token is set
+func newGitServer(t *testing.T, token string) (*httptest.Server, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	require.NoError(t, err)
+	root := t.TempDir()
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); token != "" && (!ok || password != token) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, root
+}
+
+// newSSHServer serves git-upload-pack for the bare repositories under root
+// to clients with the authorized key, and returns its address and a
+// known_hosts line for it
+func newSSHServer(t *testing.T, root string, authorized ssh.PublicKey) (string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, cfg, root)
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	knownHosts := fmt.Sprintf("[127.0.0.1]:%d %s", port, ssh.MarshalAuthorizedKey(hostSigner.PublicKey()))
+	return ln.Addr().String(), knownHosts
+}
+
+func serveSSH(conn net.Conn, cfg *ssh.ServerConfig, root string) {
+	defer conn.Close()
+	_, channels, requests, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			var env []string
+			for req := range requests {
+				switch req.Type {
+				case "env":
+					var kv struct{ Name, Value string }
+					ssh.Unmarshal(req.Payload, &kv)
+					env = append(env, kv.Name+"="+kv.Value)
+					req.Reply(true, nil)
+				case "exec":
+					var exe struct{ Command string }
+					ssh.Unmarshal(req.Payload, &exe)
+					program, repo, _ := strings.Cut(exe.Command, " ")
+					if program != "git-upload-pack" {
+						req.Reply(false, nil)
+						return
+					}
+					req.Reply(true, nil)
+					cmd := exec.Command("git", "upload-pack", filepath.Join(root, strings.Trim(repo, "'")))
+					cmd.Env = append(os.Environ(), env...)
+					cmd.Stdout, cmd.Stderr = channel, channel.Stderr()
+					stdin, _ := cmd.StdinPipe()
+					go func() {
+						io.Copy(stdin, channel)
+						stdin.Close()
+					}()
+					status := uint32(0)
+					if err := cmd.Run(); err != nil {
+						status = 1
+					}
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+					return
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
+
+// sshKey returns a new client key as a PEM private key and its public key
+func sshKey(t *testing.T) ([]byte, ssh.PublicKey) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(block), signer.PublicKey()
+}
+
+func TestGitSource(t *testing.T) {
+	server, root := newGitServer(t, "")
+	base := addRepo(t, root, "base", map[string]string{
+		"service.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: web\n",
+	}, nil)
+	addRepo(t, root, "shop", map[string]string{
+		"deploy/a-config.json":  `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings"}}`,
+		"deploy/.hidden/x.yaml": "not: [a manifest",
+		"deploy/README.md":      "docs",
+		"other/bad.yaml":        "kind: Service",
+	}, map[string]string{"deploy/vendor": server.URL + "/base.git " + base})
+	source := NewGitSource(GitSourceOptions{Dir: t.TempDir(), Depth: 1})
+	ctx := context.Background()
+	app := &Application{Name: "shop", SourceType: "git", RepoURL: server.URL + "/shop.git", RepoBranch: "main", RepoPath: "deploy"}
+
+	// Submodules are checked out too
+	desired, err := source.Desired(ctx, app, "")
+	require.NoError(t, err)
+	assert.Len(t, desired.Revision, 40)
+	require.Len(t, desired.Objects, 2)
+	assert.Equal(t, "ConfigMap", desired.Objects[0].GetKind())
+	assert.Equal(t, "Service", desired.Objects[1].GetKind())
+
+	// A pinned commit is fetched into its own checkout
+	again, err := source.Desired(ctx, app, desired.Revision)
+	require.NoError(t, err)
+	assert.Equal(t, desired.Revision, again.Revision)
+
+	app.RepoPath = "other"
+	_, err = source.Desired(ctx, app, "")
+	assert.True(t, errors.Is(err, errors.CodeValidation), err)
+
+	app.RepoPath = "../.."
+	_, err = source.Desired(ctx, app, "")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest), err)
+
+	_, err = source.Desired(ctx, app, "no-such-branch")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest), err)
+
+	app.RepoURL = root + "/shop.git"
+	_, err = source.Desired(ctx, app, "")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest), "local repositories are refused")
+}
+
+func TestGitSourceMultipleSources(t *testing.T) {
+	server, root := newGitServer(t, "")
+	addRepo(t, root, "charts", map[string]string{
+		"web/Chart.yaml":  "apiVersion: v2\nname: web\nversion: 0.1.0\n",
+		"web/values.yaml": "mode: dev\nreplicas: 1\n",
+		"web/templates/config.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Release.Name }}-config
+data:
+  mode: {{ .Values.mode }}
+  replicas: "{{ .Values.replicas }}"
+  region: {{ .Values.region }}
+`,
+	}, nil)
+	addRepo(t, root, "values", map[string]string{
+		"prod/values.yaml": "mode: prod\nreplicas: 3\n",
+	}, nil)
+	source := NewGitSource(GitSourceOptions{Dir: t.TempDir(), Depth: 1})
+
+	app := &Application{
+		Name: "shop", Namespace: "shop", SourceType: "git",
+		Sources: []AppSource{
+			{RepoURL: server.URL + "/charts.git", Path: "web", ValueFiles: []string{"values.yaml", "$values/prod/values.yaml"}},
+			{RepoURL: server.URL + "/values.git", Revision: "main", Ref: "values"},
+		},
+		ValuesYAML: "region: eu-west-1\n",
+	}
+	desired, err := source.Desired(context.Background(), app, "")
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(desired.Revision, ","), 2)
+	require.Len(t, desired.Objects, 1)
+	assert.Equal(t, "shop-config", desired.Objects[0].GetName())
+	assert.Equal(t, map[string]interface{}{"mode": "prod", "replicas": "3", "region": "eu-west-1"}, desired.Objects[0].Object["data"])
+
+	app.Sources[0].ValueFiles = []string{"$missing/values.yaml"}
+	_, err = source.Desired(context.Background(), app, "")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest), err)
+	assert.Error(t, validateSources("", app.Sources))
+}
+
+func TestRepoAccessToken(t *testing.T) {
+	server, root := newGitServer(t, "s3cret")
+	addRepo(t, root, "private", map[string]string{
+		"config.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n",
+	}, nil)
+	local := []string{"127.0.0.1"}
+	source := NewGitSource(GitSourceOptions{Dir: t.TempDir(), Depth: 1, Credentials: map[string][]string{
+		"token": local, "password": local, "wrong": local, "empty": local, "elsewhere": {"github.com"},
+	}})
+	source.secrets = mapSecrets{
+		"token":     {"token": []byte("s3cret")},
+		"elsewhere": {"token": []byte("s3cret")},
+		"team-b":    {"token": []byte("s3cret")},
+		"password":  {"username": []byte("ci"), "password": []byte("s3cret")},
+		"wrong":     {"token": []byte("nope")},
+		"empty":     {},
+	}
+	ctx := context.Background()
+	repoURL := server.URL + "/private.git"
+
+	for _, ref := range []string{"token", "password"} {
+		access, err := source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: ref}, "main")
+		require.NoError(t, err, ref)
+		assert.Len(t, access.Commit, 40)
+	}
+
+	_, err := source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "wrong"}, "")
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized), err)
+	_, err = source.ValidateRepoAccess(ctx, &Repository{URL: repoURL}, "")
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized), err)
+	_, err = source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "empty"}, "")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest), err)
+	_, err = source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "token"}, "no-such-branch")
+	assert.True(t, errors.Is(err, errors.CodeNotFound), err)
+
+	// Only registered credentials are read, and only sent to their hosts
+	_, err = source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "team-b"}, "")
+	assert.True(t, errors.Is(err, errors.CodeForbidden), err)
+	_, err = source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "elsewhere"}, "")
+	assert.True(t, errors.Is(err, errors.CodeForbidden), err)
+
+	desired, err := source.Desired(ctx, &Application{
+		Name: "private", SourceType: "git", RepoURL: repoURL, RepoPath: ".", RepoCredentialsRef: "token",
+	}, "")
+	require.NoError(t, err)
+	require.Len(t, desired.Objects, 1)
+}
+
+func TestHostAllowed(t *testing.T) {
+	assert.True(t, hostAllowed([]string{"github.com"}, "github.com"))
+	assert.True(t, hostAllowed([]string{"GitHub.com"}, "github.com:443"))
+	assert.True(t, hostAllowed([]string{"git.example.com:2222"}, "git.example.com:2222"))
+	assert.False(t, hostAllowed([]string{"git.example.com:2222"}, "git.example.com:22"))
+	assert.False(t, hostAllowed([]string{"github.com"}, "github.com.evil.example"))
+	assert.False(t, hostAllowed(nil, "github.com"))
+}
+
+func TestRepoAccessSSH(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	addRepo(t, root, "private", map[string]string{
+		"config.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n",
+	}, nil)
+	key, public := sshKey(t)
+	otherKey, _ := sshKey(t)
+	addr, knownHosts := newSSHServer(t, root, public)
+	_, otherHost := newSSHServer(t, root, public)
+	otherHost = strings.Replace(otherHost, strings.Fields(otherHost)[0], strings.Fields(knownHosts)[0], 1)
+
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownHosts), 0o600))
+	local := []string{"127.0.0.1"}
+	credentials := map[string][]string{
+		"deploy-key": local, "with-hosts": local, "other-key": local, "spoofed-host": local, "no-key": local,
+	}
+	source := NewGitSource(GitSourceOptions{Dir: t.TempDir(), Depth: 1, KnownHostsFile: knownHostsFile, Credentials: credentials})
+	source.secrets = mapSecrets{
+		"deploy-key":   {"ssh-privatekey": key},
+		"with-hosts":   {"ssh-privatekey": key, "known_hosts": []byte(knownHosts)},
+		"other-key":    {"ssh-privatekey": otherKey},
+		"spoofed-host": {"ssh-privatekey": key, "known_hosts": []byte(otherHost)},
+		"no-key":       {"known_hosts": []byte(knownHosts)},
+	}
+	ctx := context.Background()
+	repoURL := "ssh://git@" + addr + "/private.git"
+
+	for _, ref := range []string{"deploy-key", "with-hosts"} {
+		access, err := source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: ref}, "main")
+		require.NoError(t, err, ref)
+		assert.Len(t, access.Commit, 40)
+	}
+
+	_, err := source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "other-key"}, "")
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized), err)
+	_, err = source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "spoofed-host"}, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host key verification failed")
+	_, err = source.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "no-key"}, "")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest), err)
+
+	// Without known hosts nothing is trusted
+	strict := NewGitSource(GitSourceOptions{Dir: t.TempDir(), Credentials: credentials})
+	strict.secrets = source.secrets
+	_, err = strict.ValidateRepoAccess(ctx, &Repository{URL: repoURL, CredentialsRef: "deploy-key"}, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no known hosts")
+
+	desired, err := source.Desired(ctx, &Application{
+		Name: "private", SourceType: "git", RepoURL: repoURL, RepoPath: ".", RepoCredentialsRef: "deploy-key",
+	}, "")
+	require.NoError(t, err)
+	require.Len(t, desired.Objects, 1)
+}
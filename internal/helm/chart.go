@@ -0,0 +1,206 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// maxChartSize caps the unpacked size of a chart archive
+const maxChartSize = 20 << 20
+
+// ChartMetadata is the contents of a chart's Chart.yaml
+type ChartMetadata struct {
+	APIVersion   string            `json:"apiVersion"`
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	AppVersion   string            `json:"appVersion,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Type         string            `json:"type,omitempty"`
+	KubeVersion  string            `json:"kubeVersion,omitempty"`
+	Dependencies []ChartDependency `json:"dependencies,omitempty"`
+}
+
+// ChartFile is a file of a chart, by its path within the chart
+type ChartFile struct {
+	Name string
+	Data []byte
+}
+
+// ChartPackage is a chart loaded for rendering, with its subcharts
+type ChartPackage struct {
+	Metadata ChartMetadata
+	// Values are the chart's default values, from values.yaml
+	Values map[string]interface{}
+	// Schema is values.schema.json, if the chart has one
+	Schema    []byte
+	Templates []ChartFile
+	// Files are the chart's other files, for .Files in templates
+	Files        []ChartFile
+	Dependencies []*ChartPackage
+}
+
+// FullName returns the chart's name and version, as Helm shows them
+func (c *ChartPackage) FullName() string {
+	return c.Metadata.Name + "-" + c.Metadata.Version
+}
+
+// LoadChartDir loads an unpacked chart from a directory
+func LoadChartDir(dir string) (*ChartPackage, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, errors.BadRequestWrap(err, "failed to read chart")
+	}
+	return loadChartFiles(files)
+}
+
+// LoadChartArchive loads a chart packaged as a .tgz, as `helm package`
+// writes it
+func LoadChartArchive(data []byte) (*ChartPackage, error) {
+	files, err := untarChart(data)
+	if err != nil {
+		return nil, err
+	}
+	return loadChartFiles(files)
+}
+
+// untarChart unpacks an archive, stripping the chart's top directory
+func untarChart(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.BadRequestWrap(err, "chart archive is not gzipped")
+	}
+	defer gz.Close()
+
+	files := map[string][]byte{}
+	var total int64
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.BadRequestWrap(err, "invalid chart archive")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if strings.HasPrefix(name, "../") {
+			return nil, errors.BadRequest("chart archive has a path outside the chart: " + hdr.Name)
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		total += hdr.Size
+		if total > maxChartSize {
+			return nil, errors.BadRequest("chart archive is too large")
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+		if err != nil {
+			return nil, errors.BadRequestWrap(err, "invalid chart archive")
+		}
+		files[parts[1]] = content
+	}
+	return files, nil
+}
+
+// loadChartFiles builds a chart from its files, keyed by path within
+// the chart. Subcharts under charts/ may be directories or archives.
+func loadChartFiles(files map[string][]byte) (*ChartPackage, error) {
+	chartYAML, ok := files["Chart.yaml"]
+	if !ok {
+		return nil, errors.BadRequest("chart has no Chart.yaml")
+	}
+	c := &ChartPackage{Values: map[string]interface{}{}}
+	if err := yaml.Unmarshal(chartYAML, &c.Metadata); err != nil {
+		return nil, errors.BadRequestWrap(err, "invalid Chart.yaml")
+	}
+	if c.Metadata.Name == "" || c.Metadata.Version == "" {
+		return nil, errors.BadRequest("Chart.yaml needs a name and a version")
+	}
+	if values, ok := files["values.yaml"]; ok {
+		if err := yaml.Unmarshal(values, &c.Values); err != nil {
+			return nil, errors.BadRequestWrap(err, "invalid values.yaml")
+		}
+		if c.Values == nil {
+			c.Values = map[string]interface{}{}
+		}
+	}
+	c.Schema = files["values.schema.json"]
+
+	subcharts := map[string]map[string][]byte{}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data := files[name]
+		switch {
+		case name == "Chart.yaml" || name == "values.yaml" || name == "values.schema.json":
+		case strings.HasPrefix(name, "templates/"):
+			c.Templates = append(c.Templates, ChartFile{Name: name, Data: data})
+		case strings.HasPrefix(name, "charts/"):
+			rest := strings.TrimPrefix(name, "charts/")
+			if !strings.Contains(rest, "/") {
+				if strings.HasSuffix(rest, ".tgz") {
+					sub, err := LoadChartArchive(data)
+					if err != nil {
+						return nil, err
+					}
+					c.Dependencies = append(c.Dependencies, sub)
+				}
+				continue
+			}
+			dir, file, _ := strings.Cut(rest, "/")
+			if subcharts[dir] == nil {
+				subcharts[dir] = map[string][]byte{}
+			}
+			subcharts[dir][file] = data
+		default:
+			c.Files = append(c.Files, ChartFile{Name: name, Data: data})
+		}
+	}
+
+	dirs := make([]string, 0, len(subcharts))
+	for dir := range subcharts {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		sub, err := loadChartFiles(subcharts[dir])
+		if err != nil {
+			return nil, err
+		}
+		c.Dependencies = append(c.Dependencies, sub)
+	}
+	return c, nil
+}
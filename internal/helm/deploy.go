@@ -0,0 +1,361 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Hook phases, from the helm.sh/hook annotation
+const (
+	HookPreInstall   = "pre-install"
+	HookPostInstall  = "post-install"
+	HookPreUpgrade   = "pre-upgrade"
+	HookPostUpgrade  = "post-upgrade"
+	HookPreRollback  = "pre-rollback"
+	HookPostRollback = "post-rollback"
+)
+
+// Hook outcomes
+const (
+	HookSucceeded = "succeeded"
+	HookFailed    = "failed"
+	// HookSkipped hooks were not run: hooks were disabled, an earlier
+	// hook failed, or there is no cluster to run them on
+	HookSkipped = "skipped"
+)
+
+// Hook annotations
+const (
+	hookAnnotation       = "helm.sh/hook"
+	hookWeightAnnotation = "helm.sh/hook-weight"
+)
+
+// defaultDeployTimeout bounds hooks and waiting when a request sets no
+// timeout, as Helm's --timeout does
+const defaultDeployTimeout = 5 * time.Minute
+
+// readyPollInterval is how often Wait checks whether workloads are ready
+var readyPollInterval = 2 * time.Second
+
+// HookResult is what running one hook did
+type HookResult struct {
+	Phase   string `json:"phase"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Weight  int    `json:"weight"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Deployer applies release manifests to clusters. Hooks are applied and
+// waited on like any other objects, so a hook Job is done once it
+// completes.
+type Deployer interface {
+	// Apply applies objects to a cluster; namespaced objects without a
+	// namespace go in namespace
+	Apply(ctx context.Context, clusterID, namespace string, objects []*unstructured.Unstructured) error
+	// Wait blocks until objects are ready, a Job has failed, or ctx is
+	// done
+	Wait(ctx context.Context, clusterID, namespace string, objects []*unstructured.Unstructured) error
+}
+
+// SetDeployer sets how releases are applied to clusters. By default they
+// are applied through the service's Kubernetes clients; with no clients,
+// releases are only recorded.
+func (s *Service) SetDeployer(d Deployer) { s.deployer = d }
+
+// releaseHook is a hook object from a release's manifest
+type releaseHook struct {
+	object *unstructured.Unstructured
+	phases []string
+	weight int
+}
+
+func (h *releaseHook) runsIn(phase string) bool {
+	for _, p := range h.phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHooks decodes a manifest into its hooks and the objects it
+// deploys
+func splitHooks(manifest string) ([]*unstructured.Unstructured, []releaseHook, error) {
+	objects, err := kube.DecodeManifest([]byte(manifest))
+	if err != nil {
+		return nil, nil, err
+	}
+	var resources []*unstructured.Unstructured
+	var hooks []releaseHook
+	for _, obj := range objects {
+		phases := obj.GetAnnotations()[hookAnnotation]
+		if phases == "" {
+			resources = append(resources, obj)
+			continue
+		}
+		h := releaseHook{object: obj}
+		for _, p := range strings.Split(phases, ",") {
+			h.phases = append(h.phases, strings.TrimSpace(p))
+		}
+		if w := obj.GetAnnotations()[hookWeightAnnotation]; w != "" {
+			h.weight, _ = strconv.Atoi(strings.TrimSpace(w))
+		}
+		hooks = append(hooks, h)
+	}
+	// Hooks run lowest weight first, then by kind and name, as in Helm
+	sort.SliceStable(hooks, func(i, j int) bool {
+		a, b := hooks[i], hooks[j]
+		if a.weight != b.weight {
+			return a.weight < b.weight
+		}
+		if a.object.GetKind() != b.object.GetKind() {
+			return a.object.GetKind() < b.object.GetKind()
+		}
+		return a.object.GetName() < b.object.GetName()
+	})
+	return resources, hooks, nil
+}
+
+// deployOptions configures deploying a release manifest
+type deployOptions struct {
+	// prePhase and postPhase are the hooks run around applying
+	prePhase, postPhase string
+	disableHooks        bool
+	wait                bool
+	timeout             time.Duration
+}
+
+// deploy applies a release manifest to its cluster with the hooks of
+// opts' phases around it, waiting for workloads if asked. It returns the
+// hooks it ran, or would have; after a failure the rest are skipped.
+func (s *Service) deploy(ctx context.Context, clusterID, namespace, manifest string, opts deployOptions) ([]HookResult, error) {
+	resources, hooks, err := splitHooks(manifest)
+	if err != nil {
+		return nil, errors.HelmWrap(err, "release has an invalid manifest")
+	}
+	// run[i] is the hook results[i] is for
+	results := []HookResult{}
+	var run []int
+	for _, phase := range []string{opts.prePhase, opts.postPhase} {
+		for i := range hooks {
+			if !hooks[i].runsIn(phase) {
+				continue
+			}
+			results = append(results, HookResult{
+				Phase:  phase,
+				Kind:   hooks[i].object.GetKind(),
+				Name:   hooks[i].object.GetName(),
+				Weight: hooks[i].weight,
+				Status: HookSkipped,
+			})
+			run = append(run, i)
+		}
+	}
+
+	d := s.clusterDeployer()
+	if d == nil {
+		return results, nil
+	}
+	timeout := opts.timeout
+	if timeout <= 0 {
+		timeout = defaultDeployTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	runHooks := func(phase string) error {
+		if opts.disableHooks {
+			return nil
+		}
+		for i := range results {
+			if results[i].Phase != phase {
+				continue
+			}
+			hook := []*unstructured.Unstructured{hooks[run[i]].object}
+			err := d.Apply(ctx, clusterID, namespace, hook)
+			if err == nil {
+				err = d.Wait(ctx, clusterID, namespace, hook)
+			}
+			if err != nil {
+				results[i].Status = HookFailed
+				results[i].Message = err.Error()
+				return errors.HelmWrap(err, fmt.Sprintf("%s hook %s/%s failed", phase, results[i].Kind, results[i].Name)).
+					WithDetails(err.Error())
+			}
+			results[i].Status = HookSucceeded
+		}
+		return nil
+	}
+
+	if err := runHooks(opts.prePhase); err != nil {
+		return results, err
+	}
+	if err := d.Apply(ctx, clusterID, namespace, resources); err != nil {
+		return results, errors.HelmWrap(err, "failed to apply release").WithDetails(err.Error())
+	}
+	if opts.wait {
+		if err := d.Wait(ctx, clusterID, namespace, resources); err != nil {
+			return results, errors.HelmWrap(err, "release did not become ready").WithDetails(err.Error())
+		}
+	}
+	return results, runHooks(opts.postPhase)
+}
+
+// clusterDeployer is the deployer releases are applied with, if any
+func (s *Service) clusterDeployer() Deployer {
+	if s.deployer != nil {
+		return s.deployer
+	}
+	if s.kubeManager != nil {
+		return &kubeDeployer{s: s}
+	}
+	return nil
+}
+
+// kubeDeployer applies releases with the service's Kubernetes clients
+type kubeDeployer struct {
+	s *Service
+}
+
+func (d *kubeDeployer) client(ctx context.Context, clusterID string) (*kube.ClusterClient, error) {
+	var name string
+	if err := d.s.db.QueryRowContext(ctx, "SELECT name FROM clusters WHERE id = $1", clusterID).Scan(&name); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to find release cluster")
+	}
+	client, err := d.s.kubeManager.GetClient(name)
+	if err != nil {
+		return nil, errors.KubernetesWrap(err, "cluster is not connected")
+	}
+	return client, nil
+}
+
+// Apply server-side applies objects in dependency order
+func (d *kubeDeployer) Apply(ctx context.Context, clusterID, namespace string, objects []*unstructured.Unstructured) error {
+	client, err := d.client(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	sorted := append([]*unstructured.Unstructured(nil), objects...)
+	kube.SortForApply(sorted)
+	for _, obj := range sorted {
+		res, err := client.ResourceFor(obj.GroupVersionKind())
+		if err != nil {
+			return err
+		}
+		if res.Namespaced && obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		}
+		if _, err := client.ApplyObject(ctx, res, obj, kube.ApplyOptions{Force: true}); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// Wait polls objects until each is ready
+func (d *kubeDeployer) Wait(ctx context.Context, clusterID, namespace string, objects []*unstructured.Unstructured) error {
+	client, err := d.client(ctx, clusterID)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if _, ok := readinessChecks[obj.GetKind()]; !ok {
+			continue
+		}
+		res, err := client.ResourceFor(obj.GroupVersionKind())
+		if err != nil {
+			return err
+		}
+		ns := obj.GetNamespace()
+		if ns == "" {
+			ns = namespace
+		}
+		for {
+			live, err := client.GetObject(ctx, res, ns, obj.GetName())
+			if err != nil {
+				return fmt.Errorf("failed to get %s %s: %w", obj.GetKind(), obj.GetName(), err)
+			}
+			ready, err := objectReady(live)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", obj.GetKind(), obj.GetName(), err)
+			}
+			if ready {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("timed out waiting for %s %s", obj.GetKind(), obj.GetName())
+			case <-time.After(readyPollInterval):
+			}
+		}
+	}
+	return nil
+}
+
+// readinessChecks say whether a workload is ready from its status. Kinds
+// without one are ready once applied.
+var readinessChecks = map[string]func(obj map[string]interface{}) (bool, error){
+	"Deployment":  rolledOut("updatedReplicas", "availableReplicas"),
+	"StatefulSet": rolledOut("updatedReplicas", "readyReplicas"),
+	"DaemonSet": func(obj map[string]interface{}) (bool, error) {
+		desired, _, _ := unstructured.NestedInt64(obj, "status", "desiredNumberScheduled")
+		updated, _, _ := unstructured.NestedInt64(obj, "status", "updatedNumberScheduled")
+		available, _, _ := unstructured.NestedInt64(obj, "status", "numberAvailable")
+		return observed(obj) && updated == desired && available == desired, nil
+	},
+	"Job": func(obj map[string]interface{}) (bool, error) {
+		conditions, _, _ := unstructured.NestedSlice(obj, "status", "conditions")
+		for _, c := range conditions {
+			cond, _ := c.(map[string]interface{})
+			if cond["status"] != "True" {
+				continue
+			}
+			switch cond["type"] {
+			case "Complete":
+				return true, nil
+			case "Failed":
+				msg, _ := cond["message"].(string)
+				return false, fmt.Errorf("job failed: %s", msg)
+			}
+		}
+		return false, nil
+	},
+}
+
+func objectReady(obj *unstructured.Unstructured) (bool, error) {
+	check, ok := readinessChecks[obj.GetKind()]
+	if !ok {
+		return true, nil
+	}
+	return check(obj.Object)
+}
+
+// observed reports whether the controller has seen the latest spec
+func observed(obj map[string]interface{}) bool {
+	generation, _, _ := unstructured.NestedInt64(obj, "metadata", "generation")
+	seen, _, _ := unstructured.NestedInt64(obj, "status", "observedGeneration")
+	return seen >= generation
+}
+
+// rolledOut checks that every replica is updated and ready
+func rolledOut(updatedField, readyField string) func(map[string]interface{}) (bool, error) {
+	return func(obj map[string]interface{}) (bool, error) {
+		replicas, found, _ := unstructured.NestedInt64(obj, "spec", "replicas")
+		if !found {
+			replicas = 1
+		}
+		updated, _, _ := unstructured.NestedInt64(obj, "status", updatedField)
+		ready, _, _ := unstructured.NestedInt64(obj, "status", readyField)
+		return observed(obj) && updated == replicas && ready == replicas, nil
+	}
+}
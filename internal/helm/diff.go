@@ -0,0 +1,240 @@
+package helm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Resource change types
+const (
+	ChangeAdded   = "added"
+	ChangeChanged = "changed"
+	ChangeRemoved = "removed"
+)
+
+// redacted replaces Secret data in diffs
+const redacted = "(redacted)"
+
+// ResourceDiff is how an upgrade changes one resource
+type ResourceDiff struct {
+	APIVersion string        `json:"api_version"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Name       string        `json:"name"`
+	Change     string        `json:"change"`
+	Fields     []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a changed field, by its path in the resource, e.g.
+// "spec.template.spec.containers[0].image". Old is nil for added fields,
+// New for removed ones.
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// manifestResource is a resource parsed from a manifest
+type manifestResource struct {
+	apiVersion, kind, namespace, name string
+	object                            map[string]interface{}
+}
+
+// DiffManifests compares the resources of two manifests, as helm-diff
+// does. Resources without a namespace are taken to be in namespace.
+// Secret values are redacted.
+func DiffManifests(current, proposed, namespace string) ([]ResourceDiff, error) {
+	before, err := parseManifest(current, namespace)
+	if err != nil {
+		return nil, err
+	}
+	after, err := parseManifest(proposed, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool, len(before)+len(after))
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	diffs := []ResourceDiff{}
+	for _, key := range sorted {
+		old, hadOld := before[key]
+		cur, hasNew := after[key]
+		switch {
+		case !hadOld:
+			diffs = append(diffs, resourceDiff(cur, ChangeAdded, nil))
+		case !hasNew:
+			diffs = append(diffs, resourceDiff(old, ChangeRemoved, nil))
+		default:
+			var fields []FieldChange
+			diffValues("", old.object, cur.object, &fields)
+			if len(fields) > 0 {
+				if cur.kind == "Secret" {
+					redactSecretFields(fields)
+				}
+				diffs = append(diffs, resourceDiff(cur, ChangeChanged, fields))
+			}
+		}
+	}
+	return diffs, nil
+}
+
+func resourceDiff(r manifestResource, change string, fields []FieldChange) ResourceDiff {
+	return ResourceDiff{
+		APIVersion: r.apiVersion,
+		Kind:       r.kind,
+		Namespace:  r.namespace,
+		Name:       r.name,
+		Change:     change,
+		Fields:     fields,
+	}
+}
+
+// parseManifest indexes a manifest's resources by API group, kind,
+// namespace and name
+func parseManifest(manifest, namespace string) (map[string]manifestResource, error) {
+	resources := map[string]manifestResource{}
+	for _, doc := range documentSeparator.Split(manifest, -1) {
+		if isBlankDocument(doc) {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, fmt.Errorf("invalid manifest: %w", err)
+		}
+		r := manifestResource{object: obj, namespace: namespace}
+		r.apiVersion, _ = obj["apiVersion"].(string)
+		r.kind, _ = obj["kind"].(string)
+		if meta, ok := obj["metadata"].(map[string]interface{}); ok {
+			r.name, _ = meta["name"].(string)
+			if ns, _ := meta["namespace"].(string); ns != "" {
+				r.namespace = ns
+			}
+		}
+		if r.kind == "" || r.name == "" {
+			continue
+		}
+		// Moving between versions of an API is not a new resource
+		group, _, _ := strings.Cut(r.apiVersion, "/")
+		if !strings.Contains(r.apiVersion, "/") {
+			group = ""
+		}
+		resources[strings.Join([]string{group, r.kind, r.namespace, r.name}, "/")] = r
+	}
+	return resources, nil
+}
+
+// diffValues appends the differences between two decoded values. Lists
+// are compared element by element.
+func diffValues(path string, old, cur interface{}, changes *[]FieldChange) {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	curMap, curIsMap := cur.(map[string]interface{})
+	if oldIsMap && curIsMap {
+		keys := make([]string, 0, len(oldMap)+len(curMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range curMap {
+			if _, ok := oldMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			diffValues(childPath, oldMap[k], curMap[k], changes)
+		}
+		return
+	}
+
+	oldList, oldIsList := old.([]interface{})
+	curList, curIsList := cur.([]interface{})
+	if oldIsList && curIsList {
+		n := len(oldList)
+		if len(curList) > n {
+			n = len(curList)
+		}
+		for i := 0; i < n; i++ {
+			var o, c interface{}
+			if i < len(oldList) {
+				o = oldList[i]
+			}
+			if i < len(curList) {
+				c = curList[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), o, c, changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, cur) {
+		*changes = append(*changes, FieldChange{Path: path, Old: old, New: cur})
+	}
+}
+
+// redactManifestSecrets returns manifest with the values of its Secrets
+// hidden, keeping their keys and each document's leading comments
+func redactManifestSecrets(manifest string) string {
+	docs := documentSeparator.Split(manifest, -1)
+	for i, doc := range docs {
+		var obj map[string]interface{}
+		if isBlankDocument(doc) || yaml.Unmarshal([]byte(doc), &obj) != nil {
+			continue
+		}
+		if kind, _ := obj["kind"].(string); kind != "Secret" {
+			continue
+		}
+		for _, field := range []string{"data", "stringData"} {
+			values, _ := obj[field].(map[string]interface{})
+			for key := range values {
+				values[key] = redacted
+			}
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			continue
+		}
+		var comments strings.Builder
+		for _, line := range strings.Split(strings.TrimLeft(doc, "\n"), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+				break
+			}
+			comments.WriteString(line + "\n")
+		}
+		docs[i] = "\n" + comments.String() + string(data)
+	}
+	return strings.Join(docs, "---")
+}
+
+// redactSecretFields hides Secret values, keeping which keys changed
+func redactSecretFields(fields []FieldChange) {
+	for i := range fields {
+		top, _, _ := strings.Cut(fields[i].Path, ".")
+		if top != "data" && top != "stringData" {
+			continue
+		}
+		if fields[i].Old != nil {
+			fields[i].Old = redacted
+		}
+		if fields[i].New != nil {
+			fields[i].New = redacted
+		}
+	}
+}
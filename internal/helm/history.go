@@ -0,0 +1,315 @@
+package helm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Release and revision statuses
+const (
+	StatusDeployed = "deployed"
+	// StatusSuperseded revisions were deployed before the current one
+	StatusSuperseded = "superseded"
+	StatusFailed     = "failed"
+)
+
+// Deployment event types published for releases
+const (
+	EventRollback       = "helm_rollback"
+	EventRollbackFailed = "helm_rollback_failed"
+)
+
+// EventPublisher publishes deployment events; *nats.EventBus implements it
+type EventPublisher interface {
+	EmitDeploymentEvent(ctx context.Context, eventType, deploymentID, environment string, data interface{}) error
+}
+
+// SetEventBus sets where release events are published
+func (s *Service) SetEventBus(events EventPublisher) { s.events = events }
+
+// RollbackRequest contains rollback data
+type RollbackRequest struct {
+	ClusterID string `json:"-"`
+	Namespace string `json:"-"`
+	Name      string `json:"-"`
+	// Revision is the revision to roll back to; 0 is the one before the
+	// current revision
+	Revision int  `json:"revision" binding:"gte=0"`
+	Wait     bool `json:"wait"`
+	// Timeout is in seconds and bounds hooks and waiting; defaults to 5
+	// minutes
+	Timeout      int  `json:"timeout"`
+	DisableHooks bool `json:"disable_hooks"`
+}
+
+// RollbackResult is the outcome of a rollback
+type RollbackResult struct {
+	Release *Release `json:"release"`
+	// RolledBackTo is the revision whose chart, values and manifest the
+	// new revision restores
+	RolledBackTo int          `json:"rolled_back_to"`
+	Hooks        []HookResult `json:"hooks"`
+}
+
+// revision is one stored revision of a release
+type revision struct {
+	Number       int
+	ChartName    string
+	ChartVersion string
+	AppVersion   string
+	ValuesYAML   string
+	Manifest     string
+	Notes        string
+	Status       string
+	Description  string
+	DeployedAt   time.Time
+}
+
+// ListHistory returns a release's revisions, oldest first. Releases
+// recorded before revisions were kept list only their current one.
+func (s *Service) ListHistory(ctx context.Context, clusterID, namespace, name string) ([]ReleaseHistory, error) {
+	release, err := s.GetRelease(ctx, clusterID, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT revision, chart_name, chart_version, COALESCE(app_version, ''), status,
+		       COALESCE(description, ''), deployed_at
+		FROM helm_release_revisions
+		WHERE release_id = $1
+		ORDER BY revision
+	`, release.ID)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to query release history")
+	}
+	defer rows.Close()
+
+	history := []ReleaseHistory{}
+	for rows.Next() {
+		var h ReleaseHistory
+		var chartName string
+		if err := rows.Scan(&h.Revision, &chartName, &h.ChartVersion, &h.AppVersion, &h.Status,
+			&h.Description, &h.UpdatedAt); err != nil {
+			return nil, errors.DatabaseWrap(err, "failed to scan release revision")
+		}
+		h.Chart = chartName + "-" + h.ChartVersion
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to read release history")
+	}
+
+	if len(history) == 0 {
+		history = append(history, ReleaseHistory{
+			Revision:     release.Revision,
+			Status:       release.Status,
+			Chart:        release.ChartName + "-" + release.ChartVersion,
+			ChartVersion: release.ChartVersion,
+			AppVersion:   release.AppVersion,
+			Description:  "Recorded before revision history",
+			UpdatedAt:    release.LastDeployed,
+		})
+	}
+	return history, nil
+}
+
+// Rollback rolls a release back to an earlier revision. Like Helm, it
+// deploys that revision's chart, values and manifest as a new revision,
+// running the manifest's pre-rollback and post-rollback hooks around it.
+// A failed rollback is recorded as a failed revision, and the error names
+// the hook or step that failed. Either way a deployment event is
+// published.
+func (s *Service) Rollback(ctx context.Context, req *RollbackRequest) (*RollbackResult, error) {
+	release, err := s.GetRelease(ctx, req.ClusterID, req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	target := req.Revision
+	if target == 0 {
+		target = release.Revision - 1
+	}
+	if target < 1 || target >= release.Revision {
+		return nil, errors.Validation(fmt.Sprintf("release %s can only be rolled back to a revision between 1 and %d", release.Name, release.Revision-1))
+	}
+	previous, err := s.getRevision(ctx, release.ID, target)
+	if err != nil {
+		return nil, err
+	}
+
+	next := *previous
+	next.Number = release.Revision + 1
+	next.Status = StatusDeployed
+	next.Description = fmt.Sprintf("Rollback to %d", target)
+	next.DeployedAt = time.Now()
+
+	hooks, deployErr := s.deploy(ctx, release.ClusterID, release.Namespace, next.Manifest, deployOptions{
+		prePhase:     HookPreRollback,
+		postPhase:    HookPostRollback,
+		disableHooks: req.DisableHooks,
+		wait:         req.Wait,
+		timeout:      time.Duration(req.Timeout) * time.Second,
+	})
+	if deployErr != nil {
+		next.Status = StatusFailed
+		next.Description = fmt.Sprintf("Rollback to %d failed: %s", target, errors.ToAppError(deployErr).Message)
+	}
+	if err := s.commitRevision(ctx, release, &next); err != nil {
+		return nil, err
+	}
+	result := &RollbackResult{Release: release, RolledBackTo: target, Hooks: hooks}
+
+	eventType := EventRollback
+	if deployErr != nil {
+		eventType = EventRollbackFailed
+	}
+	s.emitDeploymentEvent(ctx, eventType, release, map[string]interface{}{
+		"release":        release.Name,
+		"cluster_id":     release.ClusterID,
+		"namespace":      release.Namespace,
+		"revision":       next.Number,
+		"rolled_back_to": target,
+		"chart":          next.ChartName + "-" + next.ChartVersion,
+		"status":         next.Status,
+		"hooks":          hooks,
+	})
+
+	if deployErr != nil {
+		logger.Warn("Helm release rollback failed",
+			zap.String("name", release.Name),
+			zap.Int("revision", target),
+			zap.Error(deployErr),
+		)
+		return result, deployErr
+	}
+	logger.Info("Helm release rolled back",
+		zap.String("name", release.Name),
+		zap.Int("revision", target),
+	)
+	return result, nil
+}
+
+// getRevision returns one stored revision of a release
+func (s *Service) getRevision(ctx context.Context, releaseID string, number int) (*revision, error) {
+	r := revision{Number: number}
+	var appVersion, valuesYAML, manifest, notes sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT chart_name, chart_version, app_version, values_yaml, manifest, notes, status
+		FROM helm_release_revisions
+		WHERE release_id = $1 AND revision = $2
+	`, releaseID, number).Scan(&r.ChartName, &r.ChartVersion, &appVersion, &valuesYAML, &manifest, &notes, &r.Status)
+	if err == sql.ErrNoRows {
+		return nil, errors.NotFoundMsg(fmt.Sprintf("revision %d not found", number))
+	}
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to get release revision")
+	}
+	r.AppVersion = appVersion.String
+	r.ValuesYAML = valuesYAML.String
+	r.Manifest = manifest.String
+	r.Notes = notes.String
+	return &r, nil
+}
+
+// commitRevision records rev as a release's newest revision and makes it
+// current, superseding the revision deployed before it. release is
+// updated to match. It fails with a conflict if the release got another
+// revision in the meantime.
+func (s *Service) commitRevision(ctx context.Context, release *Release, rev *revision) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // no-op after Commit
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE helm_releases
+		SET chart_name = $3, chart_version = $4, app_version = $5, values_yaml = $6,
+		    manifest = $7, notes = $8, status = $9, revision = $10,
+		    last_deployed = $11, updated_at = $11
+		WHERE id = $1 AND revision = $2
+	`, release.ID, release.Revision, rev.ChartName, rev.ChartVersion, rev.AppVersion, rev.ValuesYAML,
+		rev.Manifest, rev.Notes, rev.Status, rev.Number, rev.DeployedAt)
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to update release")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.Conflict("release was changed by another operation; retry")
+	}
+
+	if rev.Status == StatusDeployed {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE helm_release_revisions SET status = $2
+			WHERE release_id = $1 AND status = $3
+		`, release.ID, StatusSuperseded, StatusDeployed); err != nil {
+			return errors.DatabaseWrap(err, "failed to supersede release revisions")
+		}
+	}
+	if err := insertRevision(ctx, tx, release.ID, rev); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.DatabaseWrap(err, "failed to commit release revision")
+	}
+
+	release.ChartName = rev.ChartName
+	release.ChartVersion = rev.ChartVersion
+	release.AppVersion = rev.AppVersion
+	release.ValuesYAML = rev.ValuesYAML
+	release.Manifest = rev.Manifest
+	release.Notes = rev.Notes
+	release.Status = rev.Status
+	release.Revision = rev.Number
+	release.LastDeployed = rev.DeployedAt
+	release.UpdatedAt = rev.DeployedAt
+	return nil
+}
+
+// execer is a database handle or transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertRevision(ctx context.Context, db execer, releaseID string, rev *revision) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO helm_release_revisions (release_id, revision, chart_name, chart_version,
+		                                    app_version, values_yaml, manifest, notes, status,
+		                                    description, deployed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, releaseID, rev.Number, rev.ChartName, rev.ChartVersion, rev.AppVersion, rev.ValuesYAML,
+		rev.Manifest, rev.Notes, rev.Status, rev.Description, rev.DeployedAt)
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to record release revision")
+	}
+	return nil
+}
+
+// emitDeploymentEvent publishes a release event, if there is an event bus
+func (s *Service) emitDeploymentEvent(ctx context.Context, eventType string, release *Release, data map[string]interface{}) {
+	if s.events == nil {
+		return
+	}
+	if err := s.events.EmitDeploymentEvent(ctx, eventType, release.ID, release.Namespace, data); err != nil {
+		logger.Warn("Failed to emit Helm release event",
+			zap.String("name", release.Name),
+			zap.String("type", eventType),
+			zap.Error(err),
+		)
+	}
+}
+
+// valuesJSON stores values the way releases keep them
+func valuesJSON(values map[string]interface{}) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
@@ -0,0 +1,178 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeDeployer records what it applies and fails the objects in fail
+type fakeDeployer struct {
+	applied []string
+	waited  []string
+	fail    map[string]bool
+}
+
+func (d *fakeDeployer) Apply(ctx context.Context, clusterID, namespace string, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		d.applied = append(d.applied, obj.GetKind()+"/"+obj.GetName())
+	}
+	return nil
+}
+
+func (d *fakeDeployer) Wait(ctx context.Context, clusterID, namespace string, objects []*unstructured.Unstructured) error {
+	for _, obj := range objects {
+		d.waited = append(d.waited, obj.GetKind()+"/"+obj.GetName())
+		if d.fail[obj.GetName()] {
+			return fmt.Errorf("job failed: BackoffLimitExceeded")
+		}
+	}
+	return nil
+}
+
+// fakeEvents records published deployment events
+type fakeEvents struct {
+	types []string
+	data  []map[string]interface{}
+}
+
+func (e *fakeEvents) EmitDeploymentEvent(ctx context.Context, eventType, deploymentID, environment string, data interface{}) error {
+	e.types = append(e.types, eventType)
+	e.data = append(e.data, data.(map[string]interface{}))
+	return nil
+}
+
+func historyStatuses(history []ReleaseHistory) []string {
+	statuses := make([]string, len(history))
+	for i, h := range history {
+		statuses[i] = fmt.Sprintf("%d %s %s", h.Revision, h.Status, h.Description)
+	}
+	return statuses
+}
+
+func TestInstallUpgradeRollback(t *testing.T) {
+	svc := newTestService(t)
+	deployer := &fakeDeployer{fail: map[string]bool{}}
+	events := &fakeEvents{}
+	svc.SetDeployer(deployer)
+	svc.SetEventBus(events)
+	ctx := context.Background()
+	chart := loadTestChart(t)
+
+	installed, err := svc.Install(ctx, &InstallRequest{
+		Name:      "shop",
+		ClusterID: testClusterID,
+		Namespace: "apps",
+		ChartRepo: "local",
+		ChartName: "web",
+		Values:    map[string]interface{}{"replicaCount": 3},
+		Chart:     chart,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, installed.Revision)
+	assert.Equal(t, "2.4.1", installed.AppVersion)
+	assert.Contains(t, deployer.applied, "Job/shop-web-smoke-test")
+	assert.NotContains(t, deployer.applied, "Job/shop-web-migrate")
+
+	upgraded, err := svc.Upgrade(ctx, &UpgradeRequest{
+		ClusterID:   testClusterID,
+		Namespace:   "apps",
+		Name:        "shop",
+		ReuseValues: true,
+		ValuesYAML:  "image:\n  tag: \"1.26\"\n",
+		Chart:       chart,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, upgraded.Revision)
+	assert.Contains(t, upgraded.Manifest, `image: "nginx:1.26"`)
+	assert.Contains(t, upgraded.Manifest, "replicas: 3")
+
+	// Revision 0 rolls back to the one before the current revision
+	deployer.applied = nil
+	result, err := svc.Rollback(ctx, &RollbackRequest{
+		ClusterID: testClusterID, Namespace: "apps", Name: "shop", Wait: true, Timeout: 30,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.RolledBackTo)
+	assert.Equal(t, 3, result.Release.Revision)
+	assert.Equal(t, installed.Manifest, result.Release.Manifest)
+	assert.Equal(t, []HookResult{
+		{Phase: HookPreRollback, Kind: "Job", Name: "shop-web-migrate", Weight: -5, Status: HookSucceeded},
+		{Phase: HookPostRollback, Kind: "Job", Name: "shop-web-smoke-test", Status: HookSucceeded},
+	}, result.Hooks)
+	assert.Equal(t, "Job/shop-web-migrate", deployer.applied[0], "pre-rollback hooks run first")
+	assert.Equal(t, "Job/shop-web-smoke-test", deployer.applied[len(deployer.applied)-1])
+	assert.Contains(t, deployer.waited, "Deployment/shop-web")
+
+	release, err := svc.GetRelease(ctx, testClusterID, "apps", "shop")
+	require.NoError(t, err)
+	assert.Equal(t, 3, release.Revision)
+	assert.Equal(t, StatusDeployed, release.Status)
+	assert.Contains(t, release.Manifest, `image: "nginx:1.25"`)
+	values, err := svc.GetValues(ctx, testClusterID, "apps", "shop", false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"replicaCount": float64(3)}, values)
+
+	require.Equal(t, []string{EventRollback}, events.types)
+	assert.Equal(t, 3, events.data[0]["revision"])
+	assert.Equal(t, 1, events.data[0]["rolled_back_to"])
+
+	// A failing hook fails the rollback, is recorded, and skips the rest
+	deployer.fail["shop-web-migrate"] = true
+	result, err = svc.Rollback(ctx, &RollbackRequest{ClusterID: testClusterID, Namespace: "apps", Name: "shop", Revision: 2})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errors.CodeHelm))
+	assert.Contains(t, err.Error(), "pre-rollback hook Job/shop-web-migrate failed")
+	assert.Equal(t, HookFailed, result.Hooks[0].Status)
+	assert.Contains(t, result.Hooks[0].Message, "BackoffLimitExceeded")
+	assert.Equal(t, HookSkipped, result.Hooks[1].Status)
+	assert.Equal(t, EventRollbackFailed, events.types[1])
+
+	history, err := svc.ListHistory(ctx, testClusterID, "apps", "shop")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"1 superseded Install complete",
+		"2 superseded Upgrade complete",
+		"3 deployed Rollback to 1",
+		"4 failed Rollback to 2 failed: pre-rollback hook Job/shop-web-migrate failed",
+	}, historyStatuses(history))
+	assert.Equal(t, "web-1.2.0", history[0].Chart)
+	assert.Equal(t, "1.2.0", history[0].ChartVersion)
+	assert.Equal(t, "2.4.1", history[0].AppVersion)
+	assert.False(t, history[0].UpdatedAt.IsZero())
+
+	_, err = svc.Rollback(ctx, &RollbackRequest{ClusterID: testClusterID, Namespace: "apps", Name: "shop", Revision: 4})
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
+
+func TestObjectReady(t *testing.T) {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind":     "Deployment",
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"spec":     map[string]interface{}{"replicas": int64(2)},
+		"status": map[string]interface{}{
+			"observedGeneration": int64(2), "updatedReplicas": int64(2), "availableReplicas": int64(1),
+		},
+	}}
+	ready, err := objectReady(deployment)
+	require.NoError(t, err)
+	assert.False(t, ready)
+
+	require.NoError(t, unstructured.SetNestedField(deployment.Object, int64(2), "status", "availableReplicas"))
+	ready, _ = objectReady(deployment)
+	assert.True(t, ready)
+
+	job := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "Job",
+		"status": map[string]interface{}{"conditions": []interface{}{
+			map[string]interface{}{"type": "Failed", "status": "True", "message": "Job has reached the specified backoff limit"},
+		}},
+	}}
+	_, err = objectReady(job)
+	assert.ErrorContains(t, err, "backoff limit")
+}
@@ -0,0 +1,597 @@
+package helm
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// OCIScheme prefixes chart references in OCI registries
+const OCIScheme = "oci://"
+
+// Media types of Helm charts stored in OCI registries
+const (
+	ociManifestMediaType      = "application/vnd.oci.image.manifest.v1+json"
+	chartLayerMediaType       = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	legacyChartLayerMediaType = "application/tar+gzip"
+)
+
+// Defaults for the local cache of pulled charts
+const (
+	DefaultChartCacheTTL = 10 * time.Minute
+	registryTimeout      = 30 * time.Second
+)
+
+// SecretStore is the secret backend registry credentials are read from.
+// Only a reference to the secret is stored with the registry.
+type SecretStore interface {
+	// Get returns the key/value pairs of the secret at ref
+	Get(ctx context.Context, ref string) (map[string][]byte, error)
+}
+
+// SetSecretStore sets where registry credentials are read from. Without
+// one only anonymous registries can be used.
+func (s *Service) SetSecretStore(store SecretStore) { s.secrets = store }
+
+// SetChartCache sets where charts pulled from OCI registries are cached,
+// and for how long. By default they are cached in the temp directory for
+// DefaultChartCacheTTL; a negative ttl turns the cache off.
+func (s *Service) SetChartCache(dir string, ttl time.Duration) {
+	s.oci.cacheDir = dir
+	s.oci.cacheTTL = ttl
+}
+
+// RegistryLoginRequest logs in to an OCI registry. The secret at
+// CredentialsRef holds either "username" and "password", or a "token"
+// sent as a bearer token.
+type RegistryLoginRequest struct {
+	Host           string `json:"host" binding:"required"`
+	CredentialsRef string `json:"credentials_ref"`
+	// CACert is the PEM CA bundle of a registry with a private CA
+	CACert string `json:"ca_cert"`
+	// PlainHTTP talks to the registry without TLS, for local registries
+	PlainHTTP bool `json:"plain_http"`
+}
+
+// Registry is an OCI registry logged in to
+type Registry struct {
+	Host           string    `json:"host"`
+	CredentialsRef string    `json:"credentials_ref,omitempty"`
+	CACert         string    `json:"ca_cert,omitempty"`
+	PlainHTTP      bool      `json:"plain_http"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// registryCredentials are read from the secret store for each login
+type registryCredentials struct {
+	username, password, token string
+}
+
+// ociState is the service's OCI client state
+type ociState struct {
+	cacheDir string
+	cacheTTL time.Duration
+
+	mu sync.Mutex
+	// tokens are bearer tokens by host and scope
+	tokens map[string]bearerToken
+}
+
+type bearerToken struct {
+	token   string
+	expires time.Time
+}
+
+// chartRef is a parsed oci:// chart reference
+type chartRef struct {
+	host, repository, reference string
+}
+
+func (r chartRef) String() string {
+	sep := ":"
+	if strings.HasPrefix(r.reference, "sha256:") {
+		sep = "@"
+	}
+	return OCIScheme + r.host + "/" + r.repository + sep + r.reference
+}
+
+// IsOCIReference reports whether repo is a chart in an OCI registry
+func IsOCIReference(repo string) bool {
+	return strings.HasPrefix(repo, OCIScheme)
+}
+
+// parseChartRef parses "oci://host/path/chart:version" or
+// "oci://host/path/chart@sha256:...". A version given separately is
+// used when the reference has none.
+func parseChartRef(ref, version string) (chartRef, error) {
+	rest := strings.TrimPrefix(ref, OCIScheme)
+	host, repo, ok := strings.Cut(rest, "/")
+	if !IsOCIReference(ref) || !ok || !validRegistryHost(host) || repo == "" {
+		return chartRef{}, errors.BadRequest("invalid OCI chart reference: " + ref)
+	}
+	r := chartRef{host: host, repository: repo}
+	if name, digest, ok := strings.Cut(repo, "@"); ok {
+		r.repository, r.reference = name, digest
+	} else if i := strings.LastIndex(repo, ":"); i > 0 {
+		r.repository, r.reference = repo[:i], repo[i+1:]
+	}
+	if r.reference == "" {
+		// Tags can't hold "+", so Helm pushes build metadata as "_"
+		r.reference = strings.ReplaceAll(version, "+", "_")
+	}
+	if r.reference == "" {
+		return chartRef{}, errors.BadRequest("a chart version is required for OCI chart " + ref)
+	}
+	return r, nil
+}
+
+// validRegistryHost reports whether host is a host name or address with
+// an optional port; it names a cache directory, so can't be a path
+func validRegistryHost(host string) bool {
+	if host == "" || host == "." || host == ".." {
+		return false
+	}
+	return !strings.ContainsAny(host, "/\\@?#")
+}
+
+// OCIChartReference joins a chart repository and name the way Helm does
+// for "helm install oci://host/path/chart --version v"
+func OCIChartReference(repo, chart string) string {
+	return strings.TrimSuffix(repo, "/") + "/" + chart
+}
+
+// LoginRegistry checks the credentials at req.CredentialsRef against a
+// registry and records them for pulling charts from it. Only the
+// reference is stored, never the credentials.
+func (s *Service) LoginRegistry(ctx context.Context, req *RegistryLoginRequest) (*Registry, error) {
+	host := strings.TrimSuffix(strings.TrimPrefix(req.Host, OCIScheme), "/")
+	if !validRegistryHost(host) {
+		return nil, errors.BadRequest("registry host must be a host name, with an optional port")
+	}
+	if req.CACert != "" {
+		if ok := x509.NewCertPool().AppendCertsFromPEM([]byte(req.CACert)); !ok {
+			return nil, errors.BadRequest("ca_cert has no PEM certificates")
+		}
+	}
+	reg := &Registry{
+		Host:           host,
+		CredentialsRef: req.CredentialsRef,
+		CACert:         req.CACert,
+		PlainHTTP:      req.PlainHTTP,
+		CreatedAt:      time.Now(),
+	}
+
+	// Check the credentials before keeping them: /v2/ answers 200 to a
+	// client the registry accepts
+	s.forgetTokens(host)
+	resp, err := s.registryGet(ctx, reg, "/v2/", "", "")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO helm_registries (host, credentials_ref, ca_cert, plain_http, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (host) DO UPDATE SET
+			credentials_ref = EXCLUDED.credentials_ref,
+			ca_cert = EXCLUDED.ca_cert,
+			plain_http = EXCLUDED.plain_http
+	`, reg.Host, reg.CredentialsRef, reg.CACert, reg.PlainHTTP, reg.CreatedAt)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to save registry login")
+	}
+
+	logger.Info("Logged in to OCI registry", zap.String("host", host))
+	return reg, nil
+}
+
+// LogoutRegistry forgets a registry login, its tokens and the charts
+// pulled from it
+func (s *Service) LogoutRegistry(ctx context.Context, host string) error {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, OCIScheme), "/")
+	result, err := s.db.ExecContext(ctx, "DELETE FROM helm_registries WHERE host = $1", host)
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to log out of registry")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.NotFoundMsg("not logged in to registry " + host)
+	}
+	s.forgetTokens(host)
+	if err := os.RemoveAll(s.chartCacheDir(host)); err != nil {
+		logger.Warn("Failed to clear cached charts", zap.String("host", host), zap.Error(err))
+	}
+
+	logger.Info("Logged out of OCI registry", zap.String("host", host))
+	return nil
+}
+
+// getRegistry returns the login for host, or an anonymous registry if
+// there is none
+func (s *Service) getRegistry(ctx context.Context, host string) (*Registry, error) {
+	reg := &Registry{Host: host}
+	var credentialsRef, caCert sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT credentials_ref, ca_cert, plain_http, created_at FROM helm_registries WHERE host = $1
+	`, host).Scan(&credentialsRef, &caCert, &reg.PlainHTTP, &reg.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.DatabaseWrap(err, "failed to get registry login")
+	}
+	reg.CredentialsRef = credentialsRef.String
+	reg.CACert = caCert.String
+	return reg, nil
+}
+
+// PullChart loads a chart from an OCI registry, with the registry's login
+// if there is one. Pulled charts are cached locally for the cache TTL.
+func (s *Service) PullChart(ctx context.Context, ref, version string) (*ChartPackage, error) {
+	r, err := parseChartRef(ref, version)
+	if err != nil {
+		return nil, err
+	}
+	cachePath := filepath.Join(s.chartCacheDir(r.host), cacheKey(r.String())+".tgz")
+	if data, ok := s.cachedChart(cachePath); ok {
+		return LoadChartArchive(data)
+	}
+
+	reg, err := s.getRegistry(ctx, r.host)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.pullChartArchive(ctx, reg, r)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := LoadChartArchive(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o700); err == nil {
+		err = os.WriteFile(cachePath, data, 0o600)
+	}
+	if err != nil {
+		logger.Warn("Failed to cache chart", zap.String("chart", r.String()), zap.Error(err))
+	}
+	return chart, nil
+}
+
+// pullChartArchive downloads the chart layer of an OCI artifact and
+// checks it against its digest
+func (s *Service) pullChartArchive(ctx context.Context, reg *Registry, r chartRef) ([]byte, error) {
+	scope := "repository:" + r.repository + ":pull"
+	resp, err := s.registryGet(ctx, reg, "/v2/"+r.repository+"/manifests/"+r.reference, scope, ociManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+			Size      int64  `json:"size"`
+		} `json:"layers"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&manifest)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.HelmWrap(err, "invalid OCI manifest for "+r.String())
+	}
+
+	digest := ""
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == chartLayerMediaType || layer.MediaType == legacyChartLayerMediaType {
+			if layer.Size > maxChartSize {
+				return nil, errors.Helm("chart " + r.String() + " is too large")
+			}
+			digest = layer.Digest
+			break
+		}
+	}
+	if !strings.HasPrefix(digest, "sha256:") {
+		return nil, errors.Helm(r.String() + " is not a Helm chart")
+	}
+
+	resp, err = s.registryGet(ctx, reg, "/v2/"+r.repository+"/blobs/"+digest, scope, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxChartSize+1))
+	if err != nil {
+		return nil, errors.HelmWrap(err, "failed to download chart "+r.String())
+	}
+	if len(data) > maxChartSize {
+		return nil, errors.Helm("chart " + r.String() + " is too large")
+	}
+	sum := sha256.Sum256(data)
+	if "sha256:"+hex.EncodeToString(sum[:]) != digest {
+		return nil, errors.Helm("chart " + r.String() + " does not match its digest")
+	}
+	return data, nil
+}
+
+// registryGet gets a registry path, answering its auth challenge: basic
+// auth with the login's username and password, or a bearer token from the
+// registry's token service for scope. A login with a token sends it as
+// the bearer token.
+func (s *Service) registryGet(ctx context.Context, reg *Registry, path, scope, accept string) (*http.Response, error) {
+	client, err := registryClient(reg)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := s.registryCredentials(ctx, reg)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "https"
+	if reg.PlainHTTP {
+		scheme = "http"
+	}
+	target := scheme + "://" + reg.Host + path
+
+	do := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, errors.BadRequestWrap(err, "invalid registry request")
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, errors.HelmWrap(err, "failed to reach registry "+reg.Host)
+		}
+		return resp, nil
+	}
+
+	authorization := ""
+	switch {
+	case creds.token != "":
+		authorization = "Bearer " + creds.token
+	default:
+		if token, ok := s.cachedToken(reg.Host, scope); ok {
+			authorization = "Bearer " + token
+		}
+	}
+	resp, err := do(authorization)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && creds.token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		scheme, params := parseChallenge(challenge)
+		switch scheme {
+		case "basic":
+			if creds.username == "" {
+				return nil, errors.Unauthorized("registry " + reg.Host + " needs credentials")
+			}
+			req, _ := http.NewRequest(http.MethodGet, target, nil)
+			req.SetBasicAuth(creds.username, creds.password)
+			authorization = req.Header.Get("Authorization")
+		case "bearer":
+			token, err := s.fetchToken(ctx, client, reg.Host, params, scope, creds)
+			if err != nil {
+				return nil, err
+			}
+			authorization = "Bearer " + token
+		default:
+			return nil, errors.Unauthorized("registry " + reg.Host + " refused the request")
+		}
+		if resp, err = do(authorization); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return resp, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		resp.Body.Close()
+		return nil, errors.Unauthorized("registry " + reg.Host + " refused the credentials")
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, errors.NotFoundMsg("not found in registry " + reg.Host + ": " + path)
+	default:
+		resp.Body.Close()
+		return nil, errors.Helm(fmt.Sprintf("registry %s answered %s for %s", reg.Host, resp.Status, path))
+	}
+}
+
+// fetchToken gets a bearer token from a registry's token service, as in
+// the Docker registry token auth spec
+func (s *Service) fetchToken(ctx context.Context, client *http.Client, host string, params map[string]string, scope string, creds registryCredentials) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", errors.Helm("registry " + host + " has an invalid token realm")
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		scope = params["scope"]
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", errors.HelmWrap(err, "invalid token request")
+	}
+	if creds.username != "" {
+		req.SetBasicAuth(creds.username, creds.password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.HelmWrap(err, "failed to reach token service of registry "+host)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Unauthorized("registry " + host + " refused the credentials")
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", errors.HelmWrap(err, "invalid token from registry "+host)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", errors.Helm("registry " + host + " issued no token")
+	}
+	// Tokens live at least 60 seconds per the spec; refresh a little early
+	ttl := time.Duration(body.ExpiresIn) * time.Second
+	if ttl < time.Minute {
+		ttl = time.Minute
+	}
+	s.oci.mu.Lock()
+	if s.oci.tokens == nil {
+		s.oci.tokens = map[string]bearerToken{}
+	}
+	s.oci.tokens[host+" "+scope] = bearerToken{token: token, expires: time.Now().Add(ttl - 10*time.Second)}
+	s.oci.mu.Unlock()
+	return token, nil
+}
+
+func (s *Service) cachedToken(host, scope string) (string, bool) {
+	s.oci.mu.Lock()
+	defer s.oci.mu.Unlock()
+	t, ok := s.oci.tokens[host+" "+scope]
+	if !ok || time.Now().After(t.expires) {
+		return "", false
+	}
+	return t.token, true
+}
+
+func (s *Service) forgetTokens(host string) {
+	s.oci.mu.Lock()
+	defer s.oci.mu.Unlock()
+	for key := range s.oci.tokens {
+		if strings.HasPrefix(key, host+" ") {
+			delete(s.oci.tokens, key)
+		}
+	}
+}
+
+// registryCredentials reads a registry's credentials from the secret
+// store; registries without a login are anonymous
+func (s *Service) registryCredentials(ctx context.Context, reg *Registry) (registryCredentials, error) {
+	if reg.CredentialsRef == "" {
+		return registryCredentials{}, nil
+	}
+	if s.secrets == nil {
+		return registryCredentials{}, errors.Helm("no secret store configured for registry credentials")
+	}
+	data, err := s.secrets.Get(ctx, reg.CredentialsRef)
+	if err != nil {
+		return registryCredentials{}, errors.HelmWrap(err, "failed to read registry credentials")
+	}
+	creds := registryCredentials{
+		username: string(data["username"]),
+		password: string(data["password"]),
+		token:    string(data["token"]),
+	}
+	if creds.token == "" && (creds.username == "" || creds.password == "") {
+		return registryCredentials{}, errors.BadRequest("registry credentials need a token, or a username and password")
+	}
+	return creds, nil
+}
+
+// registryClient is an HTTP client trusting the registry's CA, if it has
+// its own
+func registryClient(reg *Registry) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if reg.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(reg.CACert)) {
+			return nil, errors.BadRequest("registry " + reg.Host + " has an invalid CA certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport, Timeout: registryTimeout}, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g.
+// `Bearer realm="https://auth.example.com/token",service="registry"`
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return strings.ToLower(scheme), params
+}
+
+// chartCacheDir is where charts pulled from host are cached
+func (s *Service) chartCacheDir(host string) string {
+	dir := s.oci.cacheDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "krustron-charts")
+	}
+	return filepath.Join(dir, strings.ReplaceAll(host, ":", "_"))
+}
+
+// cachedChart returns a cached chart archive younger than the cache TTL
+func (s *Service) cachedChart(path string) ([]byte, bool) {
+	ttl := s.oci.cacheTTL
+	if ttl == 0 {
+		ttl = DefaultChartCacheTTL
+	}
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > ttl {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func cacheKey(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,195 @@
+package helm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapSecrets is a secret store of fixed secrets
+type mapSecrets map[string]map[string][]byte
+
+func (m mapSecrets) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	data, ok := m[ref]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", ref)
+	}
+	return data, nil
+}
+
+// testRegistry is an OCI registry serving the fixture chart as
+// charts/web:1.2.0 over TLS. With basic set it asks for basic auth;
+// otherwise for bearer tokens from its token service.
+type testRegistry struct {
+	*httptest.Server
+	basic bool
+	pulls atomic.Int32
+}
+
+const (
+	registryUser     = "ci"
+	registryPassword = "s3cret"
+	registryToken    = "tok-123"
+)
+
+func newTestRegistry(t *testing.T, chart []byte, basic bool) *testRegistry {
+	t.Helper()
+	sum := sha256.Sum256(chart)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociManifestMediaType,
+		"layers": []map[string]interface{}{
+			{"mediaType": chartLayerMediaType, "digest": digest, "size": len(chart)},
+		},
+	})
+	require.NoError(t, err)
+
+	r := &testRegistry{basic: basic}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != registryUser || pass != registryPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": registryToken, "expires_in": 300})
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, req *http.Request) {
+		user, pass, basicOK := req.BasicAuth()
+		authorized := req.Header.Get("Authorization") == "Bearer "+registryToken
+		if r.basic {
+			authorized = basicOK && user == registryUser && pass == registryPassword
+		}
+		if !authorized {
+			if r.basic {
+				w.Header().Set("WWW-Authenticate", `Basic realm="fixture"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fixture"`, r.URL))
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/v2/":
+		case "/v2/charts/web/manifests/1.2.0":
+			w.Header().Set("Content-Type", ociManifestMediaType)
+			w.Write(manifest)
+		case "/v2/charts/web/blobs/" + digest:
+			r.pulls.Add(1)
+			w.Write(chart)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	r.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testRegistry) host() string { return strings.TrimPrefix(r.URL, "https://") }
+
+func (r *testRegistry) caCert() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.Certificate().Raw}))
+}
+
+func TestOCIRegistryInstall(t *testing.T) {
+	svc := newTestService(t)
+	cacheDir := t.TempDir()
+	svc.SetChartCache(cacheDir, 0)
+	svc.SetSecretStore(mapSecrets{
+		"registry-creds": {"username": []byte(registryUser), "password": []byte(registryPassword)},
+		"wrong-creds":    {"username": []byte(registryUser), "password": []byte("nope")},
+	})
+	ctx := context.Background()
+	registry := newTestRegistry(t, packageTestChart(t), false)
+
+	// The registry's private CA has to be trusted
+	_, err := svc.LoginRegistry(ctx, &RegistryLoginRequest{Host: registry.host(), CredentialsRef: "registry-creds"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+
+	_, err = svc.LoginRegistry(ctx, &RegistryLoginRequest{
+		Host: registry.host(), CredentialsRef: "wrong-creds", CACert: registry.caCert(),
+	})
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized), err)
+
+	login, err := svc.LoginRegistry(ctx, &RegistryLoginRequest{
+		Host: "oci://" + registry.host(), CredentialsRef: "registry-creds", CACert: registry.caCert(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, registry.host(), login.Host)
+
+	release, err := svc.Install(ctx, &InstallRequest{
+		Name:         "shop",
+		ClusterID:    testClusterID,
+		Namespace:    "apps",
+		ChartRepo:    "oci://" + registry.host() + "/charts",
+		ChartName:    "web",
+		ChartVersion: "1.2.0",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "2.4.1", release.AppVersion)
+	assert.Contains(t, release.Manifest, "kind: Deployment")
+	assert.Equal(t, int32(1), registry.pulls.Load())
+
+	// Pulled charts come from the cache until it expires
+	chart, err := svc.PullChart(ctx, "oci://"+registry.host()+"/charts/web:1.2.0", "")
+	require.NoError(t, err)
+	assert.Equal(t, "web-1.2.0", chart.FullName())
+	assert.Equal(t, int32(1), registry.pulls.Load())
+
+	require.NoError(t, svc.LogoutRegistry(ctx, registry.host()))
+	_, err = os.Stat(svc.chartCacheDir(registry.host()))
+	assert.True(t, os.IsNotExist(err), "logging out clears cached charts")
+	_, err = svc.PullChart(ctx, "oci://"+registry.host()+"/charts/web", "1.2.0")
+	require.Error(t, err)
+
+	err = svc.LogoutRegistry(ctx, registry.host())
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+}
+
+func TestOCIRegistryAuthSchemes(t *testing.T) {
+	svc := newTestService(t)
+	svc.SetChartCache(t.TempDir(), -1)
+	svc.SetSecretStore(mapSecrets{
+		"basic": {"username": []byte(registryUser), "password": []byte(registryPassword)},
+		"token": {"token": []byte(registryToken)},
+		"empty": {},
+	})
+	ctx := context.Background()
+	chart := packageTestChart(t)
+
+	basic := newTestRegistry(t, chart, true)
+	_, err := svc.LoginRegistry(ctx, &RegistryLoginRequest{Host: basic.host(), CredentialsRef: "basic", CACert: basic.caCert()})
+	require.NoError(t, err)
+	_, err = svc.PullChart(ctx, "oci://"+basic.host()+"/charts/web", "1.2.0")
+	require.NoError(t, err)
+
+	// A token is sent as is, without asking the token service
+	bearer := newTestRegistry(t, chart, false)
+	_, err = svc.LoginRegistry(ctx, &RegistryLoginRequest{Host: bearer.host(), CredentialsRef: "token", CACert: bearer.caCert()})
+	require.NoError(t, err)
+	_, err = svc.PullChart(ctx, "oci://"+bearer.host()+"/charts/web@sha256:"+strings.Repeat("0", 64), "")
+	assert.True(t, errors.Is(err, errors.CodeNotFound), err)
+
+	_, err = svc.LoginRegistry(ctx, &RegistryLoginRequest{Host: bearer.host(), CredentialsRef: "empty", CACert: bearer.caCert()})
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+	_, err = svc.LoginRegistry(ctx, &RegistryLoginRequest{Host: "../etc"})
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+	_, err = svc.PullChart(ctx, "oci://"+bearer.host()+"/charts/web", "")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+}
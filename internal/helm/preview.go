@@ -0,0 +1,126 @@
+package helm
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// UpgradePreview is what an upgrade would do, computed without applying
+// it
+type UpgradePreview struct {
+	Release   string `json:"release"`
+	Namespace string `json:"namespace"`
+	// Revision is the revision the upgrade would create
+	Revision int    `json:"revision"`
+	Chart    string `json:"chart"`
+	// Values are the values the upgrade would be made with, before the
+	// chart's defaults
+	Values map[string]interface{} `json:"values"`
+	// Manifest is the rendered manifest, with Secret values redacted
+	Manifest string         `json:"manifest"`
+	Notes    string         `json:"notes,omitempty"`
+	Changes  []ResourceDiff `json:"changes"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+// PreviewUpgrade renders chart for an upgrade of a release and diffs it
+// against the release's installed manifest, like `helm diff upgrade`.
+// ReuseValues merges the request's values over the release's;
+// otherwise they replace them. Values that don't match the chart's
+// schema fail the preview. Strict fails on templates that don't render
+// rather than leaving them out. Secret values are redacted in both the
+// diff and the manifest.
+func (s *Service) PreviewUpgrade(ctx context.Context, req *UpgradeRequest, chart *ChartPackage) (*UpgradePreview, error) {
+	if chart == nil {
+		return nil, errors.BadRequest("a chart is required")
+	}
+	release, err := s.GetRelease(ctx, req.ClusterID, req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := upgradeValues(release, req)
+	if err != nil {
+		return nil, err
+	}
+	revision := release.Revision + 1
+	rendered, err := RenderChart(chart, values, RenderOptions{
+		ReleaseName: release.Name,
+		Namespace:   release.Namespace,
+		Revision:    revision,
+		IsUpgrade:   true,
+		Strict:      s.renderStrict(chart, req.Strict),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := DiffManifests(release.Manifest, rendered.Manifest, release.Namespace)
+	if err != nil {
+		return nil, errors.HelmWrap(err, "failed to diff release manifests")
+	}
+
+	return &UpgradePreview{
+		Release:   release.Name,
+		Namespace: release.Namespace,
+		Revision:  revision,
+		Chart:     chart.FullName(),
+		Values:    values,
+		Manifest:  redactManifestSecrets(rendered.Manifest),
+		Notes:     rendered.Notes,
+		Changes:   changes,
+		Warnings:  rendered.Warnings,
+	}, nil
+}
+
+// upgradeValues returns the values an upgrade uses: the request's, over
+// the release's current ones if they are reused
+func upgradeValues(release *Release, req *UpgradeRequest) (map[string]interface{}, error) {
+	values, err := requestValues(req.Values, req.ValuesYAML)
+	if err != nil {
+		return nil, err
+	}
+	if !req.ReuseValues || req.ResetValues {
+		return values, nil
+	}
+	current, err := parseValues(release.ValuesYAML)
+	if err != nil {
+		return nil, errors.HelmWrap(err, "release has invalid stored values")
+	}
+	return coalesceValues(values, current), nil
+}
+
+// requestValues returns values given as a map, or else as YAML
+func requestValues(values map[string]interface{}, valuesYAML string) (map[string]interface{}, error) {
+	if len(values) > 0 {
+		// Round-trip through JSON so numbers decode as they do from YAML
+		data, err := json.Marshal(values)
+		if err != nil {
+			return nil, errors.BadRequestWrap(err, "invalid values")
+		}
+		return parseValues(string(data))
+	}
+	parsed, err := parseValues(valuesYAML)
+	if err != nil {
+		return nil, errors.BadRequestWrap(err, "invalid values YAML")
+	}
+	return parsed, nil
+}
+
+// parseValues parses values stored as YAML or JSON
+func parseValues(data string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if data == "" {
+		return values, nil
+	}
+	if err := yaml.Unmarshal([]byte(data), &values); err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, nil
+}
@@ -0,0 +1,233 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const testClusterID = "7c1e4b2a-9f3d-4a6e-8b5c-2d1f0e9a8b7c"
+
+func loadTestChart(t *testing.T) *ChartPackage {
+	t.Helper()
+	chart, err := LoadChartDir("testdata/charts/web")
+	require.NoError(t, err)
+	return chart
+}
+
+// newTestService backs the service with in-memory SQLite
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	_, err = sqlDB.Exec(`CREATE TABLE helm_releases (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), name TEXT NOT NULL,
+		cluster_id TEXT, namespace TEXT NOT NULL, chart_name TEXT NOT NULL,
+		chart_version TEXT NOT NULL, chart_repo TEXT, app_version TEXT, values_yaml TEXT,
+		status TEXT DEFAULT 'unknown', revision INTEGER DEFAULT 1, last_deployed TIMESTAMP,
+		notes TEXT, manifest TEXT, created_by TEXT DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(cluster_id, namespace, name))`)
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`CREATE TABLE helm_release_revisions (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), release_id TEXT NOT NULL,
+		revision INTEGER NOT NULL, chart_name TEXT NOT NULL, chart_version TEXT NOT NULL,
+		app_version TEXT, values_yaml TEXT, manifest TEXT, notes TEXT, status TEXT NOT NULL,
+		description TEXT, deployed_at TIMESTAMP NOT NULL, UNIQUE(release_id, revision))`)
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`CREATE TABLE helm_registries (
+		host TEXT PRIMARY KEY, credentials_ref TEXT, ca_cert TEXT, plain_http BOOLEAN DEFAULT false,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`)
+	require.NoError(t, err)
+	return NewService(&database.PostgresDB{DB: sqlDB}, nil, nil)
+}
+
+// findDiff returns the change to a resource, if any
+func findDiff(diffs []ResourceDiff, kind, name string) *ResourceDiff {
+	for i := range diffs {
+		if diffs[i].Kind == kind && diffs[i].Name == name {
+			return &diffs[i]
+		}
+	}
+	return nil
+}
+
+func TestRenderChart(t *testing.T) {
+	chart := loadTestChart(t)
+	assert.Equal(t, "web-1.2.0", chart.FullName())
+	require.Len(t, chart.Dependencies, 1)
+
+	rendered, err := RenderChart(chart, nil, RenderOptions{ReleaseName: "shop", Namespace: "apps", Strict: true})
+	require.NoError(t, err)
+	assert.Contains(t, rendered.Manifest, "# Source: web/templates/deployment.yaml\napiVersion: apps/v1\nkind: Deployment")
+	assert.Contains(t, rendered.Manifest, "name: shop-web\n")
+	assert.Contains(t, rendered.Manifest, `image: "nginx:1.25"`)
+	assert.Contains(t, rendered.Manifest, `app.kubernetes.io/version: "2.4.1"`)
+	assert.NotContains(t, rendered.Manifest, "kind: ConfigMap")
+	assert.NotContains(t, rendered.Manifest, "kind: StatefulSet")
+	assert.Equal(t, "shop is running 2 replicas.", rendered.Notes)
+	assert.Empty(t, rendered.Warnings)
+
+	// Subcharts see their own values and the parent's globals
+	rendered, err = RenderChart(chart, map[string]interface{}{
+		"cache": map[string]interface{}{"enabled": true, "memory": "256Mi"},
+	}, RenderOptions{ReleaseName: "shop", Namespace: "apps", Strict: true})
+	require.NoError(t, err)
+	assert.Contains(t, rendered.Manifest, "# Source: web/charts/cache/templates/statefulset.yaml")
+	assert.Contains(t, rendered.Manifest, "team: payments")
+	assert.Contains(t, rendered.Manifest, `args: ["--maxmemory", "256Mi"]`)
+}
+
+// packageTestChart packages the fixture chart as `helm package` does,
+// under a top directory
+func packageTestChart(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	root := "testdata/charts/web"
+	require.NoError(t, filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		hdr := &tar.Header{Name: "web/" + filepath.ToSlash(rel), Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestLoadChartArchive(t *testing.T) {
+	chart, err := LoadChartArchive(packageTestChart(t))
+	require.NoError(t, err)
+	assert.Equal(t, loadTestChart(t), chart)
+
+	_, err = LoadChartArchive([]byte("not a chart"))
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+}
+
+func TestRenderChartStrict(t *testing.T) {
+	chart := loadTestChart(t)
+	broken := map[string]interface{}{"image": nil}
+
+	// Without strict, the template that can't render is left out
+	rendered, err := RenderChart(chart, broken, RenderOptions{ReleaseName: "shop", Namespace: "apps"})
+	require.NoError(t, err)
+	assert.NotContains(t, rendered.Manifest, "kind: Deployment")
+	assert.Contains(t, rendered.Manifest, "kind: Service")
+	require.Len(t, rendered.Warnings, 1)
+	assert.Contains(t, rendered.Warnings[0], "web/templates/deployment.yaml")
+
+	_, err = RenderChart(chart, broken, RenderOptions{ReleaseName: "shop", Namespace: "apps", Strict: true})
+	assert.True(t, errors.Is(err, errors.CodeValidation), err)
+
+	// Strict also refuses references to missing values
+	_, err = RenderChart(chart, map[string]interface{}{
+		"config": map[string]interface{}{"enabled": true, "logLevel": nil},
+	}, RenderOptions{ReleaseName: "shop", Namespace: "apps", Strict: true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "configmap.yaml")
+}
+
+func TestPreviewUpgrade(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+	chart := loadTestChart(t)
+
+	// The installed revision runs 3 replicas and a service account the
+	// chart no longer has
+	installed, err := RenderChart(chart, map[string]interface{}{"replicaCount": 3}, RenderOptions{ReleaseName: "shop", Namespace: "apps"})
+	require.NoError(t, err)
+	manifest := installed.Manifest + "---\napiVersion: v1\nkind: ServiceAccount\nmetadata:\n  name: shop-web\n"
+	_, err = svc.db.Exec(`INSERT INTO helm_releases (name, cluster_id, namespace, chart_name, chart_version,
+		values_yaml, status, revision, manifest) VALUES ('shop', $1, 'apps', 'web', '1.1.0', $2, 'deployed', 4, $3)`,
+		testClusterID, `{"replicaCount":3}`, manifest)
+	require.NoError(t, err)
+
+	req := &UpgradeRequest{
+		ClusterID:   testClusterID,
+		Namespace:   "apps",
+		Name:        "shop",
+		ReuseValues: true,
+		ValuesYAML:  "image:\n  tag: \"1.26\"\nconfig:\n  enabled: true\npassword: s3cret\n",
+	}
+	preview, err := svc.PreviewUpgrade(ctx, req, chart)
+	require.NoError(t, err)
+	assert.Equal(t, 5, preview.Revision)
+	assert.Equal(t, "web-1.2.0", preview.Chart)
+	assert.Equal(t, float64(3), preview.Values["replicaCount"], "reused values are kept")
+	assert.Contains(t, preview.Manifest, "kind: ConfigMap")
+	assert.Equal(t, "shop is running 3 replicas.", preview.Notes)
+
+	changes := map[string]string{}
+	for _, d := range preview.Changes {
+		changes[d.Kind+"/"+d.Name] = d.Change
+	}
+	assert.Equal(t, map[string]string{
+		"ConfigMap/shop-web-config":   ChangeAdded,
+		"Deployment/shop-web":         ChangeChanged,
+		"Secret/shop-web-credentials": ChangeChanged,
+		"ServiceAccount/shop-web":     ChangeRemoved,
+	}, changes)
+
+	deployment := findDiff(preview.Changes, "Deployment", "shop-web")
+	assert.Equal(t, []FieldChange{{
+		Path: "spec.template.spec.containers[0].image",
+		Old:  "nginx:1.25",
+		New:  "nginx:1.26",
+	}}, deployment.Fields)
+	assert.Equal(t, "apps", deployment.Namespace)
+
+	secret := findDiff(preview.Changes, "Secret", "shop-web-credentials")
+	assert.Equal(t, []FieldChange{{Path: "data.password", Old: redacted, New: redacted}}, secret.Fields)
+	assert.NotContains(t, strings.Join([]string{secret.Fields[0].Old.(string), secret.Fields[0].New.(string)}, ""), "czNjcmV0")
+	assert.NotContains(t, preview.Manifest, "czNjcmV0", "the manifest's Secrets are redacted too")
+	assert.Contains(t, preview.Manifest, "# Source: web/templates/secret.yaml\napiVersion: v1\ndata:\n  password: (redacted)\n")
+	assert.Contains(t, preview.Manifest, "kind: ConfigMap")
+
+	// Without reusing values, the replica count goes back to the default
+	req.ReuseValues = false
+	preview, err = svc.PreviewUpgrade(ctx, req, chart)
+	require.NoError(t, err)
+	deployment = findDiff(preview.Changes, "Deployment", "shop-web")
+	require.Len(t, deployment.Fields, 2)
+	assert.Equal(t, FieldChange{Path: "spec.replicas", Old: float64(3), New: float64(2)}, deployment.Fields[0])
+
+	// Nothing is applied by a preview
+	release, err := svc.GetRelease(ctx, testClusterID, "apps", "shop")
+	require.NoError(t, err)
+	assert.Equal(t, 4, release.Revision)
+	assert.Equal(t, manifest, release.Manifest)
+
+	req.Name = "missing"
+	_, err = svc.PreviewUpgrade(ctx, req, chart)
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+}
@@ -0,0 +1,526 @@
+package helm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// maxIncludeDepth stops templates that include themselves
+const maxIncludeDepth = 1000
+
+// documentSeparator splits a rendered template into YAML documents
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// RenderOptions describe the release a chart is rendered for
+type RenderOptions struct {
+	ReleaseName string
+	Namespace   string
+	Revision    int
+	IsUpgrade   bool
+	// Strict fails on templates that don't render and on references to
+	// missing values, instead of skipping them with a warning
+	Strict bool
+}
+
+// RenderedChart is the output of rendering a chart
+type RenderedChart struct {
+	// Manifest is every rendered resource, each headed by a "# Source:"
+	// comment naming its template, as Helm stores it
+	Manifest string            `json:"manifest"`
+	Notes    string            `json:"notes,omitempty"`
+	Warnings []string          `json:"warnings,omitempty"`
+	Files    map[string]string `json:"-"`
+}
+
+// renderScope is what one chart's templates see
+type renderScope struct {
+	chart  *ChartPackage
+	values map[string]interface{}
+	// prefix names the chart's files, e.g. "web/charts/redis"
+	prefix string
+}
+
+// RenderChart renders a chart with values coalesced over its defaults,
+// after checking them against the chart's values schema, if it has one
+func RenderChart(chart *ChartPackage, values map[string]interface{}, opts RenderOptions) (*RenderedChart, error) {
+	if opts.Revision == 0 {
+		opts.Revision = 1
+	}
+	if err := validateValues(chart, values); err != nil {
+		return nil, err
+	}
+
+	var scopes []renderScope
+	collectScopes(chart, coalesceValues(values, chart.Values), chart.Metadata.Name, &scopes)
+
+	root := template.New("chart")
+	if opts.Strict {
+		root.Option("missingkey=error")
+	} else {
+		root.Option("missingkey=zero")
+	}
+	root.Funcs(templateFuncs(root))
+	for _, scope := range scopes {
+		for _, file := range scope.chart.Templates {
+			name := scopePath(scope, file.Name)
+			if _, err := root.New(name).Parse(string(file.Data)); err != nil {
+				return nil, errors.ValidationWrap(err, "failed to parse template "+name)
+			}
+		}
+	}
+
+	rendered := &RenderedChart{Files: map[string]string{}}
+	var sources []string
+	for _, scope := range scopes {
+		top := map[string]interface{}{
+			"Values": scope.values,
+			"Release": map[string]interface{}{
+				"Name":      opts.ReleaseName,
+				"Namespace": opts.Namespace,
+				"Revision":  opts.Revision,
+				"IsUpgrade": opts.IsUpgrade,
+				"IsInstall": !opts.IsUpgrade,
+				"Service":   "Helm",
+			},
+			"Chart": chartObject(scope.chart),
+			"Capabilities": map[string]interface{}{
+				"KubeVersion": map[string]interface{}{"Version": "v1.33.0", "Major": "1", "Minor": "33"},
+			},
+			"Files": chartFiles(scope.chart.Files),
+		}
+		for _, file := range scope.chart.Templates {
+			name := scopePath(scope, file.Name)
+			base := path.Base(file.Name)
+			if strings.HasPrefix(base, "_") {
+				continue
+			}
+			top["Template"] = map[string]interface{}{"Name": name, "BasePath": scopePath(scope, "templates")}
+
+			var buf bytes.Buffer
+			if err := root.ExecuteTemplate(&buf, name, top); err != nil {
+				if opts.Strict {
+					return nil, errors.ValidationWrap(err, "failed to render template "+name)
+				}
+				rendered.Warnings = append(rendered.Warnings, fmt.Sprintf("skipped %s: %v", name, err))
+				continue
+			}
+			out := buf.String()
+			if !opts.Strict {
+				out = strings.ReplaceAll(out, "<no value>", "")
+			}
+			if base == "NOTES.txt" {
+				// Only the top chart's notes are shown, as in Helm
+				if scope.chart == chart {
+					rendered.Notes = strings.TrimSpace(out)
+				}
+				continue
+			}
+			rendered.Files[name] = out
+			sources = append(sources, name)
+		}
+	}
+
+	var manifest strings.Builder
+	for _, name := range sources {
+		for _, doc := range documentSeparator.Split(rendered.Files[name], -1) {
+			if isBlankDocument(doc) {
+				continue
+			}
+			var obj map[string]interface{}
+			if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+				if opts.Strict {
+					return nil, errors.ValidationWrap(err, "template "+name+" is not valid YAML")
+				}
+				rendered.Warnings = append(rendered.Warnings, fmt.Sprintf("skipped invalid YAML in %s: %v", name, err))
+				continue
+			}
+			fmt.Fprintf(&manifest, "---\n# Source: %s\n%s\n", name, strings.TrimSpace(doc))
+		}
+	}
+	rendered.Manifest = manifest.String()
+	return rendered, nil
+}
+
+// collectScopes lists a chart and its enabled subcharts with the values
+// each sees. Subcharts get their key of the parent's values over their
+// own defaults, plus the parent's globals.
+func collectScopes(chart *ChartPackage, values map[string]interface{}, prefix string, scopes *[]renderScope) {
+	*scopes = append(*scopes, renderScope{chart: chart, values: values, prefix: prefix})
+	for _, dep := range chart.Dependencies {
+		if !dependencyEnabled(chart, dep, values) {
+			continue
+		}
+		subValues, _ := values[dep.Metadata.Name].(map[string]interface{})
+		merged := coalesceValues(subValues, dep.Values)
+		if globals, ok := values["global"].(map[string]interface{}); ok {
+			subGlobals, _ := merged["global"].(map[string]interface{})
+			merged["global"] = coalesceValues(globals, subGlobals)
+		}
+		collectScopes(dep, merged, prefix+"/charts/"+dep.Metadata.Name, scopes)
+	}
+}
+
+// dependencyEnabled evaluates a dependency's condition, e.g.
+// "redis.enabled", against the parent's values
+func dependencyEnabled(parent, dep *ChartPackage, values map[string]interface{}) bool {
+	for _, d := range parent.Metadata.Dependencies {
+		if d.Name != dep.Metadata.Name || d.Condition == "" {
+			continue
+		}
+		for _, condition := range strings.Split(d.Condition, ",") {
+			if v, ok := lookupPath(values, strings.TrimSpace(condition)); ok {
+				enabled, isBool := v.(bool)
+				return !isBool || enabled
+			}
+		}
+	}
+	return true
+}
+
+// scopePath names a chart file as Helm does, e.g. "web/templates/svc.yaml"
+// or "web/charts/redis/templates/svc.yaml"
+func scopePath(scope renderScope, name string) string {
+	return scope.prefix + "/" + name
+}
+
+func chartObject(c *ChartPackage) map[string]interface{} {
+	return map[string]interface{}{
+		"Name":        c.Metadata.Name,
+		"Version":     c.Metadata.Version,
+		"AppVersion":  c.Metadata.AppVersion,
+		"Description": c.Metadata.Description,
+		"Type":        c.Metadata.Type,
+	}
+}
+
+// chartFiles is .Files: Get returns a file's contents
+type chartFiles []ChartFile
+
+// Get returns the contents of a file, or "" if there is none
+func (f chartFiles) Get(name string) string {
+	for _, file := range f {
+		if file.Name == name {
+			return string(file.Data)
+		}
+	}
+	return ""
+}
+
+func isBlankDocument(doc string) bool {
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return true
+}
+
+// coalesceValues merges values over defaults, recursing into maps. A
+// null value removes the default, as in Helm.
+func coalesceValues(values, defaults map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(defaults)+len(values))
+	for k, v := range defaults {
+		out[k] = copyValue(v)
+	}
+	for k, v := range values {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		override, isMap := v.(map[string]interface{})
+		base, baseIsMap := out[k].(map[string]interface{})
+		if isMap && baseIsMap {
+			out[k] = coalesceValues(override, base)
+		} else {
+			out[k] = copyValue(v)
+		}
+	}
+	return out
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return coalesceValues(v, nil)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = copyValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// lookupPath finds a dotted path, e.g. "redis.enabled", in values
+func lookupPath(values map[string]interface{}, p string) (interface{}, bool) {
+	var cur interface{} = values
+	for _, key := range strings.Split(p, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// templateFuncs are the functions charts most commonly use: Helm's own,
+// and the most used of the Sprig library
+func templateFuncs(root *template.Template) template.FuncMap {
+	depth := 0
+	include := func(name string, data interface{}) (string, error) {
+		if depth > maxIncludeDepth {
+			return "", fmt.Errorf("include of %q nested too deeply", name)
+		}
+		depth++
+		defer func() { depth-- }()
+		var buf bytes.Buffer
+		if err := root.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+
+	return template.FuncMap{
+		"include": include,
+		"tpl": func(text string, data interface{}) (string, error) {
+			t, err := root.Clone()
+			if err != nil {
+				return "", err
+			}
+			if _, err := t.New("tpl").Parse(text); err != nil {
+				return "", err
+			}
+			var buf bytes.Buffer
+			if err := t.ExecuteTemplate(&buf, "tpl", data); err != nil {
+				return "", err
+			}
+			return buf.String(), nil
+		},
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if v == nil || v == "" {
+				return nil, fmt.Errorf("%s", msg)
+			}
+			return v, nil
+		},
+		"fail": func(msg string) (string, error) { return "", fmt.Errorf("%s", msg) },
+		"toYaml": func(v interface{}) string {
+			data, err := yaml.Marshal(v)
+			if err != nil {
+				return ""
+			}
+			return strings.TrimSuffix(string(data), "\n")
+		},
+		"fromYaml": func(s string) map[string]interface{} {
+			m := map[string]interface{}{}
+			if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+				m["Error"] = err.Error()
+			}
+			return m
+		},
+		"toJson": func(v interface{}) string {
+			data, _ := json.Marshal(v)
+			return string(data)
+		},
+		"indent": func(n int, s string) string {
+			pad := strings.Repeat(" ", n)
+			return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+		},
+		"nindent": func(n int, s string) string {
+			pad := strings.Repeat(" ", n)
+			return "\n" + pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+		},
+		"quote": func(v ...interface{}) string {
+			quoted := make([]string, 0, len(v))
+			for _, s := range v {
+				if s != nil {
+					quoted = append(quoted, strconv.Quote(toString(s)))
+				}
+			}
+			return strings.Join(quoted, " ")
+		},
+		"squote": func(v interface{}) string { return "'" + toString(v) + "'" },
+		"default": func(def interface{}, v ...interface{}) interface{} {
+			if len(v) == 0 || empty(v[0]) {
+				return def
+			}
+			return v[0]
+		},
+		"empty": empty,
+		"coalesce": func(v ...interface{}) interface{} {
+			for _, e := range v {
+				if !empty(e) {
+					return e
+				}
+			}
+			return nil
+		},
+		"ternary": func(yes, no interface{}, cond bool) interface{} {
+			if cond {
+				return yes
+			}
+			return no
+		},
+		"toString":   toString,
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trunc": func(n int, s string) string {
+			if n >= 0 && len(s) > n {
+				return s[:n]
+			}
+			return s
+		},
+		"replace":   func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":  func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix": func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix": func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"repeat":    func(n int, s string) string { return strings.Repeat(s, n) },
+		"join": func(sep string, v interface{}) string {
+			var parts []string
+			for _, e := range toList(v) {
+				parts = append(parts, toString(e))
+			}
+			return strings.Join(parts, sep)
+		},
+		"splitList": func(sep, s string) []string { return strings.Split(s, sep) },
+		"b64enc":    func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec": func(s string) string {
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return err.Error()
+			}
+			return string(data)
+		},
+		"sha256sum": func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:])
+		},
+		"list": func(v ...interface{}) []interface{} { return v },
+		"dict": func(v ...interface{}) map[string]interface{} {
+			m := make(map[string]interface{}, len(v)/2)
+			for i := 0; i+1 < len(v); i += 2 {
+				m[toString(v[i])] = v[i+1]
+			}
+			return m
+		},
+		"hasKey": func(m map[string]interface{}, key string) bool {
+			_, ok := m[key]
+			return ok
+		},
+		"keys": func(m map[string]interface{}) []string {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"merge": func(dst map[string]interface{}, srcs ...map[string]interface{}) map[string]interface{} {
+			for _, src := range srcs {
+				for k, v := range coalesceValues(dst, src) {
+					dst[k] = v
+				}
+			}
+			return dst
+		},
+		"int": func(v interface{}) int { return int(toFloat(v)) },
+		"int64": func(v interface{}) int64 {
+			return int64(toFloat(v))
+		},
+		"float64": toFloat,
+		"add":     func(a, b interface{}) int64 { return int64(toFloat(a)) + int64(toFloat(b)) },
+		"sub":     func(a, b interface{}) int64 { return int64(toFloat(a)) - int64(toFloat(b)) },
+		"kindIs":  func(kind string, v interface{}) bool { return v != nil && reflect.TypeOf(v).Kind().String() == kind },
+	}
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+func toList(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{v}
+	}
+	out := make([]interface{}, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out
+}
+
+// empty reports whether v is its type's zero value, as Sprig's empty does
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,513 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+)
+
+// rootPath names the values themselves in a violation
+const rootPath = "(root)"
+
+// maxRefDepth stops schemas whose $refs refer to themselves
+const maxRefDepth = 64
+
+// SchemaViolation is a value that doesn't match a chart's values schema
+type SchemaViolation struct {
+	// Path is where the value is, e.g. "image.tag" or "ports[0].name"
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidateValues checks values, coalesced over the chart's defaults,
+// against the chart's values.schema.json, and each enabled subchart's
+// values against its own. A chart without a schema accepts any values.
+// The JSON Schema keywords charts use are supported: types, required,
+// properties, additionalProperties, items, enum, const, bounds, lengths,
+// patterns, allOf/anyOf/oneOf/not and local $refs.
+func ValidateValues(chart *ChartPackage, values map[string]interface{}) ([]SchemaViolation, error) {
+	var scopes []renderScope
+	collectScopes(chart, coalesceValues(values, chart.Values), chart.Metadata.Name, &scopes)
+
+	violations := []SchemaViolation{}
+	for _, scope := range scopes {
+		if len(scope.chart.Schema) == 0 {
+			continue
+		}
+		var schema interface{}
+		if err := json.Unmarshal(scope.chart.Schema, &schema); err != nil {
+			return nil, fmt.Errorf("%s/values.schema.json: %w", scope.prefix, err)
+		}
+		// Subchart values sit under the subchart's name in the parent's
+		path := strings.Join(strings.Split(scope.prefix, "/charts/")[1:], ".")
+		v := &schemaValidator{root: schema}
+		v.validate(schema, scope.values, path)
+		violations = append(violations, v.violations...)
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Path < violations[j].Path })
+	return violations, nil
+}
+
+// validateValues fails with a validation error listing every violation,
+// each also under its path in the error's meta
+func validateValues(chart *ChartPackage, values map[string]interface{}) error {
+	violations, err := ValidateValues(chart, values)
+	if err != nil {
+		return errors.ValidationWrap(err, "chart has an invalid values schema")
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	appErr := errors.Validation(fmt.Sprintf("values don't match the schema of chart %s", chart.FullName()))
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = v.Path + ": " + v.Message
+		if prev, ok := appErr.Meta[v.Path]; ok {
+			appErr.WithMeta(v.Path, prev+"; "+v.Message)
+		} else {
+			appErr.WithMeta(v.Path, v.Message)
+		}
+	}
+	return appErr.WithDetails(strings.Join(lines, "\n"))
+}
+
+// SetStrictRender sets whether charts without a values schema are
+// rendered strictly, so references to undefined values fail installs and
+// upgrades instead of rendering empty. Requests can always ask for a
+// strict render.
+func (s *Service) SetStrictRender(strict bool) { s.strictRender = strict }
+
+// renderStrict reports whether to render chart strictly
+func (s *Service) renderStrict(chart *ChartPackage, requested bool) bool {
+	return requested || (s.strictRender && !hasSchema(chart))
+}
+
+// hasSchema reports whether the chart or any subchart has a values schema
+func hasSchema(chart *ChartPackage) bool {
+	if len(chart.Schema) > 0 {
+		return true
+	}
+	for _, dep := range chart.Dependencies {
+		if hasSchema(dep) {
+			return true
+		}
+	}
+	return false
+}
+
+// schemaValidator validates values against one schema document
+type schemaValidator struct {
+	root       interface{}
+	violations []SchemaViolation
+	// refDepth is how many $refs deep the validation is
+	refDepth int
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	if path == "" {
+		path = rootPath
+	}
+	v.violations = append(v.violations, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// check validates value without recording violations, for anyOf, oneOf
+// and not
+func (v *schemaValidator) check(schema, value interface{}, path string) bool {
+	sub := &schemaValidator{root: v.root, refDepth: v.refDepth}
+	sub.validate(schema, value, path)
+	return len(sub.violations) == 0
+}
+
+func (v *schemaValidator) validate(schema, value interface{}, path string) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "is not allowed")
+		}
+		return
+	case map[string]interface{}:
+		v.validateObject(s, value, path)
+	}
+}
+
+func (v *schemaValidator) validateObject(s map[string]interface{}, value interface{}, path string) {
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		if v.refDepth >= maxRefDepth {
+			v.fail(path, "schema $ref %q refers to itself", ref)
+			return
+		}
+		v.refDepth++
+		v.validate(target, value, path)
+		v.refDepth--
+	}
+
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		v.fail(path, "expected %s, got %s", typeNames(t), jsonType(value))
+		// Nothing else about the value is meaningful
+		return
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "must be one of %s", jsonList(enum))
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(c, value) {
+		v.fail(path, "must be %s", jsonText(c))
+	}
+
+	for _, sub := range schemaList(s["allOf"]) {
+		v.validate(sub, value, path)
+	}
+	if anyOf := schemaList(s["anyOf"]); len(anyOf) > 0 {
+		matched := false
+		for _, sub := range anyOf {
+			if v.check(sub, value, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "must match at least one of the allowed schemas")
+		}
+	}
+	if oneOf := schemaList(s["oneOf"]); len(oneOf) > 0 {
+		matches := 0
+		for _, sub := range oneOf {
+			if v.check(sub, value, path) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.fail(path, "must match exactly one of the allowed schemas, matches %d", matches)
+		}
+	}
+	if not, ok := s["not"]; ok && v.check(not, value, path) {
+		v.fail(path, "must not match the disallowed schema")
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateProperties(s, val, path)
+	case []interface{}:
+		v.validateItems(s, val, path)
+	case string:
+		v.validateString(s, val, path)
+	default:
+		if n, ok := toNumber(value); ok {
+			v.validateNumber(s, n, path)
+		}
+	}
+}
+
+func (v *schemaValidator) validateProperties(s map[string]interface{}, obj map[string]interface{}, path string) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := obj[name]; !ok {
+				v.fail(joinPath(path, name), "is required")
+			}
+		}
+	}
+
+	properties, _ := s["properties"].(map[string]interface{})
+	patterns, _ := s["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		matched := false
+		if sub, ok := properties[k]; ok {
+			v.validate(sub, obj[k], joinPath(path, k))
+			matched = true
+		}
+		for pattern, sub := range patterns {
+			if re, err := regexp.Compile(pattern); err == nil && re.MatchString(k) {
+				v.validate(sub, obj[k], joinPath(path, k))
+				matched = true
+			}
+		}
+		if matched || !hasAdditional {
+			continue
+		}
+		if allowed, ok := additional.(bool); ok && !allowed {
+			v.fail(joinPath(path, k), "is not a known property")
+			continue
+		}
+		v.validate(additional, obj[k], joinPath(path, k))
+	}
+
+	if n, ok := toNumber(s["minProperties"]); ok && float64(len(obj)) < n {
+		v.fail(path, "must have at least %v properties", n)
+	}
+	if n, ok := toNumber(s["maxProperties"]); ok && float64(len(obj)) > n {
+		v.fail(path, "must have at most %v properties", n)
+	}
+}
+
+func (v *schemaValidator) validateItems(s map[string]interface{}, list []interface{}, path string) {
+	switch items := s["items"].(type) {
+	case []interface{}:
+		for i, sub := range items {
+			if i < len(list) {
+				v.validate(sub, list[i], fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case nil:
+	default:
+		for i, item := range list {
+			v.validate(items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+	if n, ok := toNumber(s["minItems"]); ok && float64(len(list)) < n {
+		v.fail(path, "must have at least %v items", n)
+	}
+	if n, ok := toNumber(s["maxItems"]); ok && float64(len(list)) > n {
+		v.fail(path, "must have at most %v items", n)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if jsonEqual(list[i], list[j]) {
+					v.fail(path, "items %d and %d are the same", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(s map[string]interface{}, str, path string) {
+	length := float64(utf8.RuneCountInString(str))
+	if n, ok := toNumber(s["minLength"]); ok && length < n {
+		v.fail(path, "must be at least %v characters", n)
+	}
+	if n, ok := toNumber(s["maxLength"]); ok && length > n {
+		v.fail(path, "must be at most %v characters", n)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.fail(path, "schema pattern %q is invalid", pattern)
+		} else if !re.MatchString(str) {
+			v.fail(path, "must match %q", pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(s map[string]interface{}, n float64, path string) {
+	if min, ok := toNumber(s["minimum"]); ok {
+		if exclusive, _ := s["exclusiveMinimum"].(bool); exclusive && n <= min {
+			v.fail(path, "must be greater than %v", min)
+		} else if n < min {
+			v.fail(path, "must be at least %v", min)
+		}
+	}
+	if max, ok := toNumber(s["maximum"]); ok {
+		if exclusive, _ := s["exclusiveMaximum"].(bool); exclusive && n >= max {
+			v.fail(path, "must be less than %v", max)
+		} else if n > max {
+			v.fail(path, "must be at most %v", max)
+		}
+	}
+	if min, ok := toNumber(s["exclusiveMinimum"]); ok && n <= min {
+		v.fail(path, "must be greater than %v", min)
+	}
+	if max, ok := toNumber(s["exclusiveMaximum"]); ok && n >= max {
+		v.fail(path, "must be less than %v", max)
+	}
+	if m, ok := toNumber(s["multipleOf"]); ok && m > 0 {
+		if q := n / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(path, "must be a multiple of %v", m)
+		}
+	}
+}
+
+// resolve finds a local $ref, a JSON pointer such as
+// "#/definitions/port"
+func (v *schemaValidator) resolve(ref string) (interface{}, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("schema $ref %q is not local to the schema", ref)
+	}
+	node := v.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch n := node.(type) {
+		case map[string]interface{}:
+			next, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("schema $ref %q not found", ref)
+			}
+			node = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, fmt.Errorf("schema $ref %q not found", ref)
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("schema $ref %q not found", ref)
+		}
+	}
+	return node, nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func schemaList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
+
+// matchesType checks value against a "type", a name or a list of names
+func matchesType(t, value interface{}) bool {
+	names := []interface{}{t}
+	if list, ok := t.([]interface{}); ok {
+		names = list
+	}
+	actual := jsonType(value)
+	for _, name := range names {
+		switch name {
+		case actual:
+			return true
+		case "number":
+			if actual == "integer" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeNames(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, len(list))
+		for i, n := range list {
+			names[i] = fmt.Sprint(n)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// jsonType names the JSON Schema type of a decoded value
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	if n, ok := toNumber(value); ok {
+		if n == math.Trunc(n) && !math.IsInf(n, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return reflect.TypeOf(value).String()
+}
+
+// toNumber converts the number types values can hold
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// jsonEqual compares decoded values, treating numbers of any Go type as
+// equal if they are the same number
+func jsonEqual(a, b interface{}) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k := range x {
+			if !jsonEqual(x[k], y[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func jsonText(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func jsonList(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = jsonText(v)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,131 @@
+package helm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadSchemaChart(t *testing.T) *ChartPackage {
+	t.Helper()
+	chart, err := LoadChartDir("testdata/charts/api")
+	require.NoError(t, err)
+	require.NotEmpty(t, chart.Schema)
+	return chart
+}
+
+func TestValidateValues(t *testing.T) {
+	chart := loadSchemaChart(t)
+
+	violations, err := ValidateValues(chart, map[string]interface{}{
+		"database": map[string]interface{}{"host": "db.internal"},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = ValidateValues(chart, map[string]interface{}{
+		"replicaCount": "3",
+		"replicas":     3,
+		"image":        map[string]interface{}{"repository": nil},
+		"logLevel":     "verbose",
+		"ports":        []interface{}{map[string]interface{}{"name": "http"}},
+		"database":     map[string]interface{}{"host": "DB!", "port": 70000},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []SchemaViolation{
+		{Path: "database.host", Message: `must match "^[a-z0-9.-]+$"`},
+		{Path: "database.port", Message: "must be at most 65535"},
+		{Path: "image.repository", Message: "is required"},
+		{Path: "logLevel", Message: `must be one of "debug", "info", "warn", "error"`},
+		{Path: "ports[0].port", Message: "is required"},
+		{Path: "replicaCount", Message: "expected integer, got string"},
+		{Path: "replicas", Message: "is not a known property"},
+	}, violations)
+
+	// The chart's defaults are validated too: the database host is empty
+	violations, err = ValidateValues(chart, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []SchemaViolation{{Path: "database.host", Message: `must match "^[a-z0-9.-]+$"`}}, violations)
+
+	chart.Schema = []byte(`{"$ref": "#"}`)
+	violations, err = ValidateValues(chart, nil)
+	require.NoError(t, err)
+	assert.Equal(t, `schema $ref "#" refers to itself`, violations[0].Message)
+
+	chart.Schema = []byte(`{"type": `)
+	_, err = ValidateValues(chart, nil)
+	assert.Error(t, err)
+}
+
+func TestInstallValidatesValues(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	_, err := svc.Install(ctx, &InstallRequest{
+		Name:      "orders",
+		ClusterID: testClusterID,
+		Namespace: "apps",
+		ChartName: "api",
+		Values: map[string]interface{}{
+			"replicaCount": 0,
+			"database":     map[string]interface{}{"host": "db.internal", "port": "5432"},
+		},
+		Chart: loadSchemaChart(t),
+	})
+	require.Error(t, err)
+	appErr := errors.ToAppError(err)
+	assert.Equal(t, errors.CodeValidation, appErr.Code)
+	assert.Equal(t, map[string]string{
+		"database.port": "expected integer, got string",
+		"replicaCount":  "must be at least 1",
+	}, appErr.Meta)
+	assert.Equal(t, "database.port: expected integer, got string\nreplicaCount: must be at least 1", appErr.Details)
+
+	// Nothing is installed
+	_, err = svc.GetRelease(ctx, testClusterID, "apps", "orders")
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+
+	release, err := svc.Install(ctx, &InstallRequest{
+		Name:      "orders",
+		ClusterID: testClusterID,
+		Namespace: "apps",
+		ChartName: "api",
+		Values:    map[string]interface{}{"database": map[string]interface{}{"host": "db.internal"}},
+		Chart:     loadSchemaChart(t),
+	})
+	require.NoError(t, err)
+	assert.Contains(t, release.Manifest, `value: "postgres://db.internal:5432"`)
+}
+
+func TestStrictRenderWithoutSchema(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+	// The config template needs config.logLevel, which isn't set
+	req := func(name string) *InstallRequest {
+		return &InstallRequest{
+			Name:      name,
+			ClusterID: testClusterID,
+			Namespace: "apps",
+			ChartName: "web",
+			Values:    map[string]interface{}{"config": map[string]interface{}{"enabled": true, "logLevel": nil}},
+			Chart:     loadTestChart(t),
+		}
+	}
+
+	release, err := svc.Install(ctx, req("lenient"))
+	require.NoError(t, err)
+	assert.Contains(t, release.Manifest, "  LOG_LEVEL:\n", "the missing value renders empty")
+
+	svc.SetStrictRender(true)
+	_, err = svc.Install(ctx, req("strict"))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+	assert.Contains(t, err.Error(), "configmap.yaml")
+
+	// Charts with a schema rely on it instead
+	assert.False(t, svc.renderStrict(loadSchemaChart(t), false))
+	assert.True(t, svc.renderStrict(loadSchemaChart(t), true))
+}
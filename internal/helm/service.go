@@ -21,6 +21,12 @@ type Service struct {
 	db          *database.PostgresDB
 	kubeManager *kube.ClientManager
 	cache       *cache.RedisCache
+	deployer    Deployer
+	events      EventPublisher
+	secrets     SecretStore
+	oci         ociState
+	// strictRender renders charts without a values schema strictly
+	strictRender bool
 }
 
 // NewService creates a new Helm service
@@ -86,6 +92,7 @@ type Release struct {
 	Revision     int       `json:"revision" db:"revision"`
 	LastDeployed time.Time `json:"last_deployed" db:"last_deployed"`
 	Notes        string    `json:"notes" db:"notes"`
+	Manifest     string    `json:"manifest,omitempty" db:"manifest"`
 	CreatedBy    string    `json:"created_by" db:"created_by"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
@@ -93,12 +100,13 @@ type Release struct {
 
 // ReleaseHistory represents a release history entry
 type ReleaseHistory struct {
-	Revision    int       `json:"revision"`
-	Status      string    `json:"status"`
-	Chart       string    `json:"chart"`
-	AppVersion  string    `json:"app_version"`
-	Description string    `json:"description"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	Revision     int       `json:"revision"`
+	Status       string    `json:"status"`
+	Chart        string    `json:"chart"`
+	ChartVersion string    `json:"chart_version"`
+	AppVersion   string    `json:"app_version"`
+	Description  string    `json:"description"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 // AddRepoRequest contains repository addition data
@@ -122,7 +130,11 @@ type InstallRequest struct {
 	CreateNS     bool              `json:"create_namespace"`
 	Wait         bool              `json:"wait"`
 	Timeout      int               `json:"timeout"`
+	Strict       bool              `json:"strict"`
 	CreatedBy    string            `json:"-"`
+	// Chart, if set, is rendered and deployed with its hooks; without it
+	// the release is only recorded
+	Chart *ChartPackage `json:"-"`
 }
 
 // UpgradeRequest contains release upgrade data
@@ -135,13 +147,12 @@ type UpgradeRequest struct {
 	ValuesYAML   string                 `json:"values_yaml"`
 	ResetValues  bool                   `json:"reset_values"`
 	ReuseValues  bool                   `json:"reuse_values"`
+	Strict       bool                   `json:"strict"`
 	Wait         bool                   `json:"wait"`
 	Timeout      int                    `json:"timeout"`
-}
-
-// RollbackRequest contains rollback data
-type RollbackRequest struct {
-	Revision int `json:"revision" binding:"required"`
+	// Chart, if set, is rendered and deployed with its hooks; without it
+	// the release keeps its manifest
+	Chart *ChartPackage `json:"-"`
 }
 
 // ListRepositories returns all Helm repositories
@@ -293,8 +304,8 @@ func (s *Service) ListReleases(ctx context.Context, clusterID, namespace string)
 func (s *Service) GetRelease(ctx context.Context, clusterID, namespace, name string) (*Release, error) {
 	query := `
 		SELECT id, name, cluster_id, namespace, chart_name, chart_version,
-		       chart_repo, app_version, values_yaml, status, revision,
-		       last_deployed, notes, created_by, created_at, updated_at
+		       COALESCE(chart_repo, ''), COALESCE(app_version, ''), values_yaml, status, revision,
+		       last_deployed, notes, COALESCE(manifest, ''), created_by, created_at, updated_at
 		FROM helm_releases
 		WHERE cluster_id = $1 AND namespace = $2 AND name = $3
 	`
@@ -306,7 +317,7 @@ func (s *Service) GetRelease(ctx context.Context, clusterID, namespace, name str
 	if err := s.db.QueryRowContext(ctx, query, clusterID, namespace, name).Scan(
 		&r.ID, &r.Name, &r.ClusterID, &r.Namespace, &r.ChartName,
 		&r.ChartVersion, &r.ChartRepo, &r.AppVersion, &valuesYAML,
-		&r.Status, &r.Revision, &lastDeployed, &notes,
+		&r.Status, &r.Revision, &lastDeployed, &notes, &r.Manifest,
 		&r.CreatedBy, &r.CreatedAt, &r.UpdatedAt,
 	); err != nil {
 		if err == sql.ErrNoRows {
@@ -328,83 +339,189 @@ func (s *Service) GetRelease(ctx context.Context, clusterID, namespace, name str
 	return &r, nil
 }
 
-// Install installs a Helm release
+// Install installs a Helm release. With a chart, given or pulled from an
+// oci:// ChartRepo, its manifest is rendered and deployed with the
+// chart's install hooks; a failed deploy is recorded as a failed release.
 func (s *Service) Install(ctx context.Context, req *InstallRequest) (*Release, error) {
-	// Convert values to YAML if provided as map
-	valuesYAML := req.ValuesYAML
-	if len(req.Values) > 0 && valuesYAML == "" {
-		data, _ := json.Marshal(req.Values)
-		valuesYAML = string(data)
+	values, err := requestValues(req.Values, req.ValuesYAML)
+	if err != nil {
+		return nil, err
+	}
+	rev := revision{
+		Number:       1,
+		ChartName:    req.ChartName,
+		ChartVersion: req.ChartVersion,
+		ValuesYAML:   valuesJSON(values),
+		Status:       StatusDeployed,
+		Description:  "Install complete",
+		DeployedAt:   time.Now(),
 	}
 
-	// In a real implementation, this would use Helm SDK to install
+	if req.Chart == nil && IsOCIReference(req.ChartRepo) {
+		if req.Chart, err = s.PullChart(ctx, OCIChartReference(req.ChartRepo, req.ChartName), req.ChartVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	var deployErr error
+	if req.Chart != nil {
+		rendered, err := RenderChart(req.Chart, values, RenderOptions{
+			ReleaseName: req.Name,
+			Namespace:   req.Namespace,
+			Strict:      s.renderStrict(req.Chart, req.Strict),
+		})
+		if err != nil {
+			return nil, err
+		}
+		rev.ChartName = req.Chart.Metadata.Name
+		rev.ChartVersion = req.Chart.Metadata.Version
+		rev.AppVersion = req.Chart.Metadata.AppVersion
+		rev.Manifest = rendered.Manifest
+		rev.Notes = rendered.Notes
+		_, deployErr = s.deploy(ctx, req.ClusterID, req.Namespace, rev.Manifest, deployOptions{
+			prePhase:  HookPreInstall,
+			postPhase: HookPostInstall,
+			wait:      req.Wait,
+			timeout:   time.Duration(req.Timeout) * time.Second,
+		})
+		if deployErr != nil {
+			rev.Status = StatusFailed
+			rev.Description = "Install failed: " + errors.ToAppError(deployErr).Message
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback() // no-op after Commit
+
 	query := `
 		INSERT INTO helm_releases (name, cluster_id, namespace, chart_name,
-		                           chart_version, chart_repo, values_yaml,
-		                           status, revision, last_deployed, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, 'deployed', 1, NOW(), $8)
+		                           chart_version, chart_repo, app_version, values_yaml,
+		                           manifest, notes, status, revision, last_deployed, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 1, $12, $13)
 		RETURNING id, created_at, updated_at
 	`
 
 	var release Release
-	if err := s.db.QueryRowContext(ctx, query,
-		req.Name, req.ClusterID, req.Namespace, req.ChartName,
-		req.ChartVersion, req.ChartRepo, valuesYAML, req.CreatedBy,
+	if err := tx.QueryRowContext(ctx, query,
+		req.Name, req.ClusterID, req.Namespace, rev.ChartName,
+		rev.ChartVersion, req.ChartRepo, rev.AppVersion, rev.ValuesYAML,
+		rev.Manifest, rev.Notes, rev.Status, rev.DeployedAt, req.CreatedBy,
 	).Scan(&release.ID, &release.CreatedAt, &release.UpdatedAt); err != nil {
 		return nil, errors.DatabaseWrap(err, "failed to install release")
 	}
+	if err := insertRevision(ctx, tx, release.ID, &rev); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to install release")
+	}
 
 	release.Name = req.Name
 	release.ClusterID = req.ClusterID
 	release.Namespace = req.Namespace
-	release.ChartName = req.ChartName
-	release.ChartVersion = req.ChartVersion
+	release.ChartName = rev.ChartName
+	release.ChartVersion = rev.ChartVersion
 	release.ChartRepo = req.ChartRepo
-	release.Status = "deployed"
+	release.AppVersion = rev.AppVersion
+	release.ValuesYAML = rev.ValuesYAML
+	release.Manifest = rev.Manifest
+	release.Notes = rev.Notes
+	release.Status = rev.Status
 	release.Revision = 1
-	release.LastDeployed = time.Now()
+	release.LastDeployed = rev.DeployedAt
+	release.CreatedBy = req.CreatedBy
+
+	if deployErr != nil {
+		return &release, deployErr
+	}
 
 	logger.Info("Helm release installed",
 		zap.String("name", req.Name),
-		zap.String("chart", req.ChartName),
+		zap.String("chart", rev.ChartName),
 	)
 
 	return &release, nil
 }
 
-// Upgrade upgrades a Helm release
+// Upgrade upgrades a Helm release to a new revision. With a chart, given
+// or pulled from the release's oci:// repository, its manifest is
+// rendered and deployed with the chart's upgrade hooks; a failed deploy
+// is recorded as a failed revision.
 func (s *Service) Upgrade(ctx context.Context, req *UpgradeRequest) (*Release, error) {
-	valuesYAML := req.ValuesYAML
-	if len(req.Values) > 0 && valuesYAML == "" {
-		data, _ := json.Marshal(req.Values)
-		valuesYAML = string(data)
+	release, err := s.GetRelease(ctx, req.ClusterID, req.Namespace, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	values, err := upgradeValues(release, req)
+	if err != nil {
+		return nil, err
+	}
+	rev := revision{
+		Number:       release.Revision + 1,
+		ChartName:    release.ChartName,
+		ChartVersion: release.ChartVersion,
+		AppVersion:   release.AppVersion,
+		ValuesYAML:   valuesJSON(values),
+		Manifest:     release.Manifest,
+		Notes:        release.Notes,
+		Status:       StatusDeployed,
+		Description:  "Upgrade complete",
+		DeployedAt:   time.Now(),
+	}
+	if req.ChartVersion != "" {
+		rev.ChartVersion = req.ChartVersion
+	}
+	if req.Chart == nil && IsOCIReference(release.ChartRepo) {
+		if req.Chart, err = s.PullChart(ctx, OCIChartReference(release.ChartRepo, release.ChartName), rev.ChartVersion); err != nil {
+			return nil, err
+		}
 	}
 
-	query := `
-		UPDATE helm_releases
-		SET chart_version = COALESCE(NULLIF($4, ''), chart_version),
-		    values_yaml = COALESCE($5, values_yaml),
-		    status = 'deployed',
-		    revision = revision + 1,
-		    last_deployed = NOW(),
-		    updated_at = NOW()
-		WHERE cluster_id = $1 AND namespace = $2 AND name = $3
-		RETURNING revision
-	`
+	var deployErr error
+	if req.Chart != nil {
+		rendered, err := RenderChart(req.Chart, values, RenderOptions{
+			ReleaseName: release.Name,
+			Namespace:   release.Namespace,
+			Revision:    rev.Number,
+			IsUpgrade:   true,
+			Strict:      s.renderStrict(req.Chart, req.Strict),
+		})
+		if err != nil {
+			return nil, err
+		}
+		rev.ChartName = req.Chart.Metadata.Name
+		rev.ChartVersion = req.Chart.Metadata.Version
+		rev.AppVersion = req.Chart.Metadata.AppVersion
+		rev.Manifest = rendered.Manifest
+		rev.Notes = rendered.Notes
+		_, deployErr = s.deploy(ctx, release.ClusterID, release.Namespace, rev.Manifest, deployOptions{
+			prePhase:  HookPreUpgrade,
+			postPhase: HookPostUpgrade,
+			wait:      req.Wait,
+			timeout:   time.Duration(req.Timeout) * time.Second,
+		})
+		if deployErr != nil {
+			rev.Status = StatusFailed
+			rev.Description = "Upgrade failed: " + errors.ToAppError(deployErr).Message
+		}
+	}
 
-	var revision int
-	if err := s.db.QueryRowContext(ctx, query,
-		req.ClusterID, req.Namespace, req.Name, req.ChartVersion, valuesYAML,
-	).Scan(&revision); err != nil {
-		return nil, errors.DatabaseWrap(err, "failed to upgrade release")
+	if err := s.commitRevision(ctx, release, &rev); err != nil {
+		return nil, err
+	}
+	if deployErr != nil {
+		return release, deployErr
 	}
 
 	logger.Info("Helm release upgraded",
 		zap.String("name", req.Name),
-		zap.Int("revision", revision),
+		zap.Int("revision", rev.Number),
 	)
 
-	return s.GetRelease(ctx, req.ClusterID, req.Namespace, req.Name)
+	return release, nil
 }
 
 // Uninstall uninstalls a Helm release
@@ -424,59 +541,6 @@ func (s *Service) Uninstall(ctx context.Context, clusterID, namespace, name stri
 	return nil
 }
 
-// Rollback rolls back a Helm release
-func (s *Service) Rollback(ctx context.Context, clusterID, namespace, name string, revision int) error {
-	// In a real implementation, this would use Helm SDK to rollback
-	query := `
-		UPDATE helm_releases
-		SET status = 'deployed',
-		    revision = $4,
-		    last_deployed = NOW(),
-		    updated_at = NOW()
-		WHERE cluster_id = $1 AND namespace = $2 AND name = $3
-	`
-
-	result, err := s.db.ExecContext(ctx, query, clusterID, namespace, name, revision)
-	if err != nil {
-		return errors.DatabaseWrap(err, "failed to rollback release")
-	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		return errors.NotFoundMsg("release not found")
-	}
-
-	logger.Info("Helm release rolled back",
-		zap.String("name", name),
-		zap.Int("revision", revision),
-	)
-
-	return nil
-}
-
-// GetHistory returns release history
-func (s *Service) GetHistory(ctx context.Context, clusterID, namespace, name string) ([]ReleaseHistory, error) {
-	// In a real implementation, this would query Helm history
-	release, err := s.GetRelease(ctx, clusterID, namespace, name)
-	if err != nil {
-		return nil, err
-	}
-
-	history := make([]ReleaseHistory, release.Revision)
-	for i := 1; i <= release.Revision; i++ {
-		history[i-1] = ReleaseHistory{
-			Revision:    i,
-			Status:      "deployed",
-			Chart:       release.ChartName + "-" + release.ChartVersion,
-			AppVersion:  release.AppVersion,
-			Description: "Upgrade complete",
-			UpdatedAt:   release.LastDeployed,
-		}
-	}
-
-	return history, nil
-}
-
 // GetValues returns release values
 func (s *Service) GetValues(ctx context.Context, clusterID, namespace, name string, allValues bool) (map[string]interface{}, error) {
 	release, err := s.GetRelease(ctx, clusterID, namespace, name)
@@ -0,0 +1,323 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// Approval statuses. A run waits in StatusWaitingApproval while an approve
+// stage is pending; a rejected or timed-out approve stage ends
+// StatusRejected and fails the run.
+const (
+	StatusWaitingApproval = "waiting_approval"
+	StatusRejected        = "rejected"
+
+	DecisionApproved = "approved"
+	DecisionRejected = "rejected"
+)
+
+// Approval is one approver's decision on an approve stage
+type Approval struct {
+	UserID    string    `json:"user_id"`
+	Decision  string    `json:"decision"`
+	Comment   string    `json:"comment,omitempty"`
+	DecidedAt time.Time `json:"decided_at"`
+}
+
+// ApproveRun approves an approve stage a run is waiting on. The stage
+// passes once it has its required number of approvals. stage may be empty
+// when it is the only stage waiting, or the only one approverID may decide.
+func (s *Service) ApproveRun(ctx context.Context, pipelineID, runID, stage, approverID string) error {
+	return s.decide(ctx, pipelineID, runID, stage, approverID, DecisionApproved, "")
+}
+
+// RejectRun rejects an approve stage a run is waiting on, failing the run.
+// stage is chosen as for ApproveRun.
+func (s *Service) RejectRun(ctx context.Context, pipelineID, runID, stage, approverID, reason string) error {
+	return s.decide(ctx, pipelineID, runID, stage, approverID, DecisionRejected, reason)
+}
+
+func (s *Service) decide(ctx context.Context, pipelineID, runID, stageName, approverID, decision, comment string) error {
+	run, err := s.GetRun(ctx, pipelineID, runID)
+	if err != nil {
+		return err
+	}
+	if run.Status != StatusWaitingApproval {
+		return errors.BadRequest("run is not waiting for approval")
+	}
+	p, err := s.Get(ctx, pipelineID)
+	if err != nil {
+		return err
+	}
+
+	stage, key, err := waitingStage(p, run, stageName, approverID)
+	if err != nil {
+		return err
+	}
+	if len(stage.Approvers) > 0 && !utils.ContainsString(stage.Approvers, approverID) {
+		return errors.Forbidden("not an approver for stage " + stage.Name)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO pipeline_approvals (run_id, stage, user_id, decision, comment, decided_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (run_id, stage, user_id) DO NOTHING
+	`, runID, key, approverID, decision, comment, time.Now())
+	if err != nil {
+		return errors.DatabaseWrap(err, "failed to record approval")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.Conflict("already decided on stage " + stage.Name)
+	}
+
+	logger.Info("Pipeline stage decision recorded",
+		zap.String("run_id", runID),
+		zap.String("stage", stage.Name),
+		zap.String("user_id", approverID),
+		zap.String("decision", decision),
+	)
+
+	s.wakeApproval(runID, key)
+	return nil
+}
+
+// waitingStage picks the stage a decision is for among those the run is
+// waiting on, and the key its decisions are recorded under
+func waitingStage(p *Pipeline, run *PipelineRun, name, approverID string) (*Stage, string, error) {
+	var waiting, eligible []int
+	for i := range p.Stages {
+		if run.StagesStatus[p.Stages[i].Name].Status != StatusWaitingApproval {
+			continue
+		}
+		if name != "" && p.Stages[i].Name != name {
+			continue
+		}
+		waiting = append(waiting, i)
+		if len(p.Stages[i].Approvers) == 0 || utils.ContainsString(p.Stages[i].Approvers, approverID) {
+			eligible = append(eligible, i)
+		}
+	}
+
+	var i int
+	switch {
+	case len(waiting) == 0 && name != "":
+		return nil, "", errors.BadRequest("stage " + name + " is not waiting for approval")
+	case len(waiting) == 0:
+		return nil, "", errors.BadRequest("run has no stage waiting for approval")
+	case len(waiting) == 1:
+		i = waiting[0]
+	case len(eligible) == 1:
+		i = eligible[0]
+	default:
+		names := make([]string, len(waiting))
+		for j, w := range waiting {
+			names[j] = p.Stages[w].Name
+		}
+		return nil, "", errors.BadRequest("run is waiting on stages " + strings.Join(names, ", ") + "; name the stage to decide on")
+	}
+
+	stage := &p.Stages[i]
+	key := stage.Name
+	if canary := run.StagesStatus[stage.Name].Canary; canary != nil {
+		key = canaryApprovalKey(stage.Name, canary.Step)
+	}
+	return stage, key, nil
+}
+
+// beginApprovalWait marks the run waiting for approval and stops its
+// timeout clock until every stage waiting in parallel is decided
+func (s *Service) beginApprovalWait(ctx context.Context, state *runState) {
+	state.mu.Lock()
+	state.waiting++
+	first := state.waiting == 1
+	state.mu.Unlock()
+
+	state.clock.pause()
+	if first {
+		s.setRunStatus(ctx, state.run, StatusRunning, StatusWaitingApproval)
+	}
+}
+
+// endApprovalWait undoes beginApprovalWait once a stage is decided
+func (s *Service) endApprovalWait(ctx context.Context, state *runState) {
+	state.mu.Lock()
+	state.waiting--
+	last := state.waiting == 0
+	state.mu.Unlock()
+
+	if last {
+		s.setRunStatus(ctx, state.run, StatusWaitingApproval, StatusRunning)
+	}
+	state.clock.resume()
+}
+
+// runApprovalStage holds the run until an approve stage is approved,
+// rejected, times out or the run is cancelled, and reports whether it was
+// approved
+func (s *Service) runApprovalStage(ctx context.Context, p *Pipeline, state *runState, stage indexedStage) bool {
+	run := state.run
+	required := stage.RequiredApprovals
+	if required <= 0 {
+		required = 1
+	}
+
+	started := time.Now()
+	status := StageStatus{
+		Status:            StatusWaitingApproval,
+		StartedAt:         &started,
+		Approvers:         stage.Approvers,
+		RequiredApprovals: required,
+	}
+	s.setStage(ctx, state, stage.Name, status)
+	s.beginApprovalWait(ctx, state)
+
+	if s.emitter != nil {
+		s.emitter.EmitPipelineApproval(p.ID, map[string]interface{}{
+			"run_id":             run.ID,
+			"run_number":         run.RunNumber,
+			"stage":              stage.Name,
+			"approvers":          stage.Approvers,
+			"required_approvals": required,
+			"timeout":            stage.Timeout,
+		})
+	}
+
+	approvals, outcome, message := s.waitForApproval(ctx, run.ID, stage.Name, stage.Timeout, required)
+
+	finished := time.Now()
+	status.Status = outcome
+	status.Approvals = approvals
+	status.Message = message
+	status.FinishedAt = &finished
+	status.Duration = int(finished.Sub(started).Seconds())
+	s.setStage(ctx, state, stage.Name, status)
+	s.endApprovalWait(ctx, state)
+
+	return outcome == StatusSucceeded
+}
+
+// waitForApproval polls the decisions recorded under key, the stage name,
+// until one rejects, enough approve, timeout seconds pass or ctx ends. It
+// returns the decisions, the outcome and a message explaining a rejection.
+func (s *Service) waitForApproval(ctx context.Context, runID, key string, timeout, required int) ([]Approval, string, string) {
+	wake := s.approvalWaiter(runID, key)
+	defer s.dropApprovalWaiter(runID, key)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	interval := s.pollInterval
+	if interval <= 0 {
+		interval = defaultJobPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		approvals, err := s.stageApprovals(context.WithoutCancel(ctx), runID, key)
+		if err != nil {
+			logger.Warn("Failed to load stage approvals", zap.String("run_id", runID), zap.Error(err))
+		}
+		approved := 0
+		for _, a := range approvals {
+			if a.Decision == DecisionRejected {
+				return approvals, StatusRejected, "rejected by " + a.UserID
+			}
+			approved++
+		}
+		if approved >= required {
+			return approvals, StatusSucceeded, ""
+		}
+
+		select {
+		case <-ctx.Done():
+			return approvals, StatusCancelled, ""
+		case <-deadline:
+			return approvals, StatusRejected, fmt.Sprintf("no decision within %ds", timeout)
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// stageApprovals returns the decisions recorded for a run's stage, oldest
+// first
+func (s *Service) stageApprovals(ctx context.Context, runID, stage string) ([]Approval, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, decision, comment, decided_at
+		FROM pipeline_approvals
+		WHERE run_id = $1 AND stage = $2
+		ORDER BY decided_at
+	`, runID, stage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvals []Approval
+	for rows.Next() {
+		var a Approval
+		var comment sql.NullString
+		if err := rows.Scan(&a.UserID, &a.Decision, &comment, &a.DecidedAt); err != nil {
+			return nil, err
+		}
+		a.Comment = comment.String
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
+
+// setRunStatus moves a run between running and waiting_approval. It never
+// overrides a run that has been cancelled meanwhile.
+func (s *Service) setRunStatus(ctx context.Context, run *PipelineRun, from, to string) {
+	if _, err := s.db.ExecContext(context.WithoutCancel(ctx),
+		"UPDATE pipeline_runs SET status = $3 WHERE id = $1 AND status = $2", run.ID, from, to); err != nil {
+		logger.Warn("Failed to update run status", zap.String("run_id", run.ID), zap.Error(err))
+		return
+	}
+	run.Status = to
+}
+
+// approvalWaiter registers a channel woken when a decision on the stage is
+// recorded by this replica; other replicas' decisions are picked up by
+// polling
+func (s *Service) approvalWaiter(runID, stage string) <-chan struct{} {
+	s.runsMu.Lock()
+	defer s.runsMu.Unlock()
+	if s.approvalWake == nil {
+		s.approvalWake = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{}, 1)
+	s.approvalWake[runID+"/"+stage] = ch
+	return ch
+}
+
+func (s *Service) dropApprovalWaiter(runID, stage string) {
+	s.runsMu.Lock()
+	delete(s.approvalWake, runID+"/"+stage)
+	s.runsMu.Unlock()
+}
+
+func (s *Service) wakeApproval(runID, stage string) {
+	s.runsMu.Lock()
+	ch, ok := s.approvalWake[runID+"/"+stage]
+	s.runsMu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
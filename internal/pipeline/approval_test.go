@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// startApprovalRun stores a pipeline whose first stage is the given approve
+// stage, starts a run of it and waits until the run blocks on approval
+func startApprovalRun(t *testing.T, gate Stage) (*Service, *Pipeline) {
+	t.Helper()
+	svc, _, _ := newExecutorTestService(t, succeed)
+	p := &Pipeline{ID: "p-1", Stages: []Stage{gate, {Name: "deploy", Image: "alpine"}}}
+	insertPipeline(t, svc, p)
+
+	svc.startRun(p, insertRun(t, svc, p, nil))
+	require.Eventually(t, func() bool {
+		status, _, _ := storedRun(t, svc)
+		return status == StatusWaitingApproval
+	}, 5*time.Second, time.Millisecond)
+	return svc, p
+}
+
+// waitForFinish waits for the run to leave the executor
+func waitForFinish(t *testing.T, svc *Service) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		svc.runsMu.Lock()
+		defer svc.runsMu.Unlock()
+		return len(svc.inflight) == 0
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestApproveRunRequiresApprovals(t *testing.T) {
+	svc, p := startApprovalRun(t, Stage{
+		Name: "gate", Type: "approve", Approvers: []string{"alice", "bob", "carol"}, RequiredApprovals: 2,
+	})
+	ctx := context.Background()
+
+	_, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusWaitingApproval, stages["gate"].Status)
+	assert.Equal(t, 2, stages["gate"].RequiredApprovals)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, stages["gate"].Approvers)
+
+	err := svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "mallory")
+	assert.True(t, errors.Is(err, errors.CodeForbidden))
+
+	require.NoError(t, svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "alice"))
+	err = svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "alice")
+	assert.True(t, errors.Is(err, errors.CodeConflict))
+
+	// One approval of two: still waiting
+	status, _, _ := storedRun(t, svc)
+	assert.Equal(t, StatusWaitingApproval, status)
+
+	require.NoError(t, svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "bob"))
+	waitForFinish(t, svc)
+
+	status, stages, _ = storedRun(t, svc)
+	assert.Equal(t, StatusSucceeded, status)
+	assert.Equal(t, StatusSucceeded, stages["gate"].Status)
+	assert.Equal(t, StatusSucceeded, stages["deploy"].Status)
+	require.Len(t, stages["gate"].Approvals, 2)
+	assert.Equal(t, "alice", stages["gate"].Approvals[0].UserID)
+	assert.Equal(t, "bob", stages["gate"].Approvals[1].UserID)
+
+	err = svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "carol")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+}
+
+func TestRejectRunFailsRun(t *testing.T) {
+	svc, p := startApprovalRun(t, Stage{Name: "gate", Type: "approve", RequiredApprovals: 2})
+	ctx := context.Background()
+
+	require.NoError(t, svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "alice"))
+	require.NoError(t, svc.RejectRun(ctx, p.ID, "run-1234567890", "", "bob", "freeze until Monday"))
+	waitForFinish(t, svc)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusFailed, status)
+	assert.Equal(t, StatusRejected, stages["gate"].Status)
+	assert.Equal(t, "rejected by bob", stages["gate"].Message)
+	require.Len(t, stages["gate"].Approvals, 2)
+	assert.Equal(t, "freeze until Monday", stages["gate"].Approvals[1].Comment)
+	assert.Equal(t, StatusSkipped, stages["deploy"].Status)
+}
+
+func TestApprovalTimeoutRejects(t *testing.T) {
+	svc, _ := startApprovalRun(t, Stage{Name: "gate", Type: "approve", Timeout: 1})
+	waitForFinish(t, svc)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusFailed, status)
+	assert.Equal(t, StatusRejected, stages["gate"].Status)
+	assert.Equal(t, "no decision within 1s", stages["gate"].Message)
+	assert.Empty(t, stages["gate"].Approvals)
+	assert.Equal(t, StatusSkipped, stages["deploy"].Status)
+}
+
+func TestParallelApprovalStages(t *testing.T) {
+	svc, _, _ := newExecutorTestService(t, succeed)
+	p := &Pipeline{ID: "p-1", Stages: []Stage{
+		{Name: "qa", Type: "approve", Parallel: true, Approvers: []string{"alice", "carol"}},
+		{Name: "security", Type: "approve", Parallel: true, Approvers: []string{"bob", "carol"}},
+		{Name: "deploy", Image: "alpine"},
+	}}
+	insertPipeline(t, svc, p)
+	ctx := context.Background()
+
+	svc.startRun(p, insertRun(t, svc, p, nil))
+	require.Eventually(t, func() bool {
+		_, stages, _ := storedRun(t, svc)
+		return stages["qa"].Status == StatusWaitingApproval && stages["security"].Status == StatusWaitingApproval
+	}, 5*time.Second, time.Millisecond)
+
+	// carol may decide on both, so she has to say which
+	err := svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "carol")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+	err = svc.ApproveRun(ctx, p.ID, "run-1234567890", "deploy", "carol")
+	assert.True(t, errors.Is(err, errors.CodeBadRequest))
+
+	// alice may only decide on qa
+	require.NoError(t, svc.ApproveRun(ctx, p.ID, "run-1234567890", "", "alice"))
+	require.Eventually(t, func() bool {
+		_, stages, _ := storedRun(t, svc)
+		return stages["qa"].Status == StatusSucceeded
+	}, 5*time.Second, time.Millisecond)
+	status, _, _ := storedRun(t, svc)
+	assert.Equal(t, StatusWaitingApproval, status, "security still waits")
+
+	require.NoError(t, svc.ApproveRun(ctx, p.ID, "run-1234567890", "security", "carol"))
+	waitForFinish(t, svc)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusSucceeded, status)
+	assert.Equal(t, StatusSucceeded, stages["security"].Status)
+	assert.Equal(t, StatusSucceeded, stages["deploy"].Status)
+}
+
+func TestApprovalWaitDoesNotCountAgainstTimeout(t *testing.T) {
+	// deploy never finishes, so the run ends by timing out
+	svc, _, _ := newExecutorTestService(t, func(*batchv1.Job) *bool { return nil })
+	p := &Pipeline{ID: "p-1", Timeout: 1, Stages: []Stage{
+		{Name: "gate", Type: "approve"},
+		{Name: "deploy", Image: "alpine"},
+	}}
+	insertPipeline(t, svc, p)
+
+	svc.startRun(p, insertRun(t, svc, p, nil))
+	require.Eventually(t, func() bool {
+		status, _, _ := storedRun(t, svc)
+		return status == StatusWaitingApproval
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(1500 * time.Millisecond)
+	require.NoError(t, svc.ApproveRun(context.Background(), p.ID, "run-1234567890", "", "alice"))
+	waitForFinish(t, svc)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusFailed, status)
+	assert.Equal(t, StatusSucceeded, stages["gate"].Status, "approved after the timeout had passed")
+	assert.Equal(t, StatusFailed, stages["deploy"].Status)
+	var message string
+	require.NoError(t, svc.db.QueryRow("SELECT error_message FROM pipeline_runs").Scan(&message))
+	assert.Equal(t, "pipeline timed out after 1s", message)
+}
+
+func TestValidateStagesRequiredApprovals(t *testing.T) {
+	assert.NoError(t, validateStages([]Stage{{Name: "gate", Type: "approve", RequiredApprovals: 2}}))
+	assert.NoError(t, validateStages([]Stage{{Name: "gate", Type: "approve", Approvers: []string{"a", "b"}, RequiredApprovals: 2}}))
+	err := validateStages([]Stage{{Name: "gate", Type: "approve", Approvers: []string{"a"}, RequiredApprovals: 2}})
+	assert.True(t, errors.Is(err, errors.CodeValidation))
+}
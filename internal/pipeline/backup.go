@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// pipelinesBackup is the backup form of the pipeline definitions. Runs
+// and logs are not included.
+type pipelinesBackup struct {
+	Pipelines []pipelineBackup `json:"pipelines"`
+}
+
+// pipelineBackup is a pipeline with its webhook secret as stored: sealed
+// when a field cipher is configured, so it restores under the same field
+// keys. Without it a restored webhook pipeline could not verify deliveries
+// and would never run.
+type pipelineBackup struct {
+	Pipeline
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+}
+
+// ExportBackup returns every pipeline definition for a Krustron backup
+func (s *Service) ExportBackup(ctx context.Context) ([]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, display_name, description, application_id,
+		       trigger_type, cron_schedule, stages, variables, timeout,
+		       retry_count, is_active, created_by, created_at,
+		       COALESCE(branches, '[]'), COALESCE(build_pull_requests, false),
+		       COALESCE(webhook_secret, '')
+		FROM pipelines ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to query pipelines")
+	}
+	defer rows.Close()
+
+	backup := pipelinesBackup{Pipelines: []pipelineBackup{}}
+	for rows.Next() {
+		var p pipelineBackup
+		var stages, variables, branches []byte
+		var applicationID, createdBy sql.NullString
+		if err := rows.Scan(
+			&p.ID, &p.Name, &p.DisplayName, &p.Description, &applicationID,
+			&p.TriggerType, &p.CronSchedule, &stages, &variables, &p.Timeout,
+			&p.RetryCount, &p.IsActive, &createdBy, &p.CreatedAt, &branches,
+			&p.BuildPullRequests, &p.WebhookSecret,
+		); err != nil {
+			return nil, errors.DatabaseWrap(err, "failed to scan pipeline")
+		}
+		p.ApplicationID = applicationID.String
+		p.CreatedBy = createdBy.String
+		json.Unmarshal(stages, &p.Stages)
+		json.Unmarshal(variables, &p.Variables)
+		json.Unmarshal(branches, &p.Branches)
+		backup.Pipelines = append(backup.Pipelines, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to read pipelines")
+	}
+	return json.Marshal(backup)
+}
+
+// RestoreBackup writes the pipelines in data through tx, keeping their IDs:
+// existing pipelines get the backed-up definition, missing ones are
+// created. Their applications must exist. A pipeline backed up without a
+// webhook secret keeps the one it has. It returns the number of pipelines
+// restored.
+func (s *Service) RestoreBackup(ctx context.Context, tx *gorm.DB, data []byte) (int, error) {
+	var backup pipelinesBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return 0, fmt.Errorf("invalid pipelines backup: %w", err)
+	}
+
+	tx = tx.WithContext(ctx)
+	now := time.Now()
+	for i := range backup.Pipelines {
+		p := &backup.Pipelines[i]
+		if p.ID == "" || p.Name == "" {
+			return 0, fmt.Errorf("pipeline %d: id and name are required", i+1)
+		}
+		stages, _ := json.Marshal(p.Stages)
+		variables, _ := json.Marshal(p.Variables)
+		branches, _ := json.Marshal(p.Branches)
+		if p.Branches == nil {
+			branches = []byte("[]")
+		}
+
+		var count int64
+		if err := tx.Table("pipelines").Where("id = ?", p.ID).Count(&count).Error; err != nil {
+			return 0, fmt.Errorf("failed to look up pipeline %s: %w", p.Name, err)
+		}
+
+		var err error
+		if count > 0 {
+			err = tx.Exec(`
+				UPDATE pipelines SET name = ?, display_name = ?, description = ?,
+				       application_id = ?, trigger_type = ?, cron_schedule = ?,
+				       stages = ?, variables = ?, timeout = ?, retry_count = ?,
+				       is_active = ?, branches = ?, build_pull_requests = ?,
+				       webhook_secret = COALESCE(?, webhook_secret), updated_at = ?
+				WHERE id = ?`,
+				p.Name, p.DisplayName, p.Description, nullable(p.ApplicationID),
+				p.TriggerType, p.CronSchedule, stages, variables, p.Timeout,
+				p.RetryCount, p.IsActive, branches, p.BuildPullRequests,
+				nullable(p.WebhookSecret), now, p.ID,
+			).Error
+		} else {
+			createdAt := p.CreatedAt
+			if createdAt.IsZero() {
+				createdAt = now
+			}
+			err = tx.Exec(`
+				INSERT INTO pipelines (id, name, display_name, description, application_id,
+				                       trigger_type, cron_schedule, stages, variables, timeout,
+				                       retry_count, is_active, created_by, created_at, updated_at, branches,
+				                       build_pull_requests, webhook_secret)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				p.ID, p.Name, p.DisplayName, p.Description, nullable(p.ApplicationID),
+				p.TriggerType, p.CronSchedule, stages, variables, p.Timeout,
+				p.RetryCount, p.IsActive, nullable(p.CreatedBy), createdAt, now, branches,
+				p.BuildPullRequests, nullable(p.WebhookSecret),
+			).Error
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to restore pipeline %s: %w", p.Name, err)
+		}
+	}
+	return len(backup.Pipelines), nil
+}
+
+// nullable maps "" to NULL for optional columns
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
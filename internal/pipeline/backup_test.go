@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newTestService(t)
+	_, err := src.db.Exec(`ALTER TABLE pipelines ADD COLUMN webhook_secret TEXT`)
+	require.NoError(t, err)
+	_, err = src.db.Exec(`INSERT INTO pipelines VALUES
+		('p-1', 'build', 'Build', '', 'app-1', 'webhook', '', '[{"name":"test","type":"test","commands":["go test ./..."]}]',
+		 '{"GOFLAGS":"-mod=mod"}', 600, 1, true, NULL, NULL, 'u', '2026-01-01', '2026-01-01', '["main"]', true,
+		 'fenc:2026-01:c2VhbGVk')`)
+	require.NoError(t, err)
+
+	data, err := src.ExportBackup(ctx)
+	require.NoError(t, err)
+
+	dst := newTestService(t)
+	_, err = dst.db.Exec(`ALTER TABLE pipelines ADD COLUMN webhook_secret TEXT`)
+	require.NoError(t, err)
+	// An older copy of the pipeline is overwritten in place
+	_, err = dst.db.Exec(`INSERT INTO pipelines VALUES
+		('p-1', 'build', 'Old', '', 'app-1', 'manual', '', '[]', '{}', 60, 0, false, NULL, NULL, 'u', '2025-01-01', '2025-01-01', '[]', false, NULL)`)
+	require.NoError(t, err)
+	gdb, err := gorm.Open(sqlite.Dialector{Conn: dst.db.DB}, &gorm.Config{})
+	require.NoError(t, err)
+
+	var restored int
+	require.NoError(t, gdb.Transaction(func(tx *gorm.DB) error {
+		restored, err = dst.RestoreBackup(ctx, tx, data)
+		return err
+	}))
+	assert.Equal(t, 1, restored)
+
+	want, err := src.Get(ctx, "p-1")
+	require.NoError(t, err)
+	got, err := dst.Get(ctx, "p-1")
+	require.NoError(t, err)
+	assert.Equal(t, "Build", got.DisplayName)
+	assert.Equal(t, want.Stages, got.Stages)
+	assert.Equal(t, want.Variables, got.Variables)
+	assert.Equal(t, want.Branches, got.Branches)
+	assert.True(t, got.BuildPullRequests)
+	assert.Equal(t, 600, got.Timeout)
+	assert.True(t, got.IsActive)
+
+	// The webhook secret is restored as it was stored, still sealed
+	var secret string
+	require.NoError(t, dst.db.QueryRow(`SELECT webhook_secret FROM pipelines WHERE id = 'p-1'`).Scan(&secret))
+	assert.Equal(t, "fenc:2026-01:c2VhbGVk", secret)
+}
@@ -0,0 +1,743 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Canary traffic routing. TrafficReplicas runs the canary behind the
+// stable Deployment's Service, so traffic follows the share of pods;
+// TrafficIstio keeps the stable replicas and weights the "stable" and
+// "canary" subsets of an Istio VirtualService.
+const (
+	TrafficReplicas = "replicas"
+	TrafficIstio    = "istio"
+)
+
+// Canary stage outcome and events. A canary that breaches a metric or is
+// rejected is rolled back and ends StatusRolledBack, failing the run.
+const (
+	StatusRolledBack = "rolled_back"
+
+	CanaryEventStarted    = "canary_started"
+	CanaryEventStep       = "canary_step"
+	CanaryEventAnalysis   = "canary_analysis"
+	CanaryEventPromoted   = "canary_promoted"
+	CanaryEventRolledBack = "canary_rolled_back"
+)
+
+const (
+	defaultCanaryInterval = 30 * time.Second
+
+	// canaryTrackLabel tells canary pods from stable ones; Istio subsets
+	// select on it
+	canaryTrackLabel = "krustron.io/track"
+	canarySuffix     = "-canary"
+
+	// imageVariable is the run variable a canary stage deploys when the
+	// stage names no image
+	imageVariable = "IMAGE"
+)
+
+var virtualServiceResource = schema.GroupVersionResource{
+	Group: "networking.istio.io", Version: "v1beta1", Resource: "virtualservices",
+}
+
+// CanaryStrategy rolls a new image out to a Deployment of the pipeline's
+// application through a canary, shifting traffic to it step by step
+type CanaryStrategy struct {
+	Deployment string `json:"deployment"`
+	// Namespace defaults to the application's namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Container is updated to the stage image; the first one by default
+	Container string `json:"container,omitempty"`
+	// Traffic is TrafficReplicas (default) or TrafficIstio
+	Traffic        string `json:"traffic,omitempty"`
+	VirtualService string `json:"virtual_service,omitempty"`
+
+	Steps   []CanaryStep   `json:"steps"`
+	Metrics []CanaryMetric `json:"metrics,omitempty"`
+	// Interval is how often, in seconds, metrics are evaluated during a
+	// step's pause; 30 by default
+	Interval int `json:"interval,omitempty"`
+}
+
+// CanaryStep sends Weight percent of traffic to the canary, then analyses
+// it for Pause seconds and, with Approval, waits for the stage's approvers
+type CanaryStep struct {
+	Weight   int  `json:"weight"`
+	Pause    int  `json:"pause,omitempty"`
+	Approval bool `json:"approval,omitempty"`
+}
+
+// CanaryMetric is a success criterion: Query must evaluate within
+// [Min, Max]. Queries may refer to {{namespace}}, {{deployment}} and
+// {{canary}}, the canary Deployment's name. The canary is rolled back
+// once a metric fails more than FailureLimit times in a step.
+type CanaryMetric struct {
+	Name         string   `json:"name"`
+	Query        string   `json:"query"`
+	Min          *float64 `json:"min,omitempty"`
+	Max          *float64 `json:"max,omitempty"`
+	FailureLimit int      `json:"failure_limit,omitempty"`
+}
+
+// CanaryStatus is a canary stage's progress
+type CanaryStatus struct {
+	// Step is the 1-based step running, 0 before the first
+	Step   int                `json:"step"`
+	Weight int                `json:"weight"`
+	Steps  []CanaryStepResult `json:"steps,omitempty"`
+}
+
+// CanaryStepResult is the outcome of one step with each metric's latest
+// measurement
+type CanaryStepResult struct {
+	Weight  int            `json:"weight"`
+	Status  string         `json:"status"`
+	Metrics []MetricResult `json:"metrics,omitempty"`
+}
+
+// MetricResult is one evaluation of a canary metric
+type MetricResult struct {
+	Name     string    `json:"name"`
+	Value    float64   `json:"value"`
+	Error    string    `json:"error,omitempty"`
+	Passed   bool      `json:"passed"`
+	Failures int       `json:"failures"`
+	At       time.Time `json:"at"`
+}
+
+func (c *CanaryStatus) clone() *CanaryStatus {
+	out := *c
+	out.Steps = make([]CanaryStepResult, len(c.Steps))
+	for i, step := range c.Steps {
+		step.Metrics = append([]MetricResult(nil), step.Metrics...)
+		out.Steps[i] = step
+	}
+	return &out
+}
+
+// validateCanary checks a canary stage's strategy
+func validateCanary(stage Stage) error {
+	c := stage.Canary
+	if c == nil {
+		return errors.Validation(fmt.Sprintf("canary stage %s has no strategy", stage.Name))
+	}
+	if c.Deployment == "" {
+		return errors.Validation(fmt.Sprintf("canary stage %s names no deployment", stage.Name))
+	}
+	switch c.Traffic {
+	case "", TrafficReplicas:
+	case TrafficIstio:
+		if c.VirtualService == "" {
+			return errors.Validation(fmt.Sprintf("canary stage %s needs a virtual_service for istio traffic", stage.Name))
+		}
+	default:
+		return errors.Validation(fmt.Sprintf("canary stage %s has unknown traffic %q", stage.Name, c.Traffic))
+	}
+	if len(c.Steps) == 0 {
+		return errors.Validation(fmt.Sprintf("canary stage %s has no steps", stage.Name))
+	}
+	prev := 0
+	for _, step := range c.Steps {
+		if step.Weight <= prev || step.Weight > 100 || step.Pause < 0 {
+			return errors.Validation(fmt.Sprintf("canary stage %s steps must raise the weight up to 100", stage.Name))
+		}
+		prev = step.Weight
+	}
+	for _, m := range c.Metrics {
+		if m.Name == "" || m.Query == "" || (m.Min == nil && m.Max == nil) {
+			return errors.Validation(fmt.Sprintf("canary stage %s metrics need a name, a query and a min or max", stage.Name))
+		}
+	}
+	return nil
+}
+
+// validateStages checks stages that carry their own configuration
+func validateStages(stages []Stage) error {
+	for _, stage := range stages {
+		if len(stage.Approvers) > 0 && stage.RequiredApprovals > len(stage.Approvers) {
+			return errors.Validation(fmt.Sprintf("stage %s requires %d approvals but has %d approvers",
+				stage.Name, stage.RequiredApprovals, len(stage.Approvers)))
+		}
+		if stage.Type == "canary" {
+			if err := validateCanary(stage); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runCanaryStage rolls the stage image out through a canary and reports
+// whether it was promoted. Any outcome but promotion, including the run
+// being cancelled, rolls the canary back.
+func (s *Service) runCanaryStage(ctx context.Context, p *Pipeline, state *runState, stage indexedStage) bool {
+	started := time.Now()
+	canary := &CanaryStatus{}
+	status := StageStatus{Status: StatusRunning, StartedAt: &started}
+	save := func() {
+		status.Canary = canary.clone()
+		s.setStage(ctx, state, stage.Name, status)
+	}
+	save()
+
+	outcome, message := s.runCanary(ctx, p, state, stage, &status, canary, save)
+
+	finished := time.Now()
+	status.Status = outcome
+	status.Message = message
+	status.FinishedAt = &finished
+	status.Duration = int(finished.Sub(started).Seconds())
+	save()
+	return outcome == StatusSucceeded
+}
+
+func (s *Service) runCanary(ctx context.Context, p *Pipeline, state *runState, stage indexedStage, status *StageStatus, canary *CanaryStatus, save func()) (string, string) {
+	run := state.run
+	c := stage.Canary
+	if err := validateCanary(stage.Stage); err != nil {
+		return StatusFailed, err.Error()
+	}
+	if len(c.Metrics) > 0 && s.metrics == nil {
+		return StatusFailed, "no metric source configured for canary analysis"
+	}
+
+	router, err := s.canaryRouter(ctx, p, run, stage)
+	if err != nil {
+		return StatusFailed, err.Error()
+	}
+	s.emitCanary(p, run, stage, CanaryEventStarted, map[string]interface{}{"deployment": c.Deployment})
+
+	rollback := func(outcome, reason string) (string, string) {
+		if ctx.Err() != nil {
+			outcome = StatusCancelled
+		}
+		if err := router.Abort(context.WithoutCancel(ctx)); err != nil {
+			logger.Error("Failed to roll canary back",
+				zap.String("run_id", run.ID),
+				zap.String("stage", stage.Name),
+				zap.Error(err),
+			)
+			return StatusFailed, fmt.Sprintf("%s; rollback failed: %v", reason, err)
+		}
+		if n := len(canary.Steps); n > 0 {
+			canary.Steps[n-1].Status = outcome
+		}
+		canary.Weight = 0
+		s.emitCanary(p, run, stage, CanaryEventRolledBack, map[string]interface{}{
+			"step":   canary.Step,
+			"reason": reason,
+		})
+		return outcome, reason
+	}
+
+	for i, step := range c.Steps {
+		if err := router.SetWeight(ctx, step.Weight); err != nil {
+			return rollback(StatusRolledBack, fmt.Sprintf("failed to shift traffic to %d%%: %v", step.Weight, err))
+		}
+		canary.Step, canary.Weight = i+1, step.Weight
+		canary.Steps = append(canary.Steps, CanaryStepResult{Weight: step.Weight, Status: StatusRunning})
+		save()
+		s.emitCanary(p, run, stage, CanaryEventStep, map[string]interface{}{
+			"step":   i + 1,
+			"weight": step.Weight,
+		})
+
+		current := &canary.Steps[len(canary.Steps)-1]
+		breach, err := s.analyseCanary(ctx, router.Names(), c, step, func(results []MetricResult) {
+			current.Metrics = results
+			save()
+			s.emitCanary(p, run, stage, CanaryEventAnalysis, map[string]interface{}{
+				"step":    i + 1,
+				"metrics": results,
+			})
+		})
+		if err != nil {
+			return rollback(StatusCancelled, "run cancelled")
+		}
+		if breach != "" {
+			return rollback(StatusRolledBack, breach)
+		}
+
+		if step.Approval {
+			current.Status = StatusWaitingApproval
+			approved, reason := s.awaitCanaryApproval(ctx, p, state, stage, status, canary, save)
+			if !approved {
+				return rollback(StatusRolledBack, reason)
+			}
+		}
+		current.Status = StatusSucceeded
+		save()
+	}
+
+	if err := router.Promote(ctx); err != nil {
+		return rollback(StatusFailed, fmt.Sprintf("failed to promote canary: %v", err))
+	}
+	s.emitCanary(p, run, stage, CanaryEventPromoted, map[string]interface{}{"deployment": c.Deployment})
+	logger.Info("Canary promoted",
+		zap.String("run_id", run.ID),
+		zap.String("stage", stage.Name),
+		zap.String("deployment", c.Deployment),
+	)
+	return StatusSucceeded, ""
+}
+
+// analyseCanary evaluates the metrics every interval until the step's
+// pause is over, at least once. It returns why the canary breached its
+// criteria, or an error when ctx ends first.
+func (s *Service) analyseCanary(ctx context.Context, names canaryNames, c *CanaryStrategy, step CanaryStep, record func([]MetricResult)) (string, error) {
+	pause := time.Duration(step.Pause) * time.Second
+	if len(c.Metrics) == 0 {
+		return "", sleep(ctx, pause)
+	}
+	interval := time.Duration(c.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultCanaryInterval
+	}
+	placeholders := strings.NewReplacer(
+		"{{namespace}}", names.namespace,
+		"{{deployment}}", names.stable,
+		"{{canary}}", names.canary,
+	)
+
+	deadline := time.Now().Add(pause)
+	failures := make(map[string]int, len(c.Metrics))
+	for {
+		results := make([]MetricResult, 0, len(c.Metrics))
+		breach := ""
+		for _, m := range c.Metrics {
+			result := MetricResult{Name: m.Name, At: time.Now()}
+			value, err := s.metrics.Query(ctx, placeholders.Replace(m.Query))
+			switch {
+			case err != nil:
+				result.Error = err.Error()
+			case math.IsNaN(value):
+				result.Error = "query returned NaN"
+			default:
+				result.Value = value
+				result.Passed = (m.Min == nil || value >= *m.Min) && (m.Max == nil || value <= *m.Max)
+			}
+			if !result.Passed {
+				failures[m.Name]++
+				if failures[m.Name] > m.FailureLimit && breach == "" {
+					breach = fmt.Sprintf("metric %s failed: %s", m.Name, describeMetric(m, result))
+				}
+			}
+			result.Failures = failures[m.Name]
+			results = append(results, result)
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		record(results)
+		if breach != "" {
+			return breach, nil
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return "", nil
+		}
+		if err := sleep(ctx, min(left, interval)); err != nil {
+			return "", err
+		}
+	}
+}
+
+func describeMetric(m CanaryMetric, r MetricResult) string {
+	if r.Error != "" {
+		return r.Error
+	}
+	var bounds []string
+	if m.Min != nil {
+		bounds = append(bounds, "min "+strconv.FormatFloat(*m.Min, 'g', -1, 64))
+	}
+	if m.Max != nil {
+		bounds = append(bounds, "max "+strconv.FormatFloat(*m.Max, 'g', -1, 64))
+	}
+	return fmt.Sprintf("%s outside %s", strconv.FormatFloat(r.Value, 'g', -1, 64), strings.Join(bounds, ", "))
+}
+
+// sleep waits for d or until ctx ends
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// awaitCanaryApproval holds the canary at its current step until the
+// stage's approvers decide, as an approve stage would
+func (s *Service) awaitCanaryApproval(ctx context.Context, p *Pipeline, state *runState, stage indexedStage, status *StageStatus, canary *CanaryStatus, save func()) (bool, string) {
+	run := state.run
+	required := stage.RequiredApprovals
+	if required <= 0 {
+		required = 1
+	}
+	status.Status = StatusWaitingApproval
+	status.Approvers = stage.Approvers
+	status.RequiredApprovals = required
+	status.Approvals = nil
+	save()
+	s.beginApprovalWait(ctx, state)
+
+	if s.emitter != nil {
+		s.emitter.EmitPipelineApproval(p.ID, map[string]interface{}{
+			"run_id":             run.ID,
+			"run_number":         run.RunNumber,
+			"stage":              stage.Name,
+			"canary_step":        canary.Step,
+			"canary_weight":      canary.Weight,
+			"approvers":          stage.Approvers,
+			"required_approvals": required,
+			"timeout":            stage.Timeout,
+		})
+	}
+
+	approvals, outcome, message := s.waitForApproval(ctx, run.ID, canaryApprovalKey(stage.Name, canary.Step), stage.Timeout, required)
+
+	status.Status = StatusRunning
+	status.Approvals = approvals
+	save()
+	s.endApprovalWait(ctx, state)
+
+	switch outcome {
+	case StatusSucceeded:
+		return true, ""
+	case StatusCancelled:
+		return false, "run cancelled"
+	default:
+		return false, fmt.Sprintf("step %d %s", canary.Step, message)
+	}
+}
+
+// canaryApprovalKey names the approvals of one canary step
+func canaryApprovalKey(stage string, step int) string {
+	return fmt.Sprintf("%s#%d", stage, step)
+}
+
+func (s *Service) emitCanary(p *Pipeline, run *PipelineRun, stage indexedStage, event string, data map[string]interface{}) {
+	if s.emitter == nil {
+		return
+	}
+	data["run_id"] = run.ID
+	data["stage"] = stage.Name
+	data["event"] = event
+	s.emitter.EmitPipelineStage(p.ID, data)
+}
+
+// trafficRouter moves traffic between a stable Deployment and its canary
+type trafficRouter interface {
+	SetWeight(ctx context.Context, weight int) error
+	// Promote rolls the canary's image out to the stable Deployment and
+	// removes the canary
+	Promote(ctx context.Context) error
+	// Abort sends all traffic back to the stable Deployment and removes
+	// the canary
+	Abort(ctx context.Context) error
+	Names() canaryNames
+}
+
+type canaryNames struct {
+	namespace, stable, canary string
+}
+
+// canaryRouter starts the canary for a stage in the pipeline application's
+// cluster
+func (s *Service) canaryRouter(ctx context.Context, p *Pipeline, run *PipelineRun, stage indexedStage) (trafficRouter, error) {
+	c := stage.Canary
+	cluster, namespace, err := s.applicationCluster(ctx, p.ApplicationID)
+	if err != nil {
+		return nil, err
+	}
+	if c.Namespace != "" {
+		namespace = c.Namespace
+	}
+	image := os.Expand(stage.Image, func(name string) string { return run.Variables[name] })
+	if image == "" {
+		image = run.Variables[imageVariable]
+	}
+	if image == "" {
+		return nil, fmt.Errorf("canary stage %s has no image; set the stage image or the %s variable", stage.Name, imageVariable)
+	}
+
+	w := &canaryWorkload{
+		client:    cluster.Clientset,
+		namespace: namespace,
+		name:      c.Deployment,
+		container: c.Container,
+		image:     image,
+	}
+	if c.Traffic == TrafficIstio {
+		if err := w.start(ctx); err != nil {
+			return nil, err
+		}
+		return &istioRouter{canaryWorkload: w, client: cluster.DynamicClient, virtualService: c.VirtualService}, nil
+	}
+	w.scaleStable = true
+	if err := w.start(ctx); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// applicationCluster returns the cluster and namespace of an application
+func (s *Service) applicationCluster(ctx context.Context, appID string) (*kube.ClusterClient, string, error) {
+	if s.targetCluster != nil {
+		return s.targetCluster(ctx, appID)
+	}
+	var namespace, cluster string
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT a.namespace, c.name
+		FROM applications a JOIN clusters c ON c.id = a.cluster_id
+		WHERE a.id = $1
+	`, appID).Scan(&namespace, &cluster); err != nil {
+		return nil, "", errors.DatabaseWrap(err, "failed to find application cluster")
+	}
+	if s.kubeManager == nil {
+		return nil, "", errors.Internal("kubernetes client manager not configured")
+	}
+	client, err := s.kubeManager.GetClient(cluster)
+	if err != nil {
+		return nil, "", errors.KubernetesWrap(err, "cluster is not connected")
+	}
+	return client, namespace, nil
+}
+
+// canaryWorkload runs a canary Deployment beside a stable one. The canary
+// copies the stable pod template with the new image and a canary track
+// label, so it sits behind the same Service without the stable Deployment
+// adopting its pods.
+type canaryWorkload struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	container string
+	image     string
+	// scaleStable gives up stable replicas as the canary takes traffic
+	scaleStable bool
+
+	replicas int32
+}
+
+func (w *canaryWorkload) Names() canaryNames {
+	return canaryNames{namespace: w.namespace, stable: w.name, canary: w.name + canarySuffix}
+}
+
+func (w *canaryWorkload) start(ctx context.Context) error {
+	deployments := w.client.AppsV1().Deployments(w.namespace)
+	stable, err := deployments.Get(ctx, w.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment %s/%s: %w", w.namespace, w.name, err)
+	}
+	w.replicas = 1
+	if stable.Spec.Replicas != nil {
+		w.replicas = *stable.Spec.Replicas
+	}
+	if err := setImage(stable, w.container, w.image); err != nil {
+		return err
+	}
+
+	canary := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      w.name + canarySuffix,
+			Namespace: w.namespace,
+			Labels:    withLabel(stable.Labels, canaryTrackLabel, "canary"),
+		},
+		Spec: *stable.Spec.DeepCopy(),
+	}
+	zero := int32(0)
+	canary.Spec.Replicas = &zero
+	canary.Spec.Selector = stable.Spec.Selector.DeepCopy()
+	canary.Spec.Selector.MatchLabels = withLabel(canary.Spec.Selector.MatchLabels, canaryTrackLabel, "canary")
+	canary.Spec.Template.Labels = withLabel(canary.Spec.Template.Labels, canaryTrackLabel, "canary")
+
+	// A canary left by an interrupted run is replaced
+	if err := deployments.Delete(ctx, canary.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to remove previous canary: %w", err)
+	}
+	if _, err := deployments.Create(ctx, canary, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create canary: %w", err)
+	}
+	return nil
+}
+
+func (w *canaryWorkload) SetWeight(ctx context.Context, weight int) error {
+	canaryReplicas := int32(math.Ceil(float64(w.replicas) * float64(weight) / 100))
+	if err := w.scale(ctx, w.name+canarySuffix, canaryReplicas); err != nil {
+		return err
+	}
+	if w.scaleStable {
+		return w.scale(ctx, w.name, max(w.replicas-canaryReplicas, 0))
+	}
+	return nil
+}
+
+func (w *canaryWorkload) Promote(ctx context.Context) error {
+	if err := w.updateStable(ctx, true); err != nil {
+		return err
+	}
+	return w.deleteCanary(ctx)
+}
+
+func (w *canaryWorkload) Abort(ctx context.Context) error {
+	if err := w.updateStable(ctx, false); err != nil {
+		return err
+	}
+	return w.deleteCanary(ctx)
+}
+
+// updateStable restores the stable Deployment's replicas, rolling the
+// canary image out to it when promote is set
+func (w *canaryWorkload) updateStable(ctx context.Context, promote bool) error {
+	deployments := w.client.AppsV1().Deployments(w.namespace)
+	stable, err := deployments.Get(ctx, w.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	replicas := w.replicas
+	stable.Spec.Replicas = &replicas
+	if promote {
+		if err := setImage(stable, w.container, w.image); err != nil {
+			return err
+		}
+	}
+	_, err = deployments.Update(ctx, stable, metav1.UpdateOptions{})
+	return err
+}
+
+func (w *canaryWorkload) deleteCanary(ctx context.Context) error {
+	err := w.client.AppsV1().Deployments(w.namespace).Delete(ctx, w.name+canarySuffix, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (w *canaryWorkload) scale(ctx context.Context, name string, replicas int32) error {
+	deployments := w.client.AppsV1().Deployments(w.namespace)
+	deployment, err := deployments.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	deployment.Spec.Replicas = &replicas
+	_, err = deployments.Update(ctx, deployment, metav1.UpdateOptions{})
+	return err
+}
+
+// setImage sets the image of the named container, or the first one
+func setImage(d *appsv1.Deployment, container, image string) error {
+	containers := d.Spec.Template.Spec.Containers
+	for i := range containers {
+		if container == "" || containers[i].Name == container {
+			containers[i].Image = image
+			return nil
+		}
+	}
+	return fmt.Errorf("deployment %s has no container %q", d.Name, container)
+}
+
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+// istioRouter weights the routes of a VirtualService whose destinations
+// are the "stable" and "canary" subsets of a DestinationRule selecting on
+// the krustron.io/track label. The stable Deployment keeps its replicas
+// and the canary is sized to its share of traffic.
+type istioRouter struct {
+	*canaryWorkload
+	client         dynamic.Interface
+	virtualService string
+}
+
+func (r *istioRouter) SetWeight(ctx context.Context, weight int) error {
+	if err := r.canaryWorkload.SetWeight(ctx, weight); err != nil {
+		return err
+	}
+	return r.route(ctx, weight)
+}
+
+// Promote rolls the stable Deployment before sending traffic back to it
+func (r *istioRouter) Promote(ctx context.Context) error {
+	if err := r.updateStable(ctx, true); err != nil {
+		return err
+	}
+	if err := r.route(ctx, 0); err != nil {
+		return err
+	}
+	return r.deleteCanary(ctx)
+}
+
+func (r *istioRouter) Abort(ctx context.Context) error {
+	if err := r.route(ctx, 0); err != nil {
+		return err
+	}
+	return r.canaryWorkload.Abort(ctx)
+}
+
+// route sends weight percent of every HTTP route's traffic to the canary
+// subset and the rest to the stable one
+func (r *istioRouter) route(ctx context.Context, weight int) error {
+	services := r.client.Resource(virtualServiceResource).Namespace(r.namespace)
+	vs, err := services.Get(ctx, r.virtualService, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get virtual service %s/%s: %w", r.namespace, r.virtualService, err)
+	}
+	httpRoutes, _, _ := unstructured.NestedSlice(vs.Object, "spec", "http")
+	found := false
+	for _, httpRoute := range httpRoutes {
+		routes, _ := httpRoute.(map[string]interface{})["route"].([]interface{})
+		for _, route := range routes {
+			dest, _ := route.(map[string]interface{})
+			subset, _, _ := unstructured.NestedString(dest, "destination", "subset")
+			switch subset {
+			case "stable":
+				dest["weight"] = int64(100 - weight)
+				found = true
+			case "canary":
+				dest["weight"] = int64(weight)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("virtual service %s has no stable or canary routes", r.virtualService)
+	}
+	if err := unstructured.SetNestedSlice(vs.Object, httpRoutes, "spec", "http"); err != nil {
+		return err
+	}
+	_, err = services.Update(ctx, vs, metav1.UpdateOptions{})
+	return err
+}
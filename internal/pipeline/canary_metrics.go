@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/prometheus"
+)
+
+// MetricSource evaluates the queries canary stages judge their canary by
+type MetricSource interface {
+	// Query returns the single value a query evaluates to now
+	Query(ctx context.Context, query string) (float64, error)
+}
+
+// SetMetricSource sets where canary metrics are read from. Without one,
+// canary stages with metrics fail before touching the cluster.
+func (s *Service) SetMetricSource(m MetricSource) { s.metrics = m }
+
+// PrometheusMetrics runs canary queries against the Prometheus HTTP API
+type PrometheusMetrics struct {
+	client *prometheus.Client
+}
+
+// NewPrometheusMetrics creates a metric source for the configured
+// Prometheus
+func NewPrometheusMetrics(cfg *config.PrometheusConfig) *PrometheusMetrics {
+	return &PrometheusMetrics{client: prometheus.NewClient(prometheus.Config{
+		URL:      cfg.URL,
+		Username: cfg.Username,
+		Password: cfg.Password,
+	})}
+}
+
+// Query runs an instant query, which must yield one sample
+func (m *PrometheusMetrics) Query(ctx context.Context, query string) (float64, error) {
+	series, err := m.client.Query(ctx, query, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+	if len(series) != 1 {
+		return 0, fmt.Errorf("query returned %d series, want 1", len(series))
+	}
+	if len(series[0].Values) != 1 {
+		return 0, fmt.Errorf("malformed prometheus sample")
+	}
+	return series[0].Values[0], nil
+}
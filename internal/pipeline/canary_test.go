@@ -0,0 +1,264 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeMetrics answers each query with its queued values in turn, repeating
+// the last one
+type fakeMetrics struct {
+	mu      sync.Mutex
+	values  map[string][]float64
+	queries []string
+	// observe is called on every query, before it is answered
+	observe func()
+}
+
+func (f *fakeMetrics) Query(ctx context.Context, query string) (float64, error) {
+	if f.observe != nil {
+		f.observe()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, query)
+	for prefix, values := range f.values {
+		if strings.HasPrefix(query, prefix) {
+			value := values[0]
+			if len(values) > 1 {
+				f.values[prefix] = values[1:]
+			}
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("no data for %s", query)
+}
+
+// newCanaryTestService wires a fake application cluster running the
+// stable Deployment web:v1 with 4 replicas in namespace shop
+func newCanaryTestService(t *testing.T, metrics MetricSource, objects ...runtime.Object) (*Service, *fake.Clientset, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	svc, _, _ := newExecutorTestService(t, succeed)
+	replicas := int32(4)
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop", Labels: map[string]string{"app": "web"}},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: "proxy", Image: "envoy:1.30"},
+					{Name: "web", Image: "web:v1"},
+				}},
+			},
+		},
+	})
+	dynamic := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...)
+	svc.targetCluster = func(ctx context.Context, appID string) (*kube.ClusterClient, string, error) {
+		return &kube.ClusterClient{Clientset: clientset, DynamicClient: dynamic}, "shop", nil
+	}
+	svc.SetMetricSource(metrics)
+	return svc, clientset, dynamic
+}
+
+func deployment(t *testing.T, clientset *fake.Clientset, name string) (*appsv1.Deployment, error) {
+	t.Helper()
+	return clientset.AppsV1().Deployments("shop").Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func floatPtr(v float64) *float64 { return &v }
+
+func TestCanaryPromotesAfterApproval(t *testing.T) {
+	metrics := &fakeMetrics{values: map[string][]float64{"success_rate": {0.999}}}
+	svc, clientset, _ := newCanaryTestService(t, metrics)
+	p := &Pipeline{ID: "p-1", ApplicationID: "app-1", Stages: []Stage{{
+		Name: "rollout", Type: "canary", Image: "web:${TAG}", Approvers: []string{"alice"},
+		Canary: &CanaryStrategy{
+			Deployment: "web",
+			Container:  "web",
+			Steps:      []CanaryStep{{Weight: 25}, {Weight: 50, Approval: true}, {Weight: 100}},
+			Metrics: []CanaryMetric{
+				{Name: "success-rate", Query: `success_rate{deployment="{{canary}}"}`, Min: floatPtr(0.99)},
+			},
+		},
+	}}}
+	insertPipeline(t, svc, p)
+	svc.startRun(p, insertRun(t, svc, p, map[string]string{"TAG": "v2"}))
+
+	require.Eventually(t, func() bool {
+		status, _, _ := storedRun(t, svc)
+		return status == StatusWaitingApproval
+	}, 5*time.Second, time.Millisecond)
+
+	// Held at 50%: half the replicas run the canary, the rest stay stable
+	_, stages, _ := storedRun(t, svc)
+	require.NotNil(t, stages["rollout"].Canary)
+	assert.Equal(t, 2, stages["rollout"].Canary.Step)
+	assert.Equal(t, 50, stages["rollout"].Canary.Weight)
+	canary, err := deployment(t, clientset, "web-canary")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), *canary.Spec.Replicas)
+	assert.Equal(t, "web:v2", canary.Spec.Template.Spec.Containers[1].Image)
+	assert.Equal(t, "envoy:1.30", canary.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "canary", canary.Spec.Selector.MatchLabels[canaryTrackLabel])
+	assert.Equal(t, "web", canary.Spec.Template.Labels["app"])
+	stable, err := deployment(t, clientset, "web")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), *stable.Spec.Replicas)
+	assert.Equal(t, "web:v1", stable.Spec.Template.Spec.Containers[1].Image)
+
+	err = svc.ApproveRun(context.Background(), p.ID, "run-1234567890", "", "bob")
+	assert.True(t, errors.Is(err, errors.CodeForbidden))
+	require.NoError(t, svc.ApproveRun(context.Background(), p.ID, "run-1234567890", "", "alice"))
+	waitForFinish(t, svc)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusSucceeded, status)
+	result := stages["rollout"]
+	assert.Equal(t, StatusSucceeded, result.Status)
+	require.Len(t, result.Canary.Steps, 3)
+	for _, step := range result.Canary.Steps {
+		assert.Equal(t, StatusSucceeded, step.Status)
+		require.Len(t, step.Metrics, 1)
+		assert.True(t, step.Metrics[0].Passed)
+	}
+	assert.Equal(t, "alice", result.Approvals[0].UserID)
+
+	stable, err = deployment(t, clientset, "web")
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), *stable.Spec.Replicas)
+	assert.Equal(t, "web:v2", stable.Spec.Template.Spec.Containers[1].Image)
+	_, err = deployment(t, clientset, "web-canary")
+	assert.True(t, apierrors.IsNotFound(err))
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	assert.Equal(t, `success_rate{deployment="web-canary"}`, metrics.queries[0])
+}
+
+func TestCanaryRollsBackOnMetricBreach(t *testing.T) {
+	vs := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.istio.io/v1beta1",
+		"kind":       "VirtualService",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+		"spec": map[string]interface{}{
+			"http": []interface{}{map[string]interface{}{
+				"route": []interface{}{
+					map[string]interface{}{"destination": map[string]interface{}{"host": "web", "subset": "stable"}, "weight": int64(100)},
+					map[string]interface{}{"destination": map[string]interface{}{"host": "web", "subset": "canary"}, "weight": int64(0)},
+				},
+			}},
+		},
+	}}
+	metrics := &fakeMetrics{values: map[string][]float64{
+		"error_rate": {0.01, 0.2},
+		"latency":    {0.1},
+	}}
+	svc, clientset, dynamic := newCanaryTestService(t, metrics, vs)
+
+	// Record the canary's traffic share each time it is judged
+	var weights []int64
+	metrics.observe = func() {
+		live, err := dynamic.Resource(virtualServiceResource).Namespace("shop").Get(context.Background(), "web", metav1.GetOptions{})
+		require.NoError(t, err)
+		weights = append(weights, routeWeights(live)["canary"])
+	}
+
+	p := &Pipeline{ID: "p-1", ApplicationID: "app-1", Stages: []Stage{
+		{
+			Name: "rollout", Type: "canary",
+			Canary: &CanaryStrategy{
+				Deployment:     "web",
+				Container:      "web",
+				Traffic:        TrafficIstio,
+				VirtualService: "web",
+				Steps:          []CanaryStep{{Weight: 20}, {Weight: 50}, {Weight: 100}},
+				Metrics: []CanaryMetric{
+					{Name: "error-rate", Query: "error_rate", Max: floatPtr(0.05)},
+					{Name: "latency", Query: "latency", Max: floatPtr(0.5)},
+				},
+			},
+		},
+		{Name: "notify", Image: "curl", When: WhenOnFailure},
+	}}
+	run := insertRun(t, svc, p, map[string]string{"IMAGE": "web:v2"})
+	svc.executeRun(context.Background(), p, run, nil)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusFailed, status)
+	assert.Equal(t, StatusSucceeded, stages["notify"].Status)
+	result := stages["rollout"]
+	assert.Equal(t, StatusRolledBack, result.Status)
+	assert.Equal(t, "metric error-rate failed: 0.2 outside max 0.05", result.Message)
+	require.Len(t, result.Canary.Steps, 2)
+	assert.Equal(t, StatusSucceeded, result.Canary.Steps[0].Status)
+	assert.Equal(t, StatusRolledBack, result.Canary.Steps[1].Status)
+	assert.Equal(t, []int64{20, 20, 50, 50}, weights)
+
+	// All traffic is back on the untouched stable Deployment
+	live, err := dynamic.Resource(virtualServiceResource).Namespace("shop").Get(context.Background(), "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"stable": 100, "canary": 0}, routeWeights(live))
+	stable, err := deployment(t, clientset, "web")
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), *stable.Spec.Replicas)
+	assert.Equal(t, "web:v1", stable.Spec.Template.Spec.Containers[1].Image)
+	_, err = deployment(t, clientset, "web-canary")
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestValidateCanary(t *testing.T) {
+	valid := Stage{Name: "rollout", Type: "canary", Canary: &CanaryStrategy{
+		Deployment: "web",
+		Steps:      []CanaryStep{{Weight: 10}, {Weight: 100}},
+	}}
+	assert.NoError(t, validateStages([]Stage{valid}))
+
+	for name, mutate := range map[string]func(c *CanaryStrategy){
+		"no deployment":       func(c *CanaryStrategy) { c.Deployment = "" },
+		"no steps":            func(c *CanaryStrategy) { c.Steps = nil },
+		"falling weight":      func(c *CanaryStrategy) { c.Steps = []CanaryStep{{Weight: 50}, {Weight: 20}} },
+		"weight over 100":     func(c *CanaryStrategy) { c.Steps = []CanaryStep{{Weight: 150}} },
+		"istio without vs":    func(c *CanaryStrategy) { c.Traffic = TrafficIstio },
+		"unknown traffic":     func(c *CanaryStrategy) { c.Traffic = "linkerd" },
+		"metric without rule": func(c *CanaryStrategy) { c.Metrics = []CanaryMetric{{Name: "m", Query: "up"}} },
+	} {
+		stage := valid
+		strategy := *valid.Canary
+		mutate(&strategy)
+		stage.Canary = &strategy
+		err := validateStages([]Stage{stage})
+		assert.True(t, errors.Is(err, errors.CodeValidation), name)
+	}
+}
+
+// routeWeights returns a VirtualService's weight per subset
+func routeWeights(vs *unstructured.Unstructured) map[string]int64 {
+	weights := map[string]int64{}
+	httpRoutes, _, _ := unstructured.NestedSlice(vs.Object, "spec", "http")
+	for _, httpRoute := range httpRoutes {
+		for _, route := range httpRoute.(map[string]interface{})["route"].([]interface{}) {
+			subset, _, _ := unstructured.NestedString(route.(map[string]interface{}), "destination", "subset")
+			weights[subset], _, _ = unstructured.NestedInt64(route.(map[string]interface{}), "weight")
+		}
+	}
+	return weights
+}
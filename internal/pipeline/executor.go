@@ -0,0 +1,824 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/gitops"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Run and stage statuses
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusSkipped   = "skipped"
+)
+
+// Stage When conditions. Anything else is read as a "VAR==value" or
+// "VAR!=value" comparison against the run's variables.
+const (
+	WhenOnSuccess = "on_success"
+	WhenOnFailure = "on_failure"
+	WhenAlways    = "always"
+)
+
+const (
+	defaultJobPollInterval = 5 * time.Second
+	// runHeartbeatInterval is how often the replica executing a run
+	// records that it still is; a run whose heartbeat is older than
+	// runStaleAfter is resumed by the next recovery sweep
+	runHeartbeatInterval = 30 * time.Second
+	runStaleAfter        = 2 * time.Minute
+	runRecoveryInterval  = time.Minute
+	// jobTTLAfterFinished keeps finished stage Jobs (and their pod logs)
+	// around for an hour before the cluster garbage-collects them
+	jobTTLAfterFinished = int32(3600)
+
+	labelPipeline = "krustron.io/pipeline"
+	labelRun      = "krustron.io/pipeline-run"
+	labelStage    = "krustron.io/stage"
+
+	// revisionVariable is the run variable deploy stages sync to
+	revisionVariable = "REVISION"
+)
+
+// errRunCancelled is the cancellation cause of a run stopped by CancelRun
+var errRunCancelled = goerrors.New("pipeline run cancelled")
+
+// errRunTimedOut is the cancellation cause of a run that ran out of time
+var errRunTimedOut = goerrors.New("pipeline run timed out")
+
+// SetRunner sets the cluster client and namespace stage Jobs run in.
+// Without a runner, triggered runs are recorded but never executed.
+func (s *Service) SetRunner(client kubernetes.Interface, namespace string) {
+	s.runner = client
+	s.runnerNamespace = namespace
+}
+
+// startRun executes run in the background, bounded by the pipeline timeout
+// counted from when the run started. It reports false if the run is
+// already executing on this replica.
+func (s *Service) startRun(p *Pipeline, run *PipelineRun) bool {
+	if s.runner == nil {
+		logger.Warn("No pipeline runner configured, run will not execute",
+			zap.String("pipeline_id", p.ID),
+			zap.String("run_id", run.ID),
+		)
+		return false
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	var clock *runClock
+	if p.Timeout > 0 {
+		used := time.Duration(0)
+		if run.StartedAt != nil {
+			used = time.Since(*run.StartedAt) - approvalWait(p, run, time.Now())
+		}
+		clock = newRunClock(time.Duration(p.Timeout)*time.Second-used, cancel)
+		prev := cancel
+		cancel = func(cause error) { prev(cause); clock.stop() }
+	}
+
+	s.runsMu.Lock()
+	if s.inflight == nil {
+		s.inflight = make(map[string]context.CancelCauseFunc)
+	}
+	if _, ok := s.inflight[run.ID]; ok {
+		s.runsMu.Unlock()
+		cancel(nil)
+		return false
+	}
+	s.inflight[run.ID] = cancel
+	s.runsMu.Unlock()
+
+	go func() {
+		defer func() {
+			s.runsMu.Lock()
+			delete(s.inflight, run.ID)
+			s.runsMu.Unlock()
+			cancel(nil)
+		}()
+		go s.heartbeat(ctx, run.ID)
+		s.executeRun(ctx, p, run, clock)
+	}()
+	return true
+}
+
+// runClock cancels a run once it has spent its timeout, not counting the
+// time it waits for approvals
+type runClock struct {
+	mu        sync.Mutex
+	cancel    context.CancelCauseFunc
+	remaining time.Duration
+	since     time.Time
+	timer     *time.Timer
+	paused    int
+}
+
+func newRunClock(remaining time.Duration, cancel context.CancelCauseFunc) *runClock {
+	c := &runClock{cancel: cancel, remaining: remaining, since: time.Now()}
+	c.timer = time.AfterFunc(remaining, c.expire)
+	return c
+}
+
+func (c *runClock) expire() { c.cancel(errRunTimedOut) }
+
+// pause stops the clock while a stage waits for approval. Pauses nest, for
+// approvals waited on in parallel.
+func (c *runClock) pause() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused++
+	if c.paused == 1 && c.timer.Stop() {
+		c.remaining -= time.Since(c.since)
+	}
+}
+
+// resume restarts the clock once no stage waits for approval
+func (c *runClock) resume() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused--
+	if c.paused == 0 {
+		c.since = time.Now()
+		c.timer = time.AfterFunc(c.remaining, c.expire)
+	}
+}
+
+func (c *runClock) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timer.Stop()
+}
+
+// approvalWait is how long run has waited on its approve stages by now,
+// which doesn't count against the pipeline timeout
+func approvalWait(p *Pipeline, run *PipelineRun, now time.Time) time.Duration {
+	var wait time.Duration
+	for _, stage := range p.Stages {
+		status := run.StagesStatus[stage.Name]
+		if stage.Type != "approve" || status.StartedAt == nil {
+			continue
+		}
+		end := now
+		if status.FinishedAt != nil {
+			end = *status.FinishedAt
+		}
+		wait += end.Sub(*status.StartedAt)
+	}
+	return wait
+}
+
+// heartbeat records that this replica is executing a run until ctx ends
+func (s *Service) heartbeat(ctx context.Context, runID string) {
+	ticker := time.NewTicker(runHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.db.ExecContext(ctx,
+			"UPDATE pipeline_runs SET heartbeat_at = $2 WHERE id = $1", runID, time.Now()); err != nil && ctx.Err() == nil {
+			logger.Warn("Failed to record run heartbeat", zap.String("run_id", runID), zap.Error(err))
+		}
+	}
+}
+
+// RecoverRuns resumes runs left running or waiting for approval by a
+// replica that stopped, found by a heartbeat older than runStaleAfter.
+// Stages that finished keep their outcome; stage Jobs still in the
+// cluster are waited on rather than started again. It returns the number
+// of runs resumed.
+func (s *Service) RecoverRuns(ctx context.Context) (int, error) {
+	if s.runner == nil {
+		return 0, nil
+	}
+	stale := time.Now().Add(-runStaleAfter)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, pipeline_id FROM pipeline_runs
+		WHERE status IN ('running', 'waiting_approval')
+		  AND COALESCE(heartbeat_at, started_at, created_at) < $1
+	`, stale)
+	if err != nil {
+		return 0, errors.DatabaseWrap(err, "failed to list stale runs")
+	}
+	type staleRun struct{ id, pipelineID string }
+	var runs []staleRun
+	for rows.Next() {
+		var r staleRun
+		if err := rows.Scan(&r.id, &r.pipelineID); err != nil {
+			rows.Close()
+			return 0, errors.DatabaseWrap(err, "failed to scan stale run")
+		}
+		runs = append(runs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, errors.DatabaseWrap(err, "failed to list stale runs")
+	}
+
+	resumed := 0
+	for _, r := range runs {
+		// Claim the run so only one replica resumes it
+		result, err := s.db.ExecContext(ctx, `
+			UPDATE pipeline_runs SET heartbeat_at = $3
+			WHERE id = $1 AND COALESCE(heartbeat_at, started_at, created_at) < $2
+		`, r.id, stale, time.Now())
+		if err != nil {
+			return resumed, errors.DatabaseWrap(err, "failed to claim stale run")
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+
+		p, err := s.Get(ctx, r.pipelineID)
+		if err != nil {
+			logger.Warn("Failed to load pipeline of stale run", zap.String("run_id", r.id), zap.Error(err))
+			continue
+		}
+		run, err := s.GetRun(ctx, r.pipelineID, r.id)
+		if err != nil {
+			logger.Warn("Failed to load stale run", zap.String("run_id", r.id), zap.Error(err))
+			continue
+		}
+		if s.startRun(p, run) {
+			logger.Info("Resumed pipeline run",
+				zap.String("pipeline_id", p.ID),
+				zap.String("run_id", run.ID),
+			)
+			resumed++
+		}
+	}
+	return resumed, nil
+}
+
+// RunRecovery resumes stale runs every runRecoveryInterval, starting
+// immediately, until ctx is cancelled
+func (s *Service) RunRecovery(ctx context.Context) {
+	ticker := time.NewTicker(runRecoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.RecoverRuns(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("Pipeline run recovery failed", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopRun cancels an in-flight run started by this replica
+func (s *Service) stopRun(runID string) bool {
+	s.runsMu.Lock()
+	cancel, ok := s.inflight[runID]
+	s.runsMu.Unlock()
+	if ok {
+		cancel(errRunCancelled)
+	}
+	return ok
+}
+
+// runState is a run's stage statuses, shared by stages running in parallel
+type runState struct {
+	mu     sync.Mutex
+	run    *PipelineRun
+	stages map[string]StageStatus
+	clock  *runClock
+	// waiting counts the stages waiting for approval
+	waiting int
+}
+
+// outcome reports whether a stage has finished and, if so, whether it
+// passed
+func (r *runState) outcome(name string) (ok, done bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.stages[name].Status {
+	case StatusSucceeded, StatusSkipped:
+		return true, true
+	case StatusFailed, StatusRejected, StatusCancelled:
+		return false, true
+	}
+	return false, false
+}
+
+// indexedStage is a stage with its position in the pipeline, used to name
+// its Jobs
+type indexedStage struct {
+	index int
+	Stage
+}
+
+// stageGroups splits stages into the batches they run in. Consecutive
+// stages marked Parallel run together; every other stage runs alone.
+func stageGroups(stages []Stage) [][]indexedStage {
+	var groups [][]indexedStage
+	for i, stage := range stages {
+		n := len(groups)
+		if stage.Parallel && n > 0 && groups[n-1][0].Parallel {
+			groups[n-1] = append(groups[n-1], indexedStage{i, stage})
+			continue
+		}
+		groups = append(groups, []indexedStage{{i, stage}})
+	}
+	return groups
+}
+
+// shouldRun evaluates a stage's When condition given whether an earlier
+// stage failed
+func shouldRun(when string, failed bool, variables map[string]string) bool {
+	switch strings.TrimSpace(when) {
+	case "", WhenOnSuccess:
+		return !failed
+	case WhenOnFailure:
+		return failed
+	case WhenAlways:
+		return true
+	}
+	if failed {
+		return false
+	}
+	if name, value, ok := strings.Cut(when, "!="); ok {
+		return variables[strings.TrimSpace(name)] != strings.TrimSpace(value)
+	}
+	if name, value, ok := strings.Cut(when, "=="); ok {
+		return variables[strings.TrimSpace(name)] == strings.TrimSpace(value)
+	}
+	logger.Warn("Unrecognised stage condition, skipping stage", zap.String("when", when))
+	return false
+}
+
+// deploys reports whether stages of stageType change what runs in a
+// cluster
+func deploys(stageType string) bool {
+	return stageType == "deploy" || stageType == "canary"
+}
+
+// fromPullRequest reports whether a pull request webhook started run. Its
+// code is unreviewed, so it is never deployed.
+func (r *PipelineRun) fromPullRequest() bool {
+	return r.TriggerInfo["event"] == "pull_request"
+}
+
+// executeRun runs the pipeline's stages in order and records the outcome.
+// clock, if set, is the run's timeout.
+func (s *Service) executeRun(ctx context.Context, p *Pipeline, run *PipelineRun, clock *runClock) {
+	// A resumed run keeps the stages it already got through
+	state := &runState{run: run, stages: make(map[string]StageStatus), clock: clock}
+	for _, stage := range p.Stages {
+		status, ok := run.StagesStatus[stage.Name]
+		if !ok {
+			status = StageStatus{Status: "pending"}
+		}
+		state.stages[stage.Name] = status
+	}
+
+	run.LogsURL = fmt.Sprintf("/api/v1/pipelines/%s/runs/%s/logs", p.ID, run.ID)
+	if _, err := s.db.ExecContext(context.WithoutCancel(ctx),
+		"UPDATE pipeline_runs SET logs_url = $2 WHERE id = $1", run.ID, run.LogsURL); err != nil {
+		logger.Warn("Failed to record run logs URL", zap.String("run_id", run.ID), zap.Error(err))
+	}
+	s.saveStages(ctx, state, "")
+
+	failed := false
+	for _, group := range stageGroups(p.Stages) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		results := make([]bool, len(group))
+		var wg sync.WaitGroup
+		for i, stage := range group {
+			if ok, done := state.outcome(stage.Name); done {
+				results[i] = ok
+				continue
+			}
+			if !shouldRun(stage.When, failed, run.Variables) {
+				s.setStage(ctx, state, stage.Name, StageStatus{Status: StatusSkipped})
+				results[i] = true
+				continue
+			}
+			if deploys(stage.Type) && run.fromPullRequest() {
+				s.setStage(ctx, state, stage.Name, StageStatus{Status: StatusSkipped, Message: "not run for pull requests"})
+				results[i] = true
+				continue
+			}
+			wg.Add(1)
+			go func(i int, stage indexedStage) {
+				defer wg.Done()
+				switch stage.Type {
+				case "approve":
+					results[i] = s.runApprovalStage(ctx, p, state, stage)
+					return
+				case "canary":
+					results[i] = s.runCanaryStage(ctx, p, state, stage)
+					return
+				}
+				results[i] = s.runStage(ctx, p, state, stage)
+			}(i, stage)
+		}
+		wg.Wait()
+
+		for _, ok := range results {
+			failed = failed || !ok
+		}
+	}
+
+	s.finishRun(ctx, p, state, failed)
+}
+
+// runStage runs one stage, retrying up to the pipeline's RetryCount, and
+// reports whether it succeeded
+func (s *Service) runStage(ctx context.Context, p *Pipeline, state *runState, stage indexedStage) bool {
+	started := time.Now()
+	s.setStage(ctx, state, stage.Name, StageStatus{Status: StatusRunning, StartedAt: &started})
+
+	output := s.stageLogBuffer()
+	var err error
+	for attempt := 1; attempt <= p.RetryCount+1; attempt++ {
+		if p.RetryCount > 0 {
+			fmt.Fprintf(output, "--- attempt %d/%d ---\n", attempt, p.RetryCount+1)
+		}
+		stageCtx, cancel := ctx, context.CancelFunc(func() {})
+		if stage.Timeout > 0 {
+			stageCtx, cancel = context.WithTimeout(ctx, time.Duration(stage.Timeout)*time.Second)
+		}
+		if stage.Type == "deploy" {
+			err = s.deployStage(stageCtx, p, state.run)
+			if err == nil {
+				fmt.Fprintf(output, "synced application %s to revision %q\n", p.ApplicationID, state.run.Variables[revisionVariable])
+			}
+		} else {
+			err = s.runStageJob(stageCtx, p, state.run, stage, attempt, output)
+		}
+		cancel()
+		if err != nil {
+			fmt.Fprintf(output, "error: %v\n", err)
+		}
+
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		logger.Warn("Pipeline stage attempt failed",
+			zap.String("run_id", state.run.ID),
+			zap.String("stage", stage.Name),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+	}
+
+	finished := time.Now()
+	status := StageStatus{
+		Status:     StatusSucceeded,
+		StartedAt:  &started,
+		FinishedAt: &finished,
+		Duration:   int(finished.Sub(started).Seconds()),
+		Logs:       s.storeStageLogs(context.WithoutCancel(ctx), state.run.ID, stage.Name, output.Bytes()),
+	}
+	switch {
+	case err == nil:
+	case goerrors.Is(context.Cause(ctx), errRunCancelled):
+		status.Status = StatusCancelled
+	default:
+		status.Status = StatusFailed
+	}
+	s.setStage(ctx, state, stage.Name, status)
+	return err == nil
+}
+
+// deployStage syncs the pipeline's application to the run's revision
+func (s *Service) deployStage(ctx context.Context, p *Pipeline, run *PipelineRun) error {
+	if s.gitopsService == nil {
+		return fmt.Errorf("gitops service not configured")
+	}
+	_, err := s.gitopsService.Sync(ctx, p.ApplicationID, &gitops.SyncRequest{
+		Revision: run.Variables[revisionVariable],
+	})
+	return err
+}
+
+// runStageJob runs one attempt of a stage as a Job, waits for it to finish
+// and appends its output to output
+func (s *Service) runStageJob(ctx context.Context, p *Pipeline, run *PipelineRun, stage indexedStage, attempt int, output io.Writer) error {
+	job, secret := stageJob(p, run, stage, attempt, s.runnerNamespace)
+	jobs := s.runner.BatchV1().Jobs(s.runnerNamespace)
+	ref := s.runnerNamespace + "/" + job.Name
+
+	if secret != nil {
+		if _, err := s.runner.CoreV1().Secrets(s.runnerNamespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create stage secret: %w", err)
+		}
+	}
+	created, err := jobs.Create(ctx, job, metav1.CreateOptions{})
+	switch {
+	case apierrors.IsAlreadyExists(err):
+		// Started before this run was resumed; wait for it instead
+		fmt.Fprintf(output, "resuming job %s\n", job.Name)
+	case err != nil:
+		return fmt.Errorf("failed to create stage job: %w", err)
+	case secret != nil:
+		s.ownSecret(ctx, secret, created)
+	}
+
+	err = s.waitForJob(ctx, job.Name)
+
+	// Collect output before a cancelled Job's pods are deleted
+	logsCtx, cancelLogs := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	s.collectJobLogs(logsCtx, job.Name, output)
+	cancelLogs()
+
+	if ctx.Err() != nil {
+		// Cancelled or timed out: don't leave the pod running
+		deleteCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		propagation := metav1.DeletePropagationBackground
+		if derr := jobs.Delete(deleteCtx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); derr != nil {
+			logger.Warn("Failed to delete stage job", zap.String("job", ref), zap.Error(derr))
+		}
+	}
+	return err
+}
+
+// ownSecret makes job the owner of its variables Secret, so the Secret is
+// garbage-collected with the Job
+func (s *Service) ownSecret(ctx context.Context, secret *corev1.Secret, job *batchv1.Job) {
+	secret.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Name:       job.Name,
+		UID:        job.UID,
+	}}
+	if _, err := s.runner.CoreV1().Secrets(s.runnerNamespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		logger.Warn("Failed to set stage secret owner",
+			zap.String("secret", s.runnerNamespace+"/"+secret.Name),
+			zap.Error(err),
+		)
+	}
+}
+
+// waitForJob polls a Job until it succeeds, fails or ctx ends
+func (s *Service) waitForJob(ctx context.Context, name string) error {
+	interval := s.pollInterval
+	if interval <= 0 {
+		interval = defaultJobPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := s.runner.BatchV1().Jobs(s.runnerNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to get stage job: %w", err)
+		}
+		if err == nil {
+			if job.Status.Succeeded > 0 {
+				return nil
+			}
+			if job.Status.Failed > 0 {
+				for _, cond := range job.Status.Conditions {
+					if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+						return fmt.Errorf("stage job %s failed: %s", name, cond.Message)
+					}
+				}
+				return fmt.Errorf("stage job %s failed", name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// stageJob builds the Job for one attempt of a stage, named after the run,
+// stage and attempt, and the Secret named like it that holds the run's
+// variables, or nil without any. Variables reach the container through
+// the Secret; the stage's own Env, which overrides them, is set directly.
+// Commands run in order under "sh -e"; with no commands the image's
+// entrypoint runs.
+func stageJob(p *Pipeline, run *PipelineRun, stage indexedStage, attempt int, namespace string) (*batchv1.Job, *corev1.Secret) {
+	name := fmt.Sprintf("krustron-%s-%d-%d", run.ID, stage.index, attempt)
+
+	variables := make(map[string]string, len(run.Variables))
+	for k, v := range run.Variables {
+		if _, ok := stage.Env[k]; !ok {
+			variables[k] = v
+		}
+	}
+	names := make([]string, 0, len(variables)+len(stage.Env))
+	for k := range variables {
+		names = append(names, k)
+	}
+	for k := range stage.Env {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	envVars := make([]corev1.EnvVar, 0, len(names))
+	for _, k := range names {
+		if _, ok := variables[k]; !ok {
+			envVars = append(envVars, corev1.EnvVar{Name: k, Value: stage.Env[k]})
+			continue
+		}
+		envVars = append(envVars, corev1.EnvVar{Name: k, ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: k},
+		}})
+	}
+
+	container := corev1.Container{
+		Name:  "stage",
+		Image: stage.Image,
+		Env:   envVars,
+	}
+	if len(stage.Commands) > 0 {
+		container.Command = []string{"/bin/sh", "-ec", strings.Join(stage.Commands, "\n")}
+	}
+
+	labels := map[string]string{
+		labelPipeline: p.ID,
+		labelRun:      run.ID,
+		labelStage:    fmt.Sprintf("%d", stage.index),
+	}
+	backoff := int32(0)
+	ttl := jobTTLAfterFinished
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: map[string]string{"krustron.io/stage-name": stage.Name},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoff,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{container},
+				},
+			},
+		},
+	}
+	if stage.Timeout > 0 {
+		deadline := int64(stage.Timeout)
+		job.Spec.ActiveDeadlineSeconds = &deadline
+	}
+	if len(variables) == 0 {
+		return job, nil
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		StringData: variables,
+	}
+	return job, secret
+}
+
+// setStage records a stage's status and persists the run's stage map
+func (s *Service) setStage(ctx context.Context, state *runState, name string, status StageStatus) {
+	state.mu.Lock()
+	state.stages[name] = status
+	state.mu.Unlock()
+
+	current := ""
+	if status.Status == StatusRunning || status.Status == StatusWaitingApproval {
+		current = name
+	}
+	s.saveStages(ctx, state, current)
+
+	if s.emitter != nil {
+		s.emitter.EmitPipelineStatus(state.run.PipelineID, map[string]interface{}{
+			"run_id": state.run.ID,
+			"stage":  name,
+			"status": status.Status,
+		})
+	}
+}
+
+func (s *Service) saveStages(ctx context.Context, state *runState, current string) {
+	state.mu.Lock()
+	stages, _ := json.Marshal(state.stages)
+	state.run.StagesStatus = make(map[string]StageStatus, len(state.stages))
+	for k, v := range state.stages {
+		state.run.StagesStatus[k] = v
+	}
+	state.mu.Unlock()
+
+	query := "UPDATE pipeline_runs SET stages_status = $2 WHERE id = $1"
+	args := []interface{}{state.run.ID, stages}
+	if current != "" {
+		query = "UPDATE pipeline_runs SET stages_status = $2, current_stage = $3 WHERE id = $1"
+		args = append(args, current)
+	}
+	if _, err := s.db.ExecContext(context.WithoutCancel(ctx), query, args...); err != nil {
+		logger.Warn("Failed to save pipeline stage status", zap.String("run_id", state.run.ID), zap.Error(err))
+	}
+}
+
+// finishRun records the run's final status and duration. A run already
+// marked cancelled by CancelRun is left as it is.
+func (s *Service) finishRun(ctx context.Context, p *Pipeline, state *runState, failed bool) {
+	run := state.run
+	status, message := StatusSucceeded, ""
+	switch cause := context.Cause(ctx); {
+	case goerrors.Is(cause, errRunCancelled):
+		status = StatusCancelled
+	case goerrors.Is(cause, errRunTimedOut):
+		status, message = StatusFailed, fmt.Sprintf("pipeline timed out after %ds", p.Timeout)
+	case failed:
+		status, message = StatusFailed, "one or more stages failed"
+	}
+
+	finished := time.Now()
+	duration := 0
+	if run.StartedAt != nil {
+		elapsed := finished.Sub(*run.StartedAt)
+		duration = int(elapsed.Seconds())
+		metrics.PipelineRunDuration.WithLabelValues(status).Observe(elapsed.Seconds())
+	}
+
+	dbCtx := context.WithoutCancel(ctx)
+	if _, err := s.db.ExecContext(dbCtx, `
+		UPDATE pipeline_runs
+		SET status = $2, finished_at = $3, duration = $4, error_message = $5
+		WHERE id = $1 AND status IN ('running', 'waiting_approval')
+	`, run.ID, status, finished, duration, message); err != nil {
+		logger.Error("Failed to finalize pipeline run", zap.String("run_id", run.ID), zap.Error(err))
+	}
+	s.db.ExecContext(dbCtx, "UPDATE pipelines SET last_run_status = $2 WHERE id = $1", p.ID, status)
+
+	run.Status = status
+	run.FinishedAt = &finished
+	run.Duration = duration
+	run.ErrorMessage = message
+
+	if s.emitter != nil {
+		s.emitter.EmitPipelineStatus(p.ID, map[string]interface{}{
+			"run_id":     run.ID,
+			"run_number": run.RunNumber,
+			"status":     status,
+			"duration":   duration,
+		})
+	}
+
+	logger.Info("Pipeline run finished",
+		zap.String("pipeline_id", p.ID),
+		zap.String("run_id", run.ID),
+		zap.String("status", status),
+		zap.Int("duration", duration),
+	)
+}
+
+// deleteRunJobs removes every Job belonging to a run, including ones
+// started by another replica
+func (s *Service) deleteRunJobs(ctx context.Context, runID string) {
+	if s.runner == nil {
+		return
+	}
+	jobs := s.runner.BatchV1().Jobs(s.runnerNamespace)
+	list, err := jobs.List(ctx, metav1.ListOptions{LabelSelector: labelRun + "=" + runID})
+	if err != nil {
+		logger.Warn("Failed to list run jobs", zap.String("run_id", runID), zap.Error(err))
+		return
+	}
+	propagation := metav1.DeletePropagationBackground
+	for _, job := range list.Items {
+		if job.Status.Succeeded > 0 || job.Status.Failed > 0 {
+			continue
+		}
+		if err := jobs.Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+			logger.Warn("Failed to delete run job", zap.String("job", job.Name), zap.Error(err))
+		}
+	}
+}
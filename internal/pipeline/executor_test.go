@@ -0,0 +1,333 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// jobOutcome decides how a created stage Job ends: true succeeds, false
+// fails, nil leaves it running
+type jobOutcome func(job *batchv1.Job) *bool
+
+// newExecutorTestService wires a fake cluster whose Jobs finish as soon as
+// they're created, according to outcome. Each Job gets a pod, whose logs
+// the fake clientset always reports as "fake logs".
+func newExecutorTestService(t *testing.T, outcome jobOutcome) (*Service, *fake.Clientset, *[]*batchv1.Job) {
+	t.Helper()
+	svc := newTestService(t)
+
+	clientset := fake.NewSimpleClientset()
+	var mu sync.Mutex
+	var created []*batchv1.Job
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		mu.Lock()
+		created = append(created, job.DeepCopy())
+		mu.Unlock()
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name + "-pod",
+			Namespace: job.Namespace,
+			Labels:    map[string]string{"job-name": job.Name},
+		}}
+		if err := clientset.Tracker().Add(pod); err != nil {
+			return true, nil, err
+		}
+		if ok := outcome(job); ok != nil {
+			if *ok {
+				job.Status.Succeeded = 1
+			} else {
+				job.Status.Failed = 1
+			}
+		}
+		return false, nil, nil
+	})
+
+	svc.SetRunner(clientset, "runners")
+	svc.pollInterval = time.Millisecond
+	return svc, clientset, &created
+}
+
+// insertPipeline stores p so the service can look it up by ID
+func insertPipeline(t *testing.T, svc *Service, p *Pipeline) {
+	t.Helper()
+	stages, err := json.Marshal(p.Stages)
+	require.NoError(t, err)
+	now := time.Now()
+	_, err = svc.db.Exec(`INSERT INTO pipelines VALUES
+		($1, 'release', '', '', 'app-1', 'manual', '', $2, '{}', 0, $3, true, NULL, NULL, 'u', $4, $4, '[]', false)`,
+		p.ID, string(stages), p.RetryCount, now)
+	require.NoError(t, err)
+}
+
+func insertRun(t *testing.T, svc *Service, p *Pipeline, variables map[string]string) *PipelineRun {
+	t.Helper()
+	now := time.Now()
+	_, err := svc.db.Exec(`INSERT INTO pipeline_runs (id, pipeline_id, run_number, status, trigger,
+		trigger_info, stages_status, variables, artifacts, duration, created_by, created_at)
+		VALUES ('run-1234567890', $1, 1, 'running', 'manual', '{}', '{}', '{}', '[]', 0, 'u', $2)`, p.ID, now)
+	require.NoError(t, err)
+	return &PipelineRun{ID: "run-1234567890", PipelineID: p.ID, RunNumber: 1, Status: StatusRunning, Variables: variables, StartedAt: &now}
+}
+
+func storedRun(t *testing.T, svc *Service) (string, map[string]StageStatus, string) {
+	t.Helper()
+	var status, logsURL string
+	var stages []byte
+	require.NoError(t, svc.db.QueryRow(
+		"SELECT status, stages_status, COALESCE(logs_url, '') FROM pipeline_runs WHERE id = 'run-1234567890'",
+	).Scan(&status, &stages, &logsURL))
+	var parsed map[string]StageStatus
+	require.NoError(t, json.Unmarshal(stages, &parsed))
+	return status, parsed, logsURL
+}
+
+// runDurationCount is how many finished runs with status have been observed
+func runDurationCount(t *testing.T, status string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	observer := metrics.PipelineRunDuration.WithLabelValues(status).(prometheus.Metric)
+	require.NoError(t, observer.Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func succeed(*batchv1.Job) *bool { ok := true; return &ok }
+
+func TestExecuteRunRunsStagesAsJobs(t *testing.T) {
+	svc, clientset, created := newExecutorTestService(t, succeed)
+	p := &Pipeline{ID: "p-1", Stages: []Stage{
+		{Name: "build", Image: "golang:1.24", Commands: []string{"go build ./...", "go vet ./..."}, Env: map[string]string{"CGO_ENABLED": "0"}},
+		{Name: "unit", Image: "golang:1.24", Parallel: true},
+		{Name: "lint", Image: "golangci/golangci-lint", Parallel: true, Timeout: 60},
+		{Name: "notify", Image: "curl", When: WhenOnFailure},
+	}}
+	run := insertRun(t, svc, p, map[string]string{"BRANCH": "main"})
+	observed := runDurationCount(t, StatusSucceeded)
+
+	svc.executeRun(context.Background(), p, run, nil)
+
+	status, stages, logsURL := storedRun(t, svc)
+	assert.Equal(t, StatusSucceeded, status)
+	assert.Equal(t, observed+1, runDurationCount(t, StatusSucceeded))
+	assert.Equal(t, "/api/v1/pipelines/p-1/runs/run-1234567890/logs", logsURL)
+	assert.Equal(t, StatusSucceeded, stages["build"].Status)
+	assert.Equal(t, StatusSucceeded, stages["unit"].Status)
+	assert.Equal(t, StatusSucceeded, stages["lint"].Status)
+	assert.Equal(t, StatusSkipped, stages["notify"].Status)
+	assert.Equal(t, "db:run-1234567890/build", stages["build"].Logs)
+
+	require.Len(t, *created, 3)
+	build := (*created)[0]
+	assert.Equal(t, "krustron-run-1234567890-0-1", build.Name, "named after the whole run ID")
+	assert.Equal(t, "runners", build.Namespace)
+	assert.Equal(t, "run-1234567890", build.Labels[labelRun])
+	assert.Equal(t, []string{"/bin/sh", "-ec", "go build ./...\ngo vet ./..."}, build.Spec.Template.Spec.Containers[0].Command)
+	env := build.Spec.Template.Spec.Containers[0].Env
+	require.Len(t, env, 2)
+	assert.Equal(t, "BRANCH", env[0].Name)
+	assert.Empty(t, env[0].Value, "run variables are not in the Job")
+	require.NotNil(t, env[0].ValueFrom)
+	assert.Equal(t, build.Name, env[0].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, "BRANCH", env[0].ValueFrom.SecretKeyRef.Key)
+	assert.Equal(t, corev1.EnvVar{Name: "CGO_ENABLED", Value: "0"}, env[1])
+	assert.Equal(t, int32(0), *build.Spec.BackoffLimit)
+
+	secret, err := clientset.CoreV1().Secrets("runners").Get(context.Background(), build.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"BRANCH": "main"}, secret.StringData)
+	require.Len(t, secret.OwnerReferences, 1)
+	assert.Equal(t, "Job", secret.OwnerReferences[0].Kind)
+	assert.Equal(t, build.Name, secret.OwnerReferences[0].Name)
+
+	var lint *batchv1.Job
+	for _, job := range *created {
+		if job.Annotations["krustron.io/stage-name"] == "lint" {
+			lint = job
+		}
+	}
+	require.NotNil(t, lint)
+	assert.Equal(t, int64(60), *lint.Spec.ActiveDeadlineSeconds)
+}
+
+func TestPullRequestRunsNeverDeploy(t *testing.T) {
+	svc, _, created := newExecutorTestService(t, succeed)
+	p := &Pipeline{ID: "p-1", Stages: []Stage{
+		{Name: "test", Image: "golang:1.24"},
+		{Name: "deploy", Type: "deploy"},
+		{Name: "rollout", Type: "canary"},
+	}}
+	run := insertRun(t, svc, p, nil)
+	run.TriggerInfo = map[string]interface{}{"event": "pull_request", "pull_request": 42}
+
+	svc.executeRun(context.Background(), p, run, nil)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusSucceeded, status)
+	assert.Equal(t, StatusSucceeded, stages["test"].Status)
+	assert.Equal(t, StatusSkipped, stages["deploy"].Status)
+	assert.Equal(t, StatusSkipped, stages["rollout"].Status)
+	assert.Len(t, *created, 1)
+}
+
+func TestExecuteRunRetriesAndHandlesFailure(t *testing.T) {
+	attempts := map[string]int{}
+	var mu sync.Mutex
+	svc, _, _ := newExecutorTestService(t, func(job *batchv1.Job) *bool {
+		mu.Lock()
+		defer mu.Unlock()
+		stage := job.Annotations["krustron.io/stage-name"]
+		attempts[stage]++
+		// test is flaky once; deploy-check always fails
+		ok := !(stage == "test" && attempts[stage] == 1) && stage != "deploy-check"
+		return &ok
+	})
+	p := &Pipeline{ID: "p-1", RetryCount: 1, Stages: []Stage{
+		{Name: "test", Image: "alpine"},
+		{Name: "deploy-check", Image: "alpine"},
+		{Name: "publish", Image: "alpine"},
+		{Name: "cleanup", Image: "alpine", When: WhenAlways},
+		{Name: "report", Image: "alpine", When: WhenOnFailure},
+	}}
+	run := insertRun(t, svc, p, nil)
+
+	svc.executeRun(context.Background(), p, run, nil)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusFailed, status)
+	assert.Equal(t, StatusSucceeded, stages["test"].Status)
+	assert.Equal(t, StatusFailed, stages["deploy-check"].Status)
+	assert.Equal(t, StatusSkipped, stages["publish"].Status)
+	assert.Equal(t, StatusSucceeded, stages["cleanup"].Status)
+	assert.Equal(t, StatusSucceeded, stages["report"].Status)
+	assert.Equal(t, 2, attempts["test"])
+	assert.Equal(t, 2, attempts["deploy-check"])
+	assert.Equal(t, 0, attempts["publish"])
+}
+
+func TestStopRunDeletesInFlightJob(t *testing.T) {
+	svc, clientset, created := newExecutorTestService(t, func(*batchv1.Job) *bool { return nil })
+	p := &Pipeline{ID: "p-1", Stages: []Stage{{Name: "hang", Image: "alpine"}, {Name: "after", Image: "alpine"}}}
+	run := insertRun(t, svc, p, nil)
+
+	svc.startRun(p, run)
+	require.Eventually(t, func() bool {
+		list, err := clientset.BatchV1().Jobs("runners").List(context.Background(), metav1.ListOptions{})
+		return err == nil && len(list.Items) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	_, err := svc.db.Exec("UPDATE pipeline_runs SET status = 'cancelled' WHERE id = 'run-1234567890'")
+	require.NoError(t, err)
+	require.True(t, svc.stopRun(run.ID))
+
+	require.Eventually(t, func() bool {
+		svc.runsMu.Lock()
+		defer svc.runsMu.Unlock()
+		return len(svc.inflight) == 0
+	}, 5*time.Second, time.Millisecond)
+
+	list, err := clientset.BatchV1().Jobs("runners").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, list.Items)
+	assert.Len(t, *created, 1)
+
+	status, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusCancelled, status)
+	assert.Equal(t, StatusCancelled, stages["hang"].Status)
+	assert.Equal(t, "pending", stages["after"].Status)
+}
+
+func TestRecoverRunsResumesStaleRuns(t *testing.T) {
+	svc, clientset, created := newExecutorTestService(t, succeed)
+	p := &Pipeline{ID: "p-1", Stages: []Stage{
+		{Name: "build", Image: "alpine"},
+		{Name: "test", Image: "alpine"},
+		{Name: "publish", Image: "alpine"},
+	}}
+	insertPipeline(t, svc, p)
+	stale := time.Now().Add(-time.Hour)
+	insert := func(id string, heartbeat time.Time) {
+		_, err := svc.db.Exec(`INSERT INTO pipeline_runs (id, pipeline_id, run_number, status, trigger,
+			trigger_info, stages_status, variables, artifacts, duration, created_by, started_at, created_at, heartbeat_at)
+			VALUES ($1, $2, 1, 'running', 'manual', '{}', $3, '{}', '[]', 0, 'u', $4, $4, $5)`,
+			id, p.ID, `{"build":{"status":"succeeded"},"test":{"status":"running"}}`, stale, heartbeat)
+		require.NoError(t, err)
+	}
+	// run-1234567890 was left by a stopped replica; run-live is still
+	// being executed elsewhere
+	insert("run-1234567890", stale)
+	insert("run-live", time.Now())
+	// test's Job finished while no replica was watching
+	_, err := clientset.BatchV1().Jobs("runners").Create(context.Background(), &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "krustron-run-1234567890-1-1", Namespace: "runners"},
+		Status:     batchv1.JobStatus{Succeeded: 1},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	*created = nil
+
+	resumed, err := svc.RecoverRuns(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, resumed)
+	require.Eventually(t, func() bool {
+		status, _, _ := storedRun(t, svc)
+		return status == StatusSucceeded
+	}, 5*time.Second, time.Millisecond)
+
+	_, stages, _ := storedRun(t, svc)
+	assert.Equal(t, StatusSucceeded, stages["test"].Status)
+	assert.Equal(t, StatusSucceeded, stages["publish"].Status)
+	var names []string
+	for _, job := range *created {
+		names = append(names, job.Name)
+	}
+	assert.Equal(t, []string{"krustron-run-1234567890-1-1", "krustron-run-1234567890-2-1"}, names,
+		"build is not rerun and test's existing Job is adopted")
+
+	// Finished and live runs are left alone
+	resumed, err = svc.RecoverRuns(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, resumed)
+}
+
+func TestStageGroups(t *testing.T) {
+	groups := stageGroups([]Stage{
+		{Name: "a"}, {Name: "b", Parallel: true}, {Name: "c", Parallel: true}, {Name: "d"}, {Name: "e", Parallel: true},
+	})
+	var names []string
+	for _, g := range groups {
+		var group []string
+		for _, s := range g {
+			group = append(group, s.Name)
+		}
+		names = append(names, strings.Join(group, "+"))
+	}
+	assert.Equal(t, []string{"a", "b+c", "d", "e"}, names)
+}
+
+func TestShouldRun(t *testing.T) {
+	vars := map[string]string{"BRANCH": "main"}
+	assert.True(t, shouldRun("", false, vars))
+	assert.False(t, shouldRun("", true, vars))
+	assert.True(t, shouldRun(WhenOnFailure, true, vars))
+	assert.False(t, shouldRun(WhenOnFailure, false, vars))
+	assert.True(t, shouldRun(WhenAlways, true, vars))
+	assert.True(t, shouldRun("BRANCH == main", false, vars))
+	assert.False(t, shouldRun("BRANCH==release", false, vars))
+	assert.True(t, shouldRun("BRANCH != release", false, vars))
+	assert.False(t, shouldRun("BRANCH == main", true, vars))
+	assert.False(t, shouldRun("nonsense", false, vars))
+}
@@ -0,0 +1,339 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// defaultLogMaxBytes caps the output kept per stage when SetLogSink
+	// isn't given a limit
+	defaultLogMaxBytes = int64(1 << 20)
+	// logRetentionInterval is how often RunLogRetention purges old logs
+	logRetentionInterval = time.Hour
+)
+
+// ErrLogsNotFound is returned by a LogSink that holds no logs for a stage
+var ErrLogsNotFound = goerrors.New("logs not found")
+
+// LogSink stores the output of pipeline stages
+type LogSink interface {
+	// Put stores a stage's output, replacing any stored before, and returns
+	// a reference to where it was written
+	Put(ctx context.Context, runID, stage string, content []byte) (string, error)
+	// Open streams a stage's stored output. It returns ErrLogsNotFound if
+	// nothing was stored.
+	Open(ctx context.Context, runID, stage string) (io.ReadCloser, error)
+	// Purge deletes output stored before cutoff and returns how many
+	// stages' logs were removed
+	Purge(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// NewLogSink returns the sink configured by cfg
+func NewLogSink(db *database.PostgresDB, cfg *config.PipelineLogsConfig) (LogSink, error) {
+	switch cfg.Backend {
+	case "", "database":
+		return NewDatabaseLogSink(db), nil
+	case "s3":
+		return NewS3LogSink(&cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown pipeline log backend %q", cfg.Backend)
+	}
+}
+
+// SetLogSink sets where stage output is stored and how much of it is kept
+// per stage. The service stores logs in the database until this is called.
+func (s *Service) SetLogSink(sink LogSink, maxBytes int64) {
+	s.logs = sink
+	s.logMaxBytes = maxBytes
+}
+
+// DatabaseLogSink stores stage output in the pipeline_run_logs table. It
+// suits small logs; use object storage for verbose pipelines.
+type DatabaseLogSink struct {
+	db *database.PostgresDB
+}
+
+// NewDatabaseLogSink creates a sink backed by db
+func NewDatabaseLogSink(db *database.PostgresDB) *DatabaseLogSink {
+	return &DatabaseLogSink{db: db}
+}
+
+// Put implements LogSink
+func (d *DatabaseLogSink) Put(ctx context.Context, runID, stage string, content []byte) (string, error) {
+	if _, err := d.db.ExecContext(ctx, `
+		INSERT INTO pipeline_run_logs (run_id, stage, content, size, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (run_id, stage) DO UPDATE
+		SET content = EXCLUDED.content, size = EXCLUDED.size, created_at = EXCLUDED.created_at
+	`, runID, stage, content, len(content), time.Now()); err != nil {
+		return "", err
+	}
+	return "db:" + runID + "/" + stage, nil
+}
+
+// Open implements LogSink
+func (d *DatabaseLogSink) Open(ctx context.Context, runID, stage string) (io.ReadCloser, error) {
+	var content []byte
+	err := d.db.QueryRowContext(ctx,
+		"SELECT content FROM pipeline_run_logs WHERE run_id = $1 AND stage = $2", runID, stage,
+	).Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil, ErrLogsNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Purge implements LogSink
+func (d *DatabaseLogSink) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM pipeline_run_logs WHERE created_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// GetRunLogs streams a run's stored stage output. With a stage name only
+// that stage's output is returned; otherwise every stage with stored
+// output follows in pipeline order, each under a "==> stage <==" header.
+func (s *Service) GetRunLogs(ctx context.Context, pipelineID, runID, stage string) (io.ReadCloser, error) {
+	run, err := s.GetRun(ctx, pipelineID, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	if stage != "" {
+		if _, ok := run.StagesStatus[stage]; !ok {
+			return nil, errors.NotFound("stage", stage)
+		}
+		rc, err := s.logs.Open(ctx, runID, stage)
+		if goerrors.Is(err, ErrLogsNotFound) {
+			return nil, errors.NotFoundMsg("no logs stored for stage " + stage)
+		}
+		if err != nil {
+			return nil, errors.PipelineWrap(err, "failed to read stage logs")
+		}
+		return rc, nil
+	}
+
+	stages, err := s.runStageOrder(ctx, pipelineID, run)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.copyStageLogs(ctx, pw, runID, stages))
+	}()
+	return pr, nil
+}
+
+// runStageOrder lists the run's stages in pipeline order. Stages no longer
+// in the pipeline follow, sorted by name.
+func (s *Service) runStageOrder(ctx context.Context, pipelineID string, run *PipelineRun) ([]string, error) {
+	p, err := s.Get(ctx, pipelineID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(p.Stages))
+	var names []string
+	for _, stage := range p.Stages {
+		if _, ok := run.StagesStatus[stage.Name]; ok {
+			names = append(names, stage.Name)
+			seen[stage.Name] = true
+		}
+	}
+	var rest []string
+	for name := range run.StagesStatus {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...), nil
+}
+
+func (s *Service) copyStageLogs(ctx context.Context, w io.Writer, runID string, stages []string) error {
+	for _, stage := range stages {
+		rc, err := s.logs.Open(ctx, runID, stage)
+		if goerrors.Is(err, ErrLogsNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read logs for stage %s: %w", stage, err)
+		}
+		_, err = fmt.Fprintf(w, "==> %s <==\n", stage)
+		if err == nil {
+			err = copyLine(w, rc)
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyLine copies r to w, ending with a newline if r didn't
+func copyLine(w io.Writer, r io.Reader) error {
+	last := &lastByteWriter{w: w}
+	if _, err := io.Copy(last, r); err != nil {
+		return err
+	}
+	if last.n > 0 && last.b != '\n' {
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	return nil
+}
+
+type lastByteWriter struct {
+	w io.Writer
+	b byte
+	n int64
+}
+
+func (l *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := l.w.Write(p)
+	if n > 0 {
+		l.b = p[n-1]
+		l.n += int64(n)
+	}
+	return n, err
+}
+
+// RunLogRetention deletes stage logs older than retention every hour until
+// ctx is cancelled. A zero retention keeps logs forever.
+func (s *Service) RunLogRetention(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(logRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := s.logs.Purge(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			logger.Warn("Pipeline log retention sweep failed", zap.Error(err))
+		case n > 0:
+			logger.Info("Purged expired pipeline logs", zap.Int("stages", n))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it. Once full it is a
+// ring: new output overwrites the oldest from start onwards.
+type tailBuffer struct {
+	max     int64
+	buf     []byte
+	start   int
+	dropped int64
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	max := int(t.max)
+	if n >= max {
+		// p alone fills the buffer
+		t.dropped += int64(len(t.buf) + n - max)
+		t.buf = append(t.buf[:0], p[n-max:]...)
+		t.start = 0
+		return n, nil
+	}
+	if free := max - len(t.buf); free > 0 {
+		fill := min(free, len(p))
+		t.buf = append(t.buf, p[:fill]...)
+		p = p[fill:]
+	}
+	if len(p) > 0 {
+		copied := copy(t.buf[t.start:], p)
+		copy(t.buf, p[copied:])
+		t.start = (t.start + len(p)) % max
+		t.dropped += int64(len(p))
+	}
+	return n, nil
+}
+
+// Bytes returns the kept output, noting how much was cut from the front
+func (t *tailBuffer) Bytes() []byte {
+	out := t.buf
+	if t.start > 0 {
+		out = append(append(make([]byte, 0, len(t.buf)), t.buf[t.start:]...), t.buf[:t.start]...)
+	}
+	if t.dropped == 0 {
+		return out
+	}
+	return append([]byte(fmt.Sprintf("[... %d bytes truncated ...]\n", t.dropped)), out...)
+}
+
+// stageLogBuffer collects a stage's output across attempts
+func (s *Service) stageLogBuffer() *tailBuffer {
+	max := s.logMaxBytes
+	if max <= 0 {
+		max = defaultLogMaxBytes
+	}
+	return &tailBuffer{max: max}
+}
+
+// collectJobLogs appends the output of a stage Job's pods to w. The Job
+// controller labels its pods with the Job's name.
+func (s *Service) collectJobLogs(ctx context.Context, jobName string, w io.Writer) {
+	pods := s.runner.CoreV1().Pods(s.runnerNamespace)
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + jobName})
+	if err != nil {
+		logger.Warn("Failed to list stage job pods", zap.String("job", jobName), zap.Error(err))
+		return
+	}
+	for _, pod := range list.Items {
+		stream, err := pods.GetLogs(pod.Name, &corev1.PodLogOptions{Container: "stage"}).Stream(ctx)
+		if err != nil {
+			logger.Warn("Failed to read stage pod logs", zap.String("pod", pod.Name), zap.Error(err))
+			continue
+		}
+		if err := copyLine(w, stream); err != nil {
+			logger.Warn("Failed to read stage pod logs", zap.String("pod", pod.Name), zap.Error(err))
+		}
+		stream.Close()
+	}
+}
+
+// storeStageLogs writes a stage's collected output to the log sink and
+// returns its reference, or "" if it couldn't be stored
+func (s *Service) storeStageLogs(ctx context.Context, runID, stage string, content []byte) string {
+	if s.logs == nil || len(content) == 0 {
+		return ""
+	}
+	ref, err := s.logs.Put(ctx, runID, stage, content)
+	if err != nil {
+		logger.Warn("Failed to store stage logs",
+			zap.String("run_id", runID),
+			zap.String("stage", stage),
+			zap.Error(err),
+		)
+		return ""
+	}
+	return ref
+}
@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/sigv4"
+)
+
+// S3LogSink stores stage output as objects in an S3-compatible bucket,
+// one object per stage under <prefix>/<run id>/
+type S3LogSink struct {
+	client    *http.Client
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	now       func() time.Time
+}
+
+// NewS3LogSink creates a sink writing to the bucket described by cfg.
+// Requests are signed with AWS Signature Version 4 when credentials are
+// set.
+func NewS3LogSink(cfg *config.S3Config) (*S3LogSink, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 log sink requires an endpoint and a bucket")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3LogSink{
+		client:    &http.Client{Timeout: 60 * time.Second},
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    cfg.Bucket,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		accessKey: cfg.AccessKeyID,
+		secretKey: cfg.SecretAccessKey,
+		pathStyle: cfg.PathStyle,
+		now:       time.Now,
+	}, nil
+}
+
+// objectKey names the object holding a stage's output. Stage names are
+// escaped so one can't reach outside its run's directory.
+func (s *S3LogSink) objectKey(runID, stage string) string {
+	return path.Join(s.prefix, url.PathEscape(runID), url.PathEscape(stage)+".log")
+}
+
+// Put implements LogSink
+func (s *S3LogSink) Put(ctx context.Context, runID, stage string, content []byte) (string, error) {
+	key := s.objectKey(runID, stage)
+	resp, err := s.do(ctx, http.MethodPut, key, nil, content)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+// Open implements LogSink
+func (s *S3LogSink) Open(ctx context.Context, runID, stage string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectKey(runID, stage), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Purge implements LogSink
+func (s *S3LogSink) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	prefix := s.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	purged := 0
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return purged, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return purged, fmt.Errorf("failed to decode s3 listing: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			if !obj.LastModified.Before(cutoff) {
+				continue
+			}
+			resp, err := s.do(ctx, http.MethodDelete, obj.Key, nil, nil)
+			if err != nil {
+				return purged, err
+			}
+			resp.Body.Close()
+			purged++
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return purged, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// do sends a signed request for key (the bucket itself when key is empty).
+// A missing object is reported as ErrLogsNotFound and any other non-2xx
+// response as an error; otherwise the caller closes the response body.
+func (s *S3LogSink) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	objectPath := "/" + key
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + objectPath
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + objectPath
+	u.RawPath = sigv4.EscapePath(u.Path)
+	u.RawQuery = sigv4.CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, key, err)
+	}
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet && key != "" {
+		resp.Body.Close()
+		return nil, ErrLogsNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req. Without credentials
+// the request is sent anonymously.
+func (s *S3LogSink) sign(req *http.Request, body []byte) {
+	req.Header.Set("x-amz-content-sha256", sigv4.HexSHA256(body))
+	if s.accessKey == "" {
+		return
+	}
+	sigv4.SignRequest(req, body, sigv4.Credentials{AccessKeyID: s.accessKey, SecretAccessKey: s.secretKey},
+		s.region, "s3", s.now())
+}
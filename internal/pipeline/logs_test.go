@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+func readLogs(t *testing.T, svc *Service, stage string) string {
+	t.Helper()
+	rc, err := svc.GetRunLogs(context.Background(), "p-1", "run-1234567890", stage)
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRunLogsStoredPerStage(t *testing.T) {
+	attempts := 0
+	var mu sync.Mutex
+	svc, _, _ := newExecutorTestService(t, func(job *batchv1.Job) *bool {
+		mu.Lock()
+		defer mu.Unlock()
+		ok := true
+		if job.Annotations["krustron.io/stage-name"] == "test" {
+			attempts++
+			ok = attempts > 1
+		}
+		return &ok
+	})
+	p := &Pipeline{ID: "p-1", RetryCount: 1, Stages: []Stage{
+		{Name: "build", Image: "golang:1.24"},
+		{Name: "test", Image: "golang:1.24"},
+		{Name: "notify", Image: "curl", When: WhenOnFailure},
+	}}
+	insertPipeline(t, svc, p)
+	run := insertRun(t, svc, p, nil)
+
+	svc.executeRun(context.Background(), p, run, nil)
+
+	_, stages, logsURL := storedRun(t, svc)
+	assert.Equal(t, "/api/v1/pipelines/p-1/runs/run-1234567890/logs", logsURL)
+	assert.Equal(t, "db:run-1234567890/build", stages["build"].Logs)
+	assert.Equal(t, "db:run-1234567890/test", stages["test"].Logs)
+	assert.Empty(t, stages["notify"].Logs)
+
+	assert.Equal(t, "--- attempt 1/2 ---\nfake logs\n", readLogs(t, svc, "build"))
+	assert.Equal(t, "--- attempt 1/2 ---\nfake logs\nerror: stage job krustron-run-1234567890-1-1 failed\n"+
+		"--- attempt 2/2 ---\nfake logs\n", readLogs(t, svc, "test"))
+
+	// Without a stage every stored stage follows in pipeline order
+	assert.Equal(t, "==> build <==\n--- attempt 1/2 ---\nfake logs\n"+
+		"==> test <==\n"+readLogs(t, svc, "test"), readLogs(t, svc, ""))
+
+	_, err := svc.GetRunLogs(context.Background(), "p-1", "run-1234567890", "notify")
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+	_, err = svc.GetRunLogs(context.Background(), "p-1", "run-1234567890", "missing")
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+	_, err = svc.GetRunLogs(context.Background(), "p-2", "run-1234567890", "build")
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+
+	// Retention removes logs stored before the cutoff
+	n, err := svc.logs.Purge(context.Background(), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	_, err = svc.GetRunLogs(context.Background(), "p-1", "run-1234567890", "build")
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+}
+
+func TestTailBufferKeepsEnd(t *testing.T) {
+	buf := &tailBuffer{max: 8}
+	io.WriteString(buf, "0123456789")
+	io.WriteString(buf, "abc")
+	assert.Equal(t, "[... 5 bytes truncated ...]\n56789abc", string(buf.Bytes()))
+
+	buf = &tailBuffer{max: 8}
+	io.WriteString(buf, "short")
+	assert.Equal(t, "short", string(buf.Bytes()))
+
+	// Small writes wrap around the ring
+	buf = &tailBuffer{max: 8}
+	for _, line := range []string{"abc", "def", "ghi", "jkl"} {
+		io.WriteString(buf, line)
+	}
+	assert.Equal(t, "[... 4 bytes truncated ...]\nefghijkl", string(buf.Bytes()))
+	io.WriteString(buf, "0123456789")
+	assert.Equal(t, "[... 14 bytes truncated ...]\n23456789", string(buf.Bytes()))
+}
+
+// fakeS3 is an in-memory bucket speaking just enough of the S3 API for
+// the log sink
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+	auth    []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/logs-bucket/")
+	if r.URL.Path == "/logs-bucket" || r.URL.Path == "/logs-bucket/" {
+		key, ok = "", true
+	}
+	if !ok {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = string(body)
+	case r.Method == http.MethodGet && key == "":
+		if r.URL.Query().Get("list-type") != "2" {
+			http.Error(w, "ListObjectsV2 only", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, "<ListBucketResult>")
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				io.WriteString(w, "<Contents><Key>"+k+"</Key><LastModified>2026-01-01T00:00:00.000Z</LastModified></Contents>")
+			}
+		}
+		io.WriteString(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+	case r.Method == http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, body)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3LogSink(t *testing.T) {
+	bucket := &fakeS3{objects: map[string]string{}}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	sink, err := NewS3LogSink(&config.S3Config{
+		Endpoint: server.URL, Bucket: "logs-bucket", Prefix: "pipeline-logs",
+		AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", PathStyle: true,
+	})
+	require.NoError(t, err)
+	sink.now = func() time.Time { return time.Date(2026, 2, 3, 4, 5, 6, 0, time.UTC) }
+	ctx := context.Background()
+
+	ref, err := sink.Put(ctx, "run-1", "unit tests", []byte("ok\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "s3://logs-bucket/pipeline-logs/run-1/unit%20tests.log", ref)
+	assert.Equal(t, "ok\n", bucket.objects["pipeline-logs/run-1/unit%20tests.log"])
+	assert.True(t, strings.HasPrefix(bucket.auth[0],
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20260203/us-east-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature="))
+
+	rc, err := sink.Open(ctx, "run-1", "unit tests")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", string(data))
+
+	_, err = sink.Open(ctx, "run-1", "lint")
+	assert.ErrorIs(t, err, ErrLogsNotFound)
+
+	bucket.objects["elsewhere/keep.log"] = "not ours"
+	n, err := sink.Purge(ctx, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Zero(t, n, "objects newer than the cutoff are kept")
+	n, err = sink.Purge(ctx, time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, map[string]string{"elsewhere/keep.log": "not ours"}, bucket.objects)
+}
@@ -6,6 +6,8 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/anubhavg-icpl/krustron/internal/gitops"
@@ -14,8 +16,11 @@ import (
 	"github.com/anubhavg-icpl/krustron/pkg/errors"
 	"github.com/anubhavg-icpl/krustron/pkg/kube"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/anubhavg-icpl/krustron/pkg/utils"
 	"github.com/anubhavg-icpl/krustron/pkg/websocket"
 	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
 )
 
 // Service provides pipeline management functionality
@@ -25,6 +30,27 @@ type Service struct {
 	cache         *cache.RedisCache
 	gitopsService *gitops.Service
 	emitter       *websocket.EventEmitter
+
+	// runner executes stage Jobs in runnerNamespace; see SetRunner
+	runner          kubernetes.Interface
+	runnerNamespace string
+	pollInterval    time.Duration
+
+	// logs stores stage output; see SetLogSink
+	logs        LogSink
+	logMaxBytes int64
+
+	runsMu       sync.Mutex
+	inflight     map[string]context.CancelCauseFunc
+	approvalWake map[string]chan struct{}
+
+	// metrics judges canary stages; see SetMetricSource
+	metrics MetricSource
+	// fields encrypts webhook secrets; see SetFieldCipher
+	fields *secrets.FieldCipher
+	// targetCluster, when set, replaces the lookup of an application's
+	// cluster and namespace
+	targetCluster func(ctx context.Context, appID string) (*kube.ClusterClient, string, error)
 }
 
 // SetEventEmitter wires the real-time hub so pipeline mutations broadcast
@@ -38,6 +64,8 @@ func NewService(db *database.PostgresDB, kubeManager *kube.ClientManager, cache
 		kubeManager:   kubeManager,
 		cache:         cache,
 		gitopsService: gitopsSvc,
+		logs:          NewDatabaseLogSink(db),
+		inflight:      make(map[string]context.CancelCauseFunc),
 	}
 }
 
@@ -51,6 +79,13 @@ type Pipeline struct {
 	TriggerType   string                 `json:"trigger_type" db:"trigger_type"`
 	WebhookSecret string                 `json:"-" db:"webhook_secret"`
 	CronSchedule  string                 `json:"cron_schedule" db:"cron_schedule"`
+	// Branches filters which branches fire webhook runs (glob patterns);
+	// empty falls back to the application's branch
+	Branches      []string               `json:"branches"`
+	// BuildPullRequests also fires webhook runs for pull requests
+	// targeting those branches. Their deploy and canary stages are
+	// skipped, since the code is unreviewed.
+	BuildPullRequests bool `json:"build_pull_requests" db:"build_pull_requests"`
 	Stages        []Stage                `json:"stages"`
 	Variables     map[string]string      `json:"variables"`
 	Timeout       int                    `json:"timeout" db:"timeout"`
@@ -66,13 +101,20 @@ type Pipeline struct {
 // Stage represents a pipeline stage
 type Stage struct {
 	Name     string   `json:"name"`
-	Type     string   `json:"type"` // build, deploy, test, security, approve
+	Type     string   `json:"type"` // build, deploy, test, security, approve, canary
 	Image    string   `json:"image,omitempty"`
 	Commands []string `json:"commands,omitempty"`
 	Env      map[string]string `json:"env,omitempty"`
 	When     string   `json:"when,omitempty"`
 	Timeout  int      `json:"timeout,omitempty"`
 	Parallel bool     `json:"parallel,omitempty"`
+	// Approvers may decide an approve stage; empty lets anyone who can
+	// reach the endpoint. RequiredApprovals defaults to 1.
+	Approvers         []string `json:"approvers,omitempty"`
+	RequiredApprovals int      `json:"required_approvals,omitempty"`
+	// Canary stages only; Image is the image rolled out, the run's IMAGE
+	// variable by default, and approvers decide steps that ask for it
+	Canary *CanaryStrategy `json:"canary,omitempty"`
 }
 
 // PipelineRun represents a pipeline execution
@@ -103,6 +145,13 @@ type StageStatus struct {
 	FinishedAt *time.Time `json:"finished_at"`
 	Duration   int        `json:"duration"`
 	Logs       string     `json:"logs,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	// Approve stages only
+	Approvers         []string   `json:"approvers,omitempty"`
+	RequiredApprovals int        `json:"required_approvals,omitempty"`
+	Approvals         []Approval `json:"approvals,omitempty"`
+	// Canary stages only
+	Canary *CanaryStatus `json:"canary,omitempty"`
 }
 
 // Artifact represents a build artifact
@@ -128,6 +177,11 @@ type CreateRequest struct {
 	ApplicationID string            `json:"application_id" binding:"required"`
 	TriggerType   string            `json:"trigger_type" binding:"required,oneof=manual webhook cron"`
 	CronSchedule  string            `json:"cron_schedule"`
+	// WebhookSecret signs webhook deliveries; one is generated for webhook
+	// pipelines when empty
+	WebhookSecret string            `json:"webhook_secret"`
+	Branches      []string          `json:"branches"`
+	BuildPullRequests bool          `json:"build_pull_requests"`
 	Stages        []Stage           `json:"stages" binding:"required"`
 	Variables     map[string]string `json:"variables"`
 	Timeout       int               `json:"timeout"`
@@ -141,59 +195,106 @@ type UpdateRequest struct {
 	Description  string            `json:"description"`
 	TriggerType  string            `json:"trigger_type"`
 	CronSchedule string            `json:"cron_schedule"`
+	Branches     []string          `json:"branches"`
 	Stages       []Stage           `json:"stages"`
 	Variables    map[string]string `json:"variables"`
 	Timeout      int               `json:"timeout"`
 	RetryCount   int               `json:"retry_count"`
 	IsActive     *bool             `json:"is_active"`
+	BuildPullRequests *bool        `json:"build_pull_requests"`
 }
 
 // TriggerRequest contains pipeline trigger data
 type TriggerRequest struct {
-	Variables   map[string]string `json:"variables"`
-	TriggeredBy string            `json:"-"`
+	Variables map[string]string `json:"variables"`
+	// Trigger is what started the run; defaults to manual
+	Trigger     string                 `json:"-"`
+	TriggerInfo map[string]interface{} `json:"-"`
+	TriggeredBy string                 `json:"-"`
 }
 
-// List returns all pipelines with filters
-func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Pipeline, int, error) {
-	query := `
-		SELECT id, name, display_name, description, application_id,
+// pipelineListColumns are the columns queryPipelines scans
+const pipelineListColumns = `id, name, display_name, description, application_id,
 		       trigger_type, cron_schedule, stages, variables, timeout,
 		       retry_count, is_active, last_run_at, last_run_status,
-		       created_by, created_at, updated_at
-		FROM pipelines
-		WHERE 1=1
-	`
-	countQuery := "SELECT COUNT(*) FROM pipelines WHERE 1=1"
-	args := []interface{}{}
-	argCount := 0
+		       created_by, created_at, updated_at, COALESCE(branches, '[]'),
+		       COALESCE(build_pull_requests, false)`
 
-	if filters.ApplicationID != "" {
-		argCount++
-		query += " AND application_id = $1"
-		countQuery += " AND application_id = $1"
-		args = append(args, filters.ApplicationID)
+// where returns the WHERE conditions for filters and their arguments
+func (f *ListFilters) where() (string, []interface{}) {
+	where := " WHERE 1=1"
+	args := []interface{}{}
+	if f.ApplicationID != "" {
+		args = append(args, f.ApplicationID)
+		where += fmt.Sprintf(" AND application_id = $%d", len(args))
 	}
+	return where, args
+}
+
+// List returns all pipelines with filters
+func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Pipeline, int, error) {
+	where, args := filters.where()
 
 	var total int
-	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pipelines"+where, args...).Scan(&total); err != nil {
 		return nil, 0, errors.DatabaseWrap(err, "failed to count pipelines")
 	}
 
 	offset := (filters.Page - 1) * filters.Limit
-	query += " ORDER BY created_at DESC LIMIT $" + string(rune('0'+argCount+1)) + " OFFSET $" + string(rune('0'+argCount+2))
-	args = append(args, filters.Limit, offset)
+	query := "SELECT " + pipelineListColumns + " FROM pipelines" + where +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+
+	pipelines, err := s.queryPipelines(ctx, query, append(args, filters.Limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pipelines, total, nil
+}
+
+// ListAfter returns the page of pipelines matching filters after cursor,
+// newest first, and the cursor for the next page, empty on the last. An
+// empty cursor starts from the newest; filters.Page is ignored.
+func (s *Service) ListAfter(ctx context.Context, filters *ListFilters, cursor string) ([]Pipeline, string, error) {
+	after, err := database.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	limit := filters.Limit
+	if limit < 1 {
+		limit = 20
+	}
 
+	where, args := filters.where()
+	if !after.IsZero() {
+		args = append(args, after.CreatedAt, after.ID)
+		where += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	args = append(args, limit+1)
+	query := "SELECT " + pipelineListColumns + " FROM pipelines" + where +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	pipelines, err := s.queryPipelines(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	pipelines, next := database.KeysetPage(pipelines, limit, func(p *Pipeline) database.Cursor {
+		return database.Cursor{CreatedAt: p.CreatedAt, ID: p.ID}
+	})
+	return pipelines, next, nil
+}
+
+// queryPipelines runs a pipelines list query selecting pipelineListColumns
+func (s *Service) queryPipelines(ctx context.Context, query string, args ...interface{}) ([]Pipeline, error) {
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, errors.DatabaseWrap(err, "failed to query pipelines")
+		return nil, errors.DatabaseWrap(err, "failed to query pipelines")
 	}
 	defer rows.Close()
 
 	var pipelines []Pipeline
 	for rows.Next() {
 		var p Pipeline
-		var stages, variables []byte
+		var stages, variables, branches []byte
 		var lastRunAt sql.NullTime
 		var lastRunStatus sql.NullString
 
@@ -201,9 +302,9 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Pipeline, i
 			&p.ID, &p.Name, &p.DisplayName, &p.Description, &p.ApplicationID,
 			&p.TriggerType, &p.CronSchedule, &stages, &variables, &p.Timeout,
 			&p.RetryCount, &p.IsActive, &lastRunAt, &lastRunStatus,
-			&p.CreatedBy, &p.CreatedAt, &p.UpdatedAt,
+			&p.CreatedBy, &p.CreatedAt, &p.UpdatedAt, &branches, &p.BuildPullRequests,
 		); err != nil {
-			return nil, 0, errors.DatabaseWrap(err, "failed to scan pipeline")
+			return nil, errors.DatabaseWrap(err, "failed to scan pipeline")
 		}
 
 		if lastRunAt.Valid {
@@ -214,11 +315,12 @@ func (s *Service) List(ctx context.Context, filters *ListFilters) ([]Pipeline, i
 		}
 		json.Unmarshal(stages, &p.Stages)
 		json.Unmarshal(variables, &p.Variables)
+		json.Unmarshal(branches, &p.Branches)
 
 		pipelines = append(pipelines, p)
 	}
 
-	return pipelines, total, nil
+	return pipelines, nil
 }
 
 // Get returns a single pipeline
@@ -227,12 +329,13 @@ func (s *Service) Get(ctx context.Context, id string) (*Pipeline, error) {
 		SELECT id, name, display_name, description, application_id,
 		       trigger_type, cron_schedule, stages, variables, timeout,
 		       retry_count, is_active, last_run_at, last_run_status,
-		       created_by, created_at, updated_at
+		       created_by, created_at, updated_at, COALESCE(branches, '[]'),
+		       COALESCE(build_pull_requests, false)
 		FROM pipelines WHERE id = $1
 	`
 
 	var p Pipeline
-	var stages, variables []byte
+	var stages, variables, branches []byte
 	var lastRunAt sql.NullTime
 	var lastRunStatus sql.NullString
 
@@ -240,7 +343,7 @@ func (s *Service) Get(ctx context.Context, id string) (*Pipeline, error) {
 		&p.ID, &p.Name, &p.DisplayName, &p.Description, &p.ApplicationID,
 		&p.TriggerType, &p.CronSchedule, &stages, &variables, &p.Timeout,
 		&p.RetryCount, &p.IsActive, &lastRunAt, &lastRunStatus,
-		&p.CreatedBy, &p.CreatedAt, &p.UpdatedAt,
+		&p.CreatedBy, &p.CreatedAt, &p.UpdatedAt, &branches, &p.BuildPullRequests,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.NotFound("pipeline", id)
@@ -256,12 +359,16 @@ func (s *Service) Get(ctx context.Context, id string) (*Pipeline, error) {
 	}
 	json.Unmarshal(stages, &p.Stages)
 	json.Unmarshal(variables, &p.Variables)
+	json.Unmarshal(branches, &p.Branches)
 
 	return &p, nil
 }
 
 // Create creates a new pipeline
 func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Pipeline, error) {
+	if err := validateStages(req.Stages); err != nil {
+		return nil, err
+	}
 	stages, _ := json.Marshal(req.Stages)
 	variables, _ := json.Marshal(req.Variables)
 
@@ -275,11 +382,22 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Pipeline, er
 		timeout = 3600
 	}
 
+	webhookSecret := req.WebhookSecret
+	if webhookSecret == "" && req.TriggerType == "webhook" {
+		secret, err := utils.GenerateToken(32)
+		if err != nil {
+			return nil, errors.InternalWrap(err, "failed to generate webhook secret")
+		}
+		webhookSecret = secret
+	}
+	branches, _ := json.Marshal(req.Branches)
+
 	query := `
 		INSERT INTO pipelines (name, display_name, description, application_id,
 		                       trigger_type, cron_schedule, stages, variables,
-		                       timeout, retry_count, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		                       timeout, retry_count, created_by, webhook_secret, branches,
+		                       build_pull_requests)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 		RETURNING id, is_active, created_at, updated_at
 	`
 
@@ -287,7 +405,8 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Pipeline, er
 	if err := s.db.QueryRowContext(ctx, query,
 		req.Name, displayName, req.Description, req.ApplicationID,
 		req.TriggerType, req.CronSchedule, stages, variables,
-		timeout, req.RetryCount, req.CreatedBy,
+		timeout, req.RetryCount, req.CreatedBy, s.fields.Encrypt(webhookSecretColumn, webhookSecret), branches,
+		req.BuildPullRequests,
 	).Scan(&p.ID, &p.IsActive, &p.CreatedAt, &p.UpdatedAt); err != nil {
 		return nil, errors.DatabaseWrap(err, "failed to create pipeline")
 	}
@@ -298,6 +417,9 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Pipeline, er
 	p.ApplicationID = req.ApplicationID
 	p.TriggerType = req.TriggerType
 	p.CronSchedule = req.CronSchedule
+	p.WebhookSecret = webhookSecret
+	p.Branches = req.Branches
+	p.BuildPullRequests = req.BuildPullRequests
 	p.Stages = req.Stages
 	p.Variables = req.Variables
 	p.Timeout = timeout
@@ -314,8 +436,15 @@ func (s *Service) Create(ctx context.Context, req *CreateRequest) (*Pipeline, er
 
 // Update updates a pipeline
 func (s *Service) Update(ctx context.Context, id string, req *UpdateRequest) (*Pipeline, error) {
+	if err := validateStages(req.Stages); err != nil {
+		return nil, err
+	}
 	stages, _ := json.Marshal(req.Stages)
 	variables, _ := json.Marshal(req.Variables)
+	var branches []byte
+	if req.Branches != nil {
+		branches, _ = json.Marshal(req.Branches)
+	}
 
 	query := `
 		UPDATE pipelines
@@ -328,13 +457,16 @@ func (s *Service) Update(ctx context.Context, id string, req *UpdateRequest) (*P
 		    timeout = COALESCE(NULLIF($8, 0), timeout),
 		    retry_count = COALESCE(NULLIF($9, 0), retry_count),
 		    is_active = COALESCE($10, is_active),
+		    branches = COALESCE($11, branches),
+		    build_pull_requests = COALESCE($12, build_pull_requests),
 		    updated_at = NOW()
 		WHERE id = $1
 	`
 
 	result, err := s.db.ExecContext(ctx, query, id,
 		req.DisplayName, req.Description, req.TriggerType, req.CronSchedule,
-		stages, variables, req.Timeout, req.RetryCount, req.IsActive,
+		stages, variables, req.Timeout, req.RetryCount, req.IsActive, branches,
+		req.BuildPullRequests,
 	)
 	if err != nil {
 		return nil, errors.DatabaseWrap(err, "failed to update pipeline")
@@ -406,7 +538,20 @@ func (s *Service) Trigger(ctx context.Context, id string, req *TriggerRequest) (
 	}
 
 	variablesJSON, _ := json.Marshal(variables)
-	triggerInfo, _ := json.Marshal(map[string]interface{}{"triggered_by": req.TriggeredBy})
+	trigger := req.Trigger
+	if trigger == "" {
+		trigger = "manual"
+	}
+	info := map[string]interface{}{"triggered_by": req.TriggeredBy}
+	for k, v := range req.TriggerInfo {
+		info[k] = v
+	}
+	triggerInfo, _ := json.Marshal(info)
+	// Webhook runs have no user behind them
+	var createdBy interface{}
+	if req.TriggeredBy != "" {
+		createdBy = req.TriggeredBy
+	}
 	stagesStatus, _ := json.Marshal(map[string]StageStatus{})
 
 	now := time.Now()
@@ -414,14 +559,14 @@ func (s *Service) Trigger(ctx context.Context, id string, req *TriggerRequest) (
 	query := `
 		INSERT INTO pipeline_runs (pipeline_id, run_number, status, trigger,
 		                           trigger_info, stages_status, variables,
-		                           started_at, created_by)
-		VALUES ($1, $2, 'running', 'manual', $3, $4, $5, $6, $7)
+		                           started_at, heartbeat_at, created_by)
+		VALUES ($1, $2, 'running', $3, $4, $5, $6, $7, $7, $8)
 		RETURNING id, created_at
 	`
 
 	var run PipelineRun
 	if err := tx.QueryRowContext(ctx, query,
-		id, runNumber, triggerInfo, stagesStatus, variablesJSON, now, req.TriggeredBy,
+		id, runNumber, trigger, triggerInfo, stagesStatus, variablesJSON, now, createdBy,
 	).Scan(&run.ID, &run.CreatedAt); err != nil {
 		return nil, errors.DatabaseWrap(err, "failed to create pipeline run")
 	}
@@ -432,7 +577,8 @@ func (s *Service) Trigger(ctx context.Context, id string, req *TriggerRequest) (
 	run.PipelineID = id
 	run.RunNumber = runNumber
 	run.Status = "running"
-	run.Trigger = "manual"
+	run.Trigger = trigger
+	run.TriggerInfo = info
 	run.Variables = variables
 	run.StartedAt = &now
 	run.CreatedBy = req.TriggeredBy
@@ -445,7 +591,7 @@ func (s *Service) Trigger(ctx context.Context, id string, req *TriggerRequest) (
 		s.emitter.EmitPipelineStatus(id, map[string]interface{}{
 			"run_number": runNumber,
 			"status":     "running",
-			"trigger":    "manual",
+			"trigger":    trigger,
 		})
 	}
 
@@ -454,41 +600,84 @@ func (s *Service) Trigger(ctx context.Context, id string, req *TriggerRequest) (
 		zap.Int("run_number", runNumber),
 	)
 
+	s.startRun(pipeline, &run)
+
 	return &run, nil
 }
 
-// ListRuns returns pipeline runs
-func (s *Service) ListRuns(ctx context.Context, pipelineID string, page, limit int, status string) ([]PipelineRun, int, error) {
-	query := `
-		SELECT id, pipeline_id, run_number, status, trigger, trigger_info,
+// runListColumns are the columns queryRuns scans
+const runListColumns = `id, pipeline_id, run_number, status, trigger, trigger_info,
 		       stages_status, current_stage, variables, artifacts, logs_url,
-		       started_at, finished_at, duration, error_message, created_by, created_at
-		FROM pipeline_runs
-		WHERE pipeline_id = $1
-	`
-	countQuery := "SELECT COUNT(*) FROM pipeline_runs WHERE pipeline_id = $1"
-	args := []interface{}{pipelineID}
-	argCount := 1
+		       started_at, finished_at, duration, error_message, created_by, created_at`
 
+// runsWhere returns the WHERE conditions for a pipeline's runs in status,
+// any status if empty, and their arguments
+func runsWhere(pipelineID, status string) (string, []interface{}) {
+	where := " WHERE pipeline_id = $1"
+	args := []interface{}{pipelineID}
 	if status != "" {
-		argCount++
-		query += " AND status = $2"
-		countQuery += " AND status = $2"
 		args = append(args, status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
 	}
+	return where, args
+}
+
+// ListRuns returns pipeline runs
+func (s *Service) ListRuns(ctx context.Context, pipelineID string, page, limit int, status string) ([]PipelineRun, int, error) {
+	where, args := runsWhere(pipelineID, status)
 
 	var total int
-	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pipeline_runs"+where, args...).Scan(&total); err != nil {
 		return nil, 0, errors.DatabaseWrap(err, "failed to count runs")
 	}
 
 	offset := (page - 1) * limit
-	query += " ORDER BY created_at DESC LIMIT $" + string(rune('0'+argCount+1)) + " OFFSET $" + string(rune('0'+argCount+2))
-	args = append(args, limit, offset)
+	query := "SELECT " + runListColumns + " FROM pipeline_runs" + where +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+
+	runs, err := s.queryRuns(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return runs, total, nil
+}
 
+// ListRunsAfter returns the page of a pipeline's runs in status after
+// cursor, newest first, and the cursor for the next page, empty on the
+// last. An empty cursor starts from the newest.
+func (s *Service) ListRunsAfter(ctx context.Context, pipelineID, cursor string, limit int, status string) ([]PipelineRun, string, error) {
+	after, err := database.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	where, args := runsWhere(pipelineID, status)
+	if !after.IsZero() {
+		args = append(args, after.CreatedAt, after.ID)
+		where += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+	args = append(args, limit+1)
+	query := "SELECT " + runListColumns + " FROM pipeline_runs" + where +
+		fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	runs, err := s.queryRuns(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	runs, next := database.KeysetPage(runs, limit, func(r *PipelineRun) database.Cursor {
+		return database.Cursor{CreatedAt: r.CreatedAt, ID: r.ID}
+	})
+	return runs, next, nil
+}
+
+// queryRuns runs a pipeline runs list query selecting runListColumns
+func (s *Service) queryRuns(ctx context.Context, query string, args ...interface{}) ([]PipelineRun, error) {
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, 0, errors.DatabaseWrap(err, "failed to query runs")
+		return nil, errors.DatabaseWrap(err, "failed to query runs")
 	}
 	defer rows.Close()
 
@@ -505,7 +694,7 @@ func (s *Service) ListRuns(ctx context.Context, pipelineID string, page, limit i
 			&logsURL, &startedAt, &finishedAt, &run.Duration, &errorMessage,
 			&run.CreatedBy, &run.CreatedAt,
 		); err != nil {
-			return nil, 0, errors.DatabaseWrap(err, "failed to scan run")
+			return nil, errors.DatabaseWrap(err, "failed to scan run")
 		}
 
 		if startedAt.Valid {
@@ -531,7 +720,7 @@ func (s *Service) ListRuns(ctx context.Context, pipelineID string, page, limit i
 		runs = append(runs, run)
 	}
 
-	return runs, total, nil
+	return runs, nil
 }
 
 // GetRun returns a single pipeline run
@@ -591,7 +780,7 @@ func (s *Service) CancelRun(ctx context.Context, pipelineID, runID string) error
 		SET status = 'cancelled',
 		    finished_at = NOW(),
 		    duration = EXTRACT(EPOCH FROM (NOW() - started_at))::integer
-		WHERE pipeline_id = $1 AND id = $2 AND status = 'running'
+		WHERE pipeline_id = $1 AND id = $2 AND status IN ('running', 'waiting_approval')
 	`
 
 	result, err := s.db.ExecContext(ctx, query, pipelineID, runID)
@@ -604,6 +793,11 @@ func (s *Service) CancelRun(ctx context.Context, pipelineID, runID string) error
 		return errors.BadRequest("run is not running or not found")
 	}
 
+	// Stop the executor if it's ours, and delete in-flight Jobs either way
+	// in case another replica is running it
+	s.stopRun(runID)
+	s.deleteRunJobs(ctx, runID)
+
 	logger.Info("Pipeline run cancelled", zap.String("run_id", runID))
 	return nil
 }
@@ -625,27 +819,3 @@ func (s *Service) RetryRun(ctx context.Context, pipelineID, runID, userID string
 	})
 }
 
-// GetRunLogs returns logs for a pipeline run
-func (s *Service) GetRunLogs(ctx context.Context, pipelineID, runID, stage string) (string, error) {
-	// In a real implementation, this would fetch logs from a storage backend
-	return "Pipeline logs would appear here...", nil
-}
-
-// HandleGitHubWebhook handles GitHub webhook events
-func (s *Service) HandleGitHubWebhook(ctx context.Context, event, signature string, body []byte) error {
-	logger.Info("Received GitHub webhook", zap.String("event", event))
-	// Process webhook and trigger pipelines
-	return nil
-}
-
-// HandleGitLabWebhook handles GitLab webhook events
-func (s *Service) HandleGitLabWebhook(ctx context.Context, event, token string, body []byte) error {
-	logger.Info("Received GitLab webhook", zap.String("event", event))
-	return nil
-}
-
-// HandleBitbucketWebhook handles Bitbucket webhook events
-func (s *Service) HandleBitbucketWebhook(ctx context.Context, event string, body []byte) error {
-	logger.Info("Received Bitbucket webhook", zap.String("event", event))
-	return nil
-}
@@ -0,0 +1,220 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// newTestService backs the service with in-memory SQLite, which binds
+// $N placeholders by number the same way Postgres does
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	_, err = sqlDB.Exec(`CREATE TABLE pipelines (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), name TEXT, display_name TEXT,
+		description TEXT, application_id TEXT, trigger_type TEXT, cron_schedule TEXT, stages TEXT,
+		variables TEXT, timeout INTEGER, retry_count INTEGER, is_active BOOLEAN DEFAULT true,
+		last_run_at TIMESTAMP, last_run_status TEXT, created_by TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		branches TEXT, build_pull_requests BOOLEAN DEFAULT false)`)
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`CREATE TABLE pipeline_runs (
+		id TEXT PRIMARY KEY, pipeline_id TEXT, run_number INTEGER, status TEXT,
+		trigger TEXT, trigger_info TEXT, stages_status TEXT, current_stage TEXT,
+		variables TEXT, artifacts TEXT, logs_url TEXT, started_at TIMESTAMP,
+		finished_at TIMESTAMP, duration INTEGER, error_message TEXT,
+		created_by TEXT, created_at TIMESTAMP, heartbeat_at TIMESTAMP)`)
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`CREATE TABLE pipeline_approvals (
+		run_id TEXT, stage TEXT, user_id TEXT, decision TEXT, comment TEXT,
+		decided_at TIMESTAMP, UNIQUE (run_id, stage, user_id))`)
+	require.NoError(t, err)
+	_, err = sqlDB.Exec(`CREATE TABLE pipeline_run_logs (
+		run_id TEXT, stage TEXT, content BLOB, size INTEGER, created_at TIMESTAMP,
+		PRIMARY KEY (run_id, stage))`)
+	require.NoError(t, err)
+
+	db := &database.PostgresDB{DB: sqlDB}
+	return &Service{db: db, logs: NewDatabaseLogSink(db)}
+}
+
+func TestListFiltersAndPaginates(t *testing.T) {
+	svc := newTestService(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 15 pipelines for app-a and 5 for app-b, newest last
+	for i := 0; i < 20; i++ {
+		app := "app-a"
+		if i%4 == 3 {
+			app = "app-b"
+		}
+		_, err := svc.db.Exec(`INSERT INTO pipelines VALUES
+			($1, $2, '', '', $3, 'manual', '', '[]', '{}', 0, 0, true, NULL, NULL, 'u', $4, $4, '[]', false)`,
+			fmt.Sprintf("p-%02d", i), fmt.Sprintf("pipeline-%02d", i), app, base.Add(time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+	}
+
+	ctx := context.Background()
+	page, total, err := svc.List(ctx, &ListFilters{Page: 2, Limit: 4, ApplicationID: "app-a"})
+	require.NoError(t, err)
+	assert.Equal(t, 15, total)
+	require.Len(t, page, 4)
+	// Newest first: app-a rows are 18,17,16,14 | 13,12,10,9 | ...
+	assert.Equal(t, []string{"p-13", "p-12", "p-10", "p-09"}, pipelineIDs(page))
+
+	page, total, err = svc.List(ctx, &ListFilters{Page: 2, Limit: 3, ApplicationID: "app-b"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, []string{"p-07", "p-03"}, pipelineIDs(page))
+
+	page, total, err = svc.List(ctx, &ListFilters{Page: 1, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 20, total)
+	assert.Equal(t, []string{"p-19", "p-18"}, pipelineIDs(page))
+}
+
+func TestListRunsFiltersAndPaginates(t *testing.T) {
+	svc := newTestService(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 12; i++ {
+		pipelineID, status := "p-1", "succeeded"
+		if i%3 == 0 {
+			status = "failed"
+		}
+		if i >= 10 {
+			pipelineID = "p-2"
+		}
+		_, err := svc.db.Exec(`INSERT INTO pipeline_runs VALUES
+			($1, $2, $3, $4, 'manual', '{}', '{}', NULL, '{}', '[]', NULL, NULL, NULL, 0, NULL, 'u', $5, NULL)`,
+			fmt.Sprintf("r-%02d", i), pipelineID, i+1, status, base.Add(time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+	}
+
+	ctx := context.Background()
+	runs, total, err := svc.ListRuns(ctx, "p-1", 2, 2, "succeeded")
+	require.NoError(t, err)
+	assert.Equal(t, 6, total)
+	// p-1 succeeded runs newest first: 8,7,5,4,2,1
+	assert.Equal(t, []string{"r-05", "r-04"}, runIDs(runs))
+
+	runs, total, err = svc.ListRuns(ctx, "p-1", 1, 10, "failed")
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []string{"r-09", "r-06", "r-03", "r-00"}, runIDs(runs))
+
+	runs, total, err = svc.ListRuns(ctx, "p-2", 1, 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []string{"r-11", "r-10"}, runIDs(runs))
+}
+
+func TestListAfterMatchesOffset(t *testing.T) {
+	svc := newTestService(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Pipelines created in pairs, so pages split rows sharing a timestamp
+	for i := 0; i < 11; i++ {
+		app := "app-a"
+		if i%4 == 3 {
+			app = "app-b"
+		}
+		_, err := svc.db.Exec(`INSERT INTO pipelines VALUES
+			($1, $2, '', '', $3, 'manual', '', '[]', '{}', 0, 0, true, NULL, NULL, 'u', $4, $4, '[]', false)`,
+			fmt.Sprintf("p-%02d", i), fmt.Sprintf("pipeline-%02d", i), app, base.Add(time.Duration(i/2)*time.Minute))
+		require.NoError(t, err)
+	}
+
+	ctx := context.Background()
+	for _, app := range []string{"", "app-a"} {
+		var offset, keyset []string
+		for page := 1; page <= 4; page++ {
+			pipelines, _, err := svc.List(ctx, &ListFilters{Page: page, Limit: 3, ApplicationID: app})
+			require.NoError(t, err)
+			offset = append(offset, pipelineIDs(pipelines)...)
+		}
+		cursor := ""
+		for {
+			pipelines, next, err := svc.ListAfter(ctx, &ListFilters{Limit: 3, ApplicationID: app}, cursor)
+			require.NoError(t, err)
+			keyset = append(keyset, pipelineIDs(pipelines)...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		assert.Equal(t, offset, keyset, "application %q", app)
+	}
+
+	_, _, err := svc.ListAfter(ctx, &ListFilters{Limit: 3}, "%%%")
+	assert.Error(t, err)
+}
+
+func TestListRunsAfterWithInserts(t *testing.T) {
+	svc := newTestService(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	runs := 0
+	addRun := func(pipelineID string) {
+		_, err := svc.db.Exec(`INSERT INTO pipeline_runs VALUES
+			($1, $2, $3, 'succeeded', 'manual', '{}', '{}', NULL, '{}', '[]', NULL, NULL, NULL, 0, NULL, 'u', $4, NULL)`,
+			fmt.Sprintf("r-%02d", runs), pipelineID, runs+1, base.Add(time.Duration(runs)*time.Minute))
+		require.NoError(t, err)
+		runs++
+	}
+	for i := 0; i < 7; i++ {
+		addRun("p-1")
+	}
+	addRun("p-2")
+
+	// Runs triggered while paging appear on no page and repeat no row
+	ctx := context.Background()
+	var seen []string
+	cursor := ""
+	for {
+		page, next, err := svc.ListRunsAfter(ctx, "p-1", cursor, 2, "")
+		require.NoError(t, err)
+		seen = append(seen, runIDs(page)...)
+		if next == "" {
+			break
+		}
+		cursor = next
+		addRun("p-1")
+	}
+	assert.Equal(t, []string{"r-06", "r-05", "r-04", "r-03", "r-02", "r-01", "r-00"}, seen)
+
+	page, next, err := svc.ListRunsAfter(ctx, "p-1", "", 20, "")
+	require.NoError(t, err)
+	assert.Len(t, page, 10)
+	assert.Empty(t, next)
+}
+
+func pipelineIDs(pipelines []Pipeline) []string {
+	ids := make([]string, len(pipelines))
+	for i, p := range pipelines {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func runIDs(runs []PipelineRun) []string {
+	ids := make([]string, len(runs))
+	for i, r := range runs {
+		ids[i] = r.ID
+	}
+	return ids
+}
@@ -0,0 +1,607 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"go.uber.org/zap"
+)
+
+// Webhook providers, recorded as the run's trigger
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderBitbucket = "bitbucket"
+)
+
+// webhookSecretColumn holds the secrets webhook deliveries are signed with
+var webhookSecretColumn = secrets.Column{Table: "pipelines", Key: "id", Name: "webhook_secret"}
+
+// EncryptedColumns are the pipeline columns a FieldCipher encrypts
+var EncryptedColumns = []secrets.Column{webhookSecretColumn}
+
+// SetFieldCipher encrypts webhook secrets with fields. Optional; without
+// it they are stored in plaintext.
+func (s *Service) SetFieldCipher(fields *secrets.FieldCipher) { s.fields = fields }
+
+// webhookEvent is a push or pull request normalised across providers
+type webhookEvent struct {
+	Provider string
+	// Kind is "push" or "pull_request"
+	Kind string
+	// RepoURLs are every URL the provider gives for the repository; a
+	// pipeline matches if its application's repo_url equals any of them
+	RepoURLs []string
+	// Branch is the pushed branch, or a pull request's target branch
+	Branch       string
+	SourceBranch string
+	Commit       string
+	Message      string
+	Author       string
+	PullRequest  int
+}
+
+// triggerInfo is what the run records about the event that started it
+func (e *webhookEvent) triggerInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"provider": e.Provider,
+		"event":    e.Kind,
+		"branch":   e.Branch,
+		"commit":   e.Commit,
+		"message":  e.Message,
+		"author":   e.Author,
+	}
+	if len(e.RepoURLs) > 0 {
+		info["repository"] = e.RepoURLs[0]
+	}
+	if e.Kind == "pull_request" {
+		info["source_branch"] = e.SourceBranch
+		info["pull_request"] = e.PullRequest
+	}
+	return info
+}
+
+// webhookTarget is a webhook-triggered pipeline and the repository it
+// builds
+type webhookTarget struct {
+	PipelineID string
+	Secret     string
+	RepoURL    string
+	RepoBranch string
+	Branches   []string
+	// PullRequests opts the pipeline in to pull request events
+	PullRequests bool
+}
+
+// HandleGitHubWebhook verifies a GitHub delivery's X-Hub-Signature-256 and
+// triggers the pipelines watching the pushed repository and branch
+func (s *Service) HandleGitHubWebhook(ctx context.Context, event, signature string, body []byte) error {
+	ev, err := parseGitHubEvent(event, body)
+	if err != nil {
+		return errors.BadRequestWrap(err, "invalid GitHub webhook payload")
+	}
+	return s.dispatchWebhook(ctx, event, ev, func(secret string) bool {
+		return validHubSignature(secret, signature, body)
+	})
+}
+
+// HandleGitLabWebhook checks a GitLab delivery's X-Gitlab-Token and
+// triggers the matching pipelines
+func (s *Service) HandleGitLabWebhook(ctx context.Context, event, token string, body []byte) error {
+	ev, err := parseGitLabEvent(event, body)
+	if err != nil {
+		return errors.BadRequestWrap(err, "invalid GitLab webhook payload")
+	}
+	return s.dispatchWebhook(ctx, event, ev, func(secret string) bool {
+		return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	})
+}
+
+// HandleBitbucketWebhook verifies a Bitbucket delivery's X-Hub-Signature
+// and triggers the matching pipelines
+func (s *Service) HandleBitbucketWebhook(ctx context.Context, event, signature string, body []byte) error {
+	ev, err := parseBitbucketEvent(event, body)
+	if err != nil {
+		return errors.BadRequestWrap(err, "invalid Bitbucket webhook payload")
+	}
+	return s.dispatchWebhook(ctx, event, ev, func(secret string) bool {
+		return validHubSignature(secret, signature, body)
+	})
+}
+
+// dispatchWebhook triggers a run of every pipeline the event selects. A nil
+// event is one we don't build on (pings, tags, closed pull requests).
+func (s *Service) dispatchWebhook(ctx context.Context, event string, ev *webhookEvent, verify func(secret string) bool) error {
+	if ev == nil {
+		logger.Debug("Ignoring webhook event", zap.String("event", event))
+		return nil
+	}
+
+	targets, err := s.webhookTargets(ctx)
+	if err != nil {
+		return err
+	}
+	selected, err := selectWebhookTargets(targets, ev, verify)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range selected {
+		run, err := s.Trigger(ctx, t.PipelineID, &TriggerRequest{
+			Trigger:     ev.Provider,
+			TriggerInfo: ev.triggerInfo(),
+			Variables: map[string]string{
+				revisionVariable: ev.Commit,
+				"BRANCH":         ev.Branch,
+			},
+		})
+		if err != nil {
+			logger.Warn("Failed to trigger pipeline from webhook",
+				zap.String("pipeline_id", t.PipelineID),
+				zap.String("provider", ev.Provider),
+				zap.Error(err),
+			)
+			continue
+		}
+		logger.Info("Pipeline triggered by webhook",
+			zap.String("pipeline_id", t.PipelineID),
+			zap.String("run_id", run.ID),
+			zap.String("provider", ev.Provider),
+			zap.String("branch", ev.Branch),
+			zap.String("commit", ev.Commit),
+		)
+	}
+	return nil
+}
+
+// webhookTargets loads every active webhook-triggered pipeline with its
+// application's repository
+func (s *Service) webhookTargets(ctx context.Context) ([]webhookTarget, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, COALESCE(p.webhook_secret, ''), COALESCE(a.repo_url, ''),
+		       COALESCE(a.repo_branch, ''), COALESCE(p.branches, '[]'),
+		       COALESCE(p.build_pull_requests, false)
+		FROM pipelines p
+		JOIN applications a ON a.id = p.application_id
+		WHERE p.trigger_type = 'webhook' AND p.is_active = true
+	`)
+	if err != nil {
+		return nil, errors.DatabaseWrap(err, "failed to query webhook pipelines")
+	}
+	defer rows.Close()
+
+	var targets []webhookTarget
+	for rows.Next() {
+		var t webhookTarget
+		var branches []byte
+		if err := rows.Scan(&t.PipelineID, &t.Secret, &t.RepoURL, &t.RepoBranch, &branches, &t.PullRequests); err != nil {
+			return nil, errors.DatabaseWrap(err, "failed to scan webhook pipeline")
+		}
+		secret, err := s.fields.Decrypt(webhookSecretColumn, t.Secret)
+		if err != nil {
+			// Without its secret the pipeline can't verify deliveries
+			logger.Warn("Failed to decrypt webhook secret",
+				zap.String("pipeline_id", t.PipelineID), zap.Error(err))
+		}
+		t.Secret = secret
+		json.Unmarshal(branches, &t.Branches)
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// selectWebhookTargets picks the pipelines an event should trigger: those
+// building its repository whose secret verifies the delivery and whose
+// branch filter admits its branch. Pull requests carry unreviewed code,
+// possibly from a fork, so they only trigger pipelines that opted in. If
+// the repository has pipelines but none verifies, the delivery is rejected
+// as unauthorized.
+func selectWebhookTargets(targets []webhookTarget, ev *webhookEvent, verify func(secret string) bool) ([]webhookTarget, error) {
+	repos := make(map[string]bool, len(ev.RepoURLs))
+	for _, u := range ev.RepoURLs {
+		if u != "" {
+			repos[normalizeRepoURL(u)] = true
+		}
+	}
+
+	matched, verified := false, false
+	var selected []webhookTarget
+	for _, t := range targets {
+		if !repos[normalizeRepoURL(t.RepoURL)] {
+			continue
+		}
+		matched = true
+		// A pipeline without a secret can't authenticate deliveries, so it
+		// never fires from one
+		if t.Secret == "" || !verify(t.Secret) {
+			continue
+		}
+		verified = true
+		if ev.Kind == "pull_request" && !t.PullRequests {
+			continue
+		}
+		if branchAllowed(t, ev.Branch) {
+			selected = append(selected, t)
+		}
+	}
+
+	if matched && !verified {
+		return nil, errors.Unauthorized("invalid webhook signature")
+	}
+	return selected, nil
+}
+
+// branchAllowed applies a pipeline's branch filter. Branches are glob
+// patterns ("release/*"); with none set the application's branch is used,
+// and with neither every branch fires.
+func branchAllowed(t webhookTarget, branch string) bool {
+	patterns := t.Branches
+	if len(patterns) == 0 && t.RepoBranch != "" {
+		patterns = []string{t.RepoBranch}
+	}
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRepoURL reduces the https, ssh and scp-style forms of a
+// repository URL to "host/owner/repo" so they compare equal
+func normalizeRepoURL(raw string) string {
+	u := strings.ToLower(strings.TrimSpace(raw))
+	for _, prefix := range []string{"https://", "http://", "ssh://", "git://"} {
+		u = strings.TrimPrefix(u, prefix)
+	}
+	if at := strings.Index(u, "@"); at >= 0 && at < strings.Index(u+"/", "/") {
+		u = u[at+1:]
+	}
+	// scp-style git@host:owner/repo
+	if colon := strings.Index(u, ":"); colon >= 0 && colon < strings.Index(u+"/", "/") {
+		host, rest := u[:colon], u[colon+1:]
+		// host:port/owner/repo keeps only the host
+		if slash := strings.Index(rest, "/"); slash >= 0 && isDigits(rest[:slash]) {
+			rest = rest[slash+1:]
+		}
+		u = host + "/" + rest
+	}
+	u = strings.TrimSuffix(u, "/")
+	return strings.TrimSuffix(u, ".git")
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// validHubSignature checks a "sha256=<hex>" HMAC of body, as sent by
+// GitHub in X-Hub-Signature-256 and Bitbucket in X-Hub-Signature
+func validHubSignature(secret, signature string, body []byte) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// branchFromRef returns the branch of a "refs/heads/..." ref, or "" for
+// tags and anything else
+func branchFromRef(ref string) string {
+	branch, ok := strings.CutPrefix(ref, "refs/heads/")
+	if !ok {
+		return ""
+	}
+	return branch
+}
+
+// zeroSHA is the "after" commit of a push that deleted the branch
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+type githubRepository struct {
+	HTMLURL  string `json:"html_url"`
+	CloneURL string `json:"clone_url"`
+	SSHURL   string `json:"ssh_url"`
+}
+
+func (r githubRepository) urls() []string { return []string{r.HTMLURL, r.CloneURL, r.SSHURL} }
+
+func parseGitHubEvent(event string, body []byte) (*webhookEvent, error) {
+	switch event {
+	case "push":
+		var p struct {
+			Ref        string           `json:"ref"`
+			After      string           `json:"after"`
+			Deleted    bool             `json:"deleted"`
+			Repository githubRepository `json:"repository"`
+			HeadCommit *struct {
+				Message string `json:"message"`
+				Author  struct {
+					Name string `json:"name"`
+				} `json:"author"`
+			} `json:"head_commit"`
+			Pusher struct {
+				Name string `json:"name"`
+			} `json:"pusher"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		branch := branchFromRef(p.Ref)
+		if branch == "" || p.Deleted || p.After == zeroSHA {
+			return nil, nil
+		}
+		ev := &webhookEvent{
+			Provider: ProviderGitHub,
+			Kind:     "push",
+			RepoURLs: p.Repository.urls(),
+			Branch:   branch,
+			Commit:   p.After,
+			Author:   p.Pusher.Name,
+		}
+		if p.HeadCommit != nil {
+			ev.Message = p.HeadCommit.Message
+			ev.Author = p.HeadCommit.Author.Name
+		}
+		return ev, nil
+
+	case "pull_request":
+		var p struct {
+			Action      string           `json:"action"`
+			Number      int              `json:"number"`
+			Repository  githubRepository `json:"repository"`
+			PullRequest struct {
+				Title string `json:"title"`
+				User  struct {
+					Login string `json:"login"`
+				} `json:"user"`
+				Head struct {
+					Ref string `json:"ref"`
+					SHA string `json:"sha"`
+				} `json:"head"`
+				Base struct {
+					Ref string `json:"ref"`
+				} `json:"base"`
+			} `json:"pull_request"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		switch p.Action {
+		case "opened", "synchronize", "reopened":
+		default:
+			return nil, nil
+		}
+		return &webhookEvent{
+			Provider:     ProviderGitHub,
+			Kind:         "pull_request",
+			RepoURLs:     p.Repository.urls(),
+			Branch:       p.PullRequest.Base.Ref,
+			SourceBranch: p.PullRequest.Head.Ref,
+			Commit:       p.PullRequest.Head.SHA,
+			Message:      p.PullRequest.Title,
+			Author:       p.PullRequest.User.Login,
+			PullRequest:  p.Number,
+		}, nil
+	}
+	return nil, nil
+}
+
+type gitlabProject struct {
+	WebURL        string `json:"web_url"`
+	GitHTTPURL    string `json:"git_http_url"`
+	GitSSHURL     string `json:"git_ssh_url"`
+	HTTPURLToRepo string `json:"http_url"`
+}
+
+func (p gitlabProject) urls() []string {
+	return []string{p.WebURL, p.GitHTTPURL, p.GitSSHURL, p.HTTPURLToRepo}
+}
+
+func parseGitLabEvent(event string, body []byte) (*webhookEvent, error) {
+	switch event {
+	case "Push Hook":
+		var p struct {
+			Ref         string        `json:"ref"`
+			After       string        `json:"after"`
+			CheckoutSHA string        `json:"checkout_sha"`
+			UserName    string        `json:"user_name"`
+			Project     gitlabProject `json:"project"`
+			Commits     []struct {
+				ID      string `json:"id"`
+				Message string `json:"message"`
+			} `json:"commits"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		branch := branchFromRef(p.Ref)
+		if branch == "" || p.After == zeroSHA {
+			return nil, nil
+		}
+		commit := p.CheckoutSHA
+		if commit == "" {
+			commit = p.After
+		}
+		ev := &webhookEvent{
+			Provider: ProviderGitLab,
+			Kind:     "push",
+			RepoURLs: p.Project.urls(),
+			Branch:   branch,
+			Commit:   commit,
+			Author:   p.UserName,
+		}
+		for _, c := range p.Commits {
+			if c.ID == commit {
+				ev.Message = c.Message
+			}
+		}
+		return ev, nil
+
+	case "Merge Request Hook":
+		var p struct {
+			User struct {
+				Username string `json:"username"`
+			} `json:"user"`
+			Project          gitlabProject `json:"project"`
+			ObjectAttributes struct {
+				IID          int    `json:"iid"`
+				Title        string `json:"title"`
+				Action       string `json:"action"`
+				SourceBranch string `json:"source_branch"`
+				TargetBranch string `json:"target_branch"`
+				LastCommit   struct {
+					ID string `json:"id"`
+				} `json:"last_commit"`
+			} `json:"object_attributes"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		attrs := p.ObjectAttributes
+		switch attrs.Action {
+		case "open", "reopen", "update":
+		default:
+			return nil, nil
+		}
+		return &webhookEvent{
+			Provider:     ProviderGitLab,
+			Kind:         "pull_request",
+			RepoURLs:     p.Project.urls(),
+			Branch:       attrs.TargetBranch,
+			SourceBranch: attrs.SourceBranch,
+			Commit:       attrs.LastCommit.ID,
+			Message:      attrs.Title,
+			Author:       p.User.Username,
+			PullRequest:  attrs.IID,
+		}, nil
+	}
+	return nil, nil
+}
+
+type bitbucketRepository struct {
+	FullName string `json:"full_name"`
+	Links    struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+func (r bitbucketRepository) urls() []string {
+	urls := []string{r.Links.HTML.Href}
+	if r.FullName != "" {
+		urls = append(urls, "bitbucket.org/"+r.FullName)
+	}
+	return urls
+}
+
+type bitbucketActor struct {
+	DisplayName string `json:"display_name"`
+}
+
+func parseBitbucketEvent(event string, body []byte) (*webhookEvent, error) {
+	switch event {
+	case "repo:push":
+		var p struct {
+			Actor      bitbucketActor      `json:"actor"`
+			Repository bitbucketRepository `json:"repository"`
+			Push       struct {
+				Changes []struct {
+					New *struct {
+						Type   string `json:"type"`
+						Name   string `json:"name"`
+						Target struct {
+							Hash    string `json:"hash"`
+							Message string `json:"message"`
+						} `json:"target"`
+					} `json:"new"`
+				} `json:"changes"`
+			} `json:"push"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		// One delivery can carry several refs; build the first branch that
+		// still exists
+		for _, change := range p.Push.Changes {
+			if change.New == nil || change.New.Type != "branch" {
+				continue
+			}
+			return &webhookEvent{
+				Provider: ProviderBitbucket,
+				Kind:     "push",
+				RepoURLs: p.Repository.urls(),
+				Branch:   change.New.Name,
+				Commit:   change.New.Target.Hash,
+				Message:  change.New.Target.Message,
+				Author:   p.Actor.DisplayName,
+			}, nil
+		}
+		return nil, nil
+
+	case "pullrequest:created", "pullrequest:updated":
+		var p struct {
+			Actor       bitbucketActor      `json:"actor"`
+			Repository  bitbucketRepository `json:"repository"`
+			PullRequest struct {
+				ID     int    `json:"id"`
+				Title  string `json:"title"`
+				Source struct {
+					Branch struct {
+						Name string `json:"name"`
+					} `json:"branch"`
+					Commit struct {
+						Hash string `json:"hash"`
+					} `json:"commit"`
+				} `json:"source"`
+				Destination struct {
+					Branch struct {
+						Name string `json:"name"`
+					} `json:"branch"`
+				} `json:"destination"`
+			} `json:"pullrequest"`
+		}
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, err
+		}
+		pr := p.PullRequest
+		return &webhookEvent{
+			Provider:     ProviderBitbucket,
+			Kind:         "pull_request",
+			RepoURLs:     p.Repository.urls(),
+			Branch:       pr.Destination.Branch.Name,
+			SourceBranch: pr.Source.Branch.Name,
+			Commit:       pr.Source.Commit.Hash,
+			Message:      pr.Title,
+			Author:       p.Actor.DisplayName,
+			PullRequest:  pr.ID,
+		}, nil
+	}
+	return nil, nil
+}
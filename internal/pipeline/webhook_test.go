@@ -0,0 +1,222 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadWebhook(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", "webhooks", name))
+	require.NoError(t, err)
+	return body
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func targetIDs(targets []webhookTarget) []string {
+	ids := make([]string, 0, len(targets))
+	for _, t := range targets {
+		ids = append(ids, t.PipelineID)
+	}
+	return ids
+}
+
+func TestGitHubWebhook(t *testing.T) {
+	push := loadWebhook(t, "github_push.json")
+	ev, err := parseGitHubEvent("push", push)
+	require.NoError(t, err)
+	require.NotNil(t, ev)
+	assert.Equal(t, "main", ev.Branch)
+	assert.Equal(t, "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c", ev.Commit)
+	assert.Equal(t, "Fix checkout total rounding", ev.Message)
+	assert.Equal(t, "Mona Lisa", ev.Author)
+
+	targets := []webhookTarget{
+		// Matches by ssh URL; branch comes from the application
+		{PipelineID: "deploy-main", Secret: "s3cret", RepoURL: "git@github.com:acme/storefront.git", RepoBranch: "main"},
+		{PipelineID: "release-only", Secret: "s3cret", RepoURL: "https://github.com/acme/storefront", Branches: []string{"release/*"}},
+		{PipelineID: "other-secret", Secret: "different", RepoURL: "https://github.com/acme/storefront.git"},
+		{PipelineID: "no-secret", RepoURL: "https://github.com/acme/storefront.git"},
+		{PipelineID: "other-repo", Secret: "s3cret", RepoURL: "https://github.com/acme/backoffice.git"},
+	}
+
+	signature := sign("s3cret", push)
+	verify := func(secret string) bool { return validHubSignature(secret, signature, push) }
+	selected, err := selectWebhookTargets(targets, ev, verify)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy-main"}, targetIDs(selected))
+
+	// Tampered body: nothing for the repository verifies
+	tampered := append([]byte{}, push...)
+	tampered[10] = 'X'
+	_, err = selectWebhookTargets(targets, ev, func(secret string) bool {
+		return validHubSignature(secret, signature, tampered)
+	})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+
+	pr, err := parseGitHubEvent("pull_request", loadWebhook(t, "github_pull_request.json"))
+	require.NoError(t, err)
+	require.NotNil(t, pr)
+	assert.Equal(t, "main", pr.Branch, "pull requests match on their target branch")
+	assert.Equal(t, "feature/gift-cards", pr.SourceBranch)
+	assert.Equal(t, 42, pr.PullRequest)
+	assert.Equal(t, "a3f1c2e9b8d7f6e5d4c3b2a1f0e9d8c7b6a5f4e3", pr.Commit)
+
+	// Pings and closed pull requests are ignored
+	ev, err = parseGitHubEvent("ping", []byte(`{"zen":"Keep it logically awesome."}`))
+	require.NoError(t, err)
+	assert.Nil(t, ev)
+	ev, err = parseGitHubEvent("pull_request", []byte(`{"action":"closed"}`))
+	require.NoError(t, err)
+	assert.Nil(t, ev)
+}
+
+func TestGitLabWebhook(t *testing.T) {
+	ev, err := parseGitLabEvent("Push Hook", loadWebhook(t, "gitlab_push.json"))
+	require.NoError(t, err)
+	require.NotNil(t, ev)
+	assert.Equal(t, "release/1.4", ev.Branch)
+	assert.Equal(t, "da1560886d4f094c3e6c9ef40349f7d38b5d27d7", ev.Commit)
+	assert.Equal(t, "Bump version to 1.4.2", ev.Message)
+
+	targets := []webhookTarget{
+		{PipelineID: "releases", Secret: "gl-token", RepoURL: "https://gitlab.example.com/platform/billing.git", Branches: []string{"release/*"}},
+		{PipelineID: "main-only", Secret: "gl-token", RepoURL: "https://gitlab.example.com/platform/billing", Branches: []string{"main"}, PullRequests: true},
+		{PipelineID: "any-branch", Secret: "gl-token", RepoURL: "ssh://git@gitlab.example.com:2222/platform/billing.git"},
+	}
+	verify := func(token string) func(string) bool {
+		return func(secret string) bool { return token == secret }
+	}
+
+	selected, err := selectWebhookTargets(targets, ev, verify("gl-token"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"releases", "any-branch"}, targetIDs(selected))
+
+	_, err = selectWebhookTargets(targets, ev, verify("wrong"))
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+
+	mr, err := parseGitLabEvent("Merge Request Hook", loadWebhook(t, "gitlab_merge_request.json"))
+	require.NoError(t, err)
+	require.NotNil(t, mr)
+	assert.Equal(t, "main", mr.Branch)
+	assert.Equal(t, "feature/eur", mr.SourceBranch)
+	assert.Equal(t, 7, mr.PullRequest)
+
+	// Only pipelines that opted in build merge requests
+	selected, err = selectWebhookTargets(targets, mr, verify("gl-token"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main-only"}, targetIDs(selected))
+}
+
+func TestBitbucketWebhook(t *testing.T) {
+	push := loadWebhook(t, "bitbucket_push.json")
+	ev, err := parseBitbucketEvent("repo:push", push)
+	require.NoError(t, err)
+	require.NotNil(t, ev)
+	assert.Equal(t, "develop", ev.Branch)
+	assert.Equal(t, "709d658dc5b6d6afcd46049c2f332ee3f515a67d", ev.Commit)
+	assert.Equal(t, "Emma Jones", ev.Author)
+
+	targets := []webhookTarget{
+		{PipelineID: "develop", Secret: "bb", RepoURL: "git@bitbucket.org:acme/inventory.git", RepoBranch: "develop"},
+		{PipelineID: "main", Secret: "bb", RepoURL: "https://bitbucket.org/acme/inventory.git", RepoBranch: "main"},
+	}
+	signature := sign("bb", push)
+	selected, err := selectWebhookTargets(targets, ev, func(secret string) bool {
+		return validHubSignature(secret, signature, push)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"develop"}, targetIDs(selected))
+
+	_, err = selectWebhookTargets(targets, ev, func(secret string) bool {
+		return validHubSignature(secret, "", push)
+	})
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized))
+
+	pr, err := parseBitbucketEvent("pullrequest:created", loadWebhook(t, "bitbucket_pullrequest.json"))
+	require.NoError(t, err)
+	require.NotNil(t, pr)
+	assert.Equal(t, "develop", pr.Branch)
+	assert.Equal(t, "feature/batching", pr.SourceBranch)
+	assert.Equal(t, 12, pr.PullRequest)
+	assert.Equal(t, "pull_request", pr.triggerInfo()["event"])
+}
+
+func TestSelectWebhookTargetsUnknownRepo(t *testing.T) {
+	ev := &webhookEvent{RepoURLs: []string{"https://github.com/acme/unknown"}, Branch: "main"}
+	targets := []webhookTarget{{PipelineID: "p", Secret: "s", RepoURL: "https://github.com/acme/storefront"}}
+
+	// No pipeline builds the repository: nothing to authenticate against
+	// and nothing to trigger
+	selected, err := selectWebhookTargets(targets, ev, func(string) bool { return false })
+	require.NoError(t, err)
+	assert.Empty(t, selected)
+}
+
+func TestNormalizeRepoURL(t *testing.T) {
+	for _, u := range []string{
+		"https://github.com/Acme/Storefront",
+		"https://github.com/acme/storefront.git",
+		"https://token@github.com/acme/storefront/",
+		"git@github.com:acme/storefront.git",
+		"ssh://git@github.com/acme/storefront.git",
+		"ssh://git@github.com:22/acme/storefront.git",
+	} {
+		assert.Equal(t, "github.com/acme/storefront", normalizeRepoURL(u), u)
+	}
+}
+
+func TestWebhookSecretEncryptedAtRest(t *testing.T) {
+	svc := newTestService(t)
+	_, err := svc.db.Exec(`ALTER TABLE pipelines ADD COLUMN webhook_secret TEXT`)
+	require.NoError(t, err)
+	_, err = svc.db.Exec(`CREATE TABLE applications (id TEXT PRIMARY KEY, repo_url TEXT, repo_branch TEXT)`)
+	require.NoError(t, err)
+	_, err = svc.db.Exec(`INSERT INTO applications VALUES ('app-1', 'https://github.com/acme/storefront.git', 'main')`)
+	require.NoError(t, err)
+	fields, err := secrets.NewFieldCipher(map[string][]byte{"k1": []byte(strings.Repeat("k", 32))}, "k1")
+	require.NoError(t, err)
+	svc.SetFieldCipher(fields)
+
+	p, err := svc.Create(context.Background(), &CreateRequest{
+		Name:          "deploy",
+		ApplicationID: "app-1",
+		TriggerType:   "webhook",
+		WebhookSecret: "s3cret",
+		Stages:        []Stage{{Name: "build", Type: "build", Image: "golang:1.24"}},
+	})
+	require.NoError(t, err)
+
+	var stored string
+	require.NoError(t, svc.db.QueryRow(`SELECT webhook_secret FROM pipelines WHERE id = $1`, p.ID).Scan(&stored))
+	assert.NotContains(t, stored, "s3cret")
+	assert.True(t, strings.HasPrefix(stored, "fenc:k1:"), stored)
+
+	targets, err := svc.webhookTargets(context.Background())
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "s3cret", targets[0].Secret)
+
+	// The secret never leaves the API
+	body, err := json.Marshal(p)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "s3cret")
+}
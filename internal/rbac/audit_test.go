@@ -0,0 +1,86 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAuditChain(t *testing.T) {
+	svc := newBackupTestService(t)
+	ctx := audit.WithRequest(context.Background(), audit.RequestInfo{IPAddress: "10.0.0.7"})
+
+	start := time.Now().Add(-time.Hour)
+	for i, action := range []string{"create_role", "assign_role", "authorize_batch", "revoke_role"} {
+		svc.createAuditLog(ctx, &AuditLog{
+			ID:        "audit-" + action,
+			UserID:    "alice",
+			Action:    action,
+			Resource:  ResourceCluster,
+			Result:    "success",
+			Metadata:  map[string]interface{}{"checks": []map[string]interface{}{{"allowed": true, "n": i}}},
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	report, err := svc.VerifyAuditChain(ctx, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, report.Intact, "%+v", report.FirstBroken)
+	assert.Equal(t, 4, report.Checked)
+
+	report, err = svc.VerifyAuditChain(ctx, start.Add(time.Minute), start.Add(3*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, report.Intact)
+	assert.Equal(t, 2, report.Checked)
+
+	require.NoError(t, svc.db.Model(&AuditLog{}).Where("seq = ?", 2).Update("result", "denied").Error)
+	report, err = svc.VerifyAuditChain(ctx, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.False(t, report.Intact)
+	require.NotNil(t, report.FirstBroken)
+	assert.Equal(t, "audit-assign_role", report.FirstBroken.ID)
+	assert.Equal(t, audit.ReasonAltered, report.FirstBroken.Reason)
+
+	require.NoError(t, svc.db.Where("seq = ?", 2).Delete(&AuditLog{}).Error)
+	report, err = svc.VerifyAuditChain(ctx, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.NotNil(t, report.FirstBroken)
+	assert.Equal(t, int64(3), report.FirstBroken.Seq)
+	assert.Equal(t, audit.ReasonMissing, report.FirstBroken.Reason)
+}
+
+func TestAuditLogsGoToSharedRecorder(t *testing.T) {
+	svc := newBackupTestService(t)
+	svc.auditEnabled = true
+	sqlDB, err := svc.db.DB()
+	require.NoError(t, err)
+	svc.SetAuditRecorder(audittest.NewRecorder(t, sqlDB))
+
+	ctx := audit.WithActor(audit.WithRequest(context.Background(), audit.RequestInfo{RequestID: "req-7"}), "alice", "alice@example.com")
+	role := &Role{Name: "deployer", DisplayName: "Deployer", Type: "custom"}
+	require.NoError(t, svc.CreateRole(ctx, role))
+	role.DisplayName = "Release deployer"
+	require.NoError(t, svc.UpdateRole(ctx, role))
+
+	var action, userID, requestID, status string
+	var oldValue, newValue, metadata []byte
+	require.NoError(t, sqlDB.QueryRow(`
+		SELECT action, user_id, request_id, status, old_value, new_value, metadata
+		FROM audit_logs WHERE seq = 2`).Scan(&action, &userID, &requestID, &status, &oldValue, &newValue, &metadata))
+	assert.Equal(t, "update_role", action)
+	assert.Equal(t, "alice", userID)
+	assert.Equal(t, "req-7", requestID)
+	assert.Equal(t, audit.StatusSuccess, status)
+	assert.Contains(t, string(oldValue), `"display_name":"Deployer"`)
+	assert.Contains(t, string(newValue), `"display_name":"Release deployer"`)
+	assert.JSONEq(t, `{"source": "rbac"}`, string(metadata))
+
+	var local int64
+	require.NoError(t, svc.db.Model(&AuditLog{}).Count(&local).Error)
+	assert.Zero(t, local, "entries no longer go to rbac_audit_logs")
+}
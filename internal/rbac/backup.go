@@ -0,0 +1,137 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	gormadapter "github.com/casbin/gorm-adapter/v3"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// rbacBackup is the backup form of the RBAC state: the roles with their
+// permissions, and the Casbin policies as ExportPolicies writes them
+type rbacBackup struct {
+	Roles    []Role          `json:"roles"`
+	Policies json.RawMessage `json:"policies"`
+}
+
+// ExportBackup returns the roles and policies for a Krustron backup
+func (s *Service) ExportBackup(ctx context.Context) ([]byte, error) {
+	roles, err := s.ListRoles(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := s.ExportPolicies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export policies: %w", err)
+	}
+	return json.Marshal(rbacBackup{Roles: roles, Policies: policies})
+}
+
+// RestoreBackup writes the roles and policies in data through tx. Roles
+// are matched by name and get the backed-up permissions; policies missing
+// from the store are added, others are left alone. It returns the number
+// of roles and policies restored. Call AfterRestore once tx commits.
+func (s *Service) RestoreBackup(ctx context.Context, tx *gorm.DB, data []byte) (int, error) {
+	var backup rbacBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return 0, fmt.Errorf("invalid RBAC backup: %w", err)
+	}
+
+	tx = tx.WithContext(ctx)
+	now := time.Now()
+	for i := range backup.Roles {
+		role := backup.Roles[i]
+		if role.Name == "" {
+			return 0, fmt.Errorf("role %d: name is required", i+1)
+		}
+
+		var existing Role
+		err := tx.Where("name = ?", role.Name).First(&existing).Error
+		switch {
+		case err == nil:
+			role.ID = existing.ID
+			role.CreatedAt = existing.CreatedAt
+		case err == gorm.ErrRecordNotFound:
+			if role.ID == "" {
+				role.ID = uuid.New().String()
+			}
+			if role.CreatedAt.IsZero() {
+				role.CreatedAt = now
+			}
+		default:
+			return 0, fmt.Errorf("failed to look up role %s: %w", role.Name, err)
+		}
+		role.UpdatedAt = now
+
+		permissions := role.Permissions
+		role.Permissions = nil
+		if err := tx.Save(&role).Error; err != nil {
+			return 0, fmt.Errorf("failed to restore role %s: %w", role.Name, err)
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&Permission{}).Error; err != nil {
+			return 0, fmt.Errorf("failed to replace permissions of role %s: %w", role.Name, err)
+		}
+		for j := range permissions {
+			permissions[j].ID = uuid.New().String()
+			permissions[j].RoleID = role.ID
+			permissions[j].CreatedAt = now
+		}
+		if len(permissions) > 0 {
+			if err := tx.Create(&permissions).Error; err != nil {
+				return 0, fmt.Errorf("failed to restore permissions of role %s: %w", role.Name, err)
+			}
+		}
+	}
+
+	var policies struct {
+		Policies         [][]string `json:"policies"`
+		GroupingPolicies [][]string `json:"grouping_policies"`
+	}
+	if len(backup.Policies) > 0 {
+		if err := json.Unmarshal(backup.Policies, &policies); err != nil {
+			return 0, fmt.Errorf("invalid policies in RBAC backup: %w", err)
+		}
+	}
+	restored := len(backup.Roles)
+	for _, p := range policies.Policies {
+		if len(p) < 4 {
+			continue
+		}
+		if err := addCasbinRule(tx, "p", p); err != nil {
+			return 0, err
+		}
+		restored++
+	}
+	for _, g := range policies.GroupingPolicies {
+		if len(g) < 2 {
+			continue
+		}
+		if err := addCasbinRule(tx, "g", g); err != nil {
+			return 0, err
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// AfterRestore reloads the policies written by RestoreBackup
+func (s *Service) AfterRestore(ctx context.Context) error {
+	return s.SyncPolicies(ctx)
+}
+
+// addCasbinRule stores a policy line unless the adapter table has it
+func addCasbinRule(tx *gorm.DB, ptype string, values []string) error {
+	var v [6]string
+	copy(v[:], values)
+	rule := gormadapter.CasbinRule{Ptype: ptype, V0: v[0], V1: v[1], V2: v[2], V3: v[3], V4: v[4], V5: v[5]}
+	if err := tx.Where(map[string]interface{}{
+		"ptype": ptype, "v0": v[0], "v1": v[1], "v2": v[2], "v3": v[3], "v4": v[4], "v5": v[5],
+	}).FirstOrCreate(&rule).Error; err != nil {
+		return fmt.Errorf("failed to restore policy %v: %w", values, err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newBackupTestService(t *testing.T) *Service {
+	t.Helper()
+	// The Casbin adapter saves policies on a second connection, so the
+	// in-memory database has to be shared
+	dsn := fmt.Sprintf("file:%s-%d?mode=memory&cache=shared", t.Name(), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	svc, err := NewService(db, zap.NewNop(), &Config{})
+	require.NoError(t, err)
+	t.Cleanup(svc.Stop)
+	return svc
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newBackupTestService(t)
+
+	require.NoError(t, src.CreateRole(ctx, &Role{
+		Name: "deployer",
+		Type: "custom",
+		Permissions: []Permission{
+			{Resource: ResourceApplication, Action: ActionDeploy, Scope: "project", Effect: "allow", Priority: 400},
+		},
+	}))
+	_, err := src.enforcer.AddGroupingPolicy("alice", "deployer", "*")
+	require.NoError(t, err)
+	require.NoError(t, src.enforcer.SavePolicy())
+
+	data, err := src.ExportBackup(ctx)
+	require.NoError(t, err)
+
+	dst := newBackupTestService(t)
+	var restored int
+	require.NoError(t, dst.db.Transaction(func(tx *gorm.DB) error {
+		restored, err = dst.RestoreBackup(ctx, tx, data)
+		return err
+	}))
+	assert.Positive(t, restored)
+	require.NoError(t, dst.AfterRestore(ctx))
+
+	roles, err := dst.ListRoles(ctx, map[string]interface{}{"type": "custom"})
+	require.NoError(t, err)
+	require.Len(t, roles, 1)
+	assert.Equal(t, "deployer", roles[0].Name)
+	require.Len(t, roles[0].Permissions, 1)
+	assert.Equal(t, ActionDeploy, roles[0].Permissions[0].Action)
+
+	ok, err := dst.enforcer.HasGroupingPolicy("alice", "deployer", "*")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	srcPolicies, _ := src.enforcer.GetPolicy()
+	dstPolicies, _ := dst.enforcer.GetPolicy()
+	assert.ElementsMatch(t, srcPolicies, dstPolicies)
+
+	// A second restore changes nothing
+	require.NoError(t, dst.db.Transaction(func(tx *gorm.DB) error {
+		_, err := dst.RestoreBackup(ctx, tx, data)
+		return err
+	}))
+	require.NoError(t, dst.AfterRestore(ctx))
+	again, _ := dst.enforcer.GetPolicy()
+	assert.Len(t, again, len(dstPolicies))
+	var permissions int64
+	require.NoError(t, dst.db.Model(&Permission{}).Where("role_id = ?", roles[0].ID).Count(&permissions).Error)
+	assert.EqualValues(t, 1, permissions)
+}
@@ -3,12 +3,20 @@
 package rbac
 
 import (
+	"container/list"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/pkg/httpsafe"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
 	gormadapter "github.com/casbin/gorm-adapter/v3"
@@ -139,9 +147,11 @@ type AccessRequest struct {
 	RoleID       string                 `json:"role_id"`
 	Resource     string                 `json:"resource"`
 	ResourceID   string                 `json:"resource_id"`
+	Action       string                 `json:"action"`
+	ScopeID      string                 `json:"scope_id"`
 	Reason       string                 `json:"reason"`
 	Duration     time.Duration          `json:"duration"`
-	Status       string                 `json:"status"` // pending, approved, denied, expired
+	Status       string                 `json:"status"` // pending, approved, denied, expired, revoked
 	ApprovedBy   string                 `json:"approved_by"`
 	ApprovedAt   *time.Time             `json:"approved_at"`
 	ExpiresAt    *time.Time             `json:"expires_at"`
@@ -150,6 +160,22 @@ type AccessRequest struct {
 	UpdatedAt    time.Time              `json:"updated_at"`
 }
 
+// TemporaryGrant is the Casbin policy created when an access request is
+// approved. It is persisted alongside the request so the sweeper can still
+// remove it after a restart, even if ExpiresAt passed while we were down.
+type TemporaryGrant struct {
+	ID              string     `json:"id" gorm:"primaryKey"`
+	AccessRequestID string     `json:"access_request_id" gorm:"uniqueIndex"`
+	Subject         string     `json:"subject" gorm:"index"`
+	Domain          string     `json:"domain"`
+	Resource        string     `json:"resource"`
+	Action          string     `json:"action"`
+	ExpiresAt       time.Time  `json:"expires_at" gorm:"index"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	RevokedBy       string     `json:"revoked_by"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
 // AuditLog represents an audit log entry
 type AuditLog struct {
 	ID         string                 `json:"id" gorm:"primaryKey"`
@@ -165,6 +191,10 @@ type AuditLog struct {
 	Reason     string                 `json:"reason"`
 	Metadata   map[string]interface{} `json:"metadata" gorm:"serializer:json"`
 	CreatedAt  time.Time              `json:"created_at"`
+	// Seq, PrevHash and Hash place the entry in the tamper-evident chain
+	Seq      int64  `json:"seq,omitempty" gorm:"index"`
+	PrevHash string `json:"prev_hash,omitempty" gorm:"size:64"`
+	Hash     string `json:"hash,omitempty" gorm:"size:64"`
 }
 
 // Table names are namespaced under rbac_* so the gorm models don't collide
@@ -177,6 +207,7 @@ func (TeamMember) TableName() string   { return "rbac_team_members" }
 func (TeamRole) TableName() string     { return "rbac_team_roles" }
 func (Project) TableName() string      { return "rbac_projects" }
 func (AccessRequest) TableName() string { return "rbac_access_requests" }
+func (TemporaryGrant) TableName() string { return "rbac_temporary_grants" }
 func (AuditLog) TableName() string     { return "rbac_audit_logs" }
 
 // PolicyCondition represents a condition for attribute-based access control
@@ -191,21 +222,141 @@ type Service struct {
 	db            *gorm.DB
 	enforcer      *casbin.Enforcer
 	logger        *zap.Logger
-	cache         sync.Map
-	cacheTTL      time.Duration
+	cache         *authCache
 	auditEnabled  bool
+	batchAudit    bool
 	webhookURL    string
+	webhookSecret string
+	webhook       webhookOptions
+	httpClient    *http.Client
+	notifier      notify.Dispatcher
+	externalURL   string
+	sweepInterval time.Duration
+	stopCh        chan struct{}
+	stopOnce      sync.Once
+	auditMu       sync.Mutex
+	// recorder, when set, takes audit entries into the shared audit log
+	// instead of rbac_audit_logs
+	recorder *audit.Recorder
 }
 
 // Config holds RBAC service configuration
 type Config struct {
-	ModelPath    string
-	PolicyPath   string
-	CacheTTL     time.Duration
-	AuditEnabled bool
-	WebhookURL   string
+	ModelPath          string
+	PolicyPath         string
+	CacheTTL           time.Duration
+	CacheMaxEntries    int // upper bound on cached decisions; least recently used are evicted
+	AuditEnabled       bool
+	BatchAudit         bool // write one consolidated audit entry per AuthorizeBatch call
+	WebhookURL         string
+	WebhookSecret      string        // HMAC-SHA256 key for the X-Krustron-Signature header
+	WebhookTimeout     time.Duration // per-attempt timeout
+	WebhookMaxRetries  int
+	ExternalURL        string        // base URL for approve/deny deep links
+	Egress             httpsafe.Policy // where the webhook may be sent
+	GrantSweepInterval time.Duration // how often expired temporary grants are removed
+}
+
+// webhookOptions controls access request webhook delivery
+type webhookOptions struct {
+	maxRetries int
+	backoff    time.Duration // doubled after each failed attempt
+}
+
+// WebhookSignatureHeader carries the hex HMAC-SHA256 of the request body,
+// prefixed with "sha256=", so receivers can verify the sender
+const WebhookSignatureHeader = notify.SignatureHeader
+
+// rbacModel is the Casbin model with domain support and priority.
+//
+// Precedence is deny-override: a request is allowed only if at least one
+// matching policy allows it and no matching policy denies it. Priority does
+// not let an allow beat a deny, so an explicit deny at any scope that matches
+// the request (e.g. "clusters/prod/*") wins over a narrower or higher-priority
+// allow (e.g. "clusters/prod/namespaces/dev"), and vice versa. Requests that
+// match nothing are denied. A bare "*" object or action matches anything;
+// see domainMatch for how policy domains apply to request domains.
+const rbacModel = `
+[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = sub, dom, obj, act, eft, priority
+
+[role_definition]
+g = _, _, _
+g2 = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow)) && !some(where (p.eft == deny))
+
+[matchers]
+m = g(r.sub, p.sub, r.dom) && domainMatch(r.dom, p.dom) && (p.obj == "*" || keyMatch2(r.obj, p.obj)) && (p.act == "*" || regexMatch(r.act, p.act))
+`
+
+// Authorization domains derived from project-scoped role assignments
+const (
+	clusterDomainPrefix   = "cluster:"
+	namespaceDomainPrefix = "namespace:"
+)
+
+// ClusterDomain returns the authorization domain for a cluster
+func ClusterDomain(clusterID string) string {
+	return clusterDomainPrefix + clusterID
+}
+
+// NamespaceDomain returns the authorization domain for a namespace of a
+// cluster. Namespaces are qualified by their cluster, so a grant in one
+// cluster's namespace does not extend to a namespace of the same name in
+// another cluster.
+func NamespaceDomain(clusterID, namespace string) string {
+	return namespaceDomainPrefix + clusterID + "/" + namespace
+}
+
+// configureEnforcer installs matching functions the model relies on.
+// Team membership is stored as g(user, team, "team"); matching the "team"
+// domain against every request domain lets members inherit whatever roles
+// their team holds in a cluster or namespace domain.
+func configureEnforcer(enforcer *casbin.Enforcer) {
+	enforcer.AddNamedDomainMatchingFunc("g", "teamMembership", func(domain, pattern string) bool {
+		return domain == pattern || pattern == "team"
+	})
+	enforcer.AddFunction("domainMatch", func(args ...interface{}) (interface{}, error) {
+		requestDomain, _ := args[0].(string)
+		policyDomain, _ := args[1].(string)
+		return domainMatch(requestDomain, policyDomain), nil
+	})
+}
+
+// domainMatch reports whether a policy domain applies to a request domain.
+// "*" applies everywhere. A scope-type domain ("project", "cluster",
+// "namespace") also applies to the concrete domains derived from it, so a
+// role's project-scoped permissions hold in each of the project's clusters
+// and namespaces once the team has been granted the role there.
+func domainMatch(requestDomain, policyDomain string) bool {
+	switch {
+	case policyDomain == "*" || requestDomain == policyDomain:
+		return true
+	case policyDomain == ResourceProject:
+		return isDerivedDomain(requestDomain)
+	case policyDomain == ResourceCluster:
+		return strings.HasPrefix(requestDomain, clusterDomainPrefix)
+	case policyDomain == ResourceNamespace:
+		return isNamespaceDomain(requestDomain)
+	}
+	return false
 }
 
+// isNamespaceDomain reports whether domain is a cluster-qualified
+// namespace domain, as NamespaceDomain builds
+func isNamespaceDomain(domain string) bool {
+	rest, ok := strings.CutPrefix(domain, namespaceDomainPrefix)
+	return ok && strings.Contains(rest, "/")
+}
+
+// temporaryGrantPriority is the Casbin priority given to access-request grants
+const temporaryGrantPriority = 800
+
 // NewService creates a new RBAC service
 func NewService(db *gorm.DB, logger *zap.Logger, cfg *Config) (*Service, error) {
 	// Auto-migrate tables
@@ -217,6 +368,7 @@ func NewService(db *gorm.DB, logger *zap.Logger, cfg *Config) (*Service, error)
 		&TeamRole{},
 		&Project{},
 		&AccessRequest{},
+		&TemporaryGrant{},
 		&AuditLog{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate RBAC tables: %w", err)
@@ -228,25 +380,7 @@ func NewService(db *gorm.DB, logger *zap.Logger, cfg *Config) (*Service, error)
 		return nil, fmt.Errorf("failed to create Casbin adapter: %w", err)
 	}
 
-	// Define the RBAC model with domain support and priority
-	modelText := `
-[request_definition]
-r = sub, dom, obj, act
-
-[policy_definition]
-p = sub, dom, obj, act, eft, priority
-
-[role_definition]
-g = _, _, _
-g2 = _, _
-
-[policy_effect]
-e = priority(p.eft) || deny
-
-[matchers]
-m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && keyMatch2(r.obj, p.obj) && regexMatch(r.act, p.act)
-`
-	m, err := model.NewModelFromString(modelText)
+	m, err := model.NewModelFromString(rbacModel)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Casbin model: %w", err)
 	}
@@ -256,6 +390,7 @@ m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && keyMatch2(r.obj, p.obj) && regex
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Casbin enforcer: %w", err)
 	}
+	configureEnforcer(enforcer)
 
 	// Load policies
 	if err := enforcer.LoadPolicy(); err != nil {
@@ -266,14 +401,41 @@ m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && keyMatch2(r.obj, p.obj) && regex
 	if cacheTTL == 0 {
 		cacheTTL = 5 * time.Minute
 	}
+	cacheMaxEntries := cfg.CacheMaxEntries
+	if cacheMaxEntries == 0 {
+		cacheMaxEntries = 10000
+	}
+	sweepInterval := cfg.GrantSweepInterval
+	if sweepInterval == 0 {
+		sweepInterval = time.Minute
+	}
+	egress := cfg.Egress
+	if cfg.WebhookTimeout != 0 {
+		egress.Timeout = cfg.WebhookTimeout
+	}
+	httpClient, err := httpsafe.NewClient(egress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress policy: %w", err)
+	}
+	webhookRetries := cfg.WebhookMaxRetries
+	if webhookRetries == 0 {
+		webhookRetries = 3
+	}
 
 	svc := &Service{
-		db:           db,
-		enforcer:     enforcer,
-		logger:       logger,
-		cacheTTL:     cacheTTL,
-		auditEnabled: cfg.AuditEnabled,
-		webhookURL:   cfg.WebhookURL,
+		db:            db,
+		enforcer:      enforcer,
+		logger:        logger,
+		cache:         newAuthCache(cacheTTL, cacheMaxEntries),
+		auditEnabled:  cfg.AuditEnabled,
+		batchAudit:    cfg.BatchAudit,
+		webhookURL:    cfg.WebhookURL,
+		webhookSecret: cfg.WebhookSecret,
+		webhook:       webhookOptions{maxRetries: webhookRetries, backoff: time.Second},
+		httpClient:    httpClient,
+		externalURL:   strings.TrimRight(cfg.ExternalURL, "/"),
+		sweepInterval: sweepInterval,
+		stopCh:        make(chan struct{}),
 	}
 
 	// Initialize default roles
@@ -281,9 +443,21 @@ m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && keyMatch2(r.obj, p.obj) && regex
 		logger.Warn("Failed to initialize default roles", zap.Error(err))
 	}
 
+	// Re-apply temporary grants that were active before a restart
+	if err := svc.loadTemporaryGrants(context.Background()); err != nil {
+		logger.Warn("Failed to load temporary grants", zap.Error(err))
+	}
+
+	go svc.runGrantSweeper()
+
 	return svc, nil
 }
 
+// Stop stops the temporary grant sweeper
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
 // initializeDefaultRoles creates system default roles
 func (s *Service) initializeDefaultRoles() error {
 	defaultRoles := []Role{
@@ -378,7 +552,7 @@ func (s *Service) initializeDefaultRoles() error {
 		subject := "role:" + role.Name
 		for _, perm := range role.Permissions {
 			// p = sub, dom, obj, act, eft, priority  (dom "*" = any domain)
-			_, _ = s.enforcer.AddPolicy(subject, "*", perm.Resource, perm.Action, perm.Effect, perm.Priority)
+			_, _ = s.enforcer.AddPolicy(subject, "*", perm.Resource, perm.Action, perm.Effect, fmt.Sprintf("%d", perm.Priority))
 		}
 	}
 
@@ -402,15 +576,12 @@ func (s *Service) AuthorizeRole(ctx context.Context, role, resource, action stri
 
 // Authorize checks if a subject can perform an action on a resource
 func (s *Service) Authorize(ctx context.Context, userID, domain, resource, action string) (bool, error) {
+	defer observeAuthorize(time.Now())
+
 	// Check cache first
 	cacheKey := fmt.Sprintf("%s:%s:%s:%s", userID, domain, resource, action)
-	if cached, ok := s.cache.Load(cacheKey); ok {
-		if entry, ok := cached.(*cacheEntry); ok {
-			if time.Now().Before(entry.expiry) {
-				return entry.allowed, nil
-			}
-			s.cache.Delete(cacheKey)
-		}
+	if allowed, ok := s.cache.get(cacheKey); ok {
+		return allowed, nil
 	}
 
 	// Check with Casbin enforcer
@@ -426,10 +597,7 @@ func (s *Service) Authorize(ctx context.Context, userID, domain, resource, actio
 	}
 
 	// Cache the result
-	s.cache.Store(cacheKey, &cacheEntry{
-		allowed: allowed,
-		expiry:  time.Now().Add(s.cacheTTL),
-	})
+	s.cache.set(cacheKey, allowed)
 
 	// Audit log
 	if s.auditEnabled {
@@ -443,11 +611,346 @@ func (s *Service) Authorize(ctx context.Context, userID, domain, resource, actio
 	return allowed, nil
 }
 
+// Decision explains the outcome of an authorization check
+type Decision struct {
+	Allowed       bool     `json:"allowed"`
+	Effect        string   `json:"effect,omitempty"` // allow, deny; empty when nothing matched
+	MatchedPolicy []string `json:"matched_policy,omitempty"`
+	Reason        string   `json:"reason"`
+}
+
+// ExplainDecision evaluates a request like Authorize, bypassing the cache,
+// and reports the policy that decided it. Under deny-override the deciding
+// policy is the first matching deny if there is one, else the first allow.
+func (s *Service) ExplainDecision(ctx context.Context, userID, domain, resource, action string) (*Decision, error) {
+	allowed, explain, err := s.enforcer.EnforceEx(userID, domain, resource, action)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate policy: %w", err)
+	}
+
+	decision := &Decision{
+		Allowed:       allowed,
+		MatchedPolicy: explain,
+	}
+
+	// p = sub, dom, obj, act, eft, priority
+	if len(explain) > 4 {
+		decision.Effect = explain[4]
+	}
+
+	switch {
+	case allowed && len(explain) > 3:
+		decision.Reason = fmt.Sprintf("allowed by policy for %s on %s/%s", explain[0], explain[2], explain[3])
+	case allowed:
+		decision.Reason = "allowed"
+	case decision.Effect == "deny":
+		decision.Reason = fmt.Sprintf("explicitly denied by policy for %s on %s/%s", explain[0], explain[2], explain[3])
+	default:
+		decision.Reason = "no matching policy; denied by default"
+	}
+
+	return decision, nil
+}
+
+// ResourceAction is a single check in an AuthorizeBatch call
+type ResourceAction struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+// AuthorizeBatch checks several resource/action pairs for one subject.
+// Results are returned in input order. Cached decisions are resolved in a
+// single sweep, the remainder with one Casbin BatchEnforce call, and at most
+// one consolidated audit entry is written (only when BatchAudit is set).
+func (s *Service) AuthorizeBatch(ctx context.Context, userID, domain string, checks []ResourceAction) ([]bool, error) {
+	results := make([]bool, len(checks))
+	if len(checks) == 0 {
+		return results, nil
+	}
+
+	// Super admins are allowed everything an explicit deny does not forbid
+	if s.isSuperAdmin(userID, domain) {
+		denies, err := s.enforcer.GetFilteredPolicy(4, "deny")
+		if err != nil {
+			return nil, err
+		}
+		for i, check := range checks {
+			denied := false
+			if len(denies) > 0 {
+				if denied, err = s.explicitlyDenied(userID, domain, check); err != nil {
+					return nil, err
+				}
+			}
+			results[i] = !denied
+		}
+		s.logBatchAudit(ctx, userID, domain, checks, results)
+		return results, nil
+	}
+
+	keys := make([]string, len(checks))
+	for i, check := range checks {
+		keys[i] = fmt.Sprintf("%s:%s:%s:%s", userID, domain, check.Resource, check.Action)
+	}
+
+	var missIdx []int
+	var requests [][]interface{}
+	for i, hit := range s.cache.getMany(keys, results) {
+		if !hit {
+			missIdx = append(missIdx, i)
+			requests = append(requests, []interface{}{userID, domain, checks[i].Resource, checks[i].Action})
+		}
+	}
+
+	if len(requests) > 0 {
+		enforced, err := s.enforcer.BatchEnforce(requests)
+		if err != nil {
+			s.logger.Error("Batch authorization check failed",
+				zap.String("user_id", userID),
+				zap.Int("checks", len(requests)),
+				zap.Error(err),
+			)
+			return nil, err
+		}
+
+		missKeys := make([]string, len(missIdx))
+		for j, i := range missIdx {
+			results[i] = enforced[j]
+			missKeys[j] = keys[i]
+		}
+		s.cache.setMany(missKeys, enforced)
+	}
+
+	s.logBatchAudit(ctx, userID, domain, checks, results)
+
+	return results, nil
+}
+
+// isSuperAdmin reports whether the subject holds the wildcard super-admin role
+// in domain, either directly or through a team
+func (s *Service) isSuperAdmin(userID, domain string) bool {
+	if userID == "role:super-admin" {
+		return true
+	}
+	roles, err := s.enforcer.GetImplicitRolesForUser(userID, domain)
+	if err != nil {
+		return false
+	}
+	for _, role := range roles {
+		if role == "super-admin" || role == "role:super-admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// explicitlyDenied reports whether a deny policy decides the check
+func (s *Service) explicitlyDenied(userID, domain string, check ResourceAction) (bool, error) {
+	allowed, explain, err := s.enforcer.EnforceEx(userID, domain, check.Resource, check.Action)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate policy: %w", err)
+	}
+	// p = sub, dom, obj, act, eft, priority
+	return !allowed && len(explain) > 4 && explain[4] == "deny", nil
+}
+
+// logBatchAudit writes one audit entry summarising an AuthorizeBatch call
+func (s *Service) logBatchAudit(ctx context.Context, userID, domain string, checks []ResourceAction, results []bool) {
+	if !s.auditEnabled || !s.batchAudit {
+		return
+	}
+
+	denied := 0
+	decisions := make([]map[string]interface{}, len(checks))
+	for i, check := range checks {
+		if !results[i] {
+			denied++
+		}
+		decisions[i] = map[string]interface{}{
+			"resource": check.Resource,
+			"action":   check.Action,
+			"allowed":  results[i],
+		}
+	}
+
+	result := "success"
+	if denied > 0 {
+		result = "denied"
+	}
+
+	s.createAuditLog(ctx, &AuditLog{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Action:   "authorize_batch",
+		Resource: domain,
+		Result:   result,
+		Reason:   fmt.Sprintf("%d of %d checks denied", denied, len(checks)),
+		Metadata: map[string]interface{}{
+			"checks": decisions,
+		},
+		CreatedAt: time.Now(),
+	})
+}
+
+// CacheStats reports authorization cache effectiveness
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Size    int    `json:"size"`
+	MaxSize int    `json:"max_size"`
+}
+
+// CacheStats returns hit/miss counters and the current size of the
+// authorization cache
+func (s *Service) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+// observeAuthorize records the latency of an authorization check started
+// at start
+func observeAuthorize(start time.Time) {
+	metrics.RBACAuthorizeDuration.Observe(time.Since(start).Seconds())
+}
+
+// authCacheMetrics counts authorization cache hits and misses
+var authCacheMetrics = metrics.NewCache("rbac")
+
+// authCache is a TTL cache of authorization decisions bounded by entry count.
+// A single mutex guards the map and the LRU list since every hit reorders it.
+type authCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List // front is most recently used
+	hits       uint64
+	misses     uint64
+}
+
 type cacheEntry struct {
+	key     string
 	allowed bool
 	expiry  time.Time
 }
 
+func newAuthCache(ttl time.Duration, maxEntries int) *authCache {
+	return &authCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// get returns the cached decision for key if present and not expired
+func (c *authCache) get(key string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		authCacheMetrics.Miss()
+		return false, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expiry) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		c.misses++
+		authCacheMetrics.Miss()
+		return false, false
+	}
+
+	c.lru.MoveToFront(elem)
+	c.hits++
+	authCacheMetrics.Hit()
+	return entry.allowed, true
+}
+
+// set stores a decision, evicting the least recently used entries when full
+func (c *authCache) set(key string, allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiry := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.allowed = allowed
+		entry.expiry = expiry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	for c.lru.Len() >= c.maxEntries {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			break
+		}
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, allowed: allowed, expiry: expiry})
+}
+
+// getMany resolves keys under a single lock, writing cached decisions into
+// results and reporting which keys were hits
+func (c *authCache) getMany(keys []string, results []bool) []bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	hits := make([]bool, len(keys))
+	for i, key := range keys {
+		elem, ok := c.entries[key]
+		if !ok {
+			c.misses++
+			continue
+		}
+		entry := elem.Value.(*cacheEntry)
+		if !now.Before(entry.expiry) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+			c.misses++
+			continue
+		}
+		c.lru.MoveToFront(elem)
+		c.hits++
+		results[i] = entry.allowed
+		hits[i] = true
+	}
+	return hits
+}
+
+// setMany stores several decisions
+func (c *authCache) setMany(keys []string, allowed []bool) {
+	for i, key := range keys {
+		c.set(key, allowed[i])
+	}
+}
+
+// clear drops every cached decision; counters are kept
+func (c *authCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *authCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Size:    c.lru.Len(),
+		MaxSize: c.maxEntries,
+	}
+}
+
 // AuthorizeWithConditions checks authorization with attribute-based conditions
 func (s *Service) AuthorizeWithConditions(ctx context.Context, userID, domain, resource, action string, attributes map[string]interface{}) (bool, error) {
 	// First check basic authorization
@@ -557,11 +1060,13 @@ func (s *Service) CreateRole(ctx context.Context, role *Role) error {
 	s.enforcer.SavePolicy()
 	s.invalidateCache()
 
+	s.auditChange(ctx, "create_role", role.ID, nil, role)
 	return nil
 }
 
 // UpdateRole updates an existing role
 func (s *Service) UpdateRole(ctx context.Context, role *Role) error {
+	before, _ := s.GetRole(ctx, role.ID)
 	role.UpdatedAt = time.Now()
 
 	// Delete existing permissions
@@ -584,6 +1089,7 @@ func (s *Service) UpdateRole(ctx context.Context, role *Role) error {
 	s.enforcer.LoadPolicy()
 	s.invalidateCache()
 
+	s.auditChange(ctx, "update_role", role.ID, before, role)
 	return nil
 }
 
@@ -605,6 +1111,7 @@ func (s *Service) DeleteRole(ctx context.Context, roleID string) error {
 	s.enforcer.LoadPolicy()
 	s.invalidateCache()
 
+	s.auditChange(ctx, "delete_role", role.ID, &role, nil)
 	return nil
 }
 
@@ -646,6 +1153,71 @@ func (s *Service) CreateTeam(ctx context.Context, team *Team) error {
 	return nil
 }
 
+// GetTeam retrieves a team by ID with its members and roles
+func (s *Service) GetTeam(ctx context.Context, teamID string) (*Team, error) {
+	var team Team
+	if err := s.db.Preload("Members").Preload("Roles").First(&team, "id = ?", teamID).Error; err != nil {
+		return nil, fmt.Errorf("team not found: %w", err)
+	}
+	return &team, nil
+}
+
+// ListTeams lists all teams with their members, oldest first
+func (s *Service) ListTeams(ctx context.Context) ([]Team, error) {
+	var teams []Team
+	if err := s.db.Preload("Members").Order("created_at, id").Find(&teams).Error; err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+	return teams, nil
+}
+
+// UpdateTeam updates a team's name, description and metadata. Members
+// and roles are managed separately.
+func (s *Service) UpdateTeam(ctx context.Context, team *Team) error {
+	team.UpdatedAt = time.Now()
+	result := s.db.Model(&Team{ID: team.ID}).
+		Select("name", "display_name", "description", "metadata", "updated_at").
+		Updates(team)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update team: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("team not found: %w", gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// DeleteTeam deletes a team, its memberships and its role assignments
+func (s *Service) DeleteTeam(ctx context.Context, teamID string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", teamID).Delete(&TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", teamID).Delete(&TeamRole{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", teamID).Delete(&Team{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("team not found: %w", gorm.ErrRecordNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete team: %w", err)
+	}
+
+	// Drop g(user, team, "team") memberships and the team's role grants
+	s.enforcer.RemoveFilteredGroupingPolicy(1, teamID, "team")
+	s.enforcer.RemoveFilteredGroupingPolicy(0, teamID)
+	s.enforcer.SavePolicy()
+	s.invalidateCache()
+
+	return nil
+}
+
 // AddTeamMember adds a user to a team
 func (s *Service) AddTeamMember(ctx context.Context, teamID, userID, role, invitedBy string) error {
 	member := &TeamMember{
@@ -701,7 +1273,19 @@ func (s *Service) AssignRoleToTeam(ctx context.Context, teamID, roleID, scope, s
 	// Get role name
 	var role Role
 	if err := s.db.First(&role, "id = ?", roleID).Error; err == nil {
-		s.enforcer.AddGroupingPolicy(teamID, role.Name, scope)
+		s.enforcer.AddGroupingPolicy(teamID, policySubject(&role), scope)
+
+		// A project assignment also grants the role in every cluster and
+		// namespace the project lists
+		if scope == ResourceProject {
+			if err := s.reconcileProjectGrants(teamID, &role); err != nil {
+				s.logger.Warn("Failed to expand project grants",
+					zap.String("team_id", teamID),
+					zap.String("project_id", scopeID),
+					zap.Error(err),
+				)
+			}
+		}
 		s.enforcer.SavePolicy()
 	}
 
@@ -710,6 +1294,92 @@ func (s *Service) AssignRoleToTeam(ctx context.Context, teamID, roleID, scope, s
 	return nil
 }
 
+// policySubject returns the Casbin subject a role's policies are stored
+// under. Seeded system roles use "role:<name>" (see initializeDefaultRoles);
+// roles created through CreateRole use the bare name.
+func policySubject(role *Role) string {
+	if role.Type == "system" {
+		return "role:" + role.Name
+	}
+	return role.Name
+}
+
+// projectDomains returns the authorization domains a project expands to:
+// each of its clusters, and each of its namespaces in each of its
+// clusters. A project without clusters grants no namespaces.
+func projectDomains(project *Project) []string {
+	domains := make([]string, 0, len(project.Clusters)*(1+len(project.Namespaces)))
+	for _, clusterID := range project.Clusters {
+		domains = append(domains, ClusterDomain(clusterID))
+		for _, ns := range project.Namespaces {
+			domains = append(domains, NamespaceDomain(clusterID, ns))
+		}
+	}
+	return domains
+}
+
+// isDerivedDomain reports whether a grouping domain is a cluster or
+// namespace domain, the kinds project expansion produces
+func isDerivedDomain(domain string) bool {
+	return strings.HasPrefix(domain, clusterDomainPrefix) || isNamespaceDomain(domain)
+}
+
+// reconcileProjectGrants makes the team's derived grouping policies for a
+// role match the union of every project the role is assigned on. Computing
+// the union means a cluster or namespace shared by two projects keeps its
+// grant when only one of them drops it. Grants the role was assigned
+// directly in a cluster or namespace are never revoked.
+func (s *Service) reconcileProjectGrants(teamID string, role *Role) error {
+	var assignments []TeamRole
+	if err := s.db.Where("team_id = ? AND role_id = ?", teamID, role.ID).
+		Find(&assignments).Error; err != nil {
+		return fmt.Errorf("failed to list role assignments: %w", err)
+	}
+
+	desired := make(map[string]bool)
+	direct := make(map[string]bool)
+	for _, assignment := range assignments {
+		if assignment.Scope != ResourceProject {
+			direct[assignment.Scope] = true
+			continue
+		}
+		var project Project
+		if err := s.db.First(&project, "id = ?", assignment.ScopeID).Error; err != nil {
+			continue
+		}
+		for _, domain := range projectDomains(&project) {
+			desired[domain] = true
+		}
+	}
+
+	subject := policySubject(role)
+	current, err := s.enforcer.GetFilteredGroupingPolicy(0, teamID, subject)
+	if err != nil {
+		return fmt.Errorf("failed to read grouping policies: %w", err)
+	}
+
+	for _, g := range current {
+		if len(g) < 3 || !isDerivedDomain(g[2]) {
+			continue
+		}
+		if desired[g[2]] || direct[g[2]] {
+			delete(desired, g[2])
+			continue
+		}
+		if _, err := s.enforcer.RemoveGroupingPolicy(teamID, subject, g[2]); err != nil {
+			return fmt.Errorf("failed to revoke derived grant: %w", err)
+		}
+	}
+
+	for domain := range desired {
+		if _, err := s.enforcer.AddGroupingPolicy(teamID, subject, domain); err != nil {
+			return fmt.Errorf("failed to add derived grant: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // CreateProject creates a new project
 func (s *Service) CreateProject(ctx context.Context, project *Project) error {
 	project.ID = uuid.New().String()
@@ -732,6 +1402,43 @@ func (s *Service) GetProject(ctx context.Context, projectID string) (*Project, e
 	return &project, nil
 }
 
+// UpdateProject updates a project and re-expands the cluster/namespace grants
+// of every team holding a role on it
+func (s *Service) UpdateProject(ctx context.Context, project *Project) error {
+	var existing Project
+	if err := s.db.First(&existing, "id = ?", project.ID).Error; err != nil {
+		return fmt.Errorf("project not found: %w", err)
+	}
+
+	project.CreatedAt = existing.CreatedAt
+	project.CreatedBy = existing.CreatedBy
+	project.UpdatedAt = time.Now()
+
+	if err := s.db.Save(project).Error; err != nil {
+		return fmt.Errorf("failed to update project: %w", err)
+	}
+
+	var assignments []TeamRole
+	if err := s.db.Where("scope = ? AND scope_id = ?", ResourceProject, project.ID).Find(&assignments).Error; err != nil {
+		return fmt.Errorf("failed to list project assignments: %w", err)
+	}
+
+	for _, assignment := range assignments {
+		var role Role
+		if err := s.db.First(&role, "id = ?", assignment.RoleID).Error; err != nil {
+			continue
+		}
+		if err := s.reconcileProjectGrants(assignment.TeamID, &role); err != nil {
+			return err
+		}
+	}
+
+	s.enforcer.SavePolicy()
+	s.invalidateCache()
+
+	return nil
+}
+
 // ListProjects lists projects accessible by a user
 func (s *Service) ListProjects(ctx context.Context, userID string) ([]Project, error) {
 	var projects []Project
@@ -764,8 +1471,8 @@ func (s *Service) CreateAccessRequest(ctx context.Context, req *AccessRequest) e
 		return fmt.Errorf("failed to create access request: %w", err)
 	}
 
-	// Send webhook notification if configured
-	if s.webhookURL != "" {
+	// Send notifications if configured
+	if s.webhookURL != "" || s.notifier != nil {
 		go s.sendAccessRequestNotification(req)
 	}
 
@@ -783,6 +1490,10 @@ func (s *Service) ApproveAccessRequest(ctx context.Context, requestID, approverI
 		return fmt.Errorf("access request is not pending")
 	}
 
+	if req.Duration <= 0 {
+		return fmt.Errorf("access request has no duration")
+	}
+
 	now := time.Now()
 	expiresAt := now.Add(req.Duration)
 	req.Status = "approved"
@@ -791,12 +1502,174 @@ func (s *Service) ApproveAccessRequest(ctx context.Context, requestID, approverI
 	req.ExpiresAt = &expiresAt
 	req.UpdatedAt = now
 
-	if err := s.db.Save(&req).Error; err != nil {
+	grant := &TemporaryGrant{
+		ID:              uuid.New().String(),
+		AccessRequestID: req.ID,
+		Subject:         req.UserID,
+		Domain:          req.ScopeID,
+		Resource:        req.Resource,
+		Action:          req.Action,
+		ExpiresAt:       expiresAt,
+		CreatedAt:       now,
+	}
+	if grant.Domain == "" {
+		grant.Domain = "*"
+	}
+	if grant.Action == "" {
+		grant.Action = "*"
+	}
+
+	// Persist the request and its grant together so a crash can't leave an
+	// approved request without a grant the sweeper knows how to expire.
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&req).Error; err != nil {
+			return err
+		}
+		return tx.Create(grant).Error
+	}); err != nil {
 		return fmt.Errorf("failed to approve access request: %w", err)
 	}
 
 	// Grant temporary access
-	// This would typically add a temporary policy to Casbin
+	if _, err := s.enforcer.AddPolicy(grant.policy()...); err != nil {
+		return fmt.Errorf("failed to add temporary policy: %w", err)
+	}
+	s.enforcer.SavePolicy()
+	s.invalidateCache()
+
+	if s.auditEnabled {
+		s.logAudit(ctx, approverID, "grant_temporary_access", req.Resource, req.ID, "success", req.Reason)
+	}
+
+	return nil
+}
+
+// RevokeAccessRequest removes the temporary grant of an approved access
+// request before it expires
+func (s *Service) RevokeAccessRequest(ctx context.Context, requestID, revokerID string) error {
+	var req AccessRequest
+	if err := s.db.First(&req, "id = ?", requestID).Error; err != nil {
+		return fmt.Errorf("access request not found: %w", err)
+	}
+
+	if req.Status != "approved" {
+		return fmt.Errorf("access request is not approved")
+	}
+
+	var grant TemporaryGrant
+	if err := s.db.Where("access_request_id = ? AND revoked_at IS NULL", requestID).First(&grant).Error; err != nil {
+		return fmt.Errorf("temporary grant not found: %w", err)
+	}
+
+	if err := s.removeTemporaryGrant(&grant, "revoked", revokerID); err != nil {
+		return err
+	}
+
+	if s.auditEnabled {
+		s.logAudit(ctx, revokerID, "revoke_temporary_access", req.Resource, req.ID, "success", "")
+	}
+
+	return nil
+}
+
+// policy returns the Casbin policy tuple for the grant
+func (g *TemporaryGrant) policy() []interface{} {
+	// p = sub, dom, obj, act, eft, priority
+	return []interface{}{g.Subject, g.Domain, g.Resource, g.Action, "allow", fmt.Sprintf("%d", temporaryGrantPriority)}
+}
+
+// loadTemporaryGrants re-adds active grants to the enforcer and expires any
+// whose deadline passed while the service was down
+func (s *Service) loadTemporaryGrants(ctx context.Context) error {
+	var grants []TemporaryGrant
+	if err := s.db.Where("revoked_at IS NULL").Find(&grants).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range grants {
+		if !grants[i].ExpiresAt.After(now) {
+			continue
+		}
+		if _, err := s.enforcer.AddPolicy(grants[i].policy()...); err != nil {
+			s.logger.Warn("Failed to restore temporary grant", zap.String("grant_id", grants[i].ID), zap.Error(err))
+		}
+	}
+	s.invalidateCache()
+
+	s.expireTemporaryGrants(ctx)
+
+	s.logger.Info("Loaded temporary grants", zap.Int("count", len(grants)))
+	return nil
+}
+
+// runGrantSweeper periodically expires temporary grants
+func (s *Service) runGrantSweeper() {
+	ticker := time.NewTicker(s.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.expireTemporaryGrants(context.Background())
+		}
+	}
+}
+
+// expireTemporaryGrants removes every active grant whose ExpiresAt has passed
+func (s *Service) expireTemporaryGrants(ctx context.Context) {
+	var grants []TemporaryGrant
+	if err := s.db.Where("revoked_at IS NULL AND expires_at <= ?", time.Now()).Find(&grants).Error; err != nil {
+		s.logger.Error("Failed to query expired temporary grants", zap.Error(err))
+		return
+	}
+
+	for i := range grants {
+		if err := s.removeTemporaryGrant(&grants[i], "expired", "system"); err != nil {
+			s.logger.Error("Failed to expire temporary grant",
+				zap.String("grant_id", grants[i].ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		if s.auditEnabled {
+			s.logAudit(ctx, grants[i].Subject, "expire_temporary_access", grants[i].Resource, grants[i].AccessRequestID, "success", "")
+		}
+	}
+}
+
+// removeTemporaryGrant deletes the grant's policy, marks the grant revoked
+// and moves its access request to the given status
+func (s *Service) removeTemporaryGrant(grant *TemporaryGrant, status, revokedBy string) error {
+	now := time.Now()
+	grant.RevokedAt = &now
+	grant.RevokedBy = revokedBy
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(grant).Error; err != nil {
+			return err
+		}
+		return tx.Model(&AccessRequest{}).Where("id = ?", grant.AccessRequestID).
+			Updates(map[string]interface{}{"status": status, "updated_at": now}).Error
+	}); err != nil {
+		return fmt.Errorf("failed to remove temporary grant: %w", err)
+	}
+
+	// Another active grant may carry the identical policy tuple (e.g. two
+	// overlapping requests); keep the policy until the last one goes away.
+	var remaining int64
+	s.db.Model(&TemporaryGrant{}).
+		Where("revoked_at IS NULL AND subject = ? AND domain = ? AND resource = ? AND action = ?",
+			grant.Subject, grant.Domain, grant.Resource, grant.Action).
+		Count(&remaining)
+	if remaining == 0 {
+		if _, err := s.enforcer.RemovePolicy(grant.policy()...); err != nil {
+			return fmt.Errorf("failed to remove temporary policy: %w", err)
+		}
+		s.enforcer.SavePolicy()
+	}
 
 	s.invalidateCache()
 
@@ -877,62 +1750,123 @@ func (s *Service) logAudit(ctx context.Context, userID, action, resource, resour
 		CreatedAt:  time.Now(),
 	}
 
-	// Extract IP and user agent from context if available
-	if ipAddr, ok := ctx.Value("ip_address").(string); ok {
-		audit.IPAddress = ipAddr
-	}
-	if userAgent, ok := ctx.Value("user_agent").(string); ok {
-		audit.UserAgent = userAgent
-	}
-
-	if err := s.db.Create(audit).Error; err != nil {
-		s.logger.Error("Failed to create audit log", zap.Error(err))
-	}
+	s.createAuditLog(ctx, audit)
 }
 
-// GetAuditLogs retrieves audit logs with filtering
-func (s *Service) GetAuditLogs(ctx context.Context, filter map[string]interface{}, limit, offset int) ([]AuditLog, int64, error) {
-	var logs []AuditLog
-	var total int64
+// invalidateCache clears the authorization cache
+func (s *Service) invalidateCache() {
+	s.cache.clear()
+}
 
-	query := s.db.Model(&AuditLog{})
+// AccessRequestNotification is the JSON body posted to the access request webhook
+type AccessRequestNotification struct {
+	Event           string    `json:"event"`
+	RequestID       string    `json:"request_id"`
+	UserID          string    `json:"user_id"`
+	RoleID          string    `json:"role_id,omitempty"`
+	Resource        string    `json:"resource"`
+	ResourceID      string    `json:"resource_id,omitempty"`
+	Action          string    `json:"action,omitempty"`
+	ScopeID         string    `json:"scope_id,omitempty"`
+	Reason          string    `json:"reason"`
+	Duration        string    `json:"duration"`
+	DurationSeconds int64     `json:"duration_seconds"`
+	ApproveURL      string    `json:"approve_url,omitempty"`
+	DenyURL         string    `json:"deny_url,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
 
-	if userID, ok := filter["user_id"]; ok {
-		query = query.Where("user_id = ?", userID)
-	}
-	if action, ok := filter["action"]; ok {
-		query = query.Where("action = ?", action)
-	}
-	if resource, ok := filter["resource"]; ok {
-		query = query.Where("resource = ?", resource)
-	}
-	if startTime, ok := filter["start_time"].(time.Time); ok {
-		query = query.Where("created_at >= ?", startTime)
-	}
-	if endTime, ok := filter["end_time"].(time.Time); ok {
-		query = query.Where("created_at <= ?", endTime)
-	}
+// EventAccessRequest is the event type of new access requests, for
+// notification routes
+const EventAccessRequest = "rbac.access_request"
 
-	query.Count(&total)
+// SetNotifier also sends new access requests through d's routes. Optional.
+func (s *Service) SetNotifier(d notify.Dispatcher) { s.notifier = d }
 
-	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&logs).Error; err != nil {
-		return nil, 0, fmt.Errorf("failed to get audit logs: %w", err)
+// sendAccessRequestNotification announces a new access request through
+// the notifier, and posts a signed webhook for it, retrying with
+// exponential backoff. Failures are only logged; it runs in its own
+// goroutine so it never blocks CreateAccessRequest.
+func (s *Service) sendAccessRequestNotification(req *AccessRequest) {
+	notification := AccessRequestNotification{
+		Event:           "access_request.created",
+		RequestID:       req.ID,
+		UserID:          req.UserID,
+		RoleID:          req.RoleID,
+		Resource:        req.Resource,
+		ResourceID:      req.ResourceID,
+		Action:          req.Action,
+		ScopeID:         req.ScopeID,
+		Reason:          req.Reason,
+		Duration:        req.Duration.String(),
+		DurationSeconds: int64(req.Duration.Seconds()),
+		CreatedAt:       req.CreatedAt,
+	}
+	if s.externalURL != "" {
+		notification.ApproveURL = fmt.Sprintf("%s/rbac/access-requests/%s/approve", s.externalURL, req.ID)
+		notification.DenyURL = fmt.Sprintf("%s/rbac/access-requests/%s/deny", s.externalURL, req.ID)
+	}
+	n := &notify.Notification{
+		Event:    EventAccessRequest,
+		Severity: notify.SeverityInfo,
+		Title:    fmt.Sprintf("Access request from %s", req.UserID),
+		Message:  req.Reason,
+		Fields: []notify.Field{
+			{Name: "Resource", Value: strings.TrimSuffix(req.Resource+"/"+req.ResourceID, "/")},
+			{Name: "Action", Value: req.Action},
+			{Name: "Role", Value: req.RoleID},
+			{Name: "Scope", Value: req.ScopeID},
+			{Name: "Duration", Value: req.Duration.String()},
+			{Name: "Approve", Value: notification.ApproveURL},
+			{Name: "Deny", Value: notification.DenyURL},
+		},
+		Source:    "rbac",
+		Timestamp: req.CreatedAt,
+		Payload:   notification,
+	}
+	ctx := context.Background()
+
+	if s.notifier != nil {
+		if err := s.notifier.Notify(ctx, n); err != nil && !errors.Is(err, notify.ErrNotRouted) {
+			s.logger.Warn("Failed to route access request notification",
+				zap.String("request_id", req.ID),
+				zap.Error(err),
+			)
+		}
 	}
+	if s.webhookURL == "" {
+		return
+	}
+
+	webhook := notify.NewWebhook(s.httpClient, s.webhookURL, s.webhookSecret)
+	backoff := s.webhook.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = webhook.Send(ctx, n)
+		if err == nil {
+			s.logger.Info("Access request notification sent",
+				zap.String("request_id", req.ID),
+				zap.String("user_id", req.UserID),
+				zap.Int("attempt", attempt),
+			)
+			return
+		}
+		if attempt > s.webhook.maxRetries {
+			break
+		}
 
-	return logs, total, nil
-}
-
-// invalidateCache clears the authorization cache
-func (s *Service) invalidateCache() {
-	s.cache = sync.Map{}
-}
+		select {
+		case <-s.stopCh:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
 
-// sendAccessRequestNotification sends a webhook notification for access requests
-func (s *Service) sendAccessRequestNotification(req *AccessRequest) {
-	// Implementation would send HTTP POST to webhook URL
-	s.logger.Info("Access request notification sent",
+	s.logger.Error("Failed to send access request notification",
 		zap.String("request_id", req.ID),
 		zap.String("user_id", req.UserID),
+		zap.Error(err),
 	)
 }
 
@@ -0,0 +1,530 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newTestService builds a Service backed by an in-memory enforcer (no DB,
+// audit disabled) so Authorize can be exercised without Postgres.
+func newTestService(t testing.TB, maxEntries int) *Service {
+	t.Helper()
+
+	m, err := model.NewModelFromString(rbacModel)
+	require.NoError(t, err)
+
+	enforcer, err := casbin.NewEnforcer(m)
+	require.NoError(t, err)
+	configureEnforcer(enforcer)
+
+	_, err = enforcer.AddPolicy("alice", "*", ResourceCluster, ActionRead, "allow", "100")
+	require.NoError(t, err)
+
+	return &Service{
+		enforcer: enforcer,
+		logger:   zap.NewNop(),
+		cache:    newAuthCache(time.Minute, maxEntries),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// TestAuthorizeConcurrentInvalidation hammers Authorize while the cache is
+// being invalidated; run with -race to catch unsynchronised cache access.
+func TestAuthorizeConcurrentInvalidation(t *testing.T) {
+	svc := newTestService(t, 64)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				user := fmt.Sprintf("user-%d", (worker*500+j)%200)
+				_, err := svc.Authorize(ctx, user, "*", ResourceCluster, ActionRead)
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			svc.invalidateCache()
+			_ = svc.CacheStats()
+		}
+	}()
+
+	wg.Wait()
+
+	stats := svc.CacheStats()
+	assert.LessOrEqual(t, stats.Size, 64)
+	assert.Equal(t, uint64(8*500), stats.Hits+stats.Misses)
+}
+
+func TestAuthCacheEviction(t *testing.T) {
+	c := newAuthCache(time.Minute, 2)
+
+	c.set("a", true)
+	c.set("b", false)
+	_, ok := c.get("a") // a becomes most recently used
+	require.True(t, ok)
+	c.set("c", true) // evicts b
+
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	allowed, ok := c.get("a")
+	assert.True(t, ok)
+	assert.True(t, allowed)
+	_, ok = c.get("c")
+	assert.True(t, ok)
+
+	stats := c.stats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+}
+
+func TestAuthCacheTTL(t *testing.T) {
+	c := newAuthCache(10*time.Millisecond, 10)
+
+	c.set("a", true)
+	_, ok := c.get("a")
+	require.True(t, ok)
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok = c.get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.stats().Size)
+}
+
+func TestAuthorizeUsesCache(t *testing.T) {
+	svc := newTestService(t, 16)
+	ctx := context.Background()
+
+	allowed, err := svc.Authorize(ctx, "alice", "*", ResourceCluster, ActionRead)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = svc.Authorize(ctx, "alice", "*", ResourceCluster, ActionRead)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	stats := svc.CacheStats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+}
+
+func TestAuthorizeBatchPreservesOrder(t *testing.T) {
+	svc := newTestService(t, 16)
+	ctx := context.Background()
+
+	// Warm one entry so the batch mixes cache hits and enforcer results
+	_, err := svc.Authorize(ctx, "alice", "*", ResourceCluster, ActionRead)
+	require.NoError(t, err)
+
+	results, err := svc.AuthorizeBatch(ctx, "alice", "*", []ResourceAction{
+		{Resource: ResourceCluster, Action: ActionDelete},
+		{Resource: ResourceCluster, Action: ActionRead},
+		{Resource: ResourcePipeline, Action: ActionRead},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, false}, results)
+}
+
+func TestAuthorizeBatchSuperAdmin(t *testing.T) {
+	svc := newTestService(t, 16)
+
+	results, err := svc.AuthorizeBatch(context.Background(), "role:super-admin", "*", []ResourceAction{
+		{Resource: ResourceSecret, Action: ActionDelete},
+		{Resource: ResourceUser, Action: ActionCreate},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true}, results)
+}
+
+func TestAuthorizeBatchSuperAdminHonoursDeny(t *testing.T) {
+	svc := newTestService(t, 16)
+	_, err := svc.enforcer.AddPolicy("role:super-admin", "*", "*", "*", "allow", "1000")
+	require.NoError(t, err)
+	_, err = svc.enforcer.AddPolicy("role:super-admin", "*", ResourceSecret, ActionDelete, "deny", "1000")
+	require.NoError(t, err)
+
+	results, err := svc.AuthorizeBatch(context.Background(), "role:super-admin", "*", []ResourceAction{
+		{Resource: ResourceSecret, Action: ActionDelete},
+		{Resource: ResourceUser, Action: ActionCreate},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, results)
+}
+
+// dashboardChecks mirrors the number of checks a dashboard page load makes
+func dashboardChecks() []ResourceAction {
+	resources := []string{ResourceCluster, ResourceNamespace, ResourceApplication, ResourcePipeline, ResourceHelm,
+		ResourceSecret, ResourceConfigMap, ResourceUser, ResourceRole, ResourceTeam}
+	actions := []string{ActionCreate, ActionRead, ActionUpdate, ActionDelete}
+
+	checks := make([]ResourceAction, 0, len(resources)*len(actions))
+	for _, r := range resources {
+		for _, a := range actions {
+			checks = append(checks, ResourceAction{Resource: r, Action: a})
+		}
+	}
+	return checks
+}
+
+func BenchmarkAuthorizeSequential(b *testing.B) {
+	svc := newTestService(b, 1024)
+	ctx := context.Background()
+	checks := dashboardChecks()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		svc.invalidateCache()
+		for _, c := range checks {
+			if _, err := svc.Authorize(ctx, "alice", "*", c.Resource, c.Action); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkAuthorizeBatch(b *testing.B) {
+	svc := newTestService(b, 1024)
+	ctx := context.Background()
+	checks := dashboardChecks()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		svc.invalidateCache()
+		if _, err := svc.AuthorizeBatch(ctx, "alice", "*", checks); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDenyOverridePrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies [][]interface{}
+		resource string
+		allowed  bool
+		effect   string
+	}{
+		{
+			name: "allow at namespace, deny at cluster",
+			policies: [][]interface{}{
+				{"bob", "*", "clusters/prod/namespaces/dev", ActionUpdate, "allow", "10"},
+				{"bob", "*", "clusters/prod/*", ActionUpdate, "deny", "900"},
+			},
+			resource: "clusters/prod/namespaces/dev",
+			allowed:  false,
+			effect:   "deny",
+		},
+		{
+			name: "allow at cluster, deny at namespace",
+			policies: [][]interface{}{
+				{"bob", "*", "clusters/prod/*", ActionUpdate, "allow", "10"},
+				{"bob", "*", "clusters/prod/namespaces/dev", ActionUpdate, "deny", "900"},
+			},
+			resource: "clusters/prod/namespaces/dev",
+			allowed:  false,
+			effect:   "deny",
+		},
+		{
+			name: "deny at namespace leaves sibling namespace allowed",
+			policies: [][]interface{}{
+				{"bob", "*", "clusters/prod/*", ActionUpdate, "allow", "10"},
+				{"bob", "*", "clusters/prod/namespaces/dev", ActionUpdate, "deny", "900"},
+			},
+			resource: "clusters/prod/namespaces/qa",
+			allowed:  true,
+			effect:   "allow",
+		},
+		{
+			name: "deny at other cluster does not apply",
+			policies: [][]interface{}{
+				{"bob", "*", "clusters/prod/namespaces/dev", ActionUpdate, "allow", "10"},
+				{"bob", "*", "clusters/staging/*", ActionUpdate, "deny", "900"},
+			},
+			resource: "clusters/prod/namespaces/dev",
+			allowed:  true,
+			effect:   "allow",
+		},
+		{
+			name:     "no matching policy",
+			resource: "clusters/prod/namespaces/dev",
+			allowed:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(t, 16)
+			for _, p := range tt.policies {
+				_, err := svc.enforcer.AddPolicy(p...)
+				require.NoError(t, err)
+			}
+
+			allowed, err := svc.Authorize(context.Background(), "bob", "*", tt.resource, ActionUpdate)
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, allowed)
+
+			decision, err := svc.ExplainDecision(context.Background(), "bob", "*", tt.resource, ActionUpdate)
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, decision.Allowed)
+			assert.Equal(t, tt.effect, decision.Effect)
+			assert.NotEmpty(t, decision.Reason)
+		})
+	}
+}
+
+func TestAccessRequestWebhook(t *testing.T) {
+	const secret = "webhook-secret"
+
+	var attempts int32
+	received := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first delivery to exercise the retry path
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, notify.SignPayload(secret, body), r.Header.Get(WebhookSignatureHeader))
+
+		received <- body
+	}))
+	defer srv.Close()
+
+	svc := newTestService(t, 16)
+	svc.webhookURL = srv.URL
+	svc.webhookSecret = secret
+	svc.webhook = webhookOptions{maxRetries: 2, backoff: time.Millisecond}
+	svc.httpClient = srv.Client()
+	svc.externalURL = "https://krustron.example.com"
+
+	svc.sendAccessRequestNotification(&AccessRequest{
+		ID:       "req-1",
+		UserID:   "alice",
+		Resource: ResourceCluster,
+		Action:   ActionUpdate,
+		ScopeID:  ClusterDomain("prod"),
+		Reason:   "incident",
+		Duration: 2 * time.Hour,
+	})
+
+	var body []byte
+	select {
+	case body = <-received:
+	default:
+		t.Fatal("webhook was not delivered")
+	}
+
+	var payload AccessRequestNotification
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "access_request.created", payload.Event)
+	assert.Equal(t, "req-1", payload.RequestID)
+	assert.Equal(t, "alice", payload.UserID)
+	assert.Equal(t, ResourceCluster, payload.Resource)
+	assert.Equal(t, "incident", payload.Reason)
+	assert.Equal(t, int64(7200), payload.DurationSeconds)
+	assert.Equal(t, "https://krustron.example.com/rbac/access-requests/req-1/approve", payload.ApproveURL)
+	assert.Equal(t, "https://krustron.example.com/rbac/access-requests/req-1/deny", payload.DenyURL)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestAuthorizeRecordsMetrics(t *testing.T) {
+	svc := newTestService(t, 16)
+	ctx := context.Background()
+	observed := authorizeCount(t)
+	hits, misses := testutil.ToFloat64(authCacheMetrics.Hits), testutil.ToFloat64(authCacheMetrics.Misses)
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.Authorize(ctx, "alice", "*", ResourceCluster, ActionRead)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, observed+3, authorizeCount(t))
+	assert.Equal(t, hits+2, testutil.ToFloat64(authCacheMetrics.Hits))
+	assert.Equal(t, misses+1, testutil.ToFloat64(authCacheMetrics.Misses))
+}
+
+// authorizeCount is how many authorization checks have been observed
+func authorizeCount(t *testing.T) uint64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, metrics.RBACAuthorizeDuration.Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+// newDBTestService builds a Service over an in-memory database, with the
+// default roles seeded
+func newDBTestService(t *testing.T) *Service {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+uuid.NewString()+"?mode=memory&cache=shared"),
+		&gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{})
+	require.NoError(t, err)
+	t.Cleanup(svc.Stop)
+	return svc
+}
+
+func TestProjectAssignmentGrantsProjectNamespaces(t *testing.T) {
+	ctx := context.Background()
+	svc := newDBTestService(t)
+
+	role := &Role{Name: "ns-reader", Type: "custom", Permissions: []Permission{
+		{Resource: ResourceNamespace, Action: ActionRead, Scope: ResourceNamespace, Effect: "allow", Priority: 500},
+		{Resource: ResourceCluster, Action: ActionRead, Scope: ResourceCluster, Effect: "allow", Priority: 500},
+	}}
+	require.NoError(t, svc.CreateRole(ctx, role))
+	team := &Team{Name: "shop-oncall"}
+	require.NoError(t, svc.CreateTeam(ctx, team))
+	require.NoError(t, svc.AddTeamMember(ctx, team.ID, "u1", "member", "admin"))
+
+	// Namespace web is in both projects
+	shop := &Project{Name: "shop", Namespaces: []string{"shop", "web"}, Clusters: []string{"prod"}}
+	require.NoError(t, svc.CreateProject(ctx, shop))
+	site := &Project{Name: "site", Namespaces: []string{"web"}, Clusters: []string{"prod"}}
+	require.NoError(t, svc.CreateProject(ctx, site))
+	require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, role.ID, ResourceProject, shop.ID, "admin"))
+	require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, role.ID, ResourceProject, site.ID, "admin"))
+
+	allowed := func(domain string) bool {
+		resource := ResourceNamespace
+		if strings.HasPrefix(domain, clusterDomainPrefix) {
+			resource = ResourceCluster
+		}
+		ok, err := svc.Authorize(ctx, "u1", domain, resource, ActionRead)
+		require.NoError(t, err)
+		return ok
+	}
+	assert.True(t, allowed(NamespaceDomain("prod", "shop")))
+	assert.True(t, allowed(NamespaceDomain("prod", "web")))
+	assert.True(t, allowed(ClusterDomain("prod")))
+	assert.False(t, allowed(NamespaceDomain("prod", "billing")))
+	assert.False(t, allowed(ClusterDomain("staging")))
+
+	// Removing a namespace revokes it, unless another project still lists it
+	shop.Namespaces = []string{"web"}
+	require.NoError(t, svc.UpdateProject(ctx, shop))
+	assert.False(t, allowed(NamespaceDomain("prod", "shop")))
+	assert.True(t, allowed(NamespaceDomain("prod", "web")))
+
+	site.Namespaces = nil
+	require.NoError(t, svc.UpdateProject(ctx, site))
+	assert.True(t, allowed(NamespaceDomain("prod", "web")))
+	shop.Namespaces = nil
+	require.NoError(t, svc.UpdateProject(ctx, shop))
+	assert.False(t, allowed(NamespaceDomain("prod", "web")))
+	assert.True(t, allowed(ClusterDomain("prod")))
+}
+
+func TestProjectNamespaceGrantStaysInProjectClusters(t *testing.T) {
+	ctx := context.Background()
+	svc := newDBTestService(t)
+
+	role := &Role{Name: "ns-reader", Type: "custom", Permissions: []Permission{
+		{Resource: ResourceNamespace, Action: ActionRead, Scope: ResourceNamespace, Effect: "allow", Priority: 500},
+	}}
+	require.NoError(t, svc.CreateRole(ctx, role))
+	team := &Team{Name: "payments"}
+	require.NoError(t, svc.CreateTeam(ctx, team))
+	require.NoError(t, svc.AddTeamMember(ctx, team.ID, "u1", "member", "admin"))
+	project := &Project{Name: "payments", Clusters: []string{"eu"}, Namespaces: []string{"prod"}}
+	require.NoError(t, svc.CreateProject(ctx, project))
+	require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, role.ID, ResourceProject, project.ID, "admin"))
+
+	ok, err := svc.Authorize(ctx, "u1", NamespaceDomain("eu", "prod"), ResourceNamespace, ActionRead)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Namespace prod of a cluster outside the project is not granted
+	ok, err = svc.Authorize(ctx, "u1", NamespaceDomain("us", "prod"), ResourceNamespace, ActionRead)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestAssignSystemRoleToTeam(t *testing.T) {
+	ctx := context.Background()
+	svc := newDBTestService(t)
+
+	team := &Team{Name: "auditors"}
+	require.NoError(t, svc.CreateTeam(ctx, team))
+	require.NoError(t, svc.AddTeamMember(ctx, team.ID, "u1", "member", "admin"))
+
+	// Seeded roles keep their policies under "role:<name>"
+	require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, "role-viewer", NamespaceDomain("prod", "shop"), "shop", "admin"))
+	project := &Project{Name: "shop", Namespaces: []string{"web"}, Clusters: []string{"prod"}}
+	require.NoError(t, svc.CreateProject(ctx, project))
+	require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, "role-viewer", ResourceProject, project.ID, "admin"))
+
+	for _, ns := range []string{"shop", "web"} {
+		ok, err := svc.Authorize(ctx, "u1", NamespaceDomain("prod", ns), ResourceApplication, ActionRead)
+		require.NoError(t, err)
+		assert.True(t, ok, ns)
+		ok, err = svc.Authorize(ctx, "u1", NamespaceDomain("prod", ns), ResourceApplication, ActionDelete)
+		require.NoError(t, err)
+		assert.False(t, ok, ns)
+	}
+}
+
+func TestAuthorizeAccessClusterDenyOverridesNamespaceAllow(t *testing.T) {
+	ctx := context.Background()
+	svc := newDBTestService(t)
+
+	deployer := &Role{Name: "deployer", Type: "custom", Permissions: []Permission{
+		{Resource: ResourceApplication, Action: ActionUpdate, Scope: ResourceNamespace, Effect: "allow", Priority: 500},
+	}}
+	freeze := &Role{Name: "freeze", Type: "custom", Permissions: []Permission{
+		{Resource: ResourceApplication, Action: ActionUpdate, Scope: ResourceCluster, Effect: "deny", Priority: 900},
+	}}
+	require.NoError(t, svc.CreateRole(ctx, deployer))
+	require.NoError(t, svc.CreateRole(ctx, freeze))
+	team := &Team{Name: "shop"}
+	require.NoError(t, svc.CreateTeam(ctx, team))
+	require.NoError(t, svc.AddTeamMember(ctx, team.ID, "u1", "member", "admin"))
+	for _, clusterID := range []string{"prod", "staging"} {
+		require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, deployer.ID, NamespaceDomain(clusterID, "shop"), "shop", "admin"))
+	}
+	require.NoError(t, svc.AssignRoleToTeam(ctx, team.ID, freeze.ID, ClusterDomain("prod"), "prod", "admin"))
+
+	access := func(clusterID string) bool {
+		ok, err := svc.AuthorizeAccess(ctx, Access{
+			UserID: "u1", Resource: ResourceApplication, Action: ActionUpdate, ClusterID: clusterID, Namespace: "shop",
+		})
+		require.NoError(t, err)
+		return ok
+	}
+	assert.False(t, access("prod"), "the deny on the cluster wins over the allow in its namespace")
+	assert.True(t, access("staging"))
+}
@@ -0,0 +1,137 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+// approvalSweepInterval is how often pending approvals are checked for expiry
+const approvalSweepInterval = time.Minute
+
+// runApprovalExpiry periodically expires actions that waited longer than
+// ApprovalTimeout for a decision
+func (s *Service) runApprovalExpiry() {
+	ticker := time.NewTicker(approvalSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if _, err := s.expireApprovals(context.Background()); err != nil {
+				s.logger.Warn("Failed to expire pending approvals", zap.Error(err))
+			}
+		}
+	}
+}
+
+// expireApprovals moves actions pending approval for longer than
+// ApprovalTimeout to "expired" and returns how many it expired
+func (s *Service) expireApprovals(ctx context.Context) (int, error) {
+	var actions []RemediationAction
+	cutoff := s.now().Add(-s.config.ApprovalTimeout)
+	if err := s.db.WithContext(ctx).
+		Where("status = ? AND created_at < ?", "pending_approval", cutoff).
+		Find(&actions).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range actions {
+		ok, err := s.expireAction(ctx, &actions[i])
+		if err != nil {
+			s.logger.Warn("Failed to expire action", zap.String("action_id", actions[i].ID), zap.Error(err))
+			continue
+		}
+		if ok {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// expireAction marks one pending action expired. The update is conditional on
+// the status so an approval racing the sweeper wins or loses cleanly.
+func (s *Service) expireAction(ctx context.Context, action *RemediationAction) (bool, error) {
+	now := s.now()
+	update := &RemediationAction{
+		Status:      "expired",
+		CompletedAt: &now,
+		Result: map[string]interface{}{
+			"reason":           "approval timeout",
+			"approval_timeout": s.config.ApprovalTimeout.String(),
+		},
+	}
+
+	result := s.db.WithContext(ctx).Model(&RemediationAction{}).
+		Where("id = ? AND status = ?", action.ID, "pending_approval").
+		Select("Status", "CompletedAt", "Result").
+		Updates(update)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	action.Status = update.Status
+	action.CompletedAt = update.CompletedAt
+	action.Result = update.Result
+	s.expired.Add(1)
+	metrics.RemediationActions.WithLabelValues(update.Status).Inc()
+	s.notifyApprovalExpired(ctx, action)
+	return true, nil
+}
+
+// checkApprovable returns an error unless the action can still be approved or
+// rejected, expiring it first if its approval window has already passed
+func (s *Service) checkApprovable(ctx context.Context, action *RemediationAction) error {
+	if action.Status == "pending_approval" && s.now().Sub(action.CreatedAt) > s.config.ApprovalTimeout {
+		if _, err := s.expireAction(ctx, action); err != nil {
+			return fmt.Errorf("failed to expire action: %w", err)
+		}
+		action.Status = "expired"
+	}
+
+	switch action.Status {
+	case "pending_approval":
+		return nil
+	case "expired":
+		return fmt.Errorf("action approval expired")
+	default:
+		return fmt.Errorf("action is not pending approval")
+	}
+}
+
+// ExpiredApprovals returns the number of approvals this instance has expired
+func (s *Service) ExpiredApprovals() int64 {
+	return s.expired.Load()
+}
+
+func (s *Service) notifyApprovalExpired(ctx context.Context, action *RemediationAction) {
+	s.logger.Warn("Approval expired for action",
+		zap.String("action_id", action.ID),
+		zap.String("rule_name", action.RuleName),
+		zap.String("resource", action.ResourceName),
+		zap.Duration("timeout", s.config.ApprovalTimeout),
+	)
+
+	params := map[string]interface{}{
+		"message": "Remediation action for {{ .ResourceName }} ({{ .RuleName }}) expired without approval",
+	}
+	n := actionNotification(EventApprovalExpired, action, params)
+	// "routes" leaves the rest to the notification routes
+	for _, target := range []string{"slack", "webhook", "routes"} {
+		if err := s.notifyTarget(ctx, action, target, n, params); err != nil {
+			s.logger.Warn("Failed to send expiry notification",
+				zap.String("target", target),
+				zap.Error(err),
+			)
+		}
+	}
+}
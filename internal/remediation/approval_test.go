@@ -0,0 +1,118 @@
+package remediation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/glebarez/sqlite"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newApprovalTestService(t *testing.T, clock *fakeClock) *Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&RemediationAction{}))
+
+	return &Service{
+		db:     db,
+		logger: zap.NewNop(),
+		config: &Config{ApprovalTimeout: time.Hour},
+		now:    clock.now,
+	}
+}
+
+func TestApprovalExpiry(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	svc := newApprovalTestService(t, clock)
+	ctx := context.Background()
+
+	require.NoError(t, svc.db.Create(&RemediationAction{
+		ID: "old", Status: "pending_approval", CreatedAt: clock.t,
+	}).Error)
+	require.NoError(t, svc.db.Create(&RemediationAction{
+		ID: "fresh", Status: "pending_approval", CreatedAt: clock.t.Add(50 * time.Minute),
+	}).Error)
+
+	// Nothing is old enough yet
+	clock.t = clock.t.Add(30 * time.Minute)
+	n, err := svc.expireApprovals(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	clock.t = clock.t.Add(45 * time.Minute)
+	n, err = svc.expireApprovals(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(1), svc.ExpiredApprovals())
+
+	old, err := svc.GetAction(ctx, "old")
+	require.NoError(t, err)
+	assert.Equal(t, "expired", old.Status)
+	assert.Equal(t, "approval timeout", old.Result["reason"])
+	assert.NotNil(t, old.CompletedAt)
+
+	fresh, err := svc.GetAction(ctx, "fresh")
+	require.NoError(t, err)
+	assert.Equal(t, "pending_approval", fresh.Status)
+
+	assert.ErrorContains(t, svc.ApproveAction(ctx, "old", "alice"), "expired")
+	assert.ErrorContains(t, svc.RejectAction(ctx, "old", "alice", "too late"), "expired")
+}
+
+func TestApproveExpiresStaleActionBeforeSweep(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	svc := newApprovalTestService(t, clock)
+	ctx := context.Background()
+
+	require.NoError(t, svc.db.Create(&RemediationAction{
+		ID: "stale", Status: "pending_approval", CreatedAt: clock.t,
+	}).Error)
+
+	clock.t = clock.t.Add(2 * time.Hour)
+	assert.ErrorContains(t, svc.ApproveAction(ctx, "stale", "alice"), "expired")
+
+	action, err := svc.GetAction(ctx, "stale")
+	require.NoError(t, err)
+	assert.Equal(t, "expired", action.Status)
+}
+
+func TestFinishedActionsCounted(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	svc := newApprovalTestService(t, clock)
+	require.NoError(t, svc.db.AutoMigrate(&RemediationRule{}))
+	ctx := context.Background()
+	count := func(status string) float64 {
+		return testutil.ToFloat64(metrics.RemediationActions.WithLabelValues(status))
+	}
+	failed, rejected, expired := count("failed"), count("rejected"), count("expired")
+
+	// An action whose rule is gone fails
+	action := &RemediationAction{ID: "orphan", RuleID: "missing", Status: "queued", CreatedAt: clock.t}
+	require.NoError(t, svc.db.Create(action).Error)
+	svc.executeAction(ctx, action)
+	assert.Equal(t, "failed", action.Status)
+
+	require.NoError(t, svc.db.Create(&RemediationAction{
+		ID: "unwanted", Status: "pending_approval", CreatedAt: clock.t,
+	}).Error)
+	require.NoError(t, svc.RejectAction(ctx, "unwanted", "alice", "not now"))
+
+	require.NoError(t, svc.db.Create(&RemediationAction{
+		ID: "forgotten", Status: "pending_approval", CreatedAt: clock.t.Add(-2 * time.Hour),
+	}).Error)
+	n, err := svc.expireApprovals(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, failed+1, count("failed"))
+	assert.Equal(t, rejected+1, count("rejected"))
+	assert.Equal(t, expired+1, count("expired"))
+}
@@ -0,0 +1,172 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// remediationBackup is the backup form of the remediation rules. Rules
+// that belong to a playbook are inside it; Rules holds the others.
+type remediationBackup struct {
+	Rules     []RuleDocument     `json:"rules"`
+	Playbooks []PlaybookDocument `json:"playbooks"`
+}
+
+// ExportBackup returns every rule and playbook for a Krustron backup, in
+// the same document form ExportPlaybook uses
+func (s *Service) ExportBackup(ctx context.Context) ([]byte, error) {
+	var playbooks []Playbook
+	if err := s.db.WithContext(ctx).
+		Preload("Rules", func(db *gorm.DB) *gorm.DB { return db.Order("priority DESC, name") }).
+		Order("name").
+		Find(&playbooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list playbooks: %w", err)
+	}
+	var rules []RemediationRule
+	if err := s.db.WithContext(ctx).Order("priority DESC, name").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	backup := remediationBackup{
+		Rules:     []RuleDocument{},
+		Playbooks: make([]PlaybookDocument, 0, len(playbooks)),
+	}
+	inPlaybook := make(map[string]bool)
+	for i := range playbooks {
+		backup.Playbooks = append(backup.Playbooks, playbookToDocument(&playbooks[i]))
+		for _, r := range playbooks[i].Rules {
+			inPlaybook[r.ID] = true
+		}
+	}
+	for i := range rules {
+		if !inPlaybook[rules[i].ID] {
+			backup.Rules = append(backup.Rules, ruleToDocument(&rules[i]))
+		}
+	}
+	return json.Marshal(backup)
+}
+
+// RestoreBackup writes the rules and playbooks in data through tx, matching
+// them by name. Existing rules get the backed-up definition as a new
+// version; playbooks get the backed-up rule list. It returns the number of
+// rules and playbooks restored. Call AfterRestore once tx commits.
+func (s *Service) RestoreBackup(ctx context.Context, tx *gorm.DB, data []byte) (int, error) {
+	var backup remediationBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return 0, fmt.Errorf("invalid remediation backup: %w", err)
+	}
+
+	tx = tx.WithContext(ctx)
+	restored := 0
+	for i := range backup.Rules {
+		if _, err := s.restoreRule(ctx, tx, &backup.Rules[i]); err != nil {
+			return 0, err
+		}
+		restored++
+	}
+
+	for i := range backup.Playbooks {
+		doc := &backup.Playbooks[i]
+		if doc.Name == "" {
+			return 0, fmt.Errorf("playbook %d: name is required", i+1)
+		}
+		rules := make([]RemediationRule, 0, len(doc.Rules))
+		for j := range doc.Rules {
+			rule, err := s.restoreRule(ctx, tx, &doc.Rules[j])
+			if err != nil {
+				return 0, fmt.Errorf("playbook %s: %w", doc.Name, err)
+			}
+			rules = append(rules, *rule)
+			restored++
+		}
+
+		var playbook Playbook
+		err := tx.Where("name = ?", doc.Name).First(&playbook).Error
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			playbook = Playbook{ID: uuid.New().String(), Name: doc.Name, CreatedAt: time.Now()}
+			playbook.CreatedBy, _ = ctx.Value("user_id").(string)
+		case err != nil:
+			return 0, fmt.Errorf("failed to look up playbook %s: %w", doc.Name, err)
+		}
+		playbook.Description = doc.Description
+		playbook.Version = doc.Version
+		playbook.Tags = doc.Tags
+		playbook.UpdatedAt = time.Now()
+		if err := tx.Omit("Rules").Save(&playbook).Error; err != nil {
+			return 0, fmt.Errorf("failed to restore playbook %s: %w", doc.Name, err)
+		}
+		if err := tx.Model(&playbook).Association("Rules").Replace(rules); err != nil {
+			return 0, fmt.Errorf("failed to restore rules of playbook %s: %w", doc.Name, err)
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// restoreRule creates the rule in doc, or updates the rule with its name
+func (s *Service) restoreRule(ctx context.Context, tx *gorm.DB, doc *RuleDocument) (*RemediationRule, error) {
+	def := documentToRule(doc)
+	if err := validateRule(&def); err != nil {
+		return nil, fmt.Errorf("rule %s: %w", doc.Name, err)
+	}
+
+	var rule RemediationRule
+	err := tx.Where("name = ?", def.Name).First(&rule).Error
+	if err == gorm.ErrRecordNotFound {
+		now := time.Now()
+		def.ID = uuid.New().String()
+		def.CreatedAt = now
+		def.UpdatedAt = now
+		def.CreatedBy, _ = ctx.Value("user_id").(string)
+		if err := tx.Create(&def).Error; err != nil {
+			return nil, fmt.Errorf("failed to restore rule %s: %w", def.Name, err)
+		}
+		if err := s.recordRuleVersion(ctx, tx, &def, "restored from backup"); err != nil {
+			return nil, err
+		}
+		return &def, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up rule %s: %w", def.Name, err)
+	}
+
+	previous := rule
+	restoreDefinition(&rule, &def)
+	diff := diffRules(&previous, &rule)
+	if diff == "no changes" {
+		return &rule, nil
+	}
+	rule.UpdatedAt = time.Now()
+	if err := s.ensureBaselineVersion(tx, &previous); err != nil {
+		return nil, err
+	}
+	if err := tx.Save(&rule).Error; err != nil {
+		return nil, fmt.Errorf("failed to restore rule %s: %w", rule.Name, err)
+	}
+	if err := s.recordRuleVersion(ctx, tx, &rule, "restored from backup ("+diff+")"); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// AfterRestore loads every rule again, so the rules written by
+// RestoreBackup take effect here and, through the broadcaster, on the
+// other replicas
+func (s *Service) AfterRestore(ctx context.Context) error {
+	var rules []RemediationRule
+	if err := s.db.WithContext(ctx).Find(&rules).Error; err != nil {
+		return fmt.Errorf("failed to reload rules: %w", err)
+	}
+	for i := range rules {
+		if err := s.applyRuleChange(ctx, &rules[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
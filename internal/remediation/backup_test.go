@@ -0,0 +1,73 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newPlaybookTestService(t)
+
+	inPlaybook := &RemediationRule{
+		Name:     "restart-crashloop",
+		Enabled:  true,
+		Trigger:  RuleTrigger{Type: "event", EventTypes: []string{"Warning"}},
+		Actions:  []RuleAction{{Type: "restart_pod", Target: "{{ .ResourceName }}", Order: 1}},
+		Cooldown: 5 * time.Minute,
+	}
+	standalone := &RemediationRule{
+		Name:    "nightly-report",
+		Enabled: true,
+		Trigger: RuleTrigger{Type: "schedule", Schedule: "0 2 * * *"},
+		Actions: []RuleAction{{Type: "notify", Order: 1}},
+	}
+	require.NoError(t, src.CreateRule(ctx, inPlaybook))
+	require.NoError(t, src.CreateRule(ctx, standalone))
+	require.NoError(t, src.db.Create(&Playbook{
+		ID: "pb-1", Name: "pods", Version: "1.0.0", Rules: []RemediationRule{*inPlaybook},
+	}).Error)
+
+	data, err := src.ExportBackup(ctx)
+	require.NoError(t, err)
+	var backup remediationBackup
+	require.NoError(t, json.Unmarshal(data, &backup))
+	require.Len(t, backup.Rules, 1)
+	assert.Equal(t, "nightly-report", backup.Rules[0].Name)
+	require.Len(t, backup.Playbooks, 1)
+	require.Len(t, backup.Playbooks[0].Rules, 1)
+
+	dst := newPlaybookTestService(t)
+	restore := func() int {
+		var n int
+		require.NoError(t, dst.db.Transaction(func(tx *gorm.DB) error {
+			n, err = dst.RestoreBackup(ctx, tx, data)
+			return err
+		}))
+		return n
+	}
+	assert.Equal(t, 3, restore())
+	require.NoError(t, dst.AfterRestore(ctx))
+
+	var playbook Playbook
+	require.NoError(t, dst.db.Preload("Rules").First(&playbook, "name = ?", "pods").Error)
+	require.Len(t, playbook.Rules, 1)
+	assert.Equal(t, ruleToDocument(inPlaybook), ruleToDocument(&playbook.Rules[0]))
+	assert.Len(t, dst.rules, 2)
+	assert.Len(t, dst.cronEntries, 1, "the schedule rule is scheduled")
+
+	// Restoring again matches by name instead of duplicating, and an
+	// unchanged rule gets no new version
+	assert.Equal(t, 3, restore())
+	var rules, versions int64
+	require.NoError(t, dst.db.Model(&RemediationRule{}).Count(&rules).Error)
+	require.NoError(t, dst.db.Model(&RuleVersion{}).Count(&versions).Error)
+	assert.EqualValues(t, 2, rules)
+	assert.EqualValues(t, 2, versions)
+}
@@ -0,0 +1,62 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/anubhavg-icpl/krustron/pkg/nats"
+	"go.uber.org/zap"
+)
+
+// RuleChangeSubject is the NATS subject rule changes are broadcast on
+const RuleChangeSubject = "krustron.remediation.rules"
+
+// RuleBus publishes and subscribes to NATS subjects; *nats.Client is one
+type RuleBus interface {
+	Publish(ctx context.Context, subject string, data interface{}) error
+	Subscribe(subject string, handler nats.MessageHandler) (*nats.Subscription, error)
+}
+
+// ruleChange is the message broadcast when a rule is written or deleted
+type ruleChange struct {
+	RuleID string `json:"rule_id"`
+	// Origin is the instance that made the change, which has already
+	// applied it
+	Origin string `json:"origin"`
+}
+
+// natsBroadcaster publishes rule changes on RuleChangeSubject
+type natsBroadcaster struct {
+	bus    RuleBus
+	origin string
+}
+
+func (b *natsBroadcaster) BroadcastRuleChange(ctx context.Context, ruleID string) error {
+	return b.bus.Publish(ctx, RuleChangeSubject, ruleChange{RuleID: ruleID, Origin: b.origin})
+}
+
+// FollowRuleChanges broadcasts this replica's rule changes on bus and
+// reloads the rules other replicas change, so every replica runs the same
+// rules. Unsubscribe the returned subscription to stop following.
+func (s *Service) FollowRuleChanges(bus RuleBus) (*nats.Subscription, error) {
+	sub, err := bus.Subscribe(RuleChangeSubject, func(ctx context.Context, msg *nats.Message) error {
+		var change ruleChange
+		if err := json.Unmarshal(msg.Data, &change); err != nil || change.RuleID == "" {
+			return fmt.Errorf("invalid rule change message: %s", msg.Data)
+		}
+		if change.Origin == s.instanceID {
+			return nil
+		}
+		s.logger.Debug("Reloading rule changed by another replica",
+			zap.String("rule_id", change.RuleID),
+			zap.String("origin", change.Origin),
+		)
+		return s.ReloadRule(ctx, change.RuleID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to follow rule changes: %w", err)
+	}
+	s.SetRuleBroadcaster(&natsBroadcaster{bus: bus, origin: s.instanceID})
+	return sub, nil
+}
@@ -0,0 +1,69 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/nats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loopbackBus delivers every publish to every subscriber, like a NATS
+// subject shared by all replicas
+type loopbackBus struct {
+	handlers []nats.MessageHandler
+}
+
+func (b *loopbackBus) Publish(ctx context.Context, subject string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	for _, h := range b.handlers {
+		if err := h(ctx, &nats.Message{Subject: subject, Data: payload}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *loopbackBus) Subscribe(subject string, handler nats.MessageHandler) (*nats.Subscription, error) {
+	b.handlers = append(b.handlers, handler)
+	return nil, nil
+}
+
+func TestFollowRuleChangesReloadsOtherReplicas(t *testing.T) {
+	ctx := context.Background()
+	a := newVersionTestService(t)
+	a.instanceID = "replica-a"
+	// Replica b shares a's database but not its memory
+	b := newVersionTestService(t)
+	b.db, b.instanceID = a.db, "replica-b"
+
+	bus := &loopbackBus{}
+	_, err := a.FollowRuleChanges(bus)
+	require.NoError(t, err)
+	_, err = b.FollowRuleChanges(bus)
+	require.NoError(t, err)
+
+	rule := &RemediationRule{Name: "restart-crashloop", Enabled: true, Trigger: RuleTrigger{Type: "event"}}
+	require.NoError(t, a.CreateRule(ctx, rule))
+	b.rulesMu.RLock()
+	assert.Contains(t, b.rules, rule.ID)
+	b.rulesMu.RUnlock()
+
+	rule.Enabled = false
+	require.NoError(t, a.UpdateRule(ctx, rule))
+	b.rulesMu.RLock()
+	assert.NotContains(t, b.rules, rule.ID, "disabled rules are dropped")
+	b.rulesMu.RUnlock()
+
+	rule.Enabled = true
+	require.NoError(t, b.UpdateRule(ctx, rule))
+	require.NoError(t, a.DeleteRule(ctx, rule.ID))
+	b.rulesMu.RLock()
+	assert.NotContains(t, b.rules, rule.ID)
+	b.rulesMu.RUnlock()
+}
@@ -0,0 +1,113 @@
+package remediation
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// evictionRecorder answers evictions, refusing a pod's first one the way
+// a PodDisruptionBudget does, and tracks how many run at once
+type evictionRecorder struct {
+	mu          sync.Mutex
+	attempts    map[string]int
+	inFlight    int
+	maxInFlight int
+	refuseFirst map[string]bool
+}
+
+func (e *evictionRecorder) react(action k8stesting.Action) (bool, runtime.Object, error) {
+	if action.GetSubresource() != "eviction" {
+		return false, nil, nil
+	}
+	name := action.(k8stesting.CreateAction).GetObject().(metav1.Object).GetName()
+
+	e.mu.Lock()
+	e.attempts[name]++
+	first := e.attempts[name] == 1
+	e.inFlight++
+	e.maxInFlight = max(e.maxInFlight, e.inFlight)
+	e.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+	e.mu.Lock()
+	e.inFlight--
+	e.mu.Unlock()
+
+	if first && e.refuseFirst[name] {
+		return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+	}
+	return true, nil, nil
+}
+
+func nodePod(name string, mutate func(*corev1.Pod)) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+func TestDrainNodeEvictsThroughDisruptionBudgets(t *testing.T) {
+	controller := true
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		nodePod("web-0", nil),
+		nodePod("web-1", nil),
+		nodePod("cache-0", func(p *corev1.Pod) {
+			p.Spec.Volumes = []corev1.Volume{{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+		}),
+		nodePod("fluentd-x", func(p *corev1.Pod) {
+			p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "fluentd", Controller: &controller}}
+		}),
+		nodePod("etcd-node-1", func(p *corev1.Pod) {
+			p.Annotations = map[string]string{corev1.MirrorPodAnnotationKey: "hash"}
+		}),
+	)
+	evictions := &evictionRecorder{attempts: map[string]int{}, refuseFirst: map[string]bool{"web-0": true}}
+	client.PrependReactor("create", "pods", evictions.react)
+	svc := &Service{logger: zap.NewNop()}
+	ctx := context.Background()
+
+	// One eviction at a time; web-0 is retried after its budget refuses it
+	// and pods using local storage are left without force
+	action := &RemediationAction{ResourceName: "node-1"}
+	require.NoError(t, svc.drainNode(ctx, client, action, map[string]interface{}{"max_concurrent": float64(1)}))
+
+	node, err := client.CoreV1().Nodes().Get(ctx, "node-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, node.Spec.Unschedulable, "the node is cordoned")
+	assert.Equal(t, map[string]int{"web-0": 2, "web-1": 1}, evictions.attempts)
+	assert.Equal(t, 1, evictions.maxInFlight)
+
+	drain := action.Result["drain"].(map[string]interface{})
+	assert.Equal(t, []string{"shop/web-0", "shop/web-1"}, drain["evicted"])
+	assert.Equal(t, map[string]string{
+		"shop/cache-0":     "uses local storage (set force to evict)",
+		"shop/fluentd-x":   "managed by DaemonSet",
+		"shop/etcd-node-1": "mirror pod",
+	}, drain["skipped"])
+	assert.Empty(t, drain["failed"])
+
+	// With force, local storage no longer holds a pod back
+	action = &RemediationAction{ResourceName: "node-1"}
+	require.NoError(t, svc.drainNode(ctx, client, action, map[string]interface{}{"force": true, "max_concurrent": float64(2)}))
+	drain = action.Result["drain"].(map[string]interface{})
+	assert.Equal(t, []string{"shop/cache-0", "shop/web-0", "shop/web-1"}, drain["evicted"])
+	assert.NotContains(t, drain["skipped"], "shop/cache-0")
+	assert.LessOrEqual(t, evictions.maxInFlight, 2)
+}
@@ -0,0 +1,189 @@
+package remediation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+const (
+	// defaultExecTimeout bounds an exec action without a timeout parameter
+	defaultExecTimeout = 30 * time.Second
+
+	// maxExecOutput caps how much stdout/stderr is kept in the action result
+	maxExecOutput = 64 * 1024
+)
+
+// execInPod runs a command in a pod container over SPDY. Parameters: command
+// (list of strings), container (defaults to the first container), timeout
+// (seconds or duration string) and stdin. Output is stored in the action
+// result under "exec".
+func (s *Service) execInPod(ctx context.Context, client kubernetes.Interface, action *RemediationAction, params map[string]interface{}) error {
+	command, err := stringSliceParam(params["command"])
+	if err != nil || len(command) == 0 {
+		return fmt.Errorf("exec requires a command list")
+	}
+
+	timeout := durationParam(params, "timeout", defaultExecTimeout)
+
+	s.clientsMu.RLock()
+	restConfig := s.restConfigs[action.ClusterID]
+	s.clientsMu.RUnlock()
+	if restConfig == nil {
+		return fmt.Errorf("no rest config registered for cluster %s, cannot exec", action.ClusterID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pod, err := client.CoreV1().Pods(action.Namespace).Get(ctx, action.ResourceName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	container, _ := params["container"].(string)
+	if container == "" {
+		if len(pod.Spec.Containers) == 0 {
+			return fmt.Errorf("pod %s has no containers", pod.Name)
+		}
+		container = pod.Spec.Containers[0].Name
+	} else if !hasContainer(pod, container) {
+		return fmt.Errorf("pod %s has no container %q", pod.Name, container)
+	}
+
+	stdin, _ := params["stdin"].(string)
+
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     stdin != "",
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(restConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	streamOpts := remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}
+	if stdin != "" {
+		streamOpts.Stdin = strings.NewReader(stdin)
+	}
+
+	execErr := executor.StreamWithContext(ctx, streamOpts)
+
+	exitCode := 0
+	var codeErr utilexec.CodeExitError
+	if errors.As(execErr, &codeErr) {
+		exitCode = codeErr.ExitStatus()
+	}
+
+	if action.Result == nil {
+		action.Result = make(map[string]interface{})
+	}
+	action.Result["exec"] = map[string]interface{}{
+		"container": container,
+		"command":   command,
+		"stdout":    truncateOutput(stdout.String()),
+		"stderr":    truncateOutput(stderr.String()),
+		"exit_code": exitCode,
+	}
+
+	s.logger.Info("Executed command in pod",
+		zap.String("pod", pod.Name),
+		zap.String("container", container),
+		zap.Strings("command", command),
+		zap.Int("exit_code", exitCode),
+	)
+
+	switch {
+	case execErr == nil:
+		return nil
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("exec timed out after %s", timeout)
+	case exitCode == 126 || exitCode == 127 || isMissingExecutable(execErr, stderr.String()):
+		return fmt.Errorf("command %q is not available in container %s", command[0], container)
+	default:
+		return fmt.Errorf("exec failed: %w", execErr)
+	}
+}
+
+// durationParam reads a duration given as seconds or a duration string
+func durationParam(params map[string]interface{}, key string, def time.Duration) time.Duration {
+	switch v := params[key].(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second))
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// stringSliceParam accepts a []string or a decoded JSON array of strings
+func stringSliceParam(v interface{}) ([]string, error) {
+	switch list := v.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a list of strings")
+			}
+			out = append(out, str)
+		}
+		return out, nil
+	case string:
+		return []string{list}, nil
+	default:
+		return nil, fmt.Errorf("expected a list of strings")
+	}
+}
+
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isMissingExecutable recognises the runtime errors for a command (typically
+// a shell) that does not exist in the container image
+func isMissingExecutable(err error, stderr string) bool {
+	for _, msg := range []string{err.Error(), stderr} {
+		if strings.Contains(msg, "executable file not found") || strings.Contains(msg, "no such file or directory") {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateOutput(out string) string {
+	if len(out) <= maxExecOutput {
+		return out
+	}
+	return out[:maxExecOutput] + "\n... (truncated)"
+}
@@ -0,0 +1,356 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/prometheus"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// metricTickInterval is how often the metric scheduler checks which rules are due
+const metricTickInterval = 15 * time.Second
+
+// defaultResourceLabels are tried in order to find the resource a series is
+// about, mapped to the resource type written on the synthesized event
+var defaultResourceLabels = []struct {
+	label        string
+	resourceType string
+}{
+	{"pod", "pod"},
+	{"persistentvolumeclaim", "pvc"},
+	{"deployment", "deployment"},
+	{"statefulset", "statefulset"},
+	{"node", "node"},
+}
+
+// newPrometheusClient creates a client for the Prometheus metric rules
+// query
+func newPrometheusClient(cfg *PrometheusConfig) *prometheus.Client {
+	return prometheus.NewClient(prometheus.Config{
+		URL:         cfg.URL,
+		BearerToken: cfg.BearerToken,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		Timeout:     cfg.Timeout,
+	})
+}
+
+// metricTriggerState tracks when each rule was last evaluated, for
+// instant queries since when each series has been above threshold, and
+// the rules this replica holds the claim on
+type metricTriggerState struct {
+	mu            sync.Mutex
+	lastEvaluated map[string]time.Time
+	breachedSince map[string]map[string]time.Time // rule ID -> series key -> first breach
+	claims        map[string]cache.Lock
+}
+
+func newMetricTriggerState() *metricTriggerState {
+	return &metricTriggerState{
+		lastEvaluated: make(map[string]time.Time),
+		breachedSince: make(map[string]map[string]time.Time),
+		claims:        make(map[string]cache.Lock),
+	}
+}
+
+// claim returns the lock this replica holds on the rule, if any
+func (m *metricTriggerState) claim(ruleID string) (cache.Lock, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.claims[ruleID]
+	return lock, ok
+}
+
+// setClaim records that this replica holds the lock on the rule
+func (m *metricTriggerState) setClaim(ruleID string, lock cache.Lock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims[ruleID] = lock
+}
+
+// release drops the rule's claim along with its breach tracking, which is
+// stale once another replica has been evaluating the rule
+func (m *metricTriggerState) release(ruleID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claims, ruleID)
+	delete(m.breachedSince, ruleID)
+}
+
+// due reports whether the rule should be evaluated now and marks it evaluated
+func (m *metricTriggerState) due(ruleID string, interval time.Duration, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.lastEvaluated[ruleID]; ok && now.Sub(last) < interval {
+		return false
+	}
+	m.lastEvaluated[ruleID] = now
+	return true
+}
+
+// observe records whether a series is above threshold and returns how long
+// it has been continuously breaching (zero if it isn't)
+func (m *metricTriggerState) observe(ruleID, seriesKey string, breached bool, now time.Time) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series, ok := m.breachedSince[ruleID]
+	if !ok {
+		series = make(map[string]time.Time)
+		m.breachedSince[ruleID] = series
+	}
+
+	if !breached {
+		delete(series, seriesKey)
+		return 0
+	}
+
+	since, ok := series[seriesKey]
+	if !ok {
+		series[seriesKey] = now
+		return 0
+	}
+	return now.Sub(since)
+}
+
+// forget drops tracking for series no longer returned by a rule's query
+func (m *metricTriggerState) forget(ruleID string, seen map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.breachedSince[ruleID] {
+		if !seen[key] {
+			delete(m.breachedSince[ruleID], key)
+		}
+	}
+}
+
+// runMetricTriggers evaluates metric-triggered rules on their intervals
+func (s *Service) runMetricTriggers() {
+	ticker := time.NewTicker(metricTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			for _, rule := range s.metricRules() {
+				interval := rule.Trigger.Interval
+				if interval == 0 {
+					interval = s.config.Prometheus.EvaluationInterval
+				}
+				if !s.metricState.due(rule.ID, interval, now) {
+					continue
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				if !s.claimMetricRule(ctx, rule, interval) {
+					cancel()
+					continue
+				}
+				if err := s.evaluateMetricRule(ctx, rule, now); err != nil {
+					s.logger.Warn("Metric trigger evaluation failed",
+						zap.String("rule", rule.Name),
+						zap.Error(err),
+					)
+				}
+				cancel()
+			}
+		}
+	}
+}
+
+// claimMetricRule reports whether this replica evaluates the rule. The
+// first replica to claim a rule keeps it, renewing the claim on every
+// evaluation, so a single replica tracks how long each series has been
+// breaching and raises each breach once. Another replica takes over only
+// when the claim lapses. When Redis can't be reached the rule is skipped.
+func (s *Service) claimMetricRule(ctx context.Context, rule *RemediationRule, interval time.Duration) bool {
+	if s.locks == nil {
+		return true
+	}
+
+	// Outlive a missed tick or two before another replica takes over
+	ttl := 2*interval + metricTickInterval
+	if lock, ok := s.metricState.claim(rule.ID); ok {
+		if err := lock.Refresh(ctx, ttl); err == nil {
+			return true
+		}
+		s.metricState.release(rule.ID)
+	}
+
+	lock, ok, err := s.locks.AcquireLock(ctx, "remediation:metric:"+rule.ID, ttl)
+	if err != nil {
+		s.logger.Warn("Failed to lock metric rule, skipping evaluation",
+			zap.String("rule", rule.Name),
+			zap.Error(err),
+		)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	s.metricState.setClaim(rule.ID, lock)
+	return true
+}
+
+// metricRules returns the enabled rules with a metric trigger
+func (s *Service) metricRules() []*RemediationRule {
+	s.rulesMu.RLock()
+	defer s.rulesMu.RUnlock()
+
+	var rules []*RemediationRule
+	for _, rule := range s.rules {
+		if rule.Enabled && rule.Trigger.Type == "metric" && rule.Trigger.Query != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// evaluateMetricRule runs the rule's query and raises an event for every
+// series that stayed above Threshold for Duration. Instant queries track the
+// breach across evaluations; range queries look back over Duration at once
+// and require every sample to breach.
+func (s *Service) evaluateMetricRule(ctx context.Context, rule *RemediationRule, now time.Time) error {
+	trigger := rule.Trigger
+
+	var series []prometheus.Series
+	var err error
+	if trigger.QueryType == "range" {
+		window := trigger.Duration
+		if window == 0 {
+			window = 5 * time.Minute
+		}
+		step := trigger.Step
+		if step == 0 {
+			step = 30 * time.Second
+		}
+		series, err = s.prometheus.QueryRange(ctx, trigger.Query, now.Add(-window), now, step)
+	} else {
+		series, err = s.prometheus.Query(ctx, trigger.Query, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(series))
+	for _, ps := range series {
+		if len(ps.Values) == 0 {
+			continue
+		}
+
+		var fire bool
+		if trigger.QueryType == "range" {
+			fire = true
+			for _, v := range ps.Values {
+				if v <= trigger.Threshold {
+					fire = false
+					break
+				}
+			}
+		} else {
+			key := seriesKey(ps.Labels)
+			seen[key] = true
+			breached := ps.Values[len(ps.Values)-1] > trigger.Threshold
+			fire = breached && s.metricState.observe(rule.ID, key, true, now) >= trigger.Duration
+			if !breached {
+				s.metricState.observe(rule.ID, key, false, now)
+			}
+		}
+
+		if !fire {
+			continue
+		}
+
+		event := s.metricEvent(rule, ps, now)
+		if err := s.ProcessEvent(ctx, event); err != nil {
+			s.logger.Warn("Failed to process metric event", zap.String("rule", rule.Name), zap.Error(err))
+		}
+	}
+
+	if trigger.QueryType != "range" {
+		s.metricState.forget(rule.ID, seen)
+	}
+
+	return nil
+}
+
+// metricEvent builds the RemediationEvent for a breaching series, extracting
+// cluster, namespace and resource from its labels
+func (s *Service) metricEvent(rule *RemediationRule, ps prometheus.Series, now time.Time) *RemediationEvent {
+	value := ps.Values[len(ps.Values)-1]
+	event := &RemediationEvent{
+		ID:        uuid.New().String(),
+		Type:      "Metric",
+		Source:    "prometheus",
+		Reason:    "MetricThresholdExceeded",
+		Message:   fmt.Sprintf("%s = %g exceeded threshold %g", rule.Trigger.Query, value, rule.Trigger.Threshold),
+		Severity:  "warning",
+		Labels:    ps.Labels,
+		Timestamp: now,
+		Data: map[string]interface{}{
+			"rule_id":   rule.ID,
+			"query":     rule.Trigger.Query,
+			"value":     value,
+			"threshold": rule.Trigger.Threshold,
+		},
+	}
+
+	mapping := rule.Trigger.LabelMapping
+	label := func(field, fallback string) string {
+		if name, ok := mapping[field]; ok {
+			return ps.Labels[name]
+		}
+		return ps.Labels[fallback]
+	}
+
+	event.ClusterID = label("cluster_id", "cluster")
+	if event.ClusterID == "" && len(rule.Scope.Clusters) == 1 {
+		event.ClusterID = rule.Scope.Clusters[0]
+	}
+	event.Namespace = label("namespace", "namespace")
+
+	if name, ok := mapping["resource_name"]; ok {
+		event.ResourceName = ps.Labels[name]
+		event.ResourceType = mapping["resource_type"]
+	} else {
+		for _, candidate := range defaultResourceLabels {
+			if v := ps.Labels[candidate.label]; v != "" {
+				event.ResourceName = v
+				event.ResourceType = candidate.resourceType
+				break
+			}
+		}
+	}
+
+	return event
+}
+
+// seriesKey identifies a series by its sorted label set
+func seriesKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
@@ -0,0 +1,195 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakePrometheus answers instant queries with a vector and range queries
+// with a matrix of samples, for one pod series
+type fakePrometheus struct {
+	mu      sync.Mutex
+	value   string
+	samples []string
+	queries []url.Values
+	paths   []string
+}
+
+func (p *fakePrometheus) set(value string, samples ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.value, p.samples = value, samples
+}
+
+func (p *fakePrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paths = append(p.paths, r.URL.Path)
+	p.queries = append(p.queries, r.URL.Query())
+
+	metric := map[string]string{"namespace": "shop", "pod": "web-0"}
+	result := map[string]interface{}{"metric": metric}
+	resultType := "vector"
+	if r.URL.Path == "/api/v1/query_range" {
+		resultType = "matrix"
+		values := make([][]interface{}, len(p.samples))
+		for i, v := range p.samples {
+			values[i] = []interface{}{float64(i), v}
+		}
+		result["values"] = values
+	} else {
+		result["value"] = []interface{}{float64(0), p.value}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": resultType, "result": []interface{}{result}},
+	})
+}
+
+func newMetricTestService(t *testing.T, prom *fakePrometheus, db *gorm.DB) *Service {
+	t.Helper()
+	server := httptest.NewServer(prom)
+	t.Cleanup(server.Close)
+
+	svc, err := NewService(db, zap.NewNop(), &Config{DisableWorkers: true})
+	require.NoError(t, err)
+	svc.prometheus = newPrometheusClient(&PrometheusConfig{URL: server.URL})
+	return svc
+}
+
+func newMetricTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	return db
+}
+
+func metricRule(trigger RuleTrigger) *RemediationRule {
+	trigger.Type = "metric"
+	return &RemediationRule{
+		Name:     "hot-pod",
+		Enabled:  true,
+		Trigger:  trigger,
+		Actions:  []RuleAction{{Type: "restart_pod", Order: 1, OnFailure: "abort"}},
+		Cooldown: time.Nanosecond,
+	}
+}
+
+func countActions(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+	var n int64
+	require.NoError(t, db.Model(&RemediationAction{}).Count(&n).Error)
+	return n
+}
+
+func TestInstantMetricRuleFiresAfterBreachingForDuration(t *testing.T) {
+	ctx := context.Background()
+	prom := &fakePrometheus{}
+	db := newMetricTestDB(t)
+	svc := newMetricTestService(t, prom, db)
+	rule := metricRule(RuleTrigger{Query: "cpu", Threshold: 0.9, Duration: 2 * time.Minute})
+	require.NoError(t, svc.CreateRule(ctx, rule))
+
+	start := time.Now()
+	prom.set("0.95")
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, start))
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, start.Add(time.Minute)))
+	assert.Zero(t, countActions(t, db), "not yet breaching for the full duration")
+
+	// Dropping below the threshold restarts the clock
+	prom.set("0.5")
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, start.Add(90*time.Second)))
+	prom.set("0.95")
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, start.Add(2*time.Minute)))
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, start.Add(3*time.Minute)))
+	assert.Zero(t, countActions(t, db))
+
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, start.Add(4*time.Minute)))
+	assert.Equal(t, int64(1), countActions(t, db))
+
+	var action RemediationAction
+	require.NoError(t, db.First(&action).Error)
+	assert.Equal(t, "shop", action.Namespace)
+	assert.Equal(t, "web-0", action.ResourceName)
+	assert.Equal(t, "pod", action.ResourceType)
+}
+
+func TestRangeMetricRuleRequiresEverySampleToBreach(t *testing.T) {
+	ctx := context.Background()
+	prom := &fakePrometheus{}
+	db := newMetricTestDB(t)
+	svc := newMetricTestService(t, prom, db)
+	rule := metricRule(RuleTrigger{
+		Query: "cpu", Threshold: 0.9, Duration: 10 * time.Minute, QueryType: "range", Step: time.Minute,
+	})
+	require.NoError(t, svc.CreateRule(ctx, rule))
+
+	now := time.Now()
+	prom.set("", "0.95", "0.5", "0.97")
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, now))
+	assert.Zero(t, countActions(t, db), "one sample under the threshold")
+
+	prom.set("", "0.95", "0.96", "0.97")
+	require.NoError(t, svc.evaluateMetricRule(ctx, rule, now))
+	assert.Equal(t, int64(1), countActions(t, db))
+
+	// The query looks back over Duration at the rule's step
+	require.Len(t, prom.paths, 2)
+	assert.Equal(t, "/api/v1/query_range", prom.paths[1])
+	query := prom.queries[1]
+	assert.Equal(t, strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10), query.Get("start"))
+	assert.Equal(t, strconv.FormatInt(now.Unix(), 10), query.Get("end"))
+	assert.Equal(t, "60", query.Get("step"))
+}
+
+func TestMetricRuleClaimedByOneReplica(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redisCache, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	t.Cleanup(func() { redisCache.Close() })
+
+	replica := func() *Service {
+		svc := &Service{logger: zap.NewNop(), metricState: newMetricTriggerState()}
+		svc.SetLocks(redisCache)
+		return svc
+	}
+	first, second := replica(), replica()
+	rule := &RemediationRule{ID: "hot-pod", Name: "hot-pod"}
+
+	assert.True(t, first.claimMetricRule(ctx, rule, time.Minute))
+	assert.False(t, second.claimMetricRule(ctx, rule, time.Minute))
+
+	// The holder keeps the rule by renewing its claim on every evaluation
+	mr.FastForward(2 * time.Minute)
+	assert.True(t, first.claimMetricRule(ctx, rule, time.Minute))
+	mr.FastForward(2 * time.Minute)
+	assert.False(t, second.claimMetricRule(ctx, rule, time.Minute))
+
+	// Once the holder stops renewing, another replica takes over and the
+	// old holder drops its breach tracking
+	first.metricState.observe(rule.ID, "series", true, time.Now())
+	mr.FastForward(5 * time.Minute)
+	assert.True(t, second.claimMetricRule(ctx, rule, time.Minute))
+	assert.False(t, first.claimMetricRule(ctx, rule, time.Minute))
+	assert.Empty(t, first.metricState.breachedSince[rule.ID])
+}
@@ -0,0 +1,135 @@
+package remediation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"go.uber.org/zap"
+)
+
+const (
+	// notificationTimeout bounds each outgoing notification request
+	notificationTimeout = 10 * time.Second
+)
+
+// Event types of remediation notifications, for notification routes
+const (
+	EventActionNotification = "remediation.notification"
+	EventApprovalExpired    = "remediation.approval_expired"
+)
+
+// SetNotifier routes notify actions whose target isn't slack, pagerduty
+// or webhook through d, e.g. to email or Teams channels. Optional; without
+// it they are only logged.
+func (s *Service) SetNotifier(d notify.Dispatcher) { s.notifier = d }
+
+// sendNotification delivers a notify action to its target. The message
+// parameter is a template rendered against the action. Unconfigured targets
+// are skipped; delivery errors are returned so OnFailure applies.
+func (s *Service) sendNotification(ctx context.Context, action *RemediationAction, target string, params map[string]interface{}) error {
+	return s.notifyTarget(ctx, action, target, actionNotification(EventActionNotification, action, params), params)
+}
+
+// notifyTarget sends n to one target. slack, pagerduty and webhook use the
+// service's own settings; other targets go through the notifier's routes.
+func (s *Service) notifyTarget(ctx context.Context, action *RemediationAction, target string, n *notify.Notification, params map[string]interface{}) error {
+	switch target {
+	case "slack":
+		if !s.config.EnableSlack || s.config.SlackWebhook == "" {
+			s.logger.Debug("Slack notifications disabled, skipping", zap.String("action_id", action.ID))
+			return nil
+		}
+		channel, _ := params["channel"].(string)
+		return notify.NewSlack(s.httpClient, s.config.SlackWebhook, channel).Send(ctx, n)
+	case "pagerduty":
+		if s.config.PagerDutyRoutingKey == "" {
+			s.logger.Debug("PagerDuty routing key not configured, skipping", zap.String("action_id", action.ID))
+			return nil
+		}
+		return notify.NewPagerDuty(s.httpClient, s.config.PagerDutyRoutingKey, s.config.PagerDutyEventsURL).Send(ctx, n)
+	case "webhook":
+		withMessage := make(map[string]interface{}, len(params)+1)
+		for k, v := range params {
+			withMessage[k] = v
+		}
+		withMessage["message"] = n.Message
+		return s.callWebhook(ctx, action, withMessage)
+	}
+
+	if s.notifier == nil {
+		s.logger.Info("Notification sent",
+			zap.String("target", target),
+			zap.String("message", n.Message),
+		)
+		return nil
+	}
+	err := s.notifier.Notify(ctx, n)
+	if errors.Is(err, notify.ErrNotRouted) {
+		s.logger.Debug("No notification route for remediation event",
+			zap.String("event", n.Event),
+			zap.String("action_id", action.ID),
+		)
+		return nil
+	}
+	return err
+}
+
+// callWebhook POSTs the action to a webhook, signing the body with
+// Config.WebhookSecret when one is configured
+func (s *Service) callWebhook(ctx context.Context, action *RemediationAction, params map[string]interface{}) error {
+	if !s.config.EnableWebhooks {
+		return nil
+	}
+
+	url, _ := params["url"].(string)
+	if url == "" {
+		url = s.config.WebhookURL
+	}
+
+	if url == "" {
+		return nil
+	}
+
+	n := &notify.Notification{Event: EventActionNotification, Payload: map[string]interface{}{
+		"action_id":     action.ID,
+		"rule_name":     action.RuleName,
+		"resource_type": action.ResourceType,
+		"resource_name": action.ResourceName,
+		"namespace":     action.Namespace,
+		"cluster_id":    action.ClusterID,
+		"parameters":    params,
+		"timestamp":     time.Now(),
+	}}
+	return notify.NewWebhook(s.httpClient, url, s.config.WebhookSecret).Send(ctx, n)
+}
+
+// actionNotification describes an action for notification channels. The
+// message parameter is a template rendered against the action; severity
+// defaults to warning. The dedup key is derived from rule and resource so
+// repeated firings update one PagerDuty incident.
+func actionNotification(event string, action *RemediationAction, params map[string]interface{}) *notify.Notification {
+	message, _ := params["message"].(string)
+	severity, _ := params["severity"].(string)
+	if severity == "" {
+		severity = notify.SeverityWarning
+	}
+	return &notify.Notification{
+		Event:    event,
+		Severity: severity,
+		Title:    fmt.Sprintf("Remediation rule %s", action.RuleName),
+		Message:  renderTemplate(message, action),
+		Fields: []notify.Field{
+			{Name: "Rule", Value: action.RuleName},
+			{Name: "Cluster", Value: action.ClusterID},
+			{Name: "Namespace", Value: action.Namespace},
+			{Name: "Resource", Value: fmt.Sprintf("%s/%s", action.ResourceType, action.ResourceName)},
+			{Name: "Action", Value: fmt.Sprintf("%s (%s)", action.ID, action.ActionType)},
+		},
+		Source:    fmt.Sprintf("%s/%s", action.ClusterID, action.ResourceName),
+		DedupKey:  fmt.Sprintf("krustron:%s:%s/%s/%s/%s", action.RuleID, action.ClusterID, action.Namespace, action.ResourceType, action.ResourceName),
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,51 @@
+package remediation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// dispatcherFunc adapts a function to notify.Dispatcher
+type dispatcherFunc func(ctx context.Context, n *notify.Notification) error
+
+func (f dispatcherFunc) Notify(ctx context.Context, n *notify.Notification) error { return f(ctx, n) }
+
+func TestNotifyActionRoutedThroughNotifier(t *testing.T) {
+	ctx := context.Background()
+	svc := &Service{logger: zap.NewNop(), config: &Config{}}
+	action := &RemediationAction{
+		ID: "a1", RuleID: "r1", RuleName: "oom-restart", ActionType: "notify",
+		ClusterID: "prod", Namespace: "payments", ResourceType: "pod", ResourceName: "api-0",
+	}
+	params := map[string]interface{}{"message": "{{ .ResourceName }} was OOM killed", "severity": "critical"}
+
+	// Without a notifier the notification is only logged
+	require.NoError(t, svc.sendNotification(ctx, action, "email", params))
+
+	var sent []*notify.Notification
+	svc.SetNotifier(dispatcherFunc(func(ctx context.Context, n *notify.Notification) error {
+		sent = append(sent, n)
+		return nil
+	}))
+	require.NoError(t, svc.sendNotification(ctx, action, "email", params))
+	require.Len(t, sent, 1)
+	assert.Equal(t, EventActionNotification, sent[0].Event)
+	assert.Equal(t, notify.SeverityCritical, sent[0].Severity)
+	assert.Equal(t, "api-0 was OOM killed", sent[0].Message)
+	assert.Equal(t, "krustron:r1:prod/payments/pod/api-0", sent[0].DedupKey)
+
+	// Slack without a webhook is skipped rather than routed
+	require.NoError(t, svc.sendNotification(ctx, action, "slack", params))
+	assert.Len(t, sent, 1)
+
+	// An event no route matches isn't a failure
+	svc.SetNotifier(dispatcherFunc(func(ctx context.Context, n *notify.Notification) error {
+		return notify.ErrNotRouted
+	}))
+	assert.NoError(t, svc.sendNotification(ctx, action, "email", params))
+}
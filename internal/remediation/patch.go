@@ -0,0 +1,301 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// patchResource applies a JSON patch to the action's resource. The rule
+// action target ("deployment", "statefulset", "pvc") selects the kind; for
+// pod events the owning Deployment or StatefulSet is patched. Parameters:
+// path (JSON pointer), operation ("replace" or "multiply"), value for replace,
+// multiplier for multiply, and optional max_value, a quantity cap on either.
+func (s *Service) patchResource(ctx context.Context, client kubernetes.Interface, action *RemediationAction, target string, params map[string]interface{}) error {
+	path, _ := params["path"].(string)
+	if path == "" {
+		return fmt.Errorf("patch requires a path")
+	}
+
+	kind := normalizeKind(target)
+	if kind == "" {
+		kind = normalizeKind(action.ResourceType)
+	}
+
+	name, err := resolvePatchTarget(ctx, client, action, kind)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	operation, _ := params["operation"].(string)
+	switch operation {
+	case "", "replace":
+		value = params["value"]
+		if str, ok := value.(string); ok {
+			if value, err = capQuantity(str, params); err != nil {
+				return err
+			}
+		}
+	case "multiply":
+		current, err := getPatchTarget(ctx, client, kind, action.Namespace, name)
+		if err != nil {
+			return err
+		}
+
+		next, err := multiplyQuantityAt(current, path, params)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			s.logger.Info("Resource already at max_value, skipping patch",
+				zap.String("kind", kind),
+				zap.String("resource", name),
+				zap.String("path", path),
+			)
+			return nil
+		}
+		value = next
+	default:
+		return fmt.Errorf("unsupported patch operation: %s", operation)
+	}
+
+	if kind == "pvc" {
+		if err := checkVolumeExpansion(ctx, client, action.Namespace, name); err != nil {
+			return err
+		}
+	}
+
+	patchBytes, err := json.Marshal([]map[string]interface{}{
+		{"op": "replace", "path": path, "value": value},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch: %w", err)
+	}
+
+	switch kind {
+	case "deployment":
+		_, err = client.AppsV1().Deployments(action.Namespace).Patch(ctx, name, types.JSONPatchType, patchBytes, metav1.PatchOptions{})
+	case "statefulset":
+		_, err = client.AppsV1().StatefulSets(action.Namespace).Patch(ctx, name, types.JSONPatchType, patchBytes, metav1.PatchOptions{})
+	case "pvc":
+		_, err = client.CoreV1().PersistentVolumeClaims(action.Namespace).Patch(ctx, name, types.JSONPatchType, patchBytes, metav1.PatchOptions{})
+	default:
+		return fmt.Errorf("unsupported resource type for patch: %s", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to patch %s %s: %w", kind, name, err)
+	}
+
+	s.logger.Info("Resource patched",
+		zap.String("kind", kind),
+		zap.String("resource", name),
+		zap.String("path", path),
+		zap.Any("value", value),
+	)
+	return nil
+}
+
+// normalizeKind maps the spellings used in rules and events to one kind name
+func normalizeKind(kind string) string {
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments":
+		return "deployment"
+	case "statefulset", "statefulsets":
+		return "statefulset"
+	case "pvc", "persistentvolumeclaim", "persistentvolumeclaims":
+		return "pvc"
+	case "pod", "pods":
+		return "pod"
+	default:
+		return ""
+	}
+}
+
+// resolvePatchTarget returns the name of the kind object to patch. When the
+// action is about a pod, it follows owner references up to the workload.
+func resolvePatchTarget(ctx context.Context, client kubernetes.Interface, action *RemediationAction, kind string) (string, error) {
+	if normalizeKind(action.ResourceType) != "pod" || kind == "pod" {
+		return action.ResourceName, nil
+	}
+
+	pod, err := client.CoreV1().Pods(action.Namespace).Get(ctx, action.ResourceName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	for _, ref := range pod.OwnerReferences {
+		switch {
+		case kind == "statefulset" && ref.Kind == "StatefulSet":
+			return ref.Name, nil
+		case kind == "deployment" && ref.Kind == "ReplicaSet":
+			rs, err := client.AppsV1().ReplicaSets(action.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return "", fmt.Errorf("failed to get replicaset: %w", err)
+			}
+			for _, rsRef := range rs.OwnerReferences {
+				if rsRef.Kind == "Deployment" {
+					return rsRef.Name, nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("pod %s is not owned by a %s", action.ResourceName, kind)
+}
+
+// getPatchTarget fetches the object through the typed client and returns it
+// as generic JSON so a path can be read from it
+func getPatchTarget(ctx context.Context, client kubernetes.Interface, kind, namespace, name string) (map[string]interface{}, error) {
+	var obj interface{}
+	var err error
+	switch kind {
+	case "deployment":
+		obj, err = client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "statefulset":
+		obj, err = client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	case "pvc":
+		obj, err = client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	default:
+		return nil, fmt.Errorf("unsupported resource type for patch: %s", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s: %w", kind, name, err)
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// multiplyQuantityAt reads the quantity at path, multiplies it by
+// params["multiplier"] and caps it at params["max_value"]. It returns "" when
+// the current value is already at or above the cap.
+func multiplyQuantityAt(obj map[string]interface{}, path string, params map[string]interface{}) (string, error) {
+	multiplier, ok := params["multiplier"].(float64)
+	if !ok || multiplier <= 0 {
+		return "", fmt.Errorf("multiply requires a positive multiplier")
+	}
+
+	raw, err := lookupPointer(obj, path)
+	if err != nil {
+		return "", err
+	}
+	str, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("value at %s is not a quantity", path)
+	}
+	current, err := resource.ParseQuantity(str)
+	if err != nil {
+		return "", fmt.Errorf("invalid quantity at %s: %w", path, err)
+	}
+
+	next := multiplyQuantity(current, multiplier)
+
+	if maxStr, ok := params["max_value"].(string); ok && maxStr != "" {
+		maxValue, err := resource.ParseQuantity(maxStr)
+		if err != nil {
+			return "", fmt.Errorf("invalid max_value %q: %w", maxStr, err)
+		}
+		if current.Cmp(maxValue) >= 0 {
+			return "", nil
+		}
+		if next.Cmp(maxValue) > 0 {
+			next = maxValue
+		}
+	}
+
+	return next.String(), nil
+}
+
+// capQuantity caps value, a quantity, at params["max_value"] if set
+func capQuantity(value string, params map[string]interface{}) (string, error) {
+	maxStr, ok := params["max_value"].(string)
+	if !ok || maxStr == "" {
+		return value, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid quantity %q: %w", value, err)
+	}
+	maxValue, err := resource.ParseQuantity(maxStr)
+	if err != nil {
+		return "", fmt.Errorf("invalid max_value %q: %w", maxStr, err)
+	}
+	if q.Cmp(maxValue) > 0 {
+		return maxValue.String(), nil
+	}
+	return value, nil
+}
+
+// multiplyQuantity scales q, rounding up and keeping its format
+func multiplyQuantity(q resource.Quantity, multiplier float64) resource.Quantity {
+	if q.MilliValue()%1000 == 0 {
+		return *resource.NewQuantity(int64(math.Ceil(float64(q.Value())*multiplier)), q.Format)
+	}
+	return *resource.NewMilliQuantity(int64(math.Ceil(float64(q.MilliValue())*multiplier)), q.Format)
+}
+
+// lookupPointer resolves a JSON pointer (RFC 6901) against decoded JSON
+func lookupPointer(obj interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	current := obj
+	for _, token := range strings.Split(path[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %s not found", path)
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("path %s not found", path)
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("path %s not found", path)
+		}
+	}
+	return current, nil
+}
+
+// checkVolumeExpansion verifies the PVC's storage class allows resizing
+func checkVolumeExpansion(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pvc: %w", err)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return fmt.Errorf("pvc %s has no storage class, cannot expand", name)
+	}
+
+	sc, err := client.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get storage class: %w", err)
+	}
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return fmt.Errorf("storage class %s does not allow volume expansion", sc.Name)
+	}
+	return nil
+}
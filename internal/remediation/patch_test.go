@@ -0,0 +1,208 @@
+package remediation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const memoryLimitPath = "/spec/template/spec/containers/0/resources/limits/memory"
+
+func deploymentWithMemoryLimit(limit string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: "api",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse(limit),
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func TestPatchMultiplyDeploymentMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		maxValue string
+		want     string
+	}{
+		{name: "scaled", current: "512Mi", want: "768Mi"},
+		{name: "under cap", current: "1Gi", maxValue: "4Gi", want: "1536Mi"},
+		{name: "capped", current: "3Gi", maxValue: "4Gi", want: "4Gi"},
+		{name: "already at cap", current: "4Gi", maxValue: "4Gi", want: "4Gi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(deploymentWithMemoryLimit(tt.current))
+			svc := &Service{logger: zap.NewNop()}
+
+			params := map[string]interface{}{
+				"path":       memoryLimitPath,
+				"operation":  "multiply",
+				"multiplier": 1.5,
+			}
+			if tt.maxValue != "" {
+				params["max_value"] = tt.maxValue
+			}
+
+			action := &RemediationAction{ResourceType: "deployment", ResourceName: "api", Namespace: "default"}
+			require.NoError(t, svc.patchResource(context.Background(), client, action, "deployment", params))
+
+			deploy, err := client.AppsV1().Deployments("default").Get(context.Background(), "api", metav1.GetOptions{})
+			require.NoError(t, err)
+			got := deploy.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory]
+			assert.Equal(t, 0, got.Cmp(resource.MustParse(tt.want)), "got %s, want %s", got.String(), tt.want)
+		})
+	}
+}
+
+func TestPatchMultiplyResolvesPodOwner(t *testing.T) {
+	controller := true
+	client := fake.NewSimpleClientset(
+		deploymentWithMemoryLimit("256Mi"),
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name: "api-5d8f", Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "api", Controller: &controller}},
+		}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "api-5d8f-x7k2p", Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "api-5d8f", Controller: &controller}},
+		}},
+	)
+	svc := &Service{logger: zap.NewNop()}
+
+	action := &RemediationAction{ResourceType: "pod", ResourceName: "api-5d8f-x7k2p", Namespace: "default"}
+	err := svc.patchResource(context.Background(), client, action, "deployment", map[string]interface{}{
+		"path":       memoryLimitPath,
+		"operation":  "multiply",
+		"multiplier": 2.0,
+	})
+	require.NoError(t, err)
+
+	deploy, err := client.AppsV1().Deployments("default").Get(context.Background(), "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	got := deploy.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory]
+	assert.Equal(t, "512Mi", got.String())
+}
+
+func TestPatchMultiplyPVCRequiresExpansion(t *testing.T) {
+	for _, allow := range []bool{true, false} {
+		allowExpansion := allow
+		className := "standard"
+		client := fake.NewSimpleClientset(
+			&storagev1.StorageClass{
+				ObjectMeta:           metav1.ObjectMeta{Name: className},
+				AllowVolumeExpansion: &allowExpansion,
+			},
+			&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					StorageClassName: &className,
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+					},
+				},
+			},
+		)
+		svc := &Service{logger: zap.NewNop()}
+
+		action := &RemediationAction{ResourceType: "pvc", ResourceName: "data", Namespace: "default"}
+		err := svc.patchResource(context.Background(), client, action, "pvc", map[string]interface{}{
+			"path":       "/spec/resources/requests/storage",
+			"operation":  "multiply",
+			"multiplier": 1.5,
+		})
+
+		pvc, getErr := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "data", metav1.GetOptions{})
+		require.NoError(t, getErr)
+		got := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+
+		if allow {
+			require.NoError(t, err)
+			assert.Equal(t, "15Gi", got.String())
+		} else {
+			assert.ErrorContains(t, err, "does not allow volume expansion")
+			assert.Equal(t, "10Gi", got.String())
+		}
+	}
+}
+
+func TestRightsizingRulePatchesRequestsWithinCaps(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{DisableWorkers: true})
+	require.NoError(t, err)
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "proxy"},
+						{Name: "api", Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("2"),
+							corev1.ResourceMemory: resource.MustParse("4Gi"),
+						}}},
+					},
+				},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(deploy)
+	svc.RegisterK8sClient("prod", client, nil)
+
+	ctx := context.Background()
+	require.NoError(t, svc.ProcessEvent(ctx, &RemediationEvent{
+		Type:         RightsizingEventType,
+		Source:       "cost",
+		ClusterID:    "prod",
+		Namespace:    "payments",
+		ResourceType: "Deployment",
+		ResourceName: "api",
+		Reason:       "rightsizing",
+		Data: map[string]interface{}{
+			"container_index":         "1",
+			"recommended_cpu_request": "6",
+			"recommended_mem_request": "1Gi",
+		},
+	}))
+
+	// The action waits for approval, showing what it will set
+	var action RemediationAction
+	require.NoError(t, db.First(&action, "rule_id = ?", RightsizingRuleID).Error)
+	assert.Equal(t, "pending_approval", action.Status)
+	assert.Equal(t, "/spec/template/spec/containers/1/resources/requests/cpu", action.Parameters["path"])
+	assert.Equal(t, "6", action.Parameters["value"])
+
+	// Run as stored, the event data renders every step and the CPU cap holds
+	svc.executeAction(ctx, &action)
+	assert.Equal(t, "completed", action.Status)
+	got, err := client.AppsV1().Deployments("payments").Get(ctx, "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	requests := got.Spec.Template.Spec.Containers[1].Resources.Requests
+	assert.Equal(t, "4", requests.Cpu().String())
+	assert.Equal(t, "1Gi", requests.Memory().String())
+	assert.Empty(t, got.Spec.Template.Spec.Containers[0].Resources.Requests)
+}
@@ -0,0 +1,302 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	playbookAPIVersion = "krustron.io/v1"
+	playbookKind       = "RemediationPlaybook"
+)
+
+// PlaybookDocument is the YAML form of a playbook used for export and import.
+// Durations are written as strings ("15m0s") and IDs are omitted.
+type PlaybookDocument struct {
+	APIVersion  string         `json:"api_version"`
+	Kind        string         `json:"kind"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Version     string         `json:"version,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Rules       []RuleDocument `json:"rules"`
+}
+
+// RuleDocument is a full rule definition inside a PlaybookDocument
+type RuleDocument struct {
+	Name            string                 `json:"name"`
+	Description     string                 `json:"description,omitempty"`
+	Enabled         bool                   `json:"enabled"`
+	Priority        int                    `json:"priority,omitempty"`
+	Trigger         TriggerDocument        `json:"trigger"`
+	Conditions      []RuleCondition        `json:"conditions,omitempty"`
+	Actions         []RuleAction           `json:"actions"`
+	Cooldown        Duration               `json:"cooldown,omitempty"`
+	MaxExecutions   int                    `json:"max_executions,omitempty"`
+	RequireApproval bool                   `json:"require_approval,omitempty"`
+	Scope           RuleScope              `json:"scope,omitempty"`
+	Labels          map[string]string      `json:"labels,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// TriggerDocument mirrors RuleTrigger with readable durations
+type TriggerDocument struct {
+	Type         string                 `json:"type"`
+	Source       string                 `json:"source,omitempty"`
+	EventTypes   []string               `json:"event_types,omitempty"`
+	Query        string                 `json:"query,omitempty"`
+	QueryType    string                 `json:"query_type,omitempty"`
+	Threshold    float64                `json:"threshold,omitempty"`
+	Duration     Duration               `json:"duration,omitempty"`
+	Interval     Duration               `json:"interval,omitempty"`
+	Step         Duration               `json:"step,omitempty"`
+	Schedule     string                 `json:"schedule,omitempty"`
+	Filters      map[string]interface{} `json:"filters,omitempty"`
+	LabelMapping map[string]string      `json:"label_mapping,omitempty"`
+}
+
+// Duration is a time.Duration that serializes as a duration string
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string such as "1h30m0s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		parsed, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", str, err)
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// ImportOption adjusts how ImportPlaybook persists rules
+type ImportOption func(*importOptions)
+
+type importOptions struct {
+	enabled *bool
+}
+
+// WithRulesEnabled forces every imported rule to be enabled or disabled,
+// overriding the value in the document
+func WithRulesEnabled(enabled bool) ImportOption {
+	return func(o *importOptions) { o.enabled = &enabled }
+}
+
+// ExportPlaybook renders a playbook and its rules as YAML
+func (s *Service) ExportPlaybook(ctx context.Context, id string) ([]byte, error) {
+	var playbook Playbook
+	if err := s.db.WithContext(ctx).
+		Preload("Rules", func(db *gorm.DB) *gorm.DB { return db.Order("priority DESC, name") }).
+		First(&playbook, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("playbook not found: %w", err)
+	}
+
+	data, err := yaml.Marshal(playbookToDocument(&playbook))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode playbook: %w", err)
+	}
+	return data, nil
+}
+
+func playbookToDocument(playbook *Playbook) PlaybookDocument {
+	doc := PlaybookDocument{
+		APIVersion:  playbookAPIVersion,
+		Kind:        playbookKind,
+		Name:        playbook.Name,
+		Description: playbook.Description,
+		Version:     playbook.Version,
+		Tags:        playbook.Tags,
+		Rules:       make([]RuleDocument, 0, len(playbook.Rules)),
+	}
+	for i := range playbook.Rules {
+		doc.Rules = append(doc.Rules, ruleToDocument(&playbook.Rules[i]))
+	}
+	return doc
+}
+
+// ImportPlaybook validates a YAML playbook and persists it with fresh IDs.
+// Nothing is written if any rule is invalid. Rule and playbook names that
+// already exist get a numeric suffix.
+func (s *Service) ImportPlaybook(ctx context.Context, data []byte, opts ...ImportOption) (*Playbook, error) {
+	var options importOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var doc PlaybookDocument
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid playbook: %w", err)
+	}
+	if doc.Kind != "" && doc.Kind != playbookKind {
+		return nil, fmt.Errorf("unsupported kind %q", doc.Kind)
+	}
+	if doc.Name == "" {
+		return nil, fmt.Errorf("playbook name is required")
+	}
+
+	createdBy, _ := ctx.Value("user_id").(string)
+	now := time.Now()
+
+	rules := make([]RemediationRule, 0, len(doc.Rules))
+	for i := range doc.Rules {
+		rule := documentToRule(&doc.Rules[i])
+		if options.enabled != nil {
+			rule.Enabled = *options.enabled
+		}
+		if err := validateRule(&rule); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, doc.Rules[i].Name, err)
+		}
+		rule.ID = uuid.New().String()
+		rule.CreatedAt = now
+		rule.UpdatedAt = now
+		rule.CreatedBy = createdBy
+		rules = append(rules, rule)
+	}
+
+	playbook := &Playbook{
+		ID:          uuid.New().String(),
+		Name:        doc.Name,
+		Description: doc.Description,
+		Version:     doc.Version,
+		Tags:        doc.Tags,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		CreatedBy:   createdBy,
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i := range rules {
+			name, err := uniqueName(tx, &RemediationRule{}, rules[i].Name)
+			if err != nil {
+				return err
+			}
+			rules[i].Name = name
+
+			if err := tx.Create(&rules[i]).Error; err != nil {
+				return err
+			}
+			if err := s.recordRuleVersion(ctx, tx, &rules[i], "imported from playbook "+doc.Name); err != nil {
+				return err
+			}
+		}
+
+		name, err := uniqueName(tx, &Playbook{}, playbook.Name)
+		if err != nil {
+			return err
+		}
+		playbook.Name = name
+		playbook.Rules = rules
+		return tx.Create(playbook).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to import playbook: %w", err)
+	}
+
+	for i := range playbook.Rules {
+		if err := s.applyRuleChange(ctx, &playbook.Rules[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return playbook, nil
+}
+
+// uniqueName returns name, or name with a numeric suffix if the model's
+// table already has a row with that name
+func uniqueName(tx *gorm.DB, model interface{}, name string) (string, error) {
+	candidate := name
+	for n := 2; ; n++ {
+		var count int64
+		if err := tx.Model(model).Where("name = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", name, n)
+	}
+}
+
+func ruleToDocument(rule *RemediationRule) RuleDocument {
+	t := rule.Trigger
+	return RuleDocument{
+		Name:        rule.Name,
+		Description: rule.Description,
+		Enabled:     rule.Enabled,
+		Priority:    rule.Priority,
+		Trigger: TriggerDocument{
+			Type:         t.Type,
+			Source:       t.Source,
+			EventTypes:   t.EventTypes,
+			Query:        t.Query,
+			QueryType:    t.QueryType,
+			Threshold:    t.Threshold,
+			Duration:     Duration(t.Duration),
+			Interval:     Duration(t.Interval),
+			Step:         Duration(t.Step),
+			Schedule:     t.Schedule,
+			Filters:      t.Filters,
+			LabelMapping: t.LabelMapping,
+		},
+		Conditions:      rule.Conditions,
+		Actions:         rule.Actions,
+		Cooldown:        Duration(rule.Cooldown),
+		MaxExecutions:   rule.MaxExecutions,
+		RequireApproval: rule.RequireApproval,
+		Scope:           rule.Scope,
+		Labels:          rule.Labels,
+		Metadata:        rule.Metadata,
+	}
+}
+
+func documentToRule(doc *RuleDocument) RemediationRule {
+	t := doc.Trigger
+	return RemediationRule{
+		Name:        doc.Name,
+		Description: doc.Description,
+		Enabled:     doc.Enabled,
+		Priority:    doc.Priority,
+		Trigger: RuleTrigger{
+			Type:         t.Type,
+			Source:       t.Source,
+			EventTypes:   t.EventTypes,
+			Query:        t.Query,
+			QueryType:    t.QueryType,
+			Threshold:    t.Threshold,
+			Duration:     time.Duration(t.Duration),
+			Interval:     time.Duration(t.Interval),
+			Step:         time.Duration(t.Step),
+			Schedule:     t.Schedule,
+			Filters:      t.Filters,
+			LabelMapping: t.LabelMapping,
+		},
+		Conditions:      doc.Conditions,
+		Actions:         doc.Actions,
+		Cooldown:        time.Duration(doc.Cooldown),
+		MaxExecutions:   doc.MaxExecutions,
+		RequireApproval: doc.RequireApproval,
+		Scope:           doc.Scope,
+		Labels:          doc.Labels,
+		Metadata:        doc.Metadata,
+	}
+}
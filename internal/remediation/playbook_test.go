@@ -0,0 +1,181 @@
+package remediation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPlaybookTestService(t *testing.T) *Service {
+	t.Helper()
+
+	svc := newVersionTestService(t)
+	require.NoError(t, svc.db.AutoMigrate(&Playbook{}))
+	return svc
+}
+
+func TestPlaybookRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newPlaybookTestService(t)
+
+	rules := []*RemediationRule{
+		{
+			Name:     "restart-crashloop",
+			Enabled:  true,
+			Priority: 100,
+			Trigger: RuleTrigger{
+				Type:       "event",
+				Source:     "kubernetes",
+				EventTypes: []string{"Warning"},
+				Filters:    map[string]interface{}{"reason": "BackOff"},
+			},
+			Conditions: []RuleCondition{
+				{Type: "time_window", Field: "count", Operator: "gte", Value: "3"},
+			},
+			Actions: []RuleAction{{
+				Type: "restart_pod", Target: "{{ .ResourceName }}", Order: 1, OnFailure: "abort", MaxRetries: 2,
+				Parameters: map[string]interface{}{"grace_period": float64(30)},
+			}},
+			Cooldown:      5 * time.Minute,
+			MaxExecutions: 3,
+			Scope:         RuleScope{Namespaces: []string{"prod"}},
+		},
+		{
+			Name:     "expand-pvc",
+			Enabled:  true,
+			Priority: 70,
+			Trigger: RuleTrigger{
+				Type:      "metric",
+				Query:     `kubelet_volume_stats_used_bytes{namespace="prod"} / kubelet_volume_stats_capacity_bytes`,
+				Threshold: 0.85,
+				Duration:  10 * time.Minute,
+				Interval:  time.Minute,
+			},
+			Actions: []RuleAction{{
+				Type: "patch", Target: "pvc", Order: 1, OnFailure: "abort",
+				Parameters: map[string]interface{}{
+					"path": "/spec/resources/requests/storage", "operation": "multiply", "multiplier": 1.5,
+				},
+			}},
+			Cooldown:        time.Hour,
+			RequireApproval: true,
+			Labels:          map[string]string{"team": "storage"},
+		},
+	}
+	playbook := &Playbook{ID: "pb-1", Name: "storage-and-pods", Version: "1.2.0", Tags: []string{"prod"}}
+	for _, rule := range rules {
+		require.NoError(t, src.CreateRule(ctx, rule))
+		playbook.Rules = append(playbook.Rules, *rule)
+	}
+	require.NoError(t, src.db.Create(playbook).Error)
+
+	exported, err := src.ExportPlaybook(ctx, playbook.ID)
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), "cooldown: 5m0s")
+
+	dst := newPlaybookTestService(t)
+	imported, err := dst.ImportPlaybook(ctx, exported)
+	require.NoError(t, err)
+	assert.NotEqual(t, playbook.ID, imported.ID)
+	assert.Equal(t, playbook.Name, imported.Name)
+	require.Len(t, imported.Rules, len(rules))
+
+	for i, rule := range imported.Rules {
+		assert.NotEqual(t, rules[i].ID, rule.ID)
+		assert.Equal(t, ruleToDocument(rules[i]), ruleToDocument(&rule))
+	}
+
+	// Exporting the imported copy yields the same document
+	reexported, err := dst.ExportPlaybook(ctx, imported.ID)
+	require.NoError(t, err)
+	assert.YAMLEq(t, string(exported), string(reexported))
+}
+
+func TestImportPlaybookValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{
+			name: "unknown action type",
+			yaml: `
+name: bad
+rules:
+  - name: r1
+    trigger: {type: event}
+    actions: [{type: reboot_cluster}]
+`,
+			err: "unknown action type",
+		},
+		{
+			name: "malformed cron",
+			yaml: `
+name: bad
+rules:
+  - name: r1
+    trigger: {type: schedule, schedule: "every tuesday"}
+    actions: [{type: notify}]
+`,
+			err: "invalid cron schedule",
+		},
+		{
+			name: "malformed query",
+			yaml: `
+name: bad
+rules:
+  - name: r1
+    trigger: {type: metric, query: "sum(rate(http_requests_total[5m])"}
+    actions: [{type: notify}]
+`,
+			err: "invalid metric query",
+		},
+		{
+			name: "unknown field",
+			yaml: `
+name: bad
+rules:
+  - name: r1
+    trigger: {type: event}
+    actions: [{type: notify}]
+    cooldwon: 5m
+`,
+			err: "invalid playbook",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newPlaybookTestService(t)
+			_, err := svc.ImportPlaybook(context.Background(), []byte(tt.yaml))
+			assert.ErrorContains(t, err, tt.err)
+
+			var count int64
+			require.NoError(t, svc.db.Model(&RemediationRule{}).Count(&count).Error)
+			assert.Zero(t, count, "nothing should be persisted")
+		})
+	}
+}
+
+func TestImportPlaybookForcesEnabled(t *testing.T) {
+	svc := newPlaybookTestService(t)
+	doc := `
+name: disabled-copy
+rules:
+  - name: r1
+    enabled: true
+    trigger: {type: event}
+    actions: [{type: notify, target: slack}]
+`
+	playbook, err := svc.ImportPlaybook(context.Background(), []byte(doc), WithRulesEnabled(false))
+	require.NoError(t, err)
+	require.Len(t, playbook.Rules, 1)
+	assert.False(t, playbook.Rules[0].Enabled)
+
+	svc.rulesMu.RLock()
+	defer svc.rulesMu.RUnlock()
+	assert.Empty(t, svc.rules)
+}
@@ -0,0 +1,209 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// scheduledRunTimeout bounds one scheduled sweep of a rule across clusters
+const scheduledRunTimeout = 5 * time.Minute
+
+// scheduleLockSlack is how long before a rule's next run the lock on its
+// current run expires
+const scheduleLockSlack = 5 * time.Second
+
+// SetLocks makes a replica take a Redis lock before running a scheduled
+// rule, evaluating a metric rule or watching a cluster's events, so each
+// happens on one replica only. Optional; without it every replica runs
+// every schedule and metric rule and acts on every event.
+func (s *Service) SetLocks(c *cache.RedisCache) { s.locks = c }
+
+// scheduleRule (re)registers the cron entry for a rule, removing it when the
+// rule is disabled or no longer schedule-triggered
+func (s *Service) scheduleRule(rule *RemediationRule) error {
+	s.cronMu.Lock()
+	defer s.cronMu.Unlock()
+
+	if id, ok := s.cronEntries[rule.ID]; ok {
+		s.cron.Remove(id)
+		delete(s.cronEntries, rule.ID)
+	}
+
+	if !rule.Enabled || rule.Trigger.Type != "schedule" {
+		return nil
+	}
+
+	ruleID := rule.ID
+	id, err := s.cron.AddFunc(rule.Trigger.Schedule, func() {
+		s.runScheduledRule(ruleID)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid cron schedule %q: %w", rule.Trigger.Schedule, err)
+	}
+	s.cronEntries[rule.ID] = id
+
+	s.logger.Info("Scheduled remediation rule",
+		zap.String("rule", rule.Name),
+		zap.String("schedule", rule.Trigger.Schedule),
+	)
+	return nil
+}
+
+// unscheduleRule removes a rule's cron entry if it has one
+func (s *Service) unscheduleRule(ruleID string) {
+	s.cronMu.Lock()
+	defer s.cronMu.Unlock()
+
+	if id, ok := s.cronEntries[ruleID]; ok {
+		s.cron.Remove(id)
+		delete(s.cronEntries, ruleID)
+	}
+}
+
+// scheduleLoadedRules registers cron entries for every loaded rule
+func (s *Service) scheduleLoadedRules() {
+	s.rulesMu.RLock()
+	rules := make([]*RemediationRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	s.rulesMu.RUnlock()
+
+	for _, rule := range rules {
+		if err := s.scheduleRule(rule); err != nil {
+			s.logger.Warn("Failed to schedule rule", zap.String("rule", rule.Name), zap.Error(err))
+		}
+	}
+}
+
+// runScheduledRule lists the pods in the rule's scope on every matching
+// cluster and feeds each one through ProcessEvent, which evaluates the rule's
+// conditions against the live pod state and enqueues actions
+func (s *Service) runScheduledRule(ruleID string) {
+	s.rulesMu.RLock()
+	rule, ok := s.rules[ruleID]
+	s.rulesMu.RUnlock()
+	if !ok || !rule.Enabled {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scheduledRunTimeout)
+	defer cancel()
+
+	if !s.claimScheduledRun(ctx, rule) {
+		return
+	}
+
+	for clusterID, client := range s.scopedClients(rule.Scope) {
+		namespaces := rule.Scope.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{metav1.NamespaceAll}
+		}
+
+		for _, ns := range namespaces {
+			if ns == "*" {
+				ns = metav1.NamespaceAll
+			}
+			if err := s.sweepPods(ctx, rule, clusterID, ns, client); err != nil {
+				s.logger.Warn("Scheduled rule sweep failed",
+					zap.String("rule", rule.Name),
+					zap.String("cluster", clusterID),
+					zap.String("namespace", ns),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// claimScheduledRun reports whether this replica runs the rule's current
+// tick. The lock is not released but left to expire just before the next
+// tick, so a replica whose clock is slightly behind can't take it again
+// for the same tick. When Redis can't be reached the run is skipped.
+func (s *Service) claimScheduledRun(ctx context.Context, rule *RemediationRule) bool {
+	if s.locks == nil {
+		return true
+	}
+
+	ttl := scheduledRunTimeout
+	if schedule, err := cron.ParseStandard(rule.Trigger.Schedule); err == nil {
+		ttl = time.Until(schedule.Next(time.Now())) - scheduleLockSlack
+	}
+	if ttl < scheduleLockSlack {
+		ttl = scheduleLockSlack
+	}
+
+	_, ok, err := s.locks.AcquireLock(ctx, "remediation:schedule:"+rule.ID, ttl)
+	if err != nil {
+		s.logger.Warn("Failed to lock scheduled rule, skipping run",
+			zap.String("rule", rule.Name),
+			zap.Error(err),
+		)
+		return false
+	}
+	if !ok {
+		s.logger.Debug("Scheduled rule is running on another replica", zap.String("rule", rule.Name))
+	}
+	return ok
+}
+
+// scopedClients returns the registered clients for the clusters in scope
+func (s *Service) scopedClients(scope RuleScope) map[string]kubernetes.Interface {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	clients := make(map[string]kubernetes.Interface)
+	for clusterID, client := range s.k8sClients {
+		if s.matchScope(RuleScope{Clusters: scope.Clusters}, &RemediationEvent{ClusterID: clusterID}) {
+			clients[clusterID] = client
+		}
+	}
+	return clients
+}
+
+func (s *Service) sweepPods(ctx context.Context, rule *RemediationRule, clusterID, namespace string, client kubernetes.Interface) error {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	for i := range pods.Items {
+		event := scheduledPodEvent(rule, clusterID, &pods.Items[i])
+		if err := s.ProcessEvent(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scheduledPodEvent describes a pod's current state as a RemediationEvent so
+// resource_status and label conditions can be evaluated against it
+func scheduledPodEvent(rule *RemediationRule, clusterID string, pod *corev1.Pod) *RemediationEvent {
+	return &RemediationEvent{
+		ID:           uuid.New().String(),
+		Type:         "Scheduled",
+		Source:       "scheduler",
+		ClusterID:    clusterID,
+		Namespace:    pod.Namespace,
+		ResourceType: "pod",
+		ResourceName: pod.Name,
+		Reason:       pod.Status.Reason,
+		Message:      pod.Status.Message,
+		Labels:       pod.Labels,
+		Timestamp:    time.Now(),
+		Data: map[string]interface{}{
+			"rule_id":       rule.ID,
+			"status.phase":  string(pod.Status.Phase),
+			"status.reason": pod.Status.Reason,
+		},
+	}
+}
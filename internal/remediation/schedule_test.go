@@ -0,0 +1,44 @@
+package remediation
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestScheduledRunClaimedByOneReplica(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redisCache, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	t.Cleanup(func() { redisCache.Close() })
+
+	replica := func() *Service {
+		svc := &Service{logger: zap.NewNop()}
+		svc.SetLocks(redisCache)
+		return svc
+	}
+	first, second := replica(), replica()
+	ctx := context.Background()
+	rule := &RemediationRule{ID: "nightly", Name: "nightly", Trigger: RuleTrigger{Type: "schedule", Schedule: "@hourly"}}
+
+	assert.True(t, first.claimScheduledRun(ctx, rule))
+	assert.False(t, second.claimScheduledRun(ctx, rule), "the tick is already claimed")
+
+	// The claim lapses before the next tick, which either replica may take
+	mr.FastForward(time.Hour)
+	assert.True(t, second.claimScheduledRun(ctx, rule))
+
+	// Without Redis the run is skipped rather than duplicated
+	mr.Close()
+	assert.False(t, first.claimScheduledRun(ctx, &RemediationRule{ID: "other", Name: "other", Trigger: rule.Trigger}))
+}
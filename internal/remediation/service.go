@@ -3,19 +3,37 @@
 package remediation
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	"github.com/anubhavg-icpl/krustron/internal/clusterconfig"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/httpsafe"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
+	"github.com/anubhavg-icpl/krustron/pkg/notify"
+	"github.com/anubhavg-icpl/krustron/pkg/prometheus"
 	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // Config holds remediation service configuration
@@ -29,7 +47,31 @@ type Config struct {
 	EnableSlack          bool
 	SlackWebhook         string
 	RequireApproval      bool
+	AllowUnapprovedExec  bool // let exec actions run without approval
 	ApprovalTimeout      time.Duration
+	WebhookSecret        string // signs outgoing webhook bodies when set
+	PagerDutyRoutingKey  string
+	PagerDutyEventsURL   string // defaults to the PagerDuty Events API v2 endpoint
+	ActionLeaseDuration  time.Duration // how long a replica owns a claimed action without renewing
+	QueuePollInterval    time.Duration // how often the database queue is polled for work
+	Prometheus           PrometheusConfig
+	// Egress limits where webhooks and notifications may be sent
+	Egress httpsafe.Policy
+	// DisableWorkers skips the action processor, triggers and schedules,
+	// for one-off commands such as backup and restore
+	DisableWorkers bool
+	// EventWatch configures the per-cluster Kubernetes event watchers
+	EventWatch EventWatchConfig
+}
+
+// PrometheusConfig configures the Prometheus endpoint used by metric triggers
+type PrometheusConfig struct {
+	URL                string
+	BearerToken        string
+	Username           string
+	Password           string
+	Timeout            time.Duration
+	EvaluationInterval time.Duration // default interval for rules without Trigger.Interval
 }
 
 // Service provides auto-remediation operations
@@ -38,11 +80,30 @@ type Service struct {
 	logger       *zap.Logger
 	config       *Config
 	k8sClients   map[string]kubernetes.Interface
+	restConfigs  map[string]*rest.Config
 	clientsMu    sync.RWMutex
 	rules        map[string]*RemediationRule
 	rulesMu      sync.RWMutex
-	actionQueue  chan *RemediationAction
+	wakeCh       chan struct{}
+	instanceID   string
+	eventCounts  *eventWindow
+	prometheus   *prometheus.Client
+	metricState  *metricTriggerState
+	httpClient   *http.Client
+	notifier     notify.Dispatcher
+	now          func() time.Time
+	expired      atomic.Int64 // approvals expired by this instance
+	paused       atomic.Bool  // events are ignored, see SetMode
+	dryRun       atomic.Bool  // the global DryRun, see SetMode
+	broadcaster  RuleBroadcaster
+	overrides    *clusterconfig.Store
+	cron         *cron.Cron
+	cronEntries  map[string]cron.EntryID
+	cronMu       sync.Mutex
+	locks        *cache.RedisCache
 	stopCh       chan struct{}
+	watchers     map[string]*clusterWatcher
+	watchersMu   sync.Mutex
 }
 
 // RemediationRule defines a rule for auto-remediation
@@ -78,6 +139,12 @@ type RuleTrigger struct {
 	Duration   time.Duration          `json:"duration,omitempty"`
 	Schedule   string                 `json:"schedule,omitempty"` // Cron expression
 	Filters    map[string]interface{} `json:"filters,omitempty"`
+
+	// Metric trigger options
+	QueryType    string            `json:"query_type,omitempty"`    // instant (default), range
+	Interval     time.Duration     `json:"interval,omitempty"`      // evaluation interval
+	Step         time.Duration     `json:"step,omitempty"`          // range query resolution
+	LabelMapping map[string]string `json:"label_mapping,omitempty"` // event field -> series label
 }
 
 // RuleCondition defines conditions that must be met
@@ -107,7 +174,7 @@ type RuleScope struct {
 
 // RemediationAction represents an action execution
 type RemediationAction struct {
-	ID             string                 `json:"id" gorm:"primaryKey"`
+	ID             string                 `json:"id" gorm:"primaryKey;index:idx_remediation_actions_created_id,priority:2"`
 	RuleID         string                 `json:"rule_id" gorm:"index"`
 	RuleName       string                 `json:"rule_name"`
 	ClusterID      string                 `json:"cluster_id" gorm:"index"`
@@ -115,7 +182,7 @@ type RemediationAction struct {
 	ResourceType   string                 `json:"resource_type"`
 	ResourceName   string                 `json:"resource_name"`
 	ActionType     string                 `json:"action_type"`
-	Status         string                 `json:"status"` // pending, approved, running, completed, failed, rejected
+	Status         string                 `json:"status"` // pending, approved, running, completed, failed, rejected, expired
 	DryRun         bool                   `json:"dry_run"`
 	TriggerEvent   map[string]interface{} `json:"trigger_event" gorm:"serializer:json"`
 	Parameters     map[string]interface{} `json:"parameters" gorm:"serializer:json"`
@@ -123,10 +190,15 @@ type RemediationAction struct {
 	Error          string                 `json:"error"`
 	ApprovedBy     string                 `json:"approved_by"`
 	ApprovedAt     *time.Time             `json:"approved_at"`
+	ClaimedBy      string                 `json:"claimed_by" gorm:"index"`
+	LeaseExpiresAt *time.Time             `json:"lease_expires_at"`
+	Attempts       int                    `json:"attempts"`
+	CompletedSteps []int                  `json:"completed_steps" gorm:"serializer:json"`
+	FailedSteps    []int                  `json:"failed_steps" gorm:"serializer:json"` // failed with on_failure continue
 	StartedAt      *time.Time             `json:"started_at"`
 	CompletedAt    *time.Time             `json:"completed_at"`
 	Duration       time.Duration          `json:"duration"`
-	CreatedAt      time.Time              `json:"created_at"`
+	CreatedAt      time.Time              `json:"created_at" gorm:"index:idx_remediation_actions_created_id,priority:1"`
 }
 
 // RemediationEvent represents an event that can trigger remediation
@@ -146,6 +218,14 @@ type RemediationEvent struct {
 	Timestamp    time.Time              `json:"timestamp"`
 }
 
+// Rightsizing recommendations arrive from the cost service as events of
+// RightsizingEventType with the recommendation type as reason; the default
+// rule RightsizingRuleID patches container requests for them
+const (
+	RightsizingEventType = "Rightsizing"
+	RightsizingRuleID    = "rule-rightsizing"
+)
+
 // Playbook represents a collection of remediation rules
 type Playbook struct {
 	ID          string            `json:"id" gorm:"primaryKey"`
@@ -166,6 +246,8 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 		&RemediationRule{},
 		&RemediationAction{},
 		&Playbook{},
+		&EventWindowState{},
+		&RuleVersion{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate remediation tables: %w", err)
 	}
@@ -180,15 +262,54 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 	if config.ApprovalTimeout == 0 {
 		config.ApprovalTimeout = 1 * time.Hour
 	}
+	if config.ActionLeaseDuration == 0 {
+		config.ActionLeaseDuration = 2 * time.Minute
+	}
+	if config.QueuePollInterval == 0 {
+		config.QueuePollInterval = 5 * time.Second
+	}
+	if config.PagerDutyEventsURL == "" {
+		config.PagerDutyEventsURL = notify.DefaultPagerDutyEventsURL
+	}
+	if config.Prometheus.EvaluationInterval == 0 {
+		config.Prometheus.EvaluationInterval = time.Minute
+	}
+	config.EventWatch.withDefaults()
+
+	egress := config.Egress
+	if egress.Timeout == 0 {
+		egress.Timeout = notificationTimeout
+	}
+	httpClient, err := httpsafe.NewClient(egress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress policy: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
 
 	svc := &Service{
 		db:          db,
 		logger:      logger,
 		config:      config,
 		k8sClients:  make(map[string]kubernetes.Interface),
+		restConfigs: make(map[string]*rest.Config),
 		rules:       make(map[string]*RemediationRule),
-		actionQueue: make(chan *RemediationAction, 100),
+		wakeCh:      make(chan struct{}, 1),
+		instanceID:  fmt.Sprintf("%s-%s", hostname, uuid.New().String()[:8]),
+		eventCounts: newEventWindow(eventWindowCapacity),
+		metricState: newMetricTriggerState(),
+		httpClient:  httpClient,
+		now:         time.Now,
+		cron:        cron.New(),
+		cronEntries: make(map[string]cron.EntryID),
 		stopCh:      make(chan struct{}),
+		watchers:    make(map[string]*clusterWatcher),
+	}
+	svc.dryRun.Store(config.DryRun)
+
+	// Initialize default rules before loading so they are active on first start
+	if err := svc.initializeDefaultRules(); err != nil {
+		logger.Warn("Failed to initialize default rules", zap.Error(err))
 	}
 
 	// Load rules from database
@@ -196,13 +317,29 @@ func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, erro
 		logger.Warn("Failed to load remediation rules", zap.Error(err))
 	}
 
-	// Initialize default rules
-	if err := svc.initializeDefaultRules(); err != nil {
-		logger.Warn("Failed to initialize default rules", zap.Error(err))
+	// Restore event counts for time_window conditions
+	if err := svc.loadEventWindows(); err != nil {
+		logger.Warn("Failed to load event windows", zap.Error(err))
+	}
+
+	if config.DisableWorkers {
+		return svc, nil
 	}
 
 	// Start action processor
 	go svc.processActions()
+	go svc.persistEventWindows()
+	go svc.runApprovalExpiry()
+
+	// Poll Prometheus for metric-triggered rules
+	if config.Prometheus.URL != "" {
+		svc.prometheus = newPrometheusClient(&config.Prometheus)
+		go svc.runMetricTriggers()
+	}
+
+	// Run schedule-triggered rules on their cron expressions
+	svc.scheduleLoadedRules()
+	svc.cron.Start()
 
 	return svc, nil
 }
@@ -417,6 +554,50 @@ func (s *Service) initializeDefaultRules() error {
 			MaxExecutions:   1,
 			RequireApproval: true,
 		},
+		{
+			ID:          RightsizingRuleID,
+			Name:        "Apply Rightsizing Recommendations",
+			Description: "Set container requests to what the cost service recommends, within caps",
+			Enabled:     true,
+			Priority:    60,
+			Trigger: RuleTrigger{
+				Type:       "event",
+				Source:     "cost",
+				EventTypes: []string{RightsizingEventType},
+				Filters: map[string]interface{}{
+					"reason": "rightsizing",
+				},
+			},
+			Actions: []RuleAction{
+				{
+					// The workload kind comes from the event
+					Type: "patch",
+					Parameters: map[string]interface{}{
+						"path":      "/spec/template/spec/containers/{{ .Data.container_index }}/resources/requests/cpu",
+						"operation": "replace",
+						"value":     "{{ .Data.recommended_cpu_request }}",
+						"max_value": "4",
+					},
+					Order:     1,
+					OnFailure: "abort",
+				},
+				{
+					Type: "patch",
+					Parameters: map[string]interface{}{
+						"path":      "/spec/template/spec/containers/{{ .Data.container_index }}/resources/requests/memory",
+						"operation": "replace",
+						"value":     "{{ .Data.recommended_mem_request }}",
+						"max_value": "8Gi",
+					},
+					Order:     2,
+					OnFailure: "abort",
+				},
+			},
+			// Recommendations for different workloads arrive together
+			Cooldown:        time.Minute,
+			MaxExecutions:   100,
+			RequireApproval: true,
+		},
 	}
 
 	for _, rule := range defaultRules {
@@ -433,15 +614,52 @@ func (s *Service) initializeDefaultRules() error {
 	return nil
 }
 
-// RegisterK8sClient registers a Kubernetes client for a cluster
-func (s *Service) RegisterK8sClient(clusterID string, client kubernetes.Interface) {
+// SetClusterOverrides sets where per-cluster overrides of dry run are read
+func (s *Service) SetClusterOverrides(store *clusterconfig.Store) { s.overrides = store }
+
+// RegisterK8sClient registers a Kubernetes client for a cluster.
+// The rest config is needed by actions that stream (exec) and may be nil
+// if those actions are not used on the cluster.
+func (s *Service) RegisterK8sClient(clusterID string, client kubernetes.Interface, restConfig *rest.Config) {
 	s.clientsMu.Lock()
 	defer s.clientsMu.Unlock()
 	s.k8sClients[clusterID] = client
+	if restConfig != nil {
+		s.restConfigs[clusterID] = restConfig
+	} else {
+		delete(s.restConfigs, clusterID)
+	}
+}
+
+// requiresApproval reports whether actions created by rule must wait for
+// approval. Rules that exec into pods always do unless AllowUnapprovedExec.
+func (s *Service) requiresApproval(rule *RemediationRule) bool {
+	if rule.RequireApproval || s.config.RequireApproval {
+		return true
+	}
+	if !s.config.AllowUnapprovedExec {
+		for _, a := range rule.Actions {
+			if a.Type == "exec" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetMode switches whether events are acted on and whether actions are
+// dry runs by default, as a configuration reload does. Actions already
+// queued still run.
+func (s *Service) SetMode(enabled, dryRun bool) {
+	s.paused.Store(!enabled)
+	s.dryRun.Store(dryRun)
 }
 
 // ProcessEvent processes an event and triggers matching rules
 func (s *Service) ProcessEvent(ctx context.Context, event *RemediationEvent) error {
+	if s.paused.Load() {
+		return nil
+	}
 	s.logger.Debug("Processing remediation event",
 		zap.String("type", event.Type),
 		zap.String("resource", event.ResourceName),
@@ -452,6 +670,11 @@ func (s *Service) ProcessEvent(ctx context.Context, event *RemediationEvent) err
 	matchingRules := s.findMatchingRules(event)
 
 	for _, rule := range matchingRules {
+		// Count the occurrence before any gating so time_window conditions
+		// see every matching event, including those during cooldown
+		countKey := eventKey(rule.ID, event)
+		s.eventCounts.record(countKey, event.Timestamp)
+
 		// Check cooldown
 		if !s.checkCooldown(rule) {
 			s.logger.Debug("Rule in cooldown period",
@@ -474,6 +697,9 @@ func (s *Service) ProcessEvent(ctx context.Context, event *RemediationEvent) err
 			continue
 		}
 
+		// The rule fires now; the next firing needs a fresh run of events
+		s.eventCounts.reset(countKey)
+
 		// Create remediation action
 		action := &RemediationAction{
 			ID:           uuid.New().String(),
@@ -485,18 +711,24 @@ func (s *Service) ProcessEvent(ctx context.Context, event *RemediationEvent) err
 			ResourceName: event.ResourceName,
 			ActionType:   rule.Actions[0].Type,
 			Status:       "pending",
-			DryRun:       s.config.DryRun,
+			DryRun:       s.overrides.RemediationDryRun(ctx, event.ClusterID, s.dryRun.Load()),
 			TriggerEvent: map[string]interface{}{
 				"type":    event.Type,
 				"reason":  event.Reason,
 				"message": event.Message,
 			},
-			Parameters: rule.Actions[0].Parameters,
+			Parameters: renderParameters(rule.Actions[0].Parameters, event),
 			CreatedAt:  time.Now(),
 		}
+		// Parameters templated on the event's data are rendered again
+		// when the action runs
+		if len(event.Data) > 0 {
+			action.TriggerEvent["source"] = event.Source
+			action.TriggerEvent["data"] = event.Data
+		}
 
 		// Check if approval is required
-		if rule.RequireApproval || s.config.RequireApproval {
+		if s.requiresApproval(rule) {
 			action.Status = "pending_approval"
 			if err := s.db.Create(action).Error; err != nil {
 				s.logger.Error("Failed to create action", zap.Error(err))
@@ -506,18 +738,14 @@ func (s *Service) ProcessEvent(ctx context.Context, event *RemediationEvent) err
 			continue
 		}
 
-		// Queue action for execution
+		// Queue action for execution. The database row is the queue: it stays
+		// queued until a worker slot is free, so nothing is dropped under load.
 		action.Status = "queued"
 		if err := s.db.Create(action).Error; err != nil {
 			s.logger.Error("Failed to create action", zap.Error(err))
 			continue
 		}
-
-		select {
-		case s.actionQueue <- action:
-		default:
-			s.logger.Warn("Action queue full, dropping action", zap.String("action_id", action.ID))
-		}
+		s.wake()
 	}
 
 	return nil
@@ -535,24 +763,8 @@ func (s *Service) findMatchingRules(event *RemediationEvent) []*RemediationRule
 			continue
 		}
 
-		// Check trigger type
-		if rule.Trigger.Type == "event" {
-			// Check event type match
-			eventTypeMatch := false
-			for _, et := range rule.Trigger.EventTypes {
-				if et == event.Type {
-					eventTypeMatch = true
-					break
-				}
-			}
-			if !eventTypeMatch && len(rule.Trigger.EventTypes) > 0 {
-				continue
-			}
-
-			// Check filters
-			if !s.matchFilters(rule.Trigger.Filters, event) {
-				continue
-			}
+		if !s.matchTrigger(rule, event) {
+			continue
 		}
 
 		// Check scope
@@ -566,6 +778,35 @@ func (s *Service) findMatchingRules(event *RemediationEvent) []*RemediationRule
 	return matching
 }
 
+// matchTrigger checks an event against a rule's trigger. Event triggers match
+// on event type and filters; metric and schedule triggers only match the
+// events their evaluator synthesizes for that rule.
+func (s *Service) matchTrigger(rule *RemediationRule, event *RemediationEvent) bool {
+	switch rule.Trigger.Type {
+	case "event":
+	case "metric", "schedule":
+		ruleID, _ := event.Data["rule_id"].(string)
+		return ruleID == rule.ID
+	default:
+		return true
+	}
+
+	// Check event type match
+	eventTypeMatch := false
+	for _, et := range rule.Trigger.EventTypes {
+		if et == event.Type {
+			eventTypeMatch = true
+			break
+		}
+	}
+	if !eventTypeMatch && len(rule.Trigger.EventTypes) > 0 {
+		return false
+	}
+
+	// Check filters
+	return s.matchFilters(rule.Trigger.Filters, event)
+}
+
 func (s *Service) matchFilters(filters map[string]interface{}, event *RemediationEvent) bool {
 	for key, value := range filters {
 		var eventValue string
@@ -662,35 +903,64 @@ func (s *Service) checkCooldown(rule *RemediationRule) bool {
 
 func (s *Service) evaluateConditions(ctx context.Context, rule *RemediationRule, event *RemediationEvent) bool {
 	for _, condition := range rule.Conditions {
-		if !s.evaluateCondition(ctx, condition, event) {
+		if !s.evaluateCondition(ctx, rule, condition, event) {
 			return false
 		}
 	}
 	return true
 }
 
-func (s *Service) evaluateCondition(ctx context.Context, condition RuleCondition, event *RemediationEvent) bool {
-	var value string
+func (s *Service) evaluateCondition(ctx context.Context, rule *RemediationRule, condition RuleCondition, event *RemediationEvent) bool {
+	return s.checkCondition(ctx, rule, condition, event).Passed
+}
+
+// ConditionResult records how a single rule condition evaluated
+type ConditionResult struct {
+	Type     string `json:"type"`
+	Field    string `json:"field"`
+	Operator string `json:"operator"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Passed   bool   `json:"passed"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// checkCondition evaluates a condition and reports the value it compared
+func (s *Service) checkCondition(ctx context.Context, rule *RemediationRule, condition RuleCondition, event *RemediationEvent) ConditionResult {
+	result := ConditionResult{
+		Type:     condition.Type,
+		Field:    condition.Field,
+		Operator: condition.Operator,
+		Expected: condition.Value,
+	}
 
 	switch condition.Type {
 	case "resource_status":
 		// Would need to fetch actual resource status from K8s
 		if v, ok := event.Data[condition.Field]; ok {
-			value = fmt.Sprintf("%v", v)
+			result.Actual = fmt.Sprintf("%v", v)
 		}
 	case "label":
 		if v, ok := event.Labels[condition.Field]; ok {
-			value = v
+			result.Actual = v
 		}
 	case "time_window":
-		// Check if we've seen enough events in the time window
-		// This would require tracking event counts
-		return true // Simplified for now
+		// Compare the number of matching events seen within the trigger
+		// duration against the condition value, e.g. "gt 3"
+		window := rule.Trigger.Duration
+		if window == 0 {
+			window = defaultEventWindow
+		}
+		result.Actual = strconv.Itoa(s.eventCounts.count(eventKey(rule.ID, event), window))
+		result.Detail = fmt.Sprintf("events for this resource in the last %s", window)
 	default:
-		return true
+		result.Passed = true
+		result.Detail = "unknown condition type; ignored"
+		return result
 	}
 
-	return s.compareValues(value, condition.Operator, condition.Value)
+	result.Passed = s.compareValues(result.Actual, condition.Operator, condition.Value)
+	return result
 }
 
 func (s *Service) compareValues(actual, operator, expected string) bool {
@@ -712,29 +982,345 @@ func (s *Service) compareValues(actual, operator, expected string) bool {
 			}
 		}
 		return false
+	case "gt", "gte", "lt", "lte":
+		a, err := strconv.ParseFloat(actual, 64)
+		if err != nil {
+			return false
+		}
+		e, err := strconv.ParseFloat(expected, 64)
+		if err != nil {
+			return false
+		}
+		switch operator {
+		case "gt":
+			return a > e
+		case "gte":
+			return a >= e
+		case "lt":
+			return a < e
+		default:
+			return a <= e
+		}
 	default:
 		return actual == expected
 	}
 }
 
-// processActions processes queued actions
+const (
+	// defaultEventWindow applies to time_window conditions on rules without a Trigger.Duration
+	defaultEventWindow = 10 * time.Minute
+	// eventWindowCapacity is how many recent events are kept per counter key
+	eventWindowCapacity = 256
+	// eventWindowRetention bounds how old persisted counters may be when restored
+	eventWindowRetention = 24 * time.Hour
+)
+
+// EventWindowState persists the recent event timestamps of one counter key
+// so time_window counts survive brief restarts
+type EventWindowState struct {
+	Key        string      `json:"key" gorm:"primaryKey"`
+	Timestamps []time.Time `json:"timestamps" gorm:"serializer:json"`
+	UpdatedAt  time.Time   `json:"updated_at" gorm:"index"`
+}
+
+// eventKey identifies the counter for a rule and the resource an event is about
+func eventKey(ruleID string, event *RemediationEvent) string {
+	return strings.Join([]string{ruleID, event.ClusterID, event.Namespace, event.ResourceName}, "/")
+}
+
+// eventWindow is a sliding-window event counter. Each key keeps a ring buffer
+// of its most recent event times; counts only consider entries inside the
+// requested window, so old events age out without explicit cleanup.
+type eventWindow struct {
+	mu       sync.Mutex
+	capacity int
+	rings    map[string]*eventRing
+	dirty    map[string]bool
+	now      func() time.Time
+}
+
+type eventRing struct {
+	times []time.Time
+	next  int
+	size  int
+}
+
+func newEventWindow(capacity int) *eventWindow {
+	return &eventWindow{
+		capacity: capacity,
+		rings:    make(map[string]*eventRing),
+		dirty:    make(map[string]bool),
+		now:      time.Now,
+	}
+}
+
+// record adds an occurrence at ts (now if zero)
+func (w *eventWindow) record(key string, ts time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if ts.IsZero() {
+		ts = w.now()
+	}
+
+	ring, ok := w.rings[key]
+	if !ok {
+		ring = &eventRing{times: make([]time.Time, w.capacity)}
+		w.rings[key] = ring
+	}
+	ring.times[ring.next] = ts
+	ring.next = (ring.next + 1) % len(ring.times)
+	if ring.size < len(ring.times) {
+		ring.size++
+	}
+	w.dirty[key] = true
+}
+
+// count returns how many occurrences of key fall within the last window
+func (w *eventWindow) count(key string, window time.Duration) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ring, ok := w.rings[key]
+	if !ok {
+		return 0
+	}
+
+	cutoff := w.now().Add(-window)
+	n := 0
+	for _, ts := range ring.entries() {
+		if ts.After(cutoff) {
+			n++
+		}
+	}
+	return n
+}
+
+// reset forgets every occurrence of key
+func (w *eventWindow) reset(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.rings[key]; ok {
+		delete(w.rings, key)
+		w.dirty[key] = true
+	}
+}
+
+// restore replaces the occurrences of key, e.g. from persisted state
+func (w *eventWindow) restore(key string, times []time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ring := &eventRing{times: make([]time.Time, w.capacity)}
+	if len(times) > w.capacity {
+		times = times[len(times)-w.capacity:]
+	}
+	for _, ts := range times {
+		ring.times[ring.next] = ts
+		ring.next = (ring.next + 1) % len(ring.times)
+		ring.size++
+	}
+	w.rings[key] = ring
+}
+
+// takeDirty returns the keys changed since the last call with their current
+// occurrences; a nil slice means the key was reset
+func (w *eventWindow) takeDirty() map[string][]time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changed := make(map[string][]time.Time, len(w.dirty))
+	for key := range w.dirty {
+		if ring, ok := w.rings[key]; ok {
+			changed[key] = ring.entries()
+		} else {
+			changed[key] = nil
+		}
+	}
+	w.dirty = make(map[string]bool)
+	return changed
+}
+
+// entries returns the ring's timestamps, oldest first
+func (r *eventRing) entries() []time.Time {
+	out := make([]time.Time, 0, r.size)
+	start := (r.next - r.size + len(r.times)) % len(r.times)
+	for i := 0; i < r.size; i++ {
+		out = append(out, r.times[(start+i)%len(r.times)])
+	}
+	return out
+}
+
+// loadEventWindows restores persisted event counters and prunes stale ones
+func (s *Service) loadEventWindows() error {
+	cutoff := time.Now().Add(-eventWindowRetention)
+	if err := s.db.Where("updated_at < ?", cutoff).Delete(&EventWindowState{}).Error; err != nil {
+		return err
+	}
+
+	var states []EventWindowState
+	if err := s.db.Find(&states).Error; err != nil {
+		return err
+	}
+	for _, state := range states {
+		s.eventCounts.restore(state.Key, state.Timestamps)
+	}
+	return nil
+}
+
+// persistEventWindows periodically writes changed event counters to the
+// database, flushing once more on Stop
+func (s *Service) persistEventWindows() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flushEventWindows()
+		case <-s.stopCh:
+			s.flushEventWindows()
+			return
+		}
+	}
+}
+
+func (s *Service) flushEventWindows() {
+	for key, times := range s.eventCounts.takeDirty() {
+		var err error
+		if times == nil {
+			err = s.db.Delete(&EventWindowState{}, "key = ?", key).Error
+		} else {
+			err = s.db.Save(&EventWindowState{Key: key, Timestamps: times, UpdatedAt: time.Now()}).Error
+		}
+		if err != nil {
+			s.logger.Warn("Failed to persist event window", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// processActions claims queued actions from the database and executes them,
+// bounded by MaxConcurrentActions. Actions left running by a replica whose
+// lease expired (e.g. after a crash or restart) are claimed again, giving
+// at-least-once execution; executeAction skips steps already completed.
 func (s *Service) processActions() {
 	sem := make(chan struct{}, s.config.MaxConcurrentActions)
+	ticker := time.NewTicker(s.config.QueuePollInterval)
+	defer ticker.Stop()
 
 	for {
+		// Only this loop acquires slots, so free slots can't be taken from
+		// under us between the check and the send
+		for free := cap(sem) - len(sem); free > 0; free = cap(sem) - len(sem) {
+			actions := s.claimActions(free)
+			if len(actions) == 0 {
+				break
+			}
+			for _, action := range actions {
+				sem <- struct{}{}
+				go func(a *RemediationAction) {
+					defer func() {
+						<-sem
+						s.wake()
+					}()
+					s.runClaimedAction(a)
+				}(action)
+			}
+		}
+
 		select {
-		case action := <-s.actionQueue:
-			sem <- struct{}{}
-			go func(a *RemediationAction) {
-				defer func() { <-sem }()
-				s.executeAction(context.Background(), a)
-			}(action)
+		case <-s.wakeCh:
+		case <-ticker.C:
 		case <-s.stopCh:
 			return
 		}
 	}
 }
 
+// wake nudges processActions to poll the queue without waiting for the ticker
+func (s *Service) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// claimActions leases up to limit queued (or abandoned running) actions to
+// this instance. The conditional update makes the claim atomic, so two
+// replicas polling the same rows never both win.
+func (s *Service) claimActions(limit int) []*RemediationAction {
+	now := time.Now()
+	claimable := "status = ? OR (status = ? AND (lease_expires_at IS NULL OR lease_expires_at < ?))"
+
+	var candidates []RemediationAction
+	if err := s.db.Where(claimable, "queued", "running", now).
+		Order("created_at ASC").Limit(limit).Find(&candidates).Error; err != nil {
+		s.logger.Error("Failed to poll action queue", zap.Error(err))
+		return nil
+	}
+
+	var claimed []*RemediationAction
+	for i := range candidates {
+		action := &candidates[i]
+		leaseUntil := now.Add(s.config.ActionLeaseDuration)
+
+		result := s.db.Model(&RemediationAction{}).
+			Where("id = ? AND ("+claimable+")", action.ID, "queued", "running", now).
+			Updates(map[string]interface{}{
+				"status":           "running",
+				"claimed_by":       s.instanceID,
+				"lease_expires_at": leaseUntil,
+				"attempts":         gorm.Expr("attempts + 1"),
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+
+		if action.Status == "running" {
+			s.logger.Info("Resuming abandoned remediation action",
+				zap.String("action_id", action.ID),
+				zap.String("previous_owner", action.ClaimedBy),
+			)
+		}
+		action.Status = "running"
+		action.ClaimedBy = s.instanceID
+		action.LeaseExpiresAt = &leaseUntil
+		action.Attempts++
+		claimed = append(claimed, action)
+	}
+
+	return claimed
+}
+
+// runClaimedAction executes an action while keeping its lease alive
+func (s *Service) runClaimedAction(action *RemediationAction) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go s.renewLease(ctx, action.ID)
+	s.executeAction(ctx, action)
+}
+
+// renewLease extends the action's lease until ctx is cancelled
+func (s *Service) renewLease(ctx context.Context, actionID string) {
+	ticker := time.NewTicker(s.config.ActionLeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.db.Model(&RemediationAction{}).
+				Where("id = ? AND claimed_by = ?", actionID, s.instanceID).
+				Update("lease_expires_at", time.Now().Add(s.config.ActionLeaseDuration)).Error; err != nil {
+				s.logger.Warn("Failed to renew action lease", zap.String("action_id", actionID), zap.Error(err))
+			}
+		}
+	}
+}
+
 // executeAction executes a remediation action
 func (s *Service) executeAction(ctx context.Context, action *RemediationAction) {
 	s.logger.Info("Executing remediation action",
@@ -743,11 +1329,15 @@ func (s *Service) executeAction(ctx context.Context, action *RemediationAction)
 		zap.String("resource", action.ResourceName),
 	)
 
-	// Update status
+	// Update status. Only the columns we own are written so a concurrent
+	// lease renewal isn't overwritten; StartedAt is kept on resume because
+	// restart_pod uses it to detect work that already happened.
 	now := time.Now()
 	action.Status = "running"
-	action.StartedAt = &now
-	s.db.Save(action)
+	if action.StartedAt == nil {
+		action.StartedAt = &now
+	}
+	s.db.Model(action).Select("Status", "StartedAt").Updates(action)
 
 	// Get the rule
 	var rule RemediationRule
@@ -758,7 +1348,20 @@ func (s *Service) executeAction(ctx context.Context, action *RemediationAction)
 
 	// Execute each action in order
 	var lastError error
-	for _, ruleAction := range rule.Actions {
+	for step, ruleAction := range rule.Actions {
+		if action.stepCompleted(step) {
+			s.logger.Info("Skipping step completed by a previous attempt",
+				zap.String("action_id", action.ID),
+				zap.Int("step", step),
+				zap.String("type", ruleAction.Type),
+			)
+			continue
+		}
+		if action.stepFailed(step) {
+			lastError = fmt.Errorf("step %d (%s) failed in a previous attempt", step, ruleAction.Type)
+			continue
+		}
+
 		if action.DryRun {
 			s.logger.Info("DRY RUN: Would execute action",
 				zap.String("type", ruleAction.Type),
@@ -767,6 +1370,7 @@ func (s *Service) executeAction(ctx context.Context, action *RemediationAction)
 			continue
 		}
 
+		ruleAction.Parameters = renderParameters(ruleAction.Parameters, action.triggerEvent())
 		err := s.executeRuleAction(ctx, action, ruleAction)
 		if err != nil {
 			lastError = err
@@ -794,6 +1398,15 @@ func (s *Service) executeAction(ctx context.Context, action *RemediationAction)
 				// Continue to next action
 			}
 		}
+
+		if err != nil {
+			action.FailedSteps = append(action.FailedSteps, step)
+			s.db.Model(action).Select("FailedSteps").Updates(action)
+			continue
+		}
+
+		action.CompletedSteps = append(action.CompletedSteps, step)
+		s.db.Model(action).Select("CompletedSteps").Updates(action)
 	}
 
 	// Update rule execution tracking
@@ -809,6 +1422,43 @@ func (s *Service) executeAction(ctx context.Context, action *RemediationAction)
 	}
 }
 
+// triggerEvent rebuilds the event that created the action, for rendering
+// its rule's parameters
+func (a *RemediationAction) triggerEvent() *RemediationEvent {
+	event := &RemediationEvent{
+		ClusterID:    a.ClusterID,
+		Namespace:    a.Namespace,
+		ResourceType: a.ResourceType,
+		ResourceName: a.ResourceName,
+	}
+	event.Type, _ = a.TriggerEvent["type"].(string)
+	event.Source, _ = a.TriggerEvent["source"].(string)
+	event.Reason, _ = a.TriggerEvent["reason"].(string)
+	event.Message, _ = a.TriggerEvent["message"].(string)
+	event.Data, _ = a.TriggerEvent["data"].(map[string]interface{})
+	return event
+}
+
+// stepCompleted reports whether rule action index step already ran
+func (a *RemediationAction) stepCompleted(step int) bool {
+	return containsStep(a.CompletedSteps, step)
+}
+
+// stepFailed reports whether rule action index step already ran and failed
+// with on_failure continue
+func (a *RemediationAction) stepFailed(step int) bool {
+	return containsStep(a.FailedSteps, step)
+}
+
+func containsStep(steps []int, step int) bool {
+	for _, s := range steps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Service) executeRuleAction(ctx context.Context, action *RemediationAction, ruleAction RuleAction) error {
 	s.clientsMu.RLock()
 	client, ok := s.k8sClients[action.ClusterID]
@@ -826,7 +1476,7 @@ func (s *Service) executeRuleAction(ctx context.Context, action *RemediationActi
 	case "scale":
 		return s.scaleResource(ctx, client, action, ruleAction.Parameters)
 	case "patch":
-		return s.patchResource(ctx, client, action, ruleAction.Parameters)
+		return s.patchResource(ctx, client, action, ruleAction.Target, ruleAction.Parameters)
 	case "cordon":
 		return s.cordonNode(ctx, client, action, ruleAction.Parameters)
 	case "drain":
@@ -834,7 +1484,7 @@ func (s *Service) executeRuleAction(ctx context.Context, action *RemediationActi
 	case "exec":
 		return s.execInPod(ctx, client, action, ruleAction.Parameters)
 	case "notify":
-		return s.sendNotification(ctx, action, ruleAction.Parameters)
+		return s.sendNotification(ctx, action, ruleAction.Target, ruleAction.Parameters)
 	case "webhook":
 		return s.callWebhook(ctx, action, ruleAction.Parameters)
 	default:
@@ -848,7 +1498,20 @@ func (s *Service) restartPod(ctx context.Context, client kubernetes.Interface, a
 		gracePeriod = int64(gp)
 	}
 
-	err := client.CoreV1().Pods(action.Namespace).Delete(ctx, action.ResourceName, metav1.DeleteOptions{
+	// A resumed action may already have restarted the pod before the
+	// previous owner died: the pod is gone (controller pods come back under
+	// a new name) or was recreated after the action started.
+	pod, err := client.CoreV1().Pods(action.Namespace).Get(ctx, action.ResourceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.logger.Info("Pod already gone, treating restart as done", zap.String("pod", action.ResourceName))
+		return nil
+	}
+	if err == nil && action.StartedAt != nil && pod.CreationTimestamp.Time.After(*action.StartedAt) {
+		s.logger.Info("Pod already restarted, skipping", zap.String("pod", action.ResourceName))
+		return nil
+	}
+
+	err = client.CoreV1().Pods(action.Namespace).Delete(ctx, action.ResourceName, metav1.DeleteOptions{
 		GracePeriodSeconds: &gracePeriod,
 	})
 	if err != nil {
@@ -904,37 +1567,6 @@ func (s *Service) scaleResource(ctx context.Context, client kubernetes.Interface
 	return nil
 }
 
-func (s *Service) patchResource(ctx context.Context, client kubernetes.Interface, action *RemediationAction, params map[string]interface{}) error {
-	// Build JSON patch
-	patch := []map[string]interface{}{
-		{
-			"op":    "replace",
-			"path":  params["path"],
-			"value": params["value"],
-		},
-	}
-
-	patchBytes, err := json.Marshal(patch)
-	if err != nil {
-		return fmt.Errorf("failed to marshal patch: %w", err)
-	}
-
-	switch action.ResourceType {
-	case "deployment", "Deployment":
-		_, err = client.AppsV1().Deployments(action.Namespace).Patch(
-			ctx,
-			action.ResourceName,
-			"application/json-patch+json",
-			patchBytes,
-			metav1.PatchOptions{},
-		)
-	default:
-		return fmt.Errorf("unsupported resource type for patch: %s", action.ResourceType)
-	}
-
-	return err
-}
-
 func (s *Service) cordonNode(ctx context.Context, client kubernetes.Interface, action *RemediationAction, params map[string]interface{}) error {
 	node, err := client.CoreV1().Nodes().Get(ctx, action.ResourceName, metav1.GetOptions{})
 	if err != nil {
@@ -951,12 +1583,32 @@ func (s *Service) cordonNode(ctx context.Context, client kubernetes.Interface, a
 	return nil
 }
 
+// drainNode cordons the node and evicts its pods through the policy/v1
+// Eviction API so PodDisruptionBudgets are honoured. Parameters:
+// timeout (seconds or duration string, default 5m), max_concurrent (default 5),
+// grace_period (seconds) and force (also evict pods using emptyDir storage).
 func (s *Service) drainNode(ctx context.Context, client kubernetes.Interface, action *RemediationAction, params map[string]interface{}) error {
 	// First cordon the node
 	if err := s.cordonNode(ctx, client, action, params); err != nil {
 		return err
 	}
 
+	timeout := durationParam(params, "timeout", 5*time.Minute)
+	maxConcurrent := 5
+	if mc, ok := params["max_concurrent"].(float64); ok && mc >= 1 {
+		maxConcurrent = int(mc)
+	}
+	force, _ := params["force"].(bool)
+
+	var deleteOptions metav1.DeleteOptions
+	if gp, ok := params["grace_period"].(float64); ok {
+		gracePeriod := int64(gp)
+		deleteOptions.GracePeriodSeconds = &gracePeriod
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	// List pods on the node
 	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
 		FieldSelector: fmt.Sprintf("spec.nodeName=%s", action.ResourceName),
@@ -965,100 +1617,161 @@ func (s *Service) drainNode(ctx context.Context, client kubernetes.Interface, ac
 		return fmt.Errorf("failed to list pods: %w", err)
 	}
 
-	// Evict pods
-	for _, pod := range pods.Items {
-		// Skip daemonset pods
-		if isControlledByDaemonSet(&pod) {
+	summary := &drainSummary{}
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if reason := drainSkipReason(pod, force); reason != "" {
+			summary.skip(pod, reason)
 			continue
 		}
 
-		eviction := &corev1.Pod{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      pod.Name,
-				Namespace: pod.Namespace,
-			},
-		}
-		_ = eviction // Would use policy/v1 Eviction
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := evictPod(ctx, client, pod, deleteOptions); err != nil {
+				s.logger.Warn("Failed to evict pod",
+					zap.String("pod", pod.Name),
+					zap.String("namespace", pod.Namespace),
+					zap.Error(err),
+				)
+				summary.fail(pod, err)
+				return
+			}
+			summary.evict(pod)
+		}()
+	}
+	wg.Wait()
 
-		err := client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
-		if err != nil {
-			s.logger.Warn("Failed to evict pod",
-				zap.String("pod", pod.Name),
-				zap.Error(err),
-			)
-		}
+	if action.Result == nil {
+		action.Result = make(map[string]interface{})
 	}
+	action.Result["drain"] = summary.result()
+
+	s.logger.Info("Node drained",
+		zap.String("node", action.ResourceName),
+		zap.Int("evicted", len(summary.evicted)),
+		zap.Int("skipped", len(summary.skipped)),
+		zap.Int("failed", len(summary.failed)),
+	)
 
-	s.logger.Info("Node drained", zap.String("node", action.ResourceName))
+	if len(summary.failed) > 0 {
+		return fmt.Errorf("failed to evict %d of %d pods from node %s",
+			len(summary.failed), len(summary.failed)+len(summary.evicted), action.ResourceName)
+	}
 	return nil
 }
 
-func isControlledByDaemonSet(pod *corev1.Pod) bool {
-	for _, ref := range pod.OwnerReferences {
-		if ref.Kind == "DaemonSet" {
-			return true
-		}
-	}
-	return false
+// drainSummary collects per-pod drain outcomes for the action result
+type drainSummary struct {
+	mu      sync.Mutex
+	evicted []string
+	skipped map[string]string
+	failed  map[string]string
 }
 
-func (s *Service) execInPod(ctx context.Context, client kubernetes.Interface, action *RemediationAction, params map[string]interface{}) error {
-	// This would execute a command in a pod
-	// Simplified implementation
-	s.logger.Info("Would execute command in pod",
-		zap.String("pod", action.ResourceName),
-		zap.Any("command", params["command"]),
-	)
-	return nil
+func (d *drainSummary) evict(pod *corev1.Pod) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.evicted = append(d.evicted, pod.Namespace+"/"+pod.Name)
 }
 
-func (s *Service) sendNotification(ctx context.Context, action *RemediationAction, params map[string]interface{}) error {
-	target, _ := params["target"].(string)
-	message, _ := params["message"].(string)
+func (d *drainSummary) skip(pod *corev1.Pod, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.skipped == nil {
+		d.skipped = make(map[string]string)
+	}
+	d.skipped[pod.Namespace+"/"+pod.Name] = reason
+}
 
-	switch target {
-	case "slack":
-		if s.config.EnableSlack && s.config.SlackWebhook != "" {
-			// Send Slack notification
-			s.logger.Info("Would send Slack notification", zap.String("message", message))
-		}
-	default:
-		s.logger.Info("Notification sent",
-			zap.String("target", target),
-			zap.String("message", message),
-		)
+func (d *drainSummary) fail(pod *corev1.Pod, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failed == nil {
+		d.failed = make(map[string]string)
 	}
+	d.failed[pod.Namespace+"/"+pod.Name] = err.Error()
+}
 
-	return nil
+func (d *drainSummary) result() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sort.Strings(d.evicted)
+	return map[string]interface{}{
+		"evicted": d.evicted,
+		"skipped": d.skipped,
+		"failed":  d.failed,
+	}
 }
 
-func (s *Service) callWebhook(ctx context.Context, action *RemediationAction, params map[string]interface{}) error {
-	if !s.config.EnableWebhooks {
-		return nil
+// drainSkipReason returns why a pod must not be evicted, or "" if it can be
+func drainSkipReason(pod *corev1.Pod, force bool) string {
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return "mirror pod"
+	}
+	if isControlledByDaemonSet(pod) {
+		return "managed by DaemonSet"
 	}
+	if !force && hasLocalStorage(pod) {
+		return "uses local storage (set force to evict)"
+	}
+	return ""
+}
 
-	url, _ := params["url"].(string)
-	if url == "" {
-		url = s.config.WebhookURL
+func hasLocalStorage(pod *corev1.Pod) bool {
+	for _, v := range pod.Spec.Volumes {
+		if v.EmptyDir != nil {
+			return true
+		}
 	}
+	return false
+}
 
-	if url == "" {
-		return nil
+// evictPod requests eviction of a pod, retrying with backoff while a
+// PodDisruptionBudget temporarily forbids it (429) until ctx expires
+func evictPod(ctx context.Context, client kubernetes.Interface, pod *corev1.Pod, deleteOptions metav1.DeleteOptions) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &deleteOptions,
 	}
 
-	payload := map[string]interface{}{
-		"action_id":     action.ID,
-		"rule_name":     action.RuleName,
-		"resource_type": action.ResourceType,
-		"resource_name": action.ResourceName,
-		"namespace":     action.Namespace,
-		"cluster_id":    action.ClusterID,
-		"parameters":    params,
-		"timestamp":     time.Now(),
+	backoff := time.Second
+	for {
+		err := client.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil, apierrors.IsNotFound(err):
+			return nil
+		case !apierrors.IsTooManyRequests(err):
+			return fmt.Errorf("eviction failed: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("eviction blocked by disruption budget: %w", err)
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
 	}
+}
 
-	s.logger.Info("Would call webhook", zap.String("url", url), zap.Any("payload", payload))
-	return nil
+func isControlledByDaemonSet(pod *corev1.Pod) bool {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *Service) completeAction(action *RemediationAction, status string, err error, result map[string]interface{}) {
@@ -1072,10 +1785,18 @@ func (s *Service) completeAction(action *RemediationAction, status string, err e
 		action.Error = err.Error()
 	}
 	if result != nil {
-		action.Result = result
+		if action.Result == nil {
+			action.Result = make(map[string]interface{}, len(result))
+		}
+		for k, v := range result {
+			action.Result[k] = v
+		}
 	}
+	action.ClaimedBy = ""
+	action.LeaseExpiresAt = nil
 
 	s.db.Save(action)
+	metrics.RemediationActions.WithLabelValues(status).Inc()
 
 	s.logger.Info("Action completed",
 		zap.String("action_id", action.ID),
@@ -1101,22 +1822,20 @@ func (s *Service) ApproveAction(ctx context.Context, actionID, approverID string
 		return fmt.Errorf("action not found: %w", err)
 	}
 
-	if action.Status != "pending_approval" {
-		return fmt.Errorf("action is not pending approval")
+	if err := s.checkApprovable(ctx, &action); err != nil {
+		return err
 	}
 
 	now := time.Now()
 	action.Status = "queued"
 	action.ApprovedBy = approverID
 	action.ApprovedAt = &now
-	s.db.Save(&action)
+	if err := s.db.Save(&action).Error; err != nil {
+		return fmt.Errorf("failed to approve action: %w", err)
+	}
 
 	// Queue for execution
-	select {
-	case s.actionQueue <- &action:
-	default:
-		return fmt.Errorf("action queue full")
-	}
+	s.wake()
 
 	return nil
 }
@@ -1128,8 +1847,8 @@ func (s *Service) RejectAction(ctx context.Context, actionID, rejectorID, reason
 		return fmt.Errorf("action not found: %w", err)
 	}
 
-	if action.Status != "pending_approval" {
-		return fmt.Errorf("action is not pending approval")
+	if err := s.checkApprovable(ctx, &action); err != nil {
+		return err
 	}
 
 	action.Status = "rejected"
@@ -1138,46 +1857,144 @@ func (s *Service) RejectAction(ctx context.Context, actionID, rejectorID, reason
 		"reason":      reason,
 	}
 	s.db.Save(&action)
+	metrics.RemediationActions.WithLabelValues(action.Status).Inc()
+
+	return nil
+}
+
+// actionTypes are the rule action types executeRuleAction knows how to run
+var actionTypes = map[string]bool{
+	"restart_pod": true,
+	"delete":      true,
+	"scale":       true,
+	"patch":       true,
+	"cordon":      true,
+	"drain":       true,
+	"exec":        true,
+	"notify":      true,
+	"webhook":     true,
+}
 
+// validateRule rejects rules that can never fire or act, so a typo in a cron
+// expression, query or action type fails when the rule is saved instead of
+// silently doing nothing
+func validateRule(rule *RemediationRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+
+	switch rule.Trigger.Type {
+	case "event", "alert":
+	case "schedule":
+		if rule.Trigger.Schedule == "" {
+			return fmt.Errorf("schedule trigger requires a cron expression")
+		}
+		if _, err := cron.ParseStandard(rule.Trigger.Schedule); err != nil {
+			return fmt.Errorf("invalid cron schedule %q: %w", rule.Trigger.Schedule, err)
+		}
+	case "metric":
+		if err := validateQuery(rule.Trigger.Query); err != nil {
+			return fmt.Errorf("invalid metric query %q: %w", rule.Trigger.Query, err)
+		}
+	default:
+		return fmt.Errorf("unknown trigger type: %q", rule.Trigger.Type)
+	}
+
+	for _, action := range rule.Actions {
+		if !actionTypes[action.Type] {
+			return fmt.Errorf("unknown action type: %q", action.Type)
+		}
+	}
+	return nil
+}
+
+// validateQuery catches malformed PromQL that Prometheus would reject on
+// every evaluation: empty queries and unbalanced brackets or quotes
+func validateQuery(query string) error {
+	if strings.TrimSpace(query) == "" {
+		return fmt.Errorf("query is empty")
+	}
+
+	pairs := map[rune]rune{')': '(', ']': '[', '}': '{'}
+	var stack []rune
+	var quote rune
+	for _, r := range query {
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch r {
+		case '"', '\'', '`':
+			quote = r
+		case '(', '[', '{':
+			stack = append(stack, r)
+		case ')', ']', '}':
+			if len(stack) == 0 || stack[len(stack)-1] != pairs[r] {
+				return fmt.Errorf("unbalanced %q", r)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated string")
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("unclosed %q", stack[len(stack)-1])
+	}
 	return nil
 }
 
 // CreateRule creates a new remediation rule
 func (s *Service) CreateRule(ctx context.Context, rule *RemediationRule) error {
+	if err := validateRule(rule); err != nil {
+		return err
+	}
+
 	rule.ID = uuid.New().String()
 	rule.CreatedAt = time.Now()
 	rule.UpdatedAt = time.Now()
 
-	if err := s.db.Create(rule).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(rule).Error; err != nil {
+			return err
+		}
+		return s.recordRuleVersion(ctx, tx, rule, "created")
+	})
+	if err != nil {
 		return fmt.Errorf("failed to create rule: %w", err)
 	}
 
-	// Add to in-memory rules
-	s.rulesMu.Lock()
-	s.rules[rule.ID] = rule
-	s.rulesMu.Unlock()
-
-	return nil
+	return s.applyRuleChange(ctx, rule)
 }
 
 // UpdateRule updates a remediation rule
 func (s *Service) UpdateRule(ctx context.Context, rule *RemediationRule) error {
+	if err := validateRule(rule); err != nil {
+		return err
+	}
+
 	rule.UpdatedAt = time.Now()
 
-	if err := s.db.Save(rule).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var previous RemediationRule
+		if err := tx.First(&previous, "id = ?", rule.ID).Error; err != nil {
+			return err
+		}
+		if err := s.ensureBaselineVersion(tx, &previous); err != nil {
+			return err
+		}
+		if err := tx.Save(rule).Error; err != nil {
+			return err
+		}
+		return s.recordRuleVersion(ctx, tx, rule, diffRules(&previous, rule))
+	})
+	if err != nil {
 		return fmt.Errorf("failed to update rule: %w", err)
 	}
 
-	// Update in-memory rules
-	s.rulesMu.Lock()
-	if rule.Enabled {
-		s.rules[rule.ID] = rule
-	} else {
-		delete(s.rules, rule.ID)
-	}
-	s.rulesMu.Unlock()
-
-	return nil
+	return s.applyRuleChange(ctx, rule)
 }
 
 // DeleteRule deletes a remediation rule
@@ -1190,6 +2007,9 @@ func (s *Service) DeleteRule(ctx context.Context, ruleID string) error {
 	delete(s.rules, ruleID)
 	s.rulesMu.Unlock()
 
+	s.unscheduleRule(ruleID)
+	s.broadcastRuleChange(ctx, ruleID)
+
 	return nil
 }
 
@@ -1239,14 +2059,171 @@ func (s *Service) ListActions(ctx context.Context, filter map[string]interface{}
 
 	query.Count(&total)
 
-	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&actions).Error; err != nil {
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&actions).Error; err != nil {
 		return nil, 0, fmt.Errorf("failed to list actions: %w", err)
 	}
 
 	return actions, total, nil
 }
 
+// ListActionsAfter lists the page of actions matching filter after cursor,
+// newest first, and returns the cursor for the next page, empty on the
+// last. An empty cursor starts from the newest.
+func (s *Service) ListActionsAfter(ctx context.Context, filter map[string]interface{}, cursor string, limit int) ([]RemediationAction, string, error) {
+	after, err := database.DecodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	query := s.db.Model(&RemediationAction{})
+	for _, column := range []string{"status", "rule_id", "cluster_id"} {
+		if value, ok := filter[column]; ok {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if !after.IsZero() {
+		query = query.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.ID)
+	}
+
+	var actions []RemediationAction
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&actions).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to list actions: %w", err)
+	}
+	actions, next := database.KeysetPage(actions, limit, func(a *RemediationAction) database.Cursor {
+		return database.Cursor{CreatedAt: a.CreatedAt, ID: a.ID}
+	})
+	return actions, next, nil
+}
+
+// SimulatedAction describes what a rule would have done for one event
+type SimulatedAction struct {
+	EventID          string            `json:"event_id"`
+	EventReason      string            `json:"event_reason"`
+	ResourceName     string            `json:"resource_name"`
+	Fired            bool              `json:"fired"`
+	TriggerMatched   bool              `json:"trigger_matched"`
+	ScopeMatched     bool              `json:"scope_matched"`
+	InCooldown       bool              `json:"in_cooldown"`
+	Conditions       []ConditionResult `json:"conditions"`
+	Actions          []PlannedAction   `json:"actions,omitempty"`
+	RequiresApproval bool              `json:"requires_approval"`
+	Reason           string            `json:"reason"`
+}
+
+// PlannedAction is a rule action with its parameters rendered for an event
+type PlannedAction struct {
+	Type       string                 `json:"type"`
+	Target     string                 `json:"target"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Order      int                    `json:"order"`
+	OnFailure  string                 `json:"on_failure"`
+}
+
+// SimulateRule evaluates a rule against the given events without executing
+// anything. Unlike the global DryRun flag it works on a single rule, enabled
+// or not, and reports per-condition results so a non-matching rule can be
+// debugged. No actions are persisted and Kubernetes is never called.
+func (s *Service) SimulateRule(ctx context.Context, ruleID string, events []RemediationEvent) ([]SimulatedAction, error) {
+	rule, err := s.GetRule(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SimulatedAction, 0, len(events))
+	for i := range events {
+		event := &events[i]
+		sim := SimulatedAction{
+			EventID:          event.ID,
+			EventReason:      event.Reason,
+			ResourceName:     event.ResourceName,
+			TriggerMatched:   s.matchTrigger(rule, event),
+			ScopeMatched:     s.matchScope(rule.Scope, event),
+			InCooldown:       !s.checkCooldown(rule),
+			RequiresApproval: s.requiresApproval(rule),
+		}
+
+		conditionsPassed := true
+		for _, condition := range rule.Conditions {
+			result := s.checkCondition(ctx, rule, condition, event)
+			if !result.Passed {
+				conditionsPassed = false
+			}
+			sim.Conditions = append(sim.Conditions, result)
+		}
+
+		switch {
+		case !sim.TriggerMatched:
+			sim.Reason = "event does not match the rule trigger"
+		case !sim.ScopeMatched:
+			sim.Reason = "event is outside the rule scope"
+		case !conditionsPassed:
+			sim.Reason = "one or more conditions failed"
+		case sim.InCooldown:
+			sim.Reason = "rule is in its cooldown period"
+		case len(rule.Actions) == 0:
+			sim.Reason = "rule has no actions"
+		default:
+			sim.Fired = true
+			sim.Reason = "rule would fire"
+			for _, ruleAction := range rule.Actions {
+				sim.Actions = append(sim.Actions, PlannedAction{
+					Type:       ruleAction.Type,
+					Target:     renderTemplate(ruleAction.Target, event),
+					Parameters: renderParameters(ruleAction.Parameters, event),
+					Order:      ruleAction.Order,
+					OnFailure:  ruleAction.OnFailure,
+				})
+			}
+			sort.SliceStable(sim.Actions, func(a, b int) bool {
+				return sim.Actions[a].Order < sim.Actions[b].Order
+			})
+		}
+
+		results = append(results, sim)
+	}
+
+	return results, nil
+}
+
+// renderParameters renders templated string values (e.g. "{{ .ResourceName }}")
+// in action parameters against an event
+func renderParameters(params map[string]interface{}, event *RemediationEvent) map[string]interface{} {
+	rendered := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if str, ok := v.(string); ok {
+			rendered[k] = renderTemplate(str, event)
+			continue
+		}
+		rendered[k] = v
+	}
+	return rendered
+}
+
+// renderTemplate executes a Go template against an event or action, returning
+// the input unchanged if it is not a valid template
+func renderTemplate(text string, data interface{}) string {
+	if !strings.Contains(text, "{{") {
+		return text
+	}
+
+	tmpl, err := template.New("param").Parse(text)
+	if err != nil {
+		return text
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return text
+	}
+	return buf.String()
+}
+
 // Stop stops the remediation service
 func (s *Service) Stop() {
+	s.cron.Stop()
+	s.stopWatchers()
 	close(s.stopCh)
 }
@@ -0,0 +1,299 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeClock lets tests move the event window's notion of now
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newWindowTestService(clock *fakeClock) *Service {
+	counts := newEventWindow(eventWindowCapacity)
+	counts.now = clock.now
+	return &Service{
+		logger:      zap.NewNop(),
+		config:      &Config{DefaultCooldown: time.Minute},
+		eventCounts: counts,
+	}
+}
+
+func TestTimeWindowFiresOnNthEvent(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	svc := newWindowTestService(clock)
+
+	rule := &RemediationRule{
+		ID:         "rule-oom",
+		Trigger:    RuleTrigger{Type: "event", Duration: 10 * time.Minute},
+		Conditions: []RuleCondition{{Type: "time_window", Field: "count", Operator: "gte", Value: "3"}},
+	}
+	event := &RemediationEvent{ClusterID: "c1", Namespace: "default", ResourceName: "api"}
+	key := eventKey(rule.ID, event)
+
+	for i := 1; i <= 3; i++ {
+		svc.eventCounts.record(key, clock.now())
+		fired := svc.evaluateConditions(context.Background(), rule, event)
+		assert.Equal(t, i == 3, fired, "event %d", i)
+		clock.t = clock.t.Add(time.Minute)
+	}
+}
+
+func TestTimeWindowResetsAfterWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	svc := newWindowTestService(clock)
+
+	rule := &RemediationRule{
+		ID:         "rule-oom",
+		Trigger:    RuleTrigger{Type: "event", Duration: 10 * time.Minute},
+		Conditions: []RuleCondition{{Type: "time_window", Field: "count", Operator: "gte", Value: "3"}},
+	}
+	event := &RemediationEvent{ClusterID: "c1", Namespace: "default", ResourceName: "api"}
+	key := eventKey(rule.ID, event)
+
+	svc.eventCounts.record(key, clock.now())
+	svc.eventCounts.record(key, clock.now())
+
+	// The first two events age out before the third arrives
+	clock.t = clock.t.Add(11 * time.Minute)
+	svc.eventCounts.record(key, clock.now())
+	assert.False(t, svc.evaluateConditions(context.Background(), rule, event))
+	assert.Equal(t, 1, svc.eventCounts.count(key, 10*time.Minute))
+
+	// A different resource has its own counter
+	other := &RemediationEvent{ClusterID: "c1", Namespace: "default", ResourceName: "worker"}
+	assert.Equal(t, 0, svc.eventCounts.count(eventKey(rule.ID, other), 10*time.Minute))
+}
+
+func TestEventWindowRingWraps(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	w := newEventWindow(3)
+	w.now = clock.now
+
+	for i := 0; i < 5; i++ {
+		w.record("k", clock.now())
+		clock.t = clock.t.Add(time.Second)
+	}
+	assert.Equal(t, 3, w.count("k", time.Hour))
+
+	dirty := w.takeDirty()
+	assert.Len(t, dirty["k"], 3)
+	assert.True(t, dirty["k"][0].Before(dirty["k"][2]))
+
+	w.reset("k")
+	assert.Equal(t, 0, w.count("k", time.Hour))
+	dirty = w.takeDirty()
+	assert.Contains(t, dirty, "k")
+	assert.Nil(t, dirty["k"])
+}
+
+func TestListActionsAfter(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	svc := newApprovalTestService(t, clock)
+	ctx := context.Background()
+	created := 0
+	addAction := func(clusterID string) {
+		// Actions come in pairs sharing a timestamp
+		require.NoError(t, svc.db.Create(&RemediationAction{
+			ID: fmt.Sprintf("a-%02d", created), ClusterID: clusterID, Status: "completed",
+			CreatedAt: clock.t.Add(time.Duration(created/2) * time.Minute),
+		}).Error)
+		created++
+	}
+	for i := 0; i < 9; i++ {
+		cluster := "prod"
+		if i%3 == 2 {
+			cluster = "staging"
+		}
+		addAction(cluster)
+	}
+
+	actionIDs := func(actions []RemediationAction) []string {
+		ids := make([]string, len(actions))
+		for i, a := range actions {
+			ids[i] = a.ID
+		}
+		return ids
+	}
+	filter := map[string]interface{}{"cluster_id": "prod"}
+
+	var offset []string
+	for page := 0; page < 3; page++ {
+		actions, total, err := svc.ListActions(ctx, filter, 2, page*2)
+		require.NoError(t, err)
+		assert.EqualValues(t, 6, total)
+		offset = append(offset, actionIDs(actions)...)
+	}
+
+	// Actions created while paging shift nothing
+	var keyset []string
+	cursor := ""
+	for {
+		actions, next, err := svc.ListActionsAfter(ctx, filter, cursor, 2)
+		require.NoError(t, err)
+		keyset = append(keyset, actionIDs(actions)...)
+		if next == "" {
+			break
+		}
+		cursor = next
+		addAction("prod")
+	}
+	assert.Equal(t, []string{"a-07", "a-06", "a-04", "a-03", "a-01", "a-00"}, keyset)
+	assert.Equal(t, offset, keyset)
+
+	_, _, err := svc.ListActionsAfter(ctx, nil, "bm9wZQ", 2)
+	assert.Error(t, err)
+}
+
+func TestSetModePausesRulesAndSwitchesDryRun(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{DisableWorkers: true})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	process := func() {
+		require.NoError(t, svc.ProcessEvent(ctx, &RemediationEvent{
+			Type:         RightsizingEventType,
+			Source:       "cost",
+			ClusterID:    "prod",
+			Namespace:    "payments",
+			ResourceType: "Deployment",
+			ResourceName: "api",
+			Reason:       "rightsizing",
+			Data:         map[string]interface{}{"container_index": "0", "recommended_cpu_request": "1"},
+		}))
+	}
+
+	svc.SetMode(false, false)
+	process()
+	var actions []RemediationAction
+	require.NoError(t, db.Find(&actions).Error)
+	assert.Empty(t, actions, "a paused service ignores events")
+
+	svc.SetMode(true, true)
+	process()
+	require.NoError(t, db.Find(&actions).Error)
+	require.Len(t, actions, 1)
+	assert.True(t, actions[0].DryRun)
+}
+
+func TestContinuedStepRecordedAsFailed(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{DisableWorkers: true})
+	require.NoError(t, err)
+	svc.RegisterK8sClient("prod", fake.NewSimpleClientset(), nil)
+
+	rule := &RemediationRule{
+		ID:      uuid.NewString(),
+		Name:    "best-effort",
+		Trigger: RuleTrigger{Type: "event"},
+		Actions: []RuleAction{
+			{Type: "unsupported", Order: 1, OnFailure: "continue"},
+			{Type: "notify", Target: "slack", Order: 2, OnFailure: "abort"},
+		},
+	}
+	require.NoError(t, db.Create(rule).Error)
+	action := &RemediationAction{ID: uuid.NewString(), RuleID: rule.ID, ClusterID: "prod", Status: "approved"}
+	require.NoError(t, db.Create(action).Error)
+
+	ctx := context.Background()
+	svc.executeAction(ctx, action)
+	assert.Equal(t, "completed_with_errors", action.Status)
+	assert.Equal(t, []int{0}, action.FailedSteps)
+	assert.Equal(t, []int{1}, action.CompletedSteps)
+
+	// A resumed attempt skips the failed step but still reports it
+	var stored RemediationAction
+	require.NoError(t, db.First(&stored, "id = ?", action.ID).Error)
+	assert.Equal(t, []int{0}, stored.FailedSteps)
+	svc.executeAction(ctx, &stored)
+	assert.Equal(t, "completed_with_errors", stored.Status)
+	assert.Equal(t, []int{1}, stored.CompletedSteps)
+}
+
+func TestSimulateRuleReportsConditionsWithoutCallingKubernetes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{DisableWorkers: true})
+	require.NoError(t, err)
+	client := fake.NewSimpleClientset()
+	svc.RegisterK8sClient("prod", client, nil)
+
+	ctx := context.Background()
+	rule := &RemediationRule{
+		Name:    "restart-crashlooping",
+		Enabled: false,
+		Trigger: RuleTrigger{Type: "event", EventTypes: []string{"Warning"}, Filters: map[string]interface{}{"reason": "BackOff"}},
+		Scope:   RuleScope{Namespaces: []string{"shop"}},
+		Conditions: []RuleCondition{
+			{Type: "label", Field: "app", Operator: "eq", Value: "web"},
+			{Type: "resource_status", Field: "restart_count", Operator: "gte", Value: "3"},
+		},
+		Actions: []RuleAction{
+			{Type: "restart_pod", Target: "{{ .ResourceName }}", Order: 2, OnFailure: "abort",
+				Parameters: map[string]interface{}{"namespace": "{{ .Namespace }}", "grace_period": 30}},
+			{Type: "notify", Target: "slack", Order: 1, OnFailure: "continue"},
+		},
+	}
+	require.NoError(t, svc.CreateRule(ctx, rule))
+
+	event := func(id, namespace, app string, restarts int) RemediationEvent {
+		return RemediationEvent{
+			ID: id, Type: "Warning", Reason: "BackOff", ClusterID: "prod", Namespace: namespace,
+			ResourceType: "pod", ResourceName: "web-0",
+			Labels: map[string]string{"app": app}, Data: map[string]interface{}{"restart_count": restarts},
+		}
+	}
+	results, err := svc.SimulateRule(ctx, rule.ID, []RemediationEvent{
+		event("fires", "shop", "web", 5),
+		event("wrong-app", "shop", "api", 5),
+		event("outside", "ops", "web", 5),
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	// A disabled rule is still simulated; its actions are planned in order
+	// with their parameters rendered for the event
+	fired := results[0]
+	assert.True(t, fired.Fired, fired.Reason)
+	require.Len(t, fired.Conditions, 2)
+	assert.Equal(t, ConditionResult{Type: "label", Field: "app", Operator: "eq", Expected: "web", Actual: "web", Passed: true}, fired.Conditions[0])
+	assert.Equal(t, "5", fired.Conditions[1].Actual)
+	assert.True(t, fired.Conditions[1].Passed)
+	require.Len(t, fired.Actions, 2)
+	assert.Equal(t, "notify", fired.Actions[0].Type)
+	assert.Equal(t, "restart_pod", fired.Actions[1].Type)
+	assert.Equal(t, "web-0", fired.Actions[1].Target)
+	assert.Equal(t, map[string]interface{}{"namespace": "shop", "grace_period": float64(30)}, fired.Actions[1].Parameters)
+
+	// A failing condition is reported with the value it compared
+	wrongApp := results[1]
+	assert.False(t, wrongApp.Fired)
+	assert.Equal(t, "one or more conditions failed", wrongApp.Reason)
+	assert.Equal(t, "api", wrongApp.Conditions[0].Actual)
+	assert.False(t, wrongApp.Conditions[0].Passed)
+	assert.True(t, wrongApp.Conditions[1].Passed)
+	assert.Empty(t, wrongApp.Actions)
+
+	assert.False(t, results[2].ScopeMatched)
+	assert.Equal(t, "event is outside the rule scope", results[2].Reason)
+
+	// Nothing was executed or recorded
+	assert.Empty(t, client.Actions())
+	var n int64
+	require.NoError(t, db.Model(&RemediationAction{}).Count(&n).Error)
+	assert.Zero(t, n)
+}
@@ -0,0 +1,239 @@
+package remediation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// RuleVersion is an immutable snapshot of a rule definition, written on
+// every create, update and rollback
+type RuleVersion struct {
+	ID          string          `json:"id" gorm:"primaryKey"`
+	RuleID      string          `json:"rule_id" gorm:"uniqueIndex:idx_rule_version"`
+	Version     int             `json:"version" gorm:"uniqueIndex:idx_rule_version"`
+	Definition  RemediationRule `json:"definition" gorm:"serializer:json"`
+	ChangedBy   string          `json:"changed_by"`
+	DiffSummary string          `json:"diff_summary"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// TableName sets the rule history table name
+func (RuleVersion) TableName() string {
+	return "remediation_rule_versions"
+}
+
+// RuleBroadcaster tells other replicas that a rule changed; receivers call
+// ReloadRule with the ID
+type RuleBroadcaster interface {
+	BroadcastRuleChange(ctx context.Context, ruleID string) error
+}
+
+// SetRuleBroadcaster sets how rule changes are propagated between replicas
+func (s *Service) SetRuleBroadcaster(b RuleBroadcaster) { s.broadcaster = b }
+
+// ListRuleVersions returns a rule's history, newest first
+func (s *Service) ListRuleVersions(ctx context.Context, ruleID string) ([]RuleVersion, error) {
+	var versions []RuleVersion
+	if err := s.db.WithContext(ctx).
+		Where("rule_id = ?", ruleID).
+		Order("version DESC").
+		Find(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list rule versions: %w", err)
+	}
+	return versions, nil
+}
+
+// RollbackRule restores the definition a rule had at version. History is not
+// rewritten: the restored definition is saved as a new version.
+func (s *Service) RollbackRule(ctx context.Context, ruleID string, version int) (*RemediationRule, error) {
+	var target RuleVersion
+	if err := s.db.WithContext(ctx).
+		First(&target, "rule_id = ? AND version = ?", ruleID, version).Error; err != nil {
+		return nil, fmt.Errorf("rule version not found: %w", err)
+	}
+
+	var rule RemediationRule
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&rule, "id = ?", ruleID).Error; err != nil {
+			return err
+		}
+		previous := rule
+
+		restoreDefinition(&rule, &target.Definition)
+		if err := validateRule(&rule); err != nil {
+			return err
+		}
+		rule.UpdatedAt = time.Now()
+
+		if err := s.ensureBaselineVersion(tx, &previous); err != nil {
+			return err
+		}
+		if err := tx.Save(&rule).Error; err != nil {
+			return err
+		}
+		return s.recordRuleVersion(ctx, tx, &rule,
+			fmt.Sprintf("rollback to version %d (%s)", version, diffRules(&previous, &rule)))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to roll back rule: %w", err)
+	}
+
+	if err := s.applyRuleChange(ctx, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// ReloadRule refreshes one rule from the database into memory, e.g. after
+// another replica broadcast a change
+func (s *Service) ReloadRule(ctx context.Context, ruleID string) error {
+	var rule RemediationRule
+	err := s.db.WithContext(ctx).First(&rule, "id = ?", ruleID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.rulesMu.Lock()
+		delete(s.rules, ruleID)
+		s.rulesMu.Unlock()
+		s.unscheduleRule(ruleID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reload rule: %w", err)
+	}
+
+	s.storeRule(&rule)
+	return s.scheduleRule(&rule)
+}
+
+// applyRuleChange updates the in-memory rule set and cron entry after a rule
+// was written, then tells other replicas
+func (s *Service) applyRuleChange(ctx context.Context, rule *RemediationRule) error {
+	s.storeRule(rule)
+	if err := s.scheduleRule(rule); err != nil {
+		return err
+	}
+	s.broadcastRuleChange(ctx, rule.ID)
+	return nil
+}
+
+// storeRule keeps enabled rules in memory and drops disabled ones
+func (s *Service) storeRule(rule *RemediationRule) {
+	s.rulesMu.Lock()
+	defer s.rulesMu.Unlock()
+
+	if rule.Enabled {
+		s.rules[rule.ID] = rule
+	} else {
+		delete(s.rules, rule.ID)
+	}
+}
+
+func (s *Service) broadcastRuleChange(ctx context.Context, ruleID string) {
+	if s.broadcaster == nil {
+		return
+	}
+	if err := s.broadcaster.BroadcastRuleChange(ctx, ruleID); err != nil {
+		s.logger.Warn("Failed to broadcast rule change", zap.String("rule_id", ruleID), zap.Error(err))
+	}
+}
+
+// recordRuleVersion appends the rule's current definition to its history
+func (s *Service) recordRuleVersion(ctx context.Context, tx *gorm.DB, rule *RemediationRule, summary string) error {
+	var latest int
+	if err := tx.Model(&RuleVersion{}).
+		Where("rule_id = ?", rule.ID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&latest).Error; err != nil {
+		return err
+	}
+
+	changedBy, _ := ctx.Value("user_id").(string)
+	if changedBy == "" && latest == 0 {
+		changedBy = rule.CreatedBy
+	}
+
+	return tx.Create(&RuleVersion{
+		ID:          uuid.New().String(),
+		RuleID:      rule.ID,
+		Version:     latest + 1,
+		Definition:  *rule,
+		ChangedBy:   changedBy,
+		DiffSummary: summary,
+		CreatedAt:   time.Now(),
+	}).Error
+}
+
+// ensureBaselineVersion records the pre-change definition of rules that were
+// created before history was kept (e.g. default rules), so they can be
+// rolled back to
+func (s *Service) ensureBaselineVersion(tx *gorm.DB, previous *RemediationRule) error {
+	var count int64
+	if err := tx.Model(&RuleVersion{}).Where("rule_id = ?", previous.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return s.recordRuleVersion(context.Background(), tx, previous, "baseline")
+}
+
+// restoreDefinition copies the editable fields of def onto rule, keeping its
+// identity and execution tracking
+func restoreDefinition(rule, def *RemediationRule) {
+	rule.Name = def.Name
+	rule.Description = def.Description
+	rule.Enabled = def.Enabled
+	rule.Priority = def.Priority
+	rule.Trigger = def.Trigger
+	rule.Conditions = def.Conditions
+	rule.Actions = def.Actions
+	rule.Cooldown = def.Cooldown
+	rule.MaxExecutions = def.MaxExecutions
+	rule.RequireApproval = def.RequireApproval
+	rule.Scope = def.Scope
+	rule.Labels = def.Labels
+	rule.Metadata = def.Metadata
+}
+
+// diffRules summarises which editable fields differ between two definitions
+func diffRules(before, after *RemediationRule) string {
+	fields := []struct {
+		name          string
+		before, after interface{}
+	}{
+		{"name", before.Name, after.Name},
+		{"description", before.Description, after.Description},
+		{"enabled", before.Enabled, after.Enabled},
+		{"priority", before.Priority, after.Priority},
+		{"trigger", before.Trigger, after.Trigger},
+		{"conditions", before.Conditions, after.Conditions},
+		{"actions", before.Actions, after.Actions},
+		{"cooldown", before.Cooldown, after.Cooldown},
+		{"max_executions", before.MaxExecutions, after.MaxExecutions},
+		{"require_approval", before.RequireApproval, after.RequireApproval},
+		{"scope", before.Scope, after.Scope},
+		{"labels", before.Labels, after.Labels},
+		{"metadata", before.Metadata, after.Metadata},
+	}
+
+	var changed []string
+	for _, f := range fields {
+		a, _ := json.Marshal(f.before)
+		b, _ := json.Marshal(f.after)
+		if string(a) != string(b) {
+			changed = append(changed, f.name)
+		}
+	}
+
+	if len(changed) == 0 {
+		return "no changes"
+	}
+	return "changed: " + strings.Join(changed, ", ")
+}
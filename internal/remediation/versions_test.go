@@ -0,0 +1,101 @@
+package remediation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newVersionTestService(t *testing.T) *Service {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&RemediationRule{}, &RuleVersion{}))
+
+	return &Service{
+		db:          db,
+		logger:      zap.NewNop(),
+		config:      &Config{},
+		rules:       make(map[string]*RemediationRule),
+		cron:        cron.New(),
+		cronEntries: make(map[string]cron.EntryID),
+	}
+}
+
+func TestRollbackRuleRestoresDefinition(t *testing.T) {
+	svc := newVersionTestService(t)
+	ctx := context.WithValue(context.Background(), "user_id", "alice")
+
+	original := &RemediationRule{
+		Name:    "restart-crashloop",
+		Enabled: true,
+		Trigger: RuleTrigger{Type: "event", EventTypes: []string{"Warning"}},
+		Conditions: []RuleCondition{
+			{Type: "time_window", Field: "count", Operator: "gte", Value: "3"},
+		},
+		Actions: []RuleAction{
+			{Type: "restart_pod", Target: "{{ .ResourceName }}", Order: 1, OnFailure: "abort",
+				Parameters: map[string]interface{}{"grace_period": float64(30)}},
+		},
+		Cooldown: 5 * time.Minute,
+	}
+	require.NoError(t, svc.CreateRule(ctx, original))
+	ruleID := original.ID
+	wantConditions := original.Conditions
+	wantActions := original.Actions
+
+	// A bad edit: fire on every event and delete instead of restart
+	edited := *original
+	edited.Conditions = nil
+	edited.Actions = []RuleAction{{Type: "delete", Order: 1, OnFailure: "continue"}}
+	require.NoError(t, svc.UpdateRule(ctx, &edited))
+
+	rolledBack, err := svc.RollbackRule(ctx, ruleID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, wantConditions, rolledBack.Conditions)
+	assert.Equal(t, wantActions, rolledBack.Actions)
+
+	stored, err := svc.GetRule(ctx, ruleID)
+	require.NoError(t, err)
+	assert.Equal(t, wantConditions, stored.Conditions)
+	assert.Equal(t, wantActions, stored.Actions)
+
+	svc.rulesMu.RLock()
+	inMemory := svc.rules[ruleID]
+	svc.rulesMu.RUnlock()
+	require.NotNil(t, inMemory)
+	assert.Equal(t, wantActions, inMemory.Actions)
+
+	versions, err := svc.ListRuleVersions(ctx, ruleID)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, 3, versions[0].Version)
+	assert.Contains(t, versions[0].DiffSummary, "rollback to version 1")
+	assert.Equal(t, "alice", versions[0].ChangedBy)
+
+	// History is untouched: version 2 still holds the bad edit
+	assert.Equal(t, 2, versions[1].Version)
+	assert.Equal(t, "delete", versions[1].Definition.Actions[0].Type)
+	assert.Equal(t, "changed: conditions, actions", versions[1].DiffSummary)
+	assert.Equal(t, 1, versions[2].Version)
+	assert.Equal(t, "created", versions[2].DiffSummary)
+}
+
+func TestRollbackRuleUnknownVersion(t *testing.T) {
+	svc := newVersionTestService(t)
+	ctx := context.Background()
+
+	rule := &RemediationRule{Name: "r", Enabled: true, Trigger: RuleTrigger{Type: "event"}}
+	require.NoError(t, svc.CreateRule(ctx, rule))
+
+	_, err := svc.RollbackRule(ctx, rule.ID, 7)
+	assert.Error(t, err)
+}
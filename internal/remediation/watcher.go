@@ -0,0 +1,438 @@
+package remediation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/kube"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Reasons forwarded by default: those the default rules and common
+// playbooks act on
+var defaultWatchReasons = []string{
+	"BackOff", "CrashLoopBackOff", "OOMKilled", "OOMKilling",
+	"FailedScheduling", "FailedMount", "Unhealthy", "Evicted",
+}
+
+const (
+	defaultWatchDebounce = time.Minute
+	// watchRetryInterval is how long a watcher waits after a failed list
+	// or watch before trying again
+	watchRetryInterval = 5 * time.Second
+	// debounceKeysLimit is how many debounce entries a watcher keeps before
+	// pruning the expired ones
+	debounceKeysLimit = 1000
+	// watchLockTTL is how long a replica's claim on watching a cluster
+	// lasts without renewal; the claim is renewed while the watch runs
+	watchLockTTL = 30 * time.Second
+	// watchLockRetryInterval is how often a replica that doesn't watch a
+	// cluster tries to take the watch over
+	watchLockRetryInterval = 15 * time.Second
+)
+
+// EventWatchConfig configures the watchers that turn Kubernetes events of
+// each cluster into remediation events
+type EventWatchConfig struct {
+	// Types are the event types forwarded; default Warning
+	Types []string
+	// Reasons are the event and container state reasons forwarded, default
+	// defaultWatchReasons; "*" forwards every reason
+	Reasons []string
+	// PodStatus also watches pods, reporting containers waiting or
+	// terminated for one of Reasons, such as OOMKilled, which kubelet
+	// records in the pod status rather than as an event
+	PodStatus bool
+	// Debounce drops repeats of an event for the same object and reason
+	// within it; default one minute
+	Debounce time.Duration
+}
+
+func (c *EventWatchConfig) withDefaults() {
+	if len(c.Types) == 0 {
+		c.Types = []string{corev1.EventTypeWarning}
+	}
+	if len(c.Reasons) == 0 {
+		c.Reasons = defaultWatchReasons
+	}
+	if c.Debounce == 0 {
+		c.Debounce = defaultWatchDebounce
+	}
+}
+
+func (c *EventWatchConfig) forwards(eventType, reason string) bool {
+	return contains(c.Types, eventType) && (contains(c.Reasons, "*") || contains(c.Reasons, reason))
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterWatcher watches one cluster, forwarding what it sees to
+// ProcessEvent
+type clusterWatcher struct {
+	svc       *Service
+	clusterID string
+	client    kubernetes.Interface
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// WatchCluster starts forwarding the Kubernetes events of a cluster to
+// the rules, replacing any watcher the cluster already has. With SetLocks,
+// only the replica holding the cluster's watch lock watches it, so each
+// event is acted on once; the others take over if it stops.
+func (s *Service) WatchCluster(clusterID string, client kubernetes.Interface) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &clusterWatcher{
+		svc:       s,
+		clusterID: clusterID,
+		client:    client,
+		cancel:    cancel,
+		seen:      make(map[string]time.Time),
+	}
+
+	s.watchersMu.Lock()
+	old := s.watchers[clusterID]
+	s.watchers[clusterID] = w
+	s.watchersMu.Unlock()
+	if old != nil {
+		old.stop()
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if s.locks == nil {
+			w.watch(ctx)
+			return
+		}
+		w.watchWhileLeader(ctx)
+	}()
+}
+
+// watch runs the cluster's watch loops until ctx is done
+func (w *clusterWatcher) watch(ctx context.Context) {
+	w.svc.logger.Info("Watching cluster events for remediation", zap.String("cluster_id", w.clusterID))
+	var wg sync.WaitGroup
+	events := w.client.CoreV1().Events(metav1.NamespaceAll)
+	w.start(ctx, &wg, "events",
+		func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+			return events.List(ctx, opts)
+		},
+		events.Watch, w.handleEvent)
+	if w.svc.config.EventWatch.PodStatus {
+		pods := w.client.CoreV1().Pods(metav1.NamespaceAll)
+		w.start(ctx, &wg, "pods",
+			func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return pods.List(ctx, opts)
+			},
+			pods.Watch, w.handlePod)
+	}
+	wg.Wait()
+}
+
+// watchWhileLeader watches the cluster while holding its watch lock,
+// trying to take the lock whenever another replica holds it, until ctx
+// is done. Losing the lock stops the watch.
+func (w *clusterWatcher) watchWhileLeader(ctx context.Context) {
+	for ctx.Err() == nil {
+		held, err := w.svc.locks.WithLock(ctx, "remediation:watch:"+w.clusterID, watchLockTTL, func(ctx context.Context, _ cache.Lock) error {
+			w.watch(ctx)
+			return nil
+		})
+		switch {
+		case err != nil:
+			w.svc.logger.Warn("Failed to lock cluster watch, retrying",
+				zap.String("cluster_id", w.clusterID),
+				zap.Error(err),
+			)
+		case !held:
+			w.svc.logger.Debug("Cluster is watched by another replica", zap.String("cluster_id", w.clusterID))
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(watchLockRetryInterval):
+		}
+	}
+}
+
+// UnwatchCluster stops the watcher of a cluster
+func (s *Service) UnwatchCluster(clusterID string) {
+	s.watchersMu.Lock()
+	w := s.watchers[clusterID]
+	delete(s.watchers, clusterID)
+	s.watchersMu.Unlock()
+	if w != nil {
+		w.stop()
+		s.logger.Info("Stopped watching cluster events", zap.String("cluster_id", clusterID))
+	}
+}
+
+// ClusterAdded registers the client of a cluster added to the client
+// manager and watches its events, so the service follows a
+// kube.ClientManager it is added to as a listener
+func (s *Service) ClusterAdded(client *kube.ClusterClient) {
+	s.RegisterK8sClient(client.Name, client.Clientset, client.Config)
+	s.WatchCluster(client.Name, client.Clientset)
+}
+
+// ClusterRemoved stops watching a cluster removed from the client manager
+// and forgets its client
+func (s *Service) ClusterRemoved(name string) {
+	s.UnwatchCluster(name)
+	s.clientsMu.Lock()
+	delete(s.k8sClients, name)
+	delete(s.restConfigs, name)
+	s.clientsMu.Unlock()
+}
+
+// stopWatchers stops every cluster watcher
+func (s *Service) stopWatchers() {
+	s.watchersMu.Lock()
+	watchers := s.watchers
+	s.watchers = make(map[string]*clusterWatcher)
+	s.watchersMu.Unlock()
+	for _, w := range watchers {
+		w.stop()
+	}
+}
+
+func (w *clusterWatcher) stop() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+type (
+	listFunc  func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error)
+	watchFunc func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+)
+
+// start runs a list-then-watch loop over resource until the watcher stops.
+// The list only finds where to start: what happened before it isn't
+// replayed. A dropped watch resumes from the last resourceVersion seen,
+// and one that has expired is listed again.
+func (w *clusterWatcher) start(ctx context.Context, wg *sync.WaitGroup, resource string, list listFunc, watchFn watchFunc, handle func(context.Context, runtime.Object)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resourceVersion, listed := "", false
+		for ctx.Err() == nil {
+			if !listed {
+				obj, err := list(ctx, metav1.ListOptions{Limit: 1})
+				if err != nil {
+					w.retry(ctx, resource, "list", err)
+					continue
+				}
+				if l, err := meta.ListAccessor(obj); err == nil {
+					resourceVersion = l.GetResourceVersion()
+				}
+				listed = true
+			}
+
+			wi, err := watchFn(ctx, metav1.ListOptions{ResourceVersion: resourceVersion, AllowWatchBookmarks: true})
+			if err != nil {
+				if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+					listed = false
+				}
+				w.retry(ctx, resource, "watch", err)
+				continue
+			}
+			resourceVersion, listed = w.consume(ctx, resource, wi, resourceVersion, handle)
+			wi.Stop()
+		}
+	}()
+}
+
+// consume handles a watch until it ends, returning the resourceVersion
+// to resume from and false if it has expired
+func (w *clusterWatcher) consume(ctx context.Context, resource string, wi watch.Interface, resourceVersion string, handle func(context.Context, runtime.Object)) (string, bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion, true
+		case ev, ok := <-wi.ResultChan():
+			if !ok {
+				return resourceVersion, true
+			}
+			if ev.Type == watch.Error {
+				err := apierrors.FromObject(ev.Object)
+				if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+					return "", false
+				}
+				w.retry(ctx, resource, "watch", err)
+				return resourceVersion, true
+			}
+			if m, err := meta.Accessor(ev.Object); err == nil && m.GetResourceVersion() != "" {
+				resourceVersion = m.GetResourceVersion()
+			}
+			if ev.Type == watch.Added || ev.Type == watch.Modified {
+				handle(ctx, ev.Object)
+			}
+		}
+	}
+}
+
+func (w *clusterWatcher) retry(ctx context.Context, resource, op string, err error) {
+	w.svc.logger.Warn("Cluster watch failed, retrying",
+		zap.String("cluster_id", w.clusterID),
+		zap.String("resource", resource),
+		zap.String("op", op),
+		zap.Error(err),
+	)
+	select {
+	case <-ctx.Done():
+	case <-time.After(watchRetryInterval):
+	}
+}
+
+// handleEvent forwards a core Event of a watched type and reason
+func (w *clusterWatcher) handleEvent(ctx context.Context, obj runtime.Object) {
+	ev, ok := obj.(*corev1.Event)
+	if !ok || !w.svc.config.EventWatch.forwards(ev.Type, ev.Reason) {
+		return
+	}
+	involved := ev.InvolvedObject
+	namespace := involved.Namespace
+	if namespace == "" {
+		namespace = ev.Namespace
+	}
+	event := &RemediationEvent{
+		ID:           uuid.New().String(),
+		Type:         ev.Type,
+		Source:       "kubernetes",
+		ClusterID:    w.clusterID,
+		Namespace:    namespace,
+		ResourceType: strings.ToLower(involved.Kind),
+		ResourceName: involved.Name,
+		Reason:       ev.Reason,
+		Message:      ev.Message,
+		Severity:     strings.ToLower(ev.Type),
+		Data:         map[string]interface{}{"kind": involved.Kind, "count": ev.Count},
+		Timestamp:    eventTime(ev),
+	}
+	if involved.Kind == "Pod" {
+		w.describePod(ctx, event)
+	}
+	w.forward(ctx, fmt.Sprintf("%s/%s/%s/%s", involved.Kind, namespace, involved.Name, ev.Reason), event)
+}
+
+// describePod adds the pod's labels and status to an event about it, for
+// label and resource_status conditions. A pod already gone is left out.
+func (w *clusterWatcher) describePod(ctx context.Context, event *RemediationEvent) {
+	pod, err := w.client.CoreV1().Pods(event.Namespace).Get(ctx, event.ResourceName, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	event.Labels = pod.Labels
+	event.Data["status.phase"] = string(pod.Status.Phase)
+	event.Data["status.reason"] = pod.Status.Reason
+}
+
+// handlePod forwards containers of a pod waiting or terminated for a
+// watched reason. A termination is reported once, and only while recent,
+// since the pod keeps it as its last state until the next one.
+func (w *clusterWatcher) handlePod(ctx context.Context, obj runtime.Object) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	cfg := w.svc.config.EventWatch
+	statuses := append(append([]corev1.ContainerStatus(nil), pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		var reason, message, key string
+		if waiting := cs.State.Waiting; waiting != nil && cfg.forwards(corev1.EventTypeWarning, waiting.Reason) {
+			reason, message = waiting.Reason, waiting.Message
+			key = fmt.Sprintf("Pod/%s/%s/%s/%s", pod.Namespace, pod.Name, cs.Name, reason)
+		} else if term := cs.LastTerminationState.Terminated; term != nil && cfg.forwards(corev1.EventTypeWarning, term.Reason) &&
+			w.svc.now().Sub(term.FinishedAt.Time) < cfg.Debounce {
+			reason, message = term.Reason, term.Message
+			key = fmt.Sprintf("Pod/%s/%s/%s/%s/%d", pod.Namespace, pod.Name, cs.Name, reason, cs.RestartCount)
+		} else {
+			continue
+		}
+		w.forward(ctx, key, &RemediationEvent{
+			ID:           uuid.New().String(),
+			Type:         corev1.EventTypeWarning,
+			Source:       "kubernetes",
+			ClusterID:    w.clusterID,
+			Namespace:    pod.Namespace,
+			ResourceType: "pod",
+			ResourceName: pod.Name,
+			Reason:       reason,
+			Message:      message,
+			Severity:     "warning",
+			Labels:       pod.Labels,
+			Data: map[string]interface{}{
+				"kind":          "Pod",
+				"container":     cs.Name,
+				"restart_count": cs.RestartCount,
+				"status.phase":  string(pod.Status.Phase),
+				"status.reason": pod.Status.Reason,
+			},
+			Timestamp: w.svc.now(),
+		})
+	}
+}
+
+// forward passes event to the rules unless one with the same key was
+// forwarded within the debounce window
+func (w *clusterWatcher) forward(ctx context.Context, key string, event *RemediationEvent) {
+	debounce := w.svc.config.EventWatch.Debounce
+	now := w.svc.now()
+	w.mu.Lock()
+	if last, ok := w.seen[key]; ok && now.Sub(last) < debounce {
+		w.mu.Unlock()
+		return
+	}
+	w.seen[key] = now
+	if len(w.seen) > debounceKeysLimit {
+		for k, t := range w.seen {
+			if now.Sub(t) >= debounce {
+				delete(w.seen, k)
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	if err := w.svc.ProcessEvent(ctx, event); err != nil {
+		w.svc.logger.Error("Failed to process cluster event",
+			zap.String("cluster_id", w.clusterID),
+			zap.String("reason", event.Reason),
+			zap.Error(err),
+		)
+	}
+}
+
+// eventTime is when an event last occurred
+func eventTime(ev *corev1.Event) time.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	case !ev.FirstTimestamp.IsZero():
+		return ev.FirstTimestamp.Time
+	}
+	return time.Now()
+}
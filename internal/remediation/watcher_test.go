@@ -0,0 +1,204 @@
+package remediation
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/anubhavg-icpl/krustron/pkg/cache"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// watchCall is one watch the watcher opened
+type watchCall struct {
+	resourceVersion string
+	watcher         *watch.FakeWatcher
+}
+
+func warningEvent(rv, reason, pod string, count int32) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: pod + "." + rv, Namespace: "shop", ResourceVersion: rv},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: pod},
+		Type:           corev1.EventTypeWarning,
+		Reason:         reason,
+		Message:        "Back-off restarting failed container",
+		Count:          count,
+		LastTimestamp:  metav1.Now(),
+	}
+}
+
+func TestWatcherFeedsEventsToRules(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{DisableWorkers: true})
+	require.NoError(t, err)
+
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "shop"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	})
+	calls := make(chan watchCall, 4)
+	client.PrependWatchReactor("events", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		calls <- watchCall{action.(k8stesting.WatchActionImpl).WatchRestrictions.ResourceVersion, w}
+		return true, w, nil
+	})
+
+	svc.WatchCluster("prod", client)
+	defer svc.UnwatchCluster("prod")
+
+	first := <-calls
+	// A normal event and a reason no one watches are ignored; the repeated
+	// BackOff is debounced
+	first.watcher.Add(&corev1.Event{ObjectMeta: metav1.ObjectMeta{Name: "n", ResourceVersion: "100"}, Type: corev1.EventTypeNormal, Reason: "Pulled"})
+	first.watcher.Add(warningEvent("101", "BackOff", "web-0", 1))
+	first.watcher.Modify(warningEvent("102", "BackOff", "web-0", 2))
+	first.watcher.Add(warningEvent("103", "NodeNotReady", "web-0", 1))
+
+	// A dropped watch resumes from the last resourceVersion seen, so the
+	// events above have all been handled once it is reopened
+	first.watcher.Stop()
+	var second watchCall
+	select {
+	case second = <-calls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch was not reopened")
+	}
+	assert.Equal(t, "103", second.resourceVersion)
+
+	var actions []RemediationAction
+	require.NoError(t, db.Find(&actions).Error)
+	require.Len(t, actions, 1)
+	action := actions[0]
+	assert.Equal(t, "rule-restart-crashloop", action.RuleID)
+	assert.Equal(t, "prod", action.ClusterID)
+	assert.Equal(t, "shop", action.Namespace)
+	assert.Equal(t, "web-0", action.ResourceName)
+	assert.Equal(t, "queued", action.Status)
+	assert.Equal(t, "BackOff", action.TriggerEvent["reason"])
+
+	// An expired watch starts over with a fresh list
+	second.watcher.Error(&metav1.Status{Status: metav1.StatusFailure, Code: 410, Reason: metav1.StatusReasonExpired})
+	select {
+	case third := <-calls:
+		assert.Empty(t, third.resourceVersion)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch was not restarted")
+	}
+}
+
+func TestWatcherReportsPodStatus(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{
+		DisableWorkers: true,
+		EventWatch:     EventWatchConfig{Reasons: []string{"OOMKilled"}, PodStatus: true},
+	})
+	require.NoError(t, err)
+	require.NoError(t, svc.CreateRule(context.Background(), &RemediationRule{
+		Name:    "oom",
+		Enabled: true,
+		Trigger: RuleTrigger{Type: "event", EventTypes: []string{"Warning"}, Filters: map[string]interface{}{"reason": "OOMKilled"}},
+		Actions: []RuleAction{{Type: "notify", Target: "webhook"}},
+	}))
+
+	client := fake.NewSimpleClientset()
+	pods := make(chan *watch.FakeWatcher, 1)
+	client.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		if action.GetResource().Resource == "pods" {
+			pods <- w
+		}
+		return true, w, nil
+	})
+	svc.WatchCluster("prod", client)
+
+	oomPod := func(finished time.Time, restarts int32) runtime.Object {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "api-0", Namespace: "shop"},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{{
+				Name:         "api",
+				RestartCount: restarts,
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					Reason: "OOMKilled", FinishedAt: metav1.NewTime(finished),
+				}},
+			}}},
+		}
+	}
+	w := <-pods
+	w.Modify(oomPod(time.Now().Add(-time.Hour), 1)) // long past
+	w.Modify(oomPod(time.Now(), 2))
+	w.Modify(oomPod(time.Now(), 2)) // same termination
+	svc.UnwatchCluster("prod")
+
+	var actions []RemediationAction
+	require.NoError(t, db.Find(&actions).Error)
+	require.Len(t, actions, 1)
+	assert.Equal(t, "api-0", actions[0].ResourceName)
+	assert.Equal(t, "OOMKilled", actions[0].TriggerEvent["reason"])
+}
+
+func TestClusterWatchedByOneReplica(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	require.NoError(t, err)
+	redisCache, err := cache.NewRedisCache(&config.RedisConfig{Host: mr.Host(), Port: port})
+	require.NoError(t, err)
+	t.Cleanup(func() { redisCache.Close() })
+
+	// replica returns a service, its cluster client and the channel the
+	// client's event watches are reported on
+	replica := func() (*Service, *fake.Clientset, chan struct{}) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		require.NoError(t, err)
+		svc, err := NewService(db, zap.NewNop(), &Config{DisableWorkers: true})
+		require.NoError(t, err)
+		svc.SetLocks(redisCache)
+
+		client := fake.NewSimpleClientset()
+		watches := make(chan struct{}, 4)
+		client.PrependWatchReactor("events", func(k8stesting.Action) (bool, watch.Interface, error) {
+			watches <- struct{}{}
+			return true, watch.NewFake(), nil
+		})
+		svc.WatchCluster("prod", client)
+		return svc, client, watches
+	}
+
+	leader, _, leaderWatches := replica()
+	select {
+	case <-leaderWatches:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no replica watched the cluster")
+	}
+	follower, followerClient, followerWatches := replica()
+	defer follower.UnwatchCluster("prod")
+	select {
+	case <-followerWatches:
+		t.Fatal("a second replica watched the cluster")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Stopping the leader releases the cluster to the other replica
+	leader.UnwatchCluster("prod")
+	assert.False(t, mr.Exists("{lock:remediation:watch:prod}"))
+	follower.WatchCluster("prod", followerClient)
+	select {
+	case <-followerWatches:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the other replica did not take the cluster over")
+	}
+}
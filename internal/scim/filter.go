@@ -0,0 +1,444 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Filter is a parsed SCIM filter (RFC 7644 section 3.4.2.2). It matches
+// resources in their JSON object form.
+type Filter interface {
+	Match(resource map[string]interface{}) bool
+}
+
+// ParseFilter parses a SCIM filter expression such as
+//
+//	userName eq "alice@example.com" and not (emails[type eq "work"] pr)
+//
+// Attribute names are case-insensitive and may carry their schema URN.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, invalidFilter("unexpected %q", p.peek().text)
+	}
+	return filter, nil
+}
+
+// invalidFilter returns an invalidFilter error
+func invalidFilter(format string, args ...interface{}) error {
+	return badRequest(ErrInvalidFilter, "invalid filter: "+fmt.Sprintf(format, args...))
+}
+
+type filterTokenKind int
+
+const (
+	tokenWord filterTokenKind = iota
+	tokenString
+	tokenOpen
+	tokenClose
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+// tokenizeFilter splits expr into words, quoted strings and brackets
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{tokenOpen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{tokenClose, ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, filterToken{tokenOpenBracket, "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, filterToken{tokenCloseBracket, "]"})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, invalidFilter("unterminated string")
+			}
+			var s string
+			if err := json.Unmarshal([]byte(expr[i:end+1]), &s); err != nil {
+				return nil, invalidFilter("bad string %s", expr[i:end+1])
+			}
+			tokens = append(tokens, filterToken{tokenString, s})
+			i = end + 1
+		default:
+			end := i
+			for end < len(expr) && !strings.ContainsRune(" \t\n\r()[]\"", rune(expr[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{tokenWord, expr[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *filterParser) peek() filterToken {
+	if p.done() {
+		return filterToken{kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword reports whether the next token is the keyword kw, consuming it
+// if so
+func (p *filterParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokenWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(kind filterTokenKind, text string) error {
+	if p.next().kind != kind {
+		return invalidFilter("expected %q", text)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (Filter, error) {
+	if !p.keyword("not") {
+		return p.parseAtom()
+	}
+	if err := p.expect(tokenOpen, "("); err != nil {
+		return nil, err
+	}
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokenClose, ")"); err != nil {
+		return nil, err
+	}
+	return notFilter{inner}, nil
+}
+
+func (p *filterParser) parseAtom() (Filter, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenOpen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenClose, ")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case tokenWord:
+	default:
+		return nil, invalidFilter("expected an attribute")
+	}
+
+	path := attributePath(t.text)
+	if p.peek().kind == tokenOpenBracket {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenCloseBracket, "]"); err != nil {
+			return nil, err
+		}
+		return valuePathFilter{path: path, filter: inner}, nil
+	}
+
+	op := p.next()
+	if op.kind != tokenWord {
+		return nil, invalidFilter("expected an operator after %s", t.text)
+	}
+	operator := strings.ToLower(op.text)
+	if operator == "pr" {
+		return presentFilter{path}, nil
+	}
+	switch operator {
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, invalidFilter("unknown operator %q", op.text)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return compareFilter{path: path, op: operator, value: value}, nil
+}
+
+// parseValue parses a comparison value: a string, number, boolean or null
+func (p *filterParser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenWord:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		var n float64
+		if err := json.Unmarshal([]byte(t.text), &n); err == nil {
+			return n, nil
+		}
+	}
+	return nil, invalidFilter("expected a value, got %q", t.text)
+}
+
+// attributePath splits an attribute like "name.givenName" into its
+// segments, dropping any schema URN prefix
+func attributePath(attr string) []string {
+	if strings.HasPrefix(strings.ToLower(attr), "urn:") {
+		if i := strings.LastIndex(attr, ":"); i >= 0 {
+			attr = attr[i+1:]
+		}
+	}
+	return strings.Split(attr, ".")
+}
+
+type orFilter struct{ left, right Filter }
+
+func (f orFilter) Match(r map[string]interface{}) bool { return f.left.Match(r) || f.right.Match(r) }
+
+type andFilter struct{ left, right Filter }
+
+func (f andFilter) Match(r map[string]interface{}) bool { return f.left.Match(r) && f.right.Match(r) }
+
+type notFilter struct{ inner Filter }
+
+func (f notFilter) Match(r map[string]interface{}) bool { return !f.inner.Match(r) }
+
+// presentFilter matches resources with a non-empty value at path
+type presentFilter struct{ path []string }
+
+func (f presentFilter) Match(r map[string]interface{}) bool {
+	for _, v := range lookup(r, f.path) {
+		if v != nil && v != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// valuePathFilter matches resources with an element of a multi-valued
+// attribute that matches filter, e.g. emails[type eq "work"]
+type valuePathFilter struct {
+	path   []string
+	filter Filter
+}
+
+func (f valuePathFilter) Match(r map[string]interface{}) bool {
+	for _, v := range lookup(r, f.path) {
+		if element, ok := v.(map[string]interface{}); ok && f.filter.Match(element) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareFilter compares the values at path with value. A multi-valued
+// attribute matches if any of its values does.
+type compareFilter struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+func (f compareFilter) Match(r map[string]interface{}) bool {
+	values := lookup(r, f.path)
+	if f.value == nil {
+		switch f.op {
+		case "eq":
+			return len(values) == 0
+		case "ne":
+			return len(values) > 0
+		}
+		return false
+	}
+	for _, v := range values {
+		if compare(v, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compare applies op to a resource value and a filter value. Strings
+// compare case-insensitively, and as times when both are timestamps.
+func compare(actual interface{}, op string, expected interface{}) bool {
+	switch want := expected.(type) {
+	case bool:
+		got, ok := actual.(bool)
+		switch op {
+		case "eq":
+			return ok && got == want
+		case "ne":
+			return !ok || got != want
+		}
+		return false
+	case float64:
+		got, ok := actual.(float64)
+		if !ok {
+			return op == "ne"
+		}
+		return compareOrdered(op, got < want, got == want)
+	case string:
+		got, ok := actual.(string)
+		if !ok {
+			return op == "ne"
+		}
+		if gotTime, err := time.Parse(time.RFC3339Nano, got); err == nil {
+			if wantTime, err := time.Parse(time.RFC3339Nano, want); err == nil {
+				if op == "eq" || op == "ne" || op == "gt" || op == "ge" || op == "lt" || op == "le" {
+					return compareOrdered(op, gotTime.Before(wantTime), gotTime.Equal(wantTime))
+				}
+			}
+		}
+		got, want = strings.ToLower(got), strings.ToLower(want)
+		switch op {
+		case "co":
+			return strings.Contains(got, want)
+		case "sw":
+			return strings.HasPrefix(got, want)
+		case "ew":
+			return strings.HasSuffix(got, want)
+		}
+		return compareOrdered(op, got < want, got == want)
+	}
+	return false
+}
+
+// compareOrdered applies an equality or ordering operator given how the
+// operands compare
+func compareOrdered(op string, less, equal bool) bool {
+	switch op {
+	case "eq":
+		return equal
+	case "ne":
+		return !equal
+	case "gt":
+		return !less && !equal
+	case "ge":
+		return !less
+	case "lt":
+		return less
+	case "le":
+		return less || equal
+	}
+	return false
+}
+
+// lookup returns the values at path in r, flattening multi-valued
+// attributes. Attribute names match case-insensitively.
+func lookup(r map[string]interface{}, path []string) []interface{} {
+	current := []interface{}{r}
+	for _, name := range path {
+		var next []interface{}
+		for _, v := range current {
+			object, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key, ok := findKey(object, name)
+			if !ok {
+				continue
+			}
+			if values, ok := object[key].([]interface{}); ok {
+				next = append(next, values...)
+			} else if object[key] != nil {
+				next = append(next, object[key])
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// findKey returns the key of object that matches name case-insensitively
+func findKey(object map[string]interface{}, name string) (string, bool) {
+	if _, ok := object[name]; ok {
+		return name, true
+	}
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResource returns a user in its JSON object form
+func testResource(t *testing.T) map[string]interface{} {
+	t.Helper()
+	var resource map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"userName": "Alice@Example.com",
+		"active": true,
+		"name": {"givenName": "Alice", "familyName": "Liddell"},
+		"emails": [
+			{"value": "alice@example.com", "type": "work", "primary": true},
+			{"value": "alice@home.example", "type": "home"}
+		],
+		"meta": {"created": "2026-03-10T09:00:00Z"},
+		"loginCount": 7
+	}`), &resource))
+	return resource
+}
+
+func TestFilterMatch(t *testing.T) {
+	resource := testResource(t)
+	for expr, want := range map[string]bool{
+		`userName eq "alice@example.com"`:                                       true,
+		`USERNAME Eq "alice@example.com"`:                                       true,
+		`userName ne "alice@example.com"`:                                       false,
+		`userName sw "alice" and userName ew ".com"`:                            true,
+		`name.familyName co "DDEL"`:                                             true,
+		`name.middleName pr`:                                                    false,
+		`title eq null`:                                                         true,
+		`active eq true and not (active eq false)`:                              true,
+		`emails.value eq "alice@home.example"`:                                  true,
+		`emails[type eq "home" and primary eq true]`:                            false,
+		`emails[type eq "work" and primary eq true]`:                            true,
+		`emails[type eq "home"] or userName eq "bob"`:                           true,
+		`userName eq "bob" or (active eq true and loginCount gt 5)`:             true,
+		`loginCount le 6`:                                                       false,
+		`meta.created ge "2026-03-10T10:00:00+01:00"`:                           true,
+		`meta.created lt "2026-03-10T08:59:59Z"`:                                false,
+		`userName eq "alice@example.com" and userName eq "x" or active eq true`: true,
+	} {
+		filter, err := ParseFilter(expr)
+		require.NoError(t, err, expr)
+		assert.Equal(t, want, filter.Match(resource), expr)
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName is "alice"`,
+		`userName eq "alice`,
+		`(userName eq "alice"`,
+		`emails[type eq "work"`,
+		`not userName eq "alice"`,
+		`userName eq "alice" extra`,
+		`userName eq alice`,
+	} {
+		_, err := ParseFilter(expr)
+		require.Error(t, err, expr)
+		_, body := ErrorResponse(err)
+		assert.Equal(t, ErrInvalidFilter, body.ScimType, expr)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	resource := testResource(t)
+	require.NoError(t, applyPatch(resource, []PatchOperation{
+		{Op: "replace", Path: "name.givenName", Value: "Alicia"},
+		{Op: "Replace", Path: `emails[type eq "work"].value`, Value: "alicia@example.com"},
+		{Op: "remove", Path: `emails[type eq "home"]`},
+		{Op: "add", Path: "emails", Value: []interface{}{map[string]interface{}{"value": "a@other.example", "type": "other"}}},
+		{Op: "add", Value: map[string]interface{}{"displayName": "Alicia L", "name.familyName": "L"}},
+		{Op: "remove", Path: "loginCount"},
+		{Op: "remove", Path: `emails[type eq "home"]`},
+	}))
+
+	assert.Equal(t, map[string]interface{}{"givenName": "Alicia", "familyName": "L"}, resource["name"])
+	assert.Equal(t, "Alicia L", resource["displayName"])
+	assert.NotContains(t, resource, "loginCount")
+	emails := resource["emails"].([]interface{})
+	require.Len(t, emails, 2)
+	assert.Equal(t, "alicia@example.com", emails[0].(map[string]interface{})["value"])
+	assert.Equal(t, "other", emails[1].(map[string]interface{})["type"])
+
+	members := map[string]interface{}{"members": []interface{}{
+		map[string]interface{}{"value": "1"}, map[string]interface{}{"value": "2"},
+	}}
+	require.NoError(t, applyPatch(members, []PatchOperation{
+		{Op: "remove", Path: "members", Value: []interface{}{map[string]interface{}{"value": "1"}}},
+	}))
+	assert.Equal(t, []interface{}{map[string]interface{}{"value": "2"}}, members["members"])
+
+	for _, op := range []PatchOperation{
+		{Op: "move", Path: "active"},
+		{Op: "remove"},
+		{Op: "add", Value: "not an object"},
+		{Op: "replace", Path: `emails[type eq "fax"].value`, Value: "x"},
+		{Op: "replace", Path: `emails[type eq`, Value: "x"},
+		{Op: "replace", Path: "name.givenName.first", Value: "x"},
+	} {
+		err := applyPatch(testResource(t), []PatchOperation{op})
+		assert.Error(t, err, "%+v", op)
+	}
+}
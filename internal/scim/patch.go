@@ -0,0 +1,241 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// patchPath is the target of a PATCH operation: attr, optionally narrowed
+// to the elements matching filter, optionally a sub-attribute of it.
+// Examples are "active", "name.givenName", "members[value eq "42"]" and
+// "emails[type eq "work"].value".
+type patchPath struct {
+	attr   string
+	filter Filter
+	sub    string
+}
+
+// parsePatchPath parses the path of a PATCH operation
+func parsePatchPath(path string) (*patchPath, error) {
+	path = strings.TrimSpace(path)
+	target := &patchPath{}
+	if open := strings.Index(path, "["); open >= 0 {
+		end := strings.LastIndex(path, "]")
+		if end < open {
+			return nil, badRequest(ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+		}
+		filter, err := ParseFilter(path[open+1 : end])
+		if err != nil {
+			return nil, badRequest(ErrInvalidPath, fmt.Sprintf("invalid path %q: %v", path, err))
+		}
+		target.filter = filter
+		if rest := path[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+				return nil, badRequest(ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+			}
+			target.sub = rest[1:]
+		}
+		path = path[:open]
+	}
+
+	segments := attributePath(path)
+	switch {
+	case segments[0] == "":
+		return nil, badRequest(ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+	case len(segments) == 2 && target.filter == nil:
+		target.sub = segments[1]
+	case len(segments) != 1:
+		return nil, badRequest(ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+	}
+	target.attr = segments[0]
+	return target, nil
+}
+
+// applyPatch applies PATCH operations to resource, a resource in its JSON
+// object form. Operation names are case-insensitive, as some identity
+// providers capitalize them.
+func applyPatch(resource map[string]interface{}, operations []PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		switch op {
+		case "add", "replace", "remove":
+		default:
+			return badRequest(ErrInvalidSyntax, fmt.Sprintf("unknown patch operation %q", operation.Op))
+		}
+
+		if strings.TrimSpace(operation.Path) == "" {
+			if op == "remove" {
+				return badRequest(ErrNoTarget, "remove needs a path")
+			}
+			values, ok := operation.Value.(map[string]interface{})
+			if !ok {
+				return badRequest(ErrInvalidValue, op+" without a path needs an object value")
+			}
+			for name, value := range values {
+				target, err := parsePatchPath(name)
+				if err != nil {
+					return err
+				}
+				if err := patchAttribute(resource, op, target, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		target, err := parsePatchPath(operation.Path)
+		if err != nil {
+			return err
+		}
+		if err := patchAttribute(resource, op, target, operation.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchAttribute applies one add, replace or remove to target
+func patchAttribute(resource map[string]interface{}, op string, target *patchPath, value interface{}) error {
+	key, ok := findKey(resource, target.attr)
+	if !ok {
+		key = target.attr
+	}
+
+	switch {
+	case target.filter != nil:
+		elements, _ := resource[key].([]interface{})
+		kept := make([]interface{}, 0, len(elements))
+		matched := false
+		for _, element := range elements {
+			object, ok := element.(map[string]interface{})
+			if !ok || !target.filter.Match(object) {
+				kept = append(kept, element)
+				continue
+			}
+			matched = true
+			if op == "remove" && target.sub == "" {
+				continue
+			}
+			if err := patchObject(object, op, target.sub, value); err != nil {
+				return err
+			}
+			kept = append(kept, object)
+		}
+		// Removing what is already gone is not an error
+		if !matched && op != "remove" {
+			return badRequest(ErrNoTarget, fmt.Sprintf("no %s matches the path filter", target.attr))
+		}
+		resource[key] = kept
+
+	case target.sub != "":
+		switch current := resource[key].(type) {
+		case []interface{}:
+			for _, element := range current {
+				if object, ok := element.(map[string]interface{}); ok {
+					if err := patchObject(object, op, target.sub, value); err != nil {
+						return err
+					}
+				}
+			}
+		case map[string]interface{}:
+			return patchObject(current, op, target.sub, value)
+		default:
+			if op == "remove" {
+				return nil
+			}
+			resource[key] = map[string]interface{}{target.sub: value}
+		}
+
+	case op == "remove":
+		// Some providers remove members by value instead of by filter
+		current, isList := resource[key].([]interface{})
+		values, hasValues := value.([]interface{})
+		if isList && hasValues {
+			resource[key] = removeValues(current, values)
+		} else {
+			delete(resource, key)
+		}
+
+	default:
+		resource[key] = patchValue(op, resource[key], value)
+	}
+	return nil
+}
+
+// patchObject applies op to the sub-attribute sub of object, or with no
+// sub-attribute, merges value into object
+func patchObject(object map[string]interface{}, op, sub string, value interface{}) error {
+	if sub == "" {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return badRequest(ErrInvalidValue, op+" of a filtered path needs an object value")
+		}
+		for name, v := range values {
+			key, ok := findKey(object, name)
+			if !ok {
+				key = name
+			}
+			object[key] = patchValue(op, object[key], v)
+		}
+		return nil
+	}
+
+	key, ok := findKey(object, sub)
+	if !ok {
+		key = sub
+	}
+	if op == "remove" {
+		delete(object, key)
+		return nil
+	}
+	object[key] = patchValue(op, object[key], value)
+	return nil
+}
+
+// patchValue returns the result of adding or replacing value at an
+// attribute holding current. Adding to a multi-valued attribute appends;
+// both merge sub-attributes into a complex one.
+func patchValue(op string, current, value interface{}) interface{} {
+	if object, ok := current.(map[string]interface{}); ok {
+		if values, ok := value.(map[string]interface{}); ok {
+			for name, v := range values {
+				key, ok := findKey(object, name)
+				if !ok {
+					key = name
+				}
+				object[key] = v
+			}
+			return object
+		}
+	}
+	if list, ok := current.([]interface{}); ok && op == "add" {
+		if values, ok := value.([]interface{}); ok {
+			return append(list, values...)
+		}
+		return append(list, value)
+	}
+	return value
+}
+
+// removeValues returns elements without those whose "value" matches one
+// of values
+func removeValues(elements, values []interface{}) []interface{} {
+	remove := make(map[string]bool, len(values))
+	for _, v := range values {
+		if object, ok := v.(map[string]interface{}); ok {
+			if key, ok := findKey(object, "value"); ok {
+				remove[fmt.Sprint(object[key])] = true
+			}
+		}
+	}
+	kept := make([]interface{}, 0, len(elements))
+	for _, element := range elements {
+		if object, ok := element.(map[string]interface{}); ok {
+			if key, ok := findKey(object, "value"); ok && remove[fmt.Sprint(object[key])] {
+				continue
+			}
+		}
+		kept = append(kept, element)
+	}
+	return kept
+}
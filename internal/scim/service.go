@@ -0,0 +1,604 @@
+package scim
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// DefaultCount is the page size of list requests that don't ask for one
+const DefaultCount = 100
+
+// maxResults caps the page size of list requests
+const maxResults = 1000
+
+// externalIDKey is where a group's externalId is kept in its team's
+// metadata
+const externalIDKey = "scim_external_id"
+
+// Config holds SCIM configuration
+type Config struct {
+	// Token is the bearer token identity providers authenticate with.
+	// It is distinct from user JWTs; an empty token authenticates nothing.
+	Token string
+}
+
+// Service provisions users through the auth service and groups as RBAC
+// teams
+type Service struct {
+	auth   *auth.Service
+	rbac   *rbac.Service
+	logger *zap.Logger
+	config *Config
+}
+
+// NewService creates a SCIM service. Without an RBAC service, groups
+// cannot be provisioned.
+func NewService(authService *auth.Service, rbacService *rbac.Service, logger *zap.Logger, cfg *Config) *Service {
+	return &Service{
+		auth:   authService,
+		rbac:   rbacService,
+		logger: logger,
+		config: cfg,
+	}
+}
+
+// Authenticate reports whether token is the SCIM bearer token
+func (s *Service) Authenticate(token string) bool {
+	if s.config.Token == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) == 1
+}
+
+// ListUsers lists the users matching query
+func (s *Service) ListUsers(ctx context.Context, query ListQuery) (*ListResponse, error) {
+	filter, err := parseListFilter(query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.auth.ListAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []interface{}
+	for i := range users {
+		user := toUser(&users[i])
+		if filter != nil && !filter.Match(resourceMap(user)) {
+			continue
+		}
+		resources = append(resources, user)
+	}
+	return listPage(resources, query), nil
+}
+
+// GetUser returns a user
+func (s *Service) GetUser(ctx context.Context, id string) (*User, error) {
+	if err := checkUserID(id); err != nil {
+		return nil, err
+	}
+	user, err := s.auth.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toUser(user), nil
+}
+
+// CreateUser provisions a user
+func (s *Service) CreateUser(ctx context.Context, user *User) (*User, error) {
+	req, err := provisionRequest(user)
+	if err != nil {
+		return nil, err
+	}
+	created, err := s.auth.ProvisionUser(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return toUser(created), nil
+}
+
+// ReplaceUser replaces a user. Deactivating the user revokes their tokens.
+func (s *Service) ReplaceUser(ctx context.Context, id string, user *User) (*User, error) {
+	if err := checkUserID(id); err != nil {
+		return nil, err
+	}
+	req, err := provisionRequest(user)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := s.auth.UpdateProvisionedUser(ctx, id, req)
+	if err != nil {
+		return nil, err
+	}
+	return toUser(updated), nil
+}
+
+// PatchUser applies a PATCH request to a user
+func (s *Service) PatchUser(ctx context.Context, id string, patch *PatchRequest) (*User, error) {
+	current, err := s.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resource := resourceMap(current)
+	if err := applyPatch(resource, patch.Operations); err != nil {
+		return nil, err
+	}
+	if err := normalizeBool(resource, "active"); err != nil {
+		return nil, err
+	}
+
+	var user User
+	if err := decodeResource(resource, &user); err != nil {
+		return nil, err
+	}
+	return s.ReplaceUser(ctx, id, &user)
+}
+
+// DeleteUser revokes a user's tokens, removes them from their groups and
+// deletes them
+func (s *Service) DeleteUser(ctx context.Context, id string) error {
+	if err := checkUserID(id); err != nil {
+		return err
+	}
+	if err := s.auth.RevokeUserTokens(ctx, id); err != nil {
+		return err
+	}
+	if s.rbac != nil {
+		teams, err := s.rbac.ListTeams(ctx)
+		if err != nil {
+			return err
+		}
+		for _, team := range teams {
+			for _, member := range team.Members {
+				if member.UserID != id {
+					continue
+				}
+				if err := s.rbac.RemoveTeamMember(ctx, team.ID, id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return s.auth.DeleteUser(ctx, id)
+}
+
+// ListGroups lists the groups matching query
+func (s *Service) ListGroups(ctx context.Context, query ListQuery) (*ListResponse, error) {
+	if err := s.requireRBAC(); err != nil {
+		return nil, err
+	}
+	filter, err := parseListFilter(query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := s.rbac.ListTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []interface{}
+	for i := range teams {
+		group := toGroup(&teams[i])
+		if filter != nil && !filter.Match(resourceMap(group)) {
+			continue
+		}
+		resources = append(resources, group)
+	}
+	return listPage(resources, query), nil
+}
+
+// GetGroup returns a group
+func (s *Service) GetGroup(ctx context.Context, id string) (*Group, error) {
+	team, err := s.getTeam(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return toGroup(team), nil
+}
+
+// CreateGroup provisions a group as an RBAC team with its members
+func (s *Service) CreateGroup(ctx context.Context, group *Group) (*Group, error) {
+	if err := s.requireRBAC(); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(group.DisplayName)
+	if name == "" {
+		return nil, badRequest(ErrInvalidValue, "displayName is required")
+	}
+	if err := s.checkGroupName(ctx, name, ""); err != nil {
+		return nil, err
+	}
+	if err := s.checkMembers(ctx, group.Members); err != nil {
+		return nil, err
+	}
+
+	team := &rbac.Team{
+		Name:        name,
+		DisplayName: name,
+		Metadata:    map[string]interface{}{},
+		CreatedBy:   "scim",
+	}
+	setExternalID(team, group.ExternalID)
+	if err := s.rbac.CreateTeam(ctx, team); err != nil {
+		return nil, err
+	}
+	if err := s.syncMembers(ctx, team, group.Members); err != nil {
+		return nil, err
+	}
+	s.logger.Info("SCIM group provisioned", zap.String("team_id", team.ID), zap.String("name", name))
+	return s.GetGroup(ctx, team.ID)
+}
+
+// ReplaceGroup replaces a group's name, externalId and members
+func (s *Service) ReplaceGroup(ctx context.Context, id string, group *Group) (*Group, error) {
+	team, err := s.getTeam(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(group.DisplayName)
+	if name == "" {
+		return nil, badRequest(ErrInvalidValue, "displayName is required")
+	}
+	if name != team.Name {
+		if err := s.checkGroupName(ctx, name, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkMembers(ctx, group.Members); err != nil {
+		return nil, err
+	}
+
+	team.Name, team.DisplayName = name, name
+	if team.Metadata == nil {
+		team.Metadata = map[string]interface{}{}
+	}
+	setExternalID(team, group.ExternalID)
+	if err := s.rbac.UpdateTeam(ctx, team); err != nil {
+		return nil, teamError(err, id)
+	}
+	if err := s.syncMembers(ctx, team, group.Members); err != nil {
+		return nil, err
+	}
+	return s.GetGroup(ctx, id)
+}
+
+// PatchGroup applies a PATCH request to a group
+func (s *Service) PatchGroup(ctx context.Context, id string, patch *PatchRequest) (*Group, error) {
+	current, err := s.GetGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	resource := resourceMap(current)
+	if err := applyPatch(resource, patch.Operations); err != nil {
+		return nil, err
+	}
+
+	var group Group
+	if err := decodeResource(resource, &group); err != nil {
+		return nil, err
+	}
+	return s.ReplaceGroup(ctx, id, &group)
+}
+
+// DeleteGroup deletes a group's team
+func (s *Service) DeleteGroup(ctx context.Context, id string) error {
+	if err := s.requireRBAC(); err != nil {
+		return err
+	}
+	if err := s.rbac.DeleteTeam(ctx, id); err != nil {
+		return teamError(err, id)
+	}
+	return nil
+}
+
+// ServiceProviderConfig describes the SCIM features supported
+func (s *Service) ServiceProviderConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":        []string{SchemaServiceProviderConfig},
+		"patch":          map[string]interface{}{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": maxResults},
+		"changePassword": map[string]interface{}{"supported": false},
+		"sort":           map[string]interface{}{"supported": false},
+		"etag":           map[string]interface{}{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The SCIM token configured as auth.scim_token",
+		}},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     BasePath + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// requireRBAC fails group requests when RBAC is unavailable
+func (s *Service) requireRBAC() error {
+	if s.rbac == nil {
+		return errors.ServiceUnavailable("RBAC is unavailable, groups cannot be provisioned")
+	}
+	return nil
+}
+
+// getTeam returns the team of a group
+func (s *Service) getTeam(ctx context.Context, id string) (*rbac.Team, error) {
+	if err := s.requireRBAC(); err != nil {
+		return nil, err
+	}
+	team, err := s.rbac.GetTeam(ctx, id)
+	if err != nil {
+		return nil, teamError(err, id)
+	}
+	return team, nil
+}
+
+// checkGroupName returns a conflict if a team other than exceptID is
+// named name
+func (s *Service) checkGroupName(ctx context.Context, name, exceptID string) error {
+	teams, err := s.rbac.ListTeams(ctx)
+	if err != nil {
+		return err
+	}
+	for _, team := range teams {
+		if team.ID != exceptID && team.Name == name {
+			return errors.Conflict(fmt.Sprintf("group %q already exists", name))
+		}
+	}
+	return nil
+}
+
+// checkMembers rejects members that aren't users
+func (s *Service) checkMembers(ctx context.Context, members []Member) error {
+	for _, member := range members {
+		if err := checkUserID(member.Value); err != nil {
+			return badRequest(ErrInvalidValue, fmt.Sprintf("member %q is not a user", member.Value))
+		}
+		if _, err := s.auth.GetUser(ctx, member.Value); err != nil {
+			if errors.Is(err, errors.CodeNotFound) {
+				return badRequest(ErrInvalidValue, fmt.Sprintf("member %q is not a user", member.Value))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// syncMembers makes members the members of team
+func (s *Service) syncMembers(ctx context.Context, team *rbac.Team, members []Member) error {
+	want := make(map[string]bool, len(members))
+	for _, member := range members {
+		want[member.Value] = true
+	}
+	have := make(map[string]bool, len(team.Members))
+	for _, member := range team.Members {
+		have[member.UserID] = true
+	}
+
+	for _, userID := range sortedKeys(want) {
+		if have[userID] {
+			continue
+		}
+		if err := s.rbac.AddTeamMember(ctx, team.ID, userID, "member", "scim"); err != nil {
+			return err
+		}
+	}
+	for _, userID := range sortedKeys(have) {
+		if want[userID] {
+			continue
+		}
+		if err := s.rbac.RemoveTeamMember(ctx, team.ID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUserID returns not found for IDs that cannot be user IDs, which
+// the database would reject as malformed
+func checkUserID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return errors.NotFound("user", id)
+	}
+	return nil
+}
+
+// teamError converts a not found error from the RBAC service
+func teamError(err error, id string) error {
+	if goerrors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.NotFound("group", id)
+	}
+	return err
+}
+
+// parseListFilter parses the filter of a list request, if it has one
+func parseListFilter(expr string) (Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	return ParseFilter(expr)
+}
+
+// listPage returns the page of resources query asks for
+func listPage(resources []interface{}, query ListQuery) *ListResponse {
+	start := query.StartIndex
+	if start < 1 {
+		start = 1
+	}
+	count := query.Count
+	if count < 0 {
+		count = 0
+	}
+	if count > maxResults {
+		count = maxResults
+	}
+
+	page := []interface{}{}
+	if start <= len(resources) {
+		end := start - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[start-1 : end]
+	}
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// provisionRequest converts a SCIM user to what the auth service
+// provisions. userName is the email; an identity provider whose user
+// names aren't emails must send a primary email.
+func provisionRequest(user *User) (*auth.ProvisionUserRequest, error) {
+	email := strings.TrimSpace(user.UserName)
+	if !strings.Contains(email, "@") {
+		for _, e := range user.Emails {
+			if e.Primary || len(user.Emails) == 1 {
+				email = strings.TrimSpace(e.Value)
+			}
+		}
+	}
+	if email == "" {
+		return nil, badRequest(ErrInvalidValue, "userName is required")
+	}
+
+	name := strings.TrimSpace(user.DisplayName)
+	if name == "" && user.Name != nil {
+		name = strings.TrimSpace(user.Name.Formatted)
+		if name == "" {
+			name = strings.TrimSpace(user.Name.GivenName + " " + user.Name.FamilyName)
+		}
+	}
+
+	return &auth.ProvisionUserRequest{
+		Email:      email,
+		Name:       name,
+		ExternalID: user.ExternalID,
+		Active:     user.Active == nil || *user.Active,
+	}, nil
+}
+
+// toUser converts a krustron user to a SCIM user
+func toUser(user *auth.User) *User {
+	active := user.IsActive
+	return &User{
+		Schemas:     []string{SchemaUser},
+		ID:          user.ID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		Name:        &Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     BasePath + "/Users/" + user.ID,
+		},
+	}
+}
+
+// toGroup converts a team to a SCIM group
+func toGroup(team *rbac.Team) *Group {
+	group := &Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          team.ID,
+		DisplayName: team.DisplayName,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      team.CreatedAt,
+			LastModified: team.UpdatedAt,
+			Location:     BasePath + "/Groups/" + team.ID,
+		},
+	}
+	if group.DisplayName == "" {
+		group.DisplayName = team.Name
+	}
+	if id, ok := team.Metadata[externalIDKey].(string); ok {
+		group.ExternalID = id
+	}
+	for _, member := range team.Members {
+		group.Members = append(group.Members, Member{
+			Value: member.UserID,
+			Ref:   BasePath + "/Users/" + member.UserID,
+		})
+	}
+	return group
+}
+
+// setExternalID records a group's externalId in its team's metadata
+func setExternalID(team *rbac.Team, externalID string) {
+	if externalID == "" {
+		delete(team.Metadata, externalIDKey)
+		return
+	}
+	team.Metadata[externalIDKey] = externalID
+}
+
+// resourceMap returns the JSON object form of a resource, which filters
+// match and patches apply to
+func resourceMap(resource interface{}) map[string]interface{} {
+	data, _ := json.Marshal(resource)
+	var m map[string]interface{}
+	_ = json.Unmarshal(data, &m)
+	return m
+}
+
+// decodeResource decodes the JSON object form of a resource into v
+func decodeResource(resource map[string]interface{}, v interface{}) error {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return badRequest(ErrInvalidValue, err.Error())
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return badRequest(ErrInvalidValue, err.Error())
+	}
+	return nil
+}
+
+// normalizeBool converts the boolean attribute name from a string, as
+// some identity providers send "True" and "False"
+func normalizeBool(resource map[string]interface{}, name string) error {
+	key, ok := findKey(resource, name)
+	if !ok {
+		return nil
+	}
+	s, ok := resource[key].(string)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return badRequest(ErrInvalidValue, fmt.Sprintf("%s must be a boolean", name))
+	}
+	resource[key] = b
+	return nil
+}
+
+// sortedKeys returns the keys of set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,251 @@
+package scim
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/internal/audit"
+	"github.com/anubhavg-icpl/krustron/internal/audit/audittest"
+	"github.com/anubhavg-icpl/krustron/internal/auth"
+	"github.com/anubhavg-icpl/krustron/internal/rbac"
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"github.com/anubhavg-icpl/krustron/pkg/database"
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// usersTable is the users schema in SQLite
+const usersTable = `CREATE TABLE users (
+	id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), email TEXT UNIQUE NOT NULL,
+	password_hash TEXT, name TEXT NOT NULL, avatar_url TEXT DEFAULT '',
+	provider TEXT DEFAULT 'local', role TEXT DEFAULT 'user', is_active BOOLEAN DEFAULT true,
+	totp_enabled BOOLEAN DEFAULT false, totp_secret TEXT DEFAULT '', external_id TEXT,
+	tokens_valid_after TIMESTAMP, last_login_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := gdb.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	for _, table := range []string{usersTable, audittest.Schema} {
+		_, err := sqlDB.Exec(table)
+		require.NoError(t, err)
+	}
+	authService, err := auth.NewService(&database.PostgresDB{DB: sqlDB}, nil, &config.AuthConfig{
+		JWTSecret:         "scim-test-secret-0123456789abcdef0123456789",
+		JWTExpiration:     time.Hour,
+		RefreshExpiration: 24 * time.Hour,
+		BCryptCost:        4,
+	})
+	require.NoError(t, err)
+
+	// The Casbin adapter saves policies on a second connection, so the
+	// in-memory database has to be shared
+	dsn := fmt.Sprintf("file:scim-%d?mode=memory&cache=shared", time.Now().UnixNano())
+	rbacDB, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	rbacService, err := rbac.NewService(rbacDB, zap.NewNop(), &rbac.Config{})
+	require.NoError(t, err)
+	t.Cleanup(rbacService.Stop)
+
+	return NewService(authService, rbacService, zap.NewNop(), &Config{Token: "scim-token"})
+}
+
+func TestAuthenticate(t *testing.T) {
+	svc := newTestService(t)
+	assert.True(t, svc.Authenticate("scim-token"))
+	assert.False(t, svc.Authenticate("scim-token2"))
+	assert.False(t, svc.Authenticate(""))
+
+	unconfigured := NewService(nil, nil, zap.NewNop(), &Config{})
+	assert.False(t, unconfigured.Authenticate(""), "no token configured authenticates nothing")
+}
+
+func TestDeactivationRevokesTokens(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	user, err := svc.auth.CreateUser(ctx, &auth.CreateUserRequest{
+		Email: "alice@example.com", Password: "correct-horse", Name: "Alice",
+	})
+	require.NoError(t, err)
+	login, err := svc.auth.Login(ctx, &auth.LoginRequest{Email: "alice@example.com", Password: "correct-horse"})
+	require.NoError(t, err)
+	_, err = svc.auth.ValidateToken(login.AccessToken)
+	require.NoError(t, err)
+
+	// Azure AD deactivates with a capitalized op and a string boolean
+	scimCtx := audit.WithActor(ctx, "", "scim")
+	deactivated, err := svc.PatchUser(scimCtx, user.ID, &PatchRequest{
+		Schemas:    []string{SchemaPatchOp},
+		Operations: []PatchOperation{{Op: "Replace", Value: map[string]interface{}{"active": "False"}}},
+	})
+	require.NoError(t, err)
+	assert.False(t, *deactivated.Active)
+	assert.Equal(t, "alice@example.com", deactivated.UserName)
+
+	_, err = svc.auth.ValidateToken(login.AccessToken)
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized), "access token still valid: %v", err)
+	_, err = svc.auth.RefreshToken(ctx, login.RefreshToken)
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized), "refresh token still valid: %v", err)
+	_, err = svc.auth.Login(ctx, &auth.LoginRequest{Email: "alice@example.com", Password: "correct-horse"})
+	assert.True(t, errors.Is(err, errors.CodeUnauthorized), "disabled user can log in: %v", err)
+
+	// Reactivating the user does not bring back the tokens revoked with them
+	reactivated, err := svc.PatchUser(ctx, user.ID, &PatchRequest{
+		Operations: []PatchOperation{{Op: "replace", Path: "active", Value: true}},
+	})
+	require.NoError(t, err)
+	assert.True(t, *reactivated.Active)
+	_, err = svc.auth.ValidateToken(login.AccessToken)
+	assert.Error(t, err)
+
+	logs, _, err := svc.auth.SearchAuditLogs(ctx, auth.AuditLogFilter{Action: "deactivate", Page: 1, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, user.ID, logs[0].ResourceID)
+	assert.Equal(t, "scim", logs[0].UserEmail)
+}
+
+func TestUsers(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	inactive := false
+	var ids []string
+	for _, user := range []*User{
+		{UserName: "alice@example.com", ExternalID: "aad-1", Name: &Name{GivenName: "Alice", FamilyName: "Liddell"}},
+		{UserName: "bob", DisplayName: "Bob", Emails: []Email{{Value: "bob@example.org", Primary: true}}},
+		{UserName: "carol@example.com", DisplayName: "Carol", Active: &inactive},
+	} {
+		created, err := svc.CreateUser(ctx, user)
+		require.NoError(t, err)
+		ids = append(ids, created.ID)
+	}
+
+	alice, err := svc.GetUser(ctx, ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Alice Liddell", alice.DisplayName)
+	assert.Equal(t, "aad-1", alice.ExternalID)
+	assert.True(t, *alice.Active, "active defaults to true")
+	assert.Equal(t, BasePath+"/Users/"+ids[0], alice.Meta.Location)
+
+	_, err = svc.CreateUser(ctx, &User{UserName: "ALICE@example.com"})
+	status, body := ErrorResponse(err)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, ErrUniqueness, body.ScimType)
+	assert.Equal(t, "409", body.Status)
+
+	for filter, want := range map[string][]string{
+		`userName eq "ALICE@example.com"`:                                          {ids[0]},
+		`emails[type eq "work" and value ew "example.org"]`:                        {ids[1]},
+		`active eq false`:                                                          {ids[2]},
+		`externalId pr or displayName sw "b"`:                                      {ids[0], ids[1]},
+		`not (userName co "example.com")`:                                          {ids[1]},
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bob@example.org"`: {ids[1]},
+		`meta.created gt "2000-01-01T00:00:00Z"`:                                   ids,
+	} {
+		list, err := svc.ListUsers(ctx, ListQuery{Filter: filter, StartIndex: 1, Count: DefaultCount})
+		require.NoError(t, err, filter)
+		var got []string
+		for _, resource := range list.Resources {
+			got = append(got, resource.(*User).ID)
+		}
+		assert.Equal(t, want, got, filter)
+		assert.Equal(t, len(want), list.TotalResults, filter)
+	}
+
+	page, err := svc.ListUsers(ctx, ListQuery{StartIndex: 2, Count: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, page.TotalResults)
+	assert.Equal(t, 1, page.ItemsPerPage)
+	assert.Equal(t, ids[1], page.Resources[0].(*User).ID)
+
+	_, err = svc.ListUsers(ctx, ListQuery{Filter: `userName eq`})
+	status, body = ErrorResponse(err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, ErrInvalidFilter, body.ScimType)
+
+	_, err = svc.GetUser(ctx, "not-a-uuid")
+	status, _ = ErrorResponse(err)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	require.NoError(t, svc.DeleteUser(ctx, ids[2]))
+	_, err = svc.GetUser(ctx, ids[2])
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+}
+
+func TestGroupsMapToTeams(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	var userIDs []string
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		user, err := svc.CreateUser(ctx, &User{UserName: email})
+		require.NoError(t, err)
+		userIDs = append(userIDs, user.ID)
+	}
+	alice, bob := userIDs[0], userIDs[1]
+
+	group, err := svc.CreateGroup(ctx, &Group{
+		DisplayName: "platform-admins",
+		ExternalID:  "aad-group-1",
+		Members:     []Member{{Value: alice}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "aad-group-1", group.ExternalID)
+	require.Len(t, group.Members, 1)
+
+	team, err := svc.rbac.GetTeam(ctx, group.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "platform-admins", team.Name)
+	require.Len(t, team.Members, 1)
+	assert.Equal(t, alice, team.Members[0].UserID)
+	assert.Equal(t, "scim", team.Members[0].InvitedBy)
+
+	_, err = svc.CreateGroup(ctx, &Group{DisplayName: "platform-admins"})
+	assert.True(t, errors.Is(err, errors.CodeConflict))
+	_, err = svc.CreateGroup(ctx, &Group{DisplayName: "ghosts", Members: []Member{{Value: "5d7c1e9a-3b2f-4a6d-8e0c-1f2a3b4c5d6e"}}})
+	_, body := ErrorResponse(err)
+	assert.Equal(t, ErrInvalidValue, body.ScimType)
+
+	// Okta adds by value; Azure AD removes by filter
+	group, err = svc.PatchGroup(ctx, group.ID, &PatchRequest{Operations: []PatchOperation{
+		{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": bob}}},
+		{Op: "Remove", Path: fmt.Sprintf("members[value eq %q]", alice)},
+		{Op: "replace", Path: "displayName", Value: "platform-operators"},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "platform-operators", group.DisplayName)
+	require.Len(t, group.Members, 1)
+	assert.Equal(t, bob, group.Members[0].Value)
+
+	list, err := svc.ListGroups(ctx, ListQuery{Filter: fmt.Sprintf(`members[value eq %q]`, bob), Count: DefaultCount})
+	require.NoError(t, err)
+	assert.Equal(t, 1, list.TotalResults)
+
+	// Deleting a user takes them out of their groups
+	require.NoError(t, svc.DeleteUser(ctx, bob))
+	group, err = svc.GetGroup(ctx, group.ID)
+	require.NoError(t, err)
+	assert.Empty(t, group.Members)
+
+	require.NoError(t, svc.DeleteGroup(ctx, group.ID))
+	_, err = svc.GetGroup(ctx, group.ID)
+	status, body := ErrorResponse(err)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "404", body.Status)
+	assert.Equal(t, []string{SchemaError}, body.Schemas)
+}
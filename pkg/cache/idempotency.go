@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PrefixIdempotency prefixes the keys of stored idempotent responses
+const PrefixIdempotency = "idempotency:"
+
+// Idempotency defaults
+const (
+	// DefaultIdempotencyTTL is how long a response is replayed for its key
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// defaultInFlightTTL bounds how long a claim is held by a request that
+	// never completes, e.g. because its replica died
+	defaultInFlightTTL = 2 * time.Minute
+	// defaultInFlightWait is how long a duplicate waits on the first request
+	defaultInFlightWait     = 30 * time.Second
+	idempotencyPollInterval = 50 * time.Millisecond
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key is sent again with a
+	// different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+	// ErrIdempotencyInFlight is returned when the first request with a key
+	// is still running after a duplicate has waited for it
+	ErrIdempotencyInFlight = errors.New("request with this idempotency key is still in progress")
+)
+
+// IdempotentResponse is what's stored under an idempotency key: a claim
+// while the first request runs, then its response
+type IdempotentResponse struct {
+	// Fingerprint identifies the request the key was first sent with
+	Fingerprint string            `json:"fingerprint"`
+	Done        bool              `json:"done"`
+	Status      int               `json:"status,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+}
+
+// Idempotency stores the responses of requests sent with an idempotency
+// key, so a retried request is answered with the original response
+// rather than run again. A nil Idempotency stores nothing.
+type Idempotency struct {
+	cache       *RedisCache
+	ttl         time.Duration
+	inFlightTTL time.Duration
+	wait        time.Duration
+}
+
+// NewIdempotency stores responses in c for ttl, DefaultIdempotencyTTL if
+// zero. Without Redis it returns nil.
+func NewIdempotency(c *RedisCache, ttl time.Duration) *Idempotency {
+	if c == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &Idempotency{cache: c, ttl: ttl, inFlightTTL: defaultInFlightTTL, wait: defaultInFlightWait}
+}
+
+// Claim claims key for the request with fingerprint. It returns nil once
+// claimed, and the caller runs the request then calls Complete or
+// Release. If the key already has a response it is returned instead; if
+// its first request is still running Claim waits for it.
+func (i *Idempotency) Claim(ctx context.Context, key, fingerprint string) (*IdempotentResponse, error) {
+	deadline := time.Now().Add(i.wait)
+	for {
+		claimed, err := i.cache.SetNX(ctx, key, &IdempotentResponse{Fingerprint: fingerprint}, i.inFlightTTL)
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return nil, nil
+		}
+
+		var stored IdempotentResponse
+		err = i.cache.Get(ctx, key, &stored)
+		switch {
+		case IsCacheMiss(err):
+			// Released or expired since; try to claim it again
+			continue
+		case err != nil:
+			return nil, err
+		case stored.Fingerprint != fingerprint:
+			return nil, ErrIdempotencyKeyReused
+		case stored.Done:
+			return &stored, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrIdempotencyInFlight
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(idempotencyPollInterval):
+		}
+	}
+}
+
+// Complete stores the response of the request that claimed key
+func (i *Idempotency) Complete(ctx context.Context, key string, resp *IdempotentResponse) error {
+	resp.Done = true
+	return i.cache.Set(ctx, key, resp, i.ttl)
+}
+
+// Release gives up the claim on key, so the request may be retried, e.g.
+// after it failed in a way worth retrying
+func (i *Idempotency) Release(ctx context.Context, key string) error {
+	return i.cache.Delete(ctx, key)
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyReplaysCompletedResponse(t *testing.T) {
+	c, mr := newTestCache(t)
+	store := NewIdempotency(c, time.Hour)
+	ctx := context.Background()
+
+	stored, err := store.Claim(ctx, "idempotency:k1", "req-a")
+	require.NoError(t, err)
+	require.Nil(t, stored, "first request runs")
+	require.NoError(t, store.Complete(ctx, "idempotency:k1", &IdempotentResponse{Fingerprint: "req-a", Status: 201, Body: []byte(`{"id":"p1"}`)}))
+
+	stored, err = store.Claim(ctx, "idempotency:k1", "req-a")
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, 201, stored.Status)
+	assert.JSONEq(t, `{"id":"p1"}`, string(stored.Body))
+
+	_, err = store.Claim(ctx, "idempotency:k1", "req-b")
+	assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	// Responses expire with the TTL
+	mr.FastForward(2 * time.Hour)
+	stored, err = store.Claim(ctx, "idempotency:k1", "req-b")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestIdempotencyDuplicateWaitsForFirst(t *testing.T) {
+	c, _ := newTestCache(t)
+	store := NewIdempotency(c, time.Hour)
+	ctx := context.Background()
+
+	_, err := store.Claim(ctx, "idempotency:k2", "req")
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		store.Complete(ctx, "idempotency:k2", &IdempotentResponse{Fingerprint: "req", Status: 200})
+	}()
+	stored, err := store.Claim(ctx, "idempotency:k2", "req")
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, 200, stored.Status)
+
+	// A released claim lets the request run again; one never completed
+	// times out its duplicates
+	_, err = store.Claim(ctx, "idempotency:k3", "req")
+	require.NoError(t, err)
+	require.NoError(t, store.Release(ctx, "idempotency:k3"))
+	stored, err = store.Claim(ctx, "idempotency:k3", "req")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	store.wait = 100 * time.Millisecond
+	_, err = store.Claim(ctx, "idempotency:k3", "req")
+	assert.ErrorIs(t, err, ErrIdempotencyInFlight)
+
+	assert.Nil(t, NewIdempotency(nil, 0))
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// ErrLockNotHeld is returned when releasing or refreshing a lock that has
+// expired or been taken over by another holder
+var ErrLockNotHeld = errors.New("lock not held")
+
+// errNoRedis fails lock operations closed when Redis isn't configured
+var errNoRedis = errors.New("redis unavailable")
+
+// acquireScript takes the next fencing token for KEYS[1] from KEYS[2] and
+// stores it in KEYS[1] if the lock is free, returning it, or 0 if held
+var acquireScript = redis.NewScript(`
+local token = redis.call("INCR", KEYS[2])
+if redis.call("SET", KEYS[1], token, "NX", "PX", ARGV[1]) then
+	return token
+end
+return 0
+`)
+
+// releaseScript deletes KEYS[1] only while it still holds token ARGV[1]
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// refreshScript extends KEYS[1] to ARGV[2] ms only while it still holds
+// token ARGV[1]
+var refreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Lock is a held distributed lock. Its fencing token increases with every
+// acquisition of the key, so a resource can reject writes from a holder
+// whose lock has since expired and been taken by another.
+type Lock struct {
+	cache *RedisCache
+	key   string
+	token int64
+}
+
+// Key returns the name the lock was acquired with
+func (l Lock) Key() string { return l.key }
+
+// Token returns the lock's fencing token
+func (l Lock) Token() int64 { return l.token }
+
+// AcquireLock takes the lock named key for ttl unless another holder has
+// it, in which case ok is false. An error means Redis couldn't be asked;
+// callers should then not do the guarded work.
+func (c *RedisCache) AcquireLock(ctx context.Context, key string, ttl time.Duration) (Lock, bool, error) {
+	if c == nil {
+		return Lock{}, false, errNoRedis
+	}
+	keys := []string{lockKey(key), lockKey(key) + ":fence"}
+	token, err := acquireScript.Run(ctx, c.client, keys, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return Lock{}, false, fmt.Errorf("failed to acquire lock %s: %w", key, err)
+	}
+	if token == 0 {
+		return Lock{}, false, nil
+	}
+	return Lock{cache: c, key: key, token: token}, true, nil
+}
+
+// lockKey is the Redis key of the lock named key. The name is hash-tagged
+// so the lock and its fencing counter share a slot on Redis Cluster, which
+// rejects scripts touching keys in different slots.
+func lockKey(key string) string {
+	return "{" + BuildKey(PrefixLock, key) + "}"
+}
+
+// Release frees the lock if it is still held with this lock's token
+func (l Lock) Release(ctx context.Context) error {
+	n, err := releaseScript.Run(ctx, l.cache.client, []string{lockKey(l.key)}, l.token).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", l.key, err)
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Refresh extends the lock to expire ttl from now if it is still held
+func (l Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	n, err := refreshScript.Run(ctx, l.cache.client, []string{lockKey(l.key)}, l.token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to refresh lock %s: %w", l.key, err)
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// WithLock runs fn while holding the lock named key, refreshing it every
+// third of ttl. It returns false without running fn when another holder
+// has the lock, and an error without running fn when Redis can't be
+// reached. If the lock is lost while fn runs, fn's context is cancelled.
+func (c *RedisCache) WithLock(ctx context.Context, key string, ttl time.Duration, fn func(ctx context.Context, lock Lock) error) (bool, error) {
+	lock, ok, err := c.AcquireLock(ctx, key, ttl)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := lock.Refresh(ctx, ttl); err != nil {
+					logger.Warn("Lost lock, cancelling guarded work", zap.String("key", key), zap.Error(err))
+					cancel(fmt.Errorf("lost lock %s: %w", key, err))
+					return
+				}
+			}
+		}
+	}()
+
+	err = fn(ctx, lock)
+	close(done)
+	<-stopped
+	if releaseErr := lock.Release(context.WithoutCancel(ctx)); releaseErr != nil && !errors.Is(releaseErr, ErrLockNotHeld) {
+		logger.Warn("Failed to release lock", zap.String("key", key), zap.Error(releaseErr))
+	}
+	return true, err
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCache(t *testing.T) (*RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &RedisCache{client: client}, mr
+}
+
+func TestAcquireLock(t *testing.T) {
+	c, mr := newTestCache(t)
+	ctx := context.Background()
+
+	first, ok, err := c.AcquireLock(ctx, "cron:nightly", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, ok, err = c.AcquireLock(ctx, "cron:nightly", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok, "held by another")
+
+	// Once it expires the next holder gets a higher fencing token, and the
+	// old holder can neither extend nor release it
+	mr.FastForward(2 * time.Minute)
+	second, ok, err := c.AcquireLock(ctx, "cron:nightly", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Greater(t, second.Token(), first.Token())
+	assert.ErrorIs(t, first.Refresh(ctx, time.Minute), ErrLockNotHeld)
+	assert.ErrorIs(t, first.Release(ctx), ErrLockNotHeld)
+	assert.True(t, mr.Exists("{lock:cron:nightly}"))
+	assert.True(t, mr.Exists("{lock:cron:nightly}:fence"), "the fencing counter shares the lock's hash tag")
+
+	// Refreshing keeps it past its original expiry
+	require.NoError(t, second.Refresh(ctx, 3*time.Minute))
+	mr.FastForward(2 * time.Minute)
+	assert.True(t, mr.Exists("{lock:cron:nightly}"))
+
+	require.NoError(t, second.Release(ctx))
+	assert.False(t, mr.Exists("{lock:cron:nightly}"))
+	_, ok, err = c.AcquireLock(ctx, "cron:nightly", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestWithLockMutualExclusion(t *testing.T) {
+	c, _ := newTestCache(t)
+	ctx := context.Background()
+
+	var inside, ran, overlaps atomic.Int32
+	var lastToken atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := c.WithLock(ctx, "reconcile", time.Minute, func(ctx context.Context, lock Lock) error {
+					if inside.Add(1) > 1 {
+						overlaps.Add(1)
+					}
+					if lock.Token() <= lastToken.Load() {
+						overlaps.Add(1)
+					}
+					lastToken.Store(lock.Token())
+					ran.Add(1)
+					time.Sleep(time.Millisecond)
+					inside.Add(-1)
+					return nil
+				})
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Zero(t, overlaps.Load(), "two holders ran at once")
+	assert.Positive(t, ran.Load())
+}
+
+func TestWithLockCancelsWhenLockIsLost(t *testing.T) {
+	c, mr := newTestCache(t)
+
+	ran, err := c.WithLock(context.Background(), "leader", 30*time.Millisecond, func(ctx context.Context, lock Lock) error {
+		// Another replica takes over after the lock expired
+		mr.Del("{lock:leader}")
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(5 * time.Second):
+			return errors.New("still running")
+		}
+	})
+	assert.True(t, ran)
+	assert.ErrorIs(t, err, ErrLockNotHeld)
+}
+
+func TestLockFailsClosedWithoutRedis(t *testing.T) {
+	c, mr := newTestCache(t)
+	mr.Close()
+
+	ran, err := c.WithLock(context.Background(), "leader", time.Minute, func(context.Context, Lock) error {
+		t.Fatal("guarded work ran without the lock")
+		return nil
+	})
+	assert.False(t, ran)
+	assert.Error(t, err)
+
+	var none *RedisCache
+	ran, err = none.WithLock(context.Background(), "leader", time.Minute, func(context.Context, Lock) error {
+		t.Fatal("guarded work ran without Redis")
+		return nil
+	})
+	assert.False(t, ran)
+	assert.Error(t, err)
+}
@@ -10,10 +10,14 @@ import (
 
 	"github.com/anubhavg-icpl/krustron/pkg/config"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/metrics"
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 )
 
+// cacheMetrics counts Get and HGet hits and misses
+var cacheMetrics = metrics.NewCache("redis")
+
 // RedisCache wraps the Redis client
 type RedisCache struct {
 	client *redis.Client
@@ -74,10 +78,12 @@ func (c *RedisCache) Get(ctx context.Context, key string, dest interface{}) erro
 	data, err := c.client.Get(ctx, key).Bytes()
 	if err != nil {
 		if err == redis.Nil {
+			cacheMetrics.Miss()
 			return ErrCacheMiss
 		}
 		return fmt.Errorf("failed to get value: %w", err)
 	}
+	cacheMetrics.Hit()
 
 	if err := json.Unmarshal(data, dest); err != nil {
 		return fmt.Errorf("failed to unmarshal value: %w", err)
@@ -150,10 +156,12 @@ func (c *RedisCache) HGet(ctx context.Context, key, field string, dest interface
 	data, err := c.client.HGet(ctx, key, field).Bytes()
 	if err != nil {
 		if err == redis.Nil {
+			cacheMetrics.Miss()
 			return ErrCacheMiss
 		}
 		return fmt.Errorf("failed to get value: %w", err)
 	}
+	cacheMetrics.Hit()
 
 	if err := json.Unmarshal(data, dest); err != nil {
 		return fmt.Errorf("failed to unmarshal value: %w", err)
@@ -253,6 +261,7 @@ const (
 	PrefixLock       = "lock:"
 	PrefixRateLimit  = "ratelimit:"
 	PrefixWebhook    = "webhook:"
+	PrefixCost       = "cost:"
 )
 
 // BuildKey builds a cache key with prefix
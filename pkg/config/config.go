@@ -20,8 +20,15 @@ type Config struct {
 	Auth        AuthConfig        `mapstructure:"auth"`
 	Kubernetes  KubernetesConfig  `mapstructure:"kubernetes"`
 	GitOps      GitOpsConfig      `mapstructure:"gitops"`
+	Helm        HelmConfig        `mapstructure:"helm"`
+	Pipeline    PipelineConfig    `mapstructure:"pipeline"`
 	Observability ObservabilityConfig `mapstructure:"observability"`
 	Security    SecurityConfig    `mapstructure:"security"`
+	Webhooks    WebhooksConfig    `mapstructure:"webhooks"`
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+	Cost        CostConfig        `mapstructure:"cost"`
+	Remediation RemediationConfig `mapstructure:"remediation"`
+	Secrets     SecretsConfig     `mapstructure:"secrets"`
 	AI          AIConfig          `mapstructure:"ai"`
 	Logger      LoggerConfig      `mapstructure:"logger"`
 }
@@ -34,11 +41,19 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout    time.Duration `mapstructure:"write_timeout"`
 	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// DrainDelay is how long /ready reports not ready at shutdown before
+	// the server stops accepting connections, so load balancers stop
+	// sending traffic first
+	DrainDelay      time.Duration `mapstructure:"drain_delay"`
 	Mode            string        `mapstructure:"mode"` // debug, release, test
 	CorsOrigins     []string      `mapstructure:"cors_origins"`
 	TLSEnabled      bool          `mapstructure:"tls_enabled"`
 	TLSCert         string        `mapstructure:"tls_cert"`
 	TLSKey          string        `mapstructure:"tls_key"`
+	// RateLimit is the requests per second each client IP may make, with
+	// bursts of RateBurst
+	RateLimit       float64       `mapstructure:"rate_limit"`
+	RateBurst       int           `mapstructure:"rate_burst"`
 }
 
 // DatabaseConfig holds PostgreSQL configuration
@@ -96,6 +111,9 @@ type AuthConfig struct {
 	UseCookie     bool   `mapstructure:"use_cookie"`
 	CookieDomain  string `mapstructure:"cookie_domain"`
 	CookieSecure  bool   `mapstructure:"cookie_secure"`
+	// SCIMToken is the bearer token identity providers provision users
+	// with over SCIM. SCIM is disabled while it is empty.
+	SCIMToken string `mapstructure:"scim_token"`
 }
 
 // KubernetesConfig holds Kubernetes client configuration
@@ -108,6 +126,25 @@ type KubernetesConfig struct {
 	Burst               int           `mapstructure:"burst"`
 	AgentImage          string        `mapstructure:"agent_image"`
 	AgentNamespace      string        `mapstructure:"agent_namespace"`
+	// HealthCheckInterval is how often every registered cluster is checked
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+	// HealthCheckConcurrency caps how many clusters are checked at once
+	HealthCheckConcurrency int        `mapstructure:"health_check_concurrency"`
+	// BulkConcurrency caps how many clusters a bulk operation works on at once
+	BulkConcurrency int `mapstructure:"bulk_concurrency"`
+	// PipelineNamespace is where pipeline stage Jobs run
+	PipelineNamespace string `mapstructure:"pipeline_namespace"`
+}
+
+// HelmConfig holds Helm configuration
+type HelmConfig struct {
+	// ChartCacheDir caches charts pulled from OCI registries; defaults to
+	// a directory in the system temp directory
+	ChartCacheDir string        `mapstructure:"chart_cache_dir"`
+	ChartCacheTTL time.Duration `mapstructure:"chart_cache_ttl"`
+	// StrictRender renders charts without a values.schema.json strictly,
+	// failing on references to undefined values
+	StrictRender bool `mapstructure:"strict_render"`
 }
 
 // GitOpsConfig holds GitOps configuration
@@ -115,11 +152,35 @@ type GitOpsConfig struct {
 	Enabled           bool          `mapstructure:"enabled"`
 	Provider          string        `mapstructure:"provider"` // argocd, flux
 	ArgoCD            ArgoCDConfig  `mapstructure:"argocd"`
+	// SyncInterval is how often every application is reconciled
 	SyncInterval      time.Duration `mapstructure:"sync_interval"`
+	// ReconcileConcurrency caps how many applications are reconciled at once
+	ReconcileConcurrency int `mapstructure:"reconcile_concurrency"`
+	// RepoCacheDir holds Git checkouts; defaults to a directory under the
+	// system temp dir
+	RepoCacheDir string `mapstructure:"repo_cache_dir"`
+	// CloneDepth is how many commits are fetched per repository and
+	// submodule; 0 fetches the full history
+	CloneDepth int `mapstructure:"clone_depth"`
+	// SSHKnownHostsFile verifies SSH repository hosts whose credentials
+	// carry no known_hosts
+	SSHKnownHostsFile string `mapstructure:"ssh_known_hosts_file"`
+	// RepoCredentials are the only credential refs applications may name,
+	// each bound to the repository hosts it was issued for
+	RepoCredentials []RepoCredential `mapstructure:"repo_credentials"`
+	// PruneEnabled and SelfHealEnabled allow auto-sync to prune and to
+	// revert drift for applications that ask for it
 	PruneEnabled      bool          `mapstructure:"prune_enabled"`
 	SelfHealEnabled   bool          `mapstructure:"self_heal_enabled"`
 }
 
+// RepoCredential registers the secret at Ref for repositories on Hosts
+// (e.g. "github.com", or "git.example.com:2222" to pin a port)
+type RepoCredential struct {
+	Ref   string   `mapstructure:"ref"`
+	Hosts []string `mapstructure:"hosts"`
+}
+
 // ArgoCDConfig holds ArgoCD specific configuration
 type ArgoCDConfig struct {
 	ServerURL   string `mapstructure:"server_url"`
@@ -128,6 +189,33 @@ type ArgoCDConfig struct {
 	Namespace   string `mapstructure:"namespace"`
 }
 
+// PipelineConfig holds CI/CD pipeline configuration
+type PipelineConfig struct {
+	Logs PipelineLogsConfig `mapstructure:"logs"`
+}
+
+// PipelineLogsConfig holds where stage output is stored and for how long
+type PipelineLogsConfig struct {
+	Backend   string        `mapstructure:"backend"` // database, s3
+	Retention time.Duration `mapstructure:"retention"`
+	// MaxBytes caps the output kept per stage; the tail is kept
+	MaxBytes int64    `mapstructure:"max_bytes"`
+	S3       S3Config `mapstructure:"s3"`
+}
+
+// S3Config holds S3-compatible object storage configuration
+type S3Config struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	Region          string `mapstructure:"region"`
+	Bucket          string `mapstructure:"bucket"`
+	Prefix          string `mapstructure:"prefix"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	// PathStyle addresses the bucket as endpoint/bucket rather than
+	// bucket.endpoint, as MinIO and most self-hosted stores expect
+	PathStyle bool `mapstructure:"path_style"`
+}
+
 // ObservabilityConfig holds observability configuration
 type ObservabilityConfig struct {
 	Metrics     MetricsConfig     `mapstructure:"metrics"`
@@ -189,15 +277,119 @@ type SecurityConfig struct {
 	BlockOnCritical bool          `mapstructure:"block_on_critical"`
 }
 
+// WebhooksConfig holds where outbound webhooks, such as access request
+// and budget alert notifications, may be sent. Loopback, private and
+// link-local addresses are refused unless allow-listed.
+type WebhooksConfig struct {
+	AllowHTTP bool `mapstructure:"allow_http"`
+	// AllowedHosts are host names, or "*.example.com", that may resolve
+	// to any address
+	AllowedHosts []string `mapstructure:"allowed_hosts"`
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+	// DeniedCIDRs are refused even for allowed hosts
+	DeniedCIDRs      []string      `mapstructure:"denied_cidrs"`
+	Timeout          time.Duration `mapstructure:"timeout"`
+	MaxResponseBytes int64         `mapstructure:"max_response_bytes"`
+}
+
+// NotificationsConfig holds the mail server email notifications, such as
+// budget alerts and email notification channels, are sent through
+type NotificationsConfig struct {
+	SMTPAddr     string `mapstructure:"smtp_addr"` // host:port
+	SMTPFrom     string `mapstructure:"smtp_from"`
+	SMTPUsername string `mapstructure:"smtp_username"`
+	SMTPPassword string `mapstructure:"smtp_password"`
+}
+
+// CostConfig holds cost management configuration
+type CostConfig struct {
+	// CacheEnabled caches cost summaries and reports in Redis for CacheTTL.
+	// Ingesting allocations drops those covering the ingested period.
+	CacheEnabled bool          `mapstructure:"cache_enabled"`
+	CacheTTL     time.Duration `mapstructure:"cache_ttl"`
+	// RemediateRecommendations are the recommendation types, rightsizing
+	// or idle_gpu, sent to remediation to be acted on when generated.
+	// Needs remediation enabled.
+	RemediateRecommendations []string `mapstructure:"remediate_recommendations"`
+	// GPUUtilizationQuery overrides the PromQL query, run against
+	// observability.prometheus, giving each namespace's GPU utilization
+	// (%) so idle GPUs are flagged. "$cluster" is replaced by the cluster
+	// name.
+	GPUUtilizationQuery string `mapstructure:"gpu_utilization_query"`
+}
+
+// RemediationConfig holds auto-remediation configuration
+type RemediationConfig struct {
+	// Enabled watches every cluster's events and runs the matching rules
+	Enabled bool `mapstructure:"enabled"`
+	// DryRun records actions without applying them, unless a cluster
+	// overrides it
+	DryRun          bool `mapstructure:"dry_run"`
+	RequireApproval bool `mapstructure:"require_approval"`
+	// WatchPodStatus also watches pods for containers waiting or terminated
+	// for a watched reason, such as OOMKilled
+	WatchPodStatus bool `mapstructure:"watch_pod_status"`
+}
+
 // AIConfig holds AI/LLM configuration
 type AIConfig struct {
 	Enabled      bool   `mapstructure:"enabled"`
-	Provider     string `mapstructure:"provider"` // ollama, openai, anthropic
+	Provider     string `mapstructure:"provider"` // ollama, openai, anthropic, azure, bedrock
 	Endpoint     string `mapstructure:"endpoint"`
 	Model        string `mapstructure:"model"`
 	APIKey       string `mapstructure:"api_key"`
+	// APIKeyRef reads the key from the api_key field of this secret
+	// instead, so it can be rotated without a restart
+	APIKeyRef    string `mapstructure:"api_key_ref"`
 	MaxTokens    int    `mapstructure:"max_tokens"`
 	Temperature  float64 `mapstructure:"temperature"`
+	// Azure OpenAI: requests go to the deployment on the resource, or on
+	// endpoint when set, with api_key as the api-key header
+	AzureResource   string `mapstructure:"azure_resource"`
+	AzureDeployment string `mapstructure:"azure_deployment"`
+	AzureAPIVersion string `mapstructure:"azure_api_version"`
+	// AWS Bedrock: model is the Bedrock model ID, and requests are signed
+	// with the AWS credentials
+	BedrockRegion      string `mapstructure:"bedrock_region"`
+	AWSAccessKeyID     string `mapstructure:"aws_access_key_id"`
+	AWSSecretAccessKey string `mapstructure:"aws_secret_access_key"`
+	AWSSessionToken    string `mapstructure:"aws_session_token"`
+}
+
+// SecretsConfig selects the backend credentials are stored in: cluster
+// kubeconfigs, cloud, registry and repository credentials, and API keys
+type SecretsConfig struct {
+	// Backend is kubernetes, vault, env or database
+	Backend string `mapstructure:"backend"`
+	// Namespace holds the kubernetes backend's Secrets; defaults to
+	// kubernetes.agent_namespace
+	Namespace string `mapstructure:"namespace"`
+	// EnvPrefix names the env backend's variables: ref "git-creds" is read
+	// from <EnvPrefix>GIT_CREDS
+	EnvPrefix string `mapstructure:"env_prefix"`
+	// DataKey is a base64 AES-256 key encrypting secrets the env and
+	// database backends hold; required for database
+	DataKey string `mapstructure:"data_key"`
+	// CacheTTL is how long a secret is served from memory before it is
+	// read again, picking up rotations made outside krustron
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	// FieldKeysRef is the secret holding the keys that encrypt sensitive
+	// database columns, base64 32-byte keys by key ID; FieldKeyID is the
+	// one new values are encrypted with. Unset leaves columns in plaintext.
+	FieldKeysRef string      `mapstructure:"field_keys_ref"`
+	FieldKeyID   string      `mapstructure:"field_key_id"`
+	Vault        VaultConfig `mapstructure:"vault"`
+}
+
+// VaultConfig holds HashiCorp Vault KV v2 configuration
+type VaultConfig struct {
+	Address   string `mapstructure:"address"`
+	Token     string `mapstructure:"token"`
+	Namespace string `mapstructure:"namespace"`
+	// Mount is the KV v2 engine's path and Prefix the path under it
+	// secrets are kept at
+	Mount  string `mapstructure:"mount"`
+	Prefix string `mapstructure:"prefix"`
 }
 
 // LoggerConfig holds logger configuration
@@ -210,6 +402,13 @@ type LoggerConfig struct {
 
 // Load loads configuration from file and environment
 func Load(configPath string) (*Config, error) {
+	cfg, _, err := load(configPath)
+	return cfg, err
+}
+
+// load loads the configuration and returns the file it was read from,
+// empty when none was found
+func load(configPath string) (*Config, string, error) {
 	v := viper.New()
 
 	// Set defaults
@@ -234,19 +433,19 @@ func Load(configPath string) (*Config, error) {
 	// Read config file
 	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return nil, fmt.Errorf("error reading config file: %w", err)
+			return nil, "", fmt.Errorf("error reading config file: %w", err)
 		}
 	}
 
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
-		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+		return nil, "", fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
 	// Override with environment variables for sensitive data
 	overrideFromEnv(&cfg)
 
-	return &cfg, nil
+	return &cfg, v.ConfigFileUsed(), nil
 }
 
 // setDefaults sets default configuration values
@@ -258,8 +457,11 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("server.read_timeout", "30s")
 	v.SetDefault("server.write_timeout", "30s")
 	v.SetDefault("server.shutdown_timeout", "10s")
+	v.SetDefault("server.drain_delay", "5s")
 	v.SetDefault("server.mode", "release")
 	v.SetDefault("server.cors_origins", []string{"*"})
+	v.SetDefault("server.rate_limit", 100)
+	v.SetDefault("server.rate_burst", 200)
 
 	// Database defaults
 	v.SetDefault("database.host", "localhost")
@@ -302,14 +504,32 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("kubernetes.burst", 100)
 	v.SetDefault("kubernetes.agent_image", "ghcr.io/anubhavg-icpl/krustron-agent:latest")
 	v.SetDefault("kubernetes.agent_namespace", "krustron-system")
+	v.SetDefault("kubernetes.health_check_interval", "1m")
+	v.SetDefault("kubernetes.health_check_concurrency", 10)
+	v.SetDefault("kubernetes.bulk_concurrency", 10)
+	v.SetDefault("kubernetes.pipeline_namespace", "krustron-pipelines")
+
+	// Helm defaults
+	v.SetDefault("helm.chart_cache_ttl", "10m")
+	v.SetDefault("helm.strict_render", false)
 
 	// GitOps defaults
 	v.SetDefault("gitops.enabled", true)
 	v.SetDefault("gitops.provider", "argocd")
 	v.SetDefault("gitops.sync_interval", "3m")
+	v.SetDefault("gitops.reconcile_concurrency", 5)
+	v.SetDefault("gitops.clone_depth", 1)
 	v.SetDefault("gitops.prune_enabled", true)
 	v.SetDefault("gitops.self_heal_enabled", true)
 
+	// Pipeline defaults
+	v.SetDefault("pipeline.logs.backend", "database")
+	v.SetDefault("pipeline.logs.retention", "720h")
+	v.SetDefault("pipeline.logs.max_bytes", 1<<20)
+	v.SetDefault("pipeline.logs.s3.region", "us-east-1")
+	v.SetDefault("pipeline.logs.s3.prefix", "pipeline-logs")
+	v.SetDefault("pipeline.logs.s3.path_style", true)
+
 	// Observability defaults
 	v.SetDefault("observability.metrics.enabled", true)
 	v.SetDefault("observability.metrics.path", "/metrics")
@@ -324,6 +544,21 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("security.scan_interval", "1h")
 	v.SetDefault("security.block_on_critical", true)
 
+	// Webhook defaults
+	v.SetDefault("webhooks.allow_http", false)
+	v.SetDefault("webhooks.timeout", "10s")
+	v.SetDefault("webhooks.max_response_bytes", 1<<20)
+
+	// Cost defaults
+	v.SetDefault("cost.cache_enabled", true)
+	v.SetDefault("cost.cache_ttl", "15m")
+
+	// Remediation defaults
+	v.SetDefault("remediation.enabled", false)
+	v.SetDefault("remediation.dry_run", true)
+	v.SetDefault("remediation.require_approval", false)
+	v.SetDefault("remediation.watch_pod_status", true)
+
 	// AI defaults
 	v.SetDefault("ai.enabled", false)
 	v.SetDefault("ai.provider", "ollama")
@@ -331,6 +566,14 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("ai.model", "llama3")
 	v.SetDefault("ai.max_tokens", 2048)
 	v.SetDefault("ai.temperature", 0.7)
+	v.SetDefault("ai.azure_api_version", "2024-02-01")
+
+	// Secrets defaults
+	v.SetDefault("secrets.backend", "kubernetes")
+	v.SetDefault("secrets.env_prefix", "KRUSTRON_SECRET_")
+	v.SetDefault("secrets.cache_ttl", "5m")
+	v.SetDefault("secrets.vault.mount", "secret")
+	v.SetDefault("secrets.vault.prefix", "krustron")
 
 	// Logger defaults
 	v.SetDefault("logger.level", "info")
@@ -356,15 +599,30 @@ func overrideFromEnv(cfg *Config) {
 	if v := os.Getenv("KRUSTRON_AUTH_OIDC_CLIENT_SECRET"); v != "" {
 		cfg.Auth.OIDCClientSecret = v
 	}
+	if v := os.Getenv("KRUSTRON_AUTH_SCIM_TOKEN"); v != "" {
+		cfg.Auth.SCIMToken = v
+	}
 	if v := os.Getenv("KRUSTRON_GITOPS_ARGOCD_AUTH_TOKEN"); v != "" {
 		cfg.GitOps.ArgoCD.AuthToken = v
 	}
+	if v := os.Getenv("KRUSTRON_PIPELINE_LOGS_S3_SECRET_ACCESS_KEY"); v != "" {
+		cfg.Pipeline.Logs.S3.SecretAccessKey = v
+	}
 	if v := os.Getenv("KRUSTRON_SECURITY_WAZUH_API_KEY"); v != "" {
 		cfg.Security.WazuhAPIKey = v
 	}
+	if v := os.Getenv("KRUSTRON_NOTIFICATIONS_SMTP_PASSWORD"); v != "" {
+		cfg.Notifications.SMTPPassword = v
+	}
 	if v := os.Getenv("KRUSTRON_AI_API_KEY"); v != "" {
 		cfg.AI.APIKey = v
 	}
+	if v := os.Getenv("KRUSTRON_SECRETS_DATA_KEY"); v != "" {
+		cfg.Secrets.DataKey = v
+	}
+	if v := os.Getenv("KRUSTRON_SECRETS_VAULT_TOKEN"); v != "" {
+		cfg.Secrets.Vault.Token = v
+	}
 }
 
 // DSN returns the PostgreSQL connection string
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce lets an editor or a ConfigMap update finish writing
+// before the file is read
+const reloadDebounce = 100 * time.Millisecond
+
+// Subscriber is a subsystem that takes configuration changes without a
+// restart
+type Subscriber struct {
+	Name string
+	// Check rejects a configuration the subsystem can't apply; optional.
+	// A reload is only applied once every subscriber accepts it.
+	Check func(cfg *Config) error
+	// Apply switches the subsystem to cfg
+	Apply func(cfg *Config)
+}
+
+// Reloader re-reads the configuration when its file changes or the
+// process receives SIGHUP, and hands each accepted configuration to its
+// subscribers. Settings no subscriber applies keep their startup values
+// until a restart.
+type Reloader struct {
+	path string
+
+	mu      sync.Mutex
+	file    string
+	digest  []byte
+	current *Config
+	subs    []Subscriber
+
+	// OnError is called with every rejected reload; optional
+	OnError func(err error)
+	// OnReload is called after every applied reload; optional
+	OnReload func(cfg *Config)
+}
+
+// NewReloader creates a reloader for the configuration cfg was loaded from
+// configPath with
+func NewReloader(configPath string, cfg *Config) *Reloader {
+	return &Reloader{path: configPath, current: cfg}
+}
+
+// Subscribe registers a subsystem for configuration changes
+func (r *Reloader) Subscribe(s Subscriber) {
+	r.mu.Lock()
+	r.subs = append(r.subs, s)
+	r.mu.Unlock()
+}
+
+// Current returns the configuration last applied
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload reads the configuration again and applies it when it validates
+// and every subscriber accepts it. A configuration that fails to parse or
+// is rejected leaves the running one in place and is returned as an error
+// listing every problem.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reload(true)
+}
+
+// reload re-reads the configuration; unless force is set, an unchanged
+// file is left alone
+func (r *Reloader) reload(force bool) error {
+	cfg, file, err := load(r.path)
+	if err != nil {
+		return err
+	}
+	digest, _ := os.ReadFile(file)
+	if !force && file == r.file && bytes.Equal(digest, r.digest) {
+		return nil
+	}
+	r.file, r.digest = file, digest
+
+	var problems []error
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	for _, s := range r.subs {
+		if s.Check == nil {
+			continue
+		}
+		if err := s.Check(cfg); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", s.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("config reload rejected: %w", errors.Join(problems...))
+	}
+
+	for _, s := range r.subs {
+		s.Apply(cfg)
+	}
+	r.current = cfg
+	if r.OnReload != nil {
+		r.OnReload(cfg)
+	}
+	return nil
+}
+
+// Run reloads on SIGHUP and whenever the configuration file's directory
+// changes, until ctx ends. Watching the directory rather than the file
+// follows editors that replace the file and ConfigMap volumes that swap
+// symlinks.
+func (r *Reloader) Run(ctx context.Context) error {
+	r.mu.Lock()
+	_, file, err := load(r.path)
+	if err == nil {
+		r.file = file
+		r.digest, _ = os.ReadFile(file)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if file != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("failed to watch config file: %w", err)
+		}
+		defer watcher.Close()
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			return fmt.Errorf("failed to watch config file: %w", err)
+		}
+		events, watchErrors = watcher.Events, watcher.Errors
+	}
+
+	debounce := time.NewTimer(0)
+	<-debounce.C
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			force = true
+		case <-events:
+			debounce.Reset(reloadDebounce)
+			continue
+		case err := <-watchErrors:
+			r.report(fmt.Errorf("config watch failed: %w", err))
+			continue
+		case <-debounce.C:
+		}
+
+		r.mu.Lock()
+		err := r.reload(force)
+		r.mu.Unlock()
+		if err != nil {
+			r.report(err)
+		}
+	}
+}
+
+func (r *Reloader) report(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
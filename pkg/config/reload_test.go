@@ -0,0 +1,130 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+// loggerSubscriber applies the log level as runServer does
+var loggerSubscriber = Subscriber{
+	Name:  "logger",
+	Check: func(c *Config) error { _, err := zapcore.ParseLevel(c.Logger.Level); return err },
+	Apply: func(c *Config) { logger.SetLevel(c.Logger.Level) },
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	// Replace the file as editors and ConfigMap updates do
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+func TestReloaderAppliesWatchedFile(t *testing.T) {
+	t.Setenv("KRUSTRON_AUTH_JWT_SECRET", testJWTSecret)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "logger:\n  level: info\n")
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	require.NoError(t, logger.SetLevel(cfg.Logger.Level))
+	t.Cleanup(func() { logger.SetLevel("info") })
+
+	reloader := NewReloader(path, cfg)
+	reloader.Subscribe(loggerSubscriber)
+	rejected := make(chan error, 1)
+	reloader.OnError = func(err error) { rejected <- err }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- reloader.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	// The watch starts asynchronously, so keep rewriting until it is seen
+	require.Eventually(t, func() bool {
+		writeConfig(t, path, "logger:\n  level: debug\n")
+		return logger.Level() == zapcore.DebugLevel
+	}, 5*time.Second, 4*reloadDebounce)
+	assert.Equal(t, "debug", reloader.Current().Logger.Level)
+	assert.True(t, logger.Get().Core().Enabled(zapcore.DebugLevel))
+
+	// An invalid level is rejected and the running config kept
+	writeConfig(t, path, "logger:\n  level: loud\n")
+	select {
+	case err := <-rejected:
+		assert.Contains(t, err.Error(), "logger:")
+	case <-time.After(5 * time.Second):
+		t.Fatal("invalid config was not rejected")
+	}
+	assert.Equal(t, zapcore.DebugLevel, logger.Level())
+	assert.Equal(t, "debug", reloader.Current().Logger.Level)
+}
+
+func TestReloadRejectsWithoutApplying(t *testing.T) {
+	t.Setenv("KRUSTRON_AUTH_JWT_SECRET", testJWTSecret)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "server:\n  rate_limit: 10\n  rate_burst: 20\n")
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, cfg.Server.RateLimit)
+
+	var applied []string
+	reloader := NewReloader(path, cfg)
+	reloader.Subscribe(Subscriber{
+		Name: "rate_limit",
+		Check: func(c *Config) error {
+			if c.Server.RateLimit <= 0 {
+				return fmt.Errorf("rate_limit must be positive")
+			}
+			return nil
+		},
+		Apply: func(c *Config) { applied = append(applied, "rate_limit") },
+	})
+	reloader.Subscribe(Subscriber{
+		Name: "burst",
+		Check: func(c *Config) error {
+			if c.Server.RateBurst <= 0 {
+				return fmt.Errorf("rate_burst must be positive")
+			}
+			return nil
+		},
+		Apply: func(c *Config) { applied = append(applied, "burst") },
+	})
+
+	// Every problem is reported and no subscriber applies a partial reload
+	writeConfig(t, path, "server:\n  rate_limit: 0\n  rate_burst: -1\n")
+	err = reloader.Reload()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rate_limit: rate_limit must be positive")
+	assert.Contains(t, err.Error(), "burst: rate_burst must be positive")
+	assert.Empty(t, applied)
+	assert.Same(t, cfg, reloader.Current())
+
+	writeConfig(t, path, "server: [not a map\n")
+	assert.Error(t, reloader.Reload())
+	assert.Same(t, cfg, reloader.Current())
+
+	// Settings the server validates are checked before the subscribers
+	writeConfig(t, path, "server:\n  rate_limit: 50\n  rate_burst: 100\n  port: 70000\n")
+	err = reloader.Reload()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.port 70000 is not a valid port")
+	assert.Empty(t, applied)
+	assert.Same(t, cfg, reloader.Current())
+
+	writeConfig(t, path, "server:\n  rate_limit: 50\n  rate_burst: 100\n")
+	require.NoError(t, reloader.Reload())
+	assert.Equal(t, []string{"rate_limit", "burst"}, applied)
+	assert.Equal(t, 50.0, reloader.Current().Server.RateLimit)
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// minJWTSecretLength matches the HMAC key size auth requires
+const minJWTSecretLength = 32
+
+// minJWTSecretEntropy is the Shannon entropy, in bits per character, below
+// which a JWT secret is treated as guessable ("aaaa…", "abcabc…")
+const minJWTSecretEntropy = 3.0
+
+// insecureJWTSecrets are secrets shipped in examples and docker-compose
+var insecureJWTSecrets = map[string]bool{
+	"dev-only-insecure-secret-change-me-32chars-min": true,
+}
+
+// Validate checks the settings the server can't start without and returns
+// one error listing every problem, so a misconfiguration is reported before
+// anything connects rather than as a downstream failure
+func (c *Config) Validate() error {
+	var problems []error
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	// Server
+	if c.Server.Host == "" {
+		add("server.host is required")
+	}
+	if !validPort(c.Server.Port) {
+		add("server.port %d is not a valid port", c.Server.Port)
+	}
+	if !validPort(c.Server.GRPCPort) {
+		add("server.grpc_port %d is not a valid port", c.Server.GRPCPort)
+	} else if c.Server.GRPCPort == c.Server.Port {
+		add("server.grpc_port must differ from server.port")
+	}
+	switch c.Server.Mode {
+	case "debug", "release", "test":
+	default:
+		add("server.mode %q must be debug, release or test", c.Server.Mode)
+	}
+	if c.Server.TLSEnabled && (c.Server.TLSCert == "" || c.Server.TLSKey == "") {
+		add("server.tls_cert and server.tls_key are required when server.tls_enabled is set")
+	}
+
+	// Database
+	if c.Database.Host == "" {
+		add("database.host is required")
+	}
+	if !validPort(c.Database.Port) {
+		add("database.port %d is not a valid port", c.Database.Port)
+	}
+	if c.Database.User == "" {
+		add("database.user is required")
+	}
+	if c.Database.Database == "" {
+		add("database.database is required")
+	}
+
+	// Auth: every session, local or OIDC, is a JWT signed with the secret
+	switch secret := c.Auth.JWTSecret; {
+	case secret == "":
+		add("auth.jwt_secret is required (set KRUSTRON_AUTH_JWT_SECRET)")
+	case len(secret) < minJWTSecretLength:
+		add("auth.jwt_secret must be at least %d bytes, got %d", minJWTSecretLength, len(secret))
+	case shannonEntropy(secret) < minJWTSecretEntropy:
+		add("auth.jwt_secret is too predictable; use a random value such as `openssl rand -base64 48`")
+	}
+	if c.Auth.OIDCEnabled {
+		var missing []string
+		for _, field := range []struct{ key, value string }{
+			{"auth.oidc_issuer", c.Auth.OIDCIssuer},
+			{"auth.oidc_client_id", c.Auth.OIDCClientID},
+			{"auth.oidc_redirect_url", c.Auth.OIDCRedirectURL},
+		} {
+			if field.value == "" {
+				missing = append(missing, field.key)
+			}
+		}
+		if len(missing) > 0 {
+			add("auth.oidc_enabled requires %s", strings.Join(missing, ", "))
+		}
+	}
+	if c.Auth.BCryptCost != 0 && (c.Auth.BCryptCost < 4 || c.Auth.BCryptCost > 31) {
+		add("auth.bcrypt_cost %d must be between 4 and 31", c.Auth.BCryptCost)
+	}
+
+	// Secrets
+	switch c.Secrets.Backend {
+	case "kubernetes", "env":
+	case "database":
+		if c.Secrets.DataKey == "" {
+			add("secrets.data_key is required for the database backend (set KRUSTRON_SECRETS_DATA_KEY)")
+		}
+	case "vault":
+		if c.Secrets.Vault.Address == "" {
+			add("secrets.vault.address is required for the vault backend")
+		}
+		if c.Secrets.Vault.Token == "" {
+			add("secrets.vault.token is required for the vault backend (set KRUSTRON_SECRETS_VAULT_TOKEN)")
+		}
+	default:
+		add("secrets.backend %q must be kubernetes, vault, env or database", c.Secrets.Backend)
+	}
+	if c.Secrets.FieldKeysRef != "" && c.Secrets.FieldKeyID == "" {
+		add("secrets.field_key_id is required with secrets.field_keys_ref")
+	}
+
+	if m := c.Observability.Metrics; m.Enabled && !strings.HasPrefix(m.Path, "/") {
+		add("observability.metrics.path %q must start with /", m.Path)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration: %w", errors.Join(problems...))
+}
+
+// Warnings lists settings that work but are unsafe outside development
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if insecureJWTSecrets[c.Auth.JWTSecret] {
+		warnings = append(warnings, "auth.jwt_secret is a published default; anyone can forge tokens")
+	}
+	if c.Server.Mode == "release" {
+		for _, origin := range c.Server.CorsOrigins {
+			if origin == "*" {
+				warnings = append(warnings, "server.cors_origins allows any origin in release mode")
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var bits float64
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
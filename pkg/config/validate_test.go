@@ -0,0 +1,128 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJWTSecret = "q7Zt2Lw9Xk4Rb8Nm1Pv6Hc3Ys5Fd0Gj-"
+
+// validConfig loads the defaults with the secrets a deployment supplies
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	t.Setenv("KRUSTRON_AUTH_JWT_SECRET", testJWTSecret)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "server:\n  cors_origins: [\"https://krustron.example.com\"]\n")
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestValidateAcceptsDefaults(t *testing.T) {
+	cfg := validConfig(t)
+	assert.NoError(t, cfg.Validate())
+	assert.Empty(t, cfg.Warnings())
+}
+
+func TestValidateAggregatesProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *Config)
+		want   []string
+	}{
+		{
+			name:   "missing jwt secret",
+			mutate: func(c *Config) { c.Auth.JWTSecret = "" },
+			want:   []string{"auth.jwt_secret is required"},
+		},
+		{
+			name:   "short jwt secret",
+			mutate: func(c *Config) { c.Auth.JWTSecret = "s3cret" },
+			want:   []string{"auth.jwt_secret must be at least 32 bytes, got 6"},
+		},
+		{
+			name:   "predictable jwt secret",
+			mutate: func(c *Config) { c.Auth.JWTSecret = "abababababababababababababababab" },
+			want:   []string{"auth.jwt_secret is too predictable"},
+		},
+		{
+			name: "incomplete oidc",
+			mutate: func(c *Config) {
+				c.Auth.OIDCEnabled = true
+				c.Auth.OIDCIssuer = "https://idp.example.com"
+			},
+			want: []string{"auth.oidc_enabled requires auth.oidc_client_id, auth.oidc_redirect_url"},
+		},
+		{
+			name: "server address",
+			mutate: func(c *Config) {
+				c.Server.Host = ""
+				c.Server.Port = 70000
+				c.Server.Mode = "prod"
+			},
+			want: []string{
+				"server.host is required",
+				"server.port 70000 is not a valid port",
+				`server.mode "prod" must be debug, release or test`,
+			},
+		},
+		{
+			name: "database dsn and secret together",
+			mutate: func(c *Config) {
+				c.Database.Host = ""
+				c.Database.User = ""
+				c.Database.Database = ""
+				c.Auth.JWTSecret = ""
+			},
+			want: []string{
+				"database.host is required",
+				"database.user is required",
+				"database.database is required",
+				"auth.jwt_secret is required",
+			},
+		},
+		{
+			name: "tls without key pair",
+			mutate: func(c *Config) {
+				c.Server.TLSEnabled = true
+				c.Server.GRPCPort = c.Server.Port
+			},
+			want: []string{
+				"server.grpc_port must differ from server.port",
+				"server.tls_cert and server.tls_key are required",
+			},
+		},
+		{
+			name:   "metrics path",
+			mutate: func(c *Config) { c.Observability.Metrics.Path = "metrics" },
+			want:   []string{`observability.metrics.path "metrics" must start with /`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig(t)
+			tt.mutate(cfg)
+			err := cfg.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid configuration:")
+			for _, want := range tt.want {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.Auth.JWTSecret = "dev-only-insecure-secret-change-me-32chars-min"
+	cfg.Server.CorsOrigins = []string{"https://krustron.example.com", "*"}
+	assert.NoError(t, cfg.Validate())
+	assert.Len(t, cfg.Warnings(), 2)
+
+	// Any origin is expected while developing
+	cfg.Server.Mode = "debug"
+	assert.Equal(t, []string{"auth.jwt_secret is a published default; anyone can forge tokens"}, cfg.Warnings())
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+)
+
+// Cursor is a position in a list ordered newest first by (created_at, id),
+// for keyset pagination. Unlike an offset it stays put when rows are
+// inserted ahead of it, so pages neither skip nor repeat rows.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"i"`
+}
+
+// Encode returns the cursor as an opaque, URL-safe string
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// IsZero reports whether c is the start of the list
+func (c Cursor) IsZero() bool {
+	return c.ID == ""
+}
+
+// DecodeCursor parses a cursor made by Encode. The empty string is the
+// start of the list.
+func DecodeCursor(s string) (Cursor, error) {
+	var c Cursor
+	if s == "" {
+		return c, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.ID == "" {
+		return Cursor{}, errors.BadRequest("invalid cursor")
+	}
+	return c, nil
+}
+
+// KeysetPage trims rows, fetched with LIMIT limit+1, to a page of at most
+// limit and returns the cursor for the next page, empty on the last
+func KeysetPage[T any](rows []T, limit int, cursor func(*T) Cursor) ([]T, string) {
+	if len(rows) <= limit {
+		return rows, ""
+	}
+	rows = rows[:limit]
+	return rows, cursor(&rows[limit-1]).Encode()
+}
@@ -10,7 +10,7 @@ import (
 
 	"github.com/anubhavg-icpl/krustron/pkg/config"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -109,6 +109,19 @@ func (db *PostgresDB) Transaction(ctx context.Context, fn func(*sql.Tx) error) e
 	return nil
 }
 
+// AdvisoryLock takes a PostgreSQL advisory lock on key, held until tx
+// ends, so writers on every replica serialize. Other drivers, such as the
+// SQLite used in tests, have no advisory locks and it does nothing.
+func (db *PostgresDB) AdvisoryLock(ctx context.Context, tx *sql.Tx, key int64) error {
+	if _, ok := db.Driver().(*pq.Driver); !ok {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", key); err != nil {
+		return fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	return nil
+}
+
 // Migrate runs database migrations
 func (db *PostgresDB) Migrate(ctx context.Context) error {
 	migrations := []string{
@@ -132,6 +145,11 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT`,
 		`ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN DEFAULT false`,
 
+		// SCIM provisioning: the IdP's user ID, and when the user's tokens
+		// were last revoked wholesale
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(255)`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP WITH TIME ZONE`,
+
 		// Clusters table
 		`CREATE TABLE IF NOT EXISTS clusters (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -159,6 +177,41 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 		)`,
 
+		// Cloud provider auth metadata for eks/gke/aks clusters (idempotent)
+		`ALTER TABLE clusters ADD COLUMN IF NOT EXISTS cloud_auth JSONB`,
+		// Kubeconfigs held in the secret store rather than inline
+		`ALTER TABLE clusters ADD COLUMN IF NOT EXISTS kubeconfig_ref VARCHAR(255)`,
+
+		// Per-cluster overrides of global settings
+		`CREATE TABLE IF NOT EXISTS cluster_configs (
+			cluster_id UUID PRIMARY KEY REFERENCES clusters(id) ON DELETE CASCADE,
+			overrides JSONB NOT NULL DEFAULT '{}',
+			updated_by VARCHAR(255),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		// Secrets for the database secret backend, sealed with the data key
+		`CREATE TABLE IF NOT EXISTS secrets (
+			ref VARCHAR(255) PRIMARY KEY,
+			data TEXT NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
+		// Objects applied to clusters from raw manifests, for prune and
+		// later deletion
+		`CREATE TABLE IF NOT EXISTS applied_resources (
+			cluster_id UUID REFERENCES clusters(id) ON DELETE CASCADE,
+			api_group VARCHAR(255) NOT NULL DEFAULT '',
+			api_version VARCHAR(50) NOT NULL,
+			resource VARCHAR(255) NOT NULL,
+			kind VARCHAR(255) NOT NULL,
+			namespace VARCHAR(255) NOT NULL DEFAULT '',
+			name VARCHAR(255) NOT NULL,
+			field_manager VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (cluster_id, api_group, resource, namespace, name)
+		)`,
+
 		// Applications table
 		`CREATE TABLE IF NOT EXISTS applications (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -191,6 +244,15 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 			UNIQUE(cluster_id, namespace, name)
 		)`,
 
+		// Sync state recorded by the GitOps reconciler (idempotent)
+		`ALTER TABLE applications ADD COLUMN IF NOT EXISTS sync_message TEXT`,
+		`ALTER TABLE applications ADD COLUMN IF NOT EXISTS sync_revision VARCHAR(255)`,
+		`ALTER TABLE applications ADD COLUMN IF NOT EXISTS managed_resources JSONB DEFAULT '[]'`,
+
+		// Multiple sources and private repository credentials (idempotent)
+		`ALTER TABLE applications ADD COLUMN IF NOT EXISTS repo_credentials_ref VARCHAR(255)`,
+		`ALTER TABLE applications ADD COLUMN IF NOT EXISTS sources JSONB DEFAULT '[]'`,
+
 		// Pipelines table
 		`CREATE TABLE IF NOT EXISTS pipelines (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -213,6 +275,13 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 		)`,
 
+		// Webhook branch filters for pipelines (idempotent)
+		`ALTER TABLE pipelines ADD COLUMN IF NOT EXISTS branches JSONB DEFAULT '[]'`,
+		// Pull request webhooks are opt-in per pipeline
+		`ALTER TABLE pipelines ADD COLUMN IF NOT EXISTS build_pull_requests BOOLEAN DEFAULT false`,
+		// Encrypted webhook secrets outgrow VARCHAR(255)
+		`ALTER TABLE pipelines ALTER COLUMN webhook_secret TYPE TEXT`,
+
 		// Pipeline runs table
 		`CREATE TABLE IF NOT EXISTS pipeline_runs (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -233,6 +302,28 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 			created_by UUID REFERENCES users(id),
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 		)`,
+		// Executing replicas refresh heartbeat_at so stale runs can be resumed
+		`ALTER TABLE pipeline_runs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP WITH TIME ZONE`,
+
+		// Approve-stage decisions, one per approver per stage of a run
+		`CREATE TABLE IF NOT EXISTS pipeline_approvals (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			run_id UUID REFERENCES pipeline_runs(id) ON DELETE CASCADE,
+			stage VARCHAR(255) NOT NULL,
+			user_id UUID REFERENCES users(id),
+			decision VARCHAR(20) NOT NULL,
+			comment TEXT,
+			decided_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			UNIQUE (run_id, stage, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS pipeline_run_logs (
+			run_id UUID REFERENCES pipeline_runs(id) ON DELETE CASCADE,
+			stage VARCHAR(255) NOT NULL,
+			content BYTEA,
+			size BIGINT DEFAULT 0,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			PRIMARY KEY (run_id, stage)
+		)`,
 
 		// Helm releases table
 		`CREATE TABLE IF NOT EXISTS helm_releases (
@@ -255,6 +346,35 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 			UNIQUE(cluster_id, namespace, name)
 		)`,
 
+		// Rendered manifest of the deployed revision, for upgrade previews
+		`ALTER TABLE helm_releases ADD COLUMN IF NOT EXISTS manifest TEXT`,
+
+		// Every revision of a release, for history and rollback
+		`CREATE TABLE IF NOT EXISTS helm_release_revisions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			release_id UUID NOT NULL REFERENCES helm_releases(id) ON DELETE CASCADE,
+			revision INTEGER NOT NULL,
+			chart_name VARCHAR(255) NOT NULL,
+			chart_version VARCHAR(50) NOT NULL,
+			app_version VARCHAR(50),
+			values_yaml TEXT,
+			manifest TEXT,
+			notes TEXT,
+			status VARCHAR(50) NOT NULL,
+			description TEXT,
+			deployed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			UNIQUE(release_id, revision)
+		)`,
+
+		// OCI registries logged in to; credentials stay in the secret store
+		`CREATE TABLE IF NOT EXISTS helm_registries (
+			host VARCHAR(255) PRIMARY KEY,
+			credentials_ref VARCHAR(512),
+			ca_cert TEXT,
+			plain_http BOOLEAN DEFAULT false,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
 		// Security scans table
 		`CREATE TABLE IF NOT EXISTS security_scans (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -335,6 +455,22 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 		)`,
 
+		// API keys table; only a hash of each key is stored
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL DEFAULT '',
+			prefix VARCHAR(32) NOT NULL,
+			key_hash VARCHAR(64) UNIQUE NOT NULL,
+			scopes JSONB NOT NULL DEFAULT '[]',
+			allowed_ips JSONB NOT NULL DEFAULT '[]',
+			expires_at TIMESTAMP WITH TIME ZONE,
+			last_used_at TIMESTAMP WITH TIME ZONE,
+			last_used_ip VARCHAR(64),
+			revoked_at TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+
 		// Create indexes
 		`CREATE INDEX IF NOT EXISTS idx_clusters_status ON clusters(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_clusters_environment ON clusters(environment)`,
@@ -343,12 +479,31 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 		`CREATE INDEX IF NOT EXISTS idx_pipelines_application ON pipelines(application_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_pipeline_runs_pipeline ON pipeline_runs(pipeline_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_pipeline_runs_status ON pipeline_runs(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_pipeline_run_logs_created ON pipeline_run_logs(created_at)`,
+		// Keyset cursors for list pagination
+		`CREATE INDEX IF NOT EXISTS idx_users_created_id ON users(created_at DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_pipelines_created_id ON pipelines(created_at DESC, id DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_pipeline_runs_pipeline_created_id ON pipeline_runs(pipeline_id, created_at DESC, id DESC)`,
 		`CREATE INDEX IF NOT EXISTS idx_helm_releases_cluster ON helm_releases(cluster_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_security_scans_target ON security_scans(target_type, target_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_audit_logs_user ON audit_logs(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_audit_logs_resource ON audit_logs(resource_type, resource_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_audit_logs_created ON audit_logs(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_cluster ON audit_logs(cluster_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_email ON audit_logs(LOWER(user_email), created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_status ON audit_logs(status, created_at)`,
+		// Keyset cursor for streaming exports and list pagination
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_created_id ON audit_logs(created_at DESC, id DESC)`,
+		// Hash chain making audit logs tamper-evident; rows written before
+		// it have no seq and are not chained
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS seq BIGINT`,
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64)`,
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS hash VARCHAR(64)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_logs_seq ON audit_logs(seq)`,
+		`ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS request_id VARCHAR(64)`,
 		`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, is_read)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id, created_at)`,
 	}
 
 	for _, migration := range migrations {
@@ -357,6 +512,19 @@ func (db *PostgresDB) Migrate(ctx context.Context) error {
 		}
 	}
 
+	// Trigram indexes serve audit log text search; creating the extension
+	// may need privileges the service lacks, so search falls back to scans
+	for _, migration := range []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_resource_name_trgm ON audit_logs USING gin (LOWER(resource_name) gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_logs_error_trgm ON audit_logs USING gin (LOWER(error_message) gin_trgm_ops)`,
+	} {
+		if _, err := db.ExecContext(ctx, migration); err != nil {
+			logger.Warn("Audit log text search will not be indexed", zap.Error(err))
+			break
+		}
+	}
+
 	logger.Info("Database migrations completed successfully")
 	return nil
 }
@@ -27,8 +27,14 @@ const (
 	CodeSecurity          = "SECURITY_ERROR"
 	CodeRateLimited       = "RATE_LIMITED"
 	CodeServiceUnavailable = "SERVICE_UNAVAILABLE"
+	CodeTimeout           = "TIMEOUT"
+	CodeCanceled          = "CANCELED"
 )
 
+// MetaRetryAfter is the meta key of a rate limited error holding the
+// seconds to wait before retrying
+const MetaRetryAfter = "retry_after"
+
 // AppError represents an application error with code and context
 type AppError struct {
 	Code       string            `json:"code"`
@@ -100,7 +106,9 @@ func InternalWrap(err error, message string) *AppError {
 
 // NotFound creates a not found error
 func NotFound(resource, id string) *AppError {
-	return New(CodeNotFound, fmt.Sprintf("%s with id '%s' not found", resource, id), http.StatusNotFound)
+	return New(CodeNotFound, fmt.Sprintf("%s with id '%s' not found", resource, id), http.StatusNotFound).
+		WithMeta("resource", resource).
+		WithMeta("id", id)
 }
 
 // NotFoundMsg creates a not found error with custom message
@@ -246,7 +254,10 @@ func Is(err error, code string) bool {
 func GetHTTPStatus(err error) int {
 	var appErr *AppError
 	if errors.As(err, &appErr) {
-		return appErr.HTTPStatus
+		if appErr.HTTPStatus != 0 {
+			return appErr.HTTPStatus
+		}
+		return kindOf(appErr.Code).http
 	}
 	return http.StatusInternalServerError
 }
@@ -260,12 +271,25 @@ func GetCode(err error) string {
 	return CodeInternal
 }
 
-// ToAppError converts any error to AppError
+// Code returns the error code of err: "" for nil, CodeInternal for errors
+// that aren't AppErrors
+func Code(err error) string {
+	if err == nil {
+		return ""
+	}
+	return ToAppError(err).Code
+}
+
+// ToAppError converts any error to AppError. Context deadline and
+// cancellation errors become CodeTimeout and CodeCanceled.
 func ToAppError(err error) *AppError {
 	var appErr *AppError
 	if errors.As(err, &appErr) {
 		return appErr
 	}
+	if ctxErr := fromContextError(err); ctxErr != nil {
+		return ctxErr
+	}
 	return InternalWrap(err, err.Error())
 }
 
@@ -0,0 +1,168 @@
+package errors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the ErrorInfo domain of errors sent over gRPC
+const ErrorDomain = "krustron.io"
+
+// kind is how an error code is surfaced over each transport
+type kind struct {
+	http int
+	grpc codes.Code
+}
+
+// kinds maps every error code to its HTTP status and gRPC code. Codes
+// missing here are internal errors.
+var kinds = map[string]kind{
+	CodeInternal:           {http.StatusInternalServerError, codes.Internal},
+	CodeNotFound:           {http.StatusNotFound, codes.NotFound},
+	CodeBadRequest:         {http.StatusBadRequest, codes.InvalidArgument},
+	CodeUnauthorized:       {http.StatusUnauthorized, codes.Unauthenticated},
+	CodeForbidden:          {http.StatusForbidden, codes.PermissionDenied},
+	CodeConflict:           {http.StatusConflict, codes.Aborted},
+	CodeValidation:         {http.StatusBadRequest, codes.InvalidArgument},
+	CodeDatabase:           {http.StatusInternalServerError, codes.Internal},
+	CodeKubernetes:         {http.StatusInternalServerError, codes.Internal},
+	CodeGitOps:             {http.StatusInternalServerError, codes.Internal},
+	CodePipeline:           {http.StatusInternalServerError, codes.Internal},
+	CodeCluster:            {http.StatusInternalServerError, codes.Internal},
+	CodeHelm:               {http.StatusInternalServerError, codes.Internal},
+	CodeAuth:               {http.StatusUnauthorized, codes.Unauthenticated},
+	CodeSecurity:           {http.StatusForbidden, codes.PermissionDenied},
+	CodeRateLimited:        {http.StatusTooManyRequests, codes.ResourceExhausted},
+	CodeServiceUnavailable: {http.StatusServiceUnavailable, codes.Unavailable},
+	CodeTimeout:            {http.StatusGatewayTimeout, codes.DeadlineExceeded},
+	CodeCanceled:           {StatusClientClosedRequest, codes.Canceled},
+}
+
+// StatusClientClosedRequest is the non-standard HTTP status for a request
+// the client gave up on
+const StatusClientClosedRequest = 499
+
+func kindOf(code string) kind {
+	if k, ok := kinds[code]; ok {
+		return k
+	}
+	return kinds[CodeInternal]
+}
+
+// GRPCCode returns the gRPC code for an error code
+func GRPCCode(code string) codes.Code {
+	return kindOf(code).grpc
+}
+
+// GRPCStatus converts the error to a gRPC status carrying an ErrorInfo
+// detail with the error code as reason and the meta (plus details, if
+// any) as metadata. gRPC uses it for errors returned from handlers.
+func (e *AppError) GRPCStatus() *status.Status {
+	st := status.New(GRPCCode(e.Code), e.Message)
+
+	info := &errdetails.ErrorInfo{Reason: e.Code, Domain: ErrorDomain}
+	if len(e.Meta) > 0 || e.Details != "" {
+		info.Metadata = make(map[string]string, len(e.Meta)+1)
+		for k, v := range e.Meta {
+			info.Metadata[k] = v
+		}
+		if e.Details != "" {
+			info.Metadata["details"] = e.Details
+		}
+	}
+	if withInfo, err := st.WithDetails(info); err == nil {
+		return withInfo
+	}
+	return st
+}
+
+// FromGRPC converts an error received over gRPC back into an AppError.
+// Errors without an ErrorInfo get the error code matching their gRPC code.
+func FromGRPC(err error) *AppError {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return ToAppError(err)
+	}
+
+	appErr := &AppError{Code: codeForGRPC(st.Code()), Message: st.Message(), Err: err}
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != ErrorDomain {
+			continue
+		}
+		appErr.Code = info.Reason
+		for k, v := range info.Metadata {
+			if k == "details" {
+				appErr.Details = v
+				continue
+			}
+			appErr.WithMeta(k, v)
+		}
+		break
+	}
+	appErr.HTTPStatus = kindOf(appErr.Code).http
+	return appErr
+}
+
+// codeForGRPC is the error code for a bare gRPC status
+func codeForGRPC(c codes.Code) string {
+	switch c {
+	case codes.NotFound:
+		return CodeNotFound
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return CodeBadRequest
+	case codes.Unauthenticated:
+		return CodeUnauthorized
+	case codes.PermissionDenied:
+		return CodeForbidden
+	case codes.AlreadyExists, codes.Aborted:
+		return CodeConflict
+	case codes.ResourceExhausted:
+		return CodeRateLimited
+	case codes.Unavailable:
+		return CodeServiceUnavailable
+	case codes.DeadlineExceeded:
+		return CodeTimeout
+	case codes.Canceled:
+		return CodeCanceled
+	default:
+		return CodeInternal
+	}
+}
+
+// HTTPResponse returns the status and JSON body to answer a request that
+// failed with err. The code in the body is the same one gRPC clients get
+// as the ErrorInfo reason.
+func HTTPResponse(err error, traceID string) (int, ErrorResponse) {
+	appErr := ToAppError(err)
+	return GetHTTPStatus(appErr), appErr.ToResponse(traceID)
+}
+
+// RetryAfter returns the seconds a client should wait before retrying a
+// rate limited err, for a Retry-After header, or "" if err isn't one
+func RetryAfter(err error) string {
+	var appErr *AppError
+	if !errors.As(err, &appErr) || appErr.Code != CodeRateLimited {
+		return ""
+	}
+	return appErr.Meta[MetaRetryAfter]
+}
+
+// fromContextError converts a context error, or returns nil for any other
+func fromContextError(err error) *AppError {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return Wrap(err, CodeTimeout, "request timed out", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		return Wrap(err, CodeCanceled, "request canceled", StatusClientClosedRequest)
+	}
+	return nil
+}
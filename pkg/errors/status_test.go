@@ -0,0 +1,102 @@
+package errors
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  *AppError
+		http int
+		grpc codes.Code
+	}{
+		{Internal("boom"), http.StatusInternalServerError, codes.Internal},
+		{NotFound("cluster", "c1"), http.StatusNotFound, codes.NotFound},
+		{BadRequest("bad"), http.StatusBadRequest, codes.InvalidArgument},
+		{Unauthorized("who"), http.StatusUnauthorized, codes.Unauthenticated},
+		{Forbidden("no"), http.StatusForbidden, codes.PermissionDenied},
+		{Conflict("taken"), http.StatusConflict, codes.Aborted},
+		{Validation("invalid"), http.StatusBadRequest, codes.InvalidArgument},
+		{Database("db"), http.StatusInternalServerError, codes.Internal},
+		{Kubernetes("k8s"), http.StatusInternalServerError, codes.Internal},
+		{GitOps("git"), http.StatusInternalServerError, codes.Internal},
+		{Pipeline("pipe"), http.StatusInternalServerError, codes.Internal},
+		{Cluster("cluster"), http.StatusInternalServerError, codes.Internal},
+		{Helm("helm"), http.StatusInternalServerError, codes.Internal},
+		{Auth("token"), http.StatusUnauthorized, codes.Unauthenticated},
+		{Security("policy"), http.StatusForbidden, codes.PermissionDenied},
+		{RateLimited("slow down"), http.StatusTooManyRequests, codes.ResourceExhausted},
+		{ServiceUnavailable("down"), http.StatusServiceUnavailable, codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Code, func(t *testing.T) {
+			wrapped := fmt.Errorf("handler: %w", tt.err)
+
+			assert.Equal(t, tt.err.Code, Code(wrapped))
+			assert.Equal(t, tt.http, GetHTTPStatus(wrapped))
+			assert.Equal(t, tt.grpc, status.Code(wrapped))
+
+			httpStatus, body := HTTPResponse(wrapped, "req-1")
+			assert.Equal(t, tt.http, httpStatus)
+			assert.Equal(t, tt.err.Code, body.Error.Code)
+			assert.Equal(t, tt.err.Message, body.Error.Message)
+			assert.Equal(t, "req-1", body.TraceID)
+
+			back := FromGRPC(tt.err.GRPCStatus().Err())
+			assert.Equal(t, tt.err.Code, back.Code)
+			assert.Equal(t, tt.err.Message, back.Message)
+			assert.Equal(t, tt.http, back.HTTPStatus)
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	assert.Equal(t, "", Code(nil))
+	assert.Equal(t, CodeInternal, Code(goerrors.New("plain")))
+	assert.Equal(t, CodeTimeout, Code(fmt.Errorf("query: %w", context.DeadlineExceeded)))
+	assert.Equal(t, CodeCanceled, Code(context.Canceled))
+}
+
+func TestGRPCStatusDetails(t *testing.T) {
+	err := NotFound("cluster", "c1").WithDetails("deleted yesterday")
+
+	back := FromGRPC(err.GRPCStatus().Err())
+	assert.Equal(t, CodeNotFound, back.Code)
+	assert.Equal(t, "deleted yesterday", back.Details)
+	assert.Equal(t, map[string]string{"resource": "cluster", "id": "c1"}, back.Meta)
+
+	_, body := HTTPResponse(err, "")
+	assert.Equal(t, back.Meta, body.Error.Meta)
+}
+
+func TestFromGRPCBareStatus(t *testing.T) {
+	back := FromGRPC(status.Error(codes.AlreadyExists, "exists"))
+	require.NotNil(t, back)
+	assert.Equal(t, CodeConflict, back.Code)
+	assert.Equal(t, http.StatusConflict, back.HTTPStatus)
+	assert.Nil(t, FromGRPC(nil))
+}
+
+func TestHTTPResponseContextErrors(t *testing.T) {
+	httpStatus, body := HTTPResponse(context.DeadlineExceeded, "")
+	assert.Equal(t, http.StatusGatewayTimeout, httpStatus)
+	assert.Equal(t, CodeTimeout, body.Error.Code)
+}
+
+func TestRetryAfter(t *testing.T) {
+	err := fmt.Errorf("ask: %w", RateLimited("slow down").WithMeta(MetaRetryAfter, "30"))
+	assert.Equal(t, "30", RetryAfter(err))
+	assert.Empty(t, RetryAfter(RateLimited("slow down")))
+	assert.Empty(t, RetryAfter(NotFound("pod", "web")))
+	assert.Empty(t, RetryAfter(nil))
+}
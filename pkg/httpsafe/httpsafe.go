@@ -0,0 +1,317 @@
+// Package httpsafe provides HTTP clients for calling user-supplied URLs,
+// such as webhooks, without exposing internal services (SSRF)
+// Author: Anubhav Gain <anubhavg@infopercept.com>
+package httpsafe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a whole request, including reading the body
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultMaxResponseBytes caps how much of a response body is read
+	DefaultMaxResponseBytes = 1 << 20
+
+	// maxRedirects is how many redirects are followed; each is checked
+	maxRedirects = 5
+)
+
+var (
+	// ErrBlocked is returned for requests the policy refuses
+	ErrBlocked = errors.New("destination not allowed")
+
+	// ErrResponseTooLarge is returned when reading past the size limit
+	ErrResponseTooLarge = errors.New("response body too large")
+)
+
+// blockedRanges are refused unless allow-listed: loopback, private,
+// link-local (including cloud metadata endpoints) and other addresses
+// that are not on the public internet
+var blockedRanges = mustPrefixes(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// Policy decides where outbound requests may go. The zero value allows
+// HTTPS to public addresses only.
+type Policy struct {
+	// AllowHTTP permits plain HTTP; otherwise only HTTPS is allowed
+	AllowHTTP bool
+	// AllowedHosts may be reached whatever they resolve to. Entries are
+	// host names, or "*.example.com" for any subdomain.
+	AllowedHosts []string
+	// AllowedCIDRs are blocked ranges that may be reached anyway
+	AllowedCIDRs []string
+	// DeniedCIDRs are refused in addition to the blocked ranges, even
+	// for allowed hosts
+	DeniedCIDRs []string
+	// Timeout bounds each request; DefaultTimeout if zero
+	Timeout time.Duration
+	// MaxResponseBytes caps response bodies; DefaultMaxResponseBytes if
+	// zero
+	MaxResponseBytes int64
+}
+
+// guard is a compiled Policy
+type guard struct {
+	allowHTTP bool
+	hosts     []string
+	allowed   []netip.Prefix
+	denied    []netip.Prefix
+}
+
+// NewClient returns a client that refuses requests the policy forbids,
+// including redirects to them. Addresses are checked as they are dialed,
+// so host names cannot be pointed at internal addresses after a check.
+// Proxies from the environment are not used.
+func NewClient(policy Policy) (*http.Client, error) {
+	g, err := policy.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := policy.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	maxBytes := policy.MaxResponseBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxResponseBytes
+	}
+
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			trusted := g.hostAllowed(host)
+			d := *dialer
+			d.Control = func(_, address string, _ syscall.RawConn) error {
+				ip, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return fmt.Errorf("%w: %s", ErrBlocked, address)
+				}
+				if !g.addrAllowed(ip.Addr(), trusted) {
+					return fmt.Errorf("%w: %s resolves to %s", ErrBlocked, host, ip.Addr())
+				}
+				return nil
+			}
+			return d.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &roundTripper{guard: g, next: transport, maxBytes: maxBytes},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// CheckURL reports whether the policy allows a URL, for validating
+// configuration early. Host names are not resolved; the client checks
+// the addresses they resolve to when it connects.
+func (p Policy) CheckURL(rawURL string) error {
+	g, err := p.compile()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: invalid URL", ErrBlocked)
+	}
+	return g.checkURL(u)
+}
+
+func (p Policy) compile() (*guard, error) {
+	allowed, err := parsePrefixes(p.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parsePrefixes(p.DeniedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &guard{allowHTTP: p.AllowHTTP, allowed: allowed, denied: denied}
+	for _, host := range p.AllowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			g.hosts = append(g.hosts, host)
+		}
+	}
+	return g, nil
+}
+
+// checkURL checks the scheme, and the address if the host is one
+func (g *guard) checkURL(u *url.URL) error {
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !g.allowHTTP {
+			return fmt.Errorf("%w: plain HTTP is not allowed", ErrBlocked)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported scheme %q", ErrBlocked, u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrBlocked)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !g.addrAllowed(ip, g.hostAllowed(host)) {
+			return fmt.Errorf("%w: %s", ErrBlocked, ip)
+		}
+	}
+	return nil
+}
+
+// hostAllowed reports whether host is allow-listed
+func (g *guard) hostAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range g.hosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// addrAllowed reports whether ip may be connected to. Denied ranges win,
+// then allow-listed hosts and ranges, then the blocked ranges.
+func (g *guard) addrAllowed(ip netip.Addr, trustedHost bool) bool {
+	ip = ip.Unmap().WithZone("")
+	if containsAddr(g.denied, ip) {
+		return false
+	}
+	if trustedHost || containsAddr(g.allowed, ip) {
+		return true
+	}
+	return !containsAddr(blockedRanges, ip)
+}
+
+// roundTripper checks every request, redirects included, and limits
+// response bodies
+type roundTripper struct {
+	guard    *guard
+	next     http.RoundTripper
+	maxBytes int64
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := rt.guard.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	resp, err := rt.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: rt.maxBytes}
+	return resp, nil
+}
+
+// limitedBody fails reads past the size limit rather than truncating
+// silently
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	// Read one byte more than allowed to tell a body of exactly the
+	// limit from a longer one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes parses CIDR ranges, taking bare addresses as single
+// hosts
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		ip = ip.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func mustPrefixes(cidrs ...string) []netip.Prefix {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return prefixes
+}
@@ -0,0 +1,141 @@
+package httpsafe
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// localServer answers "ok" on a loopback address
+func localServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, policy Policy, target string) (string, error) {
+	t.Helper()
+	client, err := NewClient(policy)
+	require.NoError(t, err)
+	resp, err := client.Get(target)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	policy := Policy{AllowHTTP: true}
+	for _, target := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::ffff:169.254.169.254]/latest/meta-data/",
+		"http://10.0.0.1/",
+		"http://172.16.5.4/",
+		"http://192.168.1.1/",
+		"http://[fd00::1]/",
+		"http://[::1]/",
+		"http://0.0.0.0/",
+		"http://localhost/",
+	} {
+		_, err := get(t, policy, target)
+		assert.True(t, errors.Is(err, ErrBlocked), "%s: %v", target, err)
+	}
+
+	// Loopback test servers are refused too, by address and by name
+	srv := localServer(t)
+	_, err := get(t, policy, srv.URL)
+	assert.True(t, errors.Is(err, ErrBlocked), err)
+	u, _ := url.Parse(srv.URL)
+	_, err = get(t, policy, "http://localhost:"+u.Port())
+	assert.True(t, errors.Is(err, ErrBlocked), err)
+}
+
+func TestClientAllowList(t *testing.T) {
+	srv := localServer(t)
+	u, _ := url.Parse(srv.URL)
+
+	body, err := get(t, Policy{AllowHTTP: true, AllowedCIDRs: []string{"127.0.0.0/8"}}, srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", body)
+
+	body, err = get(t, Policy{AllowHTTP: true, AllowedHosts: []string{"LOCALHOST"}}, "http://localhost:"+u.Port())
+	require.NoError(t, err)
+	assert.Equal(t, "ok", body)
+
+	// Denied ranges win over allowed hosts
+	_, err = get(t, Policy{
+		AllowHTTP:    true,
+		AllowedHosts: []string{"localhost"},
+		DeniedCIDRs:  []string{"127.0.0.1", "::1"},
+	}, "http://localhost:"+u.Port())
+	assert.True(t, errors.Is(err, ErrBlocked), err)
+
+	// Plain HTTP needs AllowHTTP
+	_, err = get(t, Policy{AllowedCIDRs: []string{"127.0.0.0/8"}}, srv.URL)
+	assert.True(t, errors.Is(err, ErrBlocked), err)
+}
+
+func TestClientChecksRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	_, err := get(t, Policy{AllowHTTP: true, AllowedCIDRs: []string{"127.0.0.1/32"}}, srv.URL)
+	assert.True(t, errors.Is(err, ErrBlocked), err)
+}
+
+func TestClientLimitsResponseSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 2048))
+	}))
+	defer srv.Close()
+	policy := Policy{AllowHTTP: true, AllowedCIDRs: []string{"127.0.0.0/8"}}
+
+	policy.MaxResponseBytes = 1024
+	_, err := get(t, policy, srv.URL)
+	assert.True(t, errors.Is(err, ErrResponseTooLarge), err)
+
+	policy.MaxResponseBytes = 2048
+	body, err := get(t, policy, srv.URL)
+	require.NoError(t, err)
+	assert.Len(t, body, 2048)
+}
+
+func TestCheckURL(t *testing.T) {
+	policy := Policy{AllowedHosts: []string{"*.internal.example.com"}, AllowedCIDRs: []string{"10.1.0.0/16"}}
+	for target, allowed := range map[string]bool{
+		"https://hooks.slack.com/services/x":       true,
+		"http://hooks.slack.com/services/x":        false,
+		"ftp://hooks.slack.com/x":                  false,
+		"https://169.254.169.254/":                 false,
+		"https://10.1.2.3/hook":                    true,
+		"https://10.2.0.1/hook":                    false,
+		"https://ci.internal.example.com/hook":     true,
+		"https:///hook":                            false,
+		"https://[fe80::1%25eth0]/hook":            false,
+		"https://alerts.internal.example.com:8443": true,
+	} {
+		err := policy.CheckURL(target)
+		if allowed {
+			assert.NoError(t, err, target)
+		} else {
+			assert.True(t, errors.Is(err, ErrBlocked), "%s: %v", target, err)
+		}
+	}
+
+	_, err := NewClient(Policy{AllowedCIDRs: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+}
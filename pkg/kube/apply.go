@@ -0,0 +1,238 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultFieldManager owns the fields krustron applies
+const DefaultFieldManager = "krustron"
+
+// ApplyOptions configures server-side apply
+type ApplyOptions struct {
+	// FieldManager owns the applied fields; defaults to "krustron"
+	FieldManager string
+	// Force takes ownership of fields another manager owns instead of
+	// failing with a conflict
+	Force bool
+	// DryRun has the server validate and default the object without
+	// persisting it
+	DryRun bool
+}
+
+// DecodeManifest splits multi-document YAML or JSON into objects. Empty
+// documents are skipped and List kinds are expanded into their items.
+func DecodeManifest(manifest []byte) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+	var objects []*unstructured.Unstructured
+	for doc := 1; ; doc++ {
+		var raw map[string]interface{}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("document %d: %w", doc, err)
+		}
+		if len(raw) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: raw}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("document %d: %w", doc, err)
+			}
+			for i := range list.Items {
+				if err := validateObject(&list.Items[i]); err != nil {
+					return nil, fmt.Errorf("document %d item %d: %w", doc, i+1, err)
+				}
+				objects = append(objects, &list.Items[i])
+			}
+			continue
+		}
+		if err := validateObject(obj); err != nil {
+			return nil, fmt.Errorf("document %d: %w", doc, err)
+		}
+		objects = append(objects, obj)
+	}
+}
+
+func validateObject(obj *unstructured.Unstructured) error {
+	switch {
+	case obj.GetAPIVersion() == "":
+		return errors.New("apiVersion is required")
+	case obj.GetKind() == "":
+		return errors.New("kind is required")
+	case obj.GetName() == "":
+		return fmt.Errorf("%s has no metadata.name", obj.GetKind())
+	}
+	return nil
+}
+
+// applyOrder ranks kinds other objects depend on so they are applied
+// first; everything else keeps its manifest order
+var applyOrder = map[string]int{
+	"Namespace":                0,
+	"CustomResourceDefinition": 1,
+	"ServiceAccount":           2,
+	"ClusterRole":              2,
+	"ClusterRoleBinding":       3,
+	"Role":                     2,
+	"RoleBinding":              3,
+	"ConfigMap":                3,
+	"Secret":                   3,
+	"PersistentVolumeClaim":    3,
+}
+
+// SortForApply orders objects for applying: namespaces, then CRDs, then
+// RBAC, config and storage, then the rest in manifest order
+func SortForApply(objects []*unstructured.Unstructured) {
+	rank := func(obj *unstructured.Unstructured) int {
+		if r, ok := applyOrder[obj.GetKind()]; ok {
+			return r
+		}
+		return len(applyOrder)
+	}
+	sort.SliceStable(objects, func(i, j int) bool { return rank(objects[i]) < rank(objects[j]) })
+}
+
+// ResourceFor finds the resource serving gvk, asking discovery each time
+// so types created by an earlier apply are found
+func (c *ClusterClient) ResourceFor(gvk schema.GroupVersionKind) (APIResource, error) {
+	list, err := c.Clientset.Discovery().ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return APIResource{}, fmt.Errorf("%w: %s is not served", ErrUnknownResource, gvk.GroupVersion())
+		}
+		return APIResource{}, fmt.Errorf("failed to discover %s: %w", gvk.GroupVersion(), err)
+	}
+	for _, r := range list.APIResources {
+		if r.Kind == gvk.Kind && !strings.Contains(r.Name, "/") {
+			return APIResource{
+				Group: gvk.Group, Version: gvk.Version, Resource: r.Name, Kind: r.Kind,
+				Namespaced: r.Namespaced, Verbs: r.Verbs, ShortNames: r.ShortNames,
+			}, nil
+		}
+	}
+	return APIResource{}, fmt.Errorf("%w: kind %q in %s", ErrUnknownResource, gvk.Kind, gvk.GroupVersion())
+}
+
+// resourceInterface is the dynamic client for res in namespace, which is
+// ignored for cluster-scoped resources
+func (c *ClusterClient) resourceInterface(res APIResource, namespace string) (dynamic.ResourceInterface, error) {
+	if c.DynamicClient == nil {
+		return nil, fmt.Errorf("cluster %s has no dynamic client", c.Name)
+	}
+	if !res.Namespaced {
+		return c.DynamicClient.Resource(res.GVR()), nil
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("%w: %s is namespaced and needs a namespace", ErrResourceScope, res.Kind)
+	}
+	return c.DynamicClient.Resource(res.GVR()).Namespace(namespace), nil
+}
+
+// ApplyObject server-side applies obj as res, found with ResourceFor, and
+// returns what the server stored, or would store on a dry run. A
+// namespaced object must carry its namespace; a cluster-scoped one has any
+// namespace dropped.
+func (c *ClusterClient) ApplyObject(ctx context.Context, res APIResource, obj *unstructured.Unstructured, opts ApplyOptions) (*unstructured.Unstructured, error) {
+	if !res.Namespaced {
+		obj.SetNamespace("")
+	}
+	client, err := c.resourceInterface(res, obj.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+
+	fieldManager := opts.FieldManager
+	if fieldManager == "" {
+		fieldManager = DefaultFieldManager
+	}
+	applyOpts := metav1.ApplyOptions{FieldManager: fieldManager, Force: opts.Force}
+	if opts.DryRun {
+		applyOpts.DryRun = []string{metav1.DryRunAll}
+	}
+	return client.Apply(ctx, obj.GetName(), obj, applyOpts)
+}
+
+// GetObject gets one object of res without asking discovery for its scope
+func (c *ClusterClient) GetObject(ctx context.Context, res APIResource, namespace, name string) (*unstructured.Unstructured, error) {
+	client, err := c.resourceInterface(res, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return client.Get(ctx, name, metav1.GetOptions{})
+}
+
+// DeleteObject deletes one object of res, letting the garbage collector
+// remove its dependents in the background
+func (c *ClusterClient) DeleteObject(ctx context.Context, res APIResource, namespace, name string, dryRun bool) error {
+	client, err := c.resourceInterface(res, namespace)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	return client.Delete(ctx, name, opts)
+}
+
+// DiffObjects returns a unified diff between the live object and the
+// object after a change, ignoring server-managed metadata and status. A
+// nil object on either side, for a create or a delete, diffs against
+// nothing. It returns "" when nothing changes.
+func DiffObjects(name string, live, changed *unstructured.Unstructured) (string, error) {
+	from, err := diffYAML(live)
+	if err != nil {
+		return "", err
+	}
+	to, err := diffYAML(changed)
+	if err != nil {
+		return "", err
+	}
+	if from == to {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: "live/" + name,
+		ToFile:   "applied/" + name,
+		Context:  3,
+	})
+}
+
+// diffYAML renders obj for diffing without fields the server maintains
+func diffYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "status")
+	for _, field := range []string{"managedFields", "resourceVersion", "uid", "generation", "creationTimestamp"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", obj.GetName(), err)
+	}
+	return string(out), nil
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/anubhavg-icpl/krustron/pkg/config"
 	"github.com/anubhavg-icpl/krustron/pkg/logger"
 	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -28,6 +29,16 @@ type ClientManager struct {
 	clients  map[string]*ClusterClient
 	config   *config.KubernetesConfig
 	scheme   *runtime.Scheme
+	// listeners are told as clusters come and go
+	listeners []ClusterListener
+}
+
+// ClusterListener is told when a ClientManager gains or loses a cluster.
+// A cluster added again, e.g. on reconnect, is reported again with its
+// new client.
+type ClusterListener interface {
+	ClusterAdded(client *ClusterClient)
+	ClusterRemoved(name string)
 }
 
 // ClusterClient wraps Kubernetes clients for a single cluster
@@ -85,9 +96,7 @@ func (m *ClientManager) GetLocalClient() (*ClusterClient, error) {
 	// Persist under "local" so later GetClient("local") (used by cluster/helm
 	// services for in-cluster operations) actually resolves. Previously the
 	// client was built, logged once, then thrown away.
-	m.mu.Lock()
-	m.clients["local"] = client
-	m.mu.Unlock()
+	m.store(client)
 
 	return client, nil
 }
@@ -107,9 +116,7 @@ func (m *ClientManager) AddCluster(name string, kubeconfigData []byte) (*Cluster
 		return nil, err
 	}
 
-	m.mu.Lock()
-	m.clients[name] = client
-	m.mu.Unlock()
+	m.store(client)
 
 	logger.Info("Added cluster", zap.String("cluster", name))
 	return client, nil
@@ -132,20 +139,60 @@ func (m *ClientManager) AddClusterByAPIServer(name, apiServer, token, caCert str
 		return nil, err
 	}
 
-	m.mu.Lock()
-	m.clients[name] = client
-	m.mu.Unlock()
+	m.store(client)
 
 	logger.Info("Added cluster by API server", zap.String("cluster", name), zap.String("api_server", apiServer))
 	return client, nil
 }
 
+// AddClient registers an already built client under its name, replacing
+// any client of the same name
+func (m *ClientManager) AddClient(client *ClusterClient) {
+	m.store(client)
+}
+
 // RemoveCluster removes a cluster
 func (m *ClientManager) RemoveCluster(name string) {
 	m.mu.Lock()
+	_, existed := m.clients[name]
 	delete(m.clients, name)
+	listeners := m.listeners
 	m.mu.Unlock()
 	logger.Info("Removed cluster", zap.String("cluster", name))
+
+	if existed {
+		for _, l := range listeners {
+			l.ClusterRemoved(name)
+		}
+	}
+}
+
+// AddListener registers l to be told about clusters added and removed
+// from now on, first reporting those already registered
+func (m *ClientManager) AddListener(l ClusterListener) {
+	m.mu.Lock()
+	m.listeners = append(m.listeners, l)
+	existing := make([]*ClusterClient, 0, len(m.clients))
+	for _, client := range m.clients {
+		existing = append(existing, client)
+	}
+	m.mu.Unlock()
+
+	for _, client := range existing {
+		l.ClusterAdded(client)
+	}
+}
+
+// store registers client under its name and tells the listeners
+func (m *ClientManager) store(client *ClusterClient) {
+	m.mu.Lock()
+	m.clients[client.Name] = client
+	listeners := m.listeners
+	m.mu.Unlock()
+
+	for _, l := range listeners {
+		l.ClusterAdded(client)
+	}
 }
 
 // GetClient gets a cluster client by name
@@ -291,24 +338,68 @@ func (c *ClusterClient) GetNamespaces(ctx context.Context) ([]corev1.Namespace,
 	return list.Items, nil
 }
 
+// ListOptions pages and filters a list call. Continue is the cursor
+// returned with the previous page; empty starts from the beginning. A zero
+// Limit returns everything.
+type ListOptions struct {
+	Limit         int64
+	Continue      string
+	LabelSelector string
+	FieldSelector string
+}
+
+func (o ListOptions) metaOptions() metav1.ListOptions {
+	return metav1.ListOptions{
+		Limit:         o.Limit,
+		Continue:      o.Continue,
+		LabelSelector: o.LabelSelector,
+		FieldSelector: o.FieldSelector,
+	}
+}
+
 // GetPods lists pods in a namespace
 func (c *ClusterClient) GetPods(ctx context.Context, namespace string) ([]corev1.Pod, error) {
-	list, err := c.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	list, err := c.ListPods(ctx, namespace, ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return list.Items, nil
 }
 
+// ListPods lists a page of pods in a namespace; list.Continue is the
+// cursor for the next page
+func (c *ClusterClient) ListPods(ctx context.Context, namespace string, opts ListOptions) (*corev1.PodList, error) {
+	return c.Clientset.CoreV1().Pods(namespace).List(ctx, opts.metaOptions())
+}
+
 // GetServices lists services in a namespace
 func (c *ClusterClient) GetServices(ctx context.Context, namespace string) ([]corev1.Service, error) {
-	list, err := c.Clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	list, err := c.ListServices(ctx, namespace, ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListServices lists a page of services in a namespace
+func (c *ClusterClient) ListServices(ctx context.Context, namespace string, opts ListOptions) (*corev1.ServiceList, error) {
+	return c.Clientset.CoreV1().Services(namespace).List(ctx, opts.metaOptions())
+}
+
+// GetDeployments lists deployments in a namespace
+func (c *ClusterClient) GetDeployments(ctx context.Context, namespace string) ([]appsv1.Deployment, error) {
+	list, err := c.ListDeployments(ctx, namespace, ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return list.Items, nil
 }
 
+// ListDeployments lists a page of deployments in a namespace
+func (c *ClusterClient) ListDeployments(ctx context.Context, namespace string, opts ListOptions) (*appsv1.DeploymentList, error) {
+	return c.Clientset.AppsV1().Deployments(namespace).List(ctx, opts.metaOptions())
+}
+
 // GetConfigMaps lists configmaps in a namespace
 func (c *ClusterClient) GetConfigMaps(ctx context.Context, namespace string) ([]corev1.ConfigMap, error) {
 	list, err := c.Clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{})
@@ -329,13 +420,18 @@ func (c *ClusterClient) GetSecrets(ctx context.Context, namespace string) ([]cor
 
 // GetEvents lists events in a namespace
 func (c *ClusterClient) GetEvents(ctx context.Context, namespace string) ([]corev1.Event, error) {
-	list, err := c.Clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	list, err := c.ListEvents(ctx, namespace, ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return list.Items, nil
 }
 
+// ListEvents lists a page of events in a namespace
+func (c *ClusterClient) ListEvents(ctx context.Context, namespace string, opts ListOptions) (*corev1.EventList, error) {
+	return c.Clientset.CoreV1().Events(namespace).List(ctx, opts.metaOptions())
+}
+
 // GetPodLogs retrieves logs from a pod
 func (c *ClusterClient) GetPodLogs(ctx context.Context, namespace, podName, containerName string, tailLines int64) (string, error) {
 	opts := &corev1.PodLogOptions{
@@ -0,0 +1,131 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// pagingClientset is a fake clientset whose pod lists honour Limit and
+// Continue the way the API server does; the fake tracker ignores both
+func pagingClientset(pods int) (*fake.Clientset, *[]metav1.ListOptions) {
+	objects := make([]runtime.Object, pods)
+	for i := range objects {
+		objects[i] = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("pod-%03d", i),
+			Namespace: "default",
+			Labels:    map[string]string{"app": "web"},
+		}}
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+
+	var seen []metav1.ListOptions
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := action.(k8stesting.ListActionImpl)
+		opts := list.ListOptions
+		seen = append(seen, opts)
+
+		obj, err := clientset.Tracker().List(corev1.SchemeGroupVersion.WithResource("pods"),
+			corev1.SchemeGroupVersion.WithKind("Pod"), list.Namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		all := obj.(*corev1.PodList).Items
+
+		start := 0
+		if opts.Continue != "" {
+			if start, err = strconv.Atoi(opts.Continue); err != nil {
+				return true, nil, fmt.Errorf("invalid continue token %q", opts.Continue)
+			}
+		}
+		end := len(all)
+		if opts.Limit > 0 && start+int(opts.Limit) < end {
+			end = start + int(opts.Limit)
+		}
+		page := &corev1.PodList{Items: all[start:end]}
+		if end < len(all) {
+			page.Continue = strconv.Itoa(end)
+		}
+		return true, page, nil
+	})
+	return clientset, &seen
+}
+
+func TestListPodsContinueRoundTrips(t *testing.T) {
+	clientset, seen := pagingClientset(12)
+	client := &ClusterClient{Name: "test", Clientset: clientset}
+	ctx := context.Background()
+
+	opts := ListOptions{Limit: 5, LabelSelector: "app=web", FieldSelector: "status.phase=Running"}
+	names := map[string]bool{}
+	pages := 0
+	for {
+		list, err := client.ListPods(ctx, "default", opts)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(list.Items), 5)
+		for _, pod := range list.Items {
+			assert.False(t, names[pod.Name], "pod %s listed twice", pod.Name)
+			names[pod.Name] = true
+		}
+		pages++
+		if list.Continue == "" {
+			break
+		}
+		opts.Continue = list.Continue
+	}
+
+	assert.Equal(t, 3, pages)
+	assert.Len(t, names, 12)
+
+	require.Len(t, *seen, 3)
+	assert.Empty(t, (*seen)[0].Continue)
+	for i, got := range *seen {
+		assert.Equal(t, int64(5), got.Limit)
+		assert.Equal(t, "app=web", got.LabelSelector)
+		assert.Equal(t, "status.phase=Running", got.FieldSelector)
+		if i > 0 {
+			assert.Equal(t, strconv.Itoa(i*5), got.Continue)
+		}
+	}
+}
+
+func TestGetPodsListsEverything(t *testing.T) {
+	clientset, seen := pagingClientset(12)
+	client := &ClusterClient{Name: "test", Clientset: clientset}
+
+	pods, err := client.GetPods(context.Background(), "default")
+	require.NoError(t, err)
+	assert.Len(t, pods, 12)
+	require.Len(t, *seen, 1)
+	assert.Zero(t, (*seen)[0].Limit)
+}
+
+// clusterLog records what a ClusterListener is told
+type clusterLog []string
+
+func (l *clusterLog) ClusterAdded(client *ClusterClient) { *l = append(*l, "+"+client.Name) }
+func (l *clusterLog) ClusterRemoved(name string)         { *l = append(*l, "-"+name) }
+
+func TestListenersFollowClusters(t *testing.T) {
+	m, err := NewClientManager(nil)
+	require.NoError(t, err)
+	m.AddClient(&ClusterClient{Name: "prod"})
+
+	var log clusterLog
+	m.AddListener(&log)
+	m.AddClient(&ClusterClient{Name: "staging"})
+	m.RemoveCluster("prod")
+	// Removing an unknown cluster tells no one
+	m.RemoveCluster("missing")
+
+	assert.Equal(t, clusterLog{"+prod", "+staging", "-prod"}, log)
+}
@@ -0,0 +1,326 @@
+package kube
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/logger"
+	"github.com/anubhavg-icpl/krustron/pkg/sigv4"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/jwt"
+	"k8s.io/client-go/rest"
+)
+
+// Cloud auth types
+const (
+	AuthTypeEKS = "eks"
+	AuthTypeGKE = "gke"
+	AuthTypeAKS = "aks"
+)
+
+const (
+	// tokenRefreshMargin is how long before expiry a cloud token is renewed,
+	// so requests never go out with a token about to lapse
+	tokenRefreshMargin = 5 * time.Minute
+
+	// eksTokenLifetime is how long an EKS token is accepted; the presigned
+	// URL inside it is honoured for 15 minutes
+	eksTokenLifetime = 14 * time.Minute
+	eksTokenPrefix   = "k8s-aws-v1."
+	eksSessionName   = "krustron"
+
+	gkeScope        = "https://www.googleapis.com/auth/cloud-platform"
+	gkeTokenURL     = "https://oauth2.googleapis.com/token"
+	aksServerAppID  = "6dae42f8-4368-4678-94ff-3960e28e3630"
+	azureLoginURL   = "https://login.microsoftonline.com"
+	emptyBodySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// Credential keys read from a cloud cluster's secret
+const (
+	CredAWSAccessKeyID     = "aws_access_key_id"
+	CredAWSSecretAccessKey = "aws_secret_access_key"
+	CredAWSSessionToken    = "aws_session_token"
+	CredGCPServiceAccount  = "service_account.json"
+	CredAzureClientID      = "client_id"
+	CredAzureClientSecret  = "client_secret"
+)
+
+// CloudAuth is the non-secret connection metadata for a managed cluster
+// authenticated through its cloud provider. Credentials live in a secret
+// backend under CredentialsRef and are never stored with it.
+type CloudAuth struct {
+	Provider string `json:"provider"` // eks, gke or aks
+	// CACert is the PEM CA bundle of the cluster's API server
+	CACert string `json:"ca_cert"`
+	// ClusterName is the cluster's name at the provider, if it differs
+	// from its name in krustron
+	ClusterName    string `json:"cluster_name,omitempty"`
+	CredentialsRef string `json:"credentials_ref"`
+
+	// EKS
+	Region     string `json:"region,omitempty"`
+	RoleARN    string `json:"role_arn,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+
+	// GKE
+	Project  string `json:"project,omitempty"`
+	Location string `json:"location,omitempty"`
+
+	// AKS
+	SubscriptionID string `json:"subscription_id,omitempty"`
+	ResourceGroup  string `json:"resource_group,omitempty"`
+	TenantID       string `json:"tenant_id,omitempty"`
+	// ServerAppID is the AAD application the API server accepts tokens
+	// for; defaults to the AKS managed AAD server app
+	ServerAppID string `json:"server_app_id,omitempty"`
+}
+
+// Validate checks that the fields the provider needs are set
+func (a *CloudAuth) Validate() error {
+	if a.CACert == "" {
+		return fmt.Errorf("ca_cert is required")
+	}
+	if a.CredentialsRef == "" {
+		return fmt.Errorf("credentials_ref is required")
+	}
+	switch a.Provider {
+	case AuthTypeEKS:
+		if a.Region == "" {
+			return fmt.Errorf("region is required for EKS")
+		}
+	case AuthTypeGKE:
+		if a.Project == "" || a.Location == "" {
+			return fmt.Errorf("project and location are required for GKE")
+		}
+	case AuthTypeAKS:
+		if a.TenantID == "" || a.SubscriptionID == "" {
+			return fmt.Errorf("tenant_id and subscription_id are required for AKS")
+		}
+	default:
+		return fmt.Errorf("unsupported cloud provider: %s", a.Provider)
+	}
+	return nil
+}
+
+// NewCloudTokenSource returns a source of bearer tokens for the cluster,
+// minted from creds and renewed tokenRefreshMargin before they expire
+func NewCloudTokenSource(auth *CloudAuth, clusterName string, creds map[string][]byte) (oauth2.TokenSource, error) {
+	if err := auth.Validate(); err != nil {
+		return nil, err
+	}
+	if auth.ClusterName != "" {
+		clusterName = auth.ClusterName
+	}
+
+	var src oauth2.TokenSource
+	switch auth.Provider {
+	case AuthTypeEKS:
+		keys := awsKeys{
+			AccessKeyID:     string(creds[CredAWSAccessKeyID]),
+			SecretAccessKey: string(creds[CredAWSSecretAccessKey]),
+			SessionToken:    string(creds[CredAWSSessionToken]),
+		}
+		if keys.AccessKeyID == "" || keys.SecretAccessKey == "" {
+			return nil, fmt.Errorf("EKS credentials need %s and %s", CredAWSAccessKeyID, CredAWSSecretAccessKey)
+		}
+		src = &eksTokenSource{
+			client:      http.DefaultClient,
+			clusterName: clusterName,
+			region:      auth.Region,
+			roleARN:     auth.RoleARN,
+			externalID:  auth.ExternalID,
+			keys:        keys,
+			now:         time.Now,
+		}
+
+	case AuthTypeGKE:
+		var account struct {
+			ClientEmail  string `json:"client_email"`
+			PrivateKey   string `json:"private_key"`
+			PrivateKeyID string `json:"private_key_id"`
+			TokenURI     string `json:"token_uri"`
+		}
+		if err := json.Unmarshal(creds[CredGCPServiceAccount], &account); err != nil {
+			return nil, fmt.Errorf("failed to parse GKE service account: %w", err)
+		}
+		if account.ClientEmail == "" || account.PrivateKey == "" {
+			return nil, fmt.Errorf("GKE service account needs client_email and private_key")
+		}
+		if account.TokenURI == "" {
+			account.TokenURI = gkeTokenURL
+		}
+		cfg := &jwt.Config{
+			Email:        account.ClientEmail,
+			PrivateKey:   []byte(account.PrivateKey),
+			PrivateKeyID: account.PrivateKeyID,
+			TokenURL:     account.TokenURI,
+			Scopes:       []string{gkeScope},
+		}
+		src = cfg.TokenSource(context.Background())
+
+	case AuthTypeAKS:
+		clientID := string(creds[CredAzureClientID])
+		clientSecret := string(creds[CredAzureClientSecret])
+		if clientID == "" || clientSecret == "" {
+			return nil, fmt.Errorf("AKS credentials need %s and %s", CredAzureClientID, CredAzureClientSecret)
+		}
+		serverAppID := auth.ServerAppID
+		if serverAppID == "" {
+			serverAppID = aksServerAppID
+		}
+		cfg := &clientcredentials.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			TokenURL:     fmt.Sprintf("%s/%s/oauth2/v2.0/token", azureLoginURL, url.PathEscape(auth.TenantID)),
+			Scopes:       []string{serverAppID + "/.default"},
+		}
+		src = cfg.TokenSource(context.Background())
+	}
+
+	return oauth2.ReuseTokenSourceWithExpiry(nil, src, tokenRefreshMargin), nil
+}
+
+// AddClusterWithTokenSource adds a cluster whose requests carry bearer
+// tokens from ts
+func (m *ClientManager) AddClusterWithTokenSource(name, apiServer, caCert string, ts oauth2.TokenSource) (*ClusterClient, error) {
+	config := &rest.Config{
+		Host: apiServer,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: []byte(caCert),
+		},
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return &oauth2.Transport{Source: ts, Base: rt}
+		},
+		QPS:   m.config.QPS,
+		Burst: m.config.Burst,
+	}
+
+	client, err := m.createClient(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	m.store(client)
+
+	logger.Info("Added cluster with cloud auth", zap.String("cluster", name), zap.String("api_server", apiServer))
+	return client, nil
+}
+
+// awsKeys are AWS credentials, static or from an assumed role
+type awsKeys struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time // zero for static keys
+}
+
+func (k awsKeys) credentials() sigv4.Credentials {
+	return sigv4.Credentials{AccessKeyID: k.AccessKeyID, SecretAccessKey: k.SecretAccessKey, SessionToken: k.SessionToken}
+}
+
+// eksTokenSource mints EKS tokens: a presigned STS GetCallerIdentity URL
+// the API server replays to learn the caller's IAM identity. With a role
+// ARN the URL is signed with the role's credentials, assumed as needed.
+// Token is only called under the lock of the wrapping ReuseTokenSource.
+type eksTokenSource struct {
+	client      *http.Client
+	clusterName string
+	region      string
+	roleARN     string
+	externalID  string
+	keys        awsKeys
+	assumed     awsKeys
+	now         func() time.Time
+}
+
+func (e *eksTokenSource) Token() (*oauth2.Token, error) {
+	keys := e.keys
+	if e.roleARN != "" {
+		if e.assumed.AccessKeyID == "" || e.now().Add(tokenRefreshMargin+eksTokenLifetime).After(e.assumed.Expiration) {
+			assumed, err := e.assumeRole()
+			if err != nil {
+				return nil, err
+			}
+			e.assumed = assumed
+		}
+		keys = e.assumed
+	}
+
+	now := e.now()
+	u := e.stsURL(url.Values{
+		"Action":  {"GetCallerIdentity"},
+		"Version": {"2011-06-15"},
+	})
+	sigv4.PresignURL(u, map[string]string{"x-k8s-aws-id": e.clusterName}, keys.credentials(), e.region, "sts", 60*time.Second, now, emptyBodySHA256)
+
+	return &oauth2.Token{
+		AccessToken: eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(u.String())),
+		TokenType:   "Bearer",
+		Expiry:      now.Add(eksTokenLifetime),
+	}, nil
+}
+
+// assumeRole exchanges the static keys for the role's temporary
+// credentials through a presigned STS AssumeRole call
+func (e *eksTokenSource) assumeRole() (awsKeys, error) {
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {e.roleARN},
+		"RoleSessionName": {eksSessionName},
+		"DurationSeconds": {"3600"},
+	}
+	if e.externalID != "" {
+		params.Set("ExternalId", e.externalID)
+	}
+	u := e.stsURL(params)
+	sigv4.PresignURL(u, nil, e.keys.credentials(), e.region, "sts", 60*time.Second, e.now(), emptyBodySHA256)
+
+	resp, err := e.client.Get(u.String())
+	if err != nil {
+		return awsKeys{}, fmt.Errorf("failed to assume role %s: %w", e.roleARN, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return awsKeys{}, fmt.Errorf("failed to assume role %s: %s: %s", e.roleARN, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return awsKeys{}, fmt.Errorf("failed to parse AssumeRole response: %w", err)
+	}
+	return awsKeys{
+		AccessKeyID:     result.Credentials.AccessKeyID,
+		SecretAccessKey: result.Credentials.SecretAccessKey,
+		SessionToken:    result.Credentials.SessionToken,
+		Expiration:      result.Credentials.Expiration,
+	}, nil
+}
+
+func (e *eksTokenSource) stsURL(params url.Values) *url.URL {
+	return &url.URL{
+		Scheme:   "https",
+		Host:     fmt.Sprintf("sts.%s.amazonaws.com", e.region),
+		Path:     "/",
+		RawQuery: params.Encode(),
+	}
+}
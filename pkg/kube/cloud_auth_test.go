@@ -0,0 +1,126 @@
+package kube
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEKSToken(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := &eksTokenSource{
+		clusterName: "prod",
+		region:      "eu-west-1",
+		keys:        awsKeys{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"},
+		now:         func() time.Time { return now },
+	}
+
+	token, err := src.Token()
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(eksTokenLifetime), token.Expiry)
+	require.True(t, strings.HasPrefix(token.AccessToken, eksTokenPrefix))
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token.AccessToken, eksTokenPrefix))
+	require.NoError(t, err)
+	u, err := url.Parse(string(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "sts.eu-west-1.amazonaws.com", u.Host)
+	q := u.Query()
+	assert.Equal(t, "GetCallerIdentity", q.Get("Action"))
+	assert.Equal(t, "host;x-k8s-aws-id", q.Get("X-Amz-SignedHeaders"))
+	assert.Equal(t, "session", q.Get("X-Amz-Security-Token"))
+	assert.Equal(t, "60", q.Get("X-Amz-Expires"))
+	assert.Len(t, q.Get("X-Amz-Signature"), 64)
+}
+
+func TestEKSTokenAssumesRole(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	calls := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "AssumeRole", r.URL.Query().Get("Action"))
+		assert.Equal(t, "arn:aws:iam::123456789012:role/eks", r.URL.Query().Get("RoleArn"))
+		assert.Equal(t, "ext", r.URL.Query().Get("ExternalId"))
+		w.Write([]byte(`<AssumeRoleResponse><AssumeRoleResult><Credentials>
+			<AccessKeyId>ASIAROLE</AccessKeyId>
+			<SecretAccessKey>rolesecret</SecretAccessKey>
+			<SessionToken>roletoken</SessionToken>
+			<Expiration>2026-01-02T04:04:05Z</Expiration>
+		</Credentials></AssumeRoleResult></AssumeRoleResponse>`))
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	target, _ := url.Parse(server.URL)
+	client.Transport.(*http.Transport).Proxy = nil
+	base := client.Transport
+	client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r.URL.Host = target.Host
+		return base.RoundTrip(r)
+	})
+
+	src := &eksTokenSource{
+		client:      client,
+		clusterName: "prod",
+		region:      "eu-west-1",
+		roleARN:     "arn:aws:iam::123456789012:role/eks",
+		externalID:  "ext",
+		keys:        awsKeys{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		now:         func() time.Time { return now },
+	}
+
+	token, err := src.Token()
+	require.NoError(t, err)
+	raw, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token.AccessToken, eksTokenPrefix))
+	u, _ := url.Parse(string(raw))
+	assert.True(t, strings.HasPrefix(u.Query().Get("X-Amz-Credential"), "ASIAROLE/"))
+	assert.Equal(t, "roletoken", u.Query().Get("X-Amz-Security-Token"))
+
+	// The role's credentials are reused while they outlast the next token
+	_, err = src.Token()
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(45 * time.Minute)
+	_, err = src.Token()
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestCloudAuthValidate(t *testing.T) {
+	base := CloudAuth{CACert: "pem", CredentialsRef: "krustron-system/creds"}
+
+	eks := base
+	eks.Provider = AuthTypeEKS
+	assert.Error(t, eks.Validate())
+	eks.Region = "us-east-1"
+	assert.NoError(t, eks.Validate())
+
+	gke := base
+	gke.Provider = AuthTypeGKE
+	gke.Project = "p"
+	assert.Error(t, gke.Validate())
+	gke.Location = "europe-west1"
+	assert.NoError(t, gke.Validate())
+
+	aks := base
+	aks.Provider = AuthTypeAKS
+	aks.TenantID = "t"
+	assert.Error(t, aks.Validate())
+	aks.SubscriptionID = "s"
+	assert.NoError(t, aks.Validate())
+
+	aks.CredentialsRef = ""
+	assert.Error(t, aks.Validate())
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,145 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+var (
+	// ErrUnknownResource is returned for a resource type the cluster
+	// doesn't serve
+	ErrUnknownResource = errors.New("unknown resource type")
+	// ErrResourceScope is returned when a namespace is given for a
+	// cluster-scoped resource or missing for a namespaced one
+	ErrResourceScope = errors.New("namespace doesn't match resource scope")
+)
+
+// APIResource is a resource type served by a cluster, as listed by
+// discovery
+type APIResource struct {
+	Group      string   `json:"group"`
+	Version    string   `json:"version"`
+	Resource   string   `json:"resource"`
+	Kind       string   `json:"kind"`
+	Namespaced bool     `json:"namespaced"`
+	Verbs      []string `json:"verbs"`
+	ShortNames []string `json:"short_names,omitempty"`
+}
+
+// GVR returns the resource's group, version and resource
+func (r APIResource) GVR() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
+// APIResources lists the resource types the cluster serves at their
+// preferred version, sorted by group and resource. Subresources are left
+// out. Groups whose discovery fails (e.g. an unavailable aggregated API)
+// are skipped rather than failing the whole list.
+func (c *ClusterClient) APIResources(ctx context.Context) ([]APIResource, error) {
+	lists, err := discovery.ServerPreferredResources(c.Clientset.Discovery())
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover API resources: %w", err)
+	}
+
+	var resources []APIResource
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			resources = append(resources, APIResource{
+				Group:      gv.Group,
+				Version:    gv.Version,
+				Resource:   r.Name,
+				Kind:       r.Kind,
+				Namespaced: r.Namespaced,
+				Verbs:      r.Verbs,
+				ShortNames: r.ShortNames,
+			})
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Group != resources[j].Group {
+			return resources[i].Group < resources[j].Group
+		}
+		return resources[i].Resource < resources[j].Resource
+	})
+	return resources, nil
+}
+
+// ResourceScope reports whether gvr is namespaced, asking discovery for
+// its group version
+func (c *ClusterClient) ResourceScope(gvr schema.GroupVersionResource) (bool, error) {
+	list, err := c.Clientset.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("%w: %s is not served", ErrUnknownResource, gvr.GroupVersion())
+		}
+		return false, fmt.Errorf("failed to discover %s: %w", gvr.GroupVersion(), err)
+	}
+	for _, r := range list.APIResources {
+		if r.Name == gvr.Resource {
+			return r.Namespaced, nil
+		}
+	}
+	return false, fmt.Errorf("%w: %q in %s", ErrUnknownResource, gvr.Resource, gvr.GroupVersion())
+}
+
+// resourceClient returns the dynamic client for gvr, scoped to namespace
+// when the resource is namespaced. A namespace for a cluster-scoped
+// resource is an error; an empty one for a namespaced resource means all
+// namespaces.
+func (c *ClusterClient) resourceClient(gvr schema.GroupVersionResource, namespace string) (dynamic.ResourceInterface, bool, error) {
+	if c.DynamicClient == nil {
+		return nil, false, fmt.Errorf("cluster %s has no dynamic client", c.Name)
+	}
+	namespaced, err := c.ResourceScope(gvr)
+	if err != nil {
+		return nil, false, err
+	}
+	if !namespaced {
+		if namespace != "" {
+			return nil, false, fmt.Errorf("%w: %s is cluster-scoped", ErrResourceScope, gvr.GroupResource())
+		}
+		return c.DynamicClient.Resource(gvr), false, nil
+	}
+	return c.DynamicClient.Resource(gvr).Namespace(namespace), true, nil
+}
+
+// GetResource gets one object of any resource type. namespace must be
+// empty for cluster-scoped resources and set for namespaced ones.
+func (c *ClusterClient) GetResource(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	client, namespaced, err := c.resourceClient(gvr, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if namespaced && namespace == "" {
+		return nil, fmt.Errorf("%w: %s is namespaced and needs a namespace", ErrResourceScope, gvr.GroupResource())
+	}
+	return client.Get(ctx, name, metav1.GetOptions{})
+}
+
+// ListResources lists a page of objects of any resource type; list.GetContinue()
+// is the cursor for the next page. An empty namespace lists a namespaced
+// resource across all namespaces.
+func (c *ClusterClient) ListResources(ctx context.Context, gvr schema.GroupVersionResource, namespace string, opts ListOptions) (*unstructured.UnstructuredList, error) {
+	client, _, err := c.resourceClient(gvr, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return client.List(ctx, opts.metaOptions())
+}
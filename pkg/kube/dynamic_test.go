@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	certificates   = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	clusterIssuers = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+)
+
+func certManagerObject(kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"secretName": name + "-tls"},
+	}}
+	if namespace != "" {
+		obj.SetNamespace(namespace)
+	}
+	return obj
+}
+
+// crdClient is a cluster client serving cert-manager's Certificate and
+// ClusterIssuer types
+func crdClient() *ClusterClient {
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: []string{"get", "list"}},
+				{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: []string{"get"}},
+			},
+		},
+		{
+			GroupVersion: "cert-manager.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "certificates", Kind: "Certificate", Namespaced: true, Verbs: []string{"get", "list"}, ShortNames: []string{"cert"}},
+				{Name: "clusterissuers", Kind: "ClusterIssuer", Verbs: []string{"get", "list"}},
+			},
+		},
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			certificates:   "CertificateList",
+			clusterIssuers: "ClusterIssuerList",
+		},
+		certManagerObject("Certificate", "shop", "web"),
+		certManagerObject("Certificate", "ops", "grafana"),
+		certManagerObject("ClusterIssuer", "", "letsencrypt"),
+	)
+	return &ClusterClient{Name: "prod", Clientset: clientset, DynamicClient: dynamicClient}
+}
+
+func TestAPIResources(t *testing.T) {
+	resources, err := crdClient().APIResources(context.Background())
+	require.NoError(t, err)
+
+	var names []string
+	for _, r := range resources {
+		names = append(names, r.GVR().String())
+	}
+	assert.Equal(t, []string{
+		"/v1, Resource=pods",
+		"cert-manager.io/v1, Resource=certificates",
+		"cert-manager.io/v1, Resource=clusterissuers",
+	}, names, "subresources are left out")
+	assert.True(t, resources[1].Namespaced)
+	assert.Equal(t, []string{"cert"}, resources[1].ShortNames)
+	assert.False(t, resources[2].Namespaced)
+}
+
+func TestGetResource(t *testing.T) {
+	client := crdClient()
+	ctx := context.Background()
+
+	cert, err := client.GetResource(ctx, certificates, "shop", "web")
+	require.NoError(t, err)
+	assert.Equal(t, "Certificate", cert.GetKind())
+	secret, _, _ := unstructured.NestedString(cert.Object, "spec", "secretName")
+	assert.Equal(t, "web-tls", secret)
+
+	issuer, err := client.GetResource(ctx, clusterIssuers, "", "letsencrypt")
+	require.NoError(t, err)
+	assert.Equal(t, "letsencrypt", issuer.GetName())
+
+	_, err = client.GetResource(ctx, certificates, "", "web")
+	assert.ErrorIs(t, err, ErrResourceScope)
+	_, err = client.GetResource(ctx, clusterIssuers, "shop", "letsencrypt")
+	assert.ErrorIs(t, err, ErrResourceScope)
+	_, err = client.GetResource(ctx, schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}, "argocd", "web")
+	assert.ErrorIs(t, err, ErrUnknownResource)
+	_, err = client.GetResource(ctx, schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "issuers"}, "shop", "web")
+	assert.ErrorIs(t, err, ErrUnknownResource)
+}
+
+func TestListResources(t *testing.T) {
+	client := crdClient()
+	ctx := context.Background()
+
+	all, err := client.ListResources(ctx, certificates, "", ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, all.Items, 2, "an empty namespace lists all namespaces")
+
+	shop, err := client.ListResources(ctx, certificates, "shop", ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, shop.Items, 1)
+	assert.Equal(t, "web", shop.Items[0].GetName())
+
+	issuers, err := client.ListResources(ctx, clusterIssuers, "", ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, issuers.Items, 1)
+}
@@ -0,0 +1,141 @@
+// Package kube provides Kubernetes client utilities
+// Author: Anubhav Gain <anubhavg@infopercept.com>
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// ErrContainerNotFound is returned when the requested container is not
+	// part of the pod spec
+	ErrContainerNotFound = errors.New("container not found in pod")
+	// ErrContainerNotStarted is returned when the container has not run yet,
+	// or when previous logs are asked for a container that never restarted
+	ErrContainerNotStarted = errors.New("container has not started")
+)
+
+// LogOptions controls a pod log stream. SinceTime and SinceSeconds are
+// mutually exclusive; SinceTime wins when both are set. Zero TailLines and
+// LimitBytes mean no limit.
+type LogOptions struct {
+	Follow       bool
+	Previous     bool
+	Timestamps   bool
+	SinceTime    *time.Time
+	SinceSeconds int64
+	TailLines    int64
+	LimitBytes   int64
+}
+
+func (o LogOptions) podLogOptions(container string) *corev1.PodLogOptions {
+	opts := &corev1.PodLogOptions{
+		Container:  container,
+		Follow:     o.Follow,
+		Previous:   o.Previous,
+		Timestamps: o.Timestamps,
+	}
+	switch {
+	case o.SinceTime != nil:
+		t := metav1.NewTime(*o.SinceTime)
+		opts.SinceTime = &t
+	case o.SinceSeconds > 0:
+		s := o.SinceSeconds
+		opts.SinceSeconds = &s
+	}
+	if o.TailLines > 0 {
+		t := o.TailLines
+		opts.TailLines = &t
+	}
+	if o.LimitBytes > 0 {
+		l := o.LimitBytes
+		opts.LimitBytes = &l
+	}
+	return opts
+}
+
+// StreamPodLogs copies a pod's logs into out until the stream ends, the
+// byte limit is hit or ctx is cancelled. An empty container selects the
+// pod's only (or first) container.
+func (c *ClusterClient) StreamPodLogs(ctx context.Context, namespace, podName, container string, opts LogOptions, out io.Writer) error {
+	pod, err := c.Clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	container, err = resolveLogContainer(pod, container, opts.Previous)
+	if err != nil {
+		return err
+	}
+
+	stream, err := c.Clientset.CoreV1().Pods(namespace).GetLogs(podName, opts.podLogOptions(container)).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open log stream: %w", err)
+	}
+	defer stream.Close()
+
+	var src io.Reader = stream
+	if opts.LimitBytes > 0 {
+		// The API server honours LimitBytes too; this guards clusters and
+		// proxies that don't
+		src = io.LimitReader(stream, opts.LimitBytes)
+	}
+
+	if _, err := io.Copy(out, src); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to read log stream: %w", err)
+	}
+	return ctx.Err()
+}
+
+// resolveLogContainer picks the container to read and checks it has
+// something to read from
+func resolveLogContainer(pod *corev1.Pod, container string, previous bool) (string, error) {
+	if container == "" {
+		if len(pod.Spec.Containers) == 0 {
+			return "", fmt.Errorf("%w: pod %s has no containers", ErrContainerNotFound, pod.Name)
+		}
+		container = pod.Spec.Containers[0].Name
+	}
+
+	found := false
+	for _, list := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, ctr := range list {
+			if ctr.Name == container {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("%w: %s in pod %s", ErrContainerNotFound, container, pod.Name)
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.ContainerStatuses...), pod.Status.InitContainerStatuses...)
+	for _, status := range statuses {
+		if status.Name != container {
+			continue
+		}
+		if previous {
+			if status.LastTerminationState.Terminated == nil {
+				return "", fmt.Errorf("%w: %s has no previous terminated instance", ErrContainerNotStarted, container)
+			}
+			return container, nil
+		}
+		if status.State.Waiting != nil && status.RestartCount == 0 {
+			return "", fmt.Errorf("%w: %s is waiting (%s)", ErrContainerNotStarted, container, status.State.Waiting.Reason)
+		}
+		return container, nil
+	}
+
+	// No status yet means the kubelet hasn't picked the pod up
+	return "", fmt.Errorf("%w: %s has no status yet", ErrContainerNotStarted, container)
+}
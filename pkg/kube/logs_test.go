@@ -0,0 +1,91 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func logPod(status corev1.ContainerStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "sidecar"}}},
+		Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+	}
+}
+
+func TestStreamPodLogsWritesStream(t *testing.T) {
+	running := corev1.ContainerStatus{Name: "app", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	client := &ClusterClient{Clientset: fake.NewSimpleClientset(logPod(running))}
+
+	var out bytes.Buffer
+	require.NoError(t, client.StreamPodLogs(context.Background(), "default", "web", "", LogOptions{Follow: true}, &out))
+	// The fake clientset always answers log requests with this body
+	assert.Equal(t, "fake logs", out.String())
+
+	out.Reset()
+	require.NoError(t, client.StreamPodLogs(context.Background(), "default", "web", "app", LogOptions{LimitBytes: 4}, &out))
+	assert.Equal(t, "fake", out.String())
+}
+
+func TestStreamPodLogsContainerErrors(t *testing.T) {
+	waiting := corev1.ContainerStatus{Name: "app", State: corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+	}}
+	client := &ClusterClient{Clientset: fake.NewSimpleClientset(logPod(waiting))}
+	ctx := context.Background()
+
+	err := client.StreamPodLogs(ctx, "default", "web", "missing", LogOptions{}, &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrContainerNotFound)
+
+	err = client.StreamPodLogs(ctx, "default", "web", "app", LogOptions{}, &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrContainerNotStarted)
+
+	err = client.StreamPodLogs(ctx, "default", "web", "app", LogOptions{Previous: true}, &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrContainerNotStarted)
+
+	// sidecar is in the spec but the kubelet has reported nothing yet
+	err = client.StreamPodLogs(ctx, "default", "web", "sidecar", LogOptions{}, &bytes.Buffer{})
+	assert.ErrorIs(t, err, ErrContainerNotStarted)
+}
+
+func TestStreamPodLogsPreviousAfterCrash(t *testing.T) {
+	crashed := corev1.ContainerStatus{
+		Name:                 "app",
+		RestartCount:         3,
+		State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}},
+	}
+	client := &ClusterClient{Clientset: fake.NewSimpleClientset(logPod(crashed))}
+
+	var out bytes.Buffer
+	require.NoError(t, client.StreamPodLogs(context.Background(), "default", "web", "app", LogOptions{Previous: true}, &out))
+	assert.Equal(t, "fake logs", out.String())
+}
+
+func TestLogOptionsPodLogOptions(t *testing.T) {
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := LogOptions{Follow: true, Timestamps: true, SinceTime: &since, SinceSeconds: 60, TailLines: 10, LimitBytes: 1024}.
+		podLogOptions("app")
+
+	assert.Equal(t, "app", opts.Container)
+	assert.True(t, opts.Follow)
+	assert.True(t, opts.Timestamps)
+	require.NotNil(t, opts.SinceTime)
+	assert.True(t, opts.SinceTime.Time.Equal(since))
+	assert.Nil(t, opts.SinceSeconds, "sinceTime and sinceSeconds are mutually exclusive")
+	assert.Equal(t, int64(10), *opts.TailLines)
+	assert.Equal(t, int64(1024), *opts.LimitBytes)
+
+	empty := LogOptions{}.podLogOptions("")
+	assert.Nil(t, empty.TailLines)
+	assert.Nil(t, empty.LimitBytes)
+	assert.Nil(t, empty.SinceSeconds)
+}
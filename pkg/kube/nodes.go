@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrMetricsUnavailable is returned when the cluster has no metrics-server
+var ErrMetricsUnavailable = errors.New("metrics API is not available")
+
+// NodeMetricsResource is the metrics-server resource reporting node usage
+var NodeMetricsResource = schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "nodes"}
+
+// ResourceUsage is the CPU and memory a node is using
+type ResourceUsage struct {
+	CPUMillis   int64
+	MemoryBytes int64
+}
+
+// NodeMetrics returns current usage by node name from metrics-server. It
+// returns ErrMetricsUnavailable when metrics.k8s.io is not served.
+func (c *ClusterClient) NodeMetrics(ctx context.Context) (map[string]ResourceUsage, error) {
+	if c.DynamicClient == nil {
+		return nil, ErrMetricsUnavailable
+	}
+	list, err := c.DynamicClient.Resource(NodeMetricsResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) || apierrors.IsServiceUnavailable(err) {
+			return nil, ErrMetricsUnavailable
+		}
+		return nil, fmt.Errorf("failed to list node metrics: %w", err)
+	}
+
+	usage := make(map[string]ResourceUsage, len(list.Items))
+	for _, item := range list.Items {
+		var u ResourceUsage
+		if cpu, ok := nestedQuantity(item, "usage", "cpu"); ok {
+			u.CPUMillis = cpu.MilliValue()
+		}
+		if memory, ok := nestedQuantity(item, "usage", "memory"); ok {
+			u.MemoryBytes = memory.Value()
+		}
+		usage[item.GetName()] = u
+	}
+	return usage, nil
+}
+
+// nestedQuantity parses the quantity string at fields, if there is one
+func nestedQuantity(obj unstructured.Unstructured, fields ...string) (resource.Quantity, bool) {
+	value, found, err := unstructured.NestedString(obj.Object, fields...)
+	if err != nil || !found {
+		return resource.Quantity{}, false
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+	return q, true
+}
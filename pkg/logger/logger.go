@@ -14,6 +14,8 @@ import (
 var (
 	log  *zap.Logger
 	once sync.Once
+	// level is the global logger's level; see SetLevel
+	level = zap.NewAtomicLevel()
 )
 
 // Config holds logger configuration
@@ -38,22 +40,43 @@ func DefaultConfig() *Config {
 func Init(cfg *Config) error {
 	var err error
 	once.Do(func() {
-		log, err = NewLogger(cfg)
+		log, err = newLogger(cfg, level)
 	})
 	return err
 }
 
+// SetLevel changes the global logger's level without rebuilding it
+func SetLevel(lvl string) error {
+	parsed, err := zapcore.ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(parsed)
+	return nil
+}
+
+// Level returns the global logger's level
+func Level() zapcore.Level {
+	return level.Level()
+}
+
 // NewLogger creates a new zap logger instance
 func NewLogger(cfg *Config) (*zap.Logger, error) {
+	return newLogger(cfg, zap.NewAtomicLevel())
+}
+
+// newLogger builds a logger whose level is atomicLevel, set from cfg
+func newLogger(cfg *Config, atomicLevel zap.AtomicLevel) (*zap.Logger, error) {
 	if cfg == nil {
 		cfg = DefaultConfig()
 	}
 
 	// Parse log level
-	level, err := zapcore.ParseLevel(cfg.Level)
+	parsed, err := zapcore.ParseLevel(cfg.Level)
 	if err != nil {
-		level = zapcore.InfoLevel
+		parsed = zapcore.InfoLevel
 	}
+	atomicLevel.SetLevel(parsed)
 
 	// Configure encoder
 	encoderConfig := zapcore.EncoderConfig{
@@ -96,7 +119,7 @@ func NewLogger(cfg *Config) (*zap.Logger, error) {
 	}
 
 	// Build core
-	core := zapcore.NewCore(encoder, writeSyncer, level)
+	core := zapcore.NewCore(encoder, writeSyncer, atomicLevel)
 
 	// Build logger options
 	opts := []zap.Option{
@@ -114,7 +137,9 @@ func NewLogger(cfg *Config) (*zap.Logger, error) {
 // Get returns the global logger instance
 func Get() *zap.Logger {
 	if log == nil {
-		log, _ = NewLogger(DefaultConfig())
+		cfg := DefaultConfig()
+		cfg.Level = level.Level().String()
+		log, _ = newLogger(cfg, level)
 	}
 	return log
 }
@@ -0,0 +1,156 @@
+// Package metrics defines the application metrics services record and
+// serves them in the Prometheus exposition format
+// Author: Anubhav Gain <anubhavg@infopercept.com>
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are registered without a namespace; Handler prefixes them with
+// observability.metrics.namespace. Unlabelled metrics and the children
+// returned by NewCache record without allocating, so they are safe on hot
+// paths such as authorization checks.
+var (
+	// HTTPRequests counts API requests by method, route and status class
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "API requests by method, route and status class.",
+	}, []string{"method", "route", "code"})
+
+	// HTTPRequestDuration observes API request latency by method and route
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "API request latency.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// RemediationActions counts remediation actions reaching a final status
+	RemediationActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "remediation_actions_total",
+		Help: "Remediation actions by final status.",
+	}, []string{"status"})
+
+	// AITokens counts tokens used by AI requests
+	AITokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ai_tokens_total",
+		Help: "Tokens used by AI requests by provider and model.",
+	}, []string{"provider", "model"})
+
+	// PipelineRunDuration observes how long finished pipeline runs took
+	PipelineRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pipeline_run_duration_seconds",
+		Help:    "Duration of finished pipeline runs by status.",
+		Buckets: []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+	}, []string{"status"})
+
+	// AuthLoginFailures counts rejected password logins
+	AuthLoginFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "auth_login_failures_total",
+		Help: "Rejected password logins.",
+	})
+
+	// RBACAuthorizeDuration observes authorization checks, cached or not
+	RBACAuthorizeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rbac_authorize_duration_seconds",
+		Help:    "Latency of authorization checks.",
+		Buckets: []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	})
+
+	// GRPCThrottledCalls counts gRPC calls rejected by rate and
+	// concurrency limits
+	GRPCThrottledCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_throttled_calls_total",
+		Help: "gRPC calls rejected by rate and concurrency limits, by method and reason.",
+	}, []string{"method", "reason"})
+
+	cacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_hits_total",
+		Help: "Cache lookups that found an entry, by cache.",
+	}, []string{"cache"})
+
+	cacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_misses_total",
+		Help: "Cache lookups that found nothing, by cache.",
+	}, []string{"cache"})
+
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cache_entries",
+		Help: "Entries held by in-process caches, by cache.",
+	}, []string{"cache"})
+)
+
+var collectorsToRegister = []prometheus.Collector{
+	HTTPRequests,
+	HTTPRequestDuration,
+	RemediationActions,
+	AITokens,
+	PipelineRunDuration,
+	AuthLoginFailures,
+	RBACAuthorizeDuration,
+	GRPCThrottledCalls,
+	cacheHits,
+	cacheMisses,
+	cacheEntries,
+}
+
+// Cache counts the hits and misses of one cache, and the entries it holds
+// if it lives in process
+type Cache struct {
+	Hits    prometheus.Counter
+	Misses  prometheus.Counter
+	Entries prometheus.Gauge
+}
+
+// NewCache returns the counters of the cache called name
+func NewCache(name string) *Cache {
+	return &Cache{
+		Hits:    cacheHits.WithLabelValues(name),
+		Misses:  cacheMisses.WithLabelValues(name),
+		Entries: cacheEntries.WithLabelValues(name),
+	}
+}
+
+// Hit records a lookup that found an entry
+func (c *Cache) Hit() { c.Hits.Inc() }
+
+// Miss records a lookup that found nothing
+func (c *Cache) Miss() { c.Misses.Inc() }
+
+// Added records an entry added to the cache
+func (c *Cache) Added() { c.Entries.Inc() }
+
+// Removed records an entry removed from the cache
+func (c *Cache) Removed() { c.Entries.Dec() }
+
+// statusClasses labels responses by the first digit of their status
+var statusClasses = [...]string{"other", "1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// StatusClass returns the label of an HTTP status, such as "2xx"
+func StatusClass(status int) string {
+	if class := status / 100; class > 0 && class < len(statusClasses) {
+		return statusClasses[class]
+	}
+	return statusClasses[0]
+}
+
+// Handler serves the application metrics, along with Go runtime and process
+// metrics, with names prefixed by namespace
+func Handler(namespace string) http.Handler {
+	registry := prometheus.NewRegistry()
+	var registerer prometheus.Registerer = registry
+	if namespace = strings.Trim(namespace, "_"); namespace != "" {
+		registerer = prometheus.WrapRegistererWithPrefix(namespace+"_", registry)
+	}
+	registerer.MustRegister(collectorsToRegister...)
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerServesNamespacedMetrics(t *testing.T) {
+	AuthLoginFailures.Inc()
+	NewCache("handler-test").Hit()
+
+	rec := httptest.NewRecorder()
+	Handler("krustron").ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), "krustron_auth_login_failures_total")
+	assert.Contains(t, string(body), `krustron_cache_hits_total{cache="handler-test"} 1`)
+	assert.Contains(t, string(body), "go_goroutines", "runtime metrics are not namespaced")
+
+	// Handlers have their own registries, so several can coexist
+	rec = httptest.NewRecorder()
+	Handler("").ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), "\nauth_login_failures_total")
+}
+
+func TestCacheCounters(t *testing.T) {
+	c := NewCache("counter-test")
+	c.Hit()
+	c.Hit()
+	c.Miss()
+	assert.Equal(t, 2.0, testutil.ToFloat64(c.Hits))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.Misses))
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", StatusClass(204))
+	assert.Equal(t, "4xx", StatusClass(404))
+	assert.Equal(t, "5xx", StatusClass(503))
+	assert.Equal(t, "other", StatusClass(0))
+	assert.Equal(t, "other", StatusClass(799))
+}
+
+func TestHotPathDoesNotAllocate(t *testing.T) {
+	c := NewCache("alloc-test")
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Hit()
+		c.Miss()
+		AuthLoginFailures.Inc()
+		RBACAuthorizeDuration.Observe(0.0001)
+		_ = StatusClass(200)
+	})
+	assert.Zero(t, allocs)
+}
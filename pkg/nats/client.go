@@ -6,10 +6,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -41,8 +44,10 @@ type Client struct {
 	config       *Config
 	subscriptions map[string]*nats.Subscription
 	subMu        sync.RWMutex
-	handlers     map[string][]MessageHandler
+	handlers     map[string][]registeredHandler
 	handlerMu    sync.RWMutex
+	// nextHandlerID numbers handlers for Subscription.Unsubscribe
+	nextHandlerID uint64
 }
 
 // MessageHandler handles incoming messages
@@ -57,6 +62,9 @@ type Message struct {
 	Timestamp time.Time              `json:"timestamp"`
 	ReplyTo   string                 `json:"reply_to,omitempty"`
 	Sequence  uint64                 `json:"sequence,omitempty"`
+	// Deliveries counts how many times a JetStream message has been
+	// delivered, including this one
+	Deliveries uint64 `json:"deliveries,omitempty"`
 }
 
 // Event represents a Krustron event
@@ -68,6 +76,9 @@ type Event struct {
 	Data      interface{}            `json:"data"`
 	Metadata  map[string]interface{} `json:"metadata"`
 	Timestamp time.Time              `json:"timestamp"`
+	// SchemaVersion versions the shape of Data; PublishEvent defaults it
+	// to EventSchemaVersion
+	SchemaVersion string `json:"schema_version,omitempty"`
 }
 
 // Subjects for Krustron events
@@ -131,8 +142,14 @@ func NewClient(logger *zap.Logger, config *Config) (*Client, error) {
 			logger.Info("NATS connection closed")
 		}),
 		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+			// Connection-level errors, such as permission violations, come
+			// without a subscription
+			subject := ""
+			if sub != nil {
+				subject = sub.Subject
+			}
 			logger.Error("NATS error",
-				zap.String("subject", sub.Subject),
+				zap.String("subject", subject),
 				zap.Error(err),
 			)
 		}),
@@ -166,7 +183,7 @@ func NewClient(logger *zap.Logger, config *Config) (*Client, error) {
 		logger:        logger,
 		config:        config,
 		subscriptions: make(map[string]*nats.Subscription),
-		handlers:      make(map[string][]MessageHandler),
+		handlers:      make(map[string][]registeredHandler),
 	}
 
 	// Setup JetStream if enabled
@@ -203,16 +220,22 @@ func (c *Client) setupStreams() error {
 		{StreamSecurity, []string{"krustron.security.>"}},
 		{StreamAlert, []string{"krustron.alert.>"}},
 		{StreamAudit, []string{"krustron.audit.>"}},
+		// Dead letters are kept longer so they can be inspected and replayed
+		{StreamDLQ, []string{"*.DLQ"}},
 	}
 
 	for _, s := range streams {
+		maxAge := 7 * 24 * time.Hour // 7 days retention
+		if s.Name == StreamDLQ {
+			maxAge = 30 * 24 * time.Hour
+		}
 		_, err := c.js.StreamInfo(s.Name)
 		if err == nats.ErrStreamNotFound {
 			_, err = c.js.AddStream(&nats.StreamConfig{
 				Name:       s.Name,
 				Subjects:   s.Subjects,
 				Retention:  nats.LimitsPolicy,
-				MaxAge:     7 * 24 * time.Hour, // 7 days retention
+				MaxAge:     maxAge,
 				MaxMsgs:    -1,
 				MaxBytes:   -1,
 				Discard:    nats.DiscardOld,
@@ -240,11 +263,26 @@ func (c *Client) Publish(ctx context.Context, subject string, data interface{})
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
+	return c.publishPayload(ctx, subject, payload, "")
+}
 
+// publishPayload publishes an already encoded message with the trace
+// context of ctx. A non-empty msgID is sent as the Nats-Msg-Id header,
+// which JetStream uses to drop duplicates within the stream's Duplicates
+// window.
+func (c *Client) publishPayload(ctx context.Context, subject string, payload []byte, msgID string) error {
+	msg := nats.NewMsg(subject)
+	msg.Data = payload
+	if msgID != "" {
+		msg.Header.Set(nats.MsgIdHdr, msgID)
+	}
+	injectTrace(ctx, msg)
+
+	var err error
 	if c.js != nil {
-		_, err = c.js.Publish(subject, payload)
+		_, err = c.js.PublishMsg(msg)
 	} else {
-		err = c.conn.Publish(subject, payload)
+		err = c.conn.PublishMsg(msg)
 	}
 
 	if err != nil {
@@ -259,26 +297,45 @@ func (c *Client) Publish(ctx context.Context, subject string, data interface{})
 	return nil
 }
 
-// PublishEvent publishes a Krustron event
+// PublishEvent publishes a Krustron event as a CloudEvent (see
+// EncodeCloudEvent) on event.Subject, or on krustron.<source>.<type> when
+// the event has no subject. The event ID doubles as the Nats-Msg-Id, so
+// republishing the same event is deduplicated by JetStream.
 func (c *Client) PublishEvent(ctx context.Context, event *Event) error {
+	if event.ID == "" {
+		event.ID = generateEventID()
+	}
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
+	if event.SchemaVersion == "" {
+		event.SchemaVersion = EventSchemaVersion
+	}
+	if event.Subject == "" {
+		event.Subject = fmt.Sprintf("krustron.%s.%s", event.Source, event.Type)
+	}
 
-	subject := fmt.Sprintf("krustron.%s.%s", event.Source, event.Type)
-	return c.Publish(ctx, subject, event)
+	payload, err := EncodeCloudEvent(event)
+	if err != nil {
+		return err
+	}
+	return c.publishPayload(ctx, event.Subject, payload, event.ID)
 }
 
-// Request sends a request and waits for a response
+// Request sends a request and waits for a response, for at most timeout
+// or until ctx ends. The deadline travels with the request; see
+// HandleRequest and RequestTyped for replies in the standard envelope.
 func (c *Client) Request(ctx context.Context, subject string, data interface{}, timeout time.Duration) (*Message, error) {
 	payload, err := json.Marshal(data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	msg, err := c.conn.Request(subject, payload, timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	msg, err := c.requestMsg(ctx, subject, payload)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
 
 	return &Message{
@@ -289,111 +346,87 @@ func (c *Client) Request(ctx context.Context, subject string, data interface{},
 	}, nil
 }
 
-// Subscribe subscribes to a subject with a handler
-func (c *Client) Subscribe(subject string, handler MessageHandler) error {
-	c.handlerMu.Lock()
-	c.handlers[subject] = append(c.handlers[subject], handler)
-	c.handlerMu.Unlock()
-
-	c.subMu.Lock()
-	defer c.subMu.Unlock()
-
-	// Check if already subscribed
-	if _, exists := c.subscriptions[subject]; exists {
-		return nil
-	}
-
-	sub, err := c.conn.Subscribe(subject, func(msg *nats.Msg) {
-		c.handleMessage(subject, msg)
+// Subscribe registers handler for messages on subject. Each call adds a
+// separate subscriber that gets every message once; see Subscription.
+func (c *Client) Subscribe(subject string, handler MessageHandler) (*Subscription, error) {
+	return c.addHandler(subject, handler, func() (*nats.Subscription, error) {
+		sub, err := c.conn.Subscribe(subject, func(msg *nats.Msg) {
+			c.handleMessage(subject, msg)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to subscribe: %w", err)
+		}
+		c.logger.Info("Subscribed to subject", zap.String("subject", subject))
+		return sub, nil
 	})
-	if err != nil {
-		return fmt.Errorf("failed to subscribe: %w", err)
-	}
-
-	c.subscriptions[subject] = sub
-	c.logger.Info("Subscribed to subject", zap.String("subject", subject))
-
-	return nil
 }
 
-// SubscribeQueue subscribes to a subject with queue group
-func (c *Client) SubscribeQueue(subject, queue string, handler MessageHandler) error {
-	c.handlerMu.Lock()
+// SubscribeQueue registers handler for messages on subject shared across
+// the queue group. Within this client every handler registered for the
+// same subject and queue gets each message the group hands it.
+func (c *Client) SubscribeQueue(subject, queue string, handler MessageHandler) (*Subscription, error) {
 	key := fmt.Sprintf("%s:%s", subject, queue)
-	c.handlers[key] = append(c.handlers[key], handler)
-	c.handlerMu.Unlock()
-
-	c.subMu.Lock()
-	defer c.subMu.Unlock()
-
-	if _, exists := c.subscriptions[key]; exists {
-		return nil
-	}
-
-	sub, err := c.conn.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
-		c.handleMessage(key, msg)
+	return c.addHandler(key, handler, func() (*nats.Subscription, error) {
+		sub, err := c.conn.QueueSubscribe(subject, queue, func(msg *nats.Msg) {
+			c.handleMessage(key, msg)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to queue subscribe: %w", err)
+		}
+		c.logger.Info("Queue subscribed",
+			zap.String("subject", subject),
+			zap.String("queue", queue),
+		)
+		return sub, nil
 	})
-	if err != nil {
-		return fmt.Errorf("failed to queue subscribe: %w", err)
-	}
-
-	c.subscriptions[key] = sub
-	c.logger.Info("Queue subscribed",
-		zap.String("subject", subject),
-		zap.String("queue", queue),
-	)
-
-	return nil
 }
 
-// SubscribeJetStream subscribes to a JetStream stream
-func (c *Client) SubscribeJetStream(stream, consumer string, handler MessageHandler, opts ...nats.SubOpt) error {
+// SubscribeJetStream subscribes a durable consumer to a JetStream stream.
+// jsOpts sets the ack wait, delivery limit, redelivery backoff and
+// dead-letter subject; nil takes the defaults. Handlers registered for the
+// same stream and consumer share one subscription (whose options are those
+// of the first) and a message is acked only once all of them succeed.
+func (c *Client) SubscribeJetStream(stream, consumer string, handler MessageHandler, jsOpts *JetStreamOptions, opts ...nats.SubOpt) (*Subscription, error) {
 	if c.js == nil {
-		return fmt.Errorf("JetStream not enabled")
+		return nil, fmt.Errorf("JetStream not enabled")
 	}
 
 	key := fmt.Sprintf("js:%s:%s", stream, consumer)
+	return c.addHandler(key, handler, func() (*nats.Subscription, error) {
+		sub := &jsSubscription{key: key, stream: stream, consumer: consumer, opts: jsOpts.withDefaults(stream)}
+
+		// Default options. The server-side delivery limit is left unbounded:
+		// handleJetStreamMessage enforces MaxDeliver itself so an exhausted
+		// message is dead-lettered rather than silently dropped.
+		defaultOpts := []nats.SubOpt{
+			nats.BindStream(stream),
+			nats.Durable(consumer),
+			nats.ManualAck(),
+			nats.AckExplicit(),
+			nats.AckWait(sub.opts.AckWait),
+			nats.DeliverNew(),
+		}
+		opts = append(defaultOpts, opts...)
 
-	c.handlerMu.Lock()
-	c.handlers[key] = append(c.handlers[key], handler)
-	c.handlerMu.Unlock()
-
-	c.subMu.Lock()
-	defer c.subMu.Unlock()
-
-	if _, exists := c.subscriptions[key]; exists {
-		return nil
-	}
-
-	// Default options
-	defaultOpts := []nats.SubOpt{
-		nats.Durable(consumer),
-		nats.ManualAck(),
-		nats.AckExplicit(),
-		nats.DeliverNew(),
-	}
-	opts = append(defaultOpts, opts...)
-
-	sub, err := c.js.Subscribe("", func(msg *nats.Msg) {
-		c.handleJetStreamMessage(key, msg)
-	}, opts...)
-	if err != nil {
-		return fmt.Errorf("failed to JetStream subscribe: %w", err)
-	}
-
-	c.subscriptions[key] = sub
-	c.logger.Info("JetStream subscribed",
-		zap.String("stream", stream),
-		zap.String("consumer", consumer),
-	)
+		natsSub, err := c.js.Subscribe("", func(msg *nats.Msg) {
+			c.handleJetStreamMessage(sub, msg, msg)
+		}, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to JetStream subscribe: %w", err)
+		}
 
-	return nil
+		c.logger.Info("JetStream subscribed",
+			zap.String("stream", stream),
+			zap.String("consumer", consumer),
+			zap.Int("max_deliver", sub.opts.MaxDeliver),
+			zap.String("dead_letter_subject", sub.opts.DeadLetterSubject),
+		)
+		return natsSub, nil
+	})
 }
 
 func (c *Client) handleMessage(key string, msg *nats.Msg) {
-	c.handlerMu.RLock()
-	handlers := c.handlers[key]
-	c.handlerMu.RUnlock()
+	handlers := c.handlersFor(key)
 
 	m := &Message{
 		Subject:   msg.Subject,
@@ -412,62 +445,25 @@ func (c *Client) handleMessage(key string, msg *nats.Msg) {
 		}
 	}
 
-	ctx := context.Background()
+	// Handlers run in a span continuing the publisher's trace
+	ctx, span := startHandlerSpan(msg, trace.SpanKindConsumer)
+	var failed error
 	for _, handler := range handlers {
-		if err := handler(ctx, m); err != nil {
+		if err := handler.fn(ctx, m); err != nil {
 			c.logger.Error("Handler error",
 				zap.String("subject", msg.Subject),
 				zap.Error(err),
 			)
+			failed = err
 		}
 	}
+	endHandlerSpan(span, failed)
 }
 
-func (c *Client) handleJetStreamMessage(key string, msg *nats.Msg) {
-	c.handlerMu.RLock()
-	handlers := c.handlers[key]
-	c.handlerMu.RUnlock()
-
-	meta, _ := msg.Metadata()
-	m := &Message{
-		Subject:   msg.Subject,
-		Data:      msg.Data,
-		ReplyTo:   msg.Reply,
-		Headers:   make(map[string]string),
-		Timestamp: time.Now(),
-	}
-
-	if meta != nil {
-		m.Sequence = meta.Sequence.Stream
-		m.Timestamp = meta.Timestamp
-	}
-
-	if msg.Header != nil {
-		for k, v := range msg.Header {
-			if len(v) > 0 {
-				m.Headers[k] = v[0]
-			}
-		}
-	}
-
-	ctx := context.Background()
-	for _, handler := range handlers {
-		if err := handler(ctx, m); err != nil {
-			c.logger.Error("JetStream handler error",
-				zap.String("subject", msg.Subject),
-				zap.Error(err),
-			)
-			// NAK the message on error
-			msg.Nak()
-			return
-		}
-	}
-
-	// ACK successful processing
-	msg.Ack()
-}
-
-// Unsubscribe removes a subscription
+// Unsubscribe removes every handler registered for subject and closes its
+// subscription. Queue subscriptions are keyed "<subject>:<queue>" and
+// JetStream ones "js:<stream>:<consumer>". To remove a single handler use
+// the Subscription it was registered with.
 func (c *Client) Unsubscribe(subject string) error {
 	c.subMu.Lock()
 	defer c.subMu.Unlock()
@@ -573,7 +569,7 @@ func (eb *EventBus) EmitClusterEvent(ctx context.Context, eventType string, clus
 			"cluster_id": clusterID,
 		},
 	}
-	return eb.client.Publish(ctx, event.Subject, event)
+	return eb.client.PublishEvent(ctx, event)
 }
 
 // EmitApplicationEvent emits an application-related event
@@ -590,7 +586,7 @@ func (eb *EventBus) EmitApplicationEvent(ctx context.Context, eventType, appID,
 			"namespace": namespace,
 		},
 	}
-	return eb.client.Publish(ctx, event.Subject, event)
+	return eb.client.PublishEvent(ctx, event)
 }
 
 // EmitPipelineEvent emits a pipeline-related event
@@ -606,7 +602,7 @@ func (eb *EventBus) EmitPipelineEvent(ctx context.Context, eventType, pipelineID
 			"pipeline_id": pipelineID,
 		},
 	}
-	return eb.client.Publish(ctx, event.Subject, event)
+	return eb.client.PublishEvent(ctx, event)
 }
 
 // EmitDeploymentEvent emits a deployment-related event
@@ -623,7 +619,7 @@ func (eb *EventBus) EmitDeploymentEvent(ctx context.Context, eventType, deployme
 			"environment":   environment,
 		},
 	}
-	return eb.client.Publish(ctx, event.Subject, event)
+	return eb.client.PublishEvent(ctx, event)
 }
 
 // EmitSecurityEvent emits a security-related event
@@ -639,7 +635,7 @@ func (eb *EventBus) EmitSecurityEvent(ctx context.Context, eventType, severity s
 			"severity": severity,
 		},
 	}
-	return eb.client.Publish(ctx, event.Subject, event)
+	return eb.client.PublishEvent(ctx, event)
 }
 
 // EmitAlertEvent emits an alert event
@@ -656,16 +652,22 @@ func (eb *EventBus) EmitAlertEvent(ctx context.Context, eventType, severity, sou
 			"alert_source": source,
 		},
 	}
-	return eb.client.Publish(ctx, event.Subject, event)
+	return eb.client.PublishEvent(ctx, event)
 }
 
-// EmitAuditEvent emits an audit event
+// EmitAuditEvent emits an audit event on krustron.audit.<user>.<action>.
+// Actions by no user, such as background jobs, are published as "system".
+// audit.Recorder is its one caller, so every event has an audit log row.
 func (eb *EventBus) EmitAuditEvent(ctx context.Context, action, userID, resource string, data interface{}) error {
+	user := userID
+	if user == "" {
+		user = "system"
+	}
 	event := &Event{
 		ID:        generateEventID(),
 		Type:      action,
 		Source:    "audit",
-		Subject:   fmt.Sprintf("krustron.audit.%s.%s", userID, action),
+		Subject:   fmt.Sprintf("krustron.audit.%s.%s", subjectToken(user), subjectToken(action)),
 		Data:      data,
 		Timestamp: time.Now(),
 		Metadata: map[string]interface{}{
@@ -673,86 +675,105 @@ func (eb *EventBus) EmitAuditEvent(ctx context.Context, action, userID, resource
 			"resource": resource,
 		},
 	}
-	return eb.client.Publish(ctx, event.Subject, event)
+	return eb.client.PublishEvent(ctx, event)
+}
+
+// subjectToken makes s a single subject token: separators and wildcards
+// become underscores
+func subjectToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
 }
 
 // OnClusterEvent registers a handler for cluster events
-func (eb *EventBus) OnClusterEvent(handler func(ctx context.Context, event *Event) error) error {
+func (eb *EventBus) OnClusterEvent(handler func(ctx context.Context, event *Event) error) (*Subscription, error) {
 	return eb.client.Subscribe(SubjectClusterEvents, func(ctx context.Context, msg *Message) error {
-		var event Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
 			return err
 		}
-		return handler(ctx, &event)
+		return handler(ctx, event)
 	})
 }
 
 // OnApplicationEvent registers a handler for application events
-func (eb *EventBus) OnApplicationEvent(handler func(ctx context.Context, event *Event) error) error {
+func (eb *EventBus) OnApplicationEvent(handler func(ctx context.Context, event *Event) error) (*Subscription, error) {
 	return eb.client.Subscribe(SubjectApplicationEvents, func(ctx context.Context, msg *Message) error {
-		var event Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
 			return err
 		}
-		return handler(ctx, &event)
+		return handler(ctx, event)
 	})
 }
 
 // OnPipelineEvent registers a handler for pipeline events
-func (eb *EventBus) OnPipelineEvent(handler func(ctx context.Context, event *Event) error) error {
+func (eb *EventBus) OnPipelineEvent(handler func(ctx context.Context, event *Event) error) (*Subscription, error) {
 	return eb.client.Subscribe(SubjectPipelineEvents, func(ctx context.Context, msg *Message) error {
-		var event Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
 			return err
 		}
-		return handler(ctx, &event)
+		return handler(ctx, event)
 	})
 }
 
 // OnDeploymentEvent registers a handler for deployment events
-func (eb *EventBus) OnDeploymentEvent(handler func(ctx context.Context, event *Event) error) error {
+func (eb *EventBus) OnDeploymentEvent(handler func(ctx context.Context, event *Event) error) (*Subscription, error) {
 	return eb.client.Subscribe(SubjectDeploymentEvents, func(ctx context.Context, msg *Message) error {
-		var event Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
 			return err
 		}
-		return handler(ctx, &event)
+		return handler(ctx, event)
 	})
 }
 
 // OnSecurityEvent registers a handler for security events
-func (eb *EventBus) OnSecurityEvent(handler func(ctx context.Context, event *Event) error) error {
+func (eb *EventBus) OnSecurityEvent(handler func(ctx context.Context, event *Event) error) (*Subscription, error) {
 	return eb.client.Subscribe(SubjectSecurityEvents, func(ctx context.Context, msg *Message) error {
-		var event Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
 			return err
 		}
-		return handler(ctx, &event)
+		return handler(ctx, event)
 	})
 }
 
 // OnAlertEvent registers a handler for alert events
-func (eb *EventBus) OnAlertEvent(handler func(ctx context.Context, event *Event) error) error {
+func (eb *EventBus) OnAlertEvent(handler func(ctx context.Context, event *Event) error) (*Subscription, error) {
 	return eb.client.Subscribe(SubjectAlertEvents, func(ctx context.Context, msg *Message) error {
-		var event Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
 			return err
 		}
-		return handler(ctx, &event)
+		return handler(ctx, event)
 	})
 }
 
 // OnAuditEvent registers a handler for audit events
-func (eb *EventBus) OnAuditEvent(handler func(ctx context.Context, event *Event) error) error {
+func (eb *EventBus) OnAuditEvent(handler func(ctx context.Context, event *Event) error) (*Subscription, error) {
 	return eb.client.Subscribe(SubjectAuditEvents, func(ctx context.Context, msg *Message) error {
-		var event Event
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
 			return err
 		}
-		return handler(ctx, &event)
+		return handler(ctx, event)
 	})
 }
 
+// generateEventID returns a UUIDv7: unique, and ordered by creation time
+// (strictly increasing within this process)
 func generateEventID() string {
-	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), time.Now().Nanosecond())
+	id, err := uuid.NewV7()
+	if err != nil {
+		// Only fails when the system random source does
+		return uuid.NewString()
+	}
+	return id.String()
 }
@@ -0,0 +1,81 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGenerateEventIDUniqueUnderLoad(t *testing.T) {
+	const workers, perWorker = 16, 5000
+
+	ids := make([][]string, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				ids[w] = append(ids[w], generateEventID())
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool, workers*perWorker)
+	for _, batch := range ids {
+		for i, id := range batch {
+			require.False(t, seen[id], "duplicate event ID %s", id)
+			seen[id] = true
+
+			parsed, err := uuid.Parse(id)
+			require.NoError(t, err)
+			require.Equal(t, uuid.Version(7), parsed.Version())
+			// IDs sort in the order they were generated
+			if i > 0 {
+				require.Less(t, batch[i-1], id)
+			}
+		}
+	}
+	assert.Len(t, seen, workers*perWorker)
+}
+
+func TestPublishEventSetsMsgID(t *testing.T) {
+	js := &fakeJetStream{}
+	c := &Client{js: js, logger: zap.NewNop()}
+	bus := NewEventBus(c, zap.NewNop())
+
+	require.NoError(t, bus.EmitClusterEvent(context.Background(), "created", "c-1", nil))
+	require.NoError(t, bus.EmitClusterEvent(context.Background(), "created", "c-1", nil))
+	require.Len(t, js.published, 2)
+
+	first, second := js.published[0], js.published[1]
+	assert.Equal(t, "krustron.cluster.c-1.created", first.Subject)
+	assert.NotEmpty(t, first.Header.Get(nats.MsgIdHdr))
+	assert.NotEqual(t, first.Header.Get(nats.MsgIdHdr), second.Header.Get(nats.MsgIdHdr))
+
+	event, err := DecodeCloudEvent(first.Data)
+	require.NoError(t, err)
+	assert.Equal(t, event.ID, first.Header.Get(nats.MsgIdHdr))
+
+	// Plain messages carry no ID for JetStream to deduplicate on
+	require.NoError(t, c.Publish(context.Background(), "krustron.test", "x"))
+	assert.Empty(t, js.published[2].Header.Get(nats.MsgIdHdr))
+}
+
+func TestEmitAuditEventSubject(t *testing.T) {
+	js := &fakeJetStream{}
+	bus := NewEventBus(&Client{js: js, logger: zap.NewNop()}, zap.NewNop())
+
+	require.NoError(t, bus.EmitAuditEvent(context.Background(), "update", "alice@example.com", "cluster", nil))
+	require.NoError(t, bus.EmitAuditEvent(context.Background(), "authorize.batch", "", "cluster", nil))
+	require.Len(t, js.published, 2)
+	assert.Equal(t, "krustron.audit.alice@example_com.update", js.published[0].Subject)
+	assert.Equal(t, "krustron.audit.system.authorize_batch", js.published[1].Subject)
+}
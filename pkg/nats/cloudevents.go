@@ -0,0 +1,136 @@
+package nats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CloudEvents wire format (https://github.com/cloudevents/spec, v1.0 JSON).
+// PublishEvent maps an Event onto it as follows:
+//
+//	specversion      "1.0"
+//	type             "io.krustron.<Source>.<Type>"
+//	source           "/krustron/<Source>"
+//	id, time         ID, Timestamp
+//	subject          Subject (the NATS subject)
+//	datacontenttype  "application/json"
+//	schemaversion    SchemaVersion, an extension attribute
+//	krustronmetadata Metadata as a JSON string, since extension attributes
+//	                 cannot hold objects
+//	data             Data
+const (
+	CloudEventsSpecVersion = "1.0"
+	// EventSchemaVersion is the payload schema version events are published
+	// with unless they set their own. Bump it when a payload changes
+	// incompatibly so consumers can tell the shapes apart.
+	EventSchemaVersion = "1"
+
+	cloudEventTypePrefix   = "io.krustron."
+	cloudEventSourcePrefix = "/krustron/"
+	cloudEventContentType  = "application/json"
+)
+
+// cloudEvent is the structured-mode JSON form of an Event
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Subject         string          `json:"subject,omitempty"`
+	SchemaVersion   string          `json:"schemaversion,omitempty"`
+	Metadata        string          `json:"krustronmetadata,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// EncodeCloudEvent serializes event as a CloudEvents v1.0 JSON document
+func EncodeCloudEvent(event *Event) ([]byte, error) {
+	if event.ID == "" || event.Type == "" || event.Source == "" {
+		return nil, fmt.Errorf("event requires an id, type and source")
+	}
+
+	ce := cloudEvent{
+		SpecVersion:   CloudEventsSpecVersion,
+		Type:          cloudEventTypePrefix + event.Source + "." + event.Type,
+		Source:        cloudEventSourcePrefix + event.Source,
+		ID:            event.ID,
+		Subject:       event.Subject,
+		SchemaVersion: event.SchemaVersion,
+	}
+	if !event.Timestamp.IsZero() {
+		ts := event.Timestamp.UTC()
+		ce.Time = &ts
+	}
+	if event.Data != nil {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event data: %w", err)
+		}
+		ce.Data = data
+		ce.DataContentType = cloudEventContentType
+	}
+	if len(event.Metadata) > 0 {
+		metadata, err := json.Marshal(event.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event metadata: %w", err)
+		}
+		ce.Metadata = string(metadata)
+	}
+
+	return json.Marshal(ce)
+}
+
+// DecodeCloudEvent parses a CloudEvents v1.0 JSON document into an Event.
+// Documents without a specversion are read as the plain Event JSON that
+// was published before the CloudEvents format, so messages still sitting
+// in streams remain readable.
+func DecodeCloudEvent(data []byte) (*Event, error) {
+	var ce cloudEvent
+	if err := json.Unmarshal(data, &ce); err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+
+	if ce.SpecVersion == "" {
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode event: %w", err)
+		}
+		return &event, nil
+	}
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return nil, fmt.Errorf("unsupported CloudEvents specversion %q", ce.SpecVersion)
+	}
+	if ce.ID == "" || ce.Type == "" || ce.Source == "" {
+		return nil, fmt.Errorf("event requires an id, type and source")
+	}
+
+	event := &Event{
+		ID:            ce.ID,
+		Type:          ce.Type,
+		Source:        strings.TrimPrefix(ce.Source, cloudEventSourcePrefix),
+		Subject:       ce.Subject,
+		SchemaVersion: ce.SchemaVersion,
+	}
+	// Only strip the type prefix for our own events; anything else keeps
+	// its type as published
+	if rest, ok := strings.CutPrefix(ce.Type, cloudEventTypePrefix+event.Source+"."); ok {
+		event.Type = rest
+	}
+	if ce.Time != nil {
+		event.Timestamp = *ce.Time
+	}
+	if len(ce.Data) > 0 {
+		if err := json.Unmarshal(ce.Data, &event.Data); err != nil {
+			return nil, fmt.Errorf("failed to decode event data: %w", err)
+		}
+	}
+	if ce.Metadata != "" {
+		if err := json.Unmarshal([]byte(ce.Metadata), &event.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode event metadata: %w", err)
+		}
+	}
+	return event, nil
+}
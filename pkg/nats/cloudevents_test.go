@@ -0,0 +1,152 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// cloudEventAttribute is the attribute naming rule of the CloudEvents spec
+var cloudEventAttribute = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// assertCloudEvent checks raw against the CloudEvents v1.0 JSON format and
+// returns its attributes
+func assertCloudEvent(t *testing.T, raw []byte) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &doc))
+
+	for _, required := range []string{"specversion", "id", "source", "type"} {
+		value, ok := doc[required].(string)
+		require.True(t, ok, "%s must be a string", required)
+		require.NotEmpty(t, value, "%s must not be empty", required)
+	}
+	assert.Equal(t, "1.0", doc["specversion"])
+	if ts, ok := doc["time"]; ok {
+		_, err := time.Parse(time.RFC3339, ts.(string))
+		assert.NoError(t, err, "time must be RFC 3339")
+	}
+	for name, value := range doc {
+		assert.Regexp(t, cloudEventAttribute, name)
+		if name == "data" {
+			continue
+		}
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			t.Errorf("attribute %s must be a scalar, got %T", name, value)
+		}
+	}
+	return doc
+}
+
+func TestEncodeCloudEvent(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	raw, err := EncodeCloudEvent(&Event{
+		ID:            "evt-1",
+		Type:          "node_not_ready",
+		Source:        "cluster",
+		Subject:       "krustron.cluster.c-1.node_not_ready",
+		Data:          map[string]interface{}{"node": "worker-2"},
+		Metadata:      map[string]interface{}{"cluster_id": "c-1"},
+		Timestamp:     at,
+		SchemaVersion: "2",
+	})
+	require.NoError(t, err)
+
+	doc := assertCloudEvent(t, raw)
+	assert.Equal(t, "io.krustron.cluster.node_not_ready", doc["type"])
+	assert.Equal(t, "/krustron/cluster", doc["source"])
+	assert.Equal(t, "evt-1", doc["id"])
+	assert.Equal(t, "2024-03-01T12:30:00Z", doc["time"])
+	assert.Equal(t, "application/json", doc["datacontenttype"])
+	assert.Equal(t, "krustron.cluster.c-1.node_not_ready", doc["subject"])
+	assert.Equal(t, "2", doc["schemaversion"])
+	assert.Equal(t, map[string]interface{}{"node": "worker-2"}, doc["data"])
+	assert.JSONEq(t, `{"cluster_id":"c-1"}`, doc["krustronmetadata"].(string))
+
+	decoded, err := DecodeCloudEvent(raw)
+	require.NoError(t, err)
+	assert.Equal(t, &Event{
+		ID:            "evt-1",
+		Type:          "node_not_ready",
+		Source:        "cluster",
+		Subject:       "krustron.cluster.c-1.node_not_ready",
+		Data:          map[string]interface{}{"node": "worker-2"},
+		Metadata:      map[string]interface{}{"cluster_id": "c-1"},
+		Timestamp:     at,
+		SchemaVersion: "2",
+	}, decoded)
+
+	_, err = EncodeCloudEvent(&Event{Type: "created", Source: "cluster"})
+	assert.Error(t, err, "id is required")
+}
+
+func TestDecodeCloudEvent(t *testing.T) {
+	// Foreign events keep their type and source
+	event, err := DecodeCloudEvent([]byte(`{"specversion":"1.0","type":"com.github.push","source":"https://github.com/acme/app","id":"A-1","data":{"ref":"main"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "com.github.push", event.Type)
+	assert.Equal(t, "https://github.com/acme/app", event.Source)
+	assert.Equal(t, map[string]interface{}{"ref": "main"}, event.Data)
+
+	// Events published before the CloudEvents format
+	event, err = DecodeCloudEvent([]byte(`{"id":"1","type":"created","source":"cluster","subject":"krustron.cluster.c-1.created","data":{"name":"prod"},"timestamp":"2024-03-01T12:30:00Z"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "created", event.Type)
+	assert.Equal(t, "cluster", event.Source)
+	assert.Empty(t, event.SchemaVersion)
+
+	_, err = DecodeCloudEvent([]byte(`{"specversion":"0.3","type":"x","source":"y","id":"1"}`))
+	assert.Error(t, err)
+	_, err = DecodeCloudEvent([]byte(`{"specversion":"1.0","type":"x","source":"y"}`))
+	assert.Error(t, err, "id is required")
+}
+
+func TestEventBusPublishesCloudEvents(t *testing.T) {
+	url := startTestServer(t)
+	publisher := newTestClient(t, url)
+	consumer := newTestClient(t, url)
+
+	raw := make(chan []byte, 1)
+	events := make(chan *Event, 1)
+	_, err := consumer.Subscribe(SubjectPipelineEvents, func(_ context.Context, msg *Message) error {
+		raw <- msg.Data
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = NewEventBus(consumer, zap.NewNop()).OnPipelineEvent(func(_ context.Context, event *Event) error {
+		events <- event
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, consumer.Flush())
+
+	bus := NewEventBus(publisher, zap.NewNop())
+	require.NoError(t, bus.EmitPipelineEvent(context.Background(), "failed", "p-1", map[string]string{"run": "r-1"}))
+
+	select {
+	case data := <-raw:
+		doc := assertCloudEvent(t, data)
+		assert.Equal(t, "io.krustron.pipeline.failed", doc["type"])
+		assert.Equal(t, EventSchemaVersion, doc["schemaversion"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event published")
+	}
+	select {
+	case event := <-events:
+		assert.Equal(t, "failed", event.Type)
+		assert.Equal(t, "pipeline", event.Source)
+		assert.Equal(t, "krustron.pipeline.p-1.failed", event.Subject)
+		assert.Equal(t, map[string]interface{}{"run": "r-1"}, event.Data)
+		assert.Equal(t, map[string]interface{}{"pipeline_id": "p-1"}, event.Metadata)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event never reached the handler")
+	}
+}
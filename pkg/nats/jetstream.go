@@ -0,0 +1,207 @@
+package nats
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// StreamDLQ collects messages that exhausted their deliveries on any
+// stream. Each lands on "<stream>.DLQ".
+const StreamDLQ = "KRUSTRON_DLQ"
+
+// Headers set on dead-lettered messages
+const (
+	HeaderDLQStream     = "Krustron-Dlq-Stream"
+	HeaderDLQConsumer   = "Krustron-Dlq-Consumer"
+	HeaderDLQSubject    = "Krustron-Dlq-Subject"
+	HeaderDLQSequence   = "Krustron-Dlq-Sequence"
+	HeaderDLQDeliveries = "Krustron-Dlq-Deliveries"
+	HeaderDLQError      = "Krustron-Dlq-Error"
+)
+
+const (
+	defaultAckWait    = 30 * time.Second
+	defaultMaxDeliver = 5
+)
+
+// defaultBackoff spaces out redeliveries of a failing message
+var defaultBackoff = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second}
+
+// JetStreamOptions controls how a JetStream subscription acknowledges and
+// retries messages. Zero values take the defaults.
+type JetStreamOptions struct {
+	// AckWait is how long the server waits for an ack before redelivering
+	AckWait time.Duration
+	// MaxDeliver is how many times a message is handed to the handlers.
+	// After the last failed delivery it's dead-lettered and terminated
+	// rather than NAKed again.
+	MaxDeliver int
+	// Backoff is the delay before each redelivery of a failed message; the
+	// last entry repeats. Set it to an empty non-nil slice to redeliver
+	// immediately.
+	Backoff []time.Duration
+	// DeadLetterSubject receives exhausted messages. Defaults to
+	// "<stream>.DLQ", captured by StreamDLQ.
+	DeadLetterSubject string
+}
+
+func (o *JetStreamOptions) withDefaults(stream string) *JetStreamOptions {
+	out := JetStreamOptions{}
+	if o != nil {
+		out = *o
+	}
+	if out.AckWait <= 0 {
+		out.AckWait = defaultAckWait
+	}
+	if out.MaxDeliver <= 0 {
+		out.MaxDeliver = defaultMaxDeliver
+	}
+	if out.Backoff == nil {
+		out.Backoff = defaultBackoff
+	}
+	if out.DeadLetterSubject == "" {
+		out.DeadLetterSubject = stream + ".DLQ"
+	}
+	return &out
+}
+
+// backoff returns the delay before redelivering a message delivered n
+// times so far
+func (o *JetStreamOptions) backoff(n uint64) time.Duration {
+	if len(o.Backoff) == 0 {
+		return 0
+	}
+	i := int(n) - 1
+	if i >= len(o.Backoff) {
+		i = len(o.Backoff) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return o.Backoff[i]
+}
+
+// jsSubscription is one durable consumer a JetStream subscription reads
+type jsSubscription struct {
+	key      string
+	stream   string
+	consumer string
+	opts     *JetStreamOptions
+}
+
+// jsAcker answers the server about a delivered message; *nats.Msg
+// implements it
+type jsAcker interface {
+	Ack(opts ...nats.AckOpt) error
+	NakWithDelay(delay time.Duration, opts ...nats.AckOpt) error
+	Term(opts ...nats.AckOpt) error
+}
+
+// handleJetStreamMessage runs the subscription's handlers. Success acks
+// the message. A failure is NAKed for redelivery after the configured
+// backoff until the message has been delivered MaxDeliver times; then it's
+// published to the dead-letter subject and terminated so the server stops
+// redelivering it.
+func (c *Client) handleJetStreamMessage(sub *jsSubscription, msg *nats.Msg, ack jsAcker) {
+	handlers := c.handlersFor(sub.key)
+
+	meta, _ := msg.Metadata()
+	m := &Message{
+		Subject:   msg.Subject,
+		Data:      msg.Data,
+		ReplyTo:   msg.Reply,
+		Headers:   make(map[string]string),
+		Timestamp: time.Now(),
+	}
+
+	delivered := uint64(1)
+	if meta != nil {
+		m.Sequence = meta.Sequence.Stream
+		m.Timestamp = meta.Timestamp
+		m.Deliveries = meta.NumDelivered
+		delivered = meta.NumDelivered
+	}
+
+	if msg.Header != nil {
+		for k, v := range msg.Header {
+			if len(v) > 0 {
+				m.Headers[k] = v[0]
+			}
+		}
+	}
+
+	ctx, span := startHandlerSpan(msg, trace.SpanKindConsumer)
+	for _, handler := range handlers {
+		err := handler.fn(ctx, m)
+		if err == nil {
+			continue
+		}
+		endHandlerSpan(span, err)
+		c.logger.Error("JetStream handler error",
+			zap.String("subject", msg.Subject),
+			zap.Uint64("delivery", delivered),
+			zap.Error(err),
+		)
+		if delivered < uint64(sub.opts.MaxDeliver) {
+			ack.NakWithDelay(sub.opts.backoff(delivered))
+			return
+		}
+		c.deadLetter(sub, msg, m, ack, err)
+		return
+	}
+
+	// ACK successful processing
+	endHandlerSpan(span, nil)
+	ack.Ack()
+}
+
+// deadLetter publishes an exhausted message to the subscription's
+// dead-letter subject and terminates it. If the publish fails the message
+// is NAKed instead so it isn't lost; the next delivery tries again.
+func (c *Client) deadLetter(sub *jsSubscription, msg *nats.Msg, m *Message, ack jsAcker, cause error) {
+	dlq := nats.NewMsg(sub.opts.DeadLetterSubject)
+	dlq.Data = msg.Data
+	for k, v := range msg.Header {
+		dlq.Header[k] = v
+	}
+	dlq.Header.Set(HeaderDLQStream, sub.stream)
+	dlq.Header.Set(HeaderDLQConsumer, sub.consumer)
+	dlq.Header.Set(HeaderDLQSubject, msg.Subject)
+	dlq.Header.Set(HeaderDLQSequence, strconv.FormatUint(m.Sequence, 10))
+	dlq.Header.Set(HeaderDLQDeliveries, strconv.FormatUint(m.Deliveries, 10))
+	dlq.Header.Set(HeaderDLQError, cause.Error())
+
+	if _, err := c.js.PublishMsg(dlq); err != nil {
+		c.logger.Error("Failed to dead-letter message, will retry",
+			zap.String("subject", msg.Subject),
+			zap.String("dlq", sub.opts.DeadLetterSubject),
+			zap.Error(err),
+		)
+		ack.NakWithDelay(sub.opts.backoff(m.Deliveries))
+		return
+	}
+
+	c.logger.Warn("Message dead-lettered",
+		zap.String("subject", msg.Subject),
+		zap.String("dlq", sub.opts.DeadLetterSubject),
+		zap.Uint64("sequence", m.Sequence),
+		zap.Uint64("deliveries", m.Deliveries),
+	)
+	ack.Term()
+}
+
+// GetConsumerInfo returns a consumer's state. NumPending is its lag: how
+// many stream messages it hasn't been delivered yet; NumAckPending and
+// NumRedelivered count messages delivered but not yet acknowledged.
+func (c *Client) GetConsumerInfo(stream, consumer string) (*nats.ConsumerInfo, error) {
+	if c.js == nil {
+		return nil, fmt.Errorf("JetStream not enabled")
+	}
+
+	return c.js.ConsumerInfo(stream, consumer)
+}
@@ -0,0 +1,137 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeJetStream records dead-letter publishes; every other JetStream call
+// is left unimplemented
+type fakeJetStream struct {
+	nats.JetStreamContext
+	published []*nats.Msg
+	err       error
+}
+
+func (f *fakeJetStream) PublishMsg(m *nats.Msg, _ ...nats.PubOpt) (*nats.PubAck, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.published = append(f.published, m)
+	return &nats.PubAck{Stream: StreamDLQ}, nil
+}
+
+// recordedAck captures how a delivery was answered
+type recordedAck struct {
+	outcome string
+	delay   time.Duration
+}
+
+func (r *recordedAck) Ack(...nats.AckOpt) error  { r.outcome = "ack"; return nil }
+func (r *recordedAck) Term(...nats.AckOpt) error { r.outcome = "term"; return nil }
+func (r *recordedAck) NakWithDelay(d time.Duration, _ ...nats.AckOpt) error {
+	r.outcome, r.delay = "nak", d
+	return nil
+}
+
+func newJetStreamTestClient(js *fakeJetStream, handler MessageHandler, opts *JetStreamOptions) (*Client, *jsSubscription) {
+	c := &Client{
+		js:            js,
+		logger:        zap.NewNop(),
+		subscriptions: make(map[string]*nats.Subscription),
+		handlers:      make(map[string][]registeredHandler),
+	}
+	sub := &jsSubscription{key: "js:KRUSTRON_PIPELINE:notifier", stream: StreamPipeline, consumer: "notifier", opts: opts.withDefaults(StreamPipeline)}
+	c.handlers[sub.key] = []registeredHandler{{id: 1, fn: handler}}
+	return c, sub
+}
+
+// delivery builds the n-th delivery of stream message 42 the way the
+// server sends it, with the metadata encoded in the ack subject
+func delivery(n int) *nats.Msg {
+	msg := nats.NewMsg("krustron.pipeline.p-1.failed")
+	msg.Data = []byte(`{"run":"r-1"}`)
+	msg.Header.Set("Nats-Msg-Id", "evt-1")
+	msg.Reply = fmt.Sprintf("$JS.ACK.%s.notifier.%d.42.%d.%d.0", StreamPipeline, n, 100+n, time.Now().UnixNano())
+	msg.Sub = &nats.Subscription{}
+	return msg
+}
+
+func TestJetStreamDeadLettersAfterMaxDeliver(t *testing.T) {
+	js := &fakeJetStream{}
+	calls := 0
+	c, sub := newJetStreamTestClient(js, func(ctx context.Context, msg *Message) error {
+		calls++
+		assert.Equal(t, uint64(calls), msg.Deliveries)
+		assert.Equal(t, uint64(42), msg.Sequence)
+		return errors.New("smtp unavailable")
+	}, &JetStreamOptions{MaxDeliver: 3, Backoff: []time.Duration{time.Second, 10 * time.Second}})
+
+	// Redeliver the way the server does for as long as the message is NAKed
+	var answers []recordedAck
+	for n := 1; n <= 10; n++ {
+		ack := &recordedAck{}
+		c.handleJetStreamMessage(sub, delivery(n), ack)
+		answers = append(answers, *ack)
+		if ack.outcome != "nak" {
+			break
+		}
+	}
+
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []recordedAck{
+		{outcome: "nak", delay: time.Second},
+		{outcome: "nak", delay: 10 * time.Second},
+		{outcome: "term"},
+	}, answers)
+
+	require.Len(t, js.published, 1)
+	dead := js.published[0]
+	assert.Equal(t, "KRUSTRON_PIPELINE.DLQ", dead.Subject)
+	assert.Equal(t, `{"run":"r-1"}`, string(dead.Data))
+	assert.Equal(t, "evt-1", dead.Header.Get("Nats-Msg-Id"))
+	assert.Equal(t, StreamPipeline, dead.Header.Get(HeaderDLQStream))
+	assert.Equal(t, "notifier", dead.Header.Get(HeaderDLQConsumer))
+	assert.Equal(t, "krustron.pipeline.p-1.failed", dead.Header.Get(HeaderDLQSubject))
+	assert.Equal(t, "42", dead.Header.Get(HeaderDLQSequence))
+	assert.Equal(t, "3", dead.Header.Get(HeaderDLQDeliveries))
+	assert.Equal(t, "smtp unavailable", dead.Header.Get(HeaderDLQError))
+}
+
+func TestJetStreamKeepsMessageWhenDeadLetterFails(t *testing.T) {
+	js := &fakeJetStream{err: errors.New("no responders")}
+	c, sub := newJetStreamTestClient(js, func(context.Context, *Message) error {
+		return errors.New("boom")
+	}, &JetStreamOptions{MaxDeliver: 1, DeadLetterSubject: "ops.DLQ", Backoff: []time.Duration{}})
+
+	ack := &recordedAck{}
+	c.handleJetStreamMessage(sub, delivery(1), ack)
+	assert.Equal(t, recordedAck{outcome: "nak"}, *ack)
+}
+
+func TestJetStreamAcksSuccess(t *testing.T) {
+	js := &fakeJetStream{}
+	c, sub := newJetStreamTestClient(js, func(context.Context, *Message) error { return nil }, nil)
+
+	ack := &recordedAck{}
+	c.handleJetStreamMessage(sub, delivery(1), ack)
+	assert.Equal(t, "ack", ack.outcome)
+	assert.Empty(t, js.published)
+}
+
+func TestJetStreamOptionsDefaults(t *testing.T) {
+	opts := (*JetStreamOptions)(nil).withDefaults(StreamAudit)
+	assert.Equal(t, defaultAckWait, opts.AckWait)
+	assert.Equal(t, defaultMaxDeliver, opts.MaxDeliver)
+	assert.Equal(t, "KRUSTRON_AUDIT.DLQ", opts.DeadLetterSubject)
+	assert.Equal(t, time.Second, opts.backoff(1))
+	assert.Equal(t, 30*time.Second, opts.backoff(9), "the last backoff repeats")
+}
@@ -0,0 +1,33 @@
+// Package natstest runs an in-process NATS server for tests
+package natstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/require"
+)
+
+// StartServer starts an embedded NATS server with JetStream on a random
+// local port and returns its URL. JetStream stores its streams in a
+// temporary directory, and the server is shut down when the test ends.
+func StartServer(t testing.TB) string {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+	go srv.Start()
+	t.Cleanup(func() {
+		srv.Shutdown()
+		srv.WaitForShutdown()
+	})
+	require.True(t, srv.ReadyForConnections(10*time.Second), "NATS server did not start")
+	return srv.ClientURL()
+}
@@ -0,0 +1,132 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// ErrStopReplay can be returned by a replay handler to end the replay
+// early without an error
+var ErrStopReplay = errors.New("stop replay")
+
+// replayInactiveThreshold is how long the server keeps a replay consumer
+// around after its subscriber has gone, should cleanup not happen
+const replayInactiveThreshold = 30 * time.Second
+
+// ReplayEvents hands the events stored in stream since the given time to
+// handler, oldest first. See replay for the delivery guarantees.
+func (c *Client) ReplayEvents(ctx context.Context, stream string, since time.Time, handler func(*Event) error) error {
+	return c.replay(ctx, stream, &nats.ConsumerConfig{
+		DeliverPolicy: nats.DeliverByStartTimePolicy,
+		OptStartTime:  &since,
+	}, handler)
+}
+
+// ReplayBySequence hands the events stored in stream from sequence
+// startSeq on to handler, oldest first. See replay for the delivery
+// guarantees.
+func (c *Client) ReplayBySequence(ctx context.Context, stream string, startSeq uint64, handler func(*Event) error) error {
+	if startSeq == 0 {
+		startSeq = 1
+	}
+	return c.replay(ctx, stream, &nats.ConsumerConfig{
+		DeliverPolicy: nats.DeliverByStartSequencePolicy,
+		OptStartSeq:   startSeq,
+	}, handler)
+}
+
+// replay reads stream through an ephemeral push consumer built from cfg.
+// Events arrive in stream order, so in order within every subject, and
+// replay returns once it has handled the message that was last in the
+// stream when it started; events published meanwhile are left to regular
+// subscribers. A handler error ends the replay and is returned with the
+// failed sequence, from which the caller can resume, so delivery is
+// at-least-once. ErrStopReplay ends it cleanly.
+func (c *Client) replay(ctx context.Context, stream string, cfg *nats.ConsumerConfig, handler func(*Event) error) error {
+	if c.js == nil {
+		return fmt.Errorf("JetStream not enabled")
+	}
+
+	info, err := c.js.StreamInfo(stream)
+	if err != nil {
+		return fmt.Errorf("failed to get stream info: %w", err)
+	}
+	lastSeq := info.State.LastSeq
+	if lastSeq == 0 || cfg.OptStartSeq > lastSeq {
+		return nil
+	}
+
+	// Subscribe before creating the consumer: it starts pushing at once
+	cfg.DeliverSubject = nats.NewInbox()
+	cfg.AckPolicy = nats.AckNonePolicy
+	cfg.ReplayPolicy = nats.ReplayInstantPolicy
+	cfg.InactiveThreshold = replayInactiveThreshold
+	cfg.Description = "krustron event replay"
+
+	sub, err := c.conn.SubscribeSync(cfg.DeliverSubject)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to replay inbox: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	consumer, err := c.js.AddConsumer(stream, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create replay consumer: %w", err)
+	}
+	defer func() {
+		if err := c.js.DeleteConsumer(stream, consumer.Name); err != nil {
+			c.logger.Warn("Failed to delete replay consumer",
+				zap.String("stream", stream),
+				zap.String("consumer", consumer.Name),
+				zap.Error(err),
+			)
+		}
+	}()
+	if consumer.NumPending == 0 {
+		return nil
+	}
+
+	c.logger.Info("Replaying events",
+		zap.String("stream", stream),
+		zap.Uint64("pending", consumer.NumPending),
+		zap.Uint64("last_sequence", lastSeq),
+	)
+
+	var seen uint64
+	for {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return fmt.Errorf("replay of %s interrupted after sequence %d: %w", stream, seen, err)
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			// Flow control and heartbeats carry no stream message
+			continue
+		}
+		seq := meta.Sequence.Stream
+		if seq <= seen {
+			continue
+		}
+		seen = seq
+
+		event, err := DecodeCloudEvent(msg.Data)
+		if err != nil {
+			return fmt.Errorf("replay of %s failed at sequence %d: %w", stream, seq, err)
+		}
+		if err := handler(event); err != nil {
+			if errors.Is(err, ErrStopReplay) {
+				return nil
+			}
+			return fmt.Errorf("replay of %s failed at sequence %d: %w", stream, seq, err)
+		}
+
+		if seq >= lastSeq || meta.NumPending == 0 {
+			return nil
+		}
+	}
+}
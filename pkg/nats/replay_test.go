@@ -0,0 +1,100 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReplayEvents(t *testing.T) {
+	client, err := NewClient(zap.NewNop(), &Config{URL: startTestServer(t), MaxReconnects: -1, JetStreamEnabled: true})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	// Six cluster events, alternating between two clusters
+	publish := func(i int) {
+		cluster := []string{"c-1", "c-2"}[(i-1)%2]
+		require.NoError(t, client.PublishEvent(context.Background(), &Event{
+			ID:      fmt.Sprintf("evt-%d", i),
+			Type:    "node_not_ready",
+			Source:  "cluster",
+			Subject: fmt.Sprintf("krustron.cluster.%s.node_not_ready", cluster),
+		}))
+	}
+	for i := 1; i <= 6; i++ {
+		publish(i)
+	}
+	// The server stamps messages as it stores them
+	midpoint, err := client.js.GetMsg(StreamCluster, 4)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	replay := func(run func(handler func(*Event) error) error) ([]string, error) {
+		var ids []string
+		err := run(func(event *Event) error {
+			ids = append(ids, event.ID)
+			return nil
+		})
+		return ids, err
+	}
+
+	// From the midpoint on; evt-7 is published after the replay started
+	// and is not part of it
+	ids, err := replay(func(h func(*Event) error) error {
+		return client.ReplayEvents(ctx, StreamCluster, midpoint.Time, func(event *Event) error {
+			if event.ID == "evt-4" {
+				publish(7)
+			}
+			return h(event)
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-4", "evt-5", "evt-6"}, ids)
+
+	ids, err = replay(func(h func(*Event) error) error {
+		return client.ReplayBySequence(ctx, StreamCluster, 5, h)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"evt-5", "evt-6", "evt-7"}, ids)
+
+	// Nothing newer than the stream's tail
+	ids, err = replay(func(h func(*Event) error) error {
+		return client.ReplayBySequence(ctx, StreamCluster, 50, h)
+	})
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	// Handlers can stop early, or fail with the sequence to resume from
+	var seen []string
+	require.NoError(t, client.ReplayBySequence(ctx, StreamCluster, 1, func(event *Event) error {
+		seen = append(seen, event.ID)
+		if len(seen) == 2 {
+			return ErrStopReplay
+		}
+		return nil
+	}))
+	assert.Equal(t, []string{"evt-1", "evt-2"}, seen)
+
+	boom := errors.New("boom")
+	err = client.ReplayBySequence(ctx, StreamCluster, 2, func(event *Event) error {
+		if event.ID == "evt-3" {
+			return boom
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, boom)
+	assert.Contains(t, err.Error(), "sequence 3")
+
+	var consumers []string
+	for name := range client.js.ConsumerNames(StreamCluster) {
+		consumers = append(consumers, name)
+	}
+	assert.Empty(t, consumers, "every replay consumer is cleaned up")
+}
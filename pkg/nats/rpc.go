@@ -0,0 +1,233 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// HeaderDeadline carries the caller's deadline (Unix nanoseconds) so a
+// request handler stops working once nobody is waiting for the reply
+const HeaderDeadline = "Krustron-Deadline"
+
+const (
+	// defaultRequestTimeout bounds a request whose context has no deadline
+	defaultRequestTimeout = 10 * time.Second
+	// rpcQueue is the queue group request handlers join, so each request
+	// is served by one replica
+	rpcQueue = "krustron-rpc"
+)
+
+// RequestHandler serves a request. The returned value is marshalled as the
+// reply's data; a returned error is sent in the reply's error instead.
+type RequestHandler func(ctx context.Context, msg *Message) (interface{}, error)
+
+// Reply is the envelope every HandleRequest reply is sent in. Exactly one
+// of Data and Error is set.
+type Reply struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *ReplyError     `json:"error,omitempty"`
+}
+
+// ReplyError is a handler's error as sent to the caller
+type ReplyError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details string            `json:"details,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// AppError rebuilds the handler's error on the caller's side
+func (e *ReplyError) AppError() *errors.AppError {
+	status := e.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	appErr := errors.New(e.Code, e.Message, status).WithDetails(e.Details)
+	appErr.Meta = e.Meta
+	return appErr
+}
+
+// HandleRequest serves requests sent to subject. Each request runs in its
+// own goroutine with a context bounded by the caller's deadline, and its
+// result is published to the request's reply subject wrapped in a Reply.
+// Handlers on several replicas share the load through a queue group.
+func (c *Client) HandleRequest(subject string, handler RequestHandler) error {
+	key := "rpc:" + subject
+
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if _, exists := c.subscriptions[key]; exists {
+		return fmt.Errorf("a request handler is already registered for %s", subject)
+	}
+
+	sub, err := c.conn.QueueSubscribe(subject, rpcQueue, func(msg *nats.Msg) {
+		go c.serveRequest(msg, handler)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe request handler: %w", err)
+	}
+
+	c.subscriptions[key] = sub
+	c.logger.Info("Serving requests", zap.String("subject", subject))
+
+	return nil
+}
+
+func (c *Client) serveRequest(msg *nats.Msg, handler RequestHandler) {
+	if msg.Reply == "" {
+		c.logger.Warn("Dropping request without a reply subject", zap.String("subject", msg.Subject))
+		return
+	}
+
+	ctx, span := startHandlerSpan(msg, trace.SpanKindServer)
+	if deadline, ok := requestDeadline(msg); ok {
+		if time.Until(deadline) <= 0 {
+			// The caller has already given up
+			endHandlerSpan(span, context.DeadlineExceeded)
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	m := &Message{
+		Subject:   msg.Subject,
+		Data:      msg.Data,
+		ReplyTo:   msg.Reply,
+		Headers:   make(map[string]string),
+		Timestamp: time.Now(),
+	}
+	for k, v := range msg.Header {
+		if len(v) > 0 {
+			m.Headers[k] = v[0]
+		}
+	}
+
+	result, err := callHandler(ctx, handler, m)
+	endHandlerSpan(span, err)
+	var reply Reply
+	if err == nil {
+		reply.Data, err = json.Marshal(result)
+		if err != nil {
+			err = errors.InternalWrap(err, "failed to marshal reply")
+		}
+	}
+	if err != nil {
+		appErr := errors.ToAppError(err)
+		reply = Reply{Error: &ReplyError{
+			Code:    appErr.Code,
+			Message: appErr.Message,
+			Details: appErr.Details,
+			Status:  appErr.HTTPStatus,
+			Meta:    appErr.Meta,
+		}}
+	}
+
+	payload, _ := json.Marshal(reply)
+	if err := c.conn.Publish(msg.Reply, payload); err != nil {
+		c.logger.Error("Failed to send reply",
+			zap.String("subject", msg.Subject),
+			zap.Error(err),
+		)
+	}
+}
+
+// callHandler runs handler, turning a panic into an internal error so one
+// bad request can't take the process down
+func callHandler(ctx context.Context, handler RequestHandler, m *Message) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Internal(fmt.Sprintf("request handler panicked: %v", r))
+		}
+	}()
+	return handler(ctx, m)
+}
+
+// requestDeadline reads the caller's deadline from a request's headers
+func requestDeadline(msg *nats.Msg) (time.Time, bool) {
+	if msg.Header == nil {
+		return time.Time{}, false
+	}
+	v := msg.Header.Get(HeaderDeadline)
+	if v == "" {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// requestMsg sends payload to subject and waits for the reply until ctx
+// ends, passing ctx's deadline on to the handler
+func (c *Client) requestMsg(ctx context.Context, subject string, payload []byte) (*nats.Msg, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRequestTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	req := nats.NewMsg(subject)
+	req.Data = payload
+	req.Header.Set(HeaderDeadline, strconv.FormatInt(deadline.UnixNano(), 10))
+	injectTrace(ctx, req)
+
+	reply, err := c.conn.RequestMsgWithContext(ctx, req)
+	if err != nil {
+		if goerrors.Is(err, context.DeadlineExceeded) || goerrors.Is(err, nats.ErrTimeout) {
+			return nil, errors.ServiceUnavailable(fmt.Sprintf("request to %s timed out", subject))
+		}
+		if goerrors.Is(err, nats.ErrNoResponders) {
+			return nil, errors.ServiceUnavailable(fmt.Sprintf("no handler for %s", subject))
+		}
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	return reply, nil
+}
+
+// RequestTyped sends req to a HandleRequest handler on subject and decodes
+// its reply into Resp. The request is bounded by ctx's deadline, or a
+// default timeout if it has none. A handler error comes back as the
+// *errors.AppError it was sent as.
+func RequestTyped[Req, Resp any](ctx context.Context, c *Client, subject string, req Req) (Resp, error) {
+	var resp Resp
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	msg, err := c.requestMsg(ctx, subject, payload)
+	if err != nil {
+		return resp, err
+	}
+
+	var reply Reply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return resp, fmt.Errorf("failed to decode reply: %w", err)
+	}
+	if reply.Error != nil {
+		return resp, reply.Error.AppError()
+	}
+	if len(reply.Data) > 0 {
+		if err := json.Unmarshal(reply.Data, &resp); err != nil {
+			return resp, fmt.Errorf("failed to decode reply data: %w", err)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,111 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type quoteRequest struct {
+	Cluster string `json:"cluster"`
+	Nodes   int    `json:"nodes"`
+}
+
+type quoteResponse struct {
+	Cluster string  `json:"cluster"`
+	Monthly float64 `json:"monthly"`
+}
+
+func TestRequestReplyRoundTrip(t *testing.T) {
+	url := startTestServer(t)
+	server := newTestClient(t, url)
+	caller := newTestClient(t, url)
+
+	deadlines := make(chan time.Time, 1)
+	require.NoError(t, server.HandleRequest("krustron.rpc.cost.quote", func(ctx context.Context, msg *Message) (interface{}, error) {
+		var req quoteRequest
+		if err := json.Unmarshal(msg.Data, &req); err != nil {
+			return nil, errors.BadRequest("invalid quote request")
+		}
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+		switch {
+		case req.Cluster == "":
+			return nil, errors.NotFound("cluster", req.Cluster).WithDetails("cluster is required")
+		case req.Nodes < 0:
+			panic("negative node count")
+		}
+		return quoteResponse{Cluster: req.Cluster, Monthly: float64(req.Nodes) * 72.5}, nil
+	}))
+	require.Error(t, server.HandleRequest("krustron.rpc.cost.quote", nil), "one handler per subject")
+	require.NoError(t, server.Flush())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	want, _ := ctx.Deadline()
+
+	resp, err := RequestTyped[quoteRequest, quoteResponse](ctx, caller, "krustron.rpc.cost.quote", quoteRequest{Cluster: "prod", Nodes: 4})
+	require.NoError(t, err)
+	assert.Equal(t, quoteResponse{Cluster: "prod", Monthly: 290}, resp)
+	// The handler ran under the caller's deadline
+	assert.WithinDuration(t, want, <-deadlines, time.Millisecond)
+
+	_, err = RequestTyped[quoteRequest, quoteResponse](ctx, caller, "krustron.rpc.cost.quote", quoteRequest{})
+	require.Error(t, err)
+	<-deadlines
+	assert.True(t, errors.Is(err, errors.CodeNotFound))
+	assert.Equal(t, http.StatusNotFound, errors.GetHTTPStatus(err))
+	assert.Equal(t, "cluster is required", errors.ToAppError(err).Details)
+
+	_, err = RequestTyped[quoteRequest, quoteResponse](ctx, caller, "krustron.rpc.cost.quote", quoteRequest{Cluster: "prod", Nodes: -1})
+	<-deadlines
+	assert.True(t, errors.Is(err, errors.CodeInternal))
+	assert.Contains(t, err.Error(), "negative node count")
+
+	// The untyped Request sees the raw envelope
+	msg, err := caller.Request(ctx, "krustron.rpc.cost.quote", quoteRequest{Cluster: "dev", Nodes: 2}, time.Second)
+	require.NoError(t, err)
+	<-deadlines
+	assert.JSONEq(t, `{"data":{"cluster":"dev","monthly":145}}`, string(msg.Data))
+}
+
+func TestRequestTimeoutReachesHandler(t *testing.T) {
+	url := startTestServer(t)
+	server := newTestClient(t, url)
+	caller := newTestClient(t, url)
+
+	stopped := make(chan error, 1)
+	require.NoError(t, server.HandleRequest("krustron.rpc.slow", func(ctx context.Context, msg *Message) (interface{}, error) {
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return nil, ctx.Err()
+	}))
+	require.NoError(t, server.Flush())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := RequestTyped[struct{}, struct{}](ctx, caller, "krustron.rpc.slow", struct{}{})
+	assert.True(t, errors.Is(err, errors.CodeServiceUnavailable))
+
+	select {
+	case err := <-stopped:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler kept running after the caller's deadline")
+	}
+}
+
+func TestRequestWithoutHandler(t *testing.T) {
+	caller := newTestClient(t, startTestServer(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := RequestTyped[struct{}, struct{}](ctx, caller, "krustron.rpc.nobody", struct{}{})
+	assert.True(t, errors.Is(err, errors.CodeServiceUnavailable))
+}
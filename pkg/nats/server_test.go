@@ -0,0 +1,24 @@
+package nats
+
+import (
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/nats/natstest"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// startTestServer starts a NATS server with JetStream and returns its URL
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	return natstest.StartServer(t)
+}
+
+// newTestClient connects a Client to the test server
+func newTestClient(t *testing.T, url string) *Client {
+	t.Helper()
+	c, err := NewClient(zap.NewNop(), &Config{URL: url, MaxReconnects: -1})
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+	return c
+}
@@ -0,0 +1,95 @@
+package nats
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Subscription is one handler registered by Subscribe, SubscribeQueue or
+// SubscribeJetStream.
+//
+// Every call registers a separate logical subscriber: registering two
+// handlers for the same subject delivers each message once to each of
+// them, over a single NATS subscription. Unsubscribe removes just this
+// handler; the NATS subscription is closed when its last handler goes.
+type Subscription struct {
+	client *Client
+	key    string
+	id     uint64
+}
+
+// Unsubscribe removes this handler. Calling it again is a no-op.
+func (s *Subscription) Unsubscribe() error {
+	return s.client.removeHandler(s.key, s.id)
+}
+
+// registeredHandler is a handler with the ID its Subscription removes it by
+type registeredHandler struct {
+	id uint64
+	fn MessageHandler
+}
+
+// addHandler registers handler under key, creating the NATS subscription
+// with subscribe if key has none yet
+func (c *Client) addHandler(key string, handler MessageHandler, subscribe func() (*nats.Subscription, error)) (*Subscription, error) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if _, exists := c.subscriptions[key]; !exists {
+		sub, err := subscribe()
+		if err != nil {
+			return nil, err
+		}
+		c.subscriptions[key] = sub
+	}
+
+	c.handlerMu.Lock()
+	c.nextHandlerID++
+	id := c.nextHandlerID
+	// Copy on write: message callbacks iterate the old slice unlocked
+	handlers := append([]registeredHandler(nil), c.handlers[key]...)
+	c.handlers[key] = append(handlers, registeredHandler{id: id, fn: handler})
+	c.handlerMu.Unlock()
+
+	return &Subscription{client: c, key: key, id: id}, nil
+}
+
+// removeHandler drops one handler, closing key's NATS subscription if no
+// handlers remain
+func (c *Client) removeHandler(key string, id uint64) error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	c.handlerMu.Lock()
+	var remaining []registeredHandler
+	for _, h := range c.handlers[key] {
+		if h.id != id {
+			remaining = append(remaining, h)
+		}
+	}
+	if len(remaining) > 0 {
+		c.handlers[key] = remaining
+	} else {
+		delete(c.handlers, key)
+	}
+	c.handlerMu.Unlock()
+
+	if len(remaining) > 0 {
+		return nil
+	}
+	if sub, exists := c.subscriptions[key]; exists {
+		delete(c.subscriptions, key)
+		if err := sub.Unsubscribe(); err != nil {
+			return fmt.Errorf("failed to unsubscribe: %w", err)
+		}
+	}
+	return nil
+}
+
+// handlersFor returns the handlers registered under key
+func (c *Client) handlersFor(key string) []registeredHandler {
+	c.handlerMu.RLock()
+	defer c.handlerMu.RUnlock()
+	return c.handlers[key]
+}
@@ -0,0 +1,99 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder collects the payloads a handler sees and signals the "done"
+// marker, which the server delivers after everything published before it
+type recorder struct {
+	mu   sync.Mutex
+	got  []string
+	done chan struct{}
+}
+
+func newRecorder() *recorder { return &recorder{done: make(chan struct{}, 1)} }
+
+func (r *recorder) handle(_ context.Context, msg *Message) error {
+	var body string
+	if err := json.Unmarshal(msg.Data, &body); err != nil {
+		return err
+	}
+	if body == "done" {
+		r.done <- struct{}{}
+		return nil
+	}
+	r.mu.Lock()
+	r.got = append(r.got, body)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *recorder) wait(t *testing.T) []string {
+	t.Helper()
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("marker message never arrived")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	got := r.got
+	r.got = nil
+	return got
+}
+
+func TestSubscribeDeliversOncePerSubscriber(t *testing.T) {
+	url := startTestServer(t)
+	client := newTestClient(t, url)
+	publisher := newTestClient(t, url)
+	ctx := context.Background()
+	const subject = "krustron.cluster.c-1.events"
+
+	first, second := newRecorder(), newRecorder()
+	firstSub, err := client.Subscribe(subject, first.handle)
+	require.NoError(t, err)
+	secondSub, err := client.Subscribe(subject, second.handle)
+	require.NoError(t, err)
+	require.NoError(t, client.Flush())
+	// Both handlers share one NATS subscription
+	assert.Len(t, client.subscriptions, 1)
+
+	publish := func(bodies ...string) {
+		for _, body := range append(bodies, "done") {
+			require.NoError(t, publisher.Publish(ctx, subject, body))
+		}
+	}
+
+	publish("a", "b")
+	assert.Equal(t, []string{"a", "b"}, first.wait(t))
+	assert.Equal(t, []string{"a", "b"}, second.wait(t))
+
+	// Dropping one subscriber leaves the other untouched
+	require.NoError(t, firstSub.Unsubscribe())
+	require.NoError(t, firstSub.Unsubscribe(), "unsubscribing twice is a no-op")
+	publish("c")
+	assert.Equal(t, []string{"c"}, second.wait(t))
+	assert.Empty(t, first.got)
+	assert.Len(t, client.subscriptions, 1)
+
+	// The last one closes the NATS subscription
+	require.NoError(t, secondSub.Unsubscribe())
+	assert.Empty(t, client.subscriptions)
+	assert.Empty(t, client.handlers)
+
+	// Subscribing again starts from scratch
+	third := newRecorder()
+	_, err = client.Subscribe(subject, third.handle)
+	require.NoError(t, err)
+	require.NoError(t, client.Flush())
+	publish("d")
+	assert.Equal(t, []string{"d"}, third.wait(t))
+}
@@ -0,0 +1,72 @@
+package nats
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/anubhavg-icpl/krustron/pkg/nats"
+
+// headerCarrier adapts NATS headers to the OTel propagation API. NATS
+// header keys are case-sensitive, so keys are used as the propagator
+// writes them (W3C traceparent and tracestate are lower case).
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	if values := c[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = []string{value}
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// injectTrace writes the trace context of ctx into msg's headers, so the
+// handlers it reaches continue the trace. Tracing is configured globally
+// (see api/grpc); until it is, the propagator writes nothing.
+func injectTrace(ctx context.Context, msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Header))
+}
+
+// startHandlerSpan continues the trace propagated in msg's headers with a
+// span of kind around its handlers. Without tracing the span is a no-op.
+func startHandlerSpan(msg *nats.Msg, kind trace.SpanKind) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if msg.Header != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Header))
+	}
+	return otel.Tracer(tracerName).Start(ctx, msg.Subject+" process",
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", msg.Subject),
+		),
+	)
+}
+
+// endHandlerSpan records a handler failure on span and closes it
+func endHandlerSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,104 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// enableTracing installs a recording tracer provider and the W3C
+// propagator the way the server does when tracing is on
+func enableTracing(t *testing.T) (*tracetest.SpanRecorder, trace.Tracer) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	return recorder, provider.Tracer("test")
+}
+
+func TestTraceContinuesThroughPublish(t *testing.T) {
+	recorder, tracer := enableTracing(t)
+	url := startTestServer(t)
+	client := newTestClient(t, url)
+	publisher := newTestClient(t, url)
+	const subject = "krustron.cluster.c-1.events"
+
+	handled := make(chan trace.SpanContext, 1)
+	_, err := client.Subscribe(subject, func(ctx context.Context, msg *Message) error {
+		assert.NotEmpty(t, msg.Headers["traceparent"])
+		handled <- trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Flush())
+
+	ctx, parent := tracer.Start(context.Background(), "sync cluster")
+	require.NoError(t, publisher.PublishEvent(ctx, &Event{Type: "synced", Source: "cluster", Subject: subject}))
+	parent.End()
+
+	var inHandler trace.SpanContext
+	select {
+	case inHandler = <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not called")
+	}
+	assert.Equal(t, parent.SpanContext().TraceID(), inHandler.TraceID())
+
+	// The handler ran in a consumer span whose parent is the publisher's
+	require.Eventually(t, func() bool { return len(recorder.Ended()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	consumer := recorder.Ended()[1]
+	assert.Equal(t, inHandler.SpanID(), consumer.SpanContext().SpanID())
+	assert.Equal(t, parent.SpanContext().SpanID(), consumer.Parent().SpanID())
+	assert.True(t, consumer.Parent().IsRemote())
+	assert.Equal(t, trace.SpanKindConsumer, consumer.SpanKind())
+	assert.Equal(t, subject+" process", consumer.Name())
+}
+
+func TestTraceContinuesThroughJetStream(t *testing.T) {
+	recorder, tracer := enableTracing(t)
+	var inHandler trace.SpanContext
+	c, sub := newJetStreamTestClient(&fakeJetStream{}, func(ctx context.Context, msg *Message) error {
+		inHandler = trace.SpanContextFromContext(ctx)
+		return errors.New("smtp unavailable")
+	}, nil)
+
+	ctx, parent := tracer.Start(context.Background(), "run pipeline")
+	msg := delivery(1)
+	injectTrace(ctx, msg)
+	parent.End()
+
+	c.handleJetStreamMessage(sub, msg, &recordedAck{})
+
+	assert.Equal(t, parent.SpanContext().TraceID(), inHandler.TraceID())
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, otelcodes.Error, spans[1].Status().Code, "handler failures are recorded")
+}
+
+func TestTracingDisabledAddsNoHeaders(t *testing.T) {
+	msg := delivery(1)
+	injectTrace(context.Background(), msg)
+	assert.Empty(t, msg.Header.Get("traceparent"))
+
+	ctx, span := startHandlerSpan(msg, trace.SpanKindConsumer)
+	assert.False(t, span.SpanContext().IsValid())
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+	span.End()
+}
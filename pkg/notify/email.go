@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPConfig is the mail server email channels send through
+type SMTPConfig struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+// sendMailFunc matches smtp.SendMail
+type sendMailFunc func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+// Email sends plain-text mail over SMTP
+type Email struct {
+	smtp     SMTPConfig
+	to       []string
+	sendMail sendMailFunc
+}
+
+// NewEmail mails to through the server in cfg
+func NewEmail(cfg SMTPConfig, to []string) *Email {
+	return &Email{smtp: cfg, to: to, sendMail: smtp.SendMail}
+}
+
+// Type implements Notifier
+func (e *Email) Type() string { return TypeEmail }
+
+// Send implements Notifier
+func (e *Email) Send(ctx context.Context, n *Notification) error {
+	if e.smtp.Addr == "" || e.smtp.From == "" {
+		return errors.New("email notifications not configured")
+	}
+	if len(e.to) == 0 {
+		return errors.New("email channel has no recipients")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if e.smtp.Username != "" {
+		host, _, err := net.SplitHostPort(e.smtp.Addr)
+		if err != nil {
+			host = e.smtp.Addr
+		}
+		auth = smtp.PlainAuth("", e.smtp.Username, e.smtp.Password, host)
+	}
+	return e.sendMail(e.smtp.Addr, auth, e.smtp.From, e.to, emailMessage(e.smtp.From, e.to, n))
+}
+
+// emailMessage formats n as a plain-text message. Header values have line
+// breaks removed so they cannot add headers.
+func emailMessage(from string, to []string, n *Notification) []byte {
+	title := n.Title
+	if title == "" {
+		title = n.Event
+	}
+	subject := fmt.Sprintf("[%s] %s", strings.ToUpper(n.Severity), title)
+
+	var b strings.Builder
+	b.WriteString("From: " + headerValue(from) + "\r\n")
+	b.WriteString("To: " + headerValue(strings.Join(to, ", ")) + "\r\n")
+	b.WriteString("Subject: " + headerValue(subject) + "\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(n.Message + "\r\n")
+	if len(n.Fields) > 0 {
+		b.WriteString("\r\n")
+		for _, f := range n.Fields {
+			b.WriteString(f.Name + ": " + orDash(f.Value) + "\r\n")
+		}
+	}
+	if n.URL != "" {
+		b.WriteString("\r\n" + n.URL + "\r\n")
+	}
+	return []byte(b.String())
+}
+
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
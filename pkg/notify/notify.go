@@ -0,0 +1,154 @@
+// Package notify delivers notifications to email, Slack, Microsoft Teams,
+// PagerDuty and webhook channels, routing each event to the channels
+// configured for its type and severity
+// Author: Anubhav Gain <anubhavg@infopercept.com>
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Channel types
+const (
+	TypeEmail     = "email"
+	TypeSlack     = "slack"
+	TypeTeams     = "teams"
+	TypePagerDuty = "pagerduty"
+	TypeWebhook   = "webhook"
+)
+
+// Severities, lowest first
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityError    = "error"
+	SeverityCritical = "critical"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook body
+const SignatureHeader = "X-Krustron-Signature"
+
+var (
+	// ErrRateLimited is returned when a channel has sent its quota
+	ErrRateLimited = errors.New("notification channel rate limited")
+	// ErrNotRouted is returned when no enabled channel takes a notification
+	ErrNotRouted = errors.New("no notification channel matched")
+)
+
+// Notification is one message to deliver
+type Notification struct {
+	// Event is the dotted event type routes match, e.g. "cost.budget_alert"
+	Event    string `json:"event"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	// Fields are shown alongside the message, in order
+	Fields []Field `json:"fields,omitempty"`
+	// URL links to more detail
+	URL    string `json:"url,omitempty"`
+	Source string `json:"source,omitempty"`
+	// DedupKey groups repeated notifications into one PagerDuty incident
+	DedupKey  string    `json:"dedup_key,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Payload, if set, is the body webhook channels post instead of the
+	// notification, so existing consumers keep their format
+	Payload interface{} `json:"-"`
+}
+
+// Field is a named value shown with a notification
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Field returns the value of the named field, or ""
+func (n *Notification) Field(name string) string {
+	for _, f := range n.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Notifier delivers notifications to one channel
+type Notifier interface {
+	Type() string
+	Send(ctx context.Context, n *Notification) error
+}
+
+// Dispatcher routes notifications to the channels configured for them
+type Dispatcher interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// severityRank orders severities; unknown ones rank as warnings
+func severityRank(severity string) int {
+	switch severity {
+	case SeverityInfo:
+		return 0
+	case SeverityError:
+		return 2
+	case SeverityCritical:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// SignPayload returns the "sha256=<hex>" HMAC of body under secret
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postJSON sends payload as JSON and treats any non-2xx response as an
+// error. headers, if set, computes extra headers from the encoded body.
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}, headers func(body []byte) map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if headers != nil {
+		for k, v := range headers(body) {
+			req.Header.Set(k, v)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notification request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notification endpoint returned %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// orDash shows empty values as "-"
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
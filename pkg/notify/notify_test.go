@@ -0,0 +1,217 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTransport answers every request with status and keeps them
+type recordingTransport struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	t.mu.Lock()
+	t.requests = append(t.requests, req)
+	t.bodies = append(t.bodies, body)
+	t.mu.Unlock()
+	status := t.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("ok")), Header: http.Header{}}, nil
+}
+
+func (t *recordingTransport) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.requests)
+}
+
+// last returns the last request and its decoded JSON body
+func (t *recordingTransport) last(tb testing.TB) (*http.Request, map[string]interface{}) {
+	tb.Helper()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	require.NotEmpty(tb, t.requests)
+	var body map[string]interface{}
+	require.NoError(tb, json.Unmarshal(t.bodies[len(t.bodies)-1], &body))
+	return t.requests[len(t.requests)-1], body
+}
+
+func testNotification() *Notification {
+	return &Notification{
+		Event:     "cost.budget_alert",
+		Severity:  SeverityCritical,
+		Title:     "Budget alert: payments",
+		Message:   "Spend reached 95% of the budget",
+		Fields:    []Field{{Name: "Budget", Value: "payments"}, {Name: "Cluster", Value: ""}},
+		URL:       "https://krustron.example.com/cost",
+		Source:    "cost",
+		DedupKey:  "budget:payments",
+		Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestSlack(t *testing.T) {
+	transport := &recordingTransport{}
+	slack := NewSlack(&http.Client{Transport: transport}, "https://hooks.slack.com/services/T/B/X", "#ops")
+	require.NoError(t, slack.Send(context.Background(), testNotification()))
+
+	req, body := transport.last(t)
+	assert.Equal(t, "https://hooks.slack.com/services/T/B/X", req.URL.String())
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "#ops", body["channel"])
+	assert.Equal(t, ":rotating_light: *Budget alert: payments*\nSpend reached 95% of the budget", body["text"])
+
+	blocks := body["blocks"].([]interface{})
+	require.Len(t, blocks, 3)
+	fields := blocks[1].(map[string]interface{})["fields"].([]interface{})
+	assert.Equal(t, "*Budget*\npayments", fields[0].(map[string]interface{})["text"])
+	assert.Equal(t, "*Cluster*\n-", fields[1].(map[string]interface{})["text"])
+	assert.Contains(t, blocks[2].(map[string]interface{})["elements"].([]interface{})[0].(map[string]interface{})["text"],
+		"<https://krustron.example.com/cost|Details>")
+
+	transport.status = http.StatusForbidden
+	assert.ErrorContains(t, slack.Send(context.Background(), testNotification()), "returned 403")
+}
+
+func TestTeams(t *testing.T) {
+	transport := &recordingTransport{}
+	teams := NewTeams(&http.Client{Transport: transport}, "https://example.webhook.office.com/hook")
+	require.NoError(t, teams.Send(context.Background(), testNotification()))
+
+	_, body := transport.last(t)
+	assert.Equal(t, "message", body["type"])
+	attachment := body["attachments"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "application/vnd.microsoft.card.adaptive", attachment["contentType"])
+	card := attachment["content"].(map[string]interface{})
+	assert.Equal(t, "AdaptiveCard", card["type"])
+
+	content := card["body"].([]interface{})
+	require.Len(t, content, 3)
+	assert.Equal(t, "Budget alert: payments", content[0].(map[string]interface{})["text"])
+	assert.Equal(t, "attention", content[0].(map[string]interface{})["color"])
+	assert.Equal(t, "Spend reached 95% of the budget", content[1].(map[string]interface{})["text"])
+	facts := content[2].(map[string]interface{})["facts"].([]interface{})
+	assert.Equal(t, map[string]interface{}{"title": "Budget", "value": "payments"}, facts[0])
+	action := card["actions"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "https://krustron.example.com/cost", action["url"])
+}
+
+func TestPagerDuty(t *testing.T) {
+	transport := &recordingTransport{status: http.StatusAccepted}
+	pd := NewPagerDuty(&http.Client{Transport: transport}, "routing-key", "")
+	require.NoError(t, pd.Send(context.Background(), testNotification()))
+
+	req, body := transport.last(t)
+	assert.Equal(t, DefaultPagerDutyEventsURL, req.URL.String())
+	assert.Equal(t, "routing-key", body["routing_key"])
+	assert.Equal(t, "trigger", body["event_action"])
+	assert.Equal(t, "budget:payments", body["dedup_key"])
+	payload := body["payload"].(map[string]interface{})
+	assert.Equal(t, "Spend reached 95% of the budget", payload["summary"])
+	assert.Equal(t, "cost", payload["source"])
+	assert.Equal(t, "critical", payload["severity"])
+	assert.Equal(t, "payments", payload["custom_details"].(map[string]interface{})["Budget"])
+
+	n := testNotification()
+	n.Severity = "high"
+	n.DedupKey = ""
+	require.NoError(t, pd.Send(context.Background(), n))
+	_, body = transport.last(t)
+	assert.Equal(t, "error", body["payload"].(map[string]interface{})["severity"])
+	assert.NotContains(t, body, "dedup_key")
+}
+
+func TestWebhook(t *testing.T) {
+	transport := &recordingTransport{}
+	webhook := NewWebhook(&http.Client{Transport: transport}, "https://hooks.example.com/krustron", "s3cret")
+	require.NoError(t, webhook.Send(context.Background(), testNotification()))
+
+	req, body := transport.last(t)
+	assert.Equal(t, "cost.budget_alert", body["event"])
+	assert.Equal(t, "critical", body["severity"])
+	assert.Equal(t, "Spend reached 95% of the budget", body["message"])
+	transport.mu.Lock()
+	raw := transport.bodies[0]
+	transport.mu.Unlock()
+	assert.Equal(t, SignPayload("s3cret", raw), req.Header.Get(SignatureHeader))
+
+	// A payload replaces the notification, and without a secret nothing
+	// is signed
+	n := testNotification()
+	n.Payload = map[string]string{"type": "threshold"}
+	require.NoError(t, NewWebhook(&http.Client{Transport: transport}, "https://hooks.example.com/krustron", "").Send(context.Background(), n))
+	req, body = transport.last(t)
+	assert.Equal(t, map[string]interface{}{"type": "threshold"}, body)
+	assert.Empty(t, req.Header.Get(SignatureHeader))
+}
+
+func TestEmail(t *testing.T) {
+	var sent struct {
+		addr string
+		auth smtp.Auth
+		from string
+		to   []string
+		msg  string
+	}
+	email := NewEmail(SMTPConfig{Addr: "smtp.example.com:587", From: "krustron@example.com", Username: "krustron", Password: "pw"},
+		[]string{"ops@example.com", "finance@example.com"})
+	email.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent.addr, sent.auth, sent.from, sent.to, sent.msg = addr, a, from, to, string(msg)
+		return nil
+	}
+
+	n := testNotification()
+	n.Title = "Budget alert\r\nBcc: attacker@example.com"
+	require.NoError(t, email.Send(context.Background(), n))
+	assert.Equal(t, "smtp.example.com:587", sent.addr)
+	assert.NotNil(t, sent.auth)
+	assert.Equal(t, "krustron@example.com", sent.from)
+	assert.Equal(t, []string{"ops@example.com", "finance@example.com"}, sent.to)
+	assert.Contains(t, sent.msg, "To: ops@example.com, finance@example.com\r\n")
+	assert.Contains(t, sent.msg, "Subject: [CRITICAL] Budget alert  Bcc: attacker@example.com\r\n")
+	assert.NotContains(t, sent.msg, "\r\nBcc:")
+	assert.Contains(t, sent.msg, "\r\n\r\nSpend reached 95% of the budget\r\n")
+	assert.Contains(t, sent.msg, "Budget: payments\r\nCluster: -\r\n")
+
+	assert.ErrorContains(t, NewEmail(SMTPConfig{}, []string{"ops@example.com"}).Send(context.Background(), n), "not configured")
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate(`{{ .Severity | upper }}: {{ .Title }}`, `{{ .Message }} ({{ .Field "Budget" }})`)
+	require.NoError(t, err)
+
+	n := testNotification()
+	out, err := tmpl.Apply(n)
+	require.NoError(t, err)
+	assert.Equal(t, "CRITICAL: Budget alert: payments", out.Title)
+	assert.Equal(t, "Spend reached 95% of the budget (payments)", out.Message)
+	assert.Equal(t, "Budget alert: payments", n.Title, "the original is unchanged")
+
+	// Empty templates leave their part alone
+	tmpl, err = ParseTemplate("", "[{{ .Event }}] {{ .Message }}")
+	require.NoError(t, err)
+	out, err = tmpl.Apply(n)
+	require.NoError(t, err)
+	assert.Equal(t, "Budget alert: payments", out.Title)
+	assert.Equal(t, "[cost.budget_alert] Spend reached 95% of the budget", out.Message)
+
+	_, err = ParseTemplate("{{ .Title", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultPagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const DefaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// maxPagerDutySummary is the longest summary PagerDuty accepts
+const maxPagerDutySummary = 1024
+
+// PagerDuty triggers PagerDuty incidents through the Events API v2
+type PagerDuty struct {
+	client     *http.Client
+	routingKey string
+	eventsURL  string
+}
+
+// NewPagerDuty triggers incidents on the service of routingKey. eventsURL
+// defaults to DefaultPagerDutyEventsURL.
+func NewPagerDuty(client *http.Client, routingKey, eventsURL string) *PagerDuty {
+	if eventsURL == "" {
+		eventsURL = DefaultPagerDutyEventsURL
+	}
+	return &PagerDuty{client: client, routingKey: routingKey, eventsURL: eventsURL}
+}
+
+// Type implements Notifier
+func (p *PagerDuty) Type() string { return TypePagerDuty }
+
+// Send implements Notifier. Notifications with the same DedupKey update
+// one incident.
+func (p *PagerDuty) Send(ctx context.Context, n *Notification) error {
+	return postJSON(ctx, p.client, p.eventsURL, pagerDutyEvent(p.routingKey, n), nil)
+}
+
+// pagerDutyEvent builds an Events API v2 trigger
+func pagerDutyEvent(routingKey string, n *Notification) map[string]interface{} {
+	summary := n.Message
+	if summary == "" {
+		summary = n.Title
+	}
+	if len(summary) > maxPagerDutySummary {
+		summary = summary[:maxPagerDutySummary]
+	}
+	source := n.Source
+	if source == "" {
+		source = "krustron"
+	}
+	details := map[string]interface{}{"event": n.Event}
+	for _, f := range n.Fields {
+		details[f.Name] = f.Value
+	}
+
+	payload := map[string]interface{}{
+		"summary":        summary,
+		"source":         source,
+		"severity":       pagerDutySeverity(n.Severity),
+		"class":          n.Event,
+		"custom_details": details,
+	}
+	if !n.Timestamp.IsZero() {
+		payload["timestamp"] = n.Timestamp
+	}
+	event := map[string]interface{}{
+		"routing_key":  routingKey,
+		"event_action": "trigger",
+		"payload":      payload,
+	}
+	if n.DedupKey != "" {
+		event["dedup_key"] = n.DedupKey
+	}
+	if n.URL != "" {
+		event["links"] = []map[string]string{{"href": n.URL, "text": "Details"}}
+	}
+	return event
+}
+
+// pagerDutySeverity maps severities onto the PagerDuty severity levels
+func pagerDutySeverity(severity string) string {
+	switch severity {
+	case "critical":
+		return "critical"
+	case "high", "error":
+		return "error"
+	case "low", "info":
+		return "info"
+	default:
+		return "warning"
+	}
+}
@@ -0,0 +1,586 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/errors"
+	"github.com/anubhavg-icpl/krustron/pkg/httpsafe"
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+// defaultTimeout bounds each outgoing notification request
+const defaultTimeout = 10 * time.Second
+
+// settingsColumn holds channel settings, which include credentials
+var settingsColumn = secrets.Column{Table: "notification_channels", Key: "id", Name: "settings"}
+
+// EncryptedColumns are the notification columns a FieldCipher encrypts
+var EncryptedColumns = []secrets.Column{settingsColumn}
+
+// maskedSetting replaces secret settings in responses. Sent back in an
+// update, it keeps the stored value.
+const maskedSetting = "********"
+
+// secretSettings are the settings that hold credentials
+var secretSettings = map[string]bool{
+	"webhook_url": true,
+	"url":         true,
+	"secret":      true,
+	"routing_key": true,
+}
+
+// requiredSettings are the settings each channel type needs
+var requiredSettings = map[string][]string{
+	TypeEmail:     {"to"},
+	TypeSlack:     {"webhook_url"},
+	TypeTeams:     {"webhook_url"},
+	TypePagerDuty: {"routing_key"},
+	TypeWebhook:   {"url"},
+}
+
+// Config holds notification service configuration
+type Config struct {
+	// SMTP is the mail server of email channels
+	SMTP SMTPConfig
+	// Egress limits where webhook-based channels may send
+	Egress httpsafe.Policy
+}
+
+// Channel is a configured destination. Settings depend on the type:
+//
+//	email:     to (comma-separated addresses)
+//	slack:     webhook_url, channel
+//	teams:     webhook_url
+//	pagerduty: routing_key, events_url
+//	webhook:   url, secret
+type Channel struct {
+	ID       string            `json:"id" gorm:"primaryKey"`
+	Name     string            `json:"name" gorm:"uniqueIndex"`
+	Type     string            `json:"type"`
+	Enabled  bool              `json:"enabled"`
+	Settings map[string]string `json:"settings" gorm:"-"`
+	// SealedSettings is Settings as stored, encrypted when a FieldCipher
+	// is set
+	SealedSettings string `json:"-" gorm:"column:settings"`
+	// RateLimit is the most notifications sent per minute; 0 is unlimited
+	RateLimit       int       `json:"rate_limit"`
+	TitleTemplate   string    `json:"title_template,omitempty"`
+	MessageTemplate string    `json:"message_template,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// TableName keeps channels apart from other services' tables
+func (Channel) TableName() string { return "notification_channels" }
+
+// Route sends notifications whose event matches one of Events, and whose
+// severity is at least MinSeverity, to Channels
+type Route struct {
+	ID   string `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"uniqueIndex"`
+	// Events are event type patterns, e.g. "cost.*" or "*"
+	Events      []string `json:"events" gorm:"serializer:json"`
+	MinSeverity string   `json:"min_severity,omitempty"`
+	// Channels are channel IDs
+	Channels  []string  `json:"channels" gorm:"serializer:json"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName keeps routes apart from other services' tables
+func (Route) TableName() string { return "notification_routes" }
+
+// matches reports whether the route takes n
+func (r *Route) matches(n *Notification) bool {
+	if r.MinSeverity != "" && severityRank(n.Severity) < severityRank(r.MinSeverity) {
+		return false
+	}
+	for _, pattern := range r.Events {
+		if ok, _ := path.Match(pattern, n.Event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Service stores channels and routes and delivers notifications through
+// them. Rate limits are kept per replica.
+type Service struct {
+	db       *gorm.DB
+	logger   *zap.Logger
+	config   *Config
+	client   *http.Client
+	fields   *secrets.FieldCipher
+	sendMail sendMailFunc
+
+	// channels are the built channels, rebuilt when a channel changes
+	channels   map[string]*channelState
+	channelsMu sync.Mutex
+}
+
+// channelState is a channel ready to send
+type channelState struct {
+	updatedAt time.Time
+	notifier  Notifier
+	template  *Template
+	limiter   *rate.Limiter
+}
+
+// NewService creates a new notification service
+func NewService(db *gorm.DB, logger *zap.Logger, config *Config) (*Service, error) {
+	if err := db.AutoMigrate(&Channel{}, &Route{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate notification tables: %w", err)
+	}
+
+	egress := config.Egress
+	if egress.Timeout == 0 {
+		egress.Timeout = defaultTimeout
+	}
+	client, err := httpsafe.NewClient(egress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress policy: %w", err)
+	}
+
+	return &Service{
+		db:       db,
+		logger:   logger,
+		config:   config,
+		client:   client,
+		channels: make(map[string]*channelState),
+	}, nil
+}
+
+// SetFieldCipher encrypts channel settings with fields. Optional; without
+// it they are stored in plaintext.
+func (s *Service) SetFieldCipher(fields *secrets.FieldCipher) { s.fields = fields }
+
+// Notify sends n to every enabled channel of the routes it matches, once
+// each. It returns ErrNotRouted if there are none, and the failures of
+// channels that could not send.
+func (s *Service) Notify(ctx context.Context, n *Notification) error {
+	if n.Timestamp.IsZero() {
+		n.Timestamp = time.Now()
+	}
+
+	var routes []Route
+	if err := s.db.WithContext(ctx).Where("enabled = ?", true).Order("name").Find(&routes).Error; err != nil {
+		return fmt.Errorf("failed to list notification routes: %w", err)
+	}
+	var ids []string
+	seen := make(map[string]bool)
+	for i := range routes {
+		if !routes[i].matches(n) {
+			continue
+		}
+		for _, id := range routes[i].Channels {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return ErrNotRouted
+	}
+
+	var channels []Channel
+	if err := s.db.WithContext(ctx).Where("id IN ? AND enabled = ?", ids, true).Order("name").Find(&channels).Error; err != nil {
+		return fmt.Errorf("failed to load notification channels: %w", err)
+	}
+	if len(channels) == 0 {
+		return ErrNotRouted
+	}
+
+	var errs []error
+	for i := range channels {
+		err := s.open(&channels[i])
+		if err == nil {
+			err = s.deliver(ctx, &channels[i], n)
+		}
+		if err != nil {
+			s.logger.Warn("Failed to send notification",
+				zap.String("channel", channels[i].Name),
+				zap.String("event", n.Event),
+				zap.Error(err),
+			)
+			errs = append(errs, fmt.Errorf("%s: %w", channels[i].Name, err))
+		}
+	}
+	return goerrors.Join(errs...)
+}
+
+// TestChannel sends a test notification to a channel, enabled or not, so
+// its settings can be checked
+func (s *Service) TestChannel(ctx context.Context, id string) error {
+	channel, err := s.getChannel(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.deliver(ctx, channel, &Notification{
+		Event:     "notification.test",
+		Severity:  SeverityInfo,
+		Title:     "Test notification",
+		Message:   fmt.Sprintf("Krustron can send to the %s channel %s.", channel.Type, channel.Name),
+		Timestamp: time.Now(),
+	})
+}
+
+// deliver sends n to one channel within its rate limit
+func (s *Service) deliver(ctx context.Context, channel *Channel, n *Notification) error {
+	state, err := s.channelState(channel)
+	if err != nil {
+		return err
+	}
+	if state.limiter != nil && !state.limiter.Allow() {
+		return ErrRateLimited
+	}
+	rendered, err := state.template.Apply(n)
+	if err != nil {
+		return err
+	}
+	return state.notifier.Send(ctx, rendered)
+}
+
+// channelState returns the built channel, building it on first use and
+// whenever the channel has changed since
+func (s *Service) channelState(channel *Channel) (*channelState, error) {
+	s.channelsMu.Lock()
+	defer s.channelsMu.Unlock()
+
+	if state, ok := s.channels[channel.ID]; ok && state.updatedAt.Equal(channel.UpdatedAt) {
+		return state, nil
+	}
+	notifier, err := s.newNotifier(channel)
+	if err != nil {
+		return nil, err
+	}
+	template, err := ParseTemplate(channel.TitleTemplate, channel.MessageTemplate)
+	if err != nil {
+		return nil, err
+	}
+	state := &channelState{updatedAt: channel.UpdatedAt, notifier: notifier, template: template}
+	if channel.RateLimit > 0 {
+		state.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(channel.RateLimit)), channel.RateLimit)
+	}
+	s.channels[channel.ID] = state
+	return state, nil
+}
+
+// newNotifier builds the notifier of a channel's type
+func (s *Service) newNotifier(channel *Channel) (Notifier, error) {
+	settings := channel.Settings
+	switch channel.Type {
+	case TypeEmail:
+		email := NewEmail(s.config.SMTP, splitAddresses(settings["to"]))
+		if s.sendMail != nil {
+			email.sendMail = s.sendMail
+		}
+		return email, nil
+	case TypeSlack:
+		return NewSlack(s.client, settings["webhook_url"], settings["channel"]), nil
+	case TypeTeams:
+		return NewTeams(s.client, settings["webhook_url"]), nil
+	case TypePagerDuty:
+		return NewPagerDuty(s.client, settings["routing_key"], settings["events_url"]), nil
+	case TypeWebhook:
+		return NewWebhook(s.client, settings["url"], settings["secret"]), nil
+	default:
+		return nil, fmt.Errorf("unsupported notification channel type: %s", channel.Type)
+	}
+}
+
+// splitAddresses splits a comma-separated address list
+func splitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// ListChannels returns all channels with their secret settings masked
+func (s *Service) ListChannels(ctx context.Context) ([]Channel, error) {
+	var channels []Channel
+	if err := s.db.WithContext(ctx).Order("name").Find(&channels).Error; err != nil {
+		return nil, fmt.Errorf("failed to list notification channels: %w", err)
+	}
+	for i := range channels {
+		if err := s.open(&channels[i]); err != nil {
+			return nil, err
+		}
+		channels[i].mask()
+	}
+	return channels, nil
+}
+
+// CreateChannel adds a channel
+func (s *Service) CreateChannel(ctx context.Context, channel *Channel) error {
+	if err := validateChannel(channel); err != nil {
+		return err
+	}
+	channel.ID = uuid.New().String()
+	if err := s.checkNameFree(ctx, &Channel{}, "notification channel", channel.Name, channel.ID); err != nil {
+		return err
+	}
+	if err := s.seal(channel); err != nil {
+		return err
+	}
+	if err := s.db.WithContext(ctx).Create(channel).Error; err != nil {
+		return fmt.Errorf("failed to create notification channel: %w", err)
+	}
+	channel.mask()
+	return nil
+}
+
+// UpdateChannel replaces a channel's configuration. Secret settings sent
+// back masked keep their stored values.
+func (s *Service) UpdateChannel(ctx context.Context, id string, channel *Channel) error {
+	existing, err := s.getChannel(ctx, id)
+	if err != nil {
+		return err
+	}
+	for key, value := range channel.Settings {
+		if value == maskedSetting {
+			channel.Settings[key] = existing.Settings[key]
+		}
+	}
+	if err := validateChannel(channel); err != nil {
+		return err
+	}
+	channel.ID = existing.ID
+	channel.CreatedAt = existing.CreatedAt
+	if err := s.checkNameFree(ctx, &Channel{}, "notification channel", channel.Name, channel.ID); err != nil {
+		return err
+	}
+	if err := s.seal(channel); err != nil {
+		return err
+	}
+	if err := s.db.WithContext(ctx).Save(channel).Error; err != nil {
+		return fmt.Errorf("failed to update notification channel: %w", err)
+	}
+	channel.mask()
+	return nil
+}
+
+// DeleteChannel removes a channel no route sends to
+func (s *Service) DeleteChannel(ctx context.Context, id string) error {
+	if _, err := s.getChannel(ctx, id); err != nil {
+		return err
+	}
+	routes, err := s.ListRoutes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		for _, channelID := range route.Channels {
+			if channelID == id {
+				return errors.Conflict(fmt.Sprintf("notification channel is used by route %s", route.Name))
+			}
+		}
+	}
+	if err := s.db.WithContext(ctx).Delete(&Channel{}, "id = ?", id).Error; err != nil {
+		return fmt.Errorf("failed to delete notification channel: %w", err)
+	}
+
+	s.channelsMu.Lock()
+	delete(s.channels, id)
+	s.channelsMu.Unlock()
+	return nil
+}
+
+// getChannel loads a channel with its settings decrypted
+func (s *Service) getChannel(ctx context.Context, id string) (*Channel, error) {
+	var channel Channel
+	err := s.db.WithContext(ctx).First(&channel, "id = ?", id).Error
+	if goerrors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.NotFound("notification channel", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification channel: %w", err)
+	}
+	if err := s.open(&channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// seal stores Settings in SealedSettings
+func (s *Service) seal(channel *Channel) error {
+	data, err := json.Marshal(channel.Settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode channel settings: %w", err)
+	}
+	channel.SealedSettings = s.fields.Encrypt(settingsColumn, string(data))
+	return nil
+}
+
+// open decodes SealedSettings into Settings
+func (s *Service) open(channel *Channel) error {
+	data, err := s.fields.Decrypt(settingsColumn, channel.SealedSettings)
+	if err != nil {
+		return err
+	}
+	channel.Settings = map[string]string{}
+	if data == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(data), &channel.Settings); err != nil {
+		return fmt.Errorf("failed to decode settings of channel %s: %w", channel.Name, err)
+	}
+	return nil
+}
+
+// mask hides secret settings
+func (c *Channel) mask() {
+	masked := make(map[string]string, len(c.Settings))
+	for key, value := range c.Settings {
+		if secretSettings[key] && value != "" {
+			value = maskedSetting
+		}
+		masked[key] = value
+	}
+	c.Settings = masked
+}
+
+func validateChannel(channel *Channel) error {
+	if channel.Name == "" {
+		return errors.Validation("channel name is required")
+	}
+	required, ok := requiredSettings[channel.Type]
+	if !ok {
+		return errors.Validation(fmt.Sprintf("unsupported channel type %q", channel.Type))
+	}
+	for _, key := range required {
+		if strings.TrimSpace(channel.Settings[key]) == "" {
+			return errors.Validation(fmt.Sprintf("%s channels need the %s setting", channel.Type, key))
+		}
+	}
+	if channel.RateLimit < 0 {
+		return errors.Validation("rate_limit cannot be negative")
+	}
+	if _, err := ParseTemplate(channel.TitleTemplate, channel.MessageTemplate); err != nil {
+		return errors.ValidationWrap(err, err.Error())
+	}
+	return nil
+}
+
+// ListRoutes returns all routes
+func (s *Service) ListRoutes(ctx context.Context) ([]Route, error) {
+	var routes []Route
+	if err := s.db.WithContext(ctx).Order("name").Find(&routes).Error; err != nil {
+		return nil, fmt.Errorf("failed to list notification routes: %w", err)
+	}
+	return routes, nil
+}
+
+// CreateRoute adds a route
+func (s *Service) CreateRoute(ctx context.Context, route *Route) error {
+	if err := s.validateRoute(ctx, route); err != nil {
+		return err
+	}
+	route.ID = uuid.New().String()
+	if err := s.checkNameFree(ctx, &Route{}, "notification route", route.Name, route.ID); err != nil {
+		return err
+	}
+	if err := s.db.WithContext(ctx).Create(route).Error; err != nil {
+		return fmt.Errorf("failed to create notification route: %w", err)
+	}
+	return nil
+}
+
+// UpdateRoute replaces a route
+func (s *Service) UpdateRoute(ctx context.Context, id string, route *Route) error {
+	var existing Route
+	err := s.db.WithContext(ctx).First(&existing, "id = ?", id).Error
+	if goerrors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.NotFound("notification route", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get notification route: %w", err)
+	}
+	if err := s.validateRoute(ctx, route); err != nil {
+		return err
+	}
+	route.ID = existing.ID
+	route.CreatedAt = existing.CreatedAt
+	if err := s.checkNameFree(ctx, &Route{}, "notification route", route.Name, route.ID); err != nil {
+		return err
+	}
+	if err := s.db.WithContext(ctx).Save(route).Error; err != nil {
+		return fmt.Errorf("failed to update notification route: %w", err)
+	}
+	return nil
+}
+
+// DeleteRoute removes a route
+func (s *Service) DeleteRoute(ctx context.Context, id string) error {
+	result := s.db.WithContext(ctx).Delete(&Route{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete notification route: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.NotFound("notification route", id)
+	}
+	return nil
+}
+
+func (s *Service) validateRoute(ctx context.Context, route *Route) error {
+	if route.Name == "" {
+		return errors.Validation("route name is required")
+	}
+	if len(route.Events) == 0 {
+		return errors.Validation("route needs at least one event pattern")
+	}
+	for _, pattern := range route.Events {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Validation(fmt.Sprintf("invalid event pattern %q", pattern))
+		}
+	}
+	switch route.MinSeverity {
+	case "", SeverityInfo, SeverityWarning, SeverityError, SeverityCritical:
+	default:
+		return errors.Validation(fmt.Sprintf("unknown severity %q", route.MinSeverity))
+	}
+	if len(route.Channels) == 0 {
+		return errors.Validation("route needs at least one channel")
+	}
+	unique := make(map[string]bool, len(route.Channels))
+	for _, id := range route.Channels {
+		unique[id] = true
+	}
+	var found int64
+	if err := s.db.WithContext(ctx).Model(&Channel{}).Where("id IN ?", route.Channels).Count(&found).Error; err != nil {
+		return fmt.Errorf("failed to check route channels: %w", err)
+	}
+	if int(found) != len(unique) {
+		return errors.Validation("route names a notification channel that does not exist")
+	}
+	return nil
+}
+
+// checkNameFree returns a conflict if another row of model has name
+func (s *Service) checkNameFree(ctx context.Context, model interface{}, kind, name, id string) error {
+	var taken int64
+	if err := s.db.WithContext(ctx).Model(model).Where("name = ? AND id <> ?", name, id).Count(&taken).Error; err != nil {
+		return fmt.Errorf("failed to check %s name: %w", kind, err)
+	}
+	if taken > 0 {
+		return errors.Conflict(fmt.Sprintf("%s %s already exists", kind, name))
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/anubhavg-icpl/krustron/pkg/secrets"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func newTestService(t *testing.T) (*Service, *recordingTransport) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	svc, err := NewService(db, zap.NewNop(), &Config{SMTP: SMTPConfig{Addr: "smtp.example.com:25", From: "krustron@example.com"}})
+	require.NoError(t, err)
+	transport := &recordingTransport{}
+	svc.client = &http.Client{Transport: transport}
+	return svc, transport
+}
+
+func TestNotifyRoutesBySeverityAndEvent(t *testing.T) {
+	ctx := context.Background()
+	svc, transport := newTestService(t)
+	var mailed []string
+	svc.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		mailed = append(mailed, string(msg))
+		return nil
+	}
+
+	slack := &Channel{Name: "ops-slack", Type: TypeSlack, Enabled: true, RateLimit: 2,
+		Settings: map[string]string{"webhook_url": "https://hooks.slack.com/services/T/B/X"}}
+	webhook := &Channel{Name: "team-hook", Type: TypeWebhook, Enabled: true,
+		Settings:        map[string]string{"url": "https://hooks.example.com/cost"},
+		MessageTemplate: "{{ .Severity | upper }} {{ .Message }}"}
+	email := &Channel{Name: "oncall-mail", Type: TypeEmail, Enabled: true,
+		Settings: map[string]string{"to": "oncall@example.com, sre@example.com"}}
+	for _, ch := range []*Channel{slack, webhook, email} {
+		require.NoError(t, svc.CreateChannel(ctx, ch))
+	}
+	require.NoError(t, svc.CreateRoute(ctx, &Route{Name: "cost", Enabled: true, Events: []string{"cost.*"},
+		MinSeverity: SeverityWarning, Channels: []string{slack.ID, webhook.ID}}))
+	require.NoError(t, svc.CreateRoute(ctx, &Route{Name: "critical", Enabled: true, Events: []string{"*"},
+		MinSeverity: SeverityCritical, Channels: []string{email.ID, slack.ID}}))
+
+	// Matches only the cost route
+	require.NoError(t, svc.Notify(ctx, &Notification{Event: "cost.budget_alert", Severity: SeverityWarning, Message: "80% spent"}))
+	assert.Equal(t, 2, transport.count())
+	req, body := transport.last(t)
+	assert.Equal(t, "hooks.example.com", req.URL.Host, "channels send in name order")
+	assert.Equal(t, "WARNING 80% spent", body["message"], "the channel's template applies")
+	assert.Empty(t, mailed)
+
+	// Below every route's severity, or no route's event
+	assert.ErrorIs(t, svc.Notify(ctx, &Notification{Event: "cost.budget_alert", Severity: SeverityInfo}), ErrNotRouted)
+	assert.ErrorIs(t, svc.Notify(ctx, &Notification{Event: "rbac.access_request", Severity: SeverityWarning}), ErrNotRouted)
+
+	// Matches both routes and is sent to each channel once
+	require.NoError(t, svc.Notify(ctx, &Notification{Event: "cost.budget_alert", Severity: SeverityCritical, Title: "Over budget"}))
+	assert.Equal(t, 4, transport.count())
+	require.Len(t, mailed, 1)
+	assert.Contains(t, mailed[0], "Subject: [CRITICAL] Over budget")
+
+	// The Slack channel has used its two per minute
+	err := svc.Notify(ctx, &Notification{Event: "cost.budget_alert", Severity: SeverityWarning})
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, 5, transport.count(), "the webhook still sends")
+
+	// Disabled channels are skipped
+	webhook.Enabled = false
+	webhook.Settings["url"] = maskedSetting
+	require.NoError(t, svc.UpdateChannel(ctx, webhook.ID, webhook))
+	assert.ErrorIs(t, svc.Notify(ctx, &Notification{Event: "cost.x", Severity: SeverityWarning}), ErrRateLimited)
+	assert.Equal(t, 5, transport.count())
+}
+
+func TestChannelSettingsAreSealedAndMasked(t *testing.T) {
+	ctx := context.Background()
+	svc, transport := newTestService(t)
+	fields, err := secrets.NewFieldCipher(map[string][]byte{"k1": []byte(strings.Repeat("k", 32))}, "k1")
+	require.NoError(t, err)
+	svc.SetFieldCipher(fields)
+
+	channel := &Channel{Name: "pd", Type: TypePagerDuty, Enabled: true,
+		Settings: map[string]string{"routing_key": "R0UT1NG", "events_url": "https://events.example.com/v2/enqueue"}}
+	require.NoError(t, svc.CreateChannel(ctx, channel))
+	assert.Equal(t, maskedSetting, channel.Settings["routing_key"])
+
+	var stored string
+	require.NoError(t, svc.db.Raw("SELECT settings FROM notification_channels WHERE id = ?", channel.ID).Scan(&stored).Error)
+	assert.NotContains(t, stored, "R0UT1NG")
+
+	channels, err := svc.ListChannels(ctx)
+	require.NoError(t, err)
+	require.Len(t, channels, 1)
+	assert.Equal(t, maskedSetting, channels[0].Settings["routing_key"])
+	assert.Equal(t, "https://events.example.com/v2/enqueue", channels[0].Settings["events_url"])
+
+	// Sending the masked value back keeps the key
+	update := channels[0]
+	update.RateLimit = 10
+	require.NoError(t, svc.UpdateChannel(ctx, channel.ID, &update))
+	require.NoError(t, svc.TestChannel(ctx, channel.ID))
+	_, body := transport.last(t)
+	assert.Equal(t, "R0UT1NG", body["routing_key"])
+	assert.Equal(t, "info", body["payload"].(map[string]interface{})["severity"])
+}
+
+func TestChannelAndRouteValidation(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestService(t)
+
+	assert.Error(t, svc.CreateChannel(ctx, &Channel{Name: "x", Type: "sms"}))
+	assert.Error(t, svc.CreateChannel(ctx, &Channel{Name: "x", Type: TypeSlack}))
+	assert.Error(t, svc.CreateChannel(ctx, &Channel{Name: "x", Type: TypeWebhook,
+		Settings: map[string]string{"url": "https://example.com"}, MessageTemplate: "{{ .Message"}))
+
+	hook := &Channel{Name: "hook", Type: TypeWebhook, Settings: map[string]string{"url": "https://example.com"}}
+	require.NoError(t, svc.CreateChannel(ctx, hook))
+	assert.Error(t, svc.CreateChannel(ctx, &Channel{Name: "hook", Type: TypeWebhook, Settings: map[string]string{"url": "https://example.com"}}))
+
+	assert.Error(t, svc.CreateRoute(ctx, &Route{Name: "r", Events: []string{"["}, Channels: []string{hook.ID}}))
+	assert.Error(t, svc.CreateRoute(ctx, &Route{Name: "r", Events: []string{"*"}, MinSeverity: "urgent", Channels: []string{hook.ID}}))
+	assert.Error(t, svc.CreateRoute(ctx, &Route{Name: "r", Events: []string{"*"}, Channels: []string{"missing"}}))
+
+	route := &Route{Name: "r", Events: []string{"*"}, Channels: []string{hook.ID, hook.ID}}
+	require.NoError(t, svc.CreateRoute(ctx, route))
+	// A channel can't be deleted while a route sends to it
+	assert.Error(t, svc.DeleteChannel(ctx, hook.ID))
+	require.NoError(t, svc.DeleteRoute(ctx, route.ID))
+	require.NoError(t, svc.DeleteChannel(ctx, hook.ID))
+	assert.Error(t, svc.DeleteRoute(ctx, route.ID))
+}
@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Slack posts to a Slack incoming webhook
+type Slack struct {
+	client     *http.Client
+	webhookURL string
+	channel    string
+}
+
+// NewSlack posts to webhookURL, overriding the webhook's channel if
+// channel is set
+func NewSlack(client *http.Client, webhookURL, channel string) *Slack {
+	return &Slack{client: client, webhookURL: webhookURL, channel: channel}
+}
+
+// Type implements Notifier
+func (s *Slack) Type() string { return TypeSlack }
+
+// Send implements Notifier
+func (s *Slack) Send(ctx context.Context, n *Notification) error {
+	return postJSON(ctx, s.client, s.webhookURL, slackPayload(n, s.channel), nil)
+}
+
+// slackIcons mark a message's severity
+var slackIcons = map[string]string{
+	SeverityInfo:     ":information_source:",
+	SeverityWarning:  ":warning:",
+	SeverityError:    ":x:",
+	SeverityCritical: ":rotating_light:",
+}
+
+// slackPayload builds a Block Kit message with the notification's fields
+func slackPayload(n *Notification, channel string) map[string]interface{} {
+	text := n.Message
+	if n.Title != "" {
+		text = fmt.Sprintf("*%s*\n%s", n.Title, n.Message)
+	}
+	if icon, ok := slackIcons[n.Severity]; ok {
+		text = icon + " " + text
+	}
+
+	blocks := []map[string]interface{}{{
+		"type": "section",
+		"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+	}}
+	if len(n.Fields) > 0 {
+		var fields []map[string]interface{}
+		for _, f := range n.Fields {
+			// Slack shows at most 10 fields in a section
+			if len(fields) == 10 {
+				break
+			}
+			fields = append(fields, map[string]interface{}{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", f.Name, orDash(f.Value))})
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+	context := fmt.Sprintf("`%s`", n.Event)
+	if n.URL != "" {
+		context += fmt.Sprintf(" | <%s|Details>", n.URL)
+	}
+	blocks = append(blocks, map[string]interface{}{
+		"type":     "context",
+		"elements": []map[string]interface{}{{"type": "mrkdwn", "text": context}},
+	})
+
+	payload := map[string]interface{}{"text": text, "blocks": blocks}
+	if channel != "" {
+		payload["channel"] = channel
+	}
+	return payload
+}
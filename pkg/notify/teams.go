@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+)
+
+// teamsColors color a card's title by severity
+var teamsColors = map[string]string{
+	SeverityInfo:     "accent",
+	SeverityWarning:  "warning",
+	SeverityError:    "attention",
+	SeverityCritical: "attention",
+}
+
+// Teams posts an Adaptive Card to a Microsoft Teams incoming webhook or
+// workflow
+type Teams struct {
+	client     *http.Client
+	webhookURL string
+}
+
+// NewTeams posts to webhookURL
+func NewTeams(client *http.Client, webhookURL string) *Teams {
+	return &Teams{client: client, webhookURL: webhookURL}
+}
+
+// Type implements Notifier
+func (t *Teams) Type() string { return TypeTeams }
+
+// Send implements Notifier
+func (t *Teams) Send(ctx context.Context, n *Notification) error {
+	return postJSON(ctx, t.client, t.webhookURL, teamsPayload(n), nil)
+}
+
+// teamsPayload wraps an Adaptive Card in the message webhooks accept
+func teamsPayload(n *Notification) map[string]interface{} {
+	title := n.Title
+	if title == "" {
+		title = n.Event
+	}
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "text": title, "weight": "bolder", "size": "medium", "wrap": true, "color": teamsColors[n.Severity]},
+		{"type": "TextBlock", "text": n.Message, "wrap": true},
+	}
+	if len(n.Fields) > 0 {
+		facts := make([]map[string]interface{}, 0, len(n.Fields))
+		for _, f := range n.Fields {
+			facts = append(facts, map[string]interface{}{"title": f.Name, "value": orDash(f.Value)})
+		}
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if n.URL != "" {
+		card["actions"] = []map[string]interface{}{{"type": "Action.OpenUrl", "title": "Details", "url": n.URL}}
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+}
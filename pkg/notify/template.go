@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are available to channel templates
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// Template rewrites the title and message of notifications sent to a
+// channel. Both are Go templates executed against the Notification, e.g.
+// `{{ .Severity | upper }}: {{ .Message }} ({{ .Field "Cluster" }})`.
+// An empty template leaves its part unchanged.
+type Template struct {
+	title   *template.Template
+	message *template.Template
+}
+
+// ParseTemplate compiles a title and message template
+func ParseTemplate(title, message string) (*Template, error) {
+	t := &Template{}
+	var err error
+	if title != "" {
+		if t.title, err = template.New("title").Funcs(templateFuncs).Parse(title); err != nil {
+			return nil, fmt.Errorf("invalid title template: %w", err)
+		}
+	}
+	if message != "" {
+		if t.message, err = template.New("message").Funcs(templateFuncs).Parse(message); err != nil {
+			return nil, fmt.Errorf("invalid message template: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// Apply returns a copy of n with the templates applied
+func (t *Template) Apply(n *Notification) (*Notification, error) {
+	out := *n
+	if t == nil {
+		return &out, nil
+	}
+	render := func(tmpl *template.Template, current string) (string, error) {
+		if tmpl == nil {
+			return current, nil
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, n); err != nil {
+			return "", fmt.Errorf("failed to render %s template: %w", tmpl.Name(), err)
+		}
+		return buf.String(), nil
+	}
+	var err error
+	if out.Title, err = render(t.title, n.Title); err != nil {
+		return nil, err
+	}
+	if out.Message, err = render(t.message, n.Message); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
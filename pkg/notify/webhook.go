@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+)
+
+// Webhook POSTs notifications as JSON, signed when it has a secret
+type Webhook struct {
+	client *http.Client
+	url    string
+	secret string
+}
+
+// NewWebhook posts to url, signing bodies with secret in SignatureHeader
+// if it is set
+func NewWebhook(client *http.Client, url, secret string) *Webhook {
+	return &Webhook{client: client, url: url, secret: secret}
+}
+
+// Type implements Notifier
+func (w *Webhook) Type() string { return TypeWebhook }
+
+// Send implements Notifier. It posts the notification's Payload if it
+// has one, the notification otherwise.
+func (w *Webhook) Send(ctx context.Context, n *Notification) error {
+	var payload interface{} = n
+	if n.Payload != nil {
+		payload = n.Payload
+	}
+	return postJSON(ctx, w.client, w.url, payload, func(body []byte) map[string]string {
+		if w.secret == "" {
+			return nil
+		}
+		return map[string]string{SignatureHeader: SignPayload(w.secret, body)}
+	})
+}
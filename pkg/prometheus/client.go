@@ -0,0 +1,171 @@
+// Package prometheus queries the Prometheus HTTP API
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout bounds a query when Config.Timeout is unset
+const defaultTimeout = 30 * time.Second
+
+// Config is where a Prometheus is and how to authenticate to it
+type Config struct {
+	URL string
+	// BearerToken is sent when set; otherwise Username and Password are,
+	// when Username is set
+	BearerToken string
+	Username    string
+	Password    string
+	// Timeout bounds each query; defaults to 30 seconds
+	Timeout time.Duration
+}
+
+// Series is one series of a query result. An instant query yields one
+// value per series, a range query one per step; samples that aren't
+// numbers are dropped.
+type Series struct {
+	Labels map[string]string
+	Values []float64
+}
+
+// Client runs queries against the Prometheus HTTP API
+type Client struct {
+	baseURL     string
+	bearerToken string
+	username    string
+	password    string
+	httpClient  *http.Client
+}
+
+// NewClient creates a client for the Prometheus at cfg.URL
+func NewClient(cfg Config) *Client {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &Client{
+		baseURL:     strings.TrimRight(cfg.URL, "/"),
+		bearerToken: cfg.BearerToken,
+		username:    cfg.Username,
+		password:    cfg.Password,
+		httpClient:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Query runs an instant query at the given time, or at the server's
+// current time when at is zero. A scalar result is returned as one series
+// without labels.
+func (c *Client) Query(ctx context.Context, query string, at time.Time) ([]Series, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	if !at.IsZero() {
+		params.Set("time", strconv.FormatInt(at.Unix(), 10))
+	}
+	return c.do(ctx, "/api/v1/query", params)
+}
+
+// QueryRange runs a range query over [start, end] at the given step
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]Series, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+	return c.do(ctx, "/api/v1/query_range", params)
+}
+
+func (c *Client) do(ctx context.Context, path string, params url.Values) ([]Series, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode prometheus response: %w", err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s", body.Error)
+	}
+
+	switch body.Data.ResultType {
+	case "scalar":
+		var sample []interface{}
+		if err := json.Unmarshal(body.Data.Result, &sample); err != nil {
+			return nil, fmt.Errorf("failed to decode prometheus scalar: %w", err)
+		}
+		s := Series{}
+		if v, ok := parseSample(sample); ok {
+			s.Values = append(s.Values, v)
+		}
+		return []Series{s}, nil
+	case "vector", "matrix":
+	default:
+		return nil, fmt.Errorf("unsupported prometheus result type: %s", body.Data.ResultType)
+	}
+
+	var result []struct {
+		Metric map[string]string `json:"metric"`
+		Value  []interface{}     `json:"value"`
+		Values [][]interface{}   `json:"values"`
+	}
+	if err := json.Unmarshal(body.Data.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode prometheus %s: %w", body.Data.ResultType, err)
+	}
+	series := make([]Series, 0, len(result))
+	for _, r := range result {
+		s := Series{Labels: r.Metric}
+		samples := r.Values
+		if body.Data.ResultType == "vector" {
+			samples = [][]interface{}{r.Value}
+		}
+		for _, sample := range samples {
+			if v, ok := parseSample(sample); ok {
+				s.Values = append(s.Values, v)
+			}
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// parseSample parses a [timestamp, "value"] pair
+func parseSample(pair []interface{}) (float64, bool) {
+	if len(pair) != 2 {
+		return 0, false
+	}
+	str, ok := pair[1].(string)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve answers every query with body, recording the last request
+func serve(t *testing.T, body string) (*httptest.Server, **http.Request) {
+	t.Helper()
+	var last *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &last
+}
+
+func TestQueryVector(t *testing.T) {
+	server, last := serve(t, `{"status":"success","data":{"resultType":"vector","result":[
+		{"metric":{"namespace":"shop"},"value":[1700000000,"0.95"]},
+		{"metric":{"namespace":"ml"},"value":[1700000000,"NaN"]},
+		{"metric":{"namespace":"ops"},"value":[1700000000,"bogus"]}]}}`)
+	c := NewClient(Config{URL: server.URL + "/", BearerToken: "t0ken", Username: "ignored"})
+
+	at := time.Unix(1700000000, 0)
+	series, err := c.Query(context.Background(), `up{job="web"}`, at)
+	require.NoError(t, err)
+	require.Len(t, series, 3)
+	assert.Equal(t, map[string]string{"namespace": "shop"}, series[0].Labels)
+	assert.Equal(t, []float64{0.95}, series[0].Values)
+	assert.Len(t, series[1].Values, 1, "NaN is a number")
+	assert.Empty(t, series[2].Values)
+
+	req := *last
+	assert.Equal(t, "/api/v1/query", req.URL.Path)
+	assert.Equal(t, `up{job="web"}`, req.URL.Query().Get("query"))
+	assert.Equal(t, "1700000000", req.URL.Query().Get("time"))
+	assert.Equal(t, "Bearer t0ken", req.Header.Get("Authorization"))
+
+	// Without a time the server's current time is used
+	_, err = c.Query(context.Background(), "up", time.Time{})
+	require.NoError(t, err)
+	assert.False(t, (*last).URL.Query().Has("time"))
+}
+
+func TestQueryRangeAndScalar(t *testing.T) {
+	server, last := serve(t, `{"status":"success","data":{"resultType":"matrix","result":[
+		{"metric":{"pod":"web-0"},"values":[[1,"1"],[2,"2"],[3,"3"]]}]}}`)
+	c := NewClient(Config{URL: server.URL, Username: "alice", Password: "hunter2"})
+
+	end := time.Unix(1700000600, 0)
+	series, err := c.QueryRange(context.Background(), "cpu", end.Add(-10*time.Minute), end, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Equal(t, []float64{1, 2, 3}, series[0].Values)
+
+	req := *last
+	assert.Equal(t, "/api/v1/query_range", req.URL.Path)
+	assert.Equal(t, "1700000000", req.URL.Query().Get("start"))
+	assert.Equal(t, "1700000600", req.URL.Query().Get("end"))
+	assert.Equal(t, "60", req.URL.Query().Get("step"))
+	user, password, ok := req.BasicAuth()
+	require.True(t, ok)
+	assert.Equal(t, "alice", user)
+	assert.Equal(t, "hunter2", password)
+
+	scalar, _ := serve(t, `{"status":"success","data":{"resultType":"scalar","result":[1700000000,"42"]}}`)
+	series, err = NewClient(Config{URL: scalar.URL}).Query(context.Background(), "scalar(42)", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, series, 1)
+	assert.Empty(t, series[0].Labels)
+	assert.Equal(t, []float64{42}, series[0].Values)
+}
+
+func TestQueryErrors(t *testing.T) {
+	failed, _ := serve(t, `{"status":"error","errorType":"bad_data","error":"parse error at char 4"}`)
+	_, err := NewClient(Config{URL: failed.URL}).Query(context.Background(), "up{", time.Time{})
+	assert.ErrorContains(t, err, "parse error at char 4")
+
+	unsupported, _ := serve(t, `{"status":"success","data":{"resultType":"string","result":[1,"hi"]}}`)
+	_, err = NewClient(Config{URL: unsupported.URL}).Query(context.Background(), `"hi"`, time.Time{})
+	assert.ErrorContains(t, err, "unsupported prometheus result type")
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// RotateFunc is told that the secret at ref changed or was deleted
+type RotateFunc func(ctx context.Context, ref string)
+
+// Cached serves secrets from memory for a TTL, so hot paths such as Git
+// fetches and AI requests don't call the backend each time. Subscribers
+// registered with OnRotate hear about every change written through the
+// cache and, once the TTL expires, about changes made to the backend
+// directly.
+type Cached struct {
+	store Store
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	hooks   []RotateFunc
+}
+
+type cacheEntry struct {
+	data    map[string][]byte
+	fetched time.Time
+}
+
+// NewCached wraps store with a cache; a ttl of 0 reads through on every
+// Get but still detects rotations
+func NewCached(store Store, ttl time.Duration) *Cached {
+	return &Cached{store: store, ttl: ttl, now: time.Now, entries: make(map[string]cacheEntry)}
+}
+
+// OnRotate registers fn to be called, in the goroutine that noticed the
+// change, whenever a secret changes
+func (c *Cached) OnRotate(fn RotateFunc) {
+	c.mu.Lock()
+	c.hooks = append(c.hooks, fn)
+	c.mu.Unlock()
+}
+
+// Get returns the secret at ref, from memory while it is fresh
+func (c *Cached) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[ref]
+	c.mu.Unlock()
+	if ok && c.now().Sub(entry.fetched) < c.ttl {
+		return clone(entry.data), nil
+	}
+
+	data, err := c.store.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	previous, known := c.entries[ref]
+	c.entries[ref] = cacheEntry{data: clone(data), fetched: c.now()}
+	c.mu.Unlock()
+	if known && !equal(previous.data, data) {
+		c.rotated(ctx, ref)
+	}
+	return clone(data), nil
+}
+
+// Set writes the secret at ref and tells subscribers it changed
+func (c *Cached) Set(ctx context.Context, ref string, data map[string][]byte) error {
+	if err := c.store.Set(ctx, ref, data); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.entries[ref] = cacheEntry{data: clone(data), fetched: c.now()}
+	c.mu.Unlock()
+	c.rotated(ctx, ref)
+	return nil
+}
+
+// Delete removes the secret at ref and tells subscribers it changed
+func (c *Cached) Delete(ctx context.Context, ref string) error {
+	if err := c.store.Delete(ctx, ref); err != nil {
+		return err
+	}
+	c.Invalidate(ref)
+	c.rotated(ctx, ref)
+	return nil
+}
+
+// Invalidate drops ref from memory so the next Get reads the backend
+func (c *Cached) Invalidate(ref string) {
+	c.mu.Lock()
+	delete(c.entries, ref)
+	c.mu.Unlock()
+}
+
+func (c *Cached) rotated(ctx context.Context, ref string) {
+	c.mu.Lock()
+	hooks := append([]RotateFunc(nil), c.hooks...)
+	c.mu.Unlock()
+	for _, hook := range hooks {
+		hook(ctx, ref)
+	}
+}
+
+func equal(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+// countingStore counts reads of the store it wraps
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (c *countingStore) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	c.gets++
+	return c.Store.Get(ctx, ref)
+}
+
+func TestCachedServesFromMemoryUntilTTL(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: NewKube(k8sfake.NewSimpleClientset(), "krustron-system")}
+	require.NoError(t, backend.Set(ctx, "ai", map[string][]byte{"api_key": []byte("sk-1")}))
+
+	now := time.Now()
+	cached := NewCached(backend, time.Minute)
+	cached.now = func() time.Time { return now }
+	var rotated []string
+	cached.OnRotate(func(ctx context.Context, ref string) { rotated = append(rotated, ref) })
+
+	for i := 0; i < 3; i++ {
+		data, err := cached.Get(ctx, "ai")
+		require.NoError(t, err)
+		assert.Equal(t, "sk-1", string(data["api_key"]))
+		// Callers get their own copy
+		data["api_key"][0] = 'X'
+	}
+	assert.Equal(t, 1, backend.gets)
+	assert.Empty(t, rotated)
+
+	// A rotation made in the backend is seen once the entry expires
+	require.NoError(t, backend.Set(ctx, "ai", map[string][]byte{"api_key": []byte("sk-2")}))
+	data, err := cached.Get(ctx, "ai")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-1", string(data["api_key"]))
+
+	now = now.Add(time.Minute)
+	data, err = cached.Get(ctx, "ai")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-2", string(data["api_key"]))
+	assert.Equal(t, []string{"ai"}, rotated)
+
+	// Re-reading an unchanged secret is not a rotation
+	now = now.Add(time.Minute)
+	_, err = cached.Get(ctx, "ai")
+	require.NoError(t, err)
+	assert.Equal(t, 3, backend.gets)
+	assert.Equal(t, []string{"ai"}, rotated)
+}
+
+func TestCachedWritesNotifySubscribers(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: NewKube(k8sfake.NewSimpleClientset(), "krustron-system")}
+	cached := NewCached(backend, time.Minute)
+	var rotated []string
+	cached.OnRotate(func(ctx context.Context, ref string) { rotated = append(rotated, ref) })
+
+	require.NoError(t, cached.Set(ctx, "cluster-prod", map[string][]byte{"kubeconfig": []byte("v2")}))
+	data, err := cached.Get(ctx, "cluster-prod")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data["kubeconfig"]))
+	assert.Zero(t, backend.gets, "a write fills the cache")
+
+	require.NoError(t, cached.Delete(ctx, "cluster-prod"))
+	_, err = cached.Get(ctx, "cluster-prod")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, []string{"cluster-prod", "cluster-prod"}, rotated)
+}
@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix marks a value sealed by a Cipher
+const sealedPrefix = "enc:v1:"
+
+// Cipher seals secrets with AES-256-GCM under a data key. Each value is
+// bound to its ref, so a sealed value copied to another ref won't open.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a cipher from a 32-byte data key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("data key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// ParseDataKey creates a cipher from a base64 data key, as produced by
+// `openssl rand -base64 32`
+func ParseDataKey(key string) (*Cipher, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("data key is not valid base64: %w", err)
+	}
+	return NewCipher(raw)
+}
+
+// Seal encrypts plaintext for ref
+func (c *Cipher) Seal(ref string, plaintext []byte) string {
+	return sealedPrefix + c.seal(ref, plaintext)
+}
+
+// Open decrypts a value Seal produced for ref
+func (c *Cipher) Open(ref, sealed string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(sealed, sealedPrefix)
+	if !ok {
+		return nil, fmt.Errorf("secret %s is not sealed", ref)
+	}
+	plaintext, err := c.open(ref, encoded)
+	switch {
+	case err == errCorrupt:
+		return nil, fmt.Errorf("secret %s is corrupt", ref)
+	case err != nil:
+		return nil, fmt.Errorf("failed to decrypt secret %s; wrong data key?", ref)
+	}
+	return plaintext, nil
+}
+
+// errCorrupt is returned by open for a value that isn't a ciphertext
+var errCorrupt = errors.New("corrupt ciphertext")
+
+// seal encrypts plaintext bound to aad, returning the base64 nonce and
+// ciphertext
+func (c *Cipher) seal(aad string, plaintext []byte) string {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("secrets: failed to read random nonce: %v", err))
+	}
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plaintext, []byte(aad)))
+}
+
+// open decrypts what seal produced for aad
+func (c *Cipher) open(aad, encoded string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return nil, errCorrupt
+	}
+	nonce, ciphertext := raw[:c.aead.NonceSize()], raw[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, []byte(aad))
+}
+
+// IsSealed reports whether value was produced by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
@@ -0,0 +1,62 @@
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Database keeps secrets in the secrets table, sealed with the data key so
+// a database dump or backup holds no plaintext credentials
+type Database struct {
+	db     *sql.DB
+	cipher *Cipher
+}
+
+// NewDatabase creates a database store
+func NewDatabase(db *sql.DB, cipher *Cipher) *Database {
+	return &Database{db: db, cipher: cipher}
+}
+
+// Get returns the secret at ref
+func (d *Database) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	var sealed string
+	err := d.db.QueryRowContext(ctx, `SELECT data FROM secrets WHERE ref = $1`, ref).Scan(&sealed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", ref, err)
+	}
+	raw, err := d.cipher.Open(ref, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSecret(ref, raw)
+}
+
+// Set creates or replaces the secret at ref
+func (d *Database) Set(ctx context.Context, ref string, data map[string][]byte) error {
+	raw, err := encodeSecret(data)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO secrets (ref, data, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (ref) DO UPDATE SET data = EXCLUDED.data, updated_at = EXCLUDED.updated_at
+	`, ref, d.cipher.Seal(ref, raw), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s: %w", ref, err)
+	}
+	return nil
+}
+
+// Delete removes the secret at ref
+func (d *Database) Delete(ctx context.Context, ref string) error {
+	if _, err := d.db.ExecContext(ctx, `DELETE FROM secrets WHERE ref = $1`, ref); err != nil {
+		return fmt.Errorf("failed to delete secret %s: %w", ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Env reads secrets from environment variables holding a JSON object of
+// string values, e.g. KRUSTRON_SECRET_GIT_CREDS='{"token":"..."}'. With a
+// data key every value must be sealed, so the environment, and anything
+// that dumps it, never holds a plaintext credential.
+type Env struct {
+	prefix string
+	cipher *Cipher
+}
+
+// NewEnv creates an environment store; cipher is optional
+func NewEnv(prefix string, cipher *Cipher) *Env {
+	return &Env{prefix: prefix, cipher: cipher}
+}
+
+// Get returns the secret in ref's variable
+func (e *Env) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	name := e.Name(ref)
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not set", ErrNotFound, name)
+	}
+	raw := []byte(value)
+	if e.cipher != nil {
+		var err error
+		if raw, err = e.cipher.Open(ref, value); err != nil {
+			return nil, err
+		}
+	}
+	return decodeSecret(ref, raw)
+}
+
+// Set sets ref's variable for this process
+func (e *Env) Set(ctx context.Context, ref string, data map[string][]byte) error {
+	value, err := e.Encode(ref, data)
+	if err != nil {
+		return err
+	}
+	return os.Setenv(e.Name(ref), value)
+}
+
+// Delete unsets ref's variable for this process
+func (e *Env) Delete(ctx context.Context, ref string) error {
+	return os.Unsetenv(e.Name(ref))
+}
+
+// Name returns the variable ref is read from
+func (e *Env) Name(ref string) string {
+	return e.prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, ref)
+}
+
+// Encode returns the variable value holding data, sealed when the store
+// has a data key
+func (e *Env) Encode(ref string, data map[string][]byte) (string, error) {
+	raw, err := encodeSecret(data)
+	if err != nil {
+		return "", err
+	}
+	if e.cipher != nil {
+		return e.cipher.Seal(ref, raw), nil
+	}
+	return string(raw), nil
+}
+
+// encodeSecret serialises data as a JSON object of strings
+func encodeSecret(data map[string][]byte) ([]byte, error) {
+	values := make(map[string]string, len(data))
+	for k, v := range data {
+		values[k] = string(v)
+	}
+	return json.Marshal(values)
+}
+
+func decodeSecret(ref string, raw []byte) (map[string][]byte, error) {
+	var values map[string]string
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object of strings: %w", ref, err)
+	}
+	data := make(map[string][]byte, len(values))
+	for k, v := range values {
+		data[k] = []byte(v)
+	}
+	return data, nil
+}
@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// fieldPrefix starts an encrypted column value, "fenc:<key id>:<base64>".
+// It differs from a Cipher's prefix so the two can't be mistaken for each
+// other.
+const fieldPrefix = "fenc:"
+
+// Column is a database column whose values are encrypted
+type Column struct {
+	Table string
+	// Key is the column identifying a row
+	Key  string
+	Name string
+}
+
+func (c Column) String() string { return c.Table + "." + c.Name }
+
+// FieldCipher encrypts designated database columns with a Cipher per key. Each
+// value names the key it was encrypted with, so keys can be rotated: new
+// values use the active key while values under older keys still decrypt
+// until they are re-encrypted. Values are bound to their column.
+//
+// A nil FieldCipher leaves values in plaintext.
+type FieldCipher struct {
+	keys   map[string]*Cipher
+	active string
+}
+
+// NewFieldCipher creates a cipher from 32-byte keys by ID, encrypting with
+// the active one
+func NewFieldCipher(keys map[string][]byte, active string) (*FieldCipher, error) {
+	f := &FieldCipher{keys: make(map[string]*Cipher, len(keys)), active: active}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid field key ID %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("field key %s must be 32 bytes, got %d", id, len(key))
+		}
+		c, err := NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		f.keys[id] = c
+	}
+	if _, ok := f.keys[active]; !ok {
+		return nil, fmt.Errorf("active field key %q is not among the field keys", active)
+	}
+	return f, nil
+}
+
+// LoadFieldCipher reads field keys from the secret at ref, whose fields
+// are key IDs holding base64 32-byte keys, e.g. {"2024-01": "..."}
+func LoadFieldCipher(ctx context.Context, store Store, ref, active string) (*FieldCipher, error) {
+	data, err := store.Get(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read field keys: %w", err)
+	}
+	keys := make(map[string][]byte, len(data))
+	for id, encoded := range data {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil {
+			return nil, fmt.Errorf("field key %s is not valid base64: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewFieldCipher(keys, active)
+}
+
+// Encrypt encrypts a value of column with the active key. Empty values
+// stay empty.
+func (f *FieldCipher) Encrypt(column Column, plaintext string) string {
+	if f == nil || plaintext == "" {
+		return plaintext
+	}
+	return fieldPrefix + f.active + ":" + f.keys[f.active].seal(column.String(), []byte(plaintext))
+}
+
+// Decrypt returns the plaintext of a value of column. Values written
+// before the column was encrypted are returned as they are.
+func (f *FieldCipher) Decrypt(column Column, value string) (string, error) {
+	id, encoded, ok := splitField(value)
+	if !ok {
+		return value, nil
+	}
+	if f == nil {
+		return "", fmt.Errorf("%s is encrypted but no field keys are configured", column)
+	}
+	c, ok := f.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%s is encrypted with unknown field key %q", column, id)
+	}
+	plaintext, err := c.open(column.String(), encoded)
+	switch {
+	case err == errCorrupt:
+		return "", fmt.Errorf("%s holds a corrupt encrypted value", column)
+	case err != nil:
+		return "", fmt.Errorf("failed to decrypt %s with field key %q", column, id)
+	}
+	return string(plaintext), nil
+}
+
+// Current reports whether value is empty or encrypted with the active key
+func (f *FieldCipher) Current(value string) bool {
+	if f == nil {
+		return true
+	}
+	id, _, ok := splitField(value)
+	return value == "" || ok && id == f.active
+}
+
+// Reencrypt encrypts every value of column that is in plaintext or under
+// an older key with the active key, returning how many rows changed. A row
+// changed concurrently is left for the next run.
+func (f *FieldCipher) Reencrypt(ctx context.Context, db *sql.DB, column Column) (int, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT %s, %s FROM %s WHERE %s IS NOT NULL AND %s <> ''`,
+		column.Key, column.Name, column.Table, column.Name, column.Name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", column, err)
+	}
+	type row struct{ key, value string }
+	var stale []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.key, &r.value); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read %s: %w", column, err)
+		}
+		if !f.Current(r.value) {
+			stale = append(stale, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", column, err)
+	}
+
+	update := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2 AND %s = $3`,
+		column.Table, column.Name, column.Key, column.Name)
+	changed := 0
+	for _, r := range stale {
+		plaintext, err := f.Decrypt(column, r.value)
+		if err != nil {
+			return changed, err
+		}
+		result, err := db.ExecContext(ctx, update, f.Encrypt(column, plaintext), r.key, r.value)
+		if err != nil {
+			return changed, fmt.Errorf("failed to update %s: %w", column, err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// splitField splits an encrypted value into its key ID and ciphertext
+func splitField(value string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(value, fieldPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testColumn = Column{Table: "widgets", Key: "id", Name: "token"}
+
+func fieldKey(b byte) []byte { return []byte(strings.Repeat(string(b), 32)) }
+
+func TestFieldCipherRoundTrip(t *testing.T) {
+	f, err := NewFieldCipher(map[string][]byte{"k1": fieldKey('a')}, "k1")
+	require.NoError(t, err)
+
+	stored := f.Encrypt(testColumn, "hunter2")
+	assert.True(t, strings.HasPrefix(stored, "fenc:k1:"), stored)
+	assert.NotContains(t, stored, "hunter2")
+	assert.NotEqual(t, stored, f.Encrypt(testColumn, "hunter2"), "nonces are random")
+
+	plaintext, err := f.Decrypt(testColumn, stored)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+
+	// Values are bound to their column
+	_, err = f.Decrypt(Column{Table: "widgets", Key: "id", Name: "other"}, stored)
+	assert.Error(t, err)
+
+	// Empty and legacy plaintext values pass through
+	assert.Empty(t, f.Encrypt(testColumn, ""))
+	plaintext, err = f.Decrypt(testColumn, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", plaintext)
+
+	// Field values and values sealed by a Cipher are never mistaken for
+	// each other
+	assert.False(t, IsSealed(stored))
+	c, err := NewCipher(fieldKey('a'))
+	require.NoError(t, err)
+	sealed := c.Seal(testColumn.String(), []byte("hunter2"))
+	plaintext, err = f.Decrypt(testColumn, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, sealed, plaintext)
+
+	// Without keys nothing is encrypted, and encrypted values can't be read
+	var none *FieldCipher
+	assert.Equal(t, "hunter2", none.Encrypt(testColumn, "hunter2"))
+	_, err = none.Decrypt(testColumn, stored)
+	assert.Error(t, err)
+}
+
+func TestNewFieldCipherValidatesKeys(t *testing.T) {
+	_, err := NewFieldCipher(map[string][]byte{"k1": []byte("short")}, "k1")
+	assert.Error(t, err)
+	_, err = NewFieldCipher(map[string][]byte{"k:1": fieldKey('a')}, "k:1")
+	assert.Error(t, err)
+	_, err = NewFieldCipher(map[string][]byte{"k1": fieldKey('a')}, "k2")
+	assert.Error(t, err)
+}
+
+func TestFieldKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	store := NewEnv("TEST_FIELD_KEYS_", nil)
+	t.Setenv(store.Name("field-keys"), `{"k1":"`+base64.StdEncoding.EncodeToString(fieldKey('a'))+`"}`)
+	old, err := LoadFieldCipher(ctx, store, "field-keys", "k1")
+	require.NoError(t, err)
+
+	db := newTestDB(t)
+	_, err = db.Exec(`CREATE TABLE widgets (id TEXT PRIMARY KEY, token TEXT)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO widgets VALUES ('a', $1), ('b', 'plain'), ('c', NULL), ('d', '')`,
+		old.Encrypt(testColumn, "secret-a"))
+	require.NoError(t, err)
+
+	// A new key is added and made active; old values still decrypt
+	t.Setenv(store.Name("field-keys"), `{"k1":"`+base64.StdEncoding.EncodeToString(fieldKey('a'))+
+		`","k2":"`+base64.StdEncoding.EncodeToString(fieldKey('b'))+`"}`)
+	f, err := LoadFieldCipher(ctx, store, "field-keys", "k2")
+	require.NoError(t, err)
+	var stored string
+	require.NoError(t, db.QueryRow(`SELECT token FROM widgets WHERE id = 'a'`).Scan(&stored))
+	assert.False(t, f.Current(stored))
+	plaintext, err := f.Decrypt(testColumn, stored)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-a", plaintext)
+
+	changed, err := f.Reencrypt(ctx, db, testColumn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, changed)
+	for id, want := range map[string]string{"a": "secret-a", "b": "plain"} {
+		require.NoError(t, db.QueryRow(`SELECT token FROM widgets WHERE id = $1`, id).Scan(&stored))
+		assert.True(t, strings.HasPrefix(stored, "fenc:k2:"), stored)
+		plaintext, err := f.Decrypt(testColumn, stored)
+		require.NoError(t, err)
+		assert.Equal(t, want, plaintext)
+	}
+
+	// Everything is current, and the old key is no longer needed
+	changed, err = f.Reencrypt(ctx, db, testColumn)
+	require.NoError(t, err)
+	assert.Zero(t, changed)
+	_, err = old.Decrypt(testColumn, stored)
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// managedByLabel marks Secrets krustron created
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// Kube keeps secrets in Secrets in the cluster krustron runs in. A ref is
+// "name", or "namespace/name", in the namespace the store was created
+// with; refs are pinned to it so a caller can't name any Secret the
+// service account can read.
+type Kube struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+// NewKube creates a store backed by Kubernetes Secrets
+func NewKube(client kubernetes.Interface, namespace string) *Kube {
+	return &Kube{client: client, namespace: namespace}
+}
+
+// Get returns the data of the Secret at ref
+func (k *Kube) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	namespace, name, err := k.split(ref)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := k.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, namespace, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", namespace, name, err)
+	}
+	return secret.Data, nil
+}
+
+// Set creates the Secret at ref or replaces its data
+func (k *Kube) Set(ctx context.Context, ref string, data map[string][]byte) error {
+	namespace, name, err := k.split(ref)
+	if err != nil {
+		return err
+	}
+	secrets := k.client.CoreV1().Secrets(namespace)
+	existing, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{managedByLabel: "krustron"},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}, metav1.CreateOptions{})
+	case err == nil:
+		existing.Data = data
+		_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// Delete deletes the Secret at ref
+func (k *Kube) Delete(ctx context.Context, ref string) error {
+	namespace, name, err := k.split(ref)
+	if err != nil {
+		return err
+	}
+	err = k.client.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+func (k *Kube) split(ref string) (string, string, error) {
+	if ns, name, ok := strings.Cut(ref, "/"); ok {
+		if ns != k.namespace {
+			return "", "", fmt.Errorf("secret %s is outside namespace %s", ref, k.namespace)
+		}
+		return ns, name, nil
+	}
+	return k.namespace, ref, nil
+}
@@ -0,0 +1,95 @@
+// Package secrets stores credentials outside the application database:
+// in Kubernetes Secrets, HashiCorp Vault, the environment, or sealed with a
+// data key in the database
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrNotFound is returned, wrapped, for a ref with no secret
+var ErrNotFound = errors.New("secret not found")
+
+// Store is a secret backend. A secret is a set of key/value pairs named
+// by a ref; what a ref looks like is up to the backend, but a lowercase
+// DNS label such as "cluster-3f2a" works with every one.
+type Store interface {
+	// Get returns the secret at ref
+	Get(ctx context.Context, ref string) (map[string][]byte, error)
+	// Set creates or replaces the secret at ref
+	Set(ctx context.Context, ref string, data map[string][]byte) error
+	// Delete removes the secret at ref; deleting a missing secret is not
+	// an error
+	Delete(ctx context.Context, ref string) error
+}
+
+// Backends needed by New; only the one cfg selects is used
+type Backends struct {
+	Kubernetes kubernetes.Interface
+	DB         *sql.DB
+}
+
+// New creates the store cfg selects. The store is not cached; wrap it with
+// NewCached.
+func New(cfg *config.SecretsConfig, defaultNamespace string, backends Backends) (Store, error) {
+	var cipher *Cipher
+	if cfg.DataKey != "" {
+		var err error
+		if cipher, err = ParseDataKey(cfg.DataKey); err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Backend {
+	case "kubernetes", "":
+		if backends.Kubernetes == nil {
+			return nil, fmt.Errorf("the kubernetes secret backend needs a local cluster")
+		}
+		namespace := cfg.Namespace
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+		return NewKube(backends.Kubernetes, namespace), nil
+	case "vault":
+		return NewVault(&cfg.Vault, nil), nil
+	case "env":
+		return NewEnv(cfg.EnvPrefix, cipher), nil
+	case "database":
+		if cipher == nil {
+			return nil, fmt.Errorf("the database secret backend needs a data key")
+		}
+		if backends.DB == nil {
+			return nil, fmt.Errorf("the database secret backend needs a database")
+		}
+		return NewDatabase(backends.DB, cipher), nil
+	}
+	return nil, fmt.Errorf("unknown secret backend %q", cfg.Backend)
+}
+
+// Value returns one key of the secret at ref
+func Value(ctx context.Context, store Store, ref, key string) ([]byte, error) {
+	data, err := store.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no %s", ref, key)
+	}
+	return value, nil
+}
+
+// clone copies data so callers can't change a stored or cached secret
+func clone(data map[string][]byte) map[string][]byte {
+	out := make(map[string][]byte, len(data))
+	for k, v := range data {
+		out[k] = append([]byte(nil), v...)
+	}
+	return out
+}
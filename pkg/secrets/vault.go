@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/config"
+)
+
+// Vault keeps secrets in a HashiCorp Vault KV v2 engine, under
+// <mount>/<prefix>/<ref>
+type Vault struct {
+	config *config.VaultConfig
+	client *http.Client
+}
+
+// NewVault creates a Vault store; client defaults to one with a 30s
+// timeout
+func NewVault(cfg *config.VaultConfig, client *http.Client) *Vault {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Vault{config: cfg, client: client}
+}
+
+// Get returns the latest version of the secret at ref
+func (v *Vault) Get(ctx context.Context, ref string) (map[string][]byte, error) {
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	status, err := v.do(ctx, http.MethodGet, "data", ref, nil, &body)
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]byte, len(body.Data.Data))
+	for k, val := range body.Data.Data {
+		data[k] = []byte(val)
+	}
+	return data, nil
+}
+
+// Set writes a new version of the secret at ref
+func (v *Vault) Set(ctx context.Context, ref string, data map[string][]byte) error {
+	values := make(map[string]string, len(data))
+	for k, val := range data {
+		values[k] = string(val)
+	}
+	_, err := v.do(ctx, http.MethodPost, "data", ref, map[string]interface{}{"data": values}, nil)
+	return err
+}
+
+// Delete removes every version of the secret at ref
+func (v *Vault) Delete(ctx context.Context, ref string) error {
+	status, err := v.do(ctx, http.MethodDelete, "metadata", ref, nil, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// do calls the KV v2 endpoint kind ("data" or "metadata") for ref and
+// decodes the response into out
+func (v *Vault) do(ctx context.Context, method, kind, ref string, in, out interface{}) (int, error) {
+	segments := strings.Split(ref, "/")
+	for i, segment := range segments {
+		// A ref can't climb out of the prefix
+		if segment == "" || segment == "." || segment == ".." {
+			return 0, fmt.Errorf("invalid secret ref %q", ref)
+		}
+		segments[i] = url.PathEscape(segment)
+	}
+	endpoint := strings.TrimSuffix(v.config.Address, "/") + "/v1/" +
+		path.Join(v.config.Mount, kind, v.config.Prefix, strings.Join(segments, "/"))
+
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Vault-Token", v.config.Token)
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("vault request for secret %s failed: %w", ref, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&vaultErr)
+		return resp.StatusCode, fmt.Errorf("vault returned %d for secret %s: %s",
+			resp.StatusCode, ref, strings.Join(vaultErr.Errors, "; "))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode vault secret %s: %w", ref, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,369 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/nats"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// Messages of the event gateway protocol. A client sends subscribe and
+// unsubscribe with the NATS subject in the payload, e.g.
+// {"type":"subscribe","payload":{"subject":"krustron.cluster.<id>.>"}},
+// and receives each matching event as an event message on that channel.
+const (
+	MessageTypeSubscribe    MessageType = "subscribe"
+	MessageTypeUnsubscribe  MessageType = "unsubscribe"
+	MessageTypeSubscribed   MessageType = "subscribed"
+	MessageTypeUnsubscribed MessageType = "unsubscribed"
+	MessageTypeEvent        MessageType = "event"
+	// MessageTypeEventsDropped tells a client that fell behind how many
+	// events it missed, so it can refetch what it shows
+	MessageTypeEventsDropped MessageType = "events.dropped"
+)
+
+// subjectPrefix is the root of the subjects clients may subscribe to
+const subjectPrefix = "krustron."
+
+// EventSource subscribes to NATS subjects; *nats.Client is one
+type EventSource interface {
+	Subscribe(subject string, handler nats.MessageHandler) (*nats.Subscription, error)
+}
+
+// SubjectAuthorizer reports whether a connection may receive events on a
+// subject. It is asked for the subject a client subscribes to and again
+// for the concrete subject of each event.
+type SubjectAuthorizer func(ctx context.Context, subject string) bool
+
+// GatewayConfig holds event gateway configuration
+type GatewayConfig struct {
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+	WriteTimeout time.Duration
+	// SendBuffer is how many messages may wait for a slow client; events
+	// beyond it are dropped and counted
+	SendBuffer int
+	// MaxDropped closes the connection of a client that dropped this many
+	// events without catching up
+	MaxDropped int
+	// MaxSubscriptions limits the subjects one connection subscribes to
+	MaxSubscriptions int
+}
+
+// DefaultGatewayConfig returns default event gateway configuration
+func DefaultGatewayConfig() *GatewayConfig {
+	return &GatewayConfig{
+		PingInterval:     30 * time.Second,
+		PongTimeout:      60 * time.Second,
+		WriteTimeout:     10 * time.Second,
+		SendBuffer:       256,
+		MaxDropped:       1000,
+		MaxSubscriptions: 32,
+	}
+}
+
+// Gateway forwards Krustron events from NATS to WebSocket clients. Each
+// connection holds its own NATS subscriptions, removed when it closes.
+type Gateway struct {
+	source EventSource
+	logger *zap.Logger
+	config *GatewayConfig
+}
+
+// NewGateway creates an event gateway reading events from source
+func NewGateway(source EventSource, logger *zap.Logger, config *GatewayConfig) *Gateway {
+	if config == nil {
+		config = DefaultGatewayConfig()
+	}
+	return &Gateway{source: source, logger: logger, config: config}
+}
+
+// Serve upgrades the request and forwards the events the client
+// subscribes to until it disconnects. The caller has authenticated the
+// request; authorize scopes the subjects to what userID may see.
+func (g *Gateway) Serve(w http.ResponseWriter, r *http.Request, userID string, authorize SubjectAuthorizer) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		g.logger.Error("WebSocket upgrade failed", zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	c := &gatewayConn{
+		gateway:   g,
+		id:        uuid.New().String(),
+		userID:    userID,
+		conn:      conn,
+		ctx:       ctx,
+		authorize: authorize,
+		send:      make(chan *Message, g.config.SendBuffer),
+		subs:      make(map[string]*nats.Subscription),
+	}
+	g.logger.Debug("Event gateway client connected", zap.String("client_id", c.id), zap.String("user_id", userID))
+
+	done := make(chan struct{})
+	go func() {
+		c.writePump()
+		close(done)
+	}()
+	c.readPump()
+
+	// The writer stops on the cancelled context; subscriptions go first so
+	// nothing is queued for it afterwards
+	c.unsubscribeAll()
+	cancel()
+	<-done
+	conn.Close()
+	g.logger.Debug("Event gateway client disconnected", zap.String("client_id", c.id))
+}
+
+// gatewayConn is one client connection of the gateway
+type gatewayConn struct {
+	gateway   *Gateway
+	id        string
+	userID    string
+	conn      *websocket.Conn
+	ctx       context.Context
+	authorize SubjectAuthorizer
+	send      chan *Message
+	// dropped counts events dropped since the client last caught up
+	dropped atomic.Int64
+
+	mu   sync.Mutex
+	subs map[string]*nats.Subscription
+}
+
+// readPump handles client messages until the connection fails or the
+// client stops answering pings
+func (c *gatewayConn) readPump() {
+	cfg := c.gateway.config
+	c.conn.SetReadLimit(64 * 1024)
+	c.conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseAbnormalClosure) {
+				c.gateway.logger.Warn("Event gateway read error", zap.String("client_id", c.id), zap.Error(err))
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(cfg.PongTimeout))
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.replyError("", "invalid message")
+			continue
+		}
+		subject := ""
+		if payload, ok := msg.Data.(map[string]interface{}); ok {
+			subject, _ = payload["subject"].(string)
+		}
+		switch msg.Type {
+		case MessageTypeSubscribe:
+			c.subscribe(subject)
+		case MessageTypeUnsubscribe:
+			c.unsubscribe(subject)
+		case MessageTypePing:
+			c.reply(MessageTypePong, "", nil)
+		default:
+			c.replyError("", fmt.Sprintf("unknown message type %q", msg.Type))
+		}
+	}
+}
+
+// writePump writes queued messages and heartbeat pings until the
+// connection closes. After a client falls behind it is told how many
+// events it missed.
+func (c *gatewayConn) writePump() {
+	cfg := c.gateway.config
+	ticker := time.NewTicker(cfg.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+
+		case msg := <-c.send:
+			if err := c.write(msg); err != nil {
+				c.fail(err)
+				return
+			}
+			if n := c.dropped.Swap(0); n > 0 {
+				notice := newMessage(MessageTypeEventsDropped, "", map[string]int64{"count": n})
+				if err := c.write(notice); err != nil {
+					c.fail(err)
+					return
+				}
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *gatewayConn) write(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.gateway.config.WriteTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// fail closes the connection after a write error, ending readPump
+func (c *gatewayConn) fail(err error) {
+	c.gateway.logger.Warn("Event gateway write error", zap.String("client_id", c.id), zap.Error(err))
+	c.conn.Close()
+}
+
+// enqueue queues msg without blocking. A full queue drops it; a client
+// that keeps dropping is disconnected rather than held indefinitely.
+func (c *gatewayConn) enqueue(msg *Message) {
+	select {
+	case c.send <- msg:
+		return
+	default:
+	}
+	if n := c.dropped.Add(1); n == int64(c.gateway.config.MaxDropped) {
+		c.gateway.logger.Warn("Event gateway client too slow, disconnecting",
+			zap.String("client_id", c.id),
+			zap.String("user_id", c.userID),
+			zap.Int64("dropped", n),
+		)
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow"),
+			time.Now().Add(c.gateway.config.WriteTimeout))
+		c.conn.Close()
+	}
+}
+
+func (c *gatewayConn) reply(typ MessageType, subject string, data interface{}) {
+	c.enqueue(newMessage(typ, subject, data))
+}
+
+// replyError tells the client a request about subject failed
+func (c *gatewayConn) replyError(subject, message string) {
+	c.reply(MessageTypeError, subject, map[string]string{"message": message})
+}
+
+func (c *gatewayConn) subscribe(subject string) {
+	if err := validateSubject(subject); err != nil {
+		c.replyError(subject, err.Error())
+		return
+	}
+	if !c.authorize(c.ctx, subject) {
+		c.replyError(subject, "not permitted to subscribe to "+subject)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[subject]; ok {
+		c.reply(MessageTypeSubscribed, subject, nil)
+		return
+	}
+	if len(c.subs) >= c.gateway.config.MaxSubscriptions {
+		c.replyError(subject, fmt.Sprintf("at most %d subscriptions per connection", c.gateway.config.MaxSubscriptions))
+		return
+	}
+	sub, err := c.gateway.source.Subscribe(subject, c.forward)
+	if err != nil {
+		c.gateway.logger.Error("Event gateway subscribe failed", zap.String("subject", subject), zap.Error(err))
+		c.replyError(subject, "failed to subscribe")
+		return
+	}
+	c.subs[subject] = sub
+	c.reply(MessageTypeSubscribed, subject, nil)
+}
+
+func (c *gatewayConn) unsubscribe(subject string) {
+	c.mu.Lock()
+	sub, ok := c.subs[subject]
+	delete(c.subs, subject)
+	c.mu.Unlock()
+	if ok {
+		if err := sub.Unsubscribe(); err != nil {
+			c.gateway.logger.Warn("Event gateway unsubscribe failed", zap.String("subject", subject), zap.Error(err))
+		}
+	}
+	c.reply(MessageTypeUnsubscribed, subject, nil)
+}
+
+// unsubscribeAll removes every NATS subscription of the connection
+func (c *gatewayConn) unsubscribeAll() {
+	c.mu.Lock()
+	subs := c.subs
+	c.subs = map[string]*nats.Subscription{}
+	c.mu.Unlock()
+	for subject, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil {
+			c.gateway.logger.Warn("Event gateway unsubscribe failed", zap.String("subject", subject), zap.Error(err))
+		}
+	}
+}
+
+// forward queues an event received from NATS, unless its subject is
+// outside what the client may see. It runs on the NATS delivery goroutine
+// so never blocks.
+func (c *gatewayConn) forward(_ context.Context, msg *nats.Message) error {
+	if c.ctx.Err() != nil || !c.authorize(c.ctx, msg.Subject) {
+		return nil
+	}
+	var data interface{}
+	if event, err := nats.DecodeCloudEvent(msg.Data); err == nil {
+		data = event
+	} else if json.Valid(msg.Data) {
+		data = json.RawMessage(msg.Data)
+	} else {
+		data = string(msg.Data)
+	}
+	c.enqueue(newMessage(MessageTypeEvent, msg.Subject, data))
+	return nil
+}
+
+func newMessage(typ MessageType, channel string, data interface{}) *Message {
+	return &Message{ID: uuid.New().String(), Type: typ, Channel: channel, Data: data, Timestamp: time.Now()}
+}
+
+// validateSubject checks subject is a well-formed subject under
+// krustron., where ">" may only end it
+func validateSubject(subject string) error {
+	if !strings.HasPrefix(subject, subjectPrefix) {
+		return fmt.Errorf("subject must start with %q", subjectPrefix)
+	}
+	tokens := strings.Split(subject, ".")
+	for i, tok := range tokens {
+		if tok == "" || strings.ContainsAny(tok, " \t\r\n") {
+			return fmt.Errorf("invalid subject %q", subject)
+		}
+		if strings.Contains(tok, ">") && (tok != ">" || i != len(tokens)-1) {
+			return fmt.Errorf("invalid subject %q: \">\" may only end it", subject)
+		}
+		if strings.Contains(tok, "*") && tok != "*" {
+			return fmt.Errorf("invalid subject %q", subject)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anubhavg-icpl/krustron/pkg/nats"
+	"github.com/anubhavg-icpl/krustron/pkg/nats/natstest"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// dialGateway serves a gateway over a NATS test server and connects a
+// client to it, returning the client and the event bus feeding it
+func dialGateway(t *testing.T, authorize SubjectAuthorizer) (*websocket.Conn, *nats.EventBus) {
+	t.Helper()
+	client, err := nats.NewClient(zap.NewNop(), &nats.Config{URL: natstest.StartServer(t), MaxReconnects: -1})
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	gateway := NewGateway(client, zap.NewNop(), nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gateway.Serve(w, r, "alice", authorize)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, nats.NewEventBus(client, zap.NewNop())
+}
+
+func send(t *testing.T, conn *websocket.Conn, typ MessageType, subject string) {
+	t.Helper()
+	require.NoError(t, conn.WriteJSON(Message{Type: typ, Data: map[string]string{"subject": subject}}))
+}
+
+func receive(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg Message
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestGatewayForwardsNATSEvents(t *testing.T) {
+	// alice may see cluster c1 only
+	authorize := func(_ context.Context, subject string) bool {
+		return subject == "krustron.cluster.>" || strings.HasPrefix(subject, "krustron.cluster.c1.")
+	}
+	conn, bus := dialGateway(t, authorize)
+	ctx := context.Background()
+
+	send(t, conn, MessageTypeSubscribe, "krustron.pipeline.>")
+	msg := receive(t, conn)
+	assert.Equal(t, MessageTypeError, msg.Type)
+	assert.Equal(t, "krustron.pipeline.>", msg.Channel)
+
+	send(t, conn, MessageTypeSubscribe, "krustron.cluster.>")
+	msg = receive(t, conn)
+	require.Equal(t, MessageTypeSubscribed, msg.Type)
+
+	// Events of other clusters are filtered out
+	require.NoError(t, bus.EmitClusterEvent(ctx, "pod_status", "c2", map[string]string{"pod": "hidden"}))
+	require.NoError(t, bus.EmitClusterEvent(ctx, "pod_status", "c1", map[string]string{"pod": "web-0"}))
+
+	msg = receive(t, conn)
+	assert.Equal(t, MessageTypeEvent, msg.Type)
+	assert.Equal(t, "krustron.cluster.c1.pod_status", msg.Channel)
+	event, ok := msg.Data.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "pod_status", event["type"])
+	assert.Equal(t, map[string]interface{}{"pod": "web-0"}, event["data"])
+
+	// Once unsubscribed nothing more arrives
+	send(t, conn, MessageTypeUnsubscribe, "krustron.cluster.>")
+	assert.Equal(t, MessageTypeUnsubscribed, receive(t, conn).Type)
+	require.NoError(t, bus.EmitClusterEvent(ctx, "pod_status", "c1", map[string]string{"pod": "web-1"}))
+	send(t, conn, MessageTypePing, "")
+	assert.Equal(t, MessageTypePong, receive(t, conn).Type)
+}
+
+func TestValidateSubject(t *testing.T) {
+	for _, subject := range []string{"krustron.cluster.>", "krustron.cluster.*.pod_status", "krustron.audit.alice.login"} {
+		assert.NoError(t, validateSubject(subject), subject)
+	}
+	for _, subject := range []string{"", ">", "other.cluster.>", "krustron.>.x", "krustron..x", "krustron.c*", "krustron.a b"} {
+		assert.Error(t, validateSubject(subject), subject)
+	}
+}
@@ -50,6 +50,8 @@ const (
 	MessageTypePipelineStatus MessageType = "pipeline.status"
 	MessageTypePipelineLog    MessageType = "pipeline.log"
 	MessageTypePipelineStage  MessageType = "pipeline.stage"
+	// MessageTypePipelineApproval announces a run waiting on an approve stage
+	MessageTypePipelineApproval MessageType = "pipeline.approval"
 
 	// Resource messages
 	MessageTypePodStatus    MessageType = "pod.status"
@@ -655,6 +657,23 @@ func (e *EventEmitter) EmitPipelineStatus(pipelineID string, status interface{})
 	})
 }
 
+// EmitPipelineApproval emits a request for approval of a pipeline stage
+func (e *EventEmitter) EmitPipelineApproval(pipelineID string, request interface{}) {
+	e.hub.BroadcastToChannel("pipeline:"+pipelineID, &Message{
+		Type: MessageTypePipelineApproval,
+		Data: request,
+	})
+}
+
+// EmitPipelineStage emits progress within a pipeline stage, such as the
+// steps of a canary rollout
+func (e *EventEmitter) EmitPipelineStage(pipelineID string, stage interface{}) {
+	e.hub.BroadcastToChannel("pipeline:"+pipelineID, &Message{
+		Type: MessageTypePipelineStage,
+		Data: stage,
+	})
+}
+
 // EmitPipelineLog emits pipeline log line
 func (e *EventEmitter) EmitPipelineLog(pipelineID string, log interface{}) {
 	e.hub.BroadcastToChannel("pipeline:"+pipelineID, &Message{